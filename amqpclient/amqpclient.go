@@ -0,0 +1,124 @@
+// Package amqpclient publishes messages to an AMQP 0-9-1 broker, using publisher
+// confirms, with the RabbitMQ amqp091-go client. It is used to send the actions
+// notifications
+package amqpclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+var (
+	// ErrNack is returned if the broker does not confirm a published message
+	ErrNack       = errors.New("the message was rejected by the broker")
+	errTimeout    = errors.New("timeout waiting for the broker confirmation")
+	errNotConfirm = errors.New("the channel was closed before the broker confirmation")
+)
+
+// Options defines the options to connect to an AMQP broker
+type Options struct {
+	// Broker address as host:port
+	Address string
+	// TLS configuration, nil means plain TCP
+	TLSConfig   *tls.Config
+	Username    string
+	Password    string
+	VirtualHost string
+	// Timeout for the whole publish, including the connection and the confirmation.
+	// 0 means no timeout
+	Timeout time.Duration
+}
+
+func (o *Options) getURL() string {
+	if o.TLSConfig != nil {
+		return fmt.Sprintf("amqps://%v/", o.Address)
+	}
+	return fmt.Sprintf("amqp://%v/", o.Address)
+}
+
+func (o *Options) getConfig() amqp.Config {
+	config := amqp.Config{
+		SASL: []amqp.Authentication{
+			&amqp.PlainAuth{
+				Username: o.Username,
+				Password: o.Password,
+			},
+		},
+		Vhost:           o.VirtualHost,
+		TLSClientConfig: o.TLSConfig,
+	}
+	if o.Timeout > 0 {
+		config.Dial = amqp.DefaultDial(o.Timeout)
+	}
+	return config
+}
+
+// Message defines a message to publish
+type Message struct {
+	Exchange    string
+	RoutingKey  string
+	ContentType string
+	// if true the broker stores the message on disk
+	Persistent bool
+	Body       []byte
+}
+
+func (m *Message) getPublishing() amqp.Publishing {
+	publishing := amqp.Publishing{
+		ContentType: m.ContentType,
+		Body:        m.Body,
+	}
+	if m.Persistent {
+		publishing.DeliveryMode = amqp.Persistent
+	}
+	return publishing
+}
+
+// Publish connects to the broker, publishes the given message, waits for the
+// broker confirmation and disconnects
+func Publish(opts *Options, msg *Message) error {
+	if len(msg.Exchange) > 255 || len(msg.RoutingKey) > 255 {
+		return errors.New("the exchange and the routing key cannot be longer than 255 bytes")
+	}
+	var timeout <-chan time.Time
+	if opts.Timeout > 0 {
+		timer := time.NewTimer(opts.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	conn, err := amqp.DialConfig(opts.getURL(), opts.getConfig())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	channel, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer channel.Close()
+
+	if err := channel.Confirm(false); err != nil {
+		return err
+	}
+	confirms := channel.NotifyPublish(make(chan amqp.Confirmation, 1))
+	if err := channel.Publish(msg.Exchange, msg.RoutingKey, false, false, msg.getPublishing()); err != nil {
+		return err
+	}
+	select {
+	case confirm, ok := <-confirms:
+		if !ok {
+			return errNotConfirm
+		}
+		if !confirm.Ack {
+			return ErrNack
+		}
+		return nil
+	case <-timeout:
+		return errTimeout
+	}
+}
@@ -0,0 +1,96 @@
+package amqpclient
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptions(t *testing.T) {
+	opts := &Options{
+		Address:     "127.0.0.1:5672",
+		Username:    "user",
+		Password:    "pwd",
+		VirtualHost: "vhost",
+		Timeout:     5 * time.Second,
+	}
+	assert.Equal(t, "amqp://127.0.0.1:5672/", opts.getURL())
+	config := opts.getConfig()
+	require.Len(t, config.SASL, 1)
+	assert.Equal(t, "PLAIN", config.SASL[0].Mechanism())
+	assert.Equal(t, "\x00user\x00pwd", config.SASL[0].Response())
+	assert.Equal(t, "vhost", config.Vhost)
+	assert.Nil(t, config.TLSClientConfig)
+	assert.NotNil(t, config.Dial)
+
+	opts.Address = "127.0.0.1:5671"
+	opts.TLSConfig = &tls.Config{ServerName: "broker"}
+	opts.Timeout = 0
+	assert.Equal(t, "amqps://127.0.0.1:5671/", opts.getURL())
+	config = opts.getConfig()
+	assert.Equal(t, "broker", config.TLSClientConfig.ServerName)
+	assert.Nil(t, config.Dial)
+}
+
+func TestMessage(t *testing.T) {
+	msg := &Message{
+		ContentType: "application/json",
+		Persistent:  true,
+		Body:        []byte(`{"action":"upload"}`),
+	}
+	publishing := msg.getPublishing()
+	assert.Equal(t, "application/json", publishing.ContentType)
+	assert.Equal(t, amqp.Persistent, publishing.DeliveryMode)
+	assert.Equal(t, []byte(`{"action":"upload"}`), publishing.Body)
+	msg.Persistent = false
+	publishing = msg.getPublishing()
+	assert.Equal(t, uint8(0), publishing.DeliveryMode)
+}
+
+func TestPublishErrors(t *testing.T) {
+	// no broker is listening on this port
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	opts := &Options{
+		Address:     address,
+		Username:    "user",
+		Password:    "pwd",
+		VirtualHost: "/",
+		Timeout:     5 * time.Second,
+	}
+	err = Publish(opts, &Message{
+		RoutingKey: "queue",
+	})
+	assert.Error(t, err)
+	// the broker accepts the connection but it never completes the handshake
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			time.Sleep(2 * time.Second)
+			conn.Close()
+		}
+	}()
+	opts.Address = listener.Addr().String()
+	opts.Timeout = 500 * time.Millisecond
+	err = Publish(opts, &Message{
+		RoutingKey: "queue",
+	})
+	assert.Error(t, err)
+
+	err = Publish(opts, &Message{
+		Exchange: string(bytes.Repeat([]byte("a"), 256)),
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,104 @@
+// Package awssecrets allows to read secrets from AWS Secrets Manager and
+// parameters from AWS Systems Manager Parameter Store. The values are referenced
+// inside the configuration using placeholders and they are fetched when the
+// configuration is loaded
+package awssecrets
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const logSender = "awssecrets"
+
+var (
+	// ErrNotConfigured is returned if a value is requested before initializing the AWS clients
+	ErrNotConfigured = errors.New("aws secrets are not configured")
+	mu               sync.Mutex
+	activeClient     *client
+)
+
+// Config defines the configuration to access AWS Secrets Manager and SSM Parameter Store.
+// The credentials are loaded using the AWS SDK default chain, so environment variables,
+// shared configuration files, ECS task roles, EKS service accounts and EC2 instance
+// profiles are supported
+type Config struct {
+	// AWS region, for example "us-east-1". If empty the region is read from the
+	// AWS_REGION environment variable or from the shared configuration files
+	Region string `json:"region" mapstructure:"region"`
+	// Optional IAM role to assume before reading the values
+	RoleARN string `json:"role_arn" mapstructure:"role_arn"`
+	// Optional custom endpoint, for example a VPC endpoint. It is used for both
+	// Secrets Manager and SSM so it is mainly useful for testing
+	Endpoint string `json:"endpoint" mapstructure:"endpoint"`
+}
+
+// Initialize configures the AWS clients. The clients are created each time the
+// configuration is loaded, so the values are read again on reload. The credentials
+// are only required if a value is actually referenced
+func (c *Config) Initialize() error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	activeClient = nil
+	cl, err := newClient(*c)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to initialize aws clients: %v", err)
+		return fmt.Errorf("aws secrets: %w", err)
+	}
+	activeClient = cl
+	return nil
+}
+
+func getActiveClient() (*client, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if activeClient == nil {
+		return nil, ErrNotConfigured
+	}
+	return activeClient, nil
+}
+
+// GetSecret returns the value of the secret with the given ID from AWS Secrets Manager.
+// If key is not empty the secret must be a JSON object and the value for the
+// specified key is returned
+func GetSecret(secretID, key string) (string, error) {
+	cl, err := getActiveClient()
+	if err != nil {
+		return "", err
+	}
+	return cl.getSecret(secretID, key)
+}
+
+// GetParameter returns the value of the given parameter from AWS SSM Parameter Store.
+// SecureString parameters are decrypted
+func GetParameter(name string) (string, error) {
+	cl, err := getActiveClient()
+	if err != nil {
+		return "", err
+	}
+	return cl.getParameter(name)
+}
+
+// ParseSecretReference parses a secret reference in the form "secret-id" or
+// "secret-id#key"
+func ParseSecretReference(reference string) (string, string, error) {
+	secretID := reference
+	key := ""
+	if idx := strings.LastIndex(reference, "#"); idx >= 0 {
+		secretID = reference[:idx]
+		key = reference[idx+1:]
+		if key == "" {
+			return "", "", fmt.Errorf("invalid aws secret reference %#v, the key cannot be empty", reference)
+		}
+	}
+	if secretID == "" {
+		return "", "", fmt.Errorf("invalid aws secret reference %#v, the secret id cannot be empty", reference)
+	}
+	return secretID, key, nil
+}
@@ -0,0 +1,33 @@
+package awssecrets
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSecretReference(t *testing.T) {
+	secretID, key, err := ParseSecretReference("sftpgo/db#password")
+	assert.NoError(t, err)
+	assert.Equal(t, "sftpgo/db", secretID)
+	assert.Equal(t, "password", key)
+	secretID, key, err = ParseSecretReference("arn:aws:secretsmanager:us-east-1:123456789012:secret:sftpgo-AbCdEf")
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:secretsmanager:us-east-1:123456789012:secret:sftpgo-AbCdEf", secretID)
+	assert.Empty(t, key)
+	for _, reference := range []string{"", "#password", "sftpgo/db#"} {
+		_, _, err = ParseSecretReference(reference)
+		assert.Error(t, err, reference)
+	}
+}
+
+func TestNotConfigured(t *testing.T) {
+	mu.Lock()
+	activeClient = nil
+	mu.Unlock()
+
+	_, err := GetSecret("sftpgo/db", "password")
+	assert.ErrorIs(t, err, ErrNotConfigured)
+	_, err = GetParameter("/sftpgo/db/password")
+	assert.ErrorIs(t, err, ErrNotConfigured)
+}
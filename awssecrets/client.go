@@ -0,0 +1,124 @@
+// +build !noawssecrets
+
+package awssecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+
+	"github.com/drakkan/sftpgo/version"
+)
+
+const requestTimeout = 30 * time.Second
+
+func init() {
+	version.AddFeature("+awssecrets")
+}
+
+type client struct {
+	secretsManager *secretsmanager.SecretsManager
+	ssm            *ssm.SSM
+	mu             sync.Mutex
+	// values already read, the same secret can be referenced more than once
+	secrets    map[string]string
+	parameters map[string]string
+}
+
+func newClient(config Config) (*client, error) {
+	awsConfig := aws.NewConfig()
+	if config.Region != "" {
+		awsConfig.WithRegion(config.Region)
+	}
+	if config.Endpoint != "" {
+		awsConfig.WithEndpoint(config.Endpoint)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if config.RoleARN != "" {
+		awsConfig.WithCredentials(stscreds.NewCredentials(sess, config.RoleARN))
+	}
+	return &client{
+		secretsManager: secretsmanager.New(sess, awsConfig),
+		ssm:            ssm.New(sess, awsConfig),
+		secrets:        make(map[string]string),
+		parameters:     make(map[string]string),
+	}, nil
+}
+
+func (c *client) getSecret(secretID, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.secrets[secretID]
+	if !ok {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+
+		output, err := c.secretsManager.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+			SecretId: aws.String(secretID),
+		})
+		if err != nil {
+			return "", fmt.Errorf("aws secrets: unable to read secret %#v: %w", secretID, err)
+		}
+		if output.SecretString != nil {
+			value = *output.SecretString
+		} else {
+			value = string(output.SecretBinary)
+		}
+		c.secrets[secretID] = value
+	}
+	if key == "" {
+		return value, nil
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return "", fmt.Errorf("aws secrets: secret %#v is not a JSON object: %w", secretID, err)
+	}
+	keyValue, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("aws secrets: key %#v not found in secret %#v", key, secretID)
+	}
+	if s, ok := keyValue.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprintf("%v", keyValue), nil
+}
+
+func (c *client) getParameter(name string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if value, ok := c.parameters[name]; ok {
+		return value, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	output, err := c.ssm.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("aws secrets: unable to read parameter %#v: %w", name, err)
+	}
+	if output.Parameter == nil || output.Parameter.Value == nil {
+		return "", fmt.Errorf("aws secrets: parameter %#v has no value", name)
+	}
+	value := aws.StringValue(output.Parameter.Value)
+	c.parameters[name] = value
+	return value, nil
+}
@@ -0,0 +1,30 @@
+// +build noawssecrets
+
+package awssecrets
+
+import (
+	"errors"
+
+	"github.com/drakkan/sftpgo/version"
+)
+
+func init() {
+	version.AddFeature("-awssecrets")
+}
+
+type client struct{}
+
+func newClient(config Config) (*client, error) {
+	if config.Region != "" || config.RoleARN != "" || config.Endpoint != "" {
+		return nil, errors.New("AWS secrets support disabled at build time")
+	}
+	return nil, nil
+}
+
+func (c *client) getSecret(secretID, key string) (string, error) {
+	return "", errors.New("AWS secrets support disabled at build time")
+}
+
+func (c *client) getParameter(name string) (string, error) {
+	return "", errors.New("AWS secrets support disabled at build time")
+}
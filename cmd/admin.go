@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"encoding/json"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+var adminCmd = newManageCmd(&managedObject{
+	name:    "admin",
+	apiPath: "/api/v2/admins",
+	list: func(limit, offset int, order string) (interface{}, error) {
+		return dataprovider.GetAdmins(limit, offset, order)
+	},
+	get: func(name string) (interface{}, error) {
+		admin, err := dataprovider.AdminExists(name)
+		if err != nil {
+			return nil, err
+		}
+		admin.HideConfidentialData()
+		return admin, nil
+	},
+	add: func(data []byte) (interface{}, error) {
+		var admin dataprovider.Admin
+		if err := json.Unmarshal(data, &admin); err != nil {
+			return nil, err
+		}
+		if err := dataprovider.AddAdmin(&admin); err != nil {
+			return nil, err
+		}
+		admin, err := dataprovider.AdminExists(admin.Username)
+		if err != nil {
+			return nil, err
+		}
+		admin.HideConfidentialData()
+		return admin, nil
+	},
+	update: func(name string, data []byte) error {
+		admin, err := dataprovider.AdminExists(name)
+		if err != nil {
+			return err
+		}
+		adminID := admin.ID
+		if err := json.Unmarshal(data, &admin); err != nil {
+			return err
+		}
+		admin.ID = adminID
+		admin.Username = name
+		return dataprovider.UpdateAdmin(&admin)
+	},
+	remove: dataprovider.DeleteAdmin,
+}, `Add, update, delete and list SFTPGo admins. The admins are managed directly
+using the configured data provider or, if the "--url" flag is set, using the
+REST API. Please take a look at "sftpgo user --help" for more details.
+
+Managing the admins directly using the data provider is useful, for example,
+to restore the access to the WebAdmin if you lost your credentials.
+
+Examples:
+
+$ sftpgo admin add -f admin.json
+$ sftpgo admin update admin -f admin.json
+$ sftpgo admin list --url http://127.0.0.1:8080 --admin-username admin`)
+
+func init() {
+	rootCmd.AddCommand(adminCmd)
+}
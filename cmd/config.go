@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+var (
+	configCheckOnline   bool
+	configCheckJSON     bool
+	configConvertFormat string
+	configConvertOutput string
+	configCmd           = &cobra.Command{
+		Use:   "config",
+		Short: "Manage the SFTPGo configuration",
+	}
+	configCheckCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Check the configuration",
+		Long: `This command loads the configuration, from the configuration file, the
+configuration fragments and the environment, and validates it without
+starting any service.
+
+The files referenced in the configuration, such as the SSH host keys and the
+TLS certificates, are loaded, the hooks must be HTTP URLs or existing
+executables and the same address cannot be used by more than one binding.
+Use the "--online" flag to check the connection to the data provider too.
+
+The command exits with a non zero status if errors are found, so it can be
+used to validate the configuration changes before deploying them:
+
+$ sftpgo config check --config-dir /etc/sftpgo --online
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.Disabled)
+			configDir = utils.CleanDirInput(configDir)
+			var checkErrors []config.CheckError
+			if err := config.LoadConfig(configDir, configFile); err != nil {
+				checkErrors = append(checkErrors, config.CheckError{Error: err.Error()})
+			} else {
+				checkErrors = config.Check(configDir, configCheckOnline)
+			}
+			if configCheckJSON {
+				if checkErrors == nil {
+					checkErrors = []config.CheckError{}
+				}
+				data, err := json.MarshalIndent(checkErrors, "", "  ")
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "unable to marshal the configuration errors: %v\n", err)
+					os.Exit(1)
+				}
+				fmt.Println(string(data))
+			} else {
+				for _, e := range checkErrors {
+					fmt.Println(e.String())
+				}
+			}
+			if len(checkErrors) > 0 {
+				if !configCheckJSON {
+					fmt.Printf("%v configuration error(s) found\n", len(checkErrors))
+				}
+				os.Exit(1)
+			}
+			if !configCheckJSON {
+				fmt.Println("The configuration is valid")
+			}
+		},
+	}
+	configDumpCmd = &cobra.Command{
+		Use:   "dump",
+		Short: "Print the effective configuration",
+		Long: `This command loads the configuration, from the configuration file, the
+configuration fragments and the environment, and prints the effective
+configuration as JSON. The secret values, such as passwords, tokens and the
+credentials inside URLs, are redacted.
+
+This is useful to find out which value is actually used for a setting:
+
+$ sftpgo config dump --config-dir /etc/sftpgo
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.Disabled)
+			configDir = utils.CleanDirInput(configDir)
+			if err := config.LoadConfig(configDir, configFile); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to load the configuration: %v\n", err)
+				os.Exit(1)
+			}
+			conf, err := config.GetRedactedConfig()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unable to get the configuration: %v\n", err)
+				os.Exit(1)
+			}
+			data, err := json.MarshalIndent(conf, "", "  ")
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unable to marshal the configuration: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Println(string(data))
+		},
+	}
+	configConvertCmd = &cobra.Command{
+		Use:   "convert",
+		Short: "Convert the configuration file to another format",
+		Long: `This command converts the configuration file to JSON, YAML or TOML.
+The configuration file is searched as for the "serve" command. The
+configuration fragments and the environment variables are not included.
+
+The keys order is preserved for JSON and YAML configuration files and the
+comments in YAML configuration files are preserved converting to YAML or TOML.
+
+The converted configuration is printed to the standard output unless the
+"--output" flag is set:
+
+$ sftpgo config convert --config-dir /etc/sftpgo --to yaml --output /etc/sftpgo/sftpgo.yaml
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.Disabled)
+			configDir = utils.CleanDirInput(configDir)
+			data, source, err := config.ConvertConfig(configDir, configFile, configConvertFormat)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unable to convert the configuration: %v\n", err)
+				os.Exit(1)
+			}
+			if configConvertOutput == "" {
+				fmt.Print(string(data))
+				return
+			}
+			output, err := filepath.Abs(configConvertOutput)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "invalid output file %#v: %v\n", configConvertOutput, err)
+				os.Exit(1)
+			}
+			if sourcePath, err := filepath.Abs(source); err == nil && sourcePath == output {
+				fmt.Fprintf(os.Stderr, "the output file cannot be the source configuration file %#v\n", source)
+				os.Exit(1)
+			}
+			if err := os.WriteFile(output, data, 0600); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to write the converted configuration: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("The configuration file %#v was converted to %#v\n", source, output)
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(configCheckCmd)
+	configCheckCmd.Flags().BoolVar(&configCheckOnline, "online", false, `Check the connection to the configured
+data provider too`)
+	configCheckCmd.Flags().BoolVar(&configCheckJSON, "json", false, `Print the errors as a JSON array`)
+
+	addConfigFlags(configDumpCmd)
+
+	addConfigFlags(configConvertCmd)
+	configConvertCmd.Flags().StringVar(&configConvertFormat, "to", "", `Target format. Supported values: "json",
+"yaml", "toml"`)
+	configConvertCmd.Flags().StringVarP(&configConvertOutput, "output", "o", "", `Write the converted configuration to
+this file instead of the standard output`)
+	configConvertCmd.MarkFlagRequired("to") //nolint:errcheck
+
+	configCmd.AddCommand(configCheckCmd)
+	configCmd.AddCommand(configDumpCmd)
+	configCmd.AddCommand(configConvertCmd)
+	rootCmd.AddCommand(configCmd)
+}
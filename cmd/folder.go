@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"encoding/json"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+var folderCmd = newManageCmd(&managedObject{
+	name:    "folder",
+	apiPath: "/api/v2/folders",
+	list: func(limit, offset int, order string) (interface{}, error) {
+		return dataprovider.GetFolders(limit, offset, order)
+	},
+	get: func(name string) (interface{}, error) {
+		folder, err := dataprovider.GetFolderByName(name)
+		if err != nil {
+			return nil, err
+		}
+		folder.PrepareForRendering()
+		return folder, nil
+	},
+	add: func(data []byte) (interface{}, error) {
+		var folder vfs.BaseVirtualFolder
+		if err := json.Unmarshal(data, &folder); err != nil {
+			return nil, err
+		}
+		if err := dataprovider.AddFolder(&folder); err != nil {
+			return nil, err
+		}
+		folder, err := dataprovider.GetFolderByName(folder.Name)
+		if err != nil {
+			return nil, err
+		}
+		folder.PrepareForRendering()
+		return folder, nil
+	},
+	update: func(name string, data []byte) error {
+		folder, err := dataprovider.GetFolderByName(name)
+		if err != nil {
+			return err
+		}
+		users := folder.Users
+		folderID := folder.ID
+		currentFsConfig := folder.FsConfig
+
+		resetFsProviderConfigs(&folder.FsConfig)
+		if err := json.Unmarshal(data, &folder); err != nil {
+			return err
+		}
+		folder.ID = folderID
+		folder.Name = name
+		folder.FsConfig.SetEmptySecretsIfNil()
+		keepEncryptedSecrets(&folder.FsConfig, &currentFsConfig)
+		return dataprovider.UpdateFolder(&folder, users)
+	},
+	remove: dataprovider.DeleteFolder,
+}, `Add, update, delete and list SFTPGo virtual folders. The folders are managed
+directly using the configured data provider or, if the "--url" flag is set,
+using the REST API. Please take a look at "sftpgo user --help" for more details.
+
+Examples:
+
+$ sftpgo folder add -f folder.json
+$ sftpgo folder list --url http://127.0.0.1:8080 --admin-username admin
+$ sftpgo folder del folder1`)
+
+func init() {
+	rootCmd.AddCommand(folderCmd)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+var (
+	genConfigFormat  string
+	genConfigSection string
+	genConfigOutput  string
+	genConfigCmd     = &cobra.Command{
+		Use:   "config",
+		Short: "Generate a commented default configuration",
+		Long: `This command generates a fully populated default configuration, in YAML
+or TOML format, with the documentation for each setting as comments.
+Use the "--section" flag to generate only a top level section, for example
+"sftpd".
+
+The generated configuration is printed to the standard output unless the
+"--output" flag is set:
+
+$ sftpgo gen config --format yaml --output /etc/sftpgo/sftpgo.yaml
+$ sftpgo gen config --section sftpd
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.Disabled)
+			data, err := config.GenerateDefaultConfig(genConfigFormat, genConfigSection)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "unable to generate the configuration: %v\n", err)
+				os.Exit(1)
+			}
+			if genConfigOutput == "" {
+				fmt.Print(string(data))
+				return
+			}
+			if err := os.WriteFile(genConfigOutput, data, 0600); err != nil {
+				fmt.Fprintf(os.Stderr, "unable to write the generated configuration: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func init() {
+	genConfigCmd.Flags().StringVar(&genConfigFormat, "format", "yaml", `Output format. Supported values: "yaml",
+"toml"`)
+	genConfigCmd.Flags().StringVar(&genConfigSection, "section", "", `Generate only the specified top level
+section, for example "sftpd"`)
+	genConfigCmd.Flags().StringVarP(&genConfigOutput, "output", "o", "", `Write the generated configuration to this
+file instead of the standard output`)
+	genCmd.AddCommand(genConfigCmd)
+}
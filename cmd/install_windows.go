@@ -35,8 +35,12 @@ Please take a look at the usage below to customize the startup options`,
 			}
 			winService := service.WindowsService{
 				Service: s,
+				Name:    serviceName,
 			}
 			serviceArgs := []string{"service", "start"}
+			if serviceName != "" {
+				serviceArgs = append(serviceArgs, "--"+serviceNameFlag, serviceName)
+			}
 			customFlags := getCustomServeFlags()
 			if len(customFlags) > 0 {
 				serviceArgs = append(serviceArgs, customFlags...)
@@ -54,6 +58,7 @@ Please take a look at the usage below to customize the startup options`,
 
 func init() {
 	serviceCmd.AddCommand(installCmd)
+	addServiceNameFlag(installCmd)
 	addServeFlags(installCmd)
 }
 
@@ -68,6 +73,9 @@ func getCustomServeFlags() []string {
 		result = append(result, "--"+configFileFlag)
 		result = append(result, configFile)
 	}
+	if configStrict != defaultConfigStrict {
+		result = append(result, "--"+configStrictFlag+"=true")
+	}
 	if logFilePath != defaultLogFile {
 		result = append(result, "--"+logFilePathFlag)
 		result = append(result, logFilePath)
@@ -90,5 +98,13 @@ func getCustomServeFlags() []string {
 	if logCompress != defaultLogCompress {
 		result = append(result, "--"+logCompressFlag+"=true")
 	}
+	if logLevels != defaultLogLevels {
+		result = append(result, "--"+logLevelsFlag)
+		result = append(result, logLevels)
+	}
+	if logFormat != defaultLogFormat {
+		result = append(result, "--"+logFormatFlag)
+		result = append(result, logFormat)
+	}
 	return result
 }
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+var (
+	kmsPreviousURL           string
+	kmsPreviousMasterKey     string
+	kmsPreviousMasterKeyPath string
+	kmsReencryptDryRun       bool
+	kmsReencryptJSON         bool
+	kmsCmd                   = &cobra.Command{
+		Use:   "kms",
+		Short: "Manage the secrets encrypted using the KMS",
+	}
+	kmsReencryptCmd = &cobra.Command{
+		Use:   "reencrypt",
+		Short: "Re-encrypt the stored secrets using the current KMS configuration",
+		Long: `This command decrypts the secrets stored for users and folders, such as the
+cloud storage credentials, using the previous KMS configuration and encrypts
+them again using the current one. This allows to rotate the master key or to
+switch to a different KMS.
+
+Update the KMS configuration, re-encrypt the secrets and then restart SFTPGo:
+
+$ sftpgo kms reencrypt --config-dir /etc/sftpgo --previous-master-key-path /etc/sftpgo/old_mkey
+
+The secrets already encrypted using the current configuration are skipped, so
+the command can be safely executed more than once. Use the "--dry-run" flag to
+check that all the secrets can be decrypted without saving anything.
+The memory provider is not supported, please use the REST API for it.
+For the bolt provider SFTPGo must be stopped, the database file is locked
+while in use.
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			initializeManageProvider()
+			previous := kms.Configuration{
+				Secrets: kms.Secrets{
+					URL:             kmsPreviousURL,
+					MasterKeyString: kmsPreviousMasterKey,
+					MasterKeyPath:   kmsPreviousMasterKeyPath,
+				},
+			}
+			result, err := dataprovider.ReencryptSecrets(&previous, kmsReencryptDryRun,
+				func(objectType, name string, secrets int, err error) {
+					if kmsReencryptJSON {
+						return
+					}
+					if err != nil {
+						logger.WarnToConsole("%v %#v: %v", objectType, name, err)
+						return
+					}
+					logger.InfoToConsole("%v %#v: %v secret(s) re-encrypted", objectType, name, secrets)
+				})
+			if err != nil {
+				exitWithManageError(err)
+			}
+			if kmsReencryptJSON {
+				printManageResult(result)
+			} else {
+				action := "re-encrypted"
+				if kmsReencryptDryRun {
+					action = "to re-encrypt (dry run)"
+				}
+				logger.InfoToConsole("Secrets %v: %v, users: %v, folders: %v, already encrypted using the current "+
+					"configuration: %v, errors: %v", action, result.Secrets, result.Users, result.Folders,
+					result.Skipped, len(result.Errors))
+			}
+			if len(result.Errors) > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(kmsReencryptCmd)
+	kmsReencryptCmd.Flags().StringVar(&kmsPreviousURL, "previous-url", "", `KMS URL used to encrypt the stored
+secrets. Leave empty for the local
+encryption`)
+	kmsReencryptCmd.Flags().StringVar(&kmsPreviousMasterKey, "previous-master-key", "", `Master key used to encrypt the
+stored secrets`)
+	kmsReencryptCmd.Flags().StringVar(&kmsPreviousMasterKeyPath, "previous-master-key-path", "", `Path to the master key used to
+encrypt the stored secrets. It takes
+precedence over "--previous-master-key"`)
+	kmsReencryptCmd.Flags().BoolVar(&kmsReencryptDryRun, "dry-run", false, `Decrypt the secrets without saving
+anything`)
+	kmsReencryptCmd.Flags().BoolVar(&kmsReencryptJSON, "json", false, `Print the results as JSON`)
+
+	kmsCmd.AddCommand(kmsReencryptCmd)
+	rootCmd.AddCommand(kmsCmd)
+}
@@ -0,0 +1,357 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/version"
+)
+
+const (
+	manageURLFlag           = "url"
+	manageURLKey            = "manage_url"
+	manageAdminUsernameFlag = "admin-username"
+	manageAdminUsernameKey  = "manage_admin_username"
+	manageAdminPasswordFlag = "admin-password"
+	manageAdminPasswordKey  = "manage_admin_password"
+	manageTokenPath         = "/api/v2/token"
+)
+
+var (
+	manageURL           string
+	manageAdminUsername string
+	manageAdminPassword string
+	manageInputFile     string
+	manageLimit         int
+	manageOffset        int
+	manageOrder         string
+)
+
+// managedObject defines how to handle an object type, such as users, folders
+// or admins, directly using the configured data provider
+type managedObject struct {
+	// name for the object type, for example "user"
+	name string
+	// REST API path for this object type, for example "/api/v2/users"
+	apiPath string
+	list    func(limit, offset int, order string) (interface{}, error)
+	get     func(name string) (interface{}, error)
+	add     func(data []byte) (interface{}, error)
+	update  func(name string, data []byte) error
+	remove  func(name string) error
+}
+
+// newManageCmd returns the command to manage the given object type and its
+// add, update, del, get and list subcommands
+func newManageCmd(obj *managedObject, long string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   obj.name,
+		Short: fmt.Sprintf("Manage SFTPGo %vs", obj.name),
+		Long:  long,
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add",
+		Short: fmt.Sprintf("Add a new %v", obj.name),
+		Long: fmt.Sprintf(`Add a new %v. The %v must be provided as JSON, using the same format
+accepted by the REST API, in the file specified using the "--file" flag
+or on the standard input.`, obj.name, obj.name),
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			data, err := readManageInput()
+			if err != nil {
+				exitWithManageError(err)
+			}
+			var result interface{}
+			if manageURL != "" {
+				result, err = doManageAPIRequest(http.MethodPost, obj.apiPath, nil, data)
+			} else {
+				initializeManageProvider()
+				result, err = obj.add(data)
+			}
+			if err != nil {
+				exitWithManageError(err)
+			}
+			printManageResult(result)
+		},
+	}
+	addManageInputFlag(addCmd)
+
+	updateCmd := &cobra.Command{
+		Use:   "update <name>",
+		Short: fmt.Sprintf("Update an existing %v", obj.name),
+		Long: fmt.Sprintf(`Update an existing %v. The %v must be provided as JSON, using the same format
+accepted by the REST API, in the file specified using the "--file" flag
+or on the standard input. Secrets that are not in plain text, such as the
+redacted ones returned by the "get" subcommand, are not modified.`, obj.name, obj.name),
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			data, err := readManageInput()
+			if err != nil {
+				exitWithManageError(err)
+			}
+			if manageURL != "" {
+				_, err = doManageAPIRequest(http.MethodPut, getManageObjectPath(obj, args[0]), nil, data)
+			} else {
+				initializeManageProvider()
+				err = obj.update(args[0], data)
+			}
+			if err != nil {
+				exitWithManageError(err)
+			}
+			logger.InfoToConsole("%v %#v updated", obj.name, args[0])
+		},
+	}
+	addManageInputFlag(updateCmd)
+
+	deleteCmd := &cobra.Command{
+		Use:   "del <name>",
+		Short: fmt.Sprintf("Delete an existing %v", obj.name),
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			if manageURL != "" {
+				_, err = doManageAPIRequest(http.MethodDelete, getManageObjectPath(obj, args[0]), nil, nil)
+			} else {
+				initializeManageProvider()
+				err = obj.remove(args[0])
+			}
+			if err != nil {
+				exitWithManageError(err)
+			}
+			logger.InfoToConsole("%v %#v deleted", obj.name, args[0])
+		},
+	}
+
+	getCmd := &cobra.Command{
+		Use:   "get <name>",
+		Short: fmt.Sprintf("Show an existing %v as JSON", obj.name),
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var result interface{}
+			var err error
+			if manageURL != "" {
+				result, err = doManageAPIRequest(http.MethodGet, getManageObjectPath(obj, args[0]), nil, nil)
+			} else {
+				initializeManageProvider()
+				result, err = obj.get(args[0])
+			}
+			if err != nil {
+				exitWithManageError(err)
+			}
+			printManageResult(result)
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: fmt.Sprintf("List the existing %vs as JSON", obj.name),
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if manageOrder != dataprovider.OrderASC && manageOrder != dataprovider.OrderDESC {
+				exitWithManageError(fmt.Errorf("invalid order %#v", manageOrder))
+			}
+			var result interface{}
+			var err error
+			if manageURL != "" {
+				query := url.Values{}
+				query.Set("limit", strconv.Itoa(manageLimit))
+				query.Set("offset", strconv.Itoa(manageOffset))
+				query.Set("order", manageOrder)
+				result, err = doManageAPIRequest(http.MethodGet, obj.apiPath, query, nil)
+			} else {
+				initializeManageProvider()
+				result, err = obj.list(manageLimit, manageOffset, manageOrder)
+			}
+			if err != nil {
+				exitWithManageError(err)
+			}
+			printManageResult(result)
+		},
+	}
+	listCmd.Flags().IntVar(&manageLimit, "limit", 100, `Maximum number of items to return. The REST API
+returns at most 500 items`)
+	listCmd.Flags().IntVar(&manageOffset, "offset", 0, `Number of items to skip`)
+	listCmd.Flags().StringVar(&manageOrder, "order", dataprovider.OrderASC, `Ordering by name: "ASC" or "DESC"`)
+
+	for _, c := range []*cobra.Command{addCmd, updateCmd, deleteCmd, getCmd, listCmd} {
+		addManageFlags(c)
+		cmd.AddCommand(c)
+	}
+	return cmd
+}
+
+func addManageInputFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&manageInputFile, "file", "f", "-", `Path to the JSON file to read. "-" means
+standard input`)
+}
+
+func addManageFlags(cmd *cobra.Command) {
+	addConfigFlags(cmd)
+
+	viper.SetDefault(manageURLKey, "")
+	viper.BindEnv(manageURLKey, "SFTPGO_MANAGE_URL") //nolint:errcheck
+	cmd.Flags().StringVar(&manageURL, manageURLFlag, viper.GetString(manageURLKey),
+		`Base URL for the SFTPGo REST API, for example
+"http://127.0.0.1:8080". If set, the changes are
+made using the REST API, otherwise the configured
+data provider is accessed directly. This flag can
+be set using SFTPGO_MANAGE_URL env var too.`)
+	viper.BindPFlag(manageURLKey, cmd.Flags().Lookup(manageURLFlag)) //nolint:errcheck
+
+	viper.SetDefault(manageAdminUsernameKey, "")
+	viper.BindEnv(manageAdminUsernameKey, "SFTPGO_MANAGE_ADMIN_USERNAME") //nolint:errcheck
+	cmd.Flags().StringVar(&manageAdminUsername, manageAdminUsernameFlag, viper.GetString(manageAdminUsernameKey),
+		`Admin username for the REST API. This flag can
+be set using SFTPGO_MANAGE_ADMIN_USERNAME env var
+too.`)
+	viper.BindPFlag(manageAdminUsernameKey, cmd.Flags().Lookup(manageAdminUsernameFlag)) //nolint:errcheck
+
+	viper.SetDefault(manageAdminPasswordKey, "")
+	viper.BindEnv(manageAdminPasswordKey, "SFTPGO_MANAGE_ADMIN_PASSWORD") //nolint:errcheck
+	cmd.Flags().StringVar(&manageAdminPassword, manageAdminPasswordFlag, viper.GetString(manageAdminPasswordKey),
+		`Admin password for the REST API. Prefer the
+SFTPGO_MANAGE_ADMIN_PASSWORD env var to avoid
+exposing the password in the process list.`)
+	viper.BindPFlag(manageAdminPasswordKey, cmd.Flags().Lookup(manageAdminPasswordFlag)) //nolint:errcheck
+}
+
+func readManageInput() ([]byte, error) {
+	if manageInputFile == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(manageInputFile)
+}
+
+func exitWithManageError(err error) {
+	logger.ErrorToConsole("%v", err)
+	os.Exit(1)
+}
+
+func printManageResult(result interface{}) {
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		exitWithManageError(err)
+	}
+	fmt.Println(string(data))
+}
+
+// initializeManageProvider initializes the data provider using the configuration file,
+// any error is fatal
+func initializeManageProvider() {
+	logger.DisableLogger()
+	logger.EnableConsoleLogger(zerolog.InfoLevel)
+	configDir = utils.CleanDirInput(configDir)
+	err := config.LoadConfig(configDir, configFile)
+	if err != nil {
+		exitWithManageError(fmt.Errorf("unable to load configuration: %w", err))
+	}
+	kmsConfig := config.GetKMSConfig()
+	err = kmsConfig.Initialize()
+	if err != nil {
+		exitWithManageError(fmt.Errorf("unable to initialize KMS: %w", err))
+	}
+	providerConf := config.GetProviderConf()
+	if providerConf.Driver == dataprovider.MemoryDataProviderName {
+		exitWithManageError(errors.New("the memory provider cannot be managed directly, please use the REST API"))
+	}
+	err = dataprovider.Initialize(providerConf, configDir, false)
+	if err != nil {
+		exitWithManageError(fmt.Errorf("unable to initialize the data provider: %w", err))
+	}
+}
+
+func getManageObjectPath(obj *managedObject, name string) string {
+	return obj.apiPath + "/" + url.PathEscape(name)
+}
+
+func doManageAPIRequest(method, path string, query url.Values, body []byte) (interface{}, error) {
+	token, err := getManageAPIToken()
+	if err != nil {
+		return nil, err
+	}
+	return sendManageAPIRequest(method, path, query, body, func(req *http.Request) {
+		req.Header.Set("Authorization", "Bearer "+token)
+	})
+}
+
+func getManageAPIToken() (string, error) {
+	if manageAdminUsername == "" || manageAdminPassword == "" {
+		return "", errors.New("admin username and password are required to use the REST API")
+	}
+	resp, err := sendManageAPIRequest(http.MethodGet, manageTokenPath, nil, nil, func(req *http.Request) {
+		req.SetBasicAuth(manageAdminUsername, manageAdminPassword)
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to get an access token: %w", err)
+	}
+	if m, ok := resp.(map[string]interface{}); ok {
+		if token, ok := m["access_token"].(string); ok && token != "" {
+			return token, nil
+		}
+	}
+	return "", errors.New("unable to get an access token: invalid response")
+}
+
+func sendManageAPIRequest(method, path string, query url.Values, body []byte, setAuth func(*http.Request)) (interface{}, error) {
+	u, err := url.Parse(strings.TrimSuffix(manageURL, "/") + path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid REST API URL %#v: %w", manageURL, err)
+	}
+	if query != nil {
+		u.RawQuery = query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u.String(), reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("User-Agent", fmt.Sprintf("SFTPGo/%v", version.Get().Version))
+	setAuth(req)
+
+	client := &http.Client{
+		Timeout: 60 * time.Second,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("unable to decode the response, status code %v: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		if m, ok := result.(map[string]interface{}); ok {
+			if msg, ok := m["error"].(string); ok && msg != "" {
+				return nil, fmt.Errorf("unexpected status code %v: %v", resp.StatusCode, msg)
+			}
+		}
+		return nil, fmt.Errorf("unexpected status code %v", resp.StatusCode)
+	}
+	return result, nil
+}
@@ -0,0 +1,249 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+const (
+	testAdminUsername = "admin"
+	testAdminPassword = "password"
+	testAccessToken   = "test-token"
+	testUsersPath     = "/api/v2/users"
+)
+
+func setManageAPIConfig(t *testing.T, apiURL, username, password string) {
+	oldURL, oldUsername, oldPassword := manageURL, manageAdminUsername, manageAdminPassword
+	t.Cleanup(func() {
+		manageURL, manageAdminUsername, manageAdminPassword = oldURL, oldUsername, oldPassword
+	})
+	manageURL, manageAdminUsername, manageAdminPassword = apiURL, username, password
+}
+
+func writeJSONResponse(w http.ResponseWriter, statusCode int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(data) //nolint:errcheck
+}
+
+func getTestManageAPIHandler(t *testing.T) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(manageTokenPath, func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != testAdminUsername || password != testAdminPassword {
+			writeJSONResponse(w, http.StatusUnauthorized, map[string]string{"error": "invalid credentials"})
+			return
+		}
+		writeJSONResponse(w, http.StatusOK, map[string]string{"access_token": testAccessToken})
+	})
+	mux.HandleFunc(testUsersPath, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer "+testAccessToken, r.Header.Get("Authorization"))
+		switch r.Method {
+		case http.MethodGet:
+			assert.Equal(t, "10", r.URL.Query().Get("limit"))
+			assert.Equal(t, "5", r.URL.Query().Get("offset"))
+			assert.Equal(t, dataprovider.OrderDESC, r.URL.Query().Get("order"))
+			writeJSONResponse(w, http.StatusOK, []map[string]string{{"username": "user1"}})
+		case http.MethodPost:
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			body, err := io.ReadAll(r.Body)
+			assert.NoError(t, err)
+			assert.JSONEq(t, `{"username":"user1"}`, string(body))
+			writeJSONResponse(w, http.StatusCreated, map[string]string{"username": "user1"})
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	mux.HandleFunc(testUsersPath+"/user1", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer "+testAccessToken, r.Header.Get("Authorization"))
+		switch r.Method {
+		case http.MethodDelete:
+			w.WriteHeader(http.StatusOK)
+		default:
+			writeJSONResponse(w, http.StatusNotFound, map[string]string{"error": "user not found"})
+		}
+	})
+	mux.HandleFunc(testUsersPath+"/invalid", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("not json")) //nolint:errcheck
+	})
+	return mux
+}
+
+func TestManageAPIRequests(t *testing.T) {
+	server := httptest.NewServer(getTestManageAPIHandler(t))
+	defer server.Close()
+
+	setManageAPIConfig(t, server.URL+"/", testAdminUsername, testAdminPassword)
+	token, err := getManageAPIToken()
+	require.NoError(t, err)
+	assert.Equal(t, testAccessToken, token)
+
+	result, err := doManageAPIRequest(http.MethodGet, testUsersPath, map[string][]string{
+		"limit":  {"10"},
+		"offset": {"5"},
+		"order":  {dataprovider.OrderDESC},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []interface{}{map[string]interface{}{"username": "user1"}}, result)
+
+	result, err = doManageAPIRequest(http.MethodPost, testUsersPath, nil, []byte(`{"username":"user1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"username": "user1"}, result)
+	// an empty response body is accepted
+	_, err = doManageAPIRequest(http.MethodDelete, testUsersPath+"/user1", nil, nil)
+	assert.NoError(t, err)
+	// the error returned by the REST API is reported
+	_, err = doManageAPIRequest(http.MethodGet, testUsersPath+"/user1", nil, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unexpected status code 404: user not found")
+	}
+	_, err = doManageAPIRequest(http.MethodGet, testUsersPath+"/missing", nil, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unexpected status code 404")
+	}
+	_, err = doManageAPIRequest(http.MethodGet, testUsersPath+"/invalid", nil, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to decode the response")
+	}
+
+	setManageAPIConfig(t, server.URL, testAdminUsername, "wrong")
+	_, err = doManageAPIRequest(http.MethodGet, testUsersPath, nil, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to get an access token")
+		assert.Contains(t, err.Error(), "invalid credentials")
+	}
+	setManageAPIConfig(t, server.URL, "", "")
+	_, err = doManageAPIRequest(http.MethodGet, testUsersPath, nil, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "admin username and password are required")
+	}
+	setManageAPIConfig(t, "http://[::1]:namedport", testAdminUsername, testAdminPassword)
+	_, err = doManageAPIRequest(http.MethodGet, testUsersPath, nil, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid REST API URL")
+	}
+}
+
+func TestManageAPIInvalidToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, http.StatusOK, map[string]string{"token": testAccessToken})
+	}))
+	defer server.Close()
+
+	setManageAPIConfig(t, server.URL, testAdminUsername, testAdminPassword)
+	_, err := getManageAPIToken()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid response")
+	}
+}
+
+func TestManageObjectPath(t *testing.T) {
+	obj := &managedObject{
+		name:    "folder",
+		apiPath: "/api/v2/folders",
+	}
+	assert.Equal(t, "/api/v2/folders/folder1", getManageObjectPath(obj, "folder1"))
+	assert.Equal(t, "/api/v2/folders/a%2Fb%20c", getManageObjectPath(obj, "a/b c"))
+}
+
+func TestReadManageInput(t *testing.T) {
+	oldInputFile := manageInputFile
+	defer func() {
+		manageInputFile = oldInputFile
+	}()
+
+	manageInputFile = filepath.Join(t.TempDir(), "user.json")
+	_, err := readManageInput()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	err = os.WriteFile(manageInputFile, []byte(`{"username":"user1"}`), 0600)
+	require.NoError(t, err)
+	data, err := readManageInput()
+	require.NoError(t, err)
+	assert.Equal(t, `{"username":"user1"}`, string(data))
+}
+
+func TestManageCommands(t *testing.T) {
+	assert.Equal(t, "user", userCmd.Name())
+	assert.Equal(t, "folder", folderCmd.Name())
+	assert.Equal(t, "admin", adminCmd.Name())
+	for _, cmd := range []*cobra.Command{userCmd, folderCmd, adminCmd} {
+		subcommands := make(map[string]*cobra.Command)
+		for _, c := range cmd.Commands() {
+			subcommands[c.Name()] = c
+		}
+		for _, name := range []string{"add", "update", "del", "get", "list"} {
+			c, ok := subcommands[name]
+			if assert.True(t, ok, "%v %v", cmd.Name(), name) {
+				assert.NotNil(t, c.Flags().Lookup(manageURLFlag))
+				assert.NotNil(t, c.Flags().Lookup(manageAdminUsernameFlag))
+				assert.NotNil(t, c.Flags().Lookup(manageAdminPasswordFlag))
+			}
+		}
+		assert.NotNil(t, subcommands["add"].Flags().Lookup("file"))
+		assert.NotNil(t, subcommands["update"].Flags().Lookup("file"))
+		assert.Nil(t, subcommands["del"].Flags().Lookup("file"))
+		assert.NotNil(t, subcommands["list"].Flags().Lookup("limit"))
+		assert.NotNil(t, subcommands["list"].Flags().Lookup("order"))
+		assert.Error(t, subcommands["get"].Args(subcommands["get"], nil))
+		assert.NoError(t, subcommands["get"].Args(subcommands["get"], []string{"name"}))
+		assert.Error(t, subcommands["list"].Args(subcommands["list"], []string{"name"}))
+	}
+}
+
+func TestUpdatedFsConfig(t *testing.T) {
+	current := vfs.Filesystem{
+		Provider: vfs.S3FilesystemProvider,
+		S3Config: vfs.S3FsConfig{
+			Bucket:         "bucket",
+			AccessSecret:   kms.NewSecret(kms.SecretStatusSecretBox, "encrypted secret", "key", ""),
+			SSECustomerKey: kms.NewSecret(kms.SecretStatusSecretBox, "encrypted key", "key", ""),
+		},
+	}
+	fsConfig := current
+	resetFsProviderConfigs(&fsConfig)
+	assert.Empty(t, fsConfig.S3Config.Bucket)
+	assert.Nil(t, fsConfig.S3Config.AccessSecret)
+	// the current encrypted secret is kept for the redacted one and replaced
+	// with the one in plain text
+	err := json.Unmarshal([]byte(`{"provider":1,"s3config":{"bucket":"b","access_secret":{"status":"Redacted","payload":"redacted"},
+"sse_customer_key":{"status":"Plain","payload":"new key"}}}`), &fsConfig)
+	require.NoError(t, err)
+	keepEncryptedSecrets(&fsConfig, &current)
+	assert.Equal(t, "b", fsConfig.S3Config.Bucket)
+	assert.Equal(t, kms.SecretStatusSecretBox, fsConfig.S3Config.AccessSecret.GetStatus())
+	assert.Equal(t, "encrypted secret", fsConfig.S3Config.AccessSecret.GetPayload())
+	assert.Equal(t, kms.SecretStatusPlain, fsConfig.S3Config.SSECustomerKey.GetStatus())
+	assert.Equal(t, "new key", fsConfig.S3Config.SSECustomerKey.GetPayload())
+
+	current = vfs.Filesystem{
+		Provider: vfs.SFTPFilesystemProvider,
+		SFTPConfig: vfs.SFTPFsConfig{
+			Password:   kms.NewSecret(kms.SecretStatusSecretBox, "encrypted password", "key", ""),
+			PrivateKey: kms.NewEmptySecret(),
+		},
+	}
+	fsConfig = vfs.Filesystem{
+		Provider: vfs.SFTPFilesystemProvider,
+		SFTPConfig: vfs.SFTPFsConfig{
+			Password:   kms.NewSecret(kms.SecretStatusRedacted, "redacted", "", ""),
+			PrivateKey: kms.NewEmptySecret(),
+		},
+	}
+	keepEncryptedSecrets(&fsConfig, &current)
+	assert.Equal(t, "encrypted password", fsConfig.SFTPConfig.Password.GetPayload())
+	assert.True(t, fsConfig.SFTPConfig.PrivateKey.IsEmpty())
+}
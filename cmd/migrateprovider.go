@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+	"github.com/spf13/cobra"
+
+	"github.com/drakkan/sftpgo/config"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+var (
+	migrateProviderFrom                 string
+	migrateProviderFromName             string
+	migrateProviderFromConnectionString string
+	migrateProviderTo                   string
+	migrateProviderToName               string
+	migrateProviderToConnectionString   string
+	migrateProviderJSON                 bool
+	migrateProviderCmd                  = &cobra.Command{
+		Use:   "migrateprovider",
+		Short: "Copy users, folders and admins between data providers",
+		Long: `This command copies the virtual folders, the users, including their used
+quota, and the admins from a data provider to another one. The destination
+provider is initialized, if required, and the objects are validated before
+saving them.
+
+The connection settings for both providers are read from the data provider
+configuration, the driver must be specified for each provider and the database
+name and the connection string can be overridden using the specific flags.
+For example, to move from SQLite to PostgreSQL, configure the PostgreSQL
+connection settings and then run:
+
+$ sftpgo migrateprovider --config-dir /etc/sftpgo --from sqlite --from-name sftpgo.db --to postgresql
+
+The objects already existing in the destination provider are skipped and
+reported, so the command can be safely executed more than once.
+SFTPGo must be stopped while migrating, the memory provider can only be used
+as source.
+
+Please take a look at the usage below to customize the options.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			logger.DisableLogger()
+			logger.EnableConsoleLogger(zerolog.InfoLevel)
+			configDir = utils.CleanDirInput(configDir)
+			err := config.LoadConfig(configDir, configFile)
+			if err != nil {
+				exitWithManageError(fmt.Errorf("unable to load configuration: %w", err))
+			}
+			kmsConfig := config.GetKMSConfig()
+			err = kmsConfig.Initialize()
+			if err != nil {
+				exitWithManageError(fmt.Errorf("unable to initialize KMS: %w", err))
+			}
+			source := config.GetProviderConf()
+			source.Driver = migrateProviderFrom
+			if migrateProviderFromName != "" {
+				source.Name = migrateProviderFromName
+			}
+			if migrateProviderFromConnectionString != "" {
+				source.ConnectionString = migrateProviderFromConnectionString
+			}
+			destination := config.GetProviderConf()
+			destination.Driver = migrateProviderTo
+			if migrateProviderToName != "" {
+				destination.Name = migrateProviderToName
+			}
+			if migrateProviderToConnectionString != "" {
+				destination.ConnectionString = migrateProviderToConnectionString
+			}
+			if !migrateProviderJSON {
+				logger.InfoToConsole("Migrating from provider %#v to provider %#v", source.Driver, destination.Driver)
+			}
+			result, err := dataprovider.MigrateProvider(source, destination, configDir)
+			if err != nil {
+				exitWithManageError(err)
+			}
+			if migrateProviderJSON {
+				printManageResult(result)
+			} else {
+				for _, skipped := range result.Skipped {
+					logger.InfoToConsole("%v already exists in the destination provider, skipped", skipped)
+				}
+				for _, errString := range result.Errors {
+					logger.WarnToConsole("%v", errString)
+				}
+				logger.InfoToConsole("Migration completed, users: %v, folders: %v, admins: %v, skipped: %v, errors: %v",
+					result.Users, result.Folders, result.Admins, len(result.Skipped), len(result.Errors))
+			}
+			if len(result.Errors) > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func init() {
+	addConfigFlags(migrateProviderCmd)
+	migrateProviderCmd.Flags().StringVar(&migrateProviderFrom, "from", "", `Driver for the source provider:
+"sqlite", "bolt", "mysql", "postgresql",
+"cockroachdb" or "memory"`)
+	migrateProviderCmd.Flags().StringVar(&migrateProviderFromName, "from-name", "", `Database name or path for the source
+provider`)
+	migrateProviderCmd.Flags().StringVar(&migrateProviderFromConnectionString, "from-connection-string", "",
+		`Connection string for the source
+provider`)
+	migrateProviderCmd.Flags().StringVar(&migrateProviderTo, "to", "", `Driver for the destination provider:
+"sqlite", "bolt", "mysql", "postgresql"
+or "cockroachdb"`)
+	migrateProviderCmd.Flags().StringVar(&migrateProviderToName, "to-name", "", `Database name or path for the
+destination provider`)
+	migrateProviderCmd.Flags().StringVar(&migrateProviderToConnectionString, "to-connection-string", "",
+		`Connection string for the destination
+provider`)
+	migrateProviderCmd.Flags().BoolVar(&migrateProviderJSON, "json", false, `Print the results as JSON`)
+	migrateProviderCmd.MarkFlagRequired("from") //nolint:errcheck
+	migrateProviderCmd.MarkFlagRequired("to")   //nolint:errcheck
+
+	rootCmd.AddCommand(migrateProviderCmd)
+}
@@ -44,6 +44,9 @@ var (
 	portableS3KeyPrefix                string
 	portableS3ULPartSize               int
 	portableS3ULConcurrency            int
+	portableS3ULMaxBufferSize          int
+	portableS3DLPartSize               int
+	portableS3DLConcurrency            int
 	portableGCSBucket                  string
 	portableGCSCredentialsFile         string
 	portableGCSAutoCredentials         int
@@ -153,15 +156,18 @@ Please take a look at the usage below to customize the serving parameters`,
 					FsConfig: vfs.Filesystem{
 						Provider: vfs.FilesystemProvider(portableFsProvider),
 						S3Config: vfs.S3FsConfig{
-							Bucket:            portableS3Bucket,
-							Region:            portableS3Region,
-							AccessKey:         portableS3AccessKey,
-							AccessSecret:      kms.NewPlainSecret(portableS3AccessSecret),
-							Endpoint:          portableS3Endpoint,
-							StorageClass:      portableS3StorageClass,
-							KeyPrefix:         portableS3KeyPrefix,
-							UploadPartSize:    int64(portableS3ULPartSize),
-							UploadConcurrency: portableS3ULConcurrency,
+							Bucket:              portableS3Bucket,
+							Region:              portableS3Region,
+							AccessKey:           portableS3AccessKey,
+							AccessSecret:        kms.NewPlainSecret(portableS3AccessSecret),
+							Endpoint:            portableS3Endpoint,
+							StorageClass:        portableS3StorageClass,
+							KeyPrefix:           portableS3KeyPrefix,
+							UploadPartSize:      int64(portableS3ULPartSize),
+							UploadConcurrency:   portableS3ULConcurrency,
+							UploadMaxBufferSize: int64(portableS3ULMaxBufferSize),
+							DownloadPartSize:    int64(portableS3DLPartSize),
+							DownloadConcurrency: portableS3DLConcurrency,
 						},
 						GCSConfig: vfs.GCSFsConfig{
 							Bucket:               portableGCSBucket,
@@ -277,6 +283,13 @@ prefix and its contents`)
 	portableCmd.Flags().IntVar(&portableS3ULPartSize, "s3-upload-part-size", 5, `The buffer size for multipart uploads
 (MB)`)
 	portableCmd.Flags().IntVar(&portableS3ULConcurrency, "s3-upload-concurrency", 2, `How many parts are uploaded in
+parallel`)
+	portableCmd.Flags().IntVar(&portableS3ULMaxBufferSize, "s3-upload-max-buffer-size", 0, `The maximum memory used to
+buffer the parts of an upload (MB). 0 means
+no limit`)
+	portableCmd.Flags().IntVar(&portableS3DLPartSize, "s3-download-part-size", 5, `The buffer size for multipart downloads
+(MB)`)
+	portableCmd.Flags().IntVar(&portableS3DLConcurrency, "s3-download-concurrency", 5, `How many parts are downloaded in
 parallel`)
 	portableCmd.Flags().StringVar(&portableGCSBucket, "gcs-bucket", "", "")
 	portableCmd.Flags().StringVar(&portableGCSStorageClass, "gcs-storage-class", "", "")
@@ -8,6 +8,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 
+	"github.com/drakkan/sftpgo/config"
 	"github.com/drakkan/sftpgo/version"
 )
 
@@ -16,6 +17,8 @@ const (
 	configDirKey             = "config_dir"
 	configFileFlag           = "config-file"
 	configFileKey            = "config_file"
+	configStrictFlag         = "config-strict"
+	configStrictKey          = "config_strict"
 	logFilePathFlag          = "log-file-path"
 	logFilePathKey           = "log_file_path"
 	logMaxSizeFlag           = "log-max-size"
@@ -28,6 +31,12 @@ const (
 	logCompressKey           = "log_compress"
 	logVerboseFlag           = "log-verbose"
 	logVerboseKey            = "log_verbose"
+	logJournalDFlag          = "log-to-journald"
+	logJournalDKey           = "log_to_journald"
+	logLevelsFlag            = "log-levels"
+	logLevelsKey             = "log_levels"
+	logFormatFlag            = "log-format"
+	logFormatKey             = "log_format"
 	loadDataFromFlag         = "loaddata-from"
 	loadDataFromKey          = "loaddata_from"
 	loadDataModeFlag         = "loaddata-mode"
@@ -38,12 +47,16 @@ const (
 	loadDataCleanKey         = "loaddata_clean"
 	defaultConfigDir         = "."
 	defaultConfigFile        = ""
+	defaultConfigStrict      = false
 	defaultLogFile           = "sftpgo.log"
 	defaultLogMaxSize        = 10
 	defaultLogMaxBackup      = 5
 	defaultLogMaxAge         = 28
 	defaultLogCompress       = false
 	defaultLogVerbose        = true
+	defaultLogJournalD       = false
+	defaultLogLevels         = ""
+	defaultLogFormat         = "json"
 	defaultLoadDataFrom      = ""
 	defaultLoadDataMode      = 1
 	defaultLoadDataQuotaScan = 0
@@ -53,12 +66,16 @@ const (
 var (
 	configDir         string
 	configFile        string
+	configStrict      bool
 	logFilePath       string
 	logMaxSize        int
 	logMaxBackups     int
 	logMaxAge         int
 	logCompress       bool
 	logVerbose        bool
+	logToJournalD     bool
+	logLevels         string
+	logFormat         string
 	loadDataFrom      string
 	loadDataMode      int
 	loadDataQuotaScan int
@@ -67,6 +84,9 @@ var (
 	rootCmd = &cobra.Command{
 		Use:   "sftpgo",
 		Short: "Fully featured and highly configurable SFTP server",
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			config.SetStrictMode(configStrict)
+		},
 	}
 )
 
@@ -119,6 +139,17 @@ YAML, TOML, HCL or Java properties).
 This flag can be set using SFTPGO_CONFIG_FILE
 env var too.`)
 	viper.BindPFlag(configFileKey, cmd.Flags().Lookup(configFileFlag)) //nolint:errcheck
+
+	viper.SetDefault(configStrictKey, defaultConfigStrict)
+	viper.BindEnv(configStrictKey, "SFTPGO_CONFIG_STRICT") //nolint:errcheck
+	cmd.Flags().BoolVar(&configStrict, configStrictFlag, viper.GetBool(configStrictKey),
+		`Refuse to load a configuration containing
+unknown keys, for example misspelled ones,
+instead of silently ignoring them. The
+unknown keys are listed in the error.
+This flag can be set using
+SFTPGO_CONFIG_STRICT env var too.`)
+	viper.BindPFlag(configStrictKey, cmd.Flags().Lookup(configStrictFlag)) //nolint:errcheck
 }
 
 func addServeFlags(cmd *cobra.Command) {
@@ -179,6 +210,46 @@ using SFTPGO_LOG_VERBOSE env var too.
 `)
 	viper.BindPFlag(logVerboseKey, cmd.Flags().Lookup(logVerboseFlag)) //nolint:errcheck
 
+	viper.SetDefault(logJournalDKey, defaultLogJournalD)
+	viper.BindEnv(logJournalDKey, "SFTPGO_LOG_TO_JOURNALD") //nolint:errcheck
+	cmd.Flags().BoolVar(&logToJournalD, logJournalDFlag, viper.GetBool(logJournalDKey),
+		`Send logs to journald instead of the log file.
+The log fields, for example sender,
+connection_id, username and protocol, are sent
+as journal fields, so you can filter the logs
+using journalctl, for example:
+journalctl SENDER=SFTP USERNAME=user1
+If journald is not available the log file is
+used. Only available on Linux. This flag can be
+set using SFTPGO_LOG_TO_JOURNALD env var too.
+`)
+	viper.BindPFlag(logJournalDKey, cmd.Flags().Lookup(logJournalDFlag)) //nolint:errcheck
+
+	viper.SetDefault(logLevelsKey, defaultLogLevels)
+	viper.BindEnv(logLevelsKey, "SFTPGO_LOG_LEVELS") //nolint:errcheck
+	cmd.Flags().StringVar(&logLevels, logLevelsFlag, viper.GetString(logLevelsKey),
+		`Per-sender log level overrides, for example
+"sftpd=debug,dataprovider=warn,httpd=info".
+Supported levels: debug, info, warn, error.
+The other senders use the level defined by
+log-verbose. This flag can be set using
+SFTPGO_LOG_LEVELS env var too.
+`)
+	viper.BindPFlag(logLevelsKey, cmd.Flags().Lookup(logLevelsFlag)) //nolint:errcheck
+
+	viper.SetDefault(logFormatKey, defaultLogFormat)
+	viper.BindEnv(logFormatKey, "SFTPGO_LOG_FORMAT") //nolint:errcheck
+	cmd.Flags().StringVar(&logFormat, logFormatFlag, viper.GetString(logFormatKey),
+		`Log format. Supported values: "json",
+"ecs", "console". "ecs" writes JSON logs using
+the Elastic Common Schema field names,
+"console" writes human-readable logs with
+colors if the output is a terminal. Ignored if
+log-to-journald is enabled. This flag can be set
+using SFTPGO_LOG_FORMAT env var too.
+`)
+	viper.BindPFlag(logFormatKey, cmd.Flags().Lookup(logFormatFlag)) //nolint:errcheck
+
 	viper.SetDefault(loadDataFromKey, defaultLoadDataFrom)
 	viper.BindEnv(loadDataFromKey, "SFTPGO_LOADDATA_FROM") //nolint:errcheck
 	cmd.Flags().StringVar(&loadDataFrom, loadDataFromFlag, viper.GetString(loadDataFromKey),
@@ -18,6 +18,7 @@ var (
 				Service: service.Service{
 					Shutdown: make(chan bool),
 				},
+				Name: serviceName,
 			}
 			err := s.RotateLogFile()
 			if err != nil {
@@ -32,4 +33,5 @@ var (
 
 func init() {
 	serviceCmd.AddCommand(rotateLogCmd)
+	addServiceNameFlag(rotateLogCmd)
 }
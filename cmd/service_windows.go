@@ -4,8 +4,11 @@ import (
 	"github.com/spf13/cobra"
 )
 
+const serviceNameFlag = "name"
+
 var (
-	serviceCmd = &cobra.Command{
+	serviceName string
+	serviceCmd  = &cobra.Command{
 		Use:   "service",
 		Short: "Manage SFTPGo Windows Service",
 	}
@@ -14,3 +17,12 @@ var (
 func init() {
 	rootCmd.AddCommand(serviceCmd)
 }
+
+func addServiceNameFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&serviceName, serviceNameFlag, "", `Instance name. Named instances allow to run
+multiple SFTPGo services, for example with
+different configuration directories, on the
+same host. The Windows service name will be
+"SFTPGo-<name>". Leave empty for the default
+instance`)
+}
@@ -29,10 +29,13 @@ var (
 				LogMaxAge:     logMaxAge,
 				LogCompress:   logCompress,
 				LogVerbose:    logVerbose,
+				LogLevels:     logLevels,
+				LogFormat:     logFormat,
 				Shutdown:      make(chan bool),
 			}
 			winService := service.WindowsService{
 				Service: s,
+				Name:    serviceName,
 			}
 			err := winService.RunService()
 			if err != nil {
@@ -47,5 +50,6 @@ var (
 
 func init() {
 	serviceCmd.AddCommand(startCmd)
+	addServiceNameFlag(startCmd)
 	addServeFlags(startCmd)
 }
@@ -18,6 +18,7 @@ var (
 				Service: service.Service{
 					Shutdown: make(chan bool),
 				},
+				Name: serviceName,
 			}
 			err := s.Uninstall()
 			if err != nil {
@@ -32,4 +33,5 @@ var (
 
 func init() {
 	serviceCmd.AddCommand(uninstallCmd)
+	addServiceNameFlag(uninstallCmd)
 }
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/json"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+var userCmd = newManageCmd(&managedObject{
+	name:    "user",
+	apiPath: "/api/v2/users",
+	list: func(limit, offset int, order string) (interface{}, error) {
+		users, err := dataprovider.GetUsers(limit, offset, order)
+		if err != nil {
+			return nil, err
+		}
+		for idx := range users {
+			users[idx].PrepareForRendering()
+		}
+		return users, nil
+	},
+	get: func(name string) (interface{}, error) {
+		user, err := dataprovider.UserExists(name)
+		if err != nil {
+			return nil, err
+		}
+		user.PrepareForRendering()
+		return user, nil
+	},
+	add: func(data []byte) (interface{}, error) {
+		var user dataprovider.User
+		if err := json.Unmarshal(data, &user); err != nil {
+			return nil, err
+		}
+		user.SetEmptySecretsIfNil()
+		if err := dataprovider.AddUser(&user); err != nil {
+			return nil, err
+		}
+		user, err := dataprovider.UserExists(user.Username)
+		if err != nil {
+			return nil, err
+		}
+		user.PrepareForRendering()
+		return user, nil
+	},
+	update: func(name string, data []byte) error {
+		user, err := dataprovider.UserExists(name)
+		if err != nil {
+			return err
+		}
+		userID := user.ID
+		currentPermissions := user.Permissions
+		currentFsConfig := user.FsConfig
+
+		user.Permissions = make(map[string][]string)
+		resetFsProviderConfigs(&user.FsConfig)
+		user.VirtualFolders = nil
+		if err := json.Unmarshal(data, &user); err != nil {
+			return err
+		}
+		user.ID = userID
+		user.Username = name
+		user.SetEmptySecretsIfNil()
+		// we use new Permissions if passed otherwise the old ones
+		if len(user.Permissions) == 0 {
+			user.Permissions = currentPermissions
+		}
+		keepEncryptedSecrets(&user.FsConfig, &currentFsConfig)
+		return dataprovider.UpdateUser(&user)
+	},
+	remove: dataprovider.DeleteUser,
+}, `Add, update, delete and list SFTPGo users. The users are managed directly using
+the configured data provider or, if the "--url" flag is set, using the REST API.
+
+The REST API is the preferred way while the SFTPGo service is running: the
+service is notified about the changes and the bolt provider cannot be opened
+by more than one process at the same time. The memory provider can be managed
+using the REST API only.
+
+Examples:
+
+$ sftpgo user add -f user.json
+$ sftpgo user get user1 --url http://127.0.0.1:8080 --admin-username admin
+$ sftpgo user list --limit 50
+$ sftpgo user del user1`)
+
+// resetFsProviderConfigs resets the provider specific configurations so they
+// are replaced, and not merged, when the updated object is decoded
+func resetFsProviderConfigs(fsConfig *vfs.Filesystem) {
+	fsConfig.S3Config = vfs.S3FsConfig{}
+	fsConfig.AzBlobConfig = vfs.AzBlobFsConfig{}
+	fsConfig.GCSConfig = vfs.GCSFsConfig{}
+	fsConfig.CryptConfig = vfs.CryptFsConfig{}
+	fsConfig.SFTPConfig = vfs.SFTPFsConfig{}
+	fsConfig.PluginConfig = vfs.PluginFsConfig{}
+	fsConfig.SMBConfig = vfs.SMBFsConfig{}
+}
+
+// keepEncryptedSecrets restores the current secrets for the ones that are not
+// in plain text in the updated filesystem config, for example the redacted ones
+func keepEncryptedSecrets(fsConfig, current *vfs.Filesystem) {
+	switch fsConfig.Provider {
+	case vfs.S3FilesystemProvider:
+		if fsConfig.S3Config.AccessSecret.IsNotPlainAndNotEmpty() {
+			fsConfig.S3Config.AccessSecret = current.S3Config.AccessSecret
+		}
+		if fsConfig.S3Config.SSECustomerKey.IsNotPlainAndNotEmpty() {
+			fsConfig.S3Config.SSECustomerKey = current.S3Config.SSECustomerKey
+		}
+	case vfs.AzureBlobFilesystemProvider:
+		if fsConfig.AzBlobConfig.AccountKey.IsNotPlainAndNotEmpty() {
+			fsConfig.AzBlobConfig.AccountKey = current.AzBlobConfig.AccountKey
+		}
+	case vfs.GCSFilesystemProvider:
+		if fsConfig.GCSConfig.Credentials.IsNotPlainAndNotEmpty() {
+			fsConfig.GCSConfig.Credentials = current.GCSConfig.Credentials
+		}
+	case vfs.CryptedFilesystemProvider:
+		if fsConfig.CryptConfig.Passphrase.IsNotPlainAndNotEmpty() {
+			fsConfig.CryptConfig.Passphrase = current.CryptConfig.Passphrase
+		}
+	case vfs.SFTPFilesystemProvider:
+		if fsConfig.SFTPConfig.Password.IsNotPlainAndNotEmpty() {
+			fsConfig.SFTPConfig.Password = current.SFTPConfig.Password
+		}
+		if fsConfig.SFTPConfig.PrivateKey.IsNotPlainAndNotEmpty() {
+			fsConfig.SFTPConfig.PrivateKey = current.SFTPConfig.PrivateKey
+		}
+	case vfs.PluginFilesystemProvider:
+		if fsConfig.PluginConfig.Options.IsNotPlainAndNotEmpty() {
+			fsConfig.PluginConfig.Options = current.PluginConfig.Options
+		}
+	case vfs.SMBFilesystemProvider:
+		if fsConfig.SMBConfig.Password.IsNotPlainAndNotEmpty() {
+			fsConfig.SMBConfig.Password = current.SMBConfig.Password
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(userCmd)
+}
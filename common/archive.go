@@ -0,0 +1,413 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// maxArchivedStubSize defines the maximum size for a stub, only the first bytes
+// are read for bigger files
+const maxArchivedStubSize = 65536
+
+// ArchivedFile defines a file moved to an archive folder by a retention rule.
+// It is saved, as JSON, inside the stub left in place of the file
+type ArchivedFile struct {
+	// original virtual path
+	Path string `json:"path"`
+	// name of the virtual folder containing the archived file
+	Folder string `json:"folder"`
+	// path of the archived file inside the archive folder. It is informational only,
+	// the restore uses the path derived from the username and the original path
+	ArchivePath string `json:"archive_path"`
+	// file size in bytes
+	Size int64 `json:"size"`
+	// last modification time as unix timestamp in milliseconds
+	ModTime int64 `json:"mod_time"`
+	// archive time as unix timestamp in milliseconds
+	ArchivedAt int64 `json:"archived_at"`
+}
+
+func (f *ArchivedFile) getFileInfo(name string) os.FileInfo {
+	return vfs.NewFileInfo(name, false, f.Size, utils.GetTimeFromMsecSinceEpoch(f.ModTime), false)
+}
+
+// getArchiveFolderFs returns the archive folder with the given name and its filesystem
+func getArchiveFolderFs(name, connectionID string) (vfs.BaseVirtualFolder, vfs.Fs, error) {
+	folder, err := dataprovider.GetFolderByName(name)
+	if err != nil {
+		return folder, nil, err
+	}
+	vfolder := vfs.VirtualFolder{
+		BaseVirtualFolder: folder,
+		VirtualPath:       "/",
+	}
+	fs, err := vfolder.GetFilesystem(connectionID, nil)
+	return folder, fs, err
+}
+
+// openFsReader opens the file at fsPath for reading. The returned function
+// must be called when the reader is no longer needed
+func openFsReader(fs vfs.Fs, fsPath string) (io.ReadCloser, func(), error) {
+	file, pipeReader, cancelFn, err := fs.Open(fsPath, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if cancelFn == nil {
+		cancelFn = func() {}
+	}
+	if file != nil {
+		return file, cancelFn, nil
+	}
+	return pipeReader, cancelFn, nil
+}
+
+// writeToFs writes the reader contents to the file at fsPath, the file is
+// created or truncated. Returns the number of bytes written
+func writeToFs(fs vfs.Fs, fsPath string, reader io.Reader) (int64, error) {
+	file, pipeWriter, cancelFn, err := fs.Create(fsPath, 0)
+	if err != nil {
+		return 0, err
+	}
+	var writer io.WriteCloser
+	if file != nil {
+		writer = file
+	} else {
+		writer = pipeWriter
+	}
+	written, err := io.Copy(writer, reader)
+	if err != nil && cancelFn != nil {
+		cancelFn()
+	}
+	errClose := writer.Close()
+	if err == nil {
+		err = errClose
+	}
+	return written, err
+}
+
+// copyFileBetweenFs copies the file at srcPath inside srcFs to dstPath inside dstFs.
+// Returns the number of bytes copied
+func copyFileBetweenFs(srcFs vfs.Fs, srcPath string, dstFs vfs.Fs, dstPath string) (int64, error) {
+	reader, cancelFn, err := openFsReader(srcFs, srcPath)
+	if err != nil {
+		return 0, err
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	return writeToFs(dstFs, dstPath, reader)
+}
+
+func readArchivedStub(fs vfs.Fs, fsPath string) (ArchivedFile, error) {
+	var archived ArchivedFile
+	reader, cancelFn, err := openFsReader(fs, fsPath)
+	if err != nil {
+		return archived, err
+	}
+	defer cancelFn()
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, maxArchivedStubSize))
+	if err != nil {
+		return archived, err
+	}
+	err = json.Unmarshal(data, &archived)
+	return archived, err
+}
+
+func writeArchivedStub(fs vfs.Fs, fsPath string, archived *ArchivedFile) error {
+	data, err := json.Marshal(archived)
+	if err != nil {
+		return err
+	}
+	_, err = writeToFs(fs, fsPath, bytes.NewReader(data))
+	return err
+}
+
+// getArchivedStubPath returns the filesystem path of the stub for the given virtual path
+func (c *BaseConnection) getArchivedStubPath(fs vfs.Fs, virtualPath string) (string, error) {
+	mountPath := c.User.GetMountPath(virtualPath)
+	relPath := strings.TrimPrefix(virtualPath, mountPath)
+	return fs.ResolvePath(path.Join(mountPath, dataprovider.ArchivedDirName, relPath))
+}
+
+// getArchivedFile returns the archived file for the given virtual path and the
+// filesystem path of its stub
+func (c *BaseConnection) getArchivedFile(fs vfs.Fs, virtualPath string) (ArchivedFile, string, error) {
+	if !c.User.HasArchiveRules() || c.User.IsReservedPath(virtualPath) {
+		return ArchivedFile{}, "", os.ErrNotExist
+	}
+	fsStubPath, err := c.getArchivedStubPath(fs, virtualPath)
+	if err != nil {
+		return ArchivedFile{}, "", err
+	}
+	archived, err := readArchivedStub(fs, fsStubPath)
+	return archived, fsStubPath, err
+}
+
+// getArchivedEntries returns the archived files inside the given directory as
+// regular files with their original size and modification time
+func (c *BaseConnection) getArchivedEntries(fs vfs.Fs, virtualPath string) []os.FileInfo {
+	if !c.User.HasArchiveRules() || c.User.IsReservedPath(virtualPath) {
+		return nil
+	}
+	fsStubsDir, err := c.getArchivedStubPath(fs, virtualPath)
+	if err != nil {
+		return nil
+	}
+	entries, err := fs.ReadDir(fsStubsDir)
+	if err != nil {
+		return nil
+	}
+	var result []os.FileInfo
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		archived, err := readArchivedStub(fs, fs.Join(fsStubsDir, entry.Name()))
+		if err != nil {
+			c.Log(logger.LevelWarn, "unable to read archived stub %#v: %v", entry.Name(), err)
+			continue
+		}
+		result = append(result, archived.getFileInfo(entry.Name()))
+	}
+	return result
+}
+
+// appendArchivedEntries adds the archived files to the listed ones, a listed
+// file with the same name replaces the archived one
+func appendArchivedEntries(files, archived []os.FileInfo) []os.FileInfo {
+	if len(archived) == 0 {
+		return files
+	}
+	names := make(map[string]bool)
+	for _, info := range files {
+		names[info.Name()] = true
+	}
+	for _, info := range archived {
+		if !names[info.Name()] {
+			files = append(files, info)
+		}
+	}
+	return files
+}
+
+// getArchivePath returns the path, inside the archive folder, for the file with
+// the given virtual path
+func (c *BaseConnection) getArchivePath(virtualPath string) string {
+	return path.Join("/", c.User.Username, virtualPath)
+}
+
+// moveToArchiveFolder copies the given expired file inside the archive folder
+// with the given name and replaces the file with a stub
+func (c *BaseConnection) moveToArchiveFolder(fs vfs.Fs, file expiredFile, folderName string) error {
+	folder, archiveFs, err := getArchiveFolderFs(folderName, c.ID)
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to get archive folder %#v: %+v", folderName, err)
+		return err
+	}
+	defer archiveFs.Close()
+
+	archived := ArchivedFile{
+		Path:        file.virtualPath,
+		Folder:      folder.Name,
+		ArchivePath: c.getArchivePath(file.virtualPath),
+		Size:        file.size,
+		ModTime:     utils.GetTimeAsMsSinceEpoch(file.modTime),
+		ArchivedAt:  utils.GetTimeAsMsSinceEpoch(time.Now()),
+	}
+	fsArchivePath, err := archiveFs.ResolvePath(archived.ArchivePath)
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to resolve archive path %#v: %+v", archived.ArchivePath, err)
+		return err
+	}
+	fsStubPath, err := c.getArchivedStubPath(fs, file.virtualPath)
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to resolve the stub path for %#v: %+v", file.virtualPath, err)
+		return err
+	}
+	if err := c.createParentDirs(archiveFs, fsArchivePath); err != nil {
+		c.Log(logger.LevelWarn, "unable to create archive dir for %#v: %+v", fsArchivePath, err)
+		return err
+	}
+	if _, err := copyFileBetweenFs(fs, file.fsPath, archiveFs, fsArchivePath); err != nil {
+		c.Log(logger.LevelWarn, "unable to copy expired file %#v to archive folder %#v: %+v", file.fsPath, folder.Name, err)
+		archiveFs.Remove(fsArchivePath, false) //nolint:errcheck
+		return err
+	}
+	err = c.createParentDirs(fs, fsStubPath)
+	if err == nil {
+		err = writeArchivedStub(fs, fsStubPath, &archived)
+	}
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to write the stub for archived file %#v: %+v", file.fsPath, err)
+		archiveFs.Remove(fsArchivePath, false) //nolint:errcheck
+		return err
+	}
+	if err := fs.Remove(file.fsPath, false); err != nil {
+		c.Log(logger.LevelWarn, "unable to remove archived file %#v: %+v", file.fsPath, err)
+		fs.Remove(fsStubPath, false)           //nolint:errcheck
+		archiveFs.Remove(fsArchivePath, false) //nolint:errcheck
+		return err
+	}
+	logger.CommandLog(archiveLogSender, file.fsPath, fsArchivePath, c.User.Username, "", c.ID, c.protocol, -1, -1,
+		"", "", "", -1)
+	// the file is no longer stored inside the user's filesystem
+	c.updateQuotaForPath(file.virtualPath, -1, -file.size)
+	dataprovider.UpdateVirtualFolderQuota(&folder, 1, file.size, false) //nolint:errcheck
+	return nil
+}
+
+// restoreArchivedFile copies the archived file back to fsPath and removes the
+// stub and the archived copy. Permissions are not checked. The stub is stored
+// inside the user's filesystem so the archive folder must be one of the folders
+// configured for the user and the archive path is not read from the stub
+func (c *BaseConnection) restoreArchivedFile(fs vfs.Fs, fsPath, virtualPath, fsStubPath string,
+	archived *ArchivedFile,
+) error {
+	if !c.User.IsArchiveFolder(archived.Folder) {
+		c.Log(logger.LevelWarn, "unable to restore archived file %#v, folder %#v is not an archive folder for the user",
+			virtualPath, archived.Folder)
+		return os.ErrPermission
+	}
+	folder, archiveFs, err := getArchiveFolderFs(archived.Folder, c.ID)
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to get archive folder %#v: %+v", archived.Folder, err)
+		return err
+	}
+	defer archiveFs.Close()
+
+	archivePath := c.getArchivePath(virtualPath)
+	fsArchivePath, err := archiveFs.ResolvePath(archivePath)
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to resolve archive path %#v: %+v", archivePath, err)
+		return err
+	}
+	if err := c.createParentDirs(fs, fsPath); err != nil {
+		c.Log(logger.LevelWarn, "unable to create missing dirs for %#v: %+v", fsPath, err)
+		return err
+	}
+	size, err := copyFileBetweenFs(archiveFs, fsArchivePath, fs, fsPath)
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to restore archived file %#v: %+v", virtualPath, err)
+		fs.Remove(fsPath, false) //nolint:errcheck
+		return err
+	}
+	if err := fs.Remove(fsStubPath, false); err != nil {
+		c.Log(logger.LevelWarn, "unable to remove the stub for restored file %#v: %+v", virtualPath, err)
+	}
+	if err := archiveFs.Remove(fsArchivePath, false); err != nil {
+		c.Log(logger.LevelWarn, "unable to remove archived copy %#v: %+v", fsArchivePath, err)
+	}
+	logger.CommandLog(restoreArchiveLogSender, fsPath, fsArchivePath, c.User.Username, "", c.ID, c.protocol, -1, -1,
+		"", "", "", -1)
+	c.updateQuotaForPath(virtualPath, 1, size)
+	dataprovider.UpdateVirtualFolderQuota(&folder, -1, -archived.Size, false) //nolint:errcheck
+	return nil
+}
+
+// restoreArchivedFileOnAccess restores the file at the given paths if it does not
+// exist and it was moved to an archive folder
+func (c *BaseConnection) restoreArchivedFileOnAccess(fs vfs.Fs, fsPath, virtualPath string) error {
+	if !c.User.HasArchiveRules() {
+		return nil
+	}
+	if _, err := fs.Lstat(fsPath); err == nil || !fs.IsNotExist(err) {
+		return nil
+	}
+	archived, fsStubPath, err := c.getArchivedFile(fs, virtualPath)
+	if err != nil {
+		return nil
+	}
+	c.Log(logger.LevelInfo, "restoring archived file %#v on access", virtualPath)
+	if err := c.restoreArchivedFile(fs, fsPath, virtualPath, fsStubPath, &archived); err != nil {
+		return c.GetFsError(fs, err)
+	}
+	return nil
+}
+
+// ListArchivedFiles returns the files moved to an archive folder, sorted by path
+func (c *BaseConnection) ListArchivedFiles() ([]ArchivedFile, error) {
+	files := []ArchivedFile{}
+	if !c.User.HasArchiveRules() {
+		return files, c.GetOpUnsupportedError()
+	}
+	for _, mountPath := range c.User.GetMountPaths() {
+		stubsPath := path.Join(mountPath, dataprovider.ArchivedDirName)
+		fs, fsStubsPath, err := c.GetFsAndResolvedPath(stubsPath)
+		if err != nil {
+			c.Log(logger.LevelWarn, "unable to get archived stubs path for mount %#v: %+v", mountPath, err)
+			continue
+		}
+		err = fs.Walk(fsStubsPath, func(walkedPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			archived, err := readArchivedStub(fs, walkedPath)
+			if err != nil {
+				c.Log(logger.LevelWarn, "unable to read archived stub %#v: %v", walkedPath, err)
+				return nil
+			}
+			// the original path is given by the stub path
+			archived.Path = path.Join(mountPath, strings.TrimPrefix(fs.GetRelativePath(walkedPath), stubsPath))
+			if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(archived.Path)) {
+				return nil
+			}
+			files = append(files, archived)
+			return nil
+		})
+		if err != nil && !fs.IsNotExist(err) {
+			c.Log(logger.LevelWarn, "unable to list archived files for mount %#v: %+v", mountPath, err)
+			return files, c.GetFsError(fs, err)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Path < files[j].Path
+	})
+	return files, nil
+}
+
+// RestoreArchivedFile restores the archived file with the given virtual path.
+// The restore fails if a file with the same name exists
+func (c *BaseConnection) RestoreArchivedFile(virtualPath string) error {
+	if !c.User.HasArchiveRules() {
+		return c.GetOpUnsupportedError()
+	}
+	if !c.User.IsFileAllowed(virtualPath) {
+		c.Log(logger.LevelWarn, "restoring file %#v is not allowed", virtualPath)
+		return c.GetPermissionDeniedError()
+	}
+	if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualPath)) {
+		return c.GetPermissionDeniedError()
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return err
+	}
+	archived, fsStubPath, err := c.getArchivedFile(fs, virtualPath)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	if _, err := fs.Lstat(fsPath); err == nil {
+		c.Log(logger.LevelInfo, "unable to restore archived file %#v: %v", virtualPath, errTrashTargetExists)
+		return c.GetGenericError(errTrashTargetExists)
+	}
+	if err := c.restoreArchivedFile(fs, fsPath, virtualPath, fsStubPath, &archived); err != nil {
+		return c.GetFsError(fs, err)
+	}
+	return nil
+}
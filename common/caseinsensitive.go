@@ -0,0 +1,107 @@
+package common
+
+import (
+	"io"
+	"path"
+	"strings"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// getCaseInsensitivePath returns the virtual path for the existing file or
+// directory matching virtualPath ignoring the case. The path components that
+// don't match any existing file, for example the name of a file to create,
+// are returned unchanged. An error is returned if a path component matches
+// more than one file ignoring the case.
+// The given filesystem must be the one for virtualPath
+func (c *BaseConnection) getCaseInsensitivePath(fs vfs.Fs, virtualPath string) (string, error) {
+	mountPath := c.User.GetMountPath(virtualPath)
+	virtualPath = replaceMountPath(virtualPath, mountPath)
+	return c.resolveCaseInsensitivePath(fs, virtualPath, mountPath)
+}
+
+func (c *BaseConnection) resolveCaseInsensitivePath(fs vfs.Fs, virtualPath, mountPath string) (string, error) {
+	if virtualPath == mountPath || virtualPath == "/" {
+		return virtualPath, nil
+	}
+	fsPath, err := fs.ResolvePath(virtualPath)
+	if err != nil {
+		return virtualPath, err
+	}
+	if _, err := fs.Lstat(fsPath); err == nil || !fs.IsNotExist(err) {
+		return virtualPath, nil
+	}
+	parent, err := c.resolveCaseInsensitivePath(fs, path.Dir(virtualPath), mountPath)
+	if err != nil {
+		return virtualPath, err
+	}
+	name, err := c.findCaseInsensitiveName(fs, parent, path.Base(virtualPath))
+	if err != nil {
+		return virtualPath, err
+	}
+	return path.Join(parent, name), nil
+}
+
+// findCaseInsensitiveName returns the name of the entry inside the given
+// virtual directory matching name ignoring the case or name itself if there
+// is no match. The directory is read in batches, so huge directories are
+// searched in bounded memory
+func (c *BaseConnection) findCaseInsensitiveName(fs vfs.Fs, virtualDir, name string) (string, error) {
+	fsDir, err := fs.ResolvePath(virtualDir)
+	if err != nil {
+		return name, err
+	}
+	lister, err := fs.OpenDir(fsDir)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return name, nil
+		}
+		return name, err
+	}
+	defer lister.Close()
+
+	var matches []string
+	for {
+		entries, err := lister.Next(vfs.ListerBatchSize)
+		for _, info := range entries {
+			if info.Name() == name {
+				return name, nil
+			}
+			if strings.EqualFold(info.Name(), name) {
+				matches = append(matches, info.Name())
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return name, err
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return name, nil
+	case 1:
+		return matches[0], nil
+	default:
+		c.Log(logger.LevelWarn, "case insensitive collision for %#v inside %#v, matching files: %+v",
+			name, virtualDir, matches)
+		return name, errCaseCollision
+	}
+}
+
+// replaceMountPath replaces the leading directories of virtualPath that match
+// the given mount path ignoring the case with the mount path itself
+func replaceMountPath(virtualPath, mountPath string) string {
+	if mountPath == "/" {
+		return virtualPath
+	}
+	for _, dir := range utils.GetDirsForVirtualPath(virtualPath) {
+		if strings.EqualFold(dir, mountPath) {
+			return mountPath + strings.TrimPrefix(virtualPath, dir)
+		}
+	}
+	return virtualPath
+}
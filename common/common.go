@@ -19,34 +19,50 @@ import (
 	"github.com/pires/go-proxyproto"
 
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/httpclient"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
 	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
 )
 
 // constants
 const (
-	logSender                = "common"
-	uploadLogSender          = "Upload"
-	downloadLogSender        = "Download"
-	renameLogSender          = "Rename"
-	rmdirLogSender           = "Rmdir"
-	mkdirLogSender           = "Mkdir"
-	symlinkLogSender         = "Symlink"
-	removeLogSender          = "Remove"
-	chownLogSender           = "Chown"
-	chmodLogSender           = "Chmod"
-	chtimesLogSender         = "Chtimes"
-	truncateLogSender        = "Truncate"
-	operationDownload        = "download"
-	operationUpload          = "upload"
-	operationDelete          = "delete"
-	operationPreDelete       = "pre-delete"
-	operationRename          = "rename"
-	operationSSHCmd          = "ssh_cmd"
-	chtimesFormat            = "2006-01-02T15:04:05" // YYYY-MM-DDTHH:MM:SS
-	idleTimeoutCheckInterval = 3 * time.Minute
+	logSender                 = "common"
+	uploadLogSender           = "Upload"
+	downloadLogSender         = "Download"
+	renameLogSender           = "Rename"
+	rmdirLogSender            = "Rmdir"
+	mkdirLogSender            = "Mkdir"
+	symlinkLogSender          = "Symlink"
+	removeLogSender           = "Remove"
+	chownLogSender            = "Chown"
+	chmodLogSender            = "Chmod"
+	chtimesLogSender          = "Chtimes"
+	truncateLogSender         = "Truncate"
+	setXattrLogSender         = "SetXattr"
+	restoreVersionLogSender   = "RestoreVersion"
+	restoreTrashLogSender     = "RestoreTrash"
+	archiveLogSender          = "Archive"
+	restoreArchiveLogSender   = "RestoreArchive"
+	operationDownload         = "download"
+	operationUpload           = "upload"
+	operationDelete           = "delete"
+	operationPreDelete        = "pre-delete"
+	operationPreUpload        = "pre-upload"
+	operationPreDownload      = "pre-download"
+	operationRename           = "rename"
+	operationSSHCmd           = "ssh_cmd"
+	operationRetentionCheck   = "retention_check"
+	operationMkdir            = "mkdir"
+	operationRmdir            = "rmdir"
+	operationSymlink          = "symlink"
+	operationSetStat          = "setstat"
+	operationTransferProgress = "transfer_progress"
+	operationTransferAborted  = "transfer_aborted"
+	chtimesFormat             = "2006-01-02T15:04:05" // YYYY-MM-DDTHH:MM:SS
+	idleTimeoutCheckInterval  = 3 * time.Minute
 )
 
 // Stat flags
@@ -55,6 +71,7 @@ const (
 	StatAttrPerms  = 2
 	StatAttrTimes  = 4
 	StatAttrSize   = 8
+	StatAttrXattrs = 16
 )
 
 // Transfer types
@@ -71,6 +88,7 @@ const (
 	ProtocolFTP    = "FTP"
 	ProtocolWebDAV = "DAV"
 	ProtocolHTTP   = "HTTP"
+	ProtocolRsync  = "RSYNC"
 )
 
 // Upload modes
@@ -93,6 +111,9 @@ var (
 	ErrCrtRevoked           = errors.New("your certificate has been revoked")
 	errNoTransfer           = errors.New("requested transfer not found")
 	errTransferMismatch     = errors.New("transfer mismatch")
+	ErrRateLimitExceeded    = errors.New("rate limit exceeded, please retry later")
+	ErrTooManyTransfers     = errors.New("too many concurrent transfers, please retry later")
+	errCaseCollision        = errors.New("multiple files match the path ignoring the case")
 )
 
 var (
@@ -104,9 +125,13 @@ var (
 	QuotaScans            ActiveScans
 	idleTimeoutTicker     *time.Ticker
 	idleTimeoutTickerDone chan bool
-	supportedProtocols    = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP, ProtocolWebDAV, ProtocolHTTP}
+	defenderListsTicker   *time.Ticker
+	defenderListsDone     chan bool
+	supportedProtocols    = []string{ProtocolSFTP, ProtocolSCP, ProtocolSSH, ProtocolFTP, ProtocolWebDAV, ProtocolHTTP, ProtocolRsync}
 	// the map key is the protocol, for each protocol we can have multiple rate limiters
 	rateLimiters map[string][]*rateLimiter
+	// rate limiters for the operations other than connect, the map key is "protocol_operation"
+	operationRateLimiters map[string][]*rateLimiter
 )
 
 // Initialize sets the common configuration
@@ -118,15 +143,30 @@ func Initialize(c Configuration) error {
 		startIdleTimeoutTicker(idleTimeoutCheckInterval)
 	}
 	Config.defender = nil
+	stopDefenderListsTicker()
 	if c.DefenderConfig.Enabled {
-		defender, err := newInMemoryDefender(&c.DefenderConfig)
+		var defender Defender
+		var err error
+		switch c.DefenderConfig.Driver {
+		case DefenderDriverProvider:
+			defender, err = newDBDefender(&c.DefenderConfig)
+		default:
+			defender, err = newInMemoryDefender(&c.DefenderConfig)
+		}
 		if err != nil {
 			return fmt.Errorf("defender initialization error: %v", err)
 		}
 		logger.Info(logSender, "", "defender initialized with config %+v", c.DefenderConfig)
 		Config.defender = defender
+		if c.DefenderConfig.ListsCheckInterval > 0 {
+			startDefenderListsTicker(time.Duration(c.DefenderConfig.ListsCheckInterval) * time.Second)
+		}
+	}
+	if err := Config.Actions.validate(); err != nil {
+		return fmt.Errorf("actions initialization error: %v", err)
 	}
 	rateLimiters = make(map[string][]*rateLimiter)
+	operationRateLimiters = make(map[string][]*rateLimiter)
 	for _, rlCfg := range c.RateLimitersConfig {
 		if rlCfg.isEnabled() {
 			if err := rlCfg.validate(); err != nil {
@@ -134,10 +174,91 @@ func Initialize(c Configuration) error {
 			}
 			rateLimiter := rlCfg.getLimiter()
 			for _, protocol := range rlCfg.Protocols {
-				rateLimiters[protocol] = append(rateLimiters[protocol], rateLimiter)
+				for _, operation := range rlCfg.Operations {
+					if operation == RateLimitOperationConnect {
+						rateLimiters[protocol] = append(rateLimiters[protocol], rateLimiter)
+						continue
+					}
+					key := getOperationRateLimitersKey(protocol, operation)
+					operationRateLimiters[key] = append(operationRateLimiters[key], rateLimiter)
+				}
 			}
 		}
 	}
+	if err := c.DiskCache.Initialize(); err != nil {
+		return fmt.Errorf("disk cache initialization error: %v", err)
+	}
+	if err := c.QuotaScan.Initialize(); err != nil {
+		return fmt.Errorf("quota scan initialization error: %v", err)
+	}
+	if err := c.GeoIP.Initialize(); err != nil {
+		return fmt.Errorf("geoip initialization error: %v", err)
+	}
+	if c.GeoIP.IsEnabled() {
+		logger.Info(logSender, "", "geoip databases loaded: %v", geoip.GetDatabaseTypes())
+	}
+	if c.DataRetention.CheckInterval > 0 {
+		startRetentionTicker(time.Duration(c.DataRetention.CheckInterval) * time.Hour)
+	} else {
+		stopRetentionTicker()
+	}
+	stopEventsQueue()
+	if c.EventsQueue.Enabled {
+		if err := c.EventsQueue.validate(); err != nil {
+			return fmt.Errorf("events queue initialization error: %v", err)
+		}
+		startEventsQueue(&c.EventsQueue)
+	}
+	if err := startScheduler(&c.Scheduler); err != nil {
+		return fmt.Errorf("scheduler initialization error: %v", err)
+	}
+	stopSharedConnections()
+	if c.SharedConnections.Enabled {
+		if err := c.SharedConnections.validate(); err != nil {
+			return fmt.Errorf("shared connections initialization error: %v", err)
+		}
+		if err := startSharedConnections(&c.SharedConnections); err != nil {
+			return fmt.Errorf("shared connections initialization error: %v", err)
+		}
+	}
+	stopLoadShedding()
+	if c.LoadShedding.isEnabled() {
+		if err := c.LoadShedding.validate(); err != nil {
+			return fmt.Errorf("load shedding initialization error: %v", err)
+		}
+		startLoadShedding(c.LoadShedding)
+	}
+	return nil
+}
+
+// ReloadConfig applies the settings that can be changed without restarting
+// the services: idle timeout, actions, hooks, max total connections and the
+// geoip databases, the database files are read again.
+// The other settings in the given configuration are ignored
+func ReloadConfig(c Configuration) error {
+	if err := c.Actions.validate(); err != nil {
+		return fmt.Errorf("actions reload error: %v", err)
+	}
+	if err := c.GeoIP.Initialize(); err != nil {
+		return fmt.Errorf("geoip reload error: %v", err)
+	}
+	Config.GeoIP = c.GeoIP
+	Config.Actions = c.Actions
+	Config.StartupHook = c.StartupHook
+	Config.PreConnectHook = c.PreConnectHook
+	Config.PostConnectHook = c.PostConnectHook
+	Config.MaxTotalConnections = c.MaxTotalConnections
+	if Config.IdleTimeout != c.IdleTimeout {
+		Config.IdleTimeout = c.IdleTimeout
+		Config.idleTimeoutAsDuration = time.Duration(c.IdleTimeout) * time.Minute
+		if c.IdleTimeout > 0 {
+			startIdleTimeoutTicker(idleTimeoutCheckInterval)
+		} else {
+			stopIdleTimeoutTicker()
+		}
+	}
+	logger.Info(logSender, "", "configuration reloaded, idle timeout: %v, max total connections: %v",
+		Config.IdleTimeout, Config.MaxTotalConnections)
 	return nil
 }
 
@@ -155,6 +276,59 @@ func LimitRate(protocol, ip string) (time.Duration, error) {
 	return 0, nil
 }
 
+// LimitOperationRate blocks until all the rate limiters configured for the
+// given protocol and operation allow one event to happen.
+// The source is the client IP for the "auth" operation and the connection ID
+// for the "list" and "open" operations.
+// It returns an error if the time to wait exceeds the max allowed delay
+func LimitOperationRate(protocol, operation, source string) (time.Duration, error) {
+	if operation == RateLimitOperationConnect {
+		return LimitRate(protocol, source)
+	}
+	for _, limiter := range operationRateLimiters[getOperationRateLimitersKey(protocol, operation)] {
+		if delay, err := limiter.Wait(source); err != nil {
+			logger.Debug(logSender, "", "protocol %v operation %v source %v: %v", protocol, operation, source, err)
+			return delay, err
+		}
+	}
+	return 0, nil
+}
+
+// LimitAuthRate applies the rate limiters configured for the authentication
+// attempts from the given IP address
+func LimitAuthRate(protocol, ip string) error {
+	if _, err := LimitOperationRate(protocol, RateLimitOperationAuth, ip); err != nil {
+		return ErrRateLimitExceeded
+	}
+	return nil
+}
+
+func getOperationRateLimitersKey(protocol, operation string) string {
+	return fmt.Sprintf("%v_%v", protocol, operation)
+}
+
+// getRateLimiterProtocol maps a connection protocol to the matching rate limiter protocol
+func getRateLimiterProtocol(protocol string) string {
+	switch protocol {
+	case ProtocolSFTP, ProtocolSCP:
+		return ProtocolSSH
+	default:
+		return protocol
+	}
+}
+
+// removeConnectionRateLimiters removes the buckets of the per-connection
+// rate limiters for the given connection ID
+func removeConnectionRateLimiters(connectionID string) {
+	for _, limiters := range operationRateLimiters {
+		for _, limiter := range limiters {
+			if limiter.limiterType == rateLimiterTypeConnection {
+				limiter.removeSource(connectionID)
+			}
+		}
+	}
+}
+
 // ReloadDefender reloads the defender's block and safe lists
 func ReloadDefender() error {
 	if Config.defender == nil {
@@ -164,6 +338,14 @@ func ReloadDefender() error {
 	return Config.defender.Reload()
 }
 
+func reloadDefenderIfChanged() error {
+	if Config.defender == nil {
+		return nil
+	}
+
+	return Config.defender.ReloadIfChanged()
+}
+
 // IsBanned returns true if the specified IP address is banned
 func IsBanned(ip string) bool {
 	if Config.defender == nil {
@@ -235,6 +417,32 @@ func stopIdleTimeoutTicker() {
 	}
 }
 
+func startDefenderListsTicker(duration time.Duration) {
+	stopDefenderListsTicker()
+	defenderListsTicker = time.NewTicker(duration)
+	defenderListsDone = make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-defenderListsDone:
+				return
+			case <-defenderListsTicker.C:
+				if err := reloadDefenderIfChanged(); err != nil {
+					logger.Warn(logSender, "", "unable to reload defender's lists: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+func stopDefenderListsTicker() {
+	if defenderListsTicker != nil {
+		defenderListsTicker.Stop()
+		defenderListsDone <- true
+		defenderListsTicker = nil
+	}
+}
+
 // ActiveTransfer defines the interface for the current active transfers
 type ActiveTransfer interface {
 	GetID() uint64
@@ -273,6 +481,8 @@ type StatAttributes struct {
 	GID   int
 	Flags int
 	Size  int64
+	// extended attributes and POSIX ACLs to set, a nil value removes the attribute
+	Xattrs map[string][]byte
 }
 
 // ConnectionTransfer defines the trasfer details to expose
@@ -324,6 +534,10 @@ type Configuration struct {
 	// 2 means "ignore mode for cloud fs": requests for changing permissions and owner/group/time are
 	// silently ignored for cloud based filesystem such as S3, GCS, Azure Blob
 	SetstatMode int `json:"setstat_mode" mapstructure:"setstat_mode"`
+	// UploadChecksums enables the SHA-256 computation for the uploaded files.
+	// The checksum is stored alongside the file and it is verified on downloads.
+	// Supported for local filesystem and for S3, GCS and Azure Blob cloud storage
+	UploadChecksums bool `json:"upload_checksums" mapstructure:"upload_checksums"`
 	// Support for HAProxy PROXY protocol.
 	// If you are running SFTPGo behind a proxy server such as HAProxy, AWS ELB or NGNIX, you can enable
 	// the proxy protocol. It provides a convenient way to safely transport connection information
@@ -346,6 +560,11 @@ type Configuration struct {
 	// Please note that SFTPGo services may not yet be available when this hook is run.
 	// Leave empty do disable.
 	StartupHook string `json:"startup_hook" mapstructure:"startup_hook"`
+	// Absolute path to an external program or an HTTP URL to invoke as soon as a new connection
+	// is accepted, before the SSH/TLS handshake and before any other check. It allows you to
+	// reject the connection based on the source ip address, for example querying an external
+	// threat intelligence system. Leave empty do disable.
+	PreConnectHook string `json:"pre_connect_hook" mapstructure:"pre_connect_hook"`
 	// Absolute path to an external program or an HTTP URL to invoke after a user connects
 	// and before he tries to login. It allows you to reject the connection based on the source
 	// ip address. Leave empty do disable.
@@ -355,7 +574,24 @@ type Configuration struct {
 	// Defender configuration
 	DefenderConfig DefenderConfig `json:"defender" mapstructure:"defender"`
 	// Rate limiter configurations
-	RateLimitersConfig    []RateLimiterConfig `json:"rate_limiters" mapstructure:"rate_limiters"`
+	RateLimitersConfig []RateLimiterConfig `json:"rate_limiters" mapstructure:"rate_limiters"`
+	// Local disk cache for cloud storage backends
+	DiskCache vfs.DiskCacheConfig `json:"disk_cache" mapstructure:"disk_cache"`
+	// Scheduled checks for the users retention rules
+	DataRetention DataRetentionConfig `json:"data_retention" mapstructure:"data_retention"`
+	// Persistent queue for the action notifications
+	EventsQueue EventsQueueConfig `json:"events_queue" mapstructure:"events_queue"`
+	// Jobs executed by the internal scheduler
+	Scheduler SchedulerConfig `json:"scheduler" mapstructure:"scheduler"`
+	// Connections registry shared among multiple instances
+	SharedConnections SharedConnectionsConfig `json:"shared_connections" mapstructure:"shared_connections"`
+	// Resource thresholds above which new connections are rejected
+	LoadShedding LoadSheddingConfig `json:"load_shedding" mapstructure:"load_shedding"`
+	// Rate and concurrency limits for the quota scans
+	QuotaScan vfs.QuotaScanConfig `json:"quota_scan" mapstructure:"quota_scan"`
+	// Local MaxMind DB files used to resolve the country and the autonomous
+	// system for the client IP addresses
+	GeoIP                 geoip.Config `json:"geoip" mapstructure:"geoip"`
 	idleTimeoutAsDuration time.Duration
 	idleLoginTimeout      time.Duration
 	defender              Defender
@@ -369,23 +605,37 @@ func (c *Configuration) IsAtomicUploadEnabled() bool {
 // GetProxyListener returns a wrapper for the given listener that supports the
 // HAProxy Proxy Protocol or nil if the proxy protocol is not configured
 func (c *Configuration) GetProxyListener(listener net.Listener) (*proxyproto.Listener, error) {
+	return c.GetProxyListenerWithOverrides(listener, 0, nil)
+}
+
+// GetProxyListenerWithOverrides returns a wrapper for the given listener that supports the
+// HAProxy Proxy Protocol using the given proxy protocol mode and allowed IPs.
+// A proxy protocol mode <= 0 and empty allowed IPs mean the configured values
+func (c *Configuration) GetProxyListenerWithOverrides(listener net.Listener, proxyProtocol int,
+	proxyAllowed []string) (*proxyproto.Listener, error) {
+	if proxyProtocol <= 0 {
+		proxyProtocol = c.ProxyProtocol
+	}
+	if len(proxyAllowed) == 0 {
+		proxyAllowed = c.ProxyAllowed
+	}
 	var proxyListener *proxyproto.Listener
 	var err error
-	if c.ProxyProtocol > 0 {
+	if proxyProtocol > 0 {
 		var policyFunc func(upstream net.Addr) (proxyproto.Policy, error)
-		if c.ProxyProtocol == 1 && len(c.ProxyAllowed) > 0 {
-			policyFunc, err = proxyproto.LaxWhiteListPolicy(c.ProxyAllowed)
+		if proxyProtocol == 1 && len(proxyAllowed) > 0 {
+			policyFunc, err = proxyproto.LaxWhiteListPolicy(proxyAllowed)
 			if err != nil {
 				return nil, err
 			}
 		}
-		if c.ProxyProtocol == 2 {
-			if len(c.ProxyAllowed) == 0 {
+		if proxyProtocol == 2 {
+			if len(proxyAllowed) == 0 {
 				policyFunc = func(upstream net.Addr) (proxyproto.Policy, error) {
 					return proxyproto.REQUIRE, nil
 				}
 			} else {
-				policyFunc, err = proxyproto.StrictWhiteListPolicy(c.ProxyAllowed)
+				policyFunc, err = proxyproto.StrictWhiteListPolicy(proxyAllowed)
 				if err != nil {
 					return nil, err
 				}
@@ -412,7 +662,7 @@ func (c *Configuration) ExecuteStartupHook() error {
 			return err
 		}
 		startTime := time.Now()
-		httpClient := httpclient.GetRetraybleHTTPClient()
+		httpClient := httpclient.GetRetryableHTTPClient()
 		resp, err := httpClient.Get(url.String())
 		if err != nil {
 			logger.Warn(logSender, "", "Error executing startup hook: %v", err)
@@ -436,20 +686,31 @@ func (c *Configuration) ExecuteStartupHook() error {
 	return nil
 }
 
+// ExecutePreConnectHook executes the pre connect hook if defined.
+// It must be called as soon as a new connection is accepted and before
+// the protocol handshake starts
+func (c *Configuration) ExecutePreConnectHook(ipAddr, protocol string) error {
+	return executeConnectHook(c.PreConnectHook, "pre connect", ipAddr, protocol)
+}
+
 // ExecutePostConnectHook executes the post connect hook if defined
 func (c *Configuration) ExecutePostConnectHook(ipAddr, protocol string) error {
-	if c.PostConnectHook == "" {
+	return executeConnectHook(c.PostConnectHook, "post connect", ipAddr, protocol)
+}
+
+func executeConnectHook(hook, hookName, ipAddr, protocol string) error {
+	if hook == "" {
 		return nil
 	}
-	if strings.HasPrefix(c.PostConnectHook, "http") {
+	if strings.HasPrefix(hook, "http") {
 		var url *url.URL
-		url, err := url.Parse(c.PostConnectHook)
+		url, err := url.Parse(hook)
 		if err != nil {
-			logger.Warn(protocol, "", "Login from ip %#v denied, invalid post connect hook %#v: %v",
-				ipAddr, c.PostConnectHook, err)
+			logger.Warn(protocol, "", "Login from ip %#v denied, invalid %v hook %#v: %v",
+				ipAddr, hookName, hook, err)
 			return err
 		}
-		httpClient := httpclient.GetRetraybleHTTPClient()
+		httpClient := httpclient.GetRetryableHTTPClientForHook(httpclient.HookConnect)
 		q := url.Query()
 		q.Add("ip", ipAddr)
 		q.Add("protocol", protocol)
@@ -457,30 +718,31 @@ func (c *Configuration) ExecutePostConnectHook(ipAddr, protocol string) error {
 
 		resp, err := httpClient.Get(url.String())
 		if err != nil {
-			logger.Warn(protocol, "", "Login from ip %#v denied, error executing post connect hook: %v", ipAddr, err)
+			logger.Warn(protocol, "", "Login from ip %#v denied, error executing %v hook: %v", ipAddr, hookName, err)
 			return err
 		}
 		defer resp.Body.Close()
 		if resp.StatusCode != http.StatusOK {
-			logger.Warn(protocol, "", "Login from ip %#v denied, post connect hook response code: %v", ipAddr, resp.StatusCode)
+			logger.Warn(protocol, "", "Login from ip %#v denied, %v hook response code: %v", ipAddr, hookName,
+				resp.StatusCode)
 			return errUnexpectedHTTResponse
 		}
 		return nil
 	}
-	if !filepath.IsAbs(c.PostConnectHook) {
-		err := fmt.Errorf("invalid post connect hook %#v", c.PostConnectHook)
+	if !filepath.IsAbs(hook) {
+		err := fmt.Errorf("invalid %v hook %#v", hookName, hook)
 		logger.Warn(protocol, "", "Login from ip %#v denied: %v", ipAddr, err)
 		return err
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, c.PostConnectHook)
+	cmd := exec.CommandContext(ctx, hook)
 	cmd.Env = append(os.Environ(),
 		fmt.Sprintf("SFTPGO_CONNECTION_IP=%v", ipAddr),
 		fmt.Sprintf("SFTPGO_CONNECTION_PROTOCOL=%v", protocol))
 	err := cmd.Run()
 	if err != nil {
-		logger.Warn(protocol, "", "Login from ip %#v denied, connect hook error: %v", ipAddr, err)
+		logger.Warn(protocol, "", "Login from ip %#v denied, %v hook error: %v", ipAddr, hookName, err)
 	}
 	return err
 }
@@ -533,8 +795,17 @@ type ActiveConnections struct {
 }
 
 // GetActiveSessions returns the number of active sessions for the given username.
-// We return the open sessions for any protocol
+// We return the open sessions for any protocol. If the shared connections registry
+// is enabled the sessions handled by the other nodes are included
 func (conns *ActiveConnections) GetActiveSessions(username string) int {
+	numSessions := conns.getLocalSessions(username)
+	if m := sharedConns; m != nil {
+		numSessions += m.getRemoteSessions(username)
+	}
+	return numSessions
+}
+
+func (conns *ActiveConnections) getLocalSessions(username string) int {
 	conns.RLock()
 	defer conns.RUnlock()
 
@@ -547,14 +818,32 @@ func (conns *ActiveConnections) GetActiveSessions(username string) int {
 	return numSessions
 }
 
+// GetActiveTransfers returns the number of active transfers for the given
+// username on this node
+func (conns *ActiveConnections) GetActiveTransfers(username string) int {
+	conns.RLock()
+	defer conns.RUnlock()
+
+	numTransfers := 0
+	for _, c := range conns.connections {
+		if c.GetUsername() == username {
+			numTransfers += len(c.GetTransfers())
+		}
+	}
+	return numTransfers
+}
+
 // Add adds a new connection to the active ones
 func (conns *ActiveConnections) Add(c ActiveConnection) {
 	conns.Lock()
-	defer conns.Unlock()
-
 	conns.connections = append(conns.connections, c)
 	metrics.UpdateActiveConnectionsSize(len(conns.connections))
 	logger.Debug(c.GetProtocol(), c.GetID(), "connection added, num open connections: %v", len(conns.connections))
+	conns.Unlock()
+
+	if m := sharedConns; m != nil {
+		m.add(getConnectionStatus(c))
+	}
 }
 
 // Swap replaces an existing connection with the given one.
@@ -562,6 +851,16 @@ func (conns *ActiveConnections) Add(c ActiveConnection) {
 // for example for FTP is used to update the connection once the user
 // authenticates
 func (conns *ActiveConnections) Swap(c ActiveConnection) error {
+	if err := conns.swapLocal(c); err != nil {
+		return err
+	}
+	if m := sharedConns; m != nil {
+		m.add(getConnectionStatus(c))
+	}
+	return nil
+}
+
+func (conns *ActiveConnections) swapLocal(c ActiveConnection) error {
 	conns.Lock()
 	defer conns.Unlock()
 
@@ -577,6 +876,15 @@ func (conns *ActiveConnections) Swap(c ActiveConnection) error {
 
 // Remove removes a connection from the active ones
 func (conns *ActiveConnections) Remove(connectionID string) {
+	if !conns.removeLocal(connectionID) {
+		return
+	}
+	if m := sharedConns; m != nil {
+		m.remove(connectionID)
+	}
+}
+
+func (conns *ActiveConnections) removeLocal(connectionID string) bool {
 	conns.Lock()
 	defer conns.Unlock()
 
@@ -588,17 +896,43 @@ func (conns *ActiveConnections) Remove(connectionID string) {
 			conns.connections[lastIdx] = nil
 			conns.connections = conns.connections[:lastIdx]
 			metrics.UpdateActiveConnectionsSize(lastIdx)
+			removeConnectionRateLimiters(connectionID)
 			logger.Debug(conn.GetProtocol(), conn.GetID(), "connection removed, close fs error: %v, num open connections: %v",
 				err, lastIdx)
-			return
+			return true
 		}
 	}
 	logger.Warn(logSender, "", "connection id %#v to remove not found!", connectionID)
+	return false
 }
 
 // Close closes an active connection.
+// If the shared connections registry is enabled the connections handled by
+// the other nodes can be closed too, they are closed asynchronously.
 // It returns true on success
 func (conns *ActiveConnections) Close(connectionID string) bool {
+	if conns.closeLocal(connectionID) {
+		return true
+	}
+	if m := sharedConns; m != nil {
+		return m.requestClose(connectionID)
+	}
+	return false
+}
+
+func (conns *ActiveConnections) isLocal(connectionID string) bool {
+	conns.RLock()
+	defer conns.RUnlock()
+
+	for _, c := range conns.connections {
+		if c.GetID() == connectionID {
+			return true
+		}
+	}
+	return false
+}
+
+func (conns *ActiveConnections) closeLocal(connectionID string) bool {
 	conns.RLock()
 	result := false
 
@@ -723,29 +1057,72 @@ func (conns *ActiveConnections) IsNewConnectionAllowed() bool {
 	return len(conns.connections) < Config.MaxTotalConnections
 }
 
-// GetStats returns stats for active connections
+// GetStats returns stats for active connections.
+// If the shared connections registry is enabled the connections
+// handled by the other nodes are included
 func (conns *ActiveConnections) GetStats() []*ConnectionStatus {
+	stats := conns.getLocalStats()
+	if m := sharedConns; m != nil {
+		for _, stat := range stats {
+			stat.Node = m.nodeID
+		}
+		stats = append(stats, m.getRemoteStats()...)
+	}
+	return stats
+}
+
+// GetLocalCount returns the number of active connections handled by this node
+func (conns *ActiveConnections) GetLocalCount() int {
+	conns.RLock()
+	defer conns.RUnlock()
+
+	return len(conns.connections)
+}
+
+// CloseLocal closes all the active connections handled by this node.
+// It returns the number of closed connections
+func (conns *ActiveConnections) CloseLocal() int {
+	conns.RLock()
+	connections := make([]ActiveConnection, len(conns.connections))
+	copy(connections, conns.connections)
+	conns.RUnlock()
+
+	for _, c := range connections {
+		err := c.Disconnect()
+		logger.Debug(c.GetProtocol(), c.GetID(), "close connection requested, close err: %v", err)
+	}
+	return len(connections)
+}
+
+func (conns *ActiveConnections) getLocalStats() []*ConnectionStatus {
 	conns.RLock()
 	defer conns.RUnlock()
 
 	stats := make([]*ConnectionStatus, 0, len(conns.connections))
 	for _, c := range conns.connections {
-		stat := &ConnectionStatus{
-			Username:       c.GetUsername(),
-			ConnectionID:   c.GetID(),
-			ClientVersion:  c.GetClientVersion(),
-			RemoteAddress:  c.GetRemoteAddress(),
-			ConnectionTime: utils.GetTimeAsMsSinceEpoch(c.GetConnectionTime()),
-			LastActivity:   utils.GetTimeAsMsSinceEpoch(c.GetLastActivity()),
-			Protocol:       c.GetProtocol(),
-			Command:        c.GetCommand(),
-			Transfers:      c.GetTransfers(),
-		}
-		stats = append(stats, stat)
+		stats = append(stats, getConnectionStatus(c))
 	}
 	return stats
 }
 
+func getConnectionStatus(c ActiveConnection) *ConnectionStatus {
+	geoInfo := geoip.Lookup(utils.GetIPFromRemoteAddress(c.GetRemoteAddress()))
+	return &ConnectionStatus{
+		Username:       c.GetUsername(),
+		ConnectionID:   c.GetID(),
+		ClientVersion:  c.GetClientVersion(),
+		RemoteAddress:  c.GetRemoteAddress(),
+		ConnectionTime: utils.GetTimeAsMsSinceEpoch(c.GetConnectionTime()),
+		LastActivity:   utils.GetTimeAsMsSinceEpoch(c.GetLastActivity()),
+		Protocol:       c.GetProtocol(),
+		Command:        c.GetCommand(),
+		Transfers:      c.GetTransfers(),
+		Country:        geoInfo.Country,
+		ASN:            geoInfo.ASN,
+		ASOrganization: geoInfo.ASOrganization,
+	}
+}
+
 // ConnectionStatus returns the status for an active connection
 type ConnectionStatus struct {
 	// Logged in username
@@ -766,6 +1143,12 @@ type ConnectionStatus struct {
 	Transfers []ConnectionTransfer `json:"active_transfers,omitempty"`
 	// SSH command or WebDAV method
 	Command string `json:"command,omitempty"`
+	// identifier for the node handling the connection, set if the shared connections registry is enabled
+	Node string `json:"node,omitempty"`
+	// client country and autonomous system, set if the geoip databases are configured
+	Country        string `json:"country,omitempty"`
+	ASN            uint   `json:"asn,omitempty"`
+	ASOrganization string `json:"as_organization,omitempty"`
 }
 
 // GetConnectionDuration returns the connection duration as string
@@ -776,17 +1159,22 @@ func (c *ConnectionStatus) GetConnectionDuration() string {
 
 // GetConnectionInfo returns connection info.
 // Protocol,Client Version and RemoteAddress are returned.
+// The client country and autonomous system are included if known
 func (c *ConnectionStatus) GetConnectionInfo() string {
 	var result strings.Builder
 
 	result.WriteString(fmt.Sprintf("%v. Client: %#v From: %#v", c.Protocol, c.ClientVersion, c.RemoteAddress))
+	geoInfo := geoip.Info{Country: c.Country, ASN: c.ASN, ASOrganization: c.ASOrganization}
+	if !geoInfo.IsEmpty() {
+		result.WriteString(fmt.Sprintf(" (%v)", geoInfo.String()))
+	}
 
 	if c.Command == "" {
 		return result.String()
 	}
 
 	switch c.Protocol {
-	case ProtocolSSH, ProtocolFTP:
+	case ProtocolSSH, ProtocolFTP, ProtocolRsync:
 		result.WriteString(fmt.Sprintf(". Command: %#v", c.Command))
 	case ProtocolWebDAV:
 		result.WriteString(fmt.Sprintf(". Method: %#v", c.Command))
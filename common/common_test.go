@@ -226,6 +226,60 @@ func TestRateLimitersIntegration(t *testing.T) {
 	Config = configCopy
 }
 
+func TestOperationRateLimiters(t *testing.T) {
+	configCopy := Config
+
+	Config.RateLimitersConfig = []RateLimiterConfig{
+		{
+			Average:          1,
+			Period:           1000,
+			Burst:            1,
+			Type:             int(rateLimiterTypeSource),
+			Protocols:        []string{ProtocolSSH},
+			Operations:       []string{RateLimitOperationAuth},
+			EntriesSoftLimit: 100,
+			EntriesHardLimit: 150,
+		},
+		{
+			Average:          1,
+			Period:           1000,
+			Burst:            1,
+			Type:             int(rateLimiterTypeConnection),
+			Protocols:        []string{ProtocolSSH, ProtocolFTP},
+			Operations:       []string{RateLimitOperationList, RateLimitOperationOpen},
+			EntriesSoftLimit: 100,
+			EntriesHardLimit: 150,
+		},
+	}
+	err := Initialize(Config)
+	assert.NoError(t, err)
+	assert.Len(t, rateLimiters, 0)
+	assert.Len(t, operationRateLimiters, 5)
+
+	ip := "127.1.1.3"
+	assert.NoError(t, LimitAuthRate(ProtocolSSH, ip))
+	assert.ErrorIs(t, LimitAuthRate(ProtocolSSH, ip), ErrRateLimitExceeded)
+	assert.NoError(t, LimitAuthRate(ProtocolFTP, ip))
+
+	conn1 := NewBaseConnection("id1", ProtocolSFTP, dataprovider.User{})
+	conn2 := NewBaseConnection("id2", ProtocolFTP, dataprovider.User{})
+	assert.NoError(t, conn1.LimitOperationRate(RateLimitOperationOpen))
+	assert.Error(t, conn1.LimitOperationRate(RateLimitOperationOpen))
+	// each connection has its own bucket, shared among the list and open operations
+	assert.NoError(t, conn2.LimitOperationRate(RateLimitOperationOpen))
+	assert.ErrorIs(t, conn2.LimitOperationRate(RateLimitOperationList), ErrRateLimitExceeded)
+	conn3 := NewBaseConnection("id3", ProtocolWebDAV, dataprovider.User{})
+	assert.NoError(t, conn3.LimitOperationRate(RateLimitOperationList))
+	assert.NoError(t, conn3.LimitOperationRate(RateLimitOperationList))
+
+	removeConnectionRateLimiters(conn1.GetID())
+	assert.NoError(t, conn1.LimitOperationRate(RateLimitOperationList))
+
+	Config = configCopy
+	rateLimiters = make(map[string][]*rateLimiter)
+	operationRateLimiters = make(map[string][]*rateLimiter)
+}
+
 func TestMaxConnections(t *testing.T) {
 	oldValue := Config.MaxTotalConnections
 	Config.MaxTotalConnections = 1
@@ -237,11 +291,18 @@ func TestMaxConnections(t *testing.T) {
 	}
 	Connections.Add(fakeConn)
 	assert.Len(t, Connections.GetStats(), 1)
+	assert.Equal(t, 1, Connections.GetLocalCount())
 	assert.False(t, Connections.IsNewConnectionAllowed())
 
 	res := Connections.Close(fakeConn.GetID())
 	assert.True(t, res)
 	assert.Eventually(t, func() bool { return len(Connections.GetStats()) == 0 }, 300*time.Millisecond, 50*time.Millisecond)
+	assert.Equal(t, 0, Connections.GetLocalCount())
+	assert.Equal(t, 0, Connections.CloseLocal())
+
+	Connections.Add(fakeConn)
+	assert.Equal(t, 1, Connections.CloseLocal())
+	assert.Eventually(t, func() bool { return Connections.GetLocalCount() == 0 }, 300*time.Millisecond, 50*time.Millisecond)
 
 	assert.True(t, Connections.IsNewConnectionAllowed())
 	Connections.AddNetworkConnection()
@@ -254,6 +315,49 @@ func TestMaxConnections(t *testing.T) {
 	Config.MaxTotalConnections = oldValue
 }
 
+func TestReloadConfig(t *testing.T) {
+	configCopy := Config
+
+	c := Config
+	c.IdleTimeout = 10
+	c.MaxTotalConnections = 50
+	c.PostConnectHook = "/path/to/hook"
+	c.UploadMode = UploadModeAtomic
+	c.Actions.ProgressInterval = -1
+	err := ReloadConfig(c)
+	assert.Error(t, err)
+	assert.Equal(t, configCopy.IdleTimeout, Config.IdleTimeout)
+
+	c.Actions.ProgressInterval = 0
+	c.Actions.Retry.MaxRetries = -1
+	err = ReloadConfig(c)
+	assert.Error(t, err)
+	assert.Equal(t, configCopy.IdleTimeout, Config.IdleTimeout)
+
+	c.Actions.Retry.MaxRetries = configCopy.Actions.Retry.MaxRetries
+	c.GeoIP.CountryDatabase = "relative.mmdb"
+	err = ReloadConfig(c)
+	assert.Error(t, err)
+	assert.Equal(t, configCopy.IdleTimeout, Config.IdleTimeout)
+
+	c.GeoIP.CountryDatabase = ""
+	err = ReloadConfig(c)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, Config.IdleTimeout)
+	assert.Equal(t, 10*time.Minute, Config.idleTimeoutAsDuration)
+	assert.Equal(t, 50, Config.MaxTotalConnections)
+	assert.Equal(t, "/path/to/hook", Config.PostConnectHook)
+	assert.Equal(t, configCopy.UploadMode, Config.UploadMode)
+	assert.NotNil(t, idleTimeoutTicker)
+
+	err = ReloadConfig(configCopy)
+	assert.NoError(t, err)
+	Config = configCopy
+	if Config.IdleTimeout == 0 {
+		assert.Nil(t, idleTimeoutTicker)
+	}
+}
+
 func TestIdleConnections(t *testing.T) {
 	configCopy := Config
 
@@ -388,6 +492,15 @@ func TestAtomicUpload(t *testing.T) {
 	Config.UploadMode = UploadModeAtomicWithResume
 	assert.True(t, Config.IsAtomicUploadEnabled())
 
+	conn := NewBaseConnection("id", ProtocolSFTP, dataprovider.User{})
+	assert.Equal(t, UploadModeAtomicWithResume, conn.GetUploadMode())
+	assert.True(t, conn.IsAtomicUploadEnabled())
+	conn.SetUploadMode(UploadModeStandard)
+	assert.Equal(t, UploadModeStandard, conn.GetUploadMode())
+	assert.False(t, conn.IsAtomicUploadEnabled())
+	Config.UploadMode = UploadModeAtomic
+	assert.False(t, conn.IsAtomicUploadEnabled())
+
 	Config = configCopy
 }
 
@@ -469,6 +582,60 @@ func TestConnectionStatus(t *testing.T) {
 	Connections.Remove(fakeConn3.GetID())
 	stats = Connections.GetStats()
 	assert.Len(t, stats, 0)
+
+	stat := ConnectionStatus{
+		Protocol:       ProtocolSFTP,
+		RemoteAddress:  "81.2.69.142:2345",
+		Country:        "IT",
+		ASN:            3269,
+		ASOrganization: "Telecom Italia",
+	}
+	assert.Equal(t, `SFTP. Client: "" From: "81.2.69.142:2345" (IT, AS3269 Telecom Italia)`, stat.GetConnectionInfo())
+}
+
+func TestMaxConcurrentTransfers(t *testing.T) {
+	user := dataprovider.User{
+		Username: userTestUsername,
+	}
+	user.Filters.MaxConcurrentTransfers = 2
+	fs := vfs.NewOsFs("", os.TempDir(), "")
+	c1 := NewBaseConnection("id1", ProtocolSFTP, user)
+	fakeConn1 := &fakeConnection{
+		BaseConnection: c1,
+	}
+	c2 := NewBaseConnection("id2", ProtocolFTP, user)
+	fakeConn2 := &fakeConnection{
+		BaseConnection: c2,
+	}
+	Connections.Add(fakeConn1)
+	Connections.Add(fakeConn2)
+
+	assert.NoError(t, c1.CheckMaxTransfers())
+	t1 := NewBaseTransfer(nil, c1, nil, "/p1", "/r1", TransferUpload, 0, 0, 0, true, fs)
+	assert.NoError(t, c2.CheckMaxTransfers())
+	t2 := NewBaseTransfer(nil, c2, nil, "/p2", "/r2", TransferDownload, 0, 0, 0, true, fs)
+	assert.Equal(t, 2, Connections.GetActiveTransfers(userTestUsername))
+	// the limit applies to all the user sessions
+	err := c1.CheckMaxTransfers()
+	assert.Error(t, err)
+	err = c2.CheckMaxTransfers()
+	assert.ErrorIs(t, err, ErrTooManyTransfers)
+
+	err = t2.Close()
+	assert.NoError(t, err)
+	assert.NoError(t, c1.CheckMaxTransfers())
+	c1.User.Filters.MaxConcurrentTransfers = 0
+	t3 := NewBaseTransfer(nil, c1, nil, "/p3", "/r3", TransferDownload, 0, 0, 0, true, fs)
+	assert.NoError(t, c1.CheckMaxTransfers())
+
+	err = t1.Close()
+	assert.NoError(t, err)
+	err = t3.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, Connections.GetActiveTransfers(userTestUsername))
+	Connections.Remove(fakeConn1.GetID())
+	Connections.Remove(fakeConn2.GetID())
+	assert.Len(t, Connections.GetStats(), 0)
 }
 
 func TestQuotaScans(t *testing.T) {
@@ -516,6 +683,21 @@ func TestProxyProtocolVersion(t *testing.T) {
 	c.ProxyProtocol = 2
 	_, err = c.GetProxyListener(nil)
 	assert.Error(t, err)
+
+	c.ProxyProtocol = 0
+	c.ProxyAllowed = nil
+	proxyListener, err = c.GetProxyListener(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, proxyListener)
+	proxyListener, err = c.GetProxyListenerWithOverrides(nil, 2, nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, proxyListener.Policy)
+	_, err = c.GetProxyListenerWithOverrides(nil, 1, []string{"invalid"})
+	assert.Error(t, err)
+	c.ProxyProtocol = 1
+	proxyListener, err = c.GetProxyListenerWithOverrides(nil, 0, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, proxyListener.Policy)
 }
 
 func TestStartupHook(t *testing.T) {
@@ -583,6 +765,42 @@ func TestPostConnectHook(t *testing.T) {
 	Config.PostConnectHook = ""
 }
 
+func TestPreConnectHook(t *testing.T) {
+	Config.PreConnectHook = ""
+
+	ipAddr := "127.0.0.1"
+
+	assert.NoError(t, Config.ExecutePreConnectHook(ipAddr, ProtocolSSH))
+
+	Config.PreConnectHook = "http://foo\x7f.com/"
+	assert.Error(t, Config.ExecutePreConnectHook(ipAddr, ProtocolSSH))
+
+	Config.PreConnectHook = fmt.Sprintf("http://%v/404", httpAddr)
+	assert.Error(t, Config.ExecutePreConnectHook(ipAddr, ProtocolHTTP))
+
+	Config.PreConnectHook = fmt.Sprintf("http://%v", httpAddr)
+	assert.NoError(t, Config.ExecutePreConnectHook(ipAddr, ProtocolHTTP))
+	// the post connect hook is not affected
+	assert.NoError(t, Config.ExecutePostConnectHook(ipAddr, ProtocolHTTP))
+
+	Config.PreConnectHook = "invalid"
+	assert.Error(t, Config.ExecutePreConnectHook(ipAddr, ProtocolWebDAV))
+
+	if runtime.GOOS != osWindows {
+		hookCmd, err := exec.LookPath("true")
+		assert.NoError(t, err)
+		Config.PreConnectHook = hookCmd
+		assert.NoError(t, Config.ExecutePreConnectHook(ipAddr, ProtocolSSH))
+
+		hookCmd, err = exec.LookPath("false")
+		assert.NoError(t, err)
+		Config.PreConnectHook = hookCmd
+		assert.Error(t, Config.ExecutePreConnectHook(ipAddr, ProtocolSSH))
+	}
+
+	Config.PreConnectHook = ""
+}
+
 func TestCryptoConvertFileInfo(t *testing.T) {
 	name := "name"
 	fs, err := vfs.NewCryptFs("connID1", os.TempDir(), "", vfs.CryptFsConfig{Passphrase: kms.NewPlainSecret("secret")})
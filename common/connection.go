@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -13,6 +14,7 @@ import (
 	"github.com/pkg/sftp"
 
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
@@ -30,6 +32,12 @@ type BaseConnection struct {
 	// start time for this connection
 	startTime time.Time
 	protocol  string
+	// client address, if known, it is included in the action notifications
+	remoteAddr string
+	// country and autonomous system for the client address, if known
+	geoInfo geoip.Info
+	// upload mode override, nil means the configured upload mode
+	uploadMode *int
 	sync.RWMutex
 	transferID      uint64
 	activeTransfers []ActiveTransfer
@@ -53,7 +61,7 @@ func NewBaseConnection(id, protocol string, user dataprovider.User) *BaseConnect
 
 // Log outputs a log entry to the configured logger
 func (c *BaseConnection) Log(level logger.LogLevel, format string, v ...interface{}) {
-	logger.Log(level, c.protocol, c.ID, format, v...)
+	logger.ConnectionLog(level, c.ID, c.User.Username, c.protocol, &c.geoInfo, format, v...)
 }
 
 // GetTransferID returns an unique transfer ID for this connection
@@ -84,6 +92,54 @@ func (c *BaseConnection) SetProtocol(protocol string) {
 	}
 }
 
+// SetRemoteAddress sets the client address. It must be called before using
+// the connection, the client IP, its country and autonomous system are included
+// in the connection logs and in the action notifications
+func (c *BaseConnection) SetRemoteAddress(remoteAddr string) {
+	c.remoteAddr = remoteAddr
+	c.geoInfo = geoip.Lookup(c.GetRemoteIP())
+}
+
+// SetUploadMode overrides the configured upload mode for this connection.
+// It must be called before using the connection
+func (c *BaseConnection) SetUploadMode(mode int) {
+	c.uploadMode = &mode
+}
+
+// GetUploadMode returns the upload mode for this connection
+func (c *BaseConnection) GetUploadMode() int {
+	if c.uploadMode != nil {
+		return *c.uploadMode
+	}
+	return Config.UploadMode
+}
+
+// IsAtomicUploadEnabled returns true if atomic upload is enabled for this connection
+func (c *BaseConnection) IsAtomicUploadEnabled() bool {
+	mode := c.GetUploadMode()
+	return mode == UploadModeAtomic || mode == UploadModeAtomicWithResume
+}
+
+// GetRemoteIP returns the client IP address or an empty string if unknown
+func (c *BaseConnection) GetRemoteIP() string {
+	if c.remoteAddr == "" {
+		return ""
+	}
+	return utils.GetIPFromRemoteAddress(c.remoteAddr)
+}
+
+// newActionNotification returns a notification for the given operation including
+// the connection details
+func (c *BaseConnection) newActionNotification(operation, filePath, target string, fileSize int64,
+	err error,
+) *ActionNotification {
+	action := newActionNotification(&c.User, operation, filePath, target, "", c.protocol, fileSize, err)
+	action.IP = c.GetRemoteIP()
+	action.setGeoIPInfo(&c.geoInfo)
+	action.ConnectionID = c.ID
+	return action
+}
+
 // GetConnectionTime returns the initial connection time
 func (c *BaseConnection) GetConnectionTime() time.Time {
 	return c.startTime
@@ -202,11 +258,39 @@ func (c *BaseConnection) truncateOpenHandle(fsPath string, size int64) (int64, e
 	return 0, errNoTransfer
 }
 
+// LimitOperationRate applies the rate limiters configured for the given operation
+// to this connection. It returns a protocol specific error if the rate is exceeded
+func (c *BaseConnection) LimitOperationRate(operation string) error {
+	if _, err := LimitOperationRate(getRateLimiterProtocol(c.protocol), operation, c.ID); err != nil {
+		c.Log(logger.LevelDebug, "rate limit exceeded for operation %#v: %v", operation, err)
+		return c.GetGenericError(ErrRateLimitExceeded)
+	}
+	return nil
+}
+
+// CheckMaxTransfers returns a protocol specific error if the user already has
+// the maximum allowed concurrent transfers
+func (c *BaseConnection) CheckMaxTransfers() error {
+	maxTransfers := c.User.Filters.MaxConcurrentTransfers
+	if maxTransfers <= 0 {
+		return nil
+	}
+	if numTransfers := Connections.GetActiveTransfers(c.User.Username); numTransfers >= maxTransfers {
+		c.Log(logger.LevelInfo, "max concurrent transfers exceeded, active transfers: %v, max allowed: %v",
+			numTransfers, maxTransfers)
+		return c.GetGenericError(ErrTooManyTransfers)
+	}
+	return nil
+}
+
 // ListDir reads the directory matching virtualPath and returns a list of directory entries
 func (c *BaseConnection) ListDir(virtualPath string) ([]os.FileInfo, error) {
-	if !c.User.HasPerm(dataprovider.PermListItems, virtualPath) {
+	if !c.User.HasPerm(dataprovider.PermListItems, virtualPath) || c.User.IsReservedPath(virtualPath) {
 		return nil, c.GetPermissionDeniedError()
 	}
+	if err := c.LimitOperationRate(RateLimitOperationList); err != nil {
+		return nil, err
+	}
 	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
 	if err != nil {
 		return nil, err
@@ -216,9 +300,55 @@ func (c *BaseConnection) ListDir(virtualPath string) ([]os.FileInfo, error) {
 		c.Log(logger.LevelWarn, "error listing directory: %+v", err)
 		return nil, c.GetFsError(fs, err)
 	}
+	files = appendArchivedEntries(c.hideReservedDirs(files, virtualPath), c.getArchivedEntries(fs, virtualPath))
 	return c.User.AddVirtualDirs(files, virtualPath), nil
 }
 
+// ListDirLister returns a lister for the directory matching virtualPath.
+// The directory entries are read in batches so huge directories can be listed
+// in bounded memory
+func (c *BaseConnection) ListDirLister(virtualPath string) (vfs.DirLister, error) {
+	if !c.User.HasPerm(dataprovider.PermListItems, virtualPath) || c.User.IsReservedPath(virtualPath) {
+		return nil, c.GetPermissionDeniedError()
+	}
+	if err := c.LimitOperationRate(RateLimitOperationList); err != nil {
+		return nil, err
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetDirLister(fs, fsPath, virtualPath)
+}
+
+// GetDirLister returns a lister for the specified filesystem directory,
+// virtual folders are added and reserved directories hidden as for ListDir.
+// Permissions are not checked
+func (c *BaseConnection) GetDirLister(fs vfs.Fs, fsPath, virtualPath string) (vfs.DirLister, error) {
+	lister, err := fs.OpenDir(fsPath)
+	if err != nil {
+		c.Log(logger.LevelWarn, "error listing directory: %+v", err)
+		return nil, c.GetFsError(fs, err)
+	}
+	return newConnectionDirLister(c, fs, lister, virtualPath), nil
+}
+
+// hideReservedDirs removes the directories reserved for internal usage from
+// the listing of a filesystem root
+func (c *BaseConnection) hideReservedDirs(files []os.FileInfo, virtualPath string) []os.FileInfo {
+	result := files[:0]
+	for _, info := range files {
+		if info.Name() == vfs.VersionsDirName || info.Name() == dataprovider.TrashDirName ||
+			info.Name() == dataprovider.ArchivedDirName {
+			if c.User.IsReservedPath(path.Join(virtualPath, info.Name())) {
+				continue
+			}
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
 // CreateDir creates a new directory at the specified fsPath
 func (c *BaseConnection) CreateDir(virtualPath string) error {
 	if !c.User.HasPerm(dataprovider.PermCreateDirs, path.Dir(virtualPath)) {
@@ -228,6 +358,10 @@ func (c *BaseConnection) CreateDir(virtualPath string) error {
 		c.Log(logger.LevelWarn, "mkdir not allowed %#v is a virtual folder", virtualPath)
 		return c.GetPermissionDeniedError()
 	}
+	if c.User.IsReservedPath(virtualPath) {
+		c.Log(logger.LevelWarn, "mkdir not allowed %#v is inside a reserved directory", virtualPath)
+		return c.GetPermissionDeniedError()
+	}
 	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
 	if err != nil {
 		return err
@@ -239,6 +373,66 @@ func (c *BaseConnection) CreateDir(virtualPath string) error {
 	vfs.SetPathPermissions(fs, fsPath, c.User.GetUID(), c.User.GetGID())
 
 	logger.CommandLog(mkdirLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1)
+	action := c.newActionNotification(operationMkdir, fsPath, "", 0, nil)
+	action.VirtualPath = virtualPath
+	notifyAction(action)
+	return nil
+}
+
+// PreUploadAction executes the pre-upload action, if configured, before creating or
+// overwriting the file at the given virtual path. It returns the virtual path to use
+// for the upload, the action can redirect the upload to a different path, or an error
+// if the upload is rejected
+func (c *BaseConnection) PreUploadAction(virtualPath string) (string, error) {
+	var fsPath string
+	if _, p, err := c.GetFsAndResolvedPath(virtualPath); err == nil {
+		fsPath = p
+	}
+	action := c.newActionNotification(operationPreUpload, fsPath, "", 0, nil)
+	action.VirtualPath = virtualPath
+	err := handleAction(action)
+	if err == errUnconfiguredAction || err == errFilteredAction {
+		return virtualPath, nil
+	}
+	if err != nil {
+		c.Log(logger.LevelInfo, "upload to %#v rejected by pre-upload action: %v", virtualPath, err)
+		return virtualPath, c.GetPermissionDeniedError()
+	}
+	if action.VirtualTargetPath == "" {
+		return virtualPath, nil
+	}
+	targetPath := utils.CleanPath(action.VirtualTargetPath)
+	if targetPath == virtualPath {
+		return virtualPath, nil
+	}
+	if !c.User.IsFileAllowed(targetPath) {
+		c.Log(logger.LevelWarn, "upload to %#v redirected to %#v by pre-upload action, writing the target is not allowed",
+			virtualPath, targetPath)
+		return virtualPath, c.GetPermissionDeniedError()
+	}
+	c.Log(logger.LevelDebug, "upload to %#v redirected to %#v by pre-upload action", virtualPath, targetPath)
+	return targetPath, nil
+}
+
+// PreDownloadAction executes the pre-download action, if configured, before starting
+// the download of the file at the given paths. It returns an error if the download
+// is denied. Archived files are restored once the download is allowed
+func (c *BaseConnection) PreDownloadAction(fsPath, virtualPath, remoteIP string) error {
+	action := c.newActionNotification(operationPreDownload, fsPath, "", 0, nil)
+	action.VirtualPath = virtualPath
+	if remoteIP != "" && remoteIP != action.IP {
+		action.IP = remoteIP
+		geoInfo := geoip.Lookup(remoteIP)
+		action.setGeoIPInfo(&geoInfo)
+	}
+	err := handleAction(action)
+	if err != nil && err != errUnconfiguredAction && err != errFilteredAction {
+		c.Log(logger.LevelInfo, "download of %#v denied by pre-download action: %v", virtualPath, err)
+		return c.GetPermissionDeniedError()
+	}
+	if fs, err := c.User.GetFilesystemForPath(virtualPath, c.ID); err == nil {
+		return c.restoreArchivedFileOnAccess(fs, fsPath, virtualPath)
+	}
 	return nil
 }
 
@@ -261,19 +455,31 @@ func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info
 	}
 
 	size := info.Size()
-	action := newActionNotification(&c.User, operationPreDelete, fsPath, "", "", c.protocol, size, nil)
-	actionErr := actionHandler.Handle(action)
+	isKept := false
+	action := c.newActionNotification(operationPreDelete, fsPath, "", size, nil)
+	action.VirtualPath = virtualPath
+	actionErr := handleAction(action)
 	if actionErr == nil {
 		c.Log(logger.LevelDebug, "remove for path %#v handled by pre-delete action", fsPath)
 	} else {
-		if err := fs.Remove(fsPath, false); err != nil {
-			c.Log(logger.LevelWarn, "failed to remove a file/symlink %#v: %+v", fsPath, err)
-			return c.GetFsError(fs, err)
+		if info.Mode().IsRegular() {
+			kept, err := c.keepDeletedFile(fs, fsPath, virtualPath)
+			if err != nil {
+				return c.GetFsError(fs, err)
+			}
+			isKept = kept
+		}
+		if !isKept {
+			if err := fs.Remove(fsPath, false); err != nil {
+				c.Log(logger.LevelWarn, "failed to remove a file/symlink %#v: %+v", fsPath, err)
+				return c.GetFsError(fs, err)
+			}
 		}
 	}
 
 	logger.CommandLog(removeLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1)
-	if info.Mode()&os.ModeSymlink == 0 {
+	// versioned and trashed files are still stored inside the user home and so they are included in the quota
+	if info.Mode()&os.ModeSymlink == 0 && !isKept {
 		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
 		if err == nil {
 			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, -1, -size, false) //nolint:errcheck
@@ -283,10 +489,12 @@ func (c *BaseConnection) RemoveFile(fs vfs.Fs, fsPath, virtualPath string, info
 		} else {
 			dataprovider.UpdateUserQuota(&c.User, -1, -size, false) //nolint:errcheck
 		}
+		dataprovider.UpdateDirQuota(&c.User, virtualPath, -1, -size)
 	}
 	if actionErr != nil {
-		action := newActionNotification(&c.User, operationDelete, fsPath, "", "", c.protocol, size, nil)
-		go actionHandler.Handle(action) // nolint:errcheck
+		action := c.newActionNotification(operationDelete, fsPath, "", size, nil)
+		action.VirtualPath = virtualPath
+		notifyAction(action)
 	}
 	return nil
 }
@@ -301,6 +509,10 @@ func (c *BaseConnection) IsRemoveDirAllowed(fs vfs.Fs, fsPath, virtualPath strin
 		c.Log(logger.LevelWarn, "removing a virtual folder is not allowed: %#v", virtualPath)
 		return c.GetPermissionDeniedError()
 	}
+	if c.User.IsReservedPath(virtualPath) {
+		c.Log(logger.LevelWarn, "removing a reserved directory is not allowed: %#v", virtualPath)
+		return c.GetPermissionDeniedError()
+	}
 	if c.User.HasVirtualFoldersInside(virtualPath) {
 		c.Log(logger.LevelWarn, "removing a directory with a virtual folder inside is not allowed: %#v", virtualPath)
 		return c.GetOpUnsupportedError()
@@ -345,6 +557,9 @@ func (c *BaseConnection) RemoveDir(virtualPath string) error {
 	}
 
 	logger.CommandLog(rmdirLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1)
+	action := c.newActionNotification(operationRmdir, fsPath, "", 0, nil)
+	action.VirtualPath = virtualPath
+	notifyAction(action)
 	return nil
 }
 
@@ -405,9 +620,11 @@ func (c *BaseConnection) Rename(virtualSourcePath, virtualTargetPath string) err
 	c.updateQuotaAfterRename(fsDst, virtualSourcePath, virtualTargetPath, fsTargetPath, initialSize) //nolint:errcheck
 	logger.CommandLog(renameLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1,
 		"", "", "", -1)
-	action := newActionNotification(&c.User, operationRename, fsSourcePath, fsTargetPath, "", c.protocol, 0, nil)
+	action := c.newActionNotification(operationRename, fsSourcePath, fsTargetPath, 0, nil)
+	action.VirtualPath = virtualSourcePath
+	action.VirtualTargetPath = virtualTargetPath
 	// the returned error is used in test cases only, we already log the error inside action.execute
-	go actionHandler.Handle(action) // nolint:errcheck
+	notifyAction(action)
 
 	return nil
 }
@@ -443,6 +660,10 @@ func (c *BaseConnection) CreateSymlink(virtualSourcePath, virtualTargetPath stri
 		return c.GetFsError(fs, err)
 	}
 	logger.CommandLog(symlinkLogSender, fsSourcePath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", -1)
+	action := c.newActionNotification(operationSymlink, fsSourcePath, fsTargetPath, 0, nil)
+	action.VirtualPath = virtualSourcePath
+	action.VirtualTargetPath = virtualTargetPath
+	notifyAction(action)
 	return nil
 }
 
@@ -478,6 +699,12 @@ func (c *BaseConnection) DoStat(virtualPath string, mode int) (os.FileInfo, erro
 		info, err = fs.Stat(c.getRealFsPath(fsPath))
 	}
 	if err != nil {
+		if fs.IsNotExist(err) {
+			// archived files are reported with their original attributes
+			if archived, _, errArchived := c.getArchivedFile(fs, virtualPath); errArchived == nil {
+				return archived.getFileInfo(path.Base(virtualPath)), nil
+			}
+		}
 		return info, c.GetFsError(fs, err)
 	}
 	if vfs.IsCryptOsFs(fs) {
@@ -490,12 +717,22 @@ func (c *BaseConnection) ignoreSetStat(fs vfs.Fs) bool {
 	if Config.SetstatMode == 1 {
 		return true
 	}
-	if Config.SetstatMode == 2 && !vfs.IsLocalOrSFTPFs(fs) && !vfs.IsCryptOsFs(fs) {
+	if Config.SetstatMode == 2 && !fs.Capabilities().SetStat {
 		return true
 	}
 	return false
 }
 
+// notifySetStat sends the setstat action for the given path, the virtual path
+// is obtained from the filesystem so it is not affected by the path used to
+// check the permissions
+func (c *BaseConnection) notifySetStat(fs vfs.Fs, fsPath string, attributes *ActionStatAttributes) {
+	action := c.newActionNotification(operationSetStat, fsPath, "", 0, nil)
+	action.VirtualPath = fs.GetRelativePath(fsPath)
+	action.Attributes = attributes
+	notifyAction(action)
+}
+
 func (c *BaseConnection) handleChmod(fs vfs.Fs, fsPath, pathForPerms string, attributes *StatAttributes) error {
 	if !c.User.HasPerm(dataprovider.PermChmod, pathForPerms) {
 		return c.GetPermissionDeniedError()
@@ -509,6 +746,10 @@ func (c *BaseConnection) handleChmod(fs vfs.Fs, fsPath, pathForPerms string, att
 	}
 	logger.CommandLog(chmodLogSender, fsPath, "", c.User.Username, attributes.Mode.String(), c.ID, c.protocol,
 		-1, -1, "", "", "", -1)
+	c.notifySetStat(fs, fsPath, &ActionStatAttributes{
+		Type: SetStatTypeChmod,
+		Mode: fmt.Sprintf("%04o", uint32(attributes.Mode.Perm())),
+	})
 	return nil
 }
 
@@ -526,6 +767,13 @@ func (c *BaseConnection) handleChown(fs vfs.Fs, fsPath, pathForPerms string, att
 	}
 	logger.CommandLog(chownLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, attributes.UID, attributes.GID,
 		"", "", "", -1)
+	uid := attributes.UID
+	gid := attributes.GID
+	c.notifySetStat(fs, fsPath, &ActionStatAttributes{
+		Type: SetStatTypeChown,
+		UID:  &uid,
+		GID:  &gid,
+	})
 	return nil
 }
 
@@ -545,6 +793,54 @@ func (c *BaseConnection) handleChtimes(fs vfs.Fs, fsPath, pathForPerms string, a
 	modificationTimeString := attributes.Mtime.Format(chtimesFormat)
 	logger.CommandLog(chtimesLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1,
 		accessTimeString, modificationTimeString, "", -1)
+	c.notifySetStat(fs, fsPath, &ActionStatAttributes{
+		Type:  SetStatTypeChtimes,
+		Atime: utils.GetTimeAsMsSinceEpoch(attributes.Atime),
+		Mtime: utils.GetTimeAsMsSinceEpoch(attributes.Mtime),
+	})
+	return nil
+}
+
+func (c *BaseConnection) handleSetXattrs(fs vfs.Fs, fsPath, pathForPerms string, attributes *StatAttributes) error {
+	if !c.User.HasPerm(dataprovider.PermChmod, pathForPerms) {
+		return c.GetPermissionDeniedError()
+	}
+	if Config.SetstatMode == 1 {
+		return nil
+	}
+	// cloud based filesystems store extended attributes as metadata,
+	// so they are ignored in mode 2 only if the filesystem cannot store them
+	xattrFs, ok := fs.(vfs.XattrFs)
+	if !ok {
+		if Config.SetstatMode == 2 {
+			return nil
+		}
+		return c.GetOpUnsupportedError()
+	}
+	realPath := c.getRealFsPath(fsPath)
+	names := make([]string, 0, len(attributes.Xattrs))
+	for attr, value := range attributes.Xattrs {
+		var err error
+		if value == nil {
+			err = xattrFs.RemoveXattr(realPath, attr)
+		} else {
+			err = xattrFs.SetXattr(realPath, attr, value)
+		}
+		if err != nil {
+			c.Log(logger.LevelWarn, "failed to set extended attribute %#v for path %#v, err: %+v", attr, fsPath, err)
+			return c.GetFsError(fs, err)
+		}
+		logger.CommandLog(setXattrLogSender, fsPath, attr, c.User.Username, "", c.ID, c.protocol, -1, -1,
+			"", "", "", int64(len(value)))
+		names = append(names, attr)
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		c.notifySetStat(fs, fsPath, &ActionStatAttributes{
+			Type:   SetStatTypeXattrs,
+			Xattrs: names,
+		})
+	}
 	return nil
 }
 
@@ -568,6 +864,10 @@ func (c *BaseConnection) SetStat(virtualPath string, attributes *StatAttributes)
 		return c.handleChtimes(fs, fsPath, pathForPerms, attributes)
 	}
 
+	if attributes.Flags&StatAttrXattrs != 0 {
+		return c.handleSetXattrs(fs, fsPath, pathForPerms, attributes)
+	}
+
 	if attributes.Flags&StatAttrSize != 0 {
 		if !c.User.HasPerm(dataprovider.PermOverwrite, pathForPerms) {
 			return c.GetPermissionDeniedError()
@@ -578,6 +878,11 @@ func (c *BaseConnection) SetStat(virtualPath string, attributes *StatAttributes)
 			return c.GetFsError(fs, err)
 		}
 		logger.CommandLog(truncateLogSender, fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1, "", "", "", attributes.Size)
+		size := attributes.Size
+		c.notifySetStat(fs, fsPath, &ActionStatAttributes{
+			Type: SetStatTypeTruncate,
+			Size: &size,
+		})
 	}
 
 	return nil
@@ -599,7 +904,7 @@ func (c *BaseConnection) truncateFile(fs vfs.Fs, fsPath, virtualPath string, siz
 		initialSize = info.Size()
 		err = fs.Truncate(fsPath, size)
 	}
-	if err == nil && vfs.IsLocalOrSFTPFs(fs) {
+	if err == nil && fs.Capabilities().Truncate {
 		sizeDiff := initialSize - size
 		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
 		if err == nil {
@@ -610,6 +915,7 @@ func (c *BaseConnection) truncateFile(fs vfs.Fs, fsPath, virtualPath string, siz
 		} else {
 			dataprovider.UpdateUserQuota(&c.User, 0, -sizeDiff, false) //nolint:errcheck
 		}
+		dataprovider.UpdateDirQuota(&c.User, virtualPath, 0, -sizeDiff)
 	}
 	return err
 }
@@ -694,6 +1000,10 @@ func (c *BaseConnection) isRenamePermitted(fsSrc, fsDst vfs.Fs, fsSourcePath, fs
 		c.Log(logger.LevelWarn, "renaming a virtual folder is not allowed")
 		return false
 	}
+	if c.User.IsReservedPath(virtualSourcePath) || c.User.IsReservedPath(virtualTargetPath) {
+		c.Log(logger.LevelWarn, "renaming from/to a reserved directory is not allowed")
+		return false
+	}
 	if !c.User.IsFileAllowed(virtualSourcePath) || !c.User.IsFileAllowed(virtualTargetPath) {
 		if fi != nil && fi.Mode().IsRegular() {
 			c.Log(logger.LevelDebug, "renaming file is not allowed, source: %#v target: %#v",
@@ -709,6 +1019,10 @@ func (c *BaseConnection) hasSpaceForRename(fs vfs.Fs, virtualSourcePath, virtual
 	if dataprovider.GetQuotaTracking() == 0 {
 		return true
 	}
+	if c.hasDirQuotasForRename(virtualSourcePath, virtualTargetPath) {
+		quotaResult := c.HasSpace(true, false, virtualTargetPath)
+		return c.hasSpaceForCrossRename(fs, quotaResult, initialSize, fsSourcePath)
+	}
 	sourceFolder, errSrc := c.User.GetVirtualFolderForPath(path.Dir(virtualSourcePath))
 	dstFolder, errDst := c.User.GetVirtualFolderForPath(path.Dir(virtualTargetPath))
 	if errSrc != nil && errDst != nil {
@@ -818,6 +1132,14 @@ func (c *BaseConnection) GetMaxWriteSize(quotaResult vfs.QuotaCheckResult, isRes
 
 // HasSpace checks user's quota usage
 func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string) vfs.QuotaCheckResult {
+	result := c.hasSpaceForUserOrFolder(checkFiles, getUsage, requestPath)
+	if !result.HasSpace {
+		return result
+	}
+	return c.checkDirQuotas(checkFiles, requestPath, result)
+}
+
+func (c *BaseConnection) hasSpaceForUserOrFolder(checkFiles, getUsage bool, requestPath string) vfs.QuotaCheckResult {
 	result := vfs.QuotaCheckResult{
 		HasSpace:     true,
 		AllowedSize:  0,
@@ -866,6 +1188,85 @@ func (c *BaseConnection) HasSpace(checkFiles, getUsage bool, requestPath string)
 	return result
 }
 
+// checkDirQuotas checks the quota restrictions defined for the directories containing
+// the request path and merges them with the given result so that the most restrictive
+// limits are returned
+func (c *BaseConnection) checkDirQuotas(checkFiles bool, requestPath string, result vfs.QuotaCheckResult) vfs.QuotaCheckResult {
+	if dataprovider.GetQuotaTracking() == 0 {
+		return result
+	}
+	for _, q := range c.User.GetDirQuotasForPath(requestPath) {
+		usedFiles, usedSize, err := c.getUsedDirQuota(q.Path)
+		if err != nil {
+			c.Log(logger.LevelWarn, "error getting used quota for %#v directory %#v: %v", c.User.Username, q.Path, err)
+			result.HasSpace = false
+			return result
+		}
+		if (checkFiles && q.QuotaFiles > 0 && usedFiles >= q.QuotaFiles) ||
+			(q.QuotaSize > 0 && usedSize >= q.QuotaSize) {
+			c.Log(logger.LevelDebug, "quota exceed for user %#v, directory %#v request path %#v, num files: %v/%v, size: %v/%v check files: %v",
+				c.User.Username, q.Path, requestPath, usedFiles, q.QuotaFiles, usedSize, q.QuotaSize, checkFiles)
+			result.HasSpace = false
+		}
+		if q.QuotaSize > 0 && (result.QuotaSize == 0 || q.QuotaSize-usedSize < result.GetRemainingSize()) {
+			result.QuotaSize = q.QuotaSize
+			result.UsedSize = usedSize
+			result.AllowedSize = q.QuotaSize - usedSize
+		}
+		if q.QuotaFiles > 0 && (result.QuotaFiles == 0 || q.QuotaFiles-usedFiles < result.GetRemainingFiles()) {
+			result.QuotaFiles = q.QuotaFiles
+			result.UsedFiles = usedFiles
+			result.AllowedFiles = q.QuotaFiles - usedFiles
+		}
+		if !result.HasSpace {
+			return result
+		}
+	}
+	return result
+}
+
+// getUsedDirQuota returns the used quota for the given directory, the directory
+// is scanned if its used quota is unknown
+func (c *BaseConnection) getUsedDirQuota(dirPath string) (int, int64, error) {
+	if files, size, ok := dataprovider.GetUsedDirQuota(c.User.Username, dirPath); ok {
+		return files, size, nil
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(dirPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	files, size, err := fs.GetDirSize(fsPath)
+	if err != nil {
+		if !fs.IsNotExist(err) {
+			return 0, 0, err
+		}
+		files = 0
+		size = 0
+	}
+	c.Log(logger.LevelDebug, "used quota for directory %#v scanned, files: %v, size: %v", dirPath, files, size)
+	dataprovider.SetUsedDirQuota(c.User.Username, dirPath, files, size)
+	return files, size, nil
+}
+
+// hasDirQuotasForRename returns true if the target path is inside a directory with quota
+// restrictions not including the source path
+func (c *BaseConnection) hasDirQuotasForRename(virtualSourcePath, virtualTargetPath string) bool {
+	sourceQuotas := c.User.GetDirQuotasForPath(virtualSourcePath)
+	for _, q := range c.User.GetDirQuotasForPath(virtualTargetPath) {
+		found := false
+		for _, sq := range sourceQuotas {
+			if sq.Path == q.Path {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return true
+		}
+	}
+	return false
+}
+
 // returns true if this is a rename on the same fs or local virtual folders
 func (c *BaseConnection) isLocalOrSameFolderRename(virtualSourcePath, virtualTargetPath string) bool {
 	sourceFolder, errSrc := c.User.GetVirtualFolderForPath(virtualSourcePath)
@@ -978,6 +1379,11 @@ func (c *BaseConnection) updateQuotaAfterRename(fs vfs.Fs, virtualSourcePath, vi
 	if dataprovider.GetQuotaTracking() == 0 {
 		return nil
 	}
+	if len(c.User.Filters.DirQuotas) > 0 {
+		// renamed directories could contain other directories with quota restrictions,
+		// they will be scanned again when needed
+		dataprovider.ResetUserDirQuotas(c.User.Username)
+	}
 	// we don't allow to overwrite an existing directory so targetPath can be:
 	// - a new file, a symlink is as a new file here
 	// - a file overwriting an existing one
@@ -1024,8 +1430,10 @@ func (c *BaseConnection) updateQuotaAfterRename(fs vfs.Fs, virtualSourcePath, vi
 	return nil
 }
 
-// GetPermissionDeniedError returns an appropriate permission denied error for the connection protocol
+// GetPermissionDeniedError returns an appropriate permission denied error for the connection protocol.
+// The permission denial is logged to the audit log
 func (c *BaseConnection) GetPermissionDeniedError() error {
+	logger.AuditPermissionDenied(c.User.Username, c.GetRemoteIP(), c.ID, c.protocol)
 	switch c.protocol {
 	case ProtocolSFTP:
 		return sftp.ErrSSHFxPermissionDenied
@@ -1068,7 +1476,8 @@ func (c *BaseConnection) GetGenericError(err error) error {
 		return sftp.ErrSSHFxFailure
 	default:
 		if err == ErrPermissionDenied || err == ErrNotExist || err == ErrOpUnsupported ||
-			err == ErrQuotaExceeded || err == vfs.ErrStorageSizeUnavailable {
+			err == ErrQuotaExceeded || err == vfs.ErrStorageSizeUnavailable || err == ErrRateLimitExceeded ||
+			err == ErrTooManyTransfers {
 			return err
 		}
 		return ErrGenericFailure
@@ -1101,6 +1510,13 @@ func (c *BaseConnection) GetFsAndResolvedPath(virtualPath string) (vfs.Fs, strin
 		return nil, "", err
 	}
 
+	if c.User.Filters.CaseInsensitive {
+		virtualPath, err = c.getCaseInsensitivePath(fs, virtualPath)
+		if err != nil {
+			return nil, "", c.GetFsError(fs, err)
+		}
+	}
+
 	fsPath, err := fs.ResolvePath(virtualPath)
 	if err != nil {
 		return nil, "", c.GetFsError(fs, err)
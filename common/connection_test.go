@@ -1,6 +1,7 @@
 package common
 
 import (
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -31,8 +32,10 @@ func (fs MockOsFs) HasVirtualFolders() bool {
 	return fs.hasVirtualFolders
 }
 
-func (fs MockOsFs) IsUploadResumeSupported() bool {
-	return !fs.hasVirtualFolders
+func (fs MockOsFs) Capabilities() vfs.FsCapabilities {
+	return vfs.FsCapabilities{
+		UploadResume: !fs.hasVirtualFolders,
+	}
 }
 
 func newMockOsFs(hasVirtualFolders bool, connectionID, rootDir string) vfs.Fs {
@@ -102,7 +105,7 @@ func TestRecursiveRenameWalkError(t *testing.T) {
 	fs := vfs.NewOsFs("", os.TempDir(), "")
 	conn := NewBaseConnection("", ProtocolWebDAV, dataprovider.User{})
 	err := conn.checkRecursiveRenameDirPermissions(fs, fs, "/source", "/target")
-	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
 }
 
 func TestCrossRenameFsErrors(t *testing.T) {
@@ -143,6 +146,97 @@ func TestRenameVirtualFolders(t *testing.T) {
 	assert.False(t, res)
 }
 
+func TestListDirLister(t *testing.T) {
+	user := dataprovider.User{
+		Username: userTestUsername,
+		HomeDir:  t.TempDir(),
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: vfs.BaseVirtualFolder{
+			MappedPath: filepath.Join(os.TempDir(), "vdir"),
+		},
+		VirtualPath: "/vdir1/sub",
+	})
+	// the virtual folder replaces the directory with the same name
+	err := os.Mkdir(filepath.Join(user.HomeDir, "vdir1"), os.ModePerm)
+	assert.NoError(t, err)
+	for _, name := range []string{"file1", "file2", "file3"} {
+		err = os.WriteFile(filepath.Join(user.HomeDir, name), []byte("test"), os.ModePerm)
+		assert.NoError(t, err)
+	}
+	conn := NewBaseConnection("", ProtocolSFTP, user)
+	lister, err := conn.ListDirLister("/")
+	if assert.NoError(t, err) {
+		var names []string
+		for {
+			entries, err := lister.Next(1)
+			assert.LessOrEqual(t, len(entries), 1)
+			for _, info := range entries {
+				names = append(names, info.Name())
+			}
+			if err == io.EOF {
+				break
+			}
+			if !assert.NoError(t, err) {
+				break
+			}
+		}
+		assert.NoError(t, lister.Close())
+		assert.ElementsMatch(t, []string{"vdir1", "file1", "file2", "file3"}, names)
+	}
+	_, err = conn.ListDirLister("/missing")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxNoSuchFile)
+
+	user.Permissions["/vdir1"] = []string{dataprovider.PermUpload}
+	conn = NewBaseConnection("", ProtocolSFTP, user)
+	_, err = conn.ListDirLister("/vdir1")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxPermissionDenied)
+}
+
+func TestCaseInsensitivePath(t *testing.T) {
+	if runtime.GOOS == osWindows || runtime.GOOS == "darwin" {
+		t.Skip("this test requires a case sensitive filesystem")
+	}
+	user := dataprovider.User{
+		Username: userTestUsername,
+		HomeDir:  t.TempDir(),
+	}
+	user.Permissions = make(map[string][]string)
+	user.Permissions["/"] = []string{dataprovider.PermAny}
+	user.Filters.CaseInsensitive = true
+	err := os.MkdirAll(filepath.Join(user.HomeDir, "Docs", "Sub"), os.ModePerm)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(user.HomeDir, "Docs", "Sub", "Report.pdf"), []byte("test"), os.ModePerm)
+	assert.NoError(t, err)
+	conn := NewBaseConnection("", ProtocolSFTP, user)
+	_, fsPath, err := conn.GetFsAndResolvedPath("/docs/SUB/report.PDF")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(user.HomeDir, "Docs", "Sub", "Report.pdf"), fsPath)
+	// new files keep the case sent by the client
+	_, fsPath, err = conn.GetFsAndResolvedPath("/DOCS/NewDir/File.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(user.HomeDir, "Docs", "NewDir", "File.txt"), fsPath)
+	_, fsPath, err = conn.GetFsAndResolvedPath("/Docs/Sub")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(user.HomeDir, "Docs", "Sub"), fsPath)
+
+	err = os.WriteFile(filepath.Join(user.HomeDir, "Docs", "Sub", "report.pdf"), []byte("test"), os.ModePerm)
+	assert.NoError(t, err)
+	_, _, err = conn.GetFsAndResolvedPath("/docs/sub/REPORT.pdf")
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	// an exact match is not ambiguous
+	_, fsPath, err = conn.GetFsAndResolvedPath("/docs/sub/report.pdf")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(user.HomeDir, "Docs", "Sub", "report.pdf"), fsPath)
+
+	assert.Equal(t, "/vdir/file", replaceMountPath("/VDir/file", "/vdir"))
+	assert.Equal(t, "/vdir", replaceMountPath("/VDIR", "/vdir"))
+	assert.Equal(t, "/other/file", replaceMountPath("/other/file", "/vdir"))
+	assert.Equal(t, "/file", replaceMountPath("/file", "/"))
+}
+
 func TestUpdateQuotaAfterRename(t *testing.T) {
 	user := dataprovider.User{
 		Username: userTestUsername,
@@ -284,38 +378,58 @@ func TestMaxWriteSize(t *testing.T) {
 	quotaResult := vfs.QuotaCheckResult{
 		HasSpace: true,
 	}
-	size, err := conn.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported())
+	size, err := conn.GetMaxWriteSize(quotaResult, false, 0, fs.Capabilities().UploadResume)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(0), size)
 
 	conn.User.Filters.MaxUploadFileSize = 100
-	size, err = conn.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, false, 0, fs.Capabilities().UploadResume)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(100), size)
 
 	quotaResult.QuotaSize = 1000
-	size, err = conn.GetMaxWriteSize(quotaResult, false, 50, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, false, 50, fs.Capabilities().UploadResume)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(100), size)
 
 	quotaResult.QuotaSize = 1000
 	quotaResult.UsedSize = 990
-	size, err = conn.GetMaxWriteSize(quotaResult, false, 50, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, false, 50, fs.Capabilities().UploadResume)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(60), size)
 
 	quotaResult.QuotaSize = 0
 	quotaResult.UsedSize = 0
-	size, err = conn.GetMaxWriteSize(quotaResult, true, 100, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, true, 100, fs.Capabilities().UploadResume)
 	assert.EqualError(t, err, ErrQuotaExceeded.Error())
 	assert.Equal(t, int64(0), size)
 
-	size, err = conn.GetMaxWriteSize(quotaResult, true, 10, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, true, 10, fs.Capabilities().UploadResume)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(90), size)
 
 	fs = newMockOsFs(true, fs.ConnectionID(), user.GetHomeDir())
-	size, err = conn.GetMaxWriteSize(quotaResult, true, 100, fs.IsUploadResumeSupported())
+	size, err = conn.GetMaxWriteSize(quotaResult, true, 100, fs.Capabilities().UploadResume)
 	assert.EqualError(t, err, ErrOpUnsupported.Error())
 	assert.Equal(t, int64(0), size)
 }
+
+func TestConnectionActionNotification(t *testing.T) {
+	u := dataprovider.User{
+		Username: "test",
+	}
+	conn := NewBaseConnection("id", ProtocolSFTP, u)
+	assert.Empty(t, conn.GetRemoteIP())
+	action := conn.newActionNotification(operationRename, "source", "target", 0, nil)
+	assert.Empty(t, action.IP)
+	assert.Equal(t, conn.GetID(), action.ConnectionID)
+	assert.Equal(t, ProtocolSFTP, action.Protocol)
+	assert.Equal(t, "target", action.TargetPath)
+
+	conn.SetRemoteAddress("192.168.1.10:2222")
+	assert.Equal(t, "192.168.1.10", conn.GetRemoteIP())
+	action = conn.newActionNotification(operationUpload, "path", "", 100, ErrQuotaExceeded)
+	assert.Equal(t, "192.168.1.10", action.IP)
+	assert.Equal(t, int64(100), action.FileSize)
+	assert.Equal(t, 2, action.Status)
+}
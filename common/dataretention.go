@@ -0,0 +1,277 @@
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// ProtocolDataRetention identifies the internal connections used to apply the retention rules
+const ProtocolDataRetention = "DataRetention"
+
+const (
+	retentionLogSender     = "Retention"
+	retentionUsersPageSize = 100
+)
+
+var (
+	retentionTicker      *time.Ticker
+	retentionTickerDone  chan bool
+	retentionCheckActive int32
+	errRetentionActive   = errors.New("a retention check is already in progress")
+)
+
+// DataRetentionConfig defines the configuration for the scheduled checks of the users
+// retention rules
+type DataRetentionConfig struct {
+	// Interval, in hours, between two retention checks. 0 means disabled
+	CheckInterval int `json:"check_interval" mapstructure:"check_interval"`
+	// If enabled the expired files are only reported, nothing is deleted or archived
+	DryRun bool `json:"dry_run" mapstructure:"dry_run"`
+}
+
+// RetentionRuleResult defines the result of a retention rule check
+type RetentionRuleResult struct {
+	Path        string `json:"path"`
+	ArchivePath string `json:"archive_path,omitempty"`
+	// number of expired files deleted/archived, or to delete/archive in dry run mode
+	Files int `json:"files"`
+	// total size of the expired files, as bytes
+	Size int64 `json:"size"`
+	// number of expired files that could not be deleted/archived
+	Errors int `json:"errors"`
+}
+
+// RetentionCheckResult defines the result of a retention check for a user.
+// It is sent as report using the actions hook
+type RetentionCheckResult struct {
+	Username string                `json:"username"`
+	DryRun   bool                  `json:"dry_run"`
+	Results  []RetentionRuleResult `json:"results"`
+	// check start time as unix timestamp in milliseconds
+	StartTime int64 `json:"start_time"`
+	// check duration in milliseconds
+	Elapsed int64 `json:"elapsed"`
+}
+
+// the ticker cannot be started/stopped from multiple goroutines
+func startRetentionTicker(duration time.Duration) {
+	stopRetentionTicker()
+	retentionTicker = time.NewTicker(duration)
+	retentionTickerDone = make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-retentionTickerDone:
+				return
+			case <-retentionTicker.C:
+				CheckRetention() //nolint:errcheck
+			}
+		}
+	}()
+}
+
+func stopRetentionTicker() {
+	if retentionTicker != nil {
+		retentionTicker.Stop()
+		retentionTickerDone <- true
+		retentionTicker = nil
+	}
+}
+
+// CheckRetention applies the retention rules for all the users.
+// Only a check at a time is allowed
+func CheckRetention() error {
+	if !atomic.CompareAndSwapInt32(&retentionCheckActive, 0, 1) {
+		logger.Info(retentionLogSender, "", "unable to start retention check: %v", errRetentionActive)
+		return errRetentionActive
+	}
+	defer atomic.StoreInt32(&retentionCheckActive, 0)
+
+	logger.Debug(retentionLogSender, "", "retention check started, dry run: %v", Config.DataRetention.DryRun)
+	offset := 0
+	for {
+		users, err := dataprovider.GetUsers(retentionUsersPageSize, offset, dataprovider.OrderASC)
+		if err != nil {
+			logger.Warn(retentionLogSender, "", "unable to get users for retention check: %v", err)
+			return err
+		}
+		for idx := range users {
+			if len(users[idx].Filters.Retention) == 0 {
+				continue
+			}
+			// the returned users have their secrets hidden, we need the full user
+			user, err := dataprovider.UserExists(users[idx].Username)
+			if err != nil {
+				logger.Warn(retentionLogSender, "", "unable to get user %#v for retention check: %v", users[idx].Username, err)
+				continue
+			}
+			CheckUserRetention(user, Config.DataRetention.DryRun)
+		}
+		if len(users) < retentionUsersPageSize {
+			break
+		}
+		offset += len(users)
+	}
+	logger.Debug(retentionLogSender, "", "retention check completed")
+	return nil
+}
+
+// CheckUserRetention applies the retention rules for the given user and sends
+// the report using the actions hook
+func CheckUserRetention(user dataprovider.User, dryRun bool) RetentionCheckResult {
+	connection := NewBaseConnection(fmt.Sprintf("%v_%v", ProtocolDataRetention, xid.New().String()),
+		ProtocolDataRetention, user)
+	defer connection.CloseFS() //nolint:errcheck
+
+	startTime := time.Now()
+	result := RetentionCheckResult{
+		Username:  user.Username,
+		DryRun:    dryRun,
+		StartTime: utils.GetTimeAsMsSinceEpoch(startTime),
+	}
+	var totalSize int64
+	var checkErr error
+	for _, rule := range user.Filters.Retention {
+		ruleResult, err := connection.applyRetentionRule(rule, dryRun)
+		if err != nil {
+			checkErr = err
+		}
+		totalSize += ruleResult.Size
+		result.Results = append(result.Results, ruleResult)
+	}
+	result.Elapsed = time.Since(startTime).Milliseconds()
+	connection.Log(logger.LevelInfo, "retention check completed for user %#v, dry run: %v, results: %+v, err: %v",
+		user.Username, dryRun, result.Results, checkErr)
+
+	notification := newActionNotification(&user, operationRetentionCheck, "", "", "", ProtocolDataRetention,
+		totalSize, checkErr)
+	notification.RetentionReport = &result
+	notifyAction(notification)
+
+	return result
+}
+
+type expiredFile struct {
+	fsPath      string
+	virtualPath string
+	size        int64
+	modTime     time.Time
+}
+
+// applyRetentionRule deletes or archives the files not modified within the rule days
+func (c *BaseConnection) applyRetentionRule(rule dataprovider.RetentionRule, dryRun bool) (RetentionRuleResult, error) {
+	result := RetentionRuleResult{
+		Path:        rule.Path,
+		ArchivePath: rule.ArchivePath,
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(rule.Path)
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to resolve retention path %#v: %+v", rule.Path, err)
+		return result, err
+	}
+	limit := time.Now().Add(-time.Duration(rule.Days) * 24 * time.Hour)
+	mountPath := c.User.GetMountPath(rule.Path)
+	var files []expiredFile
+	err = fs.Walk(fsPath, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || info.ModTime().After(limit) || !rule.IsFileMatching(info.Name()) {
+			return nil
+		}
+		virtualPath := fs.GetRelativePath(walkedPath)
+		if c.User.IsReservedPath(virtualPath) || c.User.GetMountPath(virtualPath) != mountPath {
+			return nil
+		}
+		files = append(files, expiredFile{
+			fsPath:      walkedPath,
+			virtualPath: virtualPath,
+			size:        info.Size(),
+			modTime:     info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil && !fs.IsNotExist(err) {
+		c.Log(logger.LevelWarn, "unable to walk retention path %#v: %+v", rule.Path, err)
+		return result, err
+	}
+	for _, file := range files {
+		if dryRun {
+			c.Log(logger.LevelInfo, "dry run, file %#v modified more than %v days ago", file.virtualPath, rule.Days)
+		} else if err := c.removeOrArchiveExpiredFile(fs, file, rule); err != nil {
+			result.Errors++
+			continue
+		}
+		result.Files++
+		result.Size += file.size
+	}
+	if result.Errors > 0 {
+		return result, fmt.Errorf("unable to delete/archive %v files for path %#v", result.Errors, rule.Path)
+	}
+	return result, nil
+}
+
+func (c *BaseConnection) removeOrArchiveExpiredFile(fs vfs.Fs, file expiredFile, rule dataprovider.RetentionRule) error {
+	if rule.ArchiveFolder != "" {
+		return c.moveToArchiveFolder(fs, file, rule.ArchiveFolder)
+	}
+	if rule.ArchivePath == "" {
+		if err := fs.Remove(file.fsPath, false); err != nil {
+			c.Log(logger.LevelWarn, "unable to remove expired file %#v: %+v", file.fsPath, err)
+			return err
+		}
+		logger.CommandLog(removeLogSender, file.fsPath, "", c.User.Username, "", c.ID, c.protocol, -1, -1,
+			"", "", "", -1)
+		c.updateQuotaForPath(file.virtualPath, -1, -file.size)
+		return nil
+	}
+	// the walked paths are inside the rule path, the case could differ for case insensitive users
+	targetPath := path.Join(rule.ArchivePath, file.virtualPath[len(rule.Path):])
+	if c.User.GetMountPath(targetPath) != c.User.GetMountPath(file.virtualPath) {
+		err := fmt.Errorf("archive path %#v is not on the same filesystem of %#v", targetPath, file.virtualPath)
+		c.Log(logger.LevelWarn, "unable to archive expired file: %v", err)
+		return err
+	}
+	_, fsTargetPath, err := c.GetFsAndResolvedPath(targetPath)
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to resolve archive path %#v: %+v", targetPath, err)
+		return err
+	}
+	if err := c.createParentDirs(fs, fsTargetPath); err != nil {
+		c.Log(logger.LevelWarn, "unable to create archive dir for %#v: %+v", fsTargetPath, err)
+		return err
+	}
+	if err := fs.Rename(file.fsPath, fsTargetPath); err != nil {
+		c.Log(logger.LevelWarn, "unable to archive expired file %#v: %+v", file.fsPath, err)
+		return err
+	}
+	logger.CommandLog(renameLogSender, file.fsPath, fsTargetPath, c.User.Username, "", c.ID, c.protocol, -1, -1,
+		"", "", "", -1)
+	// the file is still inside the same filesystem, only the directory quotas change
+	dataprovider.UpdateDirQuota(&c.User, file.virtualPath, -1, -file.size)
+	dataprovider.UpdateDirQuota(&c.User, targetPath, 1, file.size)
+	return nil
+}
+
+func getRetentionReportAsJSON(report *RetentionCheckResult) string {
+	if report == nil {
+		return ""
+	}
+	data, err := json.Marshal(report)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
@@ -2,15 +2,18 @@ package common
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/yl2chen/cidranger"
 
+	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/utils"
 )
@@ -26,6 +29,14 @@ const (
 	HostEventRateExceeded
 )
 
+// Supported defender drivers
+const (
+	DefenderDriverMemory   = "memory"
+	DefenderDriverProvider = "provider"
+)
+
+var supportedDefenderDrivers = []string{DefenderDriverMemory, DefenderDriverProvider}
+
 // Defender defines the interface that a defender must implements
 type Defender interface {
 	AddEvent(ip string, event HostEvent)
@@ -34,12 +45,17 @@ type Defender interface {
 	GetScore(ip string) int
 	Unban(ip string) bool
 	Reload() error
+	ReloadIfChanged() error
 }
 
 // DefenderConfig defines the "defender" configuration
 type DefenderConfig struct {
 	// Set to true to enable the defender
 	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Defender implementation to use, "memory" or "provider". The "provider" driver
+	// stores banned hosts and scores within the data provider, so they survive restarts
+	// and can be shared among multiple instances
+	Driver string `json:"driver" mapstructure:"driver"`
 	// BanTime is the number of minutes that a host is banned
 	BanTime int `json:"ban_time" mapstructure:"ban_time"`
 	// Percentage increase of the ban time if a banned host tries to connect again
@@ -58,26 +74,196 @@ type DefenderConfig struct {
 	// the last observation time minutes
 	ObservationTime int `json:"observation_time" mapstructure:"observation_time"`
 	// The number of banned IPs and host scores kept in memory will vary between the
-	// soft and hard limit
+	// soft and hard limit. They are ignored for the "provider" driver
 	EntriesSoftLimit int `json:"entries_soft_limit" mapstructure:"entries_soft_limit"`
 	EntriesHardLimit int `json:"entries_hard_limit" mapstructure:"entries_hard_limit"`
 	// Path to a file containing a list of ip addresses and/or networks to never ban
 	SafeListFile string `json:"safelist_file" mapstructure:"safelist_file"`
 	// Path to a file containing a list of ip addresses and/or networks to always ban
 	BlockListFile string `json:"blocklist_file" mapstructure:"blocklist_file"`
+	// Interval, in seconds, to check for changes to the safe and block lists.
+	// The list files are reloaded if their modification time changes and the
+	// entries stored within the data provider are reloaded if they change.
+	// 0 means disabled, the lists can be reloaded using a signal or the REST API
+	ListsCheckInterval int `json:"lists_check_interval" mapstructure:"lists_check_interval"`
 }
 
-type memoryDefender struct {
+type baseDefender struct {
 	config *DefenderConfig
 	sync.RWMutex
+	safeList          *HostList
+	blockList         *HostList
+	providerSafeList  *HostList
+	providerBlockList *HostList
+	// reloadMu serializes the reloads and protects the fields below
+	reloadMu          sync.Mutex
+	safeListModTime   time.Time
+	blockListModTime  time.Time
+	providerListsHash string
+}
+
+// Reload reloads block and safe lists
+func (d *baseDefender) Reload() error {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	if err := d.reloadFileLists(false); err != nil {
+		return err
+	}
+
+	return d.reloadProviderLists(false)
+}
+
+// ReloadIfChanged reloads the block and safe lists files if their modification
+// time changed and the lists stored within the data provider if they changed
+func (d *baseDefender) ReloadIfChanged() error {
+	d.reloadMu.Lock()
+	defer d.reloadMu.Unlock()
+
+	if err := d.reloadFileLists(true); err != nil {
+		return err
+	}
+
+	return d.reloadProviderLists(true)
+}
+
+func (d *baseDefender) reloadFileLists(onlyIfChanged bool) error {
+	blockListModTime := getHostListFileModTime(d.config.BlockListFile)
+	if !onlyIfChanged || !blockListModTime.Equal(d.blockListModTime) {
+		blockList, err := loadHostListFromFile(d.config.BlockListFile)
+		if err != nil {
+			return err
+		}
+
+		d.Lock()
+		d.blockList = blockList
+		d.Unlock()
+		d.blockListModTime = blockListModTime
+	}
+
+	safeListModTime := getHostListFileModTime(d.config.SafeListFile)
+	if !onlyIfChanged || !safeListModTime.Equal(d.safeListModTime) {
+		safeList, err := loadHostListFromFile(d.config.SafeListFile)
+		if err != nil {
+			return err
+		}
+
+		d.Lock()
+		d.safeList = safeList
+		d.Unlock()
+		d.safeListModTime = safeListModTime
+	}
+
+	return nil
+}
+
+func (d *baseDefender) reloadProviderLists(onlyIfChanged bool) error {
+	entries, err := dataprovider.GetDefenderListEntries()
+	if err != nil {
+		if errors.Is(err, dataprovider.ErrProviderNotInitialized) {
+			// the data provider is initialized after the defender, the lists
+			// will be loaded on the next reload
+			return nil
+		}
+		return err
+	}
+
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		keys = append(keys, fmt.Sprintf("%v:%v", entry.Type, entry.Network))
+	}
+	sort.Strings(keys)
+	hash := strings.Join(keys, ",")
+	if onlyIfChanged && hash == d.providerListsHash {
+		return nil
+	}
+
+	var safeIPs, safeNetworks, blockIPs, blockNetworks []string
+	for _, entry := range entries {
+		isNetwork := strings.Contains(entry.Network, "/")
+		switch {
+		case entry.Type == dataprovider.DefenderSafeList && isNetwork:
+			safeNetworks = append(safeNetworks, entry.Network)
+		case entry.Type == dataprovider.DefenderSafeList:
+			safeIPs = append(safeIPs, entry.Network)
+		case entry.Type == dataprovider.DefenderBlockList && isNetwork:
+			blockNetworks = append(blockNetworks, entry.Network)
+		case entry.Type == dataprovider.DefenderBlockList:
+			blockIPs = append(blockIPs, entry.Network)
+		}
+	}
+
+	safeList := newHostList("provider safe list", safeIPs, safeNetworks)
+	blockList := newHostList("provider block list", blockIPs, blockNetworks)
+
+	d.Lock()
+	d.providerSafeList = safeList
+	d.providerBlockList = blockList
+	d.Unlock()
+	d.providerListsHash = hash
+
+	return nil
+}
+
+func (d *baseDefender) isBlockListed(ip string) bool {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.isBlockListedLocked(ip)
+}
+
+func (d *baseDefender) isSafeListed(ip string) bool {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.isSafeListedLocked(ip)
+}
+
+// isBlockListedLocked must be called while holding the lock
+func (d *baseDefender) isBlockListedLocked(ip string) bool {
+	return (d.blockList != nil && d.blockList.isListed(ip)) ||
+		(d.providerBlockList != nil && d.providerBlockList.isListed(ip))
+}
+
+// isSafeListedLocked must be called while holding the lock
+func (d *baseDefender) isSafeListedLocked(ip string) bool {
+	return (d.safeList != nil && d.safeList.isListed(ip)) ||
+		(d.providerSafeList != nil && d.providerSafeList.isListed(ip))
+}
+
+func (d *baseDefender) getScore(event HostEvent) int {
+	var score int
+
+	switch event {
+	case HostEventLoginFailed:
+		score = d.config.ScoreValid
+	case HostEventRateExceeded:
+		score = d.config.ScoreRateExceeded
+	case HostEventUserNotFound, HostEventNoLoginTried:
+		score = d.config.ScoreInvalid
+	}
+
+	return score
+}
+
+// getBanIncrement returns the minutes to add to the ban time if a banned host tries to connect again
+func (d *baseDefender) getBanIncrement() int {
+	increment := d.config.BanTime * d.config.BanTimeIncrement / 100
+	if increment == 0 {
+		increment++
+	}
+
+	return increment
+}
+
+type memoryDefender struct {
+	baseDefender
 	// IP addresses of the clients trying to connected are stored inside hosts,
 	// they are added to banned once the thresold is reached.
 	// A violation from a banned host will increase the ban time
 	// based on the configured BanTimeIncrement
-	hosts     map[string]hostScore // the key is the host IP
-	banned    map[string]time.Time // the key is the host IP
-	safeList  *HostList
-	blockList *HostList
+	hosts  map[string]hostScore // the key is the host IP
+	banned map[string]time.Time // the key is the host IP
 }
 
 // HostListFile defines the structure expected for safe/block list files
@@ -120,6 +306,9 @@ func (c *DefenderConfig) validate() error {
 	if !c.Enabled {
 		return nil
 	}
+	if c.Driver != "" && !utils.IsStringInSlice(c.Driver, supportedDefenderDrivers) {
+		return fmt.Errorf("unsupported defender driver %#v", c.Driver)
+	}
 	if c.ScoreInvalid >= c.Threshold {
 		return fmt.Errorf("score_invalid %v cannot be greater than threshold %v", c.ScoreInvalid, c.Threshold)
 	}
@@ -154,7 +343,9 @@ func newInMemoryDefender(config *DefenderConfig) (Defender, error) {
 		return nil, err
 	}
 	defender := &memoryDefender{
-		config: config,
+		baseDefender: baseDefender{
+			config: config,
+		},
 		hosts:  make(map[string]hostScore),
 		banned: make(map[string]time.Time),
 	}
@@ -166,29 +357,6 @@ func newInMemoryDefender(config *DefenderConfig) (Defender, error) {
 	return defender, nil
 }
 
-// Reload reloads block and safe lists
-func (d *memoryDefender) Reload() error {
-	blockList, err := loadHostListFromFile(d.config.BlockListFile)
-	if err != nil {
-		return err
-	}
-
-	d.Lock()
-	d.blockList = blockList
-	d.Unlock()
-
-	safeList, err := loadHostListFromFile(d.config.SafeListFile)
-	if err != nil {
-		return err
-	}
-
-	d.Lock()
-	d.safeList = safeList
-	d.Unlock()
-
-	return nil
-}
-
 // IsBanned returns true if the specified IP is banned
 // and increase ban time if the IP is found.
 // This method must be called as soon as the client connects
@@ -197,10 +365,7 @@ func (d *memoryDefender) IsBanned(ip string) bool {
 
 	if banTime, ok := d.banned[ip]; ok {
 		if banTime.After(time.Now()) {
-			increment := d.config.BanTime * d.config.BanTimeIncrement / 100
-			if increment == 0 {
-				increment++
-			}
+			increment := d.getBanIncrement()
 
 			d.RUnlock()
 
@@ -218,7 +383,7 @@ func (d *memoryDefender) IsBanned(ip string) bool {
 
 	defer d.RUnlock()
 
-	if d.blockList != nil && d.blockList.isListed(ip) {
+	if d.isBlockListedLocked(ip) {
 		// permanent ban
 		return true
 	}
@@ -245,24 +410,13 @@ func (d *memoryDefender) AddEvent(ip string, event HostEvent) {
 	d.Lock()
 	defer d.Unlock()
 
-	if d.safeList != nil && d.safeList.isListed(ip) {
+	if d.isSafeListedLocked(ip) {
 		return
 	}
 
-	var score int
-
-	switch event {
-	case HostEventLoginFailed:
-		score = d.config.ScoreValid
-	case HostEventRateExceeded:
-		score = d.config.ScoreRateExceeded
-	case HostEventUserNotFound, HostEventNoLoginTried:
-		score = d.config.ScoreInvalid
-	}
-
 	ev := hostEvent{
 		dateTime: time.Now(),
-		score:    score,
+		score:    d.getScore(event),
 	}
 
 	if hs, ok := d.hosts[ip]; ok {
@@ -280,8 +434,10 @@ func (d *memoryDefender) AddEvent(ip string, event HostEvent) {
 
 		hs.Events = hs.Events[:idx]
 		if hs.TotalScore >= d.config.Threshold {
-			d.banned[ip] = time.Now().Add(time.Duration(d.config.BanTime) * time.Minute)
+			banTime := time.Now().Add(time.Duration(d.config.BanTime) * time.Minute)
+			d.banned[ip] = banTime
 			delete(d.hosts, ip)
+			logger.AuditHostBanned(ip, banTime)
 			d.cleanupBanned()
 		} else {
 			d.hosts[ip] = hs
@@ -432,39 +588,58 @@ func loadHostListFromFile(name string) (*HostList, error) {
 		return nil, err
 	}
 
-	if len(hostList.CIDRNetworks) > 0 || len(hostList.IPAddresses) > 0 {
-		result := &HostList{
-			IPAddresses: make(map[string]bool),
-			Ranges:      cidranger.NewPCTrieRanger(),
+	return newHostList(fmt.Sprintf("%#v", name), hostList.IPAddresses, hostList.CIDRNetworks), nil
+}
+
+// newHostList builds a host list from the given IP addresses and CIDR networks.
+// Invalid entries are logged and skipped, nil is returned if there are no entries
+func newHostList(name string, ipAddresses, cidrNetworks []string) *HostList {
+	if len(cidrNetworks) == 0 && len(ipAddresses) == 0 {
+		return nil
+	}
+
+	result := &HostList{
+		IPAddresses: make(map[string]bool),
+		Ranges:      cidranger.NewPCTrieRanger(),
+	}
+	ipCount := 0
+	cdrCount := 0
+	for _, ip := range ipAddresses {
+		if net.ParseIP(ip) == nil {
+			logger.Warn(logSender, "", "unable to parse IP %#v", ip)
+			continue
 		}
-		ipCount := 0
-		cdrCount := 0
-		for _, ip := range hostList.IPAddresses {
-			if net.ParseIP(ip) == nil {
-				logger.Warn(logSender, "", "unable to parse IP %#v", ip)
-				continue
-			}
-			result.IPAddresses[ip] = true
-			ipCount++
+		result.IPAddresses[ip] = true
+		ipCount++
+	}
+	for _, cidrNet := range cidrNetworks {
+		_, network, err := net.ParseCIDR(cidrNet)
+		if err != nil {
+			logger.Warn(logSender, "", "unable to parse CIDR network %#v", cidrNet)
+			continue
 		}
-		for _, cidrNet := range hostList.CIDRNetworks {
-			_, network, err := net.ParseCIDR(cidrNet)
-			if err != nil {
-				logger.Warn(logSender, "", "unable to parse CIDR network %#v", cidrNet)
-				continue
-			}
-			err = result.Ranges.Insert(cidranger.NewBasicRangerEntry(*network))
-			if err == nil {
-				cdrCount++
-			}
+		err = result.Ranges.Insert(cidranger.NewBasicRangerEntry(*network))
+		if err == nil {
+			cdrCount++
 		}
-
-		logger.Info(logSender, "", "list %#v loaded, ip addresses loaded: %v/%v networks loaded: %v/%v",
-			name, ipCount, len(hostList.IPAddresses), cdrCount, len(hostList.CIDRNetworks))
-		return result, nil
 	}
 
-	return nil, nil
+	logger.Info(logSender, "", "list %v loaded, ip addresses loaded: %v/%v networks loaded: %v/%v",
+		name, ipCount, len(ipAddresses), cdrCount, len(cidrNetworks))
+	return result
+}
+
+// getHostListFileModTime returns the modification time for the given host list
+// file or the zero time if the file is not configured or cannot be accessed
+func getHostListFileModTime(name string) time.Time {
+	if name == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
 }
 
 type kv struct {
@@ -14,6 +14,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/yl2chen/cidranger"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
 )
 
 func TestBasicDefender(t *testing.T) {
@@ -152,6 +155,136 @@ func TestBasicDefender(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDBDefender(t *testing.T) {
+	config := &DefenderConfig{
+		Enabled:           true,
+		Driver:            DefenderDriverProvider,
+		BanTime:           10,
+		BanTimeIncrement:  50,
+		Threshold:         5,
+		ScoreInvalid:      2,
+		ScoreValid:        1,
+		ScoreRateExceeded: 3,
+		ObservationTime:   15,
+		EntriesSoftLimit:  1,
+		EntriesHardLimit:  2,
+	}
+	d, err := newDBDefender(config)
+	require.NoError(t, err)
+
+	ip := "172.16.2.1"
+	assert.False(t, d.IsBanned(ip))
+	assert.Nil(t, d.GetBanTime(ip))
+	assert.Equal(t, 0, d.GetScore(ip))
+	assert.False(t, d.Unban(ip))
+
+	d.AddEvent(ip, HostEventLoginFailed)
+	d.AddEvent(ip, HostEventUserNotFound)
+	assert.Equal(t, 3, d.GetScore(ip))
+	assert.False(t, d.IsBanned(ip))
+	assert.Nil(t, d.GetBanTime(ip))
+
+	d.AddEvent(ip, HostEventRateExceeded)
+	assert.True(t, d.IsBanned(ip))
+	assert.Equal(t, 0, d.GetScore(ip))
+	banTime := d.GetBanTime(ip)
+	if assert.NotNil(t, banTime) {
+		// the ban time was incremented by 5 minutes checking if the host is banned
+		assert.True(t, banTime.After(time.Now().Add(14*time.Minute)))
+	}
+	assert.True(t, d.Unban(ip))
+	assert.False(t, d.IsBanned(ip))
+	assert.Nil(t, d.GetBanTime(ip))
+
+	d.AddEvent(ip, HostEventNoLoginTried)
+	assert.Equal(t, 2, d.GetScore(ip))
+	// events outside the observation time are removed
+	err = dataprovider.CleanupDefender(utils.GetTimeAsMsSinceEpoch(time.Now().Add(time.Minute)))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, d.GetScore(ip))
+	_, err = dataprovider.GetDefenderHostByIP(ip, 0)
+	assert.Error(t, err)
+}
+
+func TestDefenderListsReload(t *testing.T) {
+	blockListFile := filepath.Join(os.TempDir(), "defender_blocklist.json")
+	hl := HostListFile{
+		IPAddresses: []string{"172.16.3.1"},
+	}
+	asJSON, err := json.Marshal(hl)
+	assert.NoError(t, err)
+	err = os.WriteFile(blockListFile, asJSON, os.ModePerm)
+	assert.NoError(t, err)
+
+	config := &DefenderConfig{
+		Enabled:           true,
+		BanTime:           10,
+		BanTimeIncrement:  2,
+		Threshold:         5,
+		ScoreInvalid:      2,
+		ScoreValid:        1,
+		ScoreRateExceeded: 3,
+		ObservationTime:   15,
+		EntriesSoftLimit:  1,
+		EntriesHardLimit:  2,
+		BlockListFile:     blockListFile,
+	}
+	d, err := newInMemoryDefender(config)
+	require.NoError(t, err)
+	assert.True(t, d.IsBanned("172.16.3.1"))
+	assert.False(t, d.IsBanned("172.16.3.2"))
+
+	hl.IPAddresses = []string{"172.16.3.2"}
+	asJSON, err = json.Marshal(hl)
+	assert.NoError(t, err)
+	err = os.WriteFile(blockListFile, asJSON, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.Chtimes(blockListFile, time.Now(), time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	err = d.ReloadIfChanged()
+	assert.NoError(t, err)
+	assert.False(t, d.IsBanned("172.16.3.1"))
+	assert.True(t, d.IsBanned("172.16.3.2"))
+
+	blockEntry := dataprovider.DefenderListEntry{
+		Type:    dataprovider.DefenderBlockList,
+		Network: "10.8.0.0/24",
+	}
+	safeEntry := dataprovider.DefenderListEntry{
+		Type:    dataprovider.DefenderSafeList,
+		Network: "10.9.0.1",
+	}
+	err = dataprovider.AddDefenderListEntry(&blockEntry)
+	assert.NoError(t, err)
+	err = dataprovider.AddDefenderListEntry(&safeEntry)
+	assert.NoError(t, err)
+	assert.False(t, d.IsBanned("10.8.0.10"))
+	err = d.ReloadIfChanged()
+	assert.NoError(t, err)
+	assert.True(t, d.IsBanned("10.8.0.10"))
+	assert.False(t, d.IsBanned("10.8.1.10"))
+
+	d.AddEvent("10.9.0.1", HostEventUserNotFound)
+	assert.Equal(t, 0, d.GetScore("10.9.0.1"))
+
+	err = dataprovider.DeleteDefenderListEntry(&blockEntry)
+	assert.NoError(t, err)
+	err = dataprovider.DeleteDefenderListEntry(&safeEntry)
+	assert.NoError(t, err)
+	err = d.ReloadIfChanged()
+	assert.NoError(t, err)
+	assert.False(t, d.IsBanned("10.8.0.10"))
+	d.AddEvent("10.9.0.1", HostEventUserNotFound)
+	assert.Equal(t, 2, d.GetScore("10.9.0.1"))
+
+	err = os.Remove(blockListFile)
+	assert.NoError(t, err)
+	err = d.ReloadIfChanged()
+	assert.Error(t, err)
+	// the previous list is preserved
+	assert.True(t, d.IsBanned("172.16.3.2"))
+}
+
 func TestLoadHostListFromFile(t *testing.T) {
 	_, err := loadHostListFromFile(".")
 	assert.Error(t, err)
@@ -229,10 +362,12 @@ func TestDefenderCleanup(t *testing.T) {
 	d := memoryDefender{
 		banned: make(map[string]time.Time),
 		hosts:  make(map[string]hostScore),
-		config: &DefenderConfig{
-			ObservationTime:  1,
-			EntriesSoftLimit: 2,
-			EntriesHardLimit: 3,
+		baseDefender: baseDefender{
+			config: &DefenderConfig{
+				ObservationTime:  1,
+				EntriesSoftLimit: 2,
+				EntriesHardLimit: 3,
+			},
 		},
 	}
 
@@ -513,7 +648,9 @@ func getDefenderForBench() *memoryDefender {
 		EntriesHardLimit: 100,
 	}
 	return &memoryDefender{
-		config: config,
+		baseDefender: baseDefender{
+			config: config,
+		},
 		hosts:  make(map[string]hostScore),
 		banned: make(map[string]time.Time),
 	}
@@ -0,0 +1,140 @@
+package common
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// dbDefender stores the hosts scores and the banned hosts within the data provider,
+// this way they are preserved after a restart and shared among multiple instances
+type dbDefender struct {
+	baseDefender
+	// last cleanup as unix timestamp in nanoseconds, must be accessed atomically
+	lastCleanup int64
+}
+
+func newDBDefender(config *DefenderConfig) (Defender, error) {
+	err := config.validate()
+	if err != nil {
+		return nil, err
+	}
+	defender := &dbDefender{
+		baseDefender: baseDefender{
+			config: config,
+		},
+	}
+
+	if err := defender.Reload(); err != nil {
+		return nil, err
+	}
+
+	return defender, nil
+}
+
+// IsBanned returns true if the specified IP is banned
+// and increase ban time if the IP is found.
+// This method must be called as soon as the client connects
+func (d *dbDefender) IsBanned(ip string) bool {
+	if d.isBlockListed(ip) {
+		// permanent ban
+		return true
+	}
+
+	_, err := dataprovider.IsDefenderHostBanned(ip)
+	if err != nil {
+		return false
+	}
+
+	if err := dataprovider.UpdateDefenderBanTime(ip, d.getBanIncrement()); err != nil {
+		logger.Warn(logSender, "", "unable to update ban time for host %#v: %v", ip, err)
+	}
+
+	return true
+}
+
+// Unban removes the specified IP address from the banned ones
+func (d *dbDefender) Unban(ip string) bool {
+	if _, err := dataprovider.IsDefenderHostBanned(ip); err != nil {
+		return false
+	}
+
+	return dataprovider.DeleteDefenderHost(ip) == nil
+}
+
+// AddEvent adds an event for the given IP.
+// This method must be called for clients not yet banned
+func (d *dbDefender) AddEvent(ip string, event HostEvent) {
+	if d.isSafeListed(ip) {
+		return
+	}
+
+	if err := dataprovider.AddDefenderEvent(ip, d.getScore(event)); err != nil {
+		logger.Warn(logSender, "", "unable to add defender event for host %#v: %v", ip, err)
+		return
+	}
+
+	host, err := dataprovider.GetDefenderHostByIP(ip, d.getStartObservationTime())
+	if err != nil {
+		logger.Warn(logSender, "", "unable to get defender score for host %#v: %v", ip, err)
+		return
+	}
+
+	if host.Score >= d.config.Threshold {
+		banTime := time.Now().Add(time.Duration(d.config.BanTime) * time.Minute)
+		if err := dataprovider.SetDefenderBanTime(ip, utils.GetTimeAsMsSinceEpoch(banTime)); err != nil {
+			logger.Warn(logSender, "", "unable to ban host %#v: %v", ip, err)
+		} else {
+			logger.AuditHostBanned(ip, banTime)
+		}
+	}
+
+	d.cleanup()
+}
+
+// GetBanTime returns the ban time for the given IP or nil if the IP is not banned
+func (d *dbDefender) GetBanTime(ip string) *time.Time {
+	host, err := dataprovider.GetDefenderHostByIP(ip, d.getStartObservationTime())
+	if err != nil {
+		return nil
+	}
+
+	return host.GetBanTime()
+}
+
+// GetScore returns the score for the given IP
+func (d *dbDefender) GetScore(ip string) int {
+	host, err := dataprovider.GetDefenderHostByIP(ip, d.getStartObservationTime())
+	if err != nil {
+		return 0
+	}
+
+	return host.Score
+}
+
+// getStartObservationTime returns the start of the observation window
+// as unix timestamp in milliseconds
+func (d *dbDefender) getStartObservationTime() int64 {
+	return utils.GetTimeAsMsSinceEpoch(time.Now().Add(-time.Duration(d.config.ObservationTime) * time.Minute))
+}
+
+// cleanup removes the expired events and hosts. It runs, at most, once for
+// each observation time. Concurrent cleanups from multiple instances are harmless
+func (d *dbDefender) cleanup() {
+	lastCleanup := atomic.LoadInt64(&d.lastCleanup)
+	now := time.Now()
+	if now.Sub(time.Unix(0, lastCleanup)) < time.Duration(d.config.ObservationTime)*time.Minute {
+		return
+	}
+	if !atomic.CompareAndSwapInt64(&d.lastCleanup, lastCleanup, now.UnixNano()) {
+		return
+	}
+
+	if err := dataprovider.CleanupDefender(d.getStartObservationTime()); err != nil {
+		logger.Warn(logSender, "", "unable to cleanup defender hosts: %v", err)
+		atomic.StoreInt64(&d.lastCleanup, lastCleanup)
+	}
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// connectionDirLister wraps the lister for a filesystem directory adding the
+// virtual folders and the archived files and hiding the directories reserved
+// for internal usage
+type connectionDirLister struct {
+	lister      vfs.DirLister
+	conn        *BaseConnection
+	fs          vfs.Fs
+	virtualPath string
+	// names for the virtual folders inside the listed directory, they replace
+	// the filesystem entries with the same name
+	virtualDirs map[string]bool
+	// archived files inside the listed directory, they are returned after the
+	// filesystem entries if a filesystem entry with the same name does not exist
+	archived      []os.FileInfo
+	archivedNames map[string]bool
+	pending       []os.FileInfo
+	eof           bool
+}
+
+func newConnectionDirLister(conn *BaseConnection, fs vfs.Fs, lister vfs.DirLister, virtualPath string) *connectionDirLister {
+	l := &connectionDirLister{
+		lister:      lister,
+		conn:        conn,
+		fs:          fs,
+		virtualPath: virtualPath,
+		virtualDirs: make(map[string]bool),
+	}
+	// virtual folders are returned first so we don't need to keep track of
+	// the filesystem entries already returned
+	for dir := range conn.User.GetVirtualFoldersInPath(virtualPath) {
+		info := vfs.NewFileInfo(dir, true, 0, time.Now(), false)
+		l.virtualDirs[info.Name()] = true
+		l.pending = append(l.pending, info)
+	}
+	l.archived = conn.getArchivedEntries(fs, virtualPath)
+	if len(l.archived) > 0 {
+		l.archivedNames = make(map[string]bool)
+		for _, info := range l.archived {
+			l.archivedNames[info.Name()] = true
+		}
+	}
+	return l
+}
+
+// Next implements the vfs.DirLister interface
+func (l *connectionDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if !l.eof && len(l.pending) < limit {
+		entries, err := l.lister.Next(limit - len(l.pending))
+		if err != nil && err != io.EOF {
+			l.conn.Log(logger.LevelWarn, "error listing directory: %+v", err)
+			return nil, l.conn.GetFsError(l.fs, err)
+		}
+		l.eof = err == io.EOF
+		for _, info := range l.conn.hideReservedDirs(entries, l.virtualPath) {
+			if _, ok := l.virtualDirs[info.Name()]; !ok {
+				l.pending = append(l.pending, info)
+				delete(l.archivedNames, info.Name())
+			}
+		}
+		if l.eof {
+			for _, info := range l.archived {
+				if l.archivedNames[info.Name()] {
+					l.pending = append(l.pending, info)
+				}
+			}
+			l.archived = nil
+		}
+	}
+	n := limit
+	if n > len(l.pending) {
+		n = len(l.pending)
+	}
+	result := l.pending[:n]
+	l.pending = l.pending[n:]
+	if l.eof && len(l.pending) == 0 {
+		return result, io.EOF
+	}
+	return result, nil
+}
+
+// Close implements the vfs.DirLister interface
+func (l *connectionDirLister) Close() error {
+	l.pending = nil
+	return l.lister.Close()
+}
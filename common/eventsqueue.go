@@ -0,0 +1,246 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/plugin"
+)
+
+const (
+	eventsQueueLogSender = "EventsQueue"
+	// minimum lease for the claimed events, the leases are renewed at each check
+	// interval so the events are not claimed by other instances while delivered
+	eventsQueueMinLease = 2 * time.Minute
+)
+
+var eventsQueue struct {
+	sync.RWMutex
+	manager *eventsQueueManager
+}
+
+// EventsQueueConfig defines the configuration for the persistent queue used
+// to deliver the action notifications
+type EventsQueueConfig struct {
+	// If enabled the action notifications are stored within the data provider and
+	// delivered by a pool of workers. This way a burst of notifications cannot start
+	// too many concurrent hooks and the queued notifications survive a restart
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Number of workers delivering the queued notifications
+	Workers int `json:"workers" mapstructure:"workers"`
+	// Interval, in seconds, between two checks for queued notifications, new
+	// notifications are delivered immediately, this check is needed to deliver
+	// the ones queued before a restart
+	CheckInterval int `json:"check_interval" mapstructure:"check_interval"`
+}
+
+func (c *EventsQueueConfig) validate() error {
+	if c.Workers < 1 {
+		return fmt.Errorf("invalid number of workers: %v", c.Workers)
+	}
+	if c.CheckInterval < 1 {
+		return fmt.Errorf("invalid check interval: %v", c.CheckInterval)
+	}
+	return nil
+}
+
+// eventsQueueManager delivers the queued events. The events are claimed, within
+// the data provider, before the delivery, so each event is delivered by a single
+// instance even if multiple instances share the same data provider
+type eventsQueueManager struct {
+	// unique identifier for the events claimed by this manager
+	owner         string
+	workers       int
+	checkInterval time.Duration
+	lease         time.Duration
+	events        chan dataprovider.QueuedEvent
+	wakeUp        chan bool
+	done          chan bool
+}
+
+func newEventsQueueManager(config *EventsQueueConfig) *eventsQueueManager {
+	checkInterval := time.Duration(config.CheckInterval) * time.Second
+	lease := 3 * checkInterval
+	if lease < eventsQueueMinLease {
+		lease = eventsQueueMinLease
+	}
+	return &eventsQueueManager{
+		owner:         xid.New().String(),
+		workers:       config.Workers,
+		checkInterval: checkInterval,
+		lease:         lease,
+		events:        make(chan dataprovider.QueuedEvent, config.Workers),
+		wakeUp:        make(chan bool, 1),
+		done:          make(chan bool),
+	}
+}
+
+func startEventsQueue(config *EventsQueueConfig) {
+	eventsQueue.Lock()
+	defer eventsQueue.Unlock()
+
+	if eventsQueue.manager != nil {
+		eventsQueue.manager.stop()
+	}
+	q := newEventsQueueManager(config)
+	q.start()
+	eventsQueue.manager = q
+	logger.Info(eventsQueueLogSender, "", "events queue started with config %+v, owner: %v", *config, q.owner)
+}
+
+func stopEventsQueue() {
+	eventsQueue.Lock()
+	defer eventsQueue.Unlock()
+
+	if eventsQueue.manager != nil {
+		eventsQueue.manager.stop()
+		eventsQueue.manager = nil
+	}
+}
+
+func getEventsQueue() *eventsQueueManager {
+	eventsQueue.RLock()
+	defer eventsQueue.RUnlock()
+
+	return eventsQueue.manager
+}
+
+// notifyAction delivers the given notification asynchronously. If the events queue is
+// enabled the notification is stored within the data provider and delivered by the
+// queue workers, if it cannot be queued it is delivered directly
+func notifyAction(notification *ActionNotification) {
+	plugin.Handler.NotifyFsEvent(notification.getPluginEvent())
+
+	if _, ok := actionHandler.(*defaultActionHandler); ok {
+		if err := Config.Actions.checkNotification(notification); err != nil {
+			return
+		}
+	}
+	if q := getEventsQueue(); q != nil {
+		if err := q.add(notification); err == nil {
+			return
+		}
+	}
+	go executeAction(notification)
+}
+
+func (q *eventsQueueManager) add(notification *ActionNotification) error {
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		logger.Warn(eventsQueueLogSender, "", "unable to serialize notification for operation %#v: %v",
+			notification.Action, err)
+		return err
+	}
+	if err := dataprovider.AddQueuedEvent(string(payload)); err != nil {
+		logger.Warn(eventsQueueLogSender, "", "unable to queue notification for operation %#v, it will be delivered directly: %v",
+			notification.Action, err)
+		return err
+	}
+	select {
+	case q.wakeUp <- true:
+	default:
+	}
+	return nil
+}
+
+func (q *eventsQueueManager) start() {
+	for i := 0; i < q.workers; i++ {
+		go q.work()
+	}
+	go q.dispatch()
+	go q.renewLeases()
+}
+
+func (q *eventsQueueManager) stop() {
+	close(q.done)
+}
+
+func (q *eventsQueueManager) dispatch() {
+	ticker := time.NewTicker(q.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		q.loadEvents()
+		select {
+		case <-q.done:
+			return
+		case <-q.wakeUp:
+		case <-ticker.C:
+		}
+	}
+}
+
+// renewLeases extends the lease for the events claimed by this manager, they
+// could wait for a free worker or be delivered for a long time if retried
+func (q *eventsQueueManager) renewLeases() {
+	ticker := time.NewTicker(q.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-ticker.C:
+			if err := dataprovider.RenewQueuedEventsLease(q.owner, q.lease); err != nil {
+				logger.Warn(eventsQueueLogSender, "", "unable to renew the lease for the claimed events: %v", err)
+			}
+		}
+	}
+}
+
+// loadEvents claims the queued events and sends them to the workers. At most
+// one event for each worker is claimed at a time, so the other instances
+// sharing the data provider can deliver the remaining ones.
+// It blocks if all the workers are busy
+func (q *eventsQueueManager) loadEvents() {
+	for {
+		events, err := dataprovider.ClaimQueuedEvents(q.owner, q.workers, q.lease)
+		if err != nil {
+			if err != dataprovider.ErrProviderNotInitialized {
+				logger.Warn(eventsQueueLogSender, "", "unable to claim queued events: %v", err)
+			}
+			return
+		}
+		for _, event := range events {
+			select {
+			case q.events <- event:
+			case <-q.done:
+				return
+			}
+		}
+		if len(events) < q.workers {
+			return
+		}
+	}
+}
+
+func (q *eventsQueueManager) work() {
+	for {
+		select {
+		case <-q.done:
+			return
+		case event := <-q.events:
+			q.deliver(event)
+		}
+	}
+}
+
+// deliver executes the action for the given event, the event is removed from the
+// queue after the delivery, even if it fails after all the configured retries
+func (q *eventsQueueManager) deliver(event dataprovider.QueuedEvent) {
+	var notification ActionNotification
+	if err := json.Unmarshal([]byte(event.Payload), &notification); err != nil {
+		logger.Warn(eventsQueueLogSender, "", "unable to decode queued event %v, it will be discarded: %v", event.ID, err)
+	} else {
+		executeAction(&notification)
+	}
+	if err := dataprovider.DeleteQueuedEvent(event.ID); err != nil {
+		logger.Warn(eventsQueueLogSender, "", "unable to remove delivered event %v: %v", event.ID, err)
+	}
+}
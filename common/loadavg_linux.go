@@ -0,0 +1,23 @@
+// +build linux
+
+package common
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// getLoadAverage returns the system load average for the last minute
+func getLoadAverage() (float64, error) {
+	content, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("unexpected /proc/loadavg content %#v", string(content))
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
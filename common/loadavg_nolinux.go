@@ -0,0 +1,9 @@
+// +build !linux
+
+package common
+
+import "errors"
+
+func getLoadAverage() (float64, error) {
+	return 0, errors.New("the load average is supported on Linux only")
+}
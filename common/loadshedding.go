@@ -0,0 +1,151 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const loadSheddingLogSender = "LoadShedding"
+
+var (
+	// ErrServerOverloaded is returned when a new connection is rejected because
+	// the configured resource thresholds are exceeded
+	ErrServerOverloaded = errors.New("the server is overloaded, please retry later")
+	loadShedder         *loadSheddingManager
+)
+
+// LoadSheddingConfig defines the resource thresholds above which new connections are rejected.
+// The resource usage is sampled periodically, the connections already established are not affected
+type LoadSheddingConfig struct {
+	// Maximum number of goroutines. 0 means disabled
+	MaxGoroutines int `json:"max_goroutines" mapstructure:"max_goroutines"`
+	// Maximum memory, as MB, obtained from the OS by the Go runtime. 0 means disabled
+	MaxMemory int `json:"max_memory" mapstructure:"max_memory"`
+	// Maximum system load average for the last minute. 0 means disabled.
+	// Supported on Linux only
+	MaxLoadAverage float64 `json:"max_load_average" mapstructure:"max_load_average"`
+	// Interval, in seconds, between two resource usage samples
+	CheckInterval int `json:"check_interval" mapstructure:"check_interval"`
+}
+
+func (c *LoadSheddingConfig) isEnabled() bool {
+	return c.MaxGoroutines > 0 || c.MaxMemory > 0 || c.MaxLoadAverage > 0
+}
+
+func (c *LoadSheddingConfig) validate() error {
+	if c.MaxGoroutines < 0 {
+		return fmt.Errorf("invalid max goroutines: %v", c.MaxGoroutines)
+	}
+	if c.MaxMemory < 0 {
+		return fmt.Errorf("invalid max memory: %v", c.MaxMemory)
+	}
+	if c.MaxLoadAverage < 0 {
+		return fmt.Errorf("invalid max load average: %v", c.MaxLoadAverage)
+	}
+	if c.MaxLoadAverage > 0 {
+		if _, err := getLoadAverage(); err != nil {
+			return fmt.Errorf("unable to get the load average: %v", err)
+		}
+	}
+	if c.CheckInterval < 1 {
+		return fmt.Errorf("invalid check interval: %v", c.CheckInterval)
+	}
+	return nil
+}
+
+// getOverloadReason returns a description of the first exceeded threshold
+// or an empty string if the resource usage is below all the thresholds
+func (c *LoadSheddingConfig) getOverloadReason() string {
+	if c.MaxGoroutines > 0 {
+		if num := runtime.NumGoroutine(); num > c.MaxGoroutines {
+			return fmt.Sprintf("goroutines %v/%v", num, c.MaxGoroutines)
+		}
+	}
+	if c.MaxMemory > 0 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		if used := int64(m.Sys / 1048576); used > int64(c.MaxMemory) {
+			return fmt.Sprintf("memory %v/%v MB", used, c.MaxMemory)
+		}
+	}
+	if c.MaxLoadAverage > 0 {
+		load, err := getLoadAverage()
+		if err != nil {
+			logger.Warn(loadSheddingLogSender, "", "unable to get the load average: %v", err)
+		} else if load > c.MaxLoadAverage {
+			return fmt.Sprintf("load average %.2f/%.2f", load, c.MaxLoadAverage)
+		}
+	}
+	return ""
+}
+
+type loadSheddingManager struct {
+	config     LoadSheddingConfig
+	overloaded int32
+	done       chan bool
+}
+
+// the load shedder cannot be started/stopped from multiple goroutines
+func startLoadShedding(config LoadSheddingConfig) {
+	stopLoadShedding()
+	m := &loadSheddingManager{
+		config: config,
+		done:   make(chan bool),
+	}
+	m.update()
+	go m.loop(time.Duration(config.CheckInterval) * time.Second)
+	loadShedder = m
+	logger.Info(loadSheddingLogSender, "", "load shedding enabled with config %+v", config)
+}
+
+func stopLoadShedding() {
+	if loadShedder != nil {
+		close(loadShedder.done)
+		loadShedder = nil
+	}
+}
+
+func (m *loadSheddingManager) loop(checkInterval time.Duration) {
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.update()
+		}
+	}
+}
+
+func (m *loadSheddingManager) update() {
+	reason := m.config.getOverloadReason()
+	if reason != "" {
+		if atomic.SwapInt32(&m.overloaded, 1) == 0 {
+			logger.Warn(loadSheddingLogSender, "", "resource threshold exceeded, new connections will be rejected: %v", reason)
+		}
+		return
+	}
+	if atomic.SwapInt32(&m.overloaded, 0) == 1 {
+		logger.Info(loadSheddingLogSender, "", "resource usage below the thresholds, new connections are accepted again")
+	}
+}
+
+func (m *loadSheddingManager) isOverloaded() bool {
+	return atomic.LoadInt32(&m.overloaded) == 1
+}
+
+// CheckLoad returns ErrServerOverloaded if the configured resource
+// thresholds are exceeded and so new connections must be rejected
+func CheckLoad() error {
+	if m := loadShedder; m != nil && m.isOverloaded() {
+		return ErrServerOverloaded
+	}
+	return nil
+}
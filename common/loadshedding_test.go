@@ -0,0 +1,57 @@
+package common
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSheddingConfig(t *testing.T) {
+	c := LoadSheddingConfig{}
+	assert.False(t, c.isEnabled())
+	c.MaxGoroutines = -1
+	assert.Error(t, c.validate())
+	c.MaxGoroutines = 0
+	c.MaxMemory = -1
+	assert.Error(t, c.validate())
+	c.MaxMemory = 0
+	c.MaxLoadAverage = -1
+	assert.Error(t, c.validate())
+	c.MaxLoadAverage = 0
+	c.MaxGoroutines = 100
+	assert.True(t, c.isEnabled())
+	assert.Error(t, c.validate())
+	c.CheckInterval = 5
+	assert.NoError(t, c.validate())
+	c.MaxLoadAverage = 1000
+	if runtime.GOOS == "linux" {
+		assert.NoError(t, c.validate())
+		load, err := getLoadAverage()
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, load, float64(0))
+	} else {
+		assert.Error(t, c.validate())
+	}
+}
+
+func TestLoadShedding(t *testing.T) {
+	assert.NoError(t, CheckLoad())
+	c := LoadSheddingConfig{
+		MaxGoroutines: 1,
+		MaxMemory:     100000,
+		CheckInterval: 60,
+	}
+	assert.Contains(t, c.getOverloadReason(), "goroutines")
+	startLoadShedding(c)
+	assert.ErrorIs(t, CheckLoad(), ErrServerOverloaded)
+	loadShedder.config.MaxGoroutines = 0
+	loadShedder.update()
+	assert.NoError(t, CheckLoad())
+	loadShedder.config.MaxMemory = 1
+	loadShedder.update()
+	assert.ErrorIs(t, CheckLoad(), ErrServerOverloaded)
+	assert.Contains(t, loadShedder.config.getOverloadReason(), "memory")
+	stopLoadShedding()
+	assert.NoError(t, CheckLoad())
+}
@@ -3,6 +3,7 @@ package common_test
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math"
@@ -797,6 +798,277 @@ func TestQuotaRenameOverwrite(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestDirQuotas(t *testing.T) {
+	u := getTestUser()
+	quotaDir := "/incoming"
+	u.Filters.DirQuotas = []dataprovider.DirQuota{
+		{
+			Path:       quotaDir,
+			QuotaSize:  131072,
+			QuotaFiles: 2,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		// a file created outside SFTPGo, it will be included in the directory scan
+		err = os.MkdirAll(filepath.Join(user.GetHomeDir(), quotaDir), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.WriteFile(filepath.Join(user.GetHomeDir(), quotaDir, "external"), make([]byte, 65536), os.ModePerm)
+		assert.NoError(t, err)
+		// files outside the directory are not limited
+		err = writeSFTPFile(testFileName, 262144, client)
+		assert.NoError(t, err)
+		err = writeSFTPFile(path.Join(quotaDir, testFileName), 65536, client)
+		assert.NoError(t, err)
+		files, size, ok := dataprovider.GetUsedDirQuota(user.Username, quotaDir)
+		assert.True(t, ok)
+		assert.Equal(t, 2, files)
+		assert.Equal(t, int64(131072), size)
+		err = writeSFTPFile(path.Join(quotaDir, testFileName+"_1"), 10, client)
+		assert.Error(t, err)
+		err = client.Rename(testFileName, path.Join(quotaDir, testFileName+"_1"))
+		assert.Error(t, err)
+
+		err = client.Remove(path.Join(quotaDir, testFileName))
+		assert.NoError(t, err)
+		files, size, ok = dataprovider.GetUsedDirQuota(user.Username, quotaDir)
+		assert.True(t, ok)
+		assert.Equal(t, 1, files)
+		assert.Equal(t, int64(65536), size)
+		// the quota size is exceeded while uploading
+		err = writeSFTPFile(path.Join(quotaDir, testFileName), 131072, client)
+		assert.Error(t, err)
+		_, err = client.Stat(path.Join(quotaDir, testFileName))
+		assert.Error(t, err)
+		err = client.Mkdir(path.Join(quotaDir, "sub"))
+		assert.NoError(t, err)
+		err = writeSFTPFile(path.Join(quotaDir, "sub", testFileName), 10, client)
+		assert.NoError(t, err)
+		err = client.Rename(path.Join(quotaDir, "sub"), "/sub")
+		assert.NoError(t, err)
+		_, _, ok = dataprovider.GetUsedDirQuota(user.Username, quotaDir)
+		assert.False(t, ok)
+		err = writeSFTPFile(path.Join(quotaDir, testFileName), 10, client)
+		assert.NoError(t, err)
+		files, size, ok = dataprovider.GetUsedDirQuota(user.Username, quotaDir)
+		assert.True(t, ok)
+		assert.Equal(t, 2, files)
+		assert.Equal(t, int64(65546), size)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, _, ok := dataprovider.GetUsedDirQuota(user.Username, quotaDir)
+	assert.False(t, ok)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestRetentionRules(t *testing.T) {
+	u := getTestUser()
+	u.Filters.Retention = []dataprovider.RetentionRule{
+		{
+			Path:     "/logs",
+			Days:     7,
+			Patterns: []string{"*.log"},
+		},
+		{
+			Path:        "/data",
+			Days:        30,
+			ArchivePath: "/archive",
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	oldTime := time.Now().Add(-60 * 24 * time.Hour)
+	logsDir := filepath.Join(user.GetHomeDir(), "logs")
+	dataDir := filepath.Join(user.GetHomeDir(), "data", "sub")
+	err = os.MkdirAll(logsDir, os.ModePerm)
+	assert.NoError(t, err)
+	err = os.MkdirAll(dataDir, os.ModePerm)
+	assert.NoError(t, err)
+	for _, name := range []string{filepath.Join(logsDir, "old.log"), filepath.Join(logsDir, "old.txt"),
+		filepath.Join(dataDir, "old.dat")} {
+		err = os.WriteFile(name, make([]byte, 100), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.Chtimes(name, oldTime, oldTime)
+		assert.NoError(t, err)
+	}
+	err = os.WriteFile(filepath.Join(logsDir, "new.log"), make([]byte, 100), os.ModePerm)
+	assert.NoError(t, err)
+
+	result := common.CheckUserRetention(user, true)
+	assert.True(t, result.DryRun)
+	if assert.Len(t, result.Results, 2) {
+		assert.Equal(t, 1, result.Results[0].Files)
+		assert.Equal(t, int64(100), result.Results[0].Size)
+		assert.Equal(t, 1, result.Results[1].Files)
+		assert.Equal(t, 0, result.Results[1].Errors)
+	}
+	assert.FileExists(t, filepath.Join(logsDir, "old.log"))
+	assert.FileExists(t, filepath.Join(dataDir, "old.dat"))
+
+	result = common.CheckUserRetention(user, false)
+	assert.False(t, result.DryRun)
+	if assert.Len(t, result.Results, 2) {
+		assert.Equal(t, 1, result.Results[0].Files)
+		assert.Equal(t, 1, result.Results[1].Files)
+		assert.Equal(t, 0, result.Results[1].Errors)
+	}
+	assert.NoFileExists(t, filepath.Join(logsDir, "old.log"))
+	assert.FileExists(t, filepath.Join(logsDir, "old.txt"))
+	assert.FileExists(t, filepath.Join(logsDir, "new.log"))
+	assert.NoFileExists(t, filepath.Join(dataDir, "old.dat"))
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "archive", "sub", "old.dat"))
+	// the archived files are not expired again
+	result = common.CheckUserRetention(user, false)
+	if assert.Len(t, result.Results, 2) {
+		assert.Equal(t, 0, result.Results[0].Files)
+		assert.Equal(t, 0, result.Results[1].Files)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestRetentionArchiveFolder(t *testing.T) {
+	mappedPath := filepath.Join(os.TempDir(), "archive")
+	folder, _, err := httpdtest.AddFolder(vfs.BaseVirtualFolder{
+		Name:       filepath.Base(mappedPath),
+		MappedPath: mappedPath,
+	}, http.StatusCreated)
+	assert.NoError(t, err)
+	u := getTestUser()
+	u.Filters.Retention = []dataprovider.RetentionRule{
+		{
+			Path:          "/data",
+			Days:          30,
+			ArchiveFolder: folder.Name,
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	oldTime := time.Now().Add(-60 * 24 * time.Hour)
+	dataDir := filepath.Join(user.GetHomeDir(), "data")
+	archivedFile := filepath.Join(mappedPath, user.Username, "data", testFileName)
+	archiveFile := func() {
+		err = os.MkdirAll(dataDir, os.ModePerm)
+		assert.NoError(t, err)
+		err = os.WriteFile(filepath.Join(dataDir, testFileName), make([]byte, 100), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.Chtimes(filepath.Join(dataDir, testFileName), oldTime, oldTime)
+		assert.NoError(t, err)
+		result := common.CheckUserRetention(user, false)
+		if assert.Len(t, result.Results, 1) {
+			assert.Equal(t, 1, result.Results[0].Files)
+			assert.Equal(t, 0, result.Results[0].Errors)
+		}
+		assert.NoFileExists(t, filepath.Join(dataDir, testFileName))
+		assert.FileExists(t, archivedFile)
+		assert.FileExists(t, filepath.Join(user.GetHomeDir(), dataprovider.ArchivedDirName, "data", testFileName))
+	}
+	archiveFile()
+
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		entries, err := client.ReadDir("/data")
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, testFileName, entries[0].Name())
+			assert.Equal(t, int64(100), entries[0].Size())
+		}
+		entries, err = client.ReadDir("/")
+		assert.NoError(t, err)
+		for _, entry := range entries {
+			assert.NotEqual(t, dataprovider.ArchivedDirName, entry.Name())
+		}
+		info, err := client.Stat(path.Join("/data", testFileName))
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(100), info.Size())
+		}
+		// the file is restored on access
+		f, err := client.Open(path.Join("/data", testFileName))
+		if assert.NoError(t, err) {
+			contents, err := io.ReadAll(f)
+			assert.NoError(t, err)
+			assert.Len(t, contents, 100)
+			err = f.Close()
+			assert.NoError(t, err)
+		}
+		assert.FileExists(t, filepath.Join(dataDir, testFileName))
+		assert.NoFileExists(t, archivedFile)
+	}
+
+	archiveFile()
+	c := common.NewBaseConnection("", common.ProtocolHTTP, user)
+	files, err := c.ListArchivedFiles()
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, path.Join("/data", testFileName), files[0].Path)
+		assert.Equal(t, folder.Name, files[0].Folder)
+		assert.Equal(t, int64(100), files[0].Size)
+	}
+	err = c.RestoreArchivedFile("/missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	err = c.RestoreArchivedFile(path.Join("/data", testFileName))
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dataDir, testFileName))
+	assert.NoFileExists(t, archivedFile)
+	files, err = c.ListArchivedFiles()
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+	// the stub is inside the user's filesystem, only the configured archive
+	// folders can be used and the archive path is not read from the stub
+	archiveFile()
+	stubPath := filepath.Join(user.GetHomeDir(), dataprovider.ArchivedDirName, "data", testFileName)
+	data, err := os.ReadFile(stubPath)
+	assert.NoError(t, err)
+	var archived common.ArchivedFile
+	err = json.Unmarshal(data, &archived)
+	assert.NoError(t, err)
+	assert.Equal(t, path.Join("/", user.Username, "data", testFileName), archived.ArchivePath)
+	archived.Folder = "other folder"
+	data, err = json.Marshal(archived)
+	assert.NoError(t, err)
+	err = os.WriteFile(stubPath, data, os.ModePerm)
+	assert.NoError(t, err)
+	err = c.RestoreArchivedFile(path.Join("/data", testFileName))
+	assert.ErrorIs(t, err, os.ErrPermission)
+	assert.NoFileExists(t, filepath.Join(dataDir, testFileName))
+	assert.FileExists(t, archivedFile)
+	archived.Folder = folder.Name
+	archived.ArchivePath = path.Join("/otheruser", "data", testFileName)
+	data, err = json.Marshal(archived)
+	assert.NoError(t, err)
+	err = os.WriteFile(stubPath, data, os.ModePerm)
+	assert.NoError(t, err)
+	err = c.RestoreArchivedFile(path.Join("/data", testFileName))
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(dataDir, testFileName))
+	assert.NoFileExists(t, archivedFile)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveFolder(folder, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = os.RemoveAll(mappedPath)
+	assert.NoError(t, err)
+}
+
 func TestVirtualFoldersQuotaValues(t *testing.T) {
 	u := getTestUser()
 	u.QuotaFiles = 100
@@ -2292,6 +2564,164 @@ func TestRenameDir(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestFileVersioning(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Versioning = vfs.VersioningConfig{
+		Mode:        vfs.VersioningModeVersionsDir,
+		MaxVersions: 2,
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		for _, size := range []int64{32, 64, 128, 256} {
+			err = writeSFTPFile(testFileName, size, client)
+			assert.NoError(t, err)
+		}
+		versions, _, err := httpdtest.GetUserFileVersions(user.Username, testFileName, http.StatusOK)
+		assert.NoError(t, err)
+		if assert.Len(t, versions, 2) {
+			assert.Equal(t, int64(128), versions[0].Size)
+			assert.Equal(t, int64(64), versions[1].Size)
+		}
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, user.UsedQuotaFiles)
+		assert.Equal(t, int64(448), user.UsedQuotaSize)
+
+		entries, err := client.ReadDir("/")
+		assert.NoError(t, err)
+		for _, entry := range entries {
+			assert.NotEqual(t, vfs.VersionsDirName, entry.Name())
+		}
+		_, err = client.ReadDir(vfs.VersionsDirName)
+		assert.Error(t, err)
+		err = client.Mkdir(path.Join(vfs.VersionsDirName, testDir))
+		assert.Error(t, err)
+		err = client.Rename(testFileName, path.Join(vfs.VersionsDirName, testFileName))
+		assert.Error(t, err)
+		_, err = client.Open(path.Join(vfs.VersionsDirName, testFileName, versions[0].ID))
+		assert.Error(t, err)
+
+		err = client.Remove(testFileName)
+		assert.NoError(t, err)
+		versions, _, err = httpdtest.GetUserFileVersions(user.Username, testFileName, http.StatusOK)
+		assert.NoError(t, err)
+		if assert.Len(t, versions, 2) {
+			assert.Equal(t, int64(256), versions[0].Size)
+			assert.Equal(t, int64(128), versions[1].Size)
+		}
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, int64(384), user.UsedQuotaSize)
+
+		_, err = httpdtest.RestoreUserFileVersion(user.Username, testFileName, "20200101T000000.000000000Z",
+			http.StatusNotFound)
+		assert.NoError(t, err)
+		_, err = httpdtest.RestoreUserFileVersion(user.Username, testFileName, versions[1].ID, http.StatusOK)
+		assert.NoError(t, err)
+		info, err := client.Stat(testFileName)
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(128), info.Size())
+		}
+		versions, _, err = httpdtest.GetUserFileVersions(user.Username, testFileName, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Len(t, versions, 1)
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, user.UsedQuotaFiles)
+		assert.Equal(t, int64(384), user.UsedQuotaSize)
+	}
+	user.FsConfig.Versioning = vfs.VersioningConfig{}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	_, _, err = httpdtest.GetUserFileVersions(user.Username, testFileName, http.StatusBadRequest)
+	assert.NoError(t, err)
+	user.FsConfig.Versioning.Mode = vfs.VersioningModeBucket
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestTrash(t *testing.T) {
+	u := getTestUser()
+	u.Filters.Trash.Enabled = true
+	u.Filters.Trash.RetentionDays = 1
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	conn, client, err := getSftpClient(user)
+	if assert.NoError(t, err) {
+		defer conn.Close()
+		defer client.Close()
+
+		err = writeSFTPFile(testFileName, 32, client)
+		assert.NoError(t, err)
+		err = writeSFTPFile(testFileName+"_1", 64, client)
+		assert.NoError(t, err)
+		expiredFile := filepath.Join(user.GetHomeDir(), dataprovider.TrashDirName, "20200101T000000.000000000Z",
+			"expired.txt")
+		err = os.MkdirAll(filepath.Dir(expiredFile), os.ModePerm)
+		assert.NoError(t, err)
+		err = os.WriteFile(expiredFile, []byte("expired"), os.ModePerm)
+		assert.NoError(t, err)
+
+		err = client.Remove(testFileName)
+		assert.NoError(t, err)
+		_, err = client.Stat(testFileName)
+		assert.ErrorIs(t, err, os.ErrNotExist)
+		assert.NoDirExists(t, filepath.Dir(expiredFile))
+		user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+		assert.NoError(t, err)
+		// the expired file was created outside SFTPGo, its removal decreases the used quota anyway
+		assert.Equal(t, 1, user.UsedQuotaFiles)
+		assert.Equal(t, int64(96-7), user.UsedQuotaSize)
+
+		entries, err := client.ReadDir("/")
+		assert.NoError(t, err)
+		for _, entry := range entries {
+			assert.NotEqual(t, dataprovider.TrashDirName, entry.Name())
+		}
+		_, err = client.ReadDir(dataprovider.TrashDirName)
+		assert.Error(t, err)
+		err = client.Rename(testFileName+"_1", path.Join(dataprovider.TrashDirName, testFileName))
+		assert.Error(t, err)
+
+		c := common.NewBaseConnection("", common.ProtocolHTTP, user)
+		items, err := c.ListTrash()
+		assert.NoError(t, err)
+		if assert.Len(t, items, 1) {
+			assert.Equal(t, "/"+testFileName, items[0].Path)
+			assert.Equal(t, int64(32), items[0].Size)
+			err = c.RestoreFromTrash(items[0].Path, "invalid")
+			assert.ErrorIs(t, err, os.ErrNotExist)
+			err = c.RestoreFromTrash("/"+testFileName+"_1", items[0].ID)
+			assert.ErrorIs(t, err, os.ErrNotExist)
+			err = c.RestoreFromTrash(items[0].Path, items[0].ID)
+			assert.NoError(t, err)
+		}
+		info, err := client.Stat(testFileName)
+		if assert.NoError(t, err) {
+			assert.Equal(t, int64(32), info.Size())
+		}
+		items, err = c.ListTrash()
+		assert.NoError(t, err)
+		assert.Len(t, items, 0)
+	}
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestRenameSymlink(t *testing.T) {
 	u := getTestUser()
 	testDir := "/dir-no-create-links"
@@ -13,10 +13,26 @@ import (
 	"github.com/drakkan/sftpgo/utils"
 )
 
+// Supported rate limited operations
+const (
+	// RateLimitOperationConnect limits new connections/requests
+	RateLimitOperationConnect = "connect"
+	// RateLimitOperationAuth limits the authentication attempts
+	RateLimitOperationAuth = "auth"
+	// RateLimitOperationList limits the directory listings
+	RateLimitOperationList = "list"
+	// RateLimitOperationOpen limits the file open requests
+	RateLimitOperationOpen = "open"
+)
+
 var (
-	errNoBucket               = errors.New("no bucket found")
-	errReserve                = errors.New("unable to reserve token")
-	rateLimiterProtocolValues = []string{ProtocolSSH, ProtocolFTP, ProtocolWebDAV, ProtocolHTTP}
+	errNoBucket                = errors.New("no bucket found")
+	errReserve                 = errors.New("unable to reserve token")
+	rateLimiterProtocolValues  = []string{ProtocolSSH, ProtocolFTP, ProtocolWebDAV, ProtocolHTTP}
+	rateLimiterOperationValues = []string{RateLimitOperationConnect, RateLimitOperationAuth, RateLimitOperationList,
+		RateLimitOperationOpen}
+	// these operations are bound to an authenticated connection
+	rateLimiterConnectionOperations = []string{RateLimitOperationList, RateLimitOperationOpen}
 )
 
 // RateLimiterType defines the supported rate limiters types
@@ -26,6 +42,7 @@ type RateLimiterType int
 const (
 	rateLimiterTypeGlobal RateLimiterType = iota + 1
 	rateLimiterTypeSource
+	rateLimiterTypeConnection
 )
 
 // RateLimiterConfig defines the configuration for a rate limiter
@@ -42,11 +59,16 @@ type RateLimiterConfig struct {
 	// Type defines the rate limiter type:
 	// - rateLimiterTypeGlobal is a global rate limiter independent from the source
 	// - rateLimiterTypeSource is a per-source rate limiter
+	// - rateLimiterTypeConnection is a per-connection rate limiter, it can only be used
+	//   for the "list" and "open" operations
 	Type int `json:"type" mapstructure:"type"`
 	// Protocols defines the protocols for this rate limiter.
-	// Available protocols are: "SFTP", "FTP", "DAV".
+	// Available protocols are: "SFTP", "FTP", "DAV", "RSYNC".
 	// A rate limiter with no protocols defined is disabled
 	Protocols []string `json:"protocols" mapstructure:"protocols"`
+	// Operations defines the operations to limit. Available operations are:
+	// "connect", "auth", "list", "open". Empty means "connect"
+	Operations []string `json:"operations" mapstructure:"operations"`
 	// If the rate limit is exceeded, the defender is enabled, and this is a per-source limiter,
 	// a new defender event will be generated
 	GenerateDefenderEvents bool `json:"generate_defender_events" mapstructure:"generate_defender_events"`
@@ -67,7 +89,8 @@ func (r *RateLimiterConfig) validate() error {
 	if r.Period < 100 {
 		return fmt.Errorf("invalid period %v. It must be >= 100", r.Period)
 	}
-	if r.Type != int(rateLimiterTypeGlobal) && r.Type != int(rateLimiterTypeSource) {
+	if r.Type != int(rateLimiterTypeGlobal) && r.Type != int(rateLimiterTypeSource) &&
+		r.Type != int(rateLimiterTypeConnection) {
 		return fmt.Errorf("invalid type %v", r.Type)
 	}
 	if r.Type != int(rateLimiterTypeGlobal) {
@@ -84,6 +107,22 @@ func (r *RateLimiterConfig) validate() error {
 			return fmt.Errorf("invalid protocol %#v", protocol)
 		}
 	}
+	if len(r.Operations) == 0 {
+		r.Operations = []string{RateLimitOperationConnect}
+	}
+	r.Operations = utils.RemoveDuplicates(r.Operations)
+	for _, operation := range r.Operations {
+		if !utils.IsStringInSlice(operation, rateLimiterOperationValues) {
+			return fmt.Errorf("invalid operation %#v", operation)
+		}
+		isConnectionOperation := utils.IsStringInSlice(operation, rateLimiterConnectionOperations)
+		if r.Type == int(rateLimiterTypeConnection) && !isConnectionOperation {
+			return fmt.Errorf("operation %#v is not supported for per-connection rate limiters", operation)
+		}
+		if r.Type == int(rateLimiterTypeSource) && isConnectionOperation {
+			return fmt.Errorf("operation %#v is not supported for per-source rate limiters", operation)
+		}
+	}
 	return nil
 }
 
@@ -91,7 +130,8 @@ func (r *RateLimiterConfig) getLimiter() *rateLimiter {
 	limiter := &rateLimiter{
 		burst:                  r.Burst,
 		globalBucket:           nil,
-		generateDefenderEvents: r.GenerateDefenderEvents,
+		limiterType:            RateLimiterType(r.Type),
+		generateDefenderEvents: r.GenerateDefenderEvents && r.Type == int(rateLimiterTypeSource),
 	}
 	var maxDelay time.Duration
 	period := time.Duration(r.Period) * time.Millisecond
@@ -111,7 +151,7 @@ func (r *RateLimiterConfig) getLimiter() *rateLimiter {
 		hardLimit: r.EntriesHardLimit,
 		softLimit: r.EntriesSoftLimit,
 	}
-	if r.Type != int(rateLimiterTypeSource) {
+	if r.Type == int(rateLimiterTypeGlobal) {
 		limiter.globalBucket = rate.NewLimiter(limiter.rate, limiter.burst)
 	}
 	return limiter
@@ -124,6 +164,7 @@ type rateLimiter struct {
 	maxDelay               time.Duration
 	globalBucket           *rate.Limiter
 	buckets                sourceBuckets
+	limiterType            RateLimiterType
 	generateDefenderEvents bool
 }
 
@@ -157,6 +198,14 @@ func (rl *rateLimiter) Wait(source string) (time.Duration, error) {
 	return 0, nil
 }
 
+// removeSource removes the bucket for the given source, if any
+func (rl *rateLimiter) removeSource(source string) {
+	if rl.globalBucket != nil {
+		return
+	}
+	rl.buckets.remove(source)
+}
+
 type sourceRateLimiter struct {
 	lastActivity int64
 	bucket       *rate.Limiter
@@ -203,6 +252,13 @@ func (b *sourceBuckets) addAndReserve(r *rate.Limiter, source string) *rate.Rese
 	return src.bucket.Reserve()
 }
 
+func (b *sourceBuckets) remove(source string) {
+	b.Lock()
+	defer b.Unlock()
+
+	delete(b.buckets, source)
+}
+
 func (b *sourceBuckets) cleanup() {
 	if len(b.buckets) >= b.hardLimit {
 		numToRemove := len(b.buckets) - b.softLimit
@@ -35,6 +35,23 @@ func TestRateLimiterConfig(t *testing.T) {
 	config.Protocols = rateLimiterProtocolValues
 	err = config.validate()
 	require.NoError(t, err)
+	require.Equal(t, []string{RateLimitOperationConnect}, config.Operations)
+	config.Operations = []string{"unsupported operation"}
+	err = config.validate()
+	require.Error(t, err)
+	config.Operations = []string{RateLimitOperationList}
+	err = config.validate()
+	require.Error(t, err)
+	config.Type = int(rateLimiterTypeConnection)
+	err = config.validate()
+	require.NoError(t, err)
+	config.Operations = []string{RateLimitOperationAuth, RateLimitOperationAuth}
+	err = config.validate()
+	require.Error(t, err)
+	config.Type = int(rateLimiterTypeSource)
+	err = config.validate()
+	require.NoError(t, err)
+	require.Len(t, config.Operations, 1)
 
 	limiter := config.getLimiter()
 	require.Equal(t, 500*time.Millisecond, limiter.maxDelay)
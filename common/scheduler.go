@@ -0,0 +1,355 @@
+package common
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/scheduler"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// Supported scheduled job types
+const (
+	JobTypeQuotaScan           = "quota_scan"
+	JobTypeBackup              = "backup"
+	JobTypeRetentionCheck      = "retention_check"
+	JobTypeUserExpirationCheck = "user_expiration_check"
+	JobTypeCommand             = "command"
+)
+
+const (
+	schedulerLogSender = "Scheduler"
+	jobsPageSize       = 100
+	backupFilePrefix   = "sftpgo-backup-"
+)
+
+var (
+	supportedJobTypes = []string{JobTypeQuotaScan, JobTypeBackup, JobTypeRetentionCheck, JobTypeUserExpirationCheck,
+		JobTypeCommand}
+	schedulerMu     sync.RWMutex
+	activeScheduler *scheduler.Scheduler
+)
+
+// ScheduledJob defines a job executed by the internal scheduler
+type ScheduledJob struct {
+	// Unique job name
+	Name string `json:"name" mapstructure:"name"`
+	// Cron expression, for example "0 2 * * *" to run the job every day at 2 AM
+	Schedule string `json:"schedule" mapstructure:"schedule"`
+	// Job type, supported values: quota_scan, backup, retention_check, user_expiration_check, command
+	Type string `json:"type" mapstructure:"type"`
+	// Absolute path to the directory to store the backups, for backup jobs
+	OutputPath string `json:"output_path" mapstructure:"output_path"`
+	// Number of backups to keep, the older ones are removed. 0 means keep all, for backup jobs
+	MaxBackups int `json:"max_backups" mapstructure:"max_backups"`
+	// Absolute path to the command to execute and its arguments, for command jobs
+	Command string   `json:"command" mapstructure:"command"`
+	Args    []string `json:"args" mapstructure:"args"`
+	// Maximum execution time, in seconds, for command jobs. 0 means no limit
+	Timeout int `json:"timeout" mapstructure:"timeout"`
+}
+
+func (j *ScheduledJob) validate() error {
+	if j.Name == "" {
+		return errors.New("the job name is mandatory")
+	}
+	if _, err := scheduler.Parse(j.Schedule); err != nil {
+		return fmt.Errorf("job %#v: %v", j.Name, err)
+	}
+	if !utils.IsStringInSlice(j.Type, supportedJobTypes) {
+		return fmt.Errorf("job %#v: unsupported type %#v", j.Name, j.Type)
+	}
+	switch j.Type {
+	case JobTypeBackup:
+		if !filepath.IsAbs(j.OutputPath) {
+			return fmt.Errorf("job %#v: invalid output path %#v, it must be an absolute path", j.Name, j.OutputPath)
+		}
+		if j.MaxBackups < 0 {
+			return fmt.Errorf("job %#v: invalid max backups %v", j.Name, j.MaxBackups)
+		}
+	case JobTypeCommand:
+		if !filepath.IsAbs(j.Command) {
+			return fmt.Errorf("job %#v: invalid command %#v, it must be an absolute path", j.Name, j.Command)
+		}
+		if j.Timeout < 0 {
+			return fmt.Errorf("job %#v: invalid timeout %v", j.Name, j.Timeout)
+		}
+	}
+	return nil
+}
+
+func (j *ScheduledJob) getRunFunc() func() error {
+	var run func() error
+	switch j.Type {
+	case JobTypeQuotaScan:
+		run = scanAllQuotas
+	case JobTypeBackup:
+		run = j.backup
+	case JobTypeRetentionCheck:
+		run = CheckRetention
+	case JobTypeUserExpirationCheck:
+		run = disableExpiredUsers
+	default:
+		run = j.executeCommand
+	}
+	return func() error {
+		startTime := time.Now()
+		logger.Debug(schedulerLogSender, "", "job %#v started", j.Name)
+		err := run()
+		if err != nil {
+			logger.Warn(schedulerLogSender, "", "job %#v failed, elapsed: %v, error: %v", j.Name, time.Since(startTime), err)
+		} else {
+			logger.Debug(schedulerLogSender, "", "job %#v completed, elapsed: %v", j.Name, time.Since(startTime))
+		}
+		return err
+	}
+}
+
+func (j *ScheduledJob) backup() error {
+	if err := os.MkdirAll(j.OutputPath, 0700); err != nil {
+		return err
+	}
+	backup, err := dataprovider.DumpData()
+	if err != nil {
+		return err
+	}
+	dump, err := json.Marshal(backup)
+	if err != nil {
+		return err
+	}
+	outputFile := filepath.Join(j.OutputPath, fmt.Sprintf("%v%v.json", backupFilePrefix,
+		time.Now().UTC().Format("20060102T150405")))
+	if err := os.WriteFile(outputFile, dump, 0600); err != nil {
+		return err
+	}
+	logger.Debug(schedulerLogSender, "", "job %#v, backup saved to %#v", j.Name, outputFile)
+	if j.MaxBackups > 0 {
+		j.removeOldBackups()
+	}
+	return nil
+}
+
+func (j *ScheduledJob) removeOldBackups() {
+	entries, err := os.ReadDir(j.OutputPath)
+	if err != nil {
+		logger.Warn(schedulerLogSender, "", "job %#v, unable to list the backups: %v", j.Name, err)
+		return
+	}
+	var backups []string
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), backupFilePrefix) &&
+			strings.HasSuffix(entry.Name(), ".json") {
+			backups = append(backups, entry.Name())
+		}
+	}
+	if len(backups) <= j.MaxBackups {
+		return
+	}
+	// the names include the timestamp so they are sorted by creation time
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-j.MaxBackups] {
+		err := os.Remove(filepath.Join(j.OutputPath, name))
+		logger.Debug(schedulerLogSender, "", "job %#v, removed old backup %#v, error: %v", j.Name, name, err)
+	}
+}
+
+func (j *ScheduledJob) executeCommand() error {
+	ctx := context.Background()
+	if j.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(j.Timeout)*time.Second)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, j.Command, j.Args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("SFTPGO_JOB_NAME=%v", j.Name))
+	return cmd.Run()
+}
+
+// SchedulerConfig defines the jobs executed by the internal scheduler
+type SchedulerConfig struct {
+	Jobs []ScheduledJob `json:"jobs" mapstructure:"jobs"`
+}
+
+func (c *SchedulerConfig) getScheduler() (*scheduler.Scheduler, error) {
+	var jobs []scheduler.Job
+	for idx := range c.Jobs {
+		job := &c.Jobs[idx]
+		if err := job.validate(); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, scheduler.Job{
+			Name: job.Name,
+			Spec: job.Schedule,
+			Run:  job.getRunFunc(),
+		})
+	}
+	return scheduler.New(jobs)
+}
+
+func startScheduler(c *SchedulerConfig) error {
+	stopScheduler()
+	if len(c.Jobs) == 0 {
+		return nil
+	}
+	s, err := c.getScheduler()
+	if err != nil {
+		return err
+	}
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+
+	activeScheduler = s
+	activeScheduler.Start()
+	logger.Info(schedulerLogSender, "", "scheduler started, jobs: %v", len(c.Jobs))
+	return nil
+}
+
+func stopScheduler() {
+	schedulerMu.Lock()
+	defer schedulerMu.Unlock()
+
+	if activeScheduler != nil {
+		activeScheduler.Stop()
+		activeScheduler = nil
+	}
+}
+
+// GetScheduledJobs returns the status of the scheduled jobs
+func GetScheduledJobs() []scheduler.JobStatus {
+	schedulerMu.RLock()
+	defer schedulerMu.RUnlock()
+
+	if activeScheduler == nil {
+		return []scheduler.JobStatus{}
+	}
+	return activeScheduler.GetStatus()
+}
+
+// RunScheduledJob starts the scheduled job with the given name now. An error
+// is returned if the job does not exist or it is already running
+func RunScheduledJob(name string) error {
+	schedulerMu.RLock()
+	defer schedulerMu.RUnlock()
+
+	if activeScheduler == nil {
+		return scheduler.ErrJobNotFound
+	}
+	return activeScheduler.RunJob(name)
+}
+
+// scanAllQuotas updates the quota usage for all the users and virtual folders.
+// The users and folders with a scan already in progress are skipped
+func scanAllQuotas() error {
+	if dataprovider.GetQuotaTracking() == 0 {
+		return errors.New("quota tracking is disabled")
+	}
+	var scanErr error
+	err := forEachUser(func(user dataprovider.User) {
+		if !QuotaScans.AddUserQuotaScan(user.Username) {
+			logger.Debug(schedulerLogSender, "", "quota scan already in progress for user %#v", user.Username)
+			return
+		}
+		defer QuotaScans.RemoveUserQuotaScan(user.Username)
+
+		numFiles, size, err := user.ScanQuota()
+		if err == nil {
+			err = dataprovider.UpdateUserQuota(&user, numFiles, size, true)
+		}
+		if err != nil {
+			logger.Warn(schedulerLogSender, "", "unable to scan quota for user %#v: %v", user.Username, err)
+			scanErr = err
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for offset := 0; ; offset += jobsPageSize {
+		folders, err := dataprovider.GetFolders(jobsPageSize, offset, dataprovider.OrderASC)
+		if err != nil {
+			return err
+		}
+		for idx := range folders {
+			folder := folders[idx]
+			if !QuotaScans.AddVFolderQuotaScan(folder.Name) {
+				logger.Debug(schedulerLogSender, "", "quota scan already in progress for folder %#v", folder.Name)
+				continue
+			}
+			f := vfs.VirtualFolder{
+				BaseVirtualFolder: folder,
+				VirtualPath:       "/",
+			}
+			numFiles, size, err := f.ScanQuota()
+			if err == nil {
+				err = dataprovider.UpdateVirtualFolderQuota(&folder, numFiles, size, true)
+			}
+			QuotaScans.RemoveVFolderQuotaScan(folder.Name)
+			if err != nil {
+				logger.Warn(schedulerLogSender, "", "unable to scan quota for folder %#v: %v", folder.Name, err)
+				scanErr = err
+			}
+		}
+		if len(folders) < jobsPageSize {
+			break
+		}
+	}
+	return scanErr
+}
+
+// disableExpiredUsers disables the active users with an expiration date in the past.
+// The provider actions, if configured, are executed for the updated users
+func disableExpiredUsers() error {
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	var updateErr error
+	err := forEachUser(func(user dataprovider.User) {
+		if user.Status != 1 || user.ExpirationDate == 0 || user.ExpirationDate >= now {
+			return
+		}
+		user.Status = 0
+		if err := dataprovider.UpdateUser(&user); err != nil {
+			logger.Warn(schedulerLogSender, "", "unable to disable expired user %#v: %v", user.Username, err)
+			updateErr = err
+			return
+		}
+		logger.Info(schedulerLogSender, "", "user %#v disabled, expired on %v", user.Username,
+			user.GetExpirationDateAsString())
+	})
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+// forEachUser executes the given function for each user. The users are
+// loaded with their secrets
+func forEachUser(fn func(user dataprovider.User)) error {
+	for offset := 0; ; offset += jobsPageSize {
+		users, err := dataprovider.GetUsers(jobsPageSize, offset, dataprovider.OrderASC)
+		if err != nil {
+			return err
+		}
+		for idx := range users {
+			// the returned users have their secrets hidden, we need the full user
+			user, err := dataprovider.UserExists(users[idx].Username)
+			if err != nil {
+				logger.Warn(schedulerLogSender, "", "unable to get user %#v: %v", users[idx].Username, err)
+				continue
+			}
+			fn(user)
+		}
+		if len(users) < jobsPageSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package common
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/scheduler"
+)
+
+func TestScheduledJobValidation(t *testing.T) {
+	job := ScheduledJob{}
+	assert.Error(t, job.validate())
+	job.Name = "job"
+	job.Schedule = "* * *"
+	assert.Error(t, job.validate())
+	job.Schedule = "0 2 * * *"
+	job.Type = "unknown"
+	assert.Error(t, job.validate())
+	job.Type = JobTypeBackup
+	job.OutputPath = "relative"
+	assert.Error(t, job.validate())
+	job.OutputPath = filepath.Join(os.TempDir(), "backups")
+	job.MaxBackups = -1
+	assert.Error(t, job.validate())
+	job.MaxBackups = 2
+	assert.NoError(t, job.validate())
+	job.Type = JobTypeCommand
+	job.Command = "relative"
+	assert.Error(t, job.validate())
+	job.Command = filepath.Join(os.TempDir(), "cmd")
+	job.Timeout = -1
+	assert.Error(t, job.validate())
+	job.Timeout = 0
+	assert.NoError(t, job.validate())
+	job.Type = JobTypeQuotaScan
+	assert.NoError(t, job.validate())
+
+	err := startScheduler(&SchedulerConfig{Jobs: []ScheduledJob{{Name: "job", Schedule: "*", Type: JobTypeQuotaScan}}})
+	assert.Error(t, err)
+	err = startScheduler(&SchedulerConfig{Jobs: []ScheduledJob{
+		{Name: "job", Schedule: "@daily", Type: JobTypeQuotaScan},
+		{Name: "job", Schedule: "@hourly", Type: JobTypeUserExpirationCheck},
+	}})
+	assert.Error(t, err)
+	assert.Len(t, GetScheduledJobs(), 0)
+	assert.ErrorIs(t, RunScheduledJob("job"), scheduler.ErrJobNotFound)
+}
+
+func TestScheduledJobs(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	hookCmd, err := exec.LookPath("true")
+	require.NoError(t, err)
+	backupsPath := filepath.Join(os.TempDir(), "scheduled_backups")
+	err = os.MkdirAll(backupsPath, os.ModePerm)
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("%v20210101T00000%v.json", backupFilePrefix, i)
+		err = os.WriteFile(filepath.Join(backupsPath, name), []byte("{}"), os.ModePerm)
+		assert.NoError(t, err)
+	}
+
+	err = startScheduler(&SchedulerConfig{Jobs: []ScheduledJob{
+		{
+			Name:     "cmd",
+			Schedule: "0 0 30 2 *",
+			Type:     JobTypeCommand,
+			Command:  hookCmd,
+			Timeout:  10,
+		},
+		{
+			Name:       "backup",
+			Schedule:   "@daily",
+			Type:       JobTypeBackup,
+			OutputPath: backupsPath,
+			MaxBackups: 2,
+		},
+		{
+			Name:     "expiration",
+			Schedule: "@hourly",
+			Type:     JobTypeUserExpirationCheck,
+		},
+	}})
+	require.NoError(t, err)
+
+	jobs := GetScheduledJobs()
+	require.Len(t, jobs, 3)
+	assert.Equal(t, "cmd", jobs[0].Name)
+	assert.Equal(t, int64(0), jobs[0].NextRun)
+	assert.Greater(t, jobs[1].NextRun, int64(0))
+
+	for _, name := range []string{"cmd", "backup", "expiration"} {
+		err = RunScheduledJob(name)
+		assert.NoError(t, err)
+	}
+	assert.Eventually(t, func() bool {
+		for _, job := range GetScheduledJobs() {
+			if job.Runs != 1 {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 100*time.Millisecond)
+
+	for _, job := range GetScheduledJobs() {
+		assert.Empty(t, job.LastError, job.Name)
+	}
+	entries, err := os.ReadDir(backupsPath)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+	stopScheduler()
+	assert.Len(t, GetScheduledJobs(), 0)
+
+	err = os.RemoveAll(backupsPath)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,232 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	sharedConnsLogSender = "SharedConnections"
+	// a connection not updated for this number of intervals is considered stale
+	sharedConnsStaleIntervals = 3
+	// remoteConnectionSeparator separates the connection id and the node id
+	// for the connections handled by the other nodes
+	remoteConnectionSeparator = "@"
+)
+
+var sharedConns *sharedConnectionsManager
+
+// SharedConnectionsConfig defines the configuration for the shared connections registry
+type SharedConnectionsConfig struct {
+	// If enabled the active connections are stored within the data provider, this way
+	// multiple SFTPGo instances sharing the same data provider, for example behind a
+	// load balancer, can list and close the connections handled by the other instances
+	// and the max sessions limit is enforced across all the instances
+	Enabled bool `json:"enabled" mapstructure:"enabled"`
+	// Unique identifier for this instance. If empty the hostname is used
+	NodeID string `json:"node_id" mapstructure:"node_id"`
+	// Interval, in seconds, between two updates of the connections stored within
+	// the data provider. The close requests for the connections handled by this
+	// instance are processed at the same interval
+	UpdateInterval int `json:"update_interval" mapstructure:"update_interval"`
+}
+
+func (c *SharedConnectionsConfig) validate() error {
+	if c.UpdateInterval < 1 {
+		return fmt.Errorf("invalid update interval: %v", c.UpdateInterval)
+	}
+	if strings.Contains(c.NodeID, remoteConnectionSeparator) {
+		return fmt.Errorf("invalid node id %#v, it cannot contain %#v", c.NodeID, remoteConnectionSeparator)
+	}
+	return nil
+}
+
+func (c *SharedConnectionsConfig) getNodeID() (string, error) {
+	if c.NodeID != "" {
+		return c.NodeID, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("unable to get the hostname, please set a node id: %v", err)
+	}
+	return strings.ReplaceAll(hostname, remoteConnectionSeparator, "_"), nil
+}
+
+type sharedConnectionsManager struct {
+	nodeID         string
+	updateInterval time.Duration
+	done           chan bool
+}
+
+// the registry cannot be started/stopped from multiple goroutines
+func startSharedConnections(config *SharedConnectionsConfig) error {
+	stopSharedConnections()
+	nodeID, err := config.getNodeID()
+	if err != nil {
+		return err
+	}
+	m := &sharedConnectionsManager{
+		nodeID:         nodeID,
+		updateInterval: time.Duration(config.UpdateInterval) * time.Second,
+		done:           make(chan bool),
+	}
+	go m.loop()
+	sharedConns = m
+	logger.Info(sharedConnsLogSender, "", "shared connections registry started, node id: %#v, update interval: %v",
+		nodeID, m.updateInterval)
+	return nil
+}
+
+func stopSharedConnections() {
+	if sharedConns != nil {
+		close(sharedConns.done)
+		sharedConns = nil
+	}
+}
+
+func (m *sharedConnectionsManager) loop() {
+	ticker := time.NewTicker(m.updateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.update()
+		}
+	}
+}
+
+// update refreshes the connections handled by this node, closes the ones that
+// another node asked to close and removes the stale ones
+func (m *sharedConnectionsManager) update() {
+	for _, stat := range Connections.getLocalStats() {
+		m.add(stat)
+	}
+	conns, err := dataprovider.GetSharedConnections(m.getStaleLimit())
+	if err != nil {
+		if err != dataprovider.ErrProviderNotInitialized {
+			logger.Warn(sharedConnsLogSender, "", "unable to get shared connections: %v", err)
+		}
+		return
+	}
+	for _, conn := range conns {
+		if conn.NodeID != m.nodeID {
+			continue
+		}
+		if !Connections.isLocal(conn.ConnectionID) {
+			// the connection was closed while we were updating the registry
+			m.remove(conn.ConnectionID)
+			continue
+		}
+		if conn.CloseRequested {
+			result := Connections.closeLocal(conn.ConnectionID)
+			logger.Debug(sharedConnsLogSender, conn.ConnectionID, "close connection requested by another node, found: %v",
+				result)
+		}
+	}
+	if err := dataprovider.CleanupSharedConnections(m.getStaleLimit()); err != nil {
+		logger.Warn(sharedConnsLogSender, "", "unable to remove stale shared connections: %v", err)
+	}
+}
+
+func (m *sharedConnectionsManager) getStaleLimit() int64 {
+	return utils.GetTimeAsMsSinceEpoch(time.Now().Add(-sharedConnsStaleIntervals * m.updateInterval))
+}
+
+func (m *sharedConnectionsManager) add(stat *ConnectionStatus) {
+	payload, err := json.Marshal(stat)
+	if err != nil {
+		logger.Warn(sharedConnsLogSender, stat.ConnectionID, "unable to serialize connection: %v", err)
+		return
+	}
+	err = dataprovider.AddSharedConnection(&dataprovider.SharedConnection{
+		ConnectionID: stat.ConnectionID,
+		NodeID:       m.nodeID,
+		Username:     stat.Username,
+		Payload:      string(payload),
+	})
+	if err != nil {
+		logger.Warn(sharedConnsLogSender, stat.ConnectionID, "unable to add shared connection: %v", err)
+	}
+}
+
+func (m *sharedConnectionsManager) remove(connectionID string) {
+	err := dataprovider.DeleteSharedConnection(m.nodeID, connectionID)
+	if err != nil {
+		if _, ok := err.(*dataprovider.RecordNotFoundError); !ok {
+			logger.Warn(sharedConnsLogSender, connectionID, "unable to remove shared connection: %v", err)
+		}
+	}
+}
+
+// getRemoteConnections returns the active connections handled by the other nodes
+func (m *sharedConnectionsManager) getRemoteConnections() []dataprovider.SharedConnection {
+	conns, err := dataprovider.GetSharedConnections(m.getStaleLimit())
+	if err != nil {
+		logger.Warn(sharedConnsLogSender, "", "unable to get shared connections: %v", err)
+		return nil
+	}
+	result := make([]dataprovider.SharedConnection, 0, len(conns))
+	for _, conn := range conns {
+		if conn.NodeID != m.nodeID {
+			result = append(result, conn)
+		}
+	}
+	return result
+}
+
+// getRemoteStats returns the stats for the active connections handled by the other
+// nodes. The connection ids include the node id so they are unique across the nodes
+func (m *sharedConnectionsManager) getRemoteStats() []*ConnectionStatus {
+	var stats []*ConnectionStatus
+	for _, conn := range m.getRemoteConnections() {
+		stat := &ConnectionStatus{}
+		if err := json.Unmarshal([]byte(conn.Payload), stat); err != nil {
+			logger.Warn(sharedConnsLogSender, conn.ConnectionID, "unable to decode shared connection from node %#v: %v",
+				conn.NodeID, err)
+			continue
+		}
+		stat.ConnectionID = conn.ConnectionID + remoteConnectionSeparator + conn.NodeID
+		stat.Node = conn.NodeID
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// getRemoteSessions returns the number of active sessions for the given
+// username handled by the other nodes
+func (m *sharedConnectionsManager) getRemoteSessions(username string) int {
+	numSessions := 0
+	for _, conn := range m.getRemoteConnections() {
+		if conn.Username == username {
+			numSessions++
+		}
+	}
+	return numSessions
+}
+
+// requestClose asks the node handling the given remote connection to close it.
+// The close request is processed by the node within its update interval
+func (m *sharedConnectionsManager) requestClose(connectionID string) bool {
+	idx := strings.LastIndex(connectionID, remoteConnectionSeparator)
+	if idx < 0 {
+		return false
+	}
+	connID := connectionID[:idx]
+	nodeID := connectionID[idx+1:]
+	if nodeID == m.nodeID {
+		return false
+	}
+	err := dataprovider.RequestSharedConnectionClose(nodeID, connID)
+	logger.Debug(sharedConnsLogSender, connID, "close connection requested for node %#v, error: %v", nodeID, err)
+	return err == nil
+}
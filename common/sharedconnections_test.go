@@ -0,0 +1,102 @@
+package common
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+func TestSharedConnectionsConfig(t *testing.T) {
+	c := SharedConnectionsConfig{
+		Enabled:        true,
+		UpdateInterval: 0,
+	}
+	assert.Error(t, c.validate())
+	c.UpdateInterval = 10
+	c.NodeID = "node@1"
+	assert.Error(t, c.validate())
+	c.NodeID = ""
+	assert.NoError(t, c.validate())
+	nodeID, err := c.getNodeID()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, nodeID)
+	c.NodeID = "node1"
+	nodeID, err = c.getNodeID()
+	assert.NoError(t, err)
+	assert.Equal(t, "node1", nodeID)
+}
+
+func TestSharedConnections(t *testing.T) {
+	err := startSharedConnections(&SharedConnectionsConfig{
+		Enabled:        true,
+		NodeID:         "node1",
+		UpdateInterval: 60,
+	})
+	require.NoError(t, err)
+
+	username := "shared_user"
+	c := NewBaseConnection("shared_id", ProtocolSFTP, dataprovider.User{Username: username})
+	fakeConn := &fakeConnection{
+		BaseConnection: c,
+	}
+	Connections.Add(fakeConn)
+	// simulate a connection handled by another node
+	payload, err := json.Marshal(&ConnectionStatus{
+		Username:     username,
+		ConnectionID: "FTP_1",
+		Protocol:     ProtocolFTP,
+	})
+	require.NoError(t, err)
+	err = dataprovider.AddSharedConnection(&dataprovider.SharedConnection{
+		ConnectionID: "FTP_1",
+		NodeID:       "node2",
+		Username:     username,
+		Payload:      string(payload),
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, Connections.GetActiveSessions(username))
+	stats := Connections.GetStats()
+	require.Len(t, stats, 2)
+	for _, stat := range stats {
+		if stat.Protocol == ProtocolFTP {
+			assert.Equal(t, "FTP_1@node2", stat.ConnectionID)
+			assert.Equal(t, "node2", stat.Node)
+		} else {
+			assert.Equal(t, fakeConn.GetID(), stat.ConnectionID)
+			assert.Equal(t, "node1", stat.Node)
+		}
+	}
+	assert.False(t, Connections.Close("missing@node2"))
+	assert.False(t, Connections.Close(fakeConn.GetID()+"@node1"))
+	assert.True(t, Connections.Close("FTP_1@node2"))
+	conns, err := dataprovider.GetSharedConnections(0)
+	assert.NoError(t, err)
+	for _, conn := range conns {
+		assert.Equal(t, conn.NodeID == "node2", conn.CloseRequested, conn.NodeID)
+	}
+	// a close request for a connection handled by this node is processed on update
+	err = dataprovider.RequestSharedConnectionClose("node1", fakeConn.GetID())
+	assert.NoError(t, err)
+	sharedConns.update()
+	assert.Len(t, Connections.getLocalStats(), 0)
+	conns, err = dataprovider.GetSharedConnections(0)
+	assert.NoError(t, err)
+	if assert.Len(t, conns, 1) {
+		assert.Equal(t, "node2", conns[0].NodeID)
+	}
+	assert.Equal(t, 1, Connections.GetActiveSessions(username))
+
+	err = dataprovider.CleanupSharedConnections(utils.GetTimeAsMsSinceEpoch(time.Now().Add(time.Minute)))
+	assert.NoError(t, err)
+	assert.Len(t, Connections.GetStats(), 0)
+
+	stopSharedConnections()
+	assert.Nil(t, sharedConns)
+}
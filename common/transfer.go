@@ -1,7 +1,10 @@
 package common
 
 import (
+	"crypto/sha256"
 	"errors"
+	"fmt"
+	"hash"
 	"path"
 	"sync"
 	"sync/atomic"
@@ -16,28 +19,39 @@ import (
 var (
 	// ErrTransferClosed defines the error returned for a closed transfer
 	ErrTransferClosed = errors.New("transfer already closed")
+	// ErrChecksumMismatch defines the error returned if the checksum of a downloaded
+	// file does not match the one stored after the upload
+	ErrChecksumMismatch = errors.New("checksum mismatch")
 )
 
 // BaseTransfer contains protocols common transfer details for an upload or a download.
 type BaseTransfer struct { //nolint:maligned
-	ID             uint64
-	BytesSent      int64
-	BytesReceived  int64
-	Fs             vfs.Fs
-	File           vfs.File
-	Connection     *BaseConnection
-	cancelFn       func()
-	fsPath         string
-	requestPath    string
-	start          time.Time
-	MaxWriteSize   int64
-	MinWriteOffset int64
-	InitialSize    int64
-	isNewFile      bool
-	transferType   int
-	AbortTransfer  int32
+	ID              uint64
+	BytesSent       int64
+	BytesReceived   int64
+	Fs              vfs.Fs
+	File            vfs.File
+	Connection      *BaseConnection
+	cancelFn        func()
+	fsPath          string
+	effectiveFsPath string
+	requestPath     string
+	start           time.Time
+	MaxWriteSize    int64
+	MinWriteOffset  int64
+	InitialSize     int64
+	isNewFile       bool
+	transferType    int
+	AbortTransfer   int32
 	sync.Mutex
 	ErrTransfer error
+	// SHA-256 computed while transferring data, nil if disabled
+	checksum         hash.Hash
+	checksumOffset   int64
+	expectedChecksum string
+	expectedSize     int64
+	// closed to stop the transfer_progress notifications, nil if disabled
+	progressDone chan bool
 }
 
 // NewBaseTransfer returns a new BaseTransfer and adds it to the given connection
@@ -62,10 +76,175 @@ func NewBaseTransfer(file vfs.File, conn *BaseConnection, cancelFn func(), fsPat
 		Fs:             fs,
 	}
 
+	t.initChecksum()
 	conn.AddTransfer(t)
+	t.startProgress()
 	return t
 }
 
+func (t *BaseTransfer) startProgress() {
+	if !Config.Actions.isProgressEnabled() {
+		return
+	}
+	t.progressDone = make(chan bool)
+	go t.progressLoop(time.Duration(Config.Actions.ProgressInterval)*time.Second, t.progressDone)
+}
+
+func (t *BaseTransfer) stopProgress() {
+	if t.progressDone != nil {
+		close(t.progressDone)
+		t.progressDone = nil
+	}
+}
+
+// progressLoop periodically sends the transfer_progress notifications until the
+// transfer is closed. A zero throughput means that the transfer is stalled
+func (t *BaseTransfer) progressLoop(interval time.Duration, done chan bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	totalSize := t.getTotalSize()
+	lastSize := t.GetSize()
+	lastCheck := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			size := t.GetSize()
+			action := t.newTransferNotification(operationTransferProgress, size, nil)
+			action.Throughput = getThroughput(size-lastSize, now.Sub(lastCheck).Milliseconds())
+			if totalSize > 0 {
+				action.Percent = int(size * 100 / totalSize)
+				if action.Percent > 100 {
+					action.Percent = 100
+				}
+			}
+			notifyAction(action)
+			lastSize = size
+			lastCheck = now
+		}
+	}
+}
+
+// getTotalSize returns the size of the file to download, 0 for uploads
+// or if the size cannot be determined
+func (t *BaseTransfer) getTotalSize() int64 {
+	if t.transferType != TransferDownload {
+		return 0
+	}
+	if t.expectedSize > 0 {
+		return t.expectedSize
+	}
+	info, err := t.Fs.Stat(t.fsPath)
+	if err != nil {
+		return 0
+	}
+	if vfs.IsCryptOsFs(t.Fs) {
+		info = t.Fs.(*vfs.CryptFs).ConvertFileInfo(info)
+	}
+	return info.Size()
+}
+
+func (t *BaseTransfer) newTransferNotification(operation string, size int64, err error) *ActionNotification {
+	action := t.Connection.newActionNotification(operation, t.fsPath, "", size, err)
+	action.VirtualPath = t.requestPath
+	action.Elapsed = time.Since(t.start).Milliseconds()
+	if t.transferType == TransferDownload {
+		action.TransferType = operationDownload
+	} else {
+		action.TransferType = operationUpload
+	}
+	return action
+}
+
+func (t *BaseTransfer) initChecksum() {
+	if !Config.UploadChecksums {
+		return
+	}
+	checksumFs, ok := vfs.GetChecksumFs(t.Fs)
+	if !ok {
+		return
+	}
+	if t.transferType == TransferUpload {
+		if t.MinWriteOffset == 0 {
+			t.checksum = sha256.New()
+		}
+		return
+	}
+	checksum, err := checksumFs.GetChecksum(t.fsPath)
+	if err != nil || checksum == "" {
+		return
+	}
+	info, err := t.Fs.Stat(t.fsPath)
+	if err != nil {
+		return
+	}
+	if vfs.IsCryptOsFs(t.Fs) {
+		info = t.Fs.(*vfs.CryptFs).ConvertFileInfo(info)
+	}
+	t.expectedChecksum = checksum
+	t.expectedSize = info.Size()
+	t.checksum = sha256.New()
+}
+
+// UpdateChecksum updates the transfer checksum with the data transferred at the
+// specified offset. The checksum is only computed for sequential transfers
+// starting at offset 0, it is disabled as soon as a non sequential offset is found
+func (t *BaseTransfer) UpdateChecksum(p []byte, off int64) {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.checksum == nil || len(p) == 0 {
+		return
+	}
+	if off != t.checksumOffset {
+		t.Connection.Log(logger.LevelDebug, "non sequential transfer for %#v, checksum disabled, offset: %v, expected: %v",
+			t.fsPath, off, t.checksumOffset)
+		t.checksum = nil
+		return
+	}
+	t.checksum.Write(p) //nolint:errcheck
+	t.checksumOffset += int64(len(p))
+}
+
+// DisableChecksum disables the checksum computation for this transfer
+func (t *BaseTransfer) DisableChecksum() {
+	t.Lock()
+	defer t.Unlock()
+
+	t.checksum = nil
+}
+
+// finalizeChecksum stores the checksum for uploads and verifies it for downloads.
+// It returns the computed checksum or an empty string
+func (t *BaseTransfer) finalizeChecksum() string {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.checksum == nil || t.ErrTransfer != nil {
+		return ""
+	}
+	checksum := fmt.Sprintf("%x", t.checksum.Sum(nil))
+	if t.transferType == TransferUpload {
+		checksumFs, _ := vfs.GetChecksumFs(t.Fs)
+		if err := checksumFs.SetChecksum(t.fsPath, checksum); err != nil {
+			t.Connection.Log(logger.LevelWarn, "unable to store checksum for file %#v: %v", t.fsPath, err)
+		}
+		return checksum
+	}
+	if t.checksumOffset != t.expectedSize {
+		// partial download, we cannot verify the checksum
+		return ""
+	}
+	if checksum != t.expectedChecksum {
+		t.Connection.Log(logger.LevelError, "checksum mismatch for downloaded file %#v, expected: %v, actual: %v",
+			t.fsPath, t.expectedChecksum, checksum)
+		t.ErrTransfer = ErrChecksumMismatch
+	}
+	return checksum
+}
+
 // GetID returns the transfer ID
 func (t *BaseTransfer) GetID() uint64 {
 	return t.ID
@@ -111,14 +290,28 @@ func (t *BaseTransfer) GetFsPath() string {
 // If atomic uploads are enabled this differ from fsPath
 func (t *BaseTransfer) GetRealFsPath(fsPath string) string {
 	if fsPath == t.GetFsPath() {
-		if t.File != nil {
-			return t.File.Name()
-		}
-		return t.fsPath
+		return t.getEffectiveFsPath()
 	}
 	return ""
 }
 
+// SetEffectiveFsPath sets the filesystem path where the data are really written.
+// This is required for atomic uploads to filesystems that don't return a File
+// handle, for example cloud storage backends
+func (t *BaseTransfer) SetEffectiveFsPath(fsPath string) {
+	t.effectiveFsPath = fsPath
+}
+
+func (t *BaseTransfer) getEffectiveFsPath() string {
+	if t.File != nil {
+		return t.File.Name()
+	}
+	if t.effectiveFsPath != "" {
+		return t.effectiveFsPath
+	}
+	return t.fsPath
+}
+
 // SetCancelFn sets the cancel function for the transfer
 func (t *BaseTransfer) SetCancelFn(cancelFn func()) {
 	t.cancelFn = cancelFn
@@ -133,11 +326,12 @@ func (t *BaseTransfer) Truncate(fsPath string, size int64) (int64, error) {
 			err := t.File.Truncate(size)
 			if err == nil {
 				t.Lock()
+				t.checksum = nil
 				t.InitialSize = size
 				if t.MaxWriteSize > 0 {
 					sizeDiff := initialSize - size
 					t.MaxWriteSize += sizeDiff
-					metrics.TransferCompleted(atomic.LoadInt64(&t.BytesSent), atomic.LoadInt64(&t.BytesReceived), t.transferType, t.ErrTransfer)
+					metrics.TransferCompleted(t.Connection.GetUsername(), atomic.LoadInt64(&t.BytesSent), atomic.LoadInt64(&t.BytesReceived), t.transferType, t.ErrTransfer)
 					atomic.StoreInt64(&t.BytesReceived, 0)
 				}
 				t.Unlock()
@@ -199,12 +393,15 @@ func (t *BaseTransfer) getUploadFileSize() (int64, error) {
 func (t *BaseTransfer) Close() error {
 	defer t.Connection.RemoveTransfer(t)
 
+	t.stopProgress()
+	transferredSize := t.GetSize()
 	var err error
 	numFiles := 0
 	if t.isNewFile {
 		numFiles = 1
 	}
-	metrics.TransferCompleted(atomic.LoadInt64(&t.BytesSent), atomic.LoadInt64(&t.BytesReceived), t.transferType, t.ErrTransfer)
+	metrics.TransferCompleted(t.Connection.GetUsername(), atomic.LoadInt64(&t.BytesSent), atomic.LoadInt64(&t.BytesReceived), t.transferType, t.ErrTransfer)
+	metrics.TransferFinished(t.Connection.protocol, t.transferType, transferredSize, time.Since(t.start), t.ErrTransfer)
 	if t.ErrTransfer == ErrQuotaExceeded && t.File != nil {
 		// if quota is exceeded we try to remove the partial file for uploads to local filesystem
 		err = t.Fs.Remove(t.File.Name(), false)
@@ -215,15 +412,20 @@ func (t *BaseTransfer) Close() error {
 		}
 		t.Connection.Log(logger.LevelWarn, "upload denied due to space limit, delete temporary file: %#v, deletion error: %v",
 			t.File.Name(), err)
-	} else if t.transferType == TransferUpload && t.File != nil && t.File.Name() != t.fsPath {
-		if t.ErrTransfer == nil || Config.UploadMode == UploadModeAtomicWithResume {
-			err = t.Fs.Rename(t.File.Name(), t.fsPath)
+	} else if t.transferType == TransferUpload && t.getEffectiveFsPath() != t.fsPath {
+		effectiveFsPath := t.getEffectiveFsPath()
+		// partial uploads to cloud storage backends cannot be resumed
+		if t.ErrTransfer == nil || (t.Connection.GetUploadMode() == UploadModeAtomicWithResume && t.Fs.Capabilities().UploadResume) {
+			err = t.Fs.Rename(effectiveFsPath, t.fsPath)
 			t.Connection.Log(logger.LevelDebug, "atomic upload completed, rename: %#v -> %#v, error: %v",
-				t.File.Name(), t.fsPath, err)
+				effectiveFsPath, t.fsPath, err)
+			if err != nil {
+				t.DisableChecksum()
+			}
 		} else {
-			err = t.Fs.Remove(t.File.Name(), false)
+			err = t.Fs.Remove(effectiveFsPath, false)
 			t.Connection.Log(logger.LevelWarn, "atomic upload completed with error: \"%v\", delete temporary file: %#v, "+
-				"deletion error: %v", t.ErrTransfer, t.File.Name(), err)
+				"deletion error: %v", t.ErrTransfer, effectiveFsPath, err)
 			if err == nil {
 				numFiles--
 				atomic.StoreInt64(&t.BytesReceived, 0)
@@ -231,13 +433,18 @@ func (t *BaseTransfer) Close() error {
 			}
 		}
 	}
+	checksum := t.finalizeChecksum()
 	elapsed := time.Since(t.start).Nanoseconds() / 1000000
 	if t.transferType == TransferDownload {
 		logger.TransferLog(downloadLogSender, t.fsPath, elapsed, atomic.LoadInt64(&t.BytesSent), t.Connection.User.Username,
 			t.Connection.ID, t.Connection.protocol)
-		action := newActionNotification(&t.Connection.User, operationDownload, t.fsPath, "", "", t.Connection.protocol,
-			atomic.LoadInt64(&t.BytesSent), t.ErrTransfer)
-		go actionHandler.Handle(action) //nolint:errcheck
+		action := t.Connection.newActionNotification(operationDownload, t.fsPath, "", atomic.LoadInt64(&t.BytesSent),
+			t.ErrTransfer)
+		action.Checksum = checksum
+		action.VirtualPath = t.requestPath
+		action.Elapsed = elapsed
+		action.Throughput = getThroughput(atomic.LoadInt64(&t.BytesSent), elapsed)
+		notifyAction(action)
 	} else {
 		fileSize := atomic.LoadInt64(&t.BytesReceived) + t.MinWriteOffset
 		if statSize, err := t.getUploadFileSize(); err == nil {
@@ -247,11 +454,15 @@ func (t *BaseTransfer) Close() error {
 		t.updateQuota(numFiles, fileSize)
 		logger.TransferLog(uploadLogSender, t.fsPath, elapsed, atomic.LoadInt64(&t.BytesReceived), t.Connection.User.Username,
 			t.Connection.ID, t.Connection.protocol)
-		action := newActionNotification(&t.Connection.User, operationUpload, t.fsPath, "", "", t.Connection.protocol,
-			fileSize, t.ErrTransfer)
-		go actionHandler.Handle(action) //nolint:errcheck
+		action := t.Connection.newActionNotification(operationUpload, t.fsPath, "", fileSize, t.ErrTransfer)
+		action.Checksum = checksum
+		action.VirtualPath = t.requestPath
+		action.Elapsed = elapsed
+		action.Throughput = getThroughput(atomic.LoadInt64(&t.BytesReceived), elapsed)
+		notifyAction(action)
 	}
 	if t.ErrTransfer != nil {
+		notifyAction(t.newTransferNotification(operationTransferAborted, transferredSize, t.ErrTransfer))
 		t.Connection.Log(logger.LevelWarn, "transfer error: %v, path: %#v", t.ErrTransfer, t.fsPath)
 		if err == nil {
 			err = t.ErrTransfer
@@ -260,6 +471,15 @@ func (t *BaseTransfer) Close() error {
 	return err
 }
 
+// getThroughput returns the average throughput, in bytes per second, for the
+// given transferred bytes and elapsed milliseconds
+func getThroughput(transferredBytes, elapsed int64) int64 {
+	if elapsed <= 0 {
+		elapsed = 1
+	}
+	return transferredBytes * 1000 / elapsed
+}
+
 func (t *BaseTransfer) updateQuota(numFiles int, fileSize int64) bool {
 	// S3 uploads are atomic, if there is an error nothing is uploaded
 	if t.File == nil && t.ErrTransfer != nil {
@@ -277,6 +497,7 @@ func (t *BaseTransfer) updateQuota(numFiles int, fileSize int64) bool {
 		} else {
 			dataprovider.UpdateUserQuota(&t.Connection.User, numFiles, sizeDiff, false) //nolint:errcheck
 		}
+		dataprovider.UpdateDirQuota(&t.Connection.User, t.requestPath, numFiles, sizeDiff)
 		return true
 	}
 	return false
@@ -1,9 +1,12 @@
 package common
 
 import (
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -175,6 +178,66 @@ func TestTruncate(t *testing.T) {
 	assert.Len(t, conn.GetTransfers(), 0)
 }
 
+func TestTransferChecksum(t *testing.T) {
+	Config.UploadChecksums = true
+	defer func() {
+		Config.UploadChecksums = false
+	}()
+
+	testFile := filepath.Join(os.TempDir(), "checksum_test_file")
+	data := []byte("sftpgo checksum test")
+	err := os.WriteFile(testFile, data, os.ModePerm)
+	require.NoError(t, err)
+	fs := vfs.NewOsFs("123", os.TempDir(), "")
+	if err := fs.SetChecksum(testFile, "checksum"); err != nil {
+		os.Remove(testFile)
+		t.Skipf("extended attributes are not supported: %v", err)
+	}
+	u := dataprovider.User{
+		Username: "user",
+		HomeDir:  os.TempDir(),
+	}
+	conn := NewBaseConnection(fs.ConnectionID(), ProtocolSFTP, u)
+	transfer := NewBaseTransfer(nil, conn, nil, testFile, "/checksum_test_file", TransferUpload, 0, 0, 0, true, fs)
+	transfer.UpdateChecksum(data[:6], 0)
+	transfer.UpdateChecksum(data[6:], 6)
+	err = transfer.Close()
+	assert.NoError(t, err)
+	checksum, err := fs.GetChecksum(testFile)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(data)), checksum)
+
+	transfer = NewBaseTransfer(nil, conn, nil, testFile, "/checksum_test_file", TransferDownload, 0, 0, 0, false, fs)
+	transfer.UpdateChecksum(data, 0)
+	err = transfer.Close()
+	assert.NoError(t, err)
+	// simulate a corrupted read
+	transfer = NewBaseTransfer(nil, conn, nil, testFile, "/checksum_test_file", TransferDownload, 0, 0, 0, false, fs)
+	transfer.UpdateChecksum([]byte("corrupted sftpgo data"[:len(data)]), 0)
+	err = transfer.Close()
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	// the checksum is not verified for non sequential and partial reads
+	transfer = NewBaseTransfer(nil, conn, nil, testFile, "/checksum_test_file", TransferDownload, 0, 0, 0, false, fs)
+	transfer.UpdateChecksum(data[6:], 6)
+	transfer.UpdateChecksum(data[:6], 0)
+	err = transfer.Close()
+	assert.NoError(t, err)
+	transfer = NewBaseTransfer(nil, conn, nil, testFile, "/checksum_test_file", TransferDownload, 0, 0, 0, false, fs)
+	transfer.UpdateChecksum([]byte("corrupted"), 0)
+	err = transfer.Close()
+	assert.NoError(t, err)
+	// a stale checksum is ignored
+	err = os.WriteFile(testFile, []byte("modified"), os.ModePerm)
+	assert.NoError(t, err)
+	checksum, err = fs.GetChecksum(testFile)
+	assert.NoError(t, err)
+	assert.Empty(t, checksum)
+
+	err = os.Remove(testFile)
+	assert.NoError(t, err)
+	assert.Len(t, conn.GetTransfers(), 0)
+}
+
 func TestTransferErrors(t *testing.T) {
 	isCancelled := false
 	cancelFn := func() {
@@ -253,6 +316,45 @@ func TestTransferErrors(t *testing.T) {
 	assert.Len(t, conn.GetTransfers(), 0)
 }
 
+func TestEffectiveFsPath(t *testing.T) {
+	fs := vfs.NewOsFs("id", os.TempDir(), "")
+	u := dataprovider.User{
+		Username: "test",
+		HomeDir:  os.TempDir(),
+	}
+	conn := NewBaseConnection("id", ProtocolSFTP, u)
+	fsPath := filepath.Join(os.TempDir(), "test_file")
+	tempPath := fs.GetAtomicUploadPath(fsPath)
+	err := os.WriteFile(tempPath, []byte("test data"), os.ModePerm)
+	assert.NoError(t, err)
+	// cloud filesystems don't return a File handle
+	transfer := NewBaseTransfer(nil, conn, nil, fsPath, "/test_file", TransferUpload, 0, 0, 0, true, fs)
+	assert.Equal(t, fsPath, transfer.GetRealFsPath(fsPath))
+	transfer.SetEffectiveFsPath(tempPath)
+	assert.Equal(t, tempPath, transfer.GetRealFsPath(fsPath))
+	assert.Equal(t, tempPath, conn.getRealFsPath(fsPath))
+	transfer.BytesReceived = 9
+	err = transfer.Close()
+	assert.NoError(t, err)
+	assert.NoFileExists(t, tempPath)
+	assert.FileExists(t, fsPath)
+	err = os.Remove(fsPath)
+	assert.NoError(t, err)
+
+	err = os.WriteFile(tempPath, []byte("test data"), os.ModePerm)
+	assert.NoError(t, err)
+	transfer = NewBaseTransfer(nil, conn, nil, fsPath, "/test_file", TransferUpload, 0, 0, 0, true, fs)
+	transfer.SetEffectiveFsPath(tempPath)
+	errFake := errors.New("fake error")
+	transfer.TransferError(errFake)
+	err = transfer.Close()
+	assert.ErrorIs(t, err, errFake)
+	assert.NoFileExists(t, tempPath)
+	assert.NoFileExists(t, fsPath)
+
+	assert.Len(t, conn.GetTransfers(), 0)
+}
+
 func TestRemovePartialCryptoFile(t *testing.T) {
 	testFile := filepath.Join(os.TempDir(), "transfer_test_file")
 	fs, err := vfs.NewCryptFs("id", os.TempDir(), "", vfs.CryptFsConfig{Passphrase: kms.NewPlainSecret("secret")})
@@ -273,3 +375,58 @@ func TestRemovePartialCryptoFile(t *testing.T) {
 	assert.Equal(t, int64(9), size)
 	assert.NoFileExists(t, testFile)
 }
+
+func TestTransferThroughput(t *testing.T) {
+	assert.Equal(t, int64(2048), getThroughput(4096, 2000))
+	assert.Equal(t, int64(0), getThroughput(0, 100))
+	assert.Equal(t, int64(1000), getThroughput(1, 0))
+}
+
+func TestTransferProgress(t *testing.T) {
+	handler := &recordingActionHandlerStub{}
+	InitializeActionHandler(handler)
+	Config.Actions.ProgressInterval = 1
+	t.Cleanup(func() {
+		Config.Actions.ProgressInterval = 0
+		InitializeActionHandler(&defaultActionHandler{})
+	})
+
+	testFile := filepath.Join(os.TempDir(), "transfer_progress_file")
+	err := os.WriteFile(testFile, make([]byte, 100), os.ModePerm)
+	require.NoError(t, err)
+	fs := vfs.NewOsFs("id", os.TempDir(), "")
+	conn := NewBaseConnection("id", ProtocolSFTP, dataprovider.User{Username: "test", HomeDir: os.TempDir()})
+	transfer := NewBaseTransfer(nil, conn, nil, testFile, "/transfer_progress_file", TransferDownload, 0, 0, 0,
+		false, fs)
+	atomic.StoreInt64(&transfer.BytesSent, 50)
+	assert.Eventually(t, func() bool {
+		return handler.getNotification(operationTransferProgress, "") != nil
+	}, 3*time.Second, 100*time.Millisecond)
+	n := handler.getNotification(operationTransferProgress, "")
+	assert.Equal(t, int64(50), n.FileSize)
+	assert.Equal(t, 50, n.Percent)
+	assert.Equal(t, operationDownload, n.TransferType)
+	assert.Equal(t, "/transfer_progress_file", n.VirtualPath)
+
+	transfer.TransferError(errors.New("connection lost"))
+	err = transfer.Close()
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		return handler.getNotification(operationTransferAborted, "") != nil
+	}, 1*time.Second, 50*time.Millisecond)
+	n = handler.getNotification(operationTransferAborted, "")
+	assert.Equal(t, int64(50), n.FileSize)
+	assert.Equal(t, 0, n.Status)
+	assert.Equal(t, operationDownload, n.TransferType)
+	// no progress notifications are sent after the transfer is closed
+	handler.Lock()
+	numNotifications := len(handler.notifications)
+	handler.Unlock()
+	time.Sleep(1500 * time.Millisecond)
+	handler.Lock()
+	assert.Len(t, handler.notifications, numNotifications)
+	handler.Unlock()
+
+	err = os.Remove(testFile)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,239 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// trashIDFormat is used to generate sortable identifiers for the deleted files
+const trashIDFormat = "20060102T150405.000000000Z"
+
+var errTrashTargetExists = errors.New("a file with the same name already exists")
+
+// TrashItem defines a file inside the trash
+type TrashItem struct {
+	// identifier for the delete operation, it is required to restore the file
+	ID string `json:"id"`
+	// original virtual path
+	Path string `json:"path"`
+	// file size in bytes
+	Size int64 `json:"size"`
+	// deletion time as unix timestamp in milliseconds
+	DeletedAt int64 `json:"deleted_at"`
+}
+
+// keepDeletedFile saves the file at fsPath as a new version or moves it inside the trash,
+// if versioning or trash are enabled. Returns true if the file was kept
+func (c *BaseConnection) keepDeletedFile(fs vfs.Fs, fsPath, virtualPath string) (bool, error) {
+	saved, err := c.SaveFileVersion(fs, fsPath, virtualPath)
+	if err != nil || saved {
+		return saved, err
+	}
+	return c.moveToTrash(fs, fsPath, virtualPath)
+}
+
+// getTrashItemPath returns the virtual and the filesystem path for the given
+// file deleted with the specified trash identifier
+func (c *BaseConnection) getTrashItemPath(fs vfs.Fs, virtualPath, trashID string) (string, string, error) {
+	mountPath := c.User.GetMountPath(virtualPath)
+	relPath := strings.TrimPrefix(virtualPath, mountPath)
+	trashPath := path.Join(mountPath, dataprovider.TrashDirName, trashID, relPath)
+	fsPath, err := fs.ResolvePath(trashPath)
+	return trashPath, fsPath, err
+}
+
+// moveToTrash moves the file at fsPath inside the trash, if enabled.
+// Returns true if the file was moved
+func (c *BaseConnection) moveToTrash(fs vfs.Fs, fsPath, virtualPath string) (bool, error) {
+	if !c.User.Filters.Trash.Enabled {
+		return false, nil
+	}
+	_, target, err := c.getTrashItemPath(fs, virtualPath, time.Now().UTC().Format(trashIDFormat))
+	if err != nil {
+		return false, err
+	}
+	if err := c.createParentDirs(fs, target); err != nil {
+		c.Log(logger.LevelWarn, "unable to create trash dir for %#v: %+v", target, err)
+		return false, err
+	}
+	if err := fs.Rename(fsPath, target); err != nil {
+		c.Log(logger.LevelWarn, "unable to move file %#v to trash: %+v", fsPath, err)
+		return false, err
+	}
+	c.Log(logger.LevelDebug, "file %#v moved to trash %#v", fsPath, target)
+	c.purgeTrash(fs, c.User.GetMountPath(virtualPath))
+	return true, nil
+}
+
+// purgeTrash removes the files deleted more than the configured retention days ago
+// from the trash of the filesystem mounted at mountPath
+func (c *BaseConnection) purgeTrash(fs vfs.Fs, mountPath string) {
+	if c.User.Filters.Trash.RetentionDays <= 0 {
+		return
+	}
+	trashPath := path.Join(mountPath, dataprovider.TrashDirName)
+	fsTrashPath, err := fs.ResolvePath(trashPath)
+	if err != nil {
+		return
+	}
+	entries, err := fs.ReadDir(fsTrashPath)
+	if err != nil {
+		return
+	}
+	limit := time.Now().Add(-time.Duration(c.User.Filters.Trash.RetentionDays) * 24 * time.Hour)
+	for _, entry := range entries {
+		deletedAt, err := time.Parse(trashIDFormat, entry.Name())
+		if err != nil || deletedAt.After(limit) {
+			continue
+		}
+		c.removeTrashDir(fs, fs.Join(fsTrashPath, entry.Name()), path.Join(trashPath, entry.Name()))
+	}
+}
+
+// removeTrashDir removes the given trash directory and its contents and updates the quota
+func (c *BaseConnection) removeTrashDir(fs vfs.Fs, fsPath, virtualPath string) {
+	var dirs []string
+	err := fs.Walk(fsPath, func(walkedPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, walkedPath)
+			return nil
+		}
+		if err := fs.Remove(walkedPath, false); err != nil {
+			c.Log(logger.LevelWarn, "unable to remove expired trash file %#v: %+v", walkedPath, err)
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			c.updateQuotaForPath(virtualPath, -1, -info.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		c.Log(logger.LevelWarn, "unable to walk expired trash dir %#v: %+v", fsPath, err)
+		return
+	}
+	// remove the deepest directories first, cloud filesystems have no real directories
+	// so errors are ignored
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, dir := range dirs {
+		fs.Remove(dir, true) //nolint:errcheck
+	}
+	c.Log(logger.LevelDebug, "expired trash dir %#v removed", fsPath)
+}
+
+// ListTrash returns the files inside the trash, most recently deleted first.
+// Expired files are removed before listing
+func (c *BaseConnection) ListTrash() ([]TrashItem, error) {
+	items := []TrashItem{}
+	if !c.User.Filters.Trash.Enabled {
+		return items, c.GetOpUnsupportedError()
+	}
+	for _, mountPath := range c.User.GetMountPaths() {
+		fs, fsTrashPath, err := c.GetFsAndResolvedPath(path.Join(mountPath, dataprovider.TrashDirName))
+		if err != nil {
+			c.Log(logger.LevelWarn, "unable to get trash path for mount %#v: %+v", mountPath, err)
+			continue
+		}
+		c.purgeTrash(fs, mountPath)
+		err = fs.Walk(fsTrashPath, func(walkedPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			relPath := strings.TrimPrefix(fs.GetRelativePath(walkedPath), path.Join(mountPath, dataprovider.TrashDirName))
+			parts := strings.SplitN(strings.TrimPrefix(relPath, "/"), "/", 2)
+			if len(parts) != 2 {
+				return nil
+			}
+			deletedAt, err := time.Parse(trashIDFormat, parts[0])
+			if err != nil {
+				return nil
+			}
+			virtualPath := path.Join(mountPath, parts[1])
+			if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(virtualPath)) {
+				return nil
+			}
+			items = append(items, TrashItem{
+				ID:        parts[0],
+				Path:      virtualPath,
+				Size:      info.Size(),
+				DeletedAt: utils.GetTimeAsMsSinceEpoch(deletedAt),
+			})
+			return nil
+		})
+		if err != nil && !fs.IsNotExist(err) {
+			c.Log(logger.LevelWarn, "unable to list trash for mount %#v: %+v", mountPath, err)
+			return items, c.GetFsError(fs, err)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].ID == items[j].ID {
+			return items[i].Path < items[j].Path
+		}
+		return items[i].ID > items[j].ID
+	})
+	return items, nil
+}
+
+// RestoreFromTrash moves the file deleted with the given trash identifier back to
+// its original virtual path. The restore fails if a file with the same name exists
+func (c *BaseConnection) RestoreFromTrash(virtualPath, trashID string) error {
+	if !c.User.Filters.Trash.Enabled {
+		return c.GetOpUnsupportedError()
+	}
+	if _, err := time.Parse(trashIDFormat, trashID); err != nil {
+		c.Log(logger.LevelWarn, "invalid trash id %#v: %v", trashID, err)
+		return c.GetNotExistError()
+	}
+	if !c.User.IsFileAllowed(virtualPath) {
+		c.Log(logger.LevelWarn, "restoring file %#v is not allowed", virtualPath)
+		return c.GetPermissionDeniedError()
+	}
+	if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualPath)) {
+		return c.GetPermissionDeniedError()
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return err
+	}
+	_, fsTrashPath, err := c.getTrashItemPath(fs, virtualPath, trashID)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	info, err := fs.Lstat(fsTrashPath)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	if !info.Mode().IsRegular() {
+		return c.GetNotExistError()
+	}
+	if _, err := fs.Lstat(fsPath); err == nil {
+		c.Log(logger.LevelInfo, "unable to restore %#v from trash: %v", virtualPath, errTrashTargetExists)
+		return c.GetGenericError(errTrashTargetExists)
+	}
+	// the restored file is already included in the quota
+	if err := c.createParentDirs(fs, fsPath); err != nil {
+		c.Log(logger.LevelWarn, "unable to create missing dirs for %#v: %+v", fsPath, err)
+		return c.GetFsError(fs, err)
+	}
+	if err := fs.Rename(fsTrashPath, fsPath); err != nil {
+		c.Log(logger.LevelWarn, "unable to restore %#v from trash: %+v", virtualPath, err)
+		return c.GetFsError(fs, err)
+	}
+	logger.CommandLog(restoreTrashLogSender, fsPath, trashID, c.User.Username, "", c.ID, c.protocol, -1, -1,
+		"", "", "", -1)
+	return nil
+}
@@ -0,0 +1,260 @@
+package common
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// getVersionsDir returns the virtual path and the filesystem path of the
+// directory where the versions of the given file are stored
+func (c *BaseConnection) getVersionsDir(fs vfs.Fs, virtualPath, mountPath string) (string, string, error) {
+	relPath := strings.TrimPrefix(virtualPath, mountPath)
+	virtualDir := path.Join(mountPath, vfs.VersionsDirName, relPath)
+	fsDir, err := fs.ResolvePath(virtualDir)
+	return virtualDir, fsDir, err
+}
+
+// SaveFileVersion moves the file at fsPath inside the versions directory, if versioning
+// is enabled for virtualPath. Returns true if the file was moved
+func (c *BaseConnection) SaveFileVersion(fs vfs.Fs, fsPath, virtualPath string) (bool, error) {
+	config, mountPath := c.User.GetVersioningConfigForPath(virtualPath)
+	if config.Mode != vfs.VersioningModeVersionsDir {
+		return false, nil
+	}
+	_, fsDir, err := c.getVersionsDir(fs, virtualPath, mountPath)
+	if err != nil {
+		return false, err
+	}
+	target := fs.Join(fsDir, vfs.NewVersionID())
+	if err := c.createParentDirs(fs, target); err != nil {
+		c.Log(logger.LevelWarn, "unable to create versions dir %#v: %+v", fsDir, err)
+		return false, err
+	}
+	if err := fs.Rename(fsPath, target); err != nil {
+		c.Log(logger.LevelWarn, "unable to save version for file %#v: %+v", fsPath, err)
+		return false, err
+	}
+	c.Log(logger.LevelDebug, "file %#v saved as version %#v", fsPath, target)
+	c.purgeFileVersions(fs, fsDir, virtualPath, config.MaxVersions)
+	return true, nil
+}
+
+// createParentDirs creates the missing parent directories for fsPath,
+// this is a no-op for cloud filesystems
+func (c *BaseConnection) createParentDirs(fs vfs.Fs, fsPath string) error {
+	if vfs.IsSFTPFs(fs) {
+		return fs.MkdirAll(path.Dir(fsPath), c.User.GetUID(), c.User.GetGID())
+	}
+	return fs.MkdirAll(fsPath, c.User.GetUID(), c.User.GetGID())
+}
+
+// purgeFileVersions removes the oldest versions exceeding maxVersions
+func (c *BaseConnection) purgeFileVersions(fs vfs.Fs, fsDir, virtualPath string, maxVersions int) {
+	if maxVersions <= 0 {
+		return
+	}
+	versions, err := c.readVersionsDir(fs, fsDir)
+	if err != nil || len(versions) <= maxVersions {
+		return
+	}
+	for _, version := range versions[maxVersions:] {
+		versionPath := fs.Join(fsDir, version.ID)
+		if err := fs.Remove(versionPath, false); err != nil {
+			c.Log(logger.LevelWarn, "unable to remove old version %#v: %+v", versionPath, err)
+			continue
+		}
+		c.Log(logger.LevelDebug, "old version %#v removed", versionPath)
+		c.updateQuotaForPath(virtualPath, -1, -version.Size)
+	}
+}
+
+// readVersionsDir returns the versions stored inside fsDir, newest first
+func (c *BaseConnection) readVersionsDir(fs vfs.Fs, fsDir string) ([]vfs.FileVersion, error) {
+	files, err := fs.ReadDir(fsDir)
+	if err != nil {
+		if fs.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	versions := make([]vfs.FileVersion, 0, len(files))
+	for _, info := range files {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if _, err := vfs.ParseVersionID(info.Name()); err != nil {
+			continue
+		}
+		versions = append(versions, vfs.FileVersion{
+			ID:           info.Name(),
+			Size:         info.Size(),
+			LastModified: info.ModTime().UnixNano() / 1000000,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].ID > versions[j].ID
+	})
+	return versions, nil
+}
+
+func (c *BaseConnection) updateQuotaForPath(virtualPath string, numFiles int, size int64) {
+	vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(virtualPath))
+	if err == nil {
+		dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, numFiles, size, false) //nolint:errcheck
+		if vfolder.IsIncludedInUserQuota() {
+			dataprovider.UpdateUserQuota(&c.User, numFiles, size, false) //nolint:errcheck
+		}
+	} else {
+		dataprovider.UpdateUserQuota(&c.User, numFiles, size, false) //nolint:errcheck
+	}
+	dataprovider.UpdateDirQuota(&c.User, virtualPath, numFiles, size)
+}
+
+// ListFileVersions returns the available versions for the specified file, newest first
+func (c *BaseConnection) ListFileVersions(virtualPath string) ([]vfs.FileVersion, error) {
+	if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(virtualPath)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+	if !c.User.IsFileAllowed(virtualPath) {
+		c.Log(logger.LevelWarn, "listing versions for file %#v is not allowed", virtualPath)
+		return nil, c.GetPermissionDeniedError()
+	}
+	config, mountPath := c.User.GetVersioningConfigForPath(virtualPath)
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return nil, err
+	}
+	switch config.Mode {
+	case vfs.VersioningModeVersionsDir:
+		_, fsDir, err := c.getVersionsDir(fs, virtualPath, mountPath)
+		if err != nil {
+			return nil, c.GetFsError(fs, err)
+		}
+		versions, err := c.readVersionsDir(fs, fsDir)
+		if err != nil {
+			c.Log(logger.LevelWarn, "unable to list versions for file %#v: %+v", virtualPath, err)
+			return nil, c.GetFsError(fs, err)
+		}
+		return versions, nil
+	case vfs.VersioningModeBucket:
+		if versionedFs, ok := fs.(vfs.VersionedFs); ok {
+			versions, err := versionedFs.ListVersions(fsPath)
+			if err != nil {
+				c.Log(logger.LevelWarn, "unable to list versions for file %#v: %+v", virtualPath, err)
+				return nil, c.GetFsError(fs, err)
+			}
+			return versions, nil
+		}
+	}
+	return nil, c.GetOpUnsupportedError()
+}
+
+// RestoreFileVersion restores the specified version for the given file.
+// If versions are stored inside the versions directory the current content,
+// if any, is saved as a new version
+func (c *BaseConnection) RestoreFileVersion(virtualPath, versionID string) error {
+	if versionID == "" || strings.Contains(versionID, "/") {
+		c.Log(logger.LevelWarn, "invalid version id %#v", versionID)
+		return c.GetNotExistError()
+	}
+	if !c.User.IsFileAllowed(virtualPath) {
+		c.Log(logger.LevelWarn, "restoring a version for file %#v is not allowed", virtualPath)
+		return c.GetPermissionDeniedError()
+	}
+	config, mountPath := c.User.GetVersioningConfigForPath(virtualPath)
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return err
+	}
+	var initialSize int64
+	numFiles := 1
+	info, err := fs.Lstat(fsPath)
+	if err == nil {
+		if !info.Mode().IsRegular() {
+			return c.GetOpUnsupportedError()
+		}
+		if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(virtualPath)) {
+			return c.GetPermissionDeniedError()
+		}
+		initialSize = info.Size()
+		numFiles = 0
+	} else {
+		if !fs.IsNotExist(err) {
+			return c.GetFsError(fs, err)
+		}
+		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualPath)) {
+			return c.GetPermissionDeniedError()
+		}
+	}
+
+	switch config.Mode {
+	case vfs.VersioningModeVersionsDir:
+		return c.restoreFromVersionsDir(fs, fsPath, virtualPath, mountPath, versionID, numFiles == 0)
+	case vfs.VersioningModeBucket:
+		versionedFs, ok := fs.(vfs.VersionedFs)
+		if !ok {
+			return c.GetOpUnsupportedError()
+		}
+		if err := versionedFs.RestoreVersion(fsPath, versionID); err != nil {
+			c.Log(logger.LevelWarn, "unable to restore version %#v for file %#v: %+v", versionID, virtualPath, err)
+			return c.GetFsError(fs, err)
+		}
+		info, err = fs.Stat(fsPath)
+		if err == nil {
+			c.updateQuotaForPath(virtualPath, numFiles, info.Size()-initialSize)
+		}
+		logger.CommandLog(restoreVersionLogSender, fsPath, versionID, c.User.Username, "", c.ID, c.protocol, -1, -1,
+			"", "", "", -1)
+		return nil
+	default:
+		return c.GetOpUnsupportedError()
+	}
+}
+
+func (c *BaseConnection) restoreFromVersionsDir(fs vfs.Fs, fsPath, virtualPath, mountPath, versionID string,
+	currentExists bool,
+) error {
+	if _, err := vfs.ParseVersionID(versionID); err != nil {
+		c.Log(logger.LevelWarn, "invalid version id %#v: %v", versionID, err)
+		return c.GetNotExistError()
+	}
+	_, fsDir, err := c.getVersionsDir(fs, virtualPath, mountPath)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	versionPath := fs.Join(fsDir, versionID)
+	info, err := fs.Lstat(versionPath)
+	if err != nil {
+		return c.GetFsError(fs, err)
+	}
+	if !info.Mode().IsRegular() {
+		return c.GetNotExistError()
+	}
+	if currentExists {
+		if _, err := c.SaveFileVersion(fs, fsPath, virtualPath); err != nil {
+			return c.GetFsError(fs, err)
+		}
+	} else {
+		// the restored file was previously counted as version, the number of files
+		// and the used size does not change
+		if err := c.createParentDirs(fs, fsPath); err != nil {
+			c.Log(logger.LevelWarn, "unable to create missing dirs for %#v: %+v", fsPath, err)
+			return c.GetFsError(fs, err)
+		}
+	}
+	if err := fs.Rename(versionPath, fsPath); err != nil {
+		if fs.IsNotExist(err) {
+			return c.GetNotExistError()
+		}
+		c.Log(logger.LevelWarn, "unable to restore version %#v for file %#v: %+v", versionID, virtualPath, err)
+		return c.GetFsError(fs, err)
+	}
+	logger.CommandLog(restoreVersionLogSender, fsPath, versionID, c.User.Username, "", c.ID, c.protocol, -1, -1,
+		"", "", "", -1)
+	return nil
+}
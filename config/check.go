@@ -0,0 +1,246 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// loadErrors are the errors found while loading the configuration. The
+// affected settings are ignored or reset to their default values
+var loadErrors []CheckError
+
+// CheckError defines an error found while checking the configuration
+type CheckError struct {
+	// Setting is the name of the invalid setting, for example "sftpd.host_keys".
+	// It is empty for errors not related to a specific setting
+	Setting string `json:"setting,omitempty"`
+	Error   string `json:"error"`
+}
+
+func (e CheckError) String() string {
+	if e.Setting == "" {
+		return e.Error
+	}
+	return fmt.Sprintf("%v: %v", e.Setting, e.Error)
+}
+
+func addLoadError(setting, message string) {
+	loadErrors = append(loadErrors, CheckError{
+		Setting: setting,
+		Error:   message,
+	})
+}
+
+type configChecker struct {
+	configDir string
+	errors    []CheckError
+}
+
+func (c *configChecker) addError(setting string, err error) {
+	c.errors = append(c.errors, CheckError{
+		Setting: setting,
+		Error:   err.Error(),
+	})
+}
+
+func (c *configChecker) getPath(name string) string {
+	if name != "" && !filepath.IsAbs(name) {
+		return filepath.Join(c.configDir, name)
+	}
+	return name
+}
+
+// checkHook checks that the hook is an HTTP URL or an absolute path to an
+// existing executable file
+func (c *configChecker) checkHook(setting, hook string) {
+	if hook == "" {
+		return
+	}
+	if strings.HasPrefix(hook, "http") {
+		u, err := url.Parse(hook)
+		if err != nil {
+			c.addError(setting, err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			c.addError(setting, fmt.Errorf("invalid hook URL %#v", hook))
+		}
+		return
+	}
+	if !filepath.IsAbs(hook) {
+		c.addError(setting, fmt.Errorf("invalid hook %#v, it must be an absolute path", hook))
+		return
+	}
+	info, err := os.Stat(hook)
+	if err != nil {
+		c.addError(setting, err)
+		return
+	}
+	if !info.Mode().IsRegular() {
+		c.addError(setting, fmt.Errorf("invalid hook %#v, it is not a regular file", hook))
+		return
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0111 == 0 {
+		c.addError(setting, fmt.Errorf("invalid hook %#v, it is not executable", hook))
+	}
+}
+
+func (c *configChecker) checkReadableFile(setting, name string) {
+	if name == "" {
+		return
+	}
+	if !utils.IsFileInputValid(name) {
+		c.addError(setting, fmt.Errorf("invalid file name %#v", name))
+		return
+	}
+	f, err := os.Open(c.getPath(name))
+	if err != nil {
+		c.addError(setting, err)
+		return
+	}
+	f.Close()
+}
+
+func (c *configChecker) checkCertificate(prefix, certificateFile, certificateKeyFile string) {
+	if certificateFile == "" && certificateKeyFile == "" {
+		return
+	}
+	if certificateFile == "" || certificateKeyFile == "" {
+		c.addError(prefix+".certificate_file", errors.New("both a certificate and a private key are required"))
+		return
+	}
+	if _, err := tls.LoadX509KeyPair(c.getPath(certificateFile), c.getPath(certificateKeyFile)); err != nil {
+		c.addError(prefix+".certificate_file", err)
+	}
+}
+
+func (c *configChecker) checkCertificateAuthorities(prefix string, caCertificates, caRevocationLists []string) {
+	for _, ca := range caCertificates {
+		c.checkReadableFile(prefix+".ca_certificates", ca)
+	}
+	for _, crl := range caRevocationLists {
+		c.checkReadableFile(prefix+".ca_revocation_lists", crl)
+	}
+}
+
+func (c *configChecker) checkSSHKeys() {
+	for _, hostKey := range globalConf.SFTPD.HostKeys {
+		if !utils.IsFileInputValid(hostKey) {
+			c.addError("sftpd.host_keys", fmt.Errorf("invalid host key %#v", hostKey))
+			continue
+		}
+		privateBytes, err := os.ReadFile(c.getPath(hostKey))
+		if err != nil {
+			c.addError("sftpd.host_keys", err)
+			continue
+		}
+		if _, err := ssh.ParsePrivateKey(privateBytes); err != nil {
+			c.addError("sftpd.host_keys", fmt.Errorf("unable to parse host key %#v: %w", hostKey, err))
+		}
+	}
+	for _, keyPath := range globalConf.SFTPD.TrustedUserCAKeys {
+		if !utils.IsFileInputValid(keyPath) {
+			c.addError("sftpd.trusted_user_ca_keys", fmt.Errorf("invalid trusted user CA key %#v", keyPath))
+			continue
+		}
+		keyBytes, err := os.ReadFile(c.getPath(keyPath))
+		if err != nil {
+			c.addError("sftpd.trusted_user_ca_keys", err)
+			continue
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey(keyBytes); err != nil {
+			c.addError("sftpd.trusted_user_ca_keys", fmt.Errorf("unable to parse trusted user CA key %#v: %w",
+				keyPath, err))
+		}
+	}
+}
+
+// checkBindings checks the ports and that the same address is not used
+// for more than one binding
+func (c *configChecker) checkBindings() {
+	if !HasServicesToStart() {
+		c.addError("", errors.New("no service configured, at least a binding for SFTP, FTP, WebDAV or rsync is required"))
+	}
+	addresses := make(map[string]string)
+	checkBinding := func(setting, address string, port int) {
+		if port < 0 || port > 65535 {
+			c.addError(setting, fmt.Errorf("invalid port %v", port))
+			return
+		}
+		if port == 0 {
+			return
+		}
+		bindAddress := fmt.Sprintf("%s:%d", address, port)
+		if other, ok := addresses[bindAddress]; ok {
+			c.addError(setting, fmt.Errorf("address %#v is already used for %v", bindAddress, other))
+			return
+		}
+		addresses[bindAddress] = setting
+	}
+	for idx, b := range globalConf.SFTPD.Bindings {
+		checkBinding(fmt.Sprintf("sftpd.bindings[%d]", idx), b.Address, b.Port)
+	}
+	for idx, b := range globalConf.FTPD.Bindings {
+		checkBinding(fmt.Sprintf("ftpd.bindings[%d]", idx), b.Address, b.Port)
+	}
+	for idx, b := range globalConf.WebDAVD.Bindings {
+		checkBinding(fmt.Sprintf("webdavd.bindings[%d]", idx), b.Address, b.Port)
+	}
+	for idx, b := range globalConf.HTTPDConfig.Bindings {
+		checkBinding(fmt.Sprintf("httpd.bindings[%d]", idx), b.Address, b.Port)
+	}
+	for idx, b := range globalConf.RsyncD.Bindings {
+		checkBinding(fmt.Sprintf("rsyncd.bindings[%d]", idx), b.Address, b.Port)
+	}
+	checkBinding("telemetry", globalConf.TelemetryConfig.BindAddress, globalConf.TelemetryConfig.BindPort)
+}
+
+// Check validates the loaded configuration and returns the errors found.
+// The referenced files, such as the host keys and the certificates, are loaded
+// and the hooks must be HTTP URLs or existing executables.
+// If online is true the connection to the data provider is checked too
+func Check(configDir string, online bool) []CheckError {
+	checker := &configChecker{
+		configDir: configDir,
+	}
+	checker.errors = append(checker.errors, loadErrors...)
+
+	checker.checkHook("common.startup_hook", globalConf.Common.StartupHook)
+	checker.checkHook("common.pre_connect_hook", globalConf.Common.PreConnectHook)
+	checker.checkHook("common.post_connect_hook", globalConf.Common.PostConnectHook)
+	checker.checkHook("common.actions.hook", globalConf.Common.Actions.Hook)
+	checker.checkHook("sftpd.keyboard_interactive_auth_hook", globalConf.SFTPD.KeyboardInteractiveHook)
+
+	checker.checkBindings()
+	checker.checkSSHKeys()
+	checker.checkReadableFile("sftpd.login_banner_file", globalConf.SFTPD.LoginBannerFile)
+	checker.checkReadableFile("ftpd.banner_file", globalConf.FTPD.BannerFile)
+	checker.checkCertificate("ftpd", globalConf.FTPD.CertificateFile, globalConf.FTPD.CertificateKeyFile)
+	checker.checkCertificateAuthorities("ftpd", globalConf.FTPD.CACertificates, globalConf.FTPD.CARevocationLists)
+	checker.checkCertificate("webdavd", globalConf.WebDAVD.CertificateFile, globalConf.WebDAVD.CertificateKeyFile)
+	checker.checkCertificateAuthorities("webdavd", globalConf.WebDAVD.CACertificates,
+		globalConf.WebDAVD.CARevocationLists)
+	checker.checkCertificate("httpd", globalConf.HTTPDConfig.CertificateFile, globalConf.HTTPDConfig.CertificateKeyFile)
+	checker.checkCertificateAuthorities("httpd", globalConf.HTTPDConfig.CACertificates,
+		globalConf.HTTPDConfig.CARevocationLists)
+	checker.checkCertificate("telemetry", globalConf.TelemetryConfig.CertificateFile,
+		globalConf.TelemetryConfig.CertificateKeyFile)
+
+	if err := globalConf.KMSConfig.Initialize(); err != nil {
+		checker.addError("kms", err)
+	}
+	if err := dataprovider.CheckConfig(globalConf.ProviderConf, configDir, online); err != nil {
+		checker.addError("data_provider", err)
+	}
+
+	return checker.errors
+}
@@ -6,22 +6,31 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strconv"
 	"strings"
 
 	"github.com/spf13/viper"
 
+	"github.com/drakkan/sftpgo/awssecrets"
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/ftpd"
+	"github.com/drakkan/sftpgo/geoip"
 	"github.com/drakkan/sftpgo/httpclient"
 	"github.com/drakkan/sftpgo/httpd"
 	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/plugin"
+	"github.com/drakkan/sftpgo/rsyncd"
 	"github.com/drakkan/sftpgo/sftpd"
 	"github.com/drakkan/sftpgo/telemetry"
+	"github.com/drakkan/sftpgo/tracing"
 	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vault"
 	"github.com/drakkan/sftpgo/version"
+	"github.com/drakkan/sftpgo/vfs"
 	"github.com/drakkan/sftpgo/webdavd"
 )
 
@@ -36,7 +45,7 @@ const (
 )
 
 var (
-	globalConf          globalConfig
+	globalConf          Configuration
 	defaultSFTPDBanner  = fmt.Sprintf("SFTPGo_%v", version.Get().Version)
 	defaultFTPDBanner   = fmt.Sprintf("SFTPGo %v ready", version.Get().Version)
 	defaultSFTPDBinding = sftpd.Binding{
@@ -61,6 +70,11 @@ var (
 		TLSCipherSuites: nil,
 		Prefix:          "",
 	}
+	defaultRsyncDBinding = rsyncd.Binding{
+		Address:          "",
+		Port:             0,
+		ApplyProxyConfig: true,
+	}
 	defaultHTTPDBinding = httpd.Binding{
 		Address:         "127.0.0.1",
 		Port:            8080,
@@ -75,23 +89,37 @@ var (
 		Period:                 1000,
 		Burst:                  1,
 		Type:                   2,
-		Protocols:              []string{common.ProtocolSSH, common.ProtocolFTP, common.ProtocolWebDAV, common.ProtocolHTTP},
+		Protocols:              []string{common.ProtocolSSH, common.ProtocolFTP, common.ProtocolWebDAV, common.ProtocolHTTP, common.ProtocolRsync},
+		Operations:             []string{common.RateLimitOperationConnect},
 		GenerateDefenderEvents: false,
 		EntriesSoftLimit:       100,
 		EntriesHardLimit:       150,
 	}
 )
 
-type globalConfig struct {
-	Common          common.Configuration  `json:"common" mapstructure:"common"`
-	SFTPD           sftpd.Configuration   `json:"sftpd" mapstructure:"sftpd"`
-	FTPD            ftpd.Configuration    `json:"ftpd" mapstructure:"ftpd"`
-	WebDAVD         webdavd.Configuration `json:"webdavd" mapstructure:"webdavd"`
-	ProviderConf    dataprovider.Config   `json:"data_provider" mapstructure:"data_provider"`
-	HTTPDConfig     httpd.Conf            `json:"httpd" mapstructure:"httpd"`
-	HTTPConfig      httpclient.Config     `json:"http" mapstructure:"http"`
-	KMSConfig       kms.Configuration     `json:"kms" mapstructure:"kms"`
-	TelemetryConfig telemetry.Conf        `json:"telemetry" mapstructure:"telemetry"`
+// Configuration defines the full SFTPGo configuration.
+// It allows to configure SFTPGo programmatically, for example when SFTPGo
+// is embedded in another Go program, without using configuration files
+type Configuration struct {
+	Common          common.Configuration     `json:"common" mapstructure:"common"`
+	SFTPD           sftpd.Configuration      `json:"sftpd" mapstructure:"sftpd"`
+	FTPD            ftpd.Configuration       `json:"ftpd" mapstructure:"ftpd"`
+	WebDAVD         webdavd.Configuration    `json:"webdavd" mapstructure:"webdavd"`
+	RsyncD          rsyncd.Configuration     `json:"rsyncd" mapstructure:"rsyncd"`
+	ProviderConf    dataprovider.Config      `json:"data_provider" mapstructure:"data_provider"`
+	HTTPDConfig     httpd.Conf               `json:"httpd" mapstructure:"httpd"`
+	HTTPConfig      httpclient.Config        `json:"http" mapstructure:"http"`
+	KMSConfig       kms.Configuration        `json:"kms" mapstructure:"kms"`
+	Vault           vault.Config             `json:"vault" mapstructure:"vault"`
+	AWSSecrets      awssecrets.Config        `json:"aws_secrets" mapstructure:"aws_secrets"`
+	TelemetryConfig telemetry.Conf           `json:"telemetry" mapstructure:"telemetry"`
+	StatsD          metrics.StatsDConfig     `json:"statsd" mapstructure:"statsd"`
+	Tracing         tracing.Config           `json:"tracing" mapstructure:"tracing"`
+	AuditLog        logger.AuditLogConfig    `json:"audit_log" mapstructure:"audit_log"`
+	AccessLog       logger.AccessLogConfig   `json:"access_log" mapstructure:"access_log"`
+	RemoteLog       logger.RemoteLogConfig   `json:"remote_log" mapstructure:"remote_log"`
+	LogSampling     logger.LogSamplingConfig `json:"log_sampling" mapstructure:"log_sampling"`
+	PluginsConfig   []plugin.Config          `json:"plugins" mapstructure:"plugins"`
 }
 
 func init() {
@@ -103,34 +131,127 @@ func init() {
 // It is exported to minimize refactoring efforts. Will eventually disappear.
 func Init() {
 	// create a default configuration to use if no config file is provided
-	globalConf = globalConfig{
+	globalConf = getDefaultConfiguration()
+
+	viper.SetEnvPrefix(configEnvPrefix)
+	replacer := strings.NewReplacer(".", "__")
+	viper.SetEnvKeyReplacer(replacer)
+	viper.SetConfigName(configName)
+	setViperDefaults()
+	viper.AutomaticEnv()
+	viper.AllowEmptyEnv(true)
+}
+
+func getDefaultConfiguration() Configuration {
+	return Configuration{
 		Common: common.Configuration{
 			IdleTimeout: 15,
 			UploadMode:  0,
 			Actions: common.ProtocolActions{
-				ExecuteOn: []string{},
-				Hook:      "",
+				ExecuteOn:        []string{},
+				Filters:          []common.ActionFilter{},
+				Hook:             "",
+				ProgressInterval: 30,
+				Retry: common.ActionsRetryConfig{
+					MaxRetries: 0,
+					BaseDelay:  1,
+					MaxWindow:  60,
+				},
+				HTTP: common.ActionsHTTPConfig{
+					Body:          "",
+					ContentType:   "",
+					Headers:       []common.ActionHTTPHeader{},
+					SigningSecret: "",
+				},
+				MQTT: common.ActionsMQTTConfig{
+					Topic:    "sftpgo/{{action}}/{{username}}",
+					QoS:      1,
+					ClientID: "",
+					Username: "",
+					Password: "",
+				},
+				Kafka: common.ActionsKafkaConfig{
+					Topic:         "sftpgo-events",
+					KeyTemplate:   "{{username}}",
+					TLS:           false,
+					SASLMechanism: "",
+					Username:      "",
+					Password:      "",
+					ClientID:      "sftpgo",
+					BatchSize:     100,
+					BatchTimeout:  500,
+				},
+				AMQP: common.ActionsAMQPConfig{
+					Exchange:   "",
+					RoutingKey: "sftpgo.{{action}}",
+					Persistent: true,
+				},
+				Syslog: common.ActionsSyslogConfig{
+					Facility: "local0",
+					AppName:  "sftpgo",
+				},
 			},
 			SetstatMode:         0,
+			UploadChecksums:     false,
 			ProxyProtocol:       0,
 			ProxyAllowed:        []string{},
+			PreConnectHook:      "",
 			PostConnectHook:     "",
 			MaxTotalConnections: 0,
 			DefenderConfig: common.DefenderConfig{
-				Enabled:           false,
-				BanTime:           30,
-				BanTimeIncrement:  50,
-				Threshold:         15,
-				ScoreInvalid:      2,
-				ScoreValid:        1,
-				ScoreRateExceeded: 3,
-				ObservationTime:   30,
-				EntriesSoftLimit:  100,
-				EntriesHardLimit:  150,
-				SafeListFile:      "",
-				BlockListFile:     "",
+				Enabled:            false,
+				Driver:             common.DefenderDriverMemory,
+				BanTime:            30,
+				BanTimeIncrement:   50,
+				Threshold:          15,
+				ScoreInvalid:       2,
+				ScoreValid:         1,
+				ScoreRateExceeded:  3,
+				ObservationTime:    30,
+				EntriesSoftLimit:   100,
+				EntriesHardLimit:   150,
+				SafeListFile:       "",
+				BlockListFile:      "",
+				ListsCheckInterval: 0,
 			},
 			RateLimitersConfig: []common.RateLimiterConfig{defaultRateLimiter},
+			DiskCache: vfs.DiskCacheConfig{
+				Path:      "",
+				MaxSize:   0,
+				WriteBack: false,
+			},
+			DataRetention: common.DataRetentionConfig{
+				CheckInterval: 0,
+				DryRun:        false,
+			},
+			EventsQueue: common.EventsQueueConfig{
+				Enabled:       false,
+				Workers:       10,
+				CheckInterval: 10,
+			},
+			Scheduler: common.SchedulerConfig{
+				Jobs: []common.ScheduledJob{},
+			},
+			SharedConnections: common.SharedConnectionsConfig{
+				Enabled:        false,
+				NodeID:         "",
+				UpdateInterval: 10,
+			},
+			LoadShedding: common.LoadSheddingConfig{
+				MaxGoroutines:  0,
+				MaxMemory:      0,
+				MaxLoadAverage: 0,
+				CheckInterval:  5,
+			},
+			QuotaScan: vfs.QuotaScanConfig{
+				FilesPerSecond:     0,
+				RequestsPerSecond:  0,
+				MaxConcurrentScans: 0,
+			},
+			GeoIP: geoip.Config{
+				CountryDatabase: "",
+				ASNDatabase:     "",
+			},
 		},
 		SFTPD: sftpd.Configuration{
 			Banner:                  defaultSFTPDBanner,
@@ -190,6 +311,11 @@ func Init() {
 				},
 			},
 		},
+		RsyncD: rsyncd.Configuration{
+			Bindings:  []rsyncd.Binding{defaultRsyncDBinding},
+			Modules:   []rsyncd.Module{},
+			RsyncPath: "rsync",
+		},
 		ProviderConf: dataprovider.Config{
 			Driver:           "sqlite",
 			Name:             "sftpgo.db",
@@ -200,12 +326,17 @@ func Init() {
 			ConnectionString: "",
 			SQLTablesPrefix:  "",
 			SSLMode:          0,
+			RootCert:         "",
+			ClientCert:       "",
+			ClientKey:        "",
+			TLSServerName:    "",
 			TrackQuota:       1,
 			PoolSize:         0,
 			UsersBaseDir:     "",
-			Actions: dataprovider.UserActions{
-				ExecuteOn: []string{},
-				Hook:      "",
+			Actions: dataprovider.ProviderActions{
+				ExecuteOn:  []string{},
+				ExecuteFor: []string{},
+				Hook:       "",
 			},
 			ExternalAuthHook:   "",
 			ExternalAuthScope:  0,
@@ -242,6 +373,7 @@ func Init() {
 			CertificateKeyFile: "",
 			CACertificates:     nil,
 			CARevocationLists:  nil,
+			RequireApproval:    false,
 		},
 		HTTPConfig: httpclient.Config{
 			Timeout:        20,
@@ -251,31 +383,124 @@ func Init() {
 			CACertificates: nil,
 			Certificates:   nil,
 			SkipTLSVerify:  false,
+			ProxyURL:       "",
+			NoProxy:        nil,
+			Headers:        nil,
+			HookTimeouts: httpclient.HookTimeouts{
+				ExternalAuth:        0,
+				PreLogin:            0,
+				PostLogin:           0,
+				CheckPassword:       0,
+				KeyboardInteractive: 0,
+				Actions:             0,
+				ProviderActions:     0,
+				Connect:             0,
+			},
+			MaxIdleConns:        0,
+			MaxIdleConnsPerHost: 0,
+			MaxConnsPerHost:     0,
+			IdleConnTimeout:     0,
+			TLSHandshakeTimeout: 0,
 		},
 		KMSConfig: kms.Configuration{
 			Secrets: kms.Secrets{
-				URL:           "",
-				MasterKeyPath: "",
+				URL:             "",
+				MasterKeyString: "",
+				MasterKeyPath:   "",
 			},
 		},
+		Vault: vault.Config{
+			Address:          "",
+			Namespace:        "",
+			KVMountPath:      "secret",
+			TransitMountPath: "transit",
+			AuthMethod:       vault.AuthMethodToken,
+			Token:            "",
+			AppRole: vault.AppRoleConfig{
+				MountPath: "approle",
+				RoleID:    "",
+				SecretID:  "",
+			},
+			CACertificate: "",
+			SkipTLSVerify: false,
+		},
+		AWSSecrets: awssecrets.Config{
+			Region:   "",
+			RoleARN:  "",
+			Endpoint: "",
+		},
 		TelemetryConfig: telemetry.Conf{
-			BindPort:           10000,
-			BindAddress:        "127.0.0.1",
-			EnableProfiler:     false,
-			AuthUserFile:       "",
-			CertificateFile:    "",
-			CertificateKeyFile: "",
-			TLSCipherSuites:    nil,
+			BindPort:            10000,
+			BindAddress:         "127.0.0.1",
+			EnableProfiler:      false,
+			AuthUserFile:        "",
+			CertificateFile:     "",
+			CertificateKeyFile:  "",
+			TLSCipherSuites:     nil,
+			PerUserMetrics:      false,
+			PerUserMetricsLimit: 100,
+		},
+		StatsD: metrics.StatsDConfig{
+			Address:       "",
+			Format:        metrics.StatsDFormatPlain,
+			Prefix:        "",
+			Tags:          nil,
+			FlushInterval: 10,
 		},
+		Tracing: tracing.Config{
+			Endpoint:    "",
+			Insecure:    false,
+			ServiceName: "sftpgo",
+			SampleRatio: 1,
+		},
+		AuditLog: logger.AuditLogConfig{
+			FilePath:   "",
+			MaxSize:    10,
+			MaxBackups: 0,
+			MaxAge:     0,
+			Compress:   false,
+		},
+		AccessLog: logger.AccessLogConfig{
+			FilePath:   "",
+			Format:     logger.AccessLogFormatCombined,
+			MaxSize:    10,
+			MaxBackups: 0,
+			MaxAge:     0,
+			Compress:   false,
+		},
+		RemoteLog: logger.RemoteLogConfig{
+			Address:        "",
+			Format:         logger.RemoteLogFormatJSON,
+			EnableTLS:      false,
+			CACertificates: nil,
+			SkipTLSVerify:  false,
+			BufferSize:     10000,
+		},
+		LogSampling: logger.LogSamplingConfig{
+			Interval:        0,
+			Burst:           10,
+			Thereafter:      100,
+			ExcludedSenders: nil,
+		},
+		PluginsConfig: nil,
 	}
+}
 
-	viper.SetEnvPrefix(configEnvPrefix)
-	replacer := strings.NewReplacer(".", "__")
-	viper.SetEnvKeyReplacer(replacer)
-	viper.SetConfigName(configName)
-	setViperDefaults()
-	viper.AutomaticEnv()
-	viper.AllowEmptyEnv(true)
+// GetDefaultConfiguration returns the default configuration, the one
+// used if no configuration file is found
+func GetDefaultConfiguration() Configuration {
+	return getDefaultConfiguration()
+}
+
+// GetConfiguration returns the current configuration
+func GetConfiguration() Configuration {
+	return globalConf
+}
+
+// SetConfiguration sets the configuration to use, it replaces the one loaded
+// from configuration files, if any
+func SetConfiguration(config Configuration) {
+	globalConf = config
 }
 
 // GetCommonConfig returns the common protocols configuration
@@ -318,6 +543,16 @@ func SetWebDAVDConfig(config webdavd.Configuration) {
 	globalConf.WebDAVD = config
 }
 
+// GetRsyncDConfig returns the configuration for the rsync daemon
+func GetRsyncDConfig() rsyncd.Configuration {
+	return globalConf.RsyncD
+}
+
+// SetRsyncDConfig sets the configuration for the rsync daemon
+func SetRsyncDConfig(config rsyncd.Configuration) {
+	globalConf.RsyncD = config
+}
+
 // GetHTTPDConfig returns the configuration for the HTTP server
 func GetHTTPDConfig() httpd.Conf {
 	return globalConf.HTTPDConfig
@@ -363,8 +598,78 @@ func SetTelemetryConfig(config telemetry.Conf) {
 	globalConf.TelemetryConfig = config
 }
 
+// GetStatsDConfig returns the StatsD exporter configuration
+func GetStatsDConfig() metrics.StatsDConfig {
+	return globalConf.StatsD
+}
+
+// SetStatsDConfig sets the StatsD exporter configuration
+func SetStatsDConfig(config metrics.StatsDConfig) {
+	globalConf.StatsD = config
+}
+
+// GetTracingConfig returns the tracing configuration
+func GetTracingConfig() tracing.Config {
+	return globalConf.Tracing
+}
+
+// SetTracingConfig sets the tracing configuration
+func SetTracingConfig(config tracing.Config) {
+	globalConf.Tracing = config
+}
+
+// GetAuditLogConfig returns the audit log configuration
+func GetAuditLogConfig() logger.AuditLogConfig {
+	return globalConf.AuditLog
+}
+
+// SetAuditLogConfig sets the audit log configuration
+func SetAuditLogConfig(config logger.AuditLogConfig) {
+	globalConf.AuditLog = config
+}
+
+// GetAccessLogConfig returns the HTTP access log configuration
+func GetAccessLogConfig() logger.AccessLogConfig {
+	return globalConf.AccessLog
+}
+
+// SetAccessLogConfig sets the HTTP access log configuration
+func SetAccessLogConfig(config logger.AccessLogConfig) {
+	globalConf.AccessLog = config
+}
+
+// GetRemoteLogConfig returns the remote log configuration
+func GetRemoteLogConfig() logger.RemoteLogConfig {
+	return globalConf.RemoteLog
+}
+
+// SetRemoteLogConfig sets the remote log configuration
+func SetRemoteLogConfig(config logger.RemoteLogConfig) {
+	globalConf.RemoteLog = config
+}
+
+// GetLogSamplingConfig returns the configuration for the log sampling
+func GetLogSamplingConfig() logger.LogSamplingConfig {
+	return globalConf.LogSampling
+}
+
+// SetLogSamplingConfig sets the configuration for the log sampling
+func SetLogSamplingConfig(config logger.LogSamplingConfig) {
+	globalConf.LogSampling = config
+}
+
+// GetPluginsConfig returns the plugins configuration
+func GetPluginsConfig() []plugin.Config {
+	return globalConf.PluginsConfig
+}
+
+// SetPluginsConfig sets the plugins configuration
+func SetPluginsConfig(config []plugin.Config) {
+	globalConf.PluginsConfig = config
+}
+
 // HasServicesToStart returns true if the config defines at least a service to start.
-// Supported services are SFTP, FTP and WebDAV
+// Supported services are SFTP, FTP, WebDAV and the rsync daemon
 func HasServicesToStart() bool {
 	if globalConf.SFTPD.ShouldBind() {
 		return true
@@ -375,12 +680,29 @@ func HasServicesToStart() bool {
 	if globalConf.WebDAVD.ShouldBind() {
 		return true
 	}
+	if globalConf.RsyncD.ShouldBind() {
+		return true
+	}
 	return false
 }
 
-func getRedactedGlobalConf() globalConfig {
+func getRedactedGlobalConf() Configuration {
 	conf := globalConf
 	conf.ProviderConf.Password = "[redacted]"
+	if conf.KMSConfig.Secrets.MasterKeyString != "" {
+		conf.KMSConfig.Secrets.MasterKeyString = "[redacted]"
+	}
+	if conf.Vault.Token != "" {
+		conf.Vault.Token = "[redacted]"
+	}
+	if conf.Vault.AppRole.SecretID != "" {
+		conf.Vault.AppRole.SecretID = "[redacted]"
+	}
+	conf.RsyncD.Modules = nil
+	for _, m := range globalConf.RsyncD.Modules {
+		m.Password = "[redacted]"
+		conf.RsyncD.Modules = append(conf.RsyncD.Modules, m)
+	}
 	return conf
 }
 
@@ -394,6 +716,13 @@ func setConfigFile(configDir, configFile string) {
 	viper.SetConfigFile(configFile)
 }
 
+func setConfigPaths(configDir, configFile string) {
+	viper.AddConfigPath(configDir)
+	setViperAdditionalConfigPaths()
+	viper.AddConfigPath(".")
+	setConfigFile(configDir, configFile)
+}
+
 // LoadConfig loads the configuration
 // configDir will be added to the configuration search paths.
 // The search path contains by default the current directory and on linux it contains
@@ -401,10 +730,8 @@ func setConfigFile(configDir, configFile string) {
 // configFile is an absolute or relative path (to the config dir) to the configuration file.
 func LoadConfig(configDir, configFile string) error {
 	var err error
-	viper.AddConfigPath(configDir)
-	setViperAdditionalConfigPaths()
-	viper.AddConfigPath(".")
-	setConfigFile(configDir, configFile)
+	loadErrors = nil
+	setConfigPaths(configDir, configFile)
 	if err = viper.ReadInConfig(); err != nil {
 		// if the user specify a configuration file we get os.ErrNotExist.
 		// viper.ConfigFileNotFoundError is returned if viper is unable
@@ -415,9 +742,23 @@ func LoadConfig(configDir, configFile string) error {
 			// should we return the error and not start here?
 			logger.Warn(logSender, "", "error loading configuration file: %v", err)
 			logger.WarnToConsole("error loading configuration file: %v", err)
+			addLoadError("", err.Error())
+		}
+	}
+	mergeConfigFragments(getConfigFragmentsDir(configDir))
+	if strictMode {
+		if err = checkUnknownKeys(); err != nil {
+			logger.Warn(logSender, "", "strict mode enabled, %v", err)
+			logger.WarnToConsole("strict mode enabled, %v", err)
+			return err
 		}
 	}
-	err = viper.Unmarshal(&globalConf)
+	if err = initializeSecretsProviders(configDir); err != nil {
+		logger.Warn(logSender, "", "unable to initialize the secrets providers: %v", err)
+		logger.WarnToConsole("unable to initialize the secrets providers: %v", err)
+		return err
+	}
+	err = viper.Unmarshal(&globalConf, getDecodeHook())
 	if err != nil {
 		logger.Warn(logSender, "", "error parsing configuration file: %v", err)
 		logger.WarnToConsole("error parsing configuration file: %v", err)
@@ -436,6 +777,7 @@ func LoadConfig(configDir, configFile string) error {
 		globalConf.ProviderConf.UsersBaseDir = ""
 		logger.Warn(logSender, "", "Configuration error: %v", err)
 		logger.WarnToConsole("Configuration error: %v", err)
+		addLoadError("data_provider.users_base_dir", err.Error())
 	}
 	if globalConf.Common.UploadMode < 0 || globalConf.Common.UploadMode > 2 {
 		warn := fmt.Sprintf("invalid upload_mode 0, 1 and 2 are supported, configured: %v reset upload_mode to 0",
@@ -443,6 +785,7 @@ func LoadConfig(configDir, configFile string) error {
 		globalConf.Common.UploadMode = 0
 		logger.Warn(logSender, "", "Configuration error: %v", warn)
 		logger.WarnToConsole("Configuration error: %v", warn)
+		addLoadError("common.upload_mode", warn)
 	}
 	if globalConf.Common.ProxyProtocol < 0 || globalConf.Common.ProxyProtocol > 2 {
 		warn := fmt.Sprintf("invalid proxy_protocol 0, 1 and 2 are supported, configured: %v reset proxy_protocol to 0",
@@ -450,23 +793,82 @@ func LoadConfig(configDir, configFile string) error {
 		globalConf.Common.ProxyProtocol = 0
 		logger.Warn(logSender, "", "Configuration error: %v", warn)
 		logger.WarnToConsole("Configuration error: %v", warn)
+		addLoadError("common.proxy_protocol", warn)
 	}
 	if globalConf.ProviderConf.ExternalAuthScope < 0 || globalConf.ProviderConf.ExternalAuthScope > 15 {
 		warn := fmt.Sprintf("invalid external_auth_scope: %v reset to 0", globalConf.ProviderConf.ExternalAuthScope)
 		globalConf.ProviderConf.ExternalAuthScope = 0
 		logger.Warn(logSender, "", "Configuration error: %v", warn)
 		logger.WarnToConsole("Configuration error: %v", warn)
+		addLoadError("data_provider.external_auth_scope", warn)
 	}
 	if globalConf.ProviderConf.CredentialsPath == "" {
 		warn := "invalid credentials path, reset to \"credentials\""
 		globalConf.ProviderConf.CredentialsPath = "credentials"
 		logger.Warn(logSender, "", "Configuration error: %v", warn)
 		logger.WarnToConsole("Configuration error: %v", warn)
+		addLoadError("data_provider.credentials_path", warn)
 	}
 	logger.Debug(logSender, "", "config file used: '%#v', config loaded: %+v", viper.ConfigFileUsed(), getRedactedGlobalConf())
 	return nil
 }
 
+// Reload loads the configuration again, from the configuration file and the
+// environment, and applies the settings that can be changed at runtime to the
+// current configuration. The other settings are not modified: the returned slice
+// contains the changed ones, they require a restart to be applied
+func Reload(configDir, configFile string) ([]string, error) {
+	current := globalConf
+	globalConf = getDefaultConfiguration()
+	err := LoadConfig(configDir, configFile)
+	reloaded := globalConf
+	globalConf = current
+	if err != nil {
+		return nil, err
+	}
+
+	globalConf.Common.IdleTimeout = reloaded.Common.IdleTimeout
+	globalConf.Common.Actions = reloaded.Common.Actions
+	globalConf.Common.StartupHook = reloaded.Common.StartupHook
+	globalConf.Common.PreConnectHook = reloaded.Common.PreConnectHook
+	globalConf.Common.PostConnectHook = reloaded.Common.PostConnectHook
+	globalConf.Common.MaxTotalConnections = reloaded.Common.MaxTotalConnections
+	globalConf.Common.GeoIP = reloaded.Common.GeoIP
+	globalConf.SFTPD.LoginBannerFile = reloaded.SFTPD.LoginBannerFile
+	globalConf.FTPD.Banner = reloaded.FTPD.Banner
+	globalConf.FTPD.BannerFile = reloaded.FTPD.BannerFile
+
+	return getChangedSettings(reflect.ValueOf(globalConf), reflect.ValueOf(reloaded), ""), nil
+}
+
+// getChangedSettings compares the given configurations and returns the names of
+// the changed settings. Nested structs are compared field by field up to the
+// second level, for example "sftpd.bindings"
+func getChangedSettings(current, reloaded reflect.Value, prefix string) []string {
+	var changed []string
+	for idx := 0; idx < current.NumField(); idx++ {
+		field := current.Type().Field(idx)
+		if field.PkgPath != "" {
+			// unexported field
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		currentValue := current.Field(idx)
+		reloadedValue := reloaded.Field(idx)
+		if prefix == "" && currentValue.Kind() == reflect.Struct {
+			changed = append(changed, getChangedSettings(currentValue, reloadedValue, name)...)
+			continue
+		}
+		if !reflect.DeepEqual(currentValue.Interface(), reloadedValue.Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
+
 func checkSFTPDBindingsCompatibility() {
 	if globalConf.SFTPD.BindPort == 0 { //nolint:staticcheck
 		return
@@ -558,11 +960,18 @@ func loadBindingsFromEnv() {
 
 	for idx := 0; idx < 10; idx++ {
 		getRateLimitersFromEnv(idx)
+		getActionFiltersFromEnv(idx)
+		getActionHTTPHeadersFromEnv(idx)
+		getScheduledJobsFromEnv(idx)
 		getSFTPDBindindFromEnv(idx)
 		getFTPDBindingFromEnv(idx)
 		getWebDAVDBindingFromEnv(idx)
+		getRsyncDBindingFromEnv(idx)
+		getRsyncDModuleFromEnv(idx)
 		getHTTPDBindingFromEnv(idx)
 		getHTTPClientCertificatesFromEnv(idx)
+		getHTTPClientHeadersFromEnv(idx)
+		getPluginsFromEnv(idx)
 	}
 }
 
@@ -604,6 +1013,12 @@ func getRateLimitersFromEnv(idx int) {
 		isSet = true
 	}
 
+	operations, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_COMMON__RATE_LIMITERS__%v__OPERATIONS", idx))
+	if ok {
+		rtlConfig.Operations = operations
+		isSet = true
+	}
+
 	generateEvents, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_COMMON__RATE_LIMITERS__%v__GENERATE_DEFENDER_EVENTS", idx))
 	if ok {
 		rtlConfig.GenerateDefenderEvents = generateEvents
@@ -631,6 +1046,218 @@ func getRateLimitersFromEnv(idx int) {
 	}
 }
 
+func getActionFiltersFromEnv(idx int) {
+	var filter common.ActionFilter
+	if len(globalConf.Common.Actions.Filters) > idx {
+		filter = globalConf.Common.Actions.Filters[idx]
+	}
+
+	isSet := false
+
+	actions, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_COMMON__ACTIONS__FILTERS__%v__ACTIONS", idx))
+	if ok {
+		filter.Actions = actions
+		isSet = true
+	}
+
+	patterns, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_COMMON__ACTIONS__FILTERS__%v__PATTERNS", idx))
+	if ok {
+		filter.Patterns = patterns
+		isSet = true
+	}
+
+	extensions, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_COMMON__ACTIONS__FILTERS__%v__EXTENSIONS", idx))
+	if ok {
+		filter.Extensions = extensions
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.Common.Actions.Filters) > idx {
+			globalConf.Common.Actions.Filters[idx] = filter
+		} else {
+			globalConf.Common.Actions.Filters = append(globalConf.Common.Actions.Filters, filter)
+		}
+	}
+}
+
+func getActionHTTPHeadersFromEnv(idx int) {
+	var header common.ActionHTTPHeader
+	if len(globalConf.Common.Actions.HTTP.Headers) > idx {
+		header = globalConf.Common.Actions.HTTP.Headers[idx]
+	}
+
+	isSet := false
+
+	key, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__ACTIONS__HTTP__HEADERS__%v__KEY", idx))
+	if ok {
+		header.Key = key
+		isSet = true
+	}
+
+	value, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__ACTIONS__HTTP__HEADERS__%v__VALUE", idx))
+	if ok {
+		header.Value = value
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.Common.Actions.HTTP.Headers) > idx {
+			globalConf.Common.Actions.HTTP.Headers[idx] = header
+		} else {
+			globalConf.Common.Actions.HTTP.Headers = append(globalConf.Common.Actions.HTTP.Headers, header)
+		}
+	}
+}
+
+func getScheduledJobsFromEnv(idx int) {
+	var job common.ScheduledJob
+	if len(globalConf.Common.Scheduler.Jobs) > idx {
+		job = globalConf.Common.Scheduler.Jobs[idx]
+	}
+
+	isSet := false
+
+	name, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__SCHEDULER__JOBS__%v__NAME", idx))
+	if ok {
+		job.Name = name
+		isSet = true
+	}
+
+	schedule, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__SCHEDULER__JOBS__%v__SCHEDULE", idx))
+	if ok {
+		job.Schedule = schedule
+		isSet = true
+	}
+
+	jobType, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__SCHEDULER__JOBS__%v__TYPE", idx))
+	if ok {
+		job.Type = jobType
+		isSet = true
+	}
+
+	outputPath, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__SCHEDULER__JOBS__%v__OUTPUT_PATH", idx))
+	if ok {
+		job.OutputPath = outputPath
+		isSet = true
+	}
+
+	maxBackups, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_COMMON__SCHEDULER__JOBS__%v__MAX_BACKUPS", idx))
+	if ok {
+		job.MaxBackups = int(maxBackups)
+		isSet = true
+	}
+
+	command, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_COMMON__SCHEDULER__JOBS__%v__COMMAND", idx))
+	if ok {
+		job.Command = command
+		isSet = true
+	}
+
+	args, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_COMMON__SCHEDULER__JOBS__%v__ARGS", idx))
+	if ok {
+		job.Args = args
+		isSet = true
+	}
+
+	timeout, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_COMMON__SCHEDULER__JOBS__%v__TIMEOUT", idx))
+	if ok {
+		job.Timeout = int(timeout)
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.Common.Scheduler.Jobs) > idx {
+			globalConf.Common.Scheduler.Jobs[idx] = job
+		} else {
+			globalConf.Common.Scheduler.Jobs = append(globalConf.Common.Scheduler.Jobs, job)
+		}
+	}
+}
+
+func getPluginsFromEnv(idx int) {
+	var pluginConfig plugin.Config
+	if len(globalConf.PluginsConfig) > idx {
+		pluginConfig = globalConf.PluginsConfig[idx]
+	}
+
+	isSet := false
+
+	pluginType, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__TYPE", idx))
+	if ok {
+		pluginConfig.Type = pluginType
+		isSet = true
+	}
+
+	fsEvents, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__FS_EVENTS", idx))
+	if ok {
+		pluginConfig.NotifierOptions.FsEvents = fsEvents
+		isSet = true
+	}
+
+	providerEvents, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__PROVIDER_EVENTS", idx))
+	if ok {
+		pluginConfig.NotifierOptions.ProviderEvents = providerEvents
+		isSet = true
+	}
+
+	providerObjects, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__NOTIFIER_OPTIONS__PROVIDER_OBJECTS", idx))
+	if ok {
+		pluginConfig.NotifierOptions.ProviderObjects = providerObjects
+		isSet = true
+	}
+
+	authScope, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__AUTH_OPTIONS__SCOPE", idx))
+	if ok {
+		pluginConfig.AuthOptions.Scope = int(authScope)
+		isSet = true
+	}
+
+	kmsScheme, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__KMS_OPTIONS__SCHEME", idx))
+	if ok {
+		pluginConfig.KMSOptions.Scheme = kmsScheme
+		isSet = true
+	}
+
+	kmsEncStatus, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__KMS_OPTIONS__ENCRYPTED_STATUS", idx))
+	if ok {
+		pluginConfig.KMSOptions.EncryptedStatus = kmsEncStatus
+		isSet = true
+	}
+
+	storageName, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__STORAGE_OPTIONS__NAME", idx))
+	if ok {
+		pluginConfig.StorageOptions.Name = storageName
+		isSet = true
+	}
+
+	cmd, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__CMD", idx))
+	if ok {
+		pluginConfig.Cmd = cmd
+		isSet = true
+	}
+
+	args, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__ARGS", idx))
+	if ok {
+		pluginConfig.Args = args
+		isSet = true
+	}
+
+	checksum, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_PLUGINS__%v__SHA256SUM", idx))
+	if ok {
+		pluginConfig.SHA256Sum = checksum
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.PluginsConfig) > idx {
+			globalConf.PluginsConfig[idx] = pluginConfig
+		} else {
+			globalConf.PluginsConfig = append(globalConf.PluginsConfig, pluginConfig)
+		}
+	}
+}
+
 func getSFTPDBindindFromEnv(idx int) {
 	binding := sftpd.Binding{
 		ApplyProxyConfig: true,
@@ -659,6 +1286,43 @@ func getSFTPDBindindFromEnv(idx int) {
 		isSet = true
 	}
 
+	banner, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__BANNER", idx))
+	if ok {
+		binding.Banner = banner
+		isSet = true
+	}
+
+	ciphers, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__CIPHERS", idx))
+	if ok {
+		binding.Ciphers = ciphers
+		isSet = true
+	}
+
+	proxyProtocol, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__PROXY_PROTOCOL", idx))
+	if ok {
+		binding.ProxyProtocol = int(proxyProtocol)
+		isSet = true
+	}
+
+	proxyAllowed, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__PROXY_ALLOWED", idx))
+	if ok {
+		binding.ProxyAllowed = proxyAllowed
+		isSet = true
+	}
+
+	sshCommands, ok := lookupStringListFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__ENABLED_SSH_COMMANDS", idx))
+	if ok {
+		binding.EnabledSSHCommands = sshCommands
+		isSet = true
+	}
+
+	uploadMode, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_SFTPD__BINDINGS__%v__UPLOAD_MODE", idx))
+	if ok {
+		mode := int(uploadMode)
+		binding.UploadMode = &mode
+		isSet = true
+	}
+
 	if isSet {
 		if len(globalConf.SFTPD.Bindings) > idx {
 			globalConf.SFTPD.Bindings[idx] = binding
@@ -782,6 +1446,102 @@ func getWebDAVDBindingFromEnv(idx int) {
 	}
 }
 
+func getRsyncDBindingFromEnv(idx int) {
+	binding := rsyncd.Binding{
+		ApplyProxyConfig: true,
+	}
+	if len(globalConf.RsyncD.Bindings) > idx {
+		binding = globalConf.RsyncD.Bindings[idx]
+	}
+
+	isSet := false
+
+	port, ok := lookupIntFromEnv(fmt.Sprintf("SFTPGO_RSYNCD__BINDINGS__%v__PORT", idx))
+	if ok {
+		binding.Port = int(port)
+		isSet = true
+	}
+
+	address, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_RSYNCD__BINDINGS__%v__ADDRESS", idx))
+	if ok {
+		binding.Address = address
+		isSet = true
+	}
+
+	applyProxyConfig, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_RSYNCD__BINDINGS__%v__APPLY_PROXY_CONFIG", idx))
+	if ok {
+		binding.ApplyProxyConfig = applyProxyConfig
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.RsyncD.Bindings) > idx {
+			globalConf.RsyncD.Bindings[idx] = binding
+		} else {
+			globalConf.RsyncD.Bindings = append(globalConf.RsyncD.Bindings, binding)
+		}
+	}
+}
+
+func getRsyncDModuleFromEnv(idx int) {
+	module := rsyncd.Module{}
+	if len(globalConf.RsyncD.Modules) > idx {
+		module = globalConf.RsyncD.Modules[idx]
+	}
+
+	isSet := false
+
+	name, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_RSYNCD__MODULES__%v__NAME", idx))
+	if ok {
+		module.Name = name
+		isSet = true
+	}
+
+	comment, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_RSYNCD__MODULES__%v__COMMENT", idx))
+	if ok {
+		module.Comment = comment
+		isSet = true
+	}
+
+	username, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_RSYNCD__MODULES__%v__USERNAME", idx))
+	if ok {
+		module.Username = username
+		isSet = true
+	}
+
+	modulePath, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_RSYNCD__MODULES__%v__PATH", idx))
+	if ok {
+		module.Path = modulePath
+		isSet = true
+	}
+
+	password, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_RSYNCD__MODULES__%v__PASSWORD", idx))
+	if ok {
+		module.Password = password
+		isSet = true
+	}
+
+	readOnly, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_RSYNCD__MODULES__%v__READ_ONLY", idx))
+	if ok {
+		module.ReadOnly = readOnly
+		isSet = true
+	}
+
+	list, ok := lookupBoolFromEnv(fmt.Sprintf("SFTPGO_RSYNCD__MODULES__%v__LIST", idx))
+	if ok {
+		module.List = list
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.RsyncD.Modules) > idx {
+			globalConf.RsyncD.Modules[idx] = module
+		} else {
+			globalConf.RsyncD.Modules = append(globalConf.RsyncD.Modules, module)
+		}
+	}
+}
+
 func getHTTPDBindingFromEnv(idx int) {
 	binding := httpd.Binding{}
 	if len(globalConf.HTTPDConfig.Bindings) > idx {
@@ -863,17 +1623,83 @@ func getHTTPClientCertificatesFromEnv(idx int) {
 	}
 }
 
+func getHTTPClientHeadersFromEnv(idx int) {
+	var header httpclient.Header
+	if len(globalConf.HTTPConfig.Headers) > idx {
+		header = globalConf.HTTPConfig.Headers[idx]
+	}
+
+	isSet := false
+
+	key, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTP__HEADERS__%v__KEY", idx))
+	if ok {
+		header.Key = key
+		isSet = true
+	}
+
+	value, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTP__HEADERS__%v__VALUE", idx))
+	if ok {
+		header.Value = value
+		isSet = true
+	}
+
+	url, ok := os.LookupEnv(fmt.Sprintf("SFTPGO_HTTP__HEADERS__%v__URL", idx))
+	if ok {
+		header.URL = url
+		isSet = true
+	}
+
+	if isSet {
+		if len(globalConf.HTTPConfig.Headers) > idx {
+			globalConf.HTTPConfig.Headers[idx] = header
+		} else {
+			globalConf.HTTPConfig.Headers = append(globalConf.HTTPConfig.Headers, header)
+		}
+	}
+}
+
 func setViperDefaults() {
 	viper.SetDefault("common.idle_timeout", globalConf.Common.IdleTimeout)
 	viper.SetDefault("common.upload_mode", globalConf.Common.UploadMode)
 	viper.SetDefault("common.actions.execute_on", globalConf.Common.Actions.ExecuteOn)
+	viper.SetDefault("common.actions.filters", globalConf.Common.Actions.Filters)
 	viper.SetDefault("common.actions.hook", globalConf.Common.Actions.Hook)
+	viper.SetDefault("common.actions.progress_interval", globalConf.Common.Actions.ProgressInterval)
+	viper.SetDefault("common.actions.retry.max_retries", globalConf.Common.Actions.Retry.MaxRetries)
+	viper.SetDefault("common.actions.retry.base_delay", globalConf.Common.Actions.Retry.BaseDelay)
+	viper.SetDefault("common.actions.retry.max_window", globalConf.Common.Actions.Retry.MaxWindow)
+	viper.SetDefault("common.actions.http.body", globalConf.Common.Actions.HTTP.Body)
+	viper.SetDefault("common.actions.http.content_type", globalConf.Common.Actions.HTTP.ContentType)
+	viper.SetDefault("common.actions.http.headers", globalConf.Common.Actions.HTTP.Headers)
+	viper.SetDefault("common.actions.http.signing_secret", globalConf.Common.Actions.HTTP.SigningSecret)
+	viper.SetDefault("common.actions.mqtt.topic", globalConf.Common.Actions.MQTT.Topic)
+	viper.SetDefault("common.actions.mqtt.qos", globalConf.Common.Actions.MQTT.QoS)
+	viper.SetDefault("common.actions.mqtt.client_id", globalConf.Common.Actions.MQTT.ClientID)
+	viper.SetDefault("common.actions.mqtt.username", globalConf.Common.Actions.MQTT.Username)
+	viper.SetDefault("common.actions.mqtt.password", globalConf.Common.Actions.MQTT.Password)
+	viper.SetDefault("common.actions.kafka.topic", globalConf.Common.Actions.Kafka.Topic)
+	viper.SetDefault("common.actions.kafka.key_template", globalConf.Common.Actions.Kafka.KeyTemplate)
+	viper.SetDefault("common.actions.kafka.tls", globalConf.Common.Actions.Kafka.TLS)
+	viper.SetDefault("common.actions.kafka.sasl_mechanism", globalConf.Common.Actions.Kafka.SASLMechanism)
+	viper.SetDefault("common.actions.kafka.username", globalConf.Common.Actions.Kafka.Username)
+	viper.SetDefault("common.actions.kafka.password", globalConf.Common.Actions.Kafka.Password)
+	viper.SetDefault("common.actions.kafka.client_id", globalConf.Common.Actions.Kafka.ClientID)
+	viper.SetDefault("common.actions.kafka.batch_size", globalConf.Common.Actions.Kafka.BatchSize)
+	viper.SetDefault("common.actions.kafka.batch_timeout", globalConf.Common.Actions.Kafka.BatchTimeout)
+	viper.SetDefault("common.actions.amqp.exchange", globalConf.Common.Actions.AMQP.Exchange)
+	viper.SetDefault("common.actions.amqp.routing_key", globalConf.Common.Actions.AMQP.RoutingKey)
+	viper.SetDefault("common.actions.amqp.persistent", globalConf.Common.Actions.AMQP.Persistent)
+	viper.SetDefault("common.actions.syslog.facility", globalConf.Common.Actions.Syslog.Facility)
+	viper.SetDefault("common.actions.syslog.app_name", globalConf.Common.Actions.Syslog.AppName)
 	viper.SetDefault("common.setstat_mode", globalConf.Common.SetstatMode)
+	viper.SetDefault("common.upload_checksums", globalConf.Common.UploadChecksums)
 	viper.SetDefault("common.proxy_protocol", globalConf.Common.ProxyProtocol)
 	viper.SetDefault("common.proxy_allowed", globalConf.Common.ProxyAllowed)
+	viper.SetDefault("common.pre_connect_hook", globalConf.Common.PreConnectHook)
 	viper.SetDefault("common.post_connect_hook", globalConf.Common.PostConnectHook)
 	viper.SetDefault("common.max_total_connections", globalConf.Common.MaxTotalConnections)
 	viper.SetDefault("common.defender.enabled", globalConf.Common.DefenderConfig.Enabled)
+	viper.SetDefault("common.defender.driver", globalConf.Common.DefenderConfig.Driver)
 	viper.SetDefault("common.defender.ban_time", globalConf.Common.DefenderConfig.BanTime)
 	viper.SetDefault("common.defender.ban_time_increment", globalConf.Common.DefenderConfig.BanTimeIncrement)
 	viper.SetDefault("common.defender.threshold", globalConf.Common.DefenderConfig.Threshold)
@@ -885,6 +1711,28 @@ func setViperDefaults() {
 	viper.SetDefault("common.defender.entries_hard_limit", globalConf.Common.DefenderConfig.EntriesHardLimit)
 	viper.SetDefault("common.defender.safelist_file", globalConf.Common.DefenderConfig.SafeListFile)
 	viper.SetDefault("common.defender.blocklist_file", globalConf.Common.DefenderConfig.BlockListFile)
+	viper.SetDefault("common.defender.lists_check_interval", globalConf.Common.DefenderConfig.ListsCheckInterval)
+	viper.SetDefault("common.disk_cache.path", globalConf.Common.DiskCache.Path)
+	viper.SetDefault("common.disk_cache.max_size", globalConf.Common.DiskCache.MaxSize)
+	viper.SetDefault("common.disk_cache.write_back", globalConf.Common.DiskCache.WriteBack)
+	viper.SetDefault("common.data_retention.check_interval", globalConf.Common.DataRetention.CheckInterval)
+	viper.SetDefault("common.data_retention.dry_run", globalConf.Common.DataRetention.DryRun)
+	viper.SetDefault("common.events_queue.enabled", globalConf.Common.EventsQueue.Enabled)
+	viper.SetDefault("common.events_queue.workers", globalConf.Common.EventsQueue.Workers)
+	viper.SetDefault("common.events_queue.check_interval", globalConf.Common.EventsQueue.CheckInterval)
+	viper.SetDefault("common.scheduler.jobs", globalConf.Common.Scheduler.Jobs)
+	viper.SetDefault("common.shared_connections.enabled", globalConf.Common.SharedConnections.Enabled)
+	viper.SetDefault("common.shared_connections.node_id", globalConf.Common.SharedConnections.NodeID)
+	viper.SetDefault("common.shared_connections.update_interval", globalConf.Common.SharedConnections.UpdateInterval)
+	viper.SetDefault("common.load_shedding.max_goroutines", globalConf.Common.LoadShedding.MaxGoroutines)
+	viper.SetDefault("common.load_shedding.max_memory", globalConf.Common.LoadShedding.MaxMemory)
+	viper.SetDefault("common.load_shedding.max_load_average", globalConf.Common.LoadShedding.MaxLoadAverage)
+	viper.SetDefault("common.load_shedding.check_interval", globalConf.Common.LoadShedding.CheckInterval)
+	viper.SetDefault("common.quota_scan.files_per_second", globalConf.Common.QuotaScan.FilesPerSecond)
+	viper.SetDefault("common.quota_scan.requests_per_second", globalConf.Common.QuotaScan.RequestsPerSecond)
+	viper.SetDefault("common.quota_scan.max_concurrent_scans", globalConf.Common.QuotaScan.MaxConcurrentScans)
+	viper.SetDefault("common.geoip.country_database", globalConf.Common.GeoIP.CountryDatabase)
+	viper.SetDefault("common.geoip.asn_database", globalConf.Common.GeoIP.ASNDatabase)
 	viper.SetDefault("sftpd.max_auth_tries", globalConf.SFTPD.MaxAuthTries)
 	viper.SetDefault("sftpd.banner", globalConf.SFTPD.Banner)
 	viper.SetDefault("sftpd.host_keys", globalConf.SFTPD.HostKeys)
@@ -924,6 +1772,7 @@ func setViperDefaults() {
 	viper.SetDefault("webdavd.cache.users.max_size", globalConf.WebDAVD.Cache.Users.MaxSize)
 	viper.SetDefault("webdavd.cache.mime_types.enabled", globalConf.WebDAVD.Cache.MimeTypes.Enabled)
 	viper.SetDefault("webdavd.cache.mime_types.max_size", globalConf.WebDAVD.Cache.MimeTypes.MaxSize)
+	viper.SetDefault("rsyncd.rsync_path", globalConf.RsyncD.RsyncPath)
 	viper.SetDefault("data_provider.driver", globalConf.ProviderConf.Driver)
 	viper.SetDefault("data_provider.name", globalConf.ProviderConf.Name)
 	viper.SetDefault("data_provider.host", globalConf.ProviderConf.Host)
@@ -931,12 +1780,17 @@ func setViperDefaults() {
 	viper.SetDefault("data_provider.username", globalConf.ProviderConf.Username)
 	viper.SetDefault("data_provider.password", globalConf.ProviderConf.Password)
 	viper.SetDefault("data_provider.sslmode", globalConf.ProviderConf.SSLMode)
+	viper.SetDefault("data_provider.root_cert", globalConf.ProviderConf.RootCert)
+	viper.SetDefault("data_provider.client_cert", globalConf.ProviderConf.ClientCert)
+	viper.SetDefault("data_provider.client_key", globalConf.ProviderConf.ClientKey)
+	viper.SetDefault("data_provider.tls_server_name", globalConf.ProviderConf.TLSServerName)
 	viper.SetDefault("data_provider.connection_string", globalConf.ProviderConf.ConnectionString)
 	viper.SetDefault("data_provider.sql_tables_prefix", globalConf.ProviderConf.SQLTablesPrefix)
 	viper.SetDefault("data_provider.track_quota", globalConf.ProviderConf.TrackQuota)
 	viper.SetDefault("data_provider.pool_size", globalConf.ProviderConf.PoolSize)
 	viper.SetDefault("data_provider.users_base_dir", globalConf.ProviderConf.UsersBaseDir)
 	viper.SetDefault("data_provider.actions.execute_on", globalConf.ProviderConf.Actions.ExecuteOn)
+	viper.SetDefault("data_provider.actions.execute_for", globalConf.ProviderConf.Actions.ExecuteFor)
 	viper.SetDefault("data_provider.actions.hook", globalConf.ProviderConf.Actions.Hook)
 	viper.SetDefault("data_provider.external_auth_hook", globalConf.ProviderConf.ExternalAuthHook)
 	viper.SetDefault("data_provider.external_auth_scope", globalConf.ProviderConf.ExternalAuthScope)
@@ -964,14 +1818,46 @@ func setViperDefaults() {
 	viper.SetDefault("httpd.certificate_key_file", globalConf.HTTPDConfig.CertificateKeyFile)
 	viper.SetDefault("httpd.ca_certificates", globalConf.HTTPDConfig.CACertificates)
 	viper.SetDefault("httpd.ca_revocation_lists", globalConf.HTTPDConfig.CARevocationLists)
+	viper.SetDefault("httpd.require_approval", globalConf.HTTPDConfig.RequireApproval)
 	viper.SetDefault("http.timeout", globalConf.HTTPConfig.Timeout)
+	viper.SetDefault("http.hook_timeouts.external_auth", globalConf.HTTPConfig.HookTimeouts.ExternalAuth)
+	viper.SetDefault("http.hook_timeouts.pre_login", globalConf.HTTPConfig.HookTimeouts.PreLogin)
+	viper.SetDefault("http.hook_timeouts.post_login", globalConf.HTTPConfig.HookTimeouts.PostLogin)
+	viper.SetDefault("http.hook_timeouts.check_password", globalConf.HTTPConfig.HookTimeouts.CheckPassword)
+	viper.SetDefault("http.hook_timeouts.keyboard_interactive", globalConf.HTTPConfig.HookTimeouts.KeyboardInteractive)
+	viper.SetDefault("http.hook_timeouts.actions", globalConf.HTTPConfig.HookTimeouts.Actions)
+	viper.SetDefault("http.hook_timeouts.provider_actions", globalConf.HTTPConfig.HookTimeouts.ProviderActions)
+	viper.SetDefault("http.hook_timeouts.connect", globalConf.HTTPConfig.HookTimeouts.Connect)
 	viper.SetDefault("http.retry_wait_min", globalConf.HTTPConfig.RetryWaitMin)
 	viper.SetDefault("http.retry_wait_max", globalConf.HTTPConfig.RetryWaitMax)
 	viper.SetDefault("http.retry_max", globalConf.HTTPConfig.RetryMax)
 	viper.SetDefault("http.ca_certificates", globalConf.HTTPConfig.CACertificates)
 	viper.SetDefault("http.skip_tls_verify", globalConf.HTTPConfig.SkipTLSVerify)
+	viper.SetDefault("http.proxy_url", globalConf.HTTPConfig.ProxyURL)
+	viper.SetDefault("http.no_proxy", globalConf.HTTPConfig.NoProxy)
+	viper.SetDefault("http.max_idle_conns", globalConf.HTTPConfig.MaxIdleConns)
+	viper.SetDefault("http.max_idle_conns_per_host", globalConf.HTTPConfig.MaxIdleConnsPerHost)
+	viper.SetDefault("http.max_conns_per_host", globalConf.HTTPConfig.MaxConnsPerHost)
+	viper.SetDefault("http.idle_conn_timeout", globalConf.HTTPConfig.IdleConnTimeout)
+	viper.SetDefault("http.tls_handshake_timeout", globalConf.HTTPConfig.TLSHandshakeTimeout)
 	viper.SetDefault("kms.secrets.url", globalConf.KMSConfig.Secrets.URL)
+	viper.SetDefault("kms.secrets.master_key", globalConf.KMSConfig.Secrets.MasterKeyString)
 	viper.SetDefault("kms.secrets.master_key_path", globalConf.KMSConfig.Secrets.MasterKeyPath)
+	viper.SetDefault("vault.address", globalConf.Vault.Address)
+	viper.SetDefault("vault.namespace", globalConf.Vault.Namespace)
+	viper.SetDefault("vault.kv_mount_path", globalConf.Vault.KVMountPath)
+	viper.SetDefault("vault.transit_mount_path", globalConf.Vault.TransitMountPath)
+	viper.SetDefault("vault.auth_method", globalConf.Vault.AuthMethod)
+	viper.SetDefault("vault.token", globalConf.Vault.Token)
+	viper.SetDefault("vault.approle.mount_path", globalConf.Vault.AppRole.MountPath)
+	viper.SetDefault("vault.approle.role_id", globalConf.Vault.AppRole.RoleID)
+	viper.SetDefault("vault.approle.secret_id", globalConf.Vault.AppRole.SecretID)
+	viper.SetDefault("vault.ca_certificate", globalConf.Vault.CACertificate)
+	viper.SetDefault("vault.skip_tls_verify", globalConf.Vault.SkipTLSVerify)
+	viper.SetDefault("aws_secrets.region", globalConf.AWSSecrets.Region)
+	viper.SetDefault("aws_secrets.role_arn", globalConf.AWSSecrets.RoleARN)
+	viper.SetDefault("aws_secrets.endpoint", globalConf.AWSSecrets.Endpoint)
+	viper.SetDefault("plugins", globalConf.PluginsConfig)
 	viper.SetDefault("telemetry.bind_port", globalConf.TelemetryConfig.BindPort)
 	viper.SetDefault("telemetry.bind_address", globalConf.TelemetryConfig.BindAddress)
 	viper.SetDefault("telemetry.enable_profiler", globalConf.TelemetryConfig.EnableProfiler)
@@ -979,6 +1865,38 @@ func setViperDefaults() {
 	viper.SetDefault("telemetry.certificate_file", globalConf.TelemetryConfig.CertificateFile)
 	viper.SetDefault("telemetry.certificate_key_file", globalConf.TelemetryConfig.CertificateKeyFile)
 	viper.SetDefault("telemetry.tls_cipher_suites", globalConf.TelemetryConfig.TLSCipherSuites)
+	viper.SetDefault("telemetry.per_user_metrics", globalConf.TelemetryConfig.PerUserMetrics)
+	viper.SetDefault("telemetry.per_user_metrics_limit", globalConf.TelemetryConfig.PerUserMetricsLimit)
+	viper.SetDefault("statsd.address", globalConf.StatsD.Address)
+	viper.SetDefault("statsd.format", globalConf.StatsD.Format)
+	viper.SetDefault("statsd.prefix", globalConf.StatsD.Prefix)
+	viper.SetDefault("statsd.tags", globalConf.StatsD.Tags)
+	viper.SetDefault("statsd.flush_interval", globalConf.StatsD.FlushInterval)
+	viper.SetDefault("tracing.endpoint", globalConf.Tracing.Endpoint)
+	viper.SetDefault("tracing.insecure", globalConf.Tracing.Insecure)
+	viper.SetDefault("tracing.service_name", globalConf.Tracing.ServiceName)
+	viper.SetDefault("tracing.sample_ratio", globalConf.Tracing.SampleRatio)
+	viper.SetDefault("audit_log.file_path", globalConf.AuditLog.FilePath)
+	viper.SetDefault("audit_log.max_size", globalConf.AuditLog.MaxSize)
+	viper.SetDefault("audit_log.max_backups", globalConf.AuditLog.MaxBackups)
+	viper.SetDefault("audit_log.max_age", globalConf.AuditLog.MaxAge)
+	viper.SetDefault("audit_log.compress", globalConf.AuditLog.Compress)
+	viper.SetDefault("access_log.file_path", globalConf.AccessLog.FilePath)
+	viper.SetDefault("access_log.format", globalConf.AccessLog.Format)
+	viper.SetDefault("access_log.max_size", globalConf.AccessLog.MaxSize)
+	viper.SetDefault("access_log.max_backups", globalConf.AccessLog.MaxBackups)
+	viper.SetDefault("access_log.max_age", globalConf.AccessLog.MaxAge)
+	viper.SetDefault("access_log.compress", globalConf.AccessLog.Compress)
+	viper.SetDefault("remote_log.address", globalConf.RemoteLog.Address)
+	viper.SetDefault("remote_log.format", globalConf.RemoteLog.Format)
+	viper.SetDefault("remote_log.enable_tls", globalConf.RemoteLog.EnableTLS)
+	viper.SetDefault("remote_log.ca_certificates", globalConf.RemoteLog.CACertificates)
+	viper.SetDefault("remote_log.skip_tls_verify", globalConf.RemoteLog.SkipTLSVerify)
+	viper.SetDefault("remote_log.buffer_size", globalConf.RemoteLog.BufferSize)
+	viper.SetDefault("log_sampling.interval", globalConf.LogSampling.Interval)
+	viper.SetDefault("log_sampling.burst", globalConf.LogSampling.Burst)
+	viper.SetDefault("log_sampling.thereafter", globalConf.LogSampling.Thereafter)
+	viper.SetDefault("log_sampling.excluded_senders", globalConf.LogSampling.ExcludedSenders)
 }
 
 func lookupBoolFromEnv(envName string) (bool, bool) {
@@ -0,0 +1,459 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+var tomlBareKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// ConvertConfig converts the configuration file to the specified format: "json",
+// "yaml" or "toml". The configuration file is searched as in LoadConfig, the
+// configuration fragments and the environment variables are not included.
+// It returns the converted configuration and the path of the source file.
+// The keys order is preserved for JSON and YAML source files and the YAML
+// comments are preserved converting to YAML or TOML
+func ConvertConfig(configDir, configFile, format string) ([]byte, string, error) {
+	setConfigPaths(configDir, configFile)
+	if err := viper.ReadInConfig(); err != nil {
+		return nil, "", err
+	}
+	source := viper.ConfigFileUsed()
+	doc, err := readConfigNode(source)
+	if err != nil {
+		return nil, source, err
+	}
+	var data []byte
+	switch format {
+	case "json":
+		data, err = encodeJSONNode(doc)
+	case "yaml", "yml":
+		data, err = encodeYAMLNode(doc)
+	case "toml":
+		data, err = encodeTOMLNode(doc)
+	default:
+		err = fmt.Errorf("unsupported format %#v, supported formats: json, yaml, toml", format)
+	}
+	return data, source, err
+}
+
+// readConfigNode reads the specified configuration file and returns its
+// contents as a YAML document node
+func readConfigNode(name string) (*yaml.Node, error) {
+	var root *yaml.Node
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		root, err = decodeJSONNode(dec)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %#v: %w", name, err)
+		}
+	case ".yaml", ".yml":
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("unable to parse %#v: %w", name, err)
+		}
+		if doc.Kind != yaml.DocumentNode || len(doc.Content) != 1 || doc.Content[0].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("unable to parse %#v: the configuration must be a mapping", name)
+		}
+		return &doc, nil
+	default:
+		// the other formats are read using viper, the keys order and the comments are lost
+		v := viper.New()
+		v.SetConfigFile(name)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, err
+		}
+		root = &yaml.Node{}
+		if err := root.Encode(v.AllSettings()); err != nil {
+			return nil, err
+		}
+	}
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("unable to parse %#v: the configuration must be an object", name)
+	}
+	return &yaml.Node{
+		Kind:    yaml.DocumentNode,
+		Content: []*yaml.Node{root},
+	}, nil
+}
+
+// decodeJSONNode decodes the next JSON value preserving the keys order
+func decodeJSONNode(dec *json.Decoder) (*yaml.Node, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch v := token.(type) {
+	case json.Delim:
+		node := &yaml.Node{}
+		switch v {
+		case '{':
+			node.Kind = yaml.MappingNode
+			node.Tag = "!!map"
+			for dec.More() {
+				keyToken, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				key, ok := keyToken.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid object key %v", keyToken)
+				}
+				value, err := decodeJSONNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+			}
+		case '[':
+			node.Kind = yaml.SequenceNode
+			node.Tag = "!!seq"
+			for dec.More() {
+				value, err := decodeJSONNode(dec)
+				if err != nil {
+					return nil, err
+				}
+				node.Content = append(node.Content, value)
+			}
+		default:
+			return nil, fmt.Errorf("unexpected delimiter %v", v)
+		}
+		// read the closing delimiter
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return node, nil
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v}, nil
+	case json.Number:
+		tag := "!!float"
+		if _, err := v.Int64(); err == nil {
+			tag = "!!int"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: v.String()}, nil
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v)}, nil
+	case nil:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+	return nil, fmt.Errorf("unexpected token %v", token)
+}
+
+func encodeYAMLNode(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeJSONNode(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeJSONNode(&buf, doc); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return nil, err
+	}
+	out.WriteString("\n")
+	return out.Bytes(), nil
+}
+
+func writeJSONNode(buf *bytes.Buffer, node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		return writeJSONNode(buf, node.Content[0])
+	case yaml.AliasNode:
+		return writeJSONNode(buf, node.Alias)
+	case yaml.MappingNode:
+		buf.WriteString("{")
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			if idx > 0 {
+				buf.WriteString(",")
+			}
+			key, err := json.Marshal(node.Content[idx].Value)
+			if err != nil {
+				return err
+			}
+			buf.Write(key)
+			buf.WriteString(":")
+			if err := writeJSONNode(buf, node.Content[idx+1]); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("}")
+	case yaml.SequenceNode:
+		buf.WriteString("[")
+		for idx, item := range node.Content {
+			if idx > 0 {
+				buf.WriteString(",")
+			}
+			if err := writeJSONNode(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]")
+	default:
+		var value interface{}
+		if err := node.Decode(&value); err != nil {
+			return err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	}
+	return nil
+}
+
+func encodeTOMLNode(doc *yaml.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	writeTOMLComment(&buf, doc.HeadComment)
+	root := doc.Content[0]
+	writeTOMLComment(&buf, root.HeadComment)
+	if err := writeTOMLTable(&buf, root, nil); err != nil {
+		return nil, err
+	}
+	return bytes.TrimLeft(buf.Bytes(), "\n"), nil
+}
+
+// writeTOMLTable writes the given mapping node as TOML table. The values are
+// written first, since the keys following a table header belong to that table,
+// then the nested tables and arrays of tables
+func writeTOMLTable(buf *bytes.Buffer, node *yaml.Node, path []string) error {
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		key := node.Content[idx]
+		value := resolveYAMLAlias(node.Content[idx+1])
+		if isTOMLTable(value) || isTOMLArrayOfTables(value) || value.ShortTag() == "!!null" {
+			continue
+		}
+		tomlValue, err := getTOMLValue(value)
+		if err != nil {
+			return fmt.Errorf("%v: %w", strings.Join(append(path, key.Value), "."), err)
+		}
+		writeTOMLComment(buf, key.HeadComment)
+		buf.WriteString(getTOMLKey(key.Value) + " = " + tomlValue)
+		writeTOMLLineComment(buf, key, value)
+		buf.WriteString("\n")
+	}
+	for idx := 0; idx+1 < len(node.Content); idx += 2 {
+		key := node.Content[idx]
+		value := resolveYAMLAlias(node.Content[idx+1])
+		tablePath := make([]string, 0, len(path)+1)
+		tablePath = append(tablePath, path...)
+		tablePath = append(tablePath, key.Value)
+		header := make([]string, 0, len(tablePath))
+		for _, p := range tablePath {
+			header = append(header, getTOMLKey(p))
+		}
+		switch {
+		case isTOMLTable(value):
+			buf.WriteString("\n")
+			writeTOMLComment(buf, key.HeadComment)
+			buf.WriteString("[" + strings.Join(header, ".") + "]")
+			writeTOMLLineComment(buf, key, value)
+			buf.WriteString("\n")
+			if err := writeTOMLTable(buf, value, tablePath); err != nil {
+				return err
+			}
+		case isTOMLArrayOfTables(value):
+			for itemIdx, item := range value.Content {
+				buf.WriteString("\n")
+				if itemIdx == 0 {
+					writeTOMLComment(buf, key.HeadComment)
+				}
+				writeTOMLComment(buf, item.HeadComment)
+				buf.WriteString("[[" + strings.Join(header, ".") + "]]\n")
+				if err := writeTOMLTable(buf, resolveYAMLAlias(item), tablePath); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func resolveYAMLAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+func isTOMLTable(node *yaml.Node) bool {
+	return node.Kind == yaml.MappingNode
+}
+
+func isTOMLArrayOfTables(node *yaml.Node) bool {
+	if node.Kind != yaml.SequenceNode || len(node.Content) == 0 {
+		return false
+	}
+	for _, item := range node.Content {
+		if resolveYAMLAlias(item).Kind != yaml.MappingNode {
+			return false
+		}
+	}
+	return true
+}
+
+// getTOMLValue returns the inline TOML representation for the given node
+func getTOMLValue(node *yaml.Node) (string, error) {
+	node = resolveYAMLAlias(node)
+	switch node.Kind {
+	case yaml.SequenceNode:
+		values := make([]string, 0, len(node.Content))
+		for _, item := range node.Content {
+			value, err := getTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			values = append(values, value)
+		}
+		return "[" + strings.Join(values, ", ") + "]", nil
+	case yaml.MappingNode:
+		values := make([]string, 0, len(node.Content)/2)
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			value, err := getTOMLValue(node.Content[idx+1])
+			if err != nil {
+				return "", err
+			}
+			values = append(values, getTOMLKey(node.Content[idx].Value)+" = "+value)
+		}
+		if len(values) == 0 {
+			return "{}", nil
+		}
+		return "{ " + strings.Join(values, ", ") + " }", nil
+	}
+	switch node.ShortTag() {
+	case "!!int":
+		var value int64
+		if err := node.Decode(&value); err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(value, 10), nil
+	case "!!float":
+		var value float64
+		if err := node.Decode(&value); err != nil {
+			return "", err
+		}
+		switch {
+		case math.IsNaN(value):
+			return "nan", nil
+		case math.IsInf(value, 1):
+			return "inf", nil
+		case math.IsInf(value, -1):
+			return "-inf", nil
+		}
+		result := strconv.FormatFloat(value, 'f', -1, 64)
+		if !strings.Contains(result, ".") {
+			result += ".0"
+		}
+		return result, nil
+	case "!!bool":
+		var value bool
+		if err := node.Decode(&value); err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(value), nil
+	case "!!null":
+		return "", errors.New("null values are not supported in TOML")
+	}
+	return getTOMLString(node.Value), nil
+}
+
+func getTOMLKey(key string) string {
+	if tomlBareKeyRegex.MatchString(key) {
+		return key
+	}
+	return getTOMLString(key)
+}
+
+func getTOMLString(value string) string {
+	var sb strings.Builder
+	sb.WriteString(`"`)
+	for _, r := range value {
+		switch r {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&sb, `\u%04X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	sb.WriteString(`"`)
+	return sb.String()
+}
+
+func writeTOMLComment(buf *bytes.Buffer, comment string) {
+	if comment == "" {
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "#") {
+			line = "# " + line
+		}
+		buf.WriteString(line + "\n")
+	}
+}
+
+func writeTOMLLineComment(buf *bytes.Buffer, key, value *yaml.Node) {
+	comment := value.LineComment
+	if comment == "" {
+		comment = key.LineComment
+	}
+	comment = strings.TrimSpace(comment)
+	if comment == "" {
+		return
+	}
+	if !strings.HasPrefix(comment, "#") {
+		comment = "# " + comment
+	}
+	buf.WriteString(" " + comment)
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+)
+
+const (
+	docsRepoURL        = "https://github.com/drakkan/sftpgo/blob/main/"
+	docsSectionHeading = "## Configuration file"
+)
+
+var (
+	docsSectionRegex = regexp.MustCompile(`^- \*\*"?([a-z0-9_]+)"?\*\*,?\s*(.*)$`)
+	docsSettingRegex = regexp.MustCompile("^- `([a-z0-9_]+)`,?\\s*(.*)$")
+	docsLinkRegex    = regexp.MustCompile(`\[([^\]]*)\]\(([^)]*)\)`)
+)
+
+// parseSettingsDocs extracts the documentation for the configuration settings
+// from the "Configuration file" section of docs/full-configuration.md, so the
+// markdown is the only source for the settings documentation.
+// Each nesting level of the settings list is indented using two spaces, the
+// lines that are not settings are appended to the parent setting.
+// The settings inside lists are identified without the index, for example
+// "sftpd.bindings.port"
+func parseSettingsDocs(markdown string) map[string]string {
+	docs := make(map[string]string)
+	// path contains the setting names for the current nesting levels
+	var path []string
+	inSection := false
+	inCodeBlock := false
+
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(line, "## ") {
+			if inSection {
+				break
+			}
+			inSection = strings.TrimSpace(line) == docsSectionHeading
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		if !inSection || trimmed == "" {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "```") {
+			inCodeBlock = !inCodeBlock
+			continue
+		}
+		if inCodeBlock {
+			continue
+		}
+		level := (len(line) - len(strings.TrimLeft(line, " "))) / 2
+		if level == 0 {
+			path = nil
+			if matches := docsSectionRegex.FindStringSubmatch(line); matches != nil {
+				path = append(path, matches[1])
+				docs[matches[1]] = matches[2]
+			}
+			continue
+		}
+		if level > len(path) {
+			continue
+		}
+		path = path[:level]
+		if matches := docsSettingRegex.FindStringSubmatch(trimmed); matches != nil {
+			path = append(path, matches[1])
+			docs[strings.Join(path, ".")] = matches[2]
+			continue
+		}
+		key := strings.Join(path, ".")
+		docs[key] = strings.TrimSpace(docs[key] + " " + strings.TrimPrefix(trimmed, "- "))
+	}
+
+	for key, value := range docs {
+		docs[key] = docsLinkRegex.ReplaceAllStringFunc(value, func(link string) string {
+			matches := docsLinkRegex.FindStringSubmatch(link)
+			return matches[1] + " (" + getDocsLinkURL(matches[2]) + ")"
+		})
+	}
+	return docs
+}
+
+// getDocsLinkURL converts a link relative to the docs directory to an absolute URL
+func getDocsLinkURL(link string) string {
+	// remove the optional title
+	if idx := strings.Index(link, " "); idx > 0 {
+		link = link[:idx]
+	}
+	switch {
+	case strings.HasPrefix(link, "#"):
+		return docsURL + link
+	case strings.HasPrefix(link, "./"):
+		return docsRepoURL + "docs/" + strings.TrimPrefix(link, "./")
+	case strings.HasPrefix(link, "../"):
+		return docsRepoURL + strings.TrimPrefix(link, "../")
+	}
+	return link
+}
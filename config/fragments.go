@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// configFragmentsDirName is the name of the directory, relative to the
+// directory containing the configuration file, with the configuration fragments
+const configFragmentsDirName = "conf.d"
+
+// fragmentsKeys are the keys set from the configuration fragments. They are
+// unset before loading the fragments again, so removed fragments are not applied
+var fragmentsKeys []string
+
+// getConfigFragmentsDir returns the directory containing the configuration
+// fragments. It is "conf.d" inside the directory of the configuration file or
+// inside the configuration directory if no configuration file is used
+func getConfigFragmentsDir(configDir string) string {
+	if configFile := viper.ConfigFileUsed(); configFile != "" {
+		return filepath.Join(filepath.Dir(configFile), configFragmentsDirName)
+	}
+	return filepath.Join(configDir, configFragmentsDirName)
+}
+
+// mergeConfigFragments merges the configuration files inside dir, in lexical
+// order, with the main configuration file. Each fragment overrides the keys
+// defined in the main configuration file and in the previous fragments, the
+// lists are replaced and not appended. The environment variables still have
+// the precedence over the fragments
+func mergeConfigFragments(dir string) {
+	for _, key := range fragmentsKeys {
+		viper.Set(key, nil)
+	}
+	fragmentsKeys = nil
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn(logSender, "", "unable to read the configuration fragments dir %#v: %v", dir, err)
+			logger.WarnToConsole("unable to read the configuration fragments dir %#v: %v", dir, err)
+			addLoadError("", fmt.Sprintf("unable to read the configuration fragments dir %#v: %v", dir, err))
+		}
+		return
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if !utils.IsStringInSlice(strings.TrimPrefix(filepath.Ext(name), "."), viper.SupportedExts) {
+			logger.Debug(logSender, "", "ignoring configuration fragment %#v, unsupported extension", name)
+			continue
+		}
+		fragmentPath := filepath.Join(dir, name)
+		fragment := viper.New()
+		fragment.SetConfigFile(fragmentPath)
+		if err := fragment.ReadInConfig(); err != nil {
+			logger.Warn(logSender, "", "error loading configuration fragment %#v: %v", fragmentPath, err)
+			logger.WarnToConsole("error loading configuration fragment %#v: %v", fragmentPath, err)
+			addLoadError("", fmt.Sprintf("error loading configuration fragment %#v: %v", fragmentPath, err))
+			continue
+		}
+		for _, key := range fragment.AllKeys() {
+			if isConfigKeySetFromEnv(key) {
+				continue
+			}
+			viper.Set(key, fragment.Get(key))
+			fragmentsKeys = append(fragmentsKeys, key)
+		}
+		logger.Debug(logSender, "", "configuration fragment %#v merged", fragmentPath)
+	}
+}
+
+func isConfigKeySetFromEnv(key string) bool {
+	envKey := strings.ToUpper(configEnvPrefix + "_" + strings.ReplaceAll(key, ".", "__"))
+	_, ok := os.LookupEnv(envKey)
+	return ok
+}
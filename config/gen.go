@@ -0,0 +1,112 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/drakkan/sftpgo/docs"
+)
+
+const (
+	docsURL          = "https://github.com/drakkan/sftpgo/blob/main/docs/full-configuration.md"
+	docsCommentWidth = 100
+)
+
+// GenerateDefaultConfig returns the default configuration, in YAML or TOML
+// format, with the documentation for each setting as comments.
+// If section is not empty only the specified top level section is included
+func GenerateDefaultConfig(format, section string) ([]byte, error) {
+	data, err := json.Marshal(getDefaultConfiguration())
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	root, err := decodeJSONNode(dec)
+	if err != nil {
+		return nil, err
+	}
+	if section != "" {
+		root, err = getConfigSectionNode(root, section)
+		if err != nil {
+			return nil, err
+		}
+	}
+	addDocsComments(root, "", parseSettingsDocs(docs.FullConfiguration))
+	doc := &yaml.Node{
+		Kind:        yaml.DocumentNode,
+		HeadComment: getDocsComment("SFTPGo default configuration, the full documentation is available here: " + docsURL),
+		Content:     []*yaml.Node{root},
+	}
+	switch format {
+	case "yaml", "yml":
+		return encodeYAMLNode(doc)
+	case "toml":
+		return encodeTOMLNode(doc)
+	}
+	return nil, fmt.Errorf("unsupported format %#v, supported formats: yaml, toml", format)
+}
+
+func getConfigSectionNode(root *yaml.Node, section string) (*yaml.Node, error) {
+	var sections []string
+	for idx := 0; idx+1 < len(root.Content); idx += 2 {
+		if root.Content[idx].Value == section {
+			return &yaml.Node{
+				Kind:    yaml.MappingNode,
+				Tag:     "!!map",
+				Content: root.Content[idx : idx+2],
+			}, nil
+		}
+		sections = append(sections, root.Content[idx].Value)
+	}
+	return nil, fmt.Errorf("unknown section %#v, available sections: %v", section, strings.Join(sections, ", "))
+}
+
+// addDocsComments adds the settings documentation as head comments.
+// For lists only the first element is documented
+func addDocsComments(node *yaml.Node, path string, settingsDocs map[string]string) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for idx := 0; idx+1 < len(node.Content); idx += 2 {
+			key := node.Content[idx]
+			keyPath := key.Value
+			if path != "" {
+				keyPath = path + "." + key.Value
+			}
+			if doc, ok := settingsDocs[keyPath]; ok {
+				key.HeadComment = getDocsComment(doc)
+			}
+			addDocsComments(node.Content[idx+1], keyPath, settingsDocs)
+		}
+	case yaml.SequenceNode:
+		if len(node.Content) > 0 {
+			addDocsComments(node.Content[0], path, settingsDocs)
+		}
+	}
+}
+
+// getDocsComment wraps the given text and returns it as comment lines
+func getDocsComment(text string) string {
+	var lines []string
+	var line strings.Builder
+	for _, word := range strings.Fields(text) {
+		if line.Len() > 0 && line.Len()+len(word)+1 > docsCommentWidth {
+			lines = append(lines, line.String())
+			line.Reset()
+		}
+		if line.Len() == 0 {
+			line.WriteString("# ")
+		} else {
+			line.WriteString(" ")
+		}
+		line.WriteString(word)
+	}
+	if line.Len() > 0 {
+		lines = append(lines, line.String())
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+
+	"github.com/drakkan/sftpgo/awssecrets"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vault"
+)
+
+const (
+	vaultPlaceholderPrefix        = "vault:"
+	awsSecretPlaceholderPrefix    = "awssm:"
+	awsParameterPlaceholderPrefix = "ssm:"
+)
+
+// placeholderRegex matches the ${ENV_VAR}, ${vault:path#key}, ${awssm:secret-id#key}
+// and ${ssm:name} placeholders and the escaped $${ENV_VAR} ones
+var placeholderRegex = regexp.MustCompile(`\$?\$\{((?:vault|awssm|ssm):[^}]+|[A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandPlaceholders replaces the ${ENV_VAR} placeholders inside value with
+// the referenced environment variables and the secret placeholders with the
+// referenced secrets. Undefined environment variables are replaced with an
+// empty string, while an error is returned for secrets that cannot be read.
+// $${ENV_VAR} can be used to get a literal ${ENV_VAR}
+func expandPlaceholders(value string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+	var err error
+	result := placeholderRegex.ReplaceAllStringFunc(value, func(placeholder string) string {
+		if strings.HasPrefix(placeholder, "$$") {
+			return placeholder[1:]
+		}
+		name := placeholder[2 : len(placeholder)-1]
+		if isSecretPlaceholder(name) {
+			secret, errSecret := getSecret(name)
+			if errSecret != nil && err == nil {
+				err = errSecret
+			}
+			return secret
+		}
+		envValue, ok := os.LookupEnv(name)
+		if !ok {
+			logger.Warn(logSender, "", "environment variable %#v referenced in the configuration is not defined", name)
+			logger.WarnToConsole("environment variable %#v referenced in the configuration is not defined", name)
+			addLoadError("", fmt.Sprintf("environment variable %#v referenced in the configuration is not defined", name))
+		}
+		return envValue
+	})
+	return result, err
+}
+
+func isSecretPlaceholder(name string) bool {
+	return strings.HasPrefix(name, vaultPlaceholderPrefix) || strings.HasPrefix(name, awsSecretPlaceholderPrefix) ||
+		strings.HasPrefix(name, awsParameterPlaceholderPrefix)
+}
+
+func getSecret(name string) (string, error) {
+	switch {
+	case strings.HasPrefix(name, vaultPlaceholderPrefix):
+		secretPath, key, err := vault.ParseReference(strings.TrimPrefix(name, vaultPlaceholderPrefix))
+		if err != nil {
+			return "", err
+		}
+		return vault.GetSecret(secretPath, key)
+	case strings.HasPrefix(name, awsSecretPlaceholderPrefix):
+		secretID, key, err := awssecrets.ParseSecretReference(strings.TrimPrefix(name, awsSecretPlaceholderPrefix))
+		if err != nil {
+			return "", err
+		}
+		return awssecrets.GetSecret(secretID, key)
+	default:
+		return awssecrets.GetParameter(strings.TrimPrefix(name, awsParameterPlaceholderPrefix))
+	}
+}
+
+// expandPlaceholdersHookFunc returns a decode hook expanding the placeholders
+// for all the string values, they are expanded before the conversion to the
+// target type, so they can be used for numbers and booleans too
+func expandPlaceholdersHookFunc() mapstructure.DecodeHookFuncKind {
+	return func(from reflect.Kind, to reflect.Kind, data interface{}) (interface{}, error) {
+		if from != reflect.String {
+			return data, nil
+		}
+		return expandPlaceholders(reflect.ValueOf(data).String())
+	}
+}
+
+// getDecodeHook returns the viper default decode hooks with the placeholders
+// expansion added as first hook
+func getDecodeHook() viper.DecoderConfigOption {
+	return viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		expandPlaceholdersHookFunc(),
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))
+}
+
+// initializeSecretsProviders configures the access to Vault and to the AWS secrets,
+// it must be called before loading the other settings so they can reference the secrets
+func initializeSecretsProviders(configDir string) error {
+	if err := initializeVault(configDir); err != nil {
+		return err
+	}
+	awsConf := getDefaultConfiguration().AWSSecrets
+	if err := viper.UnmarshalKey("aws_secrets", &awsConf, getDecodeHook()); err != nil {
+		return err
+	}
+	return awsConf.Initialize()
+}
+
+func initializeVault(configDir string) error {
+	vaultConf := getDefaultConfiguration().Vault
+	if err := viper.UnmarshalKey("vault", &vaultConf, getDecodeHook()); err != nil {
+		return err
+	}
+	return vaultConf.Initialize(configDir)
+}
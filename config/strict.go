@@ -0,0 +1,117 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// strictMode enables the check for unknown configuration keys
+var strictMode bool
+
+// SetStrictMode enables or disables the strict mode. In strict mode the
+// configuration cannot be loaded if it contains unknown keys, for example
+// misspelled ones, that would be silently ignored otherwise
+func SetStrictMode(strict bool) {
+	strictMode = strict
+}
+
+// getUnknownKeys returns the configuration keys, sorted by name, that do not
+// match any setting. The keys inside lists are reported with their index,
+// for example "sftpd.bindings[0].prot"
+func getUnknownKeys() []string {
+	var unknown []string
+	findUnknownKeys(viper.AllSettings(), reflect.TypeOf(Configuration{}), "", &unknown)
+	sort.Strings(unknown)
+	return unknown
+}
+
+func findUnknownKeys(settings map[string]interface{}, t reflect.Type, prefix string, unknown *[]string) {
+	fields := getSettingFields(t)
+	for key, value := range settings {
+		if value == nil {
+			continue
+		}
+		name := key
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+		fieldType, ok := fields[strings.ToLower(key)]
+		if !ok {
+			*unknown = append(*unknown, name)
+			continue
+		}
+		checkSettingValue(value, fieldType, name, unknown)
+	}
+}
+
+func checkSettingValue(value interface{}, t reflect.Type, name string, unknown *[]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if nested, ok := toSettingsMap(value); ok {
+			findUnknownKeys(nested, t, name, unknown)
+		}
+	case reflect.Slice, reflect.Array:
+		if items, ok := value.([]interface{}); ok {
+			for idx, item := range items {
+				checkSettingValue(item, t.Elem(), fmt.Sprintf("%v[%d]", name, idx), unknown)
+			}
+		}
+	}
+}
+
+// getSettingFields returns the fields of the given struct type keyed by
+// their lowercased setting name
+func getSettingFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		if field.PkgPath != "" {
+			// unexported field
+			continue
+		}
+		tagParts := strings.Split(field.Tag.Get("mapstructure"), ",")
+		if field.Anonymous && len(tagParts) > 1 && tagParts[1] == "squash" {
+			for name, fieldType := range getSettingFields(field.Type) {
+				fields[name] = fieldType
+			}
+			continue
+		}
+		name := tagParts[0]
+		if name == "" {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = field.Type
+	}
+	return fields
+}
+
+func toSettingsMap(value interface{}) (map[string]interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		// YAML and HCL nested maps
+		result := make(map[string]interface{})
+		for key, val := range v {
+			result[fmt.Sprintf("%v", key)] = val
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+// checkUnknownKeys returns an error listing the unknown configuration keys, if any
+func checkUnknownKeys() error {
+	unknown := getUnknownKeys()
+	if len(unknown) == 0 {
+		return nil
+	}
+	return fmt.Errorf("unknown configuration keys: %v", strings.Join(unknown, ", "))
+}
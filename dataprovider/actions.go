@@ -0,0 +1,249 @@
+package dataprovider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/plugin"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// Supported object types for provider actions
+const (
+	ActionObjectUser   = "user"
+	ActionObjectFolder = "folder"
+	ActionObjectAdmin  = "admin"
+)
+
+var validActionObjectTypes = []string{ActionObjectUser, ActionObjectFolder, ActionObjectAdmin}
+
+// ProviderActions defines the action to execute on user, folder, admin create, update, delete.
+type ProviderActions struct {
+	// Valid values are add, update, delete. Empty slice to disable
+	ExecuteOn []string `json:"execute_on" mapstructure:"execute_on"`
+	// Valid values are user, folder, admin. Empty slice means user only
+	ExecuteFor []string `json:"execute_for" mapstructure:"execute_for"`
+	// Absolute path to an external program or an HTTP URL
+	Hook string `json:"hook" mapstructure:"hook"`
+}
+
+type providerActionNotification struct {
+	Action     string          `json:"action"`
+	ObjectType string          `json:"object_type"`
+	ObjectName string          `json:"object_name"`
+	Object     json.RawMessage `json:"object,omitempty"`
+	PrevObject json.RawMessage `json:"previous_object,omitempty"`
+}
+
+func validateProviderActions() error {
+	for _, objectType := range config.Actions.ExecuteFor {
+		if !utils.IsStringInSlice(objectType, validActionObjectTypes) {
+			return fmt.Errorf("invalid provider actions object type %#v", objectType)
+		}
+	}
+	return nil
+}
+
+func isActionEnabled(operation, objectType string) bool {
+	return isHookActionEnabled(operation, objectType) || plugin.Handler.HasNotifiersForProviderEvent(operation, objectType)
+}
+
+func isHookActionEnabled(operation, objectType string) bool {
+	if config.Actions.Hook == "" {
+		return false
+	}
+	if !utils.IsStringInSlice(operation, config.Actions.ExecuteOn) {
+		return false
+	}
+	if len(config.Actions.ExecuteFor) == 0 {
+		return objectType == ActionObjectUser
+	}
+	return utils.IsStringInSlice(objectType, config.Actions.ExecuteFor)
+}
+
+func getActionObjectAsJSON(objectType, objectName string) ([]byte, error) {
+	switch objectType {
+	case ActionObjectUser:
+		user, err := provider.userExists(objectName)
+		if err != nil {
+			return nil, err
+		}
+		user.PrepareForRendering()
+		return json.Marshal(user)
+	case ActionObjectFolder:
+		folder, err := provider.getFolderByName(objectName)
+		if err != nil {
+			return nil, err
+		}
+		folder.PrepareForRendering()
+		return json.Marshal(folder)
+	case ActionObjectAdmin:
+		admin, err := provider.adminExists(objectName)
+		if err != nil {
+			return nil, err
+		}
+		admin.HideConfidentialData()
+		return json.Marshal(admin)
+	default:
+		return nil, fmt.Errorf("unsupported object type %#v", objectType)
+	}
+}
+
+// getActionPreviousObject returns the object, serialized as JSON, as it is before
+// the specified operation. Nothing is returned if no action is configured for the
+// given operation and object type
+func getActionPreviousObject(operation, objectType, objectName string) []byte {
+	if !isActionEnabled(operation, objectType) {
+		return nil
+	}
+	prevObject, err := getActionObjectAsJSON(objectType, objectName)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to get the %v %#v to notify for operation %#v: %v", objectType,
+			objectName, operation, err)
+		return nil
+	}
+	return prevObject
+}
+
+func executeAction(operation, objectType, objectName string, prevObject []byte) {
+	if !isActionEnabled(operation, objectType) {
+		return
+	}
+
+	go func() {
+		notification := providerActionNotification{
+			Action:     operation,
+			ObjectType: objectType,
+			ObjectName: objectName,
+			PrevObject: prevObject,
+		}
+		if operation != operationDelete {
+			object, err := getActionObjectAsJSON(objectType, objectName)
+			if err != nil {
+				providerLog(logger.LevelWarn, "unable to get the %v %#v to notify for operation %#v: %v", objectType,
+					objectName, operation, err)
+				return
+			}
+			notification.Object = object
+		}
+		notifyPlugins(&notification)
+		if !isHookActionEnabled(operation, objectType) {
+			return
+		}
+		if strings.HasPrefix(config.Actions.Hook, "http") {
+			executeNotificationHTTP(&notification)
+		} else {
+			executeNotificationCommand(&notification) //nolint:errcheck // the error is used in test cases only
+		}
+	}()
+}
+
+func notifyPlugins(notification *providerActionNotification) {
+	object := notification.Object
+	if notification.Action == operationDelete {
+		object = notification.PrevObject
+	}
+	plugin.Handler.NotifyProviderEvent(&plugin.ProviderEvent{
+		Timestamp:  utils.GetTimeAsMsSinceEpoch(time.Now()),
+		Action:     notification.Action,
+		ObjectType: notification.ObjectType,
+		ObjectName: notification.ObjectName,
+		Object:     object,
+	})
+}
+
+func executeNotificationHTTP(notification *providerActionNotification) {
+	url, err := url.Parse(config.Actions.Hook)
+	if err != nil {
+		providerLog(logger.LevelWarn, "Invalid http_notification_url %#v for operation %#v: %v", config.Actions.Hook,
+			notification.Action, err)
+		return
+	}
+	body, err := getNotificationHTTPBody(notification)
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to serialize notification as JSON for operation %#v: %v",
+			notification.Action, err)
+		return
+	}
+	q := url.Query()
+	q.Add("action", notification.Action)
+	q.Add("object_type", notification.ObjectType)
+	q.Add("object_name", notification.ObjectName)
+	url.RawQuery = q.Encode()
+	startTime := time.Now()
+	httpClient := httpclient.GetRetryableHTTPClientForHook(httpclient.HookProviderActions)
+	resp, err := httpClient.Post(url.String(), "application/json", bytes.NewBuffer(body))
+	respCode := 0
+	if err == nil {
+		respCode = resp.StatusCode
+		resp.Body.Close()
+	}
+	providerLog(logger.LevelDebug, "notified operation %#v for %v %#v to URL: %v status code: %v, elapsed: %v err: %v",
+		notification.Action, notification.ObjectType, notification.ObjectName, url.Redacted(), respCode,
+		time.Since(startTime), err)
+}
+
+// getNotificationHTTPBody returns the body to send to the HTTP hook. Users are
+// sent as is, as in previous versions, the other object types are wrapped in
+// a providerActionNotification
+func getNotificationHTTPBody(notification *providerActionNotification) ([]byte, error) {
+	if notification.ObjectType != ActionObjectUser {
+		return json.Marshal(notification)
+	}
+	if notification.Action == operationDelete {
+		return notification.PrevObject, nil
+	}
+	return notification.Object, nil
+}
+
+func executeNotificationCommand(notification *providerActionNotification) error {
+	if !filepath.IsAbs(config.Actions.Hook) {
+		err := fmt.Errorf("invalid notification command %#v", config.Actions.Hook)
+		logger.Warn(logSender, "", "unable to execute notification command: %v", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	object := notification.Object
+	if notification.Action == operationDelete {
+		object = notification.PrevObject
+	}
+	commandArgs := []string{notification.Action, notification.ObjectType, notification.ObjectName}
+	env := []string{
+		fmt.Sprintf("SFTPGO_PROVIDER_ACTION=%v", notification.Action),
+		fmt.Sprintf("SFTPGO_PROVIDER_OBJECT_TYPE=%v", notification.ObjectType),
+		fmt.Sprintf("SFTPGO_PROVIDER_OBJECT_NAME=%v", notification.ObjectName),
+		fmt.Sprintf("SFTPGO_PROVIDER_OBJECT=%v", string(object)),
+		fmt.Sprintf("SFTPGO_PROVIDER_PREVIOUS_OBJECT=%v", string(notification.PrevObject)),
+	}
+	if notification.ObjectType == ActionObjectUser {
+		// keep the legacy arguments and environment variables for users
+		var user User
+		if err := json.Unmarshal(object, &user); err == nil {
+			commandArgs = user.getNotificationFieldsAsSlice(notification.Action)
+		}
+		env = append(env, fmt.Sprintf("SFTPGO_USER_ACTION=%v", notification.Action),
+			fmt.Sprintf("SFTPGO_USER=%v", string(object)))
+	}
+
+	cmd := exec.CommandContext(ctx, config.Actions.Hook, commandArgs...)
+	cmd.Env = append(os.Environ(), env...)
+
+	startTime := time.Now()
+	err := cmd.Run()
+	providerLog(logger.LevelDebug, "executed command %#v with arguments: %+v, elapsed: %v, error: %v",
+		config.Actions.Hook, commandArgs, time.Since(startTime), err)
+	return err
+}
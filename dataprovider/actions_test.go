@@ -0,0 +1,95 @@
+package dataprovider
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/httpclient"
+)
+
+func TestProviderActionHTTPBody(t *testing.T) {
+	type actionQuery struct {
+		action     string
+		objectType string
+		objectName string
+	}
+	type receivedRequest struct {
+		query actionQuery
+		body  []byte
+	}
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	err := (&httpclient.Config{Timeout: 10}).Initialize(t.TempDir())
+	require.NoError(t, err)
+
+	requests := make(chan receivedRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err)
+		requests <- receivedRequest{
+			query: actionQuery{
+				action:     r.URL.Query().Get("action"),
+				objectType: r.URL.Query().Get("object_type"),
+				objectName: r.URL.Query().Get("object_name"),
+			},
+			body: body,
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	config.Actions.Hook = server.URL
+	userObject := []byte(`{"username":"user1","status":1}`)
+	prevUserObject := []byte(`{"username":"user1","status":0}`)
+	// users are sent as is, for the delete action the deleted user is sent
+	executeNotificationHTTP(&providerActionNotification{
+		Action:     operationUpdate,
+		ObjectType: ActionObjectUser,
+		ObjectName: "user1",
+		Object:     userObject,
+		PrevObject: prevUserObject,
+	})
+	req := <-requests
+	assert.Equal(t, actionQuery{action: operationUpdate, objectType: ActionObjectUser, objectName: "user1"}, req.query)
+	assert.JSONEq(t, string(userObject), string(req.body))
+	executeNotificationHTTP(&providerActionNotification{
+		Action:     operationDelete,
+		ObjectType: ActionObjectUser,
+		ObjectName: "user1",
+		PrevObject: prevUserObject,
+	})
+	req = <-requests
+	assert.Equal(t, operationDelete, req.query.action)
+	assert.JSONEq(t, string(prevUserObject), string(req.body))
+	var user User
+	err = json.Unmarshal(req.body, &user)
+	assert.NoError(t, err)
+	assert.Equal(t, "user1", user.Username)
+	// the other objects are wrapped
+	folderObject := []byte(`{"name":"folder1","mapped_path":"/tmp/folder1"}`)
+	executeNotificationHTTP(&providerActionNotification{
+		Action:     operationAdd,
+		ObjectType: ActionObjectFolder,
+		ObjectName: "folder1",
+		Object:     folderObject,
+	})
+	req = <-requests
+	assert.Equal(t, actionQuery{action: operationAdd, objectType: ActionObjectFolder, objectName: "folder1"}, req.query)
+	var notification providerActionNotification
+	err = json.Unmarshal(req.body, &notification)
+	require.NoError(t, err)
+	assert.Equal(t, operationAdd, notification.Action)
+	assert.Equal(t, ActionObjectFolder, notification.ObjectType)
+	assert.Equal(t, "folder1", notification.ObjectName)
+	assert.JSONEq(t, string(folderObject), string(notification.Object))
+	assert.Len(t, notification.PrevObject, 0)
+}
@@ -30,6 +30,7 @@ const (
 	PermAdminManageSystem     = "manage_system"
 	PermAdminManageDefender   = "manage_defender"
 	PermAdminViewDefender     = "view_defender"
+	PermAdminImpersonateUsers = "impersonate_users"
 )
 
 var (
@@ -37,7 +38,7 @@ var (
 	validAdminPerms = []string{PermAdminAny, PermAdminAddUsers, PermAdminChangeUsers, PermAdminDeleteUsers,
 		PermAdminViewUsers, PermAdminViewConnections, PermAdminCloseConnections, PermAdminViewServerStatus,
 		PermAdminManageAdmins, PermAdminQuotaScans, PermAdminManageSystem, PermAdminManageDefender,
-		PermAdminViewDefender}
+		PermAdminViewDefender, PermAdminImpersonateUsers}
 )
 
 // AdminFilters defines additional restrictions for SFTPGo admins
@@ -62,6 +63,8 @@ type Admin struct {
 	Filters        AdminFilters `json:"filters,omitempty"`
 	Description    string       `json:"description,omitempty"`
 	AdditionalInfo string       `json:"additional_info,omitempty"`
+	// Incremented on each update. Updates must provide the version they are based on
+	Version int64 `json:"version"`
 }
 
 func (a *Admin) checkPassword() error {
@@ -240,6 +243,7 @@ func (a *Admin) getACopy() Admin {
 		Filters:        filters,
 		AdditionalInfo: a.AdditionalInfo,
 		Description:    a.Description,
+		Version:        a.Version,
 	}
 }
 
@@ -0,0 +1,130 @@
+package dataprovider
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// Supported operations for the approval requests
+const (
+	ApprovalOperationDeleteUser    = "delete_user"
+	ApprovalOperationDeleteFolder  = "delete_folder"
+	ApprovalOperationRestoreBackup = "restore_backup"
+)
+
+// Approval request statuses
+const (
+	// the request is waiting for a second admin
+	ApprovalStatusPending = "pending"
+	// the request was approved and the operation is in progress
+	ApprovalStatusApproved = "approved"
+	// the operation was executed successfully
+	ApprovalStatusExecuted = "executed"
+	// the operation was executed with errors
+	ApprovalStatusFailed   = "failed"
+	ApprovalStatusRejected = "rejected"
+)
+
+var validApprovalOperations = []string{ApprovalOperationDeleteUser, ApprovalOperationDeleteFolder,
+	ApprovalOperationRestoreBackup}
+
+// ApprovalRequest defines a destructive admin operation waiting for the
+// approval of a second admin. The resolved requests are kept as audit trail
+type ApprovalRequest struct {
+	// unique identifier
+	ID string `json:"id"`
+	// the requested operation
+	Operation string `json:"operation"`
+	// the user or folder name for the delete operations
+	ObjectName string `json:"object_name,omitempty"`
+	// operation specific data, for example the backup to restore.
+	// It is removed when the request is resolved
+	Payload string `json:"payload,omitempty"`
+	Status  string `json:"status"`
+	// admin that requested the operation
+	RequestedBy string `json:"requested_by"`
+	// request time as unix timestamp in milliseconds
+	RequestedAt int64 `json:"requested_at"`
+	// admin that approved or rejected the operation
+	ResolvedBy string `json:"resolved_by,omitempty"`
+	// approval or rejection time as unix timestamp in milliseconds
+	ResolvedAt int64 `json:"resolved_at,omitempty"`
+	// execution error, if any
+	Error string `json:"error,omitempty"`
+}
+
+// IsPending returns true if the request is waiting for approval
+func (r *ApprovalRequest) IsPending() bool {
+	return r.Status == ApprovalStatusPending
+}
+
+// GetRequestedAtAsString returns the request time as string
+func (r *ApprovalRequest) GetRequestedAtAsString() string {
+	return utils.GetTimeFromMsecSinceEpoch(r.RequestedAt).Format("2006-01-02 15:04") // YYYY-MM-DD HH:MM
+}
+
+// GetResolvedAtAsString returns the resolution time as string
+func (r *ApprovalRequest) GetResolvedAtAsString() string {
+	if r.ResolvedAt == 0 {
+		return ""
+	}
+	return utils.GetTimeFromMsecSinceEpoch(r.ResolvedAt).Format("2006-01-02 15:04") // YYYY-MM-DD HH:MM
+}
+
+func (r *ApprovalRequest) validate() error {
+	if !utils.IsStringInSlice(r.Operation, validApprovalOperations) {
+		return NewValidationError(fmt.Sprintf("invalid operation %#v", r.Operation))
+	}
+	if r.Operation != ApprovalOperationRestoreBackup && r.ObjectName == "" {
+		return NewValidationError("the object name is mandatory")
+	}
+	if r.RequestedBy == "" {
+		return NewValidationError("the requesting admin is mandatory")
+	}
+	return nil
+}
+
+// AddApprovalRequest adds a new pending approval request. The id and the
+// request time are generated
+func AddApprovalRequest(req *ApprovalRequest) error {
+	req.ID = xid.New().String()
+	req.Status = ApprovalStatusPending
+	req.RequestedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	req.ResolvedBy = ""
+	req.ResolvedAt = 0
+	req.Error = ""
+	if err := req.validate(); err != nil {
+		return err
+	}
+	return provider.addApprovalRequest(req)
+}
+
+// ApprovalRequestExists returns the approval request with the given id if it exists
+func ApprovalRequestExists(id string) (ApprovalRequest, error) {
+	if id == "" {
+		return ApprovalRequest{}, &RecordNotFoundError{err: "approval request with an empty id does not exist"}
+	}
+	return provider.approvalRequestExists(id)
+}
+
+// GetApprovalRequests returns an array of approval requests, most recent
+// first, respecting limit and offset
+func GetApprovalRequests(limit, offset int) ([]ApprovalRequest, error) {
+	return provider.getApprovalRequests(limit, offset)
+}
+
+// UpdateApprovalRequestStatus saves the status, the resolving admin and the
+// error for the given request. The update is applied only if the stored
+// request has the fromStatus status, so a request cannot be resolved twice.
+// The payload is removed for the final statuses
+func UpdateApprovalRequestStatus(req *ApprovalRequest, fromStatus string) error {
+	if req.Status != ApprovalStatusApproved {
+		req.Payload = ""
+	}
+	req.ResolvedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	return provider.updateApprovalRequestStatus(req, fromStatus)
+}
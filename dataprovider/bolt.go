@@ -3,11 +3,14 @@
 package dataprovider
 
 import (
+	"context"
 	"crypto/x509"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"path/filepath"
+	"sort"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
@@ -23,11 +26,17 @@ const (
 )
 
 var (
-	usersBucket     = []byte("users")
-	foldersBucket   = []byte("folders")
-	adminsBucket    = []byte("admins")
-	dbVersionBucket = []byte("db_version")
-	dbVersionKey    = []byte("version")
+	usersBucket         = []byte("users")
+	foldersBucket       = []byte("folders")
+	adminsBucket        = []byte("admins")
+	dbVersionBucket     = []byte("db_version")
+	defenderBucket      = []byte("defender")
+	defenderListsBucket = []byte("defender_lists")
+	eventsQueueBucket   = []byte("events_queue")
+	sharedConnsBucket   = []byte("shared_connections")
+	dropLinksBucket     = []byte("drop_links")
+	approvalsBucket     = []byte("approval_requests")
+	dbVersionKey        = []byte("version")
 )
 
 // BoltProvider auth provider for bolt key/value store
@@ -83,6 +92,54 @@ func initializeBoltProvider(basePath string) error {
 			providerLog(logger.LevelWarn, "error creating admins bucket: %v", err)
 			return err
 		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(defenderBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating defender bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(defenderListsBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating defender lists bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(eventsQueueBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating events queue bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(sharedConnsBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating shared connections bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(dropLinksBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating drop links bucket: %v", err)
+			return err
+		}
+		err = dbHandle.Update(func(tx *bolt.Tx) error {
+			_, e := tx.CreateBucketIfNotExists(approvalsBucket)
+			return e
+		})
+		if err != nil {
+			providerLog(logger.LevelWarn, "error creating approval requests bucket: %v", err)
+			return err
+		}
 		err = dbHandle.Update(func(tx *bolt.Tx) error {
 			_, e := tx.CreateBucketIfNotExists(dbVersionBucket)
 			return e
@@ -116,7 +173,7 @@ func (p *BoltProvider) validateUserAndTLSCert(username, protocol string, tlsCert
 	return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 }
 
-func (p *BoltProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
+func (p *BoltProvider) validateUserAndPass(ctx context.Context, username, password, ip, protocol string) (User, error) {
 	var user User
 	if password == "" {
 		return user, errors.New("credentials cannot be null or empty")
@@ -126,7 +183,7 @@ func (p *BoltProvider) validateUserAndPass(username, password, ip, protocol stri
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, err
 	}
-	return checkUserAndPass(&user, password, ip, protocol)
+	return checkUserAndPass(ctx, &user, password, ip, protocol)
 }
 
 func (p *BoltProvider) validateAdminAndPass(username, password, ip string) (Admin, error) {
@@ -261,6 +318,7 @@ func (p *BoltProvider) addAdmin(admin *Admin) error {
 			return err
 		}
 		admin.ID = int64(id)
+		admin.Version = 0
 		buf, err := json.Marshal(admin)
 		if err != nil {
 			return err
@@ -289,8 +347,12 @@ func (p *BoltProvider) updateAdmin(admin *Admin) error {
 		if err != nil {
 			return err
 		}
+		if oldAdmin.Version != admin.Version {
+			return ErrVersionMismatch
+		}
 
 		admin.ID = oldAdmin.ID
+		admin.Version = oldAdmin.Version + 1
 		buf, err := json.Marshal(admin)
 		if err != nil {
 			return err
@@ -435,6 +497,7 @@ func (p *BoltProvider) addUser(user *User) error {
 		user.UsedQuotaSize = 0
 		user.UsedQuotaFiles = 0
 		user.LastLogin = 0
+		user.Version = 0
 		for idx := range user.VirtualFolders {
 			err = addUserToFolderMapping(&user.VirtualFolders[idx].BaseVirtualFolder, user, folderBucket)
 			if err != nil {
@@ -472,6 +535,9 @@ func (p *BoltProvider) updateUser(user *User) error {
 		if err != nil {
 			return err
 		}
+		if oldUser.Version != user.Version {
+			return ErrVersionMismatch
+		}
 		for idx := range oldUser.VirtualFolders {
 			err = removeUserFromFolderMapping(&oldUser.VirtualFolders[idx], &oldUser, folderBucket)
 			if err != nil {
@@ -489,6 +555,7 @@ func (p *BoltProvider) updateUser(user *User) error {
 		user.UsedQuotaSize = oldUser.UsedQuotaSize
 		user.UsedQuotaFiles = oldUser.UsedQuotaFiles
 		user.LastLogin = oldUser.LastLogin
+		user.Version = oldUser.Version + 1
 		buf, err := json.Marshal(user)
 		if err != nil {
 			return err
@@ -519,6 +586,9 @@ func (p *BoltProvider) deleteUser(user *User) error {
 		if exists == nil {
 			return &RecordNotFoundError{err: fmt.Sprintf("user %#v does not exist", user.Username)}
 		}
+		if err := removeUserDropLinks(user.Username, tx); err != nil {
+			return err
+		}
 		return bucket.Delete([]byte(user.Username))
 	})
 }
@@ -705,6 +775,7 @@ func (p *BoltProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
 			return fmt.Errorf("folder %v already exists", folder.Name)
 		}
 		folder.Users = nil
+		folder.Version = 0
 		return addFolderInternal(*folder, bucket)
 	})
 }
@@ -729,8 +800,12 @@ func (p *BoltProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
 		if err != nil {
 			return err
 		}
+		if oldFolder.Version != folder.Version {
+			return ErrVersionMismatch
+		}
 
 		folder.ID = oldFolder.ID
+		folder.Version = oldFolder.Version + 1
 		folder.LastQuotaUpdate = oldFolder.LastQuotaUpdate
 		folder.UsedQuotaFiles = oldFolder.UsedQuotaFiles
 		folder.UsedQuotaSize = oldFolder.UsedQuotaSize
@@ -941,6 +1016,7 @@ func addUserToFolderMapping(baseFolder *vfs.BaseVirtualFolder, user *User, bucke
 		baseFolder.UsedQuotaFiles = 0
 		baseFolder.UsedQuotaSize = 0
 		baseFolder.Users = []string{user.Username}
+		baseFolder.Version = 0
 		return addFolderInternal(*baseFolder, bucket)
 	}
 	var oldFolder vfs.BaseVirtualFolder
@@ -953,6 +1029,8 @@ func addUserToFolderMapping(baseFolder *vfs.BaseVirtualFolder, user *User, bucke
 	baseFolder.UsedQuotaFiles = oldFolder.UsedQuotaFiles
 	baseFolder.UsedQuotaSize = oldFolder.UsedQuotaSize
 	baseFolder.Users = oldFolder.Users
+	// the folder definition embedded in the user is applied as is
+	baseFolder.Version = oldFolder.Version + 1
 	if !utils.IsStringInSlice(user.Username, baseFolder.Users) {
 		baseFolder.Users = append(baseFolder.Users, user.Username)
 	}
@@ -991,6 +1069,737 @@ func removeUserFromFolderMapping(folder *vfs.VirtualFolder, user *User, bucket *
 	return err
 }
 
+func (p *BoltProvider) getDefenderHostByIP(ip string, from int64) (DefenderEntry, error) {
+	var entry DefenderEntry
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getDefenderBucket(tx)
+		if err != nil {
+			return err
+		}
+		host, err := getBoltDefenderHost(bucket, ip)
+		if err != nil {
+			return err
+		}
+		entry = host.getEntry(from)
+		return nil
+	})
+	return entry, err
+}
+
+func (p *BoltProvider) isDefenderHostBanned(ip string) (DefenderEntry, error) {
+	var entry DefenderEntry
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getDefenderBucket(tx)
+		if err != nil {
+			return err
+		}
+		host, err := getBoltDefenderHost(bucket, ip)
+		if err != nil {
+			return err
+		}
+		if !host.isBanned() {
+			return &RecordNotFoundError{err: fmt.Sprintf("defender host %#v is not banned", ip)}
+		}
+		entry = DefenderEntry{IP: host.IP, BanTime: host.BanTime}
+		return nil
+	})
+	return entry, err
+}
+
+func (p *BoltProvider) updateDefenderBanTime(ip string, minutes int) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDefenderBucket(tx)
+		if err != nil {
+			return err
+		}
+		host, err := getBoltDefenderHost(bucket, ip)
+		if err != nil {
+			return err
+		}
+		host.BanTime += int64(minutes) * 60 * 1000
+		host.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+		return putBoltDefenderHost(bucket, &host)
+	})
+}
+
+func (p *BoltProvider) deleteDefenderHost(ip string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDefenderBucket(tx)
+		if err != nil {
+			return err
+		}
+		if bucket.Get([]byte(ip)) == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("defender host %#v does not exist", ip)}
+		}
+		return bucket.Delete([]byte(ip))
+	})
+}
+
+func (p *BoltProvider) addDefenderEvent(ip string, score int) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDefenderBucket(tx)
+		if err != nil {
+			return err
+		}
+		host, err := getBoltDefenderHost(bucket, ip)
+		if err != nil {
+			if _, ok := err.(*RecordNotFoundError); !ok {
+				return err
+			}
+			host = defenderHost{IP: ip}
+		}
+		host.addEvent(score)
+		return putBoltDefenderHost(bucket, &host)
+	})
+}
+
+func (p *BoltProvider) setDefenderBanTime(ip string, banTime int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDefenderBucket(tx)
+		if err != nil {
+			return err
+		}
+		host, err := getBoltDefenderHost(bucket, ip)
+		if err != nil {
+			if _, ok := err.(*RecordNotFoundError); !ok {
+				return err
+			}
+			host = defenderHost{IP: ip}
+		}
+		host.BanTime = banTime
+		host.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+		host.Events = nil
+		return putBoltDefenderHost(bucket, &host)
+	})
+}
+
+func (p *BoltProvider) cleanupDefender(from int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDefenderBucket(tx)
+		if err != nil {
+			return err
+		}
+		var hosts []defenderHost
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var host defenderHost
+			if err := json.Unmarshal(v, &host); err != nil {
+				return err
+			}
+			hosts = append(hosts, host)
+		}
+		// the bucket cannot be modified while iterating over it
+		for idx := range hosts {
+			host := &hosts[idx]
+			numEvents := len(host.Events)
+			host.removeEventsBefore(from)
+			if len(host.Events) == 0 && !host.isBanned() {
+				if err := bucket.Delete([]byte(host.IP)); err != nil {
+					return err
+				}
+				continue
+			}
+			if len(host.Events) != numEvents {
+				if err := putBoltDefenderHost(bucket, host); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) getDefenderListEntries() ([]DefenderListEntry, error) {
+	var entries []DefenderListEntry
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getDefenderListsBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var entry DefenderListEntry
+			if err := json.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+func (p *BoltProvider) addDefenderListEntry(entry *DefenderListEntry) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDefenderListsBucket(tx)
+		if err != nil {
+			return err
+		}
+		key := []byte(entry.getKey())
+		if bucket.Get(key) != nil {
+			return fmt.Errorf("defender list entry %#v already exists", entry.Network)
+		}
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, buf)
+	})
+}
+
+func (p *BoltProvider) deleteDefenderListEntry(entry *DefenderListEntry) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDefenderListsBucket(tx)
+		if err != nil {
+			return err
+		}
+		key := []byte(entry.getKey())
+		if bucket.Get(key) == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("defender list entry %#v does not exist", entry.Network)}
+		}
+		return bucket.Delete(key)
+	})
+}
+
+func (p *BoltProvider) addQueuedEvent(payload string, createdAt int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getEventsQueueBucket(tx)
+		if err != nil {
+			return err
+		}
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		buf, err := json.Marshal(QueuedEvent{
+			ID:        int64(id),
+			Payload:   payload,
+			CreatedAt: createdAt,
+		})
+		if err != nil {
+			return err
+		}
+		return bucket.Put(getBoltQueuedEventKey(int64(id)), buf)
+	})
+}
+
+func (p *BoltProvider) getQueuedEvents(limit int) ([]QueuedEvent, error) {
+	events := make([]QueuedEvent, 0, limit)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getEventsQueueBucket(tx)
+		if err != nil {
+			return err
+		}
+		// the keys are big endian encoded ids so the cursor returns the oldest events first
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil && len(events) < limit; k, v = cursor.Next() {
+			var event QueuedEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (p *BoltProvider) claimQueuedEvents(owner string, limit int, now, leaseExpires int64) ([]QueuedEvent, error) {
+	events := make([]QueuedEvent, 0, limit)
+	err := p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getEventsQueueBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil && len(events) < limit; k, v = cursor.Next() {
+			var event QueuedEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if event.LeaseExpires >= now {
+				continue
+			}
+			event.ClaimedBy = owner
+			event.LeaseExpires = leaseExpires
+			buf, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(k, buf); err != nil {
+				return err
+			}
+			events = append(events, event)
+		}
+		return nil
+	})
+	return events, err
+}
+
+func (p *BoltProvider) renewQueuedEventsLease(owner string, leaseExpires int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getEventsQueueBucket(tx)
+		if err != nil {
+			return err
+		}
+		cursor := bucket.Cursor()
+		for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+			var event QueuedEvent
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if event.ClaimedBy != owner {
+				continue
+			}
+			event.LeaseExpires = leaseExpires
+			buf, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put(k, buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) deleteQueuedEvent(id int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getEventsQueueBucket(tx)
+		if err != nil {
+			return err
+		}
+		key := getBoltQueuedEventKey(id)
+		if bucket.Get(key) == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("queued event %v does not exist", id)}
+		}
+		return bucket.Delete(key)
+	})
+}
+
+func (p *BoltProvider) addSharedConnection(conn *SharedConnection) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getSharedConnsBucket(tx)
+		if err != nil {
+			return err
+		}
+		c := *conn
+		c.CloseRequested = false
+		key := []byte(c.getKey())
+		if v := bucket.Get(key); v != nil {
+			var existing SharedConnection
+			if err := json.Unmarshal(v, &existing); err != nil {
+				return err
+			}
+			c.CloseRequested = existing.CloseRequested
+		}
+		buf, err := json.Marshal(c)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, buf)
+	})
+}
+
+func (p *BoltProvider) deleteSharedConnection(nodeID, connectionID string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getSharedConnsBucket(tx)
+		if err != nil {
+			return err
+		}
+		key := []byte((&SharedConnection{NodeID: nodeID, ConnectionID: connectionID}).getKey())
+		if bucket.Get(key) == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("shared connection %#v for node %#v does not exist", connectionID, nodeID)}
+		}
+		return bucket.Delete(key)
+	})
+}
+
+func (p *BoltProvider) getSharedConnections(from int64) ([]SharedConnection, error) {
+	conns := make([]SharedConnection, 0, 10)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getSharedConnsBucket(tx)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var conn SharedConnection
+			if err := json.Unmarshal(v, &conn); err != nil {
+				return err
+			}
+			if conn.UpdatedAt > from {
+				conns = append(conns, conn)
+			}
+			return nil
+		})
+	})
+	return conns, err
+}
+
+func (p *BoltProvider) requestSharedConnectionClose(nodeID, connectionID string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getSharedConnsBucket(tx)
+		if err != nil {
+			return err
+		}
+		key := []byte((&SharedConnection{NodeID: nodeID, ConnectionID: connectionID}).getKey())
+		v := bucket.Get(key)
+		if v == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("shared connection %#v for node %#v does not exist", connectionID, nodeID)}
+		}
+		var conn SharedConnection
+		if err := json.Unmarshal(v, &conn); err != nil {
+			return err
+		}
+		conn.CloseRequested = true
+		buf, err := json.Marshal(conn)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, buf)
+	})
+}
+
+func (p *BoltProvider) cleanupSharedConnections(from int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getSharedConnsBucket(tx)
+		if err != nil {
+			return err
+		}
+		var toRemove [][]byte
+		err = bucket.ForEach(func(k, v []byte) error {
+			var conn SharedConnection
+			if err := json.Unmarshal(v, &conn); err != nil {
+				return err
+			}
+			if conn.UpdatedAt <= from {
+				// the key is only valid for the life of the transaction
+				toRemove = append(toRemove, append([]byte(nil), k...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range toRemove {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *BoltProvider) addDropLink(link *DropLink) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDropLinksBucket(tx)
+		if err != nil {
+			return err
+		}
+		usersBucket, err := getUsersBucket(tx)
+		if err != nil {
+			return err
+		}
+		if usersBucket.Get([]byte(link.Username)) == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("user %#v does not exist", link.Username)}
+		}
+		if bucket.Get([]byte(link.LinkID)) != nil {
+			return fmt.Errorf("drop link %#v already exists", link.LinkID)
+		}
+		buf, err := json.Marshal(link)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(link.LinkID), buf)
+	})
+}
+
+func (p *BoltProvider) dropLinkExists(linkID string) (DropLink, error) {
+	var link DropLink
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getDropLinksBucket(tx)
+		if err != nil {
+			return err
+		}
+		v := bucket.Get([]byte(linkID))
+		if v == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("drop link %#v does not exist", linkID)}
+		}
+		return json.Unmarshal(v, &link)
+	})
+	return link, err
+}
+
+func (p *BoltProvider) getDropLinks(username string) ([]DropLink, error) {
+	links := make([]DropLink, 0, 10)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getDropLinksBucket(tx)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var link DropLink
+			if err := json.Unmarshal(v, &link); err != nil {
+				return err
+			}
+			if link.Username == username {
+				links = append(links, link)
+			}
+			return nil
+		})
+	})
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt < links[j].CreatedAt
+	})
+	return links, err
+}
+
+func (p *BoltProvider) deleteDropLink(linkID, username string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDropLinksBucket(tx)
+		if err != nil {
+			return err
+		}
+		v := bucket.Get([]byte(linkID))
+		if v == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("drop link %#v for user %#v does not exist", linkID, username)}
+		}
+		var link DropLink
+		if err := json.Unmarshal(v, &link); err != nil {
+			return err
+		}
+		if link.Username != username {
+			return &RecordNotFoundError{err: fmt.Sprintf("drop link %#v for user %#v does not exist", linkID, username)}
+		}
+		return bucket.Delete([]byte(linkID))
+	})
+}
+
+func (p *BoltProvider) updateDropLinkUsage(linkID string, filesAdd int, sizeAdd int64) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getDropLinksBucket(tx)
+		if err != nil {
+			return err
+		}
+		v := bucket.Get([]byte(linkID))
+		if v == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("drop link %#v does not exist", linkID)}
+		}
+		var link DropLink
+		if err := json.Unmarshal(v, &link); err != nil {
+			return err
+		}
+		link.UploadedFiles += filesAdd
+		link.UploadedSize += sizeAdd
+		link.LastUseAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+		buf, err := json.Marshal(link)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(linkID), buf)
+	})
+}
+
+func (p *BoltProvider) addApprovalRequest(req *ApprovalRequest) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getApprovalsBucket(tx)
+		if err != nil {
+			return err
+		}
+		if bucket.Get([]byte(req.ID)) != nil {
+			return fmt.Errorf("approval request %#v already exists", req.ID)
+		}
+		buf, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(req.ID), buf)
+	})
+}
+
+func (p *BoltProvider) approvalRequestExists(id string) (ApprovalRequest, error) {
+	var req ApprovalRequest
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getApprovalsBucket(tx)
+		if err != nil {
+			return err
+		}
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("approval request %#v does not exist", id)}
+		}
+		return json.Unmarshal(v, &req)
+	})
+	return req, err
+}
+
+func (p *BoltProvider) getApprovalRequests(limit, offset int) ([]ApprovalRequest, error) {
+	requests := make([]ApprovalRequest, 0, limit)
+	err := p.dbHandle.View(func(tx *bolt.Tx) error {
+		bucket, err := getApprovalsBucket(tx)
+		if err != nil {
+			return err
+		}
+		itNum := 0
+		cursor := bucket.Cursor()
+		// the ids are sortable by creation time, the most recent requests are the last ones
+		for k, v := cursor.Last(); k != nil; k, v = cursor.Prev() {
+			itNum++
+			if itNum <= offset {
+				continue
+			}
+			var req ApprovalRequest
+			if err := json.Unmarshal(v, &req); err != nil {
+				return err
+			}
+			req.Payload = ""
+			requests = append(requests, req)
+			if len(requests) >= limit {
+				break
+			}
+		}
+		return nil
+	})
+	return requests, err
+}
+
+func (p *BoltProvider) updateApprovalRequestStatus(req *ApprovalRequest, fromStatus string) error {
+	return p.dbHandle.Update(func(tx *bolt.Tx) error {
+		bucket, err := getApprovalsBucket(tx)
+		if err != nil {
+			return err
+		}
+		v := bucket.Get([]byte(req.ID))
+		if v == nil {
+			return &RecordNotFoundError{err: fmt.Sprintf("approval request %#v does not exist", req.ID)}
+		}
+		var stored ApprovalRequest
+		if err := json.Unmarshal(v, &stored); err != nil {
+			return err
+		}
+		if stored.Status != fromStatus {
+			return &RecordNotFoundError{err: fmt.Sprintf("approval request %#v with status %#v does not exist", req.ID, fromStatus)}
+		}
+		stored.Status = req.Status
+		stored.Payload = req.Payload
+		stored.ResolvedBy = req.ResolvedBy
+		stored.ResolvedAt = req.ResolvedAt
+		stored.Error = req.Error
+		buf, err := json.Marshal(stored)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(req.ID), buf)
+	})
+}
+
+func removeUserDropLinks(username string, tx *bolt.Tx) error {
+	bucket, err := getDropLinksBucket(tx)
+	if err != nil {
+		return err
+	}
+	var toRemove [][]byte
+	err = bucket.ForEach(func(k, v []byte) error {
+		var link DropLink
+		if err := json.Unmarshal(v, &link); err != nil {
+			return err
+		}
+		if link.Username == username {
+			// the key is only valid for the life of the transaction
+			toRemove = append(toRemove, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range toRemove {
+		if err := bucket.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getBoltQueuedEventKey(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+func getBoltDefenderHost(bucket *bolt.Bucket, ip string) (defenderHost, error) {
+	var host defenderHost
+	h := bucket.Get([]byte(ip))
+	if h == nil {
+		return host, &RecordNotFoundError{err: fmt.Sprintf("defender host %#v does not exist", ip)}
+	}
+	err := json.Unmarshal(h, &host)
+	return host, err
+}
+
+func putBoltDefenderHost(bucket *bolt.Bucket, host *defenderHost) error {
+	buf, err := json.Marshal(host)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(host.IP), buf)
+}
+
+func getDefenderBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(defenderBucket)
+	if bucket == nil {
+		err = errors.New("unable to find defender bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getDefenderListsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(defenderListsBucket)
+	if bucket == nil {
+		err = errors.New("unable to find defender lists bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getEventsQueueBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(eventsQueueBucket)
+	if bucket == nil {
+		err = errors.New("unable to find events queue bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getSharedConnsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(sharedConnsBucket)
+	if bucket == nil {
+		err = errors.New("unable to find shared connections bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getDropLinksBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(dropLinksBucket)
+	if bucket == nil {
+		err = errors.New("unable to find drop links bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
+func getApprovalsBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
+	var err error
+	bucket := tx.Bucket(approvalsBucket)
+	if bucket == nil {
+		err = errors.New("unable to find approval requests bucket, bolt database structure not correcly defined")
+	}
+	return bucket, err
+}
+
 func getAdminBucket(tx *bolt.Tx) (*bolt.Bucket, error) {
 	var err error
 
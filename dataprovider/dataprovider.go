@@ -46,6 +46,8 @@ import (
 	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/plugin"
+	"github.com/drakkan/sftpgo/tracing"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
 )
@@ -112,16 +114,21 @@ var (
 	// ErrNoAuthTryed defines the error for connection closed before authentication
 	ErrNoAuthTryed = errors.New("no auth tryed")
 	// ValidProtocols defines all the valid protcols
-	ValidProtocols = []string{"SSH", "FTP", "DAV", "HTTP"}
+	ValidProtocols = []string{"SSH", "FTP", "DAV", "HTTP", "RSYNC"}
 	// ErrNoInitRequired defines the error returned by InitProvider if no inizialization/update is required
 	ErrNoInitRequired = errors.New("the data provider is up to date")
 	// ErrInvalidCredentials defines the error to return if the supplied credentials are invalid
 	ErrInvalidCredentials = errors.New("invalid credentials")
-	validTLSUsernames     = []string{string(TLSUsernameNone), string(TLSUsernameCN)}
-	config                Config
-	provider              Provider
-	sqlPlaceholders       []string
-	hashPwdPrefixes       = []string{argonPwdPrefix, bcryptPwdPrefix, pbkdf2SHA1Prefix, pbkdf2SHA256Prefix,
+	// ErrProviderNotInitialized defines the error returned if the data provider is not yet initialized
+	ErrProviderNotInitialized = errors.New("the data provider is not initialized")
+	// ErrVersionMismatch defines the error returned if an object was modified after it was read.
+	// The update must be retried with the current version
+	ErrVersionMismatch = errors.New("the object was modified concurrently, please reload it and retry")
+	validTLSUsernames  = []string{string(TLSUsernameNone), string(TLSUsernameCN)}
+	config             Config
+	provider           Provider
+	sqlPlaceholders    []string
+	hashPwdPrefixes    = []string{argonPwdPrefix, bcryptPwdPrefix, pbkdf2SHA1Prefix, pbkdf2SHA256Prefix,
 		pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix, md5cryptPwdPrefix, md5cryptApr1PwdPrefix, sha512cryptPwdPrefix}
 	pbkdfPwdPrefixes        = []string{pbkdf2SHA1Prefix, pbkdf2SHA256Prefix, pbkdf2SHA512Prefix, pbkdf2SHA256B64SaltPrefix}
 	pbkdfPwdB64SaltPrefixes = []string{pbkdf2SHA256B64SaltPrefix}
@@ -135,9 +142,17 @@ var (
 	sqlTableFoldersMapping  = "folders_mapping"
 	sqlTableAdmins          = "admins"
 	sqlTableSchemaVersion   = "schema_version"
+	sqlTableDefenderHosts   = "defender_hosts"
+	sqlTableDefenderEvents  = "defender_events"
+	sqlTableDefenderLists   = "defender_lists"
+	sqlTableEventsQueue     = "events_queue"
+	sqlTableSharedConns     = "shared_connections"
+	sqlTableDropLinks       = "drop_links"
+	sqlTableApprovals       = "approval_requests"
 	argon2Params            *argon2id.Params
 	lastLoginMinDelay       = 10 * time.Minute
 	usernameRegex           = regexp.MustCompile("^[a-zA-Z0-9-_.~]+$")
+	metadataKeyRegex        = regexp.MustCompile("^[a-zA-Z0-9-_.]+$")
 )
 
 type schemaVersion struct {
@@ -164,14 +179,6 @@ type PasswordHashing struct {
 	Algo string `json:"algo" mapstructure:"algo"`
 }
 
-// UserActions defines the action to execute on user create, update, delete.
-type UserActions struct {
-	// Valid values are add, update, delete. Empty slice to disable
-	ExecuteOn []string `json:"execute_on" mapstructure:"execute_on"`
-	// Absolute path to an external program or an HTTP URL
-	Hook string `json:"hook" mapstructure:"hook"`
-}
-
 // ProviderStatus defines the provider status
 type ProviderStatus struct {
 	Driver   string `json:"driver"`
@@ -200,6 +207,19 @@ type Config struct {
 	// 2 set ssl mode to verify-ca for driver postgresql and skip-verify for driver mysql.
 	// 3 set ssl mode to verify-full for driver postgresql and preferred for driver mysql.
 	SSLMode int `json:"sslmode" mapstructure:"sslmode"`
+	// Path to the root certificate authority used to verify that the server certificate was signed by a
+	// trusted CA. Used for drivers mysql and postgresql. It can be a path relative to the config dir
+	// or an absolute one
+	RootCert string `json:"root_cert" mapstructure:"root_cert"`
+	// Path to the client certificate for two-way TLS authentication.
+	// Used for drivers mysql and postgresql
+	ClientCert string `json:"client_cert" mapstructure:"client_cert"`
+	// Path to the client key for two-way TLS authentication.
+	// Used for drivers mysql and postgresql
+	ClientKey string `json:"client_key" mapstructure:"client_key"`
+	// TLSServerName overrides the server name used to verify the server certificate.
+	// Used for driver mysql only, for driver postgresql the host is always used
+	TLSServerName string `json:"tls_server_name" mapstructure:"tls_server_name"`
 	// Custom database connection string.
 	// If not empty this connection string will be used instead of build one using the previous parameters
 	ConnectionString string `json:"connection_string" mapstructure:"connection_string"`
@@ -221,13 +241,13 @@ type Config struct {
 	// a valid absolute path, then the user home dir will be automatically
 	// defined as the path obtained joining the base dir and the username
 	UsersBaseDir string `json:"users_base_dir" mapstructure:"users_base_dir"`
-	// Actions to execute on user add, update, delete.
+	// Actions to execute on user, folder, admin add, update, delete.
 	// Update action will not be fired for internal updates such as the last login or the user quota fields.
-	Actions UserActions `json:"actions" mapstructure:"actions"`
+	Actions ProviderActions `json:"actions" mapstructure:"actions"`
 	// Absolute path to an external program or an HTTP URL to invoke for users authentication.
 	// Leave empty to use builtin authentication.
 	// If the authentication succeed the user will be automatically added/updated inside the defined data provider.
-	// Actions defined for user added/updated will not be executed in this case.
+	// Actions defined for user added/updated are executed in this case too.
 	// This method is slower than built-in authentication methods, but it's very flexible as anyone can
 	// easily write his own authentication hooks.
 	ExternalAuthHook string `json:"external_auth_hook" mapstructure:"external_auth_hook"`
@@ -402,7 +422,7 @@ func GetQuotaTracking() int {
 
 // Provider defines the interface that data providers must implement.
 type Provider interface {
-	validateUserAndPass(username, password, ip, protocol string) (User, error)
+	validateUserAndPass(ctx context.Context, username, password, ip, protocol string) (User, error)
 	validateUserAndPubKey(username string, pubKey []byte) (User, string, error)
 	validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error)
 	updateQuota(username string, filesAdd int, sizeAdd int64, reset bool) error
@@ -429,6 +449,35 @@ type Provider interface {
 	getAdmins(limit int, offset int, order string) ([]Admin, error)
 	dumpAdmins() ([]Admin, error)
 	validateAdminAndPass(username, password, ip string) (Admin, error)
+	getDefenderHostByIP(ip string, from int64) (DefenderEntry, error)
+	isDefenderHostBanned(ip string) (DefenderEntry, error)
+	updateDefenderBanTime(ip string, minutes int) error
+	deleteDefenderHost(ip string) error
+	addDefenderEvent(ip string, score int) error
+	setDefenderBanTime(ip string, banTime int64) error
+	cleanupDefender(from int64) error
+	getDefenderListEntries() ([]DefenderListEntry, error)
+	addDefenderListEntry(entry *DefenderListEntry) error
+	deleteDefenderListEntry(entry *DefenderListEntry) error
+	addQueuedEvent(payload string, createdAt int64) error
+	getQueuedEvents(limit int) ([]QueuedEvent, error)
+	claimQueuedEvents(owner string, limit int, now, leaseExpires int64) ([]QueuedEvent, error)
+	renewQueuedEventsLease(owner string, leaseExpires int64) error
+	deleteQueuedEvent(id int64) error
+	addSharedConnection(conn *SharedConnection) error
+	deleteSharedConnection(nodeID, connectionID string) error
+	getSharedConnections(from int64) ([]SharedConnection, error)
+	requestSharedConnectionClose(nodeID, connectionID string) error
+	cleanupSharedConnections(from int64) error
+	addDropLink(link *DropLink) error
+	dropLinkExists(linkID string) (DropLink, error)
+	getDropLinks(username string) ([]DropLink, error)
+	deleteDropLink(linkID, username string) error
+	updateDropLinkUsage(linkID string, filesAdd int, sizeAdd int64) error
+	addApprovalRequest(req *ApprovalRequest) error
+	approvalRequestExists(id string) (ApprovalRequest, error)
+	getApprovalRequests(limit, offset int) ([]ApprovalRequest, error)
+	updateApprovalRequestStatus(req *ApprovalRequest, fromStatus string) error
 	checkAvailability() error
 	close() error
 	reloadConfig() error
@@ -509,6 +558,9 @@ func Initialize(cnf Config, basePath string, checkAdmins bool) error {
 }
 
 func validateHooks() error {
+	if err := validateProviderActions(); err != nil {
+		return err
+	}
 	var hooks []string
 	if config.PreLoginHook != "" && !strings.HasPrefix(config.PreLoginHook, "http") {
 		hooks = append(hooks, config.PreLoginHook)
@@ -549,6 +601,13 @@ func validateSQLTablesPrefix() error {
 		sqlTableFoldersMapping = config.SQLTablesPrefix + sqlTableFoldersMapping
 		sqlTableAdmins = config.SQLTablesPrefix + sqlTableAdmins
 		sqlTableSchemaVersion = config.SQLTablesPrefix + sqlTableSchemaVersion
+		sqlTableDefenderHosts = config.SQLTablesPrefix + sqlTableDefenderHosts
+		sqlTableDefenderEvents = config.SQLTablesPrefix + sqlTableDefenderEvents
+		sqlTableDefenderLists = config.SQLTablesPrefix + sqlTableDefenderLists
+		sqlTableEventsQueue = config.SQLTablesPrefix + sqlTableEventsQueue
+		sqlTableSharedConns = config.SQLTablesPrefix + sqlTableSharedConns
+		sqlTableDropLinks = config.SQLTablesPrefix + sqlTableDropLinks
+		sqlTableApprovals = config.SQLTablesPrefix + sqlTableApprovals
 		providerLog(logger.LevelDebug, "sql table for users %#v, folders %#v folders mapping %#v admins %#v schema version %#v",
 			sqlTableUsers, sqlTableFolders, sqlTableFoldersMapping, sqlTableAdmins, sqlTableSchemaVersion)
 	}
@@ -591,6 +650,32 @@ func InitializeDatabase(cnf Config, basePath string) error {
 	return provider.migrateDatabase()
 }
 
+// CheckConfig validates the data provider configuration.
+// If online is true the connection to the configured provider is checked too
+func CheckConfig(cnf Config, basePath string, online bool) error {
+	config = cnf
+
+	if !utils.IsStringInSlice(config.Driver, []string{SQLiteDataProviderName, PGSQLDataProviderName,
+		CockroachDataProviderName, MySQLDataProviderName, BoltDataProviderName, MemoryDataProviderName}) {
+		return fmt.Errorf("unsupported data provider: %v", config.Driver)
+	}
+	if config.PasswordHashing.Algo == HashingAlgoBcrypt && config.PasswordHashing.BcryptOptions.Cost > bcrypt.MaxCost {
+		return fmt.Errorf("invalid bcrypt cost %v, max allowed %v", config.PasswordHashing.BcryptOptions.Cost, bcrypt.MaxCost)
+	}
+	if err := validateHooks(); err != nil {
+		return err
+	}
+	if !online {
+		return nil
+	}
+	if err := createProvider(basePath); err != nil {
+		return err
+	}
+	defer provider.close() //nolint:errcheck
+
+	return provider.checkAvailability()
+}
+
 // RevertDatabase restores schema and/or data to a previous version
 func RevertDatabase(cnf Config, basePath string, targetVersion int) error {
 	config = cnf
@@ -614,7 +699,10 @@ func RevertDatabase(cnf Config, basePath string, targetVersion int) error {
 
 // CheckAdminAndPass validates the given admin and password connecting from ip
 func CheckAdminAndPass(username, password, ip string) (Admin, error) {
-	return provider.validateAdminAndPass(username, password, ip)
+	query := startQuery(context.Background(), "validate_admin_and_pass")
+	admin, err := provider.validateAdminAndPass(username, password, ip)
+	query.end(err)
+	return admin, err
 }
 
 // CheckCachedUserCredentials checks the credentials for a cached user
@@ -652,18 +740,79 @@ func CheckCachedUserCredentials(user *CachedUser, password, loginMethod, protoco
 // CheckCompositeCredentials checks multiple credentials.
 // WebDAV users can send both a password and a TLS certificate within the same request
 func CheckCompositeCredentials(username, password, ip, loginMethod, protocol string, tlsCert *x509.Certificate) (User, string, error) {
+	ctx, span := startLoginSpan(username, loginMethod, ip, protocol)
+	user, loginMethod, err := doCheckCompositeCredentials(ctx, username, password, ip, loginMethod, protocol, tlsCert)
+	span.End(err)
+	return user, loginMethod, err
+}
+
+// CheckUserBeforeTLSAuth checks if a user exits before trying mutual TLS
+func CheckUserBeforeTLSAuth(username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
+	ctx, span := startLoginSpan(username, LoginMethodTLSCertificate, ip, protocol)
+	user, err := doCheckUserBeforeTLSAuth(ctx, username, ip, protocol, tlsCert)
+	span.End(err)
+	return user, err
+}
+
+// CheckUserBeforeSharedSecretAuth returns the SFTPGo user with the given username
+// if it exists and is allowed to login. The credentials must be verified by the
+// caller, for example the rsync daemon verifies the module shared secret
+func CheckUserBeforeSharedSecretAuth(username, ip, protocol string) (User, error) {
+	ctx, span := startLoginSpan(username, LoginMethodPassword, ip, protocol)
+	user, err := doCheckUserBeforeSharedSecretAuth(ctx, username, ip, protocol)
+	span.End(err)
+	return user, err
+}
+
+// CheckUserAndTLSCert returns the SFTPGo user with the given username and check if the
+// given TLS certificate allow authentication without password
+func CheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
+	ctx, span := startLoginSpan(username, LoginMethodTLSCertificate, ip, protocol)
+	user, err := doCheckUserAndTLSCert(ctx, username, ip, protocol, tlsCert)
+	span.End(err)
+	return user, err
+}
+
+// CheckUserAndPass retrieves the SFTPGo user with the given username and password if a match is found or an error
+func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
+	ctx, span := startLoginSpan(username, LoginMethodPassword, ip, protocol)
+	user, err := doCheckUserAndPass(ctx, username, password, ip, protocol)
+	span.End(err)
+	return user, err
+}
+
+// CheckUserAndPubKey retrieves the SFTP user with the given username and public key if a match is found or an error
+func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string) (User, string, error) {
+	ctx, span := startLoginSpan(username, SSHLoginMethodPublicKey, ip, protocol)
+	user, keyID, err := doCheckUserAndPubKey(ctx, username, pubKey, ip, protocol)
+	span.End(err)
+	return user, keyID, err
+}
+
+// CheckKeyboardInteractiveAuth checks the keyboard interactive authentication and returns
+// the authenticated user or an error
+func CheckKeyboardInteractiveAuth(username, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (User, error) {
+	ctx, span := startLoginSpan(username, SSHLoginMethodKeyboardInteractive, ip, protocol)
+	user, err := doCheckKeyboardInteractiveAuth(ctx, username, authHook, client, ip, protocol)
+	span.End(err)
+	return user, err
+}
+
+func doCheckCompositeCredentials(ctx context.Context, username, password, ip, loginMethod, protocol string,
+	tlsCert *x509.Certificate,
+) (User, string, error) {
 	if loginMethod == LoginMethodPassword {
-		user, err := CheckUserAndPass(username, password, ip, protocol)
+		user, err := doCheckUserAndPass(ctx, username, password, ip, protocol)
 		return user, loginMethod, err
 	}
-	user, err := CheckUserBeforeTLSAuth(username, ip, protocol, tlsCert)
+	user, err := doCheckUserBeforeTLSAuth(ctx, username, ip, protocol, tlsCert)
 	if err != nil {
 		return user, loginMethod, err
 	}
 	if !user.IsTLSUsernameVerificationEnabled() {
 		// for backward compatibility with 2.0.x we only check the password and change the login method here
 		// in future updates we have to return an error
-		user, err := CheckUserAndPass(username, password, ip, protocol)
+		user, err := doCheckUserAndPass(ctx, username, password, ip, protocol)
 		return user, LoginMethodPassword, err
 	}
 	user, err = checkUserAndTLSCertificate(&user, protocol, tlsCert)
@@ -674,108 +823,173 @@ func CheckCompositeCredentials(username, password, ip, loginMethod, protocol str
 		return user, loginMethod, fmt.Errorf("certificate login method is not allowed for user %#v", user.Username)
 	}
 	if loginMethod == LoginMethodTLSCertificateAndPwd {
-		if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
-			user, err = doExternalAuth(username, password, nil, "", ip, protocol, nil)
+		if isExternalAuthConfigured(1) {
+			user, err = doExternalAuth(ctx, username, password, nil, "", ip, protocol, nil)
 			if err != nil {
 				return user, loginMethod, err
 			}
 		}
 		if config.PreLoginHook != "" {
-			user, err = executePreLoginHook(username, LoginMethodPassword, ip, protocol)
+			user, err = executePreLoginHook(ctx, username, LoginMethodPassword, ip, protocol)
 			if err != nil {
 				return user, loginMethod, err
 			}
 		}
-		user, err = checkUserAndPass(&user, password, ip, protocol)
+		user, err = checkUserAndPass(ctx, &user, password, ip, protocol)
 	}
 	return user, loginMethod, err
 }
 
-// CheckUserBeforeTLSAuth checks if a user exits before trying mutual TLS
-func CheckUserBeforeTLSAuth(username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
-	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&8 != 0) {
-		return doExternalAuth(username, "", nil, "", ip, protocol, tlsCert)
+func doCheckUserBeforeTLSAuth(ctx context.Context, username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
+	if isExternalAuthConfigured(8) {
+		return doExternalAuth(ctx, username, "", nil, "", ip, protocol, tlsCert)
 	}
 	if config.PreLoginHook != "" {
-		return executePreLoginHook(username, LoginMethodTLSCertificate, ip, protocol)
+		return executePreLoginHook(ctx, username, LoginMethodTLSCertificate, ip, protocol)
 	}
-	return UserExists(username)
+	return userExistsWithContext(ctx, username)
 }
 
-// CheckUserAndTLSCert returns the SFTPGo user with the given username and check if the
-// given TLS certificate allow authentication without password
-func CheckUserAndTLSCert(username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
-	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&8 != 0) {
-		user, err := doExternalAuth(username, "", nil, "", ip, protocol, tlsCert)
+func doCheckUserBeforeSharedSecretAuth(ctx context.Context, username, ip, protocol string) (User, error) {
+	var user User
+	var err error
+	if config.PreLoginHook != "" {
+		user, err = executePreLoginHook(ctx, username, LoginMethodPassword, ip, protocol)
+	} else {
+		user, err = userExistsWithContext(ctx, username)
+	}
+	if err != nil {
+		return user, err
+	}
+	return user, checkLoginConditions(&user)
+}
+
+func doCheckUserAndTLSCert(ctx context.Context, username, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
+	if isExternalAuthConfigured(8) {
+		user, err := doExternalAuth(ctx, username, "", nil, "", ip, protocol, tlsCert)
 		if err != nil {
 			return user, err
 		}
 		return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 	}
 	if config.PreLoginHook != "" {
-		user, err := executePreLoginHook(username, LoginMethodTLSCertificate, ip, protocol)
+		user, err := executePreLoginHook(ctx, username, LoginMethodTLSCertificate, ip, protocol)
 		if err != nil {
 			return user, err
 		}
 		return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 	}
-	return provider.validateUserAndTLSCert(username, protocol, tlsCert)
+	query := startQuery(ctx, "validate_user_and_tls_cert")
+	user, err := provider.validateUserAndTLSCert(username, protocol, tlsCert)
+	query.end(err)
+	return user, err
 }
 
-// CheckUserAndPass retrieves the SFTPGo user with the given username and password if a match is found or an error
-func CheckUserAndPass(username, password, ip, protocol string) (User, error) {
-	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&1 != 0) {
-		user, err := doExternalAuth(username, password, nil, "", ip, protocol, nil)
+func doCheckUserAndPass(ctx context.Context, username, password, ip, protocol string) (User, error) {
+	if isExternalAuthConfigured(1) {
+		user, err := doExternalAuth(ctx, username, password, nil, "", ip, protocol, nil)
 		if err != nil {
 			return user, err
 		}
-		return checkUserAndPass(&user, password, ip, protocol)
+		return checkUserAndPass(ctx, &user, password, ip, protocol)
 	}
 	if config.PreLoginHook != "" {
-		user, err := executePreLoginHook(username, LoginMethodPassword, ip, protocol)
+		user, err := executePreLoginHook(ctx, username, LoginMethodPassword, ip, protocol)
 		if err != nil {
 			return user, err
 		}
-		return checkUserAndPass(&user, password, ip, protocol)
+		return checkUserAndPass(ctx, &user, password, ip, protocol)
 	}
-	return provider.validateUserAndPass(username, password, ip, protocol)
+	queryCtx, query := startQueryContext(ctx, "validate_user_and_pass")
+	user, err := provider.validateUserAndPass(queryCtx, username, password, ip, protocol)
+	query.end(err)
+	return user, err
 }
 
-// CheckUserAndPubKey retrieves the SFTP user with the given username and public key if a match is found or an error
-func CheckUserAndPubKey(username string, pubKey []byte, ip, protocol string) (User, string, error) {
-	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&2 != 0) {
-		user, err := doExternalAuth(username, "", pubKey, "", ip, protocol, nil)
+func doCheckUserAndPubKey(ctx context.Context, username string, pubKey []byte, ip, protocol string) (User, string, error) {
+	if isExternalAuthConfigured(2) {
+		user, err := doExternalAuth(ctx, username, "", pubKey, "", ip, protocol, nil)
 		if err != nil {
 			return user, "", err
 		}
 		return checkUserAndPubKey(&user, pubKey)
 	}
 	if config.PreLoginHook != "" {
-		user, err := executePreLoginHook(username, SSHLoginMethodPublicKey, ip, protocol)
+		user, err := executePreLoginHook(ctx, username, SSHLoginMethodPublicKey, ip, protocol)
 		if err != nil {
 			return user, "", err
 		}
 		return checkUserAndPubKey(&user, pubKey)
 	}
-	return provider.validateUserAndPubKey(username, pubKey)
+	query := startQuery(ctx, "validate_user_and_pub_key")
+	user, keyID, err := provider.validateUserAndPubKey(username, pubKey)
+	query.end(err)
+	return user, keyID, err
 }
 
-// CheckKeyboardInteractiveAuth checks the keyboard interactive authentication and returns
-// the authenticated user or an error
-func CheckKeyboardInteractiveAuth(username, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (User, error) {
+func doCheckKeyboardInteractiveAuth(ctx context.Context, username, authHook string, client ssh.KeyboardInteractiveChallenge,
+	ip, protocol string,
+) (User, error) {
 	var user User
 	var err error
-	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&4 != 0) {
-		user, err = doExternalAuth(username, "", nil, "1", ip, protocol, nil)
+	if isExternalAuthConfigured(4) {
+		user, err = doExternalAuth(ctx, username, "", nil, "1", ip, protocol, nil)
 	} else if config.PreLoginHook != "" {
-		user, err = executePreLoginHook(username, SSHLoginMethodKeyboardInteractive, ip, protocol)
+		user, err = executePreLoginHook(ctx, username, SSHLoginMethodKeyboardInteractive, ip, protocol)
 	} else {
-		user, err = provider.userExists(username)
+		user, err = userExistsWithContext(ctx, username)
 	}
 	if err != nil {
 		return user, err
 	}
-	return doKeyboardInteractiveAuth(&user, authHook, client, ip, protocol)
+	return doKeyboardInteractiveAuth(ctx, &user, authHook, client, ip, protocol)
+}
+
+// startLoginSpan starts the root span for a login attempt
+func startLoginSpan(username, loginMethod, ip, protocol string) (context.Context, *tracing.Span) {
+	return tracing.Start(context.Background(), "login", tracing.String("username", username),
+		tracing.String("login_method", loginMethod), tracing.String("ip", ip), tracing.String("protocol", protocol))
+}
+
+// providerQuery measures a data provider query, the query rate and latency
+// are exported as metrics and the query is traced
+type providerQuery struct {
+	operation string
+	startTime time.Time
+	span      *tracing.Span
+}
+
+// startQueryContext starts measuring a data provider query, the returned
+// context can be used for the hooks executed within the query
+func startQueryContext(ctx context.Context, operation string) (context.Context, *providerQuery) {
+	ctx, span := tracing.Start(ctx, "dataprovider."+operation, tracing.String("db.system", config.Driver))
+	return ctx, &providerQuery{
+		operation: operation,
+		startTime: time.Now(),
+		span:      span,
+	}
+}
+
+// startQuery starts measuring a data provider query
+func startQuery(ctx context.Context, operation string) *providerQuery {
+	_, query := startQueryContext(ctx, operation)
+	return query
+}
+
+func (q *providerQuery) end(err error) {
+	q.span.End(err)
+	if _, ok := err.(*RecordNotFoundError); ok || err == ErrInvalidCredentials {
+		// the query succeeded, the object does not exist or the credentials are wrong
+		err = nil
+	}
+	metrics.DataProviderQueryCompleted(q.operation, time.Since(q.startTime), err)
+}
+
+func userExistsWithContext(ctx context.Context, username string) (User, error) {
+	query := startQuery(ctx, "user_exists")
+	user, err := provider.userExists(username)
+	query.end(err)
+	return user, err
 }
 
 // UpdateLastLogin updates the last login fields for the given SFTP user
@@ -783,7 +997,9 @@ func UpdateLastLogin(user *User) error {
 	lastLogin := utils.GetTimeFromMsecSinceEpoch(user.LastLogin)
 	diff := -time.Until(lastLogin)
 	if diff < 0 || diff > lastLoginMinDelay {
+		query := startQuery(context.Background(), "update_last_login")
 		err := provider.updateLastLogin(user.Username)
+		query.end(err)
 		if err == nil {
 			webDAVUsersCache.updateLastLogin(user.Username)
 		}
@@ -806,8 +1022,12 @@ func UpdateUserQuota(user *User, filesAdd int, sizeAdd int64, reset bool) error
 	if config.DelayedQuotaUpdate == 0 || reset {
 		if reset {
 			delayedQuotaUpdater.resetUserQuota(user.Username)
+			delayedQuotaUpdater.resetUserDirQuotas(user.Username)
 		}
-		return provider.updateQuota(user.Username, filesAdd, sizeAdd, reset)
+		query := startQuery(context.Background(), "update_quota")
+		err := provider.updateQuota(user.Username, filesAdd, sizeAdd, reset)
+		query.end(err)
+		return err
 	}
 	delayedQuotaUpdater.updateUserQuota(user.Username, filesAdd, sizeAdd)
 	return nil
@@ -826,18 +1046,53 @@ func UpdateVirtualFolderQuota(vfolder *vfs.BaseVirtualFolder, filesAdd int, size
 		if reset {
 			delayedQuotaUpdater.resetFolderQuota(vfolder.Name)
 		}
-		return provider.updateFolderQuota(vfolder.Name, filesAdd, sizeAdd, reset)
+		query := startQuery(context.Background(), "update_folder_quota")
+		err := provider.updateFolderQuota(vfolder.Name, filesAdd, sizeAdd, reset)
+		query.end(err)
+		return err
 	}
 	delayedQuotaUpdater.updateFolderQuota(vfolder.Name, filesAdd, sizeAdd)
 	return nil
 }
 
+// UpdateDirQuota updates the used quota for the directories with quota restrictions
+// containing the file with the given virtual path
+func UpdateDirQuota(user *User, virtualPath string, filesAdd int, sizeAdd int64) {
+	if config.TrackQuota == 0 || (filesAdd == 0 && sizeAdd == 0) {
+		return
+	}
+	for _, q := range user.GetDirQuotasForPath(virtualPath) {
+		delayedQuotaUpdater.updateDirQuota(user.Username, q.Path, filesAdd, sizeAdd)
+	}
+}
+
+// SetUsedDirQuota sets the used quota for the given user directory,
+// usually after a directory scan
+func SetUsedDirQuota(username, dirPath string, files int, size int64) {
+	delayedQuotaUpdater.setDirQuota(username, dirPath, files, size)
+}
+
+// GetUsedDirQuota returns the used quota for the given user directory.
+// The returned bool is false if the used quota is unknown and so the
+// directory must be scanned
+func GetUsedDirQuota(username, dirPath string) (int, int64, bool) {
+	return delayedQuotaUpdater.getDirQuota(username, dirPath)
+}
+
+// ResetUserDirQuotas discards the used quota for all the directories of the given
+// user, they will be scanned again when needed
+func ResetUserDirQuotas(username string) {
+	delayedQuotaUpdater.resetUserDirQuotas(username)
+}
+
 // GetUsedQuota returns the used quota for the given SFTP user.
 func GetUsedQuota(username string) (int, int64, error) {
 	if config.TrackQuota == 0 {
 		return 0, 0, &MethodDisabledError{err: trackQuotaDisabledError}
 	}
+	query := startQuery(context.Background(), "get_used_quota")
 	files, size, err := provider.getUsedQuota(username)
+	query.end(err)
 	if err != nil {
 		return files, size, err
 	}
@@ -850,7 +1105,9 @@ func GetUsedVirtualFolderQuota(name string) (int, int64, error) {
 	if config.TrackQuota == 0 {
 		return 0, 0, &MethodDisabledError{err: trackQuotaDisabledError}
 	}
+	query := startQuery(context.Background(), "get_used_folder_quota")
 	files, size, err := provider.getUsedFolderQuota(name)
+	query.end(err)
 	if err != nil {
 		return files, size, err
 	}
@@ -860,12 +1117,26 @@ func GetUsedVirtualFolderQuota(name string) (int, int64, error) {
 
 // AddAdmin adds a new SFTPGo admin
 func AddAdmin(admin *Admin) error {
-	return provider.addAdmin(admin)
+	query := startQuery(context.Background(), "add_admin")
+	err := provider.addAdmin(admin)
+	query.end(err)
+	if err == nil {
+		executeAction(operationAdd, ActionObjectAdmin, admin.Username, nil)
+	}
+	return err
 }
 
-// UpdateAdmin updates an existing SFTPGo admin
+// UpdateAdmin updates an existing SFTPGo admin.
+// ErrVersionMismatch is returned if the admin was modified after it was read
 func UpdateAdmin(admin *Admin) error {
-	return provider.updateAdmin(admin)
+	prevObject := getActionPreviousObject(operationUpdate, ActionObjectAdmin, admin.Username)
+	query := startQuery(context.Background(), "update_admin")
+	err := provider.updateAdmin(admin)
+	query.end(err)
+	if err == nil {
+		executeAction(operationUpdate, ActionObjectAdmin, admin.Username, prevObject)
+	}
+	return err
 }
 
 // DeleteAdmin deletes an existing SFTPGo admin
@@ -874,35 +1145,52 @@ func DeleteAdmin(username string) error {
 	if err != nil {
 		return err
 	}
-	return provider.deleteAdmin(&admin)
+	prevObject := getActionPreviousObject(operationDelete, ActionObjectAdmin, username)
+	query := startQuery(context.Background(), "delete_admin")
+	err = provider.deleteAdmin(&admin)
+	query.end(err)
+	if err == nil {
+		executeAction(operationDelete, ActionObjectAdmin, username, prevObject)
+	}
+	return err
 }
 
 // AdminExists returns the given admins if it exists
 func AdminExists(username string) (Admin, error) {
-	return provider.adminExists(username)
+	query := startQuery(context.Background(), "admin_exists")
+	admin, err := provider.adminExists(username)
+	query.end(err)
+	return admin, err
 }
 
 // UserExists checks if the given SFTPGo username exists, returns an error if no match is found
 func UserExists(username string) (User, error) {
-	return provider.userExists(username)
+	return userExistsWithContext(context.Background(), username)
 }
 
 // AddUser adds a new SFTPGo user.
 func AddUser(user *User) error {
+	query := startQuery(context.Background(), "add_user")
 	err := provider.addUser(user)
+	query.end(err)
 	if err == nil {
-		executeAction(operationAdd, user)
+		executeAction(operationAdd, ActionObjectUser, user.Username, nil)
 	}
 	return err
 }
 
 // UpdateUser updates an existing SFTPGo user.
+// ErrVersionMismatch is returned if the user was modified after it was read
 func UpdateUser(user *User) error {
+	prevObject := getActionPreviousObject(operationUpdate, ActionObjectUser, user.Username)
+	query := startQuery(context.Background(), "update_user")
 	err := provider.updateUser(user)
+	query.end(err)
 	if err == nil {
 		webDAVUsersCache.swap(user)
 		cachedPasswords.Remove(user.Username)
-		executeAction(operationUpdate, user)
+		delayedQuotaUpdater.resetUserDirQuotas(user.Username)
+		executeAction(operationUpdate, ActionObjectUser, user.Username, prevObject)
 	}
 	return err
 }
@@ -913,12 +1201,16 @@ func DeleteUser(username string) error {
 	if err != nil {
 		return err
 	}
+	prevObject := getActionPreviousObject(operationDelete, ActionObjectUser, username)
+	query := startQuery(context.Background(), "delete_user")
 	err = provider.deleteUser(&user)
+	query.end(err)
 	if err == nil {
 		RemoveCachedWebDAVUser(user.Username)
 		delayedQuotaUpdater.resetUserQuota(username)
+		delayedQuotaUpdater.resetUserDirQuotas(username)
 		cachedPasswords.Remove(username)
-		executeAction(operationDelete, &user)
+		executeAction(operationDelete, ActionObjectUser, username, prevObject)
 	}
 	return err
 }
@@ -932,26 +1224,43 @@ func ReloadConfig() error {
 
 // GetAdmins returns an array of admins respecting limit and offset
 func GetAdmins(limit, offset int, order string) ([]Admin, error) {
-	return provider.getAdmins(limit, offset, order)
+	query := startQuery(context.Background(), "get_admins")
+	admins, err := provider.getAdmins(limit, offset, order)
+	query.end(err)
+	return admins, err
 }
 
 // GetUsers returns an array of users respecting limit and offset and filtered by username exact match if not empty
 func GetUsers(limit, offset int, order string) ([]User, error) {
-	return provider.getUsers(limit, offset, order)
+	query := startQuery(context.Background(), "get_users")
+	users, err := provider.getUsers(limit, offset, order)
+	query.end(err)
+	return users, err
 }
 
 // AddFolder adds a new virtual folder.
 func AddFolder(folder *vfs.BaseVirtualFolder) error {
-	return provider.addFolder(folder)
+	query := startQuery(context.Background(), "add_folder")
+	err := provider.addFolder(folder)
+	query.end(err)
+	if err == nil {
+		executeAction(operationAdd, ActionObjectFolder, folder.Name, nil)
+	}
+	return err
 }
 
-// UpdateFolder updates the specified virtual folder
+// UpdateFolder updates the specified virtual folder.
+// ErrVersionMismatch is returned if the folder was modified after it was read
 func UpdateFolder(folder *vfs.BaseVirtualFolder, users []string) error {
+	prevObject := getActionPreviousObject(operationUpdate, ActionObjectFolder, folder.Name)
+	query := startQuery(context.Background(), "update_folder")
 	err := provider.updateFolder(folder)
+	query.end(err)
 	if err == nil {
 		for _, user := range users {
 			RemoveCachedWebDAVUser(user)
 		}
+		executeAction(operationUpdate, ActionObjectFolder, folder.Name, prevObject)
 	}
 	return err
 }
@@ -962,24 +1271,34 @@ func DeleteFolder(folderName string) error {
 	if err != nil {
 		return err
 	}
+	prevObject := getActionPreviousObject(operationDelete, ActionObjectFolder, folderName)
+	query := startQuery(context.Background(), "delete_folder")
 	err = provider.deleteFolder(&folder)
+	query.end(err)
 	if err == nil {
 		for _, user := range folder.Users {
 			RemoveCachedWebDAVUser(user)
 		}
 		delayedQuotaUpdater.resetFolderQuota(folderName)
+		executeAction(operationDelete, ActionObjectFolder, folderName, prevObject)
 	}
 	return err
 }
 
 // GetFolderByName returns the folder with the specified name if any
 func GetFolderByName(name string) (vfs.BaseVirtualFolder, error) {
-	return provider.getFolderByName(name)
+	query := startQuery(context.Background(), "get_folder_by_name")
+	folder, err := provider.getFolderByName(name)
+	query.end(err)
+	return folder, err
 }
 
 // GetFolders returns an array of folders respecting limit and offset
 func GetFolders(limit, offset int, order string) ([]vfs.BaseVirtualFolder, error) {
-	return provider.getFolders(limit, offset, order)
+	query := startQuery(context.Background(), "get_folders")
+	folders, err := provider.getFolders(limit, offset, order)
+	query.end(err)
+	return folders, err
 }
 
 // DumpData returns all users and folders
@@ -1045,6 +1364,10 @@ func createProvider(basePath string) error {
 		return err
 	}
 	logSender = fmt.Sprintf("dataprovider_%v", config.Driver)
+	if err = validateTLSConfig(); err != nil {
+		return err
+	}
+	resolveTLSPaths(basePath)
 
 	switch config.Driver {
 	case SQLiteDataProviderName:
@@ -1067,7 +1390,9 @@ func buildUserHomeDir(user *User) {
 	if user.HomeDir == "" {
 		if config.UsersBaseDir != "" {
 			user.HomeDir = filepath.Join(config.UsersBaseDir, user.Username)
-		} else if user.FsConfig.Provider == vfs.SFTPFilesystemProvider {
+		} else if user.FsConfig.Provider == vfs.SFTPFilesystemProvider ||
+			user.FsConfig.Provider == vfs.PluginFilesystemProvider ||
+			user.FsConfig.Provider == vfs.SMBFilesystemProvider {
 			user.HomeDir = filepath.Join(os.TempDir(), user.Username)
 		}
 	}
@@ -1330,6 +1655,50 @@ func validateFiltersFileExtensions(user *User) error {
 	return nil
 }
 
+func validateOperationsFilters(user *User) error {
+	if len(user.Filters.Operations) == 0 {
+		user.Filters.Operations = []OperationsFilter{}
+		return nil
+	}
+	filteredPaths := []string{}
+	var filters []OperationsFilter
+	for _, f := range user.Filters.Operations {
+		cleanedPath := filepath.ToSlash(path.Clean(f.Path))
+		if !path.IsAbs(cleanedPath) {
+			return &ValidationError{err: fmt.Sprintf("invalid path %#v for operations filter", f.Path)}
+		}
+		if utils.IsStringInSlice(cleanedPath, filteredPaths) {
+			return &ValidationError{err: fmt.Sprintf("duplicate operations filter for path %#v", f.Path)}
+		}
+		if len(f.AllowedOperations) == 0 && len(f.DeniedOperations) == 0 {
+			return &ValidationError{err: fmt.Sprintf("empty operations filter for path %#v", f.Path)}
+		}
+		f.Path = cleanedPath
+		for _, operations := range [][]string{f.AllowedOperations, f.DeniedOperations} {
+			for _, op := range operations {
+				if op == PermAny || !utils.IsStringInSlice(op, ValidPerms) {
+					return &ValidationError{err: fmt.Sprintf("invalid operation %#v for path %#v", op, f.Path)}
+				}
+			}
+		}
+		f.AllowedOperations = utils.RemoveDuplicates(f.AllowedOperations)
+		f.DeniedOperations = utils.RemoveDuplicates(f.DeniedOperations)
+		filters = append(filters, f)
+		filteredPaths = append(filteredPaths, cleanedPath)
+	}
+	user.Filters.Operations = filters
+	return nil
+}
+
+func validateMetadata(user *User) error {
+	for key := range user.Filters.Metadata {
+		if !metadataKeyRegex.MatchString(key) {
+			return &ValidationError{err: fmt.Sprintf("invalid metadata key %#v, the allowed characters are: a-zA-Z0-9-_.", key)}
+		}
+	}
+	return nil
+}
+
 func validateFileFilters(user *User) error {
 	if err := validateFiltersFileExtensions(user); err != nil {
 		return err
@@ -1337,6 +1706,89 @@ func validateFileFilters(user *User) error {
 	return validateFiltersPatternExtensions(user)
 }
 
+func validateDirQuotas(user *User) error {
+	if len(user.Filters.DirQuotas) == 0 {
+		user.Filters.DirQuotas = []DirQuota{}
+		return nil
+	}
+	quotaPaths := []string{}
+	var quotas []DirQuota
+	for _, q := range user.Filters.DirQuotas {
+		cleanedPath := filepath.ToSlash(path.Clean(q.Path))
+		if !path.IsAbs(cleanedPath) || cleanedPath == "/" {
+			return &ValidationError{err: fmt.Sprintf("invalid path %#v for directory quota", q.Path)}
+		}
+		if utils.IsStringInSlice(cleanedPath, quotaPaths) {
+			return &ValidationError{err: fmt.Sprintf("duplicate directory quota for path %#v", q.Path)}
+		}
+		if q.QuotaSize < 0 || q.QuotaFiles < 0 {
+			return &ValidationError{err: fmt.Sprintf("invalid directory quota for path %#v", q.Path)}
+		}
+		if q.QuotaSize == 0 && q.QuotaFiles == 0 {
+			return &ValidationError{err: fmt.Sprintf("empty directory quota for path %#v", q.Path)}
+		}
+		q.Path = cleanedPath
+		quotas = append(quotas, q)
+		quotaPaths = append(quotaPaths, cleanedPath)
+	}
+	user.Filters.DirQuotas = quotas
+	return nil
+}
+
+func validateRetentionRules(user *User) error {
+	if len(user.Filters.Retention) == 0 {
+		user.Filters.Retention = []RetentionRule{}
+		return nil
+	}
+	rulePaths := []string{}
+	var rules []RetentionRule
+	for _, r := range user.Filters.Retention {
+		cleanedPath := filepath.ToSlash(path.Clean(r.Path))
+		if !path.IsAbs(cleanedPath) {
+			return &ValidationError{err: fmt.Sprintf("invalid path %#v for retention rule", r.Path)}
+		}
+		if utils.IsStringInSlice(cleanedPath, rulePaths) {
+			return &ValidationError{err: fmt.Sprintf("duplicate retention rule for path %#v", r.Path)}
+		}
+		if r.Days <= 0 {
+			return &ValidationError{err: fmt.Sprintf("invalid retention days for path %#v: %v", r.Path, r.Days)}
+		}
+		patterns := make([]string, 0, len(r.Patterns))
+		for _, pattern := range r.Patterns {
+			pattern = strings.TrimSpace(pattern)
+			if pattern == "" {
+				continue
+			}
+			if _, err := path.Match(pattern, "abc"); err != nil {
+				return &ValidationError{err: fmt.Sprintf("invalid retention pattern %#v for path %#v", pattern, r.Path)}
+			}
+			patterns = append(patterns, strings.ToLower(pattern))
+		}
+		if r.ArchivePath != "" {
+			archivePath := filepath.ToSlash(path.Clean(r.ArchivePath))
+			if !path.IsAbs(archivePath) || archivePath == "/" {
+				return &ValidationError{err: fmt.Sprintf("invalid archive path %#v for retention rule", r.ArchivePath)}
+			}
+			if archivePath == cleanedPath || strings.HasPrefix(archivePath, strings.TrimSuffix(cleanedPath, "/")+"/") {
+				return &ValidationError{err: fmt.Sprintf("the archive path %#v cannot be inside the retention path %#v",
+					r.ArchivePath, r.Path)}
+			}
+			r.ArchivePath = archivePath
+		}
+		r.ArchiveFolder = strings.TrimSpace(r.ArchiveFolder)
+		if r.ArchivePath != "" && r.ArchiveFolder != "" {
+			return &ValidationError{err: fmt.Sprintf("archive path and archive folder are mutually exclusive for path %#v",
+				r.Path)}
+		}
+		r.Path = cleanedPath
+		r.Patterns = patterns
+		rules = append(rules, r)
+		rulePaths = append(rulePaths, cleanedPath)
+	}
+	user.Filters.Retention = rules
+	return nil
+}
+
 func checkEmptyFiltersStruct(user *User) {
 	if len(user.Filters.AllowedIP) == 0 {
 		user.Filters.AllowedIP = []string{}
@@ -1392,7 +1844,114 @@ func validateFilters(user *User) error {
 			return &ValidationError{err: fmt.Sprintf("invalid web client options %#v", opts)}
 		}
 	}
-	return validateFileFilters(user)
+	if user.Filters.MaxConcurrentTransfers < 0 {
+		return &ValidationError{err: fmt.Sprintf("invalid max concurrent transfers: %v", user.Filters.MaxConcurrentTransfers)}
+	}
+	if user.Filters.Trash.RetentionDays < 0 {
+		return &ValidationError{err: fmt.Sprintf("invalid trash retention days: %v", user.Filters.Trash.RetentionDays)}
+	}
+	if !user.Filters.Trash.Enabled {
+		user.Filters.Trash.RetentionDays = 0
+	}
+	if err := validateDirQuotas(user); err != nil {
+		return err
+	}
+	if err := validateRetentionRules(user); err != nil {
+		return err
+	}
+	if err := validateFileFilters(user); err != nil {
+		return err
+	}
+	if err := validateOperationsFilters(user); err != nil {
+		return err
+	}
+	if err := validateMetadata(user); err != nil {
+		return err
+	}
+	if err := validateImpersonation(user); err != nil {
+		return err
+	}
+	return validateCaseInsensitivePaths(user)
+}
+
+func validateImpersonation(user *User) error {
+	if !user.Filters.Impersonate {
+		return nil
+	}
+	if !vfs.IsImpersonationSupported() {
+		return &ValidationError{err: "OS impersonation is not supported on this platform"}
+	}
+	if user.GetUID() <= 0 || user.GetGID() <= 0 {
+		return &ValidationError{err: "OS impersonation requires a uid and a gid greater than 0"}
+	}
+	return nil
+}
+
+// validateCaseInsensitivePaths checks that the paths used for permissions,
+// virtual folders and filters don't differ only by case. They would be
+// ambiguous for a user resolving paths case insensitively
+func validateCaseInsensitivePaths(user *User) error {
+	if !user.Filters.CaseInsensitive {
+		return nil
+	}
+	checkPaths := func(kind string, paths []string) error {
+		lowerPaths := make(map[string]string)
+		for _, p := range paths {
+			lowerPath := strings.ToLower(p)
+			if other, ok := lowerPaths[lowerPath]; ok && other != p {
+				return &ValidationError{err: fmt.Sprintf("%v paths %#v and %#v differ only by case", kind, other, p)}
+			}
+			lowerPaths[lowerPath] = p
+		}
+		return nil
+	}
+	var paths []string
+	for dir := range user.Permissions {
+		paths = append(paths, dir)
+	}
+	if err := checkPaths("permissions", paths); err != nil {
+		return err
+	}
+	paths = nil
+	for idx := range user.VirtualFolders {
+		paths = append(paths, user.VirtualFolders[idx].VirtualPath)
+	}
+	if err := checkPaths("virtual folders", paths); err != nil {
+		return err
+	}
+	paths = nil
+	for _, f := range user.Filters.FilePatterns {
+		paths = append(paths, f.Path)
+	}
+	if err := checkPaths("file patterns", paths); err != nil {
+		return err
+	}
+	paths = nil
+	for _, f := range user.Filters.FileExtensions {
+		paths = append(paths, f.Path)
+	}
+	if err := checkPaths("file extensions", paths); err != nil {
+		return err
+	}
+	paths = nil
+	for _, f := range user.Filters.Operations {
+		paths = append(paths, f.Path)
+	}
+	if err := checkPaths("operations filters", paths); err != nil {
+		return err
+	}
+	paths = nil
+	for _, q := range user.Filters.DirQuotas {
+		paths = append(paths, q.Path)
+	}
+	if err := checkPaths("directory quotas", paths); err != nil {
+		return err
+	}
+	paths = nil
+	for _, r := range user.Filters.Retention {
+		paths = append(paths, r.Path)
+	}
+	return checkPaths("retention rules", paths)
 }
 
 func saveGCSCredentials(fsConfig *vfs.Filesystem, helper fsValidatorHelper) error {
@@ -1437,6 +1996,9 @@ func saveGCSCredentials(fsConfig *vfs.Filesystem, helper fsValidatorHelper) erro
 }
 
 func validateFilesystemConfig(fsConfig *vfs.Filesystem, helper fsValidatorHelper) error {
+	if err := fsConfig.Versioning.Validate(fsConfig.Provider); err != nil {
+		return &ValidationError{err: fmt.Sprintf("could not validate versioning config: %v", err)}
+	}
 	if fsConfig.Provider == vfs.S3FilesystemProvider {
 		if err := fsConfig.S3Config.Validate(); err != nil {
 			return &ValidationError{err: fmt.Sprintf("could not validate s3config: %v", err)}
@@ -1448,6 +2010,8 @@ func validateFilesystemConfig(fsConfig *vfs.Filesystem, helper fsValidatorHelper
 		fsConfig.AzBlobConfig = vfs.AzBlobFsConfig{}
 		fsConfig.CryptConfig = vfs.CryptFsConfig{}
 		fsConfig.SFTPConfig = vfs.SFTPFsConfig{}
+		fsConfig.PluginConfig = vfs.PluginFsConfig{}
+		fsConfig.SMBConfig = vfs.SMBFsConfig{}
 		return nil
 	} else if fsConfig.Provider == vfs.GCSFilesystemProvider {
 		if err := fsConfig.GCSConfig.Validate(helper.GetGCSCredentialsFilePath()); err != nil {
@@ -1457,6 +2021,8 @@ func validateFilesystemConfig(fsConfig *vfs.Filesystem, helper fsValidatorHelper
 		fsConfig.AzBlobConfig = vfs.AzBlobFsConfig{}
 		fsConfig.CryptConfig = vfs.CryptFsConfig{}
 		fsConfig.SFTPConfig = vfs.SFTPFsConfig{}
+		fsConfig.PluginConfig = vfs.PluginFsConfig{}
+		fsConfig.SMBConfig = vfs.SMBFsConfig{}
 		return nil
 	} else if fsConfig.Provider == vfs.AzureBlobFilesystemProvider {
 		if err := fsConfig.AzBlobConfig.Validate(); err != nil {
@@ -1469,6 +2035,8 @@ func validateFilesystemConfig(fsConfig *vfs.Filesystem, helper fsValidatorHelper
 		fsConfig.GCSConfig = vfs.GCSFsConfig{}
 		fsConfig.CryptConfig = vfs.CryptFsConfig{}
 		fsConfig.SFTPConfig = vfs.SFTPFsConfig{}
+		fsConfig.PluginConfig = vfs.PluginFsConfig{}
+		fsConfig.SMBConfig = vfs.SMBFsConfig{}
 		return nil
 	} else if fsConfig.Provider == vfs.CryptedFilesystemProvider {
 		if err := fsConfig.CryptConfig.Validate(); err != nil {
@@ -1481,6 +2049,8 @@ func validateFilesystemConfig(fsConfig *vfs.Filesystem, helper fsValidatorHelper
 		fsConfig.GCSConfig = vfs.GCSFsConfig{}
 		fsConfig.AzBlobConfig = vfs.AzBlobFsConfig{}
 		fsConfig.SFTPConfig = vfs.SFTPFsConfig{}
+		fsConfig.PluginConfig = vfs.PluginFsConfig{}
+		fsConfig.SMBConfig = vfs.SMBFsConfig{}
 		return nil
 	} else if fsConfig.Provider == vfs.SFTPFilesystemProvider {
 		if err := fsConfig.SFTPConfig.Validate(); err != nil {
@@ -1493,6 +2063,36 @@ func validateFilesystemConfig(fsConfig *vfs.Filesystem, helper fsValidatorHelper
 		fsConfig.GCSConfig = vfs.GCSFsConfig{}
 		fsConfig.AzBlobConfig = vfs.AzBlobFsConfig{}
 		fsConfig.CryptConfig = vfs.CryptFsConfig{}
+		fsConfig.PluginConfig = vfs.PluginFsConfig{}
+		fsConfig.SMBConfig = vfs.SMBFsConfig{}
+		return nil
+	} else if fsConfig.Provider == vfs.PluginFilesystemProvider {
+		if err := fsConfig.PluginConfig.Validate(); err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not validate plugin fs config: %v", err)}
+		}
+		if err := fsConfig.PluginConfig.EncryptCredentials(helper.GetEncrytionAdditionalData()); err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not encrypt plugin fs options: %v", err)}
+		}
+		fsConfig.S3Config = vfs.S3FsConfig{}
+		fsConfig.GCSConfig = vfs.GCSFsConfig{}
+		fsConfig.AzBlobConfig = vfs.AzBlobFsConfig{}
+		fsConfig.CryptConfig = vfs.CryptFsConfig{}
+		fsConfig.SFTPConfig = vfs.SFTPFsConfig{}
+		fsConfig.SMBConfig = vfs.SMBFsConfig{}
+		return nil
+	} else if fsConfig.Provider == vfs.SMBFilesystemProvider {
+		if err := fsConfig.SMBConfig.Validate(); err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not validate SMB fs config: %v", err)}
+		}
+		if err := fsConfig.SMBConfig.EncryptCredentials(helper.GetEncrytionAdditionalData()); err != nil {
+			return &ValidationError{err: fmt.Sprintf("could not encrypt SMB fs password: %v", err)}
+		}
+		fsConfig.S3Config = vfs.S3FsConfig{}
+		fsConfig.GCSConfig = vfs.GCSFsConfig{}
+		fsConfig.AzBlobConfig = vfs.AzBlobFsConfig{}
+		fsConfig.CryptConfig = vfs.CryptFsConfig{}
+		fsConfig.SFTPConfig = vfs.SFTPFsConfig{}
+		fsConfig.PluginConfig = vfs.PluginFsConfig{}
 		return nil
 	}
 	fsConfig.Provider = vfs.LocalFilesystemProvider
@@ -1501,6 +2101,8 @@ func validateFilesystemConfig(fsConfig *vfs.Filesystem, helper fsValidatorHelper
 	fsConfig.AzBlobConfig = vfs.AzBlobFsConfig{}
 	fsConfig.CryptConfig = vfs.CryptFsConfig{}
 	fsConfig.SFTPConfig = vfs.SFTPFsConfig{}
+	fsConfig.PluginConfig = vfs.PluginFsConfig{}
+	fsConfig.SMBConfig = vfs.SMBFsConfig{}
 	return nil
 }
 
@@ -1605,6 +2207,9 @@ func ValidateUser(user *User) error {
 	if err := validateFilters(user); err != nil {
 		return err
 	}
+	if err := user.checkPlaceholders(); err != nil {
+		return &ValidationError{err: err.Error()}
+	}
 	if err := saveGCSCredentials(&user.FsConfig, user); err != nil {
 		return err
 	}
@@ -1619,7 +2224,7 @@ func checkLoginConditions(user *User) error {
 		return fmt.Errorf("user %#v is expired, expiration timestamp: %v current timestamp: %v", user.Username,
 			user.ExpirationDate, utils.GetTimeAsMsSinceEpoch(time.Now()))
 	}
-	return nil
+	return user.checkPlaceholders()
 }
 
 func isPasswordOK(user *User, password string) (bool, error) {
@@ -1680,7 +2285,7 @@ func checkUserAndTLSCertificate(user *User, protocol string, tlsCert *x509.Certi
 	}
 }
 
-func checkUserAndPass(user *User, password, ip, protocol string) (User, error) {
+func checkUserAndPass(ctx context.Context, user *User, password, ip, protocol string) (User, error) {
 	err := checkLoginConditions(user)
 	if err != nil {
 		return *user, err
@@ -1689,7 +2294,7 @@ func checkUserAndPass(user *User, password, ip, protocol string) (User, error) {
 		return *user, errors.New("credentials cannot be null or empty")
 	}
 	if !user.Filters.Hooks.CheckPasswordDisabled {
-		hookResponse, err := executeCheckPasswordHook(user.Username, password, ip, protocol)
+		hookResponse, err := executeCheckPasswordHook(ctx, user.Username, password, ip, protocol)
 		if err != nil {
 			providerLog(logger.LevelDebug, "error executing check password hook: %v", err)
 			return *user, errors.New("unable to check credentials")
@@ -1869,6 +2474,28 @@ func getSSLMode() string {
 	return ""
 }
 
+func validateTLSConfig() error {
+	if config.ClientCert != "" && config.ClientKey == "" {
+		return errors.New("client_cert requires a client_key")
+	}
+	if config.ClientKey != "" && config.ClientCert == "" {
+		return errors.New("client_key requires a client_cert")
+	}
+	return nil
+}
+
+func resolveTLSPaths(basePath string) {
+	if config.RootCert != "" && !filepath.IsAbs(config.RootCert) {
+		config.RootCert = filepath.Join(basePath, config.RootCert)
+	}
+	if config.ClientCert != "" && !filepath.IsAbs(config.ClientCert) {
+		config.ClientCert = filepath.Join(basePath, config.ClientCert)
+	}
+	if config.ClientKey != "" && !filepath.IsAbs(config.ClientKey) {
+		config.ClientKey = filepath.Join(basePath, config.ClientKey)
+	}
+}
+
 func startAvailabilityTimer() {
 	availabilityTicker = time.NewTicker(30 * time.Second)
 	availabilityTickerDone = make(chan bool)
@@ -1921,7 +2548,7 @@ func validateKeyboardAuthResponse(response keyboardAuthHookResponse) error {
 
 func sendKeyboardAuthHTTPReq(url *url.URL, request keyboardAuthHookRequest) (keyboardAuthHookResponse, error) {
 	var response keyboardAuthHookResponse
-	httpClient := httpclient.GetHTTPClient()
+	httpClient := httpclient.GetRetryableHTTPClientForHook(httpclient.HookKeyboardInteractive)
 	reqAsJSON, err := json.Marshal(request)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error serializing keyboard interactive auth request: %v", err)
@@ -1940,7 +2567,9 @@ func sendKeyboardAuthHTTPReq(url *url.URL, request keyboardAuthHookRequest) (key
 	return response, err
 }
 
-func executeKeyboardInteractiveHTTPHook(user *User, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (int, error) {
+func executeKeyboardInteractiveHTTPHook(ctx context.Context, user *User, authHook string, client ssh.KeyboardInteractiveChallenge,
+	ip, protocol string,
+) (int, error) {
 	authResult := 0
 	var url *url.URL
 	url, err := url.Parse(authHook)
@@ -1967,7 +2596,7 @@ func executeKeyboardInteractiveHTTPHook(user *User, authHook string, client ssh.
 		if err = validateKeyboardAuthResponse(response); err != nil {
 			return authResult, err
 		}
-		answers, err := getKeyboardInteractiveAnswers(client, response, user, ip, protocol)
+		answers, err := getKeyboardInteractiveAnswers(ctx, client, response, user, ip, protocol)
 		if err != nil {
 			return authResult, err
 		}
@@ -1981,8 +2610,8 @@ func executeKeyboardInteractiveHTTPHook(user *User, authHook string, client ssh.
 	}
 }
 
-func getKeyboardInteractiveAnswers(client ssh.KeyboardInteractiveChallenge, response keyboardAuthHookResponse,
-	user *User, ip, protocol string) ([]string, error) {
+func getKeyboardInteractiveAnswers(ctx context.Context, client ssh.KeyboardInteractiveChallenge,
+	response keyboardAuthHookResponse, user *User, ip, protocol string) ([]string, error) {
 	questions := response.Questions
 	answers, err := client(user.Username, response.Instruction, questions, response.Echos)
 	if err != nil {
@@ -1995,7 +2624,7 @@ func getKeyboardInteractiveAnswers(client ssh.KeyboardInteractiveChallenge, resp
 		return answers, err
 	}
 	if len(answers) == 1 && response.CheckPwd > 0 {
-		_, err = checkUserAndPass(user, answers[0], ip, protocol)
+		_, err = checkUserAndPass(ctx, user, answers[0], ip, protocol)
 		providerLog(logger.LevelInfo, "interactive auth hook requested password validation for user %#v, validation error: %v",
 			user.Username, err)
 		if err != nil {
@@ -2006,9 +2635,9 @@ func getKeyboardInteractiveAnswers(client ssh.KeyboardInteractiveChallenge, resp
 	return answers, err
 }
 
-func handleProgramInteractiveQuestions(client ssh.KeyboardInteractiveChallenge, response keyboardAuthHookResponse,
-	user *User, stdin io.WriteCloser, ip, protocol string) error {
-	answers, err := getKeyboardInteractiveAnswers(client, response, user, ip, protocol)
+func handleProgramInteractiveQuestions(ctx context.Context, client ssh.KeyboardInteractiveChallenge,
+	response keyboardAuthHookResponse, user *User, stdin io.WriteCloser, ip, protocol string) error {
+	answers, err := getKeyboardInteractiveAnswers(ctx, client, response, user, ip, protocol)
 	if err != nil {
 		return err
 	}
@@ -2026,9 +2655,11 @@ func handleProgramInteractiveQuestions(client ssh.KeyboardInteractiveChallenge,
 	return nil
 }
 
-func executeKeyboardInteractiveProgram(user *User, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (int, error) {
+func executeKeyboardInteractiveProgram(ctx context.Context, user *User, authHook string, client ssh.KeyboardInteractiveChallenge,
+	ip, protocol string,
+) (int, error) {
 	authResult := 0
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, authHook)
 	cmd.Env = append(os.Environ(),
@@ -2066,7 +2697,7 @@ func executeKeyboardInteractiveProgram(user *User, authHook string, client ssh.K
 			break
 		}
 		go func() {
-			err := handleProgramInteractiveQuestions(client, response, user, stdin, ip, protocol)
+			err := handleProgramInteractiveQuestions(ctx, client, response, user, stdin, ip, protocol)
 			if err != nil {
 				once.Do(func() { terminateInteractiveAuthProgram(cmd, false) })
 			}
@@ -2084,14 +2715,18 @@ func executeKeyboardInteractiveProgram(user *User, authHook string, client ssh.K
 	return authResult, err
 }
 
-func doKeyboardInteractiveAuth(user *User, authHook string, client ssh.KeyboardInteractiveChallenge, ip, protocol string) (User, error) {
+func doKeyboardInteractiveAuth(ctx context.Context, user *User, authHook string, client ssh.KeyboardInteractiveChallenge,
+	ip, protocol string,
+) (User, error) {
 	var authResult int
 	var err error
+	ctx, span := tracing.Start(ctx, "hook.keyboard_interactive")
 	if strings.HasPrefix(authHook, "http") {
-		authResult, err = executeKeyboardInteractiveHTTPHook(user, authHook, client, ip, protocol)
+		authResult, err = executeKeyboardInteractiveHTTPHook(ctx, user, authHook, client, ip, protocol)
 	} else {
-		authResult, err = executeKeyboardInteractiveProgram(user, authHook, client, ip, protocol)
+		authResult, err = executeKeyboardInteractiveProgram(ctx, user, authHook, client, ip, protocol)
 	}
+	span.End(err)
 	if err != nil {
 		return *user, err
 	}
@@ -2143,7 +2778,7 @@ func getPasswordHookResponse(username, password, ip, protocol string) ([]byte, e
 		if err != nil {
 			return result, err
 		}
-		httpClient := httpclient.GetHTTPClient()
+		httpClient := httpclient.GetRetryableHTTPClientForHook(httpclient.HookCheckPassword)
 		resp, err := httpClient.Post(url.String(), "application/json", bytes.NewBuffer(reqAsJSON))
 		if err != nil {
 			providerLog(logger.LevelWarn, "error getting check password hook response: %v", err)
@@ -2167,7 +2802,7 @@ func getPasswordHookResponse(username, password, ip, protocol string) ([]byte, e
 	return cmd.Output()
 }
 
-func executeCheckPasswordHook(username, password, ip, protocol string) (checkPasswordResponse, error) {
+func executeCheckPasswordHook(ctx context.Context, username, password, ip, protocol string) (checkPasswordResponse, error) {
 	var response checkPasswordResponse
 
 	if !isCheckPasswordHookDefined(protocol) {
@@ -2176,7 +2811,9 @@ func executeCheckPasswordHook(username, password, ip, protocol string) (checkPas
 	}
 
 	startTime := time.Now()
+	_, span := tracing.Start(ctx, "hook.check_password")
 	out, err := getPasswordHookResponse(username, password, ip, protocol)
+	span.End(err)
 	providerLog(logger.LevelDebug, "check password hook executed, error: %v, elapsed: %v", err, time.Since(startTime))
 	if err != nil {
 		return response, err
@@ -2199,7 +2836,7 @@ func getPreLoginHookResponse(loginMethod, ip, protocol string, userAsJSON []byte
 		q.Add("ip", ip)
 		q.Add("protocol", protocol)
 		url.RawQuery = q.Encode()
-		httpClient := httpclient.GetHTTPClient()
+		httpClient := httpclient.GetRetryableHTTPClientForHook(httpclient.HookPreLogin)
 		resp, err := httpClient.Post(url.String(), "application/json", bytes.NewBuffer(userAsJSON))
 		if err != nil {
 			providerLog(logger.LevelWarn, "error getting pre-login hook response: %v", err)
@@ -2226,8 +2863,8 @@ func getPreLoginHookResponse(loginMethod, ip, protocol string, userAsJSON []byte
 	return cmd.Output()
 }
 
-func executePreLoginHook(username, loginMethod, ip, protocol string) (User, error) {
-	u, userAsJSON, err := getUserAndJSONForHook(username)
+func executePreLoginHook(ctx context.Context, username, loginMethod, ip, protocol string) (User, error) {
+	u, userAsJSON, err := getUserAndJSONForHook(ctx, username)
 	if err != nil {
 		return u, err
 	}
@@ -2235,7 +2872,9 @@ func executePreLoginHook(username, loginMethod, ip, protocol string) (User, erro
 		return u, nil
 	}
 	startTime := time.Now()
+	_, span := tracing.Start(ctx, "hook.pre_login")
 	out, err := getPreLoginHookResponse(loginMethod, ip, protocol, userAsJSON)
+	span.End(err)
 	if err != nil {
 		return u, fmt.Errorf("pre-login hook error: %v, elapsed %v", err, time.Since(startTime))
 	}
@@ -2255,6 +2894,7 @@ func executePreLoginHook(username, loginMethod, ip, protocol string) (User, erro
 	userUsedQuotaFiles := u.UsedQuotaFiles
 	userLastQuotaUpdate := u.LastQuotaUpdate
 	userLastLogin := u.LastLogin
+	userVersion := u.Version
 	err = json.Unmarshal(out, &u)
 	if err != nil {
 		return u, fmt.Errorf("invalid pre-login hook response %#v, error: %v", string(out), err)
@@ -2264,29 +2904,40 @@ func executePreLoginHook(username, loginMethod, ip, protocol string) (User, erro
 	u.UsedQuotaFiles = userUsedQuotaFiles
 	u.LastQuotaUpdate = userLastQuotaUpdate
 	u.LastLogin = userLastLogin
+	// the hook response replaces the stored user so no version check is needed
+	u.Version = userVersion
+	var query *providerQuery
 	if userID == 0 {
+		query = startQuery(ctx, "add_user")
 		err = provider.addUser(&u)
 	} else {
+		prevObject := getActionPreviousObject(operationUpdate, ActionObjectUser, u.Username)
+		query = startQuery(ctx, "update_user")
 		err = provider.updateUser(&u)
 		if err == nil {
 			webDAVUsersCache.swap(&u)
 			if u.Password != userPwd {
 				cachedPasswords.Remove(username)
 			}
+			executeAction(operationUpdate, ActionObjectUser, u.Username, prevObject)
 		}
 	}
+	query.end(err)
 	if err != nil {
 		return u, err
 	}
 	providerLog(logger.LevelDebug, "user %#v added/updated from pre-login hook response, id: %v", username, userID)
 	if userID == 0 {
-		return provider.userExists(username)
+		executeAction(operationAdd, ActionObjectUser, u.Username, nil)
+		return userExistsWithContext(ctx, username)
 	}
 	return u, nil
 }
 
-// ExecutePostLoginHook executes the post login hook if defined
+// ExecutePostLoginHook logs the login attempt to the audit log and executes
+// the post login hook if defined
 func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err error) {
+	logger.AuditLogin(user.Username, ip, loginMethod, protocol, err)
 	if config.PostLoginHook == "" {
 		return
 	}
@@ -2325,12 +2976,14 @@ func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err erro
 
 			startTime := time.Now()
 			respCode := 0
-			httpClient := httpclient.GetRetraybleHTTPClient()
+			span := startPostLoginHookSpan(user.Username, loginMethod, ip, protocol)
+			httpClient := httpclient.GetRetryableHTTPClientForHook(httpclient.HookPostLogin)
 			resp, err := httpClient.Post(url.String(), "application/json", bytes.NewBuffer(userAsJSON))
 			if err == nil {
 				respCode = resp.StatusCode
 				resp.Body.Close()
 			}
+			span.End(err)
 			providerLog(logger.LevelDebug, "post login hook executed, response code: %v, elapsed: %v err: %v",
 				respCode, time.Since(startTime), err)
 			return
@@ -2345,11 +2998,44 @@ func ExecutePostLoginHook(user *User, loginMethod, ip, protocol string, err erro
 			fmt.Sprintf("SFTPGO_LOGIND_STATUS=%v", status),
 			fmt.Sprintf("SFTPGO_LOGIND_PROTOCOL=%v", protocol))
 		startTime := time.Now()
+		span := startPostLoginHookSpan(user.Username, loginMethod, ip, protocol)
 		err = cmd.Run()
+		span.End(err)
 		providerLog(logger.LevelDebug, "post login hook executed, elapsed %v err: %v", time.Since(startTime), err)
 	}()
 }
 
+// startPostLoginHookSpan starts a span for the post-login hook. The hook is
+// executed asynchronously so it is not a child of the login span
+func startPostLoginHookSpan(username, loginMethod, ip, protocol string) *tracing.Span {
+	_, span := tracing.Start(context.Background(), "hook.post_login", tracing.String("username", username),
+		tracing.String("login_method", loginMethod), tracing.String("ip", ip), tracing.String("protocol", protocol))
+	return span
+}
+
+// isExternalAuthConfigured returns true if the external authentication hook
+// or an auth plugin is configured for the given scope
+func isExternalAuthConfigured(scope int) bool {
+	if config.ExternalAuthHook != "" && (config.ExternalAuthScope == 0 || config.ExternalAuthScope&scope != 0) {
+		return true
+	}
+	return plugin.Handler.HasAuthScope(scope)
+}
+
+// getExternalAuthScope returns the external authentication scope for the given credentials
+func getExternalAuthScope(pkey, keyboardInteractive string, cert *x509.Certificate) int {
+	switch {
+	case keyboardInteractive != "":
+		return 4
+	case pkey != "":
+		return 2
+	case cert != nil:
+		return 8
+	default:
+		return 1
+	}
+}
+
 func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip, protocol string, cert *x509.Certificate, userAsJSON []byte) ([]byte, error) {
 	var tlsCert string
 	if cert != nil {
@@ -2359,6 +3045,18 @@ func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip,
 			return nil, err
 		}
 	}
+	if scope := getExternalAuthScope(pkey, keyboardInteractive, cert); plugin.Handler.HasAuthScope(scope) {
+		return plugin.Handler.Authenticate(scope, &plugin.AuthRequest{
+			Username:            username,
+			IP:                  ip,
+			Protocol:            protocol,
+			Password:            password,
+			PublicKey:           pkey,
+			KeyboardInteractive: keyboardInteractive != "",
+			TLSCert:             tlsCert,
+			User:                userAsJSON,
+		})
+	}
 	if strings.HasPrefix(config.ExternalAuthHook, "http") {
 		var url *url.URL
 		var result []byte
@@ -2367,7 +3065,7 @@ func getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip,
 			providerLog(logger.LevelWarn, "invalid url for external auth hook %#v, error: %v", config.ExternalAuthHook, err)
 			return result, err
 		}
-		httpClient := httpclient.GetHTTPClient()
+		httpClient := httpclient.GetRetryableHTTPClientForHook(httpclient.HookExternalAuth)
 		authRequest := make(map[string]string)
 		authRequest["username"] = username
 		authRequest["ip"] = ip
@@ -2421,10 +3119,12 @@ func updateUserFromExtAuthResponse(user *User, password, pkey string) {
 	}
 }
 
-func doExternalAuth(username, password string, pubKey []byte, keyboardInteractive, ip, protocol string, tlsCert *x509.Certificate) (User, error) {
+func doExternalAuth(ctx context.Context, username, password string, pubKey []byte, keyboardInteractive, ip, protocol string,
+	tlsCert *x509.Certificate,
+) (User, error) {
 	var user User
 
-	u, userAsJSON, err := getUserAndJSONForHook(username)
+	u, userAsJSON, err := getUserAndJSONForHook(ctx, username)
 	if err != nil {
 		return user, err
 	}
@@ -2439,7 +3139,9 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 	}
 
 	startTime := time.Now()
+	_, span := tracing.Start(ctx, "hook.external_auth")
 	out, err := getExternalAuthResponse(username, password, pkey, keyboardInteractive, ip, protocol, tlsCert, userAsJSON)
+	span.End(err)
 	if err != nil {
 		return user, fmt.Errorf("external auth error: %v, elapsed: %v", err, time.Since(startTime))
 	}
@@ -2466,7 +3168,7 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 	// returns "user" in both cases, so we use the username returned from
 	// external auth and not the one used to login
 	if user.Username != username {
-		u, err = provider.userExists(user.Username)
+		u, err = userExistsWithContext(ctx, user.Username)
 	}
 	if u.ID > 0 && err == nil {
 		user.ID = u.ID
@@ -2474,23 +3176,31 @@ func doExternalAuth(username, password string, pubKey []byte, keyboardInteractiv
 		user.UsedQuotaFiles = u.UsedQuotaFiles
 		user.LastQuotaUpdate = u.LastQuotaUpdate
 		user.LastLogin = u.LastLogin
+		user.Version = u.Version
+		prevObject := getActionPreviousObject(operationUpdate, ActionObjectUser, user.Username)
+		query := startQuery(ctx, "update_user")
 		err = provider.updateUser(&user)
+		query.end(err)
 		if err == nil {
 			webDAVUsersCache.swap(&user)
 			cachedPasswords.Add(user.Username, password)
+			executeAction(operationUpdate, ActionObjectUser, user.Username, prevObject)
 		}
 		return user, err
 	}
+	query := startQuery(ctx, "add_user")
 	err = provider.addUser(&user)
+	query.end(err)
 	if err != nil {
 		return user, err
 	}
-	return provider.userExists(user.Username)
+	executeAction(operationAdd, ActionObjectUser, user.Username, nil)
+	return userExistsWithContext(ctx, user.Username)
 }
 
-func getUserAndJSONForHook(username string) (User, []byte, error) {
+func getUserAndJSONForHook(ctx context.Context, username string) (User, []byte, error) {
 	var userAsJSON []byte
-	u, err := provider.userExists(username)
+	u, err := userExistsWithContext(ctx, username)
 	if err != nil {
 		if _, ok := err.(*RecordNotFoundError); !ok {
 			return u, userAsJSON, err
@@ -2510,75 +3220,3 @@ func getUserAndJSONForHook(username string) (User, []byte, error) {
 func providerLog(level logger.LogLevel, format string, v ...interface{}) {
 	logger.Log(level, logSender, "", format, v...)
 }
-
-func executeNotificationCommand(operation string, commandArgs []string, userAsJSON []byte) error {
-	if !filepath.IsAbs(config.Actions.Hook) {
-		err := fmt.Errorf("invalid notification command %#v", config.Actions.Hook)
-		logger.Warn(logSender, "", "unable to execute notification command: %v", err)
-		return err
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	cmd := exec.CommandContext(ctx, config.Actions.Hook, commandArgs...)
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("SFTPGO_USER_ACTION=%v", operation),
-		fmt.Sprintf("SFTPGO_USER=%v", string(userAsJSON)))
-
-	startTime := time.Now()
-	err := cmd.Run()
-	providerLog(logger.LevelDebug, "executed command %#v with arguments: %+v, elapsed: %v, error: %v",
-		config.Actions.Hook, commandArgs, time.Since(startTime), err)
-	return err
-}
-
-func executeAction(operation string, user *User) {
-	if !utils.IsStringInSlice(operation, config.Actions.ExecuteOn) {
-		return
-	}
-	if config.Actions.Hook == "" {
-		return
-	}
-
-	go func() {
-		if operation != operationDelete {
-			var err error
-			u, err := provider.userExists(user.Username)
-			if err != nil {
-				providerLog(logger.LevelWarn, "unable to get the user to notify for operation %#v: %v", operation, err)
-				return
-			}
-			user = &u
-		}
-		user.PrepareForRendering()
-		userAsJSON, err := json.Marshal(user)
-		if err != nil {
-			providerLog(logger.LevelWarn, "unable to serialize user as JSON for operation %#v: %v", operation, err)
-			return
-		}
-		if strings.HasPrefix(config.Actions.Hook, "http") {
-			var url *url.URL
-			url, err := url.Parse(config.Actions.Hook)
-			if err != nil {
-				providerLog(logger.LevelWarn, "Invalid http_notification_url %#v for operation %#v: %v", config.Actions.Hook, operation, err)
-				return
-			}
-			q := url.Query()
-			q.Add("action", operation)
-			url.RawQuery = q.Encode()
-			startTime := time.Now()
-			httpClient := httpclient.GetRetraybleHTTPClient()
-			resp, err := httpClient.Post(url.String(), "application/json", bytes.NewBuffer(userAsJSON))
-			respCode := 0
-			if err == nil {
-				respCode = resp.StatusCode
-				resp.Body.Close()
-			}
-			providerLog(logger.LevelDebug, "notified operation %#v to URL: %v status code: %v, elapsed: %v err: %v",
-				operation, url.String(), respCode, time.Since(startTime), err)
-		} else {
-			executeNotificationCommand(operation, user.getNotificationFieldsAsSlice(operation), userAsJSON) //nolint:errcheck // the error is used in test cases only
-		}
-	}()
-}
@@ -0,0 +1,190 @@
+package dataprovider
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// DefenderEntry defines a host tracked by the defender
+type DefenderEntry struct {
+	IP    string `json:"ip"`
+	Score int    `json:"score"`
+	// ban expiration as unix timestamp in milliseconds, 0 means not banned
+	BanTime int64 `json:"ban_time"`
+}
+
+// GetBanTime returns the ban expiration or nil if the host is not banned
+func (d *DefenderEntry) GetBanTime() *time.Time {
+	if d.BanTime <= utils.GetTimeAsMsSinceEpoch(time.Now()) {
+		return nil
+	}
+	banTime := utils.GetTimeFromMsecSinceEpoch(d.BanTime)
+	return &banTime
+}
+
+// defenderEvent and defenderHost are used to store the defender state
+// within the memory and the bolt providers
+type defenderEvent struct {
+	DateTime int64 `json:"date_time"`
+	Score    int   `json:"score"`
+}
+
+type defenderHost struct {
+	IP        string          `json:"ip"`
+	BanTime   int64           `json:"ban_time"`
+	UpdatedAt int64           `json:"updated_at"`
+	Events    []defenderEvent `json:"events,omitempty"`
+}
+
+func (h *defenderHost) addEvent(score int) {
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	h.Events = append(h.Events, defenderEvent{
+		DateTime: now,
+		Score:    score,
+	})
+	h.UpdatedAt = now
+}
+
+// removeEventsBefore removes the events older than from, expressed as unix timestamp in milliseconds
+func (h *defenderHost) removeEventsBefore(from int64) {
+	events := h.Events[:0]
+	for _, ev := range h.Events {
+		if ev.DateTime >= from {
+			events = append(events, ev)
+		}
+	}
+	h.Events = events
+}
+
+func (h *defenderHost) isBanned() bool {
+	return h.BanTime > utils.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+func (h *defenderHost) getEntry(from int64) DefenderEntry {
+	entry := DefenderEntry{
+		IP:      h.IP,
+		BanTime: h.BanTime,
+	}
+	for _, ev := range h.Events {
+		if ev.DateTime >= from {
+			entry.Score += ev.Score
+		}
+	}
+	return entry
+}
+
+// GetDefenderHostByIP returns the defender entry for the given IP. Only the
+// events occurred after from, expressed as unix timestamp in milliseconds,
+// are included in the score
+func GetDefenderHostByIP(ip string, from int64) (DefenderEntry, error) {
+	return provider.getDefenderHostByIP(ip, from)
+}
+
+// IsDefenderHostBanned returns the defender entry for the given IP if it is banned
+// and a RecordNotFoundError otherwise
+func IsDefenderHostBanned(ip string) (DefenderEntry, error) {
+	return provider.isDefenderHostBanned(ip)
+}
+
+// UpdateDefenderBanTime increments the ban time for the given IP by the specified minutes
+func UpdateDefenderBanTime(ip string, minutes int) error {
+	return provider.updateDefenderBanTime(ip, minutes)
+}
+
+// DeleteDefenderHost removes the given IP, and its events, from the defender hosts
+func DeleteDefenderHost(ip string) error {
+	return provider.deleteDefenderHost(ip)
+}
+
+// AddDefenderEvent adds an event with the given score for the specified IP
+func AddDefenderEvent(ip string, score int) error {
+	return provider.addDefenderEvent(ip, score)
+}
+
+// SetDefenderBanTime sets the ban time for the given IP, expressed as unix
+// timestamp in milliseconds, and removes the host events
+func SetDefenderBanTime(ip string, banTime int64) error {
+	return provider.setDefenderBanTime(ip, banTime)
+}
+
+// CleanupDefender removes the events older than from, expressed as unix timestamp
+// in milliseconds, and the hosts that are not banned and have no events
+func CleanupDefender(from int64) error {
+	return provider.cleanupDefender(from)
+}
+
+// Supported defender list types
+const (
+	DefenderSafeList = iota + 1
+	DefenderBlockList
+)
+
+// DefenderListEntry defines an IP address or a CIDR network to never or always ban.
+// These entries are added to the ones loaded from the safe and block list files
+type DefenderListEntry struct {
+	// 1 safe list, 2 block list
+	Type int `json:"type"`
+	// IP address or CIDR network, for example "192.168.1.1" or "192.168.1.0/24"
+	Network     string `json:"network"`
+	Description string `json:"description,omitempty"`
+	// creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+}
+
+// getKey returns the key used to store the entry within the memory and bolt providers
+func (e *DefenderListEntry) getKey() string {
+	return fmt.Sprintf("%v:%v", e.Type, e.Network)
+}
+
+func (e *DefenderListEntry) validate() error {
+	if e.Type != DefenderSafeList && e.Type != DefenderBlockList {
+		return &ValidationError{err: fmt.Sprintf("invalid defender list type: %v", e.Type)}
+	}
+	e.Network = strings.TrimSpace(e.Network)
+	if strings.Contains(e.Network, "/") {
+		_, network, err := net.ParseCIDR(e.Network)
+		if err != nil {
+			return &ValidationError{err: fmt.Sprintf("invalid CIDR network %#v", e.Network)}
+		}
+		e.Network = network.String()
+	} else {
+		ip := net.ParseIP(e.Network)
+		if ip == nil {
+			return &ValidationError{err: fmt.Sprintf("invalid IP address %#v", e.Network)}
+		}
+		e.Network = ip.String()
+	}
+	if len(e.Description) > 512 {
+		return &ValidationError{err: "the description cannot be longer than 512 characters"}
+	}
+	return nil
+}
+
+// GetDefenderListEntries returns the safe and block list entries stored within the data provider
+func GetDefenderListEntries() ([]DefenderListEntry, error) {
+	if provider == nil {
+		return nil, ErrProviderNotInitialized
+	}
+	return provider.getDefenderListEntries()
+}
+
+// AddDefenderListEntry adds a safe or block list entry to the data provider
+func AddDefenderListEntry(entry *DefenderListEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	entry.CreatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	return provider.addDefenderListEntry(entry)
+}
+
+// DeleteDefenderListEntry removes the given safe or block list entry from the data provider
+func DeleteDefenderListEntry(entry *DefenderListEntry) error {
+	if err := entry.validate(); err != nil {
+		return err
+	}
+	return provider.deleteDefenderListEntry(entry)
+}
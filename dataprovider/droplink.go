@@ -0,0 +1,142 @@
+package dataprovider
+
+import (
+	"encoding/base64"
+	"fmt"
+	"time"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// DropLink defines an upload-only public link. Anonymous visitors can upload
+// files, within the configured limits, inside the link directory. The uploads
+// are attributed to the user owning the link for quota and events
+type DropLink struct {
+	// unique random identifier, it is part of the public URL
+	LinkID string `json:"id"`
+	// owner username
+	Username    string `json:"username"`
+	Description string `json:"description,omitempty"`
+	// virtual directory, for the owner, where the files are uploaded
+	Path string `json:"path"`
+	// maximum size, in bytes, for all the files uploaded using this link. 0 means unlimited
+	MaxSize int64 `json:"max_size"`
+	// maximum number of files that can be uploaded using this link. 0 means unlimited
+	MaxFiles int `json:"max_files"`
+	// size, in bytes, of the files uploaded using this link
+	UploadedSize int64 `json:"uploaded_size"`
+	// number of files uploaded using this link
+	UploadedFiles int `json:"uploaded_files"`
+	// expiration as unix timestamp in milliseconds. 0 means no expiration
+	ExpiresAt int64 `json:"expires_at"`
+	// creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// last upload as unix timestamp in milliseconds
+	LastUseAt int64 `json:"last_use_at"`
+}
+
+// IsExpired returns true if the link is expired
+func (l *DropLink) IsExpired() bool {
+	return l.ExpiresAt > 0 && l.ExpiresAt < utils.GetTimeAsMsSinceEpoch(time.Now())
+}
+
+// HasFilesLimitReached returns true if no more files can be uploaded using this link
+func (l *DropLink) HasFilesLimitReached() bool {
+	return l.MaxFiles > 0 && l.UploadedFiles >= l.MaxFiles
+}
+
+// GetRemainingSize returns the size, in bytes, that can still be uploaded
+// using this link. -1 means unlimited
+func (l *DropLink) GetRemainingSize() int64 {
+	if l.MaxSize <= 0 {
+		return -1
+	}
+	if l.UploadedSize >= l.MaxSize {
+		return 0
+	}
+	return l.MaxSize - l.UploadedSize
+}
+
+// GetOwner returns the user owning this link. An error is returned if the
+// owner is not allowed to receive uploads using this link
+func (l *DropLink) GetOwner() (User, error) {
+	user, err := provider.userExists(l.Username)
+	if err != nil {
+		return user, err
+	}
+	if err := checkLoginConditions(&user); err != nil {
+		return user, err
+	}
+	if !user.CanManageDropLinks() {
+		return user, fmt.Errorf("drop links are disabled for user %#v", user.Username)
+	}
+	if !user.HasPerm(PermUpload, l.Path) {
+		return user, fmt.Errorf("user %#v does not have the permission to upload to %#v", user.Username, l.Path)
+	}
+	return user, nil
+}
+
+func (l *DropLink) validate(user *User) error {
+	if l.LinkID == "" {
+		return NewValidationError("the link id is mandatory")
+	}
+	if len(l.Description) > 512 {
+		return NewValidationError("the description cannot be longer than 512 characters")
+	}
+	if l.Path == "" {
+		return NewValidationError("the link path is mandatory")
+	}
+	l.Path = utils.CleanPath(l.Path)
+	if !user.HasPerm(PermUpload, l.Path) {
+		return NewValidationError(fmt.Sprintf("user %#v does not have the permission to upload to %#v", user.Username, l.Path))
+	}
+	if l.MaxSize < 0 {
+		return NewValidationError(fmt.Sprintf("invalid max size: %v", l.MaxSize))
+	}
+	if l.MaxFiles < 0 {
+		return NewValidationError(fmt.Sprintf("invalid max files: %v", l.MaxFiles))
+	}
+	if l.ExpiresAt < 0 || l.IsExpired() {
+		return NewValidationError("the expiration date must be in the future")
+	}
+	return nil
+}
+
+// AddDropLink adds a new drop link for the given user. The link id, the
+// creation time and the usage counters are generated
+func AddDropLink(link *DropLink, user *User) error {
+	link.LinkID = base64.RawURLEncoding.EncodeToString(utils.GenerateRandomBytes(24))
+	link.Username = user.Username
+	link.UploadedSize = 0
+	link.UploadedFiles = 0
+	link.CreatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	link.LastUseAt = 0
+	if err := link.validate(user); err != nil {
+		return err
+	}
+	return provider.addDropLink(link)
+}
+
+// DropLinkExists returns the drop link with the given id if it exists
+func DropLinkExists(linkID string) (DropLink, error) {
+	if linkID == "" {
+		return DropLink{}, &RecordNotFoundError{err: "drop link with an empty id does not exist"}
+	}
+	return provider.dropLinkExists(linkID)
+}
+
+// GetDropLinks returns the drop links for the given user
+func GetDropLinks(username string) ([]DropLink, error) {
+	return provider.getDropLinks(username)
+}
+
+// DeleteDropLink deletes the drop link with the given id owned by the given user
+func DeleteDropLink(linkID, username string) error {
+	return provider.deleteDropLink(linkID, username)
+}
+
+// UpdateDropLinkUsage adds the given number of files and size to the usage
+// counters for the drop link with the given id
+func UpdateDropLinkUsage(linkID string, filesAdd int, sizeAdd int64) error {
+	return provider.updateDropLinkUsage(linkID, filesAdd, sizeAdd)
+}
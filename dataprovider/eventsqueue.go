@@ -0,0 +1,66 @@
+package dataprovider
+
+import (
+	"time"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// QueuedEvent defines an event waiting to be delivered.
+// The payload is opaque for the data provider
+type QueuedEvent struct {
+	ID      int64  `json:"id"`
+	Payload string `json:"payload"`
+	// creation time as unix timestamp in milliseconds
+	CreatedAt int64 `json:"created_at"`
+	// owner of the last claim, an event is claimed until the lease expires
+	ClaimedBy string `json:"claimed_by,omitempty"`
+	// lease expiration as unix timestamp in milliseconds
+	LeaseExpires int64 `json:"lease_expires,omitempty"`
+}
+
+// AddQueuedEvent adds an event with the given payload to the events queue
+func AddQueuedEvent(payload string) error {
+	if provider == nil {
+		return ErrProviderNotInitialized
+	}
+	return provider.addQueuedEvent(payload, utils.GetTimeAsMsSinceEpoch(time.Now()))
+}
+
+// GetQueuedEvents returns, at most, limit events from the events queue,
+// the oldest ones first
+func GetQueuedEvents(limit int) ([]QueuedEvent, error) {
+	if provider == nil {
+		return nil, ErrProviderNotInitialized
+	}
+	return provider.getQueuedEvents(limit)
+}
+
+// ClaimQueuedEvents returns, at most, limit events from the events queue, the
+// oldest ones first. Only the events not claimed or with an expired lease are
+// returned, they are claimed by the given owner for the specified duration
+func ClaimQueuedEvents(owner string, limit int, lease time.Duration) ([]QueuedEvent, error) {
+	if provider == nil {
+		return nil, ErrProviderNotInitialized
+	}
+	now := time.Now()
+	return provider.claimQueuedEvents(owner, limit, utils.GetTimeAsMsSinceEpoch(now),
+		utils.GetTimeAsMsSinceEpoch(now.Add(lease)))
+}
+
+// RenewQueuedEventsLease extends, for the specified duration, the lease for the
+// events claimed by the given owner
+func RenewQueuedEventsLease(owner string, lease time.Duration) error {
+	if provider == nil {
+		return ErrProviderNotInitialized
+	}
+	return provider.renewQueuedEventsLease(owner, utils.GetTimeAsMsSinceEpoch(time.Now().Add(lease)))
+}
+
+// DeleteQueuedEvent removes the event with the given id from the events queue
+func DeleteQueuedEvent(id int64) error {
+	if provider == nil {
+		return ErrProviderNotInitialized
+	}
+	return provider.deleteQueuedEvent(id)
+}
@@ -1,6 +1,7 @@
 package dataprovider
 
 import (
+	"context"
 	"crypto/x509"
 	"errors"
 	"fmt"
@@ -36,6 +37,22 @@ type memoryProviderHandle struct {
 	admins map[string]Admin
 	// slice with ordered admins
 	adminsUsernames []string
+	// map for defender hosts, the IP is the key
+	defenderHosts map[string]defenderHost
+	// map for defender safe and block list entries
+	defenderLists map[string]DefenderListEntry
+	// queued events, ordered by id
+	eventsQueue []QueuedEvent
+	// last assigned queued event id
+	eventsQueueSeq int64
+	// map for shared connections, "node_id:connection_id" is the key
+	sharedConns map[string]SharedConnection
+	// map for drop links, the link id is the key
+	dropLinks map[string]DropLink
+	// map for approval requests, the request id is the key
+	approvals map[string]ApprovalRequest
+	// slice with the approval request ids ordered by creation time
+	approvalIDs []string
 }
 
 // MemoryProvider auth provider for a memory store
@@ -60,6 +77,13 @@ func initializeMemoryProvider(basePath string) {
 			vfoldersNames:   []string{},
 			admins:          make(map[string]Admin),
 			adminsUsernames: []string{},
+			defenderHosts:   make(map[string]defenderHost),
+			defenderLists:   make(map[string]DefenderListEntry),
+			eventsQueue:     []QueuedEvent{},
+			sharedConns:     make(map[string]SharedConnection),
+			dropLinks:       make(map[string]DropLink),
+			approvals:       make(map[string]ApprovalRequest),
+			approvalIDs:     []string{},
 			configFile:      configFile,
 		},
 	}
@@ -101,7 +125,7 @@ func (p *MemoryProvider) validateUserAndTLSCert(username, protocol string, tlsCe
 	return checkUserAndTLSCertificate(&user, protocol, tlsCert)
 }
 
-func (p *MemoryProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
+func (p *MemoryProvider) validateUserAndPass(ctx context.Context, username, password, ip, protocol string) (User, error) {
 	var user User
 	if password == "" {
 		return user, errors.New("credentials cannot be null or empty")
@@ -111,7 +135,7 @@ func (p *MemoryProvider) validateUserAndPass(username, password, ip, protocol st
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, err
 	}
-	return checkUserAndPass(&user, password, ip, protocol)
+	return checkUserAndPass(ctx, &user, password, ip, protocol)
 }
 
 func (p *MemoryProvider) validateUserAndPubKey(username string, pubKey []byte) (User, string, error) {
@@ -214,6 +238,7 @@ func (p *MemoryProvider) addUser(user *User) error {
 	user.UsedQuotaSize = 0
 	user.UsedQuotaFiles = 0
 	user.LastLogin = 0
+	user.Version = 0
 	user.VirtualFolders = p.joinVirtualFoldersFields(user)
 	p.dbHandle.users[user.Username] = user.getACopy()
 	p.dbHandle.usernames = append(p.dbHandle.usernames, user.Username)
@@ -239,6 +264,9 @@ func (p *MemoryProvider) updateUser(user *User) error {
 	if err != nil {
 		return err
 	}
+	if u.Version != user.Version {
+		return ErrVersionMismatch
+	}
 	for _, oldFolder := range u.VirtualFolders {
 		p.removeUserFromFolderMapping(oldFolder.Name, u.Username)
 	}
@@ -248,6 +276,7 @@ func (p *MemoryProvider) updateUser(user *User) error {
 	user.UsedQuotaFiles = u.UsedQuotaFiles
 	user.LastLogin = u.LastLogin
 	user.ID = u.ID
+	user.Version = u.Version + 1
 	// pre-login and external auth hook will use the passed *user so save a copy
 	p.dbHandle.users[user.Username] = user.getACopy()
 	return nil
@@ -267,6 +296,11 @@ func (p *MemoryProvider) deleteUser(user *User) error {
 		p.removeUserFromFolderMapping(oldFolder.Name, u.Username)
 	}
 	delete(p.dbHandle.users, user.Username)
+	for linkID, link := range p.dbHandle.dropLinks {
+		if link.Username == user.Username {
+			delete(p.dbHandle.dropLinks, linkID)
+		}
+	}
 	// this could be more efficient
 	p.dbHandle.usernames = make([]string, 0, len(p.dbHandle.users))
 	for username := range p.dbHandle.users {
@@ -385,6 +419,7 @@ func (p *MemoryProvider) addAdmin(admin *Admin) error {
 		return fmt.Errorf("admin %#v already exists", admin.Username)
 	}
 	admin.ID = p.getNextAdminID()
+	admin.Version = 0
 	p.dbHandle.admins[admin.Username] = admin.getACopy()
 	p.dbHandle.adminsUsernames = append(p.dbHandle.adminsUsernames, admin.Username)
 	sort.Strings(p.dbHandle.adminsUsernames)
@@ -405,7 +440,11 @@ func (p *MemoryProvider) updateAdmin(admin *Admin) error {
 	if err != nil {
 		return err
 	}
+	if a.Version != admin.Version {
+		return ErrVersionMismatch
+	}
 	admin.ID = a.ID
+	admin.Version = a.Version + 1
 	p.dbHandle.admins[admin.Username] = admin.getACopy()
 	return nil
 }
@@ -588,6 +627,8 @@ func (p *MemoryProvider) addOrUpdateFolderInternal(baseFolder *vfs.BaseVirtualFo
 		folder.MappedPath = baseFolder.MappedPath
 		folder.Description = baseFolder.Description
 		folder.FsConfig = baseFolder.FsConfig.GetACopy()
+		// the folder definition embedded in the user is applied as is
+		folder.Version++
 		if !utils.IsStringInSlice(username, folder.Users) {
 			folder.Users = append(folder.Users, username)
 		}
@@ -601,6 +642,7 @@ func (p *MemoryProvider) addOrUpdateFolderInternal(baseFolder *vfs.BaseVirtualFo
 		folder.UsedQuotaFiles = usedQuotaFiles
 		folder.LastQuotaUpdate = lastQuotaUpdate
 		folder.Users = []string{username}
+		folder.Version = 0
 		p.updateFoldersMappingInternal(folder)
 		return folder, nil
 	}
@@ -690,6 +732,7 @@ func (p *MemoryProvider) addFolder(folder *vfs.BaseVirtualFolder) error {
 	}
 	folder.ID = p.getNextFolderID()
 	folder.Users = nil
+	folder.Version = 0
 	p.dbHandle.vfolders[folder.Name] = folder.GetACopy()
 	p.dbHandle.vfoldersNames = append(p.dbHandle.vfoldersNames, folder.Name)
 	sort.Strings(p.dbHandle.vfoldersNames)
@@ -711,7 +754,11 @@ func (p *MemoryProvider) updateFolder(folder *vfs.BaseVirtualFolder) error {
 	if err != nil {
 		return err
 	}
+	if f.Version != folder.Version {
+		return ErrVersionMismatch
+	}
 	folder.ID = f.ID
+	folder.Version = f.Version + 1
 	folder.LastQuotaUpdate = f.LastQuotaUpdate
 	folder.UsedQuotaFiles = f.UsedQuotaFiles
 	folder.UsedQuotaSize = f.UsedQuotaSize
@@ -800,6 +847,449 @@ func (p *MemoryProvider) getNextAdminID() int64 {
 	return nextID
 }
 
+func (p *MemoryProvider) getDefenderHostByIP(ip string, from int64) (DefenderEntry, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return DefenderEntry{}, errMemoryProviderClosed
+	}
+	host, ok := p.dbHandle.defenderHosts[ip]
+	if !ok {
+		return DefenderEntry{}, &RecordNotFoundError{err: fmt.Sprintf("defender host %#v does not exist", ip)}
+	}
+	return host.getEntry(from), nil
+}
+
+func (p *MemoryProvider) isDefenderHostBanned(ip string) (DefenderEntry, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return DefenderEntry{}, errMemoryProviderClosed
+	}
+	host, ok := p.dbHandle.defenderHosts[ip]
+	if !ok || !host.isBanned() {
+		return DefenderEntry{}, &RecordNotFoundError{err: fmt.Sprintf("defender host %#v is not banned", ip)}
+	}
+	return DefenderEntry{IP: host.IP, BanTime: host.BanTime}, nil
+}
+
+func (p *MemoryProvider) updateDefenderBanTime(ip string, minutes int) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	host, ok := p.dbHandle.defenderHosts[ip]
+	if !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("defender host %#v does not exist", ip)}
+	}
+	host.BanTime += int64(minutes) * 60 * 1000
+	host.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.defenderHosts[ip] = host
+	return nil
+}
+
+func (p *MemoryProvider) deleteDefenderHost(ip string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.defenderHosts[ip]; !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("defender host %#v does not exist", ip)}
+	}
+	delete(p.dbHandle.defenderHosts, ip)
+	return nil
+}
+
+func (p *MemoryProvider) addDefenderEvent(ip string, score int) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	host, ok := p.dbHandle.defenderHosts[ip]
+	if !ok {
+		host = defenderHost{IP: ip}
+	}
+	host.addEvent(score)
+	p.dbHandle.defenderHosts[ip] = host
+	return nil
+}
+
+func (p *MemoryProvider) setDefenderBanTime(ip string, banTime int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	host, ok := p.dbHandle.defenderHosts[ip]
+	if !ok {
+		host = defenderHost{IP: ip}
+	}
+	host.BanTime = banTime
+	host.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	host.Events = nil
+	p.dbHandle.defenderHosts[ip] = host
+	return nil
+}
+
+func (p *MemoryProvider) cleanupDefender(from int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for ip, host := range p.dbHandle.defenderHosts {
+		host.removeEventsBefore(from)
+		if len(host.Events) == 0 && !host.isBanned() {
+			delete(p.dbHandle.defenderHosts, ip)
+			continue
+		}
+		p.dbHandle.defenderHosts[ip] = host
+	}
+	return nil
+}
+
+func (p *MemoryProvider) getDefenderListEntries() ([]DefenderListEntry, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	entries := make([]DefenderListEntry, 0, len(p.dbHandle.defenderLists))
+	for _, entry := range p.dbHandle.defenderLists {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (p *MemoryProvider) addDefenderListEntry(entry *DefenderListEntry) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.defenderLists[entry.getKey()]; ok {
+		return fmt.Errorf("defender list entry %#v already exists", entry.Network)
+	}
+	p.dbHandle.defenderLists[entry.getKey()] = *entry
+	return nil
+}
+
+func (p *MemoryProvider) deleteDefenderListEntry(entry *DefenderListEntry) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.defenderLists[entry.getKey()]; !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("defender list entry %#v does not exist", entry.Network)}
+	}
+	delete(p.dbHandle.defenderLists, entry.getKey())
+	return nil
+}
+
+func (p *MemoryProvider) addQueuedEvent(payload string, createdAt int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	p.dbHandle.eventsQueueSeq++
+	p.dbHandle.eventsQueue = append(p.dbHandle.eventsQueue, QueuedEvent{
+		ID:        p.dbHandle.eventsQueueSeq,
+		Payload:   payload,
+		CreatedAt: createdAt,
+	})
+	return nil
+}
+
+func (p *MemoryProvider) getQueuedEvents(limit int) ([]QueuedEvent, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	if limit > len(p.dbHandle.eventsQueue) {
+		limit = len(p.dbHandle.eventsQueue)
+	}
+	events := make([]QueuedEvent, limit)
+	copy(events, p.dbHandle.eventsQueue)
+	return events, nil
+}
+
+func (p *MemoryProvider) claimQueuedEvents(owner string, limit int, now, leaseExpires int64) ([]QueuedEvent, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	var events []QueuedEvent
+	for idx := range p.dbHandle.eventsQueue {
+		if len(events) >= limit {
+			break
+		}
+		event := &p.dbHandle.eventsQueue[idx]
+		if event.LeaseExpires >= now {
+			continue
+		}
+		event.ClaimedBy = owner
+		event.LeaseExpires = leaseExpires
+		events = append(events, *event)
+	}
+	return events, nil
+}
+
+func (p *MemoryProvider) renewQueuedEventsLease(owner string, leaseExpires int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for idx := range p.dbHandle.eventsQueue {
+		if p.dbHandle.eventsQueue[idx].ClaimedBy == owner {
+			p.dbHandle.eventsQueue[idx].LeaseExpires = leaseExpires
+		}
+	}
+	return nil
+}
+
+func (p *MemoryProvider) deleteQueuedEvent(id int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for idx, event := range p.dbHandle.eventsQueue {
+		if event.ID == id {
+			p.dbHandle.eventsQueue = append(p.dbHandle.eventsQueue[:idx], p.dbHandle.eventsQueue[idx+1:]...)
+			return nil
+		}
+	}
+	return &RecordNotFoundError{err: fmt.Sprintf("queued event %v does not exist", id)}
+}
+
+func (p *MemoryProvider) addSharedConnection(conn *SharedConnection) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	c := *conn
+	if existing, ok := p.dbHandle.sharedConns[c.getKey()]; ok {
+		c.CloseRequested = existing.CloseRequested
+	} else {
+		c.CloseRequested = false
+	}
+	p.dbHandle.sharedConns[c.getKey()] = c
+	return nil
+}
+
+func (p *MemoryProvider) deleteSharedConnection(nodeID, connectionID string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	c := SharedConnection{NodeID: nodeID, ConnectionID: connectionID}
+	if _, ok := p.dbHandle.sharedConns[c.getKey()]; !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("shared connection %#v for node %#v does not exist", connectionID, nodeID)}
+	}
+	delete(p.dbHandle.sharedConns, c.getKey())
+	return nil
+}
+
+func (p *MemoryProvider) getSharedConnections(from int64) ([]SharedConnection, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	conns := make([]SharedConnection, 0, len(p.dbHandle.sharedConns))
+	for _, c := range p.dbHandle.sharedConns {
+		if c.UpdatedAt > from {
+			conns = append(conns, c)
+		}
+	}
+	return conns, nil
+}
+
+func (p *MemoryProvider) requestSharedConnectionClose(nodeID, connectionID string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	key := (&SharedConnection{NodeID: nodeID, ConnectionID: connectionID}).getKey()
+	c, ok := p.dbHandle.sharedConns[key]
+	if !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("shared connection %#v for node %#v does not exist", connectionID, nodeID)}
+	}
+	c.CloseRequested = true
+	p.dbHandle.sharedConns[key] = c
+	return nil
+}
+
+func (p *MemoryProvider) cleanupSharedConnections(from int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	for key, c := range p.dbHandle.sharedConns {
+		if c.UpdatedAt <= from {
+			delete(p.dbHandle.sharedConns, key)
+		}
+	}
+	return nil
+}
+
+func (p *MemoryProvider) addDropLink(link *DropLink) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, err := p.userExistsInternal(link.Username); err != nil {
+		return err
+	}
+	if _, ok := p.dbHandle.dropLinks[link.LinkID]; ok {
+		return fmt.Errorf("drop link %#v already exists", link.LinkID)
+	}
+	p.dbHandle.dropLinks[link.LinkID] = *link
+	return nil
+}
+
+func (p *MemoryProvider) dropLinkExists(linkID string) (DropLink, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return DropLink{}, errMemoryProviderClosed
+	}
+	if link, ok := p.dbHandle.dropLinks[linkID]; ok {
+		return link, nil
+	}
+	return DropLink{}, &RecordNotFoundError{err: fmt.Sprintf("drop link %#v does not exist", linkID)}
+}
+
+func (p *MemoryProvider) getDropLinks(username string) ([]DropLink, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	links := make([]DropLink, 0, 10)
+	for _, link := range p.dbHandle.dropLinks {
+		if link.Username == username {
+			links = append(links, link)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt < links[j].CreatedAt
+	})
+	return links, nil
+}
+
+func (p *MemoryProvider) deleteDropLink(linkID, username string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	link, ok := p.dbHandle.dropLinks[linkID]
+	if !ok || link.Username != username {
+		return &RecordNotFoundError{err: fmt.Sprintf("drop link %#v for user %#v does not exist", linkID, username)}
+	}
+	delete(p.dbHandle.dropLinks, linkID)
+	return nil
+}
+
+func (p *MemoryProvider) updateDropLinkUsage(linkID string, filesAdd int, sizeAdd int64) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	link, ok := p.dbHandle.dropLinks[linkID]
+	if !ok {
+		return &RecordNotFoundError{err: fmt.Sprintf("drop link %#v does not exist", linkID)}
+	}
+	link.UploadedFiles += filesAdd
+	link.UploadedSize += sizeAdd
+	link.LastUseAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	p.dbHandle.dropLinks[linkID] = link
+	return nil
+}
+
+func (p *MemoryProvider) addApprovalRequest(req *ApprovalRequest) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	if _, ok := p.dbHandle.approvals[req.ID]; ok {
+		return fmt.Errorf("approval request %#v already exists", req.ID)
+	}
+	p.dbHandle.approvals[req.ID] = *req
+	p.dbHandle.approvalIDs = append(p.dbHandle.approvalIDs, req.ID)
+	return nil
+}
+
+func (p *MemoryProvider) approvalRequestExists(id string) (ApprovalRequest, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return ApprovalRequest{}, errMemoryProviderClosed
+	}
+	if req, ok := p.dbHandle.approvals[id]; ok {
+		return req, nil
+	}
+	return ApprovalRequest{}, &RecordNotFoundError{err: fmt.Sprintf("approval request %#v does not exist", id)}
+}
+
+func (p *MemoryProvider) getApprovalRequests(limit, offset int) ([]ApprovalRequest, error) {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return nil, errMemoryProviderClosed
+	}
+	requests := make([]ApprovalRequest, 0, limit)
+	itNum := 0
+	for i := len(p.dbHandle.approvalIDs) - 1; i >= 0; i-- {
+		itNum++
+		if itNum <= offset {
+			continue
+		}
+		req := p.dbHandle.approvals[p.dbHandle.approvalIDs[i]]
+		req.Payload = ""
+		requests = append(requests, req)
+		if len(requests) >= limit {
+			break
+		}
+	}
+	return requests, nil
+}
+
+func (p *MemoryProvider) updateApprovalRequestStatus(req *ApprovalRequest, fromStatus string) error {
+	p.dbHandle.Lock()
+	defer p.dbHandle.Unlock()
+	if p.dbHandle.isClosed {
+		return errMemoryProviderClosed
+	}
+	stored, ok := p.dbHandle.approvals[req.ID]
+	if !ok || stored.Status != fromStatus {
+		return &RecordNotFoundError{err: fmt.Sprintf("approval request %#v with status %#v does not exist", req.ID, fromStatus)}
+	}
+	stored.Status = req.Status
+	stored.Payload = req.Payload
+	stored.ResolvedBy = req.ResolvedBy
+	stored.ResolvedAt = req.ResolvedAt
+	stored.Error = req.Error
+	p.dbHandle.approvals[req.ID] = stored
+	return nil
+}
+
 func (p *MemoryProvider) clear() {
 	p.dbHandle.Lock()
 	defer p.dbHandle.Unlock()
@@ -809,6 +1299,13 @@ func (p *MemoryProvider) clear() {
 	p.dbHandle.vfolders = make(map[string]vfs.BaseVirtualFolder)
 	p.dbHandle.admins = make(map[string]Admin)
 	p.dbHandle.adminsUsernames = []string{}
+	p.dbHandle.defenderHosts = make(map[string]defenderHost)
+	p.dbHandle.defenderLists = make(map[string]DefenderListEntry)
+	p.dbHandle.eventsQueue = []QueuedEvent{}
+	p.dbHandle.sharedConns = make(map[string]SharedConnection)
+	p.dbHandle.dropLinks = make(map[string]DropLink)
+	p.dbHandle.approvals = make(map[string]ApprovalRequest)
+	p.dbHandle.approvalIDs = []string{}
 }
 
 func (p *MemoryProvider) reloadConfig() error {
@@ -0,0 +1,65 @@
+// +build !nometrics
+
+package dataprovider
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type queryMetrics struct {
+	queries float64
+	errors  float64
+	count   uint64
+}
+
+func getQueryMetrics(t *testing.T, operation string) queryMetrics {
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	var result queryMetrics
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			found := false
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "operation" && label.GetValue() == operation {
+					found = true
+				}
+			}
+			if !found {
+				continue
+			}
+			switch family.GetName() {
+			case "sftpgo_dataprovider_queries_total":
+				result.queries = m.GetCounter().GetValue()
+			case "sftpgo_dataprovider_query_errors_total":
+				result.errors = m.GetCounter().GetValue()
+			case "sftpgo_dataprovider_query_duration_seconds":
+				result.count = m.GetHistogram().GetSampleCount()
+			}
+		}
+	}
+	return result
+}
+
+func TestProviderQueryMetrics(t *testing.T) {
+	operation := "test_query"
+	startQuery(context.Background(), operation).end(nil)
+	assert.Equal(t, queryMetrics{queries: 1, errors: 0, count: 1}, getQueryMetrics(t, operation))
+
+	_, query := startQueryContext(context.Background(), operation)
+	query.end(errors.New("query error"))
+	assert.Equal(t, queryMetrics{queries: 2, errors: 1, count: 2}, getQueryMetrics(t, operation))
+	// a missing object or invalid credentials are not query errors
+	startQuery(context.Background(), operation).end(&RecordNotFoundError{err: "missing user"})
+	startQuery(context.Background(), operation).end(ErrInvalidCredentials)
+	assert.Equal(t, queryMetrics{queries: 4, errors: 1, count: 4}, getQueryMetrics(t, operation))
+	// the metrics are tracked for each operation
+	startQuery(context.Background(), "other_test_query").end(nil)
+	assert.Equal(t, queryMetrics{queries: 4, errors: 1, count: 4}, getQueryMetrics(t, operation))
+	assert.Equal(t, queryMetrics{queries: 1, errors: 0, count: 1}, getQueryMetrics(t, "other_test_query"))
+}
@@ -0,0 +1,167 @@
+package dataprovider
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// MigrationResult defines the results of a data provider migration
+type MigrationResult struct {
+	// number of migrated users
+	Users int `json:"users"`
+	// number of migrated folders
+	Folders int `json:"folders"`
+	// number of migrated admins
+	Admins int `json:"admins"`
+	// objects already existing in the destination provider, they are not modified
+	Skipped []string `json:"skipped"`
+	// errors for the objects that cannot be migrated
+	Errors []string `json:"errors"`
+}
+
+// MigrateProvider copies the virtual folders, the users, the admins and the
+// used quota from the source data provider to the destination one.
+// The destination provider is initialized and migrated to the current schema
+// version, if required. The objects already existing in the destination
+// provider are skipped, the objects are validated before saving them and the
+// validation errors are reported within the results.
+// The active data provider, if any, is replaced and the destination provider
+// is closed before returning
+func MigrateProvider(source, destination Config, basePath string) (MigrationResult, error) {
+	result := MigrationResult{
+		Skipped: []string{},
+		Errors:  []string{},
+	}
+	if destination.Driver == MemoryDataProviderName {
+		return result, errors.New("the memory provider cannot be used as migration destination")
+	}
+	if isSameProvider(&source, &destination) {
+		return result, errors.New("the source and the destination providers must be different")
+	}
+	data, err := dumpMigrationSource(source, basePath)
+	if err != nil {
+		return result, fmt.Errorf("unable to read the source provider: %w", err)
+	}
+	if err := initializeMigrationDestination(destination, basePath); err != nil {
+		return result, fmt.Errorf("unable to initialize the destination provider: %w", err)
+	}
+	defer provider.close() //nolint:errcheck
+
+	providerLog(logger.LevelInfo, "start migrating from provider %#v, users: %v, folders: %v, admins: %v",
+		source.Driver, len(data.Users), len(data.Folders), len(data.Admins))
+
+	for idx := range data.Folders {
+		folder := &data.Folders[idx]
+		_, err := provider.getFolderByName(folder.Name)
+		if migrateObject(ActionObjectFolder, folder.Name, err, &result, func() error {
+			return provider.addFolder(folder)
+		}) {
+			result.Folders++
+		}
+	}
+	for idx := range data.Users {
+		user := &data.Users[idx]
+		_, err := provider.userExists(user.Username)
+		if migrateObject(ActionObjectUser, user.Username, err, &result, func() error {
+			if err := provider.addUser(user); err != nil {
+				return err
+			}
+			if user.UsedQuotaFiles == 0 && user.UsedQuotaSize == 0 {
+				return nil
+			}
+			return provider.updateQuota(user.Username, user.UsedQuotaFiles, user.UsedQuotaSize, true)
+		}) {
+			result.Users++
+		}
+	}
+	for idx := range data.Admins {
+		admin := &data.Admins[idx]
+		_, err := provider.adminExists(admin.Username)
+		if migrateObject(ActionObjectAdmin, admin.Username, err, &result, func() error {
+			return provider.addAdmin(admin)
+		}) {
+			result.Admins++
+		}
+	}
+	providerLog(logger.LevelInfo, "migration completed, users: %v, folders: %v, admins: %v, skipped: %v, errors: %v",
+		result.Users, result.Folders, result.Admins, len(result.Skipped), len(result.Errors))
+	return result, nil
+}
+
+// migrateObject adds an object to the destination provider if it does not
+// already exist there. existsErr is the error returned while searching the
+// object in the destination provider, nil means that the object exists.
+// It returns true if the object is added
+func migrateObject(objectType, name string, existsErr error, result *MigrationResult, add func() error) bool {
+	if existsErr == nil {
+		providerLog(logger.LevelDebug, "%v %#v already exists in the destination provider, skipped", objectType, name)
+		result.Skipped = append(result.Skipped, fmt.Sprintf("%v %#v", objectType, name))
+		return false
+	}
+	var notFoundErr *RecordNotFoundError
+	err := existsErr
+	if errors.As(existsErr, &notFoundErr) {
+		err = add()
+	}
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to migrate %v %#v: %v", objectType, name, err)
+		result.Errors = append(result.Errors, fmt.Sprintf("%v %#v: %v", objectType, name, err))
+		return false
+	}
+	return true
+}
+
+func dumpMigrationSource(source Config, basePath string) (BackupData, error) {
+	var data BackupData
+
+	if err := createMigrationProvider(source, basePath); err != nil {
+		return data, err
+	}
+	defer provider.close() //nolint:errcheck
+
+	if err := provider.checkAvailability(); err != nil {
+		return data, err
+	}
+	return DumpData()
+}
+
+func initializeMigrationDestination(destination Config, basePath string) error {
+	if err := createMigrationProvider(destination, basePath); err != nil {
+		return err
+	}
+	err := provider.initializeDatabase()
+	if err != nil && err != ErrNoInitRequired {
+		provider.close() //nolint:errcheck
+		return err
+	}
+	err = provider.migrateDatabase()
+	if err != nil && err != ErrNoInitRequired {
+		provider.close() //nolint:errcheck
+		return err
+	}
+	return nil
+}
+
+func createMigrationProvider(cnf Config, basePath string) error {
+	config = cnf
+
+	if filepath.IsAbs(config.CredentialsPath) {
+		credentialsDirPath = config.CredentialsPath
+	} else {
+		credentialsDirPath = filepath.Join(basePath, config.CredentialsPath)
+	}
+	vfs.SetCredentialsDirPath(credentialsDirPath)
+	return createProvider(basePath)
+}
+
+func isSameProvider(source, destination *Config) bool {
+	if source.Driver != destination.Driver {
+		return false
+	}
+	return source.Name == destination.Name && source.Host == destination.Host && source.Port == destination.Port &&
+		source.ConnectionString == destination.ConnectionString && source.SQLTablesPrefix == destination.SQLTablesPrefix
+}
@@ -0,0 +1,101 @@
+package dataprovider
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+func TestMigrateProvider(t *testing.T) {
+	basePath := t.TempDir()
+	source := Config{
+		Driver:          BoltDataProviderName,
+		Name:            filepath.Join(basePath, "sftpgo.bolt"),
+		TrackQuota:      1,
+		CredentialsPath: "credentials",
+		PasswordHashing: PasswordHashing{
+			Argon2Options: Argon2Options{
+				Memory:      65536,
+				Iterations:  1,
+				Parallelism: 2,
+			},
+		},
+	}
+	destination := source
+	destination.Driver = SQLiteDataProviderName
+	destination.Name = filepath.Join(basePath, "sftpgo.db")
+
+	_, err := MigrateProvider(source, source, basePath)
+	assert.Error(t, err)
+	memoryDestination := source
+	memoryDestination.Driver = MemoryDataProviderName
+	_, err = MigrateProvider(source, memoryDestination, basePath)
+	assert.Error(t, err)
+
+	err = Initialize(source, basePath, false)
+	require.NoError(t, err)
+	folder := vfs.BaseVirtualFolder{
+		Name:       "migrated_folder",
+		MappedPath: filepath.Join(basePath, "folder"),
+	}
+	err = AddFolder(&folder)
+	assert.NoError(t, err)
+	user := getTestUser()
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		BaseVirtualFolder: folder,
+		VirtualPath:       "/vdir",
+	})
+	err = AddUser(&user)
+	assert.NoError(t, err)
+	err = UpdateUserQuota(&user, 10, 6000, true)
+	assert.NoError(t, err)
+	admin := Admin{
+		Username:    "migrated_admin",
+		Password:    "password",
+		Status:      1,
+		Permissions: []string{PermAdminAny},
+	}
+	err = AddAdmin(&admin)
+	assert.NoError(t, err)
+	err = Close()
+	assert.NoError(t, err)
+
+	result, err := MigrateProvider(source, destination, basePath)
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Users)
+	assert.Equal(t, 1, result.Folders)
+	assert.Equal(t, 1, result.Admins)
+	assert.Len(t, result.Skipped, 0)
+	assert.Len(t, result.Errors, 0)
+	// the objects already migrated are skipped
+	result, err = MigrateProvider(source, destination, basePath)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Users)
+	assert.Equal(t, 0, result.Folders)
+	assert.Equal(t, 0, result.Admins)
+	assert.Len(t, result.Skipped, 3)
+	assert.Len(t, result.Errors, 0)
+
+	err = Initialize(destination, basePath, false)
+	require.NoError(t, err)
+	migratedUser, err := UserExists(user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, migratedUser.UsedQuotaFiles)
+	assert.Equal(t, int64(6000), migratedUser.UsedQuotaSize)
+	if assert.Len(t, migratedUser.VirtualFolders, 1) {
+		assert.Equal(t, folder.Name, migratedUser.VirtualFolders[0].Name)
+	}
+	migratedAdmin, err := AdminExists(admin.Username)
+	assert.NoError(t, err)
+	match, err := migratedAdmin.CheckPassword("password")
+	assert.NoError(t, err)
+	assert.True(t, match)
+	_, err = GetFolderByName(folder.Name)
+	assert.NoError(t, err)
+	err = Close()
+	assert.NoError(t, err)
+}
@@ -4,15 +4,16 @@ package dataprovider
 
 import (
 	"context"
+	"crypto/tls"
 	"crypto/x509"
 	"database/sql"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"strings"
 	"time"
 
-	// we import go-sql-driver/mysql here to be able to disable MySQL support using a build tag
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/version"
@@ -48,8 +49,54 @@ const (
 		"ALTER TABLE `{{folders}}` DROP COLUMN `filesystem`;" +
 		"ALTER TABLE `{{folders}}` DROP COLUMN `description`;" +
 		"ALTER TABLE `{{admins}}` DROP COLUMN `description`;"
+	mysqlV10SQL = "CREATE TABLE `{{defender_hosts}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`ip` varchar(50) NOT NULL UNIQUE, `ban_time` bigint NOT NULL, `updated_at` bigint NOT NULL);" +
+		"CREATE TABLE `{{defender_events}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`date_time` bigint NOT NULL, `score` integer NOT NULL, `host_id` bigint NOT NULL);" +
+		"ALTER TABLE `{{defender_events}}` ADD CONSTRAINT `{{prefix}}defender_events_host_id_fk_defender_hosts_id` " +
+		"FOREIGN KEY (`host_id`) REFERENCES `{{defender_hosts}}` (`id`) ON DELETE CASCADE;" +
+		"CREATE INDEX `{{prefix}}defender_hosts_ban_time_idx` ON `{{defender_hosts}}` (`ban_time`);" +
+		"CREATE INDEX `{{prefix}}defender_events_date_time_idx` ON `{{defender_events}}` (`date_time`);" +
+		"CREATE TABLE `{{defender_lists}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, `type` integer NOT NULL, " +
+		"`network` varchar(50) NOT NULL, `description` varchar(512) NULL, `created_at` bigint NOT NULL, " +
+		"CONSTRAINT `{{prefix}}defender_lists_type_network_uniq` UNIQUE (`type`, `network`));" +
+		"CREATE TABLE `{{events_queue}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, `payload` longtext NOT NULL, " +
+		"`created_at` bigint NOT NULL, `claimed_by` varchar(255) NOT NULL, `lease_expires` bigint NOT NULL);" +
+		"CREATE TABLE `{{shared_connections}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`connection_id` varchar(255) NOT NULL, `node_id` varchar(255) NOT NULL, `username` varchar(255) NOT NULL, " +
+		"`payload` longtext NOT NULL, `updated_at` bigint NOT NULL, `close_requested` integer NOT NULL, " +
+		"CONSTRAINT `{{prefix}}shared_connections_node_id_connection_id_uniq` UNIQUE (`node_id`, `connection_id`));" +
+		"CREATE INDEX `{{prefix}}shared_connections_updated_at_idx` ON `{{shared_connections}}` (`updated_at`);" +
+		"CREATE TABLE `{{drop_links}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`link_id` varchar(255) NOT NULL UNIQUE, `description` varchar(512) NULL, `path` longtext NOT NULL, " +
+		"`max_size` bigint NOT NULL, `max_files` integer NOT NULL, `uploaded_size` bigint NOT NULL, " +
+		"`uploaded_files` integer NOT NULL, `expires_at` bigint NOT NULL, `created_at` bigint NOT NULL, " +
+		"`last_use_at` bigint NOT NULL, `user_id` integer NOT NULL);" +
+		"ALTER TABLE `{{drop_links}}` ADD CONSTRAINT `{{prefix}}drop_links_user_id_fk_users_id` " +
+		"FOREIGN KEY (`user_id`) REFERENCES `{{users}}` (`id`) ON DELETE CASCADE;" +
+		"CREATE TABLE `{{approval_requests}}` (`id` bigint AUTO_INCREMENT NOT NULL PRIMARY KEY, " +
+		"`req_id` varchar(255) NOT NULL UNIQUE, `operation` varchar(50) NOT NULL, `object_name` varchar(255) NULL, " +
+		"`payload` longtext NULL, `status` varchar(20) NOT NULL, `requested_by` varchar(255) NOT NULL, " +
+		"`requested_at` bigint NOT NULL, `resolved_by` varchar(255) NULL, `resolved_at` bigint NOT NULL, " +
+		"`exec_error` longtext NULL);" +
+		"CREATE INDEX `{{prefix}}approval_requests_requested_at_idx` ON `{{approval_requests}}` (`requested_at`);" +
+		"ALTER TABLE `{{users}}` ADD COLUMN `version` bigint DEFAULT 0 NOT NULL;" +
+		"ALTER TABLE `{{folders}}` ADD COLUMN `version` bigint DEFAULT 0 NOT NULL;" +
+		"ALTER TABLE `{{admins}}` ADD COLUMN `version` bigint DEFAULT 0 NOT NULL;"
+	mysqlV10DownSQL = "ALTER TABLE `{{admins}}` DROP COLUMN `version`;" +
+		"ALTER TABLE `{{folders}}` DROP COLUMN `version`;" +
+		"ALTER TABLE `{{users}}` DROP COLUMN `version`;" +
+		"DROP TABLE `{{approval_requests}}` CASCADE;" +
+		"DROP TABLE `{{drop_links}}` CASCADE;" +
+		"DROP TABLE `{{shared_connections}}` CASCADE;" +
+		"DROP TABLE `{{events_queue}}` CASCADE;" +
+		"DROP TABLE `{{defender_lists}}` CASCADE;" +
+		"DROP TABLE `{{defender_events}}` CASCADE;" +
+		"DROP TABLE `{{defender_hosts}}` CASCADE;"
 )
 
+const mysqlCustomTLSConfigName = "sftpgo_custom"
+
 // MySQLProvider auth provider for MySQL/MariaDB database
 type MySQLProvider struct {
 	dbHandle *sql.DB
@@ -62,6 +109,10 @@ func init() {
 func initializeMySQLProvider() error {
 	var err error
 
+	if err = registerMySQLCustomTLSConfig(); err != nil {
+		providerLog(logger.LevelWarn, "unable to register custom TLS config: %v", err)
+		return err
+	}
 	dbHandle, err := sql.Open("mysql", getMySQLConnectionString(false))
 	if err == nil {
 		providerLog(logger.LevelDebug, "mysql database handle created, connection string: %#v, pool size: %v",
@@ -80,6 +131,57 @@ func initializeMySQLProvider() error {
 	}
 	return err
 }
+
+func hasMySQLCustomTLSConfig() bool {
+	return config.SSLMode > 0 && (config.RootCert != "" || config.ClientCert != "" || config.TLSServerName != "")
+}
+
+func registerMySQLCustomTLSConfig() error {
+	if !hasMySQLCustomTLSConfig() {
+		return nil
+	}
+	tlsConfig, err := getMySQLCustomTLSConfig()
+	if err != nil {
+		return err
+	}
+	return mysql.RegisterTLSConfig(mysqlCustomTLSConfigName, tlsConfig)
+}
+
+// getMySQLCustomTLSConfig returns a TLS config with the custom certificates
+// and server name. Like the "skip-verify" and "preferred" modes, sslmode 2 and 3
+// don't verify the server certificate. A TLS connection is always required,
+// the driver falls back to plain text only for its builtin "preferred" mode
+func getMySQLCustomTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         config.TLSServerName,
+		InsecureSkipVerify: config.SSLMode == 2 || config.SSLMode == 3,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = config.Host
+	}
+	if config.RootCert != "" {
+		rootCAs := x509.NewCertPool()
+		pem, err := ioutil.ReadFile(config.RootCert)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read root certificate %#v: %w", config.RootCert, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("unable to add root certificate %#v", config.RootCert)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if config.ClientCert != "" {
+		clientCert, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate %#v and key %#v: %w", config.ClientCert,
+				config.ClientKey, err)
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, clientCert)
+	}
+	return tlsConfig, nil
+}
+
 func getMySQLConnectionString(redactedPwd bool) string {
 	var connectionString string
 	if config.ConnectionString == "" {
@@ -87,8 +189,12 @@ func getMySQLConnectionString(redactedPwd bool) string {
 		if redactedPwd {
 			password = "[redacted]"
 		}
+		sslMode := getSSLMode()
+		if hasMySQLCustomTLSConfig() {
+			sslMode = mysqlCustomTLSConfigName
+		}
 		connectionString = fmt.Sprintf("%v:%v@tcp([%v]:%v)/%v?charset=utf8&interpolateParams=true&timeout=10s&tls=%v&writeTimeout=10s&readTimeout=10s",
-			config.Username, password, config.Host, config.Port, config.Name, getSSLMode())
+			config.Username, password, config.Host, config.Port, config.Name, sslMode)
 	} else {
 		connectionString = config.ConnectionString
 	}
@@ -99,8 +205,8 @@ func (p *MySQLProvider) checkAvailability() error {
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
 
-func (p *MySQLProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
-	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.dbHandle)
+func (p *MySQLProvider) validateUserAndPass(ctx context.Context, username, password, ip, protocol string) (User, error) {
+	return sqlCommonValidateUserAndPass(ctx, username, password, ip, protocol, p.dbHandle)
 }
 
 func (p *MySQLProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
@@ -209,6 +315,122 @@ func (p *MySQLProvider) validateAdminAndPass(username, password, ip string) (Adm
 	return sqlCommonValidateAdminAndPass(username, password, ip, p.dbHandle)
 }
 
+func (p *MySQLProvider) getDefenderHostByIP(ip string, from int64) (DefenderEntry, error) {
+	return sqlCommonGetDefenderHostByIP(ip, from, p.dbHandle)
+}
+
+func (p *MySQLProvider) isDefenderHostBanned(ip string) (DefenderEntry, error) {
+	return sqlCommonIsDefenderHostBanned(ip, p.dbHandle)
+}
+
+func (p *MySQLProvider) updateDefenderBanTime(ip string, minutes int) error {
+	return sqlCommonUpdateDefenderBanTime(ip, minutes, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteDefenderHost(ip string) error {
+	return sqlCommonDeleteDefenderHost(ip, p.dbHandle)
+}
+
+func (p *MySQLProvider) addDefenderEvent(ip string, score int) error {
+	return sqlCommonAddDefenderEvent(ip, score, p.dbHandle)
+}
+
+func (p *MySQLProvider) setDefenderBanTime(ip string, banTime int64) error {
+	return sqlCommonSetDefenderBanTime(ip, banTime, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupDefender(from int64) error {
+	return sqlCommonCleanupDefender(from, p.dbHandle)
+}
+
+func (p *MySQLProvider) getDefenderListEntries() ([]DefenderListEntry, error) {
+	return sqlCommonGetDefenderListEntries(p.dbHandle)
+}
+
+func (p *MySQLProvider) addDefenderListEntry(entry *DefenderListEntry) error {
+	return sqlCommonAddDefenderListEntry(entry, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteDefenderListEntry(entry *DefenderListEntry) error {
+	return sqlCommonDeleteDefenderListEntry(entry, p.dbHandle)
+}
+
+func (p *MySQLProvider) addQueuedEvent(payload string, createdAt int64) error {
+	return sqlCommonAddQueuedEvent(payload, createdAt, p.dbHandle)
+}
+
+func (p *MySQLProvider) getQueuedEvents(limit int) ([]QueuedEvent, error) {
+	return sqlCommonGetQueuedEvents(limit, p.dbHandle)
+}
+
+func (p *MySQLProvider) claimQueuedEvents(owner string, limit int, now, leaseExpires int64) ([]QueuedEvent, error) {
+	return sqlCommonClaimQueuedEvents(owner, limit, now, leaseExpires, p.dbHandle)
+}
+
+func (p *MySQLProvider) renewQueuedEventsLease(owner string, leaseExpires int64) error {
+	return sqlCommonRenewQueuedEventsLease(owner, leaseExpires, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteQueuedEvent(id int64) error {
+	return sqlCommonDeleteQueuedEvent(id, p.dbHandle)
+}
+
+func (p *MySQLProvider) addSharedConnection(conn *SharedConnection) error {
+	return sqlCommonAddSharedConnection(conn, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteSharedConnection(nodeID, connectionID string) error {
+	return sqlCommonDeleteSharedConnection(nodeID, connectionID, p.dbHandle)
+}
+
+func (p *MySQLProvider) getSharedConnections(from int64) ([]SharedConnection, error) {
+	return sqlCommonGetSharedConnections(from, p.dbHandle)
+}
+
+func (p *MySQLProvider) requestSharedConnectionClose(nodeID, connectionID string) error {
+	return sqlCommonRequestSharedConnectionClose(nodeID, connectionID, p.dbHandle)
+}
+
+func (p *MySQLProvider) cleanupSharedConnections(from int64) error {
+	return sqlCommonCleanupSharedConnections(from, p.dbHandle)
+}
+
+func (p *MySQLProvider) addDropLink(link *DropLink) error {
+	return sqlCommonAddDropLink(link, p.dbHandle)
+}
+
+func (p *MySQLProvider) dropLinkExists(linkID string) (DropLink, error) {
+	return sqlCommonGetDropLinkByID(linkID, p.dbHandle)
+}
+
+func (p *MySQLProvider) getDropLinks(username string) ([]DropLink, error) {
+	return sqlCommonGetDropLinks(username, p.dbHandle)
+}
+
+func (p *MySQLProvider) deleteDropLink(linkID, username string) error {
+	return sqlCommonDeleteDropLink(linkID, username, p.dbHandle)
+}
+
+func (p *MySQLProvider) updateDropLinkUsage(linkID string, filesAdd int, sizeAdd int64) error {
+	return sqlCommonUpdateDropLinkUsage(linkID, filesAdd, sizeAdd, p.dbHandle)
+}
+
+func (p *MySQLProvider) addApprovalRequest(req *ApprovalRequest) error {
+	return sqlCommonAddApprovalRequest(req, p.dbHandle)
+}
+
+func (p *MySQLProvider) approvalRequestExists(id string) (ApprovalRequest, error) {
+	return sqlCommonGetApprovalRequestByID(id, p.dbHandle)
+}
+
+func (p *MySQLProvider) getApprovalRequests(limit, offset int) ([]ApprovalRequest, error) {
+	return sqlCommonGetApprovalRequests(limit, offset, p.dbHandle)
+}
+
+func (p *MySQLProvider) updateApprovalRequestStatus(req *ApprovalRequest, fromStatus string) error {
+	return sqlCommonUpdateApprovalRequestStatus(req, fromStatus, p.dbHandle)
+}
+
 func (p *MySQLProvider) close() error {
 	return p.dbHandle.Close()
 }
@@ -250,6 +472,8 @@ func (p *MySQLProvider) migrateDatabase() error {
 		return err
 	case version == 8:
 		return updateMySQLDatabaseFromV8(p.dbHandle)
+	case version == 9:
+		return updateMySQLDatabaseFromV9(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelWarn, "database version %v is newer than the supported one: %v", version,
@@ -274,19 +498,35 @@ func (p *MySQLProvider) revertDatabase(targetVersion int) error {
 	switch dbVersion.Version {
 	case 9:
 		return downgradeMySQLDatabaseFromV9(p.dbHandle)
+	case 10:
+		return downgradeMySQLDatabaseFromV10(p.dbHandle)
 	default:
 		return fmt.Errorf("database version not handled: %v", dbVersion.Version)
 	}
 }
 
 func updateMySQLDatabaseFromV8(dbHandle *sql.DB) error {
-	return updateMySQLDatabaseFrom8To9(dbHandle)
+	if err := updateMySQLDatabaseFrom8To9(dbHandle); err != nil {
+		return err
+	}
+	return updateMySQLDatabaseFromV9(dbHandle)
+}
+
+func updateMySQLDatabaseFromV9(dbHandle *sql.DB) error {
+	return updateMySQLDatabaseFrom9To10(dbHandle)
 }
 
 func downgradeMySQLDatabaseFromV9(dbHandle *sql.DB) error {
 	return downgradeMySQLDatabaseFrom9To8(dbHandle)
 }
 
+func downgradeMySQLDatabaseFromV10(dbHandle *sql.DB) error {
+	if err := downgradeMySQLDatabaseFrom10To9(dbHandle); err != nil {
+		return err
+	}
+	return downgradeMySQLDatabaseFromV9(dbHandle)
+}
+
 func updateMySQLDatabaseFrom8To9(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database version: 8 -> 9")
 	providerLog(logger.LevelInfo, "updating database version: 8 -> 9")
@@ -304,3 +544,36 @@ func downgradeMySQLDatabaseFrom9To8(dbHandle *sql.DB) error {
 	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 8)
 }
+
+func updateMySQLDatabaseFrom9To10(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 9 -> 10")
+	providerLog(logger.LevelInfo, "updating database version: 9 -> 10")
+	sql := strings.ReplaceAll(mysqlV10SQL, "{{defender_hosts}}", sqlTableDefenderHosts)
+	sql = strings.ReplaceAll(sql, "{{defender_events}}", sqlTableDefenderEvents)
+	sql = strings.ReplaceAll(sql, "{{defender_lists}}", sqlTableDefenderLists)
+	sql = strings.ReplaceAll(sql, "{{events_queue}}", sqlTableEventsQueue)
+	sql = strings.ReplaceAll(sql, "{{shared_connections}}", sqlTableSharedConns)
+	sql = strings.ReplaceAll(sql, "{{drop_links}}", sqlTableDropLinks)
+	sql = strings.ReplaceAll(sql, "{{approval_requests}}", sqlTableApprovals)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{admins}}", sqlTableAdmins)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 10)
+}
+
+func downgradeMySQLDatabaseFrom10To9(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 10 -> 9")
+	providerLog(logger.LevelInfo, "downgrading database version: 10 -> 9")
+	sql := strings.ReplaceAll(mysqlV10DownSQL, "{{defender_hosts}}", sqlTableDefenderHosts)
+	sql = strings.ReplaceAll(sql, "{{defender_events}}", sqlTableDefenderEvents)
+	sql = strings.ReplaceAll(sql, "{{defender_lists}}", sqlTableDefenderLists)
+	sql = strings.ReplaceAll(sql, "{{events_queue}}", sqlTableEventsQueue)
+	sql = strings.ReplaceAll(sql, "{{shared_connections}}", sqlTableSharedConns)
+	sql = strings.ReplaceAll(sql, "{{drop_links}}", sqlTableDropLinks)
+	sql = strings.ReplaceAll(sql, "{{approval_requests}}", sqlTableApprovals)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{admins}}", sqlTableAdmins)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, strings.Split(sql, ";"), 9)
+}
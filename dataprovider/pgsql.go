@@ -6,13 +6,14 @@ import (
 	"context"
 	"crypto/x509"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"time"
 
-	// we import lib/pq here to be able to disable PostgreSQL support using a build tag
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/version"
@@ -54,6 +55,52 @@ ALTER TABLE "{{users}}" ADD COLUMN "description" varchar(512) NULL;
 ALTER TABLE "{{folders}}" DROP COLUMN "filesystem" CASCADE;
 ALTER TABLE "{{folders}}" DROP COLUMN "description" CASCADE;
 ALTER TABLE "{{admins}}" DROP COLUMN "description" CASCADE;
+`
+	pgsqlV10SQL = `CREATE TABLE "{{defender_hosts}}" ("id" bigserial NOT NULL PRIMARY KEY, "ip" varchar(50) NOT NULL UNIQUE,
+"ban_time" bigint NOT NULL, "updated_at" bigint NOT NULL);
+CREATE TABLE "{{defender_events}}" ("id" bigserial NOT NULL PRIMARY KEY, "date_time" bigint NOT NULL, "score" integer NOT NULL,
+"host_id" bigint NOT NULL);
+ALTER TABLE "{{defender_events}}" ADD CONSTRAINT "{{prefix}}defender_events_host_id_fk_defender_hosts_id" FOREIGN KEY ("host_id")
+REFERENCES "{{defender_hosts}}" ("id") MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE;
+CREATE INDEX "{{prefix}}defender_hosts_ban_time_idx" ON "{{defender_hosts}}" ("ban_time");
+CREATE INDEX "{{prefix}}defender_events_date_time_idx" ON "{{defender_events}}" ("date_time");
+CREATE INDEX "{{prefix}}defender_events_host_id_idx" ON "{{defender_events}}" ("host_id");
+CREATE TABLE "{{defender_lists}}" ("id" bigserial NOT NULL PRIMARY KEY, "type" integer NOT NULL, "network" varchar(50) NOT NULL,
+"description" varchar(512) NULL, "created_at" bigint NOT NULL,
+CONSTRAINT "{{prefix}}defender_lists_type_network_uniq" UNIQUE ("type", "network"));
+CREATE TABLE "{{events_queue}}" ("id" bigserial NOT NULL PRIMARY KEY, "payload" text NOT NULL, "created_at" bigint NOT NULL,
+"claimed_by" varchar(255) NOT NULL, "lease_expires" bigint NOT NULL);
+CREATE TABLE "{{shared_connections}}" ("id" bigserial NOT NULL PRIMARY KEY, "connection_id" varchar(255) NOT NULL,
+"node_id" varchar(255) NOT NULL, "username" varchar(255) NOT NULL, "payload" text NOT NULL, "updated_at" bigint NOT NULL,
+"close_requested" integer NOT NULL,
+CONSTRAINT "{{prefix}}shared_connections_node_id_connection_id_uniq" UNIQUE ("node_id", "connection_id"));
+CREATE INDEX "{{prefix}}shared_connections_updated_at_idx" ON "{{shared_connections}}" ("updated_at");
+CREATE TABLE "{{drop_links}}" ("id" bigserial NOT NULL PRIMARY KEY, "link_id" varchar(255) NOT NULL UNIQUE,
+"description" varchar(512) NULL, "path" text NOT NULL, "max_size" bigint NOT NULL, "max_files" integer NOT NULL,
+"uploaded_size" bigint NOT NULL, "uploaded_files" integer NOT NULL, "expires_at" bigint NOT NULL, "created_at" bigint NOT NULL,
+"last_use_at" bigint NOT NULL, "user_id" integer NOT NULL);
+ALTER TABLE "{{drop_links}}" ADD CONSTRAINT "{{prefix}}drop_links_user_id_fk_users_id" FOREIGN KEY ("user_id")
+REFERENCES "{{users}}" ("id") MATCH SIMPLE ON UPDATE NO ACTION ON DELETE CASCADE;
+CREATE INDEX "{{prefix}}drop_links_user_id_idx" ON "{{drop_links}}" ("user_id");
+CREATE TABLE "{{approval_requests}}" ("id" bigserial NOT NULL PRIMARY KEY, "req_id" varchar(255) NOT NULL UNIQUE,
+"operation" varchar(50) NOT NULL, "object_name" varchar(255) NULL, "payload" text NULL, "status" varchar(20) NOT NULL,
+"requested_by" varchar(255) NOT NULL, "requested_at" bigint NOT NULL, "resolved_by" varchar(255) NULL,
+"resolved_at" bigint NOT NULL, "exec_error" text NULL);
+CREATE INDEX "{{prefix}}approval_requests_requested_at_idx" ON "{{approval_requests}}" ("requested_at");
+ALTER TABLE "{{users}}" ADD COLUMN "version" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{folders}}" ADD COLUMN "version" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{admins}}" ADD COLUMN "version" bigint DEFAULT 0 NOT NULL;
+`
+	pgsqlV10DownSQL = `ALTER TABLE "{{admins}}" DROP COLUMN "version" CASCADE;
+ALTER TABLE "{{folders}}" DROP COLUMN "version" CASCADE;
+ALTER TABLE "{{users}}" DROP COLUMN "version" CASCADE;
+DROP TABLE "{{approval_requests}}" CASCADE;
+DROP TABLE "{{drop_links}}" CASCADE;
+DROP TABLE "{{shared_connections}}" CASCADE;
+DROP TABLE "{{events_queue}}" CASCADE;
+DROP TABLE "{{defender_lists}}" CASCADE;
+DROP TABLE "{{defender_events}}" CASCADE;
+DROP TABLE "{{defender_hosts}}" CASCADE;
 `
 )
 
@@ -68,7 +115,15 @@ func init() {
 
 func initializePGSQLProvider() error {
 	var err error
-	dbHandle, err := sql.Open("postgres", getPGSQLConnectionString(false))
+	var dbHandle *sql.DB
+	if hasPGSQLCustomServerName() {
+		dbHandle = sql.OpenDB(&pgsqlConnector{
+			dsn:     getPGSQLConnectionString(false),
+			address: net.JoinHostPort(config.Host, fmt.Sprintf("%v", config.Port)),
+		})
+	} else {
+		dbHandle, err = sql.Open("postgres", getPGSQLConnectionString(false))
+	}
 	if err == nil {
 		providerLog(logger.LevelDebug, "postgres database handle created, connection string: %#v, pool size: %v",
 			getPGSQLConnectionString(true), config.PoolSize)
@@ -94,20 +149,87 @@ func getPGSQLConnectionString(redactedPwd bool) string {
 		if redactedPwd {
 			password = "[redacted]"
 		}
+		host := config.Host
+		if hasPGSQLCustomServerName() {
+			// lib/pq verifies the server certificate against the host, the
+			// connection is established to the configured host by pgsqlDialer
+			host = config.TLSServerName
+		}
 		connectionString = fmt.Sprintf("host='%v' port=%v dbname='%v' user='%v' password='%v' sslmode=%v connect_timeout=10",
-			config.Host, config.Port, config.Name, config.Username, password, getSSLMode())
+			host, config.Port, config.Name, config.Username, password, getSSLMode())
+		if config.RootCert != "" {
+			connectionString += fmt.Sprintf(" sslrootcert='%v'", config.RootCert)
+		}
+		if config.ClientCert != "" {
+			connectionString += fmt.Sprintf(" sslcert='%v' sslkey='%v'", config.ClientCert, config.ClientKey)
+		}
 	} else {
 		connectionString = config.ConnectionString
 	}
 	return connectionString
 }
 
+func hasPGSQLCustomServerName() bool {
+	return config.ConnectionString == "" && config.SSLMode > 0 && config.TLSServerName != ""
+}
+
+// pgsqlDialer always connects to the configured address, the address requested
+// by lib/pq is built using the TLS server name
+type pgsqlDialer struct {
+	address string
+	// the connection attempts are aborted if this context is canceled
+	ctx context.Context
+}
+
+func (d *pgsqlDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *pgsqlDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return d.DialContext(ctx, network, address)
+}
+
+// DialContext implements pq.DialerContext. lib/pq passes a context derived from
+// context.Background, it is used for the connect timeout only
+func (d *pgsqlDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.ctx != nil {
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(d.ctx, deadline)
+			defer cancel()
+		} else {
+			ctx = d.ctx
+		}
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, d.address)
+}
+
+// pgsqlConnector opens the connections using pgsqlDialer
+type pgsqlConnector struct {
+	dsn     string
+	address string
+}
+
+// Connect opens a new connection, pq.DialOpen does not accept a context so
+// the dialer is bound to the one of the connection request
+func (c *pgsqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return pq.DialOpen(&pgsqlDialer{address: c.address, ctx: ctx}, c.dsn)
+}
+
+func (c *pgsqlConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
 func (p *PGSQLProvider) checkAvailability() error {
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
 
-func (p *PGSQLProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
-	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.dbHandle)
+func (p *PGSQLProvider) validateUserAndPass(ctx context.Context, username, password, ip, protocol string) (User, error) {
+	return sqlCommonValidateUserAndPass(ctx, username, password, ip, protocol, p.dbHandle)
 }
 
 func (p *PGSQLProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
@@ -216,6 +338,122 @@ func (p *PGSQLProvider) validateAdminAndPass(username, password, ip string) (Adm
 	return sqlCommonValidateAdminAndPass(username, password, ip, p.dbHandle)
 }
 
+func (p *PGSQLProvider) getDefenderHostByIP(ip string, from int64) (DefenderEntry, error) {
+	return sqlCommonGetDefenderHostByIP(ip, from, p.dbHandle)
+}
+
+func (p *PGSQLProvider) isDefenderHostBanned(ip string) (DefenderEntry, error) {
+	return sqlCommonIsDefenderHostBanned(ip, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateDefenderBanTime(ip string, minutes int) error {
+	return sqlCommonUpdateDefenderBanTime(ip, minutes, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteDefenderHost(ip string) error {
+	return sqlCommonDeleteDefenderHost(ip, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addDefenderEvent(ip string, score int) error {
+	return sqlCommonAddDefenderEvent(ip, score, p.dbHandle)
+}
+
+func (p *PGSQLProvider) setDefenderBanTime(ip string, banTime int64) error {
+	return sqlCommonSetDefenderBanTime(ip, banTime, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupDefender(from int64) error {
+	return sqlCommonCleanupDefender(from, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getDefenderListEntries() ([]DefenderListEntry, error) {
+	return sqlCommonGetDefenderListEntries(p.dbHandle)
+}
+
+func (p *PGSQLProvider) addDefenderListEntry(entry *DefenderListEntry) error {
+	return sqlCommonAddDefenderListEntry(entry, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteDefenderListEntry(entry *DefenderListEntry) error {
+	return sqlCommonDeleteDefenderListEntry(entry, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addQueuedEvent(payload string, createdAt int64) error {
+	return sqlCommonAddQueuedEvent(payload, createdAt, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getQueuedEvents(limit int) ([]QueuedEvent, error) {
+	return sqlCommonGetQueuedEvents(limit, p.dbHandle)
+}
+
+func (p *PGSQLProvider) claimQueuedEvents(owner string, limit int, now, leaseExpires int64) ([]QueuedEvent, error) {
+	return sqlCommonClaimQueuedEvents(owner, limit, now, leaseExpires, p.dbHandle)
+}
+
+func (p *PGSQLProvider) renewQueuedEventsLease(owner string, leaseExpires int64) error {
+	return sqlCommonRenewQueuedEventsLease(owner, leaseExpires, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteQueuedEvent(id int64) error {
+	return sqlCommonDeleteQueuedEvent(id, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addSharedConnection(conn *SharedConnection) error {
+	return sqlCommonAddSharedConnection(conn, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteSharedConnection(nodeID, connectionID string) error {
+	return sqlCommonDeleteSharedConnection(nodeID, connectionID, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getSharedConnections(from int64) ([]SharedConnection, error) {
+	return sqlCommonGetSharedConnections(from, p.dbHandle)
+}
+
+func (p *PGSQLProvider) requestSharedConnectionClose(nodeID, connectionID string) error {
+	return sqlCommonRequestSharedConnectionClose(nodeID, connectionID, p.dbHandle)
+}
+
+func (p *PGSQLProvider) cleanupSharedConnections(from int64) error {
+	return sqlCommonCleanupSharedConnections(from, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addDropLink(link *DropLink) error {
+	return sqlCommonAddDropLink(link, p.dbHandle)
+}
+
+func (p *PGSQLProvider) dropLinkExists(linkID string) (DropLink, error) {
+	return sqlCommonGetDropLinkByID(linkID, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getDropLinks(username string) ([]DropLink, error) {
+	return sqlCommonGetDropLinks(username, p.dbHandle)
+}
+
+func (p *PGSQLProvider) deleteDropLink(linkID, username string) error {
+	return sqlCommonDeleteDropLink(linkID, username, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateDropLinkUsage(linkID string, filesAdd int, sizeAdd int64) error {
+	return sqlCommonUpdateDropLinkUsage(linkID, filesAdd, sizeAdd, p.dbHandle)
+}
+
+func (p *PGSQLProvider) addApprovalRequest(req *ApprovalRequest) error {
+	return sqlCommonAddApprovalRequest(req, p.dbHandle)
+}
+
+func (p *PGSQLProvider) approvalRequestExists(id string) (ApprovalRequest, error) {
+	return sqlCommonGetApprovalRequestByID(id, p.dbHandle)
+}
+
+func (p *PGSQLProvider) getApprovalRequests(limit, offset int) ([]ApprovalRequest, error) {
+	return sqlCommonGetApprovalRequests(limit, offset, p.dbHandle)
+}
+
+func (p *PGSQLProvider) updateApprovalRequestStatus(req *ApprovalRequest, fromStatus string) error {
+	return sqlCommonUpdateApprovalRequestStatus(req, fromStatus, p.dbHandle)
+}
+
 func (p *PGSQLProvider) close() error {
 	return p.dbHandle.Close()
 }
@@ -263,6 +501,8 @@ func (p *PGSQLProvider) migrateDatabase() error {
 		return err
 	case version == 8:
 		return updatePGSQLDatabaseFromV8(p.dbHandle)
+	case version == 9:
+		return updatePGSQLDatabaseFromV9(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelWarn, "database version %v is newer than the supported one: %v", version,
@@ -287,19 +527,35 @@ func (p *PGSQLProvider) revertDatabase(targetVersion int) error {
 	switch dbVersion.Version {
 	case 9:
 		return downgradePGSQLDatabaseFromV9(p.dbHandle)
+	case 10:
+		return downgradePGSQLDatabaseFromV10(p.dbHandle)
 	default:
 		return fmt.Errorf("database version not handled: %v", dbVersion.Version)
 	}
 }
 
 func updatePGSQLDatabaseFromV8(dbHandle *sql.DB) error {
-	return updatePGSQLDatabaseFrom8To9(dbHandle)
+	if err := updatePGSQLDatabaseFrom8To9(dbHandle); err != nil {
+		return err
+	}
+	return updatePGSQLDatabaseFromV9(dbHandle)
+}
+
+func updatePGSQLDatabaseFromV9(dbHandle *sql.DB) error {
+	return updatePGSQLDatabaseFrom9To10(dbHandle)
 }
 
 func downgradePGSQLDatabaseFromV9(dbHandle *sql.DB) error {
 	return downgradePGSQLDatabaseFrom9To8(dbHandle)
 }
 
+func downgradePGSQLDatabaseFromV10(dbHandle *sql.DB) error {
+	if err := downgradePGSQLDatabaseFrom10To9(dbHandle); err != nil {
+		return err
+	}
+	return downgradePGSQLDatabaseFromV9(dbHandle)
+}
+
 func updatePGSQLDatabaseFrom8To9(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database version: 8 -> 9")
 	providerLog(logger.LevelInfo, "updating database version: 8 -> 9")
@@ -317,3 +573,36 @@ func downgradePGSQLDatabaseFrom9To8(dbHandle *sql.DB) error {
 	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
 	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 8)
 }
+
+func updatePGSQLDatabaseFrom9To10(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 9 -> 10")
+	providerLog(logger.LevelInfo, "updating database version: 9 -> 10")
+	sql := strings.ReplaceAll(pgsqlV10SQL, "{{defender_hosts}}", sqlTableDefenderHosts)
+	sql = strings.ReplaceAll(sql, "{{defender_events}}", sqlTableDefenderEvents)
+	sql = strings.ReplaceAll(sql, "{{defender_lists}}", sqlTableDefenderLists)
+	sql = strings.ReplaceAll(sql, "{{events_queue}}", sqlTableEventsQueue)
+	sql = strings.ReplaceAll(sql, "{{shared_connections}}", sqlTableSharedConns)
+	sql = strings.ReplaceAll(sql, "{{drop_links}}", sqlTableDropLinks)
+	sql = strings.ReplaceAll(sql, "{{approval_requests}}", sqlTableApprovals)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{admins}}", sqlTableAdmins)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 10)
+}
+
+func downgradePGSQLDatabaseFrom10To9(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 10 -> 9")
+	providerLog(logger.LevelInfo, "downgrading database version: 10 -> 9")
+	sql := strings.ReplaceAll(pgsqlV10DownSQL, "{{defender_hosts}}", sqlTableDefenderHosts)
+	sql = strings.ReplaceAll(sql, "{{defender_events}}", sqlTableDefenderEvents)
+	sql = strings.ReplaceAll(sql, "{{defender_lists}}", sqlTableDefenderLists)
+	sql = strings.ReplaceAll(sql, "{{events_queue}}", sqlTableEventsQueue)
+	sql = strings.ReplaceAll(sql, "{{shared_connections}}", sqlTableSharedConns)
+	sql = strings.ReplaceAll(sql, "{{drop_links}}", sqlTableDropLinks)
+	sql = strings.ReplaceAll(sql, "{{approval_requests}}", sqlTableApprovals)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{admins}}", sqlTableAdmins)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 9)
+}
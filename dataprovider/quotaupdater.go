@@ -1,6 +1,7 @@
 package dataprovider
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -24,12 +25,17 @@ type quotaUpdater struct {
 	sync.RWMutex
 	pendingUserQuotaUpdates   map[string]quotaObject
 	pendingFolderQuotaUpdates map[string]quotaObject
+	// used quota for the user directories with quota restrictions,
+	// the key is the username and then the directory virtual path.
+	// Directory quotas are not stored within the data provider
+	usedDirQuotas map[string]map[string]quotaObject
 }
 
 func newQuotaUpdater() quotaUpdater {
 	return quotaUpdater{
 		pendingUserQuotaUpdates:   make(map[string]quotaObject),
 		pendingFolderQuotaUpdates: make(map[string]quotaObject),
+		usedDirQuotas:             make(map[string]map[string]quotaObject),
 	}
 }
 
@@ -130,6 +136,56 @@ func (q *quotaUpdater) getFolderPendingQuota(name string) (int, int64) {
 	return obj.files, obj.size
 }
 
+func (q *quotaUpdater) resetUserDirQuotas(username string) {
+	q.Lock()
+	defer q.Unlock()
+
+	delete(q.usedDirQuotas, username)
+}
+
+func (q *quotaUpdater) setDirQuota(username, dirPath string, files int, size int64) {
+	q.Lock()
+	defer q.Unlock()
+
+	if _, ok := q.usedDirQuotas[username]; !ok {
+		q.usedDirQuotas[username] = make(map[string]quotaObject)
+	}
+	q.usedDirQuotas[username][dirPath] = quotaObject{
+		size:  size,
+		files: files,
+	}
+}
+
+// updateDirQuota updates the used quota for the given directory if already known.
+// Unknown directories will be scanned when needed
+func (q *quotaUpdater) updateDirQuota(username, dirPath string, files int, size int64) {
+	q.Lock()
+	defer q.Unlock()
+
+	obj, ok := q.usedDirQuotas[username][dirPath]
+	if !ok {
+		return
+	}
+	obj.size += size
+	obj.files += files
+	if obj.size < 0 {
+		obj.size = 0
+	}
+	if obj.files < 0 {
+		obj.files = 0
+	}
+	q.usedDirQuotas[username][dirPath] = obj
+}
+
+func (q *quotaUpdater) getDirQuota(username, dirPath string) (int, int64, bool) {
+	q.RLock()
+	defer q.RUnlock()
+
+	obj, ok := q.usedDirQuotas[username][dirPath]
+
+	return obj.files, obj.size, ok
+}
+
 func (q *quotaUpdater) getUsernames() []string {
 	q.RLock()
 	defer q.RUnlock()
@@ -158,7 +214,9 @@ func (q *quotaUpdater) storeUsersQuota() {
 	for _, username := range q.getUsernames() {
 		files, size := q.getUserPendingQuota(username)
 		if size != 0 || files != 0 {
+			query := startQuery(context.Background(), "update_quota")
 			err := provider.updateQuota(username, files, size, false)
+			query.end(err)
 			if err != nil {
 				providerLog(logger.LevelWarn, "unable to update quota delayed for user %#v: %v", username, err)
 				continue
@@ -172,7 +230,9 @@ func (q *quotaUpdater) storeFoldersQuota() {
 	for _, name := range q.getFoldernames() {
 		files, size := q.getFolderPendingQuota(name)
 		if size != 0 || files != 0 {
+			query := startQuery(context.Background(), "update_folder_quota")
 			err := provider.updateFolderQuota(name, files, size, false)
+			query.end(err)
 			if err != nil {
 				providerLog(logger.LevelWarn, "unable to update quota delayed for folder %#v: %v", name, err)
 				continue
@@ -69,6 +69,66 @@ func TestFolderQuotaUpdater(t *testing.T) {
 	assert.Len(t, q.getFoldernames(), 0)
 }
 
+func TestDirQuotaUpdater(t *testing.T) {
+	user1 := "user1"
+	dir1 := "/dir1"
+	q := newQuotaUpdater()
+	_, _, ok := q.getDirQuota(user1, dir1)
+	assert.False(t, ok)
+	// unknown directories are not updated, they will be scanned
+	q.updateDirQuota(user1, dir1, 1, 100)
+	_, _, ok = q.getDirQuota(user1, dir1)
+	assert.False(t, ok)
+
+	q.setDirQuota(user1, dir1, 10, 1234)
+	q.updateDirQuota(user1, dir1, 1, 100)
+	files, size, ok := q.getDirQuota(user1, dir1)
+	assert.True(t, ok)
+	assert.Equal(t, 11, files)
+	assert.Equal(t, int64(1334), size)
+
+	q.updateDirQuota(user1, dir1, -20, -2000)
+	files, size, ok = q.getDirQuota(user1, dir1)
+	assert.True(t, ok)
+	assert.Equal(t, 0, files)
+	assert.Equal(t, int64(0), size)
+
+	q.resetUserDirQuotas(user1)
+	_, _, ok = q.getDirQuota(user1, dir1)
+	assert.False(t, ok)
+}
+
+func TestValidateDirQuotas(t *testing.T) {
+	user := User{}
+	user.Filters.DirQuotas = []DirQuota{
+		{
+			Path:      "/dir1/",
+			QuotaSize: 100,
+		},
+	}
+	err := validateDirQuotas(&user)
+	assert.NoError(t, err)
+	assert.Equal(t, "/dir1", user.Filters.DirQuotas[0].Path)
+
+	user.Filters.DirQuotas = append(user.Filters.DirQuotas, DirQuota{
+		Path:       "/dir1",
+		QuotaFiles: 10,
+	})
+	err = validateDirQuotas(&user)
+	assert.Error(t, err)
+
+	for _, q := range []DirQuota{
+		{Path: "/", QuotaSize: 100},
+		{Path: "relative", QuotaSize: 100},
+		{Path: "/dir", QuotaSize: -1},
+		{Path: "/dir"},
+	} {
+		user.Filters.DirQuotas = []DirQuota{q}
+		err = validateDirQuotas(&user)
+		assert.Error(t, err, "path %v", q.Path)
+	}
+}
+
 func TestQuotaUpdater(t *testing.T) {
 	c := Config{
 		Driver:          "sqlite",
@@ -0,0 +1,200 @@
+package dataprovider
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+const reencryptPageSize = 100
+
+var reencryptInProgress int32
+
+// ReencryptResult defines the results of a secrets re-encryption
+type ReencryptResult struct {
+	// number of users with at least a re-encrypted secret
+	Users int `json:"users"`
+	// number of folders with at least a re-encrypted secret
+	Folders int `json:"folders"`
+	// number of re-encrypted secrets
+	Secrets int `json:"secrets"`
+	// number of secrets already encrypted using the current KMS configuration
+	Skipped int `json:"skipped"`
+	// errors for the users and folders that cannot be re-encrypted
+	Errors []string `json:"errors"`
+}
+
+// ReencryptProgressFn defines the function called after processing each user
+// or folder with encrypted secrets
+type ReencryptProgressFn func(objectType, name string, secrets int, err error)
+
+// ReencryptSecrets decrypts the secrets stored for users and folders using the
+// previous KMS configuration and encrypts them again using the active one.
+// The secrets that cannot be decrypted using the previous configuration but
+// can be decrypted using the active one are skipped, so the re-encryption can
+// be safely executed more than once.
+// If dryRun is true the secrets are decrypted but nothing is saved.
+// An error is returned only if the users or folders cannot be listed, the
+// errors for the single objects are reported within the results
+func ReencryptSecrets(previous *kms.Configuration, dryRun bool, progress ReencryptProgressFn) (ReencryptResult, error) {
+	result := ReencryptResult{
+		Errors: []string{},
+	}
+	if !atomic.CompareAndSwapInt32(&reencryptInProgress, 0, 1) {
+		return result, &ValidationError{err: "a secrets re-encryption is already in progress"}
+	}
+	defer atomic.StoreInt32(&reencryptInProgress, 0)
+
+	if err := previous.LoadMasterKey(); err != nil {
+		return result, &ValidationError{err: fmt.Sprintf("unable to load the previous master key: %v", err)}
+	}
+	providerLog(logger.LevelInfo, "start re-encrypting the secrets, dry run: %v", dryRun)
+
+	for offset := 0; ; offset += reencryptPageSize {
+		users, err := GetUsers(reencryptPageSize, offset, OrderASC)
+		if err != nil {
+			return result, err
+		}
+		for idx := range users {
+			secrets, err := reencryptUser(users[idx].Username, previous, dryRun, &result)
+			reportReencryptProgress(ActionObjectUser, users[idx].Username, secrets, err, &result, progress)
+			if err == nil && secrets > 0 {
+				result.Users++
+			}
+		}
+		if len(users) < reencryptPageSize {
+			break
+		}
+	}
+	for offset := 0; ; offset += reencryptPageSize {
+		folders, err := GetFolders(reencryptPageSize, offset, OrderASC)
+		if err != nil {
+			return result, err
+		}
+		for idx := range folders {
+			secrets, err := reencryptFolder(folders[idx].Name, previous, dryRun, &result)
+			reportReencryptProgress(ActionObjectFolder, folders[idx].Name, secrets, err, &result, progress)
+			if err == nil && secrets > 0 {
+				result.Folders++
+			}
+		}
+		if len(folders) < reencryptPageSize {
+			break
+		}
+	}
+	providerLog(logger.LevelInfo, "secrets re-encryption completed, users: %v, folders: %v, secrets: %v, "+
+		"skipped: %v, errors: %v, dry run: %v", result.Users, result.Folders, result.Secrets, result.Skipped,
+		len(result.Errors), dryRun)
+	return result, nil
+}
+
+func reportReencryptProgress(objectType, name string, secrets int, err error, result *ReencryptResult,
+	progress ReencryptProgressFn,
+) {
+	if err != nil {
+		providerLog(logger.LevelWarn, "unable to re-encrypt the secrets for %v %#v: %v", objectType, name, err)
+		result.Errors = append(result.Errors, fmt.Sprintf("%v %#v: %v", objectType, name, err))
+	} else if secrets > 0 {
+		providerLog(logger.LevelDebug, "%v secrets re-encrypted for %v %#v", secrets, objectType, name)
+	}
+	if progress != nil && (err != nil || secrets > 0) {
+		progress(objectType, name, secrets, err)
+	}
+}
+
+func reencryptUser(username string, previous *kms.Configuration, dryRun bool, result *ReencryptResult) (int, error) {
+	user, err := UserExists(username)
+	if err != nil {
+		return 0, err
+	}
+	secrets, err := reencryptFsSecrets(&user.FsConfig, user.GetGCSCredentialsFilePath(), previous, result)
+	if err != nil || secrets == 0 || dryRun {
+		return secrets, err
+	}
+	return secrets, UpdateUser(&user)
+}
+
+func reencryptFolder(name string, previous *kms.Configuration, dryRun bool, result *ReencryptResult) (int, error) {
+	folder, err := GetFolderByName(name)
+	if err != nil {
+		return 0, err
+	}
+	secrets, err := reencryptFsSecrets(&folder.FsConfig, folder.GetGCSCredentialsFilePath(), previous, result)
+	if err != nil || secrets == 0 || dryRun {
+		return secrets, err
+	}
+	return secrets, UpdateFolder(&folder, folder.Users)
+}
+
+// reencryptFsSecrets decrypts the filesystem secrets using the previous KMS
+// configuration, the decrypted secrets will be encrypted using the active
+// configuration while saving the filesystem config
+func reencryptFsSecrets(fsConfig *vfs.Filesystem, gcsCredentialsPath string, previous *kms.Configuration,
+	result *ReencryptResult,
+) (int, error) {
+	fsConfig.SetEmptySecretsIfNil()
+	if err := loadGCSCredentialsFromFile(fsConfig, gcsCredentialsPath); err != nil {
+		return 0, err
+	}
+	var secrets []*kms.Secret
+	switch fsConfig.Provider {
+	case vfs.S3FilesystemProvider:
+		secrets = []*kms.Secret{fsConfig.S3Config.AccessSecret, fsConfig.S3Config.SSECustomerKey}
+	case vfs.GCSFilesystemProvider:
+		secrets = []*kms.Secret{fsConfig.GCSConfig.Credentials}
+	case vfs.AzureBlobFilesystemProvider:
+		secrets = []*kms.Secret{fsConfig.AzBlobConfig.AccountKey}
+	case vfs.CryptedFilesystemProvider:
+		secrets = []*kms.Secret{fsConfig.CryptConfig.Passphrase}
+	case vfs.SFTPFilesystemProvider:
+		secrets = []*kms.Secret{fsConfig.SFTPConfig.Password, fsConfig.SFTPConfig.PrivateKey}
+	case vfs.PluginFilesystemProvider:
+		secrets = []*kms.Secret{fsConfig.PluginConfig.Options}
+	case vfs.SMBFilesystemProvider:
+		secrets = []*kms.Secret{fsConfig.SMBConfig.Password}
+	}
+	reencrypted := 0
+	skipped := 0
+	for _, secret := range secrets {
+		if !secret.IsEncrypted() {
+			continue
+		}
+		if err := secret.DecryptWith(previous); err != nil {
+			// the secret could be already encrypted using the active configuration
+			if errCurrent := secret.Clone().Decrypt(); errCurrent == nil {
+				skipped++
+				continue
+			}
+			return 0, fmt.Errorf("unable to decrypt a %v secret: %w", secret.GetStatus(), err)
+		}
+		reencrypted++
+	}
+	result.Secrets += reencrypted
+	result.Skipped += skipped
+	return reencrypted, nil
+}
+
+// loadGCSCredentialsFromFile loads the GCS credentials stored outside the
+// data provider, they will be saved again while updating the filesystem config
+func loadGCSCredentialsFromFile(fsConfig *vfs.Filesystem, credentialsPath string) error {
+	if fsConfig.Provider != vfs.GCSFilesystemProvider || fsConfig.GCSConfig.AutomaticCredentials > 0 {
+		return nil
+	}
+	if !fsConfig.GCSConfig.Credentials.IsEmpty() {
+		return nil
+	}
+	creds, err := os.ReadFile(credentialsPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(creds, fsConfig.GCSConfig.Credentials)
+}
@@ -0,0 +1,77 @@
+package dataprovider
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// SharedConnection defines an active connection stored within the shared
+// connections registry. Multiple SFTPGo instances sharing the same data provider
+// use this registry to see the connections handled by the other instances.
+// The payload is opaque for the data provider
+type SharedConnection struct {
+	// connection identifier, it is unique within a node
+	ConnectionID string `json:"connection_id"`
+	// identifier for the node handling the connection
+	NodeID   string `json:"node_id"`
+	Username string `json:"username"`
+	Payload  string `json:"payload"`
+	// last update as unix timestamp in milliseconds
+	UpdatedAt int64 `json:"updated_at"`
+	// true if another node asked to close this connection
+	CloseRequested bool `json:"close_requested"`
+}
+
+// getKey returns the key used to store the connection within the memory and bolt providers
+func (c *SharedConnection) getKey() string {
+	return fmt.Sprintf("%v:%v", c.NodeID, c.ConnectionID)
+}
+
+// AddSharedConnection adds or updates the given connection within the shared
+// connections registry. A pending close request is preserved on update
+func AddSharedConnection(conn *SharedConnection) error {
+	if provider == nil {
+		return ErrProviderNotInitialized
+	}
+	conn.UpdatedAt = utils.GetTimeAsMsSinceEpoch(time.Now())
+	return provider.addSharedConnection(conn)
+}
+
+// DeleteSharedConnection removes the connection with the given id, handled
+// by the specified node, from the shared connections registry
+func DeleteSharedConnection(nodeID, connectionID string) error {
+	if provider == nil {
+		return ErrProviderNotInitialized
+	}
+	return provider.deleteSharedConnection(nodeID, connectionID)
+}
+
+// GetSharedConnections returns the connections updated after from, expressed
+// as unix timestamp in milliseconds
+func GetSharedConnections(from int64) ([]SharedConnection, error) {
+	if provider == nil {
+		return nil, ErrProviderNotInitialized
+	}
+	return provider.getSharedConnections(from)
+}
+
+// RequestSharedConnectionClose asks the node handling the connection with
+// the given id to close it
+func RequestSharedConnectionClose(nodeID, connectionID string) error {
+	if provider == nil {
+		return ErrProviderNotInitialized
+	}
+	return provider.requestSharedConnectionClose(nodeID, connectionID)
+}
+
+// CleanupSharedConnections removes the connections not updated after from,
+// expressed as unix timestamp in milliseconds. These connections belong to
+// nodes that are no longer running
+func CleanupSharedConnections(from int64) error {
+	if provider == nil {
+		return ErrProviderNotInitialized
+	}
+	return provider.cleanupSharedConnections(from)
+}
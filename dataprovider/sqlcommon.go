@@ -18,7 +18,7 @@ import (
 )
 
 const (
-	sqlDatabaseVersion     = 9
+	sqlDatabaseVersion     = 10
 	defaultSQLQueryTimeout = 10 * time.Second
 	longSQLQueryTimeout    = 60 * time.Second
 )
@@ -116,9 +116,16 @@ func sqlCommonUpdateAdmin(admin *Admin, dbHandle *sql.DB) error {
 		return err
 	}
 
-	_, err = stmt.ExecContext(ctx, admin.Password, admin.Status, admin.Email, string(perms), string(filters),
-		admin.AdditionalInfo, admin.Description, admin.Username)
-	return err
+	res, err := stmt.ExecContext(ctx, admin.Password, admin.Status, admin.Email, string(perms), string(filters),
+		admin.AdditionalInfo, admin.Description, admin.Username, admin.Version)
+	if err != nil {
+		return err
+	}
+	if err := sqlCommonCheckVersionedUpdate(res); err != nil {
+		return err
+	}
+	admin.Version++
+	return nil
 }
 
 func sqlCommonDeleteAdmin(admin *Admin, dbHandle *sql.DB) error {
@@ -216,7 +223,7 @@ func sqlCommonGetUserByUsername(username string, dbHandle sqlQuerier) (User, err
 	return getUserWithVirtualFolders(ctx, user, dbHandle)
 }
 
-func sqlCommonValidateUserAndPass(username, password, ip, protocol string, dbHandle *sql.DB) (User, error) {
+func sqlCommonValidateUserAndPass(ctx context.Context, username, password, ip, protocol string, dbHandle *sql.DB) (User, error) {
 	var user User
 	if password == "" {
 		return user, errors.New("credentials cannot be null or empty")
@@ -226,7 +233,7 @@ func sqlCommonValidateUserAndPass(username, password, ip, protocol string, dbHan
 		providerLog(logger.LevelWarn, "error authenticating user %#v: %v", username, err)
 		return user, err
 	}
-	return checkUserAndPass(&user, password, ip, protocol)
+	return checkUserAndPass(ctx, &user, password, ip, protocol)
 }
 
 func sqlCommonValidateUserAndTLSCertificate(username, protocol string, tlsCert *x509.Certificate, dbHandle *sql.DB) (User, error) {
@@ -395,13 +402,20 @@ func sqlCommonUpdateUser(user *User, dbHandle *sql.DB) error {
 		if err != nil {
 			return err
 		}
-		_, err = stmt.ExecContext(ctx, user.Password, string(publicKeys), user.HomeDir, user.UID, user.GID, user.MaxSessions, user.QuotaSize,
-			user.QuotaFiles, string(permissions), user.UploadBandwidth, user.DownloadBandwidth, user.Status, user.ExpirationDate,
-			string(filters), string(fsConfig), user.AdditionalInfo, user.Description, user.ID)
+		res, err := stmt.ExecContext(ctx, user.Password, string(publicKeys), user.HomeDir, user.UID, user.GID, user.MaxSessions,
+			user.QuotaSize, user.QuotaFiles, string(permissions), user.UploadBandwidth, user.DownloadBandwidth, user.Status,
+			user.ExpirationDate, string(filters), string(fsConfig), user.AdditionalInfo, user.Description, user.ID, user.Version)
 		if err != nil {
 			return err
 		}
-		return generateVirtualFoldersMapping(ctx, user, tx)
+		if err := sqlCommonCheckVersionedUpdate(res); err != nil {
+			return err
+		}
+		if err := generateVirtualFoldersMapping(ctx, user, tx); err != nil {
+			return err
+		}
+		user.Version++
+		return nil
 	})
 }
 
@@ -490,7 +504,7 @@ func getAdminFromDbRow(row sqlScanner) (Admin, error) {
 	var email, filters, additionalInfo, permissions, description sql.NullString
 
 	err := row.Scan(&admin.ID, &admin.Username, &admin.Password, &admin.Status, &email, &permissions,
-		&filters, &additionalInfo, &description)
+		&filters, &additionalInfo, &description, &admin.Version)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -540,7 +554,7 @@ func getUserFromDbRow(row sqlScanner) (User, error) {
 	err := row.Scan(&user.ID, &user.Username, &password, &publicKey, &user.HomeDir, &user.UID, &user.GID, &user.MaxSessions,
 		&user.QuotaSize, &user.QuotaFiles, &permissions, &user.UsedQuotaSize, &user.UsedQuotaFiles, &user.LastQuotaUpdate,
 		&user.UploadBandwidth, &user.DownloadBandwidth, &user.ExpirationDate, &user.LastLogin, &user.Status, &filters, &fsConfig,
-		&additionalInfo, &description)
+		&additionalInfo, &description, &user.Version)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return user, &RecordNotFoundError{err: err.Error()}
@@ -593,17 +607,18 @@ func getUserFromDbRow(row sqlScanner) (User, error) {
 	return user, err
 }
 
-func sqlCommonCheckFolderExists(ctx context.Context, name string, dbHandle sqlQuerier) error {
-	var folderName string
-	q := checkFolderNameQuery()
+func sqlCommonGetFolderVersion(ctx context.Context, name string, dbHandle sqlQuerier) (int64, error) {
+	var version int64
+	q := getFolderVersionQuery()
 	stmt, err := dbHandle.PrepareContext(ctx, q)
 	if err != nil {
 		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
-		return err
+		return version, err
 	}
 	defer stmt.Close()
 	row := stmt.QueryRowContext(ctx, name)
-	return row.Scan(&folderName)
+	err = row.Scan(&version)
+	return version, err
 }
 
 func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (vfs.BaseVirtualFolder, error) {
@@ -618,7 +633,7 @@ func sqlCommonGetFolder(ctx context.Context, name string, dbHandle sqlQuerier) (
 	row := stmt.QueryRowContext(ctx, name)
 	var mappedPath, description, fsConfig sql.NullString
 	err = row.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles, &folder.LastQuotaUpdate,
-		&folder.Name, &description, &fsConfig)
+		&folder.Name, &description, &fsConfig, &folder.Version)
 	if err == sql.ErrNoRows {
 		return folder, &RecordNotFoundError{err: err.Error()}
 	}
@@ -657,9 +672,11 @@ func sqlCommonAddOrUpdateFolder(ctx context.Context, baseFolder *vfs.BaseVirtual
 	usedQuotaFiles int, lastQuotaUpdate int64, dbHandle sqlQuerier) (vfs.BaseVirtualFolder, error) {
 	var folder vfs.BaseVirtualFolder
 	// FIXME: we could use an UPSERT here, this SELECT could be racy
-	err := sqlCommonCheckFolderExists(ctx, baseFolder.Name, dbHandle)
+	version, err := sqlCommonGetFolderVersion(ctx, baseFolder.Name, dbHandle)
 	switch err {
 	case nil:
+		// the folder definition embedded in the user is applied as is
+		baseFolder.Version = version
 		err = sqlCommonUpdateFolder(baseFolder, dbHandle)
 		if err != nil {
 			return folder, err
@@ -720,8 +737,28 @@ func sqlCommonUpdateFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) e
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.ExecContext(ctx, folder.MappedPath, folder.Description, string(fsConfig), folder.Name)
-	return err
+	res, err := stmt.ExecContext(ctx, folder.MappedPath, folder.Description, string(fsConfig), folder.Name, folder.Version)
+	if err != nil {
+		return err
+	}
+	if err := sqlCommonCheckVersionedUpdate(res); err != nil {
+		return err
+	}
+	folder.Version++
+	return nil
+}
+
+// sqlCommonCheckVersionedUpdate returns ErrVersionMismatch if an update
+// conditioned on the expected version did not modify any row
+func sqlCommonCheckVersionedUpdate(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrVersionMismatch
+	}
+	return nil
 }
 
 func sqlCommonDeleteFolder(folder *vfs.BaseVirtualFolder, dbHandle sqlQuerier) error {
@@ -758,7 +795,7 @@ func sqlCommonDumpFolders(dbHandle sqlQuerier) ([]vfs.BaseVirtualFolder, error)
 		var folder vfs.BaseVirtualFolder
 		var mappedPath, description, fsConfig sql.NullString
 		err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &folder.Version)
 		if err != nil {
 			return folders, err
 		}
@@ -805,7 +842,7 @@ func sqlCommonGetFolders(limit, offset int, order string, dbHandle sqlQuerier) (
 		var folder vfs.BaseVirtualFolder
 		var mappedPath, description, fsConfig sql.NullString
 		err = rows.Scan(&folder.ID, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
-			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig)
+			&folder.LastQuotaUpdate, &folder.Name, &description, &fsConfig, &folder.Version)
 		if err != nil {
 			return folders, err
 		}
@@ -912,7 +949,7 @@ func getUsersWithVirtualFolders(ctx context.Context, users []User, dbHandle sqlQ
 		var mappedPath, fsConfig, description sql.NullString
 		err = rows.Scan(&folder.ID, &folder.Name, &mappedPath, &folder.UsedQuotaSize, &folder.UsedQuotaFiles,
 			&folder.LastQuotaUpdate, &folder.VirtualPath, &folder.QuotaSize, &folder.QuotaFiles, &userID, &fsConfig,
-			&description)
+			&description, &folder.Version)
 		if err != nil {
 			return users, err
 		}
@@ -1029,6 +1066,622 @@ func sqlCommonGetFolderUsedQuota(mappedPath string, dbHandle *sql.DB) (int, int6
 	return usedFiles, usedSize, err
 }
 
+func sqlCommonGetDefenderHostByIP(ip string, from int64, dbHandle sqlQuerier) (DefenderEntry, error) {
+	var entry DefenderEntry
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDefenderHostQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return entry, err
+	}
+	defer stmt.Close()
+	var score sql.NullInt64
+	err = stmt.QueryRowContext(ctx, from, ip).Scan(&entry.IP, &entry.BanTime, &score)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entry, &RecordNotFoundError{err: fmt.Sprintf("defender host %#v does not exist", ip)}
+		}
+		return entry, err
+	}
+	if score.Valid {
+		entry.Score = int(score.Int64)
+	}
+	return entry, nil
+}
+
+func sqlCommonIsDefenderHostBanned(ip string, dbHandle sqlQuerier) (DefenderEntry, error) {
+	var entry DefenderEntry
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDefenderBanTimeQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return entry, err
+	}
+	defer stmt.Close()
+	err = stmt.QueryRowContext(ctx, ip, utils.GetTimeAsMsSinceEpoch(time.Now())).Scan(&entry.IP, &entry.BanTime)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entry, &RecordNotFoundError{err: fmt.Sprintf("defender host %#v is not banned", ip)}
+		}
+		return entry, err
+	}
+	return entry, nil
+}
+
+func sqlCommonUpdateDefenderBanTime(ip string, minutes int, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getUpdateDefenderBanTimeQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, int64(minutes)*60*1000, utils.GetTimeAsMsSinceEpoch(time.Now()), ip)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error updating ban time for defender host %#v: %v", ip, err)
+	}
+	return err
+}
+
+func sqlCommonDeleteDefenderHost(ip string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		if err := sqlCommonExecDefenderQuery(ctx, getDeleteDefenderHostEventsQuery(), tx, ip); err != nil {
+			return err
+		}
+		q := getDeleteDefenderHostQuery()
+		stmt, err := tx.PrepareContext(ctx, q)
+		if err != nil {
+			providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+			return err
+		}
+		defer stmt.Close()
+		res, err := stmt.ExecContext(ctx, ip)
+		if err != nil {
+			return err
+		}
+		if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+			return &RecordNotFoundError{err: fmt.Sprintf("defender host %#v does not exist", ip)}
+		}
+		return nil
+	})
+}
+
+func sqlCommonAddDefenderEvent(ip string, score int, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		if err := sqlCommonExecDefenderQuery(ctx, getAddDefenderHostQuery(), tx, ip, now); err != nil {
+			return err
+		}
+		return sqlCommonExecDefenderQuery(ctx, getAddDefenderEventQuery(), tx, now, score, ip)
+	})
+}
+
+func sqlCommonSetDefenderBanTime(ip string, banTime int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	now := utils.GetTimeAsMsSinceEpoch(time.Now())
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		if err := sqlCommonExecDefenderQuery(ctx, getAddDefenderHostQuery(), tx, ip, now); err != nil {
+			return err
+		}
+		if err := sqlCommonExecDefenderQuery(ctx, getSetDefenderBanTimeQuery(), tx, banTime, now, ip); err != nil {
+			return err
+		}
+		return sqlCommonExecDefenderQuery(ctx, getDeleteDefenderHostEventsQuery(), tx, ip)
+	})
+}
+
+func sqlCommonCleanupDefender(from int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), longSQLQueryTimeout)
+	defer cancel()
+	return sqlCommonExecuteTx(ctx, dbHandle, func(tx *sql.Tx) error {
+		if err := sqlCommonExecDefenderQuery(ctx, getDeleteOldDefenderEventsQuery(), tx, from); err != nil {
+			return err
+		}
+		return sqlCommonExecDefenderQuery(ctx, getDeleteExpiredDefenderHostsQuery(), tx,
+			utils.GetTimeAsMsSinceEpoch(time.Now()))
+	})
+}
+
+func sqlCommonGetDefenderListEntries(dbHandle sqlQuerier) ([]DefenderListEntry, error) {
+	entries := make([]DefenderListEntry, 0, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDefenderListEntriesQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry DefenderListEntry
+		var description sql.NullString
+		if err := rows.Scan(&entry.Type, &entry.Network, &description, &entry.CreatedAt); err != nil {
+			return entries, err
+		}
+		if description.Valid {
+			entry.Description = description.String
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func sqlCommonAddDefenderListEntry(entry *DefenderListEntry, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	return sqlCommonExecDefenderQuery(ctx, getAddDefenderListEntryQuery(), dbHandle, entry.Type, entry.Network,
+		entry.Description, entry.CreatedAt)
+}
+
+func sqlCommonDeleteDefenderListEntry(entry *DefenderListEntry, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDeleteDefenderListEntryQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, entry.Type, entry.Network)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return &RecordNotFoundError{err: fmt.Sprintf("defender list entry %#v does not exist", entry.Network)}
+	}
+	return nil
+}
+
+func sqlCommonAddQueuedEvent(payload string, createdAt int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getAddQueuedEventQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, payload, createdAt)
+	return err
+}
+
+func sqlCommonGetQueuedEvents(limit int, dbHandle sqlQuerier) ([]QueuedEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	return sqlCommonQueryQueuedEvents(ctx, getQueuedEventsQuery(), dbHandle, limit)
+}
+
+// sqlCommonClaimQueuedEvents claims the events with an expired lease. The claim
+// is a conditional update so an event is claimed by a single owner even if
+// multiple instances share the same database
+func sqlCommonClaimQueuedEvents(owner string, limit int, now, leaseExpires int64, dbHandle *sql.DB) ([]QueuedEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	events, err := sqlCommonQueryQueuedEvents(ctx, getClaimableQueuedEventsQuery(), dbHandle, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	q := getClaimQueuedEventQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+	claimed := make([]QueuedEvent, 0, len(events))
+	for _, event := range events {
+		res, err := stmt.ExecContext(ctx, owner, leaseExpires, event.ID, now)
+		if err != nil {
+			return claimed, err
+		}
+		rows, err := res.RowsAffected()
+		if err != nil {
+			return claimed, err
+		}
+		if rows == 0 {
+			// claimed by another owner
+			continue
+		}
+		event.ClaimedBy = owner
+		event.LeaseExpires = leaseExpires
+		claimed = append(claimed, event)
+	}
+	return claimed, nil
+}
+
+func sqlCommonRenewQueuedEventsLease(owner string, leaseExpires int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getRenewQueuedEventsLeaseQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, leaseExpires, owner)
+	return err
+}
+
+func sqlCommonQueryQueuedEvents(ctx context.Context, q string, dbHandle sqlQuerier, args ...interface{}) ([]QueuedEvent, error) {
+	var events []QueuedEvent
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, args...)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event QueuedEvent
+		if err := rows.Scan(&event.ID, &event.Payload, &event.CreatedAt, &event.ClaimedBy, &event.LeaseExpires); err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func sqlCommonDeleteQueuedEvent(id int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDeleteQueuedEventQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return &RecordNotFoundError{err: fmt.Sprintf("queued event %v does not exist", id)}
+	}
+	return nil
+}
+
+func sqlCommonAddSharedConnection(conn *SharedConnection, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getAddSharedConnectionQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, conn.ConnectionID, conn.NodeID, conn.Username, conn.Payload, conn.UpdatedAt)
+	return err
+}
+
+func sqlCommonDeleteSharedConnection(nodeID, connectionID string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	return sqlCommonUpdateSharedConnection(ctx, getDeleteSharedConnectionQuery(), nodeID, connectionID, dbHandle)
+}
+
+func sqlCommonRequestSharedConnectionClose(nodeID, connectionID string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	return sqlCommonUpdateSharedConnection(ctx, getRequestSharedConnectionCloseQuery(), nodeID, connectionID, dbHandle)
+}
+
+func sqlCommonUpdateSharedConnection(ctx context.Context, q, nodeID, connectionID string, dbHandle *sql.DB) error {
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, nodeID, connectionID)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return &RecordNotFoundError{err: fmt.Sprintf("shared connection %#v for node %#v does not exist", connectionID, nodeID)}
+	}
+	return nil
+}
+
+func sqlCommonGetSharedConnections(from int64, dbHandle sqlQuerier) ([]SharedConnection, error) {
+	conns := make([]SharedConnection, 0, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getSharedConnectionsQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, from)
+	if err != nil {
+		return conns, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var conn SharedConnection
+		var closeRequested int
+		if err := rows.Scan(&conn.ConnectionID, &conn.NodeID, &conn.Username, &conn.Payload, &conn.UpdatedAt,
+			&closeRequested); err != nil {
+			return conns, err
+		}
+		conn.CloseRequested = closeRequested > 0
+		conns = append(conns, conn)
+	}
+	return conns, rows.Err()
+}
+
+func sqlCommonCleanupSharedConnections(from int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getCleanupSharedConnectionsQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, from)
+	return err
+}
+
+func sqlCommonAddDropLink(link *DropLink, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getAddDropLinkQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, link.LinkID, link.Description, link.Path, link.MaxSize, link.MaxFiles, link.ExpiresAt,
+		link.CreatedAt, link.Username)
+	return err
+}
+
+func sqlCommonGetDropLinkByID(linkID string, dbHandle sqlQuerier) (DropLink, error) {
+	var link DropLink
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDropLinkByIDQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return link, err
+	}
+	defer stmt.Close()
+	link, err = getDropLinkFromDbRow(stmt.QueryRowContext(ctx, linkID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return link, &RecordNotFoundError{err: fmt.Sprintf("drop link %#v does not exist", linkID)}
+	}
+	return link, err
+}
+
+func sqlCommonGetDropLinks(username string, dbHandle sqlQuerier) ([]DropLink, error) {
+	links := make([]DropLink, 0, 10)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDropLinksQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, username)
+	if err != nil {
+		return links, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		link, err := getDropLinkFromDbRow(rows)
+		if err != nil {
+			return links, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func sqlCommonDeleteDropLink(linkID, username string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getDeleteDropLinkQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, linkID, username)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return &RecordNotFoundError{err: fmt.Sprintf("drop link %#v for user %#v does not exist", linkID, username)}
+	}
+	return nil
+}
+
+func sqlCommonUpdateDropLinkUsage(linkID string, filesAdd int, sizeAdd int64, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getUpdateDropLinkUsageQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, filesAdd, sizeAdd, utils.GetTimeAsMsSinceEpoch(time.Now()), linkID)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return &RecordNotFoundError{err: fmt.Sprintf("drop link %#v does not exist", linkID)}
+	}
+	return nil
+}
+
+func sqlCommonAddApprovalRequest(req *ApprovalRequest, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getAddApprovalRequestQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, req.ID, req.Operation, req.ObjectName, req.Payload, req.Status, req.RequestedBy,
+		req.RequestedAt)
+	return err
+}
+
+func sqlCommonGetApprovalRequestByID(id string, dbHandle sqlQuerier) (ApprovalRequest, error) {
+	var req ApprovalRequest
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getApprovalRequestByIDQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return req, err
+	}
+	defer stmt.Close()
+	req, err = getApprovalRequestFromDbRow(stmt.QueryRowContext(ctx, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return req, &RecordNotFoundError{err: fmt.Sprintf("approval request %#v does not exist", id)}
+	}
+	return req, err
+}
+
+func sqlCommonGetApprovalRequests(limit, offset int, dbHandle sqlQuerier) ([]ApprovalRequest, error) {
+	requests := make([]ApprovalRequest, 0, limit)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getApprovalRequestsQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return nil, err
+	}
+	defer stmt.Close()
+	rows, err := stmt.QueryContext(ctx, limit, offset)
+	if err != nil {
+		return requests, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		req, err := getApprovalRequestFromDbRow(rows)
+		if err != nil {
+			return requests, err
+		}
+		// the payload can be large and it is not required for listing
+		req.Payload = ""
+		requests = append(requests, req)
+	}
+	return requests, rows.Err()
+}
+
+func sqlCommonUpdateApprovalRequestStatus(req *ApprovalRequest, fromStatus string, dbHandle *sql.DB) error {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
+	defer cancel()
+	q := getUpdateApprovalRequestStatusQuery()
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, req.Status, req.Payload, req.ResolvedBy, req.ResolvedAt, req.Error, req.ID, fromStatus)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err == nil && rows == 0 {
+		return &RecordNotFoundError{err: fmt.Sprintf("approval request %#v with status %#v does not exist", req.ID, fromStatus)}
+	}
+	return nil
+}
+
+func getApprovalRequestFromDbRow(row sqlScanner) (ApprovalRequest, error) {
+	var req ApprovalRequest
+	var objectName, payload, resolvedBy, reqError sql.NullString
+	err := row.Scan(&req.ID, &req.Operation, &objectName, &payload, &req.Status, &req.RequestedBy, &req.RequestedAt,
+		&resolvedBy, &req.ResolvedAt, &reqError)
+	if err != nil {
+		return req, err
+	}
+	if objectName.Valid {
+		req.ObjectName = objectName.String
+	}
+	if payload.Valid {
+		req.Payload = payload.String
+	}
+	if resolvedBy.Valid {
+		req.ResolvedBy = resolvedBy.String
+	}
+	if reqError.Valid {
+		req.Error = reqError.String
+	}
+	return req, nil
+}
+
+func getDropLinkFromDbRow(row sqlScanner) (DropLink, error) {
+	var link DropLink
+	var description sql.NullString
+	err := row.Scan(&link.LinkID, &link.Username, &description, &link.Path, &link.MaxSize, &link.MaxFiles,
+		&link.UploadedSize, &link.UploadedFiles, &link.ExpiresAt, &link.CreatedAt, &link.LastUseAt)
+	if err != nil {
+		return link, err
+	}
+	if description.Valid {
+		link.Description = description.String
+	}
+	return link, nil
+}
+
+func sqlCommonExecDefenderQuery(ctx context.Context, q string, dbHandle sqlQuerier, args ...interface{}) error {
+	stmt, err := dbHandle.PrepareContext(ctx, q)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error preparing database query %#v: %v", q, err)
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, args...)
+	if err != nil {
+		providerLog(logger.LevelWarn, "error executing defender query %#v: %v", q, err)
+	}
+	return err
+}
+
 func sqlCommonGetDatabaseVersion(dbHandle *sql.DB, showInitWarn bool) (schemaVersion, error) {
 	var result schemaVersion
 	ctx, cancel := context.WithTimeout(context.Background(), defaultSQLQueryTimeout)
@@ -76,6 +76,49 @@ INSERT INTO "new__folders" ("id", "name", "path", "used_quota_size", "used_quota
 SELECT "id", "name", "path", "used_quota_size", "used_quota_files", "last_quota_update" FROM "{{folders}}";
 DROP TABLE "{{folders}}";
 ALTER TABLE "new__folders" RENAME TO "{{folders}}";
+`
+	sqliteV10SQL = `CREATE TABLE "{{defender_hosts}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "ip" varchar(50) NOT NULL UNIQUE,
+"ban_time" bigint NOT NULL, "updated_at" bigint NOT NULL);
+CREATE TABLE "{{defender_events}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "date_time" bigint NOT NULL,
+"score" integer NOT NULL, "host_id" integer NOT NULL REFERENCES "{{defender_hosts}}" ("id") ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED);
+CREATE INDEX "{{prefix}}defender_hosts_ban_time_idx" ON "{{defender_hosts}}" ("ban_time");
+CREATE INDEX "{{prefix}}defender_events_date_time_idx" ON "{{defender_events}}" ("date_time");
+CREATE INDEX "{{prefix}}defender_events_host_id_idx" ON "{{defender_events}}" ("host_id");
+CREATE TABLE "{{defender_lists}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "type" integer NOT NULL,
+"network" varchar(50) NOT NULL, "description" varchar(512) NULL, "created_at" bigint NOT NULL,
+CONSTRAINT "{{prefix}}defender_lists_type_network_uniq" UNIQUE ("type", "network"));
+CREATE TABLE "{{events_queue}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "payload" text NOT NULL,
+"created_at" bigint NOT NULL, "claimed_by" varchar(255) NOT NULL, "lease_expires" bigint NOT NULL);
+CREATE TABLE "{{shared_connections}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "connection_id" varchar(255) NOT NULL,
+"node_id" varchar(255) NOT NULL, "username" varchar(255) NOT NULL, "payload" text NOT NULL, "updated_at" bigint NOT NULL,
+"close_requested" integer NOT NULL,
+CONSTRAINT "{{prefix}}shared_connections_node_id_connection_id_uniq" UNIQUE ("node_id", "connection_id"));
+CREATE INDEX "{{prefix}}shared_connections_updated_at_idx" ON "{{shared_connections}}" ("updated_at");
+CREATE TABLE "{{drop_links}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "link_id" varchar(255) NOT NULL UNIQUE,
+"description" varchar(512) NULL, "path" text NOT NULL, "max_size" bigint NOT NULL, "max_files" integer NOT NULL,
+"uploaded_size" bigint NOT NULL, "uploaded_files" integer NOT NULL, "expires_at" bigint NOT NULL, "created_at" bigint NOT NULL,
+"last_use_at" bigint NOT NULL,
+"user_id" integer NOT NULL REFERENCES "{{users}}" ("id") ON DELETE CASCADE DEFERRABLE INITIALLY DEFERRED);
+CREATE INDEX "{{prefix}}drop_links_user_id_idx" ON "{{drop_links}}" ("user_id");
+CREATE TABLE "{{approval_requests}}" ("id" integer NOT NULL PRIMARY KEY AUTOINCREMENT, "req_id" varchar(255) NOT NULL UNIQUE,
+"operation" varchar(50) NOT NULL, "object_name" varchar(255) NULL, "payload" text NULL, "status" varchar(20) NOT NULL,
+"requested_by" varchar(255) NOT NULL, "requested_at" bigint NOT NULL, "resolved_by" varchar(255) NULL,
+"resolved_at" bigint NOT NULL, "exec_error" text NULL);
+CREATE INDEX "{{prefix}}approval_requests_requested_at_idx" ON "{{approval_requests}}" ("requested_at");
+ALTER TABLE "{{users}}" ADD COLUMN "version" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{folders}}" ADD COLUMN "version" bigint DEFAULT 0 NOT NULL;
+ALTER TABLE "{{admins}}" ADD COLUMN "version" bigint DEFAULT 0 NOT NULL;
+`
+	sqliteV10DownSQL = `ALTER TABLE "{{admins}}" DROP COLUMN "version";
+ALTER TABLE "{{folders}}" DROP COLUMN "version";
+ALTER TABLE "{{users}}" DROP COLUMN "version";
+DROP TABLE "{{approval_requests}}";
+DROP TABLE "{{drop_links}}";
+DROP TABLE "{{shared_connections}}";
+DROP TABLE "{{events_queue}}";
+DROP TABLE "{{defender_lists}}";
+DROP TABLE "{{defender_events}}";
+DROP TABLE "{{defender_hosts}}";
 `
 )
 
@@ -120,8 +163,8 @@ func (p *SQLiteProvider) checkAvailability() error {
 	return sqlCommonCheckAvailability(p.dbHandle)
 }
 
-func (p *SQLiteProvider) validateUserAndPass(username, password, ip, protocol string) (User, error) {
-	return sqlCommonValidateUserAndPass(username, password, ip, protocol, p.dbHandle)
+func (p *SQLiteProvider) validateUserAndPass(ctx context.Context, username, password, ip, protocol string) (User, error) {
+	return sqlCommonValidateUserAndPass(ctx, username, password, ip, protocol, p.dbHandle)
 }
 
 func (p *SQLiteProvider) validateUserAndTLSCert(username, protocol string, tlsCert *x509.Certificate) (User, error) {
@@ -230,6 +273,122 @@ func (p *SQLiteProvider) validateAdminAndPass(username, password, ip string) (Ad
 	return sqlCommonValidateAdminAndPass(username, password, ip, p.dbHandle)
 }
 
+func (p *SQLiteProvider) getDefenderHostByIP(ip string, from int64) (DefenderEntry, error) {
+	return sqlCommonGetDefenderHostByIP(ip, from, p.dbHandle)
+}
+
+func (p *SQLiteProvider) isDefenderHostBanned(ip string) (DefenderEntry, error) {
+	return sqlCommonIsDefenderHostBanned(ip, p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateDefenderBanTime(ip string, minutes int) error {
+	return sqlCommonUpdateDefenderBanTime(ip, minutes, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteDefenderHost(ip string) error {
+	return sqlCommonDeleteDefenderHost(ip, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addDefenderEvent(ip string, score int) error {
+	return sqlCommonAddDefenderEvent(ip, score, p.dbHandle)
+}
+
+func (p *SQLiteProvider) setDefenderBanTime(ip string, banTime int64) error {
+	return sqlCommonSetDefenderBanTime(ip, banTime, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupDefender(from int64) error {
+	return sqlCommonCleanupDefender(from, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getDefenderListEntries() ([]DefenderListEntry, error) {
+	return sqlCommonGetDefenderListEntries(p.dbHandle)
+}
+
+func (p *SQLiteProvider) addDefenderListEntry(entry *DefenderListEntry) error {
+	return sqlCommonAddDefenderListEntry(entry, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteDefenderListEntry(entry *DefenderListEntry) error {
+	return sqlCommonDeleteDefenderListEntry(entry, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addQueuedEvent(payload string, createdAt int64) error {
+	return sqlCommonAddQueuedEvent(payload, createdAt, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getQueuedEvents(limit int) ([]QueuedEvent, error) {
+	return sqlCommonGetQueuedEvents(limit, p.dbHandle)
+}
+
+func (p *SQLiteProvider) claimQueuedEvents(owner string, limit int, now, leaseExpires int64) ([]QueuedEvent, error) {
+	return sqlCommonClaimQueuedEvents(owner, limit, now, leaseExpires, p.dbHandle)
+}
+
+func (p *SQLiteProvider) renewQueuedEventsLease(owner string, leaseExpires int64) error {
+	return sqlCommonRenewQueuedEventsLease(owner, leaseExpires, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteQueuedEvent(id int64) error {
+	return sqlCommonDeleteQueuedEvent(id, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addSharedConnection(conn *SharedConnection) error {
+	return sqlCommonAddSharedConnection(conn, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteSharedConnection(nodeID, connectionID string) error {
+	return sqlCommonDeleteSharedConnection(nodeID, connectionID, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getSharedConnections(from int64) ([]SharedConnection, error) {
+	return sqlCommonGetSharedConnections(from, p.dbHandle)
+}
+
+func (p *SQLiteProvider) requestSharedConnectionClose(nodeID, connectionID string) error {
+	return sqlCommonRequestSharedConnectionClose(nodeID, connectionID, p.dbHandle)
+}
+
+func (p *SQLiteProvider) cleanupSharedConnections(from int64) error {
+	return sqlCommonCleanupSharedConnections(from, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addDropLink(link *DropLink) error {
+	return sqlCommonAddDropLink(link, p.dbHandle)
+}
+
+func (p *SQLiteProvider) dropLinkExists(linkID string) (DropLink, error) {
+	return sqlCommonGetDropLinkByID(linkID, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getDropLinks(username string) ([]DropLink, error) {
+	return sqlCommonGetDropLinks(username, p.dbHandle)
+}
+
+func (p *SQLiteProvider) deleteDropLink(linkID, username string) error {
+	return sqlCommonDeleteDropLink(linkID, username, p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateDropLinkUsage(linkID string, filesAdd int, sizeAdd int64) error {
+	return sqlCommonUpdateDropLinkUsage(linkID, filesAdd, sizeAdd, p.dbHandle)
+}
+
+func (p *SQLiteProvider) addApprovalRequest(req *ApprovalRequest) error {
+	return sqlCommonAddApprovalRequest(req, p.dbHandle)
+}
+
+func (p *SQLiteProvider) approvalRequestExists(id string) (ApprovalRequest, error) {
+	return sqlCommonGetApprovalRequestByID(id, p.dbHandle)
+}
+
+func (p *SQLiteProvider) getApprovalRequests(limit, offset int) ([]ApprovalRequest, error) {
+	return sqlCommonGetApprovalRequests(limit, offset, p.dbHandle)
+}
+
+func (p *SQLiteProvider) updateApprovalRequestStatus(req *ApprovalRequest, fromStatus string) error {
+	return sqlCommonUpdateApprovalRequestStatus(req, fromStatus, p.dbHandle)
+}
+
 func (p *SQLiteProvider) close() error {
 	return p.dbHandle.Close()
 }
@@ -271,6 +430,8 @@ func (p *SQLiteProvider) migrateDatabase() error {
 		return err
 	case version == 8:
 		return updateSQLiteDatabaseFromV8(p.dbHandle)
+	case version == 9:
+		return updateSQLiteDatabaseFromV9(p.dbHandle)
 	default:
 		if version > sqlDatabaseVersion {
 			providerLog(logger.LevelWarn, "database version %v is newer than the supported one: %v", version,
@@ -295,19 +456,35 @@ func (p *SQLiteProvider) revertDatabase(targetVersion int) error {
 	switch dbVersion.Version {
 	case 9:
 		return downgradeSQLiteDatabaseFromV9(p.dbHandle)
+	case 10:
+		return downgradeSQLiteDatabaseFromV10(p.dbHandle)
 	default:
 		return fmt.Errorf("database version not handled: %v", dbVersion.Version)
 	}
 }
 
 func updateSQLiteDatabaseFromV8(dbHandle *sql.DB) error {
-	return updateSQLiteDatabaseFrom8To9(dbHandle)
+	if err := updateSQLiteDatabaseFrom8To9(dbHandle); err != nil {
+		return err
+	}
+	return updateSQLiteDatabaseFromV9(dbHandle)
+}
+
+func updateSQLiteDatabaseFromV9(dbHandle *sql.DB) error {
+	return updateSQLiteDatabaseFrom9To10(dbHandle)
 }
 
 func downgradeSQLiteDatabaseFromV9(dbHandle *sql.DB) error {
 	return downgradeSQLiteDatabaseFrom9To8(dbHandle)
 }
 
+func downgradeSQLiteDatabaseFromV10(dbHandle *sql.DB) error {
+	if err := downgradeSQLiteDatabaseFrom10To9(dbHandle); err != nil {
+		return err
+	}
+	return downgradeSQLiteDatabaseFromV9(dbHandle)
+}
+
 func updateSQLiteDatabaseFrom8To9(dbHandle *sql.DB) error {
 	logger.InfoToConsole("updating database version: 8 -> 9")
 	providerLog(logger.LevelInfo, "updating database version: 8 -> 9")
@@ -341,3 +518,36 @@ func setPragmaFK(dbHandle *sql.DB, value string) error {
 	_, err := dbHandle.ExecContext(ctx, sql)
 	return err
 }
+
+func updateSQLiteDatabaseFrom9To10(dbHandle *sql.DB) error {
+	logger.InfoToConsole("updating database version: 9 -> 10")
+	providerLog(logger.LevelInfo, "updating database version: 9 -> 10")
+	sql := strings.ReplaceAll(sqliteV10SQL, "{{defender_hosts}}", sqlTableDefenderHosts)
+	sql = strings.ReplaceAll(sql, "{{defender_events}}", sqlTableDefenderEvents)
+	sql = strings.ReplaceAll(sql, "{{defender_lists}}", sqlTableDefenderLists)
+	sql = strings.ReplaceAll(sql, "{{events_queue}}", sqlTableEventsQueue)
+	sql = strings.ReplaceAll(sql, "{{shared_connections}}", sqlTableSharedConns)
+	sql = strings.ReplaceAll(sql, "{{drop_links}}", sqlTableDropLinks)
+	sql = strings.ReplaceAll(sql, "{{approval_requests}}", sqlTableApprovals)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{admins}}", sqlTableAdmins)
+	sql = strings.ReplaceAll(sql, "{{prefix}}", config.SQLTablesPrefix)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 10)
+}
+
+func downgradeSQLiteDatabaseFrom10To9(dbHandle *sql.DB) error {
+	logger.InfoToConsole("downgrading database version: 10 -> 9")
+	providerLog(logger.LevelInfo, "downgrading database version: 10 -> 9")
+	sql := strings.ReplaceAll(sqliteV10DownSQL, "{{defender_hosts}}", sqlTableDefenderHosts)
+	sql = strings.ReplaceAll(sql, "{{defender_events}}", sqlTableDefenderEvents)
+	sql = strings.ReplaceAll(sql, "{{defender_lists}}", sqlTableDefenderLists)
+	sql = strings.ReplaceAll(sql, "{{events_queue}}", sqlTableEventsQueue)
+	sql = strings.ReplaceAll(sql, "{{shared_connections}}", sqlTableSharedConns)
+	sql = strings.ReplaceAll(sql, "{{drop_links}}", sqlTableDropLinks)
+	sql = strings.ReplaceAll(sql, "{{approval_requests}}", sqlTableApprovals)
+	sql = strings.ReplaceAll(sql, "{{users}}", sqlTableUsers)
+	sql = strings.ReplaceAll(sql, "{{folders}}", sqlTableFolders)
+	sql = strings.ReplaceAll(sql, "{{admins}}", sqlTableAdmins)
+	return sqlCommonExecSQLAndUpdateDBVersion(dbHandle, []string{sql}, 9)
+}
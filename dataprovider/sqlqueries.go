@@ -11,9 +11,11 @@ import (
 const (
 	selectUserFields = "id,username,password,public_keys,home_dir,uid,gid,max_sessions,quota_size,quota_files,permissions,used_quota_size," +
 		"used_quota_files,last_quota_update,upload_bandwidth,download_bandwidth,expiration_date,last_login,status,filters,filesystem," +
-		"additional_info,description"
-	selectFolderFields = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem"
-	selectAdminFields  = "id,username,password,status,email,permissions,filters,additional_info,description"
+		"additional_info,description,version"
+	selectFolderFields          = "id,path,used_quota_size,used_quota_files,last_quota_update,name,description,filesystem,version"
+	selectAdminFields           = "id,username,password,status,email,permissions,filters,additional_info,description,version"
+	selectApprovalRequestFields = "req_id,operation,object_name,payload,status,requested_by,requested_at,resolved_by," +
+		"resolved_at,exec_error"
 )
 
 func getSQLPlaceholders() []string {
@@ -48,9 +50,10 @@ func getAddAdminQuery() string {
 }
 
 func getUpdateAdminQuery() string {
-	return fmt.Sprintf(`UPDATE %v SET password=%v,status=%v,email=%v,permissions=%v,filters=%v,additional_info=%v,description=%v
-		WHERE username = %v`, sqlTableAdmins, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
-		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7])
+	return fmt.Sprintf(`UPDATE %v SET password=%v,status=%v,email=%v,permissions=%v,filters=%v,additional_info=%v,description=%v,
+		version=version+1 WHERE username = %v AND version = %v`, sqlTableAdmins, sqlPlaceholders[0], sqlPlaceholders[1],
+		sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7],
+		sqlPlaceholders[8])
 }
 
 func getDeleteAdminQuery() string {
@@ -105,10 +108,10 @@ func getAddUserQuery() string {
 func getUpdateUserQuery() string {
 	return fmt.Sprintf(`UPDATE %v SET password=%v,public_keys=%v,home_dir=%v,uid=%v,gid=%v,max_sessions=%v,quota_size=%v,
 		quota_files=%v,permissions=%v,upload_bandwidth=%v,download_bandwidth=%v,status=%v,expiration_date=%v,filters=%v,filesystem=%v,
-		additional_info=%v,description=%v WHERE id = %v`, sqlTableUsers, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3],
-		sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6], sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9],
-		sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12], sqlPlaceholders[13], sqlPlaceholders[14], sqlPlaceholders[15],
-		sqlPlaceholders[16], sqlPlaceholders[17])
+		additional_info=%v,description=%v,version=version+1 WHERE id = %v AND version = %v`, sqlTableUsers, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6],
+		sqlPlaceholders[7], sqlPlaceholders[8], sqlPlaceholders[9], sqlPlaceholders[10], sqlPlaceholders[11], sqlPlaceholders[12],
+		sqlPlaceholders[13], sqlPlaceholders[14], sqlPlaceholders[15], sqlPlaceholders[16], sqlPlaceholders[17], sqlPlaceholders[18])
 }
 
 func getDeleteUserQuery() string {
@@ -119,8 +122,8 @@ func getFolderByNameQuery() string {
 	return fmt.Sprintf(`SELECT %v FROM %v WHERE name = %v`, selectFolderFields, sqlTableFolders, sqlPlaceholders[0])
 }
 
-func checkFolderNameQuery() string {
-	return fmt.Sprintf(`SELECT name FROM %v WHERE name = %v`, sqlTableFolders, sqlPlaceholders[0])
+func getFolderVersionQuery() string {
+	return fmt.Sprintf(`SELECT version FROM %v WHERE name = %v`, sqlTableFolders, sqlPlaceholders[0])
 }
 
 func getAddFolderQuery() string {
@@ -130,8 +133,8 @@ func getAddFolderQuery() string {
 }
 
 func getUpdateFolderQuery() string {
-	return fmt.Sprintf(`UPDATE %v SET path=%v,description=%v,filesystem=%v WHERE name = %v`, sqlTableFolders, sqlPlaceholders[0],
-		sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+	return fmt.Sprintf(`UPDATE %v SET path=%v,description=%v,filesystem=%v,version=version+1 WHERE name = %v AND version = %v`,
+		sqlTableFolders, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
 }
 
 func getDeleteFolderQuery() string {
@@ -182,7 +185,7 @@ func getRelatedFoldersForUsersQuery(users []User) string {
 		sb.WriteString(")")
 	}
 	return fmt.Sprintf(`SELECT f.id,f.name,f.path,f.used_quota_size,f.used_quota_files,f.last_quota_update,fm.virtual_path,
-		fm.quota_size,fm.quota_files,fm.user_id,f.filesystem,f.description FROM %v f INNER JOIN %v fm ON f.id = fm.folder_id WHERE
+		fm.quota_size,fm.quota_files,fm.user_id,f.filesystem,f.description,f.version FROM %v f INNER JOIN %v fm ON f.id = fm.folder_id WHERE
 		fm.user_id IN %v ORDER BY fm.user_id`, sqlTableFolders, sqlTableFoldersMapping, sb.String())
 }
 
@@ -203,6 +206,183 @@ func getRelatedUsersForFoldersQuery(folders []vfs.BaseVirtualFolder) string {
 		WHERE fm.folder_id IN %v ORDER BY fm.folder_id`, sqlTableFoldersMapping, sqlTableUsers, sb.String())
 }
 
+func getDefenderHostQuery() string {
+	return fmt.Sprintf(`SELECT h.ip,h.ban_time,(SELECT SUM(e.score) FROM %v e WHERE e.host_id = h.id AND e.date_time >= %v)
+		FROM %v h WHERE h.ip = %v`, sqlTableDefenderEvents, sqlPlaceholders[0], sqlTableDefenderHosts, sqlPlaceholders[1])
+}
+
+func getDefenderBanTimeQuery() string {
+	return fmt.Sprintf(`SELECT ip,ban_time FROM %v WHERE ip = %v AND ban_time > %v`, sqlTableDefenderHosts,
+		sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getAddDefenderHostQuery() string {
+	if config.Driver == MySQLDataProviderName {
+		return fmt.Sprintf(`INSERT INTO %v (ip,updated_at,ban_time) VALUES (%v,%v,0) ON DUPLICATE KEY UPDATE updated_at=VALUES(updated_at)`,
+			sqlTableDefenderHosts, sqlPlaceholders[0], sqlPlaceholders[1])
+	}
+	return fmt.Sprintf(`INSERT INTO %v (ip,updated_at,ban_time) VALUES (%v,%v,0) ON CONFLICT (ip) DO UPDATE SET updated_at = EXCLUDED.updated_at`,
+		sqlTableDefenderHosts, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getAddDefenderEventQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (date_time,score,host_id) VALUES (%v,%v,(SELECT id FROM %v WHERE ip = %v))`,
+		sqlTableDefenderEvents, sqlPlaceholders[0], sqlPlaceholders[1], sqlTableDefenderHosts, sqlPlaceholders[2])
+}
+
+func getSetDefenderBanTimeQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET ban_time=%v,updated_at=%v WHERE ip = %v`, sqlTableDefenderHosts, sqlPlaceholders[0],
+		sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getUpdateDefenderBanTimeQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET ban_time=ban_time + %v,updated_at=%v WHERE ip = %v`, sqlTableDefenderHosts,
+		sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2])
+}
+
+func getDeleteDefenderHostQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE ip = %v`, sqlTableDefenderHosts, sqlPlaceholders[0])
+}
+
+func getDeleteDefenderHostEventsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE host_id = (SELECT id FROM %v WHERE ip = %v)`, sqlTableDefenderEvents,
+		sqlTableDefenderHosts, sqlPlaceholders[0])
+}
+
+func getDeleteOldDefenderEventsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE date_time < %v`, sqlTableDefenderEvents, sqlPlaceholders[0])
+}
+
+func getDeleteExpiredDefenderHostsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE ban_time < %v AND NOT EXISTS (SELECT id FROM %v WHERE %v.host_id = %v.id)`,
+		sqlTableDefenderHosts, sqlPlaceholders[0], sqlTableDefenderEvents, sqlTableDefenderEvents, sqlTableDefenderHosts)
+}
+
+func getDefenderListEntriesQuery() string {
+	return fmt.Sprintf(`SELECT type,network,description,created_at FROM %v ORDER BY id ASC`, sqlTableDefenderLists)
+}
+
+func getAddDefenderListEntryQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (type,network,description,created_at) VALUES (%v,%v,%v,%v)`,
+		sqlTableDefenderLists, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getDeleteDefenderListEntryQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE type = %v AND network = %v`, sqlTableDefenderLists, sqlPlaceholders[0],
+		sqlPlaceholders[1])
+}
+
+func getAddQueuedEventQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (payload,created_at,claimed_by,lease_expires) VALUES (%v,%v,'',0)`,
+		sqlTableEventsQueue, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getQueuedEventsQuery() string {
+	return fmt.Sprintf(`SELECT id,payload,created_at,claimed_by,lease_expires FROM %v ORDER BY id ASC LIMIT %v`,
+		sqlTableEventsQueue, sqlPlaceholders[0])
+}
+
+func getClaimableQueuedEventsQuery() string {
+	return fmt.Sprintf(`SELECT id,payload,created_at,claimed_by,lease_expires FROM %v WHERE lease_expires < %v
+		ORDER BY id ASC LIMIT %v`, sqlTableEventsQueue, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getClaimQueuedEventQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET claimed_by=%v,lease_expires=%v WHERE id = %v AND lease_expires < %v`,
+		sqlTableEventsQueue, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
+func getRenewQueuedEventsLeaseQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET lease_expires=%v WHERE claimed_by = %v`, sqlTableEventsQueue,
+		sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getDeleteQueuedEventQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE id = %v`, sqlTableEventsQueue, sqlPlaceholders[0])
+}
+
+func getAddSharedConnectionQuery() string {
+	if config.Driver == MySQLDataProviderName {
+		return fmt.Sprintf(`INSERT INTO %v (connection_id,node_id,username,payload,updated_at,close_requested) VALUES (%v,%v,%v,%v,%v,0)
+			ON DUPLICATE KEY UPDATE username=VALUES(username),payload=VALUES(payload),updated_at=VALUES(updated_at)`,
+			sqlTableSharedConns, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+	}
+	return fmt.Sprintf(`INSERT INTO %v (connection_id,node_id,username,payload,updated_at,close_requested) VALUES (%v,%v,%v,%v,%v,0)
+		ON CONFLICT (node_id,connection_id) DO UPDATE SET username = EXCLUDED.username,payload = EXCLUDED.payload,updated_at = EXCLUDED.updated_at`,
+		sqlTableSharedConns, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4])
+}
+
+func getDeleteSharedConnectionQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE node_id = %v AND connection_id = %v`, sqlTableSharedConns, sqlPlaceholders[0],
+		sqlPlaceholders[1])
+}
+
+func getSharedConnectionsQuery() string {
+	return fmt.Sprintf(`SELECT connection_id,node_id,username,payload,updated_at,close_requested FROM %v WHERE updated_at > %v
+		ORDER BY id ASC`, sqlTableSharedConns, sqlPlaceholders[0])
+}
+
+func getRequestSharedConnectionCloseQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET close_requested=1 WHERE node_id = %v AND connection_id = %v`, sqlTableSharedConns,
+		sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getCleanupSharedConnectionsQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE updated_at <= %v`, sqlTableSharedConns, sqlPlaceholders[0])
+}
+
+func getDropLinkFieldsQuery() string {
+	return fmt.Sprintf(`SELECT d.link_id,u.username,d.description,d.path,d.max_size,d.max_files,d.uploaded_size,d.uploaded_files,
+		d.expires_at,d.created_at,d.last_use_at FROM %v d INNER JOIN %v u ON d.user_id = u.id`, sqlTableDropLinks, sqlTableUsers)
+}
+
+func getAddDropLinkQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (link_id,description,path,max_size,max_files,uploaded_size,uploaded_files,expires_at,
+		created_at,last_use_at,user_id) VALUES (%v,%v,%v,%v,%v,0,0,%v,%v,0,(SELECT id FROM %v WHERE username = %v))`,
+		sqlTableDropLinks, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3], sqlPlaceholders[4],
+		sqlPlaceholders[5], sqlPlaceholders[6], sqlTableUsers, sqlPlaceholders[7])
+}
+
+func getDropLinkByIDQuery() string {
+	return fmt.Sprintf(`%v WHERE d.link_id = %v`, getDropLinkFieldsQuery(), sqlPlaceholders[0])
+}
+
+func getDropLinksQuery() string {
+	return fmt.Sprintf(`%v WHERE u.username = %v ORDER BY d.created_at ASC`, getDropLinkFieldsQuery(), sqlPlaceholders[0])
+}
+
+func getDeleteDropLinkQuery() string {
+	return fmt.Sprintf(`DELETE FROM %v WHERE link_id = %v AND user_id = (SELECT id FROM %v WHERE username = %v)`,
+		sqlTableDropLinks, sqlPlaceholders[0], sqlTableUsers, sqlPlaceholders[1])
+}
+
+func getAddApprovalRequestQuery() string {
+	return fmt.Sprintf(`INSERT INTO %v (req_id,operation,object_name,payload,status,requested_by,requested_at,resolved_at)
+		VALUES (%v,%v,%v,%v,%v,%v,%v,0)`, sqlTableApprovals, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+}
+
+func getApprovalRequestByIDQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v WHERE req_id = %v`, selectApprovalRequestFields, sqlTableApprovals,
+		sqlPlaceholders[0])
+}
+
+func getApprovalRequestsQuery() string {
+	return fmt.Sprintf(`SELECT %v FROM %v ORDER BY id DESC LIMIT %v OFFSET %v`, selectApprovalRequestFields,
+		sqlTableApprovals, sqlPlaceholders[0], sqlPlaceholders[1])
+}
+
+func getUpdateApprovalRequestStatusQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET status = %v,payload = %v,resolved_by = %v,resolved_at = %v,exec_error = %v
+		WHERE req_id = %v AND status = %v`, sqlTableApprovals, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2],
+		sqlPlaceholders[3], sqlPlaceholders[4], sqlPlaceholders[5], sqlPlaceholders[6])
+}
+
+func getUpdateDropLinkUsageQuery() string {
+	return fmt.Sprintf(`UPDATE %v SET uploaded_files = uploaded_files + %v,uploaded_size = uploaded_size + %v,last_use_at = %v
+		WHERE link_id = %v`, sqlTableDropLinks, sqlPlaceholders[0], sqlPlaceholders[1], sqlPlaceholders[2], sqlPlaceholders[3])
+}
+
 func getDatabaseVersionQuery() string {
 	return fmt.Sprintf("SELECT version from %v LIMIT 1", sqlTableSchemaVersion)
 }
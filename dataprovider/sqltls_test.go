@@ -0,0 +1,142 @@
+// +build !nomysql,!nopgsql
+
+package dataprovider
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateTLSConfig(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	config.ClientCert = "client.crt"
+	config.ClientKey = ""
+	assert.Error(t, validateTLSConfig())
+	config.ClientCert = ""
+	config.ClientKey = "client.key"
+	assert.Error(t, validateTLSConfig())
+	config.ClientCert = "client.crt"
+	assert.NoError(t, validateTLSConfig())
+	config.ClientCert = ""
+	config.ClientKey = ""
+	assert.NoError(t, validateTLSConfig())
+
+	config.Driver = BoltDataProviderName
+	config.ClientCert = "client.crt"
+	err := createProvider(t.TempDir())
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "client_key")
+	}
+}
+
+func TestMySQLCustomTLSConfig(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	config.Driver = MySQLDataProviderName
+	config.Host = "127.0.0.1"
+	config.SSLMode = 1
+	assert.False(t, hasMySQLCustomTLSConfig())
+	config.TLSServerName = "db.example.com"
+	assert.True(t, hasMySQLCustomTLSConfig())
+	assert.Contains(t, getMySQLConnectionString(true), "tls="+mysqlCustomTLSConfigName)
+
+	tlsConfig, err := getMySQLCustomTLSConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "db.example.com", tlsConfig.ServerName)
+	assert.False(t, tlsConfig.InsecureSkipVerify)
+	for _, mode := range []int{2, 3} {
+		config.SSLMode = mode
+		tlsConfig, err = getMySQLCustomTLSConfig()
+		require.NoError(t, err)
+		assert.True(t, tlsConfig.InsecureSkipVerify, "sslmode %v", mode)
+	}
+	config.TLSServerName = ""
+	config.RootCert = filepath.Join(t.TempDir(), "missing.crt")
+	tlsConfig, err = getMySQLCustomTLSConfig()
+	require.Error(t, err)
+	assert.Nil(t, tlsConfig)
+	err = os.WriteFile(config.RootCert, []byte("invalid pem"), os.ModePerm)
+	require.NoError(t, err)
+	_, err = getMySQLCustomTLSConfig()
+	assert.Error(t, err)
+	config.RootCert = ""
+	config.ClientCert = filepath.Join(t.TempDir(), "client.crt")
+	config.ClientKey = filepath.Join(t.TempDir(), "client.key")
+	_, err = getMySQLCustomTLSConfig()
+	assert.Error(t, err)
+}
+
+func TestPGSQLCustomServerName(t *testing.T) {
+	oldConfig := config
+	defer func() {
+		config = oldConfig
+	}()
+
+	config.Driver = PGSQLDataProviderName
+	config.Host = "127.0.0.1"
+	config.Port = 5432
+	config.SSLMode = 3
+	assert.False(t, hasPGSQLCustomServerName())
+	assert.Contains(t, getPGSQLConnectionString(true), "host='127.0.0.1'")
+	config.TLSServerName = "db.example.com"
+	assert.True(t, hasPGSQLCustomServerName())
+	connectionString := getPGSQLConnectionString(true)
+	assert.Contains(t, connectionString, "host='db.example.com'")
+	assert.Contains(t, connectionString, "sslmode=verify-full")
+	config.SSLMode = 0
+	assert.False(t, hasPGSQLCustomServerName())
+	config.SSLMode = 3
+	config.ConnectionString = "host=127.0.0.1"
+	assert.False(t, hasPGSQLCustomServerName())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	dialer := &pgsqlDialer{address: listener.Addr().String()}
+	// the requested address is ignored, the configured one is always used
+	conn, err := dialer.Dial("tcp", "db.example.com:5432")
+	require.NoError(t, err)
+	assert.Equal(t, listener.Addr().String(), conn.RemoteAddr().String())
+	assert.NoError(t, conn.Close())
+	conn, err = dialer.DialTimeout("tcp", "db.example.com:5432", 2*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, listener.Addr().String(), conn.RemoteAddr().String())
+	assert.NoError(t, conn.Close())
+	// the context of the connection request is used
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dialer.ctx = ctx
+	_, err = dialer.DialTimeout("tcp", "db.example.com:5432", 2*time.Second)
+	assert.ErrorIs(t, err, context.Canceled)
+	connector := &pgsqlConnector{
+		dsn:     "host='db.example.com' port=5432 sslmode=disable connect_timeout=10",
+		address: listener.Addr().String(),
+	}
+	_, err = connector.Connect(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -53,11 +53,12 @@ const (
 // Web Client restrictions
 const (
 	WebClientPubKeyChangeDisabled = "publickey-change-disabled"
+	WebClientDropLinksDisabled    = "droplinks-disabled"
 )
 
 var (
 	// WebClientOptions defines the available options for the web client interface
-	WebClientOptions = []string{WebClientPubKeyChangeDisabled}
+	WebClientOptions = []string{WebClientPubKeyChangeDisabled, WebClientDropLinksDisabled}
 )
 
 // Available login methods
@@ -72,6 +73,16 @@ const (
 	LoginMethodTLSCertificateAndPwd   = "TLSCertificate+password"
 )
 
+// TrashDirName is the name of the directory, inside the filesystem root, where
+// deleted files are moved if the trash is enabled.
+// This directory is hidden and cannot be accessed directly
+const TrashDirName = ".trash"
+
+// ArchivedDirName is the name of the directory, inside the filesystem root, where
+// the stubs for the files moved to an archive folder are saved.
+// This directory is hidden and cannot be accessed directly
+const ArchivedDirName = ".archived"
+
 // TLSUsername defines the TLS certificate attribute to use as username
 type TLSUsername string
 
@@ -128,6 +139,24 @@ type PatternsFilter struct {
 	DeniedPatterns []string `json:"denied_patterns,omitempty"`
 }
 
+// OperationsFilter defines the operations allowed or denied for a path.
+// The operations are identified using the permission names, for example
+// "upload" or "list", and they are evaluated together with the permissions:
+// an operation is allowed only if both the permissions and the filter allow it
+type OperationsFilter struct {
+	// Virtual path, if no other specific filter is defined, the filter apply for
+	// sub directories too.
+	// For example if filters are defined for the paths "/" and "/sub" then the
+	// filters for "/" are applied for any path outside the "/sub" directory
+	Path string `json:"path"`
+	// if not empty only these operations are allowed.
+	// Denied operations are evaluated before the allowed ones
+	AllowedOperations []string `json:"allowed_operations,omitempty"`
+	// these operations are not allowed.
+	// Denied operations are evaluated before the allowed ones
+	DeniedOperations []string `json:"denied_operations,omitempty"`
+}
+
 // HooksFilter defines user specific overrides for global hooks
 type HooksFilter struct {
 	ExternalAuthDisabled  bool `json:"external_auth_disabled"`
@@ -135,6 +164,61 @@ type HooksFilter struct {
 	CheckPasswordDisabled bool `json:"check_password_disabled"`
 }
 
+// TrashFilter defines the recycle bin configuration for a user
+type TrashFilter struct {
+	// if enabled deleted files are moved inside the trash directory
+	Enabled bool `json:"enabled,omitempty"`
+	// deleted files are automatically removed from the trash after this number of days,
+	// 0 means they are never removed
+	RetentionDays int `json:"retention_days,omitempty"`
+}
+
+// DirQuota defines quota restrictions for a directory inside the user's filesystem.
+// Files inside virtual folders mounted below the directory are not included
+type DirQuota struct {
+	// Virtual path for the directory, for example "/incoming".
+	// The quota applies to sub directories too
+	Path string `json:"path"`
+	// maximum size allowed in bytes, 0 means unlimited
+	QuotaSize int64 `json:"quota_size,omitempty"`
+	// maximum number of files allowed, 0 means unlimited
+	QuotaFiles int `json:"quota_files,omitempty"`
+}
+
+// RetentionRule defines a retention policy for a directory inside the user's filesystem.
+// Files inside virtual folders mounted below the directory are not included
+type RetentionRule struct {
+	// Virtual path for the directory, for example "/logs".
+	// The rule applies to sub directories too
+	Path string `json:"path"`
+	// files not modified for this number of days are deleted or archived
+	Days int `json:"days"`
+	// optional shell patterns, for example "*.log", to match against the file names.
+	// If empty all the files are included
+	Patterns []string `json:"patterns,omitempty"`
+	// if not empty, the expired files are moved inside this virtual directory
+	// instead of being deleted. The directory structure is preserved
+	ArchivePath string `json:"archive_path,omitempty"`
+	// if not empty, the expired files are moved to the virtual folder with this
+	// name, for example a folder backed by a cold storage, and a stub is left in
+	// their place. The archived files are restored on access or explicitly
+	ArchiveFolder string `json:"archive_folder,omitempty"`
+}
+
+// IsFileMatching returns true if the given file name matches the rule patterns
+func (r *RetentionRule) IsFileMatching(name string) bool {
+	if len(r.Patterns) == 0 {
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, pattern := range r.Patterns {
+		if matched, err := path.Match(pattern, name); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
 // UserFilters defines additional restrictions for a user
 // TODO: rename to UserOptions in v3
 type UserFilters struct {
@@ -156,8 +240,16 @@ type UserFilters struct {
 	FileExtensions []ExtensionsFilter `json:"file_extensions,omitempty"`
 	// filter based on shell patterns
 	FilePatterns []PatternsFilter `json:"file_patterns,omitempty"`
+	// operations allowed or denied for specific paths
+	Operations []OperationsFilter `json:"operations,omitempty"`
+	// custom key/value pairs, they can be referenced as %{key} inside the
+	// home directory and the cloud storage key prefix
+	Metadata map[string]string `json:"metadata,omitempty"`
 	// max size allowed for a single upload, 0 means unlimited
 	MaxUploadFileSize int64 `json:"max_upload_file_size,omitempty"`
+	// maximum number of concurrent uploads and downloads for this user,
+	// across all its sessions. 0 means no limit
+	MaxConcurrentTransfers int `json:"max_concurrent_transfers,omitempty"`
 	// TLS certificate attribute to use as username.
 	// For FTP clients it must match the name provided using the
 	// "USER" command
@@ -174,6 +266,20 @@ type UserFilters struct {
 	DisableFsChecks bool `json:"disable_fs_checks,omitempty"`
 	// WebClient related configuration options
 	WebClient []string `json:"web_client,omitempty"`
+	// recycle bin configuration
+	Trash TrashFilter `json:"trash,omitempty"`
+	// quota restrictions for specific directories
+	DirQuotas []DirQuota `json:"dir_quotas,omitempty"`
+	// retention policies for specific directories
+	Retention []RetentionRule `json:"retention,omitempty"`
+	// Resolve paths ignoring the case, for clients expecting that, for example,
+	// "Foo.TXT" and "foo.txt" are the same file. Permissions and filters are
+	// matched ignoring the case too
+	CaseInsensitive bool `json:"case_insensitive,omitempty"`
+	// Execute the local filesystem operations using the user's UID and GID,
+	// so the operating system permissions are enforced and the created files
+	// are owned by them. SFTPGo must run as root, supported on Linux only
+	Impersonate bool `json:"impersonate,omitempty"`
 }
 
 // User defines a SFTPGo user
@@ -229,6 +335,9 @@ type User struct {
 	Description string `json:"description,omitempty"`
 	// free form text field for external systems
 	AdditionalInfo string `json:"additional_info,omitempty"`
+	// Incremented on each update. Updates must provide the version they are based on,
+	// they fail if the user was modified in the meantime
+	Version int64 `json:"version"`
 	// we store the filesystem here using the base path as key.
 	fsCache map[string]vfs.Fs `json:"-"`
 }
@@ -245,26 +354,56 @@ func (u *User) GetFilesystem(connectionID string) (fs vfs.Fs, err error) {
 }
 
 func (u *User) getRootFs(connectionID string) (fs vfs.Fs, err error) {
+	homeDir, err := u.getHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve the home dir %#v: %w", u.HomeDir, err)
+	}
 	switch u.FsConfig.Provider {
 	case vfs.S3FilesystemProvider:
-		return vfs.NewS3Fs(connectionID, u.GetHomeDir(), "", u.FsConfig.S3Config)
+		config := u.FsConfig.S3Config
+		config.KeyPrefix, err = u.getKeyPrefix(config.KeyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		return vfs.NewS3Fs(connectionID, homeDir, "", config)
 	case vfs.GCSFilesystemProvider:
 		config := u.FsConfig.GCSConfig
 		config.CredentialFile = u.GetGCSCredentialsFilePath()
-		return vfs.NewGCSFs(connectionID, u.GetHomeDir(), "", config)
+		config.KeyPrefix, err = u.getKeyPrefix(config.KeyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		return vfs.NewGCSFs(connectionID, homeDir, "", config)
 	case vfs.AzureBlobFilesystemProvider:
-		return vfs.NewAzBlobFs(connectionID, u.GetHomeDir(), "", u.FsConfig.AzBlobConfig)
+		config := u.FsConfig.AzBlobConfig
+		config.KeyPrefix, err = u.getKeyPrefix(config.KeyPrefix)
+		if err != nil {
+			return nil, err
+		}
+		return vfs.NewAzBlobFs(connectionID, homeDir, "", config)
 	case vfs.CryptedFilesystemProvider:
-		return vfs.NewCryptFs(connectionID, u.GetHomeDir(), "", u.FsConfig.CryptConfig)
+		return vfs.NewCryptFs(connectionID, homeDir, "", u.FsConfig.CryptConfig)
 	case vfs.SFTPFilesystemProvider:
 		forbiddenSelfUsers, err := u.getForbiddenSFTPSelfUsers(u.FsConfig.SFTPConfig.Username)
 		if err != nil {
 			return nil, err
 		}
 		forbiddenSelfUsers = append(forbiddenSelfUsers, u.Username)
-		return vfs.NewSFTPFs(connectionID, "", u.GetHomeDir(), forbiddenSelfUsers, u.FsConfig.SFTPConfig)
+		return vfs.NewSFTPFs(connectionID, "", homeDir, forbiddenSelfUsers, u.FsConfig.SFTPConfig)
+	case vfs.PluginFilesystemProvider:
+		return vfs.NewPluginFs(connectionID, homeDir, "", u.FsConfig.PluginConfig)
+	case vfs.SMBFilesystemProvider:
+		return vfs.NewSMBFs(connectionID, "", homeDir, u.FsConfig.SMBConfig)
 	default:
-		return vfs.NewOsFs(connectionID, u.GetHomeDir(), ""), nil
+		fs := vfs.NewOsFs(connectionID, homeDir, "")
+		u.setImpersonation(fs)
+		return fs, nil
+	}
+}
+
+func (u *User) setImpersonation(fs vfs.Fs) {
+	if u.Filters.Impersonate {
+		vfs.SetOsFsImpersonation(fs, u.GetUID(), u.GetGID())
 	}
 }
 
@@ -340,6 +479,7 @@ func (u *User) hideConfidentialData() {
 	switch u.FsConfig.Provider {
 	case vfs.S3FilesystemProvider:
 		u.FsConfig.S3Config.AccessSecret.Hide()
+		u.FsConfig.S3Config.SSECustomerKey.Hide()
 	case vfs.GCSFilesystemProvider:
 		u.FsConfig.GCSConfig.Credentials.Hide()
 	case vfs.AzureBlobFilesystemProvider:
@@ -349,6 +489,10 @@ func (u *User) hideConfidentialData() {
 	case vfs.SFTPFilesystemProvider:
 		u.FsConfig.SFTPConfig.Password.Hide()
 		u.FsConfig.SFTPConfig.PrivateKey.Hide()
+	case vfs.PluginFilesystemProvider:
+		u.FsConfig.PluginConfig.Options.Hide()
+	case vfs.SMBFilesystemProvider:
+		u.FsConfig.SMBConfig.Password.Hide()
 	}
 }
 
@@ -370,6 +514,9 @@ func (u *User) hasRedactedSecret() bool {
 		if u.FsConfig.S3Config.AccessSecret.IsRedacted() {
 			return true
 		}
+		if u.FsConfig.S3Config.SSECustomerKey.IsRedacted() {
+			return true
+		}
 	case vfs.GCSFilesystemProvider:
 		if u.FsConfig.GCSConfig.Credentials.IsRedacted() {
 			return true
@@ -389,6 +536,14 @@ func (u *User) hasRedactedSecret() bool {
 		if u.FsConfig.SFTPConfig.PrivateKey.IsRedacted() {
 			return true
 		}
+	case vfs.PluginFilesystemProvider:
+		if u.FsConfig.PluginConfig.Options.IsRedacted() {
+			return true
+		}
+	case vfs.SMBFilesystemProvider:
+		if u.FsConfig.SMBConfig.Password.IsRedacted() {
+			return true
+		}
 	}
 
 	for idx := range u.VirtualFolders {
@@ -434,11 +589,14 @@ func (u *User) IsTLSUsernameVerificationEnabled() bool {
 // SetEmptySecrets sets to empty any user secret
 func (u *User) SetEmptySecrets() {
 	u.FsConfig.S3Config.AccessSecret = kms.NewEmptySecret()
+	u.FsConfig.S3Config.SSECustomerKey = kms.NewEmptySecret()
 	u.FsConfig.GCSConfig.Credentials = kms.NewEmptySecret()
 	u.FsConfig.AzBlobConfig.AccountKey = kms.NewEmptySecret()
 	u.FsConfig.CryptConfig.Passphrase = kms.NewEmptySecret()
 	u.FsConfig.SFTPConfig.Password = kms.NewEmptySecret()
 	u.FsConfig.SFTPConfig.PrivateKey = kms.NewEmptySecret()
+	u.FsConfig.PluginConfig.Options = kms.NewEmptySecret()
+	u.FsConfig.SMBConfig.Password = kms.NewEmptySecret()
 	for idx := range u.VirtualFolders {
 		folder := &u.VirtualFolders[idx]
 		folder.FsConfig.SetEmptySecretsIfNil()
@@ -463,7 +621,7 @@ func (u *User) GetPermissionsForPath(p string) []string {
 	// [ "/1/2/3/4", "/1/2/3", "/1/2", "/1", "/" ]
 	// so the first match is the one we are interested to
 	for idx := range dirsForPath {
-		if perms, ok := u.Permissions[dirsForPath[idx]]; ok {
+		if perms, ok := u.getPermissionsForDir(dirsForPath[idx]); ok {
 			permissions = perms
 			break
 		}
@@ -471,6 +629,38 @@ func (u *User) GetPermissionsForPath(p string) []string {
 	return permissions
 }
 
+func (u *User) getPermissionsForDir(dir string) ([]string, bool) {
+	if perms, ok := u.Permissions[dir]; ok {
+		return perms, true
+	}
+	if u.Filters.CaseInsensitive {
+		for p, perms := range u.Permissions {
+			if strings.EqualFold(p, dir) {
+				return perms, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// isSamePath returns true if the given virtual paths are equal.
+// The case is ignored if the user resolves paths case insensitively
+func (u *User) isSamePath(p1, p2 string) bool {
+	if u.Filters.CaseInsensitive {
+		return strings.EqualFold(p1, p2)
+	}
+	return p1 == p2
+}
+
+// hasPathPrefix returns true if the given virtual path begins with prefix.
+// The case is ignored if the user resolves paths case insensitively
+func (u *User) hasPathPrefix(p, prefix string) bool {
+	if u.Filters.CaseInsensitive {
+		return len(p) >= len(prefix) && strings.EqualFold(p[:len(prefix)], prefix)
+	}
+	return strings.HasPrefix(p, prefix)
+}
+
 func (u *User) getForbiddenSFTPSelfUsers(username string) ([]string, error) {
 	sftpUser, err := UserExists(username)
 	if err == nil {
@@ -517,6 +707,7 @@ func (u *User) GetFilesystemForPath(virtualPath, connectionID string) (vfs.Fs, e
 			}
 			fs, err := folder.GetFilesystem(connectionID, forbiddenSelfUsers)
 			if err == nil {
+				u.setImpersonation(fs)
 				u.fsCache[folder.VirtualPath] = fs
 			}
 			return fs, err
@@ -541,7 +732,7 @@ func (u *User) GetVirtualFolderForPath(virtualPath string) (vfs.VirtualFolder, e
 	for index := range dirsForPath {
 		for idx := range u.VirtualFolders {
 			v := &u.VirtualFolders[idx]
-			if v.VirtualPath == dirsForPath[index] {
+			if u.isSamePath(v.VirtualPath, dirsForPath[index]) {
 				return *v, nil
 			}
 		}
@@ -557,7 +748,7 @@ func (u *User) ScanQuota() (int, int64, error) {
 		return 0, 0, err
 	}
 	defer fs.Close()
-	numFiles, size, err := fs.ScanRootDirContents()
+	numFiles, size, err := vfs.ScanQuota(fs)
 	if err != nil {
 		return numFiles, size, err
 	}
@@ -590,7 +781,7 @@ func (u *User) GetVirtualFoldersInPath(virtualPath string) map[string]bool {
 			if d == "/" {
 				continue
 			}
-			if path.Dir(d) == virtualPath {
+			if u.isSamePath(path.Dir(d), virtualPath) {
 				result[d] = true
 			}
 		}
@@ -638,7 +829,7 @@ func (u *User) IsMappedPath(fsPath string) bool {
 func (u *User) IsVirtualFolder(virtualPath string) bool {
 	for idx := range u.VirtualFolders {
 		v := &u.VirtualFolders[idx]
-		if virtualPath == v.VirtualPath {
+		if u.isSamePath(virtualPath, v.VirtualPath) {
 			return true
 		}
 	}
@@ -654,7 +845,7 @@ func (u *User) HasVirtualFoldersInside(virtualPath string) bool {
 	for idx := range u.VirtualFolders {
 		v := &u.VirtualFolders[idx]
 		if len(v.VirtualPath) > len(virtualPath) {
-			if strings.HasPrefix(v.VirtualPath, virtualPath+"/") {
+			if u.hasPathPrefix(v.VirtualPath, virtualPath+"/") {
 				return true
 			}
 		}
@@ -666,10 +857,10 @@ func (u *User) HasVirtualFoldersInside(virtualPath string) bool {
 // no subdirs with defined permissions
 func (u *User) HasPermissionsInside(virtualPath string) bool {
 	for dir := range u.Permissions {
-		if dir == virtualPath {
+		if u.isSamePath(dir, virtualPath) {
 			return true
 		} else if len(dir) > len(virtualPath) {
-			if strings.HasPrefix(dir, virtualPath+"/") {
+			if u.hasPathPrefix(dir, virtualPath+"/") {
 				return true
 			}
 		}
@@ -679,6 +870,9 @@ func (u *User) HasPermissionsInside(virtualPath string) bool {
 
 // HasPerm returns true if the user has the given permission or any permission
 func (u *User) HasPerm(permission, path string) bool {
+	if !u.isOperationAllowed(permission, path) {
+		return false
+	}
 	perms := u.GetPermissionsForPath(path)
 	if utils.IsStringInSlice(PermAny, perms) {
 		return true
@@ -688,6 +882,11 @@ func (u *User) HasPerm(permission, path string) bool {
 
 // HasPerms return true if the user has all the given permissions
 func (u *User) HasPerms(permissions []string, path string) bool {
+	for _, permission := range permissions {
+		if !u.isOperationAllowed(permission, path) {
+			return false
+		}
+	}
 	perms := u.GetPermissionsForPath(path)
 	if utils.IsStringInSlice(PermAny, perms) {
 		return true
@@ -700,6 +899,26 @@ func (u *User) HasPerms(permissions []string, path string) bool {
 	return true
 }
 
+// isOperationAllowed returns false if the operation filter for the given path,
+// if any, does not allow the operation identified by the given permission
+func (u *User) isOperationAllowed(permission, virtualPath string) bool {
+	if len(u.Filters.Operations) == 0 {
+		return true
+	}
+	dirsForPath := utils.GetDirsForVirtualPath(virtualPath)
+	for _, dir := range dirsForPath {
+		for _, f := range u.Filters.Operations {
+			if u.isSamePath(f.Path, dir) {
+				if utils.IsStringInSlice(permission, f.DeniedOperations) {
+					return false
+				}
+				return len(f.AllowedOperations) == 0 || utils.IsStringInSlice(permission, f.AllowedOperations)
+			}
+		}
+	}
+	return true
+}
+
 // HasNoQuotaRestrictions returns true if no quota restrictions need to be applyed
 func (u *User) HasNoQuotaRestrictions(checkFiles bool) bool {
 	if u.QuotaSize == 0 && (!checkFiles || u.QuotaFiles == 0) {
@@ -780,9 +999,137 @@ func (u *User) GetAllowedLoginMethods() []string {
 
 // IsFileAllowed returns true if the specified file is allowed by the file restrictions filters
 func (u *User) IsFileAllowed(virtualPath string) bool {
+	if u.IsReservedPath(virtualPath) {
+		return false
+	}
 	return u.isFilePatternAllowed(virtualPath) && u.isFileExtensionAllowed(virtualPath)
 }
 
+// GetVersioningConfigForPath returns the versioning configuration for the given
+// virtual path and the virtual path where the related filesystem is mounted
+func (u *User) GetVersioningConfigForPath(virtualPath string) (vfs.VersioningConfig, string) {
+	if virtualPath != "" && virtualPath != "/" && len(u.VirtualFolders) > 0 {
+		folder, err := u.GetVirtualFolderForPath(virtualPath)
+		if err == nil {
+			return folder.FsConfig.Versioning, folder.VirtualPath
+		}
+	}
+	return u.FsConfig.Versioning, "/"
+}
+
+// GetMountPath returns the virtual path where the filesystem containing
+// the given virtual path is mounted
+func (u *User) GetMountPath(virtualPath string) string {
+	_, mountPath := u.GetVersioningConfigForPath(virtualPath)
+	return mountPath
+}
+
+// GetMountPaths returns the virtual paths where the user filesystems are mounted
+func (u *User) GetMountPaths() []string {
+	mountPaths := []string{"/"}
+	for idx := range u.VirtualFolders {
+		mountPaths = append(mountPaths, u.VirtualFolders[idx].VirtualPath)
+	}
+	return mountPaths
+}
+
+// HasFileVersioning returns true if file versioning is enabled for the user
+// home or for any virtual folder
+func (u *User) HasFileVersioning() bool {
+	if u.FsConfig.Versioning.IsEnabled() {
+		return true
+	}
+	for idx := range u.VirtualFolders {
+		if u.VirtualFolders[idx].FsConfig.Versioning.IsEnabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// HasArchiveRules returns true if the user has retention rules that move the
+// expired files to an archive folder
+func (u *User) HasArchiveRules() bool {
+	for idx := range u.Filters.Retention {
+		if u.Filters.Retention[idx].ArchiveFolder != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsArchiveFolder returns true if the virtual folder with the given name is the
+// archive folder for one of the retention rules
+func (u *User) IsArchiveFolder(name string) bool {
+	if name == "" {
+		return false
+	}
+	for idx := range u.Filters.Retention {
+		if u.Filters.Retention[idx].ArchiveFolder == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetDirQuotasForPath returns the directory quotas that apply to the file with
+// the given virtual path. Quotas defined for a directory mounted on a different
+// filesystem than the file one are not returned
+func (u *User) GetDirQuotasForPath(virtualPath string) []DirQuota {
+	var quotas []DirQuota
+	if len(u.Filters.DirQuotas) == 0 {
+		return quotas
+	}
+	mountPath := u.GetMountPath(virtualPath)
+	for _, q := range u.Filters.DirQuotas {
+		if !u.hasPathPrefix(virtualPath, q.Path+"/") {
+			continue
+		}
+		if u.GetMountPath(q.Path) != mountPath {
+			continue
+		}
+		quotas = append(quotas, q)
+	}
+	return quotas
+}
+
+// IsVersionsPath returns true if the given virtual path is inside the
+// directory used to store file versions. This directory cannot be accessed
+// directly
+func (u *User) IsVersionsPath(virtualPath string) bool {
+	return u.isInsideMountDir(virtualPath, vfs.VersionsDirName)
+}
+
+// IsTrashPath returns true if the given virtual path is inside the trash
+// directory. This directory cannot be accessed directly
+func (u *User) IsTrashPath(virtualPath string) bool {
+	return u.isInsideMountDir(virtualPath, TrashDirName)
+}
+
+// IsArchivedPath returns true if the given virtual path is inside the directory
+// containing the stubs for the archived files. This directory cannot be accessed directly
+func (u *User) IsArchivedPath(virtualPath string) bool {
+	return u.isInsideMountDir(virtualPath, ArchivedDirName)
+}
+
+// IsReservedPath returns true if the given virtual path is inside a directory
+// reserved for internal usage, such as the versions or the trash directories
+func (u *User) IsReservedPath(virtualPath string) bool {
+	return u.IsVersionsPath(virtualPath) || u.IsTrashPath(virtualPath) || u.IsArchivedPath(virtualPath)
+}
+
+func (u *User) isInsideMountDir(virtualPath, dirName string) bool {
+	if u.Filters.CaseInsensitive {
+		if !strings.Contains(strings.ToLower(virtualPath), strings.ToLower(dirName)) {
+			return false
+		}
+	} else if !strings.Contains(virtualPath, dirName) {
+		return false
+	}
+	dir := path.Join(u.GetMountPath(virtualPath), dirName)
+	return u.isSamePath(virtualPath, dir) || u.hasPathPrefix(virtualPath, dir+"/")
+}
+
 func (u *User) isFileExtensionAllowed(virtualPath string) bool {
 	if len(u.Filters.FileExtensions) == 0 {
 		return true
@@ -791,7 +1138,7 @@ func (u *User) isFileExtensionAllowed(virtualPath string) bool {
 	var filter ExtensionsFilter
 	for _, dir := range dirsForPath {
 		for _, f := range u.Filters.FileExtensions {
-			if f.Path == dir {
+			if u.isSamePath(f.Path, dir) {
 				filter = f
 				break
 			}
@@ -825,7 +1172,7 @@ func (u *User) isFilePatternAllowed(virtualPath string) bool {
 	var filter PatternsFilter
 	for _, dir := range dirsForPath {
 		for _, f := range u.Filters.FilePatterns {
-			if f.Path == dir {
+			if u.isSamePath(f.Path, dir) {
 				filter = f
 				break
 			}
@@ -859,6 +1206,12 @@ func (u *User) CanManagePublicKeys() bool {
 	return !utils.IsStringInSlice(WebClientPubKeyChangeDisabled, u.Filters.WebClient)
 }
 
+// CanManageDropLinks returns true if this user is allowed to create upload-only
+// public links
+func (u *User) CanManageDropLinks() bool {
+	return !utils.IsStringInSlice(WebClientDropLinksDisabled, u.Filters.WebClient)
+}
+
 // GetSignature returns a signature for this admin.
 // It could change after an update
 func (u *User) GetSignature() string {
@@ -939,9 +1292,133 @@ func (u *User) GetGID() int {
 	return u.GID
 }
 
-// GetHomeDir returns the shortest path name equivalent to the user's home directory
+// GetHomeDir returns the shortest path name equivalent to the user's home directory.
+// The placeholders, if any, are replaced with their values for this user.
+// The placeholders without a value are replaced with an empty string, they
+// are rejected by the validation and at login, see checkPlaceholders
 func (u *User) GetHomeDir() string {
-	return filepath.Clean(u.HomeDir)
+	homeDir, _ := u.getHomeDir()
+	return homeDir
+}
+
+func (u *User) getHomeDir() (string, error) {
+	homeDir, err := u.replacePlaceholders(u.HomeDir)
+	return filepath.Clean(homeDir), err
+}
+
+// getKeyPrefix returns the given cloud storage key prefix with the placeholders
+// replaced. The result is cleaned as done while validating the key prefix
+func (u *User) getKeyPrefix(keyPrefix string) (string, error) {
+	if !strings.Contains(keyPrefix, "%") {
+		return keyPrefix, nil
+	}
+	replaced, err := u.replacePlaceholders(keyPrefix)
+	if err != nil {
+		return "", err
+	}
+	keyPrefix = strings.TrimPrefix(path.Clean("/"+replaced), "/")
+	if keyPrefix == "" {
+		return "", nil
+	}
+	return keyPrefix + "/", nil
+}
+
+// getFsKeyPrefix returns the key prefix configured for the cloud storage
+// backends, if any, without replacing the placeholders
+func (u *User) getFsKeyPrefix() string {
+	switch u.FsConfig.Provider {
+	case vfs.S3FilesystemProvider:
+		return u.FsConfig.S3Config.KeyPrefix
+	case vfs.GCSFilesystemProvider:
+		return u.FsConfig.GCSConfig.KeyPrefix
+	case vfs.AzureBlobFilesystemProvider:
+		return u.FsConfig.AzBlobConfig.KeyPrefix
+	default:
+		return ""
+	}
+}
+
+// checkPlaceholders returns an error if the home directory or the key prefix
+// reference a placeholder without a value for this user
+func (u *User) checkPlaceholders() error {
+	if _, err := u.getHomeDir(); err != nil {
+		return fmt.Errorf("unable to resolve the home dir %#v: %w", u.HomeDir, err)
+	}
+	if keyPrefix := u.getFsKeyPrefix(); keyPrefix != "" {
+		if _, err := u.getKeyPrefix(keyPrefix); err != nil {
+			return fmt.Errorf("unable to resolve the key prefix %#v: %w", keyPrefix, err)
+		}
+	}
+	return nil
+}
+
+// replacePlaceholders replaces the following placeholders inside the given value:
+//
+// - %u the username
+// - %n the username without the domain part, if any
+// - %d the domain part of the username, for example "example.com" for "user@example.com"
+// - %{key} the value for the given metadata key
+// - %% a literal %
+//
+// Unknown placeholders are left unchanged. An error is returned if a placeholder
+// has no value, for example %d for a username without a domain part, the placeholder
+// is replaced with an empty string in this case. Path separators and dot-dot inside
+// the replaced values are not allowed, they are replaced with "_", so a value cannot
+// escape the configured directory
+func (u *User) replacePlaceholders(value string) (string, error) {
+	if !strings.Contains(value, "%") {
+		return value, nil
+	}
+	name, domain := u.Username, ""
+	if idx := strings.LastIndex(u.Username, "@"); idx >= 0 {
+		name, domain = u.Username[:idx], u.Username[idx+1:]
+	}
+	var sb strings.Builder
+	var err error
+	writeValue := func(placeholder, val string) {
+		if val == "" && err == nil {
+			err = fmt.Errorf("the placeholder %#v has no value for user %#v", placeholder, u.Username)
+		}
+		sb.WriteString(sanitizePlaceholderValue(val))
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' || i == len(value)-1 {
+			sb.WriteByte(value[i])
+			continue
+		}
+		switch value[i+1] {
+		case 'u':
+			writeValue("%u", u.Username)
+		case 'n':
+			writeValue("%n", name)
+		case 'd':
+			writeValue("%d", domain)
+		case '%':
+			sb.WriteByte('%')
+		case '{':
+			end := strings.IndexByte(value[i+2:], '}')
+			if end < 0 {
+				sb.WriteByte(value[i])
+				continue
+			}
+			key := value[i+2 : i+2+end]
+			writeValue(value[i:i+3+end], u.Filters.Metadata[key])
+			i += end + 1
+		default:
+			sb.WriteByte(value[i])
+			continue
+		}
+		i++
+	}
+	return sb.String(), err
+}
+
+func sanitizePlaceholderValue(value string) string {
+	value = strings.NewReplacer("/", "_", "\\", "_").Replace(value)
+	if value == "." || value == ".." {
+		return "_"
+	}
+	return value
 }
 
 // HasQuotaRestrictions returns true if there is a quota restriction on number of files or size or both
@@ -1028,6 +1505,10 @@ func (u *User) GetInfoString() string {
 		result += "Storage: Encrypted "
 	case vfs.SFTPFilesystemProvider:
 		result += "Storage: SFTP "
+	case vfs.PluginFilesystemProvider:
+		result += "Storage: Plugin "
+	case vfs.SMBFilesystemProvider:
+		result += "Storage: SMB "
 	}
 	if len(u.PublicKeys) > 0 {
 		result += fmt.Sprintf("Public keys: %v ", len(u.PublicKeys))
@@ -1106,6 +1587,7 @@ func (u *User) getACopy() User {
 	}
 	filters := UserFilters{}
 	filters.MaxUploadFileSize = u.Filters.MaxUploadFileSize
+	filters.MaxConcurrentTransfers = u.Filters.MaxConcurrentTransfers
 	filters.TLSUsername = u.Filters.TLSUsername
 	filters.AllowedIP = make([]string, len(u.Filters.AllowedIP))
 	copy(filters.AllowedIP, u.Filters.AllowedIP)
@@ -1117,14 +1599,42 @@ func (u *User) getACopy() User {
 	copy(filters.FileExtensions, u.Filters.FileExtensions)
 	filters.FilePatterns = make([]PatternsFilter, len(u.Filters.FilePatterns))
 	copy(filters.FilePatterns, u.Filters.FilePatterns)
+	filters.Operations = make([]OperationsFilter, 0, len(u.Filters.Operations))
+	for _, f := range u.Filters.Operations {
+		allowed := make([]string, len(f.AllowedOperations))
+		copy(allowed, f.AllowedOperations)
+		denied := make([]string, len(f.DeniedOperations))
+		copy(denied, f.DeniedOperations)
+		f.AllowedOperations = allowed
+		f.DeniedOperations = denied
+		filters.Operations = append(filters.Operations, f)
+	}
 	filters.DeniedProtocols = make([]string, len(u.Filters.DeniedProtocols))
 	copy(filters.DeniedProtocols, u.Filters.DeniedProtocols)
 	filters.Hooks.ExternalAuthDisabled = u.Filters.Hooks.ExternalAuthDisabled
 	filters.Hooks.PreLoginDisabled = u.Filters.Hooks.PreLoginDisabled
 	filters.Hooks.CheckPasswordDisabled = u.Filters.Hooks.CheckPasswordDisabled
 	filters.DisableFsChecks = u.Filters.DisableFsChecks
+	filters.CaseInsensitive = u.Filters.CaseInsensitive
+	filters.Impersonate = u.Filters.Impersonate
+	filters.Trash = u.Filters.Trash
+	filters.DirQuotas = make([]DirQuota, len(u.Filters.DirQuotas))
+	copy(filters.DirQuotas, u.Filters.DirQuotas)
+	filters.Retention = make([]RetentionRule, 0, len(u.Filters.Retention))
+	for _, rule := range u.Filters.Retention {
+		patterns := make([]string, len(rule.Patterns))
+		copy(patterns, rule.Patterns)
+		rule.Patterns = patterns
+		filters.Retention = append(filters.Retention, rule)
+	}
 	filters.WebClient = make([]string, len(u.Filters.WebClient))
 	copy(filters.WebClient, u.Filters.WebClient)
+	if u.Filters.Metadata != nil {
+		filters.Metadata = make(map[string]string, len(u.Filters.Metadata))
+		for k, v := range u.Filters.Metadata {
+			filters.Metadata[k] = v
+		}
+	}
 
 	return User{
 		ID:                u.ID,
@@ -1151,6 +1661,7 @@ func (u *User) getACopy() User {
 		FsConfig:          u.FsConfig.GetACopy(),
 		AdditionalInfo:    u.AdditionalInfo,
 		Description:       u.Description,
+		Version:           u.Version,
 	}
 }
 
@@ -0,0 +1,327 @@
+package dataprovider
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+func TestCaseInsensitivePaths(t *testing.T) {
+	user := User{
+		Permissions: map[string][]string{
+			"/":        {PermAny},
+			"/Private": {PermListItems},
+		},
+	}
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/VDir",
+	})
+	user.Filters.FilePatterns = []PatternsFilter{
+		{
+			Path:           "/Docs",
+			DeniedPatterns: []string{"*.exe"},
+		},
+	}
+	assert.True(t, user.HasPerm(PermDownload, "/private/file"))
+	assert.True(t, user.IsFileAllowed("/docs/file.exe"))
+	assert.False(t, user.IsVirtualFolder("/vdir"))
+	assert.False(t, user.IsReservedPath("/.SFTPGO-VERSIONS/file"))
+
+	user.Filters.CaseInsensitive = true
+	assert.False(t, user.HasPerm(PermDownload, "/private/file"))
+	assert.False(t, user.HasPerm(PermDownload, "/PRIVATE"))
+	assert.True(t, user.HasPerm(PermListItems, "/pRiVaTe/sub"))
+	assert.False(t, user.IsFileAllowed("/docs/file.exe"))
+	assert.True(t, user.IsVirtualFolder("/vdir"))
+	assert.Equal(t, "/VDir", user.GetMountPath("/vdir/file"))
+	assert.True(t, user.HasVirtualFoldersInside("/"))
+	assert.True(t, user.IsReservedPath("/.SFTPGO-VERSIONS/file"))
+	assert.True(t, user.IsReservedPath("/vdir/"+vfs.VersionsDirName))
+
+	assert.NoError(t, validateCaseInsensitivePaths(&user))
+	user.Permissions["/private"] = []string{PermAny}
+	assert.Error(t, validateCaseInsensitivePaths(&user))
+	delete(user.Permissions, "/private")
+	user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+		VirtualPath: "/vdir",
+	})
+	assert.Error(t, validateCaseInsensitivePaths(&user))
+	user.Filters.CaseInsensitive = false
+	assert.NoError(t, validateCaseInsensitivePaths(&user))
+}
+
+func TestImpersonationValidation(t *testing.T) {
+	user := User{}
+	assert.NoError(t, validateImpersonation(&user))
+	user.Filters.Impersonate = true
+	err := validateImpersonation(&user)
+	assert.Error(t, err)
+	if vfs.IsImpersonationSupported() {
+		assert.Contains(t, err.Error(), "greater than 0")
+		user.UID = 1000
+		assert.Error(t, validateImpersonation(&user))
+		user.GID = 1000
+		assert.NoError(t, validateImpersonation(&user))
+	}
+}
+
+func TestOperationsFilters(t *testing.T) {
+	user := User{
+		Permissions: map[string][]string{
+			"/": {PermAny},
+		},
+	}
+	user.Filters.Operations = []OperationsFilter{
+		{
+			Path:             "/readonly/",
+			DeniedOperations: []string{PermUpload, PermDelete, PermUpload},
+		},
+		{
+			Path:              "/dropbox",
+			AllowedOperations: []string{PermUpload, PermCreateDirs},
+		},
+		{
+			Path:              "/dropbox/sub",
+			AllowedOperations: []string{PermUpload, PermListItems},
+			DeniedOperations:  []string{PermUpload},
+		},
+	}
+	err := validateOperationsFilters(&user)
+	require.NoError(t, err)
+	assert.Equal(t, "/readonly", user.Filters.Operations[0].Path)
+	assert.Len(t, user.Filters.Operations[0].DeniedOperations, 2)
+
+	assert.True(t, user.HasPerm(PermUpload, "/"))
+	assert.True(t, user.HasPerm(PermDownload, "/readonly"))
+	assert.False(t, user.HasPerm(PermUpload, "/readonly"))
+	assert.False(t, user.HasPerm(PermDelete, "/readonly/dir/file"))
+	assert.False(t, user.HasPerms([]string{PermDownload, PermDelete}, "/readonly"))
+	assert.True(t, user.HasPerms([]string{PermDownload, PermListItems}, "/readonly"))
+	assert.True(t, user.HasPerm(PermUpload, "/dropbox/file"))
+	assert.False(t, user.HasPerm(PermDownload, "/dropbox/file"))
+	assert.False(t, user.HasPerm(PermListItems, "/dropbox"))
+	assert.True(t, user.HasPerm(PermListItems, "/dropbox/sub"))
+	assert.False(t, user.HasPerm(PermUpload, "/dropbox/sub/file"))
+	// the filters cannot grant operations not allowed by the permissions
+	user.Permissions["/dropbox"] = []string{PermListItems}
+	assert.False(t, user.HasPerm(PermUpload, "/dropbox/file"))
+	assert.True(t, user.HasPerm(PermListItems, "/dropbox/sub"))
+
+	user.Filters.Operations = []OperationsFilter{
+		{
+			Path:             "readonly",
+			DeniedOperations: []string{PermUpload},
+		},
+	}
+	err = validateOperationsFilters(&user)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid path")
+	}
+	user.Filters.Operations = []OperationsFilter{
+		{
+			Path:             "/readonly",
+			DeniedOperations: []string{PermUpload},
+		},
+		{
+			Path:              "/readonly/",
+			AllowedOperations: []string{PermDownload},
+		},
+	}
+	err = validateOperationsFilters(&user)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "duplicate")
+	}
+	user.Filters.Operations = []OperationsFilter{
+		{
+			Path: "/readonly",
+		},
+	}
+	err = validateOperationsFilters(&user)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "empty operations filter")
+	}
+	for _, op := range []string{PermAny, "invalid"} {
+		user.Filters.Operations = []OperationsFilter{
+			{
+				Path:             "/readonly",
+				DeniedOperations: []string{op},
+			},
+		}
+		err = validateOperationsFilters(&user)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "invalid operation")
+		}
+	}
+	user.Filters.Operations = nil
+	err = validateOperationsFilters(&user)
+	assert.NoError(t, err)
+	assert.NotNil(t, user.Filters.Operations)
+	assert.True(t, user.HasPerm(PermUpload, "/readonly"))
+}
+
+func TestHomeDirPlaceholders(t *testing.T) {
+	user := User{
+		Username: "john@example.com",
+		HomeDir:  filepath.Join(os.TempDir(), "%{tenant}", "%d", "%n"),
+	}
+	user.Filters.Metadata = map[string]string{
+		"tenant": "acme",
+	}
+	assert.Equal(t, filepath.Join(os.TempDir(), "acme", "example.com", "john"), user.GetHomeDir())
+	assert.NoError(t, user.checkPlaceholders())
+	user.HomeDir = filepath.Join(os.TempDir(), "%u", "%%", "%x", "%{unclosed")
+	assert.Equal(t, filepath.Join(os.TempDir(), "john@example.com", "%", "%x", "%{unclosed"), user.GetHomeDir())
+	assert.NoError(t, user.checkPlaceholders())
+	// the replaced values cannot escape the configured directory
+	user.Username = "..@.."
+	user.Filters.Metadata["tenant"] = "../acme"
+	user.HomeDir = filepath.Join(os.TempDir(), "%{tenant}", "%d", "%n")
+	assert.Equal(t, filepath.Join(os.TempDir(), ".._acme", "_", "_"), user.GetHomeDir())
+	// a placeholder without a value is an error
+	user.Username = "john"
+	err := user.checkPlaceholders()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `the placeholder "%d" has no value`)
+	}
+	_, err = user.GetFilesystem("")
+	assert.Error(t, err)
+	user.HomeDir = filepath.Join(os.TempDir(), "%{missing}", "%n")
+	err = user.checkPlaceholders()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), `the placeholder "%{missing}" has no value`)
+	}
+	user.Filters.Metadata["missing"] = ""
+	assert.Error(t, user.checkPlaceholders())
+
+	user.Username = "john@example.com"
+	user.HomeDir = filepath.Join(os.TempDir(), "%n")
+	user.Filters.Metadata["tenant"] = "acme"
+	for _, keyPrefix := range []string{"", "static/", "tenants/%{tenant}/%d/%n/"} {
+		prefix, err := user.getKeyPrefix(keyPrefix)
+		assert.NoError(t, err)
+		switch keyPrefix {
+		case "tenants/%{tenant}/%d/%n/":
+			assert.Equal(t, "tenants/acme/example.com/john/", prefix)
+		default:
+			assert.Equal(t, keyPrefix, prefix)
+		}
+	}
+	_, err = user.getKeyPrefix("%{missing}/%n/")
+	assert.Error(t, err)
+
+	user.FsConfig.Provider = vfs.S3FilesystemProvider
+	user.FsConfig.S3Config.KeyPrefix = "%d/%n/"
+	assert.NoError(t, user.checkPlaceholders())
+	userCopy := user.getACopy()
+	userCopy.Filters.Metadata["tenant"] = "changed"
+	assert.Equal(t, "acme", user.Filters.Metadata["tenant"])
+	assert.Equal(t, "%d/%n/", userCopy.FsConfig.S3Config.KeyPrefix)
+	userCopy.Username = "john"
+	err = userCopy.checkPlaceholders()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to resolve the key prefix")
+	}
+	userCopy.Status = 1
+	err = checkLoginConditions(&userCopy)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to resolve the key prefix")
+	}
+
+	assert.NoError(t, validateMetadata(&user))
+	user.Filters.Metadata["invalid key"] = "value"
+	err = validateMetadata(&user)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid metadata key")
+	}
+}
+
+func TestOptimisticLocking(t *testing.T) {
+	basePath := t.TempDir()
+	for _, driver := range []string{BoltDataProviderName, SQLiteDataProviderName, MemoryDataProviderName} {
+		c := Config{
+			Driver:          driver,
+			Name:            filepath.Join(basePath, driver+".db"),
+			CredentialsPath: "credentials",
+			PasswordHashing: PasswordHashing{
+				Argon2Options: Argon2Options{
+					Memory:      65536,
+					Iterations:  1,
+					Parallelism: 2,
+				},
+			},
+		}
+		if driver == MemoryDataProviderName {
+			c.Name = ""
+		}
+		err := Initialize(c, basePath, false)
+		require.NoError(t, err, driver)
+
+		folder := vfs.BaseVirtualFolder{
+			Name:       "locked_folder",
+			MappedPath: filepath.Join(basePath, "folder"),
+		}
+		err = AddFolder(&folder)
+		assert.NoError(t, err)
+		user := getTestUser()
+		user.VirtualFolders = append(user.VirtualFolders, vfs.VirtualFolder{
+			BaseVirtualFolder: folder,
+			VirtualPath:       "/vdir",
+		})
+		err = AddUser(&user)
+		assert.NoError(t, err)
+		admin := Admin{
+			Username:    "locked_admin",
+			Password:    "password",
+			Status:      1,
+			Permissions: []string{PermAdminAny},
+		}
+		err = AddAdmin(&admin)
+		assert.NoError(t, err)
+
+		user1, err := UserExists(user.Username)
+		assert.NoError(t, err)
+		user2, err := UserExists(user.Username)
+		assert.NoError(t, err)
+		user1.Description = "first update"
+		err = UpdateUser(&user1)
+		assert.NoError(t, err, driver)
+		assert.Equal(t, user2.Version+1, user1.Version)
+		user2.Description = "second update"
+		err = UpdateUser(&user2)
+		assert.True(t, errors.Is(err, ErrVersionMismatch), driver)
+		user, err = UserExists(user.Username)
+		assert.NoError(t, err)
+		assert.Equal(t, user1.Version, user.Version)
+		assert.Equal(t, "first update", user.Description)
+
+		folder1, err := GetFolderByName(folder.Name)
+		assert.NoError(t, err)
+		// the user update changed the folder too
+		assert.Greater(t, folder1.Version, int64(0), driver)
+		folder2 := folder1.GetACopy()
+		folder1.Description = "first update"
+		err = UpdateFolder(&folder1, folder1.Users)
+		assert.NoError(t, err, driver)
+		err = UpdateFolder(&folder2, folder2.Users)
+		assert.True(t, errors.Is(err, ErrVersionMismatch), driver)
+
+		admin1, err := AdminExists(admin.Username)
+		assert.NoError(t, err)
+		admin2, err := AdminExists(admin.Username)
+		assert.NoError(t, err)
+		admin1.Description = "first update"
+		err = UpdateAdmin(&admin1)
+		assert.NoError(t, err, driver)
+		err = UpdateAdmin(&admin2)
+		assert.True(t, errors.Is(err, ErrVersionMismatch), driver)
+
+		err = Close()
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,12 @@
+// Package docs embeds the documentation needed at runtime
+package docs
+
+import (
+	_ "embed"
+)
+
+// FullConfiguration is the full configuration reference. It is used to document
+// the settings of the generated default configuration
+//
+//go:embed full-configuration.md
+var FullConfiguration string
@@ -4,7 +4,9 @@ package ftpd
 import (
 	"fmt"
 	"net"
+	"os"
 	"path/filepath"
+	"sync"
 
 	ftpserver "github.com/fclairamb/ftpserverlib"
 
@@ -20,6 +22,9 @@ const (
 var (
 	certMgr       *common.CertManager
 	serviceStatus ServiceStatus
+	serversMu     sync.Mutex
+	ftpServers    []*ftpserver.FtpServer
+	ftpDrivers    []*Server
 )
 
 // Binding defines the configuration for a network listener
@@ -225,10 +230,25 @@ func (c *Configuration) Initialize(configDir string) error {
 		server := NewServer(c, configDir, binding, idx)
 
 		go func(s *Server) {
-			ftpServer := ftpserver.NewFtpServer(s)
 			logger.Info(logSender, "", "starting FTP serving, binding: %v", s.binding.GetAddress())
 			utils.CheckTCP4Port(s.binding.Port)
-			exitChannel <- ftpServer.ListenAndServe()
+			// the listener is created here, instead of letting ftpserverlib create it,
+			// so it can be inherited by the new process after a graceful restart
+			listener, err := utils.Listen("tcp", s.binding.GetAddress())
+			if err != nil {
+				logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
+				exitChannel <- err
+				return
+			}
+			s.listener = listener
+			ftpServer := ftpserver.NewFtpServer(s)
+			serversMu.Lock()
+			ftpServers = append(ftpServers, ftpServer)
+			ftpDrivers = append(ftpDrivers, s)
+			serversMu.Unlock()
+			err = ftpServer.ListenAndServe()
+			listener.Close()
+			exitChannel <- err
 		}(server)
 
 		serviceStatus.Bindings = append(serviceStatus.Bindings, binding)
@@ -239,6 +259,51 @@ func (c *Configuration) Initialize(configDir string) error {
 	return <-exitChannel
 }
 
+// Stop stops the running FTP servers, they will not accept new connections
+func Stop() {
+	serversMu.Lock()
+	defer serversMu.Unlock()
+
+	for _, s := range ftpServers {
+		if err := s.Stop(); err != nil {
+			logger.Debug(logSender, "", "error stopping FTP server: %v", err)
+		}
+	}
+	ftpServers = nil
+	ftpDrivers = nil
+}
+
+// ReloadBanner reads the banner again, using the banner and banner_file settings
+// of this configuration, and sends the new banner to the clients that connect from
+// now on. The other settings are ignored
+func (c *Configuration) ReloadBanner(configDir string) {
+	msg := c.getInitialMessage(configDir)
+
+	serversMu.Lock()
+	defer serversMu.Unlock()
+
+	for _, s := range ftpDrivers {
+		s.setInitialMessage(msg)
+	}
+}
+
+func (c *Configuration) getInitialMessage(configDir string) string {
+	if c.BannerFile == "" {
+		return c.Banner
+	}
+	bannerFilePath := c.BannerFile
+	if !filepath.IsAbs(bannerFilePath) {
+		bannerFilePath = filepath.Join(configDir, bannerFilePath)
+	}
+	bannerContent, err := os.ReadFile(bannerFilePath)
+	if err != nil {
+		logger.WarnToConsole("unable to read FTPD banner file: %v", err)
+		logger.Warn(logSender, "", "unable to read banner file: %v", err)
+		return c.Banner
+	}
+	return string(bannerContent)
+}
+
 // ReloadCertificateMgr reloads the certificate manager
 func ReloadCertificateMgr() error {
 	if certMgr != nil {
@@ -13,6 +13,7 @@ import (
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
 )
 
@@ -276,6 +277,22 @@ func (c *Connection) ReadDir(name string) ([]os.FileInfo, error) {
 func (c *Connection) GetHandle(name string, flags int, offset int64) (ftpserver.FileTransfer, error) {
 	c.UpdateLastActivity()
 
+	if err := c.LimitOperationRate(common.RateLimitOperationOpen); err != nil {
+		return nil, err
+	}
+
+	if err := c.CheckMaxTransfers(); err != nil {
+		return nil, err
+	}
+
+	if flags&os.O_WRONLY != 0 {
+		virtualPath, err := c.PreUploadAction(name)
+		if err != nil {
+			return nil, err
+		}
+		name = virtualPath
+	}
+
 	fs, p, err := c.GetFsAndResolvedPath(name)
 	if err != nil {
 		return nil, err
@@ -301,6 +318,10 @@ func (c *Connection) downloadFile(fs vfs.Fs, fsPath, ftpPath string, offset int6
 		return nil, c.GetPermissionDeniedError()
 	}
 
+	if err := c.PreDownloadAction(fsPath, ftpPath, utils.GetIPFromRemoteAddress(c.GetRemoteAddress())); err != nil {
+		return nil, err
+	}
+
 	file, r, cancelFn, err := fs.Open(fsPath, offset)
 	if err != nil {
 		c.Log(logger.LevelWarn, "could not open file %#v for reading: %+v", fsPath, err)
@@ -321,7 +342,7 @@ func (c *Connection) uploadFile(fs vfs.Fs, fsPath, ftpPath string, flags int) (f
 	}
 
 	filePath := fsPath
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.Config.IsAtomicUploadEnabled() && fs.Capabilities().AtomicUpload {
 		filePath = fs.GetAtomicUploadPath(fsPath)
 	}
 
@@ -330,6 +351,13 @@ func (c *Connection) uploadFile(fs vfs.Fs, fsPath, ftpPath string, flags int) (f
 		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(ftpPath)) {
 			return nil, c.GetPermissionDeniedError()
 		}
+		// an interrupted upload to a cloud storage backend is not visible, it
+		// can be resumed using REST or APPE for the missing file
+		if statErr != nil && flags&os.O_TRUNC == 0 {
+			if size, ok := vfs.GetResumableUploadSize(fs, fsPath); ok {
+				return c.handleFTPUploadToExistingFile(fs, flags, fsPath, filePath, size, ftpPath)
+			}
+		}
 		return c.handleFTPUploadToNewFile(fs, fsPath, filePath, ftpPath)
 	}
 
@@ -348,6 +376,17 @@ func (c *Connection) uploadFile(fs vfs.Fs, fsPath, ftpPath string, flags int) (f
 		return nil, c.GetPermissionDeniedError()
 	}
 
+	if flags&os.O_TRUNC != 0 {
+		// the existing content will be replaced, save it as a new version if versioning is enabled
+		isVersioned, err := c.SaveFileVersion(fs, fsPath, ftpPath)
+		if err != nil {
+			return nil, c.GetFsError(fs, err)
+		}
+		if isVersioned {
+			return c.handleFTPUploadToNewFile(fs, fsPath, filePath, ftpPath)
+		}
+	}
+
 	return c.handleFTPUploadToExistingFile(fs, flags, fsPath, filePath, stat.Size(), ftpPath)
 }
 
@@ -366,10 +405,11 @@ func (c *Connection) handleFTPUploadToNewFile(fs vfs.Fs, resolvedPath, filePath,
 	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
 
 	// we can get an error only for resume
-	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported())
+	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0, fs.Capabilities().UploadResume)
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, 0, 0, maxWriteSize, true, fs)
+	baseTransfer.SetEffectiveFsPath(filePath)
 	t := newTransfer(baseTransfer, w, nil, 0)
 
 	return t, nil
@@ -390,15 +430,19 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 	// - os.O_WRONLY | os.O_CREATE | os.O_TRUNC if the command is not APPE and REST = 0
 	// so if we don't have O_TRUNC is a resume.
 	isResume := flags&os.O_TRUNC == 0
+	// an interrupted upload to a cloud storage backend can be continued, the file
+	// size is the already uploaded size
+	isResumableUpload := isResume && vfs.IsResumableUpload(fs, filePath, fileSize)
 	// if there is a size limit remaining size cannot be 0 here, since quotaResult.HasSpace
 	// will return false in this case and we deny the upload before
-	maxWriteSize, err := c.GetMaxWriteSize(quotaResult, isResume, fileSize, fs.IsUploadResumeSupported())
+	maxWriteSize, err := c.GetMaxWriteSize(quotaResult, isResume, fileSize,
+		fs.Capabilities().UploadResume || isResumableUpload)
 	if err != nil {
 		c.Log(logger.LevelDebug, "unable to get max write size: %v", err)
 		return nil, err
 	}
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if caps := fs.Capabilities(); common.Config.IsAtomicUploadEnabled() && caps.AtomicUpload && caps.Truncate {
 		err = fs.Rename(resolvedPath, filePath)
 		if err != nil {
 			c.Log(logger.LevelWarn, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %+v",
@@ -407,7 +451,14 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 		}
 	}
 
-	file, w, cancelFn, err := fs.Create(filePath, flags)
+	var file vfs.File
+	var w *vfs.PipeWriter
+	var cancelFn func()
+	if isResumableUpload {
+		w, cancelFn, err = fs.(vfs.ResumableUploadFs).ResumeUpload(filePath, fileSize)
+	} else {
+		file, w, cancelFn, err = fs.Create(filePath, flags)
+	}
 	if err != nil {
 		c.Log(logger.LevelWarn, "error opening existing file, flags: %v, source: %#v, err: %+v", flags, filePath, err)
 		return nil, c.GetFsError(fs, err)
@@ -417,13 +468,16 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 	if isResume {
 		c.Log(logger.LevelDebug, "resuming upload requested, file path: %#v initial size: %v", filePath, fileSize)
 		minWriteOffset = fileSize
-		initialSize = fileSize
-		if vfs.IsSFTPFs(fs) && fs.IsUploadResumeSupported() {
+		// the interrupted upload is not included in the quota
+		if !isResumableUpload {
+			initialSize = fileSize
+		}
+		if vfs.IsSFTPFs(fs) && fs.Capabilities().UploadResume {
 			// we need this since we don't allow resume with wrong offset, we should fix this in pkg/sftp
 			file.Seek(initialSize, io.SeekStart) //nolint:errcheck // for sftp seek simply set the offset
 		}
 	} else {
-		if vfs.IsLocalOrSFTPFs(fs) {
+		if fs.Capabilities().Truncate {
 			vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
 				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
@@ -433,6 +487,7 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 			} else {
 				dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
 			}
+			dataprovider.UpdateDirQuota(&c.User, requestPath, 0, -fileSize)
 		} else {
 			initialSize = fileSize
 		}
@@ -441,7 +496,8 @@ func (c *Connection) handleFTPUploadToExistingFile(fs vfs.Fs, flags int, resolve
 	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
-		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, false, fs)
+		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, isResumableUpload, fs)
+	baseTransfer.SetEffectiveFsPath(filePath)
 	t := newTransfer(baseTransfer, w, nil, 0)
 
 	return t, nil
@@ -307,14 +307,11 @@ func (fs MockOsFs) Name() string {
 	return "mockOsFs"
 }
 
-// IsUploadResumeSupported returns true if resuming uploads is supported
-func (MockOsFs) IsUploadResumeSupported() bool {
-	return false
-}
-
-// IsAtomicUploadSupported returns true if atomic upload is supported
-func (fs MockOsFs) IsAtomicUploadSupported() bool {
-	return fs.isAtomicUploadSupported
+// Capabilities returns the features supported by this filesystem
+func (fs MockOsFs) Capabilities() vfs.FsCapabilities {
+	return vfs.FsCapabilities{
+		AtomicUpload: fs.isAtomicUploadSupported,
+	}
 }
 
 // Stat returns a FileInfo describing the named file
@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"net"
-	"os"
 	"path/filepath"
 	"sync"
 
@@ -27,6 +26,7 @@ type Server struct {
 	initialMsg       string
 	statusBanner     string
 	binding          Binding
+	listener         net.Listener
 	tlsConfig        *tls.Config
 	mu               sync.RWMutex
 	verifiedTLSConns map[uint32]bool
@@ -37,29 +37,30 @@ func NewServer(config *Configuration, configDir string, binding Binding, id int)
 	binding.setCiphers()
 	server := &Server{
 		config:           config,
-		initialMsg:       config.Banner,
+		initialMsg:       config.getInitialMessage(configDir),
 		statusBanner:     fmt.Sprintf("SFTPGo %v FTP Server", version.Get().Version),
 		binding:          binding,
 		ID:               id,
 		verifiedTLSConns: make(map[uint32]bool),
 	}
-	if config.BannerFile != "" {
-		bannerFilePath := config.BannerFile
-		if !filepath.IsAbs(bannerFilePath) {
-			bannerFilePath = filepath.Join(configDir, bannerFilePath)
-		}
-		bannerContent, err := os.ReadFile(bannerFilePath)
-		if err == nil {
-			server.initialMsg = string(bannerContent)
-		} else {
-			logger.WarnToConsole("unable to read FTPD banner file: %v", err)
-			logger.Warn(logSender, "", "unable to read banner file: %v", err)
-		}
-	}
 	server.buildTLSConfig()
 	return server
 }
 
+func (s *Server) getInitialMessage() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.initialMsg
+}
+
+func (s *Server) setInitialMessage(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.initialMsg = msg
+}
+
 func (s *Server) isTLSConnVerified(id uint32) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
@@ -93,12 +94,16 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 			End:   s.config.PassivePortRange.End,
 		}
 	}
-	var ftpListener net.Listener
+	ftpListener := s.listener
 	if common.Config.ProxyProtocol > 0 && s.binding.ApplyProxyConfig {
-		listener, err := net.Listen("tcp", s.binding.GetAddress())
-		if err != nil {
-			logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
-			return nil, err
+		var err error
+		listener := s.listener
+		if listener == nil {
+			listener, err = utils.Listen("tcp", s.binding.GetAddress())
+			if err != nil {
+				logger.Warn(logSender, "", "error starting listener on address %v: %v", s.binding.GetAddress(), err)
+				return nil, err
+			}
 		}
 		ftpListener, err = common.Config.GetProxyListener(listener)
 		if err != nil {
@@ -137,6 +142,9 @@ func (s *Server) GetSettings() (*ftpserver.Settings, error) {
 func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 	common.Connections.AddNetworkConnection()
 	ipAddr := utils.GetIPFromRemoteAddress(cc.RemoteAddr().String())
+	if err := common.Config.ExecutePreConnectHook(ipAddr, common.ProtocolFTP); err != nil {
+		return "Access denied by pre connect hook", err
+	}
 	if common.IsBanned(ipAddr) {
 		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, ip %#v is banned", ipAddr)
 		return "Access denied: banned client IP", common.ErrConnectionDenied
@@ -145,6 +153,10 @@ func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused, configured limit reached")
 		return "Access denied: max allowed connection exceeded", common.ErrConnectionDenied
 	}
+	if err := common.CheckLoad(); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolFTP, "", "connection refused: %v", err)
+		return fmt.Sprintf("Access denied: %v", err.Error()), err
+	}
 	_, err := common.LimitRate(common.ProtocolFTP, ipAddr)
 	if err != nil {
 		return fmt.Sprintf("Access denied: %v", err.Error()), err
@@ -158,8 +170,9 @@ func (s *Server) ClientConnected(cc ftpserver.ClientContext) (string, error) {
 		BaseConnection: common.NewBaseConnection(connID, common.ProtocolFTP, user),
 		clientContext:  cc,
 	}
+	connection.SetRemoteAddress(cc.RemoteAddr().String())
 	common.Connections.Add(connection)
-	return s.initialMsg, nil
+	return s.getInitialMessage(), nil
 }
 
 // ClientDisconnected is called when the user disconnects, even if he never authenticated
@@ -177,6 +190,9 @@ func (s *Server) AuthUser(cc ftpserver.ClientContext, username, password string)
 		loginMethod = dataprovider.LoginMethodTLSCertificateAndPwd
 	}
 	ipAddr := utils.GetIPFromRemoteAddress(cc.RemoteAddr().String())
+	if err := common.LimitAuthRate(common.ProtocolFTP, ipAddr); err != nil {
+		return nil, err
+	}
 	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, common.ProtocolFTP)
 	if err != nil {
 		user.Username = username
@@ -207,6 +223,9 @@ func (s *Server) VerifyConnection(cc ftpserver.ClientContext, user string, tlsCo
 		state := tlsConn.ConnectionState()
 		if len(state.PeerCertificates) > 0 {
 			ipAddr := utils.GetIPFromRemoteAddress(cc.RemoteAddr().String())
+			if err := common.LimitAuthRate(common.ProtocolFTP, ipAddr); err != nil {
+				return nil, err
+			}
 			dbUser, err := dataprovider.CheckUserBeforeTLSAuth(user, ipAddr, common.ProtocolFTP, state.PeerCertificates[0])
 			if err != nil {
 				dbUser.Username = user
@@ -338,6 +357,7 @@ func (s *Server) validateUser(user dataprovider.User, cc ftpserver.ClientContext
 		BaseConnection: common.NewBaseConnection(fmt.Sprintf("%v_%v", s.ID, cc.ID()), common.ProtocolFTP, user),
 		clientContext:  cc,
 	}
+	connection.SetRemoteAddress(cc.RemoteAddr().String())
 	err = common.Connections.Swap(connection)
 	if err != nil {
 		err = user.CloseFs()
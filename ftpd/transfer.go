@@ -47,7 +47,7 @@ func (t *transfer) Read(p []byte) (n int, err error) {
 	t.Connection.UpdateLastActivity()
 
 	n, err = t.reader.Read(p)
-	atomic.AddInt64(&t.BytesSent, int64(n))
+	t.UpdateChecksum(p[:n], atomic.AddInt64(&t.BytesSent, int64(n))-int64(n))
 
 	if err != nil && err != io.EOF {
 		t.TransferError(err)
@@ -62,7 +62,7 @@ func (t *transfer) Write(p []byte) (n int, err error) {
 	t.Connection.UpdateLastActivity()
 
 	n, err = t.writer.Write(p)
-	atomic.AddInt64(&t.BytesReceived, int64(n))
+	t.UpdateChecksum(p[:n], atomic.AddInt64(&t.BytesReceived, int64(n))-int64(n))
 
 	if t.MaxWriteSize > 0 && err == nil && atomic.LoadInt64(&t.BytesReceived) > t.MaxWriteSize {
 		err = common.ErrQuotaExceeded
@@ -83,9 +83,19 @@ func (t *transfer) Seek(offset int64, whence int) (int64, error) {
 		if err != nil {
 			t.TransferError(err)
 		}
+		if ret != 0 {
+			t.DisableChecksum()
+		}
 		return ret, err
 	}
 	if t.reader != nil && t.expectedOffset == offset && whence == io.SeekStart {
+		if offset != 0 {
+			t.DisableChecksum()
+		}
+		return offset, nil
+	}
+	// resumed uploads to cloud storage backends continue at the already uploaded size
+	if t.writer != nil && t.MinWriteOffset > 0 && t.MinWriteOffset == offset && whence == io.SeekStart {
 		return offset, nil
 	}
 	t.TransferError(errors.New("seek is unsupported for this transfer"))
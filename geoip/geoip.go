@@ -0,0 +1,189 @@
+// Package geoip resolves the client IP addresses to their country and
+// autonomous system using local MaxMind DB files, for example the GeoLite2
+// databases. No network request is made for the lookups
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+var (
+	mu        sync.RWMutex
+	countryDB *maxminddb.Reader
+	asnDB     *maxminddb.Reader
+)
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	// the registered country is used if the country is not available
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+func (r *countryRecord) getCountryCode() string {
+	if r.Country.ISOCode != "" {
+		return r.Country.ISOCode
+	}
+	return r.RegisteredCountry.ISOCode
+}
+
+type asnRecord struct {
+	AutonomousSystemNumber       uint   `maxminddb:"autonomous_system_number"`
+	AutonomousSystemOrganization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Config defines the MaxMind DB files used to resolve the client IP addresses.
+// The same file can be used for both the country and the autonomous system if
+// it includes both the information
+type Config struct {
+	// Absolute path to a MaxMind DB file including the country, for example
+	// GeoLite2-Country.mmdb or GeoLite2-City.mmdb. Leave empty to disable
+	CountryDatabase string `json:"country_database" mapstructure:"country_database"`
+	// Absolute path to a MaxMind DB file including the autonomous system, for
+	// example GeoLite2-ASN.mmdb. Leave empty to disable
+	ASNDatabase string `json:"asn_database" mapstructure:"asn_database"`
+}
+
+// IsEnabled returns true if at least a database is configured
+func (c *Config) IsEnabled() bool {
+	return c.CountryDatabase != "" || c.ASNDatabase != ""
+}
+
+func (c *Config) validate() error {
+	if c.CountryDatabase != "" && !filepath.IsAbs(c.CountryDatabase) {
+		return fmt.Errorf("invalid country database %#v, it must be an absolute path", c.CountryDatabase)
+	}
+	if c.ASNDatabase != "" && !filepath.IsAbs(c.ASNDatabase) {
+		return fmt.Errorf("invalid ASN database %#v, it must be an absolute path", c.ASNDatabase)
+	}
+	return nil
+}
+
+// Initialize loads the configured databases. The files are read again on each
+// call, so it can be used to load updated databases. The previous databases,
+// if any, are kept if an error is returned
+func (c *Config) Initialize() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	var country, asn *maxminddb.Reader
+	var err error
+	if c.CountryDatabase != "" {
+		country, err = maxminddb.Open(c.CountryDatabase)
+		if err != nil {
+			return fmt.Errorf("unable to load the country database %#v: %w", c.CountryDatabase, err)
+		}
+	}
+	if c.ASNDatabase != "" {
+		asn, err = maxminddb.Open(c.ASNDatabase)
+		if err != nil {
+			closeDatabases(country)
+			return fmt.Errorf("unable to load the ASN database %#v: %w", c.ASNDatabase, err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// the lookups hold the read lock, so the previous databases are not in use
+	closeDatabases(countryDB, asnDB)
+	countryDB = country
+	asnDB = asn
+	return nil
+}
+
+func closeDatabases(databases ...*maxminddb.Reader) {
+	for _, db := range databases {
+		if db != nil {
+			db.Close() //nolint:errcheck
+		}
+	}
+}
+
+// GetDatabaseTypes returns the types of the loaded databases, for example
+// "GeoLite2-Country", for logging purpose
+func GetDatabaseTypes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	var result []string
+	for _, db := range []*maxminddb.Reader{countryDB, asnDB} {
+		if db != nil {
+			result = append(result, db.Metadata.DatabaseType)
+		}
+	}
+	return result
+}
+
+// Info defines the information resolved for an IP address.
+// Empty fields mean unknown
+type Info struct {
+	// ISO 3166-1 alpha-2 country code, for example "IT"
+	Country string
+	// autonomous system number and organization
+	ASN            uint
+	ASOrganization string
+}
+
+// IsEmpty returns true if no information is available
+func (i *Info) IsEmpty() bool {
+	return i.Country == "" && i.ASN == 0 && i.ASOrganization == ""
+}
+
+// String returns a human readable representation, for example
+// "IT, AS3269 Telecom Italia"
+func (i *Info) String() string {
+	var parts []string
+	if i.Country != "" {
+		parts = append(parts, i.Country)
+	}
+	if i.ASN > 0 {
+		asn := fmt.Sprintf("AS%d", i.ASN)
+		if i.ASOrganization != "" {
+			asn += " " + i.ASOrganization
+		}
+		parts = append(parts, asn)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Lookup returns the information available for the given IP address. An empty
+// Info is returned if the address is not valid, it is not found or if no
+// database is configured
+func Lookup(ip string) Info {
+	var info Info
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if countryDB == nil && asnDB == nil {
+		return info
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return info
+	}
+	if countryDB != nil {
+		var record countryRecord
+		if err := countryDB.Lookup(parsed, &record); err == nil {
+			info.Country = record.getCountryCode()
+		}
+	}
+	if asnDB != nil {
+		var record asnRecord
+		if err := asnDB.Lookup(parsed, &record); err == nil {
+			info.ASN = record.AutonomousSystemNumber
+			info.ASOrganization = record.AutonomousSystemOrganization
+		}
+	}
+	return info
+}
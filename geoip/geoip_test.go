@@ -0,0 +1,280 @@
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// MaxMind DB format constants used to write the test databases, see
+// https://maxmind.github.io/MaxMind-DB/
+const (
+	metadataStartMarker      = "\xAB\xCD\xEFMaxMind.com"
+	dataSectionSeparatorSize = 16
+	typeString               = 2
+	typeUint16               = 5
+	typeUint32               = 6
+	typeMap                  = 7
+)
+
+type testNetwork struct {
+	cidr   string
+	record map[string]interface{}
+}
+
+type testEncoder struct {
+	buf bytes.Buffer
+}
+
+func (e *testEncoder) writeCtrl(dataType, size int) {
+	// only the types that don't require an extended control byte are used
+	ctrl := byte(dataType << 5)
+	var sizeBytes []byte
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 285:
+		ctrl |= 29
+		sizeBytes = []byte{byte(size - 29)}
+	case size < 65821:
+		ctrl |= 30
+		sizeBytes = []byte{byte((size - 285) >> 8), byte(size - 285)}
+	default:
+		ctrl |= 31
+		sizeBytes = []byte{byte((size - 65821) >> 16), byte((size - 65821) >> 8), byte(size - 65821)}
+	}
+	e.buf.WriteByte(ctrl)
+	e.buf.Write(sizeBytes)
+}
+
+func (e *testEncoder) writeUint(dataType int, value uint64) {
+	var payload []byte
+	for value > 0 {
+		payload = append([]byte{byte(value)}, payload...)
+		value >>= 8
+	}
+	e.writeCtrl(dataType, len(payload))
+	e.buf.Write(payload)
+}
+
+func (e *testEncoder) encode(value interface{}) {
+	switch v := value.(type) {
+	case string:
+		e.writeCtrl(typeString, len(v))
+		e.buf.WriteString(v)
+	case uint16:
+		e.writeUint(typeUint16, uint64(v))
+	case uint32:
+		e.writeUint(typeUint32, uint64(v))
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		e.writeCtrl(typeMap, len(v))
+		for _, key := range keys {
+			e.encode(key)
+			e.encode(v[key])
+		}
+	default:
+		panic("unsupported test value")
+	}
+}
+
+func getBit(ip net.IP, idx int) int {
+	return int(ip[idx>>3]>>(7-uint(idx%8))) & 1
+}
+
+func buildTestDatabase(t *testing.T, recordSize, ipVersion int, networks []testNetwork) []byte {
+	// node children: 0 means empty, a positive value is a node index and a
+	// negative value is a data section offset as -(offset+1)
+	nodes := [][2]int{{0, 0}}
+	data := &testEncoder{}
+	for _, network := range networks {
+		_, ipNet, err := net.ParseCIDR(network.cidr)
+		require.NoError(t, err)
+		ip := ipNet.IP
+		prefixLen, _ := ipNet.Mask.Size()
+		if ipVersion == 6 && len(ip) == net.IPv4len {
+			ip = append(make(net.IP, 12), ip...)
+			prefixLen += 96
+		}
+		offset := data.buf.Len()
+		data.encode(network.record)
+		node := 0
+		for i := 0; i < prefixLen-1; i++ {
+			bit := getBit(ip, i)
+			child := nodes[node][bit]
+			if child <= 0 {
+				nodes = append(nodes, [2]int{0, 0})
+				child = len(nodes) - 1
+				nodes[node][bit] = child
+			}
+			node = child
+		}
+		nodes[node][getBit(ip, prefixLen-1)] = -(offset + 1)
+	}
+	nodeCount := len(nodes)
+	getRecord := func(value int) uint32 {
+		switch {
+		case value == 0:
+			return uint32(nodeCount)
+		case value > 0:
+			return uint32(value)
+		default:
+			return uint32(nodeCount + dataSectionSeparatorSize - value - 1)
+		}
+	}
+	var tree bytes.Buffer
+	for _, node := range nodes {
+		left := getRecord(node[0])
+		right := getRecord(node[1])
+		switch recordSize {
+		case 24:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte(right >> 16), byte(right >> 8), byte(right)})
+		case 28:
+			tree.Write([]byte{byte(left >> 16), byte(left >> 8), byte(left), byte((left>>24)<<4 | (right >> 24)),
+				byte(right >> 16), byte(right >> 8), byte(right)})
+		default:
+			tree.Write([]byte{byte(left >> 24), byte(left >> 16), byte(left >> 8), byte(left),
+				byte(right >> 24), byte(right >> 16), byte(right >> 8), byte(right)})
+		}
+	}
+	metadata := &testEncoder{}
+	metadata.encode(map[string]interface{}{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"database_type":               "SFTPGo-Test",
+		"ip_version":                  uint16(ipVersion),
+		"node_count":                  uint32(nodeCount),
+		"record_size":                 uint16(recordSize),
+	})
+	var result bytes.Buffer
+	result.Write(tree.Bytes())
+	result.Write(make([]byte, dataSectionSeparatorSize))
+	result.Write(data.buf.Bytes())
+	result.WriteString(metadataStartMarker)
+	result.Write(metadata.buf.Bytes())
+	return result.Bytes()
+}
+
+func writeTestDatabase(t *testing.T, recordSize, ipVersion int, networks []testNetwork) string {
+	name := filepath.Join(t.TempDir(), "test.mmdb")
+	err := os.WriteFile(name, buildTestDatabase(t, recordSize, ipVersion, networks), 0600)
+	require.NoError(t, err)
+	return name
+}
+
+func getTestNetworks() []testNetwork {
+	return []testNetwork{
+		{
+			cidr: "81.2.69.0/24",
+			record: map[string]interface{}{
+				"country":                        map[string]interface{}{"iso_code": "IT"},
+				"registered_country":             map[string]interface{}{"iso_code": "DE"},
+				"autonomous_system_number":       uint32(3269),
+				"autonomous_system_organization": "Telecom Italia",
+			},
+		},
+		{
+			cidr: "2001:db8::/32",
+			record: map[string]interface{}{
+				"registered_country":       map[string]interface{}{"iso_code": "FR"},
+				"autonomous_system_number": uint32(64496),
+			},
+		},
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, recordSize := range []int{24, 28, 32} {
+		dbPath := writeTestDatabase(t, recordSize, 6, getTestNetworks())
+		c := Config{
+			CountryDatabase: dbPath,
+			ASNDatabase:     dbPath,
+		}
+		require.True(t, c.IsEnabled())
+		err := c.Initialize()
+		require.NoError(t, err, "record size %v", recordSize)
+		assert.Equal(t, []string{"SFTPGo-Test", "SFTPGo-Test"}, GetDatabaseTypes())
+
+		info := Lookup("81.2.69.142")
+		assert.Equal(t, "IT", info.Country)
+		assert.Equal(t, uint(3269), info.ASN)
+		assert.Equal(t, "Telecom Italia", info.ASOrganization)
+		assert.Equal(t, "IT, AS3269 Telecom Italia", info.String())
+		info = Lookup("2001:db8:1::5")
+		assert.Equal(t, "FR", info.Country)
+		assert.Equal(t, uint(64496), info.ASN)
+		assert.Empty(t, info.ASOrganization)
+		assert.Equal(t, "FR, AS64496", info.String())
+		for _, ip := range []string{"81.2.70.1", "127.0.0.1", "2001:db9::1", "::1", "invalid"} {
+			info = Lookup(ip)
+			assert.True(t, info.IsEmpty(), ip)
+			assert.Empty(t, info.String())
+		}
+	}
+	// IPv4 only database
+	dbPath := writeTestDatabase(t, 24, 4, getTestNetworks()[:1])
+	c := Config{
+		ASNDatabase: dbPath,
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+	info := Lookup("81.2.69.1")
+	assert.Empty(t, info.Country)
+	assert.Equal(t, uint(3269), info.ASN)
+	info = Lookup("2001:db8:1::5")
+	assert.True(t, info.IsEmpty())
+
+	c = Config{}
+	require.False(t, c.IsEnabled())
+	err = c.Initialize()
+	require.NoError(t, err)
+	assert.Len(t, GetDatabaseTypes(), 0)
+	info = Lookup("81.2.69.1")
+	assert.True(t, info.IsEmpty())
+}
+
+func TestInitializeErrors(t *testing.T) {
+	dbPath := writeTestDatabase(t, 24, 6, getTestNetworks())
+	c := Config{
+		CountryDatabase: dbPath,
+	}
+	err := c.Initialize()
+	require.NoError(t, err)
+
+	c.CountryDatabase = "relative.mmdb"
+	err = c.Initialize()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must be an absolute path")
+	c.CountryDatabase = ""
+	c.ASNDatabase = "relative.mmdb"
+	err = c.Initialize()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "must be an absolute path")
+	c.ASNDatabase = filepath.Join(t.TempDir(), "missing.mmdb")
+	err = c.Initialize()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	invalidPath := filepath.Join(t.TempDir(), "invalid.mmdb")
+	err = os.WriteFile(invalidPath, []byte("invalid"), 0600)
+	require.NoError(t, err)
+	c.ASNDatabase = ""
+	c.CountryDatabase = invalidPath
+	err = c.Initialize()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to load the country database")
+	// the previous database is still used
+	assert.Equal(t, "IT", Lookup("81.2.69.1").Country)
+
+	c = Config{}
+	err = c.Initialize()
+	require.NoError(t, err)
+}
@@ -1,15 +1,21 @@
 package httpclient
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
+	"golang.org/x/net/http/httpproxy"
 
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/utils"
@@ -21,17 +27,91 @@ type TLSKeyPair struct {
 	Key  string `json:"key" mapstructure:"key"`
 }
 
+// Header defines an HTTP header to add to the outgoing requests
+type Header struct {
+	Key   string `json:"key" mapstructure:"key"`
+	Value string `json:"value" mapstructure:"value"`
+	// URL prefix the header is restricted to, for example
+	// "https://auth.example.com/". Empty means all the requests
+	URL string `json:"url" mapstructure:"url"`
+}
+
+// HookType defines the hooks that can override the global HTTP timeout
+type HookType int
+
+// Supported hook types
+const (
+	HookExternalAuth HookType = iota
+	HookPreLogin
+	HookPostLogin
+	HookCheckPassword
+	HookKeyboardInteractive
+	HookActions
+	HookProviderActions
+	HookConnect
+)
+
+// HookTimeouts defines the timeouts, in seconds, for specific hooks.
+// 0 means the global timeout
+type HookTimeouts struct {
+	ExternalAuth        int64 `json:"external_auth" mapstructure:"external_auth"`
+	PreLogin            int64 `json:"pre_login" mapstructure:"pre_login"`
+	PostLogin           int64 `json:"post_login" mapstructure:"post_login"`
+	CheckPassword       int64 `json:"check_password" mapstructure:"check_password"`
+	KeyboardInteractive int64 `json:"keyboard_interactive" mapstructure:"keyboard_interactive"`
+	Actions             int64 `json:"actions" mapstructure:"actions"`
+	ProviderActions     int64 `json:"provider_actions" mapstructure:"provider_actions"`
+	Connect             int64 `json:"connect" mapstructure:"connect"`
+}
+
+func (t *HookTimeouts) validate() error {
+	for _, timeout := range []int64{t.ExternalAuth, t.PreLogin, t.PostLogin, t.CheckPassword, t.KeyboardInteractive,
+		t.Actions, t.ProviderActions, t.Connect} {
+		if timeout < 0 {
+			return fmt.Errorf("invalid hook timeout %v, it cannot be negative", timeout)
+		}
+	}
+	return nil
+}
+
+func (t *HookTimeouts) get(hook HookType) int64 {
+	switch hook {
+	case HookExternalAuth:
+		return t.ExternalAuth
+	case HookPreLogin:
+		return t.PreLogin
+	case HookPostLogin:
+		return t.PostLogin
+	case HookCheckPassword:
+		return t.CheckPassword
+	case HookKeyboardInteractive:
+		return t.KeyboardInteractive
+	case HookActions:
+		return t.Actions
+	case HookProviderActions:
+		return t.ProviderActions
+	case HookConnect:
+		return t.Connect
+	default:
+		return 0
+	}
+}
+
 // Config defines the configuration for HTTP clients.
 // HTTP clients are used for executing hooks such as the ones used for
 // custom actions, external authentication and pre-login user modifications
 type Config struct {
 	// Timeout specifies a time limit, in seconds, for a request
 	Timeout int64 `json:"timeout" mapstructure:"timeout"`
+	// HookTimeouts overrides the timeout for specific hooks, so a slow
+	// integration cannot stall the others, for example the logins
+	HookTimeouts HookTimeouts `json:"hook_timeouts" mapstructure:"hook_timeouts"`
 	// RetryWaitMin defines the minimum waiting time between attempts in seconds
 	RetryWaitMin int `json:"retry_wait_min" mapstructure:"retry_wait_min"`
-	// RetryWaitMax defines the minimum waiting time between attempts in seconds
+	// RetryWaitMax defines the maximum waiting time between attempts in seconds.
+	// The waiting time is doubled for each attempt up to this limit
 	RetryWaitMax int `json:"retry_wait_max" mapstructure:"retry_wait_max"`
-	// RetryMax defines the maximum number of attempts
+	// RetryMax defines the maximum number of retries
 	RetryMax int `json:"retry_max" mapstructure:"retry_max"`
 	// CACertificates defines extra CA certificates to trust.
 	// The paths can be absolute or relative to the config dir.
@@ -44,9 +124,37 @@ type Config struct {
 	// the server and any host name in that certificate.
 	// In this mode, TLS is susceptible to man-in-the-middle attacks.
 	// This should be used only for testing.
-	SkipTLSVerify   bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
-	customTransport *http.Transport
-	tlsConfig       *tls.Config
+	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
+	// ProxyURL defines the proxy to use for the outbound requests, for example
+	// "http://proxy.example.com:3128" or "socks5://127.0.0.1:1080".
+	// Leave empty to use the proxy defined using the HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY environment variables, if any
+	ProxyURL string `json:"proxy_url" mapstructure:"proxy_url"`
+	// NoProxy defines the hosts to connect to directly, bypassing the proxy.
+	// Each entry can be a host name, a domain name suffix, such as ".example.com",
+	// an IP address or a CIDR range, optionally followed by a port
+	NoProxy []string `json:"no_proxy" mapstructure:"no_proxy"`
+	// Headers defines the static headers to add to the outgoing requests,
+	// for example to authenticate SFTPGo against the hook receivers
+	Headers []Header `json:"headers" mapstructure:"headers"`
+	// MaxIdleConns defines the maximum number of idle connections across all
+	// hosts. 0 means 100
+	MaxIdleConns int `json:"max_idle_conns" mapstructure:"max_idle_conns"`
+	// MaxIdleConnsPerHost defines the maximum number of idle connections to
+	// keep for each host. 0 means 2
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host" mapstructure:"max_idle_conns_per_host"`
+	// MaxConnsPerHost limits the number of connections, active and idle, for
+	// each host. 0 means no limit
+	MaxConnsPerHost int `json:"max_conns_per_host" mapstructure:"max_conns_per_host"`
+	// IdleConnTimeout defines the time, in seconds, an idle connection is kept
+	// open before closing it. 0 means 90 seconds
+	IdleConnTimeout int `json:"idle_conn_timeout" mapstructure:"idle_conn_timeout"`
+	// TLSHandshakeTimeout defines the maximum time, in seconds, to wait for a
+	// TLS handshake. 0 means 10 seconds
+	TLSHandshakeTimeout int `json:"tls_handshake_timeout" mapstructure:"tls_handshake_timeout"`
+	customTransport     *http.Transport
+	tlsConfig           *tls.Config
+	proxyFunc           func(*http.Request) (*url.URL, error)
 }
 
 const logSender = "httpclient"
@@ -55,6 +163,18 @@ var httpConfig Config
 
 // Initialize configures HTTP clients
 func (c *Config) Initialize(configDir string) error {
+	if err := c.validateRetries(); err != nil {
+		return err
+	}
+	if err := c.HookTimeouts.validate(); err != nil {
+		return err
+	}
+	if err := c.validateHeaders(); err != nil {
+		return err
+	}
+	if err := c.validatePool(); err != nil {
+		return err
+	}
 	rootCAs, err := c.loadCACerts(configDir)
 	if err != nil {
 		return err
@@ -69,6 +189,11 @@ func (c *Config) Initialize(configDir string) error {
 		}
 	}
 	customTransport.TLSClientConfig.InsecureSkipVerify = c.SkipTLSVerify
+	if err = c.loadProxy(); err != nil {
+		return err
+	}
+	customTransport.Proxy = c.proxyFunc
+	c.configurePool(customTransport)
 	c.customTransport = customTransport
 	c.tlsConfig = customTransport.TLSClientConfig
 
@@ -80,6 +205,98 @@ func (c *Config) Initialize(configDir string) error {
 	return nil
 }
 
+func (c *Config) validateRetries() error {
+	if c.RetryMax < 0 {
+		return fmt.Errorf("invalid retry_max %v, it cannot be negative", c.RetryMax)
+	}
+	if c.RetryWaitMin < 0 || c.RetryWaitMax < 0 {
+		return fmt.Errorf("invalid retry wait times, min: %v max: %v, they cannot be negative", c.RetryWaitMin,
+			c.RetryWaitMax)
+	}
+	if c.RetryWaitMax < c.RetryWaitMin {
+		return fmt.Errorf("invalid retry wait times, max %v is lower than min %v", c.RetryWaitMax, c.RetryWaitMin)
+	}
+	return nil
+}
+
+func (c *Config) validateHeaders() error {
+	for _, header := range c.Headers {
+		if header.Key == "" {
+			return errors.New("invalid HTTP header, the key is mandatory")
+		}
+		if header.URL != "" {
+			if _, err := url.Parse(header.URL); err != nil {
+				return fmt.Errorf("invalid URL %#v for HTTP header %#v: %v", header.URL, header.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) validatePool() error {
+	if c.MaxIdleConns < 0 || c.MaxIdleConnsPerHost < 0 || c.MaxConnsPerHost < 0 {
+		return fmt.Errorf("invalid connection limits, max idle: %v, max idle per host: %v, max per host: %v, "+
+			"they cannot be negative", c.MaxIdleConns, c.MaxIdleConnsPerHost, c.MaxConnsPerHost)
+	}
+	if c.IdleConnTimeout < 0 || c.TLSHandshakeTimeout < 0 {
+		return fmt.Errorf("invalid idle connection timeout %v or TLS handshake timeout %v, they cannot be negative",
+			c.IdleConnTimeout, c.TLSHandshakeTimeout)
+	}
+	return nil
+}
+
+// configurePool applies the connection pool settings to the given transport,
+// the transport defaults are preserved for the unset values
+func (c *Config) configurePool(transport *http.Transport) {
+	if c.MaxIdleConns > 0 {
+		transport.MaxIdleConns = c.MaxIdleConns
+	}
+	if c.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	}
+	if c.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = c.MaxConnsPerHost
+	}
+	if c.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = time.Duration(c.IdleConnTimeout) * time.Second
+	}
+	if c.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = time.Duration(c.TLSHandshakeTimeout) * time.Second
+	}
+}
+
+// loadProxy configures the proxy function for the outbound requests. The
+// proxy settings from the environment are used if no proxy is configured
+func (c *Config) loadProxy() error {
+	if c.ProxyURL == "" {
+		c.proxyFunc = http.ProxyFromEnvironment
+		return nil
+	}
+	proxyURL, err := url.Parse(c.ProxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL %#v: %v", c.ProxyURL, err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("invalid proxy URL %#v, unsupported scheme %#v", proxyURL.Redacted(), proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return fmt.Errorf("invalid proxy URL %#v, the host is missing", proxyURL.Redacted())
+	}
+	proxyConfig := httpproxy.Config{
+		HTTPProxy:  c.ProxyURL,
+		HTTPSProxy: c.ProxyURL,
+		NoProxy:    strings.Join(c.NoProxy, ","),
+	}
+	proxyFunc := proxyConfig.ProxyFunc()
+	c.proxyFunc = func(req *http.Request) (*url.URL, error) {
+		return proxyFunc(req.URL)
+	}
+	logger.Debug(logSender, "", "outbound proxy %#v configured, bypass list: %v", proxyURL.Redacted(), c.NoProxy)
+	return nil
+}
+
 // loadCACerts returns system cert pools and try to add the configured
 // CA certificates to it
 func (c *Config) loadCACerts(configDir string) (*x509.CertPool, error) {
@@ -145,20 +362,143 @@ func (c *Config) loadCertificates(configDir string) error {
 func GetHTTPClient() *http.Client {
 	return &http.Client{
 		Timeout:   time.Duration(httpConfig.Timeout) * time.Second,
-		Transport: httpConfig.customTransport,
+		Transport: addHeaders(httpConfig.customTransport),
 	}
 }
 
-// GetRetraybleHTTPClient returns an HTTP client that retry a request on error.
-// It uses the configured retry parameters
-func GetRetraybleHTTPClient() *retryablehttp.Client {
+// GetRetryableHTTPClient returns an HTTP client that retries a request on error
+// using an exponential backoff. It uses the configured retry parameters and
+// shares the connection pool with the clients returned by GetHTTPClient.
+// Requests using non-idempotent methods, such as POST, are retried only if they
+// were not sent to the server or if the server explicitly asks to retry later
+func GetRetryableHTTPClient() *retryablehttp.Client {
 	client := retryablehttp.NewClient()
-	client.HTTPClient.Timeout = time.Duration(httpConfig.Timeout) * time.Second
-	client.HTTPClient.Transport.(*http.Transport).TLSClientConfig = httpConfig.tlsConfig
+	client.HTTPClient = GetHTTPClient()
 	client.Logger = &logger.LeveledLogger{Sender: "RetryableHTTPClient"}
 	client.RetryWaitMin = time.Duration(httpConfig.RetryWaitMin) * time.Second
 	client.RetryWaitMax = time.Duration(httpConfig.RetryWaitMax) * time.Second
 	client.RetryMax = httpConfig.RetryMax
+	client.Backoff = retryablehttp.DefaultBackoff
+	client.CheckRetry = checkRetry
 
 	return client
 }
+
+// GetRetryableHTTPClientForHook returns a retryable HTTP client for the given
+// hook type. The hook specific timeout is used, if configured
+func GetRetryableHTTPClientForHook(hook HookType) *retryablehttp.Client {
+	client := GetRetryableHTTPClient()
+	if timeout := httpConfig.HookTimeouts.get(hook); timeout > 0 {
+		client.HTTPClient.Timeout = time.Duration(timeout) * time.Second
+	}
+	return client
+}
+
+// addHeaders returns a transport adding the configured headers to the requests
+// sent using the given one
+func addHeaders(base http.RoundTripper) http.RoundTripper {
+	if len(httpConfig.Headers) == 0 {
+		return base
+	}
+	return &headersTransport{
+		base:    base,
+		headers: httpConfig.Headers,
+	}
+}
+
+// headersTransport adds the configured headers to the requests. The headers
+// already set for a request, for example by the custom actions, are preserved
+type headersTransport struct {
+	base    http.RoundTripper
+	headers []Header
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *headersTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var cloned *http.Request
+	for _, header := range t.headers {
+		if header.URL != "" && !strings.HasPrefix(req.URL.String(), header.URL) {
+			continue
+		}
+		if req.Header.Get(header.Key) != "" {
+			continue
+		}
+		// a RoundTripper must not modify the request
+		if cloned == nil {
+			cloned = req.Clone(req.Context())
+		}
+		cloned.Header.Set(header.Key, header.Value)
+	}
+	if cloned == nil {
+		return t.base.RoundTrip(req)
+	}
+	return t.base.RoundTrip(cloned)
+}
+
+// checkRetry wraps the default retry policy and avoids to repeat the requests
+// using non-idempotent methods if the server could have processed them
+func checkRetry(ctx context.Context, resp *http.Response, err error) (bool, error) {
+	retry, checkErr := retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+	if !retry || checkErr != nil {
+		return retry, checkErr
+	}
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && !isIdempotentMethod(urlErr.Op) {
+			return isDialError(err), nil
+		}
+		return true, nil
+	}
+	if resp != nil && resp.Request != nil && !isIdempotentMethod(resp.Request.Method) {
+		return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable, nil
+	}
+	return true, nil
+}
+
+// isIdempotentMethod returns true if the given HTTP method is idempotent as
+// defined in RFC 7231. The method is case insensitive since the url.Error
+// operations are reported as "Get", "Post" and so on
+func isIdempotentMethod(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// isDialError returns true if the connection to the server was never
+// established, so the request was not sent
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return opErr.Op == "dial"
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr)
+}
+
+// GetProxyFunc returns the function that selects the proxy for the outbound
+// requests. It can be used by the clients for the cloud storage backends
+func GetProxyFunc() func(*http.Request) (*url.URL, error) {
+	if httpConfig.proxyFunc == nil {
+		return http.ProxyFromEnvironment
+	}
+	return httpConfig.proxyFunc
+}
+
+// IsProxyConfigured returns true if an outbound proxy is explicitly configured
+func IsProxyConfigured() bool {
+	return httpConfig.ProxyURL != ""
+}
+
+// GetTLSConfig returns a copy of the configured TLS settings. It can be used
+// by the clients for other protocols, for example MQTT, used to execute hooks
+func GetTLSConfig() *tls.Config {
+	if httpConfig.tlsConfig == nil {
+		return &tls.Config{}
+	}
+	tlsConfig := httpConfig.tlsConfig.Clone()
+	tlsConfig.NextProtos = nil
+	return tlsConfig
+}
@@ -0,0 +1,263 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitializeRetries(t *testing.T) {
+	c := Config{
+		RetryMax: -1,
+	}
+	err := c.Initialize("")
+	assert.Error(t, err)
+	c.RetryMax = 3
+	c.RetryWaitMin = -1
+	err = c.Initialize("")
+	assert.Error(t, err)
+	c.RetryWaitMin = 10
+	c.RetryWaitMax = 5
+	err = c.Initialize("")
+	assert.Error(t, err)
+	c.RetryWaitMax = 30
+	err = c.Initialize("")
+	assert.NoError(t, err)
+
+	client := GetRetryableHTTPClient()
+	assert.Equal(t, 3, client.RetryMax)
+}
+
+func TestCheckRetry(t *testing.T) {
+	dialErr := &url.Error{
+		Op:  "Post",
+		URL: "http://127.0.0.1:8080/hook",
+		Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")},
+	}
+	readErr := &url.Error{
+		Op:  "Post",
+		URL: "http://127.0.0.1:8080/hook",
+		Err: &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset by peer")},
+	}
+	retry, err := checkRetry(context.Background(), nil, dialErr)
+	assert.NoError(t, err)
+	assert.True(t, retry)
+	// the POST request could be already processed
+	retry, err = checkRetry(context.Background(), nil, readErr)
+	assert.NoError(t, err)
+	assert.False(t, retry)
+	readErr.Op = "Get"
+	retry, err = checkRetry(context.Background(), nil, readErr)
+	assert.NoError(t, err)
+	assert.True(t, retry)
+
+	postReq, err := http.NewRequest(http.MethodPost, "http://127.0.0.1:8080/hook", nil)
+	assert.NoError(t, err)
+	getReq, err := http.NewRequest(http.MethodGet, "http://127.0.0.1:8080/hook", nil)
+	assert.NoError(t, err)
+	testCases := []struct {
+		req        *http.Request
+		statusCode int
+		retry      bool
+	}{
+		{postReq, http.StatusOK, false},
+		{postReq, http.StatusInternalServerError, false},
+		{postReq, http.StatusServiceUnavailable, true},
+		{postReq, http.StatusTooManyRequests, true},
+		{getReq, http.StatusOK, false},
+		{getReq, http.StatusInternalServerError, true},
+		{getReq, http.StatusBadGateway, true},
+	}
+	for _, tc := range testCases {
+		resp := &http.Response{
+			StatusCode: tc.statusCode,
+			Request:    tc.req,
+		}
+		retry, err = checkRetry(context.Background(), resp, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.retry, retry, "method %v, status code %v", tc.req.Method, tc.statusCode)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	retry, err = checkRetry(ctx, nil, dialErr)
+	assert.Error(t, err)
+	assert.False(t, retry)
+}
+
+func TestIdempotentMethods(t *testing.T) {
+	for _, method := range []string{http.MethodGet, "Get", http.MethodHead, http.MethodPut, http.MethodDelete} {
+		assert.True(t, isIdempotentMethod(method), method)
+	}
+	for _, method := range []string{http.MethodPost, "Post", http.MethodPatch, http.MethodConnect} {
+		assert.False(t, isIdempotentMethod(method), method)
+	}
+}
+
+func TestProxy(t *testing.T) {
+	c := Config{
+		ProxyURL: "ftp://127.0.0.1:21",
+	}
+	err := c.Initialize("")
+	assert.Error(t, err)
+	c.ProxyURL = "http://"
+	err = c.Initialize("")
+	assert.Error(t, err)
+	c.ProxyURL = "http://proxy.example.com:3128"
+	c.NoProxy = []string{".internal.example.com", "10.0.0.0/8"}
+	err = c.Initialize("")
+	assert.NoError(t, err)
+	assert.True(t, IsProxyConfigured())
+
+	proxyFunc := GetProxyFunc()
+	req, err := http.NewRequest(http.MethodPost, "https://hooks.example.com/auth", nil)
+	assert.NoError(t, err)
+	proxyURL, err := proxyFunc(req)
+	assert.NoError(t, err)
+	if assert.NotNil(t, proxyURL) {
+		assert.Equal(t, "proxy.example.com:3128", proxyURL.Host)
+	}
+	for _, u := range []string{"https://auth.internal.example.com/hook", "http://10.1.2.3:8080/hook"} {
+		req, err = http.NewRequest(http.MethodPost, u, nil)
+		assert.NoError(t, err)
+		proxyURL, err = proxyFunc(req)
+		assert.NoError(t, err)
+		assert.Nil(t, proxyURL, u)
+	}
+
+	c = Config{}
+	err = c.Initialize("")
+	assert.NoError(t, err)
+	assert.False(t, IsProxyConfigured())
+}
+
+func TestHookTimeouts(t *testing.T) {
+	c := Config{
+		Timeout: 20,
+		HookTimeouts: HookTimeouts{
+			ExternalAuth: -1,
+		},
+	}
+	err := c.Initialize("")
+	assert.Error(t, err)
+	c.HookTimeouts.ExternalAuth = 10
+	c.HookTimeouts.Actions = 2
+	err = c.Initialize("")
+	assert.NoError(t, err)
+
+	client := GetRetryableHTTPClientForHook(HookExternalAuth)
+	assert.Equal(t, 10*time.Second, client.HTTPClient.Timeout)
+	client = GetRetryableHTTPClientForHook(HookActions)
+	assert.Equal(t, 2*time.Second, client.HTTPClient.Timeout)
+	// the global timeout is used if no hook specific timeout is configured
+	client = GetRetryableHTTPClientForHook(HookPreLogin)
+	assert.Equal(t, 20*time.Second, client.HTTPClient.Timeout)
+	client = GetRetryableHTTPClient()
+	assert.Equal(t, 20*time.Second, client.HTTPClient.Timeout)
+}
+
+func TestHeaders(t *testing.T) {
+	c := Config{
+		Headers: []Header{
+			{
+				Value: "value",
+			},
+		},
+	}
+	err := c.Initialize("")
+	assert.Error(t, err)
+	c.Headers = []Header{
+		{
+			Key:   "Authorization",
+			Value: "Bearer token",
+			URL:   "https://auth.example.com/",
+		},
+		{
+			Key:   "X-Tenant-ID",
+			Value: "tenant1",
+		},
+	}
+	err = c.Initialize("")
+	assert.NoError(t, err)
+
+	var received http.Header
+	transport := addHeaders(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		received = req.Header
+		return &http.Response{StatusCode: http.StatusOK, Request: req}, nil
+	}))
+	req, err := http.NewRequest(http.MethodPost, "https://auth.example.com/check", nil)
+	assert.NoError(t, err)
+	_, err = transport.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer token", received.Get("Authorization"))
+	assert.Equal(t, "tenant1", received.Get("X-Tenant-ID"))
+	// the original request is not modified
+	assert.Empty(t, req.Header.Get("Authorization"))
+
+	req, err = http.NewRequest(http.MethodPost, "https://hooks.example.com/notify", nil)
+	assert.NoError(t, err)
+	req.Header.Set("X-Tenant-ID", "tenant2")
+	_, err = transport.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Empty(t, received.Get("Authorization"))
+	assert.Equal(t, "tenant2", received.Get("X-Tenant-ID"))
+
+	c = Config{}
+	err = c.Initialize("")
+	assert.NoError(t, err)
+	base := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, errors.New("not implemented")
+	})
+	_, ok := addHeaders(base).(*headersTransport)
+	assert.False(t, ok)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestConnectionPool(t *testing.T) {
+	c := Config{
+		MaxIdleConnsPerHost: -1,
+	}
+	err := c.Initialize("")
+	assert.Error(t, err)
+	c.MaxIdleConnsPerHost = 0
+	c.IdleConnTimeout = -1
+	err = c.Initialize("")
+	assert.Error(t, err)
+	c.IdleConnTimeout = 0
+	err = c.Initialize("")
+	assert.NoError(t, err)
+	// the transport defaults are preserved
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+	assert.Equal(t, defaultTransport.MaxIdleConns, httpConfig.customTransport.MaxIdleConns)
+	assert.Equal(t, defaultTransport.IdleConnTimeout, httpConfig.customTransport.IdleConnTimeout)
+	assert.Equal(t, defaultTransport.TLSHandshakeTimeout, httpConfig.customTransport.TLSHandshakeTimeout)
+
+	c = Config{
+		MaxIdleConns:        500,
+		MaxIdleConnsPerHost: 50,
+		MaxConnsPerHost:     100,
+		IdleConnTimeout:     30,
+		TLSHandshakeTimeout: 5,
+	}
+	err = c.Initialize("")
+	assert.NoError(t, err)
+	assert.Equal(t, 500, httpConfig.customTransport.MaxIdleConns)
+	assert.Equal(t, 50, httpConfig.customTransport.MaxIdleConnsPerHost)
+	assert.Equal(t, 100, httpConfig.customTransport.MaxConnsPerHost)
+	assert.Equal(t, 30*time.Second, httpConfig.customTransport.IdleConnTimeout)
+	assert.Equal(t, 5*time.Second, httpConfig.customTransport.TLSHandshakeTimeout)
+	// the retryable clients share the connection pool
+	client := GetRetryableHTTPClient()
+	assert.Equal(t, httpConfig.customTransport, client.HTTPClient.Transport)
+}
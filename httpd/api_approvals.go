@@ -0,0 +1,236 @@
+package httpd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// restoreApprovalPayload defines the data saved inside an approval request
+// to restore a backup after the approval
+type restoreApprovalPayload struct {
+	Content   []byte `json:"content"`
+	InputFile string `json:"input_file,omitempty"`
+	ScanQuota int    `json:"scan_quota"`
+	Mode      int    `json:"mode"`
+}
+
+// getApprovalPermission returns the admin permission required to request and
+// approve the given operation
+func getApprovalPermission(operation string) string {
+	if operation == dataprovider.ApprovalOperationRestoreBackup {
+		return dataprovider.PermAdminManageSystem
+	}
+	return dataprovider.PermAdminDeleteUsers
+}
+
+func canViewApprovals(claims *jwtTokenClaims) bool {
+	return claims.hasPerm(dataprovider.PermAdminDeleteUsers) || claims.hasPerm(dataprovider.PermAdminManageSystem)
+}
+
+func createApprovalRequest(r *http.Request, operation, objectName, payload string) (dataprovider.ApprovalRequest, error) {
+	req := dataprovider.ApprovalRequest{
+		Operation:  operation,
+		ObjectName: objectName,
+		Payload:    payload,
+	}
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		return req, dataprovider.NewValidationError("invalid token claims")
+	}
+	req.RequestedBy = claims.Username
+	err = dataprovider.AddApprovalRequest(&req)
+	logger.AuditApproval(claims.Username, utils.GetIPFromRemoteAddress(r.RemoteAddr), req.ID, operation, objectName,
+		dataprovider.ApprovalStatusPending, err)
+	if err == nil {
+		logger.Info(logSender, "", "approval request %#v added by admin %#v, operation %#v, object name %#v",
+			req.ID, req.RequestedBy, operation, objectName)
+	}
+	return req, err
+}
+
+// sendApprovalRequired saves a new approval request for the given operation
+// instead of executing it and sends a 202 response
+func sendApprovalRequired(w http.ResponseWriter, r *http.Request, operation, objectName, payload string) {
+	req, err := createApprovalRequest(r, operation, objectName, payload)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	w.Header().Add("Location", fmt.Sprintf("%v/%v", approvalsPath, req.ID))
+	sendAPIResponse(w, r, nil, "Approval request created", http.StatusAccepted)
+}
+
+func getRestoreApprovalPayload(content []byte, inputFile string, scanQuota, mode int) (string, error) {
+	payload, err := json.Marshal(restoreApprovalPayload{
+		Content:   content,
+		InputFile: inputFile,
+		ScanQuota: scanQuota,
+		Mode:      mode,
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(payload), nil
+}
+
+func sendRestoreApprovalRequired(w http.ResponseWriter, r *http.Request, content []byte, inputFile string,
+	scanQuota, mode int) {
+	payload, err := getRestoreApprovalPayload(content, inputFile, scanQuota, mode)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	sendApprovalRequired(w, r, dataprovider.ApprovalOperationRestoreBackup, "", payload)
+}
+
+func executeApprovalRequest(req *dataprovider.ApprovalRequest) error {
+	switch req.Operation {
+	case dataprovider.ApprovalOperationDeleteUser:
+		if err := dataprovider.DeleteUser(req.ObjectName); err != nil {
+			return err
+		}
+		disconnectUser(req.ObjectName)
+		return nil
+	case dataprovider.ApprovalOperationDeleteFolder:
+		return dataprovider.DeleteFolder(req.ObjectName)
+	case dataprovider.ApprovalOperationRestoreBackup:
+		var payload restoreApprovalPayload
+		if err := json.Unmarshal([]byte(req.Payload), &payload); err != nil {
+			return fmt.Errorf("unable to decode the backup to restore: %w", err)
+		}
+		return restoreBackup(payload.Content, payload.InputFile, payload.ScanQuota, payload.Mode)
+	default:
+		return fmt.Errorf("unsupported operation %#v", req.Operation)
+	}
+}
+
+func getApprovals(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if !canViewApprovals(&claims) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	limit, offset, _, err := getSearchFilters(w, r)
+	if err != nil {
+		return
+	}
+	requests, err := dataprovider.GetApprovalRequests(limit, offset)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, requests)
+}
+
+func getApprovalByID(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	if !canViewApprovals(&claims) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	req, err := dataprovider.ApprovalRequestExists(getURLParam(r, "id"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	req.Payload = ""
+	render.JSON(w, r, req)
+}
+
+// getApprovalForResolution returns the pending approval request with the id
+// specified in the URL if the logged admin can resolve it. If the request
+// cannot be resolved an error response is sent and false is returned
+func getApprovalForResolution(w http.ResponseWriter, r *http.Request) (dataprovider.ApprovalRequest, string, bool) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return dataprovider.ApprovalRequest{}, "", false
+	}
+	req, err := dataprovider.ApprovalRequestExists(getURLParam(r, "id"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return req, "", false
+	}
+	if !claims.hasPerm(getApprovalPermission(req.Operation)) {
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return req, "", false
+	}
+	if !req.IsPending() {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("The approval request is already %v", req.Status),
+			http.StatusConflict)
+		return req, "", false
+	}
+	return req, claims.Username, true
+}
+
+func approveRequest(w http.ResponseWriter, r *http.Request) {
+	req, admin, ok := getApprovalForResolution(w, r)
+	if !ok {
+		return
+	}
+	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	if req.RequestedBy == admin {
+		logger.AuditApproval(admin, ipAddr, req.ID, req.Operation, req.ObjectName, dataprovider.ApprovalStatusApproved,
+			errors.New("self approval is not allowed"))
+		sendAPIResponse(w, r, nil, "You cannot approve your own requests", http.StatusForbidden)
+		return
+	}
+	req.Status = dataprovider.ApprovalStatusApproved
+	req.ResolvedBy = admin
+	// the pending status is checked while updating so only one admin can
+	// approve a request
+	if err := dataprovider.UpdateApprovalRequestStatus(&req, dataprovider.ApprovalStatusPending); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	logger.AuditApproval(admin, ipAddr, req.ID, req.Operation, req.ObjectName, dataprovider.ApprovalStatusApproved, nil)
+
+	execErr := executeApprovalRequest(&req)
+	req.Status = dataprovider.ApprovalStatusExecuted
+	if execErr != nil {
+		req.Status = dataprovider.ApprovalStatusFailed
+		req.Error = execErr.Error()
+	}
+	logger.AuditApproval(admin, ipAddr, req.ID, req.Operation, req.ObjectName, req.Status, execErr)
+	if err := dataprovider.UpdateApprovalRequestStatus(&req, dataprovider.ApprovalStatusApproved); err != nil {
+		logger.Warn(logSender, "", "unable to save the final status for approval request %#v: %v", req.ID, err)
+	}
+	if execErr != nil {
+		sendAPIResponse(w, r, execErr, "The request was approved but the operation failed", getRespStatus(execErr))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Request approved and executed", http.StatusOK)
+}
+
+func rejectRequest(w http.ResponseWriter, r *http.Request) {
+	req, admin, ok := getApprovalForResolution(w, r)
+	if !ok {
+		return
+	}
+	req.Status = dataprovider.ApprovalStatusRejected
+	req.ResolvedBy = admin
+	err := dataprovider.UpdateApprovalRequestStatus(&req, dataprovider.ApprovalStatusPending)
+	logger.AuditApproval(admin, utils.GetIPFromRemoteAddress(r.RemoteAddr), req.ID, req.Operation, req.ObjectName,
+		dataprovider.ApprovalStatusRejected, err)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Request rejected", http.StatusOK)
+}
@@ -0,0 +1,70 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/render"
+)
+
+// ConfigReloader defines the function used to reload the configuration.
+// It returns the changed settings that require a restart to be applied
+type ConfigReloader func() ([]string, error)
+
+// ConfigDumper defines the function used to get the effective configuration
+// with the sensitive values redacted
+type ConfigDumper func() (map[string]interface{}, error)
+
+var (
+	configReloader ConfigReloader
+	configDumper   ConfigDumper
+)
+
+type configReloadResponse struct {
+	Message         string   `json:"message"`
+	RestartRequired []string `json:"restart_required"`
+}
+
+// SetConfigReloader sets the function used to reload the configuration using the REST API.
+// A nil reloader disables the configuration reload
+func SetConfigReloader(reloader ConfigReloader) {
+	configReloader = reloader
+}
+
+// SetConfigDumper sets the function used to get the effective configuration
+// using the REST API. A nil dumper disables the configuration dump
+func SetConfigDumper(dumper ConfigDumper) {
+	configDumper = dumper
+}
+
+func dumpConfig(w http.ResponseWriter, r *http.Request) {
+	if configDumper == nil {
+		sendAPIResponse(w, r, errors.New("configuration dump is not supported"), "", http.StatusBadRequest)
+		return
+	}
+	conf, err := configDumper()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	render.JSON(w, r, conf)
+}
+
+func reloadConfig(w http.ResponseWriter, r *http.Request) {
+	if configReloader == nil {
+		sendAPIResponse(w, r, errors.New("configuration reload is not supported"), "", http.StatusBadRequest)
+		return
+	}
+	changed, err := configReloader()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	if changed == nil {
+		changed = []string{}
+	}
+	render.JSON(w, r, configReloadResponse{
+		Message:         "Configuration reloaded",
+		RestartRequired: changed,
+	})
+}
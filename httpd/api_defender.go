@@ -5,11 +5,14 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/go-chi/render"
 
 	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
 )
 
 func getBanTime(w http.ResponseWriter, r *http.Request) {
@@ -71,6 +74,71 @@ func unban(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func getDefenderListEntries(w http.ResponseWriter, r *http.Request) {
+	entries, err := dataprovider.GetDefenderListEntries()
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, entries)
+}
+
+func addDefenderListEntry(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+
+	var entry dataprovider.DefenderListEntry
+	err := render.DecodeJSON(r.Body, &entry)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	err = dataprovider.AddDefenderListEntry(&entry)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	reloadDefenderLists()
+
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, entry)
+}
+
+func deleteDefenderListEntry(w http.ResponseWriter, r *http.Request) {
+	listType, err := strconv.Atoi(r.URL.Query().Get("type"))
+	if err != nil {
+		sendAPIResponse(w, r, fmt.Errorf("invalid list type: %w", err), "", http.StatusBadRequest)
+		return
+	}
+	entry := dataprovider.DefenderListEntry{
+		Type:    listType,
+		Network: r.URL.Query().Get("network"),
+	}
+	err = dataprovider.DeleteDefenderListEntry(&entry)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	reloadDefenderLists()
+
+	sendAPIResponse(w, r, nil, "Entry deleted", http.StatusOK)
+}
+
+func reloadDefender(w http.ResponseWriter, r *http.Request) {
+	if err := common.ReloadDefender(); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+		return
+	}
+	sendAPIResponse(w, r, nil, "Defender lists reloaded", http.StatusOK)
+}
+
+// reloadDefenderLists applies the safe and block list changes to the local defender,
+// other instances sharing the same data provider will apply them on their next reload
+func reloadDefenderLists() {
+	if err := common.ReloadDefender(); err != nil {
+		logger.Warn(logSender, "", "unable to reload defender's lists: %v", err)
+	}
+}
+
 func validateIPAddress(ip string) error {
 	if ip == "" {
 		return errors.New("ip address is required")
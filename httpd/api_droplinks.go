@@ -0,0 +1,205 @@
+package httpd
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// getDropLinksUser returns the user authenticated using an user API token if
+// allowed to manage drop links. If the user is not allowed an error response
+// is sent and false is returned
+func getDropLinksUser(w http.ResponseWriter, r *http.Request) (dataprovider.User, bool) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return dataprovider.User{}, false
+	}
+	user, err := dataprovider.UserExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return user, false
+	}
+	if user.GetSignature() != claims.Signature {
+		sendAPIResponse(w, r, nil, "Your token is no longer valid", http.StatusUnauthorized)
+		return user, false
+	}
+	if !user.CanManageDropLinks() {
+		sendAPIResponse(w, r, nil, "You are not allowed to manage drop links", http.StatusForbidden)
+		return user, false
+	}
+	return user, true
+}
+
+func getUserDropLinks(w http.ResponseWriter, r *http.Request) {
+	user, ok := getDropLinksUser(w, r)
+	if !ok {
+		return
+	}
+	links, err := dataprovider.GetDropLinks(user.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, links)
+}
+
+func getUserDropLinkByID(w http.ResponseWriter, r *http.Request) {
+	user, ok := getDropLinksUser(w, r)
+	if !ok {
+		return
+	}
+	link, err := dataprovider.DropLinkExists(getURLParam(r, "id"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if link.Username != user.Username {
+		sendAPIResponse(w, r, nil, "", http.StatusNotFound)
+		return
+	}
+	render.JSON(w, r, link)
+}
+
+func addUserDropLink(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	user, ok := getDropLinksUser(w, r)
+	if !ok {
+		return
+	}
+	var link dataprovider.DropLink
+	err := render.DecodeJSON(r.Body, &link)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if err := dataprovider.AddDropLink(&link, &user); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	logger.Info(logSender, "", "drop link %#v added for user %#v, path %#v", link.LinkID, user.Username, link.Path)
+	w.Header().Add("Location", fmt.Sprintf("%v/%v", userDropLinksPath, link.LinkID))
+	render.Status(r, http.StatusCreated)
+	render.JSON(w, r, link)
+}
+
+func deleteUserDropLink(w http.ResponseWriter, r *http.Request) {
+	user, ok := getDropLinksUser(w, r)
+	if !ok {
+		return
+	}
+	linkID := getURLParam(r, "id")
+	if err := dataprovider.DeleteDropLink(linkID, user.Username); err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	logger.Info(logSender, "", "drop link %#v deleted for user %#v", linkID, user.Username)
+	sendAPIResponse(w, r, nil, "Drop link deleted", http.StatusOK)
+}
+
+// uploadToDropLink stores the request body in a new file inside the drop link
+// directory. The upload is anonymous and it is attributed to the link owner
+func uploadToDropLink(w http.ResponseWriter, r *http.Request) {
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	name := r.URL.Query().Get("name")
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+		sendAPIResponse(w, r, nil, "Please set a valid file name using the \"name\" parameter", http.StatusBadRequest)
+		return
+	}
+	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	if common.IsBanned(ipAddr) {
+		sendAPIResponse(w, r, nil, "your IP address is banned", http.StatusForbidden)
+		return
+	}
+	if !common.Connections.IsNewConnectionAllowed() {
+		logger.Log(logger.LevelDebug, common.ProtocolHTTP, "", "connection refused, configured limit reached")
+		sendAPIResponse(w, r, nil, "configured connections limit reached", http.StatusServiceUnavailable)
+		return
+	}
+	link, err := dataprovider.DropLinkExists(getURLParam(r, "id"))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if link.IsExpired() {
+		sendAPIResponse(w, r, nil, "This link is expired", http.StatusNotFound)
+		return
+	}
+	if link.HasFilesLimitReached() {
+		sendAPIResponse(w, r, nil, "The maximum number of files for this link has been reached", http.StatusForbidden)
+		return
+	}
+	remainingSize := link.GetRemainingSize()
+	if remainingSize == 0 || (remainingSize > 0 && r.ContentLength > remainingSize) {
+		sendAPIResponse(w, r, nil, "The maximum size for this link has been exceeded", http.StatusRequestEntityTooLarge)
+		return
+	}
+	user, err := link.GetOwner()
+	if err == nil && utils.IsStringInSlice(common.ProtocolHTTP, user.Filters.DeniedProtocols) {
+		err = fmt.Errorf("protocol HTTP is not allowed for user %#v", user.Username)
+	}
+	if err != nil {
+		logger.Debug(logSender, "", "upload refused for drop link %#v: %v", link.LinkID, err)
+		sendAPIResponse(w, r, nil, "This link cannot be used", http.StatusForbidden)
+		return
+	}
+
+	connID := xid.New().String()
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, common.ProtocolHTTP, user),
+		request:        r,
+	}
+	connection.SetRemoteAddress(r.RemoteAddr)
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	filePath := path.Join(link.Path, name)
+	// anonymous visitors cannot overwrite existing files
+	if _, err := connection.Stat(filePath, 0); err == nil {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("The file %#v already exists", name), http.StatusConflict)
+		return
+	}
+	file, err := connection.getFileWriter(filePath)
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to write file %#v", name), getMappedStatusCode(err))
+		return
+	}
+	var reader io.Reader = r.Body
+	if remainingSize > 0 {
+		// read an extra byte to detect if the limit is exceeded
+		reader = io.LimitReader(r.Body, remainingSize+1)
+	}
+	written, err := io.Copy(file, reader)
+	if err == nil && remainingSize > 0 && written > remainingSize {
+		// the partial file is removed as for the quota exceeded errors
+		err = common.ErrQuotaExceeded
+	}
+	if err != nil {
+		file.TransferError(err)
+	}
+	errClose := file.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to write file %#v", name), getMappedStatusCode(err))
+		return
+	}
+	if err := dataprovider.UpdateDropLinkUsage(link.LinkID, 1, written); err != nil {
+		connection.Log(logger.LevelWarn, "unable to update usage for drop link %#v: %v", link.LinkID, err)
+	}
+	connection.Log(logger.LevelInfo, "file %#v uploaded using drop link %#v, size: %v", filePath, link.LinkID, written)
+	sendAPIResponse(w, r, nil, "Upload completed", http.StatusCreated)
+}
@@ -53,17 +53,22 @@ func updateFolder(w http.ResponseWriter, r *http.Request) {
 	users := folder.Users
 	folderID := folder.ID
 	currentS3AccessSecret := folder.FsConfig.S3Config.AccessSecret
+	currentS3SSECustomerKey := folder.FsConfig.S3Config.SSECustomerKey
 	currentAzAccountKey := folder.FsConfig.AzBlobConfig.AccountKey
 	currentGCSCredentials := folder.FsConfig.GCSConfig.Credentials
 	currentCryptoPassphrase := folder.FsConfig.CryptConfig.Passphrase
 	currentSFTPPassword := folder.FsConfig.SFTPConfig.Password
 	currentSFTPKey := folder.FsConfig.SFTPConfig.PrivateKey
+	currentPluginOptions := folder.FsConfig.PluginConfig.Options
+	currentSMBPassword := folder.FsConfig.SMBConfig.Password
 
 	folder.FsConfig.S3Config = vfs.S3FsConfig{}
 	folder.FsConfig.AzBlobConfig = vfs.AzBlobFsConfig{}
 	folder.FsConfig.GCSConfig = vfs.GCSFsConfig{}
 	folder.FsConfig.CryptConfig = vfs.CryptFsConfig{}
 	folder.FsConfig.SFTPConfig = vfs.SFTPFsConfig{}
+	folder.FsConfig.PluginConfig = vfs.PluginFsConfig{}
+	folder.FsConfig.SMBConfig = vfs.SMBFsConfig{}
 	err = render.DecodeJSON(r.Body, &folder)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
@@ -72,8 +77,9 @@ func updateFolder(w http.ResponseWriter, r *http.Request) {
 	folder.ID = folderID
 	folder.Name = name
 	folder.FsConfig.SetEmptySecretsIfNil()
-	updateEncryptedSecrets(&folder.FsConfig, currentS3AccessSecret, currentAzAccountKey, currentGCSCredentials,
-		currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey)
+	updateEncryptedSecrets(&folder.FsConfig, currentS3AccessSecret, currentS3SSECustomerKey, currentAzAccountKey,
+		currentGCSCredentials, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentPluginOptions,
+		currentSMBPassword)
 	err = dataprovider.UpdateFolder(&folder, users)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -104,6 +110,14 @@ func getFolderByName(w http.ResponseWriter, r *http.Request) {
 
 func deleteFolder(w http.ResponseWriter, r *http.Request) {
 	name := getURLParam(r, "name")
+	if requireApproval {
+		if _, err := dataprovider.GetFolderByName(name); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		sendApprovalRequired(w, r, dataprovider.ApprovalOperationDeleteFolder, name, "")
+		return
+	}
 	err := dataprovider.DeleteFolder(name)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
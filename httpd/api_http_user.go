@@ -0,0 +1,318 @@
+package httpd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// userDirEntry defines a directory entry returned by the user API
+type userDirEntry struct {
+	Name string `json:"name"`
+	// file, dir or symlink
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	// unix permissions
+	Mode uint32 `json:"mode"`
+	// last modification time as unix timestamp in milliseconds
+	LastModified int64 `json:"last_modified"`
+}
+
+func newUserDirEntry(info os.FileInfo) userDirEntry {
+	entryType := "file"
+	if info.IsDir() {
+		entryType = "dir"
+	} else if info.Mode()&os.ModeSymlink != 0 {
+		entryType = "symlink"
+	}
+	return userDirEntry{
+		Name:         info.Name(),
+		Type:         entryType,
+		Size:         info.Size(),
+		Mode:         uint32(info.Mode().Perm()),
+		LastModified: utils.GetTimeAsMsSinceEpoch(info.ModTime()),
+	}
+}
+
+// getMappedStatusCode returns the HTTP status code for the given connection error
+func getMappedStatusCode(err error) int {
+	switch {
+	case errors.Is(err, os.ErrPermission), errors.Is(err, common.ErrPermissionDenied):
+		return http.StatusForbidden
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, common.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, common.ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, common.ErrOpUnsupported):
+		return http.StatusBadRequest
+	case errors.Is(err, common.ErrRateLimitExceeded), errors.Is(err, common.ErrTooManyTransfers):
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// getUserConnection returns a new connection for the user authenticated using an
+// user API token. If the connection is not allowed an error response is sent and nil is returned
+func getUserConnection(w http.ResponseWriter, r *http.Request) *Connection {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return nil
+	}
+	if !common.Connections.IsNewConnectionAllowed() {
+		logger.Log(logger.LevelDebug, common.ProtocolHTTP, "", "connection refused, configured limit reached")
+		sendAPIResponse(w, r, nil, "configured connections limit reached", http.StatusServiceUnavailable)
+		return nil
+	}
+	if err := common.CheckLoad(); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolHTTP, "", "connection refused: %v", err)
+		w.Header().Set("Retry-After", "30")
+		sendAPIResponse(w, r, err, "", http.StatusServiceUnavailable)
+		return nil
+	}
+	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	if common.IsBanned(ipAddr) {
+		sendAPIResponse(w, r, nil, "your IP address is banned", http.StatusForbidden)
+		return nil
+	}
+
+	user, err := dataprovider.UserExists(claims.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return nil
+	}
+	if user.GetSignature() != claims.Signature {
+		sendAPIResponse(w, r, nil, "Your token is no longer valid", http.StatusUnauthorized)
+		return nil
+	}
+
+	connID := xid.New().String()
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolHTTP, connID)
+	if err := checkWebClientUser(&user, r, connectionID); err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return nil
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, common.ProtocolHTTP, user),
+		request:        r,
+	}
+	connection.SetRemoteAddress(r.RemoteAddr)
+	return connection
+}
+
+// getRequiredPathParam returns the cleaned value for the query parameter with the
+// given name. If the parameter is missing an error response is sent
+func getRequiredPathParam(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Please set the %#v parameter", name), http.StatusBadRequest)
+		return "", false
+	}
+	return utils.CleanPath(value), true
+}
+
+func getUserDirContents(w http.ResponseWriter, r *http.Request) {
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getUserConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	name := "/"
+	if _, ok := r.URL.Query()["path"]; ok {
+		name = utils.CleanPath(r.URL.Query().Get("path"))
+	}
+	contents, err := connection.ReadDir(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, "Unable to get directory contents", getMappedStatusCode(err))
+		return
+	}
+	results := make([]userDirEntry, 0, len(contents))
+	for _, info := range contents {
+		results = append(results, newUserDirEntry(info))
+	}
+	render.JSON(w, r, results)
+}
+
+func createUserDir(w http.ResponseWriter, r *http.Request) {
+	name, ok := getRequiredPathParam(w, r, "path")
+	if !ok {
+		return
+	}
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getUserConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	if err := connection.CreateDir(name); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to create directory %#v", name), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Directory created", http.StatusCreated)
+}
+
+func deleteUserDir(w http.ResponseWriter, r *http.Request) {
+	name, ok := getRequiredPathParam(w, r, "path")
+	if !ok {
+		return
+	}
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getUserConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	if err := connection.RemoveDir(name); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to delete directory %#v", name), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Directory deleted", http.StatusOK)
+}
+
+// renameUserItem renames, or moves, a file or a directory
+func renameUserItem(w http.ResponseWriter, r *http.Request) {
+	name, ok := getRequiredPathParam(w, r, "path")
+	if !ok {
+		return
+	}
+	target, ok := getRequiredPathParam(w, r, "target")
+	if !ok {
+		return
+	}
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getUserConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	if err := connection.Rename(name, target); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to rename %#v to %#v", name, target), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Renamed", http.StatusOK)
+}
+
+func getUserFile(w http.ResponseWriter, r *http.Request) {
+	name, ok := getRequiredPathParam(w, r, "path")
+	if !ok {
+		return
+	}
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getUserConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	var info os.FileInfo
+	var err error
+	if name == "/" {
+		info = vfs.NewFileInfo(name, true, 0, time.Now(), false)
+	} else {
+		info, err = connection.Stat(name, 0)
+	}
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to stat file %#v", name), getMappedStatusCode(err))
+		return
+	}
+	if info.IsDir() {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Please set the path to a valid file, %#v is a directory", name),
+			http.StatusBadRequest)
+		return
+	}
+	if status, err := downloadFile(w, r, connection, name, info); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to read file %#v", name), status)
+	}
+}
+
+// uploadUserFile stores the request body in the file at the given path,
+// an existing file is overwritten
+func uploadUserFile(w http.ResponseWriter, r *http.Request) {
+	name, ok := getRequiredPathParam(w, r, "path")
+	if !ok {
+		return
+	}
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getUserConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	file, err := connection.getFileWriter(name)
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to write file %#v", name), getMappedStatusCode(err))
+		return
+	}
+	_, err = io.Copy(file, r.Body)
+	if err != nil {
+		file.TransferError(err)
+	}
+	errClose := file.Close()
+	if err == nil {
+		err = errClose
+	}
+	if err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to write file %#v", name), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Upload completed", http.StatusCreated)
+}
+
+func deleteUserFile(w http.ResponseWriter, r *http.Request) {
+	name, ok := getRequiredPathParam(w, r, "path")
+	if !ok {
+		return
+	}
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getUserConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	if err := connection.Remove(name); err != nil {
+		sendAPIResponse(w, r, err, fmt.Sprintf("Unable to delete file %#v", name), getMappedStatusCode(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "File deleted", http.StatusOK)
+}
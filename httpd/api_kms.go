@@ -0,0 +1,35 @@
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/kms"
+)
+
+type reencryptSecretsRequest struct {
+	// KMS configuration used to encrypt the stored secrets
+	Previous kms.Secrets `json:"previous"`
+	DryRun   bool        `json:"dry_run"`
+}
+
+func reencryptSecrets(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	var req reencryptSecretsRequest
+	err := render.DecodeJSON(r.Body, &req)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	previous := kms.Configuration{
+		Secrets: req.Previous,
+	}
+	result, err := dataprovider.ReencryptSecrets(&previous, req.DryRun, nil)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	render.JSON(w, r, result)
+}
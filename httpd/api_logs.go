@@ -0,0 +1,30 @@
+package httpd
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+func getLogLevels(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, logger.GetLogLevels())
+}
+
+func updateLogLevels(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	var levels logger.LogLevels
+	err := render.DecodeJSON(r.Body, &levels)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	if err = logger.SetLogLevels(levels); err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	levels = logger.GetLogLevels()
+	logger.Info(logSender, "", "log levels updated, default: %#v, overrides: %v", levels.Default, levels.Overrides)
+	sendAPIResponse(w, r, nil, "Log levels updated", http.StatusOK)
+}
@@ -109,6 +109,10 @@ func loadDataFromRequest(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	if requireApproval {
+		sendRestoreApprovalRequired(w, r, content, "", scanQuota, mode)
+		return
+	}
 	if err := restoreBackup(content, "", scanQuota, mode); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 	}
@@ -141,6 +145,10 @@ func loadData(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 		return
 	}
+	if requireApproval {
+		sendRestoreApprovalRequired(w, r, content, inputFile, scanQuota, mode)
+		return
+	}
 	if err := restoreBackup(content, inputFile, scanQuota, mode); err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
 	}
@@ -207,6 +215,8 @@ func RestoreFolders(folders []vfs.BaseVirtualFolder, inputFile string, mode, sca
 				continue
 			}
 			folder.ID = f.ID
+			// the restored folder replaces the existing one
+			folder.Version = f.Version
 			err = dataprovider.UpdateFolder(&folder, f.Users)
 			logger.Debug(logSender, "", "restoring existing folder: %+v, dump file: %#v, error: %v", folder, inputFile, err)
 		} else {
@@ -238,6 +248,7 @@ func RestoreAdmins(admins []dataprovider.Admin, inputFile string, mode int) erro
 				continue
 			}
 			admin.ID = a.ID
+			admin.Version = a.Version
 			err = dataprovider.UpdateAdmin(&admin)
 			admin.Password = redactedSecret
 			logger.Debug(logSender, "", "restoring existing admin: %+v, dump file: %#v, error: %v", admin, inputFile, err)
@@ -265,6 +276,7 @@ func RestoreUsers(users []dataprovider.User, inputFile string, mode, scanQuota i
 				continue
 			}
 			user.ID = u.ID
+			user.Version = u.Version
 			err = dataprovider.UpdateUser(&user)
 			user.Password = redactedSecret
 			logger.Debug(logSender, "", "restoring existing user: %+v, dump file: %#v, error: %v", user, inputFile, err)
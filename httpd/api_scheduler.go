@@ -0,0 +1,29 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/render"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/scheduler"
+)
+
+func getScheduledJobs(w http.ResponseWriter, r *http.Request) {
+	render.JSON(w, r, common.GetScheduledJobs())
+}
+
+func runScheduledJob(w http.ResponseWriter, r *http.Request) {
+	err := common.RunScheduledJob(getURLParam(r, "name"))
+	switch {
+	case err == nil:
+		sendAPIResponse(w, r, nil, "Job started", http.StatusAccepted)
+	case errors.Is(err, scheduler.ErrJobNotFound):
+		sendAPIResponse(w, r, err, "", http.StatusNotFound)
+	case errors.Is(err, scheduler.ErrJobRunning):
+		sendAPIResponse(w, r, err, "", http.StatusConflict)
+	default:
+		sendAPIResponse(w, r, err, "", http.StatusInternalServerError)
+	}
+}
@@ -64,6 +64,10 @@ func addUser(w http.ResponseWriter, r *http.Request) {
 			sendAPIResponse(w, r, errors.New("invalid access_secret"), "", http.StatusBadRequest)
 			return
 		}
+		if user.FsConfig.S3Config.SSECustomerKey.IsRedacted() {
+			sendAPIResponse(w, r, errors.New("invalid sse_customer_key"), "", http.StatusBadRequest)
+			return
+		}
 	case vfs.GCSFilesystemProvider:
 		if user.FsConfig.GCSConfig.Credentials.IsRedacted() {
 			sendAPIResponse(w, r, errors.New("invalid credentials"), "", http.StatusBadRequest)
@@ -88,6 +92,16 @@ func addUser(w http.ResponseWriter, r *http.Request) {
 			sendAPIResponse(w, r, errors.New("invalid SFTP private key"), "", http.StatusBadRequest)
 			return
 		}
+	case vfs.PluginFilesystemProvider:
+		if user.FsConfig.PluginConfig.Options.IsRedacted() {
+			sendAPIResponse(w, r, errors.New("invalid plugin options"), "", http.StatusBadRequest)
+			return
+		}
+	case vfs.SMBFilesystemProvider:
+		if user.FsConfig.SMBConfig.Password.IsRedacted() {
+			sendAPIResponse(w, r, errors.New("invalid SMB password"), "", http.StatusBadRequest)
+			return
+		}
 	}
 	err = dataprovider.AddUser(&user)
 	if err != nil {
@@ -119,11 +133,14 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	userID := user.ID
 	currentPermissions := user.Permissions
 	currentS3AccessSecret := user.FsConfig.S3Config.AccessSecret
+	currentS3SSECustomerKey := user.FsConfig.S3Config.SSECustomerKey
 	currentAzAccountKey := user.FsConfig.AzBlobConfig.AccountKey
 	currentGCSCredentials := user.FsConfig.GCSConfig.Credentials
 	currentCryptoPassphrase := user.FsConfig.CryptConfig.Passphrase
 	currentSFTPPassword := user.FsConfig.SFTPConfig.Password
 	currentSFTPKey := user.FsConfig.SFTPConfig.PrivateKey
+	currentPluginOptions := user.FsConfig.PluginConfig.Options
+	currentSMBPassword := user.FsConfig.SMBConfig.Password
 
 	user.Permissions = make(map[string][]string)
 	user.FsConfig.S3Config = vfs.S3FsConfig{}
@@ -131,6 +148,8 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	user.FsConfig.GCSConfig = vfs.GCSFsConfig{}
 	user.FsConfig.CryptConfig = vfs.CryptFsConfig{}
 	user.FsConfig.SFTPConfig = vfs.SFTPFsConfig{}
+	user.FsConfig.PluginConfig = vfs.PluginFsConfig{}
+	user.FsConfig.SMBConfig = vfs.SMBFsConfig{}
 	user.VirtualFolders = nil
 	err = render.DecodeJSON(r.Body, &user)
 	if err != nil {
@@ -144,8 +163,8 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 	if len(user.Permissions) == 0 {
 		user.Permissions = currentPermissions
 	}
-	updateEncryptedSecrets(&user.FsConfig, currentS3AccessSecret, currentAzAccountKey, currentGCSCredentials, currentCryptoPassphrase,
-		currentSFTPPassword, currentSFTPKey)
+	updateEncryptedSecrets(&user.FsConfig, currentS3AccessSecret, currentS3SSECustomerKey, currentAzAccountKey, currentGCSCredentials,
+		currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentPluginOptions, currentSMBPassword)
 	err = dataprovider.UpdateUser(&user)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -159,6 +178,14 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 
 func deleteUser(w http.ResponseWriter, r *http.Request) {
 	username := getURLParam(r, "username")
+	if requireApproval {
+		if _, err := dataprovider.UserExists(username); err != nil {
+			sendAPIResponse(w, r, err, "", getRespStatus(err))
+			return
+		}
+		sendApprovalRequired(w, r, dataprovider.ApprovalOperationDeleteUser, username, "")
+		return
+	}
 	err := dataprovider.DeleteUser(username)
 	if err != nil {
 		sendAPIResponse(w, r, err, "", getRespStatus(err))
@@ -176,14 +203,18 @@ func disconnectUser(username string) {
 	}
 }
 
-func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentS3AccessSecret, currentAzAccountKey,
-	currentGCSCredentials, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey *kms.Secret) {
+func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentS3AccessSecret, currentS3SSECustomerKey, currentAzAccountKey,
+	currentGCSCredentials, currentCryptoPassphrase, currentSFTPPassword, currentSFTPKey, currentPluginOptions,
+	currentSMBPassword *kms.Secret) {
 	// we use the new access secret if plain or empty, otherwise the old value
 	switch fsConfig.Provider {
 	case vfs.S3FilesystemProvider:
 		if fsConfig.S3Config.AccessSecret.IsNotPlainAndNotEmpty() {
 			fsConfig.S3Config.AccessSecret = currentS3AccessSecret
 		}
+		if fsConfig.S3Config.SSECustomerKey.IsNotPlainAndNotEmpty() {
+			fsConfig.S3Config.SSECustomerKey = currentS3SSECustomerKey
+		}
 	case vfs.AzureBlobFilesystemProvider:
 		if fsConfig.AzBlobConfig.AccountKey.IsNotPlainAndNotEmpty() {
 			fsConfig.AzBlobConfig.AccountKey = currentAzAccountKey
@@ -203,5 +234,13 @@ func updateEncryptedSecrets(fsConfig *vfs.Filesystem, currentS3AccessSecret, cur
 		if fsConfig.SFTPConfig.PrivateKey.IsNotPlainAndNotEmpty() {
 			fsConfig.SFTPConfig.PrivateKey = currentSFTPKey
 		}
+	case vfs.PluginFilesystemProvider:
+		if fsConfig.PluginConfig.Options.IsNotPlainAndNotEmpty() {
+			fsConfig.PluginConfig.Options = currentPluginOptions
+		}
+	case vfs.SMBFilesystemProvider:
+		if fsConfig.SMBConfig.Password.IsNotPlainAndNotEmpty() {
+			fsConfig.SMBConfig.Password = currentSMBPassword
+		}
 	}
 }
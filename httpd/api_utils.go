@@ -36,6 +36,9 @@ func getRespStatus(err error) int {
 	if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
 		return http.StatusNotFound
 	}
+	if errors.Is(err, dataprovider.ErrVersionMismatch) {
+		return http.StatusConflict
+	}
 	if os.IsNotExist(err) {
 		return http.StatusBadRequest
 	}
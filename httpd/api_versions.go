@@ -0,0 +1,75 @@
+package httpd
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/go-chi/render"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+func getUserFileVersions(w http.ResponseWriter, r *http.Request) {
+	connection, err := getFileVersionsConnection(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	defer connection.CloseFS() //nolint:errcheck
+
+	versions, err := connection.ListFileVersions(utils.CleanPath(r.URL.Query().Get("path")))
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getFileVersionsRespStatus(err))
+		return
+	}
+	render.JSON(w, r, versions)
+}
+
+func restoreUserFileVersion(w http.ResponseWriter, r *http.Request) {
+	versionID := r.URL.Query().Get("version_id")
+	if versionID == "" {
+		sendAPIResponse(w, r, errors.New("version_id is mandatory"), "", http.StatusBadRequest)
+		return
+	}
+	connection, err := getFileVersionsConnection(r)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	defer connection.CloseFS() //nolint:errcheck
+
+	err = connection.RestoreFileVersion(utils.CleanPath(r.URL.Query().Get("path")), versionID)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getFileVersionsRespStatus(err))
+		return
+	}
+	sendAPIResponse(w, r, nil, "Version restored", http.StatusOK)
+}
+
+func getFileVersionsConnection(r *http.Request) (*common.BaseConnection, error) {
+	if r.URL.Query().Get("path") == "" {
+		return nil, dataprovider.NewValidationError("path is mandatory")
+	}
+	user, err := dataprovider.UserExists(getURLParam(r, "username"))
+	if err != nil {
+		return nil, err
+	}
+	return common.NewBaseConnection(xid.New().String(), common.ProtocolHTTP, user), nil
+}
+
+func getFileVersionsRespStatus(err error) int {
+	if os.IsNotExist(err) {
+		return http.StatusNotFound
+	}
+	if os.IsPermission(err) || err == common.ErrPermissionDenied {
+		return http.StatusForbidden
+	}
+	if err == common.ErrOpUnsupported {
+		return http.StatusBadRequest
+	}
+	return getRespStatus(err)
+}
@@ -21,13 +21,15 @@ const (
 	tokenAudienceWebAdmin  tokenAudience = "WebAdmin"
 	tokenAudienceWebClient tokenAudience = "WebClient"
 	tokenAudienceAPI       tokenAudience = "API"
+	tokenAudienceAPIUser   tokenAudience = "APIUser"
 	tokenAudienceCSRF      tokenAudience = "CSRF"
 )
 
 const (
-	claimUsernameKey    = "username"
-	claimPermissionsKey = "permissions"
-	basicRealm          = "Basic realm=\"SFTPGo\""
+	claimUsernameKey     = "username"
+	claimPermissionsKey  = "permissions"
+	claimImpersonatorKey = "impersonator"
+	basicRealm           = "Basic realm=\"SFTPGo\""
 )
 
 var (
@@ -39,6 +41,8 @@ type jwtTokenClaims struct {
 	Username    string
 	Permissions []string
 	Signature   string
+	// username of the admin that is impersonating the user, if any
+	Impersonator string
 }
 
 func (c *jwtTokenClaims) asMap() map[string]interface{} {
@@ -47,6 +51,9 @@ func (c *jwtTokenClaims) asMap() map[string]interface{} {
 	claims[claimUsernameKey] = c.Username
 	claims[claimPermissionsKey] = c.Permissions
 	claims[jwt.SubjectKey] = c.Signature
+	if c.Impersonator != "" {
+		claims[claimImpersonatorKey] = c.Impersonator
+	}
 
 	return claims
 }
@@ -66,6 +73,13 @@ func (c *jwtTokenClaims) Decode(token map[string]interface{}) {
 		c.Signature = v
 	}
 
+	impersonator := token[claimImpersonatorKey]
+
+	switch v := impersonator.(type) {
+	case string:
+		c.Impersonator = v
+	}
+
 	permissions := token[claimPermissionsKey]
 	switch v := permissions.(type) {
 	case []interface{}:
@@ -198,6 +212,16 @@ func getUserFromToken(r *http.Request) *dataprovider.User {
 	return user
 }
 
+func getImpersonatorFromToken(r *http.Request) string {
+	_, claims, err := jwtauth.FromContext(r.Context())
+	if err != nil {
+		return ""
+	}
+	tokenClaims := jwtTokenClaims{}
+	tokenClaims.Decode(claims)
+	return tokenClaims.Impersonator
+}
+
 func getAdminFromToken(r *http.Request) *dataprovider.Admin {
 	admin := &dataprovider.Admin{}
 	_, claims, err := jwtauth.FromContext(r.Context())
@@ -8,6 +8,7 @@ import (
 	"github.com/eikenb/pipeat"
 
 	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/vfs"
 )
 
 var errTransferAborted = errors.New("transfer aborted")
@@ -15,6 +16,7 @@ var errTransferAborted = errors.New("transfer aborted")
 type httpdFile struct {
 	*common.BaseTransfer
 	reader     io.ReadCloser
+	writer     io.WriteCloser
 	isFinished bool
 }
 
@@ -32,6 +34,20 @@ func newHTTPDFile(baseTransfer *common.BaseTransfer, pipeReader *pipeat.PipeRead
 	}
 }
 
+func newHTTPDUploadFile(baseTransfer *common.BaseTransfer, pipeWriter *vfs.PipeWriter) *httpdFile {
+	var writer io.WriteCloser
+	if baseTransfer.File != nil {
+		writer = baseTransfer.File
+	} else if pipeWriter != nil {
+		writer = pipeWriter
+	}
+	return &httpdFile{
+		BaseTransfer: baseTransfer,
+		writer:       writer,
+		isFinished:   false,
+	}
+}
+
 // Read reads the contents to downloads.
 func (f *httpdFile) Read(p []byte) (n int, err error) {
 	if atomic.LoadInt32(&f.AbortTransfer) == 1 {
@@ -41,7 +57,7 @@ func (f *httpdFile) Read(p []byte) (n int, err error) {
 	f.Connection.UpdateLastActivity()
 
 	n, err = f.reader.Read(p)
-	atomic.AddInt64(&f.BytesSent, int64(n))
+	f.UpdateChecksum(p[:n], atomic.AddInt64(&f.BytesSent, int64(n))-int64(n))
 
 	if err != nil && err != io.EOF {
 		f.TransferError(err)
@@ -51,6 +67,28 @@ func (f *httpdFile) Read(p []byte) (n int, err error) {
 	return
 }
 
+// Write writes the uploaded contents.
+func (f *httpdFile) Write(p []byte) (n int, err error) {
+	if atomic.LoadInt32(&f.AbortTransfer) == 1 {
+		return 0, errTransferAborted
+	}
+
+	f.Connection.UpdateLastActivity()
+
+	n, err = f.writer.Write(p)
+	f.UpdateChecksum(p[:n], atomic.AddInt64(&f.BytesReceived, int64(n))-int64(n))
+
+	if f.MaxWriteSize > 0 && err == nil && atomic.LoadInt64(&f.BytesReceived) > f.MaxWriteSize {
+		err = common.ErrQuotaExceeded
+	}
+	if err != nil {
+		f.TransferError(err)
+		return
+	}
+	f.HandleThrottle()
+	return
+}
+
 // Close closes the current transfer
 func (f *httpdFile) Close() error {
 	if err := f.setFinished(); err != nil {
@@ -69,6 +107,14 @@ func (f *httpdFile) closeIO() error {
 	var err error
 	if f.File != nil {
 		err = f.File.Close()
+	} else if f.writer != nil {
+		err = f.writer.Close()
+		f.Lock()
+		// we set ErrTransfer here so quota is not updated, in this case the uploads are atomic
+		if err != nil && f.ErrTransfer == nil {
+			f.ErrTransfer = err
+		}
+		f.Unlock()
 	} else if f.reader != nil {
 		err = f.reader.Close()
 	}
@@ -11,6 +11,7 @@ import (
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
 )
 
 // Connection details for a HTTP connection used to inteact with an SFTPGo filesystem
@@ -87,11 +88,23 @@ func (c *Connection) getFileReader(name string, offset int64) (io.ReadCloser, er
 		return nil, os.ErrPermission
 	}
 
+	if err := c.LimitOperationRate(common.RateLimitOperationOpen); err != nil {
+		return nil, err
+	}
+
+	if err := c.CheckMaxTransfers(); err != nil {
+		return nil, err
+	}
+
 	fs, p, err := c.GetFsAndResolvedPath(name)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := c.PreDownloadAction(p, name, utils.GetIPFromRemoteAddress(c.GetRemoteAddress())); err != nil {
+		return nil, err
+	}
+
 	file, r, cancelFn, err := fs.Open(p, offset)
 	if err != nil {
 		c.Log(logger.LevelWarn, "could not open file %#v for reading: %+v", p, err)
@@ -100,5 +113,170 @@ func (c *Connection) getFileReader(name string, offset int64) (io.ReadCloser, er
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, p, name, common.TransferDownload,
 		0, 0, 0, false, fs)
+	if offset > 0 {
+		baseTransfer.DisableChecksum()
+	}
 	return newHTTPDFile(baseTransfer, r), nil
 }
+
+// Remove removes a file or a symlink
+func (c *Connection) Remove(name string) error {
+	c.UpdateLastActivity()
+
+	name = utils.CleanPath(name)
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return err
+	}
+
+	var fi os.FileInfo
+	if fi, err = fs.Lstat(p); err != nil {
+		c.Log(logger.LevelWarn, "failed to remove a file %#v: stat error: %+v", p, err)
+		return c.GetFsError(fs, err)
+	}
+
+	if fi.IsDir() && fi.Mode()&os.ModeSymlink == 0 {
+		c.Log(logger.LevelDebug, "cannot remove %#v is not a file/symlink", p)
+		return c.GetGenericError(nil)
+	}
+	return c.RemoveFile(fs, p, name, fi)
+}
+
+func (c *Connection) getFileWriter(name string) (*httpdFile, error) {
+	c.UpdateLastActivity()
+
+	if err := c.LimitOperationRate(common.RateLimitOperationOpen); err != nil {
+		return nil, err
+	}
+
+	if err := c.CheckMaxTransfers(); err != nil {
+		return nil, err
+	}
+
+	name, err := c.PreUploadAction(utils.CleanPath(name))
+	if err != nil {
+		return nil, err
+	}
+	if !c.User.IsFileAllowed(name) {
+		c.Log(logger.LevelWarn, "writing file %#v is not allowed", name)
+		return nil, c.GetPermissionDeniedError()
+	}
+	fs, p, err := c.GetFsAndResolvedPath(name)
+	if err != nil {
+		return nil, err
+	}
+	filePath := p
+	if common.Config.IsAtomicUploadEnabled() && fs.Capabilities().AtomicUpload {
+		filePath = fs.GetAtomicUploadPath(p)
+	}
+
+	stat, statErr := fs.Lstat(p)
+	if (statErr == nil && stat.Mode()&os.ModeSymlink != 0) || fs.IsNotExist(statErr) {
+		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(name)) {
+			return nil, c.GetPermissionDeniedError()
+		}
+		return c.handleUploadToNewFile(fs, p, filePath, name)
+	}
+
+	if statErr != nil {
+		c.Log(logger.LevelError, "error performing file stat %#v: %+v", p, statErr)
+		return nil, c.GetFsError(fs, statErr)
+	}
+
+	// This happen if we upload a file that has the same name of an existing directory
+	if stat.IsDir() {
+		c.Log(logger.LevelWarn, "attempted to open a directory for writing to: %#v", p)
+		return nil, c.GetOpUnsupportedError()
+	}
+
+	if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(name)) {
+		return nil, c.GetPermissionDeniedError()
+	}
+
+	// the existing content will be replaced, save it as a new version if versioning is enabled
+	isVersioned, err := c.SaveFileVersion(fs, p, name)
+	if err != nil {
+		return nil, c.GetFsError(fs, err)
+	}
+	if isVersioned {
+		return c.handleUploadToNewFile(fs, p, filePath, name)
+	}
+
+	return c.handleUploadToExistingFile(fs, p, filePath, stat.Size(), name)
+}
+
+func (c *Connection) handleUploadToNewFile(fs vfs.Fs, resolvedPath, filePath, requestPath string) (*httpdFile, error) {
+	quotaResult := c.HasSpace(true, false, requestPath)
+	if !quotaResult.HasSpace {
+		c.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return nil, common.ErrQuotaExceeded
+	}
+	file, w, cancelFn, err := fs.Create(filePath, 0)
+	if err != nil {
+		c.Log(logger.LevelWarn, "error creating file %#v: %+v", resolvedPath, err)
+		return nil, c.GetFsError(fs, err)
+	}
+
+	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
+
+	// we can get an error only for resume
+	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0, fs.Capabilities().UploadResume)
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
+		common.TransferUpload, 0, 0, maxWriteSize, true, fs)
+	baseTransfer.SetEffectiveFsPath(filePath)
+
+	return newHTTPDUploadFile(baseTransfer, w), nil
+}
+
+func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePath string, fileSize int64,
+	requestPath string) (*httpdFile, error) {
+	var err error
+	quotaResult := c.HasSpace(false, false, requestPath)
+	if !quotaResult.HasSpace {
+		c.Log(logger.LevelInfo, "denying file write due to quota limits")
+		return nil, common.ErrQuotaExceeded
+	}
+
+	// if there is a size limit remaining size cannot be 0 here, since quotaResult.HasSpace
+	// will return false in this case and we deny the upload before
+	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, fileSize, fs.Capabilities().UploadResume)
+
+	if caps := fs.Capabilities(); common.Config.IsAtomicUploadEnabled() && caps.AtomicUpload && caps.Truncate {
+		err = fs.Rename(resolvedPath, filePath)
+		if err != nil {
+			c.Log(logger.LevelWarn, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %+v",
+				resolvedPath, filePath, err)
+			return nil, c.GetFsError(fs, err)
+		}
+	}
+
+	file, w, cancelFn, err := fs.Create(filePath, 0)
+	if err != nil {
+		c.Log(logger.LevelWarn, "error creating file %#v: %+v", resolvedPath, err)
+		return nil, c.GetFsError(fs, err)
+	}
+	initialSize := int64(0)
+	if fs.Capabilities().Truncate {
+		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
+		if err == nil {
+			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
+			if vfolder.IsIncludedInUserQuota() {
+				dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+			}
+		} else {
+			dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
+		}
+		dataprovider.UpdateDirQuota(&c.User, requestPath, 0, -fileSize)
+	} else {
+		initialSize = fileSize
+	}
+
+	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
+
+	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
+		common.TransferUpload, 0, initialSize, maxWriteSize, false, fs)
+	baseTransfer.SetEffectiveFsPath(filePath)
+
+	return newHTTPDUploadFile(baseTransfer, w), nil
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/ftpd"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/rsyncd"
 	"github.com/drakkan/sftpgo/sftpd"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/webdavd"
@@ -46,8 +47,22 @@ const (
 	defenderBanTime                 = "/api/v2/defender/bantime"
 	defenderUnban                   = "/api/v2/defender/unban"
 	defenderScore                   = "/api/v2/defender/score"
+	defenderListsPath               = "/api/v2/defender/lists"
+	defenderReloadPath              = "/api/v2/defender/reload"
+	scheduledJobsPath               = "/api/v2/scheduler/jobs"
+	configReloadPath                = "/api/v2/config/reload"
+	configDumpPath                  = "/api/v2/config/dump"
+	kmsReencryptPath                = "/api/v2/kms/reencrypt"
+	logLevelsPath                   = "/api/v2/logs/levels"
 	adminPath                       = "/api/v2/admins"
 	adminPwdPath                    = "/api/v2/changepwd/admin"
+	userTokenPath                   = "/api/v2/user/token"
+	userLogoutPath                  = "/api/v2/user/logout"
+	userDirsPath                    = "/api/v2/user/dirs"
+	userFilesPath                   = "/api/v2/user/files"
+	userDropLinksPath               = "/api/v2/user/droplinks"
+	dropLinksPath                   = "/api/v2/droplinks"
+	approvalsPath                   = "/api/v2/approvals"
 	healthzPath                     = "/healthz"
 	webRootPathDefault              = "/"
 	webBasePathDefault              = "/web"
@@ -68,15 +83,20 @@ const (
 	webRestorePathDefault           = "/web/admin/restore"
 	webScanVFolderPathDefault       = "/web/admin/folder-quota-scans"
 	webQuotaScanPathDefault         = "/web/admin/quota-scans"
+	webImpersonateUserPathDefault   = "/web/admin/impersonate"
 	webChangeAdminPwdPathDefault    = "/web/admin/changepwd"
 	webTemplateUserDefault          = "/web/admin/template/user"
 	webTemplateFolderDefault        = "/web/admin/template/folder"
+	webApprovalsPathDefault         = "/web/admin/approvals"
 	webClientLoginPathDefault       = "/web/client/login"
 	webClientFilesPathDefault       = "/web/client/files"
 	webClientCredentialsPathDefault = "/web/client/credentials"
 	webChangeClientPwdPathDefault   = "/web/client/changepwd"
 	webChangeClientKeysPathDefault  = "/web/client/managekeys"
 	webClientLogoutPathDefault      = "/web/client/logout"
+	webClientVersionsPathDefault    = "/web/client/versions"
+	webClientTrashPathDefault       = "/web/client/trash"
+	webClientArchivedPathDefault    = "/web/client/archived"
 	webStaticFilesPathDefault       = "/static"
 	// MaxRestoreSize defines the max size for the loaddata input file
 	MaxRestoreSize   = 10485760 // 10 MB
@@ -87,6 +107,7 @@ const (
 
 var (
 	backupsPath              string
+	requireApproval          bool
 	certMgr                  *common.CertManager
 	jwtTokensCleanupTicker   *time.Ticker
 	jwtTokensCleanupDone     chan bool
@@ -111,15 +132,20 @@ var (
 	webRestorePath           string
 	webScanVFolderPath       string
 	webQuotaScanPath         string
+	webImpersonateUserPath   string
 	webChangeAdminPwdPath    string
 	webTemplateUser          string
 	webTemplateFolder        string
+	webApprovalsPath         string
 	webClientLoginPath       string
 	webClientFilesPath       string
 	webClientCredentialsPath string
 	webChangeClientPwdPath   string
 	webChangeClientKeysPath  string
 	webClientLogoutPath      string
+	webClientVersionsPath    string
+	webClientTrashPath       string
+	webClientArchivedPath    string
 	webStaticFilesPath       string
 )
 
@@ -183,6 +209,7 @@ type ServicesStatus struct {
 	SSH          sftpd.ServiceStatus         `json:"ssh"`
 	FTP          ftpd.ServiceStatus          `json:"ftp"`
 	WebDAV       webdavd.ServiceStatus       `json:"webdav"`
+	Rsync        rsyncd.ServiceStatus        `json:"rsync"`
 	DataProvider dataprovider.ProviderStatus `json:"data_provider"`
 	Defender     defenderStatus              `json:"defender"`
 }
@@ -216,6 +243,9 @@ type Conf struct {
 	// CARevocationLists defines a set a revocation lists, one for each root CA, to be used to check
 	// if a client certificate has been revoked
 	CARevocationLists []string `json:"ca_revocation_lists" mapstructure:"ca_revocation_lists"`
+	// If enabled, deleting users and folders and restoring backups must be approved by a second
+	// admin. The requested operations are saved as pending approval requests
+	RequireApproval bool `json:"require_approval" mapstructure:"require_approval"`
 }
 
 type apiResponse struct {
@@ -256,6 +286,7 @@ func (c *Conf) isWebClientEnabled() bool {
 func (c *Conf) Initialize(configDir string) error {
 	logger.Debug(logSender, "", "initializing HTTP server with config %+v", c)
 	backupsPath = getConfigPath(c.BackupsPath, configDir)
+	requireApproval = c.RequireApproval
 	staticFilesPath := getConfigPath(c.StaticFilesPath, configDir)
 	templatesPath := getConfigPath(c.TemplatesPath, configDir)
 	if backupsPath == "" {
@@ -346,6 +377,7 @@ func getServicesStatus() ServicesStatus {
 		SSH:          sftpd.GetStatus(),
 		FTP:          ftpd.GetStatus(),
 		WebDAV:       webdavd.GetStatus(),
+		Rsync:        rsyncd.GetStatus(),
 		DataProvider: dataprovider.GetProviderStatus(),
 		Defender: defenderStatus{
 			IsActive: common.Config.DefenderConfig.Enabled,
@@ -391,6 +423,9 @@ func updateWebClientURLs(baseURL string) {
 	webChangeClientPwdPath = path.Join(baseURL, webChangeClientPwdPathDefault)
 	webChangeClientKeysPath = path.Join(baseURL, webChangeClientKeysPathDefault)
 	webClientLogoutPath = path.Join(baseURL, webClientLogoutPathDefault)
+	webClientVersionsPath = path.Join(baseURL, webClientVersionsPathDefault)
+	webClientTrashPath = path.Join(baseURL, webClientTrashPathDefault)
+	webClientArchivedPath = path.Join(baseURL, webClientArchivedPathDefault)
 }
 
 func updateWebAdminURLs(baseURL string) {
@@ -415,9 +450,11 @@ func updateWebAdminURLs(baseURL string) {
 	webRestorePath = path.Join(baseURL, webRestorePathDefault)
 	webScanVFolderPath = path.Join(baseURL, webScanVFolderPathDefault)
 	webQuotaScanPath = path.Join(baseURL, webQuotaScanPathDefault)
+	webImpersonateUserPath = path.Join(baseURL, webImpersonateUserPathDefault)
 	webChangeAdminPwdPath = path.Join(baseURL, webChangeAdminPwdPathDefault)
 	webTemplateUser = path.Join(baseURL, webTemplateUserDefault)
 	webTemplateFolder = path.Join(baseURL, webTemplateFolderDefault)
+	webApprovalsPath = path.Join(baseURL, webApprovalsPathDefault)
 	webStaticFilesPath = path.Join(baseURL, webStaticFilesPathDefault)
 }
 
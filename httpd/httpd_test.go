@@ -3,6 +3,7 @@ package httpd_test
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -66,6 +67,11 @@ const (
 	updateUsedQuotaPath       = "/api/v2/quota-update"
 	updateFolderUsedQuotaPath = "/api/v2/folder-quota-update"
 	defenderUnban             = "/api/v2/defender/unban"
+	defenderListsPath         = "/api/v2/defender/lists"
+	configReloadPath          = "/api/v2/config/reload"
+	configDumpPath            = "/api/v2/config/dump"
+	kmsReencryptPath          = "/api/v2/kms/reencrypt"
+	logLevelsPath             = "/api/v2/logs/levels"
 	versionPath               = "/api/v2/version"
 	logoutPath                = "/api/v2/logout"
 	healthzPath               = "/healthz"
@@ -93,6 +99,13 @@ const (
 	webChangeClientPwdPath    = "/web/client/changepwd"
 	webChangeClientKeysPath   = "/web/client/managekeys"
 	webClientLogoutPath       = "/web/client/logout"
+	webClientTrashPath        = "/web/client/trash"
+	webImpersonateUserPath    = "/web/admin/impersonate"
+	userTokenPath             = "/api/v2/user/token"
+	userDirsPath              = "/api/v2/user/dirs"
+	userFilesPath             = "/api/v2/user/files"
+	userDropLinksPath         = "/api/v2/user/droplinks"
+	dropLinksPath             = "/api/v2/droplinks"
 	httpBaseURL               = "http://127.0.0.1:8081"
 	sftpServerAddr            = "127.0.0.1:8022"
 	configDir                 = ".."
@@ -630,6 +643,10 @@ func TestAddUserInvalidFilters(t *testing.T) {
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
 	u.Filters.DeniedIP = []string{}
+	u.Filters.MaxConcurrentTransfers = -1
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.MaxConcurrentTransfers = 0
 	u.Filters.DeniedLoginMethods = []string{"invalid"}
 	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
 	assert.NoError(t, err)
@@ -725,6 +742,81 @@ func TestAddUserInvalidFilters(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUserRetentionRules(t *testing.T) {
+	u := getTestUser()
+	u.Filters.Retention = []dataprovider.RetentionRule{
+		{
+			Path: "relative",
+			Days: 10,
+		},
+	}
+	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.Retention = []dataprovider.RetentionRule{
+		{
+			Path: "/dir",
+			Days: 0,
+		},
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.Retention = []dataprovider.RetentionRule{
+		{
+			Path: "/dir",
+			Days: 10,
+		},
+		{
+			Path: "/dir/",
+			Days: 20,
+		},
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.Retention = []dataprovider.RetentionRule{
+		{
+			Path:     "/dir",
+			Days:     10,
+			Patterns: []string{"a\\"},
+		},
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.Retention = []dataprovider.RetentionRule{
+		{
+			Path:        "/dir",
+			Days:        10,
+			ArchivePath: "/dir/archive",
+		},
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.Retention = []dataprovider.RetentionRule{
+		{
+			Path:        "/dir",
+			Days:        10,
+			ArchivePath: "/",
+		},
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.Filters.Retention = []dataprovider.RetentionRule{
+		{
+			Path:        "/dir",
+			Days:        10,
+			Patterns:    []string{"*.LOG"},
+			ArchivePath: "/archive/",
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	if assert.Len(t, user.Filters.Retention, 1) {
+		assert.Equal(t, "/archive", user.Filters.Retention[0].ArchivePath)
+		assert.Equal(t, []string{"*.log"}, user.Filters.Retention[0].Patterns)
+	}
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestAddUserInvalidFsConfig(t *testing.T) {
 	u := getTestUser()
 	u.FsConfig.Provider = vfs.S3FilesystemProvider
@@ -1488,6 +1580,149 @@ func TestUserS3Config(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUserS3SSEConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.S3FilesystemProvider
+	u.FsConfig.S3Config.Bucket = "test"
+	u.FsConfig.S3Config.Region = "us-east-1"
+	u.FsConfig.S3Config.SSE = "invalid"
+	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.S3Config.SSE = "AES256"
+	u.FsConfig.S3Config.SSEKMSKeyID = "alias/key"
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.S3Config.SSE = "SSE-C"
+	u.FsConfig.S3Config.SSEKMSKeyID = ""
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.S3Config.SSECustomerKey = kms.NewPlainSecret(base64.StdEncoding.EncodeToString([]byte("short key")))
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.S3Config.SSECustomerKey = kms.NewPlainSecret(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, kms.SecretStatusSecretBox, user.FsConfig.S3Config.SSECustomerKey.GetStatus())
+	assert.NotEmpty(t, user.FsConfig.S3Config.SSECustomerKey.GetPayload())
+	initialKeyPayload := user.FsConfig.S3Config.SSECustomerKey.GetPayload()
+	// the encrypted key must be preserved on update
+	user.FsConfig.S3Config.UploadConcurrency = 3
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, initialKeyPayload, user.FsConfig.S3Config.SSECustomerKey.GetPayload())
+	user.FsConfig.S3Config.SSE = "aws:kms"
+	user.FsConfig.S3Config.SSEKMSKeyID = "arn:aws:kms:us-east-1:111122223333:key/1234abcd"
+	user.FsConfig.S3Config.SSECustomerKey = kms.NewEmptySecret()
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Nil(t, user.FsConfig.S3Config.SSECustomerKey)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestUserS3UploadRules(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.S3FilesystemProvider
+	u.FsConfig.S3Config.Bucket = "test"
+	u.FsConfig.S3Config.Region = "us-east-1"
+	u.FsConfig.S3Config.Tags = map[string]string{
+		"aws:reserved": "value",
+	}
+	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.S3Config.Tags = make(map[string]string)
+	for i := 0; i < 11; i++ {
+		u.FsConfig.S3Config.Tags[fmt.Sprintf("key%v", i)] = "value"
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.S3Config.Tags = map[string]string{
+		"team": "dev",
+	}
+	u.FsConfig.S3Config.UploadRules = []vfs.S3UploadRule{
+		{
+			Pattern:      "[a-",
+			StorageClass: "GLACIER_IR",
+		},
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.S3Config.UploadRules = []vfs.S3UploadRule{
+		{
+			Pattern: "*.bak",
+		},
+	}
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.S3Config.UploadRules = []vfs.S3UploadRule{
+		{
+			Pattern:      "*.bak",
+			StorageClass: "GLACIER_IR",
+			Tags: map[string]string{
+				"retention": "long",
+			},
+		},
+		{
+			Pattern:      "/logs/*",
+			StorageClass: "STANDARD_IA",
+		},
+	}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	user.FsConfig.S3Config.UploadRules = user.FsConfig.S3Config.UploadRules[:1]
+	user.FsConfig.S3Config.Tags = nil
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Len(t, user.FsConfig.S3Config.UploadRules, 1)
+	assert.Len(t, user.FsConfig.S3Config.Tags, 0)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
+func TestUserCloudRequestConfig(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.S3FilesystemProvider
+	u.FsConfig.S3Config.Bucket = "test"
+	u.FsConfig.S3Config.Region = "us-east-1"
+	u.FsConfig.S3Config.MaxRetries = 21
+	_, _, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.S3Config.MaxRetries = 5
+	u.FsConfig.S3Config.RetryBackoff = -1
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.S3Config.RetryBackoff = 200
+	u.FsConfig.S3Config.RequestTimeout = 3601
+	_, _, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	u.FsConfig.S3Config.RequestTimeout = 60
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, user.FsConfig.S3Config.MaxRetries)
+	assert.Equal(t, 200, user.FsConfig.S3Config.RetryBackoff)
+	assert.Equal(t, 60, user.FsConfig.S3Config.RequestTimeout)
+
+	user.FsConfig.Provider = vfs.GCSFilesystemProvider
+	user.FsConfig.GCSConfig.Bucket = "test"
+	user.FsConfig.GCSConfig.Credentials = kms.NewPlainSecret("fake credentials")
+	user.FsConfig.GCSConfig.RequestTimeout = -1
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
+	assert.NoError(t, err)
+	user.FsConfig.GCSConfig.MaxRetries = 3
+	user.FsConfig.GCSConfig.RequestTimeout = 10
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, user.FsConfig.GCSConfig.MaxRetries)
+	assert.Equal(t, 0, user.FsConfig.GCSConfig.RetryBackoff)
+	assert.Equal(t, 10, user.FsConfig.GCSConfig.RequestTimeout)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(credentialsPath)
+	assert.NoError(t, err)
+	err = os.MkdirAll(credentialsPath, 0700)
+	assert.NoError(t, err)
+}
+
 func TestUserGCSConfig(t *testing.T) {
 	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
@@ -1777,6 +2012,49 @@ func TestUserSFTPFs(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUserSMBFs(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.SMBFilesystemProvider
+	u.FsConfig.SMBConfig.Endpoint = "127.0.0.1"
+	u.FsConfig.SMBConfig.Share = "share/subdir"
+	u.FsConfig.SMBConfig.Username = "smb_user"
+	u.FsConfig.SMBConfig.Password = kms.NewPlainSecret("smb_pwd")
+	_, resp, err := httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "invalid share")
+	u.FsConfig.SMBConfig.Share = "share"
+	u.FsConfig.SMBConfig.Password = kms.NewEmptySecret()
+	_, resp, err = httpdtest.AddUser(u, http.StatusBadRequest)
+	assert.NoError(t, err)
+	assert.Contains(t, string(resp), "password cannot be empty")
+	u.FsConfig.SMBConfig.Password = kms.NewPlainSecret("smb_pwd")
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:445", user.FsConfig.SMBConfig.Endpoint)
+	assert.Equal(t, "share", user.FsConfig.SMBConfig.Share)
+	assert.Equal(t, "/", user.FsConfig.SMBConfig.Prefix)
+	initialPwdPayload := user.FsConfig.SMBConfig.Password.GetPayload()
+	assert.Equal(t, kms.SecretStatusSecretBox, user.FsConfig.SMBConfig.Password.GetStatus())
+	assert.NotEmpty(t, initialPwdPayload)
+	assert.Empty(t, user.FsConfig.SMBConfig.Password.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.SMBConfig.Password.GetKey())
+	user.FsConfig.SMBConfig.Password.SetStatus(kms.SecretStatusSecretBox)
+	user.FsConfig.SMBConfig.Password.SetAdditionalData("adata")
+	user.FsConfig.SMBConfig.Password.SetKey("fake pwd key")
+	user.FsConfig.SMBConfig.Domain = "WORKGROUP"
+	user.FsConfig.SMBConfig.Prefix = "/users/%username%"
+	user, bb, err := httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err, string(bb))
+	assert.Equal(t, kms.SecretStatusSecretBox, user.FsConfig.SMBConfig.Password.GetStatus())
+	assert.Equal(t, initialPwdPayload, user.FsConfig.SMBConfig.Password.GetPayload())
+	assert.Empty(t, user.FsConfig.SMBConfig.Password.GetAdditionalData())
+	assert.Empty(t, user.FsConfig.SMBConfig.Password.GetKey())
+	assert.Equal(t, "WORKGROUP", user.FsConfig.SMBConfig.Domain)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+}
+
 func TestUserHiddenFields(t *testing.T) {
 	err := dataprovider.Close()
 	assert.NoError(t, err)
@@ -2125,6 +2403,93 @@ func TestSecretObjectCompatibility(t *testing.T) {
 	}
 }
 
+func TestVersionedMasterKey(t *testing.T) {
+	testPayload := "test payload"
+	masterKeyPath := filepath.Join(os.TempDir(), "mkey_versioned")
+	err := os.WriteFile(masterKeyPath, []byte("# comment\n1:first key\n"), os.ModePerm)
+	assert.NoError(t, err)
+	kmsConfig := kms.Configuration{
+		Secrets: kms.Secrets{
+			MasterKeyPath: masterKeyPath,
+		},
+	}
+	err = kmsConfig.Initialize()
+	assert.NoError(t, err)
+	secret := kms.NewPlainSecret(testPayload)
+	secret.SetAdditionalData("add data")
+	err = secret.Encrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, secret.GetMode())
+	assert.Equal(t, 1, secret.GetKeyVersion())
+	v1AsJSON, err := json.Marshal(secret)
+	assert.NoError(t, err)
+	assert.Contains(t, string(v1AsJSON), `"key_version":1`)
+	// add a new key version and reload it
+	err = os.WriteFile(masterKeyPath, []byte("1:first key\n\n2:second key\n"), os.ModePerm)
+	assert.NoError(t, err)
+	err = kms.ReloadMasterKey()
+	assert.NoError(t, err)
+	secret = kms.NewPlainSecret(testPayload)
+	secret.SetAdditionalData("add data")
+	err = secret.Encrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, secret.GetKeyVersion())
+	secretClone := secret.Clone()
+	err = secretClone.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, testPayload, secretClone.GetPayload())
+	assert.Equal(t, 0, secretClone.GetKeyVersion())
+	// the secret encrypted using the previous version can be decrypted
+	secret = kms.NewEmptySecret()
+	err = json.Unmarshal(v1AsJSON, secret)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, secret.GetKeyVersion())
+	err = secret.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, testPayload, secret.GetPayload())
+	// remove the previous version
+	err = os.WriteFile(masterKeyPath, []byte("2:second key"), os.ModePerm)
+	assert.NoError(t, err)
+	err = kms.ReloadMasterKey()
+	assert.NoError(t, err)
+	secret = kms.NewEmptySecret()
+	err = json.Unmarshal(v1AsJSON, secret)
+	assert.NoError(t, err)
+	err = secret.Decrypt()
+	assert.Error(t, err)
+	// invalid files are refused and the loaded keys are preserved
+	err = os.WriteFile(masterKeyPath, []byte("2:second key\n2:another key"), os.ModePerm)
+	assert.NoError(t, err)
+	err = kms.ReloadMasterKey()
+	assert.Error(t, err)
+	err = os.WriteFile(masterKeyPath, []byte("99999999999999999999:key"), os.ModePerm)
+	assert.NoError(t, err)
+	err = kmsConfig.Initialize()
+	assert.Error(t, err)
+	secret = kms.NewPlainSecret(testPayload)
+	err = secret.Encrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, secret.GetKeyVersion())
+	// a not versioned key has version 0
+	err = os.WriteFile(masterKeyPath, []byte("single key\n2:second key"), os.ModePerm)
+	assert.NoError(t, err)
+	err = kmsConfig.Initialize()
+	assert.NoError(t, err)
+	secret = kms.NewPlainSecret(testPayload)
+	err = secret.Encrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, secret.GetMode())
+	assert.Equal(t, 0, secret.GetKeyVersion())
+	err = secret.Decrypt()
+	assert.NoError(t, err)
+
+	kmsConfig = config.GetKMSConfig()
+	err = kmsConfig.Initialize()
+	assert.NoError(t, err)
+	err = os.Remove(masterKeyPath)
+	assert.NoError(t, err)
+}
+
 func TestUpdateUserNoCredentials(t *testing.T) {
 	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
@@ -2991,6 +3356,80 @@ func TestDefenderAPI(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestDefenderListsAPI(t *testing.T) {
+	oldConfig := config.GetCommonConfig()
+
+	cfg := config.GetCommonConfig()
+	cfg.DefenderConfig.Enabled = true
+
+	err := common.Initialize(cfg)
+	require.NoError(t, err)
+
+	entries, _, err := httpdtest.GetDefenderListEntries(http.StatusOK)
+	require.NoError(t, err)
+	assert.Len(t, entries, 0)
+
+	entry := dataprovider.DefenderListEntry{
+		Type:        dataprovider.DefenderBlockList,
+		Network:     "192.168.1.10/24",
+		Description: "test block list",
+	}
+	newEntry, _, err := httpdtest.AddDefenderListEntry(entry, http.StatusCreated)
+	require.NoError(t, err)
+	assert.Equal(t, "192.168.1.0/24", newEntry.Network)
+	assert.Equal(t, entry.Description, newEntry.Description)
+	assert.Greater(t, newEntry.CreatedAt, int64(0))
+	assert.True(t, common.IsBanned("192.168.1.20"))
+	assert.False(t, common.IsBanned("192.168.2.20"))
+
+	_, _, err = httpdtest.AddDefenderListEntry(entry, http.StatusInternalServerError)
+	require.NoError(t, err)
+
+	entries, _, err = httpdtest.GetDefenderListEntries(http.StatusOK)
+	require.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, newEntry.Network, entries[0].Network)
+		assert.Equal(t, newEntry.Type, entries[0].Type)
+	}
+
+	err = httpdtest.ReloadDefender(http.StatusOK)
+	require.NoError(t, err)
+	assert.True(t, common.IsBanned("192.168.1.20"))
+
+	_, err = httpdtest.RemoveDefenderListEntry(newEntry, http.StatusOK)
+	require.NoError(t, err)
+	assert.False(t, common.IsBanned("192.168.1.20"))
+	_, err = httpdtest.RemoveDefenderListEntry(newEntry, http.StatusNotFound)
+	require.NoError(t, err)
+
+	_, _, err = httpdtest.AddDefenderListEntry(dataprovider.DefenderListEntry{
+		Type:    3,
+		Network: "192.168.1.1",
+	}, http.StatusBadRequest)
+	require.NoError(t, err)
+	_, _, err = httpdtest.AddDefenderListEntry(dataprovider.DefenderListEntry{
+		Type:    dataprovider.DefenderSafeList,
+		Network: "invalid",
+	}, http.StatusBadRequest)
+	require.NoError(t, err)
+	_, err = httpdtest.RemoveDefenderListEntry(dataprovider.DefenderListEntry{
+		Type:    dataprovider.DefenderSafeList,
+		Network: "192.168.1.1/33",
+	}, http.StatusBadRequest)
+	require.NoError(t, err)
+
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	require.NoError(t, err)
+	req, err := http.NewRequest(http.MethodDelete, defenderListsPath+"?type=a&network=192.168.1.1", nil)
+	require.NoError(t, err)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	err = common.Initialize(oldConfig)
+	require.NoError(t, err)
+}
+
 func TestDefenderAPIErrors(t *testing.T) {
 	_, _, err := httpdtest.GetBanTime("", http.StatusBadRequest)
 	require.NoError(t, err)
@@ -3426,54 +3865,283 @@ func TestUnbanInvalidJsonMock(t *testing.T) {
 	checkResponseCode(t, http.StatusBadRequest, rr)
 }
 
-func TestAddUserInvalidJsonMock(t *testing.T) {
+func TestReloadConfigMock(t *testing.T) {
 	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
 	assert.NoError(t, err)
-	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer([]byte("invalid json")))
+	req, _ := http.NewRequest(http.MethodPost, configReloadPath, nil)
 	setBearerForReq(req, token)
 	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-}
 
-func TestAddAdminInvalidJsonMock(t *testing.T) {
-	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
-	assert.NoError(t, err)
-	req, _ := http.NewRequest(http.MethodPost, adminPath, bytes.NewBuffer([]byte("...")))
+	httpd.SetConfigReloader(func() ([]string, error) {
+		return nil, errors.New("reload error")
+	})
+	req, _ = http.NewRequest(http.MethodPost, configReloadPath, nil)
 	setBearerForReq(req, token)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-}
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr)
+	assert.Contains(t, rr.Body.String(), "reload error")
 
-func TestAddAdminNoPasswordMock(t *testing.T) {
-	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
-	assert.NoError(t, err)
-	admin := getTestAdmin()
-	admin.Password = ""
-	asJSON, err := json.Marshal(admin)
-	assert.NoError(t, err)
-	req, _ := http.NewRequest(http.MethodPost, adminPath, bytes.NewBuffer(asJSON))
+	httpd.SetConfigReloader(func() ([]string, error) {
+		return []string{"sftpd.bindings"}, nil
+	})
+	req, _ = http.NewRequest(http.MethodPost, configReloadPath, nil)
 	setBearerForReq(req, token)
-	rr := executeRequest(req)
-	checkResponseCode(t, http.StatusBadRequest, rr)
-	assert.Contains(t, rr.Body.String(), "please set a password")
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var resp map[string]interface{}
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"sftpd.bindings"}, resp["restart_required"])
+
+	httpd.SetConfigReloader(nil)
 }
 
-func TestChangeAdminPwdInvalidJsonMock(t *testing.T) {
+func TestDumpConfigMock(t *testing.T) {
 	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
 	assert.NoError(t, err)
-	req, _ := http.NewRequest(http.MethodPut, adminPwdPath, bytes.NewBuffer([]byte("{")))
+	req, _ := http.NewRequest(http.MethodGet, configDumpPath, nil)
 	setBearerForReq(req, token)
 	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
-}
 
-func TestLoginInvalidPasswordMock(t *testing.T) {
-	_, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass+"1")
-	assert.Error(t, err)
-	// now a login with no credentials
-	req, _ := http.NewRequest(http.MethodGet, "/api/v2/token", nil)
-	rr := executeRequest(req)
-	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	httpd.SetConfigDumper(func() (map[string]interface{}, error) {
+		return nil, errors.New("dump error")
+	})
+	req, _ = http.NewRequest(http.MethodGet, configDumpPath, nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusInternalServerError, rr)
+	assert.Contains(t, rr.Body.String(), "dump error")
+
+	httpd.SetConfigDumper(func() (map[string]interface{}, error) {
+		return map[string]interface{}{
+			"data_provider": map[string]interface{}{
+				"password": "[redacted]",
+			},
+		}, nil
+	})
+	req, _ = http.NewRequest(http.MethodGet, configDumpPath, nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var resp map[string]interface{}
+	err = json.Unmarshal(rr.Body.Bytes(), &resp)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"password": "[redacted]"}, resp["data_provider"])
+
+	httpd.SetConfigDumper(nil)
+}
+
+func TestReencryptSecrets(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.CryptedFilesystemProvider
+	u.FsConfig.CryptConfig.Passphrase = kms.NewPlainSecret("crypt passphrase")
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	dbUser, err := dataprovider.UserExists(user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, kms.SecretStatusSecretBox, dbUser.FsConfig.CryptConfig.Passphrase.GetStatus())
+	assert.Equal(t, 0, dbUser.FsConfig.CryptConfig.Passphrase.GetMode())
+
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPost, kmsReencryptPath, bytes.NewBuffer([]byte("{")))
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	asJSON, err := json.Marshal(map[string]interface{}{
+		"previous": map[string]string{
+			"master_key_path": filepath.Join(os.TempDir(), "missing_mkey"),
+		},
+	})
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPost, kmsReencryptPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	masterKeyPath := filepath.Join(os.TempDir(), "mkey")
+	err = os.WriteFile(masterKeyPath, []byte("new test key"), os.ModePerm)
+	assert.NoError(t, err)
+	kmsConfig := kms.Configuration{
+		Secrets: kms.Secrets{
+			MasterKeyPath: masterKeyPath,
+		},
+	}
+	err = kmsConfig.Initialize()
+	assert.NoError(t, err)
+
+	// the previous configuration has no master key
+	asJSON, err = json.Marshal(map[string]interface{}{
+		"previous": map[string]string{},
+		"dry_run":  true,
+	})
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPost, kmsReencryptPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var result dataprovider.ReencryptResult
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, result.Users, 1)
+	assert.GreaterOrEqual(t, result.Secrets, 1)
+	dbUser, err = dataprovider.UserExists(user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, dbUser.FsConfig.CryptConfig.Passphrase.GetMode())
+
+	asJSON, err = json.Marshal(map[string]interface{}{
+		"previous": map[string]string{},
+	})
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPost, kmsReencryptPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	result = dataprovider.ReencryptResult{}
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, result.Secrets, 1)
+	dbUser, err = dataprovider.UserExists(user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, kms.SecretStatusSecretBox, dbUser.FsConfig.CryptConfig.Passphrase.GetStatus())
+	assert.Equal(t, 1, dbUser.FsConfig.CryptConfig.Passphrase.GetMode())
+	err = dbUser.FsConfig.CryptConfig.Passphrase.Decrypt()
+	assert.NoError(t, err)
+	assert.Equal(t, "crypt passphrase", dbUser.FsConfig.CryptConfig.Passphrase.GetPayload())
+	// the secret is now encrypted using the current configuration and it is skipped
+	req, _ = http.NewRequest(http.MethodPost, kmsReencryptPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	result = dataprovider.ReencryptResult{}
+	err = json.Unmarshal(rr.Body.Bytes(), &result)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, result.Skipped, 1)
+	dbUser, err = dataprovider.UserExists(user.Username)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, dbUser.FsConfig.CryptConfig.Passphrase.GetMode())
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	kmsConfig = config.GetKMSConfig()
+	err = kmsConfig.Initialize()
+	assert.NoError(t, err)
+	err = os.Remove(masterKeyPath)
+	assert.NoError(t, err)
+}
+
+func TestLogLevelsMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodGet, logLevelsPath, nil)
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var initialLevels logger.LogLevels
+	err = json.Unmarshal(rr.Body.Bytes(), &initialLevels)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, initialLevels.Default)
+
+	req, _ = http.NewRequest(http.MethodPut, logLevelsPath, bytes.NewBuffer([]byte("invalid json")))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	asJSON, err := json.Marshal(logger.LogLevels{
+		Overrides: map[string]string{
+			"sftpd": "trace",
+		},
+	})
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPut, logLevelsPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "invalid log level")
+
+	asJSON, err = json.Marshal(logger.LogLevels{
+		Overrides: map[string]string{
+			"SFTPD":        "debug",
+			"dataProvider": "warn",
+		},
+	})
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPut, logLevelsPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.True(t, logger.IsLevelEnabled("sftpd", logger.LevelDebug))
+	assert.False(t, logger.IsLevelEnabled("dataprovider", logger.LevelInfo))
+	assert.True(t, logger.IsLevelEnabled("dataprovider", logger.LevelWarn))
+
+	req, _ = http.NewRequest(http.MethodGet, logLevelsPath, nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var levels logger.LogLevels
+	err = json.Unmarshal(rr.Body.Bytes(), &levels)
+	assert.NoError(t, err)
+	assert.Equal(t, initialLevels.Default, levels.Default)
+	assert.Equal(t, map[string]string{"sftpd": "debug", "dataprovider": "warn"}, levels.Overrides)
+
+	err = logger.SetLogLevels(initialLevels)
+	assert.NoError(t, err)
+	assert.Equal(t, initialLevels, logger.GetLogLevels())
+}
+
+func TestAddUserInvalidJsonMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPost, userPath, bytes.NewBuffer([]byte("invalid json")))
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+}
+
+func TestAddAdminInvalidJsonMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPost, adminPath, bytes.NewBuffer([]byte("...")))
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+}
+
+func TestAddAdminNoPasswordMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	admin := getTestAdmin()
+	admin.Password = ""
+	asJSON, err := json.Marshal(admin)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPost, adminPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "please set a password")
+}
+
+func TestChangeAdminPwdInvalidJsonMock(t *testing.T) {
+	token, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPut, adminPwdPath, bytes.NewBuffer([]byte("{")))
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+}
+
+func TestLoginInvalidPasswordMock(t *testing.T) {
+	_, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass+"1")
+	assert.Error(t, err)
+	// now a login with no credentials
+	req, _ := http.NewRequest(http.MethodGet, "/api/v2/token", nil)
+	rr := executeRequest(req)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
 }
 
 func TestChangeAdminPwdMock(t *testing.T) {
@@ -4693,108 +5361,308 @@ func TestPostConnectHook(t *testing.T) {
 	common.Config.PostConnectHook = ""
 }
 
-func TestMaxSessions(t *testing.T) {
-	u := getTestUser()
-	u.MaxSessions = 1
-	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+func TestMaxSessions(t *testing.T) {
+	u := getTestUser()
+	u.MaxSessions = 1
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	// now add a fake connection
+	fs := vfs.NewOsFs("id", os.TempDir(), "")
+	connection := &httpd.Connection{
+		BaseConnection: common.NewBaseConnection(fs.ConnectionID(), common.ProtocolHTTP, user),
+	}
+	common.Connections.Add(connection)
+	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.Error(t, err)
+	common.Connections.Remove(connection.GetID())
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	assert.Len(t, common.Connections.GetStats(), 0)
+}
+
+func TestLoginInvalidFs(t *testing.T) {
+	u := getTestUser()
+	u.FsConfig.Provider = vfs.GCSFilesystemProvider
+	u.FsConfig.GCSConfig.Bucket = "test"
+	u.FsConfig.GCSConfig.Credentials = kms.NewPlainSecret("invalid JSON for credentials")
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+
+	credentialsFile := filepath.Join(credentialsPath, fmt.Sprintf("%v_gcs_credentials.json", u.Username))
+	if !filepath.IsAbs(credentialsFile) {
+		credentialsFile = filepath.Join(configDir, credentialsFile)
+	}
+
+	// now remove the credentials file so the filesystem creation will fail
+	err = os.Remove(credentialsFile)
+	assert.NoError(t, err)
+
+	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.Error(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestWebClientChangePwd(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	csrfToken, err := getCSRFToken(httpBaseURL + webClientLoginPath)
+	assert.NoError(t, err)
+
+	form := make(url.Values)
+	form.Set("current_password", defaultPassword)
+	form.Set("new_password1", defaultPassword)
+	form.Set("new_password2", defaultPassword)
+	// no csrf token
+	req, _ := http.NewRequest(http.MethodPost, webChangeClientPwdPath, bytes.NewBuffer([]byte(form.Encode())))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), "unable to verify form token")
+
+	form.Set(csrfFormToken, csrfToken)
+	req, _ = http.NewRequest(http.MethodPost, webChangeClientPwdPath, bytes.NewBuffer([]byte(form.Encode())))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "the new password must be different from the current one")
+
+	form.Set("current_password", defaultPassword+"2")
+	form.Set("new_password1", defaultPassword+"1")
+	form.Set("new_password2", defaultPassword+"1")
+	req, _ = http.NewRequest(http.MethodPost, webChangeClientPwdPath, bytes.NewBuffer([]byte(form.Encode())))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "current password does not match")
+
+	form.Set("current_password", defaultPassword)
+	form.Set("new_password1", defaultPassword+"1")
+	form.Set("new_password2", defaultPassword+"1")
+	req, _ = http.NewRequest(http.MethodPost, webChangeClientPwdPath, bytes.NewBuffer([]byte(form.Encode())))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	assert.Equal(t, webClientLoginPath, rr.Header().Get("Location"))
+
+	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.Error(t, err)
+	_, err = getJWTWebClientTokenFromTestServer(defaultUsername+"1", defaultPassword+"1")
+	assert.Error(t, err)
+	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword+"1")
+	assert.NoError(t, err)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestWebImpersonateUserMock(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	admin := getTestAdmin()
+	admin.Username = altAdminUsername
+	admin.Password = altAdminPassword
+	admin.Permissions = []string{dataprovider.PermAdminViewUsers}
+	admin, _, err = httpdtest.AddAdmin(admin, http.StatusCreated)
+	assert.NoError(t, err)
+	csrfToken, err := getCSRFToken(httpBaseURL + webLoginPath)
+	assert.NoError(t, err)
+	asJSON, err := json.Marshal(map[string]string{"username": user.Username})
+	assert.NoError(t, err)
+
+	altToken, err := getJWTWebTokenFromTestServer(altAdminUsername, altAdminPassword)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPost, webImpersonateUserPath, bytes.NewBuffer(asJSON))
+	setJWTCookieForReq(req, altToken)
+	setCSRFHeaderForReq(req, csrfToken)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	token, err := getJWTWebTokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	// no csrf token
+	req, _ = http.NewRequest(http.MethodPost, webImpersonateUserPath, bytes.NewBuffer(asJSON))
+	setJWTCookieForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	req, _ = http.NewRequest(http.MethodPost, webImpersonateUserPath, bytes.NewBuffer([]byte("invalid json")))
+	setJWTCookieForReq(req, token)
+	setCSRFHeaderForReq(req, csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	missingUser, err := json.Marshal(map[string]string{"username": "missing_user"})
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPost, webImpersonateUserPath, bytes.NewBuffer(missingUser))
+	setJWTCookieForReq(req, token)
+	setCSRFHeaderForReq(req, csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, _ = http.NewRequest(http.MethodPost, webImpersonateUserPath, bytes.NewBuffer(asJSON))
+	setJWTCookieForReq(req, token)
+	setCSRFHeaderForReq(req, csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	cookie := strings.Split(rr.Header().Get("Set-Cookie"), ";")
+	require.True(t, strings.HasPrefix(cookie[0], "jwt="))
+	assert.Contains(t, rr.Header().Get("Set-Cookie"), "Path="+webBasePathClient)
+	webClientToken := cookie[0][4:]
+
+	req, _ = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	setJWTCookieForReq(req, webClientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), fmt.Sprintf("Admin \"%v\" is impersonating", defaultTokenAuthUser))
+	// the user credentials cannot be changed
+	clientCSRFToken, err := getCSRFToken(httpBaseURL + webClientLoginPath)
+	assert.NoError(t, err)
+	form := make(url.Values)
+	form.Set("current_password", defaultPassword)
+	form.Set("new_password1", defaultPassword+"1")
+	form.Set("new_password2", defaultPassword+"1")
+	form.Set(csrfFormToken, clientCSRFToken)
+	req, _ = http.NewRequest(http.MethodPost, webChangeClientPwdPath, bytes.NewBuffer([]byte(form.Encode())))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webClientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	assert.Contains(t, rr.Body.String(), "not allowed while impersonating a user")
+
+	form = make(url.Values)
+	form.Set("public_keys", testPubKey)
+	form.Set(csrfFormToken, clientCSRFToken)
+	req, _ = http.NewRequest(http.MethodPost, webChangeClientKeysPath, bytes.NewBuffer([]byte(form.Encode())))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	setJWTCookieForReq(req, webClientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	req, _ = http.NewRequest(http.MethodGet, webClientLogoutPath, nil)
+	setJWTCookieForReq(req, webClientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+
+	req, _ = http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	setJWTCookieForReq(req, webClientToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusFound, rr)
+	// disabled users cannot be impersonated
+	user.Status = 0
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPost, webImpersonateUserPath, bytes.NewBuffer(asJSON))
+	setJWTCookieForReq(req, token)
+	setCSRFHeaderForReq(req, csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "is disabled")
+
+	user.Status = 1
+	user.Filters.DeniedProtocols = []string{common.ProtocolHTTP}
+	user, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
 	assert.NoError(t, err)
-	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
+	req, _ = http.NewRequest(http.MethodPost, webImpersonateUserPath, bytes.NewBuffer(asJSON))
+	setJWTCookieForReq(req, token)
+	setCSRFHeaderForReq(req, csrfToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	assert.Contains(t, rr.Body.String(), "Protocol HTTP is not allowed")
+
+	_, err = httpdtest.RemoveAdmin(admin, http.StatusOK)
 	assert.NoError(t, err)
-	// now add a fake connection
-	fs := vfs.NewOsFs("id", os.TempDir(), "")
-	connection := &httpd.Connection{
-		BaseConnection: common.NewBaseConnection(fs.ConnectionID(), common.ProtocolHTTP, user),
-	}
-	common.Connections.Add(connection)
-	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
-	assert.Error(t, err)
-	common.Connections.Remove(connection.GetID())
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
 	assert.NoError(t, err)
 	err = os.RemoveAll(user.GetHomeDir())
 	assert.NoError(t, err)
-	assert.Len(t, common.Connections.GetStats(), 0)
 }
 
-func TestLoginInvalidFs(t *testing.T) {
+func TestWebClientTrash(t *testing.T) {
 	u := getTestUser()
-	u.FsConfig.Provider = vfs.GCSFilesystemProvider
-	u.FsConfig.GCSConfig.Bucket = "test"
-	u.FsConfig.GCSConfig.Credentials = kms.NewPlainSecret("invalid JSON for credentials")
+	u.Filters.Trash.Enabled = true
 	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
 	assert.NoError(t, err)
-
-	credentialsFile := filepath.Join(credentialsPath, fmt.Sprintf("%v_gcs_credentials.json", u.Username))
-	if !filepath.IsAbs(credentialsFile) {
-		credentialsFile = filepath.Join(configDir, credentialsFile)
-	}
-
-	// now remove the credentials file so the filesystem creation will fail
-	err = os.Remove(credentialsFile)
-	assert.NoError(t, err)
-
-	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
-	assert.Error(t, err)
-
-	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	trashID := time.Now().UTC().Format("20060102T150405.000000000Z")
+	trashedFile := filepath.Join(user.GetHomeDir(), dataprovider.TrashDirName, trashID, "sub", "file.txt")
+	err = os.MkdirAll(filepath.Dir(trashedFile), os.ModePerm)
 	assert.NoError(t, err)
-	err = os.RemoveAll(user.GetHomeDir())
+	err = os.WriteFile(trashedFile, []byte("trash content"), os.ModePerm)
 	assert.NoError(t, err)
-}
 
-func TestWebClientChangePwd(t *testing.T) {
-	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
-	assert.NoError(t, err)
 	webToken, err := getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
 	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodGet, webClientFilesPath, nil)
+	setJWTCookieForReq(req, webToken)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.NotContains(t, rr.Body.String(), dataprovider.TrashDirName)
+	assert.Contains(t, rr.Body.String(), webClientTrashPath)
+
+	req, _ = http.NewRequest(http.MethodGet, webClientTrashPath, nil)
+	setJWTCookieForReq(req, webToken)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "/sub/file.txt")
+	assert.Contains(t, rr.Body.String(), trashID)
+
 	csrfToken, err := getCSRFToken(httpBaseURL + webClientLoginPath)
 	assert.NoError(t, err)
-
 	form := make(url.Values)
-	form.Set("current_password", defaultPassword)
-	form.Set("new_password1", defaultPassword)
-	form.Set("new_password2", defaultPassword)
+	form.Set("path", "/sub/file.txt")
+	form.Set("trash_id", trashID)
 	// no csrf token
-	req, _ := http.NewRequest(http.MethodPost, webChangeClientPwdPath, bytes.NewBuffer([]byte(form.Encode())))
+	req, _ = http.NewRequest(http.MethodPost, webClientTrashPath, bytes.NewBuffer([]byte(form.Encode())))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	setJWTCookieForReq(req, webToken)
-	rr := executeRequest(req)
+	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusForbidden, rr)
-	assert.Contains(t, rr.Body.String(), "unable to verify form token")
 
 	form.Set(csrfFormToken, csrfToken)
-	req, _ = http.NewRequest(http.MethodPost, webChangeClientPwdPath, bytes.NewBuffer([]byte(form.Encode())))
+	form.Set("trash_id", "invalid")
+	req, _ = http.NewRequest(http.MethodPost, webClientTrashPath, bytes.NewBuffer([]byte(form.Encode())))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
 	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), "the new password must be different from the current one")
+	assert.Contains(t, rr.Body.String(), "unable to restore")
 
-	form.Set("current_password", defaultPassword+"2")
-	form.Set("new_password1", defaultPassword+"1")
-	form.Set("new_password2", defaultPassword+"1")
-	req, _ = http.NewRequest(http.MethodPost, webChangeClientPwdPath, bytes.NewBuffer([]byte(form.Encode())))
+	form.Set("trash_id", trashID)
+	req, _ = http.NewRequest(http.MethodPost, webClientTrashPath, bytes.NewBuffer([]byte(form.Encode())))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusOK, rr)
-	assert.Contains(t, rr.Body.String(), "current password does not match")
+	checkResponseCode(t, http.StatusSeeOther, rr)
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "sub", "file.txt"))
+	assert.NoFileExists(t, trashedFile)
 
-	form.Set("current_password", defaultPassword)
-	form.Set("new_password1", defaultPassword+"1")
-	form.Set("new_password2", defaultPassword+"1")
-	req, _ = http.NewRequest(http.MethodPost, webChangeClientPwdPath, bytes.NewBuffer([]byte(form.Encode())))
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	user.Filters.Trash.Enabled = false
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodGet, webClientTrashPath, nil)
 	setJWTCookieForReq(req, webToken)
 	rr = executeRequest(req)
-	checkResponseCode(t, http.StatusFound, rr)
-	assert.Equal(t, webClientLoginPath, rr.Header().Get("Location"))
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Contains(t, rr.Body.String(), "unable to list the trash contents")
 
-	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword)
-	assert.Error(t, err)
-	_, err = getJWTWebClientTokenFromTestServer(defaultUsername+"1", defaultPassword+"1")
-	assert.Error(t, err)
-	_, err = getJWTWebClientTokenFromTestServer(defaultUsername, defaultPassword+"1")
+	user.Filters.Trash.Enabled = true
+	user.Filters.Trash.RetentionDays = -1
+	_, _, err = httpdtest.UpdateUser(user, http.StatusBadRequest, "")
 	assert.NoError(t, err)
 
 	_, err = httpdtest.RemoveUser(user, http.StatusOK)
@@ -4976,6 +5844,248 @@ func TestWebGetFiles(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestUserAPIFiles(t *testing.T) {
+	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
+	assert.NoError(t, err)
+	testFileContents := []byte("file contents")
+	// admin tokens cannot be used for the user API and vice versa
+	adminToken, err := getJWTAPITokenFromTestServer(defaultTokenAuthUser, defaultTokenAuthPass)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodGet, userDirsPath, nil)
+	setBearerForReq(req, adminToken)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+
+	_, err = getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword+"1")
+	assert.Error(t, err)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodGet, userPath, nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+
+	req, _ = http.NewRequest(http.MethodPost, userDirsPath+"?path=adir", nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+
+	req, _ = http.NewRequest(http.MethodPost, userDirsPath, nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, _ = http.NewRequest(http.MethodPost, userFilesPath+"?path=adir/file.txt", bytes.NewBuffer(testFileContents))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+
+	req, _ = http.NewRequest(http.MethodGet, userDirsPath+"?path=adir", nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var contents []map[string]interface{}
+	err = json.Unmarshal(rr.Body.Bytes(), &contents)
+	assert.NoError(t, err)
+	if assert.Len(t, contents, 1) {
+		assert.Equal(t, "file.txt", contents[0]["name"])
+		assert.Equal(t, "file", contents[0]["type"])
+		assert.Equal(t, float64(len(testFileContents)), contents[0]["size"])
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, userFilesPath+"?path=adir/file.txt", nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.Equal(t, testFileContents, rr.Body.Bytes())
+
+	req, _ = http.NewRequest(http.MethodGet, userFilesPath+"?path=adir", nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	req, _ = http.NewRequest(http.MethodGet, userFilesPath+"?path=missing", nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	req, _ = http.NewRequest(http.MethodPatch, userFilesPath+"?path=adir/file.txt&target=file.txt", nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "file.txt"))
+
+	req, _ = http.NewRequest(http.MethodDelete, userFilesPath+"?path=file.txt", nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "file.txt"))
+
+	req, _ = http.NewRequest(http.MethodDelete, userDirsPath+"?path=adir", nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	assert.NoDirExists(t, filepath.Join(user.GetHomeDir(), "adir"))
+
+	user.Permissions["/"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	_, resp, err := httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err, string(resp))
+	// the updated permissions are applied to the existing tokens too
+	req, _ = http.NewRequest(http.MethodPost, userFilesPath+"?path=file.txt", bytes.NewBuffer(testFileContents))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	req, _ = http.NewRequest(http.MethodPost, userDirsPath+"?path=adir", nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	user.Password = defaultPassword + "1"
+	_, resp, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err, string(resp))
+	// the token is no longer valid after a password change
+	req, _ = http.NewRequest(http.MethodGet, userDirsPath, nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusUnauthorized, rr)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
+func TestUserAPIDropLinks(t *testing.T) {
+	u := getTestUser()
+	u.QuotaFiles = 100
+	u.Permissions["/denied"] = []string{dataprovider.PermListItems, dataprovider.PermDownload}
+	user, _, err := httpdtest.AddUser(u, http.StatusCreated)
+	assert.NoError(t, err)
+	token, err := getJWTAPIUserTokenFromTestServer(defaultUsername, defaultPassword)
+	assert.NoError(t, err)
+
+	link := dataprovider.DropLink{
+		Description: "drop box",
+		Path:        "/denied",
+		MaxFiles:    2,
+		MaxSize:     20,
+	}
+	asJSON, err := json.Marshal(link)
+	assert.NoError(t, err)
+	req, _ := http.NewRequest(http.MethodPost, userDropLinksPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr := executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	link.Path = "/uploads"
+	link.ExpiresAt = utils.GetTimeAsMsSinceEpoch(time.Now().Add(-1 * time.Hour))
+	asJSON, err = json.Marshal(link)
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPost, userDropLinksPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+
+	link.ExpiresAt = utils.GetTimeAsMsSinceEpoch(time.Now().Add(1 * time.Hour))
+	asJSON, err = json.Marshal(link)
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodPost, userDropLinksPath, bytes.NewBuffer(asJSON))
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	err = json.Unmarshal(rr.Body.Bytes(), &link)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, link.LinkID)
+	assert.Equal(t, user.Username, link.Username)
+
+	req, _ = http.NewRequest(http.MethodGet, userDropLinksPath, nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	var links []dataprovider.DropLink
+	err = json.Unmarshal(rr.Body.Bytes(), &links)
+	assert.NoError(t, err)
+	assert.Len(t, links, 1)
+
+	req, _ = http.NewRequest(http.MethodPost, userDirsPath+"?path=uploads", nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	// anonymous uploads
+	req, _ = http.NewRequest(http.MethodPost, path.Join(dropLinksPath, link.LinkID)+"?name=..", bytes.NewBuffer([]byte("data")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusBadRequest, rr)
+	req, _ = http.NewRequest(http.MethodPost, path.Join(dropLinksPath, "missing")+"?name=file1", bytes.NewBuffer([]byte("data")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req, _ = http.NewRequest(http.MethodPost, path.Join(dropLinksPath, link.LinkID)+"?name=file1", bytes.NewBuffer([]byte("data")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	assert.FileExists(t, filepath.Join(user.GetHomeDir(), "uploads", "file1"))
+	// existing files cannot be overwritten
+	req, _ = http.NewRequest(http.MethodPost, path.Join(dropLinksPath, link.LinkID)+"?name=file1", bytes.NewBuffer([]byte("data")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusConflict, rr)
+	// the size limit is exceeded
+	req, _ = http.NewRequest(http.MethodPost, path.Join(dropLinksPath, link.LinkID)+"?name=file2",
+		bytes.NewBuffer(make([]byte, 17)))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusRequestEntityTooLarge, rr)
+	assert.NoFileExists(t, filepath.Join(user.GetHomeDir(), "uploads", "file2"))
+	req, _ = http.NewRequest(http.MethodPost, path.Join(dropLinksPath, link.LinkID)+"?name=file2",
+		bytes.NewBuffer(make([]byte, 16)))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusCreated, rr)
+	// the files limit is reached
+	req, _ = http.NewRequest(http.MethodPost, path.Join(dropLinksPath, link.LinkID)+"?name=file3", bytes.NewBuffer([]byte("")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+
+	req, _ = http.NewRequest(http.MethodGet, path.Join(userDropLinksPath, link.LinkID), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	err = json.Unmarshal(rr.Body.Bytes(), &link)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, link.UploadedFiles)
+	assert.Equal(t, int64(20), link.UploadedSize)
+	assert.Greater(t, link.LastUseAt, int64(0))
+	// the uploads are attributed to the link owner
+	user, _, err = httpdtest.GetUserByUsername(user.Username, http.StatusOK)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, user.UsedQuotaFiles)
+	assert.Equal(t, int64(20), user.UsedQuotaSize)
+
+	user.Filters.WebClient = []string{dataprovider.WebClientDropLinksDisabled}
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+	req, _ = http.NewRequest(http.MethodGet, userDropLinksPath, nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusForbidden, rr)
+	user.Filters.WebClient = nil
+	_, _, err = httpdtest.UpdateUser(user, http.StatusOK, "")
+	assert.NoError(t, err)
+
+	req, _ = http.NewRequest(http.MethodDelete, path.Join(userDropLinksPath, link.LinkID), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusOK, rr)
+	req, _ = http.NewRequest(http.MethodDelete, path.Join(userDropLinksPath, link.LinkID), nil)
+	setBearerForReq(req, token)
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+	req, _ = http.NewRequest(http.MethodPost, path.Join(dropLinksPath, link.LinkID)+"?name=file4", bytes.NewBuffer([]byte("data")))
+	rr = executeRequest(req)
+	checkResponseCode(t, http.StatusNotFound, rr)
+
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+}
+
 func TestGetFilesSFTPBackend(t *testing.T) {
 	user, _, err := httpdtest.AddUser(getTestUser(), http.StatusCreated)
 	assert.NoError(t, err)
@@ -6323,6 +7433,10 @@ func TestUserTemplateMock(t *testing.T) {
 	form.Set("s3_access_key", "%username%")
 	form.Set("s3_access_secret", "%password%")
 	form.Set("s3_key_prefix", "base/%username%")
+	form.Set("s3_tags", "team=dev\ninvalid\n=value")
+	form.Set("s3_upload_rules", "*.bak::GLACIER_IR::retention=long, team=ops\n/logs/*::STANDARD_IA\n::invalid")
+	form.Set("versioning_mode", "2")
+	form.Set("versioning_max_versions", "0")
 	form.Set("allowed_extensions", "/dir1::.jpg,.png")
 	form.Set("denied_extensions", "/dir2::.zip")
 	form.Set("max_upload_file_size", "0")
@@ -6338,6 +7452,9 @@ func TestUserTemplateMock(t *testing.T) {
 	rr := executeRequest(req)
 	checkResponseCode(t, http.StatusBadRequest, rr)
 	form.Set("s3_upload_part_size", strconv.FormatInt(user.FsConfig.S3Config.UploadPartSize, 10))
+	form.Set("s3_download_part_size", strconv.FormatInt(user.FsConfig.S3Config.DownloadPartSize, 10))
+	form.Set("s3_download_concurrency", strconv.Itoa(user.FsConfig.S3Config.DownloadConcurrency))
+	form.Set("s3_upload_max_buffer_size", strconv.FormatInt(user.FsConfig.S3Config.UploadMaxBufferSize, 10))
 	form.Set("s3_upload_concurrency", strconv.Itoa(user.FsConfig.S3Config.UploadConcurrency))
 
 	b, contentType, _ = getMultipartFormData(form, "", "")
@@ -6392,6 +7509,15 @@ func TestUserTemplateMock(t *testing.T) {
 	require.Equal(t, user2.Username, user2.FsConfig.S3Config.AccessKey)
 	require.Equal(t, path.Join("base", user1.Username)+"/", user1.FsConfig.S3Config.KeyPrefix)
 	require.Equal(t, path.Join("base", user2.Username)+"/", user2.FsConfig.S3Config.KeyPrefix)
+	require.Equal(t, map[string]string{"team": "dev", "invalid": ""}, user1.FsConfig.S3Config.Tags)
+	require.Len(t, user1.FsConfig.S3Config.UploadRules, 2)
+	require.Equal(t, "*.bak", user1.FsConfig.S3Config.UploadRules[0].Pattern)
+	require.Equal(t, "GLACIER_IR", user1.FsConfig.S3Config.UploadRules[0].StorageClass)
+	require.Equal(t, map[string]string{"retention": "long", "team": "ops"}, user1.FsConfig.S3Config.UploadRules[0].Tags)
+	require.Equal(t, "/logs/*", user1.FsConfig.S3Config.UploadRules[1].Pattern)
+	require.Equal(t, "STANDARD_IA", user1.FsConfig.S3Config.UploadRules[1].StorageClass)
+	require.Len(t, user1.FsConfig.S3Config.UploadRules[1].Tags, 0)
+	require.Equal(t, vfs.VersioningModeBucket, user1.FsConfig.Versioning.Mode)
 	require.True(t, user1.FsConfig.S3Config.AccessSecret.IsEncrypted())
 	err = user1.FsConfig.S3Config.AccessSecret.Decrypt()
 	require.NoError(t, err)
@@ -6479,6 +7605,9 @@ func TestFolderTemplateMock(t *testing.T) {
 
 	form.Set("s3_upload_part_size", "5")
 	form.Set("s3_upload_concurrency", "4")
+	form.Set("s3_download_part_size", "6")
+	form.Set("s3_download_concurrency", "2")
+	form.Set("s3_upload_max_buffer_size", "0")
 	req, _ = http.NewRequest(http.MethodPost, webTemplateFolder, bytes.NewBuffer([]byte(form.Encode())))
 	setJWTCookieForReq(req, token)
 	req.Header.Set("Content-Type", contentType)
@@ -6553,6 +7682,9 @@ func TestWebUserS3Mock(t *testing.T) {
 	user.FsConfig.S3Config.KeyPrefix = "somedir/subdir/"
 	user.FsConfig.S3Config.UploadPartSize = 5
 	user.FsConfig.S3Config.UploadConcurrency = 4
+	user.FsConfig.S3Config.DownloadPartSize = 6
+	user.FsConfig.S3Config.DownloadConcurrency = 3
+	user.FsConfig.S3Config.UploadMaxBufferSize = 20
 	user.Description = "s3 tèst user"
 	form := make(url.Values)
 	form.Set(csrfFormToken, csrfToken)
@@ -6595,6 +7727,9 @@ func TestWebUserS3Mock(t *testing.T) {
 	checkResponseCode(t, http.StatusOK, rr)
 	// test invalid s3_concurrency
 	form.Set("s3_upload_part_size", strconv.FormatInt(user.FsConfig.S3Config.UploadPartSize, 10))
+	form.Set("s3_download_part_size", strconv.FormatInt(user.FsConfig.S3Config.DownloadPartSize, 10))
+	form.Set("s3_download_concurrency", strconv.Itoa(user.FsConfig.S3Config.DownloadConcurrency))
+	form.Set("s3_upload_max_buffer_size", strconv.FormatInt(user.FsConfig.S3Config.UploadMaxBufferSize, 10))
 	form.Set("s3_upload_concurrency", "a")
 	b, contentType, _ = getMultipartFormData(form, "", "")
 	req, _ = http.NewRequest(http.MethodPost, path.Join(webUserPath, user.Username), &b)
@@ -6626,6 +7761,9 @@ func TestWebUserS3Mock(t *testing.T) {
 	assert.Equal(t, updateUser.FsConfig.S3Config.KeyPrefix, user.FsConfig.S3Config.KeyPrefix)
 	assert.Equal(t, updateUser.FsConfig.S3Config.UploadPartSize, user.FsConfig.S3Config.UploadPartSize)
 	assert.Equal(t, updateUser.FsConfig.S3Config.UploadConcurrency, user.FsConfig.S3Config.UploadConcurrency)
+	assert.Equal(t, updateUser.FsConfig.S3Config.DownloadPartSize, user.FsConfig.S3Config.DownloadPartSize)
+	assert.Equal(t, updateUser.FsConfig.S3Config.DownloadConcurrency, user.FsConfig.S3Config.DownloadConcurrency)
+	assert.Equal(t, updateUser.FsConfig.S3Config.UploadMaxBufferSize, user.FsConfig.S3Config.UploadMaxBufferSize)
 	assert.Equal(t, 2, len(updateUser.Filters.FileExtensions))
 	assert.Equal(t, kms.SecretStatusSecretBox, updateUser.FsConfig.S3Config.AccessSecret.GetStatus())
 	assert.NotEmpty(t, updateUser.FsConfig.S3Config.AccessSecret.GetPayload())
@@ -7212,6 +8350,8 @@ func TestS3WebFolderMock(t *testing.T) {
 	S3KeyPrefix := "somedir/subdir/"
 	S3UploadPartSize := 5
 	S3UploadConcurrency := 4
+	S3DownloadPartSize := 6
+	S3DownloadConcurrency := 3
 	form := make(url.Values)
 	form.Set("mapped_path", mappedPath)
 	form.Set("name", folderName)
@@ -7225,6 +8365,9 @@ func TestS3WebFolderMock(t *testing.T) {
 	form.Set("s3_endpoint", S3Endpoint)
 	form.Set("s3_key_prefix", S3KeyPrefix)
 	form.Set("s3_upload_part_size", strconv.Itoa(S3UploadPartSize))
+	form.Set("s3_download_part_size", strconv.Itoa(S3DownloadPartSize))
+	form.Set("s3_download_concurrency", strconv.Itoa(S3DownloadConcurrency))
+	form.Set("s3_upload_max_buffer_size", "20")
 	form.Set("s3_upload_concurrency", "a")
 	form.Set(csrfFormToken, csrfToken)
 	b, contentType, err := getMultipartFormData(form, "", "")
@@ -7266,6 +8409,9 @@ func TestS3WebFolderMock(t *testing.T) {
 	assert.Equal(t, S3KeyPrefix, folder.FsConfig.S3Config.KeyPrefix)
 	assert.Equal(t, S3UploadConcurrency, folder.FsConfig.S3Config.UploadConcurrency)
 	assert.Equal(t, int64(S3UploadPartSize), folder.FsConfig.S3Config.UploadPartSize)
+	assert.Equal(t, S3DownloadConcurrency, folder.FsConfig.S3Config.DownloadConcurrency)
+	assert.Equal(t, int64(20), folder.FsConfig.S3Config.UploadMaxBufferSize)
+	assert.Equal(t, int64(S3DownloadPartSize), folder.FsConfig.S3Config.DownloadPartSize)
 	// update
 	S3UploadConcurrency = 10
 	form.Set("s3_upload_concurrency", "b")
@@ -7752,6 +8898,21 @@ func getJWTAPITokenFromTestServer(username, password string) (string, error) {
 	return responseHolder["access_token"].(string), nil
 }
 
+func getJWTAPIUserTokenFromTestServer(username, password string) (string, error) {
+	req, _ := http.NewRequest(http.MethodGet, userTokenPath, nil)
+	req.SetBasicAuth(username, password)
+	rr := executeRequest(req)
+	if rr.Code != http.StatusOK {
+		return "", fmt.Errorf("unexpected  status code %v", rr)
+	}
+	responseHolder := make(map[string]interface{})
+	err := render.DecodeJSON(rr.Body, &responseHolder)
+	if err != nil {
+		return "", err
+	}
+	return responseHolder["access_token"].(string), nil
+}
+
 func getJWTWebToken(username, password string) (string, error) {
 	csrfToken, err := getCSRFToken(httpBaseURL + webLoginPath)
 	if err != nil {
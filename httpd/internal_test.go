@@ -1306,3 +1306,148 @@ func TestManageKeysInvalidClaims(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, rr.Code)
 	assert.Contains(t, rr.Body.String(), "Invalid token claims")
 }
+
+func TestApprovalWorkflow(t *testing.T) {
+	requireApproval = true
+	defer func() {
+		requireApproval = false
+	}()
+
+	tokenAuth := jwtauth.New(jwa.HS256.String(), utils.GenerateRandomBytes(32), nil)
+	getRequest := func(method, target, admin string, permissions []string, urlParams map[string]string,
+		body []byte) *http.Request {
+		c := jwtTokenClaims{
+			Username:    admin,
+			Permissions: permissions,
+		}
+		token, _, err := tokenAuth.Encode(c.asMap())
+		require.NoError(t, err)
+		req, err := http.NewRequest(method, target, bytes.NewBuffer(body))
+		require.NoError(t, err)
+		rctx := chi.NewRouteContext()
+		for k, v := range urlParams {
+			rctx.URLParams.Add(k, v)
+		}
+		ctx := context.WithValue(req.Context(), chi.RouteCtxKey, rctx)
+		return req.WithContext(jwtauth.NewContext(ctx, token, nil))
+	}
+
+	folder := vfs.BaseVirtualFolder{
+		Name:       "approval_folder",
+		MappedPath: filepath.Join(os.TempDir(), "approval_folder"),
+	}
+	err := dataprovider.AddFolder(&folder)
+	require.NoError(t, err)
+
+	params := map[string]string{"name": folder.Name}
+	rr := httptest.NewRecorder()
+	deleteFolder(rr, getRequest(http.MethodDelete, path.Join(folderPath, folder.Name), "admin1",
+		[]string{dataprovider.PermAdminDeleteUsers}, params, nil))
+	assert.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+	location := rr.Header().Get("Location")
+	assert.True(t, strings.HasPrefix(location, approvalsPath+"/"))
+	requestID := strings.TrimPrefix(location, approvalsPath+"/")
+	// the folder is not deleted until approved
+	_, err = dataprovider.GetFolderByName(folder.Name)
+	assert.NoError(t, err)
+
+	rr = httptest.NewRecorder()
+	deleteFolder(rr, getRequest(http.MethodDelete, path.Join(folderPath, "missing"), "admin1",
+		[]string{dataprovider.PermAdminDeleteUsers}, map[string]string{"name": "missing"}, nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+
+	params = map[string]string{"id": requestID}
+	rr = httptest.NewRecorder()
+	getApprovalByID(rr, getRequest(http.MethodGet, location, "admin1", []string{dataprovider.PermAdminDeleteUsers},
+		params, nil))
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var approval dataprovider.ApprovalRequest
+	err = json.Unmarshal(rr.Body.Bytes(), &approval)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.ApprovalOperationDeleteFolder, approval.Operation)
+	assert.Equal(t, folder.Name, approval.ObjectName)
+	assert.Equal(t, dataprovider.ApprovalStatusPending, approval.Status)
+	assert.Equal(t, "admin1", approval.RequestedBy)
+
+	rr = httptest.NewRecorder()
+	getApprovals(rr, getRequest(http.MethodGet, approvalsPath, "admin3", []string{dataprovider.PermAdminQuotaScans},
+		nil, nil))
+	assert.Equal(t, http.StatusForbidden, rr.Code, rr.Body.String())
+	// self approval is not allowed
+	rr = httptest.NewRecorder()
+	approveRequest(rr, getRequest(http.MethodPost, location+"/approve", "admin1", []string{dataprovider.PermAdminAny},
+		params, nil))
+	assert.Equal(t, http.StatusForbidden, rr.Code, rr.Body.String())
+	// the approving admin must have the permission for the operation
+	rr = httptest.NewRecorder()
+	approveRequest(rr, getRequest(http.MethodPost, location+"/approve", "admin2", []string{dataprovider.PermAdminViewUsers},
+		params, nil))
+	assert.Equal(t, http.StatusForbidden, rr.Code, rr.Body.String())
+	rr = httptest.NewRecorder()
+	approveRequest(rr, getRequest(http.MethodPost, location+"/approve", "admin2", []string{dataprovider.PermAdminDeleteUsers},
+		params, nil))
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	_, err = dataprovider.GetFolderByName(folder.Name)
+	assert.Error(t, err)
+	// a request can be resolved only once
+	rr = httptest.NewRecorder()
+	rejectRequest(rr, getRequest(http.MethodPost, location+"/reject", "admin2", []string{dataprovider.PermAdminDeleteUsers},
+		params, nil))
+	assert.Equal(t, http.StatusConflict, rr.Code, rr.Body.String())
+	approval, err = dataprovider.ApprovalRequestExists(requestID)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.ApprovalStatusExecuted, approval.Status)
+	assert.Equal(t, "admin2", approval.ResolvedBy)
+	assert.Greater(t, approval.ResolvedAt, int64(0))
+	assert.Empty(t, approval.Error)
+
+	backup := dataprovider.BackupData{
+		Folders: []vfs.BaseVirtualFolder{folder},
+		Version: dataprovider.DumpVersion,
+	}
+	asJSON, err := json.Marshal(backup)
+	assert.NoError(t, err)
+	rr = httptest.NewRecorder()
+	loadDataFromRequest(rr, getRequest(http.MethodPost, loadDataPath, "admin1",
+		[]string{dataprovider.PermAdminManageSystem}, nil, asJSON))
+	assert.Equal(t, http.StatusAccepted, rr.Code, rr.Body.String())
+	location = rr.Header().Get("Location")
+	requestID = strings.TrimPrefix(location, approvalsPath+"/")
+	approval, err = dataprovider.ApprovalRequestExists(requestID)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.ApprovalOperationRestoreBackup, approval.Operation)
+	assert.NotEmpty(t, approval.Payload)
+
+	params = map[string]string{"id": requestID}
+	rr = httptest.NewRecorder()
+	rejectRequest(rr, getRequest(http.MethodPost, location+"/reject", "admin2", []string{dataprovider.PermAdminDeleteUsers},
+		params, nil))
+	assert.Equal(t, http.StatusForbidden, rr.Code, rr.Body.String())
+	rr = httptest.NewRecorder()
+	rejectRequest(rr, getRequest(http.MethodPost, location+"/reject", "admin2", []string{dataprovider.PermAdminManageSystem},
+		params, nil))
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	_, err = dataprovider.GetFolderByName(folder.Name)
+	assert.Error(t, err)
+	approval, err = dataprovider.ApprovalRequestExists(requestID)
+	assert.NoError(t, err)
+	assert.Equal(t, dataprovider.ApprovalStatusRejected, approval.Status)
+	assert.Empty(t, approval.Payload)
+
+	rr = httptest.NewRecorder()
+	getApprovals(rr, getRequest(http.MethodGet, approvalsPath, "admin3", []string{dataprovider.PermAdminManageSystem},
+		nil, nil))
+	assert.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var approvals []dataprovider.ApprovalRequest
+	err = json.Unmarshal(rr.Body.Bytes(), &approvals)
+	assert.NoError(t, err)
+	if assert.GreaterOrEqual(t, len(approvals), 2) {
+		assert.Equal(t, requestID, approvals[0].ID)
+		assert.Empty(t, approvals[0].Payload)
+	}
+
+	rr = httptest.NewRecorder()
+	approveRequest(rr, getRequest(http.MethodPost, approvalsPath+"/missing/approve", "admin2",
+		[]string{dataprovider.PermAdminAny}, map[string]string{"id": "missing"}, nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, rr.Body.String())
+}
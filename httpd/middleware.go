@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/jwtauth/v5"
 	"github.com/lestrrat-go/jwx/jwt"
 
@@ -47,7 +48,7 @@ func validateJWTToken(w http.ResponseWriter, r *http.Request, audience tokenAudi
 
 	if err != nil || token == nil {
 		logger.Debug(logSender, "", "error getting jwt token: %v", err)
-		if audience == tokenAudienceAPI {
+		if audience == tokenAudienceAPI || audience == tokenAudienceAPIUser {
 			sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		} else {
 			http.Redirect(w, r, redirectPath, http.StatusFound)
@@ -58,7 +59,7 @@ func validateJWTToken(w http.ResponseWriter, r *http.Request, audience tokenAudi
 	err = jwt.Validate(token)
 	if err != nil {
 		logger.Debug(logSender, "", "error validating jwt token: %v", err)
-		if audience == tokenAudienceAPI {
+		if audience == tokenAudienceAPI || audience == tokenAudienceAPIUser {
 			sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		} else {
 			http.Redirect(w, r, redirectPath, http.StatusFound)
@@ -67,7 +68,7 @@ func validateJWTToken(w http.ResponseWriter, r *http.Request, audience tokenAudi
 	}
 	if !utils.IsStringInSlice(audience, token.Audience()) {
 		logger.Debug(logSender, "", "the token is not valid for audience %#v", audience)
-		if audience == tokenAudienceAPI {
+		if audience == tokenAudienceAPI || audience == tokenAudienceAPIUser {
 			sendAPIResponse(w, r, nil, "Your token audience is not valid", http.StatusUnauthorized)
 		} else {
 			http.Redirect(w, r, redirectPath, http.StatusFound)
@@ -76,7 +77,7 @@ func validateJWTToken(w http.ResponseWriter, r *http.Request, audience tokenAudi
 	}
 	if isTokenInvalidated(r) {
 		logger.Debug(logSender, "", "the token has been invalidated")
-		if audience == tokenAudienceAPI {
+		if audience == tokenAudienceAPI || audience == tokenAudienceAPIUser {
 			sendAPIResponse(w, r, nil, "Your token is no longer valid", http.StatusUnauthorized)
 		} else {
 			http.Redirect(w, r, redirectPath, http.StatusFound)
@@ -97,6 +98,17 @@ func jwtAuthenticatorAPI(next http.Handler) http.Handler {
 	})
 }
 
+func jwtAuthenticatorAPIUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := validateJWTToken(w, r, tokenAudienceAPIUser); err != nil {
+			return
+		}
+
+		// Token is authenticated, pass it through
+		next.ServeHTTP(w, r)
+	})
+}
+
 func jwtAuthenticatorWebAdmin(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if err := validateJWTToken(w, r, tokenAudienceWebAdmin); err != nil {
@@ -140,6 +152,19 @@ func checkClientPerm(perm string) func(next http.Handler) http.Handler {
 	}
 }
 
+// denyImpersonation denies the requests from the web client sessions started
+// by an admin impersonating the user
+func denyImpersonation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if impersonator := getImpersonatorFromToken(r); impersonator != "" {
+			renderClientForbiddenPage(w, r, "This action is not allowed while impersonating a user")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func checkPerm(perm string) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -169,6 +194,35 @@ func checkPerm(perm string) func(next http.Handler) http.Handler {
 	}
 }
 
+// auditAdminRequest logs the admin requests that can modify the server state
+// to the audit log. GET and HEAD requests are skipped, except for the backup
+// and restore ones
+func auditAdminRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !logger.IsAuditLogEnabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if r.URL.Path != dumpDataPath && r.URL.Path != loadDataPath {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			var admin string
+			if claims, err := getTokenClaims(r); err == nil {
+				admin = claims.Username
+			}
+			logger.AuditAdminRequest(admin, utils.GetIPFromRemoteAddress(r.RemoteAddr), r.Method, r.RequestURI,
+				middleware.GetReqID(r.Context()), ww.Status())
+		}()
+
+		next.ServeHTTP(ww, r)
+	})
+}
+
 func verifyCSRFHeader(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenString := r.Header.Get(csrfHeaderToken)
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"time"
 
@@ -69,9 +70,17 @@ func (s *httpdServer) listenAndServe() error {
 			httpServer.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
 			httpServer.TLSConfig.VerifyConnection = s.verifyTLSConnection
 		}
-		return utils.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
+		return utils.HTTPListenAndServeWithConnCheck(httpServer, s.binding.Address, s.binding.Port, true, logSender,
+			s.checkConnection)
 	}
-	return utils.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
+	return utils.HTTPListenAndServeWithConnCheck(httpServer, s.binding.Address, s.binding.Port, false, logSender,
+		s.checkConnection)
+}
+
+// checkConnection executes the pre connect hook before reading anything from the connection
+func (s *httpdServer) checkConnection(conn net.Conn) error {
+	ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	return common.Config.ExecutePreConnectHook(ipAddr, common.ProtocolHTTP)
 }
 
 func (s *httpdServer) verifyTLSConnection(state tls.ConnectionState) error {
@@ -134,6 +143,11 @@ func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Re
 		renderClientLoginPage(w, "configured connections limit reached")
 		return
 	}
+	if err := common.CheckLoad(); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolHTTP, "", "connection refused: %v", err)
+		renderClientLoginPage(w, err.Error())
+		return
+	}
 	if common.IsBanned(ipAddr) {
 		renderClientLoginPage(w, "your IP address is banned")
 		return
@@ -144,6 +158,10 @@ func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Re
 		return
 	}
 
+	if err := common.LimitAuthRate(common.ProtocolHTTP, ipAddr); err != nil {
+		renderClientLoginPage(w, err.Error())
+		return
+	}
 	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, common.ProtocolHTTP)
 	if err != nil {
 		updateLoginMetrics(&user, ipAddr, err)
@@ -182,6 +200,58 @@ func (s *httpdServer) handleWebClientLoginPost(w http.ResponseWriter, r *http.Re
 	http.Redirect(w, r, webClientFilesPath, http.StatusFound)
 }
 
+func (s *httpdServer) handleWebImpersonateUser(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	if !s.enableWebClient {
+		sendAPIResponse(w, r, nil, "The web client is disabled", http.StatusBadRequest)
+		return
+	}
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		sendAPIResponse(w, r, err, "Invalid token claims", http.StatusBadRequest)
+		return
+	}
+	var u dataprovider.User
+	err = render.DecodeJSON(r.Body, &u)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", http.StatusBadRequest)
+		return
+	}
+	user, err := dataprovider.UserExists(u.Username)
+	if err != nil {
+		sendAPIResponse(w, r, err, "", getRespStatus(err))
+		return
+	}
+	if user.Status < 1 {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("User %#v is disabled", user.Username), http.StatusBadRequest)
+		return
+	}
+	if user.ExpirationDate > 0 && user.ExpirationDate < utils.GetTimeAsMsSinceEpoch(time.Now()) {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("User %#v is expired", user.Username), http.StatusBadRequest)
+		return
+	}
+	if utils.IsStringInSlice(common.ProtocolHTTP, user.Filters.DeniedProtocols) {
+		sendAPIResponse(w, r, nil, fmt.Sprintf("Protocol HTTP is not allowed for user %#v", user.Username),
+			http.StatusBadRequest)
+		return
+	}
+	c := jwtTokenClaims{
+		Username:     user.Username,
+		Permissions:  user.Filters.WebClient,
+		Signature:    user.GetSignature(),
+		Impersonator: claims.Username,
+	}
+	if err := c.createAndSetCookie(w, r, s.tokenAuth, tokenAudienceWebClient); err != nil {
+		sendAPIResponse(w, r, err, "Unable to create the impersonation session", http.StatusInternalServerError)
+		return
+	}
+	logger.Info(logSender, "", "admin %#v started a web client session impersonating user %#v, remote address: %v",
+		claims.Username, user.Username, r.RemoteAddr)
+	logger.AuditImpersonation(claims.Username, user.Username, utils.GetIPFromRemoteAddress(r.RemoteAddr),
+		logger.AuditImpersonationStart)
+	sendAPIResponse(w, r, nil, "Impersonation session started", http.StatusOK)
+}
+
 func (s *httpdServer) handleWebAdminLoginPost(w http.ResponseWriter, r *http.Request) {
 	r.Body = http.MaxBytesReader(w, r.Body, maxLoginPostSize)
 	if err := r.ParseForm(); err != nil {
@@ -198,19 +268,28 @@ func (s *httpdServer) handleWebAdminLoginPost(w http.ResponseWriter, r *http.Req
 		renderLoginPage(w, err.Error())
 		return
 	}
-	admin, err := dataprovider.CheckAdminAndPass(username, password, utils.GetIPFromRemoteAddress(r.RemoteAddr))
+	if err := common.LimitAuthRate(common.ProtocolHTTP, utils.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
+		renderLoginPage(w, err.Error())
+		return
+	}
+	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	admin, err := dataprovider.CheckAdminAndPass(username, password, ipAddr)
 	if err != nil {
+		logger.AuditAdminLogin(username, ipAddr, dataprovider.LoginMethodPassword, common.ProtocolHTTP, err)
 		renderLoginPage(w, err.Error())
 		return
 	}
 	if connAddr, ok := r.Context().Value(connAddrKey).(string); ok {
 		if connAddr != r.RemoteAddr {
 			if !admin.CanLoginFromIP(utils.GetIPFromRemoteAddress(connAddr)) {
+				logger.AuditAdminLogin(username, ipAddr, dataprovider.LoginMethodPassword, common.ProtocolHTTP,
+					fmt.Errorf("login from IP %v is not allowed", connAddr))
 				renderLoginPage(w, fmt.Sprintf("Login from IP %v is not allowed", connAddr))
 				return
 			}
 		}
 	}
+	logger.AuditAdminLogin(username, ipAddr, dataprovider.LoginMethodPassword, common.ProtocolHTTP, nil)
 	c := jwtTokenClaims{
 		Username:    admin.Username,
 		Permissions: admin.Permissions,
@@ -238,8 +317,14 @@ func (s *httpdServer) getToken(w http.ResponseWriter, r *http.Request) {
 		sendAPIResponse(w, r, nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
 	}
+	if err := common.LimitAuthRate(common.ProtocolHTTP, utils.GetIPFromRemoteAddress(r.RemoteAddr)); err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
 	admin, err := dataprovider.CheckAdminAndPass(username, password, utils.GetIPFromRemoteAddress(r.RemoteAddr))
 	if err != nil {
+		logger.AuditAdminLogin(username, utils.GetIPFromRemoteAddress(r.RemoteAddr), dataprovider.LoginMethodPassword,
+			common.ProtocolHTTP, err)
 		w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
 		sendAPIResponse(w, r, err, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
 		return
@@ -248,15 +333,80 @@ func (s *httpdServer) getToken(w http.ResponseWriter, r *http.Request) {
 	s.checkAddrAndSendToken(w, r, admin)
 }
 
+func (s *httpdServer) getUserToken(w http.ResponseWriter, r *http.Request) {
+	username, password, ok := r.BasicAuth()
+	if !ok || username == "" || password == "" {
+		w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+		sendAPIResponse(w, r, nil, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
+	if common.IsBanned(ipAddr) {
+		sendAPIResponse(w, r, nil, "your IP address is banned", http.StatusForbidden)
+		return
+	}
+	if err := common.Config.ExecutePostConnectHook(ipAddr, common.ProtocolHTTP); err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	if err := common.LimitAuthRate(common.ProtocolHTTP, ipAddr); err != nil {
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+	user, err := dataprovider.CheckUserAndPass(username, password, ipAddr, common.ProtocolHTTP)
+	if err != nil {
+		w.Header().Set(common.HTTPAuthenticationHeader, basicRealm)
+		updateLoginMetrics(&user, ipAddr, err)
+		sendAPIResponse(w, r, dataprovider.ErrInvalidCredentials, http.StatusText(http.StatusUnauthorized),
+			http.StatusUnauthorized)
+		return
+	}
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolHTTP, xid.New().String())
+	if err := checkWebClientUser(&user, r, connectionID); err != nil {
+		updateLoginMetrics(&user, ipAddr, err)
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	defer user.CloseFs() //nolint:errcheck
+	err = user.CheckFsRoot(connectionID)
+	if err != nil {
+		logger.Warn(logSender, connectionID, "unable to check fs root: %v", err)
+		updateLoginMetrics(&user, ipAddr, err)
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	c := jwtTokenClaims{
+		Username:    user.Username,
+		Permissions: user.Filters.WebClient,
+		Signature:   user.GetSignature(),
+	}
+
+	resp, err := c.createTokenResponse(s.tokenAuth, tokenAudienceAPIUser)
+	if err != nil {
+		updateLoginMetrics(&user, ipAddr, err)
+		sendAPIResponse(w, r, err, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	updateLoginMetrics(&user, ipAddr, err)
+	render.JSON(w, r, resp)
+}
+
 func (s *httpdServer) checkAddrAndSendToken(w http.ResponseWriter, r *http.Request, admin dataprovider.Admin) {
 	if connAddr, ok := r.Context().Value(connAddrKey).(string); ok {
 		if connAddr != r.RemoteAddr {
 			if !admin.CanLoginFromIP(utils.GetIPFromRemoteAddress(connAddr)) {
+				logger.AuditAdminLogin(admin.Username, utils.GetIPFromRemoteAddress(r.RemoteAddr),
+					dataprovider.LoginMethodPassword, common.ProtocolHTTP,
+					fmt.Errorf("login from IP %v is not allowed", connAddr))
 				sendAPIResponse(w, r, nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
 				return
 			}
 		}
 	}
+	logger.AuditAdminLogin(admin.Username, utils.GetIPFromRemoteAddress(r.RemoteAddr), dataprovider.LoginMethodPassword,
+		common.ProtocolHTTP, nil)
 
 	c := jwtTokenClaims{
 		Username:    admin.Username,
@@ -287,6 +437,10 @@ func (s *httpdServer) checkCookieExpiration(w http.ResponseWriter, r *http.Reque
 	if time.Until(token.Expiration()) > tokenRefreshMin {
 		return
 	}
+	if tokenClaims.Impersonator != "" {
+		// impersonation sessions are not refreshed, they expire after tokenDuration
+		return
+	}
 	if utils.IsStringInSlice(tokenAudienceWebClient, token.Audience()) {
 		s.refreshClientToken(w, r, tokenClaims)
 	} else {
@@ -391,10 +545,13 @@ func (s *httpdServer) initializeRouter() {
 		}))
 
 		router.Get(tokenPath, s.getToken)
+		router.Get(userTokenPath, s.getUserToken)
+		router.Post(dropLinksPath+"/{id}", uploadToDropLink)
 
 		router.Group(func(router chi.Router) {
 			router.Use(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromHeader))
 			router.Use(jwtAuthenticatorAPI)
+			router.Use(auditAdminRequest)
 
 			router.Get(versionPath, func(w http.ResponseWriter, r *http.Request) {
 				render.JSON(w, r, version.Get())
@@ -424,6 +581,9 @@ func (s *httpdServer) initializeRouter() {
 			router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(userPath+"/{username}", getUserByUsername)
 			router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Put(userPath+"/{username}", updateUser)
 			router.With(checkPerm(dataprovider.PermAdminDeleteUsers)).Delete(userPath+"/{username}", deleteUser)
+			router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(userPath+"/{username}/versions", getUserFileVersions)
+			router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Post(userPath+"/{username}/versions",
+				restoreUserFileVersion)
 			router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(folderPath, getFolders)
 			router.With(checkPerm(dataprovider.PermAdminViewUsers)).Get(folderPath+"/{name}", getFolderByName)
 			router.With(checkPerm(dataprovider.PermAdminAddUsers)).Post(folderPath, addFolder)
@@ -432,16 +592,51 @@ func (s *httpdServer) initializeRouter() {
 			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Get(dumpDataPath, dumpData)
 			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Get(loadDataPath, loadData)
 			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Post(loadDataPath, loadDataFromRequest)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Post(configReloadPath, reloadConfig)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Get(configDumpPath, dumpConfig)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Post(kmsReencryptPath, reencryptSecrets)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Get(logLevelsPath, getLogLevels)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Put(logLevelsPath, updateLogLevels)
 			router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Put(updateUsedQuotaPath, updateUserQuotaUsage)
 			router.With(checkPerm(dataprovider.PermAdminChangeUsers)).Put(updateFolderUsedQuotaPath, updateVFolderQuotaUsage)
 			router.With(checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderBanTime, getBanTime)
 			router.With(checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderScore, getScore)
 			router.With(checkPerm(dataprovider.PermAdminManageDefender)).Post(defenderUnban, unban)
+			router.With(checkPerm(dataprovider.PermAdminViewDefender)).Get(defenderListsPath, getDefenderListEntries)
+			router.With(checkPerm(dataprovider.PermAdminManageDefender)).Post(defenderListsPath, addDefenderListEntry)
+			router.With(checkPerm(dataprovider.PermAdminManageDefender)).Delete(defenderListsPath, deleteDefenderListEntry)
+			router.With(checkPerm(dataprovider.PermAdminManageDefender)).Post(defenderReloadPath, reloadDefender)
+			router.With(checkPerm(dataprovider.PermAdminViewServerStatus)).Get(scheduledJobsPath, getScheduledJobs)
+			router.With(checkPerm(dataprovider.PermAdminManageSystem)).Post(scheduledJobsPath+"/{name}/run",
+				runScheduledJob)
 			router.With(checkPerm(dataprovider.PermAdminManageAdmins)).Get(adminPath, getAdmins)
 			router.With(checkPerm(dataprovider.PermAdminManageAdmins)).Post(adminPath, addAdmin)
 			router.With(checkPerm(dataprovider.PermAdminManageAdmins)).Get(adminPath+"/{username}", getAdminByUsername)
 			router.With(checkPerm(dataprovider.PermAdminManageAdmins)).Put(adminPath+"/{username}", updateAdmin)
 			router.With(checkPerm(dataprovider.PermAdminManageAdmins)).Delete(adminPath+"/{username}", deleteAdmin)
+			router.Get(approvalsPath, getApprovals)
+			router.Get(approvalsPath+"/{id}", getApprovalByID)
+			router.Post(approvalsPath+"/{id}/approve", approveRequest)
+			router.Post(approvalsPath+"/{id}/reject", rejectRequest)
+		})
+
+		router.Group(func(router chi.Router) {
+			router.Use(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromHeader))
+			router.Use(jwtAuthenticatorAPIUser)
+
+			router.Get(userLogoutPath, s.logout)
+			router.Get(userDirsPath, getUserDirContents)
+			router.Post(userDirsPath, createUserDir)
+			router.Patch(userDirsPath, renameUserItem)
+			router.Delete(userDirsPath, deleteUserDir)
+			router.Get(userFilesPath, getUserFile)
+			router.Post(userFilesPath, uploadUserFile)
+			router.Patch(userFilesPath, renameUserItem)
+			router.Delete(userFilesPath, deleteUserFile)
+			router.Get(userDropLinksPath, getUserDropLinks)
+			router.Post(userDropLinksPath, addUserDropLink)
+			router.Get(userDropLinksPath+"/{id}", getUserDropLinkByID)
+			router.Delete(userDropLinksPath+"/{id}", deleteUserDropLink)
 		})
 
 		if s.enableWebAdmin || s.enableWebClient {
@@ -480,8 +675,14 @@ func (s *httpdServer) initializeRouter() {
 				router.Get(webClientLogoutPath, handleWebClientLogout)
 				router.With(s.refreshCookie).Get(webClientFilesPath, handleClientGetFiles)
 				router.With(s.refreshCookie).Get(webClientCredentialsPath, handleClientGetCredentials)
-				router.Post(webChangeClientPwdPath, handleWebClientChangePwdPost)
-				router.With(checkClientPerm(dataprovider.WebClientPubKeyChangeDisabled)).
+				router.With(s.refreshCookie).Get(webClientVersionsPath, handleClientGetFileVersions)
+				router.Post(webClientVersionsPath, handleClientRestoreFileVersion)
+				router.With(s.refreshCookie).Get(webClientTrashPath, handleClientGetTrash)
+				router.Post(webClientTrashPath, handleClientRestoreFromTrash)
+				router.With(s.refreshCookie).Get(webClientArchivedPath, handleClientGetArchivedFiles)
+				router.Post(webClientArchivedPath, handleClientRestoreArchivedFile)
+				router.With(denyImpersonation).Post(webChangeClientPwdPath, handleWebClientChangePwdPost)
+				router.With(denyImpersonation, checkClientPerm(dataprovider.WebClientPubKeyChangeDisabled)).
 					Post(webChangeClientKeysPath, handleWebClientManageKeysPost)
 			})
 		}
@@ -496,6 +697,7 @@ func (s *httpdServer) initializeRouter() {
 			router.Group(func(router chi.Router) {
 				router.Use(jwtauth.Verify(s.tokenAuth, jwtauth.TokenFromCookie))
 				router.Use(jwtAuthenticatorWebAdmin)
+				router.Use(auditAdminRequest)
 
 				router.Get(webLogoutPath, handleWebLogout)
 				router.With(s.refreshCookie).Get(webChangeAdminPwdPath, handleWebAdminChangePwd)
@@ -540,6 +742,8 @@ func (s *httpdServer) initializeRouter() {
 					Delete(webUserPath+"/{username}", deleteUser)
 				router.With(checkPerm(dataprovider.PermAdminQuotaScans), verifyCSRFHeader).
 					Post(webQuotaScanPath, startQuotaScan)
+				router.With(checkPerm(dataprovider.PermAdminImpersonateUsers), verifyCSRFHeader).
+					Post(webImpersonateUserPath, s.handleWebImpersonateUser)
 				router.With(checkPerm(dataprovider.PermAdminManageSystem)).Get(webMaintenancePath, handleWebMaintenance)
 				router.With(checkPerm(dataprovider.PermAdminManageSystem)).Get(webBackupPath, dumpData)
 				router.With(checkPerm(dataprovider.PermAdminManageSystem)).Post(webRestorePath, handleWebRestore)
@@ -549,6 +753,9 @@ func (s *httpdServer) initializeRouter() {
 				router.With(checkPerm(dataprovider.PermAdminManageSystem), s.refreshCookie).
 					Get(webTemplateFolder, handleWebTemplateFolderGet)
 				router.With(checkPerm(dataprovider.PermAdminManageSystem)).Post(webTemplateFolder, handleWebTemplateFolderPost)
+				router.With(s.refreshCookie).Get(webApprovalsPath, handleWebGetApprovals)
+				router.With(verifyCSRFHeader).Post(webApprovalsPath+"/{id}/approve", approveRequest)
+				router.With(verifyCSRFHeader).Post(webApprovalsPath+"/{id}/reject", rejectRequest)
 			})
 		}
 	})
@@ -54,6 +54,7 @@ const (
 	templateLogin        = "login.html"
 	templateChangePwd    = "changepwd.html"
 	templateMaintenance  = "maintenance.html"
+	templateApprovals    = "approvals.html"
 	pageUsersTitle       = "Users"
 	pageAdminsTitle      = "Admins"
 	pageConnectionsTitle = "Connections"
@@ -61,6 +62,7 @@ const (
 	pageFoldersTitle     = "Folders"
 	pageChangePwdTitle   = "Change password"
 	pageMaintenanceTitle = "Maintenance"
+	pageApprovalsTitle   = "Approvals"
 	defaultQueryLimit    = 500
 )
 
@@ -77,6 +79,8 @@ type basePage struct {
 	AdminsURL          string
 	AdminURL           string
 	QuotaScanURL       string
+	ImpersonateURL     string
+	WebClientURL       string
 	ConnectionsURL     string
 	FoldersURL         string
 	FolderURL          string
@@ -86,6 +90,7 @@ type basePage struct {
 	FolderQuotaScanURL string
 	StatusURL          string
 	MaintenanceURL     string
+	ApprovalsURL       string
 	StaticURL          string
 	UsersTitle         string
 	AdminsTitle        string
@@ -93,6 +98,8 @@ type basePage struct {
 	FoldersTitle       string
 	StatusTitle        string
 	MaintenanceTitle   string
+	ApprovalsTitle     string
+	ApprovalsEnabled   bool
 	Version            string
 	CSRFToken          string
 	LoggedAdmin        *dataprovider.Admin
@@ -118,6 +125,11 @@ type connectionsPage struct {
 	Connections []*common.ConnectionStatus
 }
 
+type approvalsPage struct {
+	basePage
+	Approvals []dataprovider.ApprovalRequest
+}
+
 type statusPage struct {
 	basePage
 	Status ServicesStatus
@@ -225,6 +237,10 @@ func loadAdminTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateAdminDir, templateBase),
 		filepath.Join(templatesPath, templateAdminDir, templateMaintenance),
 	}
+	approvalsPath := []string{
+		filepath.Join(templatesPath, templateAdminDir, templateBase),
+		filepath.Join(templatesPath, templateAdminDir, templateApprovals),
+	}
 	usersTmpl := utils.LoadTemplate(template.ParseFiles(usersPaths...))
 	userTmpl := utils.LoadTemplate(template.ParseFiles(userPaths...))
 	adminsTmpl := utils.LoadTemplate(template.ParseFiles(adminsPaths...))
@@ -237,6 +253,7 @@ func loadAdminTemplates(templatesPath string) {
 	loginTmpl := utils.LoadTemplate(template.ParseFiles(loginPath...))
 	changePwdTmpl := utils.LoadTemplate(template.ParseFiles(changePwdPaths...))
 	maintenanceTmpl := utils.LoadTemplate(template.ParseFiles(maintenancePath...))
+	approvalsTmpl := utils.LoadTemplate(template.ParseFiles(approvalsPath...))
 
 	adminTemplates[templateUsers] = usersTmpl
 	adminTemplates[templateUser] = userTmpl
@@ -250,6 +267,7 @@ func loadAdminTemplates(templatesPath string) {
 	adminTemplates[templateLogin] = loginTmpl
 	adminTemplates[templateChangePwd] = changePwdTmpl
 	adminTemplates[templateMaintenance] = maintenanceTmpl
+	adminTemplates[templateApprovals] = approvalsTmpl
 }
 
 func getBasePageData(title, currentURL string, r *http.Request) basePage {
@@ -271,10 +289,13 @@ func getBasePageData(title, currentURL string, r *http.Request) basePage {
 		LogoutURL:          webLogoutPath,
 		ChangeAdminPwdURL:  webChangeAdminPwdPath,
 		QuotaScanURL:       webQuotaScanPath,
+		ImpersonateURL:     webImpersonateUserPath,
+		WebClientURL:       webClientFilesPath,
 		ConnectionsURL:     webConnectionsPath,
 		StatusURL:          webStatusPath,
 		FolderQuotaScanURL: webScanVFolderPath,
 		MaintenanceURL:     webMaintenancePath,
+		ApprovalsURL:       webApprovalsPath,
 		StaticURL:          webStaticFilesPath,
 		UsersTitle:         pageUsersTitle,
 		AdminsTitle:        pageAdminsTitle,
@@ -282,6 +303,8 @@ func getBasePageData(title, currentURL string, r *http.Request) basePage {
 		FoldersTitle:       pageFoldersTitle,
 		StatusTitle:        pageStatusTitle,
 		MaintenanceTitle:   pageMaintenanceTitle,
+		ApprovalsTitle:     pageApprovalsTitle,
+		ApprovalsEnabled:   requireApproval,
 		Version:            version.GetAsString(),
 		LoggedAdmin:        getAdminFromToken(r),
 		CSRFToken:          csrfToken,
@@ -564,6 +587,32 @@ func getFilePatternsFromPostField(valueAllowed, valuesDenied string) []dataprovi
 	return result
 }
 
+func getOperationsFromPostField(valueAllowed, valuesDenied string) []dataprovider.OperationsFilter {
+	var result []dataprovider.OperationsFilter
+	allowedOperations := getListFromPostFields(valueAllowed)
+	deniedOperations := getListFromPostFields(valuesDenied)
+
+	for dirAllowed, allowedOps := range allowedOperations {
+		filter := dataprovider.OperationsFilter{
+			Path:              dirAllowed,
+			AllowedOperations: allowedOps,
+		}
+		if deniedOps, ok := deniedOperations[dirAllowed]; ok {
+			filter.DeniedOperations = deniedOps
+		}
+		result = append(result, filter)
+	}
+	for dirDenied, deniedOps := range deniedOperations {
+		if _, ok := allowedOperations[dirDenied]; !ok {
+			result = append(result, dataprovider.OperationsFilter{
+				Path:             dirDenied,
+				DeniedOperations: deniedOps,
+			})
+		}
+	}
+	return result
+}
+
 func getFileExtensionsFromPostField(valueAllowed, valuesDenied string) []dataprovider.ExtensionsFilter {
 	var result []dataprovider.ExtensionsFilter
 	allowedExtensions := getListFromPostFields(valueAllowed)
@@ -600,6 +649,74 @@ func getFileExtensionsFromPostField(valueAllowed, valuesDenied string) []datapro
 	return result
 }
 
+// getDirQuotasFromPostField parses lines in the format
+// "/dir::[quota_size(bytes)]::[quota_files]"
+func getDirQuotasFromPostField(value string) ([]dataprovider.DirQuota, error) {
+	var quotas []dataprovider.DirQuota
+	for _, cleaned := range getSliceFromDelimitedValues(value, "\n") {
+		if strings.Contains(cleaned, "::") {
+			fields := strings.Split(cleaned, "::")
+			quota := dataprovider.DirQuota{
+				Path: strings.TrimSpace(fields[0]),
+			}
+			if quota.Path == "" {
+				continue
+			}
+			if size := strings.TrimSpace(fields[1]); size != "" {
+				quotaSize, err := strconv.ParseInt(size, 10, 64)
+				if err != nil {
+					return quotas, fmt.Errorf("invalid quota size for directory %#v: %w", quota.Path, err)
+				}
+				quota.QuotaSize = quotaSize
+			}
+			if len(fields) > 2 {
+				if files := strings.TrimSpace(fields[2]); files != "" {
+					quotaFiles, err := strconv.Atoi(files)
+					if err != nil {
+						return quotas, fmt.Errorf("invalid quota files for directory %#v: %w", quota.Path, err)
+					}
+					quota.QuotaFiles = quotaFiles
+				}
+			}
+			quotas = append(quotas, quota)
+		}
+	}
+	return quotas, nil
+}
+
+// getRetentionRulesFromPostField parses lines in the format
+// "/dir::days::[patterns comma separated]::[archive path]"
+func getRetentionRulesFromPostField(value string) ([]dataprovider.RetentionRule, error) {
+	var rules []dataprovider.RetentionRule
+	for _, cleaned := range getSliceFromDelimitedValues(value, "\n") {
+		if strings.Contains(cleaned, "::") {
+			fields := strings.Split(cleaned, "::")
+			rule := dataprovider.RetentionRule{
+				Path: strings.TrimSpace(fields[0]),
+			}
+			if rule.Path == "" {
+				continue
+			}
+			days, err := strconv.Atoi(strings.TrimSpace(fields[1]))
+			if err != nil {
+				return rules, fmt.Errorf("invalid retention days for directory %#v: %w", rule.Path, err)
+			}
+			rule.Days = days
+			if len(fields) > 2 {
+				rule.Patterns = getSliceFromDelimitedValues(fields[2], ",")
+			}
+			if len(fields) > 3 {
+				rule.ArchivePath = strings.TrimSpace(fields[3])
+			}
+			if len(fields) > 4 {
+				rule.ArchiveFolder = strings.TrimSpace(fields[4])
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
 func getFiltersFromUserPostFields(r *http.Request) dataprovider.UserFilters {
 	var filters dataprovider.UserFilters
 	filters.AllowedIP = getSliceFromDelimitedValues(r.Form.Get("allowed_ip"), ",")
@@ -608,6 +725,8 @@ func getFiltersFromUserPostFields(r *http.Request) dataprovider.UserFilters {
 	filters.DeniedProtocols = r.Form["denied_protocols"]
 	filters.FileExtensions = getFileExtensionsFromPostField(r.Form.Get("allowed_extensions"), r.Form.Get("denied_extensions"))
 	filters.FilePatterns = getFilePatternsFromPostField(r.Form.Get("allowed_patterns"), r.Form.Get("denied_patterns"))
+	filters.Operations = getOperationsFromPostField(r.Form.Get("allowed_operations"), r.Form.Get("denied_operations"))
+	filters.Metadata = getKeyValuesFromPostField(r.Form.Get("metadata"), "\n")
 	filters.TLSUsername = dataprovider.TLSUsername(r.Form.Get("tls_username"))
 	filters.WebClient = r.Form["web_client_options"]
 	hooks := r.Form["hooks"]
@@ -621,6 +740,9 @@ func getFiltersFromUserPostFields(r *http.Request) dataprovider.UserFilters {
 		filters.Hooks.CheckPasswordDisabled = true
 	}
 	filters.DisableFsChecks = len(r.Form.Get("disable_fs_checks")) > 0
+	filters.CaseInsensitive = len(r.Form.Get("case_insensitive")) > 0
+	filters.Impersonate = len(r.Form.Get("impersonate")) > 0
+	filters.Trash.Enabled = len(r.Form.Get("trash_enabled")) > 0
 	return filters
 }
 
@@ -635,6 +757,50 @@ func getSecretFromFormField(r *http.Request, field string) *kms.Secret {
 	return secret
 }
 
+func getKeyValuesFromPostField(value, delimiter string) map[string]string {
+	var tags map[string]string
+	for _, cleaned := range getSliceFromDelimitedValues(value, delimiter) {
+		keyValue := strings.SplitN(cleaned, "=", 2)
+		key := strings.TrimSpace(keyValue[0])
+		if key == "" {
+			continue
+		}
+		var val string
+		if len(keyValue) > 1 {
+			val = strings.TrimSpace(keyValue[1])
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[key] = val
+	}
+	return tags
+}
+
+// getS3UploadRulesFromPostField parses lines in the format
+// "pattern::storage class::key1=value1,key2=value2"
+func getS3UploadRulesFromPostField(value string) []vfs.S3UploadRule {
+	var rules []vfs.S3UploadRule
+	for _, cleaned := range getSliceFromDelimitedValues(value, "\n") {
+		if strings.Contains(cleaned, "::") {
+			fields := strings.Split(cleaned, "::")
+			pattern := strings.TrimSpace(fields[0])
+			if pattern == "" {
+				continue
+			}
+			rule := vfs.S3UploadRule{
+				Pattern:      pattern,
+				StorageClass: strings.TrimSpace(fields[1]),
+			}
+			if len(fields) > 2 {
+				rule.Tags = getKeyValuesFromPostField(fields[2], ",")
+			}
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
 func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	var err error
 	config := vfs.S3FsConfig{}
@@ -645,14 +811,61 @@ func getS3Config(r *http.Request) (vfs.S3FsConfig, error) {
 	config.Endpoint = r.Form.Get("s3_endpoint")
 	config.StorageClass = r.Form.Get("s3_storage_class")
 	config.KeyPrefix = r.Form.Get("s3_key_prefix")
+	config.SSE = r.Form.Get("s3_sse")
+	config.SSEKMSKeyID = r.Form.Get("s3_sse_kms_key_id")
+	config.SSECustomerKey = getSecretFromFormField(r, "s3_sse_customer_key")
+	config.Tags = getKeyValuesFromPostField(r.Form.Get("s3_tags"), "\n")
+	config.UploadRules = getS3UploadRulesFromPostField(r.Form.Get("s3_upload_rules"))
 	config.UploadPartSize, err = strconv.ParseInt(r.Form.Get("s3_upload_part_size"), 10, 64)
 	if err != nil {
 		return config, err
 	}
 	config.UploadConcurrency, err = strconv.Atoi(r.Form.Get("s3_upload_concurrency"))
+	if err != nil {
+		return config, err
+	}
+	config.UploadMaxBufferSize, err = strconv.ParseInt(r.Form.Get("s3_upload_max_buffer_size"), 10, 64)
+	if err != nil {
+		return config, err
+	}
+	config.DownloadPartSize, err = strconv.ParseInt(r.Form.Get("s3_download_part_size"), 10, 64)
+	if err != nil {
+		return config, err
+	}
+	config.DownloadConcurrency, err = strconv.Atoi(r.Form.Get("s3_download_concurrency"))
+	if err != nil {
+		return config, err
+	}
+	config.CloudRequestConfig, err = getCloudRequestConfig(r, "s3")
 	return config, err
 }
 
+// getCloudRequestConfig returns the retries and timeouts for the cloud backend with
+// the given form fields prefix, empty fields means the default values
+func getCloudRequestConfig(r *http.Request, prefix string) (vfs.CloudRequestConfig, error) {
+	config := vfs.CloudRequestConfig{}
+	values := []struct {
+		field string
+		value *int
+	}{
+		{field: prefix + "_max_retries", value: &config.MaxRetries},
+		{field: prefix + "_retry_backoff", value: &config.RetryBackoff},
+		{field: prefix + "_request_timeout", value: &config.RequestTimeout},
+	}
+	for _, v := range values {
+		formValue := r.Form.Get(v.field)
+		if formValue == "" {
+			continue
+		}
+		val, err := strconv.Atoi(formValue)
+		if err != nil {
+			return config, fmt.Errorf("invalid %v: %w", v.field, err)
+		}
+		*v.value = val
+	}
+	return config, nil
+}
+
 func getGCSConfig(r *http.Request) (vfs.GCSFsConfig, error) {
 	var err error
 	config := vfs.GCSFsConfig{}
@@ -660,6 +873,10 @@ func getGCSConfig(r *http.Request) (vfs.GCSFsConfig, error) {
 	config.Bucket = r.Form.Get("gcs_bucket")
 	config.StorageClass = r.Form.Get("gcs_storage_class")
 	config.KeyPrefix = r.Form.Get("gcs_key_prefix")
+	config.CloudRequestConfig, err = getCloudRequestConfig(r, "gcs")
+	if err != nil {
+		return config, err
+	}
 	autoCredentials := r.Form.Get("gcs_auto_credentials")
 	if autoCredentials != "" {
 		config.AutomaticCredentials = 1
@@ -701,6 +918,17 @@ func getSFTPConfig(r *http.Request) (vfs.SFTPFsConfig, error) {
 	return config, err
 }
 
+func getSMBConfig(r *http.Request) vfs.SMBFsConfig {
+	config := vfs.SMBFsConfig{}
+	config.Endpoint = r.Form.Get("smb_endpoint")
+	config.Share = r.Form.Get("smb_share")
+	config.Username = r.Form.Get("smb_username")
+	config.Password = getSecretFromFormField(r, "smb_password")
+	config.Domain = r.Form.Get("smb_domain")
+	config.Prefix = r.Form.Get("smb_prefix")
+	return config
+}
+
 func getAzureConfig(r *http.Request) (vfs.AzBlobFsConfig, error) {
 	var err error
 	config := vfs.AzBlobFsConfig{}
@@ -754,10 +982,45 @@ func getFsConfigFromPostFields(r *http.Request) (vfs.Filesystem, error) {
 			return fs, err
 		}
 		fs.SFTPConfig = config
+	case vfs.PluginFilesystemProvider:
+		fs.PluginConfig.Endpoint = r.Form.Get("plugin_endpoint")
+		fs.PluginConfig.Options = getSecretFromFormField(r, "plugin_options")
+	case vfs.SMBFilesystemProvider:
+		fs.SMBConfig = getSMBConfig(r)
 	}
+	versioning, err := getVersioningConfig(r)
+	if err != nil {
+		return fs, err
+	}
+	fs.Versioning = versioning
 	return fs, nil
 }
 
+func getVersioningConfig(r *http.Request) (vfs.VersioningConfig, error) {
+	var err error
+	config := vfs.VersioningConfig{}
+	if r.Form.Get("versioning_mode") != "" {
+		config.Mode, err = strconv.Atoi(r.Form.Get("versioning_mode"))
+		if err != nil {
+			return config, err
+		}
+	}
+	if r.Form.Get("versioning_max_versions") != "" {
+		config.MaxVersions, err = strconv.Atoi(r.Form.Get("versioning_max_versions"))
+	}
+	return config, err
+}
+
+// getVersionFromPostFields returns the object version submitted with the form.
+// The current version is returned if the form does not include it
+func getVersionFromPostFields(r *http.Request, currentVersion int64) (int64, error) {
+	version := r.Form.Get("version")
+	if version == "" {
+		return currentVersion, nil
+	}
+	return strconv.ParseInt(version, 10, 64)
+}
+
 func getAdminFromPostFields(r *http.Request) (dataprovider.Admin, error) {
 	var admin dataprovider.Admin
 	err := r.ParseForm()
@@ -804,6 +1067,8 @@ func getFolderFromTemplate(folder vfs.BaseVirtualFolder, name string) vfs.BaseVi
 		folder.FsConfig.AzBlobConfig = getAzBlobFsFromTemplate(folder.FsConfig.AzBlobConfig, replacements)
 	case vfs.SFTPFilesystemProvider:
 		folder.FsConfig.SFTPConfig = getSFTPFsFromTemplate(folder.FsConfig.SFTPConfig, replacements)
+	case vfs.SMBFilesystemProvider:
+		folder.FsConfig.SMBConfig = getSMBFsFromTemplate(folder.FsConfig.SMBConfig, replacements)
 	}
 
 	return folder
@@ -854,6 +1119,16 @@ func getSFTPFsFromTemplate(fsConfig vfs.SFTPFsConfig, replacements map[string]st
 	return fsConfig
 }
 
+func getSMBFsFromTemplate(fsConfig vfs.SMBFsConfig, replacements map[string]string) vfs.SMBFsConfig {
+	fsConfig.Prefix = replacePlaceholders(fsConfig.Prefix, replacements)
+	fsConfig.Username = replacePlaceholders(fsConfig.Username, replacements)
+	if fsConfig.Password != nil && fsConfig.Password.IsPlain() {
+		payload := replacePlaceholders(fsConfig.Password.GetPayload(), replacements)
+		fsConfig.Password = kms.NewPlainSecret(payload)
+	}
+	return fsConfig
+}
+
 func getUserFromTemplate(user dataprovider.User, template userTemplateFields) dataprovider.User {
 	user.Username = template.Username
 	user.Password = template.Password
@@ -888,6 +1163,8 @@ func getUserFromTemplate(user dataprovider.User, template userTemplateFields) da
 		user.FsConfig.AzBlobConfig = getAzBlobFsFromTemplate(user.FsConfig.AzBlobConfig, replacements)
 	case vfs.SFTPFilesystemProvider:
 		user.FsConfig.SFTPConfig = getSFTPFsFromTemplate(user.FsConfig.SFTPConfig, replacements)
+	case vfs.SMBFilesystemProvider:
+		user.FsConfig.SMBConfig = getSMBFsFromTemplate(user.FsConfig.SMBConfig, replacements)
 	}
 
 	return user
@@ -967,6 +1244,26 @@ func getUserFromPostFields(r *http.Request) (dataprovider.User, error) {
 		AdditionalInfo:    r.Form.Get("additional_info"),
 		Description:       r.Form.Get("description"),
 	}
+	if r.Form.Get("trash_retention_days") != "" {
+		user.Filters.Trash.RetentionDays, err = strconv.Atoi(r.Form.Get("trash_retention_days"))
+		if err != nil {
+			return user, err
+		}
+	}
+	if r.Form.Get("max_concurrent_transfers") != "" {
+		user.Filters.MaxConcurrentTransfers, err = strconv.Atoi(r.Form.Get("max_concurrent_transfers"))
+		if err != nil {
+			return user, err
+		}
+	}
+	user.Filters.DirQuotas, err = getDirQuotasFromPostField(r.Form.Get("dir_quotas"))
+	if err != nil {
+		return user, err
+	}
+	user.Filters.Retention, err = getRetentionRulesFromPostField(r.Form.Get("retention_rules"))
+	if err != nil {
+		return user, err
+	}
 	maxFileSize, err := strconv.ParseInt(r.Form.Get("max_upload_file_size"), 10, 64)
 	user.Filters.MaxUploadFileSize = maxFileSize
 	return user, err
@@ -1058,6 +1355,20 @@ func handleWebRestore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if requireApproval {
+		payload, err := getRestoreApprovalPayload(backupContent, "", scanQuota, restoreMode)
+		if err == nil {
+			_, err = createApprovalRequest(r, dataprovider.ApprovalOperationRestoreBackup, "", payload)
+		}
+		if err != nil {
+			renderMaintenancePage(w, r, err.Error())
+			return
+		}
+		renderMessagePage(w, r, "Approval required", "", http.StatusAccepted, nil,
+			"The restore request was saved, it will be executed after the approval of another admin")
+		return
+	}
+
 	if err := restoreBackup(backupContent, "", scanQuota, restoreMode); err != nil {
 		renderMaintenancePage(w, r, err.Error())
 		return
@@ -1154,6 +1465,11 @@ func handleWebUpdateAdminPost(w http.ResponseWriter, r *http.Request) {
 	}
 	updatedAdmin.ID = admin.ID
 	updatedAdmin.Username = admin.Username
+	updatedAdmin.Version, err = getVersionFromPostFields(r, admin.Version)
+	if err != nil {
+		renderAddUpdateAdminPage(w, r, &admin, fmt.Sprintf("Invalid version: %v", err), false)
+		return
+	}
 	if updatedAdmin.Password == "" {
 		updatedAdmin.Password = admin.Password
 	}
@@ -1401,13 +1717,19 @@ func handleWebUpdateUserPost(w http.ResponseWriter, r *http.Request) {
 	}
 	updatedUser.ID = user.ID
 	updatedUser.Username = user.Username
+	updatedUser.Version, err = getVersionFromPostFields(r, user.Version)
+	if err != nil {
+		renderUserPage(w, r, &user, userPageModeUpdate, fmt.Sprintf("Invalid version: %v", err))
+		return
+	}
 	updatedUser.SetEmptySecretsIfNil()
 	if updatedUser.Password == redactedSecret {
 		updatedUser.Password = user.Password
 	}
-	updateEncryptedSecrets(&updatedUser.FsConfig, user.FsConfig.S3Config.AccessSecret, user.FsConfig.AzBlobConfig.AccountKey,
-		user.FsConfig.GCSConfig.Credentials, user.FsConfig.CryptConfig.Passphrase, user.FsConfig.SFTPConfig.Password,
-		user.FsConfig.SFTPConfig.PrivateKey)
+	updateEncryptedSecrets(&updatedUser.FsConfig, user.FsConfig.S3Config.AccessSecret, user.FsConfig.S3Config.SSECustomerKey,
+		user.FsConfig.AzBlobConfig.AccountKey, user.FsConfig.GCSConfig.Credentials, user.FsConfig.CryptConfig.Passphrase,
+		user.FsConfig.SFTPConfig.Password, user.FsConfig.SFTPConfig.PrivateKey, user.FsConfig.PluginConfig.Options,
+		user.FsConfig.SMBConfig.Password)
 
 	err = dataprovider.UpdateUser(&updatedUser)
 	if err == nil {
@@ -1437,6 +1759,28 @@ func handleWebGetConnections(w http.ResponseWriter, r *http.Request) {
 	renderAdminTemplate(w, templateConnections, data)
 }
 
+func handleWebGetApprovals(w http.ResponseWriter, r *http.Request) {
+	claims, err := getTokenClaims(r)
+	if err != nil || claims.Username == "" {
+		renderBadRequestPage(w, r, errors.New("invalid token claims"))
+		return
+	}
+	if !canViewApprovals(&claims) {
+		renderForbiddenPage(w, r, "You don't have permission for this action")
+		return
+	}
+	approvals, err := dataprovider.GetApprovalRequests(defaultQueryLimit, 0)
+	if err != nil {
+		renderInternalServerErrorPage(w, r, err)
+		return
+	}
+	data := approvalsPage{
+		basePage:  getBasePageData(pageApprovalsTitle, webApprovalsPath, r),
+		Approvals: approvals,
+	}
+	renderAdminTemplate(w, templateApprovals, data)
+}
+
 func handleWebAddFolderGet(w http.ResponseWriter, r *http.Request) {
 	renderFolderPage(w, r, vfs.BaseVirtualFolder{}, folderPageModeAdd, "")
 }
@@ -1515,11 +1859,17 @@ func handleWebUpdateFolderPost(w http.ResponseWriter, r *http.Request) {
 	}
 	updatedFolder.ID = folder.ID
 	updatedFolder.Name = folder.Name
+	updatedFolder.Version, err = getVersionFromPostFields(r, folder.Version)
+	if err != nil {
+		renderFolderPage(w, r, folder, folderPageModeUpdate, fmt.Sprintf("Invalid version: %v", err))
+		return
+	}
 	updatedFolder.FsConfig = fsConfig
 	updatedFolder.FsConfig.SetEmptySecretsIfNil()
-	updateEncryptedSecrets(&updatedFolder.FsConfig, folder.FsConfig.S3Config.AccessSecret, folder.FsConfig.AzBlobConfig.AccountKey,
-		folder.FsConfig.GCSConfig.Credentials, folder.FsConfig.CryptConfig.Passphrase, folder.FsConfig.SFTPConfig.Password,
-		folder.FsConfig.SFTPConfig.PrivateKey)
+	updateEncryptedSecrets(&updatedFolder.FsConfig, folder.FsConfig.S3Config.AccessSecret, folder.FsConfig.S3Config.SSECustomerKey,
+		folder.FsConfig.AzBlobConfig.AccountKey, folder.FsConfig.GCSConfig.Credentials, folder.FsConfig.CryptConfig.Passphrase,
+		folder.FsConfig.SFTPConfig.Password, folder.FsConfig.SFTPConfig.PrivateKey, folder.FsConfig.PluginConfig.Options,
+		folder.FsConfig.SMBConfig.Password)
 
 	err = dataprovider.UpdateFolder(updatedFolder, folder.Users)
 	if err != nil {
@@ -33,8 +33,14 @@ const (
 	templateClientFiles        = "files.html"
 	templateClientMessage      = "message.html"
 	templateClientCredentials  = "credentials.html"
+	templateClientVersions     = "versions.html"
+	templateClientTrash        = "trash.html"
+	templateClientArchived     = "archived.html"
 	pageClientFilesTitle       = "My Files"
 	pageClientCredentialsTitle = "Credentials"
+	pageClientVersionsTitle    = "File versions"
+	pageClientTrashTitle       = "Trash"
+	pageClientArchivedTitle    = "Archived files"
 )
 
 // condResult is the result of an HTTP request precondition check.
@@ -69,6 +75,7 @@ type baseClientPage struct {
 	Version          string
 	CSRFToken        string
 	LoggedUser       *dataprovider.User
+	ImpersonatedBy   string
 }
 
 type dirMapping struct {
@@ -78,14 +85,46 @@ type dirMapping struct {
 
 type filesPage struct {
 	baseClientPage
-	CurrentDir   string
-	Files        []os.FileInfo
-	Error        string
-	Paths        []dirMapping
-	FormatTime   func(time.Time) string
-	GetObjectURL func(string, string) string
-	GetSize      func(int64) string
-	IsLink       func(os.FileInfo) bool
+	CurrentDir     string
+	Files          []os.FileInfo
+	Error          string
+	Paths          []dirMapping
+	HasVersioning  bool
+	HasTrash       bool
+	TrashURL       string
+	HasArchive     bool
+	ArchivedURL    string
+	FormatTime     func(time.Time) string
+	GetObjectURL   func(string, string) string
+	GetVersionsURL func(string, string) string
+	GetSize        func(int64) string
+	IsLink         func(os.FileInfo) bool
+}
+
+type trashPage struct {
+	baseClientPage
+	Items      []common.TrashItem
+	Error      string
+	FormatTime func(int64) string
+	GetSize    func(int64) string
+}
+
+type archivedPage struct {
+	baseClientPage
+	Items      []common.ArchivedFile
+	Error      string
+	FormatTime func(int64) string
+	GetSize    func(int64) string
+}
+
+type versionsPage struct {
+	baseClientPage
+	FilePath   string
+	DirURL     string
+	Versions   []vfs.FileVersion
+	Error      string
+	FormatTime func(int64) string
+	GetSize    func(int64) string
 }
 
 type clientMessagePage struct {
@@ -107,6 +146,14 @@ func getFileObjectURL(baseDir, name string) string {
 	return fmt.Sprintf("%v?path=%v", webClientFilesPath, url.QueryEscape(path.Join(baseDir, name)))
 }
 
+func getFileVersionsURL(baseDir, name string) string {
+	return fmt.Sprintf("%v?path=%v", webClientVersionsPath, url.QueryEscape(path.Join(baseDir, name)))
+}
+
+func getFileVersionModTime(msec int64) string {
+	return getFileObjectModTime(utils.GetTimeFromMsecSinceEpoch(msec))
+}
+
 func getFileObjectModTime(t time.Time) string {
 	if isZeroTime(t) {
 		return ""
@@ -123,6 +170,18 @@ func loadClientTemplates(templatesPath string) {
 		filepath.Join(templatesPath, templateClientDir, templateClientBase),
 		filepath.Join(templatesPath, templateClientDir, templateClientFiles),
 	}
+	trashPaths := []string{
+		filepath.Join(templatesPath, templateClientDir, templateClientBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientTrash),
+	}
+	archivedPaths := []string{
+		filepath.Join(templatesPath, templateClientDir, templateClientBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientArchived),
+	}
+	versionsPaths := []string{
+		filepath.Join(templatesPath, templateClientDir, templateClientBase),
+		filepath.Join(templatesPath, templateClientDir, templateClientVersions),
+	}
 	credentialsPaths := []string{
 		filepath.Join(templatesPath, templateClientDir, templateClientBase),
 		filepath.Join(templatesPath, templateClientDir, templateClientCredentials),
@@ -137,11 +196,17 @@ func loadClientTemplates(templatesPath string) {
 
 	filesTmpl := utils.LoadTemplate(template.ParseFiles(filesPaths...))
 	credentialsTmpl := utils.LoadTemplate(template.ParseFiles(credentialsPaths...))
+	versionsTmpl := utils.LoadTemplate(template.ParseFiles(versionsPaths...))
+	trashTmpl := utils.LoadTemplate(template.ParseFiles(trashPaths...))
+	archivedTmpl := utils.LoadTemplate(template.ParseFiles(archivedPaths...))
 	loginTmpl := utils.LoadTemplate(template.ParseFiles(loginPath...))
 	messageTmpl := utils.LoadTemplate(template.ParseFiles(messagePath...))
 
 	clientTemplates[templateClientFiles] = filesTmpl
 	clientTemplates[templateClientCredentials] = credentialsTmpl
+	clientTemplates[templateClientVersions] = versionsTmpl
+	clientTemplates[templateClientTrash] = trashTmpl
+	clientTemplates[templateClientArchived] = archivedTmpl
 	clientTemplates[templateClientLogin] = loginTmpl
 	clientTemplates[templateClientMessage] = messageTmpl
 }
@@ -165,6 +230,7 @@ func getBaseClientPageData(title, currentURL string, r *http.Request) baseClient
 		Version:          fmt.Sprintf("%v-%v", v.Version, v.CommitHash),
 		CSRFToken:        csrfToken,
 		LoggedUser:       getUserFromToken(r),
+		ImpersonatedBy:   getImpersonatorFromToken(r),
 	}
 }
 
@@ -219,14 +285,22 @@ func renderClientNotFoundPage(w http.ResponseWriter, r *http.Request, err error)
 	renderClientMessagePage(w, r, page404Title, page404Body, http.StatusNotFound, err, "")
 }
 
-func renderFilesPage(w http.ResponseWriter, r *http.Request, files []os.FileInfo, dirName, error string) {
+func renderFilesPage(w http.ResponseWriter, r *http.Request, files []os.FileInfo, dirName, error string,
+	user *dataprovider.User,
+) {
 	data := filesPage{
 		baseClientPage: getBaseClientPageData(pageClientFilesTitle, webClientFilesPath, r),
 		Files:          files,
 		Error:          error,
 		CurrentDir:     dirName,
+		HasVersioning:  user != nil && user.HasFileVersioning(),
+		HasTrash:       user != nil && user.Filters.Trash.Enabled,
+		TrashURL:       webClientTrashPath,
+		HasArchive:     user != nil && user.HasArchiveRules(),
+		ArchivedURL:    webClientArchivedPath,
 		FormatTime:     getFileObjectModTime,
 		GetObjectURL:   getFileObjectURL,
+		GetVersionsURL: getFileVersionsURL,
 		GetSize:        utils.ByteCountIEC,
 		IsLink:         isFileObjectLink,
 	}
@@ -251,6 +325,43 @@ func renderFilesPage(w http.ResponseWriter, r *http.Request, files []os.FileInfo
 	renderClientTemplate(w, templateClientFiles, data)
 }
 
+func renderVersionsPage(w http.ResponseWriter, r *http.Request, filePath string, versions []vfs.FileVersion,
+	error string,
+) {
+	data := versionsPage{
+		baseClientPage: getBaseClientPageData(pageClientVersionsTitle, webClientVersionsPath, r),
+		FilePath:       filePath,
+		DirURL:         getFileObjectURL("/", path.Dir(filePath)),
+		Versions:       versions,
+		Error:          error,
+		FormatTime:     getFileVersionModTime,
+		GetSize:        utils.ByteCountIEC,
+	}
+	renderClientTemplate(w, templateClientVersions, data)
+}
+
+func renderTrashPage(w http.ResponseWriter, r *http.Request, items []common.TrashItem, error string) {
+	data := trashPage{
+		baseClientPage: getBaseClientPageData(pageClientTrashTitle, webClientTrashPath, r),
+		Items:          items,
+		Error:          error,
+		FormatTime:     getFileVersionModTime,
+		GetSize:        utils.ByteCountIEC,
+	}
+	renderClientTemplate(w, templateClientTrash, data)
+}
+
+func renderArchivedPage(w http.ResponseWriter, r *http.Request, items []common.ArchivedFile, error string) {
+	data := archivedPage{
+		baseClientPage: getBaseClientPageData(pageClientArchivedTitle, webClientArchivedPath, r),
+		Items:          items,
+		Error:          error,
+		FormatTime:     getFileVersionModTime,
+		GetSize:        utils.ByteCountIEC,
+	}
+	renderClientTemplate(w, templateClientArchived, data)
+}
+
 func renderCredentialsPage(w http.ResponseWriter, r *http.Request, pwdError string, keyError string) {
 	data := credentialsPage{
 		baseClientPage: getBaseClientPageData(pageClientCredentialsTitle, webClientCredentialsPath, r),
@@ -273,47 +384,69 @@ func handleClientWebLogin(w http.ResponseWriter, r *http.Request) {
 
 func handleWebClientLogout(w http.ResponseWriter, r *http.Request) {
 	c := jwtTokenClaims{}
+	if claims, err := getTokenClaims(r); err == nil && claims.Impersonator != "" {
+		logger.Info(logSender, "", "admin %#v ended the web client session impersonating user %#v, remote address: %v",
+			claims.Impersonator, claims.Username, r.RemoteAddr)
+		logger.AuditImpersonation(claims.Impersonator, claims.Username, utils.GetIPFromRemoteAddress(r.RemoteAddr),
+			logger.AuditImpersonationEnd)
+	}
 	c.removeCookie(w, r)
 
 	http.Redirect(w, r, webClientLoginPath, http.StatusFound)
 }
 
-func handleClientGetFiles(w http.ResponseWriter, r *http.Request) {
-	common.Connections.AddNetworkConnection()
-	defer common.Connections.RemoveNetworkConnection()
-
+// getClientConnection returns a new connection for the logged in user.
+// If the connection is not allowed an error page is rendered and nil is returned
+func getClientConnection(w http.ResponseWriter, r *http.Request) *Connection {
 	claims, err := getTokenClaims(r)
 	if err != nil || claims.Username == "" {
 		renderClientForbiddenPage(w, r, "Invalid token claims")
-		return
+		return nil
 	}
 	if !common.Connections.IsNewConnectionAllowed() {
 		logger.Log(logger.LevelDebug, common.ProtocolHTTP, "", "connection refused, configured limit reached")
 		renderClientForbiddenPage(w, r, "configured connections limit reached")
-		return
+		return nil
+	}
+	if err := common.CheckLoad(); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolHTTP, "", "connection refused: %v", err)
+		renderClientForbiddenPage(w, r, err.Error())
+		return nil
 	}
 	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
 	if common.IsBanned(ipAddr) {
 		renderClientForbiddenPage(w, r, "your IP address is banned")
-		return
+		return nil
 	}
 
 	user, err := dataprovider.UserExists(claims.Username)
 	if err != nil {
 		renderClientInternalServerErrorPage(w, r, err)
-		return
+		return nil
 	}
 
 	connID := xid.New().String()
 	connectionID := fmt.Sprintf("%v_%v", common.ProtocolHTTP, connID)
 	if err := checkWebClientUser(&user, r, connectionID); err != nil {
 		renderClientForbiddenPage(w, r, err.Error())
-		return
+		return nil
 	}
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(connID, common.ProtocolHTTP, user),
 		request:        r,
 	}
+	connection.SetRemoteAddress(r.RemoteAddr)
+	return connection
+}
+
+func handleClientGetFiles(w http.ResponseWriter, r *http.Request) {
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getClientConnection(w, r)
+	if connection == nil {
+		return
+	}
 	common.Connections.Add(connection)
 	defer common.Connections.Remove(connection.GetID())
 
@@ -322,20 +455,176 @@ func handleClientGetFiles(w http.ResponseWriter, r *http.Request) {
 		name = utils.CleanPath(r.URL.Query().Get("path"))
 	}
 	var info os.FileInfo
+	var err error
 	if name == "/" {
 		info = vfs.NewFileInfo(name, true, 0, time.Now(), false)
 	} else {
 		info, err = connection.Stat(name, 0)
 	}
 	if err != nil {
-		renderFilesPage(w, r, nil, name, fmt.Sprintf("unable to stat file %#v: %v", name, err))
+		renderFilesPage(w, r, nil, name, fmt.Sprintf("unable to stat file %#v: %v", name, err), nil)
 		return
 	}
 	if info.IsDir() {
 		renderDirContents(w, r, connection, name)
 		return
 	}
-	downloadFile(w, r, connection, name, info)
+	if status, err := downloadFile(w, r, connection, name, info); err != nil {
+		if status == http.StatusRequestedRangeNotSatisfiable {
+			http.Error(w, err.Error(), status)
+			return
+		}
+		renderFilesPage(w, r, nil, name, fmt.Sprintf("unable to read file %#v: %v", name, err), nil)
+	}
+}
+
+func handleClientGetFileVersions(w http.ResponseWriter, r *http.Request) {
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getClientConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	name := utils.CleanPath(r.URL.Query().Get("path"))
+	versions, err := connection.ListFileVersions(name)
+	if err != nil {
+		renderVersionsPage(w, r, name, nil, fmt.Sprintf("unable to list versions for file %#v: %v", name, err))
+		return
+	}
+	renderVersionsPage(w, r, name, versions, "")
+}
+
+func handleClientRestoreFileVersion(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	err := r.ParseForm()
+	if err != nil {
+		renderClientBadRequestPage(w, r, err)
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken)); err != nil {
+		renderClientForbiddenPage(w, r, err.Error())
+		return
+	}
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getClientConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	name := utils.CleanPath(r.Form.Get("path"))
+	versionID := r.Form.Get("version_id")
+	if err := connection.RestoreFileVersion(name, versionID); err != nil {
+		versions, _ := connection.ListFileVersions(name)
+		renderVersionsPage(w, r, name, versions, fmt.Sprintf("unable to restore version %#v: %v", versionID, err))
+		return
+	}
+	http.Redirect(w, r, getFileVersionsURL("/", name), http.StatusSeeOther)
+}
+
+func handleClientGetTrash(w http.ResponseWriter, r *http.Request) {
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getClientConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	items, err := connection.ListTrash()
+	if err != nil {
+		renderTrashPage(w, r, items, fmt.Sprintf("unable to list the trash contents: %v", err))
+		return
+	}
+	renderTrashPage(w, r, items, "")
+}
+
+func handleClientRestoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	err := r.ParseForm()
+	if err != nil {
+		renderClientBadRequestPage(w, r, err)
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken)); err != nil {
+		renderClientForbiddenPage(w, r, err.Error())
+		return
+	}
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getClientConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	name := utils.CleanPath(r.Form.Get("path"))
+	if err := connection.RestoreFromTrash(name, r.Form.Get("trash_id")); err != nil {
+		items, _ := connection.ListTrash()
+		renderTrashPage(w, r, items, fmt.Sprintf("unable to restore %#v: %v", name, err))
+		return
+	}
+	http.Redirect(w, r, webClientTrashPath, http.StatusSeeOther)
+}
+
+func handleClientGetArchivedFiles(w http.ResponseWriter, r *http.Request) {
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getClientConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	items, err := connection.ListArchivedFiles()
+	if err != nil {
+		renderArchivedPage(w, r, items, fmt.Sprintf("unable to list the archived files: %v", err))
+		return
+	}
+	renderArchivedPage(w, r, items, "")
+}
+
+func handleClientRestoreArchivedFile(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestSize)
+	err := r.ParseForm()
+	if err != nil {
+		renderClientBadRequestPage(w, r, err)
+		return
+	}
+	if err := verifyCSRFToken(r.Form.Get(csrfFormToken)); err != nil {
+		renderClientForbiddenPage(w, r, err.Error())
+		return
+	}
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	connection := getClientConnection(w, r)
+	if connection == nil {
+		return
+	}
+	common.Connections.Add(connection)
+	defer common.Connections.Remove(connection.GetID())
+
+	name := utils.CleanPath(r.Form.Get("path"))
+	if err := connection.RestoreArchivedFile(name); err != nil {
+		items, _ := connection.ListArchivedFiles()
+		renderArchivedPage(w, r, items, fmt.Sprintf("unable to restore %#v: %v", name, err))
+		return
+	}
+	http.Redirect(w, r, webClientArchivedPath, http.StatusSeeOther)
 }
 
 func handleClientGetCredentials(w http.ResponseWriter, r *http.Request) {
@@ -421,13 +710,15 @@ func doChangeUserPassword(r *http.Request, currentPassword, newPassword, confirm
 func renderDirContents(w http.ResponseWriter, r *http.Request, connection *Connection, name string) {
 	contents, err := connection.ReadDir(name)
 	if err != nil {
-		renderFilesPage(w, r, nil, name, fmt.Sprintf("unable to get contents for directory %#v: %v", name, err))
+		renderFilesPage(w, r, nil, name, fmt.Sprintf("unable to get contents for directory %#v: %v", name, err), nil)
 		return
 	}
-	renderFilesPage(w, r, contents, name, "")
+	renderFilesPage(w, r, contents, name, "", &connection.User)
 }
 
-func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection, name string, info os.FileInfo) {
+// downloadFile sends the requested file, or the requested range, to the client.
+// If an error is returned nothing was written and the caller must send the error response
+func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection, name string, info os.FileInfo) (int, error) {
 	var err error
 	rangeHeader := r.Header.Get("Range")
 	if rangeHeader != "" && checkIfRange(r, info.ModTime()) == condFalse {
@@ -438,26 +729,23 @@ func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 	responseStatus := http.StatusOK
 	if strings.HasPrefix(rangeHeader, "bytes=") {
 		if strings.Contains(rangeHeader, ",") {
-			http.Error(w, fmt.Sprintf("unsupported range %#v", rangeHeader), http.StatusRequestedRangeNotSatisfiable)
-			return
+			return http.StatusRequestedRangeNotSatisfiable, fmt.Errorf("unsupported range %#v", rangeHeader)
 		}
 		offset, size, err = parseRangeRequest(rangeHeader[6:], size)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
-			return
+			return http.StatusRequestedRangeNotSatisfiable, err
 		}
 		responseStatus = http.StatusPartialContent
 	}
 	reader, err := connection.getFileReader(name, offset)
 	if err != nil {
-		renderFilesPage(w, r, nil, name, fmt.Sprintf("unable to read file %#v: %v", name, err))
-		return
+		return getMappedStatusCode(err), err
 	}
 	defer reader.Close()
 
 	w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
 	if checkPreconditions(w, r, info.ModTime()) {
-		return
+		return 0, nil
 	}
 	ctype := mime.TypeByExtension(path.Ext(name))
 	if ctype == "" {
@@ -474,6 +762,7 @@ func downloadFile(w http.ResponseWriter, r *http.Request, connection *Connection
 	if r.Method != http.MethodHead {
 		io.CopyN(w, reader, size) //nolint:errcheck
 	}
+	return responseStatus, nil
 }
 
 func checkPreconditions(w http.ResponseWriter, r *http.Request, modtime time.Time) bool {
@@ -20,6 +20,7 @@ import (
 	"github.com/drakkan/sftpgo/httpclient"
 	"github.com/drakkan/sftpgo/httpd"
 	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/scheduler"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/version"
 	"github.com/drakkan/sftpgo/vfs"
@@ -41,6 +42,9 @@ const (
 	defenderBanTime           = "/api/v2/defender/bantime"
 	defenderUnban             = "/api/v2/defender/unban"
 	defenderScore             = "/api/v2/defender/score"
+	defenderListsPath         = "/api/v2/defender/lists"
+	defenderReloadPath        = "/api/v2/defender/reload"
+	scheduledJobsPath         = "/api/v2/scheduler/jobs"
 	adminPath                 = "/api/v2/admins"
 	adminPwdPath              = "/api/v2/changepwd/admin"
 )
@@ -216,6 +220,47 @@ func GetUserByUsername(username string, expectedStatusCode int) (dataprovider.Us
 	return user, body, err
 }
 
+// GetUserFileVersions returns the versions for the given file and checks the received HTTP Status code against expectedStatusCode.
+func GetUserFileVersions(username, filePath string, expectedStatusCode int) ([]vfs.FileVersion, []byte, error) {
+	var versions []vfs.FileVersion
+	var body []byte
+	url, err := addFileVersionQueryParams(buildURLRelativeToBase(userPath, url.PathEscape(username), "versions"),
+		filePath, "")
+	if err != nil {
+		return versions, body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodGet, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return versions, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &versions)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return versions, body, err
+}
+
+// RestoreUserFileVersion restores the specified version for the given file and checks the received HTTP Status code
+// against expectedStatusCode.
+func RestoreUserFileVersion(username, filePath, versionID string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	url, err := addFileVersionQueryParams(buildURLRelativeToBase(userPath, url.PathEscape(username), "versions"),
+		filePath, versionID)
+	if err != nil {
+		return body, err
+	}
+	resp, err := sendHTTPRequest(http.MethodPost, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // GetUsers returns a list of users and checks the received HTTP Status code against expectedStatusCode.
 // The number of results can be limited specifying a limit.
 // Some results can be skipped specifying an offset.
@@ -712,6 +757,106 @@ func UnbanIP(ip string, expectedStatusCode int) error {
 	return checkResponse(resp.StatusCode, expectedStatusCode)
 }
 
+// GetDefenderListEntries returns the defender safe and block list entries stored within the data provider
+func GetDefenderListEntries(expectedStatusCode int) ([]dataprovider.DefenderListEntry, []byte, error) {
+	var entries []dataprovider.DefenderListEntry
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(defenderListsPath), nil, "", getDefaultToken())
+	if err != nil {
+		return entries, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &entries)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return entries, body, err
+}
+
+// AddDefenderListEntry adds a defender safe or block list entry
+func AddDefenderListEntry(entry dataprovider.DefenderListEntry, expectedStatusCode int) (dataprovider.DefenderListEntry, []byte, error) {
+	var newEntry dataprovider.DefenderListEntry
+	var body []byte
+	asJSON, _ := json.Marshal(entry)
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(defenderListsPath), bytes.NewBuffer(asJSON),
+		"application/json", getDefaultToken())
+	if err != nil {
+		return newEntry, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusCreated {
+		err = render.DecodeJSON(resp.Body, &newEntry)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return newEntry, body, err
+}
+
+// RemoveDefenderListEntry removes the given defender safe or block list entry
+func RemoveDefenderListEntry(entry dataprovider.DefenderListEntry, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	url, err := url.Parse(buildURLRelativeToBase(defenderListsPath))
+	if err != nil {
+		return body, err
+	}
+	q := url.Query()
+	q.Add("type", strconv.Itoa(entry.Type))
+	q.Add("network", entry.Network)
+	url.RawQuery = q.Encode()
+	resp, err := sendHTTPRequest(http.MethodDelete, url.String(), nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// ReloadDefender reloads the defender safe and block lists
+func ReloadDefender(expectedStatusCode int) error {
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(defenderReloadPath), nil, "",
+		getDefaultToken())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
+// GetScheduledJobs returns the status of the scheduled jobs
+func GetScheduledJobs(expectedStatusCode int) ([]scheduler.JobStatus, []byte, error) {
+	var jobs []scheduler.JobStatus
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodGet, buildURLRelativeToBase(scheduledJobsPath), nil, "", getDefaultToken())
+	if err != nil {
+		return jobs, body, err
+	}
+	defer resp.Body.Close()
+	err = checkResponse(resp.StatusCode, expectedStatusCode)
+	if err == nil && expectedStatusCode == http.StatusOK {
+		err = render.DecodeJSON(resp.Body, &jobs)
+	} else {
+		body, _ = getResponseBody(resp)
+	}
+	return jobs, body, err
+}
+
+// RunScheduledJob starts the scheduled job with the given name
+func RunScheduledJob(name string, expectedStatusCode int) ([]byte, error) {
+	var body []byte
+	resp, err := sendHTTPRequest(http.MethodPost, buildURLRelativeToBase(scheduledJobsPath, url.PathEscape(name), "run"),
+		nil, "", getDefaultToken())
+	if err != nil {
+		return body, err
+	}
+	defer resp.Body.Close()
+	body, _ = getResponseBody(resp)
+	return body, checkResponse(resp.StatusCode, expectedStatusCode)
+}
+
 // Dumpdata requests a backup to outputFile.
 // outputFile is relative to the configured backups_path
 func Dumpdata(outputFile, outputData, indent string, expectedStatusCode int) (map[string]interface{}, []byte, error) {
@@ -969,6 +1114,12 @@ func compareFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.Provider != actual.Provider {
 		return errors.New("fs provider mismatch")
 	}
+	if expected.Versioning.Mode != actual.Versioning.Mode {
+		return errors.New("fs versioning mode mismatch")
+	}
+	if expected.Versioning.MaxVersions != actual.Versioning.MaxVersions {
+		return errors.New("fs versioning max versions mismatch")
+	}
 	if err := compareS3Config(expected, actual); err != nil {
 		return err
 	}
@@ -984,6 +1135,9 @@ func compareFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if err := compareSFTPFsConfig(expected, actual); err != nil {
 		return err
 	}
+	if err := compareSMBFsConfig(expected, actual); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -1012,10 +1166,58 @@ func compareS3Config(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.S3Config.UploadConcurrency != actual.S3Config.UploadConcurrency {
 		return errors.New("fs S3 upload concurrency mismatch")
 	}
+	if expected.S3Config.UploadMaxBufferSize != actual.S3Config.UploadMaxBufferSize {
+		return errors.New("fs S3 upload max buffer size mismatch")
+	}
+	if expected.S3Config.DownloadPartSize != actual.S3Config.DownloadPartSize {
+		return errors.New("fs S3 download part size mismatch")
+	}
+	if expected.S3Config.DownloadConcurrency != actual.S3Config.DownloadConcurrency {
+		return errors.New("fs S3 download concurrency mismatch")
+	}
+	if expected.S3Config.SSE != actual.S3Config.SSE {
+		return errors.New("fs S3 sse mismatch")
+	}
+	if expected.S3Config.SSEKMSKeyID != actual.S3Config.SSEKMSKeyID {
+		return errors.New("fs S3 sse kms key id mismatch")
+	}
+	if expected.S3Config.CloudRequestConfig != actual.S3Config.CloudRequestConfig {
+		return errors.New("fs S3 request config mismatch")
+	}
+	if err := checkEncryptedSecret(expected.S3Config.SSECustomerKey, actual.S3Config.SSECustomerKey); err != nil {
+		return fmt.Errorf("fs S3 sse customer key mismatch: %v", err)
+	}
 	if expected.S3Config.KeyPrefix != actual.S3Config.KeyPrefix &&
 		expected.S3Config.KeyPrefix+"/" != actual.S3Config.KeyPrefix {
 		return errors.New("fs S3 key prefix mismatch")
 	}
+	if err := compareS3Tags(expected.S3Config.Tags, actual.S3Config.Tags); err != nil {
+		return err
+	}
+	if len(expected.S3Config.UploadRules) != len(actual.S3Config.UploadRules) {
+		return errors.New("fs S3 upload rules mismatch")
+	}
+	for idx, rule := range expected.S3Config.UploadRules {
+		actualRule := actual.S3Config.UploadRules[idx]
+		if rule.Pattern != actualRule.Pattern || rule.StorageClass != actualRule.StorageClass {
+			return errors.New("fs S3 upload rule mismatch")
+		}
+		if err := compareS3Tags(rule.Tags, actualRule.Tags); err != nil {
+			return fmt.Errorf("fs S3 upload rule %#v: %v", rule.Pattern, err)
+		}
+	}
+	return nil
+}
+
+func compareS3Tags(expected, actual map[string]string) error {
+	if len(expected) != len(actual) {
+		return errors.New("fs S3 tags mismatch")
+	}
+	for k, v := range expected {
+		if val, ok := actual[k]; !ok || val != v {
+			return fmt.Errorf("fs S3 tag %#v mismatch", k)
+		}
+	}
 	return nil
 }
 
@@ -1033,6 +1235,9 @@ func compareGCSConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.GCSConfig.AutomaticCredentials != actual.GCSConfig.AutomaticCredentials {
 		return errors.New("GCS automatic credentials mismatch")
 	}
+	if expected.GCSConfig.CloudRequestConfig != actual.GCSConfig.CloudRequestConfig {
+		return errors.New("GCS request config mismatch")
+	}
 	return nil
 }
 
@@ -1071,6 +1276,32 @@ func compareSFTPFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error
 	return nil
 }
 
+func compareSMBFsConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
+	if expected.SMBConfig.Endpoint != actual.SMBConfig.Endpoint {
+		if expected.SMBConfig.Endpoint+":445" != actual.SMBConfig.Endpoint {
+			return errors.New("SMBFs endpoint mismatch")
+		}
+	}
+	if expected.SMBConfig.Share != actual.SMBConfig.Share {
+		return errors.New("SMBFs share mismatch")
+	}
+	if expected.SMBConfig.Username != actual.SMBConfig.Username {
+		return errors.New("SMBFs username mismatch")
+	}
+	if expected.SMBConfig.Domain != actual.SMBConfig.Domain {
+		return errors.New("SMBFs domain mismatch")
+	}
+	if err := checkEncryptedSecret(expected.SMBConfig.Password, actual.SMBConfig.Password); err != nil {
+		return fmt.Errorf("SMBFs password mismatch: %v", err)
+	}
+	if expected.SMBConfig.Prefix != actual.SMBConfig.Prefix {
+		if expected.SMBConfig.Prefix != "" && actual.SMBConfig.Prefix != "/" {
+			return errors.New("SMBFs prefix mismatch")
+		}
+	}
+	return nil
+}
+
 func compareAzBlobConfig(expected *vfs.Filesystem, actual *vfs.Filesystem) error {
 	if expected.AzBlobConfig.Container != actual.AzBlobConfig.Container {
 		return errors.New("azure Blob container mismatch")
@@ -1147,6 +1378,26 @@ func checkEncryptedSecret(expected, actual *kms.Secret) error {
 	return nil
 }
 
+func compareUserRetentionRules(expected *dataprovider.User, actual *dataprovider.User) error {
+	if len(expected.Filters.Retention) != len(actual.Filters.Retention) {
+		return errors.New("retention rules mismatch")
+	}
+	for _, rule := range expected.Filters.Retention {
+		found := false
+		for _, actualRule := range actual.Filters.Retention {
+			if rule.Path == actualRule.Path && rule.Days == actualRule.Days && rule.ArchivePath == actualRule.ArchivePath &&
+				rule.ArchiveFolder == actualRule.ArchiveFolder && len(rule.Patterns) == len(actualRule.Patterns) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("retention rule for path %#v mismatch", rule.Path)
+		}
+	}
+	return nil
+}
+
 func compareUserFilterSubStructs(expected *dataprovider.User, actual *dataprovider.User) error {
 	for _, IPMask := range expected.Filters.AllowedIP {
 		if !utils.IsStringInSlice(IPMask, actual.Filters.AllowedIP) {
@@ -1185,6 +1436,24 @@ func compareUserFilterSubStructs(expected *dataprovider.User, actual *dataprovid
 	if expected.Filters.DisableFsChecks != actual.Filters.DisableFsChecks {
 		return errors.New("disable_fs_checks mismatch")
 	}
+	if expected.Filters.CaseInsensitive != actual.Filters.CaseInsensitive {
+		return errors.New("case_insensitive mismatch")
+	}
+	if expected.Filters.Impersonate != actual.Filters.Impersonate {
+		return errors.New("impersonate mismatch")
+	}
+	return compareUserMetadata(expected, actual)
+}
+
+func compareUserMetadata(expected *dataprovider.User, actual *dataprovider.User) error {
+	if len(expected.Filters.Metadata) != len(actual.Filters.Metadata) {
+		return errors.New("metadata mismatch")
+	}
+	for k, v := range expected.Filters.Metadata {
+		if val, ok := actual.Filters.Metadata[k]; !ok || val != v {
+			return fmt.Errorf("metadata %#v mismatch", k)
+		}
+	}
 	return nil
 }
 
@@ -1201,9 +1470,36 @@ func compareUserFilters(expected *dataprovider.User, actual *dataprovider.User)
 	if len(expected.Filters.DeniedProtocols) != len(actual.Filters.DeniedProtocols) {
 		return errors.New("denied protocols mismatch")
 	}
+	if expected.Filters.Trash.Enabled != actual.Filters.Trash.Enabled {
+		return errors.New("trash enabled mismatch")
+	}
+	if expected.Filters.Trash.Enabled && expected.Filters.Trash.RetentionDays != actual.Filters.Trash.RetentionDays {
+		return errors.New("trash retention days mismatch")
+	}
+	if len(expected.Filters.DirQuotas) != len(actual.Filters.DirQuotas) {
+		return errors.New("directory quotas mismatch")
+	}
+	for _, q := range expected.Filters.DirQuotas {
+		found := false
+		for _, actualQuota := range actual.Filters.DirQuotas {
+			if q.Path == actualQuota.Path && q.QuotaSize == actualQuota.QuotaSize && q.QuotaFiles == actualQuota.QuotaFiles {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("directory quota for path %#v mismatch", q.Path)
+		}
+	}
+	if err := compareUserRetentionRules(expected, actual); err != nil {
+		return err
+	}
 	if expected.Filters.MaxUploadFileSize != actual.Filters.MaxUploadFileSize {
 		return errors.New("max upload file size mismatch")
 	}
+	if expected.Filters.MaxConcurrentTransfers != actual.Filters.MaxConcurrentTransfers {
+		return errors.New("max concurrent transfers mismatch")
+	}
 	if expected.Filters.TLSUsername != actual.Filters.TLSUsername {
 		return errors.New("TLSUsername mismatch")
 	}
@@ -1216,6 +1512,9 @@ func compareUserFilters(expected *dataprovider.User, actual *dataprovider.User)
 	if err := compareUserFileExtensionsFilters(expected, actual); err != nil {
 		return err
 	}
+	if err := compareUserOperationsFilters(expected, actual); err != nil {
+		return err
+	}
 	return compareUserFilePatternsFilters(expected, actual)
 }
 
@@ -1253,6 +1552,28 @@ func compareUserFilePatternsFilters(expected *dataprovider.User, actual *datapro
 	return nil
 }
 
+func compareUserOperationsFilters(expected *dataprovider.User, actual *dataprovider.User) error {
+	if len(expected.Filters.Operations) != len(actual.Filters.Operations) {
+		return errors.New("operations filters mismatch")
+	}
+	for _, f := range expected.Filters.Operations {
+		found := false
+		for _, f1 := range actual.Filters.Operations {
+			if path.Clean(f.Path) == path.Clean(f1.Path) {
+				if !checkFilterMatch(f.AllowedOperations, f1.AllowedOperations) ||
+					!checkFilterMatch(f.DeniedOperations, f1.DeniedOperations) {
+					return errors.New("operations filters contents mismatch")
+				}
+				found = true
+			}
+		}
+		if !found {
+			return errors.New("operations filters contents mismatch")
+		}
+	}
+	return nil
+}
+
 func compareUserFileExtensionsFilters(expected *dataprovider.User, actual *dataprovider.User) error {
 	if len(expected.Filters.FileExtensions) != len(actual.Filters.FileExtensions) {
 		return errors.New("file extensions mismatch")
@@ -1350,6 +1671,20 @@ func addModeQueryParam(rawurl, mode string) (*url.URL, error) {
 	return url, err
 }
 
+func addFileVersionQueryParams(rawurl, filePath, versionID string) (*url.URL, error) {
+	url, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Query()
+	q.Add("path", filePath)
+	if versionID != "" {
+		q.Add("version_id", versionID)
+	}
+	url.RawQuery = q.Encode()
+	return url, err
+}
+
 func addDisconnectQueryParam(rawurl, disconnect string) (*url.URL, error) {
 	url, err := url.Parse(rawurl)
 	if err != nil {
@@ -0,0 +1,162 @@
+// Package kafkaclient publishes batches of messages to Kafka using the
+// segmentio/kafka-go client. It is used to send the actions notifications
+package kafkaclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// Supported SASL mechanisms
+const (
+	SASLMechanismPlain       = "PLAIN"
+	SASLMechanismSCRAMSHA256 = "SCRAM-SHA-256"
+	SASLMechanismSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+// maxAttempts is the number of attempts to send a batch before giving up
+const maxAttempts = 3
+
+// ErrProducerClosed is returned when producing messages using a closed producer
+var ErrProducerClosed = errors.New("the producer is closed")
+
+// Config defines the producer configuration
+type Config struct {
+	// Bootstrap brokers as host:port, they are used to discover the cluster
+	Brokers []string
+	// Topic to publish the messages to
+	Topic string
+	// TLS configuration, nil means plain TCP
+	TLSConfig *tls.Config
+	// SASL mechanism, empty means no SASL authentication
+	SASLMechanism string
+	Username      string
+	Password      string
+	ClientID      string
+	// Maximum number of messages in a batch
+	BatchSize int
+	// Maximum time to wait for a batch to fill before sending it
+	BatchTimeout time.Duration
+	// Timeout for each request, including the connection
+	Timeout time.Duration
+}
+
+func (c *Config) validate() error {
+	if len(c.Brokers) == 0 {
+		return errors.New("at least a broker is required")
+	}
+	if c.Topic == "" {
+		return errors.New("the topic is required")
+	}
+	if c.BatchSize < 1 {
+		return fmt.Errorf("invalid batch size: %v", c.BatchSize)
+	}
+	if c.BatchTimeout <= 0 {
+		// kafka-go uses a default timeout for zero values, we want to send the
+		// messages without waiting
+		c.BatchTimeout = time.Millisecond
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Second
+	}
+	return nil
+}
+
+func (c *Config) getSASLMechanism() (sasl.Mechanism, error) {
+	switch c.SASLMechanism {
+	case "":
+		return nil, nil
+	case SASLMechanismPlain:
+		return plain.Mechanism{
+			Username: c.Username,
+			Password: c.Password,
+		}, nil
+	case SASLMechanismSCRAMSHA256:
+		return scram.Mechanism(scram.SHA256, c.Username, c.Password)
+	case SASLMechanismSCRAMSHA512:
+		return scram.Mechanism(scram.SHA512, c.Username, c.Password)
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %#v", c.SASLMechanism)
+	}
+}
+
+// Producer publishes messages to Kafka. The messages are grouped in batches and
+// sent, for each topic partition, to the partition leader. Messages with the same
+// key are sent to the same partition using the same algorithm as the Java client,
+// messages without a key are distributed among all the partitions
+type Producer struct {
+	writer    *kafka.Writer
+	transport *kafka.Transport
+	mu        sync.RWMutex
+	closed    bool
+}
+
+// NewProducer returns a new producer. The connections to the brokers are
+// established when the first batch is sent
+func NewProducer(config Config) (*Producer, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	mechanism, err := config.getSASLMechanism()
+	if err != nil {
+		return nil, err
+	}
+	transport := &kafka.Transport{
+		DialTimeout: config.Timeout,
+		ClientID:    config.ClientID,
+		TLS:         config.TLSConfig,
+		SASL:        mechanism,
+	}
+	return &Producer{
+		writer: &kafka.Writer{
+			Addr:         kafka.TCP(config.Brokers...),
+			Topic:        config.Topic,
+			Balancer:     kafka.Murmur2Balancer{},
+			MaxAttempts:  maxAttempts,
+			BatchSize:    config.BatchSize,
+			BatchTimeout: config.BatchTimeout,
+			ReadTimeout:  config.Timeout,
+			WriteTimeout: config.Timeout,
+			RequiredAcks: kafka.RequireAll,
+			Transport:    transport,
+		},
+		transport: transport,
+	}, nil
+}
+
+// Produce adds the given message to the current batch and waits until the batch
+// is acknowledged by the partition leader
+func (p *Producer) Produce(key, value []byte) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrProducerClosed
+	}
+	return p.writer.WriteMessages(context.Background(), kafka.Message{
+		Key:   key,
+		Value: value,
+	})
+}
+
+// Close sends the pending messages and closes the connections to the brokers
+func (p *Producer) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.writer.Close() //nolint:errcheck
+	p.transport.CloseIdleConnections()
+}
@@ -0,0 +1,89 @@
+package kafkaclient
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProducerConfig(t *testing.T) {
+	config := Config{
+		Brokers:      []string{"127.0.0.1:9092", "127.0.0.1:9093"},
+		Topic:        "events",
+		ClientID:     "sftpgo",
+		BatchSize:    10,
+		BatchTimeout: 100 * time.Millisecond,
+	}
+	producer, err := NewProducer(config)
+	require.NoError(t, err)
+	assert.Equal(t, "events", producer.writer.Topic)
+	assert.Equal(t, 10, producer.writer.BatchSize)
+	assert.Equal(t, 100*time.Millisecond, producer.writer.BatchTimeout)
+	assert.Equal(t, 30*time.Second, producer.writer.WriteTimeout)
+	assert.Equal(t, kafka.RequireAll, producer.writer.RequiredAcks)
+	assert.Equal(t, "127.0.0.1:9092,127.0.0.1:9093", producer.writer.Addr.String())
+	assert.Equal(t, "sftpgo", producer.transport.ClientID)
+	assert.Nil(t, producer.transport.SASL)
+	producer.Close()
+	// a zero batch timeout must not wait for the kafka-go default timeout
+	config.BatchTimeout = 0
+	producer, err = NewProducer(config)
+	require.NoError(t, err)
+	assert.Equal(t, time.Millisecond, producer.writer.BatchTimeout)
+	producer.Close()
+
+	for _, mechanism := range []string{SASLMechanismPlain, SASLMechanismSCRAMSHA256, SASLMechanismSCRAMSHA512} {
+		config.SASLMechanism = mechanism
+		config.Username = "user"
+		config.Password = "password"
+		producer, err = NewProducer(config)
+		require.NoError(t, err)
+		if assert.NotNil(t, producer.transport.SASL) {
+			assert.Equal(t, mechanism, producer.transport.SASL.Name())
+		}
+		producer.Close()
+	}
+
+	config.SASLMechanism = "GSSAPI"
+	_, err = NewProducer(config)
+	assert.Error(t, err)
+	config.SASLMechanism = SASLMechanismPlain
+	config.BatchSize = 0
+	_, err = NewProducer(config)
+	assert.Error(t, err)
+	config.BatchSize = 1
+	config.Topic = ""
+	_, err = NewProducer(config)
+	assert.Error(t, err)
+	config.Topic = "events"
+	config.Brokers = nil
+	_, err = NewProducer(config)
+	assert.Error(t, err)
+}
+
+func TestProducerUnavailableBroker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	err = listener.Close()
+	require.NoError(t, err)
+
+	producer, err := NewProducer(Config{
+		Brokers:   []string{address},
+		Topic:     "events",
+		BatchSize: 1,
+		Timeout:   time.Second,
+	})
+	require.NoError(t, err)
+	err = producer.Produce(nil, []byte("value"))
+	assert.Error(t, err)
+	producer.Close()
+	err = producer.Produce(nil, []byte("value"))
+	assert.ErrorIs(t, err, ErrProducerClosed)
+	// closing a closed producer is a no-op
+	producer.Close()
+}
@@ -17,10 +17,10 @@ func init() {
 	version.AddFeature("+awskms")
 }
 
-func newAWSSecret(base baseSecret, url, masterKey string) SecretProvider {
+func newAWSSecret(base BaseSecret, url, masterKey string) SecretProvider {
 	return &awsSecret{
 		baseGCloudSecret{
-			baseSecret: base,
+			BaseSecret: base,
 			url:        url,
 			masterKey:  masterKey,
 		},
@@ -12,6 +12,6 @@ func init() {
 	version.AddFeature("-awskms")
 }
 
-func newAWSSecret(base baseSecret, url, masterKey string) SecretProvider {
+func newAWSSecret(base BaseSecret, url, masterKey string) SecretProvider {
 	return newDisabledSecret(errors.New("AWS KMS disabled at build time"))
 }
@@ -8,10 +8,35 @@ import (
 	"gocloud.dev/secrets"
 )
 
+// secretKeeper defines the methods to encrypt and decrypt the payloads,
+// *secrets.Keeper implements this interface
+type secretKeeper interface {
+	Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
+	Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
+	Close() error
+}
+
 type baseGCloudSecret struct {
-	baseSecret
+	BaseSecret
 	masterKey string
 	url       string
+	// openKeeper returns the keeper for the url, nil means the Go CDK keeper
+	openKeeper func(ctx context.Context, url string) (secretKeeper, error)
+}
+
+func openGCloudKeeper(ctx context.Context, url string) (secretKeeper, error) {
+	keeper, err := secrets.OpenKeeper(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return keeper, nil
+}
+
+func (s *baseGCloudSecret) getKeeper(ctx context.Context) (secretKeeper, error) {
+	if s.openKeeper != nil {
+		return s.openKeeper(ctx, s.url)
+	}
+	return openGCloudKeeper(ctx, s.url)
 }
 
 func (s *baseGCloudSecret) Encrypt() error {
@@ -25,8 +50,9 @@ func (s *baseGCloudSecret) Encrypt() error {
 	payload := s.Payload
 	key := ""
 	mode := 0
+	keyVersion := 0
 	if s.masterKey != "" {
-		localSecret := newLocalSecret(s.baseSecret, s.masterKey)
+		localSecret := newLocalSecret(s.BaseSecret, s.masterKey)
 		err := localSecret.Encrypt()
 		if err != nil {
 			return err
@@ -34,12 +60,13 @@ func (s *baseGCloudSecret) Encrypt() error {
 		payload = localSecret.GetPayload()
 		key = localSecret.GetKey()
 		mode = localSecret.GetMode()
+		keyVersion = localSecret.GetKeyVersion()
 	}
 
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(defaultTimeout))
 	defer cancelFn()
 
-	keeper, err := secrets.OpenKeeper(ctx, s.url)
+	keeper, err := s.getKeeper(ctx)
 	if err != nil {
 		return err
 	}
@@ -52,6 +79,7 @@ func (s *baseGCloudSecret) Encrypt() error {
 	s.Payload = base64.StdEncoding.EncodeToString(ciphertext)
 	s.Key = key
 	s.Mode = mode
+	s.KeyVersion = keyVersion
 	return nil
 }
 
@@ -63,7 +91,7 @@ func (s *baseGCloudSecret) Decrypt() error {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(defaultTimeout))
 	defer cancelFn()
 
-	keeper, err := secrets.OpenKeeper(ctx, s.url)
+	keeper, err := s.getKeeper(ctx)
 	if err != nil {
 		return err
 	}
@@ -75,14 +103,15 @@ func (s *baseGCloudSecret) Decrypt() error {
 	}
 	payload := string(plaintext)
 	if s.Key != "" {
-		baseSecret := baseSecret{
+		base := BaseSecret{
 			Status:         SecretStatusSecretBox,
 			Payload:        string(plaintext),
 			Key:            s.Key,
 			AdditionalData: s.AdditionalData,
 			Mode:           s.Mode,
+			KeyVersion:     s.KeyVersion,
 		}
-		localSecret := newLocalSecret(baseSecret, s.masterKey)
+		localSecret := newLocalSecret(base, s.masterKey)
 		err = localSecret.Decrypt()
 		if err != nil {
 			return err
@@ -94,5 +123,6 @@ func (s *baseGCloudSecret) Decrypt() error {
 	s.Key = ""
 	s.AdditionalData = ""
 	s.Mode = 0
+	s.KeyVersion = 0
 	return nil
 }
@@ -1,48 +1,64 @@
 package kms
 
-// baseSecret defines the base struct shared among all the secret providers
-type baseSecret struct {
+// BaseSecret defines the base struct shared among all the secret providers.
+// It can be embedded by the secret providers registered using RegisterSecretProvider
+type BaseSecret struct {
 	Status         SecretStatus `json:"status,omitempty"`
 	Payload        string       `json:"payload,omitempty"`
 	Key            string       `json:"key,omitempty"`
 	AdditionalData string       `json:"additional_data,omitempty"`
 	// 1 means encrypted using a master key
 	Mode int `json:"mode,omitempty"`
+	// version of the master key, if the master key file contains versioned keys
+	KeyVersion int `json:"key_version,omitempty"`
 }
 
-func (s *baseSecret) GetStatus() SecretStatus {
+// GetStatus returns the secret status
+func (s *BaseSecret) GetStatus() SecretStatus {
 	return s.Status
 }
 
-func (s *baseSecret) GetPayload() string {
+// GetPayload returns the secret payload
+func (s *BaseSecret) GetPayload() string {
 	return s.Payload
 }
 
-func (s *baseSecret) GetKey() string {
+// GetKey returns the secret key
+func (s *BaseSecret) GetKey() string {
 	return s.Key
 }
 
-func (s *baseSecret) GetMode() int {
+// GetMode returns the encryption mode
+func (s *BaseSecret) GetMode() int {
 	return s.Mode
 }
 
-func (s *baseSecret) GetAdditionalData() string {
+// GetKeyVersion returns the version of the master key used for encryption
+func (s *BaseSecret) GetKeyVersion() int {
+	return s.KeyVersion
+}
+
+// GetAdditionalData returns the secret additional data
+func (s *BaseSecret) GetAdditionalData() string {
 	return s.AdditionalData
 }
 
-func (s *baseSecret) SetKey(value string) {
+// SetKey sets the secret key
+func (s *BaseSecret) SetKey(value string) {
 	s.Key = value
 }
 
-func (s *baseSecret) SetAdditionalData(value string) {
+// SetAdditionalData sets the secret additional data
+func (s *BaseSecret) SetAdditionalData(value string) {
 	s.AdditionalData = value
 }
 
-func (s *baseSecret) SetStatus(value SecretStatus) {
+// SetStatus sets the secret status
+func (s *BaseSecret) SetStatus(value SecretStatus) {
 	s.Status = value
 }
 
-func (s *baseSecret) isEmpty() bool {
+func (s *BaseSecret) isEmpty() bool {
 	if s.Status != "" {
 		return false
 	}
@@ -10,12 +10,12 @@ import (
 )
 
 type builtinSecret struct {
-	baseSecret
+	BaseSecret
 }
 
-func newBuiltinSecret(base baseSecret) SecretProvider {
+func newBuiltinSecret(base BaseSecret) SecretProvider {
 	return &builtinSecret{
-		baseSecret: base,
+		BaseSecret: base,
 	}
 }
 
@@ -5,13 +5,13 @@ package kms
 const disabledProviderName = "Disabled"
 
 type disabledSecret struct {
-	baseSecret
+	BaseSecret
 	err error
 }
 
 func newDisabledSecret(err error) SecretProvider {
 	return &disabledSecret{
-		baseSecret: baseSecret{},
+		BaseSecret: BaseSecret{},
 		err:        err,
 	}
 }
@@ -17,10 +17,10 @@ func init() {
 	version.AddFeature("+gcpkms")
 }
 
-func newGCPSecret(base baseSecret, url, masterKey string) SecretProvider {
+func newGCPSecret(base BaseSecret, url, masterKey string) SecretProvider {
 	return &gcpSecret{
 		baseGCloudSecret{
-			baseSecret: base,
+			BaseSecret: base,
 			url:        url,
 			masterKey:  masterKey,
 		},
@@ -12,6 +12,6 @@ func init() {
 	version.AddFeature("-gcpkms")
 }
 
-func newGCPSecret(base baseSecret, url, masterKey string) SecretProvider {
+func newGCPSecret(base BaseSecret, url, masterKey string) SecretProvider {
 	return newDisabledSecret(errors.New("GCP KMS disabled at build time"))
 }
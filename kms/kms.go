@@ -4,7 +4,10 @@ package kms
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -23,6 +26,7 @@ type SecretProvider interface {
 	GetKey() string
 	GetAdditionalData() string
 	GetMode() int
+	GetKeyVersion() int
 	SetKey(string)
 	SetAdditionalData(string)
 	SetStatus(SecretStatus)
@@ -66,9 +70,18 @@ type Configuration struct {
 
 // Secrets define the KMS configuration for encryption/decryption
 type Secrets struct {
-	URL           string `json:"url" mapstructure:"url"`
+	URL string `json:"url" mapstructure:"url"`
+	// MasterKeyString defines the master key. It is ignored if MasterKeyPath is set
+	MasterKeyString string `json:"master_key" mapstructure:"master_key"`
+	// MasterKeyPath defines the path to a file containing a single master key
+	// or multiple versioned master keys, one per line, as "<version>:<key>".
+	// The latest version is used to encrypt the new secrets
 	MasterKeyPath string `json:"master_key_path" mapstructure:"master_key_path"`
 	masterKey     string
+	// version of the master key used to encrypt the secrets
+	masterKeyVersion int
+	// master keys by version, nil if the master key is not loaded from a file
+	masterKeys map[int]string
 }
 
 var (
@@ -78,12 +91,77 @@ var (
 	validSecretStatuses    = []string{SecretStatusPlain, SecretStatusAES256GCM, SecretStatusSecretBox,
 		SecretStatusVaultTransit, SecretStatusAWS, SecretStatusGCP, SecretStatusRedacted}
 	config         Configuration
+	configMutex    sync.RWMutex
 	defaultTimeout = 10 * time.Second
+	// versionedKeyRegex matches the lines of a versioned master key file
+	versionedKeyRegex = regexp.MustCompile(`^([0-9]+):(.+)$`)
 )
 
+// SecretProviderFn defines the function used to build a SecretProvider
+// for the given secret, KMS URL and master key
+type SecretProviderFn func(base BaseSecret, url, masterKey string) SecretProvider
+
+type registeredSecretProvider struct {
+	encryptedStatus SecretStatus
+	newFn           SecretProviderFn
+}
+
+var secretProviders = struct {
+	sync.RWMutex
+	providers map[string]registeredSecretProvider
+}{
+	providers: make(map[string]registeredSecretProvider),
+}
+
+// RegisterSecretProvider registers a secret provider for the given URL scheme.
+// The provider is used to encrypt the secrets if the configured KMS URL uses
+// the given scheme and to decrypt the secrets with the given encrypted status
+func RegisterSecretProvider(scheme string, encryptedStatus SecretStatus, fn SecretProviderFn) {
+	secretProviders.Lock()
+	defer secretProviders.Unlock()
+
+	secretProviders.providers[scheme] = registeredSecretProvider{
+		encryptedStatus: encryptedStatus,
+		newFn:           fn,
+	}
+}
+
+func getRegisteredProviderForURL(url string) (SecretProviderFn, bool) {
+	secretProviders.RLock()
+	defer secretProviders.RUnlock()
+
+	for scheme, p := range secretProviders.providers {
+		if strings.HasPrefix(url, scheme+"://") {
+			return p.newFn, true
+		}
+	}
+	return nil, false
+}
+
+func getRegisteredProviderForStatus(status SecretStatus) (SecretProviderFn, bool) {
+	secretProviders.RLock()
+	defer secretProviders.RUnlock()
+
+	for _, p := range secretProviders.providers {
+		if p.encryptedStatus == status {
+			return p.newFn, true
+		}
+	}
+	return nil, false
+}
+
+func isValidSecretStatus(status SecretStatus) bool {
+	if utils.IsStringInSlice(status, validSecretStatuses) {
+		return true
+	}
+	_, ok := getRegisteredProviderForStatus(status)
+	return ok
+}
+
 // NewSecret builds a new Secret using the provided arguments
 func NewSecret(status SecretStatus, payload, key, data string) *Secret {
-	return config.newSecret(status, payload, key, data)
+	c := getConfig()
+	return c.newSecret(status, payload, key, data)
 }
 
 // NewEmptySecret returns an empty secret
@@ -107,19 +185,119 @@ func GetSecretFromCompatString(secret string) (*Secret, error) {
 
 // Initialize configures the KMS support
 func (c *Configuration) Initialize() error {
+	if err := c.LoadMasterKey(); err != nil {
+		return err
+	}
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	config = *c
+	return nil
+}
+
+// ReloadMasterKey reads the master key file again, if any, so the added key
+// versions are used without restarting. The secrets encrypted using the
+// previous versions can be decrypted as long as these versions are not
+// removed from the file
+func ReloadMasterKey() error {
+	c := getConfig()
+	if c.Secrets.MasterKeyPath == "" {
+		return nil
+	}
+	if err := c.LoadMasterKey(); err != nil {
+		return err
+	}
+	configMutex.Lock()
+	defer configMutex.Unlock()
+
+	config = c
+	return nil
+}
+
+func getConfig() Configuration {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+
+	return config
+}
+
+// getMasterKeyVersion returns the version for the given master key, 0 if the
+// key is not versioned
+func getMasterKeyVersion(masterKey string) int {
+	c := getConfig()
+	for version, key := range c.Secrets.masterKeys {
+		if key == masterKey {
+			return version
+		}
+	}
+	return 0
+}
+
+// LoadMasterKey loads the master key from the configured string or file.
+// It is called by Initialize and it must be called for configurations that
+// are not initialized, for example the previous configuration to use with
+// DecryptWith
+func (c *Configuration) LoadMasterKey() error {
+	c.Secrets.masterKey = c.Secrets.MasterKeyString
+	c.Secrets.masterKeyVersion = 0
+	c.Secrets.masterKeys = nil
 	if c.Secrets.MasterKeyPath != "" {
 		mKey, err := os.ReadFile(c.Secrets.MasterKeyPath)
 		if err != nil {
 			return err
 		}
-		c.Secrets.masterKey = strings.TrimSpace(string(mKey))
+		keys, err := parseMasterKeys(string(mKey))
+		if err != nil {
+			return fmt.Errorf("invalid master key file %#v: %w", c.Secrets.MasterKeyPath, err)
+		}
+		c.Secrets.masterKey = ""
+		c.Secrets.masterKeys = keys
+		for version := range keys {
+			if version > c.Secrets.masterKeyVersion {
+				c.Secrets.masterKeyVersion = version
+			}
+		}
+		c.Secrets.masterKey = keys[c.Secrets.masterKeyVersion]
 	}
-	config = *c
 	return nil
 }
 
+// parseMasterKeys parses the content of a master key file. If all the lines,
+// excluding the empty ones and the comments starting with "#", have the
+// "<version>:<key>" format the file contains versioned keys, otherwise the
+// whole content is a single key with version 0
+func parseMasterKeys(content string) (map[int]string, error) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, nil
+	}
+	keys := make(map[int]string)
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		matches := versionedKeyRegex.FindStringSubmatch(line)
+		if matches == nil {
+			return map[int]string{0: content}, nil
+		}
+		version, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key version %#v: %w", matches[1], err)
+		}
+		if _, ok := keys[version]; ok {
+			return nil, fmt.Errorf("duplicated master key version %v", version)
+		}
+		keys[version] = strings.TrimSpace(matches[2])
+	}
+	if len(keys) == 0 {
+		return map[int]string{0: content}, nil
+	}
+	return keys, nil
+}
+
 func (c *Configuration) newSecret(status SecretStatus, payload, key, data string) *Secret {
-	base := baseSecret{
+	base := BaseSecret{
 		Status:         status,
 		Key:            key,
 		Payload:        payload,
@@ -130,7 +308,10 @@ func (c *Configuration) newSecret(status SecretStatus, payload, key, data string
 	}
 }
 
-func (c *Configuration) getSecretProvider(base baseSecret) SecretProvider {
+func (c *Configuration) getSecretProvider(base BaseSecret) SecretProvider {
+	if fn, ok := getRegisteredProviderForURL(c.Secrets.URL); ok {
+		return fn(base, c.Secrets.URL, c.Secrets.masterKey)
+	}
 	if strings.HasPrefix(c.Secrets.URL, "hashivault://") {
 		return newVaultSecret(base, c.Secrets.URL, c.Secrets.masterKey)
 	}
@@ -143,6 +324,45 @@ func (c *Configuration) getSecretProvider(base baseSecret) SecretProvider {
 	return newLocalSecret(base, c.Secrets.masterKey)
 }
 
+// getMasterKey returns the master key for the given secret: the latest version
+// for the secrets to encrypt and the version used to encrypt the other ones
+func (c *Configuration) getMasterKey(base BaseSecret) string {
+	switch base.Status {
+	case "", SecretStatusPlain, SecretStatusRedacted:
+		return c.Secrets.masterKey
+	}
+	if c.Secrets.masterKeys == nil {
+		return c.Secrets.masterKey
+	}
+	return c.Secrets.masterKeys[base.KeyVersion]
+}
+
+// getProviderForStatus returns the provider to decrypt a secret with the
+// status of the given base secret
+func (c *Configuration) getProviderForStatus(base BaseSecret) (SecretProvider, error) {
+	masterKey := c.getMasterKey(base)
+	switch base.Status {
+	case SecretStatusAES256GCM:
+		return newBuiltinSecret(base), nil
+	case SecretStatusSecretBox:
+		return newLocalSecret(base, masterKey), nil
+	case SecretStatusVaultTransit:
+		return newVaultSecret(base, c.Secrets.URL, masterKey), nil
+	case SecretStatusAWS:
+		return newAWSSecret(base, c.Secrets.URL, masterKey), nil
+	case SecretStatusGCP:
+		return newGCPSecret(base, c.Secrets.URL, masterKey), nil
+	case SecretStatusPlain, SecretStatusRedacted:
+		return c.getSecretProvider(base), nil
+	default:
+		fn, ok := getRegisteredProviderForStatus(base.Status)
+		if !ok {
+			return nil, errInvalidSecret
+		}
+		return fn(base, c.Secrets.URL, masterKey), nil
+	}
+}
+
 // Secret defines the struct used to store confidential data
 type Secret struct {
 	sync.RWMutex
@@ -154,12 +374,13 @@ func (s *Secret) MarshalJSON() ([]byte, error) {
 	s.RLock()
 	defer s.RUnlock()
 
-	return json.Marshal(&baseSecret{
+	return json.Marshal(&BaseSecret{
 		Status:         s.provider.GetStatus(),
 		Payload:        s.provider.GetPayload(),
 		Key:            s.provider.GetKey(),
 		AdditionalData: s.provider.GetAdditionalData(),
 		Mode:           s.provider.GetMode(),
+		KeyVersion:     s.provider.GetKeyVersion(),
 	})
 }
 
@@ -169,31 +390,21 @@ func (s *Secret) UnmarshalJSON(data []byte) error {
 	s.Lock()
 	defer s.Unlock()
 
-	baseSecret := baseSecret{}
-	err := json.Unmarshal(data, &baseSecret)
+	base := BaseSecret{}
+	err := json.Unmarshal(data, &base)
 	if err != nil {
 		return err
 	}
-	if baseSecret.isEmpty() {
-		s.provider = config.getSecretProvider(baseSecret)
+	c := getConfig()
+	if base.isEmpty() {
+		s.provider = c.getSecretProvider(base)
 		return nil
 	}
-	switch baseSecret.Status {
-	case SecretStatusAES256GCM:
-		s.provider = newBuiltinSecret(baseSecret)
-	case SecretStatusSecretBox:
-		s.provider = newLocalSecret(baseSecret, config.Secrets.masterKey)
-	case SecretStatusVaultTransit:
-		s.provider = newVaultSecret(baseSecret, config.Secrets.URL, config.Secrets.masterKey)
-	case SecretStatusAWS:
-		s.provider = newAWSSecret(baseSecret, config.Secrets.URL, config.Secrets.masterKey)
-	case SecretStatusGCP:
-		s.provider = newGCPSecret(baseSecret, config.Secrets.URL, config.Secrets.masterKey)
-	case SecretStatusPlain, SecretStatusRedacted:
-		s.provider = config.getSecretProvider(baseSecret)
-	default:
-		return errInvalidSecret
+	provider, err := c.getProviderForStatus(base)
+	if err != nil {
+		return err
 	}
+	s.provider = provider
 	return nil
 }
 
@@ -214,6 +425,9 @@ func (s *Secret) IsEqual(other *Secret) bool {
 	if s.GetMode() != other.GetMode() {
 		return false
 	}
+	if s.GetKeyVersion() != other.GetKeyVersion() {
+		return false
+	}
 	return true
 }
 
@@ -222,33 +436,41 @@ func (s *Secret) Clone() *Secret {
 	s.RLock()
 	defer s.RUnlock()
 
-	baseSecret := baseSecret{
+	base := BaseSecret{
 		Status:         s.provider.GetStatus(),
 		Payload:        s.provider.GetPayload(),
 		Key:            s.provider.GetKey(),
 		AdditionalData: s.provider.GetAdditionalData(),
 		Mode:           s.provider.GetMode(),
+		KeyVersion:     s.provider.GetKeyVersion(),
 	}
+	c := getConfig()
+	masterKey := c.getMasterKey(base)
 	switch s.provider.Name() {
 	case builtinProviderName:
 		return &Secret{
-			provider: newBuiltinSecret(baseSecret),
+			provider: newBuiltinSecret(base),
 		}
 	case awsProviderName:
 		return &Secret{
-			provider: newAWSSecret(baseSecret, config.Secrets.URL, config.Secrets.masterKey),
+			provider: newAWSSecret(base, c.Secrets.URL, masterKey),
 		}
 	case gcpProviderName:
 		return &Secret{
-			provider: newGCPSecret(baseSecret, config.Secrets.URL, config.Secrets.masterKey),
+			provider: newGCPSecret(base, c.Secrets.URL, masterKey),
 		}
 	case localProviderName:
 		return &Secret{
-			provider: newLocalSecret(baseSecret, config.Secrets.masterKey),
+			provider: newLocalSecret(base, masterKey),
 		}
 	case vaultProviderName:
 		return &Secret{
-			provider: newVaultSecret(baseSecret, config.Secrets.URL, config.Secrets.masterKey),
+			provider: newVaultSecret(base, c.Secrets.URL, masterKey),
+		}
+	}
+	if fn, ok := getRegisteredProviderForStatus(base.Status); ok {
+		return &Secret{
+			provider: fn(base, c.Secrets.URL, masterKey),
 		}
 	}
 	return NewSecret(s.GetStatus(), s.GetPayload(), s.GetKey(), s.GetAdditionalData())
@@ -330,6 +552,14 @@ func (s *Secret) GetMode() int {
 	return s.provider.GetMode()
 }
 
+// GetKeyVersion returns the version of the master key used to encrypt the secret
+func (s *Secret) GetKeyVersion() int {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.provider.GetKeyVersion()
+}
+
 // SetAdditionalData sets the given additional data
 func (s *Secret) SetAdditionalData(value string) {
 	s.Lock()
@@ -401,7 +631,7 @@ func (s *Secret) IsValidInput() bool {
 	s.RLock()
 	defer s.RUnlock()
 
-	if !utils.IsStringInSlice(s.provider.GetStatus(), validSecretStatuses) {
+	if !isValidSecretStatus(s.provider.GetStatus()) {
 		return false
 	}
 	if s.provider.GetPayload() == "" {
@@ -435,6 +665,39 @@ func (s *Secret) Decrypt() error {
 	return s.provider.Decrypt()
 }
 
+// DecryptWith decrypts an encrypted Secret object using the given configuration
+// instead of the active one, for example the previous master key or KMS URL.
+// The decrypted secret is a plain text secret bound to the active configuration,
+// so it will be encrypted using the current KMS settings
+func (s *Secret) DecryptWith(c *Configuration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if !s.provider.IsEncrypted() {
+		return errWrongSecretStatus
+	}
+	provider, err := c.getProviderForStatus(BaseSecret{
+		Status:         s.provider.GetStatus(),
+		Payload:        s.provider.GetPayload(),
+		Key:            s.provider.GetKey(),
+		AdditionalData: s.provider.GetAdditionalData(),
+		Mode:           s.provider.GetMode(),
+		KeyVersion:     s.provider.GetKeyVersion(),
+	})
+	if err != nil {
+		return err
+	}
+	if err := provider.Decrypt(); err != nil {
+		return err
+	}
+	current := getConfig()
+	s.provider = current.getSecretProvider(BaseSecret{
+		Status:  SecretStatusPlain,
+		Payload: provider.GetPayload(),
+	})
+	return nil
+}
+
 // TryDecrypt decrypts a Secret object if encrypted.
 // It returns a nil error if the object is not encrypted
 func (s *Secret) TryDecrypt() error {
@@ -12,13 +12,13 @@ import (
 )
 
 type localSecret struct {
-	baseSecret
+	BaseSecret
 	masterKey string
 }
 
-func newLocalSecret(base baseSecret, masterKey string) SecretProvider {
+func newLocalSecret(base BaseSecret, masterKey string) SecretProvider {
 	return &localSecret{
-		baseSecret: base,
+		BaseSecret: base,
 		masterKey:  masterKey,
 	}
 }
@@ -57,6 +57,10 @@ func (s *localSecret) Encrypt() error {
 	s.Payload = base64.StdEncoding.EncodeToString(ciphertext)
 	s.Status = SecretStatusSecretBox
 	s.Mode = s.getEncryptionMode()
+	s.KeyVersion = 0
+	if s.Mode == 1 {
+		s.KeyVersion = getMasterKeyVersion(s.masterKey)
+	}
 	return nil
 }
 
@@ -88,6 +92,7 @@ func (s *localSecret) Decrypt() error {
 	s.Key = ""
 	s.AdditionalData = ""
 	s.Mode = 0
+	s.KeyVersion = 0
 	return nil
 }
 
@@ -3,9 +3,15 @@
 package kms
 
 import (
+	"context"
+	"errors"
+	"net/url"
+	"path"
+
 	// we import hashivault here to be able to disable Vault support using a build tag
 	_ "gocloud.dev/secrets/hashivault"
 
+	"github.com/drakkan/sftpgo/vault"
 	"github.com/drakkan/sftpgo/version"
 )
 
@@ -17,16 +23,57 @@ func init() {
 	version.AddFeature("+vaultkms")
 }
 
-func newVaultSecret(base baseSecret, url, masterKey string) SecretProvider {
+func newVaultSecret(base BaseSecret, url, masterKey string) SecretProvider {
 	return &vaultSecret{
 		baseGCloudSecret{
-			baseSecret: base,
+			BaseSecret: base,
 			url:        url,
 			masterKey:  masterKey,
+			openKeeper: openVaultKeeper,
 		},
 	}
 }
 
+// openVaultKeeper returns a keeper using the Vault client configured inside
+// the "vault" configuration section, if any. Otherwise the Go CDK keeper is
+// used, it is configured using the VAULT_SERVER_URL and VAULT_SERVER_TOKEN
+// environment variables. The ciphertexts are the same in both cases
+func openVaultKeeper(ctx context.Context, keeperURL string) (secretKeeper, error) {
+	if !vault.IsConfigured() {
+		return openGCloudKeeper(ctx, keeperURL)
+	}
+	u, err := url.Parse(keeperURL)
+	if err != nil {
+		return nil, err
+	}
+	keyName := path.Join(u.Host, u.Path)
+	if keyName == "" || keyName == "/" {
+		return nil, errors.New("invalid Vault transit URL, the key name is missing")
+	}
+	return &vaultKeeper{keyName: keyName}, nil
+}
+
+// vaultKeeper encrypts and decrypts the payloads using the transit secrets engine
+type vaultKeeper struct {
+	keyName string
+}
+
+func (k *vaultKeeper) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
+	ciphertext, err := vault.TransitEncrypt(k.keyName, plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(ciphertext), nil
+}
+
+func (k *vaultKeeper) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	return vault.TransitDecrypt(k.keyName, string(ciphertext))
+}
+
+func (k *vaultKeeper) Close() error {
+	return nil
+}
+
 func (s *vaultSecret) Name() string {
 	return vaultProviderName
 }
@@ -12,6 +12,6 @@ func init() {
 	version.AddFeature("-vaultkms")
 }
 
-func newVaultSecret(base baseSecret, url, masterKey string) SecretProvider {
+func newVaultSecret(base BaseSecret, url, masterKey string) SecretProvider {
 	return newDisabledSecret(errors.New("Vault KMS disabled at build time"))
 }
@@ -0,0 +1,187 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Supported access log formats
+const (
+	// AccessLogFormatCommon is the Common Log Format
+	AccessLogFormatCommon = "common"
+	// AccessLogFormatCombined is the Combined Log Format, the Common Log Format
+	// with the referer and the user agent
+	AccessLogFormatCombined = "combined"
+	// AccessLogFormatJSON writes a JSON object for each request
+	AccessLogFormatJSON = "json"
+)
+
+const accessLogTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+var (
+	accessLogFormat     = AccessLogFormatCommon
+	accessLogger        = zerolog.Nop()
+	accessRollingLogger *lumberjack.Logger
+)
+
+// AccessLogConfig defines the configuration for the HTTP access log. The
+// requests to the web admin, the web client and the REST API are written to a
+// separate file, using a format understood by the existing log analyzers
+type AccessLogConfig struct {
+	// Path to the access log file. Leave empty to disable the access log.
+	// A relative path is resolved against the configuration directory
+	FilePath string `json:"file_path" mapstructure:"file_path"`
+	// Format for the access log: "common", "combined" or "json"
+	Format string `json:"format" mapstructure:"format"`
+	// Maximum size in megabytes of the access log file before it gets rotated
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+	// Maximum number of old access log files to retain. 0 means retain all
+	MaxBackups int `json:"max_backups" mapstructure:"max_backups"`
+	// Maximum number of days to retain old access log files. 0 means no limit
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+	// Compress the rotated access log files using gzip
+	Compress bool `json:"compress" mapstructure:"compress"`
+}
+
+// InitAccessLogger configures the HTTP access logger. The access log is
+// disabled if the file path is empty
+func InitAccessLogger(config AccessLogConfig, configDir string) error {
+	accessRollingLogger = nil
+	accessLogger = zerolog.Nop()
+	if config.FilePath == "" {
+		return nil
+	}
+	switch config.Format {
+	case "":
+		config.Format = AccessLogFormatCommon
+	case AccessLogFormatCommon, AccessLogFormatCombined, AccessLogFormatJSON:
+	default:
+		return fmt.Errorf("invalid access log format %#v, supported formats: %v, %v, %v", config.Format,
+			AccessLogFormatCommon, AccessLogFormatCombined, AccessLogFormatJSON)
+	}
+	if !isLogFilePathValid(config.FilePath) {
+		return fmt.Errorf("invalid access log file path %#v", config.FilePath)
+	}
+	if config.MaxSize < 0 || config.MaxBackups < 0 || config.MaxAge < 0 {
+		return errors.New("invalid access log rotation settings, negative values are not allowed")
+	}
+	filePath := config.FilePath
+	if !filepath.IsAbs(filePath) {
+		filePath = filepath.Join(configDir, filePath)
+	}
+	accessRollingLogger = &lumberjack.Logger{
+		Filename:   filePath,
+		MaxSize:    config.MaxSize,
+		MaxBackups: config.MaxBackups,
+		MaxAge:     config.MaxAge,
+		Compress:   config.Compress,
+	}
+	accessLogFormat = config.Format
+	accessLogger = zerolog.New(accessRollingLogger)
+	return nil
+}
+
+// accessLogEntry defines the request details written to the access log
+type accessLogEntry struct {
+	remoteAddr string
+	username   string
+	method     string
+	uri        string
+	proto      string
+	referer    string
+	userAgent  string
+	requestID  string
+	start      time.Time
+}
+
+func newAccessLogEntry(r *http.Request, requestID string) accessLogEntry {
+	username, _, _ := r.BasicAuth()
+	return accessLogEntry{
+		remoteAddr: r.RemoteAddr,
+		username:   username,
+		method:     r.Method,
+		uri:        r.RequestURI,
+		proto:      r.Proto,
+		referer:    r.Referer(),
+		userAgent:  r.UserAgent(),
+		requestID:  requestID,
+		start:      time.Now(),
+	}
+}
+
+func (e *accessLogEntry) getClientIP() string {
+	ip, _, err := net.SplitHostPort(e.remoteAddr)
+	if err != nil {
+		return e.remoteAddr
+	}
+	return ip
+}
+
+func (e *accessLogEntry) write(status, size int, elapsed time.Duration) {
+	if accessRollingLogger == nil {
+		return
+	}
+	if accessLogFormat == AccessLogFormatJSON {
+		accessLogger.Log().
+			Str("time", e.start.Format(time.RFC3339)).
+			Str("client_ip", e.getClientIP()).
+			Str("username", e.username).
+			Str("method", e.method).
+			Str("uri", e.uri).
+			Str("proto", e.proto).
+			Int("resp_status", status).
+			Int("resp_size", size).
+			Int64("elapsed_ms", elapsed.Nanoseconds()/1000000).
+			Str("referer", e.referer).
+			Str("user_agent", e.userAgent).
+			Str("request_id", e.requestID).
+			Send()
+		return
+	}
+	var sb strings.Builder
+	sb.WriteString(getCLFValue(e.getClientIP()))
+	sb.WriteString(" - ")
+	sb.WriteString(getCLFValue(strings.ReplaceAll(e.username, " ", "%20")))
+	sb.WriteString(" [")
+	sb.WriteString(e.start.Format(accessLogTimeFormat))
+	sb.WriteString("] \"")
+	sb.WriteString(escapeCLFString(fmt.Sprintf("%s %s %s", e.method, e.uri, e.proto)))
+	sb.WriteString("\" ")
+	sb.WriteString(strconv.Itoa(status))
+	sb.WriteString(" ")
+	if size > 0 {
+		sb.WriteString(strconv.Itoa(size))
+	} else {
+		sb.WriteString("-")
+	}
+	if accessLogFormat == AccessLogFormatCombined {
+		sb.WriteString(" \"")
+		sb.WriteString(escapeCLFString(getCLFValue(e.referer)))
+		sb.WriteString("\" \"")
+		sb.WriteString(escapeCLFString(getCLFValue(e.userAgent)))
+		sb.WriteString("\"")
+	}
+	sb.WriteString("\n")
+	accessRollingLogger.Write([]byte(sb.String())) //nolint:errcheck
+}
+
+func getCLFValue(val string) string {
+	if val == "" {
+		return "-"
+	}
+	return val
+}
+
+func escapeCLFString(val string) string {
+	val = strings.ReplaceAll(val, `\`, `\\`)
+	return strings.ReplaceAll(val, `"`, `\"`)
+}
@@ -0,0 +1,186 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+)
+
+// audit events
+const (
+	AuditEventLogin            = "login"
+	AuditEventAdminLogin       = "admin_login"
+	AuditEventPermissionDenied = "permission_denied"
+	AuditEventAdminRequest     = "admin_request"
+	AuditEventHostBanned       = "host_banned"
+	AuditEventApproval         = "approval"
+	AuditEventImpersonation    = "impersonation"
+)
+
+// impersonation actions
+const (
+	AuditImpersonationStart = "start"
+	AuditImpersonationEnd   = "end"
+)
+
+var (
+	auditLogger        = zerolog.Nop()
+	auditRollingLogger *lumberjack.Logger
+)
+
+// AuditLogConfig defines the configuration for the audit log. The audit log
+// contains the security-relevant events, such as logins, permission denials,
+// admin changes and defender bans, and it is separated from the other logs
+type AuditLogConfig struct {
+	// Path to the audit log file. Leave empty to disable the audit log.
+	// A relative path is resolved against the configuration directory
+	FilePath string `json:"file_path" mapstructure:"file_path"`
+	// Maximum size in megabytes of the audit log file before it gets rotated
+	MaxSize int `json:"max_size" mapstructure:"max_size"`
+	// Maximum number of old audit log files to retain. 0 means retain all
+	MaxBackups int `json:"max_backups" mapstructure:"max_backups"`
+	// Maximum number of days to retain old audit log files. 0 means no limit
+	MaxAge int `json:"max_age" mapstructure:"max_age"`
+	// Compress the rotated audit log files using gzip
+	Compress bool `json:"compress" mapstructure:"compress"`
+}
+
+// InitAuditLogger configures the audit logger. The audit log is disabled if
+// the file path is empty
+func InitAuditLogger(config AuditLogConfig, configDir string) error {
+	auditRollingLogger = nil
+	auditLogger = zerolog.Nop()
+	if config.FilePath == "" {
+		return nil
+	}
+	if !isLogFilePathValid(config.FilePath) {
+		return fmt.Errorf("invalid audit log file path %#v", config.FilePath)
+	}
+	if config.MaxSize < 0 || config.MaxBackups < 0 || config.MaxAge < 0 {
+		return errors.New("invalid audit log rotation settings, negative values are not allowed")
+	}
+	filePath := config.FilePath
+	if !filepath.IsAbs(filePath) {
+		filePath = filepath.Join(configDir, filePath)
+	}
+	auditRollingLogger = &lumberjack.Logger{
+		Filename:   filePath,
+		MaxSize:    config.MaxSize,
+		MaxBackups: config.MaxBackups,
+		MaxAge:     config.MaxAge,
+		Compress:   config.Compress,
+	}
+	var w io.Writer = auditRollingLogger
+	if logFormat == LogFormatECS {
+		w = &ecsWriter{
+			output: w,
+		}
+	}
+	auditLogger = zerolog.New(w)
+	return nil
+}
+
+// IsAuditLogEnabled returns true if the audit log is enabled
+func IsAuditLogEnabled() bool {
+	return auditRollingLogger != nil
+}
+
+func getAuditEvent(event string) *zerolog.Event {
+	return auditLogger.Log().
+		Timestamp().
+		Str("sender", "audit").
+		Str("event", event)
+}
+
+func getAuditStatus(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+// AuditLogin logs a user login attempt
+func AuditLogin(user, ip, loginMethod, protocol string, err error) {
+	getAuditEvent(AuditEventLogin).
+		Str("username", user).
+		Str("client_ip", ip).
+		Str("login_method", loginMethod).
+		Str("protocol", protocol).
+		Str("status", getAuditStatus(err)).
+		Err(err).
+		Send()
+}
+
+// AuditAdminLogin logs an admin login attempt
+func AuditAdminLogin(admin, ip, loginMethod, protocol string, err error) {
+	getAuditEvent(AuditEventAdminLogin).
+		Str("username", admin).
+		Str("client_ip", ip).
+		Str("login_method", loginMethod).
+		Str("protocol", protocol).
+		Str("status", getAuditStatus(err)).
+		Err(err).
+		Send()
+}
+
+// AuditPermissionDenied logs an operation denied for missing permissions
+func AuditPermissionDenied(user, ip, connectionID, protocol string) {
+	getAuditEvent(AuditEventPermissionDenied).
+		Str("username", user).
+		Str("client_ip", ip).
+		Str("connection_id", connectionID).
+		Str("protocol", protocol).
+		Send()
+}
+
+// AuditAdminRequest logs a request, from an admin, that can modify the server
+// state, for example adding a user or restoring a backup
+func AuditAdminRequest(admin, ip, method, uri, requestID string, status int) {
+	getAuditEvent(AuditEventAdminRequest).
+		Str("username", admin).
+		Str("client_ip", ip).
+		Str("method", method).
+		Str("uri", uri).
+		Str("request_id", requestID).
+		Int("resp_status", status).
+		Send()
+}
+
+// AuditHostBanned logs a host banned by the defender
+func AuditHostBanned(ip string, banTime time.Time) {
+	getAuditEvent(AuditEventHostBanned).
+		Str("client_ip", ip).
+		Time("ban_time", banTime).
+		Send()
+}
+
+// AuditApproval logs a change for an approval request. The action is the
+// request status after the change, for example pending for new requests
+func AuditApproval(admin, ip, requestID, operation, objectName, action string, err error) {
+	getAuditEvent(AuditEventApproval).
+		Str("username", admin).
+		Str("client_ip", ip).
+		Str("approval_id", requestID).
+		Str("operation", operation).
+		Str("object_name", objectName).
+		Str("action", action).
+		Str("status", getAuditStatus(err)).
+		Err(err).
+		Send()
+}
+
+// AuditImpersonation logs the start or the end, as specified by action, of a
+// web client session started by an admin impersonating a user
+func AuditImpersonation(admin, user, ip, action string) {
+	getAuditEvent(AuditEventImpersonation).
+		Str("username", admin).
+		Str("impersonated_user", user).
+		Str("client_ip", ip).
+		Str("action", action).
+		Send()
+}
@@ -0,0 +1,63 @@
+package logger
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readAuditEntries(t *testing.T, logFilePath string) []map[string]interface{} {
+	data, err := os.ReadFile(logFilePath)
+	require.NoError(t, err)
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry map[string]interface{}
+		err := json.Unmarshal([]byte(line), &entry)
+		require.NoError(t, err, line)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestAuditImpersonation(t *testing.T) {
+	configDir := t.TempDir()
+	err := InitAuditLogger(AuditLogConfig{FilePath: "audit.log"}, configDir)
+	require.NoError(t, err)
+	defer InitAuditLogger(AuditLogConfig{}, configDir) //nolint:errcheck
+
+	assert.True(t, IsAuditLogEnabled())
+	AuditImpersonation("admin", "user1", "127.0.0.1", AuditImpersonationStart)
+	AuditImpersonation("admin", "user1", "127.0.0.1", AuditImpersonationEnd)
+	entries := readAuditEntries(t, filepath.Join(configDir, "audit.log"))
+	require.Len(t, entries, 2)
+	for idx, action := range []string{AuditImpersonationStart, AuditImpersonationEnd} {
+		assert.Equal(t, "audit", entries[idx]["sender"])
+		assert.Equal(t, AuditEventImpersonation, entries[idx]["event"])
+		assert.Equal(t, "admin", entries[idx]["username"])
+		assert.Equal(t, "user1", entries[idx]["impersonated_user"])
+		assert.Equal(t, "127.0.0.1", entries[idx]["client_ip"])
+		assert.Equal(t, action, entries[idx]["action"])
+	}
+
+	require.NoError(t, SetLogFormat(LogFormatECS))
+	defer SetLogFormat("") //nolint:errcheck
+
+	err = InitAuditLogger(AuditLogConfig{FilePath: "audit_ecs.log"}, configDir)
+	require.NoError(t, err)
+	AuditImpersonation("admin", "user1", "127.0.0.1", AuditImpersonationStart)
+	entries = readAuditEntries(t, filepath.Join(configDir, "audit_ecs.log"))
+	require.Len(t, entries, 1)
+	assert.Equal(t, "admin", entries[0]["user.name"])
+	assert.Equal(t, "user1", entries[0]["user.target.name"])
+
+	err = InitAuditLogger(AuditLogConfig{}, configDir)
+	require.NoError(t, err)
+	assert.False(t, IsAuditLogEnabled())
+	// the events are discarded if the audit log is disabled
+	AuditImpersonation("admin", "user1", "127.0.0.1", AuditImpersonationStart)
+}
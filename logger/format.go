@@ -0,0 +1,141 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Supported log formats
+const (
+	// LogFormatJSON writes the logs as JSON using the SFTPGo field names
+	LogFormatJSON = "json"
+	// LogFormatECS writes the logs as JSON using the Elastic Common Schema field names
+	LogFormatECS = "ecs"
+	// LogFormatConsole writes the logs in a human-readable format, with colors
+	// if the output is a terminal
+	LogFormatConsole = "console"
+)
+
+const (
+	ecsVersion    = "1.12.0"
+	ecsTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+)
+
+var (
+	logFormat = LogFormatJSON
+	// ecsFieldNames maps the SFTPGo log fields to the Elastic Common Schema
+	// fields, the other fields are written inside the "sftpgo" namespace
+	ecsFieldNames = map[string]string{
+		zerolog.LevelFieldName:   "log.level",
+		zerolog.MessageFieldName: "message",
+		"sender":                 "log.logger",
+		"error":                  "error.message",
+		"username":               "user.name",
+		"impersonated_user":      "user.target.name",
+		"client_ip":              "client.ip",
+		"remote_addr":            "client.address",
+		"protocol":               "network.protocol",
+		"file_path":              "file.path",
+		"target_path":            "file.target_path",
+		"size_bytes":             "file.size",
+		"method":                 "http.request.method",
+		"request_id":             "http.request.id",
+		"resp_status":            "http.response.status_code",
+		"resp_size":              "http.response.body.bytes",
+		"user_agent":             "user_agent.original",
+		"uri":                    "url.full",
+	}
+)
+
+// SetLogFormat sets the format for the logs written by the next logger
+// initialization. An empty format means LogFormatJSON.
+// The format is ignored for journald
+func SetLogFormat(format string) error {
+	switch format {
+	case "":
+		logFormat = LogFormatJSON
+	case LogFormatJSON, LogFormatECS, LogFormatConsole:
+		logFormat = format
+	default:
+		return fmt.Errorf("invalid log format %#v, supported formats: %v, %v, %v", format, LogFormatJSON,
+			LogFormatECS, LogFormatConsole)
+	}
+	return nil
+}
+
+// getLogWriter returns a writer for the configured log format
+func getLogWriter(w io.Writer, output *os.File) io.Writer {
+	switch logFormat {
+	case LogFormatECS:
+		return &ecsWriter{
+			output: w,
+		}
+	case LogFormatConsole:
+		return zerolog.ConsoleWriter{
+			Out:        w,
+			TimeFormat: dateFormat,
+			NoColor:    !isTerminal(output),
+		}
+	}
+	return w
+}
+
+func isTerminal(f *os.File) bool {
+	if f == nil || runtime.GOOS == "windows" {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// ecsWriter rewrites the JSON logs using the Elastic Common Schema field names
+type ecsWriter struct {
+	output io.Writer
+}
+
+func (w *ecsWriter) Write(p []byte) (int, error) {
+	var fields map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return w.output.Write(p)
+	}
+	ecsFields := make(map[string]interface{}, len(fields)+1)
+	ecsFields["ecs.version"] = ecsVersion
+	for name, value := range fields {
+		if name == zerolog.TimestampFieldName {
+			if val, ok := value.(string); ok {
+				if t, err := time.ParseInLocation(dateFormat, val, time.Local); err == nil {
+					value = t.Format(ecsTimeFormat)
+				}
+			}
+			ecsFields["@timestamp"] = value
+			continue
+		}
+		if ecsName, ok := ecsFieldNames[name]; ok {
+			ecsFields[ecsName] = value
+		} else {
+			ecsFields["sftpgo."+name] = value
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(ecsFields); err != nil {
+		return w.output.Write(p)
+	}
+	if _, err := w.output.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,129 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetLogFormat(t *testing.T) {
+	defer SetLogFormat("") //nolint:errcheck
+
+	for _, format := range []string{LogFormatJSON, LogFormatECS, LogFormatConsole} {
+		err := SetLogFormat(format)
+		assert.NoError(t, err)
+		assert.Equal(t, format, logFormat)
+	}
+	err := SetLogFormat("")
+	assert.NoError(t, err)
+	assert.Equal(t, LogFormatJSON, logFormat)
+	err = SetLogFormat("xml")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "invalid log format")
+	}
+	// the current format is not changed
+	assert.Equal(t, LogFormatJSON, logFormat)
+}
+
+func TestGetLogWriter(t *testing.T) {
+	defer SetLogFormat("") //nolint:errcheck
+
+	var buf bytes.Buffer
+	assert.Equal(t, &buf, getLogWriter(&buf, nil))
+	require.NoError(t, SetLogFormat(LogFormatECS))
+	_, ok := getLogWriter(&buf, nil).(*ecsWriter)
+	assert.True(t, ok)
+	require.NoError(t, SetLogFormat(LogFormatConsole))
+	w, ok := getLogWriter(&buf, nil).(zerolog.ConsoleWriter)
+	require.True(t, ok)
+	assert.True(t, w.NoColor)
+	// no colors if the output is not a terminal
+	f, err := os.Create(filepath.Join(t.TempDir(), "log"))
+	require.NoError(t, err)
+	defer f.Close()
+
+	w, ok = getLogWriter(f, f).(zerolog.ConsoleWriter)
+	require.True(t, ok)
+	assert.True(t, w.NoColor)
+
+	l := zerolog.New(w)
+	l.Info().Str("sender", "sftpd").Msg("console message")
+	data, err := os.ReadFile(f.Name())
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "console message")
+	assert.Contains(t, string(data), "sender=sftpd")
+	assert.NotContains(t, string(data), "\x1b[")
+}
+
+func TestECSWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &ecsWriter{
+		output: &buf,
+	}
+	oldTimeFormat := zerolog.TimeFieldFormat
+	zerolog.TimeFieldFormat = dateFormat
+	defer func() {
+		zerolog.TimeFieldFormat = oldTimeFormat
+	}()
+
+	l := zerolog.New(w)
+	l.Info().Timestamp().Str("sender", "SFTP").Str("username", "user1").Str("protocol", "SFTP").
+		Str("connection_id", "connID").Int64("size_bytes", 1024).Str("file_path", "/<file>&.txt").Msg("upload")
+	// the HTML characters are not escaped
+	assert.Contains(t, buf.String(), "/<file>&.txt")
+	var entry map[string]interface{}
+	dec := json.NewDecoder(&buf)
+	dec.UseNumber()
+	err := dec.Decode(&entry)
+	require.NoError(t, err)
+	assert.Equal(t, ecsVersion, entry["ecs.version"])
+	assert.Equal(t, "info", entry["log.level"])
+	assert.Equal(t, "upload", entry["message"])
+	assert.Equal(t, "SFTP", entry["log.logger"])
+	assert.Equal(t, "user1", entry["user.name"])
+	assert.Equal(t, "SFTP", entry["network.protocol"])
+	assert.Equal(t, json.Number("1024"), entry["file.size"])
+	assert.Equal(t, "/<file>&.txt", entry["file.path"])
+	// the fields without an ECS equivalent are namespaced
+	assert.Equal(t, "connID", entry["sftpgo.connection_id"])
+	for _, name := range []string{"level", "sender", "username", "time", "connection_id"} {
+		assert.NotContains(t, entry, name)
+	}
+	timestamp, ok := entry["@timestamp"].(string)
+	require.True(t, ok)
+	ts, err := time.Parse(ecsTimeFormat, timestamp)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), ts, 10*time.Second)
+	// the entries that are not valid JSON are written unchanged
+	buf.Reset()
+	n, err := w.Write([]byte("not json\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 9, n)
+	assert.Equal(t, "not json\n", buf.String())
+}
+
+func TestInitLoggerECSFormat(t *testing.T) {
+	require.NoError(t, SetLogFormat(LogFormatECS))
+	defer SetLogFormat("") //nolint:errcheck
+	defer DisableLogger()
+
+	logFilePath := filepath.Join(t.TempDir(), "sftpgo.log")
+	InitLogger(logFilePath, 10, 1, 1, false, zerolog.DebugLevel)
+	ConnectionLog(LevelInfo, "connID", "user1", "SFTP", nil, "test message")
+	data, err := os.ReadFile(logFilePath)
+	require.NoError(t, err)
+	var entry map[string]interface{}
+	err = json.Unmarshal([]byte(strings.TrimSpace(string(data))), &entry)
+	require.NoError(t, err)
+	assert.Equal(t, "test message", entry["message"])
+	assert.Equal(t, "user1", entry["user.name"])
+	assert.Equal(t, "connID", entry["sftpgo.connection_id"])
+}
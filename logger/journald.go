@@ -3,12 +3,22 @@
 package logger
 
 import (
+	"github.com/coreos/go-systemd/journal"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/journald"
 )
 
-// InitJournalDLogger configures the logger to write to journald
+// InitJournalDLogger configures the logger to write to journald.
+// The log fields are sent as journal fields with upper case names, for
+// example SENDER, CONNECTION_ID, USERNAME and PROTOCOL
 func InitJournalDLogger(level zerolog.Level) {
-	logger = zerolog.New(journald.NewJournalDWriter()).Level(level)
+	logOutput = journald.NewJournalDWriter()
+	logger = zerolog.New(logOutput).Level(zerolog.DebugLevel)
 	consoleLogger = zerolog.Nop()
+	setDefaultLevel(level)
+}
+
+// IsJournalDAvailable returns true if the journald socket is available
+func IsJournalDAvailable() bool {
+	return journal.Enabled()
 }
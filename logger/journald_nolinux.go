@@ -8,3 +8,8 @@ import "github.com/rs/zerolog"
 func InitJournalDLogger(level zerolog.Level) {
 	InitStdErrLogger(level)
 }
+
+// IsJournalDAvailable returns true if the journald socket is available
+func IsJournalDAvailable() bool {
+	return false
+}
@@ -0,0 +1,144 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	levelsMutex    sync.RWMutex
+	defaultLevel   = zerolog.DebugLevel
+	levelOverrides = make(map[string]zerolog.Level)
+)
+
+// LogLevels defines the default log level and the per-sender overrides.
+// The senders are case insensitive, for example "sftpd", "dataprovider",
+// "httpd" or a protocol, such as "SFTP", for the client connections logs
+type LogLevels struct {
+	Default   string            `json:"default"`
+	Overrides map[string]string `json:"overrides"`
+}
+
+// GetLogLevels returns the default log level and the per-sender overrides
+func GetLogLevels() LogLevels {
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+
+	levels := LogLevels{
+		Default:   defaultLevel.String(),
+		Overrides: make(map[string]string),
+	}
+	for sender, level := range levelOverrides {
+		levels.Overrides[sender] = level.String()
+	}
+	return levels
+}
+
+// SetLogLevels replaces the per-sender log level overrides and sets the
+// default log level, if not empty. The new levels are applied immediately
+func SetLogLevels(levels LogLevels) error {
+	var level zerolog.Level
+	var err error
+	if levels.Default != "" {
+		level, err = parseLevel(levels.Default)
+		if err != nil {
+			return err
+		}
+	}
+	overrides := make(map[string]zerolog.Level)
+	for sender, value := range levels.Overrides {
+		sender = strings.ToLower(strings.TrimSpace(sender))
+		if sender == "" {
+			return fmt.Errorf("invalid log level override %#v, the sender is required", value)
+		}
+		overrides[sender], err = parseLevel(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	levelsMutex.Lock()
+	defer levelsMutex.Unlock()
+
+	if levels.Default != "" {
+		defaultLevel = level
+	}
+	levelOverrides = overrides
+	return nil
+}
+
+// ParseLevelOverrides parses the per-sender log level overrides specified
+// as "sender1=level1,sender2=level2", for example "sftpd=debug,dataprovider=warn"
+func ParseLevelOverrides(value string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		pair := strings.SplitN(item, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return nil, fmt.Errorf("invalid log level override %#v, the expected format is \"sender=level\"", item)
+		}
+		if _, err := parseLevel(pair[1]); err != nil {
+			return nil, err
+		}
+		overrides[strings.TrimSpace(pair[0])] = strings.TrimSpace(pair[1])
+	}
+	return overrides, nil
+}
+
+func setDefaultLevel(level zerolog.Level) {
+	levelsMutex.Lock()
+	defer levelsMutex.Unlock()
+
+	defaultLevel = level
+}
+
+// IsLevelEnabled returns true if the given level is enabled for the specified sender
+func IsLevelEnabled(sender string, level LogLevel) bool {
+	return isLevelEnabled(sender, getZerologLevel(level))
+}
+
+// isLevelEnabled returns true if the given level is enabled for the specified sender
+func isLevelEnabled(sender string, level zerolog.Level) bool {
+	levelsMutex.RLock()
+	defer levelsMutex.RUnlock()
+
+	if len(levelOverrides) > 0 {
+		if override, ok := levelOverrides[strings.ToLower(sender)]; ok {
+			return level >= override
+		}
+	}
+	return level >= defaultLevel
+}
+
+func parseLevel(level string) (zerolog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return zerolog.DebugLevel, nil
+	case "info":
+		return zerolog.InfoLevel, nil
+	case "warn", "warning":
+		return zerolog.WarnLevel, nil
+	case "error":
+		return zerolog.ErrorLevel, nil
+	}
+	return zerolog.NoLevel, fmt.Errorf("invalid log level %#v, supported levels: debug, info, warn, error", level)
+}
+
+func getZerologLevel(level LogLevel) zerolog.Level {
+	switch level {
+	case LevelDebug:
+		return zerolog.DebugLevel
+	case LevelInfo:
+		return zerolog.InfoLevel
+	case LevelWarn:
+		return zerolog.WarnLevel
+	default:
+		return zerolog.ErrorLevel
+	}
+}
@@ -17,6 +17,8 @@ import (
 
 	"github.com/rs/zerolog"
 	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/drakkan/sftpgo/geoip"
 )
 
 const (
@@ -79,7 +81,10 @@ func (l *LeveledLogger) addKeysAndValues(ev *zerolog.Event, keysAndValues ...int
 
 // Error logs at error level for the specified sender
 func (l *LeveledLogger) Error(msg string, keysAndValues ...interface{}) {
-	ev := logger.Error()
+	ev := getLogEvent(zerolog.ErrorLevel, l.Sender)
+	if ev == nil {
+		return
+	}
 	ev.Timestamp().Str("sender", l.Sender)
 	l.addKeysAndValues(ev, keysAndValues...)
 	ev.Msg(msg)
@@ -87,7 +92,10 @@ func (l *LeveledLogger) Error(msg string, keysAndValues ...interface{}) {
 
 // Info logs at info level for the specified sender
 func (l *LeveledLogger) Info(msg string, keysAndValues ...interface{}) {
-	ev := logger.Info()
+	ev := getLogEvent(zerolog.InfoLevel, l.Sender)
+	if ev == nil {
+		return
+	}
 	ev.Timestamp().Str("sender", l.Sender)
 	l.addKeysAndValues(ev, keysAndValues...)
 	ev.Msg(msg)
@@ -95,7 +103,10 @@ func (l *LeveledLogger) Info(msg string, keysAndValues ...interface{}) {
 
 // Debug logs at debug level for the specified sender
 func (l *LeveledLogger) Debug(msg string, keysAndValues ...interface{}) {
-	ev := logger.Debug()
+	ev := getLogEvent(zerolog.DebugLevel, l.Sender)
+	if ev == nil {
+		return
+	}
 	ev.Timestamp().Str("sender", l.Sender)
 	l.addKeysAndValues(ev, keysAndValues...)
 	ev.Msg(msg)
@@ -103,7 +114,10 @@ func (l *LeveledLogger) Debug(msg string, keysAndValues ...interface{}) {
 
 // Warn logs at warn level for the specified sender
 func (l *LeveledLogger) Warn(msg string, keysAndValues ...interface{}) {
-	ev := logger.Warn()
+	ev := getLogEvent(zerolog.WarnLevel, l.Sender)
+	if ev == nil {
+		return
+	}
 	ev.Timestamp().Str("sender", l.Sender)
 	l.addKeysAndValues(ev, keysAndValues...)
 	ev.Msg(msg)
@@ -125,29 +139,35 @@ func InitLogger(logFilePath string, logMaxSize int, logMaxBackups int, logMaxAge
 			MaxAge:     logMaxAge,
 			Compress:   logCompress,
 		}
-		logger = zerolog.New(rollingLogger)
+		logOutput = getLogWriter(rollingLogger, nil)
 		EnableConsoleLogger(level)
 	} else {
-		logger = zerolog.New(&logSyncWrapper{
+		logOutput = getLogWriter(&logSyncWrapper{
 			output: os.Stdout,
-		})
+		}, os.Stdout)
 		consoleLogger = zerolog.Nop()
 	}
-	logger = logger.Level(level)
+	logger = zerolog.New(logOutput)
+	// the levels are checked for each sender, see isLevelEnabled
+	logger = logger.Level(zerolog.DebugLevel)
+	setDefaultLevel(level)
 }
 
 // InitStdErrLogger configures the logger to write to stderr
 func InitStdErrLogger(level zerolog.Level) {
-	logger = zerolog.New(&logSyncWrapper{
+	logOutput = getLogWriter(&logSyncWrapper{
 		output: os.Stderr,
-	}).Level(level)
+	}, os.Stderr)
+	logger = zerolog.New(logOutput).Level(zerolog.DebugLevel)
 	consoleLogger = zerolog.Nop()
+	setDefaultLevel(level)
 }
 
 // DisableLogger disable the main logger.
 // ConsoleLogger will not be affected
 func DisableLogger() {
 	logger = zerolog.Nop()
+	logOutput = nil
 	rollingLogger = nil
 }
 
@@ -161,26 +181,39 @@ func EnableConsoleLogger(level zerolog.Level) {
 	consoleLogger = zerolog.New(consoleOutput).With().Timestamp().Logger().Level(level)
 }
 
-// RotateLogFile closes the existing log file and immediately create a new one
+// RotateLogFile closes the existing log file and immediately create a new one.
+// The audit and access log files, if enabled, are rotated too
 func RotateLogFile() error {
+	if auditRollingLogger != nil {
+		if err := auditRollingLogger.Rotate(); err != nil {
+			return err
+		}
+	}
+	if accessRollingLogger != nil {
+		if err := accessRollingLogger.Rotate(); err != nil {
+			return err
+		}
+	}
 	if rollingLogger != nil {
 		return rollingLogger.Rotate()
 	}
 	return errors.New("logging to file is disabled")
 }
 
+// getLogEvent returns a new event or nil if the level is not enabled for the
+// specified sender
+func getLogEvent(level zerolog.Level, sender string) *zerolog.Event {
+	if !isLevelEnabled(sender, level) {
+		return nil
+	}
+	return logger.WithLevel(level)
+}
+
 // Log logs at the specified level for the specified sender
 func Log(level LogLevel, sender string, connectionID string, format string, v ...interface{}) {
-	var ev *zerolog.Event
-	switch level {
-	case LevelDebug:
-		ev = logger.Debug()
-	case LevelInfo:
-		ev = logger.Info()
-	case LevelWarn:
-		ev = logger.Warn()
-	default:
-		ev = logger.Error()
+	ev := getSampledLogEvent(getZerologLevel(level), sender, format)
+	if ev == nil {
+		return
 	}
 	ev.Timestamp().Str("sender", sender)
 	if connectionID != "" {
@@ -189,6 +222,40 @@ func Log(level LogLevel, sender string, connectionID string, format string, v ..
 	ev.Msg(fmt.Sprintf(format, v...))
 }
 
+// ConnectionLog logs at the specified level for the specified client connection.
+// The protocol is used as sender, the username is omitted if empty, the country
+// and the autonomous system are omitted if unknown
+func ConnectionLog(level LogLevel, connectionID, username, protocol string, geoInfo *geoip.Info, format string,
+	v ...interface{},
+) {
+	ev := getSampledLogEvent(getZerologLevel(level), protocol, format)
+	if ev == nil {
+		return
+	}
+	ev.Timestamp().Str("sender", protocol).Str("connection_id", connectionID)
+	if username != "" {
+		ev.Str("username", username)
+	}
+	ev.Str("protocol", protocol)
+	addGeoIPInfo(ev, geoInfo)
+	ev.Msg(fmt.Sprintf(format, v...))
+}
+
+func addGeoIPInfo(ev *zerolog.Event, geoInfo *geoip.Info) {
+	if geoInfo == nil {
+		return
+	}
+	if geoInfo.Country != "" {
+		ev.Str("country", geoInfo.Country)
+	}
+	if geoInfo.ASN > 0 {
+		ev.Uint("asn", geoInfo.ASN)
+	}
+	if geoInfo.ASOrganization != "" {
+		ev.Str("as_organization", geoInfo.ASOrganization)
+	}
+}
+
 // Debug logs at debug level for the specified sender
 func Debug(sender string, connectionID string, format string, v ...interface{}) {
 	Log(LevelDebug, sender, connectionID, format, v...)
@@ -231,7 +298,7 @@ func ErrorToConsole(format string, v ...interface{}) {
 
 // TransferLog logs uploads or downloads
 func TransferLog(operation string, path string, elapsed int64, size int64, user string, connectionID string, protocol string) {
-	logger.Info().
+	getLogEvent(zerolog.InfoLevel, operation).
 		Timestamp().
 		Str("sender", operation).
 		Int64("elapsed_ms", elapsed).
@@ -246,7 +313,7 @@ func TransferLog(operation string, path string, elapsed int64, size int64, user
 // CommandLog logs an SFTP/SCP/SSH command
 func CommandLog(command, path, target, user, fileMode, connectionID, protocol string, uid, gid int, atime, mtime,
 	sshCommand string, size int64) {
-	logger.Info().
+	getLogEvent(zerolog.InfoLevel, command).
 		Timestamp().
 		Str("sender", command).
 		Str("username", user).
@@ -268,16 +335,22 @@ func CommandLog(command, path, target, user, fileMode, connectionID, protocol st
 // A connection can fail for an authentication error or other errors such as
 // a client abort or a time out if the login does not happen in two minutes.
 // These logs are useful for better integration with Fail2ban and similar tools.
+// The client country and autonomous system are included if known
 func ConnectionFailedLog(user, ip, loginType, protocol, errorString string) {
-	logger.Debug().
-		Timestamp().
+	ev := getSampledLogEvent(zerolog.DebugLevel, "connection_failed", protocol+" "+loginType)
+	if ev == nil {
+		return
+	}
+	ev.Timestamp().
 		Str("sender", "connection_failed").
 		Str("client_ip", ip).
 		Str("username", user).
 		Str("login_type", loginType).
 		Str("protocol", protocol).
-		Str("error", errorString).
-		Send()
+		Str("error", errorString)
+	geoInfo := geoip.Lookup(ip)
+	addGeoIPInfo(ev, &geoInfo)
+	ev.Send()
 }
 
 func isLogFilePathValid(logFilePath string) bool {
@@ -0,0 +1,105 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/geoip"
+)
+
+// setTestLogger writes the logs to the given writer until the test ends
+func setTestLogger(t *testing.T, w io.Writer) {
+	oldLogger := logger
+	t.Cleanup(func() {
+		logger = oldLogger
+		setDefaultLevel(zerolog.DebugLevel)
+	})
+	logger = zerolog.New(w).Level(zerolog.DebugLevel)
+	setDefaultLevel(zerolog.DebugLevel)
+}
+
+func getLogEntries(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		err := json.Unmarshal([]byte(line), &entry)
+		require.NoError(t, err, line)
+		entries = append(entries, entry)
+	}
+	buf.Reset()
+	return entries
+}
+
+func TestConnectionLog(t *testing.T) {
+	var buf bytes.Buffer
+	setTestLogger(t, &buf)
+
+	ConnectionLog(LevelInfo, "connID", "user1", "SFTP", nil, "file %#v uploaded", "file.txt")
+	entries := getLogEntries(t, &buf)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "info", entries[0]["level"])
+	assert.Equal(t, "SFTP", entries[0]["sender"])
+	assert.Equal(t, "connID", entries[0]["connection_id"])
+	assert.Equal(t, "user1", entries[0]["username"])
+	assert.Equal(t, "SFTP", entries[0]["protocol"])
+	assert.Equal(t, `file "file.txt" uploaded`, entries[0]["message"])
+	assert.NotContains(t, entries[0], "country")
+	assert.NotContains(t, entries[0], "asn")
+	// the username is not known before the authentication
+	ConnectionLog(LevelWarn, "connID", "", "FTP", &geoip.Info{Country: "IT", ASN: 3269, ASOrganization: "Telecom Italia"},
+		"login failed")
+	entries = getLogEntries(t, &buf)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "warn", entries[0]["level"])
+	assert.Equal(t, "FTP", entries[0]["sender"])
+	assert.NotContains(t, entries[0], "username")
+	assert.Equal(t, "IT", entries[0]["country"])
+	assert.Equal(t, float64(3269), entries[0]["asn"])
+	assert.Equal(t, "Telecom Italia", entries[0]["as_organization"])
+	ConnectionLog(LevelError, "connID", "user1", "SSH", &geoip.Info{}, "error")
+	entries = getLogEntries(t, &buf)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "error", entries[0]["level"])
+	assert.NotContains(t, entries[0], "country")
+	assert.NotContains(t, entries[0], "asn")
+	assert.NotContains(t, entries[0], "as_organization")
+	// the protocol is used as sender for the per-sender levels
+	err := SetLogLevels(LogLevels{
+		Overrides: map[string]string{"sftp": "warn"},
+	})
+	require.NoError(t, err)
+	defer SetLogLevels(LogLevels{}) //nolint:errcheck
+
+	ConnectionLog(LevelInfo, "connID", "user1", "SFTP", nil, "info message")
+	ConnectionLog(LevelInfo, "connID", "user1", "FTP", nil, "info message")
+	entries = getLogEntries(t, &buf)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "FTP", entries[0]["protocol"])
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	setTestLogger(t, &buf)
+
+	Log(LevelDebug, "sender", "", "message %d", 1)
+	Info("sender", "connID", "message %d", 2)
+	entries := getLogEntries(t, &buf)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "debug", entries[0]["level"])
+	assert.Equal(t, "sender", entries[0]["sender"])
+	assert.NotContains(t, entries[0], "connection_id")
+	assert.Equal(t, "message 1", entries[0]["message"])
+	assert.Equal(t, "info", entries[1]["level"])
+	assert.Equal(t, "connID", entries[1]["connection_id"])
+	assert.NotContains(t, entries[1], "username")
+}
@@ -0,0 +1,388 @@
+package logger
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Supported formats for the remote log
+const (
+	// RemoteLogFormatJSON sends the logs as newline-delimited JSON
+	RemoteLogFormatJSON = "json"
+	// RemoteLogFormatGELF sends the logs using the Graylog Extended Log Format,
+	// the messages are delimited by a null byte as expected by the GELF TCP inputs
+	RemoteLogFormatGELF = "gelf"
+)
+
+const (
+	remoteLogDialTimeout    = 10 * time.Second
+	remoteLogWriteTimeout   = 10 * time.Second
+	remoteLogMinRetryDelay  = 1 * time.Second
+	remoteLogMaxRetryDelay  = 60 * time.Second
+	remoteLogFlushTimeout   = 5 * time.Second
+	remoteLogGELFVersion    = "1.1"
+	remoteLogDroppedMessage = "%d log entries were dropped while the remote log endpoint was unavailable"
+)
+
+var (
+	// logOutput is the writer used by the main logger, the remote log writer
+	// is added to it
+	logOutput    io.Writer
+	remoteWriter *remoteLogWriter
+	// gelfLevels maps the zerolog levels to the syslog severity levels used by GELF
+	gelfLevels = map[string]int{
+		zerolog.DebugLevel.String(): 7,
+		zerolog.InfoLevel.String():  6,
+		zerolog.WarnLevel.String():  4,
+		zerolog.ErrorLevel.String(): 3,
+		zerolog.FatalLevel.String(): 2,
+		zerolog.PanicLevel.String(): 0,
+	}
+)
+
+// RemoteLogConfig defines the configuration to send the logs to a remote
+// aggregator, such as Graylog or Logstash, over TCP
+type RemoteLogConfig struct {
+	// Address of the remote endpoint as host:port. Leave empty to disable
+	Address string `json:"address" mapstructure:"address"`
+	// Format for the logs, "json" or "gelf"
+	Format string `json:"format" mapstructure:"format"`
+	// Set to true to connect to the remote endpoint using TLS
+	EnableTLS bool `json:"enable_tls" mapstructure:"enable_tls"`
+	// Paths to extra CA certificates to trust for TLS connections.
+	// The paths can be absolute or relative to the config dir
+	CACertificates []string `json:"ca_certificates" mapstructure:"ca_certificates"`
+	// Set to true to skip the server certificate verification for TLS connections
+	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
+	// Maximum number of log entries buffered in memory while the remote
+	// endpoint is unavailable. The oldest entries are dropped if the buffer is full
+	BufferSize int `json:"buffer_size" mapstructure:"buffer_size"`
+}
+
+func (c *RemoteLogConfig) validate() error {
+	if c.Format == "" {
+		c.Format = RemoteLogFormatJSON
+	}
+	if c.Format != RemoteLogFormatJSON && c.Format != RemoteLogFormatGELF {
+		return fmt.Errorf("invalid remote log format %#v, supported formats: %v, %v", c.Format, RemoteLogFormatJSON,
+			RemoteLogFormatGELF)
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("invalid remote log address %#v: %w", c.Address, err)
+	}
+	if c.BufferSize <= 0 {
+		return fmt.Errorf("invalid remote log buffer size %v", c.BufferSize)
+	}
+	return nil
+}
+
+func (c *RemoteLogConfig) getTLSConfig(configDir string) (*tls.Config, error) {
+	if !c.EnableTLS {
+		return nil, nil
+	}
+	host, _, _ := net.SplitHostPort(c.Address)
+	tlsConfig := &tls.Config{
+		ServerName:         host,
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: c.SkipTLSVerify,
+	}
+	if len(c.CACertificates) == 0 {
+		return tlsConfig, nil
+	}
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil {
+		rootCAs = x509.NewCertPool()
+	}
+	for _, ca := range c.CACertificates {
+		if !isLogFilePathValid(ca) {
+			return nil, fmt.Errorf("invalid remote log CA certificate %#v", ca)
+		}
+		if !filepath.IsAbs(ca) {
+			ca = filepath.Join(configDir, ca)
+		}
+		certs, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load remote log CA certificate: %w", err)
+		}
+		if !rootCAs.AppendCertsFromPEM(certs) {
+			return nil, fmt.Errorf("unable to add remote log CA certificate %#v to the trusted certificates", ca)
+		}
+	}
+	tlsConfig.RootCAs = rootCAs
+	return tlsConfig, nil
+}
+
+// InitRemoteLogger configures the main logger to send the logs to the
+// configured remote endpoint too. The remote log is disabled if the address
+// is empty. The logs are buffered in memory while the remote endpoint is
+// unavailable and sent as soon as the connection is restored
+func InitRemoteLogger(config RemoteLogConfig, configDir string) error {
+	CloseRemoteLogger()
+	if config.Address == "" {
+		return nil
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	tlsConfig, err := config.getTLSConfig(configDir)
+	if err != nil {
+		return err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "sftpgo"
+	}
+	remoteWriter = &remoteLogWriter{
+		address:   config.Address,
+		format:    config.Format,
+		tlsConfig: tlsConfig,
+		hostname:  hostname,
+		queue:     make(chan []byte, config.BufferSize),
+		done:      make(chan bool),
+		exited:    make(chan bool),
+	}
+	go remoteWriter.run()
+
+	var w io.Writer = remoteWriter
+	if config.Format == RemoteLogFormatJSON && logFormat == LogFormatECS {
+		w = &ecsWriter{
+			output: remoteWriter,
+		}
+	}
+	if logOutput != nil {
+		logger = logger.Output(zerolog.MultiLevelWriter(logOutput, w))
+	} else {
+		logger = zerolog.New(w).Level(zerolog.DebugLevel)
+	}
+	return nil
+}
+
+// CloseRemoteLogger stops sending the logs to the remote endpoint. The
+// buffered logs are flushed if the remote endpoint is available
+func CloseRemoteLogger() {
+	if remoteWriter == nil {
+		return
+	}
+	if logOutput != nil {
+		logger = logger.Output(logOutput)
+	} else {
+		logger = zerolog.Nop()
+	}
+	remoteWriter.close()
+	remoteWriter = nil
+}
+
+// remoteLogWriter sends the logs to a remote endpoint over TCP.
+// Write never blocks, the logs are queued and sent from a dedicated goroutine
+type remoteLogWriter struct {
+	address   string
+	format    string
+	tlsConfig *tls.Config
+	hostname  string
+	queue     chan []byte
+	dropped   int64
+	closeOnce sync.Once
+	done      chan bool
+	exited    chan bool
+}
+
+func (w *remoteLogWriter) Write(p []byte) (int, error) {
+	entry := make([]byte, len(p))
+	copy(entry, p)
+	for {
+		select {
+		case w.queue <- entry:
+			return len(p), nil
+		default:
+		}
+		// the buffer is full, drop the oldest entry
+		select {
+		case <-w.queue:
+			atomic.AddInt64(&w.dropped, 1)
+		default:
+		}
+	}
+}
+
+func (w *remoteLogWriter) close() {
+	w.closeOnce.Do(func() {
+		close(w.done)
+		<-w.exited
+	})
+}
+
+func (w *remoteLogWriter) dial() (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: remoteLogDialTimeout,
+	}
+	if w.tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", w.address, w.tlsConfig)
+	}
+	return dialer.Dial("tcp", w.address)
+}
+
+func (w *remoteLogWriter) send(conn net.Conn, message []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(remoteLogWriteTimeout)); err != nil {
+		return err
+	}
+	_, err := conn.Write(message)
+	return err
+}
+
+func (w *remoteLogWriter) run() {
+	defer close(w.exited)
+
+	var conn net.Conn
+	var pending []byte
+	retryDelay := remoteLogMinRetryDelay
+
+	for {
+		if pending == nil {
+			select {
+			case <-w.done:
+				w.flush(conn)
+				return
+			case entry := <-w.queue:
+				pending = w.getMessage(entry)
+			}
+		}
+		if conn == nil {
+			c, err := w.dial()
+			if err != nil {
+				select {
+				case <-w.done:
+					return
+				case <-time.After(retryDelay):
+				}
+				retryDelay *= 2
+				if retryDelay > remoteLogMaxRetryDelay {
+					retryDelay = remoteLogMaxRetryDelay
+				}
+				continue
+			}
+			conn = c
+			retryDelay = remoteLogMinRetryDelay
+			if dropped := atomic.SwapInt64(&w.dropped, 0); dropped > 0 {
+				if err := w.send(conn, w.getDroppedMessage(dropped)); err != nil {
+					atomic.AddInt64(&w.dropped, dropped)
+					conn.Close()
+					conn = nil
+					continue
+				}
+			}
+		}
+		if err := w.send(conn, pending); err != nil {
+			conn.Close()
+			conn = nil
+			continue
+		}
+		pending = nil
+	}
+}
+
+// flush sends the queued logs before exiting, if the remote endpoint is
+// available, and closes the connection
+func (w *remoteLogWriter) flush(conn net.Conn) {
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(remoteLogFlushTimeout)
+	for time.Now().Before(deadline) {
+		select {
+		case entry := <-w.queue:
+			if err := w.send(conn, w.getMessage(entry)); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (w *remoteLogWriter) getDroppedMessage(dropped int64) []byte {
+	var buf bytes.Buffer
+	l := zerolog.New(&buf)
+	l.Warn().Timestamp().Str("sender", "logger").Msgf(remoteLogDroppedMessage, dropped)
+	return w.getMessage(buf.Bytes())
+}
+
+func (w *remoteLogWriter) getMessage(entry []byte) []byte {
+	if w.format == RemoteLogFormatGELF {
+		return w.getGELFMessage(entry)
+	}
+	if len(entry) == 0 || entry[len(entry)-1] != '\n' {
+		entry = append(entry, '\n')
+	}
+	return entry
+}
+
+// getGELFMessage converts a JSON log entry to a GELF message, the log fields
+// are sent as additional fields
+func (w *remoteLogWriter) getGELFMessage(entry []byte) []byte {
+	var fields map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(entry))
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		fields = map[string]interface{}{
+			zerolog.MessageFieldName: string(bytes.TrimSpace(entry)),
+		}
+	}
+	message := map[string]interface{}{
+		"version": remoteLogGELFVersion,
+		"host":    w.hostname,
+		"level":   gelfLevels[zerolog.InfoLevel.String()],
+	}
+	for name, value := range fields {
+		switch name {
+		case zerolog.MessageFieldName:
+			message["short_message"] = value
+		case zerolog.LevelFieldName:
+			if level, ok := gelfLevels[fmt.Sprintf("%v", value)]; ok {
+				message["level"] = level
+			}
+		case zerolog.TimestampFieldName:
+			if val, ok := value.(string); ok {
+				if t, err := time.ParseInLocation(dateFormat, val, time.Local); err == nil {
+					message["timestamp"] = float64(t.UnixNano()/int64(time.Millisecond)) / 1000
+					continue
+				}
+			}
+			message["_time"] = value
+		case "id":
+			// "_id" is reserved in GELF
+			message["_log_id"] = value
+		default:
+			message["_"+name] = value
+		}
+	}
+	if val, ok := message["short_message"]; !ok || fmt.Sprintf("%v", val) == "" {
+		if sender, ok := fields["sender"]; ok {
+			message["short_message"] = sender
+		} else {
+			message["short_message"] = "-"
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(message); err != nil {
+		return nil
+	}
+	// GELF TCP messages are delimited by a null byte instead of a newline
+	result := bytes.TrimRight(buf.Bytes(), "\n")
+	return append(result, 0)
+}
@@ -24,6 +24,8 @@ type StructuredLoggerEntry struct {
 	Logger *zerolog.Logger
 	// fields to write in the log
 	fields map[string]interface{}
+	// request details for the access log
+	accessLog accessLogEntry
 }
 
 // NewStructuredLogger returns a chi.middleware.RequestLogger using our StructuredLogger.
@@ -51,12 +53,16 @@ func (l *StructuredLogger) NewLogEntry(r *http.Request) middleware.LogEntry {
 		fields["request_id"] = reqID
 	}
 
-	return &StructuredLoggerEntry{Logger: l.Logger, fields: fields}
+	return &StructuredLoggerEntry{Logger: l.Logger, fields: fields, accessLog: newAccessLogEntry(r, reqID)}
 }
 
 // Write logs a new entry at the end of the HTTP request
 func (l *StructuredLoggerEntry) Write(status, bytes int, header http.Header, elapsed time.Duration, extra interface{}) {
 	metrics.HTTPRequestServed(status)
+	l.accessLog.write(status, bytes, elapsed)
+	if !isLevelEnabled("httpd", zerolog.InfoLevel) {
+		return
+	}
 	l.Logger.Info().
 		Timestamp().
 		Str("sender", "httpd").
@@ -0,0 +1,227 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	samplerMutex sync.RWMutex
+	sampler      *logSampler
+)
+
+// LogSamplingConfig defines the sampling for repetitive log entries.
+// Log entries are considered repetitive if they have the same sender, level
+// and message format, the values are ignored. For example all the "login
+// failed" entries for a protocol are considered repetitive, regardless of the
+// client IP and the username. For each interval the first Burst repetitive
+// entries are logged, then only one entry every Thereafter is logged.
+// A summary with the number of suppressed entries is logged at the end of
+// each interval
+type LogSamplingConfig struct {
+	// Sampling interval as seconds. 0 means sampling disabled
+	Interval int `json:"interval" mapstructure:"interval"`
+	// Number of repetitive entries logged in each interval before sampling
+	Burst int `json:"burst" mapstructure:"burst"`
+	// After the burst, log one entry every Thereafter entries.
+	// 0 means suppress all the other entries
+	Thereafter int `json:"thereafter" mapstructure:"thereafter"`
+	// Senders to never sample, for example "connection_failed" if you
+	// use Fail2ban. The senders are case insensitive
+	ExcludedSenders []string `json:"excluded_senders" mapstructure:"excluded_senders"`
+}
+
+func (c *LogSamplingConfig) validate() error {
+	if c.Interval < 0 {
+		return fmt.Errorf("invalid log sampling interval %v", c.Interval)
+	}
+	if c.Burst < 0 {
+		return fmt.Errorf("invalid log sampling burst %v", c.Burst)
+	}
+	if c.Thereafter < 0 {
+		return fmt.Errorf("invalid log sampling thereafter %v", c.Thereafter)
+	}
+	return nil
+}
+
+// SetLogSampling configures the sampling for repetitive log entries.
+// The sampling is disabled if the interval is 0
+func SetLogSampling(config LogSamplingConfig) error {
+	if err := config.validate(); err != nil {
+		return err
+	}
+	samplerMutex.Lock()
+	old := sampler
+	sampler = nil
+	if config.Interval > 0 {
+		sampler = newLogSampler(config)
+	}
+	samplerMutex.Unlock()
+
+	if old != nil {
+		old.stop()
+	}
+	return nil
+}
+
+// isSampled returns true if the log entry with the given key must be logged
+func isSampled(sender string, level zerolog.Level, key string) bool {
+	samplerMutex.RLock()
+	s := sampler
+	samplerMutex.RUnlock()
+
+	if s == nil {
+		return true
+	}
+	return s.check(sender, level, key)
+}
+
+// getSampledLogEvent returns a new event or nil if the level is not enabled
+// for the specified sender or the event is suppressed by the sampling
+func getSampledLogEvent(level zerolog.Level, sender, key string) *zerolog.Event {
+	if !isLevelEnabled(sender, level) {
+		return nil
+	}
+	if !isSampled(sender, level, key) {
+		return nil
+	}
+	return logger.WithLevel(level)
+}
+
+type sampledEntry struct {
+	sender     string
+	level      zerolog.Level
+	message    string
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+type logSampler struct {
+	sync.Mutex
+	config   LogSamplingConfig
+	interval time.Duration
+	excluded map[string]bool
+	entries  map[string]*sampledEntry
+	done     chan bool
+}
+
+func newLogSampler(config LogSamplingConfig) *logSampler {
+	s := &logSampler{
+		config:   config,
+		interval: time.Duration(config.Interval) * time.Second,
+		excluded: make(map[string]bool),
+		entries:  make(map[string]*sampledEntry),
+		done:     make(chan bool),
+	}
+	for _, sender := range config.ExcludedSenders {
+		s.excluded[strings.ToLower(strings.TrimSpace(sender))] = true
+	}
+	go s.run()
+	return s
+}
+
+func (s *logSampler) stop() {
+	close(s.done)
+	s.flush(time.Time{})
+}
+
+func (s *logSampler) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.flush(now)
+		}
+	}
+}
+
+func (s *logSampler) check(sender string, level zerolog.Level, key string) bool {
+	if s.excluded[strings.ToLower(sender)] {
+		return true
+	}
+	entryKey := fmt.Sprintf("%s|%s|%s", sender, level, key)
+	now := time.Now()
+
+	s.Lock()
+	entry, ok := s.entries[entryKey]
+	var expired *sampledEntry
+	if ok && now.Sub(entry.start) >= s.interval {
+		expired = entry
+		ok = false
+	}
+	if !ok {
+		entry = &sampledEntry{
+			sender:  sender,
+			level:   level,
+			message: key,
+			start:   now,
+		}
+		s.entries[entryKey] = entry
+	}
+	entry.count++
+	result := s.isAllowed(entry.count)
+	if !result {
+		entry.suppressed++
+	}
+	s.Unlock()
+
+	if expired != nil {
+		s.logSummary(expired, now)
+	}
+	return result
+}
+
+func (s *logSampler) isAllowed(count int) bool {
+	if count <= s.config.Burst {
+		return true
+	}
+	if s.config.Thereafter > 0 && (count-s.config.Burst)%s.config.Thereafter == 0 {
+		return true
+	}
+	return false
+}
+
+// flush removes the entries with an expired interval and logs a summary for
+// the ones with suppressed log entries. All the entries are removed if now
+// is the zero time
+func (s *logSampler) flush(now time.Time) {
+	var expired []*sampledEntry
+
+	s.Lock()
+	for key, entry := range s.entries {
+		if now.IsZero() || now.Sub(entry.start) >= s.interval {
+			delete(s.entries, key)
+			expired = append(expired, entry)
+		}
+	}
+	s.Unlock()
+
+	if now.IsZero() {
+		now = time.Now()
+	}
+	for _, entry := range expired {
+		s.logSummary(entry, now)
+	}
+}
+
+func (s *logSampler) logSummary(entry *sampledEntry, now time.Time) {
+	if entry.suppressed == 0 {
+		return
+	}
+	logger.WithLevel(entry.level).
+		Timestamp().
+		Str("sender", entry.sender).
+		Str("sampled_message", entry.message).
+		Int("suppressed", entry.suppressed).
+		Msgf("%d similar log entries suppressed in the last %v", entry.suppressed,
+			now.Sub(entry.start).Round(time.Second))
+}
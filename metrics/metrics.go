@@ -4,6 +4,8 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/go-chi/chi/v5"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
@@ -32,12 +34,37 @@ var (
 		Help: "Availability for the configured data provider, 1 means OK, 0 KO",
 	})
 
+	// buildInfo is the metric that reports the build details and the loaded configuration fingerprint
+	buildInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sftpgo_build_info",
+		Help: "A metric with a constant '1' value labeled by version, commit hash, build date and configuration fingerprint",
+	}, []string{"version", "commit_hash", "build_date", "config_fingerprint"})
+
 	// activeConnections is the metric that reports the total number of active connections
 	activeConnections = promauto.NewGauge(prometheus.GaugeOpts{
 		Name: "sftpgo_active_connections",
 		Help: "Total number of logged in users",
 	})
 
+	// dataproviderQueries is the metric that reports the total number of data provider queries
+	dataproviderQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_dataprovider_queries_total",
+		Help: "The total number of data provider queries for each operation",
+	}, []string{"operation"})
+
+	// dataproviderQueryErrors is the metric that reports the total number of failed data provider queries
+	dataproviderQueryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_dataprovider_query_errors_total",
+		Help: "The total number of failed data provider queries for each operation",
+	}, []string{"operation"})
+
+	// dataproviderQueryDuration is the metric that reports the distribution of the data provider queries duration
+	dataproviderQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_dataprovider_query_duration_seconds",
+		Help:    "The duration as seconds of the data provider queries for each operation",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"operation"})
+
 	// totalUploads is the metric that reports the total number of successful uploads
 	totalUploads = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "sftpgo_uploads_total",
@@ -74,6 +101,27 @@ var (
 		Help: "The total download size as bytes, partial downloads are included",
 	})
 
+	// transferSize is the metric that reports the distribution of the successful transfers size as bytes
+	transferSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_transfer_size_bytes",
+		Help:    "The size as bytes of the successful transfers",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 12),
+	}, []string{"protocol", "type"})
+
+	// transferDuration is the metric that reports the distribution of the successful transfers duration
+	transferDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_transfer_duration_seconds",
+		Help:    "The duration as seconds of the successful transfers",
+		Buckets: prometheus.ExponentialBuckets(0.1, 2, 15),
+	}, []string{"protocol", "type"})
+
+	// transferThroughput is the metric that reports the distribution of the successful transfers throughput
+	transferThroughput = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sftpgo_transfer_throughput_bytes_per_second",
+		Help:    "The throughput as bytes per second of the successful transfers",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 12),
+	}, []string{"protocol", "type"})
+
 	// totalSSHCommands is the metric that reports the total number of executed SSH commands
 	totalSSHCommands = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "sftpgo_ssh_commands_total",
@@ -572,8 +620,10 @@ func AddMetricsEndpoint(metricsPath string, handler chi.Router) {
 	handler.Handle(metricsPath, promhttp.Handler())
 }
 
-// TransferCompleted updates metrics after an upload or a download
-func TransferCompleted(bytesSent, bytesReceived int64, transferKind int, err error) {
+// TransferCompleted updates metrics after an upload or a download.
+// The per-user metrics are updated too, if enabled
+func TransferCompleted(username string, bytesSent, bytesReceived int64, transferKind int, err error) {
+	userTransferCompleted(username, bytesSent, bytesReceived, transferKind, err)
 	if transferKind == 0 {
 		// upload
 		if err == nil {
@@ -593,6 +643,23 @@ func TransferCompleted(bytesSent, bytesReceived int64, transferKind int, err err
 	}
 }
 
+// TransferFinished updates the histograms for transfer sizes, durations and
+// throughput. Only the successful transfers are observed
+func TransferFinished(protocol string, transferKind int, size int64, elapsed time.Duration, err error) {
+	if err != nil {
+		return
+	}
+	transferType := "upload"
+	if transferKind != 0 {
+		transferType = "download"
+	}
+	transferSize.WithLabelValues(protocol, transferType).Observe(float64(size))
+	transferDuration.WithLabelValues(protocol, transferType).Observe(elapsed.Seconds())
+	if elapsed > 0 && size > 0 {
+		transferThroughput.WithLabelValues(protocol, transferType).Observe(float64(size) / elapsed.Seconds())
+	}
+}
+
 // S3TransferCompleted updates metrics after an S3 upload or a download
 func S3TransferCompleted(bytes int64, transferKind int, err error) {
 	if transferKind == 0 {
@@ -809,6 +876,15 @@ func UpdateDataProviderAvailability(err error) {
 	}
 }
 
+// DataProviderQueryCompleted updates the metrics after a data provider query
+func DataProviderQueryCompleted(operation string, elapsed time.Duration, err error) {
+	dataproviderQueries.WithLabelValues(operation).Inc()
+	if err != nil {
+		dataproviderQueryErrors.WithLabelValues(operation).Inc()
+	}
+	dataproviderQueryDuration.WithLabelValues(operation).Observe(elapsed.Seconds())
+}
+
 // AddLoginAttempt increments the metrics for login attempts
 func AddLoginAttempt(authMethod string) {
 	totalLoginAttempts.Inc()
@@ -897,6 +973,14 @@ func HTTPRequestServed(status int) {
 	}
 }
 
+// SetBuildInfo updates the build info metric with the given configuration
+// fingerprint, the previous value, if any, is removed
+func SetBuildInfo(configFingerprint string) {
+	info := version.Get()
+	buildInfo.Reset()
+	buildInfo.WithLabelValues(info.Version, info.CommitHash, info.BuildDate, configFingerprint).Set(1)
+}
+
 // UpdateActiveConnectionsSize sets the metric for active connections
 func UpdateActiveConnectionsSize(size int) {
 	activeConnections.Set(float64(size))
@@ -3,6 +3,9 @@
 package metrics
 
 import (
+	"errors"
+	"time"
+
 	"github.com/go-chi/chi/v5"
 
 	"github.com/drakkan/sftpgo/version"
@@ -15,8 +18,31 @@ func init() {
 // AddMetricsEndpoint exposes metrics to the specified endpoint
 func AddMetricsEndpoint(metricsPath string, handler chi.Router) {}
 
-// TransferCompleted updates metrics after an upload or a download
-func TransferCompleted(bytesSent, bytesReceived int64, transferKind int, err error) {}
+// TransferCompleted updates metrics after an upload or a download.
+// The per-user metrics are updated too, if enabled
+func TransferCompleted(username string, bytesSent, bytesReceived int64, transferKind int, err error) {
+}
+
+// TransferFinished updates the histograms for transfer sizes, durations and
+// throughput. Only the successful transfers are observed
+func TransferFinished(protocol string, transferKind int, size int64, elapsed time.Duration, err error) {
+}
+
+// InitStatsD starts pushing the metrics to the configured StatsD server.
+// An error is returned if the StatsD exporter is enabled, the metrics support is disabled
+func InitStatsD(config StatsDConfig) error {
+	if config.Address != "" {
+		return errors.New("unable to enable the StatsD exporter, metrics support is disabled")
+	}
+	return nil
+}
+
+// StopStatsD pushes the metrics a last time and stops the StatsD exporter
+func StopStatsD() {}
+
+// SetPerUserMetricsLimit enables the per-user transfer metrics for up to
+// limit users. 0 disables the per-user metrics
+func SetPerUserMetricsLimit(limit int) {}
 
 // S3TransferCompleted updates metrics after an S3 upload or a download
 func S3TransferCompleted(bytes int64, transferKind int, err error) {}
@@ -54,6 +80,9 @@ func SSHCommandCompleted(err error) {}
 // UpdateDataProviderAvailability updates the metric for the data provider availability
 func UpdateDataProviderAvailability(err error) {}
 
+// DataProviderQueryCompleted updates the metrics after a data provider query
+func DataProviderQueryCompleted(operation string, elapsed time.Duration, err error) {}
+
 // AddLoginAttempt increments the metrics for login attempts
 func AddLoginAttempt(authMethod string) {}
 
@@ -69,3 +98,7 @@ func HTTPRequestServed(status int) {}
 
 // UpdateActiveConnectionsSize sets the metric for active connections
 func UpdateActiveConnectionsSize(size int) {}
+
+// SetBuildInfo updates the build info metric with the given configuration
+// fingerprint, the previous value, if any, is removed
+func SetBuildInfo(configFingerprint string) {}
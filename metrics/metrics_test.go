@@ -0,0 +1,158 @@
+// +build !nometrics
+
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func resetPerUserMetrics(t *testing.T) {
+	reset := func() {
+		SetPerUserMetricsLimit(0)
+		perUserMetricsMutex.Lock()
+		perUserMetricsUsers = make(map[string]bool)
+		perUserMetricsMutex.Unlock()
+		for _, vec := range []*prometheus.CounterVec{userUploads, userDownloads, userUploadErrors, userDownloadErrors,
+			userUploadSize, userDownloadSize} {
+			vec.Reset()
+		}
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func getHistogram(t *testing.T, vec *prometheus.HistogramVec, labels ...string) *dto.Histogram {
+	observer, err := vec.GetMetricWithLabelValues(labels...)
+	require.NoError(t, err)
+	metric := &dto.Metric{}
+	err = observer.(prometheus.Metric).Write(metric)
+	require.NoError(t, err)
+	return metric.GetHistogram()
+}
+
+// getBucketCount returns the cumulative count for the bucket with the given upper bound
+func getBucketCount(t *testing.T, histogram *dto.Histogram, upperBound float64) uint64 {
+	for _, bucket := range histogram.GetBucket() {
+		if bucket.GetUpperBound() == upperBound {
+			return bucket.GetCumulativeCount()
+		}
+	}
+	require.Fail(t, "bucket not found", "upper bound: %v", upperBound)
+	return 0
+}
+
+func TestPerUserMetricsDisabled(t *testing.T) {
+	resetPerUserMetrics(t)
+
+	assert.Empty(t, getUserLabel("user1"))
+	TransferCompleted("user1", 0, 100, 0, nil)
+	assert.Equal(t, 0, testutil.CollectAndCount(userUploads))
+	assert.Equal(t, 0, testutil.CollectAndCount(userUploadSize))
+
+	SetPerUserMetricsLimit(-1)
+	assert.Empty(t, getUserLabel("user1"))
+	SetPerUserMetricsLimit(1)
+	// an empty username is never tracked
+	assert.Empty(t, getUserLabel(""))
+}
+
+func TestPerUserMetrics(t *testing.T) {
+	resetPerUserMetrics(t)
+
+	SetPerUserMetricsLimit(2)
+	TransferCompleted("user1", 0, 100, 0, nil)
+	TransferCompleted("user1", 0, 50, 0, errors.New("upload error"))
+	TransferCompleted("user1", 200, 0, 1, nil)
+	TransferCompleted("user2", 300, 0, 1, errors.New("download error"))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(userUploads.WithLabelValues("user1")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(userUploadErrors.WithLabelValues("user1")))
+	assert.Equal(t, float64(150), testutil.ToFloat64(userUploadSize.WithLabelValues("user1")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(userDownloads.WithLabelValues("user1")))
+	assert.Equal(t, float64(200), testutil.ToFloat64(userDownloadSize.WithLabelValues("user1")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(userDownloadErrors.WithLabelValues("user2")))
+	assert.Equal(t, float64(300), testutil.ToFloat64(userDownloadSize.WithLabelValues("user2")))
+	// user2 has no successful downloads
+	assert.Equal(t, 1, testutil.CollectAndCount(userDownloads))
+}
+
+func TestPerUserMetricsLimit(t *testing.T) {
+	resetPerUserMetrics(t)
+
+	limit := 3
+	SetPerUserMetricsLimit(limit)
+	for i := 0; i < 10; i++ {
+		username := fmt.Sprintf("user%v", i)
+		TransferCompleted(username, 0, 10, 0, nil)
+		TransferCompleted(username, 10, 0, 1, nil)
+	}
+	// the users exceeding the limit share the same label, so the cardinality is capped
+	assert.Equal(t, limit+1, testutil.CollectAndCount(userUploads))
+	assert.Equal(t, limit+1, testutil.CollectAndCount(userDownloadSize))
+	for i := 0; i < limit; i++ {
+		username := fmt.Sprintf("user%v", i)
+		assert.Equal(t, username, getUserLabel(username))
+		assert.Equal(t, float64(1), testutil.ToFloat64(userUploads.WithLabelValues(username)))
+		assert.Equal(t, float64(10), testutil.ToFloat64(userUploadSize.WithLabelValues(username)))
+	}
+	assert.Equal(t, otherUsersLabel, getUserLabel("user5"))
+	assert.Equal(t, float64(10-limit), testutil.ToFloat64(userUploads.WithLabelValues(otherUsersLabel)))
+	assert.Equal(t, float64(10*(10-limit)), testutil.ToFloat64(userDownloadSize.WithLabelValues(otherUsersLabel)))
+	// the users already tracked keep their label
+	TransferCompleted("user0", 0, 10, 0, nil)
+	assert.Equal(t, float64(2), testutil.ToFloat64(userUploads.WithLabelValues("user0")))
+	assert.Equal(t, limit+1, testutil.CollectAndCount(userUploads))
+	// reducing the limit does not remove the users already tracked
+	SetPerUserMetricsLimit(1)
+	assert.Equal(t, "user2", getUserLabel("user2"))
+	assert.Equal(t, otherUsersLabel, getUserLabel("user9"))
+}
+
+func TestTransferHistograms(t *testing.T) {
+	protocol := "TestProto"
+	t.Cleanup(func() {
+		for _, vec := range []*prometheus.HistogramVec{transferSize, transferDuration, transferThroughput} {
+			vec.DeleteLabelValues(protocol, "upload")
+			vec.DeleteLabelValues(protocol, "download")
+		}
+	})
+
+	TransferFinished(protocol, 0, 2048, 2*time.Second, nil)
+	TransferFinished(protocol, 0, 1000, 100*time.Millisecond, nil)
+	// failed transfers are not observed
+	TransferFinished(protocol, 0, 1<<20, time.Second, errors.New("upload error"))
+	// zero size or zero duration transfers are not included in the throughput
+	TransferFinished(protocol, 1, 0, time.Second, nil)
+	TransferFinished(protocol, 1, 4096, 0, nil)
+
+	sizes := getHistogram(t, transferSize, protocol, "upload")
+	assert.Equal(t, uint64(2), sizes.GetSampleCount())
+	assert.Equal(t, float64(3048), sizes.GetSampleSum())
+	assert.Equal(t, uint64(1), getBucketCount(t, sizes, 1024))
+	assert.Equal(t, uint64(2), getBucketCount(t, sizes, 4096))
+
+	durations := getHistogram(t, transferDuration, protocol, "upload")
+	assert.Equal(t, uint64(2), durations.GetSampleCount())
+	assert.Equal(t, uint64(1), getBucketCount(t, durations, 0.1))
+	assert.Equal(t, uint64(1), getBucketCount(t, durations, 1.6))
+	assert.Equal(t, uint64(2), getBucketCount(t, durations, 3.2))
+
+	throughput := getHistogram(t, transferThroughput, protocol, "upload")
+	assert.Equal(t, uint64(2), throughput.GetSampleCount())
+	assert.Equal(t, float64(2048/2+10000), throughput.GetSampleSum())
+	assert.Equal(t, uint64(1), getBucketCount(t, throughput, 1024))
+	assert.Equal(t, uint64(2), getBucketCount(t, throughput, 16384))
+
+	assert.Equal(t, uint64(2), getHistogram(t, transferSize, protocol, "download").GetSampleCount())
+	assert.Equal(t, uint64(2), getHistogram(t, transferDuration, protocol, "download").GetSampleCount())
+	assert.Equal(t, uint64(0), getHistogram(t, transferThroughput, protocol, "download").GetSampleCount())
+}
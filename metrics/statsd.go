@@ -0,0 +1,202 @@
+// +build !nometrics
+
+package metrics
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// statsDMaxPacketSize is the maximum size for a StatsD UDP packet, it avoids
+// fragmentation on the common networks
+const statsDMaxPacketSize = 1432
+
+var (
+	statsDMutex    sync.Mutex
+	statsDExporter *statsDPusher
+)
+
+// InitStatsD starts pushing the metrics to the configured StatsD server.
+// The StatsD exporter is disabled if the address is empty
+func InitStatsD(config StatsDConfig) error {
+	StopStatsD()
+	if config.Address == "" {
+		return nil
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	conn, err := net.Dial("udp", config.Address)
+	if err != nil {
+		return err
+	}
+	statsDMutex.Lock()
+	defer statsDMutex.Unlock()
+
+	statsDExporter = &statsDPusher{
+		config:   config,
+		conn:     conn,
+		gatherer: prometheus.DefaultGatherer,
+		previous: make(map[string]float64),
+		done:     make(chan bool),
+		exited:   make(chan bool),
+	}
+	go statsDExporter.run()
+	return nil
+}
+
+// StopStatsD pushes the metrics a last time and stops the StatsD exporter
+func StopStatsD() {
+	statsDMutex.Lock()
+	defer statsDMutex.Unlock()
+
+	if statsDExporter == nil {
+		return
+	}
+	close(statsDExporter.done)
+	<-statsDExporter.exited
+	statsDExporter = nil
+}
+
+// statsDPusher pushes the Prometheus metrics to a StatsD server. Gauges are
+// sent as is, counters are sent as the difference from the previous push.
+// For histograms and summaries the observations count and sum are sent as counters
+type statsDPusher struct {
+	config   StatsDConfig
+	conn     net.Conn
+	gatherer prometheus.Gatherer
+	// previous counter values, used to compute the differences
+	previous map[string]float64
+	done     chan bool
+	exited   chan bool
+}
+
+func (p *statsDPusher) run() {
+	defer close(p.exited)
+	defer p.conn.Close()
+
+	ticker := time.NewTicker(time.Duration(p.config.FlushInterval) * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			p.push()
+			return
+		case <-ticker.C:
+			p.push()
+		}
+	}
+}
+
+func (p *statsDPusher) push() {
+	families, err := p.gatherer.Gather()
+	if err != nil && len(families) == 0 {
+		return
+	}
+	var lines []string
+	for _, family := range families {
+		name := family.GetName()
+		for _, m := range family.GetMetric() {
+			labels := m.GetLabel()
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				lines = p.addCounter(lines, name, labels, m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				lines = p.addGauge(lines, name, labels, m.GetGauge().GetValue())
+			case dto.MetricType_UNTYPED:
+				lines = p.addGauge(lines, name, labels, m.GetUntyped().GetValue())
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				lines = p.addCounter(lines, name+"_count", labels, float64(h.GetSampleCount()))
+				lines = p.addCounter(lines, name+"_sum", labels, h.GetSampleSum())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				lines = p.addCounter(lines, name+"_count", labels, float64(s.GetSampleCount()))
+				lines = p.addCounter(lines, name+"_sum", labels, s.GetSampleSum())
+			}
+		}
+	}
+	p.send(lines)
+}
+
+func (p *statsDPusher) addCounter(lines []string, name string, labels []*dto.LabelPair, value float64) []string {
+	metricName, tags := p.getNameAndTags(name, labels)
+	key := metricName + tags
+	delta := value - p.previous[key]
+	p.previous[key] = value
+	if delta <= 0 {
+		return lines
+	}
+	return append(lines, metricName+":"+formatStatsDValue(delta)+"|c"+tags)
+}
+
+func (p *statsDPusher) addGauge(lines []string, name string, labels []*dto.LabelPair, value float64) []string {
+	metricName, tags := p.getNameAndTags(name, labels)
+	return append(lines, metricName+":"+formatStatsDValue(value)+"|g"+tags)
+}
+
+// getNameAndTags returns the StatsD metric name and the DogStatsD tags suffix.
+// For the plain StatsD format the label values are appended to the name
+func (p *statsDPusher) getNameAndTags(name string, labels []*dto.LabelPair) (string, string) {
+	metricName := p.config.Prefix + name
+	if p.config.Format != StatsDFormatDogStatsD {
+		for _, label := range labels {
+			// dots are used as separators in the plain StatsD metric names
+			metricName += "." + strings.ReplaceAll(sanitizeStatsDValue(label.GetValue()), ".", "_")
+		}
+		return metricName, ""
+	}
+	tags := make([]string, 0, len(p.config.Tags)+len(labels))
+	tags = append(tags, p.config.Tags...)
+	for _, label := range labels {
+		tags = append(tags, label.GetName()+":"+sanitizeStatsDValue(label.GetValue()))
+	}
+	if len(tags) == 0 {
+		return metricName, ""
+	}
+	sort.Strings(tags)
+	return metricName, "|#" + strings.Join(tags, ",")
+}
+
+// send writes the lines to the StatsD server, splitting them in packets
+func (p *statsDPusher) send(lines []string) {
+	var sb strings.Builder
+	for _, line := range lines {
+		if sb.Len() > 0 && sb.Len()+len(line)+1 > statsDMaxPacketSize {
+			p.conn.Write([]byte(sb.String())) //nolint:errcheck
+			sb.Reset()
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(line)
+	}
+	if sb.Len() > 0 {
+		p.conn.Write([]byte(sb.String())) //nolint:errcheck
+	}
+}
+
+func formatStatsDValue(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+func sanitizeStatsDValue(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', ',', '#', '@', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, value)
+}
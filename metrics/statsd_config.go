@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Supported StatsD formats
+const (
+	// StatsDFormatPlain is the plain StatsD format, the labels are appended
+	// to the metric names
+	StatsDFormatPlain = "statsd"
+	// StatsDFormatDogStatsD is the DogStatsD format, the labels are sent as tags
+	StatsDFormatDogStatsD = "dogstatsd"
+)
+
+// StatsDConfig defines the configuration to push the metrics to a StatsD
+// server, such as Telegraf or the Datadog agent
+type StatsDConfig struct {
+	// Address of the StatsD server as host:port. Leave empty to disable
+	Address string `json:"address" mapstructure:"address"`
+	// Format for the metrics, "statsd" or "dogstatsd"
+	Format string `json:"format" mapstructure:"format"`
+	// Prefix to add to the metric names, for example "sftpgo."
+	Prefix string `json:"prefix" mapstructure:"prefix"`
+	// Tags to add to all the metrics, as "key:value". Tags are only
+	// supported for the "dogstatsd" format
+	Tags []string `json:"tags" mapstructure:"tags"`
+	// Interval, as seconds, between two pushes
+	FlushInterval int `json:"flush_interval" mapstructure:"flush_interval"`
+}
+
+func (c *StatsDConfig) validate() error {
+	if c.Format == "" {
+		c.Format = StatsDFormatPlain
+	}
+	if c.Format != StatsDFormatPlain && c.Format != StatsDFormatDogStatsD {
+		return fmt.Errorf("invalid StatsD format %#v, supported formats: %v, %v", c.Format, StatsDFormatPlain,
+			StatsDFormatDogStatsD)
+	}
+	if _, _, err := net.SplitHostPort(c.Address); err != nil {
+		return fmt.Errorf("invalid StatsD address %#v: %w", c.Address, err)
+	}
+	if c.FlushInterval <= 0 {
+		return fmt.Errorf("invalid StatsD flush interval %v", c.FlushInterval)
+	}
+	for _, tag := range c.Tags {
+		if strings.TrimSpace(tag) == "" || strings.ContainsAny(tag, ",|#") {
+			return fmt.Errorf("invalid StatsD tag %#v", tag)
+		}
+	}
+	if len(c.Tags) > 0 && c.Format != StatsDFormatDogStatsD {
+		return fmt.Errorf("StatsD tags are only supported for the %#v format", StatsDFormatDogStatsD)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+// +build !nometrics
+
+package metrics
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getTestStatsDServer(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+func readStatsDPackets(t *testing.T, conn net.PacketConn) []string {
+	var packets []string
+	buf := make([]byte, 65536)
+	for {
+		err := conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		require.NoError(t, err)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return packets
+		}
+		packets = append(packets, string(buf[:n]))
+	}
+}
+
+func readStatsDLines(t *testing.T, conn net.PacketConn) []string {
+	var lines []string
+	for _, packet := range readStatsDPackets(t, conn) {
+		lines = append(lines, strings.Split(packet, "\n")...)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func newTestStatsDPusher(t *testing.T, config StatsDConfig, gatherer prometheus.Gatherer, server net.PacketConn) *statsDPusher {
+	conn, err := net.Dial("udp", server.LocalAddr().String())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return &statsDPusher{
+		config:   config,
+		conn:     conn,
+		gatherer: gatherer,
+		previous: make(map[string]float64),
+	}
+}
+
+func getTestRegistry(t *testing.T) (*prometheus.Registry, *prometheus.CounterVec, prometheus.Gauge, prometheus.Histogram) {
+	registry := prometheus.NewRegistry()
+	counter := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "test_requests_total",
+		Help: "test counter",
+	}, []string{"protocol", "status"})
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "test_connections",
+		Help: "test gauge",
+	})
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name: "test_duration_seconds",
+		Help: "test histogram",
+	})
+	require.NoError(t, registry.Register(counter))
+	require.NoError(t, registry.Register(gauge))
+	require.NoError(t, registry.Register(histogram))
+	return registry, counter, gauge, histogram
+}
+
+func TestStatsDConfigValidation(t *testing.T) {
+	c := StatsDConfig{
+		Address:       "127.0.0.1:8125",
+		FlushInterval: 10,
+	}
+	require.NoError(t, c.validate())
+	assert.Equal(t, StatsDFormatPlain, c.Format)
+	c.Format = "graphite"
+	assert.Error(t, c.validate())
+	c.Format = StatsDFormatDogStatsD
+	c.Address = "127.0.0.1"
+	assert.Error(t, c.validate())
+	c.Address = "127.0.0.1:8125"
+	c.FlushInterval = 0
+	assert.Error(t, c.validate())
+	c.FlushInterval = 10
+	c.Tags = []string{"env:prod", "region:eu"}
+	assert.NoError(t, c.validate())
+	for _, tag := range []string{" ", "env:prod,region:eu", "env|prod", "#env"} {
+		c.Tags = []string{tag}
+		assert.Error(t, c.validate(), tag)
+	}
+	c.Tags = []string{"env:prod"}
+	c.Format = StatsDFormatPlain
+	err := c.validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "only supported for the \"dogstatsd\" format")
+	}
+}
+
+func TestStatsDPlainFormat(t *testing.T) {
+	server := getTestStatsDServer(t)
+	registry, counter, gauge, histogram := getTestRegistry(t)
+	p := newTestStatsDPusher(t, StatsDConfig{
+		Format: StatsDFormatPlain,
+		Prefix: "sftpgo.",
+	}, registry, server)
+
+	counter.WithLabelValues("SFTP", "ok").Add(3)
+	counter.WithLabelValues("FTP", "a.b:c").Inc()
+	gauge.Set(2.5)
+	histogram.Observe(0.5)
+	histogram.Observe(1)
+	p.push()
+	assert.Equal(t, []string{
+		"sftpgo.test_connections:2.5|g",
+		"sftpgo.test_duration_seconds_count:2|c",
+		"sftpgo.test_duration_seconds_sum:1.5|c",
+		"sftpgo.test_requests_total.FTP.a_b_c:1|c",
+		"sftpgo.test_requests_total.SFTP.ok:3|c",
+	}, readStatsDLines(t, server))
+	// the counters are sent as the difference from the previous push and only
+	// if they changed, the gauges are always sent
+	counter.WithLabelValues("SFTP", "ok").Add(2)
+	gauge.Set(1)
+	p.push()
+	assert.Equal(t, []string{
+		"sftpgo.test_connections:1|g",
+		"sftpgo.test_requests_total.SFTP.ok:2|c",
+	}, readStatsDLines(t, server))
+}
+
+func TestStatsDDogStatsDFormat(t *testing.T) {
+	server := getTestStatsDServer(t)
+	registry, counter, gauge, _ := getTestRegistry(t)
+	p := newTestStatsDPusher(t, StatsDConfig{
+		Format: StatsDFormatDogStatsD,
+		Tags:   []string{"env:test"},
+	}, registry, server)
+
+	counter.WithLabelValues("SFTP", "").Inc()
+	gauge.Set(4)
+	// the histogram has no observations, so its counters are not sent
+	p.push()
+	assert.Equal(t, []string{
+		"test_connections:4|g|#env:test",
+		"test_requests_total:1|c|#env:test,protocol:SFTP,status:none",
+	}, readStatsDLines(t, server))
+
+	p = newTestStatsDPusher(t, StatsDConfig{
+		Format: StatsDFormatDogStatsD,
+	}, registry, server)
+	p.push()
+	assert.Equal(t, []string{
+		"test_connections:4|g",
+		"test_requests_total:1|c|#protocol:SFTP,status:none",
+	}, readStatsDLines(t, server))
+}
+
+func TestStatsDPacketSize(t *testing.T) {
+	server := getTestStatsDServer(t)
+	p := newTestStatsDPusher(t, StatsDConfig{}, prometheus.NewRegistry(), server)
+
+	var lines []string
+	for i := 0; i < 200; i++ {
+		lines = append(lines, strings.Repeat("a", 30)+":1|c")
+	}
+	p.send(lines)
+	packets := readStatsDPackets(t, server)
+	assert.Greater(t, len(packets), 1)
+	var received []string
+	for _, packet := range packets {
+		assert.LessOrEqual(t, len(packet), statsDMaxPacketSize)
+		received = append(received, strings.Split(packet, "\n")...)
+	}
+	assert.Equal(t, lines, received)
+}
+
+func TestInitStatsD(t *testing.T) {
+	err := InitStatsD(StatsDConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, statsDExporter)
+	err = InitStatsD(StatsDConfig{
+		Address: "127.0.0.1:8125",
+		Format:  "invalid",
+	})
+	assert.Error(t, err)
+	assert.Nil(t, statsDExporter)
+
+	server := getTestStatsDServer(t)
+	err = InitStatsD(StatsDConfig{
+		Address:       server.LocalAddr().String(),
+		Prefix:        "sftpgo.",
+		FlushInterval: 3600,
+	})
+	require.NoError(t, err)
+	assert.NotNil(t, statsDExporter)
+	// the metrics are pushed a last time on stop
+	StopStatsD()
+	assert.Nil(t, statsDExporter)
+	lines := readStatsDLines(t, server)
+	assert.NotEmpty(t, lines)
+	for _, line := range lines {
+		assert.True(t, strings.HasPrefix(line, "sftpgo."), line)
+	}
+	// stopping again is a no-op
+	StopStatsD()
+}
+
+func TestSanitizeStatsDValue(t *testing.T) {
+	assert.Equal(t, "none", sanitizeStatsDValue(""))
+	assert.Equal(t, "a_b_c_d_e_f_g", sanitizeStatsDValue("a:b|c,d#e@f g"))
+	assert.Equal(t, "1.5", formatStatsDValue(1.5))
+	assert.Equal(t, "100", formatStatsDValue(100))
+}
@@ -0,0 +1,123 @@
+// +build !nometrics
+
+package metrics
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// otherUsersLabel is the username label used for the users exceeding the
+// per-user metrics limit. It is not a valid SFTPGo username
+const otherUsersLabel = "#other"
+
+var (
+	perUserMetricsMutex sync.RWMutex
+	// perUserMetricsLimit is the maximum number of users with dedicated
+	// metrics, 0 means per-user metrics disabled
+	perUserMetricsLimit int
+	perUserMetricsUsers = make(map[string]bool)
+
+	// userUploads is the metric that reports the number of successful uploads for each user
+	userUploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_uploads_total",
+		Help: "The total number of successful uploads for each user",
+	}, []string{"username"})
+
+	// userDownloads is the metric that reports the number of successful downloads for each user
+	userDownloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_downloads_total",
+		Help: "The total number of successful downloads for each user",
+	}, []string{"username"})
+
+	// userUploadErrors is the metric that reports the number of upload errors for each user
+	userUploadErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_upload_errors_total",
+		Help: "The total number of upload errors for each user",
+	}, []string{"username"})
+
+	// userDownloadErrors is the metric that reports the number of download errors for each user
+	userDownloadErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_download_errors_total",
+		Help: "The total number of download errors for each user",
+	}, []string{"username"})
+
+	// userUploadSize is the metric that reports the uploads size as bytes for each user
+	userUploadSize = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_upload_size",
+		Help: "The total upload size as bytes for each user, partial uploads are included",
+	}, []string{"username"})
+
+	// userDownloadSize is the metric that reports the downloads size as bytes for each user
+	userDownloadSize = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sftpgo_user_download_size",
+		Help: "The total download size as bytes for each user, partial downloads are included",
+	}, []string{"username"})
+)
+
+// SetPerUserMetricsLimit enables the per-user transfer metrics for up to
+// limit users, the transfers for the other users are reported using the
+// "#other" username. 0 disables the per-user metrics
+func SetPerUserMetricsLimit(limit int) {
+	perUserMetricsMutex.Lock()
+	defer perUserMetricsMutex.Unlock()
+
+	if limit < 0 {
+		limit = 0
+	}
+	perUserMetricsLimit = limit
+}
+
+// getUserLabel returns the username label for the given user, or an empty
+// string if the per-user metrics are disabled
+func getUserLabel(username string) string {
+	perUserMetricsMutex.RLock()
+	limit := perUserMetricsLimit
+	_, ok := perUserMetricsUsers[username]
+	perUserMetricsMutex.RUnlock()
+
+	if limit == 0 || username == "" {
+		return ""
+	}
+	if ok {
+		return username
+	}
+
+	perUserMetricsMutex.Lock()
+	defer perUserMetricsMutex.Unlock()
+
+	if _, ok := perUserMetricsUsers[username]; ok {
+		return username
+	}
+	if len(perUserMetricsUsers) >= perUserMetricsLimit {
+		return otherUsersLabel
+	}
+	perUserMetricsUsers[username] = true
+	return username
+}
+
+func userTransferCompleted(username string, bytesSent, bytesReceived int64, transferKind int, err error) {
+	label := getUserLabel(username)
+	if label == "" {
+		return
+	}
+	if transferKind == 0 {
+		// upload
+		if err == nil {
+			userUploads.WithLabelValues(label).Inc()
+		} else {
+			userUploadErrors.WithLabelValues(label).Inc()
+		}
+		userUploadSize.WithLabelValues(label).Add(float64(bytesReceived))
+	} else {
+		// download
+		if err == nil {
+			userDownloads.WithLabelValues(label).Inc()
+		} else {
+			userDownloadErrors.WithLabelValues(label).Inc()
+		}
+		userDownloadSize.WithLabelValues(label).Add(float64(bytesSent))
+	}
+}
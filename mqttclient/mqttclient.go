@@ -0,0 +1,109 @@
+// Package mqttclient publishes messages to an MQTT broker using the Eclipse
+// Paho client. It is used to send the actions notifications
+package mqttclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// quiesce is the time, in milliseconds, to wait for the pending work before disconnecting
+const quiesce = 250
+
+var (
+	errInvalidQoS   = errors.New("invalid QoS, allowed values are 0, 1 and 2")
+	errInvalidTopic = errors.New("invalid topic, it cannot be empty or contain wildcards")
+	errTimeout      = errors.New("timeout publishing the message")
+)
+
+// Options defines the options to connect to an MQTT broker
+type Options struct {
+	// Broker address as host:port
+	Address string
+	// TLS configuration, nil means plain TCP
+	TLSConfig *tls.Config
+	// Client identifier, can be empty
+	ClientID string
+	Username string
+	Password string
+	// Timeout for the whole publish, including the connection and the acknowledgements.
+	// 0 means no timeout
+	Timeout time.Duration
+}
+
+func (o *Options) getClientOptions() *mqtt.ClientOptions {
+	clientOpts := mqtt.NewClientOptions()
+	if o.TLSConfig != nil {
+		clientOpts.AddBroker(fmt.Sprintf("ssl://%v", o.Address))
+		clientOpts.SetTLSConfig(o.TLSConfig)
+	} else {
+		clientOpts.AddBroker(fmt.Sprintf("tcp://%v", o.Address))
+	}
+	clientOpts.SetClientID(o.ClientID)
+	clientOpts.SetUsername(o.Username)
+	clientOpts.SetPassword(o.Password)
+	clientOpts.SetCleanSession(true)
+	clientOpts.SetAutoReconnect(false)
+	clientOpts.SetConnectRetry(false)
+	clientOpts.SetConnectTimeout(o.Timeout)
+	clientOpts.SetWriteTimeout(o.Timeout)
+	return clientOpts
+}
+
+// Message defines a message to publish
+type Message struct {
+	Topic   string
+	Payload []byte
+	// 0 at most once, 1 at least once, 2 exactly once
+	QoS byte
+}
+
+func (m *Message) validate() error {
+	if m.QoS > 2 {
+		return errInvalidQoS
+	}
+	if m.Topic == "" || len(m.Topic) > 65535 {
+		return errInvalidTopic
+	}
+	for _, c := range m.Topic {
+		if c == '+' || c == '#' || c == 0 {
+			return errInvalidTopic
+		}
+	}
+	return nil
+}
+
+// Publish connects to the broker, publishes the given message and disconnects.
+// For QoS 1 and 2 it waits for the broker acknowledgements
+func Publish(opts *Options, msg *Message) error {
+	if err := msg.validate(); err != nil {
+		return err
+	}
+	var deadline time.Time
+	if opts.Timeout > 0 {
+		deadline = time.Now().Add(opts.Timeout)
+	}
+	client := mqtt.NewClient(opts.getClientOptions())
+	if err := waitToken(client.Connect(), deadline); err != nil {
+		return fmt.Errorf("unable to connect to MQTT broker %#v: %w", opts.Address, err)
+	}
+	defer client.Disconnect(quiesce)
+
+	return waitToken(client.Publish(msg.Topic, msg.QoS, false, msg.Payload), deadline)
+}
+
+// waitToken waits for the given token to complete, a zero deadline means no timeout
+func waitToken(token mqtt.Token, deadline time.Time) error {
+	if deadline.IsZero() {
+		token.Wait()
+		return token.Error()
+	}
+	if !token.WaitTimeout(time.Until(deadline)) {
+		return errTimeout
+	}
+	return token.Error()
+}
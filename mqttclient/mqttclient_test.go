@@ -0,0 +1,83 @@
+package mqttclient
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientOptions(t *testing.T) {
+	opts := &Options{
+		Address:  "127.0.0.1:1883",
+		ClientID: "sftpgo",
+		Username: "user",
+		Password: "pwd",
+		Timeout:  5 * time.Second,
+	}
+	clientOpts := opts.getClientOptions()
+	require.Len(t, clientOpts.Servers, 1)
+	assert.Equal(t, "tcp://127.0.0.1:1883", clientOpts.Servers[0].String())
+	assert.Equal(t, "sftpgo", clientOpts.ClientID)
+	assert.Equal(t, "user", clientOpts.Username)
+	assert.Equal(t, "pwd", clientOpts.Password)
+	assert.True(t, clientOpts.CleanSession)
+	assert.False(t, clientOpts.AutoReconnect)
+	assert.Equal(t, 5*time.Second, clientOpts.ConnectTimeout)
+
+	opts.Address = "127.0.0.1:8883"
+	opts.TLSConfig = &tls.Config{ServerName: "broker"}
+	clientOpts = opts.getClientOptions()
+	require.Len(t, clientOpts.Servers, 1)
+	assert.Equal(t, "ssl://127.0.0.1:8883", clientOpts.Servers[0].String())
+	assert.Equal(t, "broker", clientOpts.TLSConfig.ServerName)
+}
+
+func TestPublishErrors(t *testing.T) {
+	// get a free port with no broker listening on it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	opts := &Options{
+		Address: address,
+		Timeout: 5 * time.Second,
+	}
+	msg := &Message{
+		Topic: "topic",
+	}
+	err = Publish(opts, msg)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to connect to MQTT broker")
+	}
+	// the broker accepts the connection but never replies
+	listener, err = net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			time.Sleep(2 * time.Second)
+			conn.Close()
+		}
+	}()
+	opts.Address = listener.Addr().String()
+	opts.Timeout = 500 * time.Millisecond
+	err = Publish(opts, msg)
+	assert.Error(t, err)
+
+	msg.QoS = 3
+	err = Publish(opts, msg)
+	assert.ErrorIs(t, err, errInvalidQoS)
+	msg.QoS = 0
+	msg.Topic = "sftpgo/#"
+	err = Publish(opts, msg)
+	assert.ErrorIs(t, err, errInvalidTopic)
+	msg.Topic = ""
+	err = Publish(opts, msg)
+	assert.ErrorIs(t, err, errInvalidTopic)
+}
@@ -0,0 +1,14 @@
+package plugin
+
+type authPlugin struct {
+	config  AuthConfig
+	process *pluginProcess
+}
+
+func (a *authPlugin) authenticate(req *AuthRequest) ([]byte, error) {
+	resp := new(AuthResponse)
+	if err := a.process.invoke(AuthServiceName, "Authenticate", req, resp); err != nil {
+		return nil, err
+	}
+	return resp.User, nil
+}
@@ -0,0 +1,92 @@
+package plugin
+
+import (
+	"errors"
+
+	"github.com/drakkan/sftpgo/kms"
+)
+
+const kmsProviderName = "Plugin"
+
+var (
+	errWrongSecretStatus = errors.New("wrong secret status")
+	errInvalidSecret     = errors.New("invalid secret")
+)
+
+type kmsPlugin struct {
+	config  KMSConfig
+	process *pluginProcess
+}
+
+// newSecret is the kms.SecretProviderFn for this plugin
+func (k *kmsPlugin) newSecret(base kms.BaseSecret, url, masterKey string) kms.SecretProvider {
+	return &kmsPluginSecret{
+		BaseSecret: base,
+		url:        url,
+		masterKey:  masterKey,
+		plugin:     k,
+	}
+}
+
+// kmsPluginSecret is a kms.SecretProvider that encrypts and decrypts secrets using a KMS plugin
+type kmsPluginSecret struct {
+	kms.BaseSecret
+	url       string
+	masterKey string
+	plugin    *kmsPlugin
+}
+
+func (s *kmsPluginSecret) Name() string {
+	return kmsProviderName
+}
+
+func (s *kmsPluginSecret) IsEncrypted() bool {
+	return s.Status == s.plugin.config.EncryptedStatus
+}
+
+func (s *kmsPluginSecret) Encrypt() error {
+	if s.Status != kms.SecretStatusPlain {
+		return errWrongSecretStatus
+	}
+	if s.Payload == "" {
+		return errInvalidSecret
+	}
+	resp := new(KMSEncryptResponse)
+	err := s.plugin.process.invoke(KMSServiceName, "Encrypt", &KMSEncryptRequest{
+		Payload:        s.Payload,
+		AdditionalData: s.AdditionalData,
+		URL:            s.url,
+		MasterKey:      s.masterKey,
+	}, resp)
+	if err != nil {
+		return err
+	}
+	s.Status = s.plugin.config.EncryptedStatus
+	s.Payload = resp.Payload
+	s.Key = resp.Key
+	s.Mode = resp.Mode
+	return nil
+}
+
+func (s *kmsPluginSecret) Decrypt() error {
+	if !s.IsEncrypted() {
+		return errWrongSecretStatus
+	}
+	resp := new(KMSDecryptResponse)
+	err := s.plugin.process.invoke(KMSServiceName, "Decrypt", &KMSDecryptRequest{
+		Payload:        s.Payload,
+		Key:            s.Key,
+		AdditionalData: s.AdditionalData,
+		Mode:           s.Mode,
+		URL:            s.url,
+		MasterKey:      s.masterKey,
+	}, resp)
+	if err != nil {
+		return err
+	}
+	s.Status = kms.SecretStatusPlain
+	s.Payload = resp.Payload
+	s.Key = ""
+	s.AdditionalData = ""
+	return nil
+}
@@ -0,0 +1,93 @@
+package plugin
+
+import "encoding/json"
+
+// Empty is the response for methods without a result
+type Empty struct{}
+
+// FsEvent defines a filesystem event, for example an upload or a download
+type FsEvent struct {
+	// Timestamp is the event time as unix timestamp in milliseconds
+	Timestamp  int64  `json:"timestamp"`
+	Action     string `json:"action"`
+	Username   string `json:"username"`
+	Path       string `json:"path"`
+	TargetPath string `json:"target_path,omitempty"`
+	// virtual paths, as seen by the user
+	VirtualPath       string `json:"virtual_path,omitempty"`
+	VirtualTargetPath string `json:"virtual_target_path,omitempty"`
+	SSHCmd            string `json:"ssh_cmd,omitempty"`
+	FileSize          int64  `json:"file_size,omitempty"`
+	FsProvider        int    `json:"fs_provider"`
+	// 1 means no error, 2 generic error, 3 quota exceeded error
+	Status       int    `json:"status"`
+	Protocol     string `json:"protocol"`
+	IP           string `json:"ip,omitempty"`
+	ConnectionID string `json:"connection_id,omitempty"`
+}
+
+// ProviderEvent defines an event for a data provider object, for example a user update
+type ProviderEvent struct {
+	// Timestamp is the event time as unix timestamp in milliseconds
+	Timestamp  int64  `json:"timestamp"`
+	Action     string `json:"action"`
+	ObjectType string `json:"object_type"`
+	ObjectName string `json:"object_name"`
+	// Object is the object serialized as JSON, for the delete action
+	// this is the object as it was before the deletion
+	Object json.RawMessage `json:"object,omitempty"`
+}
+
+// AuthRequest is the request for the Authenticate method.
+// The fields are the same sent to the external authentication hook
+type AuthRequest struct {
+	Username            string `json:"username"`
+	IP                  string `json:"ip"`
+	Protocol            string `json:"protocol"`
+	Password            string `json:"password,omitempty"`
+	PublicKey           string `json:"public_key,omitempty"`
+	KeyboardInteractive bool   `json:"keyboard_interactive,omitempty"`
+	// TLSCert is the client certificate as PEM
+	TLSCert string `json:"tls_cert,omitempty"`
+	// User is the existing SFTPGo user serialized as JSON, the id is 0
+	// if the user does not exist
+	User json.RawMessage `json:"user,omitempty"`
+}
+
+// AuthResponse is the response for the Authenticate method
+type AuthResponse struct {
+	// User serialized as JSON. An empty user means no modification for an existing user,
+	// a user with an empty username means authentication failure
+	User json.RawMessage `json:"user,omitempty"`
+}
+
+// KMSEncryptRequest is the request for the KMS Encrypt method
+type KMSEncryptRequest struct {
+	Payload        string `json:"payload"`
+	AdditionalData string `json:"additional_data,omitempty"`
+	// URL and MasterKey are the configured KMS URL and master key, if any
+	URL       string `json:"url"`
+	MasterKey string `json:"master_key,omitempty"`
+}
+
+// KMSEncryptResponse is the response for the KMS Encrypt method
+type KMSEncryptResponse struct {
+	Payload string `json:"payload"`
+	Key     string `json:"key,omitempty"`
+	Mode    int    `json:"mode,omitempty"`
+}
+
+// KMSDecryptRequest is the request for the KMS Decrypt method
+type KMSDecryptRequest struct {
+	Payload        string `json:"payload"`
+	Key            string `json:"key,omitempty"`
+	AdditionalData string `json:"additional_data,omitempty"`
+	Mode           int    `json:"mode,omitempty"`
+	URL            string `json:"url"`
+	MasterKey      string `json:"master_key,omitempty"`
+}
+
+// KMSDecryptResponse is the response for the KMS Decrypt method
+type KMSDecryptResponse struct {
+	Payload string `json:"payload"`
+}
@@ -0,0 +1,41 @@
+package plugin
+
+import (
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+type notifierPlugin struct {
+	config  NotifierConfig
+	process *pluginProcess
+}
+
+func (n *notifierPlugin) handlesFsEvent(action string) bool {
+	return utils.IsStringInSlice(action, n.config.FsEvents)
+}
+
+func (n *notifierPlugin) handlesProviderEvent(action, objectType string) bool {
+	if !utils.IsStringInSlice(action, n.config.ProviderEvents) {
+		return false
+	}
+	if len(n.config.ProviderObjects) == 0 {
+		return true
+	}
+	return utils.IsStringInSlice(objectType, n.config.ProviderObjects)
+}
+
+func (n *notifierPlugin) notifyFsEvent(event *FsEvent) {
+	err := n.process.invoke(NotifierServiceName, "NotifyFsEvent", event, new(Empty))
+	if err != nil {
+		logger.Warn(logSender, event.ConnectionID, "unable to notify fs event %#v to plugin %#v: %v",
+			event.Action, n.process.config.Cmd, err)
+	}
+}
+
+func (n *notifierPlugin) notifyProviderEvent(event *ProviderEvent) {
+	err := n.process.invoke(NotifierServiceName, "NotifyProviderEvent", event, new(Empty))
+	if err != nil {
+		logger.Warn(logSender, "", "unable to notify provider event %#v for %v %#v to plugin %#v: %v",
+			event.Action, event.ObjectType, event.ObjectName, n.process.config.Cmd, err)
+	}
+}
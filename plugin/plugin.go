@@ -0,0 +1,302 @@
+// Package plugin allows to extend SFTPGo using external plugins.
+// A plugin is a separate binary launched and supervised by SFTPGo: it exposes one or
+// more gRPC services, using the JSON codec, and SFTPGo connects to them to send
+// notifications, authenticate users, encrypt and decrypt secrets or access a storage
+// backend. This way extensions can be shipped independently from the SFTPGo binary.
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+const (
+	logSender = "plugins"
+)
+
+// Supported plugin types
+const (
+	TypeNotifier = "notifier"
+	TypeAuth     = "auth"
+	TypeKMS      = "kms"
+	TypeStorage  = "storage"
+)
+
+// Supported authentication scopes for the auth plugins.
+// They have the same meaning as for the external authentication hook
+const (
+	AuthScopePassword            = 1
+	AuthScopePublicKey           = 2
+	AuthScopeKeyboardInteractive = 4
+	AuthScopeTLSCertificate      = 8
+)
+
+var (
+	validPluginTypes = []string{TypeNotifier, TypeAuth, TypeKMS, TypeStorage}
+	// Handler defines the plugins manager
+	Handler Manager
+)
+
+// NotifierConfig defines the configuration for notifier plugins
+type NotifierConfig struct {
+	// Filesystem events to notify, for example upload, download, delete.
+	// The supported events are the same as for the custom actions
+	FsEvents []string `json:"fs_events" mapstructure:"fs_events"`
+	// Provider events to notify: add, update, delete
+	ProviderEvents []string `json:"provider_events" mapstructure:"provider_events"`
+	// Provider objects to notify: user, folder, admin. Empty means all
+	ProviderObjects []string `json:"provider_objects" mapstructure:"provider_objects"`
+}
+
+// AuthConfig defines the configuration for authentication plugins
+type AuthConfig struct {
+	// Scope defines the authentication methods handled by the plugin:
+	// 1 password, 2 public key, 4 keyboard interactive, 8 TLS certificate.
+	// You can combine them, for example 3 means password and public key
+	Scope int `json:"scope" mapstructure:"scope"`
+}
+
+// KMSConfig defines the configuration for KMS plugins
+type KMSConfig struct {
+	// Scheme is the KMS URL scheme handled by the plugin. Secrets are encrypted
+	// using the plugin if the configured KMS URL uses this scheme
+	Scheme string `json:"scheme" mapstructure:"scheme"`
+	// EncryptedStatus is the status for the secrets encrypted by the plugin.
+	// Secrets with this status are decrypted using the plugin
+	EncryptedStatus string `json:"encrypted_status" mapstructure:"encrypted_status"`
+}
+
+// StorageConfig defines the configuration for storage plugins
+type StorageConfig struct {
+	// Name for the plugin, users and folders can use this plugin setting
+	// "plugin://<name>" as storage plugin endpoint
+	Name string `json:"name" mapstructure:"name"`
+}
+
+// Config defines a plugin configuration
+type Config struct {
+	// Plugin type: notifier, auth, kms, storage
+	Type string `json:"type" mapstructure:"type"`
+	// NotifierOptions defines additional options for notifier plugins
+	NotifierOptions NotifierConfig `json:"notifier_options" mapstructure:"notifier_options"`
+	// AuthOptions defines additional options for authentication plugins
+	AuthOptions AuthConfig `json:"auth_options" mapstructure:"auth_options"`
+	// KMSOptions defines additional options for KMS plugins
+	KMSOptions KMSConfig `json:"kms_options" mapstructure:"kms_options"`
+	// StorageOptions defines additional options for storage plugins
+	StorageOptions StorageConfig `json:"storage_options" mapstructure:"storage_options"`
+	// Absolute path to the plugin executable
+	Cmd string `json:"cmd" mapstructure:"cmd"`
+	// Args for the plugin executable
+	Args []string `json:"args" mapstructure:"args"`
+	// SHA256 checksum for the plugin executable.
+	// If not empty it will be used to verify the integrity of the executable
+	SHA256Sum string `json:"sha256sum" mapstructure:"sha256sum"`
+}
+
+func (c *Config) validate() error {
+	if !utils.IsStringInSlice(c.Type, validPluginTypes) {
+		return fmt.Errorf("invalid plugin type %#v", c.Type)
+	}
+	if !filepath.IsAbs(c.Cmd) {
+		return fmt.Errorf("invalid plugin command %#v: it must be an absolute path", c.Cmd)
+	}
+	switch c.Type {
+	case TypeNotifier:
+		if len(c.NotifierOptions.FsEvents) == 0 && len(c.NotifierOptions.ProviderEvents) == 0 {
+			return fmt.Errorf("no events defined for the notifier plugin %#v", c.Cmd)
+		}
+	case TypeAuth:
+		if c.AuthOptions.Scope < 1 || c.AuthOptions.Scope > 15 {
+			return fmt.Errorf("invalid scope %v for the auth plugin %#v", c.AuthOptions.Scope, c.Cmd)
+		}
+	case TypeKMS:
+		if c.KMSOptions.Scheme == "" || c.KMSOptions.EncryptedStatus == "" {
+			return fmt.Errorf("scheme and encrypted status are required for the kms plugin %#v", c.Cmd)
+		}
+	case TypeStorage:
+		if c.StorageOptions.Name == "" {
+			return fmt.Errorf("a name is required for the storage plugin %#v", c.Cmd)
+		}
+	}
+	return nil
+}
+
+func (c *Config) verifyChecksum() error {
+	if c.SHA256Sum == "" {
+		return nil
+	}
+	f, err := os.Open(c.Cmd)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if checksum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(checksum, c.SHA256Sum) {
+		return fmt.Errorf("checksum mismatch for plugin %#v: %v, expected %v", c.Cmd, checksum, c.SHA256Sum)
+	}
+	return nil
+}
+
+// Manager handles the configured plugins
+type Manager struct {
+	sync.RWMutex
+	processes []*pluginProcess
+	notifiers []*notifierPlugin
+	auths     []*authPlugin
+}
+
+// Initialize launches the configured plugins.
+// Any previously started plugin is stopped
+func Initialize(configs []Config) error {
+	Handler.Cleanup()
+
+	for idx := range configs {
+		if err := configs[idx].validate(); err != nil {
+			return err
+		}
+	}
+
+	Handler.Lock()
+	defer Handler.Unlock()
+
+	for idx := range configs {
+		config := configs[idx]
+		var onConnect func(*pluginProcess)
+		if config.Type == TypeStorage {
+			name := config.StorageOptions.Name
+			onConnect = func(p *pluginProcess) {
+				vfs.SetManagedPluginConn(name, p.getConn())
+			}
+		}
+		p := newPluginProcess(config, onConnect)
+		if err := p.start(); err != nil {
+			Handler.stopProcesses()
+			return fmt.Errorf("unable to start plugin %#v: %w", config.Cmd, err)
+		}
+		Handler.processes = append(Handler.processes, p)
+
+		switch config.Type {
+		case TypeNotifier:
+			Handler.notifiers = append(Handler.notifiers, &notifierPlugin{
+				config:  config.NotifierOptions,
+				process: p,
+			})
+		case TypeAuth:
+			Handler.auths = append(Handler.auths, &authPlugin{
+				config:  config.AuthOptions,
+				process: p,
+			})
+		case TypeKMS:
+			kmsProvider := &kmsPlugin{
+				config:  config.KMSOptions,
+				process: p,
+			}
+			kms.RegisterSecretProvider(config.KMSOptions.Scheme, config.KMSOptions.EncryptedStatus, kmsProvider.newSecret)
+		}
+		logger.Info(logSender, "", "plugin %#v, type %#v started", config.Cmd, config.Type)
+	}
+	return nil
+}
+
+// Cleanup stops all the running plugins
+func (m *Manager) Cleanup() {
+	m.Lock()
+	defer m.Unlock()
+
+	m.stopProcesses()
+}
+
+func (m *Manager) stopProcesses() {
+	for _, p := range m.processes {
+		p.stop()
+	}
+	m.processes = nil
+	m.notifiers = nil
+	m.auths = nil
+}
+
+// NotifyFsEvent sends the given filesystem event to the interested notifier plugins.
+// Notifications are sent asynchronously
+func (m *Manager) NotifyFsEvent(event *FsEvent) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, n := range m.notifiers {
+		if n.handlesFsEvent(event.Action) {
+			go n.notifyFsEvent(event)
+		}
+	}
+}
+
+// HasNotifiersForProviderEvent returns true if at least a notifier plugin handles
+// the given provider event for the specified object type
+func (m *Manager) HasNotifiersForProviderEvent(action, objectType string) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, n := range m.notifiers {
+		if n.handlesProviderEvent(action, objectType) {
+			return true
+		}
+	}
+	return false
+}
+
+// NotifyProviderEvent sends the given provider event to the interested notifier plugins.
+// Notifications are sent asynchronously
+func (m *Manager) NotifyProviderEvent(event *ProviderEvent) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, n := range m.notifiers {
+		if n.handlesProviderEvent(event.Action, event.ObjectType) {
+			go n.notifyProviderEvent(event)
+		}
+	}
+}
+
+// HasAuthScope returns true if at least an auth plugin handles the given authentication scope
+func (m *Manager) HasAuthScope(scope int) bool {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, a := range m.auths {
+		if a.config.Scope&scope != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate authenticates the given request using the first auth plugin that
+// handles the specified scope. The response has the same meaning as the one
+// returned by the external authentication hook: an empty response means no
+// modification for an existing user, otherwise the user serialized as JSON
+func (m *Manager) Authenticate(scope int, req *AuthRequest) ([]byte, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	for _, a := range m.auths {
+		if a.config.Scope&scope != 0 {
+			return a.authenticate(req)
+		}
+	}
+	return nil, errors.New("no auth plugin configured for the requested scope")
+}
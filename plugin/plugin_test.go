@@ -0,0 +1,105 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidation(t *testing.T) {
+	c := Config{
+		Type: "unknown",
+	}
+	assert.Error(t, c.validate())
+	c.Type = TypeNotifier
+	c.Cmd = "relative"
+	assert.Error(t, c.validate())
+	c.Cmd = filepath.Join(os.TempDir(), "plugin")
+	assert.Error(t, c.validate())
+	c.NotifierOptions.FsEvents = []string{"upload"}
+	assert.NoError(t, c.validate())
+	c.Type = TypeAuth
+	assert.Error(t, c.validate())
+	c.AuthOptions.Scope = 16
+	assert.Error(t, c.validate())
+	c.AuthOptions.Scope = AuthScopePassword | AuthScopePublicKey
+	assert.NoError(t, c.validate())
+	c.Type = TypeKMS
+	c.KMSOptions.Scheme = "custom"
+	assert.Error(t, c.validate())
+	c.KMSOptions.EncryptedStatus = "Custom"
+	assert.NoError(t, c.validate())
+	c.Type = TypeStorage
+	assert.Error(t, c.validate())
+	c.StorageOptions.Name = "storage"
+	assert.NoError(t, c.validate())
+}
+
+func TestNotifierFilters(t *testing.T) {
+	n := notifierPlugin{
+		config: NotifierConfig{
+			FsEvents:       []string{"upload"},
+			ProviderEvents: []string{"add"},
+		},
+	}
+	assert.True(t, n.handlesFsEvent("upload"))
+	assert.False(t, n.handlesFsEvent("download"))
+	assert.True(t, n.handlesProviderEvent("add", "user"))
+	assert.True(t, n.handlesProviderEvent("add", "folder"))
+	assert.False(t, n.handlesProviderEvent("delete", "user"))
+	n.config.ProviderObjects = []string{"user"}
+	assert.True(t, n.handlesProviderEvent("add", "user"))
+	assert.False(t, n.handlesProviderEvent("add", "folder"))
+}
+
+func TestPluginStartErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("this test is not available on Windows")
+	}
+	pluginPath := filepath.Join(os.TempDir(), "test_plugin.sh")
+	defer os.Remove(pluginPath)
+
+	config := Config{
+		Type: TypeNotifier,
+		NotifierOptions: NotifierConfig{
+			FsEvents: []string{"upload"},
+		},
+		Cmd: pluginPath,
+	}
+	err := Initialize([]Config{config})
+	assert.Error(t, err)
+
+	err = os.WriteFile(pluginPath, []byte("#!/bin/sh\n\nexit 1\n"), os.ModePerm)
+	require.NoError(t, err)
+	err = Initialize([]Config{config})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "handshake")
+	}
+
+	content := []byte("#!/bin/sh\n\necho '2|unix:///tmp/plugin.sock'\n")
+	err = os.WriteFile(pluginPath, content, os.ModePerm)
+	require.NoError(t, err)
+	err = Initialize([]Config{config})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unsupported plugin protocol version")
+	}
+
+	config.SHA256Sum = "invalid"
+	err = Initialize([]Config{config})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "checksum mismatch")
+	}
+	checksum := sha256.Sum256(content)
+	config.SHA256Sum = hex.EncodeToString(checksum[:])
+	assert.NoError(t, config.verifyChecksum())
+
+	Handler.Cleanup()
+	assert.False(t, Handler.HasAuthScope(AuthScopePassword))
+	assert.False(t, Handler.HasNotifiersForProviderEvent("add", "user"))
+}
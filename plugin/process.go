@@ -0,0 +1,251 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/vfs/fsplugin"
+)
+
+const (
+	// MagicCookieKey is the environment variable set by SFTPGo when launching a plugin
+	MagicCookieKey = "SFTPGO_PLUGIN_MAGIC_COOKIE"
+	// MagicCookieValue is the value for the MagicCookieKey environment variable.
+	// It allows plugins to detect that they are launched by SFTPGo
+	MagicCookieValue = "85dc1f3f-2c54-4f5f-9ccf-1d2b3e2a9f0d"
+	// protocolVersion is the version for the handshake protocol
+	protocolVersion = "1"
+	// max time to wait for the plugin handshake
+	handshakeTimeout = 30 * time.Second
+	// max time to wait for a plugin to exit after closing its stdin
+	stopTimeout = 5 * time.Second
+	// max delay between two restart attempts for a crashed plugin
+	maxRestartDelay = 60 * time.Second
+	// timeout for the requests to the plugins
+	requestTimeout = 30 * time.Second
+)
+
+// pluginProcess launches and supervises a plugin process.
+// Crashed plugins are automatically restarted
+type pluginProcess struct {
+	config    Config
+	onConnect func(*pluginProcess)
+	mu        sync.RWMutex
+	conn      *grpc.ClientConn
+	stdin     io.WriteCloser
+	exited    chan bool
+	cmd       *exec.Cmd
+	stopped   bool
+}
+
+func newPluginProcess(config Config, onConnect func(*pluginProcess)) *pluginProcess {
+	return &pluginProcess{
+		config:    config,
+		onConnect: onConnect,
+	}
+}
+
+func (p *pluginProcess) getConn() *grpc.ClientConn {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.conn
+}
+
+// invoke calls the given method for the specified plugin service
+func (p *pluginProcess) invoke(serviceName, method string, req, resp interface{}) error {
+	conn := p.getConn()
+	if conn == nil {
+		return fmt.Errorf("plugin %#v is not connected", p.config.Cmd)
+	}
+	ctx, cancelFn := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancelFn()
+
+	return conn.Invoke(ctx, getFullMethod(serviceName, method), req, resp,
+		grpc.CallContentSubtype(fsplugin.CodecName))
+}
+
+func (p *pluginProcess) isStopped() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.stopped
+}
+
+func (p *pluginProcess) start() error {
+	if err := p.config.verifyChecksum(); err != nil {
+		return err
+	}
+	cmd := exec.Command(p.config.Cmd, p.config.Args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%v=%v", MagicCookieKey, MagicCookieValue))
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan bool)
+	go func() {
+		err := cmd.Wait()
+		close(exited)
+		p.onExit(cmd, err)
+	}()
+	go p.logOutput(bufio.NewReader(stderr))
+
+	reader := bufio.NewReader(stdout)
+	target, err := p.readHandshake(reader, exited)
+	if err != nil {
+		p.kill(cmd, stdin, exited)
+		return err
+	}
+	go p.logOutput(reader)
+
+	conn, err := grpc.Dial(target, grpc.WithInsecure())
+	if err != nil {
+		p.kill(cmd, stdin, exited)
+		return fmt.Errorf("unable to connect to %#v: %w", target, err)
+	}
+
+	p.mu.Lock()
+	oldConn := p.conn
+	p.conn = conn
+	p.stdin = stdin
+	p.exited = exited
+	p.cmd = cmd
+	p.mu.Unlock()
+
+	if oldConn != nil {
+		oldConn.Close()
+	}
+	if p.onConnect != nil {
+		p.onConnect(p)
+	}
+	logger.Debug(logSender, "", "plugin %#v connected, target: %#v", p.config.Cmd, target)
+	return nil
+}
+
+// readHandshake reads the handshake line written by the plugin on its stdout.
+// The expected format is "<protocol version>|<gRPC target>"
+func (p *pluginProcess) readHandshake(reader *bufio.Reader, exited chan bool) (string, error) {
+	type result struct {
+		line string
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		line, err := reader.ReadString('\n')
+		ch <- result{line: line, err: err}
+	}()
+
+	select {
+	case res := <-ch:
+		if res.err != nil {
+			return "", fmt.Errorf("unable to read plugin handshake: %w", res.err)
+		}
+		parts := strings.Split(strings.TrimSpace(res.line), "|")
+		if len(parts) != 2 || parts[1] == "" {
+			return "", fmt.Errorf("invalid plugin handshake %#v", res.line)
+		}
+		if parts[0] != protocolVersion {
+			return "", fmt.Errorf("unsupported plugin protocol version %#v, expected %#v", parts[0], protocolVersion)
+		}
+		return parts[1], nil
+	case <-exited:
+		return "", errors.New("plugin exited before completing the handshake")
+	case <-time.After(handshakeTimeout):
+		return "", errors.New("timeout waiting for plugin handshake")
+	}
+}
+
+func (p *pluginProcess) logOutput(reader *bufio.Reader) {
+	for {
+		line, err := reader.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" {
+			logger.Debug(logSender, "", "plugin %#v: %v", p.config.Cmd, line)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *pluginProcess) onExit(cmd *exec.Cmd, err error) {
+	p.mu.RLock()
+	isCurrent := p.cmd == cmd
+	p.mu.RUnlock()
+
+	if !isCurrent || p.isStopped() {
+		return
+	}
+	logger.Warn(logSender, "", "plugin %#v exited unexpectedly, error: %v, restarting", p.config.Cmd, err)
+	for retry := 1; ; retry++ {
+		delay := time.Duration(retry) * time.Second
+		if delay > maxRestartDelay {
+			delay = maxRestartDelay
+		}
+		time.Sleep(delay)
+		if p.isStopped() {
+			return
+		}
+		err := p.start()
+		if err == nil {
+			logger.Info(logSender, "", "plugin %#v restarted", p.config.Cmd)
+			return
+		}
+		logger.Warn(logSender, "", "unable to restart plugin %#v, attempt %v, error: %v", p.config.Cmd, retry, err)
+	}
+}
+
+// kill terminates a plugin that failed to start
+func (p *pluginProcess) kill(cmd *exec.Cmd, stdin io.WriteCloser, exited chan bool) {
+	stdin.Close()
+	cmd.Process.Kill() //nolint:errcheck
+	<-exited
+}
+
+// stop asks the plugin to exit closing its stdin, the plugin is killed
+// if it does not exit within the stop timeout
+func (p *pluginProcess) stop() {
+	p.mu.Lock()
+	p.stopped = true
+	conn := p.conn
+	stdin := p.stdin
+	exited := p.exited
+	cmd := p.cmd
+	p.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+	if cmd == nil {
+		return
+	}
+	stdin.Close()
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		logger.Warn(logSender, "", "plugin %#v does not exit, killing it", p.config.Cmd)
+		cmd.Process.Kill() //nolint:errcheck
+	}
+	logger.Debug(logSender, "", "plugin %#v stopped", p.config.Cmd)
+}
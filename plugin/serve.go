@@ -0,0 +1,84 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"google.golang.org/grpc"
+
+	"github.com/drakkan/sftpgo/vfs/fsplugin"
+)
+
+// ServeConfig defines the services implemented by a plugin, nil services are not exposed
+type ServeConfig struct {
+	Notifier      Notifier
+	Authenticator Authenticator
+	KMS           KMS
+	Filesystem    fsplugin.Filesystem
+}
+
+// Serve exposes the configured services and completes the handshake with SFTPGo.
+// Go plugins must call this function from their main function, it blocks until
+// SFTPGo asks the plugin to exit, closing its stdin, or SFTPGo terminates
+func Serve(config *ServeConfig) error {
+	if os.Getenv(MagicCookieKey) != MagicCookieValue {
+		return errors.New("this program is an SFTPGo plugin and it must be launched by SFTPGo")
+	}
+	listener, target, err := getPluginListener()
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer()
+	if config.Notifier != nil {
+		server.RegisterService(&notifierServiceDesc, config.Notifier)
+	}
+	if config.Authenticator != nil {
+		server.RegisterService(&authServiceDesc, config.Authenticator)
+	}
+	if config.KMS != nil {
+		server.RegisterService(&kmsServiceDesc, config.KMS)
+	}
+	if config.Filesystem != nil {
+		fsplugin.RegisterFilesystem(server, config.Filesystem)
+	}
+	go func() {
+		// SFTPGo closes our stdin when the plugin must exit
+		io.Copy(io.Discard, os.Stdin) //nolint:errcheck
+		server.Stop()
+	}()
+
+	fmt.Printf("%v|%v\n", protocolVersion, target)
+	err = server.Serve(listener)
+	if addr, ok := listener.Addr().(*net.UnixAddr); ok {
+		os.Remove(addr.Name)
+	}
+	return err
+}
+
+// getPluginListener returns a listener on a unix domain socket, or on
+// the loopback interface on Windows, and the gRPC target to reach it
+func getPluginListener() (net.Listener, string, error) {
+	if runtime.GOOS == "windows" {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, "", err
+		}
+		return listener, listener.Addr().String(), nil
+	}
+	socketPath := filepath.Join(os.TempDir(), fmt.Sprintf("sftpgo-plugin-%v.sock", os.Getpid()))
+	os.Remove(socketPath)
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, "", err
+	}
+	return listener, "unix://" + socketPath, nil
+}
@@ -0,0 +1,119 @@
+package plugin
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// gRPC service names that plugins must expose
+const (
+	NotifierServiceName = "sftpgo.plugin.v1.Notifier"
+	AuthServiceName     = "sftpgo.plugin.v1.Auth"
+	KMSServiceName      = "sftpgo.plugin.v1.KMS"
+)
+
+// Notifier defines the interface that notifier plugins must implement
+type Notifier interface {
+	// NotifyFsEvent is called for the configured filesystem events
+	NotifyFsEvent(ctx context.Context, event *FsEvent) (*Empty, error)
+	// NotifyProviderEvent is called for the configured provider events
+	NotifyProviderEvent(ctx context.Context, event *ProviderEvent) (*Empty, error)
+}
+
+// Authenticator defines the interface that auth plugins must implement
+type Authenticator interface {
+	// Authenticate checks the user credentials for the configured scopes
+	Authenticate(ctx context.Context, req *AuthRequest) (*AuthResponse, error)
+}
+
+// KMS defines the interface that KMS plugins must implement
+type KMS interface {
+	// Encrypt encrypts the given payload
+	Encrypt(ctx context.Context, req *KMSEncryptRequest) (*KMSEncryptResponse, error)
+	// Decrypt decrypts the given payload
+	Decrypt(ctx context.Context, req *KMSDecryptRequest) (*KMSDecryptResponse, error)
+}
+
+func getFullMethod(serviceName, method string) string {
+	return "/" + serviceName + "/" + method
+}
+
+func newUnaryHandler(serviceName, method string, newRequest func() interface{},
+	call func(interface{}, context.Context, interface{}) (interface{}, error),
+) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+		interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := newRequest()
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv, ctx, req)
+		}
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: getFullMethod(serviceName, method),
+		}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+var notifierServiceDesc = grpc.ServiceDesc{
+	ServiceName: NotifierServiceName,
+	HandlerType: (*Notifier)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "NotifyFsEvent",
+			Handler: newUnaryHandler(NotifierServiceName, "NotifyFsEvent", func() interface{} { return new(FsEvent) },
+				func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(Notifier).NotifyFsEvent(ctx, req.(*FsEvent))
+				}),
+		},
+		{
+			MethodName: "NotifyProviderEvent",
+			Handler: newUnaryHandler(NotifierServiceName, "NotifyProviderEvent", func() interface{} { return new(ProviderEvent) },
+				func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(Notifier).NotifyProviderEvent(ctx, req.(*ProviderEvent))
+				}),
+		},
+	},
+}
+
+var authServiceDesc = grpc.ServiceDesc{
+	ServiceName: AuthServiceName,
+	HandlerType: (*Authenticator)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Authenticate",
+			Handler: newUnaryHandler(AuthServiceName, "Authenticate", func() interface{} { return new(AuthRequest) },
+				func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(Authenticator).Authenticate(ctx, req.(*AuthRequest))
+				}),
+		},
+	},
+}
+
+var kmsServiceDesc = grpc.ServiceDesc{
+	ServiceName: KMSServiceName,
+	HandlerType: (*KMS)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Encrypt",
+			Handler: newUnaryHandler(KMSServiceName, "Encrypt", func() interface{} { return new(KMSEncryptRequest) },
+				func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(KMS).Encrypt(ctx, req.(*KMSEncryptRequest))
+				}),
+		},
+		{
+			MethodName: "Decrypt",
+			Handler: newUnaryHandler(KMSServiceName, "Decrypt", func() interface{} { return new(KMSDecryptRequest) },
+				func(srv interface{}, ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(KMS).Decrypt(ctx, req.(*KMSDecryptRequest))
+				}),
+		},
+	},
+}
@@ -0,0 +1,526 @@
+package rsyncd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+var (
+	errUnsupportedConfig = errors.New("command unsupported for this configuration")
+	errInvalidArgs       = errors.New("invalid rsync arguments")
+	errReadOnlyModule    = errors.New("the module is read only")
+	// long options that could be used to read or write outside the module
+	// or to bypass the enforced symlinks handling. Abbreviations are denied too
+	deniedLongOptions = []string{"daemon", "config", "rsh", "rsync-path", "log-file", "write-batch", "read-batch",
+		"only-write-batch", "files-from", "exclude-from", "include-from", "filter", "temp-dir", "partial-dir",
+		"backup-dir", "compare-dest", "copy-dest", "link-dest", "password-file", "remote-option", "copy-links",
+		"copy-unsafe-links", "copy-dirlinks", "keep-dirlinks", "write-devices", "no-safe-links", "no-munge-links"}
+	// short options with the same meaning as the denied long ones
+	deniedShortOptions = "LkKTfM"
+	// long options that can be followed by a separate value
+	valueLongOptions = []string{"--usermap", "--groupmap", "--chown", "--chmod", "--suffix", "--iconv",
+		"--skip-compress", "--compress-choice", "--checksum-choice", "--zc", "--cc", "--info", "--debug"}
+	// exact long options allowed even if they are a prefix of a denied one
+	allowedLongOptions = []string{"backup"}
+)
+
+// Connection details for a rsync daemon connection.
+// It implements common.ActiveConnection
+type Connection struct {
+	*common.BaseConnection
+	conn      net.Conn
+	module    Module
+	protocol  int
+	rsyncPath string
+	mu        sync.RWMutex
+	command   string
+}
+
+// GetClientVersion returns the negotiated rsync protocol version
+func (c *Connection) GetClientVersion() string {
+	return fmt.Sprintf("rsync protocol %v", c.protocol)
+}
+
+// GetRemoteAddress return the connected client's address
+func (c *Connection) GetRemoteAddress() string {
+	return c.conn.RemoteAddr().String()
+}
+
+// Disconnect disconnects the client
+func (c *Connection) Disconnect() error {
+	return c.conn.Close()
+}
+
+// GetCommand returns the rsync command requested by the client
+func (c *Connection) GetCommand() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.command
+}
+
+func (c *Connection) setCommand(command string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.command = command
+}
+
+type rsyncArgs struct {
+	options  []string
+	paths    []string
+	isSender bool
+}
+
+type rsyncCommand struct {
+	cmd            *exec.Cmd
+	fs             vfs.Fs
+	fsPath         string
+	virtualPath    string
+	quotaCheckPath string
+	isUpload       bool
+}
+
+func (c *Connection) execute(args []string) error {
+	c.setCommand(fmt.Sprintf("rsync %v", strings.Join(args, " ")))
+	parsedArgs, err := parseArgs(args)
+	if err != nil {
+		c.Log(logger.LevelDebug, "rejected rsync arguments %+v: %v", args, err)
+		return err
+	}
+	command, err := c.getRsyncCommand(parsedArgs)
+	if err != nil {
+		return err
+	}
+	if !command.isUpload {
+		return c.GetFsError(command.fs, c.runCommand(command, 0))
+	}
+	quotaResult := c.HasSpace(true, false, command.quotaCheckPath)
+	if !quotaResult.HasSpace {
+		return common.ErrQuotaExceeded
+	}
+	initialFiles, initialSize, err := c.getSizeForPath(command.fs, command.fsPath)
+	if err != nil {
+		return err
+	}
+	err = c.runCommand(command, quotaResult.GetRemainingSize())
+
+	numFiles, dirSize, errSize := c.getSizeForPath(command.fs, command.fsPath)
+	if errSize == nil {
+		c.updateQuota(command.virtualPath, numFiles-initialFiles, dirSize-initialSize)
+	}
+	c.Log(logger.LevelDebug, "command %#v finished for path %#v, initial files %v initial size %v "+
+		"current files %v current size %v size err: %v", c.GetCommand(), command.fsPath, initialFiles, initialSize,
+		numFiles, dirSize, errSize)
+	return c.GetFsError(command.fs, err)
+}
+
+func (c *Connection) runCommand(command rsyncCommand, remainingQuotaSize int64) error {
+	stdin, err := command.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := command.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := command.cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := command.cmd.Start(); err != nil {
+		return err
+	}
+	// the protocol version was already negotiated during the handshake, the
+	// server side process expects it as a binary integer and sends its own one
+	if err := c.exchangeProtocolVersion(stdin, stdout); err != nil {
+		killerr := command.cmd.Process.Kill()
+		c.Log(logger.LevelDebug, "unable to exchange the protocol version: %v, kill cmd error: %v", err, killerr)
+		command.cmd.Wait() //nolint:errcheck
+		return err
+	}
+
+	closeCmdOnError := func() {
+		c.Log(logger.LevelDebug, "kill cmd: %#v and close connection after read or write error", c.GetCommand())
+		killerr := command.cmd.Process.Kill()
+		closerr := c.conn.Close()
+		c.Log(logger.LevelDebug, "kill cmd error: %v close connection error: %v", killerr, closerr)
+	}
+	var once sync.Once
+	commandResponse := make(chan bool)
+
+	go func() {
+		defer stdin.Close()
+		transfer := common.NewBaseTransfer(nil, c.BaseConnection, nil, command.fsPath, command.virtualPath,
+			common.TransferUpload, 0, 0, remainingQuotaSize, false, command.fs)
+
+		w, e := copyFromReaderToWriter(transfer, stdin, c.conn)
+		c.Log(logger.LevelDebug, "command: %#v, copy from client to stdin ended, written: %v, "+
+			"initial remaining quota: %v, err: %v", c.GetCommand(), w, remainingQuotaSize, e)
+		if e != nil {
+			once.Do(closeCmdOnError)
+		}
+	}()
+
+	go func() {
+		transfer := common.NewBaseTransfer(nil, c.BaseConnection, nil, command.fsPath, command.virtualPath,
+			common.TransferDownload, 0, 0, 0, false, command.fs)
+
+		w, e := copyFromReaderToWriter(transfer, c.conn, stdout)
+		c.Log(logger.LevelDebug, "command: %#v, copy from stdout to client ended, written: %v err: %v",
+			c.GetCommand(), w, e)
+		if e != nil {
+			once.Do(closeCmdOnError)
+		}
+		commandResponse <- true
+	}()
+
+	go func() {
+		scanner := bufio.NewScanner(stderr)
+		for scanner.Scan() {
+			c.Log(logger.LevelWarn, "rsync stderr: %v", scanner.Text())
+		}
+	}()
+
+	<-commandResponse
+	return command.cmd.Wait()
+}
+
+func (c *Connection) exchangeProtocolVersion(stdin io.Writer, stdout io.Reader) error {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(c.protocol))
+	if _, err := stdin.Write(buf); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(stdout, buf)
+	return err
+}
+
+func (c *Connection) getRsyncCommand(args rsyncArgs) (rsyncCommand, error) {
+	var command rsyncCommand
+	if !args.isSender {
+		if c.module.ReadOnly {
+			return command, errReadOnlyModule
+		}
+		if len(args.paths) != 1 {
+			return command, errInvalidArgs
+		}
+	}
+	var fsPaths []string
+	for idx, p := range args.paths {
+		virtualPath, err := c.getVirtualPath(p)
+		if err != nil {
+			return command, err
+		}
+		fs, fsPath, err := c.resolvePath(virtualPath)
+		if err != nil {
+			return command, err
+		}
+		cleanPath := utils.CleanPath(virtualPath)
+		if err := c.checkPermissions(args, cleanPath); err != nil {
+			return command, err
+		}
+		if idx == 0 {
+			command.fs = fs
+			command.fsPath = fsPath
+			command.virtualPath = cleanPath
+			command.quotaCheckPath = cleanPath
+		}
+		fsPaths = append(fsPaths, fsPath)
+	}
+	if !args.isSender {
+		command.isUpload = true
+		fi, err := command.fs.Stat(command.fsPath)
+		if err == nil && fi.IsDir() {
+			// if the target is an existing dir the command will write inside this dir
+			// so we need to check the quota for this directory and not its parent dir
+			command.quotaCheckPath = path.Join(command.virtualPath, "fakecontent")
+		}
+	}
+	// as for rsync over SSH, if the user has the permission to create symlinks we add
+	// the option --safe-links to avoid symlinks pointing outside the home dir,
+	// otherwise we add --munge-links to make the symlinks unusable
+	var cmdArgs []string
+	if c.User.HasPerm(dataprovider.PermCreateSymlinks, command.virtualPath) {
+		cmdArgs = append(cmdArgs, "--safe-links")
+	} else {
+		cmdArgs = append(cmdArgs, "--munge-links")
+	}
+	cmdArgs = append(cmdArgs, args.options...)
+	cmdArgs = append(cmdArgs, ".")
+	cmdArgs = append(cmdArgs, fsPaths...)
+	c.Log(logger.LevelDebug, "new rsync command, args: %+v fs path %#v quota check path %#v",
+		cmdArgs, command.fsPath, command.quotaCheckPath)
+	cmd := exec.Command(c.rsyncPath, cmdArgs...)
+	command.cmd = wrapCmd(cmd, c.User.GetUID(), c.User.GetGID())
+	return command, nil
+}
+
+func (c *Connection) checkPermissions(args rsyncArgs, virtualPath string) error {
+	var perms []string
+	if args.isSender {
+		perms = []string{dataprovider.PermDownload, dataprovider.PermListItems}
+		for _, opt := range args.options {
+			if opt == "--remove-source-files" || opt == "--remove-sent-files" {
+				perms = append(perms, dataprovider.PermDelete)
+			}
+		}
+	} else {
+		perms = []string{dataprovider.PermDownload, dataprovider.PermUpload, dataprovider.PermCreateDirs,
+			dataprovider.PermListItems, dataprovider.PermOverwrite, dataprovider.PermDelete}
+	}
+	if !c.User.HasPerms(perms, virtualPath) {
+		return c.GetPermissionDeniedError()
+	}
+	return nil
+}
+
+// getVirtualPath maps a path sent by the client, such as "module/dir/file", to
+// the virtual path inside the user home dir. The path cannot be outside the module
+func (c *Connection) getVirtualPath(p string) (string, error) {
+	var rel string
+	switch {
+	case p == c.module.Name:
+	case strings.HasPrefix(p, c.module.Name+"/"):
+		rel = strings.TrimPrefix(p, c.module.Name+"/")
+	default:
+		return "", fmt.Errorf("path %#v is not inside the module %#v", p, c.module.Name)
+	}
+	virtualPath := utils.CleanPath(path.Join(c.module.Path, rel))
+	if virtualPath != c.module.Path && c.module.Path != "/" && !strings.HasPrefix(virtualPath, c.module.Path+"/") {
+		return "", fmt.Errorf("path %#v is not inside the module %#v", p, c.module.Name)
+	}
+	if strings.HasSuffix(p, "/") && !strings.HasSuffix(virtualPath, "/") {
+		virtualPath += "/"
+	}
+	return virtualPath, nil
+}
+
+func (c *Connection) resolvePath(virtualPath string) (vfs.Fs, string, error) {
+	cleanPath := utils.CleanPath(virtualPath)
+	if !c.isLocalPath(cleanPath) {
+		return nil, "", errUnsupportedConfig
+	}
+	if err := c.isPathAllowed(cleanPath); err != nil {
+		return nil, "", err
+	}
+	fs, err := c.User.GetFilesystemForPath(cleanPath, c.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	fsPath, err := fs.ResolvePath(cleanPath)
+	if err != nil {
+		return nil, "", c.GetFsError(fs, err)
+	}
+	if strings.HasSuffix(virtualPath, "/") && !strings.HasSuffix(fsPath, string(os.PathSeparator)) {
+		fsPath += string(os.PathSeparator)
+	}
+	return fs, fsPath, nil
+}
+
+func (c *Connection) isLocalPath(virtualPath string) bool {
+	folder, err := c.User.GetVirtualFolderForPath(virtualPath)
+	if err != nil {
+		return c.User.FsConfig.Provider == vfs.LocalFilesystemProvider
+	}
+	return folder.FsConfig.Provider == vfs.LocalFilesystemProvider
+}
+
+// isPathAllowed returns an error if the given path includes virtual folders or
+// files extensions filters, they cannot be enforced by the rsync process
+func (c *Connection) isPathAllowed(virtualPath string) error {
+	if c.User.IsVirtualFolder(virtualPath) {
+		// overlapped virtual path are not allowed
+		return nil
+	}
+	if c.User.HasVirtualFoldersInside(virtualPath) {
+		c.Log(logger.LevelDebug, "path %#v is not allowed, it has virtual folders inside it, user %#v",
+			virtualPath, c.User.Username)
+		return errUnsupportedConfig
+	}
+	for _, f := range c.User.Filters.FileExtensions {
+		if f.Path == virtualPath || f.Path == "/" || strings.HasPrefix(virtualPath, f.Path+"/") ||
+			virtualPath == "/" || strings.HasPrefix(f.Path, virtualPath+"/") {
+			c.Log(logger.LevelDebug, "path %#v is not allowed, it includes folders with files extensions filters %#v, user %#v",
+				virtualPath, f.Path, c.User.Username)
+			return errUnsupportedConfig
+		}
+	}
+	return nil
+}
+
+func (c *Connection) updateQuota(virtualPath string, filesNum int, filesSize int64) {
+	vfolder, err := c.User.GetVirtualFolderForPath(virtualPath)
+	if err == nil {
+		dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, filesNum, filesSize, false) //nolint:errcheck
+		if vfolder.IsIncludedInUserQuota() {
+			dataprovider.UpdateUserQuota(&c.User, filesNum, filesSize, false) //nolint:errcheck
+		}
+	} else {
+		dataprovider.UpdateUserQuota(&c.User, filesNum, filesSize, false) //nolint:errcheck
+	}
+	if len(c.User.Filters.DirQuotas) > 0 {
+		// the affected directory could contain other directories with quota restrictions
+		dataprovider.ResetUserDirQuotas(c.User.Username)
+	}
+}
+
+func (c *Connection) getSizeForPath(fs vfs.Fs, name string) (int, int64, error) {
+	if dataprovider.GetQuotaTracking() > 0 {
+		fi, err := fs.Lstat(name)
+		if err != nil {
+			if fs.IsNotExist(err) {
+				return 0, 0, nil
+			}
+			c.Log(logger.LevelDebug, "unable to stat %#v error: %v", name, err)
+			return 0, 0, err
+		}
+		if fi.IsDir() {
+			files, size, err := fs.GetDirSize(name)
+			if err != nil {
+				c.Log(logger.LevelDebug, "unable to get size for dir %#v error: %v", name, err)
+			}
+			return files, size, err
+		} else if fi.Mode().IsRegular() {
+			return 1, fi.Size(), nil
+		}
+	}
+	return 0, 0, nil
+}
+
+// parseArgs validates the arguments sent by the client, they must be in the form:
+// --server [--sender] [options] . path [path...]
+func parseArgs(args []string) (rsyncArgs, error) {
+	var result rsyncArgs
+	if len(args) < 3 || args[0] != "--server" {
+		return result, errInvalidArgs
+	}
+	idx := 1
+	for ; idx < len(args); idx++ {
+		arg := args[idx]
+		if arg == "." {
+			break
+		}
+		if arg == "--sender" {
+			result.isSender = true
+		}
+		if !strings.HasPrefix(arg, "-") {
+			return result, fmt.Errorf("%w: unexpected argument %#v", errInvalidArgs, arg)
+		}
+		if err := checkOption(arg); err != nil {
+			return result, err
+		}
+		result.options = append(result.options, arg)
+		if utils.IsStringInSlice(arg, valueLongOptions) && idx+1 < len(args) {
+			idx++
+			result.options = append(result.options, args[idx])
+		}
+	}
+	if idx >= len(args)-1 {
+		return result, fmt.Errorf("%w: no path specified", errInvalidArgs)
+	}
+	result.paths = args[idx+1:]
+	return result, nil
+}
+
+func checkOption(opt string) error {
+	if strings.HasPrefix(opt, "--") {
+		name := strings.TrimPrefix(opt, "--")
+		if idx := strings.Index(name, "="); idx >= 0 {
+			name = name[:idx]
+		}
+		if name == "" {
+			return fmt.Errorf("%w: unexpected argument %#v", errInvalidArgs, opt)
+		}
+		if utils.IsStringInSlice(name, allowedLongOptions) {
+			return nil
+		}
+		for _, denied := range deniedLongOptions {
+			if strings.HasPrefix(denied, name) {
+				return fmt.Errorf("%w: option %#v is not allowed", errInvalidArgs, opt)
+			}
+		}
+		return nil
+	}
+	// short options, in server mode the characters after "e" are the client capabilities
+	for _, ch := range strings.TrimPrefix(opt, "-") {
+		if ch == 'e' {
+			break
+		}
+		if strings.ContainsRune(deniedShortOptions, ch) {
+			return fmt.Errorf("%w: option %#v is not allowed", errInvalidArgs, opt)
+		}
+	}
+	return nil
+}
+
+func copyFromReaderToWriter(t *common.BaseTransfer, dst io.Writer, src io.Reader) (int64, error) {
+	defer t.Connection.RemoveTransfer(t)
+
+	var written int64
+	var err error
+
+	if t.MaxWriteSize < 0 {
+		return 0, common.ErrQuotaExceeded
+	}
+	isDownload := t.GetType() == common.TransferDownload
+	buf := make([]byte, 32768)
+	for {
+		t.Connection.UpdateLastActivity()
+		nr, er := src.Read(buf)
+		if nr > 0 {
+			nw, ew := dst.Write(buf[0:nr])
+			if nw > 0 {
+				written += int64(nw)
+				if isDownload {
+					atomic.StoreInt64(&t.BytesSent, written)
+				} else {
+					atomic.StoreInt64(&t.BytesReceived, written)
+				}
+				if t.MaxWriteSize > 0 && written > t.MaxWriteSize {
+					err = common.ErrQuotaExceeded
+					break
+				}
+			}
+			if ew != nil {
+				err = ew
+				break
+			}
+			if nr != nw {
+				err = io.ErrShortWrite
+				break
+			}
+		}
+		if er != nil {
+			if er != io.EOF {
+				err = er
+			}
+			break
+		}
+		t.HandleThrottle()
+	}
+	t.ErrTransfer = err
+	if written > 0 || err != nil {
+		metrics.TransferCompleted(t.Connection.GetUsername(), atomic.LoadInt64(&t.BytesSent), atomic.LoadInt64(&t.BytesReceived), t.GetType(), t.ErrTransfer)
+		metrics.TransferFinished(t.Connection.GetProtocol(), t.GetType(), written, time.Since(t.GetStartTime()), t.ErrTransfer)
+	}
+	return written, err
+}
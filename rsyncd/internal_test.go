@@ -0,0 +1,289 @@
+package rsyncd
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+)
+
+func TestConfigValidation(t *testing.T) {
+	c := Configuration{}
+	assert.False(t, c.ShouldBind())
+	err := c.Initialize(".")
+	assert.ErrorIs(t, err, common.ErrNoBinding)
+
+	c.Bindings = []Binding{
+		{
+			Port: 8873,
+		},
+	}
+	assert.True(t, c.ShouldBind())
+	assert.Error(t, c.validate())
+	c.RsyncPath = "rsync"
+	assert.Error(t, c.validate())
+	c.Modules = []Module{
+		{
+			Name: "#list",
+		},
+	}
+	assert.Error(t, c.validate())
+	c.Modules[0].Name = "mod"
+	assert.Error(t, c.validate())
+	c.Modules[0].Username = "user"
+	assert.Error(t, c.validate())
+	c.Modules[0].Password = "secret"
+	c.Modules[0].Path = "relative"
+	assert.Error(t, c.validate())
+	c.Modules[0].Path = ""
+	assert.NoError(t, c.validate())
+	assert.Equal(t, "/", c.Modules[0].Path)
+	c.Modules[0].Path = "/dir/../sub/"
+	assert.NoError(t, c.validate())
+	assert.Equal(t, "/sub", c.Modules[0].Path)
+	c.Modules = append(c.Modules, c.Modules[0])
+	err = c.validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "duplicated")
+	}
+	_, ok := c.getModule("mod")
+	assert.True(t, ok)
+	_, ok = c.getModule("missing")
+	assert.False(t, ok)
+
+	redacted := c.getRedactedConfig()
+	assert.Equal(t, "[redacted]", redacted.Modules[0].Password)
+	assert.Equal(t, "secret", c.Modules[0].Password)
+}
+
+func TestParseGreeting(t *testing.T) {
+	version, err := parseGreeting("@RSYNCD: 31.0 sha512 sha256 sha1 md5 md4")
+	assert.NoError(t, err)
+	assert.Equal(t, 31, version)
+	version, err = parseGreeting("@RSYNCD: 30.0")
+	assert.NoError(t, err)
+	assert.Equal(t, 30, version)
+	version, err = parseGreeting("@RSYNCD: 29")
+	assert.NoError(t, err)
+	assert.Equal(t, 29, version)
+	_, err = parseGreeting("RSYNCD: 30.0")
+	assert.Error(t, err)
+	_, err = parseGreeting("@RSYNCD: a.0")
+	assert.Error(t, err)
+	_, err = parseGreeting("@RSYNCD: -1.0")
+	assert.Error(t, err)
+}
+
+func TestAuthResponse(t *testing.T) {
+	assert.Equal(t, "QEiMMf9XE5h9Y4xG3FmQOA", getAuthResponse("secret", "challenge"))
+	assert.True(t, checkAuthResponse("secret", "challenge", "QEiMMf9XE5h9Y4xG3FmQOA"))
+	assert.False(t, checkAuthResponse("secret", "challenge", "QEiMMf9XE5h9Y4xG3FmQOA=="))
+	assert.False(t, checkAuthResponse("wrong", "challenge", "QEiMMf9XE5h9Y4xG3FmQOA"))
+
+	username, response := parseAuthResponse("user QEiMMf9XE5h9Y4xG3FmQOA")
+	assert.Equal(t, "user", username)
+	assert.Equal(t, "QEiMMf9XE5h9Y4xG3FmQOA", response)
+	username, response = parseAuthResponse("user")
+	assert.Empty(t, username)
+	assert.Empty(t, response)
+
+	challenge1, err := generateChallenge()
+	assert.NoError(t, err)
+	challenge2, err := generateChallenge()
+	assert.NoError(t, err)
+	assert.NotEqual(t, challenge1, challenge2)
+}
+
+func TestParseArgs(t *testing.T) {
+	args, err := parseArgs([]string{"--server", "--sender", "-vlogDtpre.iLsfxC", ".", "mod/dir"})
+	assert.NoError(t, err)
+	assert.True(t, args.isSender)
+	assert.Equal(t, []string{"--sender", "-vlogDtpre.iLsfxC"}, args.options)
+	assert.Equal(t, []string{"mod/dir"}, args.paths)
+
+	args, err = parseArgs([]string{"--server", "-vlogDtpre.iLsfxC", "--delete", "--chmod", "D755", ".", "mod/"})
+	assert.NoError(t, err)
+	assert.False(t, args.isSender)
+	assert.Equal(t, []string{"-vlogDtpre.iLsfxC", "--delete", "--chmod", "D755"}, args.options)
+	assert.Equal(t, []string{"mod/"}, args.paths)
+
+	_, err = parseArgs([]string{"--sender", "-vlogDtpre.iLsfxC", ".", "mod/dir"})
+	assert.ErrorIs(t, err, errInvalidArgs)
+	_, err = parseArgs([]string{"--server", "-vlogDtpre.iLsfxC", "mod/dir"})
+	assert.ErrorIs(t, err, errInvalidArgs)
+	_, err = parseArgs([]string{"--server", "-vlogDtpre.iLsfxC", "."})
+	assert.ErrorIs(t, err, errInvalidArgs)
+	_, err = parseArgs([]string{"--server", "--temp-dir", "/tmp", ".", "mod/"})
+	assert.ErrorIs(t, err, errInvalidArgs)
+	_, err = parseArgs([]string{"--server", "-vlogDtpr", "/etc", ".", "mod/"})
+	assert.ErrorIs(t, err, errInvalidArgs)
+}
+
+func TestCheckOption(t *testing.T) {
+	for _, opt := range []string{"--sender", "--delete", "--backup", "--log-format=%i", "--timeout=30",
+		"-vlogDtpre.iLsfxCIvu", "--safe-links", "--compress-level=9", "--remove-source-files"} {
+		assert.NoError(t, checkOption(opt), opt)
+	}
+	for _, opt := range []string{"--daemon", "--config=/etc/rsyncd.conf", "--temp-dir=/tmp", "--temp=/tmp",
+		"--backup-dir=/tmp", "--link-dest=/", "--files-from=/etc/passwd", "--no-munge-links", "--no-safe-links",
+		"--copy-links", "--copy-unsafe-links", "--filter=merge /etc/rules", "--", "--=value", "-L", "-rLe.iLsfxC",
+		"-T/tmp", "-vk"} {
+		assert.ErrorIs(t, checkOption(opt), errInvalidArgs, opt)
+	}
+}
+
+func TestVirtualPath(t *testing.T) {
+	c := Connection{
+		module: Module{
+			Name: "mod",
+			Path: "/",
+		},
+	}
+	p, err := c.getVirtualPath("mod")
+	assert.NoError(t, err)
+	assert.Equal(t, "/", p)
+	p, err = c.getVirtualPath("mod/")
+	assert.NoError(t, err)
+	assert.Equal(t, "/", p)
+	p, err = c.getVirtualPath("mod/dir/sub/")
+	assert.NoError(t, err)
+	assert.Equal(t, "/dir/sub/", p)
+	p, err = c.getVirtualPath("mod/../../etc")
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc", p)
+	_, err = c.getVirtualPath("other/dir")
+	assert.Error(t, err)
+	_, err = c.getVirtualPath("module/dir")
+	assert.Error(t, err)
+
+	c.module.Path = "/backups"
+	p, err = c.getVirtualPath("mod")
+	assert.NoError(t, err)
+	assert.Equal(t, "/backups", p)
+	p, err = c.getVirtualPath("mod/host1/")
+	assert.NoError(t, err)
+	assert.Equal(t, "/backups/host1/", p)
+	_, err = c.getVirtualPath("mod/../other")
+	assert.Error(t, err)
+	_, err = c.getVirtualPath("mod/../backups2")
+	assert.Error(t, err)
+}
+
+func TestPathAllowed(t *testing.T) {
+	c := Connection{
+		BaseConnection: common.NewBaseConnection("", common.ProtocolRsync, dataprovider.User{}),
+	}
+	c.User.Filters.FileExtensions = []dataprovider.ExtensionsFilter{
+		{
+			Path:              "/dir/sub",
+			AllowedExtensions: []string{".jpg"},
+		},
+	}
+	assert.NoError(t, c.isPathAllowed("/other"))
+	assert.NoError(t, c.isPathAllowed("/dir/subdir"))
+	assert.ErrorIs(t, c.isPathAllowed("/"), errUnsupportedConfig)
+	assert.ErrorIs(t, c.isPathAllowed("/dir"), errUnsupportedConfig)
+	assert.ErrorIs(t, c.isPathAllowed("/dir/sub"), errUnsupportedConfig)
+	assert.ErrorIs(t, c.isPathAllowed("/dir/sub/inner"), errUnsupportedConfig)
+}
+
+func TestHandshake(t *testing.T) {
+	s := &Server{
+		config: &Configuration{
+			Modules: []Module{
+				{
+					Name:    "listed",
+					Comment: "listed module",
+					List:    true,
+				},
+				{
+					Name: "hidden",
+				},
+			},
+		},
+	}
+	client, server := net.Pipe()
+	defer client.Close()
+
+	done := make(chan bool)
+	go func() {
+		defer server.Close()
+		h := &handshake{
+			conn:   server,
+			reader: bufio.NewReader(server),
+		}
+		module, err := s.negotiate(h)
+		assert.NoError(t, err)
+		assert.Nil(t, module)
+		done <- true
+	}()
+
+	reader := bufio.NewReader(client)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "@RSYNCD: 30.0\n", line)
+	_, err = client.Write([]byte("@RSYNCD: 31.0 sha512 sha256 sha1 md5 md4\n#list\n"))
+	require.NoError(t, err)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "listed"))
+	assert.Contains(t, line, "listed module")
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "@RSYNCD: EXIT\n", line)
+	<-done
+
+	client, server = net.Pipe()
+	defer client.Close()
+
+	go func() {
+		defer server.Close()
+		h := &handshake{
+			conn:   server,
+			reader: bufio.NewReader(server),
+		}
+		_, err := s.negotiate(h)
+		assert.Error(t, err)
+		done <- true
+	}()
+
+	reader = bufio.NewReader(client)
+	_, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	_, err = client.Write([]byte("@RSYNCD: 29.0\n"))
+	require.NoError(t, err)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "@ERROR: "))
+	<-done
+}
+
+func TestReadArgs(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	h := &handshake{
+		conn:   server,
+		reader: bufio.NewReader(server),
+	}
+	go func() {
+		client.Write([]byte("--server\x00--sender\x00.\x00mod/dir\x00\x00")) //nolint:errcheck
+	}()
+	args, err := h.readArgs()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"--server", "--sender", ".", "mod/dir"}, args)
+
+	go func() {
+		client.Write([]byte(strings.Repeat("a", maxLineLength+1))) //nolint:errcheck
+	}()
+	_, err = h.readArgs()
+	assert.True(t, errors.Is(err, errLineTooLong))
+}
@@ -0,0 +1,205 @@
+// Package rsyncd implements a rsync daemon compatible listener.
+// Clients connect using rsync:// URLs, each configured module maps to an SFTPGo
+// user and to a virtual path inside its home directory. The file transfer is
+// handled by the system rsync executable, as for rsync over SSH.
+package rsyncd
+
+import (
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	logSender = "rsyncd"
+	// we only support the protocol versions, 30 and above, using NUL terminated
+	// arguments and MD5 based authentication, rsync 3.0.0 and later
+	minProtocolVersion = 30
+	protocolVersion    = 30
+)
+
+var (
+	serviceStatus ServiceStatus
+)
+
+// Binding defines the configuration for a network listener
+type Binding struct {
+	// The address to listen on. A blank value means listen on all available network interfaces.
+	Address string `json:"address" mapstructure:"address"`
+	// The port used for serving requests
+	Port int `json:"port" mapstructure:"port"`
+	// Apply the proxy configuration, if any, for this binding
+	ApplyProxyConfig bool `json:"apply_proxy_config" mapstructure:"apply_proxy_config"`
+}
+
+// GetAddress returns the binding address
+func (b *Binding) GetAddress() string {
+	return fmt.Sprintf("%s:%d", b.Address, b.Port)
+}
+
+// IsValid returns true if the binding port is > 0
+func (b *Binding) IsValid() bool {
+	return b.Port > 0
+}
+
+// HasProxy returns true if the proxy protocol is active for this binding
+func (b *Binding) HasProxy() bool {
+	return b.ApplyProxyConfig && common.Config.ProxyProtocol > 0
+}
+
+// Module defines a rsync module. A module exposes a path of an SFTPGo user
+type Module struct {
+	// Module name, clients use it in rsync URLs, for example rsync://host/<name>/
+	Name string `json:"name" mapstructure:"name"`
+	// Optional description displayed in the modules list
+	Comment string `json:"comment" mapstructure:"comment"`
+	// SFTPGo user mapped to this module. The rsync client must authenticate
+	// using this username
+	Username string `json:"username" mapstructure:"username"`
+	// Virtual path, inside the user home dir, exposed by this module.
+	// Empty means the user home dir. It can be a virtual folder too
+	Path string `json:"path" mapstructure:"path"`
+	// Shared secret required to access the module
+	Password string `json:"password" mapstructure:"password"`
+	// Set to true to deny uploads for this module
+	ReadOnly bool `json:"read_only" mapstructure:"read_only"`
+	// Set to true to show this module when the client asks for the modules list
+	List bool `json:"list" mapstructure:"list"`
+}
+
+func (m *Module) validate() error {
+	if m.Name == "" || m.Name == "#list" {
+		return fmt.Errorf("invalid module name %#v", m.Name)
+	}
+	if m.Username == "" {
+		return fmt.Errorf("module %#v: a username is required", m.Name)
+	}
+	if m.Password == "" {
+		return fmt.Errorf("module %#v: a password is required", m.Name)
+	}
+	if m.Path == "" {
+		m.Path = "/"
+	}
+	if !path.IsAbs(m.Path) {
+		return fmt.Errorf("module %#v: invalid path %#v, it must be an absolute virtual path", m.Name, m.Path)
+	}
+	m.Path = utils.CleanPath(m.Path)
+	return nil
+}
+
+// ServiceStatus defines the service status
+type ServiceStatus struct {
+	IsActive bool      `json:"is_active"`
+	Bindings []Binding `json:"bindings"`
+	Modules  []string  `json:"modules"`
+}
+
+// Configuration defines the configuration for the rsync daemon
+type Configuration struct {
+	// Addresses and ports to bind to
+	Bindings []Binding `json:"bindings" mapstructure:"bindings"`
+	// Modules to expose
+	Modules []Module `json:"modules" mapstructure:"modules"`
+	// Path to the rsync executable, it is used for the file transfers
+	RsyncPath string `json:"rsync_path" mapstructure:"rsync_path"`
+}
+
+// GetStatus returns the server status
+func GetStatus() ServiceStatus {
+	return serviceStatus
+}
+
+// ShouldBind returns true if there is at least a valid binding
+func (c *Configuration) ShouldBind() bool {
+	for _, binding := range c.Bindings {
+		if binding.IsValid() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Configuration) validate() error {
+	if c.RsyncPath == "" {
+		return errors.New("the rsync executable path is required")
+	}
+	if len(c.Modules) == 0 {
+		return errors.New("no rsync module defined")
+	}
+	names := make(map[string]bool)
+	for idx := range c.Modules {
+		if err := c.Modules[idx].validate(); err != nil {
+			return err
+		}
+		if names[c.Modules[idx].Name] {
+			return fmt.Errorf("module %#v is duplicated", c.Modules[idx].Name)
+		}
+		names[c.Modules[idx].Name] = true
+	}
+	return nil
+}
+
+func (c *Configuration) getModule(name string) (Module, bool) {
+	for _, m := range c.Modules {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return Module{}, false
+}
+
+// Initialize configures and starts the rsync daemon
+func (c *Configuration) Initialize(configDir string) error {
+	logger.Debug(logSender, "", "initializing rsync daemon with config %+v", c.getRedactedConfig())
+	if !c.ShouldBind() {
+		return common.ErrNoBinding
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+
+	serviceStatus = ServiceStatus{
+		Bindings: nil,
+	}
+	for _, m := range c.Modules {
+		serviceStatus.Modules = append(serviceStatus.Modules, m.Name)
+	}
+
+	exitChannel := make(chan error, 1)
+
+	for _, binding := range c.Bindings {
+		if !binding.IsValid() {
+			continue
+		}
+
+		go func(binding Binding) {
+			server := &Server{
+				config:  c,
+				binding: binding,
+			}
+			exitChannel <- server.listenAndServe()
+		}(binding)
+		serviceStatus.Bindings = append(serviceStatus.Bindings, binding)
+	}
+
+	serviceStatus.IsActive = true
+
+	return <-exitChannel
+}
+
+func (c *Configuration) getRedactedConfig() Configuration {
+	conf := *c
+	conf.Modules = make([]Module, 0, len(c.Modules))
+	for _, m := range c.Modules {
+		if m.Password != "" {
+			m.Password = "[redacted]"
+		}
+		conf.Modules = append(conf.Modules, m)
+	}
+	return conf
+}
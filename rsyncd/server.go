@@ -0,0 +1,435 @@
+package rsyncd
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	handshakeTimeout = 2 * time.Minute
+	greetingPrefix   = "@RSYNCD: "
+	maxLineLength    = 4096
+	maxArgs          = 512
+)
+
+var (
+	errLineTooLong = errors.New("line too long")
+	errTooManyArgs = errors.New("too many arguments")
+)
+
+// Server defines a rsync daemon listener
+type Server struct {
+	config  *Configuration
+	binding Binding
+}
+
+func (s *Server) listenAndServe() error {
+	addr := s.binding.GetAddress()
+	utils.CheckTCP4Port(s.binding.Port)
+	listener, err := utils.Listen("tcp", addr)
+	if err != nil {
+		logger.Warn(logSender, "", "error starting listener on address %v: %v", addr, err)
+		return err
+	}
+	if s.binding.ApplyProxyConfig {
+		proxyListener, err := common.Config.GetProxyListener(listener)
+		if err != nil {
+			logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
+			return err
+		}
+		if proxyListener != nil {
+			listener = proxyListener
+		}
+	}
+	return s.serve(listener)
+}
+
+func (s *Server) serve(listener net.Listener) error {
+	logger.Info(logSender, "", "server listener registered, address: %v", listener.Addr().String())
+	var tempDelay time.Duration // how long to sleep on accept failure
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if tempDelay == 0 {
+					tempDelay = 5 * time.Millisecond
+				} else {
+					tempDelay *= 2
+				}
+				if max := 1 * time.Second; tempDelay > max {
+					tempDelay = max
+				}
+				logger.Warn(logSender, "", "accept error: %v; retrying in %v", err, tempDelay)
+				time.Sleep(tempDelay)
+				continue
+			}
+			logger.Warn(logSender, "", "unrecoverable accept error: %v", err)
+			return err
+		}
+
+		go s.handleConnection(conn)
+	}
+}
+
+func (s *Server) handleConnection(conn net.Conn) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Error(logSender, "", "panic in handleConnection: %#v stack strace: %v", r, string(debug.Stack()))
+		}
+	}()
+	defer conn.Close()
+
+	common.Connections.AddNetworkConnection()
+	defer common.Connections.RemoveNetworkConnection()
+
+	ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	if !canAcceptConnection(ipAddr) {
+		return
+	}
+	// the handshake must be completed within the timeout, the idle timeout
+	// is used once the transfer starts
+	conn.SetDeadline(time.Now().Add(handshakeTimeout)) //nolint:errcheck
+
+	h := &handshake{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}
+	module, err := s.negotiate(h)
+	if err != nil {
+		logger.Debug(logSender, "", "handshake failed for remote address %#v: %v", conn.RemoteAddr().String(), err)
+		return
+	}
+	if module == nil {
+		// modules list sent
+		return
+	}
+	connection, err := s.authenticate(h, module, ipAddr)
+	if err != nil {
+		return
+	}
+	defer common.Connections.Remove(connection.GetID())
+
+	args, err := h.readArgs()
+	if err != nil {
+		connection.Log(logger.LevelDebug, "unable to read the rsync arguments: %v", err)
+		return
+	}
+	conn.SetDeadline(time.Time{}) //nolint:errcheck
+
+	if err := connection.execute(args); err != nil {
+		connection.Log(logger.LevelDebug, "rsync command %#v finished with error: %v", connection.GetCommand(), err)
+	}
+}
+
+// negotiate exchanges the protocol versions and reads the requested module.
+// A nil module is returned if the client asked for the modules list
+func (s *Server) negotiate(h *handshake) (*Module, error) {
+	if err := h.writeLine(fmt.Sprintf("%v%v.0", greetingPrefix, protocolVersion)); err != nil {
+		return nil, err
+	}
+	line, err := h.readLine()
+	if err != nil {
+		return nil, err
+	}
+	clientVersion, err := parseGreeting(line)
+	if err != nil {
+		h.writeError(err.Error())
+		return nil, err
+	}
+	if clientVersion < minProtocolVersion {
+		err = fmt.Errorf("protocol version %v is not supported, rsync 3.0.0 or later is required", clientVersion)
+		h.writeError(err.Error())
+		return nil, err
+	}
+	h.protocol = protocolVersion
+	if clientVersion < h.protocol {
+		h.protocol = clientVersion
+	}
+	moduleName, err := h.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if moduleName == "" || moduleName == "#list" {
+		for _, m := range s.config.Modules {
+			if m.List {
+				if err := h.writeLine(fmt.Sprintf("%-15s\t%s", m.Name, m.Comment)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, h.writeLine(greetingPrefix + "EXIT")
+	}
+	module, ok := s.config.getModule(moduleName)
+	if !ok {
+		err = fmt.Errorf("unknown module %#v", moduleName)
+		h.writeError(fmt.Sprintf("Unknown module '%v'", moduleName))
+		return nil, err
+	}
+	return &module, nil
+}
+
+// authenticate verifies the module shared secret and the SFTPGo user mapped
+// to the module, the returned connection is already registered
+func (s *Server) authenticate(h *handshake, module *Module, ipAddr string) (*Connection, error) {
+	loginMethod := dataprovider.LoginMethodPassword
+	challenge, err := generateChallenge()
+	if err != nil {
+		h.writeError("unable to generate the authentication challenge")
+		return nil, err
+	}
+	if err := h.writeLine(fmt.Sprintf("%vAUTHREQD %v", greetingPrefix, challenge)); err != nil {
+		return nil, err
+	}
+	line, err := h.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if err := common.LimitAuthRate(common.ProtocolRsync, ipAddr); err != nil {
+		h.writeError(fmt.Sprintf("auth failed on module %v", module.Name))
+		return nil, err
+	}
+	username, response := parseAuthResponse(line)
+	user := dataprovider.User{
+		Username: username,
+	}
+	if username != module.Username || !checkAuthResponse(module.Password, challenge, response) {
+		updateLoginMetrics(&user, ipAddr, loginMethod, dataprovider.ErrInvalidCredentials)
+		h.writeError(fmt.Sprintf("auth failed on module %v", module.Name))
+		return nil, dataprovider.ErrInvalidCredentials
+	}
+	user, err = dataprovider.CheckUserBeforeSharedSecretAuth(username, ipAddr, common.ProtocolRsync)
+	if err != nil {
+		user.Username = username
+		updateLoginMetrics(&user, ipAddr, loginMethod, err)
+		h.writeError(fmt.Sprintf("auth failed on module %v", module.Name))
+		return nil, err
+	}
+	connection, err := s.validateUser(user, h, module, loginMethod)
+	updateLoginMetrics(&user, ipAddr, loginMethod, err)
+	if err != nil {
+		h.writeError(fmt.Sprintf("access denied to module %v", module.Name))
+		return nil, err
+	}
+	connection.Log(logger.LevelInfo, "User id: %d, logged in with: %#v, username: %#v, home_dir: %#v, module: %#v remote addr: %#v",
+		user.ID, loginMethod, user.Username, user.HomeDir, module.Name, ipAddr)
+	dataprovider.UpdateLastLogin(&user) //nolint:errcheck
+
+	if err := h.writeLine(greetingPrefix + "OK"); err != nil {
+		common.Connections.Remove(connection.GetID())
+		return nil, err
+	}
+	return connection, nil
+}
+
+func (s *Server) validateUser(user dataprovider.User, h *handshake, module *Module, loginMethod string) (*Connection, error) {
+	connID := xid.New().String()
+	connectionID := fmt.Sprintf("%v_%v", common.ProtocolRsync, connID)
+	if !filepath.IsAbs(user.HomeDir) {
+		logger.Warn(logSender, connectionID, "user %#v has an invalid home dir: %#v. Home dir must be an absolute path, login not allowed",
+			user.Username, user.HomeDir)
+		return nil, fmt.Errorf("cannot login user with invalid home dir: %#v", user.HomeDir)
+	}
+	if utils.IsStringInSlice(common.ProtocolRsync, user.Filters.DeniedProtocols) {
+		logger.Debug(logSender, connectionID, "cannot login user %#v, protocol RSYNC is not allowed", user.Username)
+		return nil, fmt.Errorf("protocol RSYNC is not allowed for user %#v", user.Username)
+	}
+	if !user.IsLoginMethodAllowed(loginMethod, nil) {
+		logger.Debug(logSender, connectionID, "cannot login user %#v, %v login method is not allowed", user.Username, loginMethod)
+		return nil, fmt.Errorf("login method %v is not allowed for user %#v", loginMethod, user.Username)
+	}
+	if user.MaxSessions > 0 {
+		activeSessions := common.Connections.GetActiveSessions(user.Username)
+		if activeSessions >= user.MaxSessions {
+			logger.Debug(logSender, connectionID, "authentication refused for user: %#v, too many open sessions: %v/%v", user.Username,
+				activeSessions, user.MaxSessions)
+			return nil, fmt.Errorf("too many open sessions: %v", activeSessions)
+		}
+	}
+	remoteAddr := h.conn.RemoteAddr().String()
+	if !user.IsLoginFromAddrAllowed(remoteAddr) {
+		logger.Debug(logSender, connectionID, "cannot login user %#v, remote address is not allowed: %v", user.Username, remoteAddr)
+		return nil, fmt.Errorf("login for user %#v is not allowed from this address: %v", user.Username, remoteAddr)
+	}
+	err := user.CheckFsRoot(connectionID)
+	if err != nil {
+		errClose := user.CloseFs()
+		logger.Warn(logSender, connectionID, "unable to check fs root: %v close fs error: %v", err, errClose)
+		return nil, err
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection(connID, common.ProtocolRsync, user),
+		conn:           h.conn,
+		module:         *module,
+		protocol:       h.protocol,
+		rsyncPath:      s.config.RsyncPath,
+	}
+	connection.SetRemoteAddress(remoteAddr)
+	common.Connections.Add(connection)
+	return connection, nil
+}
+
+type handshake struct {
+	conn     net.Conn
+	reader   *bufio.Reader
+	protocol int
+}
+
+func (h *handshake) writeLine(line string) error {
+	_, err := h.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+func (h *handshake) writeError(message string) {
+	h.writeLine("@ERROR: " + message) //nolint:errcheck
+}
+
+func (h *handshake) readLine() (string, error) {
+	line, err := h.readUntil('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+func (h *handshake) readUntil(delim byte) (string, error) {
+	var result []byte
+	for {
+		b, err := h.reader.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		if b == delim {
+			return string(result), nil
+		}
+		if len(result) >= maxLineLength {
+			return "", errLineTooLong
+		}
+		result = append(result, b)
+	}
+}
+
+// readArgs reads the NUL terminated arguments sent by the client, the list
+// ends with an empty argument
+func (h *handshake) readArgs() ([]string, error) {
+	var args []string
+	for {
+		arg, err := h.readUntil(0)
+		if err != nil {
+			return nil, err
+		}
+		if arg == "" {
+			return args, nil
+		}
+		if len(args) >= maxArgs {
+			return nil, errTooManyArgs
+		}
+		args = append(args, arg)
+	}
+}
+
+// parseGreeting returns the protocol version from a greeting line
+// such as "@RSYNCD: 31.0 sha512 sha256 sha1 md5 md4"
+func parseGreeting(line string) (int, error) {
+	if !strings.HasPrefix(line, greetingPrefix) {
+		return 0, fmt.Errorf("invalid greeting %#v", line)
+	}
+	version := strings.TrimPrefix(line, greetingPrefix)
+	if idx := strings.IndexAny(version, ". "); idx >= 0 {
+		version = version[:idx]
+	}
+	result, err := strconv.Atoi(version)
+	if err != nil || result <= 0 {
+		return 0, fmt.Errorf("invalid protocol version in greeting %#v", line)
+	}
+	return result, nil
+}
+
+func parseAuthResponse(line string) (string, string) {
+	idx := strings.Index(line, " ")
+	if idx < 0 {
+		return "", ""
+	}
+	return line[:idx], line[idx+1:]
+}
+
+func generateChallenge() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// getAuthResponse returns the expected response for the given password and
+// challenge: the MD5 digest, base64 encoded without padding, of the password
+// followed by the challenge
+func getAuthResponse(password, challenge string) string {
+	digest := md5.Sum([]byte(password + challenge))
+	return base64.RawStdEncoding.EncodeToString(digest[:])
+}
+
+func checkAuthResponse(password, challenge, response string) bool {
+	expected := getAuthResponse(password, challenge)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(response)) == 1
+}
+
+func canAcceptConnection(ip string) bool {
+	if err := common.Config.ExecutePreConnectHook(ip, common.ProtocolRsync); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolRsync, "", "connection refused by pre connect hook, ip %#v", ip)
+		return false
+	}
+	if common.IsBanned(ip) {
+		logger.Log(logger.LevelDebug, common.ProtocolRsync, "", "connection refused, ip %#v is banned", ip)
+		return false
+	}
+	if !common.Connections.IsNewConnectionAllowed() {
+		logger.Log(logger.LevelDebug, common.ProtocolRsync, "", "connection refused, configured limit reached")
+		return false
+	}
+	if err := common.CheckLoad(); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolRsync, "", "connection refused: %v", err)
+		return false
+	}
+	_, err := common.LimitRate(common.ProtocolRsync, ip)
+	if err != nil {
+		return false
+	}
+	if err := common.Config.ExecutePostConnectHook(ip, common.ProtocolRsync); err != nil {
+		return false
+	}
+	return true
+}
+
+func updateLoginMetrics(user *dataprovider.User, ip, loginMethod string, err error) {
+	metrics.AddLoginAttempt(loginMethod)
+	if err != nil {
+		logger.ConnectionFailedLog(user.Username, ip, loginMethod, common.ProtocolRsync, err.Error())
+		event := common.HostEventLoginFailed
+		if _, ok := err.(*dataprovider.RecordNotFoundError); ok {
+			event = common.HostEventUserNotFound
+		}
+		common.AddDefenderEvent(ip, event)
+	}
+	metrics.AddLoginResult(loginMethod, err)
+	dataprovider.ExecutePostLoginHook(user, loginMethod, ip, common.ProtocolRsync, err)
+}
@@ -0,0 +1,210 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxSearchYears limits the search for the next activation, a schedule such as
+// "0 0 30 2 *" never matches
+const maxSearchYears = 5
+
+type field struct {
+	name     string
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{name: "minute", min: 0, max: 59}
+	hourField   = field{name: "hour", min: 0, max: 23}
+	domField    = field{name: "day of month", min: 1, max: 31}
+	monthField  = field{name: "month", min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// both 0 and 7 are Sunday
+	dowField = field{name: "day of week", min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Schedule defines a parsed cron expression
+type Schedule struct {
+	spec    string
+	minute  uint64
+	hour    uint64
+	dom     uint64
+	month   uint64
+	dow     uint64
+	domStar bool
+	dowStar bool
+}
+
+// Parse parses a cron expression with five fields: minute, hour, day of month,
+// month and day of week. Each field can be "*", a value, a range such as "1-5",
+// a list such as "1,15" and a step such as "*/15" or "0-30/10". Months and days
+// of week can also be specified using the first three letters of their names.
+// The descriptors @yearly, @annually, @monthly, @weekly, @daily, @midnight and
+// @hourly are supported too. As for the standard cron, if both the day of month
+// and the day of week are restricted, a day matching either field matches
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	expr := spec
+	if strings.HasPrefix(expr, "@") {
+		e, ok := descriptors[strings.ToLower(expr)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported descriptor %#v", spec)
+		}
+		expr = e
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %#v: 5 fields expected, got %v", spec, len(fields))
+	}
+	s := &Schedule{
+		spec:    spec,
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+	var err error
+	for idx, dst := range []struct {
+		bits *uint64
+		f    field
+	}{
+		{&s.minute, minuteField},
+		{&s.hour, hourField},
+		{&s.dom, domField},
+		{&s.month, monthField},
+		{&s.dow, dowField},
+	} {
+		*dst.bits, err = parseField(fields[idx], dst.f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %#v: %v", spec, err)
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	return s, nil
+}
+
+func parseField(value string, f field) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(value, ",") {
+		rangeValue := item
+		step := 1
+		if idx := strings.Index(item, "/"); idx >= 0 {
+			var err error
+			step, err = strconv.Atoi(item[idx+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %v field %#v", f.name, item)
+			}
+			rangeValue = item[:idx]
+		}
+		start, end := f.min, f.max
+		switch {
+		case rangeValue == "*":
+		case strings.Contains(rangeValue, "-"):
+			parts := strings.SplitN(rangeValue, "-", 2)
+			var err error
+			start, err = f.parseValue(parts[0])
+			if err != nil {
+				return 0, err
+			}
+			end, err = f.parseValue(parts[1])
+			if err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range in %v field %#v", f.name, item)
+			}
+		default:
+			var err error
+			start, err = f.parseValue(rangeValue)
+			if err != nil {
+				return 0, err
+			}
+			// "5/10" means from 5 to the maximum every 10
+			if step == 1 {
+				end = start
+			}
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (f *field) parseValue(value string) (int, error) {
+	if v, ok := f.names[strings.ToLower(value)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("invalid value %#v for %v field, allowed range: %v-%v", value, f.name, f.min, f.max)
+	}
+	return v, nil
+}
+
+// String returns the cron expression
+func (s *Schedule) String() string {
+	return s.spec
+}
+
+// Next returns the next activation time after the given time, using its location.
+// A zero time is returned if the schedule never matches
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	// all the current time zones have whole minute offsets
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(maxSearchYears, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.isDayMatching(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			next := time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			if !next.After(t) {
+				// daylight saving time transition
+				next = t.Truncate(time.Hour).Add(time.Hour)
+			}
+			t = next
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) isDayMatching(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,233 @@
+// Package scheduler implements a scheduler for periodic jobs defined using
+// cron expressions. A job is never executed concurrently with itself, if a
+// job is still running when it is scheduled again the new execution is skipped
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrJobNotFound defines the error returned for unknown jobs
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobRunning defines the error returned if a job is already running
+	ErrJobRunning = errors.New("the job is already running")
+)
+
+// Job defines a job to schedule
+type Job struct {
+	// Unique job name
+	Name string
+	// Cron expression, see Parse for the supported syntax
+	Spec string
+	// Function to execute
+	Run func() error
+}
+
+// JobStatus defines the status of a scheduled job
+type JobStatus struct {
+	Name     string `json:"name"`
+	Schedule string `json:"schedule"`
+	// true if the job is currently running
+	IsRunning bool `json:"is_running"`
+	// next scheduled execution as unix timestamp in milliseconds, 0 means never
+	NextRun int64 `json:"next_run"`
+	// last execution start time as unix timestamp in milliseconds, 0 means never
+	LastRun int64 `json:"last_run"`
+	// last execution duration in milliseconds
+	LastDuration int64 `json:"last_duration"`
+	// error returned by the last execution, if any
+	LastError string `json:"last_error,omitempty"`
+	// number of completed executions and number of failed ones
+	Runs     int64 `json:"runs"`
+	Failures int64 `json:"failures"`
+	// number of scheduled executions skipped because the job was still running
+	Skipped int64 `json:"skipped"`
+}
+
+type job struct {
+	Job
+	schedule *Schedule
+	status   JobStatus
+	next     time.Time
+}
+
+// Scheduler executes the configured jobs according to their schedules
+type Scheduler struct {
+	sync.RWMutex
+	jobs []*job
+	done chan bool
+	wg   sync.WaitGroup
+}
+
+// New returns a scheduler for the given jobs, it must be started using Start
+func New(jobs []Job) (*Scheduler, error) {
+	s := &Scheduler{}
+	names := make(map[string]bool)
+	for _, j := range jobs {
+		if j.Name == "" {
+			return nil, errors.New("the job name is mandatory")
+		}
+		if names[j.Name] {
+			return nil, fmt.Errorf("duplicated job name %#v", j.Name)
+		}
+		if j.Run == nil {
+			return nil, fmt.Errorf("job %#v: nothing to run", j.Name)
+		}
+		schedule, err := Parse(j.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("job %#v: %v", j.Name, err)
+		}
+		names[j.Name] = true
+		s.jobs = append(s.jobs, &job{
+			Job:      j,
+			schedule: schedule,
+			status: JobStatus{
+				Name:     j.Name,
+				Schedule: schedule.String(),
+			},
+		})
+	}
+	return s, nil
+}
+
+// Start starts the scheduler in background
+func (s *Scheduler) Start() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.done != nil {
+		return
+	}
+	s.done = make(chan bool)
+	s.setNextRuns(time.Now())
+	go s.loop(s.done)
+}
+
+// Stop stops the scheduler, the running jobs are not interrupted
+func (s *Scheduler) Stop() {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.done != nil {
+		close(s.done)
+		s.done = nil
+	}
+}
+
+// Wait waits for the running jobs to complete
+func (s *Scheduler) Wait() {
+	s.wg.Wait()
+}
+
+// GetStatus returns the status of the scheduled jobs
+func (s *Scheduler) GetStatus() []JobStatus {
+	s.RLock()
+	defer s.RUnlock()
+
+	result := make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		status := j.status
+		if !j.next.IsZero() {
+			status.NextRun = j.next.UnixNano() / int64(time.Millisecond)
+		}
+		result = append(result, status)
+	}
+	return result
+}
+
+// RunJob starts the job with the given name now, without waiting for its completion
+func (s *Scheduler) RunJob(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, j := range s.jobs {
+		if j.Name == name {
+			if j.status.IsRunning {
+				return ErrJobRunning
+			}
+			s.startJob(j)
+			return nil
+		}
+	}
+	return ErrJobNotFound
+}
+
+func (s *Scheduler) loop(done chan bool) {
+	for {
+		s.RLock()
+		var next time.Time
+		for _, j := range s.jobs {
+			if !j.next.IsZero() && (next.IsZero() || j.next.Before(next)) {
+				next = j.next
+			}
+		}
+		s.RUnlock()
+
+		if next.IsZero() {
+			<-done
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-done:
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			s.tick(now)
+		}
+	}
+}
+
+// tick starts the jobs scheduled at or before the given time
+func (s *Scheduler) tick(now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+
+	for _, j := range s.jobs {
+		if j.next.IsZero() || j.next.After(now) {
+			continue
+		}
+		if j.status.IsRunning {
+			j.status.Skipped++
+		} else {
+			s.startJob(j)
+		}
+		j.next = j.schedule.Next(now)
+	}
+}
+
+func (s *Scheduler) setNextRuns(now time.Time) {
+	for _, j := range s.jobs {
+		j.next = j.schedule.Next(now)
+	}
+}
+
+// startJob must be called with the lock held
+func (s *Scheduler) startJob(j *job) {
+	startTime := time.Now()
+	j.status.IsRunning = true
+	j.status.LastRun = startTime.UnixNano() / int64(time.Millisecond)
+	s.wg.Add(1)
+
+	go func() {
+		defer s.wg.Done()
+
+		err := j.Run()
+
+		s.Lock()
+		defer s.Unlock()
+
+		j.status.IsRunning = false
+		j.status.LastDuration = time.Since(startTime).Milliseconds()
+		j.status.Runs++
+		j.status.LastError = ""
+		if err != nil {
+			j.status.Failures++
+			j.status.LastError = err.Error()
+		}
+	}()
+}
@@ -0,0 +1,166 @@
+package scheduler
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"* * * foo *",
+		"@every 5m",
+	} {
+		_, err := Parse(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestNext(t *testing.T) {
+	from := time.Date(2021, 5, 20, 10, 11, 12, 0, time.UTC)
+	for _, tc := range []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2021, 5, 20, 10, 12, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2021, 5, 20, 10, 15, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2021, 5, 21, 2, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * mon-fri", time.Date(2021, 5, 20, 13, 0, 0, 0, time.UTC)},
+		{"0 0 * * sun", time.Date(2021, 5, 23, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2021, 5, 23, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)},
+		// day of month or day of week
+		{"0 0 1 * 6", time.Date(2021, 5, 22, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 feb *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2021, 5, 20, 10, 25, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2021, 5, 20, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2021, 5, 21, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2021, 5, 23, 0, 0, 0, 0, time.UTC)},
+		{"@monthly", time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"@yearly", time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		s, err := Parse(tc.spec)
+		require.NoError(t, err, tc.spec)
+		assert.Equal(t, tc.next, s.Next(from), tc.spec)
+	}
+
+	s, err := Parse("0 0 30 2 *")
+	require.NoError(t, err)
+	assert.True(t, s.Next(from).IsZero())
+}
+
+func TestNextDST(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Skip("timezone data not available")
+	}
+	s, err := Parse("30 2 * * *")
+	require.NoError(t, err)
+	// 2:30 does not exist on 2021-03-28
+	next := s.Next(time.Date(2021, 3, 28, 0, 0, 0, 0, loc))
+	assert.Equal(t, time.Date(2021, 3, 29, 2, 30, 0, 0, loc), next)
+	s, err = Parse("0 * * * *")
+	require.NoError(t, err)
+	from := time.Date(2021, 10, 31, 1, 30, 0, 0, loc)
+	next = s.Next(from)
+	assert.Equal(t, time.Hour-30*time.Minute, next.Sub(from))
+	next = s.Next(next)
+	assert.Equal(t, 2*time.Hour-30*time.Minute, next.Sub(from))
+}
+
+func TestNewErrors(t *testing.T) {
+	run := func() error { return nil }
+	_, err := New([]Job{{Spec: "* * * * *", Run: run}})
+	assert.Error(t, err)
+	_, err = New([]Job{{Name: "job", Spec: "* * * * *"}})
+	assert.Error(t, err)
+	_, err = New([]Job{{Name: "job", Spec: "* * *", Run: run}})
+	assert.Error(t, err)
+	_, err = New([]Job{{Name: "job", Spec: "* * * * *", Run: run}, {Name: "job", Spec: "@daily", Run: run}})
+	assert.Error(t, err)
+}
+
+func TestScheduler(t *testing.T) {
+	release := make(chan bool)
+	started := make(chan bool, 10)
+	errJob := errors.New("job error")
+	s, err := New([]Job{
+		{
+			Name: "slow",
+			Spec: "* * * * *",
+			Run: func() error {
+				started <- true
+				<-release
+				return errJob
+			},
+		},
+		{
+			Name: "never",
+			Spec: "0 0 30 2 *",
+			Run: func() error {
+				return nil
+			},
+		},
+	})
+	require.NoError(t, err)
+	s.Start()
+	s.Start()
+	defer s.Stop()
+
+	status := s.GetStatus()
+	require.Len(t, status, 2)
+	assert.Equal(t, "slow", status[0].Name)
+	assert.Equal(t, "* * * * *", status[0].Schedule)
+	assert.Greater(t, status[0].NextRun, time.Now().UnixNano()/int64(time.Millisecond))
+	assert.Equal(t, int64(0), status[1].NextRun)
+
+	assert.ErrorIs(t, s.RunJob("unknown"), ErrJobNotFound)
+	err = s.RunJob("slow")
+	assert.NoError(t, err)
+	<-started
+	assert.ErrorIs(t, s.RunJob("slow"), ErrJobRunning)
+	// the scheduled execution is skipped while the job is running
+	s.tick(time.Now().Add(time.Minute))
+	status = s.GetStatus()
+	assert.True(t, status[0].IsRunning)
+	assert.Equal(t, int64(1), status[0].Skipped)
+	assert.Greater(t, status[0].LastRun, int64(0))
+
+	release <- true
+	s.Wait()
+	status = s.GetStatus()
+	assert.False(t, status[0].IsRunning)
+	assert.Equal(t, int64(1), status[0].Runs)
+	assert.Equal(t, int64(1), status[0].Failures)
+	assert.Equal(t, errJob.Error(), status[0].LastError)
+
+	s.tick(time.Now().Add(2 * time.Minute))
+	<-started
+	release <- true
+	s.Wait()
+	status = s.GetStatus()
+	assert.Equal(t, int64(2), status[0].Runs)
+	assert.Equal(t, int64(0), status[1].Runs)
+
+	err = s.RunJob("never")
+	assert.NoError(t, err)
+	s.Wait()
+	status = s.GetStatus()
+	assert.Equal(t, int64(1), status[1].Runs)
+	assert.Empty(t, status[1].LastError)
+}
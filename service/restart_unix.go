@@ -0,0 +1,146 @@
+// +build !windows
+
+package service
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/ftpd"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/plugin"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// the new process writes a byte to this file descriptor when it is ready
+const restartReadyFdEnvVar = "SFTPGO_RESTART_READY_FD"
+
+var (
+	restartReadyTimeout = 60 * time.Second
+	// drainTimeout is the maximum time to wait for the active connections to
+	// finish, the remaining connections are closed after this timeout
+	drainTimeout       = 12 * time.Hour
+	drainCheckInterval = 2 * time.Second
+)
+
+// gracefulRestart starts a new process, using the current executable path, that
+// inherits the listeners. Once the new process is ready we stop accepting new
+// connections and we wait, up to drainTimeout, for the active ones to finish
+// before exiting. If the new process cannot be started we continue to serve the requests
+func (s *Service) gracefulRestart() {
+	if !atomic.CompareAndSwapInt32(&s.restarting, 0, 1) {
+		logger.Warn(logSender, "", "a graceful restart is already in progress")
+		return
+	}
+	logger.Info(logSender, "", "Received graceful restart request")
+	if err := startNewProcess(); err != nil {
+		logger.Error(logSender, "", "graceful restart aborted, unable to start the new process: %v", err)
+		atomic.StoreInt32(&s.restarting, 0)
+		return
+	}
+	utils.CloseListeners()
+	ftpd.Stop()
+	if !waitForConnectionsDrain(common.Connections.GetLocalCount, drainTimeout, drainCheckInterval) {
+		count := common.Connections.CloseLocal()
+		logger.Warn(logSender, "", "graceful restart, drain timeout expired, %v active connections closed", count)
+	}
+	plugin.Handler.Cleanup()
+	if err := dataprovider.Close(); err != nil {
+		logger.Warn(logSender, "", "unable to close the data provider: %v", err)
+	}
+	logger.Info(logSender, "", "graceful restart completed, all the connections are closed")
+	s.shutdown()
+}
+
+// waitForConnectionsDrain waits until getCount returns 0, checking it at the
+// specified interval. It returns false if the timeout expires before
+func waitForConnectionsDrain(getCount func() int, timeout, interval time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		count := getCount()
+		if count == 0 {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		logger.Debug(logSender, "", "graceful restart, waiting for %v active connections", count)
+		time.Sleep(interval)
+	}
+}
+
+func startNewProcess() error {
+	executable, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return err
+	}
+	files, err := utils.GetListenerFiles()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%v=%v", utils.InheritedListenersEnvVar, len(files)),
+		fmt.Sprintf("%v=%v", restartReadyFdEnvVar, 3+len(files)))
+	cmd.ExtraFiles = append(files, w)
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return err
+	}
+	go cmd.Wait() //nolint:errcheck
+
+	if err := r.SetReadDeadline(time.Now().Add(restartReadyTimeout)); err != nil {
+		logger.Warn(logSender, "", "unable to set read deadline for the restart pipe: %v", err)
+	}
+	buf := make([]byte, 1)
+	if _, err := r.Read(buf); err != nil {
+		cmd.Process.Kill() //nolint:errcheck
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			return errors.New("timeout waiting for the new process")
+		}
+		return fmt.Errorf("the new process exited: %w", err)
+	}
+	logger.Info(logSender, "", "new process started, pid: %v", cmd.Process.Pid)
+	return nil
+}
+
+// notifyRestartReady notifies the parent process, if any, that we are
+// ready to accept connections on the inherited listeners
+func notifyRestartReady() {
+	val := os.Getenv(restartReadyFdEnvVar)
+	if val == "" {
+		return
+	}
+	os.Unsetenv(restartReadyFdEnvVar)
+	fd, err := strconv.Atoi(val)
+	if err != nil {
+		logger.Warn(logSender, "", "invalid restart ready file descriptor %#v: %v", val, err)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "restart-ready")
+	defer f.Close()
+
+	if _, err := f.Write([]byte{1}); err != nil {
+		logger.Warn(logSender, "", "unable to notify the parent process: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+// +build !windows
+
+package service
+
+import (
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/drakkan/sftpgo/utils"
+)
+
+const (
+	// the test executable is started as new process after a graceful restart,
+	// this environment variable defines how it should behave
+	restartTestModeEnvVar    = "SFTPGO_TEST_RESTART_MODE"
+	restartTestAddressEnvVar = "SFTPGO_TEST_RESTART_ADDRESS"
+	restartTestGreeting      = "new process"
+)
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(restartTestModeEnvVar) {
+	case "ready":
+		runRestartTestProcess()
+	case "exit":
+		os.Exit(1)
+	case "hang":
+		time.Sleep(time.Minute)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// runRestartTestProcess emulates a new process after a graceful restart: it
+// serves a connection using the inherited listener
+func runRestartTestProcess() {
+	l, err := utils.Listen("tcp", os.Getenv(restartTestAddressEnvVar))
+	if err != nil {
+		os.Exit(2)
+	}
+	notifyRestartReady()
+	conn, err := l.Accept()
+	if err != nil {
+		os.Exit(3)
+	}
+	conn.Write([]byte(restartTestGreeting)) //nolint:errcheck
+	conn.Close()
+	os.Exit(0)
+}
+
+func setTestEnv(t *testing.T, key, value string) {
+	oldValue, ok := os.LookupEnv(key)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, oldValue)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+	os.Setenv(key, value)
+}
+
+func TestGracefulRestartListenersHandoff(t *testing.T) {
+	l, err := utils.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(utils.CloseListeners)
+	address := l.Addr().String()
+
+	setTestEnv(t, restartTestModeEnvVar, "ready")
+	setTestEnv(t, restartTestAddressEnvVar, address)
+	// the new process cannot bind the address we are listening on, so it must
+	// use the inherited listener to notify that it is ready
+	err = startNewProcess()
+	require.NoError(t, err)
+	// we stop accepting connections, the new process will handle them
+	utils.CloseListeners()
+	_, err = l.Accept()
+	assert.Error(t, err)
+
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, err)
+	data, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, restartTestGreeting, string(data))
+}
+
+func TestGracefulRestartNewProcessErrors(t *testing.T) {
+	_, err := utils.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(utils.CloseListeners)
+
+	setTestEnv(t, restartTestModeEnvVar, "exit")
+	err = startNewProcess()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "the new process exited")
+	}
+
+	oldTimeout := restartReadyTimeout
+	restartReadyTimeout = 500 * time.Millisecond
+	t.Cleanup(func() {
+		restartReadyTimeout = oldTimeout
+	})
+	setTestEnv(t, restartTestModeEnvVar, "hang")
+	err = startNewProcess()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "timeout waiting for the new process")
+	}
+}
+
+func TestNotifyRestartReady(t *testing.T) {
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	// notifyRestartReady closes the file descriptor, so we pass a duplicated one
+	fd, err := syscall.Dup(int(w.Fd()))
+	require.NoError(t, err)
+	err = w.Close()
+	require.NoError(t, err)
+
+	setTestEnv(t, restartReadyFdEnvVar, strconv.Itoa(fd))
+	notifyRestartReady()
+	_, ok := os.LookupEnv(restartReadyFdEnvVar)
+	assert.False(t, ok)
+	buf := make([]byte, 1)
+	_, err = r.Read(buf)
+	assert.NoError(t, err)
+	// the file descriptor is closed after the notification
+	_, err = r.Read(buf)
+	assert.ErrorIs(t, err, io.EOF)
+	// nothing to notify or invalid file descriptor
+	notifyRestartReady()
+	setTestEnv(t, restartReadyFdEnvVar, "invalid")
+	notifyRestartReady()
+}
+
+func TestWaitForConnectionsDrain(t *testing.T) {
+	var count int32 = 3
+	getCount := func() int {
+		return int(atomic.AddInt32(&count, -1) + 1)
+	}
+	assert.True(t, waitForConnectionsDrain(getCount, time.Second, 10*time.Millisecond))
+	assert.Equal(t, int32(-1), atomic.LoadInt32(&count))
+	// the connections never end
+	getCount = func() int {
+		return 1
+	}
+	start := time.Now()
+	assert.False(t, waitForConnectionsDrain(getCount, 200*time.Millisecond, 50*time.Millisecond))
+	assert.GreaterOrEqual(t, int64(time.Since(start)), int64(200*time.Millisecond))
+	// no active connections, the timeout does not matter
+	getCount = func() int {
+		return 0
+	}
+	assert.True(t, waitForConnectionsDrain(getCount, 0, time.Minute))
+}
@@ -0,0 +1,4 @@
+package service
+
+// notifyRestartReady does nothing, graceful restart is not supported on Windows
+func notifyRestartReady() {}
@@ -6,14 +6,21 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 
 	"github.com/rs/zerolog"
 
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/config"
 	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/ftpd"
 	"github.com/drakkan/sftpgo/httpd"
+	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/metrics"
+	"github.com/drakkan/sftpgo/plugin"
+	"github.com/drakkan/sftpgo/tracing"
 	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/version"
 )
@@ -26,7 +33,10 @@ var (
 	chars = []rune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789")
 )
 
-// Service defines the SFTPGo service
+// Service defines the SFTPGo service.
+// It can be used to embed SFTPGo in other Go programs, in this case set the Config
+// field to configure SFTPGo programmatically instead of using configuration files.
+// A single service can run at the same time within the same process
 type Service struct {
 	ConfigDir         string
 	ConfigFile        string
@@ -38,12 +48,28 @@ type Service struct {
 	PortableUser      dataprovider.User
 	LogCompress       bool
 	LogVerbose        bool
+	LogJournalD       bool
+	LogLevels         string
+	LogFormat         string
 	LoadDataClean     bool
 	LoadDataFrom      string
 	LoadDataMode      int
 	LoadDataQuotaScan int
 	Shutdown          chan bool
 	Error             error
+	// Config, if not nil, is used instead of loading the configuration from
+	// ConfigDir and ConfigFile. ConfigDir is still used as base directory for
+	// relative paths. No signal handler is registered for this service
+	Config *config.Configuration
+	// MemoryProvider, if true, forces the in-memory data provider. Users, folders
+	// and admins are not persisted, they can be added using InitialData
+	// or the data provider API
+	MemoryProvider bool
+	// InitialData, if not nil, is restored after initializing the data provider,
+	// using LoadDataMode and LoadDataQuotaScan
+	InitialData *dataprovider.BackupData
+	stopped     int32
+	restarting  int32
 }
 
 func (s *Service) initLogger() {
@@ -51,10 +77,22 @@ func (s *Service) initLogger() {
 	if !s.LogVerbose {
 		logLevel = zerolog.InfoLevel
 	}
+	if s.LogJournalD && logger.IsJournalDAvailable() {
+		logger.InitJournalDLogger(logLevel)
+		return
+	}
 	if !filepath.IsAbs(s.LogFilePath) && utils.IsFileInputValid(s.LogFilePath) {
 		s.LogFilePath = filepath.Join(s.ConfigDir, s.LogFilePath)
 	}
+	formatErr := logger.SetLogFormat(s.LogFormat)
 	logger.InitLogger(s.LogFilePath, s.LogMaxSize, s.LogMaxBackups, s.LogMaxAge, s.LogCompress, logLevel)
+	if formatErr != nil {
+		logger.Warn(logSender, "", "%v, using the default format", formatErr)
+		logger.WarnToConsole("%v, using the default format", formatErr)
+	}
+	if s.LogJournalD {
+		logger.Warn(logSender, "", "journald is not available, logging to %#v", s.LogFilePath)
+	}
 	if s.PortableMode == 1 {
 		logger.EnableConsoleLogger(logLevel)
 		if s.LogFilePath == "" {
@@ -63,14 +101,30 @@ func (s *Service) initLogger() {
 	}
 }
 
+func (s *Service) initLogLevels() {
+	overrides, err := logger.ParseLevelOverrides(s.LogLevels)
+	if err == nil {
+		err = logger.SetLogLevels(logger.LogLevels{
+			Overrides: overrides,
+		})
+	}
+	if err != nil {
+		logger.Warn(logSender, "", "unable to set the log level overrides %#v: %v", s.LogLevels, err)
+		logger.WarnToConsole("unable to set the log level overrides %#v: %v", s.LogLevels, err)
+	}
+}
+
 // Start initializes the service
 func (s *Service) Start() error {
 	s.initLogger()
+	s.initLogLevels()
 	logger.Info(logSender, "", "starting SFTPGo %v, config dir: %v, config file: %v, log max size: %v log max backups: %v "+
 		"log max age: %v log verbose: %v, log compress: %v, load data from: %#v", version.GetAsString(), s.ConfigDir, s.ConfigFile,
 		s.LogMaxSize, s.LogMaxBackups, s.LogMaxAge, s.LogVerbose, s.LogCompress, s.LoadDataFrom)
 	// in portable mode we don't read configuration from file
-	if s.PortableMode != 1 {
+	if s.Config != nil {
+		config.SetConfiguration(*s.Config)
+	} else if s.PortableMode != 1 {
 		err := config.LoadConfig(s.ConfigDir, s.ConfigFile)
 		if err != nil {
 			logger.Error(logSender, "", "error loading configuration: %v", err)
@@ -84,21 +138,61 @@ func (s *Service) Start() error {
 		return errors.New(infoString)
 	}
 
+	if err := logger.InitAuditLogger(config.GetAuditLogConfig(), s.ConfigDir); err != nil {
+		logger.Error(logSender, "", "unable to initialize the audit log: %v", err)
+		logger.ErrorToConsole("unable to initialize the audit log: %v", err)
+		return err
+	}
+	if err := logger.InitAccessLogger(config.GetAccessLogConfig(), s.ConfigDir); err != nil {
+		logger.Error(logSender, "", "unable to initialize the access log: %v", err)
+		logger.ErrorToConsole("unable to initialize the access log: %v", err)
+		return err
+	}
+	if err := logger.InitRemoteLogger(config.GetRemoteLogConfig(), s.ConfigDir); err != nil {
+		logger.Error(logSender, "", "unable to initialize the remote log: %v", err)
+		logger.ErrorToConsole("unable to initialize the remote log: %v", err)
+		return err
+	}
+	if err := logger.SetLogSampling(config.GetLogSamplingConfig()); err != nil {
+		logger.Error(logSender, "", "unable to configure the log sampling: %v", err)
+		logger.ErrorToConsole("unable to configure the log sampling: %v", err)
+		return err
+	}
+	if err := metrics.InitStatsD(config.GetStatsDConfig()); err != nil {
+		logger.Error(logSender, "", "unable to initialize the StatsD exporter: %v", err)
+		logger.ErrorToConsole("unable to initialize the StatsD exporter: %v", err)
+		return err
+	}
+	if err := tracing.Initialize(config.GetTracingConfig()); err != nil {
+		logger.Error(logSender, "", "unable to initialize tracing: %v", err)
+		logger.ErrorToConsole("unable to initialize tracing: %v", err)
+		return err
+	}
+	s.updateBuildInfo()
 	err := common.Initialize(config.GetCommonConfig())
 	if err != nil {
 		logger.Error(logSender, "", "%v", err)
 		logger.ErrorToConsole("%v", err)
-		os.Exit(1)
+		return err
 	}
 	kmsConfig := config.GetKMSConfig()
 	err = kmsConfig.Initialize()
 	if err != nil {
 		logger.Error(logSender, "", "unable to initialize KMS: %v", err)
 		logger.ErrorToConsole("unable to initialize KMS: %v", err)
-		os.Exit(1)
+		return err
+	}
+	if err := plugin.Initialize(config.GetPluginsConfig()); err != nil {
+		logger.Error(logSender, "", "unable to initialize plugins: %v", err)
+		logger.ErrorToConsole("unable to initialize plugins: %v", err)
+		return err
 	}
 
 	providerConf := config.GetProviderConf()
+	if s.MemoryProvider {
+		providerConf.Driver = dataprovider.MemoryDataProviderName
+		providerConf.Name = ""
+	}
 
 	err = dataprovider.Initialize(providerConf, s.ConfigDir, s.PortableMode == 0)
 	if err != nil {
@@ -106,6 +200,11 @@ func (s *Service) Start() error {
 		logger.ErrorToConsole("error initializing data provider: %v", err)
 		return err
 	}
+	// the defender is initialized before the data provider, now we can load
+	// the safe and block list entries stored within the data provider
+	if err := common.ReloadDefender(); err != nil {
+		logger.Warn(logSender, "", "error reloading defender's lists: %v", err)
+	}
 
 	if s.PortableMode == 1 {
 		// create the user for portable mode
@@ -121,6 +220,12 @@ func (s *Service) Start() error {
 		logger.Error(logSender, "", "unable to load initial data: %v", err)
 		logger.ErrorToConsole("unable to load initial data: %v", err)
 	}
+	if s.InitialData != nil {
+		if err := s.restoreDump(s.InitialData); err != nil {
+			logger.Error(logSender, "", "unable to restore the initial data: %v", err)
+			return err
+		}
+	}
 
 	httpConfig := config.GetHTTPConfig()
 	err = httpConfig.Initialize(s.ConfigDir)
@@ -130,8 +235,14 @@ func (s *Service) Start() error {
 		return err
 	}
 
+	if s.canReloadConfig() {
+		httpd.SetConfigReloader(s.reloadConfig)
+	}
+	httpd.SetConfigDumper(config.GetRedactedConfig)
+
 	s.startServices()
 	go common.Config.ExecuteStartupHook() //nolint:errcheck
+	notifyRestartReady()
 
 	return nil
 }
@@ -141,17 +252,18 @@ func (s *Service) startServices() {
 	ftpdConf := config.GetFTPDConfig()
 	httpdConf := config.GetHTTPDConfig()
 	webDavDConf := config.GetWebDAVDConfig()
+	rsyncDConf := config.GetRsyncDConfig()
 	telemetryConf := config.GetTelemetryConfig()
 
 	if sftpdConf.ShouldBind() {
 		go func() {
 			logger.Debug(logSender, "", "initializing SFTP server with config %+v", sftpdConf)
-			if err := sftpdConf.Initialize(s.ConfigDir); err != nil {
+			if err := sftpdConf.Initialize(s.ConfigDir); err != nil && !s.isRestarting() {
 				logger.Error(logSender, "", "could not start SFTP server: %v", err)
 				logger.ErrorToConsole("could not start SFTP server: %v", err)
 				s.Error = err
 			}
-			s.Shutdown <- true
+			s.serverExited()
 		}()
 	} else {
 		logger.Debug(logSender, "", "SFTP server not started, disabled in config file")
@@ -159,12 +271,12 @@ func (s *Service) startServices() {
 
 	if httpdConf.ShouldBind() {
 		go func() {
-			if err := httpdConf.Initialize(s.ConfigDir); err != nil {
+			if err := httpdConf.Initialize(s.ConfigDir); err != nil && !s.isRestarting() {
 				logger.Error(logSender, "", "could not start HTTP server: %v", err)
 				logger.ErrorToConsole("could not start HTTP server: %v", err)
 				s.Error = err
 			}
-			s.Shutdown <- true
+			s.serverExited()
 		}()
 	} else {
 		logger.Debug(logSender, "", "HTTP server not started, disabled in config file")
@@ -174,36 +286,48 @@ func (s *Service) startServices() {
 	}
 	if ftpdConf.ShouldBind() {
 		go func() {
-			if err := ftpdConf.Initialize(s.ConfigDir); err != nil {
+			if err := ftpdConf.Initialize(s.ConfigDir); err != nil && !s.isRestarting() {
 				logger.Error(logSender, "", "could not start FTP server: %v", err)
 				logger.ErrorToConsole("could not start FTP server: %v", err)
 				s.Error = err
 			}
-			s.Shutdown <- true
+			s.serverExited()
 		}()
 	} else {
 		logger.Debug(logSender, "", "FTP server not started, disabled in config file")
 	}
 	if webDavDConf.ShouldBind() {
 		go func() {
-			if err := webDavDConf.Initialize(s.ConfigDir); err != nil {
+			if err := webDavDConf.Initialize(s.ConfigDir); err != nil && !s.isRestarting() {
 				logger.Error(logSender, "", "could not start WebDAV server: %v", err)
 				logger.ErrorToConsole("could not start WebDAV server: %v", err)
 				s.Error = err
 			}
-			s.Shutdown <- true
+			s.serverExited()
 		}()
 	} else {
 		logger.Debug(logSender, "", "WebDAV server not started, disabled in config file")
 	}
+	if rsyncDConf.ShouldBind() {
+		go func() {
+			if err := rsyncDConf.Initialize(s.ConfigDir); err != nil && !s.isRestarting() {
+				logger.Error(logSender, "", "could not start rsync daemon: %v", err)
+				logger.ErrorToConsole("could not start rsync daemon: %v", err)
+				s.Error = err
+			}
+			s.serverExited()
+		}()
+	} else {
+		logger.Debug(logSender, "", "rsync daemon not started, disabled in config file")
+	}
 	if telemetryConf.ShouldBind() {
 		go func() {
-			if err := telemetryConf.Initialize(s.ConfigDir); err != nil {
+			if err := telemetryConf.Initialize(s.ConfigDir); err != nil && !s.isRestarting() {
 				logger.Error(logSender, "", "could not start telemetry server: %v", err)
 				logger.ErrorToConsole("could not start telemetry server: %v", err)
 				s.Error = err
 			}
-			s.Shutdown <- true
+			s.serverExited()
 		}()
 	} else {
 		logger.Debug(logSender, "", "telemetry server not started, disabled in config file")
@@ -215,16 +339,101 @@ func (s *Service) startServices() {
 
 // Wait blocks until the service exits
 func (s *Service) Wait() {
-	if s.PortableMode != 1 {
-		registerSignals()
+	if s.PortableMode != 1 && s.Config == nil {
+		registerSignals(s)
 	}
 	<-s.Shutdown
 }
 
-// Stop terminates the service unblocking the Wait method
+// Stop terminates the service unblocking the Wait method.
+// The servers stop accepting new connections and the active
+// connections are closed
 func (s *Service) Stop() {
-	close(s.Shutdown)
+	utils.CloseListeners()
+	ftpd.Stop()
+	for _, stat := range common.Connections.GetStats() {
+		common.Connections.Close(stat.ConnectionID)
+	}
+	plugin.Handler.Cleanup()
+	if err := dataprovider.Close(); err != nil {
+		logger.Warn(logSender, "", "unable to close the data provider: %v", err)
+	}
+	s.shutdown()
+	metrics.StopStatsD()
+	tracing.Shutdown()
 	logger.Debug(logSender, "", "Service stopped")
+	logger.CloseRemoteLogger()
+}
+
+// serverExited is called when a server exits. The service is stopped unless a
+// graceful restart is in progress, in this case the servers exit because their
+// listeners were handed over to the new process
+func (s *Service) serverExited() {
+	if !s.isRestarting() {
+		s.shutdown()
+	}
+}
+
+func (s *Service) isRestarting() bool {
+	return atomic.LoadInt32(&s.restarting) == 1
+}
+
+// shutdown unblocks the Wait method, it is safe to call it more than once
+func (s *Service) shutdown() {
+	if atomic.CompareAndSwapInt32(&s.stopped, 0, 1) {
+		close(s.Shutdown)
+	}
+}
+
+// canReloadConfig returns true if the configuration was loaded from the
+// configuration file and so it can be reloaded at runtime
+func (s *Service) canReloadConfig() bool {
+	return s.Config == nil && s.PortableMode != 1
+}
+
+// reloadConfig loads the configuration file again and applies the settings that
+// can be changed without restarting the services. The changed settings that
+// require a restart are returned and logged
+func (s *Service) reloadConfig() ([]string, error) {
+	if !s.canReloadConfig() {
+		return nil, errors.New("configuration reload is not supported for this service")
+	}
+	changed, err := config.Reload(s.ConfigDir, s.ConfigFile)
+	if err != nil {
+		logger.Warn(logSender, "", "error reloading configuration: %v", err)
+		return nil, err
+	}
+	if err := common.ReloadConfig(config.GetCommonConfig()); err != nil {
+		logger.Warn(logSender, "", "error applying the reloaded configuration: %v", err)
+		return nil, err
+	}
+	sftpdConf := config.GetSFTPDConfig()
+	sftpdConf.ReloadLoginBanner(s.ConfigDir)
+	ftpdConf := config.GetFTPDConfig()
+	ftpdConf.ReloadBanner(s.ConfigDir)
+	if err := kms.ReloadMasterKey(); err != nil {
+		logger.Warn(logSender, "", "unable to reload the KMS master key, the previous keys are still used: %v", err)
+	}
+	s.updateBuildInfo()
+	if len(changed) > 0 {
+		logger.Warn(logSender, "", "configuration reloaded, the following changed settings require a restart: %v",
+			strings.Join(changed, ", "))
+	} else {
+		logger.Info(logSender, "", "configuration reloaded")
+	}
+	return changed, nil
+}
+
+// updateBuildInfo exports the build details and the fingerprint of the
+// effective configuration
+func (s *Service) updateBuildInfo() {
+	fingerprint, err := config.GetFingerprint()
+	if err != nil {
+		logger.Warn(logSender, "", "unable to compute the configuration fingerprint: %v", err)
+		return
+	}
+	logger.Debug(logSender, "", "configuration fingerprint: %v", fingerprint)
+	metrics.SetBuildInfo(fingerprint)
 }
 
 func (s *Service) loadInitialData() error {
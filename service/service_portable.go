@@ -50,6 +50,9 @@ func (s *Service) StartPortableMode(sftpdPort, ftpPort, webdavPort int, enabledS
 	httpdConf := config.GetHTTPDConfig()
 	httpdConf.Bindings = nil
 	config.SetHTTPDConfig(httpdConf)
+	rsyncdConf := config.GetRsyncDConfig()
+	rsyncdConf.Bindings = nil
+	config.SetRsyncDConfig(rsyncdConf)
 	telemetryConf := config.GetTelemetryConfig()
 	telemetryConf.BindPort = 0
 	config.SetTelemetryConfig(telemetryConf)
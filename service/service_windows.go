@@ -43,8 +43,13 @@ const (
 )
 
 type WindowsService struct {
-	Service       Service
+	Service Service
+	// Name is the instance name, empty for the default instance.
+	// Named instances allow to run multiple services, for example with
+	// different configuration directories, on the same host
+	Name          string
 	isInteractive bool
+	eventLog      *eventlog.Log
 }
 
 func (s Status) String() string {
@@ -68,6 +73,46 @@ func (s Status) String() string {
 	}
 }
 
+// getServiceName returns the Windows service name for this instance
+func (s *WindowsService) getServiceName() string {
+	if s.Name == "" {
+		return serviceName
+	}
+	return serviceName + "-" + s.Name
+}
+
+func (s *WindowsService) getDisplayName() string {
+	if s.Name == "" {
+		return serviceName
+	}
+	return fmt.Sprintf("%v (%v)", serviceName, s.Name)
+}
+
+func (s *WindowsService) validateName() error {
+	for _, r := range s.Name {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+			return fmt.Errorf("invalid instance name %#v, only letters, numbers, \"-\" and \"_\" are allowed", s.Name)
+		}
+	}
+	return nil
+}
+
+// logEvent writes the given message to the Windows event log, if available
+func (s *WindowsService) logEvent(eventType uint32, format string, v ...interface{}) {
+	if s.eventLog == nil {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	switch eventType {
+	case eventlog.Error:
+		s.eventLog.Error(1, msg) //nolint:errcheck
+	case eventlog.Warning:
+		s.eventLog.Warning(1, msg) //nolint:errcheck
+	default:
+		s.eventLog.Info(1, msg) //nolint:errcheck
+	}
+}
+
 func (s *WindowsService) handleExit(wasStopped chan bool) {
 	s.Service.Wait()
 
@@ -81,8 +126,10 @@ func (s *WindowsService) handleExit(wasStopped chan bool) {
 		// The defined recovery action will be executed.
 		logger.Debug(logSender, "", "Service wait ended, error: %v", s.Service.Error)
 		if s.Service.Error == nil {
+			s.logEvent(eventlog.Warning, "service %v exited unexpectedly", s.getServiceName())
 			os.Exit(0)
 		} else {
+			s.logEvent(eventlog.Error, "service %v exited unexpectedly: %v", s.getServiceName(), s.Service.Error)
 			os.Exit(1)
 		}
 	}
@@ -92,8 +139,10 @@ func (s *WindowsService) Execute(args []string, r <-chan svc.ChangeRequest, chan
 	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptParamChange | acceptRotateLog
 	changes <- svc.Status{State: svc.StartPending}
 	if err := s.Service.Start(); err != nil {
+		s.logEvent(eventlog.Error, "unable to start service %v: %v", s.getServiceName(), err)
 		return true, 1
 	}
+	s.logEvent(eventlog.Info, "service %v started", s.getServiceName())
 
 	wasStopped := make(chan bool, 1)
 
@@ -112,9 +161,11 @@ loop:
 			changes <- svc.Status{State: svc.StopPending}
 			wasStopped <- true
 			s.Service.Stop()
+			s.logEvent(eventlog.Info, "service %v stopped", s.getServiceName())
 			break loop
 		case svc.ParamChange:
 			logger.Debug(logSender, "", "Received reload request")
+			s.Service.reloadConfig() //nolint:errcheck // errors are logged
 			err := dataprovider.ReloadConfig()
 			if err != nil {
 				logger.Warn(logSender, "", "error reloading dataprovider configuration: %v", err)
@@ -172,7 +223,11 @@ func (s *WindowsService) RunService() error {
 	if s.isInteractive {
 		return s.Start()
 	}
-	return svc.Run(serviceName, s)
+	if elog, err := eventlog.Open(s.getServiceName()); err == nil {
+		s.eventLog = elog
+		defer elog.Close()
+	}
+	return svc.Run(s.getServiceName(), s)
 }
 
 func (s *WindowsService) Start() error {
@@ -181,7 +236,7 @@ func (s *WindowsService) Start() error {
 		return err
 	}
 	defer m.Disconnect()
-	service, err := m.OpenService(serviceName)
+	service, err := m.OpenService(s.getServiceName())
 	if err != nil {
 		return fmt.Errorf("could not access service: %v", err)
 	}
@@ -199,7 +254,7 @@ func (s *WindowsService) Reload() error {
 		return err
 	}
 	defer m.Disconnect()
-	service, err := m.OpenService(serviceName)
+	service, err := m.OpenService(s.getServiceName())
 	if err != nil {
 		return fmt.Errorf("could not access service: %v", err)
 	}
@@ -217,7 +272,7 @@ func (s *WindowsService) RotateLogFile() error {
 		return err
 	}
 	defer m.Disconnect()
-	service, err := m.OpenService(serviceName)
+	service, err := m.OpenService(s.getServiceName())
 	if err != nil {
 		return fmt.Errorf("could not access service: %v", err)
 	}
@@ -230,6 +285,9 @@ func (s *WindowsService) RotateLogFile() error {
 }
 
 func (s *WindowsService) Install(args ...string) error {
+	if err := s.validateName(); err != nil {
+		return err
+	}
 	exePath, err := s.getExePath()
 	if err != nil {
 		return err
@@ -239,21 +297,21 @@ func (s *WindowsService) Install(args ...string) error {
 		return err
 	}
 	defer m.Disconnect()
-	service, err := m.OpenService(serviceName)
+	service, err := m.OpenService(s.getServiceName())
 	if err == nil {
 		service.Close()
-		return fmt.Errorf("service %s already exists", serviceName)
+		return fmt.Errorf("service %s already exists", s.getServiceName())
 	}
 	config := mgr.Config{
-		DisplayName: serviceName,
+		DisplayName: s.getDisplayName(),
 		Description: serviceDesc,
 		StartType:   mgr.StartAutomatic}
-	service, err = m.CreateService(serviceName, exePath, config, args...)
+	service, err = m.CreateService(s.getServiceName(), exePath, config, args...)
 	if err != nil {
 		return err
 	}
 	defer service.Close()
-	err = eventlog.InstallAsEventCreate(serviceName, eventlog.Error|eventlog.Warning|eventlog.Info)
+	err = eventlog.InstallAsEventCreate(s.getServiceName(), eventlog.Error|eventlog.Warning|eventlog.Info)
 	if err != nil {
 		if !strings.Contains(err.Error(), "exists") {
 			service.Delete()
@@ -279,6 +337,13 @@ func (s *WindowsService) Install(args ...string) error {
 		service.Delete()
 		return fmt.Errorf("unable to set recovery actions: %v", err)
 	}
+	// execute the recovery actions if the service stops with an error too,
+	// for example if it cannot start
+	err = service.SetRecoveryActionsOnNonCrashFailures(true)
+	if err != nil {
+		service.Delete()
+		return fmt.Errorf("unable to enable recovery actions on non crash failures: %v", err)
+	}
 	return nil
 }
 
@@ -288,16 +353,16 @@ func (s *WindowsService) Uninstall() error {
 		return err
 	}
 	defer m.Disconnect()
-	service, err := m.OpenService(serviceName)
+	service, err := m.OpenService(s.getServiceName())
 	if err != nil {
-		return fmt.Errorf("service %s is not installed", serviceName)
+		return fmt.Errorf("service %s is not installed", s.getServiceName())
 	}
 	defer service.Close()
 	err = service.Delete()
 	if err != nil {
 		return err
 	}
-	err = eventlog.Remove(serviceName)
+	err = eventlog.Remove(s.getServiceName())
 	if err != nil {
 		return fmt.Errorf("RemoveEventLogSource() failed: %s", err)
 	}
@@ -310,7 +375,7 @@ func (s *WindowsService) Stop() error {
 		return err
 	}
 	defer m.Disconnect()
-	service, err := m.OpenService(serviceName)
+	service, err := m.OpenService(s.getServiceName())
 	if err != nil {
 		return fmt.Errorf("could not access service: %v", err)
 	}
@@ -339,7 +404,7 @@ func (s *WindowsService) Status() (Status, error) {
 		return StatusUnknown, err
 	}
 	defer m.Disconnect()
-	service, err := m.OpenService(serviceName)
+	service, err := m.OpenService(s.getServiceName())
 	if err != nil {
 		return StatusUnknown, fmt.Errorf("could not access service: %v", err)
 	}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+func TestWindowsServiceName(t *testing.T) {
+	s := WindowsService{}
+	assert.NoError(t, s.validateName())
+	assert.Equal(t, serviceName, s.getServiceName())
+	assert.Equal(t, serviceName, s.getDisplayName())
+
+	s.Name = "instance_1-test"
+	assert.NoError(t, s.validateName())
+	assert.Equal(t, serviceName+"-instance_1-test", s.getServiceName())
+	assert.Equal(t, serviceName+" (instance_1-test)", s.getDisplayName())
+
+	for _, name := range []string{"instance 1", "instance/1", `instance\1`, "istànza"} {
+		s.Name = name
+		err := s.validateName()
+		if assert.Error(t, err, name) {
+			assert.Contains(t, err.Error(), "invalid instance name")
+		}
+		// the name is validated before accessing the service manager
+		err = s.Install("service", "start")
+		if assert.Error(t, err, name) {
+			assert.Contains(t, err.Error(), "invalid instance name")
+		}
+	}
+}
+
+func TestLogEventWithoutEventLog(t *testing.T) {
+	s := WindowsService{
+		Name: "test",
+	}
+	// the event log is not available if the service is not installed, the
+	// events are ignored
+	s.logEvent(eventlog.Info, "service %v started", s.getServiceName())
+	s.logEvent(eventlog.Warning, "service %v exited unexpectedly", s.getServiceName())
+	s.logEvent(eventlog.Error, "service %v exited unexpectedly: %v", s.getServiceName(), "error")
+	assert.Nil(t, s.eventLog)
+}
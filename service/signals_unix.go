@@ -12,20 +12,23 @@ import (
 	"github.com/drakkan/sftpgo/ftpd"
 	"github.com/drakkan/sftpgo/httpd"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/plugin"
 	"github.com/drakkan/sftpgo/telemetry"
 	"github.com/drakkan/sftpgo/webdavd"
 )
 
-func registerSignals() {
+func registerSignals(s *Service) {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1, syscall.SIGUSR2)
 	go func() {
 		for sig := range c {
 			switch sig {
 			case syscall.SIGHUP:
-				handleSIGHUP()
+				handleSIGHUP(s)
 			case syscall.SIGUSR1:
 				handleSIGUSR1()
+			case syscall.SIGUSR2:
+				go s.gracefulRestart()
 			case syscall.SIGINT, syscall.SIGTERM:
 				handleInterrupt()
 			}
@@ -33,8 +36,9 @@ func registerSignals() {
 	}()
 }
 
-func handleSIGHUP() {
+func handleSIGHUP(s *Service) {
 	logger.Debug(logSender, "", "Received reload request")
+	s.reloadConfig() //nolint:errcheck // errors are logged
 	err := dataprovider.ReloadConfig()
 	if err != nil {
 		logger.Warn(logSender, "", "error reloading dataprovider configuration: %v", err)
@@ -71,5 +75,6 @@ func handleSIGUSR1() {
 
 func handleInterrupt() {
 	logger.Debug(logSender, "", "Received interrupt request")
+	plugin.Handler.Cleanup()
 	os.Exit(0)
 }
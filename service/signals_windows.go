@@ -5,14 +5,16 @@ import (
 	"os/signal"
 
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/plugin"
 )
 
-func registerSignals() {
+func registerSignals(s *Service) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		for range c {
 			logger.Debug(logSender, "", "Received interrupt request")
+			plugin.Handler.Cleanup()
 			os.Exit(0)
 		}
 	}()
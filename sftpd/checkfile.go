@@ -0,0 +1,355 @@
+package sftpd
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/dataprovider"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+const (
+	sftpPacketVersion       = 2
+	sftpPacketStatus        = 101
+	sftpPacketExtended      = 200
+	sftpPacketExtendedReply = 201
+	// same limit as pkg/sftp, longer packets are passed through and rejected
+	sftpMaxPacketLength = 256 * 1024
+
+	sftpStatusNoSuchFile       = 2
+	sftpStatusPermissionDenied = 3
+	sftpStatusFailure          = 4
+	sftpStatusBadMessage       = 5
+	sftpStatusOpUnsupported    = 8
+
+	checkFileExtension   = "check-file"
+	checkFileNameRequest = "check-file-name"
+)
+
+var (
+	// checkFileAlgorithms defines the supported hash algorithms, they are
+	// advertised in the SFTP version packet
+	checkFileAlgorithms    = []string{"md5", "sha1", "sha256", "sha384", "sha512"}
+	errCheckFileBadMessage = errors.New("bad message")
+)
+
+// checkFileRequest defines a "check-file-name" extended request as described in
+// draft-ietf-secsh-filexfer-extensions-00, section 3
+type checkFileRequest struct {
+	name        string
+	algorithms  []string
+	startOffset uint64
+	length      uint64
+	blockSize   uint32
+}
+
+// checkFileChannel wraps an SFTP channel and handles the "check-file-name"
+// extended requests, pkg/sftp has no hook for custom extensions.
+// The other packets are passed through unchanged, the packets written by
+// pkg/sftp are buffered until complete so the check-file replies are never
+// interleaved with them
+type checkFileChannel struct {
+	io.ReadWriteCloser
+	connection  *Connection
+	readBuf     []byte
+	writeMu     sync.Mutex
+	writeBuf    []byte
+	versionSent bool
+}
+
+func newCheckFileChannel(channel io.ReadWriteCloser, connection *Connection) *checkFileChannel {
+	return &checkFileChannel{
+		ReadWriteCloser: channel,
+		connection:      connection,
+	}
+}
+
+// Read returns the packets sent by the client, except the check-file requests.
+// A check-file request is handled before reading the next packet
+func (c *checkFileChannel) Read(p []byte) (int, error) {
+	for len(c.readBuf) == 0 {
+		packet, err := c.readPacket()
+		if err != nil {
+			return 0, err
+		}
+		if !c.handlePacket(packet) {
+			c.readBuf = packet
+		}
+	}
+	n := copy(p, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *checkFileChannel) readPacket() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(c.ReadWriteCloser, header); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header)
+	if length > sftpMaxPacketLength {
+		return header, nil
+	}
+	packet := make([]byte, 4+length)
+	copy(packet, header)
+	if _, err := io.ReadFull(c.ReadWriteCloser, packet[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return packet, nil
+}
+
+// handlePacket handles the given packet if it is a check-file request and
+// returns true, otherwise it returns false
+func (c *checkFileChannel) handlePacket(packet []byte) bool {
+	if len(packet) < 9 || packet[4] != sftpPacketExtended {
+		return false
+	}
+	id := binary.BigEndian.Uint32(packet[5:])
+	name, data, ok := unmarshalSFTPString(packet[9:])
+	if !ok || name != checkFileNameRequest {
+		return false
+	}
+	request, err := parseCheckFileRequest(data)
+	if err != nil {
+		c.connection.Log(logger.LevelDebug, "unable to parse check-file request: %v", err)
+		c.writePacket(marshalSFTPStatus(id, err)) //nolint:errcheck
+		return true
+	}
+	algorithm, sum, err := c.connection.handleCheckFile(request)
+	if err != nil {
+		c.writePacket(marshalSFTPStatus(id, err)) //nolint:errcheck
+		return true
+	}
+	c.writePacket(marshalCheckFileReply(id, algorithm, sum)) //nolint:errcheck
+	return true
+}
+
+// Write buffers the data written by pkg/sftp and sends the complete packets.
+// The check-file extension is added to the version packet
+func (c *checkFileChannel) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.writeBuf = append(c.writeBuf, p...)
+	offset := 0
+	for len(c.writeBuf)-offset >= 4 {
+		length := int(binary.BigEndian.Uint32(c.writeBuf[offset:]))
+		if len(c.writeBuf)-offset-4 < length {
+			break
+		}
+		packet := c.writeBuf[offset : offset+4+length]
+		offset += 4 + length
+		if !c.versionSent && length > 0 && packet[4] == sftpPacketVersion {
+			c.versionSent = true
+			packet = addCheckFileExtension(packet)
+		}
+		if _, err := c.ReadWriteCloser.Write(packet); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(c.writeBuf, c.writeBuf[offset:])
+	c.writeBuf = c.writeBuf[:n]
+	return len(p), nil
+}
+
+func (c *checkFileChannel) writePacket(packet []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	_, err := c.ReadWriteCloser.Write(packet)
+	return err
+}
+
+// handleCheckFile returns the algorithm used and the hash for the requested
+// file. Only the hash of the whole file is supported. The stored checksum is
+// returned for SHA-256, if available, otherwise the file is read
+func (c *Connection) handleCheckFile(request checkFileRequest) (string, []byte, error) {
+	c.UpdateLastActivity()
+
+	algorithm := ""
+	for _, algo := range request.algorithms {
+		if utils.IsStringInSlice(algo, checkFileAlgorithms) {
+			algorithm = algo
+			break
+		}
+	}
+	if algorithm == "" || request.startOffset != 0 {
+		c.Log(logger.LevelInfo, "unsupported check-file request, algorithms: %v, start offset: %v",
+			request.algorithms, request.startOffset)
+		return "", nil, c.GetOpUnsupportedError()
+	}
+	virtualPath := utils.CleanPath(request.name)
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
+		return "", nil, c.GetPermissionDeniedError()
+	}
+	if !c.User.IsFileAllowed(virtualPath) {
+		c.Log(logger.LevelInfo, "check-file not allowed for file %#v", virtualPath)
+		return "", nil, c.GetPermissionDeniedError()
+	}
+	info, err := c.DoStat(virtualPath, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	if info.IsDir() {
+		return "", nil, c.GetGenericError(nil)
+	}
+	size := uint64(info.Size())
+	if (request.length != 0 && request.length != size) || (request.blockSize != 0 && uint64(request.blockSize) < size) {
+		c.Log(logger.LevelInfo, "unsupported check-file request for %#v, size: %v, length: %v, block size: %v",
+			virtualPath, size, request.length, request.blockSize)
+		return "", nil, c.GetOpUnsupportedError()
+	}
+	fs, fsPath, err := c.GetFsAndResolvedPath(virtualPath)
+	if err != nil {
+		return "", nil, err
+	}
+	checksum := ""
+	if algorithm == "sha256" {
+		checksum = c.getStoredChecksum(fs, fsPath)
+	}
+	if checksum == "" {
+		checksum, err = computeHashForFile(fs, getHashForAlgorithm(algorithm), fsPath)
+		if err != nil {
+			return "", nil, c.GetFsError(fs, err)
+		}
+	}
+	sum, err := hex.DecodeString(checksum)
+	if err != nil {
+		return "", nil, c.GetGenericError(err)
+	}
+	c.Log(logger.LevelDebug, "check-file for %#v, algorithm: %v, hash: %v", virtualPath, algorithm, checksum)
+	return algorithm, sum, nil
+}
+
+// getStoredChecksum returns the SHA-256 checksum stored after the upload, if any
+func (c *Connection) getStoredChecksum(fs vfs.Fs, fsPath string) string {
+	if !common.Config.UploadChecksums {
+		return ""
+	}
+	checksumFs, ok := vfs.GetChecksumFs(fs)
+	if !ok {
+		return ""
+	}
+	checksum, err := checksumFs.GetChecksum(fsPath)
+	if err != nil {
+		c.Log(logger.LevelDebug, "unable to get the stored checksum for %#v: %v", fsPath, err)
+		return ""
+	}
+	return checksum
+}
+
+// getHashForAlgorithm returns the hash for the given algorithm, one of the
+// checkFileAlgorithms. SHA-512 is returned for unknown algorithms
+func getHashForAlgorithm(algorithm string) hash.Hash {
+	switch algorithm {
+	case "md5":
+		return md5.New()
+	case "sha1":
+		return sha1.New()
+	case "sha256":
+		return sha256.New()
+	case "sha384":
+		return sha512.New384()
+	default:
+		return sha512.New()
+	}
+}
+
+func parseCheckFileRequest(data []byte) (checkFileRequest, error) {
+	var request checkFileRequest
+	var algorithms string
+	var ok bool
+	if request.name, data, ok = unmarshalSFTPString(data); !ok {
+		return request, errCheckFileBadMessage
+	}
+	if algorithms, data, ok = unmarshalSFTPString(data); !ok {
+		return request, errCheckFileBadMessage
+	}
+	if len(data) < 20 {
+		return request, errCheckFileBadMessage
+	}
+	request.algorithms = strings.Split(algorithms, ",")
+	request.startOffset = binary.BigEndian.Uint64(data)
+	request.length = binary.BigEndian.Uint64(data[8:])
+	request.blockSize = binary.BigEndian.Uint32(data[16:])
+	return request, nil
+}
+
+// addCheckFileExtension adds the check-file extension to the given version packet
+func addCheckFileExtension(packet []byte) []byte {
+	result := append([]byte(nil), packet...)
+	result = appendSFTPString(result, checkFileExtension)
+	result = appendSFTPString(result, strings.Join(checkFileAlgorithms, ","))
+	binary.BigEndian.PutUint32(result, uint32(len(result)-4))
+	return result
+}
+
+func marshalCheckFileReply(id uint32, algorithm string, sum []byte) []byte {
+	packet := make([]byte, 9, 13+len(algorithm)+len(sum))
+	packet[4] = sftpPacketExtendedReply
+	binary.BigEndian.PutUint32(packet[5:], id)
+	packet = appendSFTPString(packet, algorithm)
+	packet = append(packet, sum...)
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	return packet
+}
+
+func marshalSFTPStatus(id uint32, err error) []byte {
+	var code uint32
+	switch {
+	case errors.Is(err, sftp.ErrSSHFxNoSuchFile):
+		code = sftpStatusNoSuchFile
+	case errors.Is(err, sftp.ErrSSHFxPermissionDenied):
+		code = sftpStatusPermissionDenied
+	case errors.Is(err, sftp.ErrSSHFxOpUnsupported):
+		code = sftpStatusOpUnsupported
+	case errors.Is(err, errCheckFileBadMessage):
+		code = sftpStatusBadMessage
+	default:
+		code = sftpStatusFailure
+	}
+	packet := make([]byte, 13)
+	packet[4] = sftpPacketStatus
+	binary.BigEndian.PutUint32(packet[5:], id)
+	binary.BigEndian.PutUint32(packet[9:], code)
+	packet = appendSFTPString(packet, err.Error())
+	packet = appendSFTPString(packet, "")
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	return packet
+}
+
+func appendSFTPString(b []byte, s string) []byte {
+	length := make([]byte, 4)
+	binary.BigEndian.PutUint32(length, uint32(len(s)))
+	b = append(b, length...)
+	return append(b, s...)
+}
+
+func unmarshalSFTPString(b []byte) (string, []byte, bool) {
+	if len(b) < 4 {
+		return "", b, false
+	}
+	length := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(length) {
+		return "", b, false
+	}
+	return string(b[4 : 4+length]), b[4+length:], true
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/dataprovider"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/tracing"
+	"github.com/drakkan/sftpgo/utils"
 	"github.com/drakkan/sftpgo/vfs"
 )
 
@@ -41,9 +43,25 @@ func (c *Connection) GetCommand() string {
 	return c.command
 }
 
+// startRequestSpan starts a tracing span for the given SFTP request
+func (c *Connection) startRequestSpan(request *sftp.Request) *tracing.Span {
+	_, span := tracing.Start(request.Context(), "sftp."+request.Method, tracing.String("username", c.GetUsername()),
+		tracing.String("connection_id", c.GetID()), tracing.String("path", request.Filepath))
+	return span
+}
+
+func endRequestSpan(span *tracing.Span, err error) {
+	if err == sftp.ErrSSHFxOk {
+		err = nil
+	}
+	span.End(err)
+}
+
 // Fileread creates a reader for a file on the system and returns the reader back.
-func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+func (c *Connection) Fileread(request *sftp.Request) (reader io.ReaderAt, err error) {
 	c.UpdateLastActivity()
+	span := c.startRequestSpan(request)
+	defer func() { endRequestSpan(span, err) }()
 
 	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(request.Filepath)) {
 		return nil, sftp.ErrSSHFxPermissionDenied
@@ -54,11 +72,23 @@ func (c *Connection) Fileread(request *sftp.Request) (io.ReaderAt, error) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 
+	if err := c.LimitOperationRate(common.RateLimitOperationOpen); err != nil {
+		return nil, err
+	}
+
+	if err := c.CheckMaxTransfers(); err != nil {
+		return nil, err
+	}
+
 	fs, p, err := c.GetFsAndResolvedPath(request.Filepath)
 	if err != nil {
 		return nil, err
 	}
 
+	if err := c.PreDownloadAction(p, request.Filepath, utils.GetIPFromRemoteAddress(c.GetRemoteAddress())); err != nil {
+		return nil, err
+	}
+
 	file, r, cancelFn, err := fs.Open(p, 0)
 	if err != nil {
 		c.Log(logger.LevelWarn, "could not open file %#v for reading: %+v", p, err)
@@ -82,21 +112,36 @@ func (c *Connection) Filewrite(request *sftp.Request) (io.WriterAt, error) {
 	return c.handleFilewrite(request)
 }
 
-func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReaderAt, error) {
+func (c *Connection) handleFilewrite(request *sftp.Request) (writer sftp.WriterAtReaderAt, err error) {
 	c.UpdateLastActivity()
+	span := c.startRequestSpan(request)
+	defer func() { endRequestSpan(span, err) }()
 
 	if !c.User.IsFileAllowed(request.Filepath) {
 		c.Log(logger.LevelWarn, "writing file %#v is not allowed", request.Filepath)
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 
-	fs, p, err := c.GetFsAndResolvedPath(request.Filepath)
+	if err := c.LimitOperationRate(common.RateLimitOperationOpen); err != nil {
+		return nil, err
+	}
+
+	if err := c.CheckMaxTransfers(); err != nil {
+		return nil, err
+	}
+
+	virtualPath, err := c.PreUploadAction(request.Filepath)
+	if err != nil {
+		return nil, err
+	}
+
+	fs, p, err := c.GetFsAndResolvedPath(virtualPath)
 	if err != nil {
 		return nil, err
 	}
 
 	filePath := p
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if c.IsAtomicUploadEnabled() && fs.Capabilities().AtomicUpload {
 		filePath = fs.GetAtomicUploadPath(p)
 	}
 
@@ -105,7 +150,7 @@ func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReader
 		// read and write mode is only supported for local filesystem
 		errForRead = sftp.ErrSSHFxOpUnsupported
 	}
-	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(request.Filepath)) {
+	if !c.User.HasPerm(dataprovider.PermDownload, path.Dir(virtualPath)) {
 		// we can try to read only for local fs here, see above.
 		// os.ErrPermission will become sftp.ErrSSHFxPermissionDenied when sent to
 		// the client
@@ -114,10 +159,17 @@ func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReader
 
 	stat, statErr := fs.Lstat(p)
 	if (statErr == nil && stat.Mode()&os.ModeSymlink != 0) || fs.IsNotExist(statErr) {
-		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(request.Filepath)) {
+		if !c.User.HasPerm(dataprovider.PermUpload, path.Dir(virtualPath)) {
 			return nil, sftp.ErrSSHFxPermissionDenied
 		}
-		return c.handleSFTPUploadToNewFile(fs, p, filePath, request.Filepath, errForRead)
+		// an interrupted upload to a cloud storage backend is not visible, it
+		// can be resumed appending to the missing file
+		if pflags := request.Pflags(); statErr != nil && pflags.Append && !pflags.Trunc {
+			if size, ok := vfs.GetResumableUploadSize(fs, p); ok {
+				return c.handleSFTPUploadToExistingFile(fs, pflags, p, filePath, size, virtualPath, errForRead)
+			}
+		}
+		return c.handleSFTPUploadToNewFile(fs, p, filePath, virtualPath, errForRead)
 	}
 
 	if statErr != nil {
@@ -131,17 +183,31 @@ func (c *Connection) handleFilewrite(request *sftp.Request) (sftp.WriterAtReader
 		return nil, sftp.ErrSSHFxOpUnsupported
 	}
 
-	if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(request.Filepath)) {
+	if !c.User.HasPerm(dataprovider.PermOverwrite, path.Dir(virtualPath)) {
 		return nil, sftp.ErrSSHFxPermissionDenied
 	}
 
-	return c.handleSFTPUploadToExistingFile(fs, request.Pflags(), p, filePath, stat.Size(), request.Filepath, errForRead)
+	pflags := request.Pflags()
+	if pflags.Trunc || (!pflags.Append && !fs.Capabilities().Truncate) {
+		// the existing content will be replaced, save it as a new version if versioning is enabled
+		isVersioned, err := c.SaveFileVersion(fs, p, virtualPath)
+		if err != nil {
+			return nil, c.GetFsError(fs, err)
+		}
+		if isVersioned {
+			return c.handleSFTPUploadToNewFile(fs, p, filePath, virtualPath, errForRead)
+		}
+	}
+
+	return c.handleSFTPUploadToExistingFile(fs, request.Pflags(), p, filePath, stat.Size(), virtualPath, errForRead)
 }
 
 // Filecmd hander for basic SFTP system calls related to files, but not anything to do with reading
 // or writing to those files.
-func (c *Connection) Filecmd(request *sftp.Request) error {
+func (c *Connection) Filecmd(request *sftp.Request) (err error) {
 	c.UpdateLastActivity()
+	span := c.startRequestSpan(request)
+	defer func() { endRequestSpan(span, err) }()
 
 	c.Log(logger.LevelDebug, "new cmd, method: %v, sourcePath: %#v, targetPath: %#v", request.Method,
 		request.Filepath, request.Target)
@@ -175,16 +241,18 @@ func (c *Connection) Filecmd(request *sftp.Request) error {
 
 // Filelist is the handler for SFTP filesystem list calls. This will handle calls to list the contents of
 // a directory as well as perform file/folder stat calls.
-func (c *Connection) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+func (c *Connection) Filelist(request *sftp.Request) (result sftp.ListerAt, err error) {
 	c.UpdateLastActivity()
+	span := c.startRequestSpan(request)
+	defer func() { endRequestSpan(span, err) }()
 
 	switch request.Method {
 	case "List":
-		files, err := c.ListDir(request.Filepath)
+		lister, err := c.ListDirLister(request.Filepath)
 		if err != nil {
 			return nil, err
 		}
-		return listerAt(files), nil
+		return newDirListerAt(lister), nil
 	case "Stat":
 		if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(request.Filepath)) {
 			return nil, sftp.ErrSSHFxPermissionDenied
@@ -225,8 +293,10 @@ func (c *Connection) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
 }
 
 // Lstat implements LstatFileLister interface
-func (c *Connection) Lstat(request *sftp.Request) (sftp.ListerAt, error) {
+func (c *Connection) Lstat(request *sftp.Request) (result sftp.ListerAt, err error) {
 	c.UpdateLastActivity()
+	span := c.startRequestSpan(request)
+	defer func() { endRequestSpan(span, err) }()
 
 	if !c.User.HasPerm(dataprovider.PermListItems, path.Dir(request.Filepath)) {
 		return nil, sftp.ErrSSHFxPermissionDenied
@@ -242,8 +312,10 @@ func (c *Connection) Lstat(request *sftp.Request) (sftp.ListerAt, error) {
 }
 
 // StatVFS implements StatVFSFileCmder interface
-func (c *Connection) StatVFS(r *sftp.Request) (*sftp.StatVFS, error) {
+func (c *Connection) StatVFS(r *sftp.Request) (stat *sftp.StatVFS, err error) {
 	c.UpdateLastActivity()
+	span := c.startRequestSpan(r)
+	defer func() { endRequestSpan(span, err) }()
 
 	// we are assuming that r.Filepath is a dir, this could be wrong but should
 	// not produce any side effect here.
@@ -295,6 +367,13 @@ func (c *Connection) handleSFTPSetstat(request *sftp.Request) error {
 		attrs.Flags |= common.StatAttrSize
 		attrs.Size = int64(request.Attributes().Size)
 	}
+	if request.Flags&sshFileXferAttrExtended != 0 {
+		xattrs := getXattrsFromExtended(request.Attributes().Extended)
+		if len(xattrs) > 0 {
+			attrs.Flags |= common.StatAttrXattrs
+			attrs.Xattrs = xattrs
+		}
+	}
 
 	return c.SetStat(request.Filepath, &attrs)
 }
@@ -334,10 +413,11 @@ func (c *Connection) handleSFTPUploadToNewFile(fs vfs.Fs, resolvedPath, filePath
 	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
 
 	// we can get an error only for resume
-	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported())
+	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0, fs.Capabilities().UploadResume)
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, 0, 0, maxWriteSize, true, fs)
+	baseTransfer.SetEffectiveFsPath(filePath)
 	t := newTransfer(baseTransfer, w, nil, errForRead)
 
 	return t, nil
@@ -356,17 +436,26 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 	osFlags := getOSOpenFlags(pflags)
 	isTruncate := osFlags&os.O_TRUNC != 0
 	isResume := pflags.Append && !isTruncate
+	// an interrupted upload to a cloud storage backend can be continued, the file
+	// size is the already uploaded size
+	isResumableUpload := !isTruncate && vfs.IsResumableUpload(fs, filePath, fileSize)
+	if isResumableUpload {
+		isResume = true
+	}
 
 	// if there is a size limit the remaining size cannot be 0 here, since quotaResult.HasSpace
 	// will return false in this case and we deny the upload before.
 	// For Cloud FS GetMaxWriteSize will return unsupported operation
-	maxWriteSize, err := c.GetMaxWriteSize(quotaResult, isResume, fileSize, fs.IsUploadResumeSupported())
+	maxWriteSize, err := c.GetMaxWriteSize(quotaResult, isResume, fileSize,
+		fs.Capabilities().UploadResume || isResumableUpload)
 	if err != nil {
 		c.Log(logger.LevelDebug, "unable to get max write size: %v", err)
 		return nil, err
 	}
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	// uploads to cloud storage backends always replace the existing object, we don't need to
+	// copy it to the temporary path
+	if caps := fs.Capabilities(); c.IsAtomicUploadEnabled() && caps.AtomicUpload && caps.Truncate {
 		err = fs.Rename(resolvedPath, filePath)
 		if err != nil {
 			c.Log(logger.LevelWarn, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %+v",
@@ -375,7 +464,14 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 		}
 	}
 
-	file, w, cancelFn, err := fs.Create(filePath, osFlags)
+	var file vfs.File
+	var w *vfs.PipeWriter
+	var cancelFn func()
+	if isResumableUpload {
+		w, cancelFn, err = fs.(vfs.ResumableUploadFs).ResumeUpload(filePath, fileSize)
+	} else {
+		file, w, cancelFn, err = fs.Create(filePath, osFlags)
+	}
 	if err != nil {
 		c.Log(logger.LevelWarn, "error opening existing file, flags: %v, source: %#v, err: %+v", pflags, filePath, err)
 		return nil, c.GetFsError(fs, err)
@@ -385,9 +481,12 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 	if isResume {
 		c.Log(logger.LevelDebug, "resuming upload requested, file path %#v initial size: %v", filePath, fileSize)
 		minWriteOffset = fileSize
-		initialSize = fileSize
+		// the interrupted upload is not included in the quota
+		if !isResumableUpload {
+			initialSize = fileSize
+		}
 	} else {
-		if vfs.IsLocalOrSFTPFs(fs) && isTruncate {
+		if fs.Capabilities().Truncate && isTruncate {
 			vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
 				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
@@ -397,6 +496,7 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 			} else {
 				dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
 			}
+			dataprovider.UpdateDirQuota(&c.User, requestPath, 0, -fileSize)
 		} else {
 			initialSize = fileSize
 		}
@@ -405,7 +505,8 @@ func (c *Connection) handleSFTPUploadToExistingFile(fs vfs.Fs, pflags sftp.FileO
 	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
-		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, false, fs)
+		common.TransferUpload, minWriteOffset, initialSize, maxWriteSize, isResumableUpload, fs)
+	baseTransfer.SetEffectiveFsPath(filePath)
 	t := newTransfer(baseTransfer, w, nil, errForRead)
 
 	return t, nil
@@ -2,6 +2,11 @@ package sftpd
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
@@ -81,14 +86,11 @@ func (fs MockOsFs) Name() string {
 	return "mockOsFs"
 }
 
-// IsUploadResumeSupported returns true if resuming uploads is supported
-func (MockOsFs) IsUploadResumeSupported() bool {
-	return false
-}
-
-// IsAtomicUploadSupported returns true if atomic upload is supported
-func (fs MockOsFs) IsAtomicUploadSupported() bool {
-	return fs.isAtomicUploadSupported
+// Capabilities returns the features supported by this filesystem
+func (fs MockOsFs) Capabilities() vfs.FsCapabilities {
+	return vfs.FsCapabilities{
+		AtomicUpload: fs.isAtomicUploadSupported,
+	}
 }
 
 // Stat returns a FileInfo describing the named file
@@ -123,6 +125,36 @@ func (fs MockOsFs) Rename(source, target string) error {
 	return os.Rename(source, target)
 }
 
+// mockPipeFs emulates a cloud backend, downloads are streamed using a pipe
+type mockPipeFs struct {
+	vfs.Fs
+	openOffsets []int64
+}
+
+// Open opens the named file for reading
+func (fs *mockPipeFs) Open(name string, offset int64) (vfs.File, *pipeat.PipeReaderAt, func(), error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.Pipe()
+	if err != nil {
+		f.Close()
+		return nil, nil, nil, err
+	}
+	fs.openOffsets = append(fs.openOffsets, offset)
+	go func() {
+		defer f.Close()
+		_, err := io.Copy(w, f)
+		w.CloseWithError(err) //nolint:errcheck
+	}()
+	return nil, r, func() {}, nil
+}
+
 func newMockOsFs(err, statErr error, atomicUpload bool, connectionID, rootDir string) vfs.Fs {
 	return &MockOsFs{
 		Fs:                      vfs.NewOsFs(connectionID, rootDir, ""),
@@ -233,6 +265,90 @@ func TestReadWriteErrors(t *testing.T) {
 	assert.Len(t, conn.GetTransfers(), 0)
 }
 
+func TestDirListerAt(t *testing.T) {
+	dirPath := t.TempDir()
+	for i := 0; i < 3; i++ {
+		err := os.WriteFile(filepath.Join(dirPath, fmt.Sprintf("file%v", i)), []byte("data"), os.ModePerm)
+		require.NoError(t, err)
+	}
+	fs := vfs.NewOsFs("", dirPath, "")
+	lister, err := fs.OpenDir(dirPath)
+	require.NoError(t, err)
+	l := newDirListerAt(lister)
+	entries := make([]os.FileInfo, 2)
+	n, err := l.ListAt(entries, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = l.ListAt(entries, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = l.ListAt(entries, 3)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, 0, n)
+	assert.NoError(t, l.Close())
+
+	lister, err = fs.OpenDir(dirPath)
+	require.NoError(t, err)
+	l = newDirListerAt(lister)
+	// entries must be requested sequentially
+	_, err = l.ListAt(entries, 1)
+	assert.ErrorIs(t, err, sftp.ErrSSHFxFailure)
+	_, err = l.ListAt(entries, 0)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.NoError(t, l.Close())
+}
+
+func TestRangeReads(t *testing.T) {
+	testfile := filepath.Join(os.TempDir(), "range_read_test_file")
+	content := make([]byte, 2*rangeReadMinGap)
+	_, err := rand.Read(content)
+	assert.NoError(t, err)
+	err = os.WriteFile(testfile, content, os.ModePerm)
+	assert.NoError(t, err)
+
+	fs := &mockPipeFs{
+		Fs: vfs.NewOsFs("", os.TempDir(), ""),
+	}
+	conn := common.NewBaseConnection("", common.ProtocolSFTP, dataprovider.User{})
+	_, r, _, err := fs.Open(testfile, 0)
+	assert.NoError(t, err)
+	baseTransfer := common.NewBaseTransfer(nil, conn, nil, testfile, "/range_read_test_file", common.TransferDownload,
+		0, 0, 0, false, fs)
+	transfer := newTransfer(baseTransfer, nil, r, nil)
+	buf := make([]byte, 32768)
+	// sequential reads use the initial reader
+	n, err := transfer.ReadAt(buf, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, content[:n], buf[:n])
+	n, err = transfer.ReadAt(buf, 32768)
+	assert.NoError(t, err)
+	assert.Equal(t, content[32768:32768+n], buf[:n])
+	assert.Equal(t, []int64{0}, fs.openOffsets)
+	// read the file tail
+	offset := int64(len(content) - 1000)
+	n, err = transfer.ReadAt(buf, offset)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 1000, n)
+	assert.Equal(t, content[offset:], buf[:n])
+	assert.Equal(t, []int64{0, offset}, fs.openOffsets)
+	// read before the current reader offset
+	n, err = transfer.ReadAt(buf, 65536)
+	assert.NoError(t, err)
+	assert.Equal(t, content[65536:65536+n], buf[:n])
+	assert.Equal(t, []int64{0, offset, 65536}, fs.openOffsets)
+	// reads beyond the end of the file
+	n, err = transfer.ReadAt(buf, int64(len(content)+rangeReadMinGap))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 0, n)
+	assert.Len(t, fs.openOffsets, 3)
+	assert.NoError(t, transfer.ErrTransfer)
+
+	err = transfer.Close()
+	assert.NoError(t, err)
+	err = os.Remove(testfile)
+	assert.NoError(t, err)
+}
+
 func TestUnsupportedListOP(t *testing.T) {
 	conn := common.NewBaseConnection("", common.ProtocolSFTP, dataprovider.User{})
 	sftpConn := Connection{
@@ -381,6 +497,47 @@ func TestSupportedSSHCommands(t *testing.T) {
 	}
 }
 
+func TestBindingOverrides(t *testing.T) {
+	serverConfig := &ssh.ServerConfig{
+		ServerVersion: "SSH-2.0-SFTPGo",
+	}
+	b := Binding{
+		Port: 2022,
+	}
+	assert.NoError(t, b.validate())
+	assert.Equal(t, serverConfig, b.getServerConfig(serverConfig))
+
+	b.Banner = "internal"
+	b.Ciphers = []string{"aes128-ctr"}
+	bindingConfig := b.getServerConfig(serverConfig)
+	assert.Equal(t, "SSH-2.0-internal", bindingConfig.ServerVersion)
+	assert.Equal(t, []string{"aes128-ctr"}, bindingConfig.Ciphers)
+	assert.Equal(t, "SSH-2.0-SFTPGo", serverConfig.ServerVersion)
+	assert.Empty(t, serverConfig.Ciphers)
+
+	b.EnabledSSHCommands = []string{"md5sum", "unsupported"}
+	assert.NoError(t, b.validate())
+	assert.Equal(t, []string{"md5sum"}, b.EnabledSSHCommands)
+	b.EnabledSSHCommands = []string{"*"}
+	assert.NoError(t, b.validate())
+	assert.Equal(t, GetSupportedSSHCommands(), b.EnabledSSHCommands)
+
+	b.ProxyProtocol = 3
+	assert.Error(t, b.validate())
+	b.ProxyProtocol = 2
+	assert.NoError(t, b.validate())
+	b.ApplyProxyConfig = true
+	assert.True(t, b.HasProxy())
+	b.ApplyProxyConfig = false
+	assert.False(t, b.HasProxy())
+
+	uploadMode := 3
+	b.UploadMode = &uploadMode
+	assert.Error(t, b.validate())
+	uploadMode = common.UploadModeAtomic
+	assert.NoError(t, b.validate())
+}
+
 func TestSSHCommandPath(t *testing.T) {
 	buf := make([]byte, 65535)
 	stdErrBuf := make([]byte, 65535)
@@ -1930,7 +2087,7 @@ func TestSFTPSubSystem(t *testing.T) {
 
 func TestRecoverer(t *testing.T) {
 	c := Configuration{}
-	c.AcceptInboundConnection(nil, nil)
+	c.AcceptInboundConnection(nil, nil, Binding{})
 	connID := "connectionID"
 	connection := &Connection{
 		BaseConnection: common.NewBaseConnection(connID, common.ProtocolSFTP, dataprovider.User{}),
@@ -2020,3 +2177,221 @@ func newFakeListener(err error) net.Listener {
 		err:    err,
 	}
 }
+
+func TestGetXattrsFromExtended(t *testing.T) {
+	xattrs := getXattrsFromExtended([]sftp.StatExtended{
+		{ExtType: "user.comment", ExtData: "value"},
+		{ExtType: "user.removed", ExtData: ""},
+		{ExtType: "system.posix_acl_access", ExtData: "\x02\x00\x00\x00"},
+		{ExtType: "user.sftpgo.sha256", ExtData: "value"},
+		{ExtType: "limits@openssh.com", ExtData: "value"},
+	})
+	assert.Len(t, xattrs, 3)
+	assert.Equal(t, []byte("value"), xattrs["user.comment"])
+	assert.Nil(t, xattrs["user.removed"])
+	assert.Contains(t, xattrs, "user.removed")
+	assert.Equal(t, []byte{2, 0, 0, 0}, xattrs["system.posix_acl_access"])
+}
+
+// checkFileTestChannel reads the client packets from in and writes the server
+// packets to out
+type checkFileTestChannel struct {
+	in  *bytes.Buffer
+	out *bytes.Buffer
+}
+
+func (c *checkFileTestChannel) Read(data []byte) (int, error) {
+	return c.in.Read(data)
+}
+
+func (c *checkFileTestChannel) Write(data []byte) (int, error) {
+	return c.out.Write(data)
+}
+
+func (c *checkFileTestChannel) Close() error {
+	return nil
+}
+
+func getTestSFTPPacket(packetType byte, id uint32, payload []byte) []byte {
+	packet := make([]byte, 9, 9+len(payload))
+	packet[4] = packetType
+	binary.BigEndian.PutUint32(packet[5:], id)
+	packet = append(packet, payload...)
+	binary.BigEndian.PutUint32(packet, uint32(len(packet)-4))
+	return packet
+}
+
+func getTestCheckFilePacket(id uint32, name, algorithms string, startOffset, length uint64, blockSize uint32) []byte {
+	payload := appendSFTPString(nil, checkFileNameRequest)
+	payload = appendSFTPString(payload, name)
+	payload = appendSFTPString(payload, algorithms)
+	data := make([]byte, 20)
+	binary.BigEndian.PutUint64(data, startOffset)
+	binary.BigEndian.PutUint64(data[8:], length)
+	binary.BigEndian.PutUint32(data[16:], blockSize)
+	return getTestSFTPPacket(sftpPacketExtended, id, append(payload, data...))
+}
+
+// getTestSFTPPackets splits the given data in packets, the length is removed
+func getTestSFTPPackets(t *testing.T, data []byte) [][]byte {
+	var packets [][]byte
+	for len(data) > 0 {
+		require.GreaterOrEqual(t, len(data), 4)
+		length := int(binary.BigEndian.Uint32(data))
+		require.GreaterOrEqual(t, len(data), 4+length)
+		packets = append(packets, data[4:4+length])
+		data = data[4+length:]
+	}
+	return packets
+}
+
+func TestCheckFileChannelVersion(t *testing.T) {
+	testChannel := &checkFileTestChannel{
+		in:  bytes.NewBuffer(nil),
+		out: bytes.NewBuffer(nil),
+	}
+	channel := newCheckFileChannel(testChannel, nil)
+	version := []byte{0x00, 0x00, 0x00, 0x05, sftpPacketVersion, 0x00, 0x00, 0x00, 0x03}
+	// partial packets are not sent
+	n, err := channel.Write(version[:3])
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, 0, testChannel.out.Len())
+	status := marshalSFTPStatus(1, sftp.ErrSSHFxFailure)
+	n, err = channel.Write(append(version[3:], status[:6]...))
+	assert.NoError(t, err)
+	assert.Equal(t, 12, n)
+	n, err = channel.Write(status[6:])
+	assert.NoError(t, err)
+	assert.Equal(t, len(status)-6, n)
+	// only the first version packet is modified
+	n, err = channel.Write(version)
+	assert.NoError(t, err)
+	assert.Equal(t, len(version), n)
+
+	packets := getTestSFTPPackets(t, testChannel.out.Bytes())
+	require.Len(t, packets, 3)
+	assert.Equal(t, version[4:9], packets[0][:5])
+	name, data, ok := unmarshalSFTPString(packets[0][5:])
+	assert.True(t, ok)
+	assert.Equal(t, checkFileExtension, name)
+	algorithms, data, ok := unmarshalSFTPString(data)
+	assert.True(t, ok)
+	assert.Equal(t, "md5,sha1,sha256,sha384,sha512", algorithms)
+	assert.Len(t, data, 0)
+	assert.Equal(t, status[4:], packets[1])
+	assert.Equal(t, version[4:], packets[2])
+
+	channel = newCheckFileChannel(&MockChannel{
+		Buffer:     bytes.NewBuffer(nil),
+		WriteError: errors.New("write error"),
+	}, nil)
+	_, err = channel.Write(version)
+	assert.EqualError(t, err, "write error")
+}
+
+func TestCheckFileChannel(t *testing.T) {
+	homeDir := t.TempDir()
+	content := []byte("check-file content")
+	err := os.WriteFile(filepath.Join(homeDir, "file"), content, os.ModePerm)
+	require.NoError(t, err)
+	err = os.Mkdir(filepath.Join(homeDir, "sub"), os.ModePerm)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(homeDir, "sub", "file"), content, os.ModePerm)
+	require.NoError(t, err)
+
+	permissions := make(map[string][]string)
+	permissions["/"] = []string{dataprovider.PermAny}
+	permissions["/sub"] = []string{dataprovider.PermListItems}
+	user := dataprovider.User{
+		Username:    "check_file_user",
+		Permissions: permissions,
+		HomeDir:     homeDir,
+	}
+	connection := &Connection{
+		BaseConnection: common.NewBaseConnection("check_file_id", common.ProtocolSFTP, user),
+	}
+	initPacket := []byte{0x00, 0x00, 0x00, 0x05, 0x01, 0x00, 0x00, 0x00, 0x03}
+	rename := getTestSFTPPacket(sftpPacketExtended, 11, appendSFTPString(nil, "posix-rename@openssh.com"))
+	badMessage := getTestSFTPPacket(sftpPacketExtended, 10, appendSFTPString(nil, checkFileNameRequest))
+	testChannel := &checkFileTestChannel{
+		in:  bytes.NewBuffer(nil),
+		out: bytes.NewBuffer(nil),
+	}
+	testChannel.in.Write(initPacket)                                                                //nolint:errcheck
+	testChannel.in.Write(getTestCheckFilePacket(1, "/file", "sha256", 0, 0, 0))                     //nolint:errcheck
+	testChannel.in.Write(getTestCheckFilePacket(2, "file", "crc32,md5,sha1", 0, 0, 1024))           //nolint:errcheck
+	testChannel.in.Write(getTestCheckFilePacket(3, "/missing", "sha256", 0, 0, 0))                  //nolint:errcheck
+	testChannel.in.Write(getTestCheckFilePacket(4, "/sub/file", "sha256", 0, 0, 0))                 //nolint:errcheck
+	testChannel.in.Write(getTestCheckFilePacket(5, "/file", "crc32", 0, 0, 0))                      //nolint:errcheck
+	testChannel.in.Write(getTestCheckFilePacket(6, "/file", "sha256", 1, 0, 0))                     //nolint:errcheck
+	testChannel.in.Write(getTestCheckFilePacket(7, "/file", "sha256", 0, 1, 0))                     //nolint:errcheck
+	testChannel.in.Write(getTestCheckFilePacket(8, "/file", "sha256", 0, 0, 1))                     //nolint:errcheck
+	testChannel.in.Write(getTestCheckFilePacket(9, "/sub", "sha256", 0, 0, 0))                      //nolint:errcheck
+	testChannel.in.Write(badMessage)                                                                //nolint:errcheck
+	testChannel.in.Write(rename)                                                                    //nolint:errcheck
+	testChannel.in.Write(getTestCheckFilePacket(12, "/file", "sha512", 0, uint64(len(content)), 0)) //nolint:errcheck
+
+	channel := newCheckFileChannel(testChannel, connection)
+	// the other packets are passed through
+	data, err := io.ReadAll(channel)
+	assert.NoError(t, err)
+	assert.Equal(t, append(initPacket, rename...), data)
+
+	packets := getTestSFTPPackets(t, testChannel.out.Bytes())
+	require.Len(t, packets, 11)
+	sha256Sum := sha256.Sum256(content)
+	md5Sum := md5.Sum(content)
+	sha512Sum := sha512.Sum512(content)
+	for idx, expected := range [][]byte{sha256Sum[:], md5Sum[:]} {
+		packet := packets[idx]
+		assert.Equal(t, byte(sftpPacketExtendedReply), packet[0])
+		assert.Equal(t, uint32(idx+1), binary.BigEndian.Uint32(packet[1:]))
+		algorithm, sum, ok := unmarshalSFTPString(packet[5:])
+		assert.True(t, ok)
+		assert.Equal(t, []string{"sha256", "md5"}[idx], algorithm)
+		assert.Equal(t, expected, sum)
+	}
+	statusCodes := []uint32{sftpStatusNoSuchFile, sftpStatusPermissionDenied, sftpStatusOpUnsupported,
+		sftpStatusOpUnsupported, sftpStatusOpUnsupported, sftpStatusOpUnsupported, sftpStatusFailure,
+		sftpStatusBadMessage}
+	for idx, code := range statusCodes {
+		packet := packets[idx+2]
+		assert.Equal(t, byte(sftpPacketStatus), packet[0])
+		assert.Equal(t, uint32(idx+3), binary.BigEndian.Uint32(packet[1:]))
+		assert.Equal(t, code, binary.BigEndian.Uint32(packet[5:]), "unexpected status code for id %v", idx+3)
+	}
+	packet := packets[10]
+	assert.Equal(t, byte(sftpPacketExtendedReply), packet[0])
+	assert.Equal(t, uint32(12), binary.BigEndian.Uint32(packet[1:]))
+	algorithm, sum, ok := unmarshalSFTPString(packet[5:])
+	assert.True(t, ok)
+	assert.Equal(t, "sha512", algorithm)
+	assert.Equal(t, sha512Sum[:], sum)
+}
+
+func TestCheckFileChannelReadErrors(t *testing.T) {
+	testChannel := &checkFileTestChannel{
+		in:  bytes.NewBuffer([]byte{0x00, 0x00, 0x00, 0x05, 0x01}),
+		out: bytes.NewBuffer(nil),
+	}
+	channel := newCheckFileChannel(testChannel, nil)
+	_, err := channel.Read(make([]byte, 10))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	_, err = channel.Read(make([]byte, 10))
+	assert.ErrorIs(t, err, io.EOF)
+	// packets too long are passed through without reading them
+	testChannel.in.Write([]byte{0x00, 0x05, 0x00, 0x00, 0x01}) //nolint:errcheck
+	buf := make([]byte, 10)
+	n, err := channel.Read(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x00, 0x05, 0x00, 0x00}, buf[:n])
+	assert.Equal(t, 1, testChannel.in.Len())
+
+	_, err = parseCheckFileRequest(appendSFTPString(nil, "file"))
+	assert.ErrorIs(t, err, errCheckFileBadMessage)
+	_, err = parseCheckFileRequest(appendSFTPString(appendSFTPString(nil, "file"), "sha256"))
+	assert.ErrorIs(t, err, errCheckFileBadMessage)
+	_, _, ok := unmarshalSFTPString([]byte{0x00, 0x00, 0x00, 0x02, 0x01})
+	assert.False(t, ok)
+}
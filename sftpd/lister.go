@@ -3,6 +3,11 @@ package sftpd
 import (
 	"io"
 	"os"
+	"sync"
+
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/vfs"
 )
 
 type listerAt []os.FileInfo
@@ -20,3 +25,66 @@ func (l listerAt) ListAt(f []os.FileInfo, offset int64) (int, error) {
 	}
 	return n, nil
 }
+
+// dirListerAt implements sftp.ListerAt reading the directory entries in
+// batches from a vfs.DirLister, so huge directories are listed in bounded memory
+type dirListerAt struct {
+	sync.Mutex
+	lister vfs.DirLister
+	offset int64
+	done   bool
+}
+
+func newDirListerAt(lister vfs.DirLister) *dirListerAt {
+	return &dirListerAt{
+		lister: lister,
+	}
+}
+
+// ListAt returns the number of entries copied and an io.EOF error if we made it to the end of the file list.
+// pkg/sftp requests the entries sequentially, so offset must match the number
+// of the entries already returned
+func (l *dirListerAt) ListAt(f []os.FileInfo, offset int64) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.done {
+		return 0, io.EOF
+	}
+	if offset != l.offset {
+		l.close()
+		return 0, sftp.ErrSSHFxFailure
+	}
+	if len(f) == 0 {
+		return 0, nil
+	}
+	for {
+		entries, err := l.lister.Next(len(f))
+		n := copy(f, entries)
+		l.offset += int64(n)
+		if err != nil {
+			l.close()
+			return n, err
+		}
+		// an empty batch doesn't mean that the listing is complete
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Close closes the underlying lister if the listing is not complete
+func (l *dirListerAt) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	l.close()
+	return nil
+}
+
+func (l *dirListerAt) close() {
+	if !l.done {
+		l.done = true
+		l.lister.Close()
+	}
+}
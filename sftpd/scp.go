@@ -220,7 +220,17 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 		return err
 	}
 
-	maxWriteSize, _ := c.connection.GetMaxWriteSize(quotaResult, false, fileSize, fs.IsUploadResumeSupported())
+	maxWriteSize, _ := c.connection.GetMaxWriteSize(quotaResult, false, fileSize, fs.Capabilities().UploadResume)
+
+	if err := c.connection.LimitOperationRate(common.RateLimitOperationOpen); err != nil {
+		c.sendErrorMessage(fs, err)
+		return err
+	}
+
+	if err := c.connection.CheckMaxTransfers(); err != nil {
+		c.sendErrorMessage(fs, err)
+		return err
+	}
 
 	file, w, cancelFn, err := fs.Create(filePath, 0)
 	if err != nil {
@@ -231,7 +241,7 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 
 	initialSize := int64(0)
 	if !isNewFile {
-		if vfs.IsLocalOrSFTPFs(fs) {
+		if fs.Capabilities().Truncate {
 			vfolder, err := c.connection.User.GetVirtualFolderForPath(path.Dir(requestPath))
 			if err == nil {
 				dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
@@ -241,6 +251,7 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 			} else {
 				dataprovider.UpdateUserQuota(&c.connection.User, 0, -fileSize, false) //nolint:errcheck
 			}
+			dataprovider.UpdateDirQuota(&c.connection.User, requestPath, 0, -fileSize)
 		} else {
 			initialSize = fileSize
 		}
@@ -253,6 +264,7 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 
 	baseTransfer := common.NewBaseTransfer(file, c.connection.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, 0, initialSize, maxWriteSize, isNewFile, fs)
+	baseTransfer.SetEffectiveFsPath(filePath)
 	t := newTransfer(baseTransfer, w, nil, nil)
 
 	return c.getUploadFileData(sizeToRead, t)
@@ -261,6 +273,12 @@ func (c *scpCommand) handleUploadFile(fs vfs.Fs, resolvedPath, filePath string,
 func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error {
 	c.connection.UpdateLastActivity()
 
+	uploadFilePath, err := c.connection.PreUploadAction(uploadFilePath)
+	if err != nil {
+		c.sendErrorMessage(nil, err)
+		return err
+	}
+
 	fs, p, err := c.connection.GetFsAndResolvedPath(uploadFilePath)
 	if err != nil {
 		c.connection.Log(logger.LevelWarn, "error uploading file: %#v, err: %v", uploadFilePath, err)
@@ -275,7 +293,7 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 	}
 
 	filePath := p
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if c.connection.IsAtomicUploadEnabled() && fs.Capabilities().AtomicUpload {
 		filePath = fs.GetAtomicUploadPath(p)
 	}
 	stat, statErr := fs.Lstat(p)
@@ -307,7 +325,7 @@ func (c *scpCommand) handleUpload(uploadFilePath string, sizeToRead int64) error
 		return common.ErrPermissionDenied
 	}
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if caps := fs.Capabilities(); c.connection.IsAtomicUploadEnabled() && caps.AtomicUpload && caps.Truncate {
 		err = fs.Rename(p, filePath)
 		if err != nil {
 			c.connection.Log(logger.LevelError, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %v",
@@ -359,24 +377,13 @@ func (c *scpCommand) handleRecursiveDownload(fs vfs.Fs, dirPath, virtualPath str
 		if err != nil {
 			return err
 		}
-		files, err := fs.ReadDir(dirPath)
+		lister, err := c.connection.GetDirLister(fs, dirPath, fs.GetRelativePath(dirPath))
 		if err != nil {
 			c.sendErrorMessage(fs, err)
 			return err
 		}
-		files = c.connection.User.AddVirtualDirs(files, fs.GetRelativePath(dirPath))
-		var dirs []string
-		for _, file := range files {
-			filePath := fs.GetRelativePath(fs.Join(dirPath, file.Name()))
-			if file.Mode().IsRegular() || file.Mode()&os.ModeSymlink != 0 {
-				err = c.handleDownload(filePath)
-				if err != nil {
-					break
-				}
-			} else if file.IsDir() {
-				dirs = append(dirs, filePath)
-			}
-		}
+		dirs, err := c.downloadDirFiles(fs, dirPath, lister)
+		lister.Close()
 		if err != nil {
 			c.sendErrorMessage(fs, err)
 			return err
@@ -402,6 +409,31 @@ func (c *scpCommand) handleRecursiveDownload(fs vfs.Fs, dirPath, virtualPath str
 	return err
 }
 
+// downloadDirFiles sends the files inside the given directory reading its
+// entries in batches and returns the paths for the subdirectories
+func (c *scpCommand) downloadDirFiles(fs vfs.Fs, dirPath string, lister vfs.DirLister) ([]string, error) {
+	var dirs []string
+	for {
+		files, err := lister.Next(vfs.ListerBatchSize)
+		for _, file := range files {
+			filePath := fs.GetRelativePath(fs.Join(dirPath, file.Name()))
+			if file.Mode().IsRegular() || file.Mode()&os.ModeSymlink != 0 {
+				if errDownload := c.handleDownload(filePath); errDownload != nil {
+					return dirs, errDownload
+				}
+			} else if file.IsDir() {
+				dirs = append(dirs, filePath)
+			}
+		}
+		if err == io.EOF {
+			return dirs, nil
+		}
+		if err != nil {
+			return dirs, err
+		}
+	}
+}
+
 func (c *scpCommand) sendDownloadFileData(fs vfs.Fs, filePath string, stat os.FileInfo, transfer *transfer) error {
 	var err error
 	if c.sendFileTime() {
@@ -507,6 +539,22 @@ func (c *scpCommand) handleDownload(filePath string) error {
 		return common.ErrPermissionDenied
 	}
 
+	if err := c.connection.LimitOperationRate(common.RateLimitOperationOpen); err != nil {
+		c.sendErrorMessage(fs, err)
+		return err
+	}
+
+	if err := c.connection.CheckMaxTransfers(); err != nil {
+		c.sendErrorMessage(fs, err)
+		return err
+	}
+
+	remoteIP := utils.GetIPFromRemoteAddress(c.connection.GetRemoteAddress())
+	if err := c.connection.PreDownloadAction(p, filePath, remoteIP); err != nil {
+		c.sendErrorMessage(fs, err)
+		return err
+	}
+
 	file, r, cancelFn, err := fs.Open(p, 0)
 	if err != nil {
 		c.connection.Log(logger.LevelError, "could not open file %#v for reading: %v", p, err)
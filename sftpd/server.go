@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -33,6 +34,8 @@ const (
 
 var (
 	sftpExtensions = []string{"statvfs@openssh.com"}
+	loginBannerMu  sync.RWMutex
+	loginBanner    string
 )
 
 // Binding defines the configuration for a network listener
@@ -43,6 +46,20 @@ type Binding struct {
 	Port int `json:"port" mapstructure:"port"`
 	// Apply the proxy configuration, if any, for this binding
 	ApplyProxyConfig bool `json:"apply_proxy_config" mapstructure:"apply_proxy_config"`
+	// The following settings override the global ones for this binding.
+	// Identification string used by the server. Empty means the global banner
+	Banner string `json:"banner" mapstructure:"banner"`
+	// Ciphers allowed. Empty means the global ciphers
+	Ciphers []string `json:"ciphers" mapstructure:"ciphers"`
+	// Proxy protocol mode, 1 optional, 2 required. 0 means the common proxy_protocol.
+	// It is ignored if ApplyProxyConfig is false
+	ProxyProtocol int `json:"proxy_protocol" mapstructure:"proxy_protocol"`
+	// IP addresses and ranges allowed to send the proxy header. Empty means the common proxy_allowed
+	ProxyAllowed []string `json:"proxy_allowed" mapstructure:"proxy_allowed"`
+	// Enabled SSH commands. Empty means the global enabled SSH commands
+	EnabledSSHCommands []string `json:"enabled_ssh_commands" mapstructure:"enabled_ssh_commands"`
+	// Upload mode, same values as the common upload_mode. Nil means the common upload_mode
+	UploadMode *int `json:"upload_mode" mapstructure:"upload_mode"`
 }
 
 // GetAddress returns the binding address
@@ -57,7 +74,37 @@ func (b *Binding) IsValid() bool {
 
 // HasProxy returns true if the proxy protocol is active for this binding
 func (b *Binding) HasProxy() bool {
-	return b.ApplyProxyConfig && common.Config.ProxyProtocol > 0
+	return b.ApplyProxyConfig && (b.ProxyProtocol > 0 || common.Config.ProxyProtocol > 0)
+}
+
+func (b *Binding) validate() error {
+	if b.ProxyProtocol < 0 || b.ProxyProtocol > 2 {
+		return fmt.Errorf("invalid proxy protocol %v for binding %#v", b.ProxyProtocol, b.GetAddress())
+	}
+	if b.UploadMode != nil {
+		if *b.UploadMode < common.UploadModeStandard || *b.UploadMode > common.UploadModeAtomicWithResume {
+			return fmt.Errorf("invalid upload mode %v for binding %#v", *b.UploadMode, b.GetAddress())
+		}
+	}
+	if len(b.EnabledSSHCommands) > 0 {
+		b.EnabledSSHCommands = getEnabledSSHCommands(b.EnabledSSHCommands)
+	}
+	return nil
+}
+
+// getServerConfig returns the SSH server configuration for this binding
+func (b *Binding) getServerConfig(serverConfig *ssh.ServerConfig) *ssh.ServerConfig {
+	if b.Banner == "" && len(b.Ciphers) == 0 {
+		return serverConfig
+	}
+	bindingConfig := *serverConfig
+	if b.Banner != "" {
+		bindingConfig.ServerVersion = fmt.Sprintf("SSH-2.0-%v", b.Banner)
+	}
+	if len(b.Ciphers) > 0 {
+		bindingConfig.Ciphers = b.Ciphers
+	}
+	return &bindingConfig
 }
 
 // Configuration for the SFTP server
@@ -225,6 +272,15 @@ func (c *Configuration) Initialize(configDir string) error {
 	exitChannel := make(chan error, 1)
 	serviceStatus.Bindings = nil
 
+	for idx := range c.Bindings {
+		if !c.Bindings[idx].IsValid() {
+			continue
+		}
+		if err := c.Bindings[idx].validate(); err != nil {
+			return err
+		}
+	}
+
 	for _, binding := range c.Bindings {
 		if !binding.IsValid() {
 			continue
@@ -234,7 +290,7 @@ func (c *Configuration) Initialize(configDir string) error {
 		go func(binding Binding) {
 			addr := binding.GetAddress()
 			utils.CheckTCP4Port(binding.Port)
-			listener, err := net.Listen("tcp", addr)
+			listener, err := utils.Listen("tcp", addr)
 			if err != nil {
 				logger.Warn(logSender, "", "error starting listener on address %v: %v", addr, err)
 				exitChannel <- err
@@ -242,7 +298,8 @@ func (c *Configuration) Initialize(configDir string) error {
 			}
 
 			if binding.ApplyProxyConfig {
-				proxyListener, err := common.Config.GetProxyListener(listener)
+				proxyListener, err := common.Config.GetProxyListenerWithOverrides(listener, binding.ProxyProtocol,
+					binding.ProxyAllowed)
 				if err != nil {
 					logger.Warn(logSender, "", "error enabling proxy listener: %v", err)
 					exitChannel <- err
@@ -253,7 +310,7 @@ func (c *Configuration) Initialize(configDir string) error {
 				}
 			}
 
-			exitChannel <- c.serve(listener, serverConfig)
+			exitChannel <- c.serve(listener, binding.getServerConfig(serverConfig), binding)
 		}(binding)
 	}
 
@@ -263,7 +320,7 @@ func (c *Configuration) Initialize(configDir string) error {
 	return <-exitChannel
 }
 
-func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerConfig) error {
+func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerConfig, binding Binding) error {
 	logger.Info(logSender, "", "server listener registered, address: %v", listener.Addr().String())
 	var tempDelay time.Duration // how long to sleep on accept failure
 
@@ -287,7 +344,7 @@ func (c *Configuration) serve(listener net.Listener, serverConfig *ssh.ServerCon
 			return err
 		}
 
-		go c.AcceptInboundConnection(conn, serverConfig)
+		go c.AcceptInboundConnection(conn, serverConfig, binding)
 	}
 }
 
@@ -304,22 +361,37 @@ func (c *Configuration) configureSecurityOptions(serverConfig *ssh.ServerConfig)
 }
 
 func (c *Configuration) configureLoginBanner(serverConfig *ssh.ServerConfig, configDir string) {
-	if len(c.LoginBannerFile) > 0 {
+	c.ReloadLoginBanner(configDir)
+	serverConfig.BannerCallback = func(conn ssh.ConnMetadata) string {
+		loginBannerMu.RLock()
+		defer loginBannerMu.RUnlock()
+
+		return loginBanner
+	}
+}
+
+// ReloadLoginBanner reads the login banner file again, the new contents are sent
+// to the clients that connect from now on. The other settings are ignored
+func (c *Configuration) ReloadLoginBanner(configDir string) {
+	var banner string
+	if c.LoginBannerFile != "" {
 		bannerFilePath := c.LoginBannerFile
 		if !filepath.IsAbs(bannerFilePath) {
 			bannerFilePath = filepath.Join(configDir, bannerFilePath)
 		}
 		bannerContent, err := os.ReadFile(bannerFilePath)
 		if err == nil {
-			banner := string(bannerContent)
-			serverConfig.BannerCallback = func(conn ssh.ConnMetadata) string {
-				return banner
-			}
+			banner = string(bannerContent)
 		} else {
 			logger.WarnToConsole("unable to read SFTPD login banner file: %v", err)
 			logger.Warn(logSender, "", "unable to read login banner file: %v", err)
 		}
 	}
+
+	loginBannerMu.Lock()
+	defer loginBannerMu.Unlock()
+
+	loginBanner = banner
 }
 
 func (c *Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.ServerConfig) {
@@ -352,6 +424,10 @@ func (c *Configuration) configureKeyboardInteractiveAuth(serverConfig *ssh.Serve
 }
 
 func canAcceptConnection(ip string) bool {
+	if err := common.Config.ExecutePreConnectHook(ip, common.ProtocolSSH); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused by pre connect hook, ip %#v", ip)
+		return false
+	}
 	if common.IsBanned(ip) {
 		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused, ip %#v is banned", ip)
 		return false
@@ -360,6 +436,10 @@ func canAcceptConnection(ip string) bool {
 		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused, configured limit reached")
 		return false
 	}
+	if err := common.CheckLoad(); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolSSH, "", "connection refused: %v", err)
+		return false
+	}
 	_, err := common.LimitRate(common.ProtocolSSH, ip)
 	if err != nil {
 		return false
@@ -371,7 +451,8 @@ func canAcceptConnection(ip string) bool {
 }
 
 // AcceptInboundConnection handles an inbound connection to the server instance and determines if the request should be served or not.
-func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig) {
+// The binding overrides, if any, are applied to the connection
+func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.ServerConfig, binding Binding) {
 	defer func() {
 		if r := recover(); r != nil {
 			logger.Error(logSender, "", "panic in AcceptInboundConnection: %#v stack strace: %v", r, string(debug.Stack()))
@@ -429,6 +510,11 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 
 	go ssh.DiscardRequests(reqs)
 
+	enabledSSHCommands := c.EnabledSSHCommands
+	if len(binding.EnabledSSHCommands) > 0 {
+		enabledSSHCommands = binding.EnabledSSHCommands
+	}
+
 	channelCounter := int64(0)
 	for newChannel := range chans {
 		// If its not a session channel we just move on because its not something we
@@ -465,6 +551,10 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 							RemoteAddr:     conn.RemoteAddr(),
 							channel:        channel,
 						}
+						connection.SetRemoteAddress(conn.RemoteAddr().String())
+						if binding.UploadMode != nil {
+							connection.SetUploadMode(*binding.UploadMode)
+						}
 						go c.handleSftpConnection(channel, &connection)
 					}
 				case "exec":
@@ -475,7 +565,11 @@ func (c *Configuration) AcceptInboundConnection(conn net.Conn, config *ssh.Serve
 						RemoteAddr:     conn.RemoteAddr(),
 						channel:        channel,
 					}
-					ok = processSSHCommand(req.Payload, &connection, c.EnabledSSHCommands)
+					connection.SetRemoteAddress(conn.RemoteAddr().String())
+					if binding.UploadMode != nil {
+						connection.SetUploadMode(*binding.UploadMode)
+					}
+					ok = processSSHCommand(req.Payload, &connection, enabledSSHCommands)
 				}
 				if req.WantReply {
 					req.Reply(ok, nil) //nolint:errcheck
@@ -498,7 +592,7 @@ func (c *Configuration) handleSftpConnection(channel ssh.Channel, connection *Co
 	handler := c.createHandler(connection)
 
 	// Create the server instance for the channel using the handler we created above.
-	server := sftp.NewRequestServer(channel, handler, sftp.WithRSAllocator())
+	server := sftp.NewRequestServer(newCheckFileChannel(channel, connection), handler, sftp.WithRSAllocator())
 
 	defer server.Close()
 	if err := server.Serve(); err == io.EOF {
@@ -592,12 +686,16 @@ func loginUser(user *dataprovider.User, loginMethod, publicKey string, conn ssh.
 }
 
 func (c *Configuration) checkSSHCommands() {
-	if utils.IsStringInSlice("*", c.EnabledSSHCommands) {
-		c.EnabledSSHCommands = GetSupportedSSHCommands()
-		return
+	c.EnabledSSHCommands = getEnabledSSHCommands(c.EnabledSSHCommands)
+}
+
+// getEnabledSSHCommands expands "*" and removes the unsupported SSH commands
+func getEnabledSSHCommands(commands []string) []string {
+	if utils.IsStringInSlice("*", commands) {
+		return GetSupportedSSHCommands()
 	}
 	sshCommands := []string{}
-	for _, command := range c.EnabledSSHCommands {
+	for _, command := range commands {
 		if utils.IsStringInSlice(command, supportedSSHCommands) {
 			sshCommands = append(sshCommands, command)
 		} else {
@@ -605,7 +703,7 @@ func (c *Configuration) checkSSHCommands() {
 			logger.WarnToConsole("unsupported ssh command: %#v ignored", command)
 		}
 	}
-	c.EnabledSSHCommands = sshCommands
+	return sshCommands
 }
 
 func (c *Configuration) generateDefaultHostKeys(configDir string) error {
@@ -779,6 +877,9 @@ func (c *Configuration) validatePublicKeyCredentials(conn ssh.ConnMetadata, pubK
 	connectionID := hex.EncodeToString(conn.SessionID())
 	method := dataprovider.SSHLoginMethodPublicKey
 	ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	if err := common.LimitAuthRate(common.ProtocolSSH, ipAddr); err != nil {
+		return nil, err
+	}
 	cert, ok := pubKey.(*ssh.Certificate)
 	if ok {
 		if cert.CertType != ssh.UserCert {
@@ -832,6 +933,9 @@ func (c *Configuration) validatePasswordCredentials(conn ssh.ConnMetadata, pass
 		method = dataprovider.SSHLoginMethodKeyAndPassword
 	}
 	ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	if err := common.LimitAuthRate(common.ProtocolSSH, ipAddr); err != nil {
+		return nil, err
+	}
 	if user, err = dataprovider.CheckUserAndPass(conn.User(), string(pass), ipAddr, common.ProtocolSSH); err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
 	}
@@ -850,6 +954,9 @@ func (c *Configuration) validateKeyboardInteractiveCredentials(conn ssh.ConnMeta
 		method = dataprovider.SSHLoginMethodKeyAndKeyboardInt
 	}
 	ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	if err := common.LimitAuthRate(common.ProtocolSSH, ipAddr); err != nil {
+		return nil, err
+	}
 	if user, err = dataprovider.CheckKeyboardInteractiveAuth(conn.User(), c.KeyboardInteractiveHook, client,
 		ipAddr, common.ProtocolSSH); err == nil {
 		sshPerm, err = loginUser(&user, method, "", conn)
@@ -2515,6 +2515,93 @@ func TestLoginExternalAuthPwdAndPubKey(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestHooksProviderActions(t *testing.T) {
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	usePubKey := false
+	u := getTestUser(usePubKey)
+	actionsPath := filepath.Join(homeBasePath, "provider_actions.sh")
+	actionsOutput := filepath.Join(homeBasePath, "provider_actions.out")
+	err := os.WriteFile(actionsPath, []byte(fmt.Sprintf("#!/bin/sh\n\necho \"$SFTPGO_PROVIDER_ACTION $SFTPGO_PROVIDER_OBJECT_NAME\" >> %v\n",
+		actionsOutput)), os.ModePerm)
+	assert.NoError(t, err)
+	getActions := func() string {
+		data, err := os.ReadFile(actionsOutput)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf := config.GetProviderConf()
+	providerConf.Actions.ExecuteOn = []string{"add", "update"}
+	providerConf.Actions.Hook = actionsPath
+	err = os.WriteFile(extAuthPath, getExtAuthScriptContent(u, false, false, ""), os.ModePerm)
+	assert.NoError(t, err)
+	providerConf.ExternalAuthHook = extAuthPath
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	// the user added and then updated by the external auth hook fires the actions
+	for i := 0; i < 2; i++ {
+		conn, client, err := getSftpClient(u, usePubKey)
+		if assert.NoError(t, err) {
+			assert.NoError(t, checkBasicSFTP(client))
+			client.Close()
+			conn.Close()
+		}
+	}
+	assert.Eventually(t, func() bool {
+		return getActions() == fmt.Sprintf("add %v\nupdate %v\n", defaultUsername, defaultUsername)
+	}, 2*time.Second, 100*time.Millisecond)
+	err = os.Remove(actionsOutput)
+	assert.NoError(t, err)
+
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	providerConf.ExternalAuthHook = ""
+	err = os.WriteFile(preLoginPath, getPreLoginScriptContent(u, false), os.ModePerm)
+	assert.NoError(t, err)
+	providerConf.PreLoginHook = preLoginPath
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	// the user updated by the pre-login hook fires the update action
+	conn, client, err := getSftpClient(u, usePubKey)
+	if assert.NoError(t, err) {
+		assert.NoError(t, checkBasicSFTP(client))
+		client.Close()
+		conn.Close()
+	}
+	assert.Eventually(t, func() bool {
+		return getActions() == fmt.Sprintf("update %v\n", defaultUsername)
+	}, 2*time.Second, 100*time.Millisecond)
+
+	user, _, err := httpdtest.GetUserByUsername(defaultUsername, http.StatusOK)
+	assert.NoError(t, err)
+	_, err = httpdtest.RemoveUser(user, http.StatusOK)
+	assert.NoError(t, err)
+	err = os.RemoveAll(user.GetHomeDir())
+	assert.NoError(t, err)
+	err = dataprovider.Close()
+	assert.NoError(t, err)
+	err = config.LoadConfig(configDir, "")
+	assert.NoError(t, err)
+	providerConf = config.GetProviderConf()
+	err = dataprovider.Initialize(providerConf, configDir, true)
+	assert.NoError(t, err)
+	err = os.Remove(extAuthPath)
+	assert.NoError(t, err)
+	err = os.Remove(preLoginPath)
+	assert.NoError(t, err)
+	err = os.Remove(actionsPath)
+	assert.NoError(t, err)
+	err = os.Remove(actionsOutput)
+	assert.NoError(t, err)
+}
+
 func TestExternalAuthEmptyResponse(t *testing.T) {
 	if runtime.GOOS == osWindows {
 		t.Skip("this test is not available on Windows")
@@ -1,10 +1,6 @@
 package sftpd
 
 import (
-	"crypto/md5"
-	"crypto/sha1"
-	"crypto/sha256"
-	"crypto/sha512"
 	"errors"
 	"fmt"
 	"hash"
@@ -250,21 +246,14 @@ func (c *sshCommand) updateQuota(sshDestPath string, filesNum int, filesSize int
 	} else {
 		dataprovider.UpdateUserQuota(&c.connection.User, filesNum, filesSize, false) //nolint:errcheck
 	}
+	if len(c.connection.User.Filters.DirQuotas) > 0 {
+		// the affected directory could contain other directories with quota restrictions
+		dataprovider.ResetUserDirQuotas(c.connection.User.Username)
+	}
 }
 
 func (c *sshCommand) handleHashCommands() error {
-	var h hash.Hash
-	if c.command == "md5sum" {
-		h = md5.New()
-	} else if c.command == "sha1sum" {
-		h = sha1.New()
-	} else if c.command == "sha256sum" {
-		h = sha256.New()
-	} else if c.command == "sha384sum" {
-		h = sha512.New384()
-	} else {
-		h = sha512.New()
-	}
+	h := getHashForAlgorithm(strings.TrimSuffix(c.command, "sum"))
 	var response string
 	if len(c.args) == 0 {
 		// without args we need to read the string to hash from stdin
@@ -288,7 +277,13 @@ func (c *sshCommand) handleHashCommands() error {
 		if !c.connection.User.HasPerm(dataprovider.PermListItems, sshPath) {
 			return c.sendErrorResponse(c.connection.GetPermissionDeniedError())
 		}
-		hash, err := c.computeHashForFile(fs, h, fsPath)
+		hash := ""
+		if c.command == "sha256sum" {
+			hash = c.connection.getStoredChecksum(fs, fsPath)
+		}
+		if hash == "" {
+			hash, err = computeHashForFile(fs, h, fsPath)
+		}
 		if err != nil {
 			return c.sendErrorResponse(c.connection.GetFsError(fs, err))
 		}
@@ -735,6 +730,8 @@ func (c *sshCommand) sendExitStatus(err error) {
 	// for scp we notify single uploads/downloads
 	if c.command != scpCmdName {
 		metrics.SSHCommandCompleted(err)
+		virtualPath := cmdPath
+		virtualTarget := targetPath
 		if cmdPath != "" {
 			_, p, errFs := c.connection.GetFsAndResolvedPath(cmdPath)
 			if errFs == nil {
@@ -747,11 +744,12 @@ func (c *sshCommand) sendExitStatus(err error) {
 				targetPath = p
 			}
 		}
-		common.SSHCommandActionNotification(&c.connection.User, cmdPath, targetPath, c.command, err)
+		common.SSHCommandActionNotification(&c.connection.User, virtualPath, virtualTarget, cmdPath, targetPath,
+			c.command, err)
 	}
 }
 
-func (c *sshCommand) computeHashForFile(fs vfs.Fs, hasher hash.Hash, path string) (string, error) {
+func computeHashForFile(fs vfs.Fs, hasher hash.Hash, path string) (string, error) {
 	hash := ""
 	f, r, _, err := fs.Open(path, 0)
 	if err != nil {
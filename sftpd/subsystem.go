@@ -54,7 +54,7 @@ func ServeSubSystemConnection(user *dataprovider.User, connectionID string, read
 	common.Connections.Add(connection)
 	defer common.Connections.Remove(connection.GetID())
 
-	server := sftp.NewRequestServer(connection.channel, sftp.Handlers{
+	server := sftp.NewRequestServer(newCheckFileChannel(connection.channel, connection), sftp.Handlers{
 		FileGet:  connection,
 		FilePut:  connection,
 		FileCmd:  connection,
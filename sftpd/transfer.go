@@ -4,10 +4,12 @@ import (
 	"fmt"
 	"io"
 	"sync/atomic"
+	"time"
 
 	"github.com/eikenb/pipeat"
 
 	"github.com/drakkan/sftpgo/common"
+	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
 	"github.com/drakkan/sftpgo/vfs"
 )
@@ -17,6 +19,11 @@ type writerAtCloser interface {
 	io.Closer
 }
 
+// rangeReadMinGap defines the minimum gap, between a read offset and the highest offset
+// read so far, that causes a cloud download to restart from the requested offset.
+// Smaller gaps are expected for pipelined sequential reads
+const rangeReadMinGap = 8 * 1024 * 1024
+
 type readerAtCloser interface {
 	io.ReaderAt
 	io.Closer
@@ -45,6 +52,11 @@ type transfer struct {
 	writerAt   writerAtCloser
 	readerAt   readerAtCloser
 	isFinished bool
+	// for downloads from cloud backends we can restart the download from the
+	// requested offset, using a ranged request, for random access reads
+	isPipeReader  bool
+	readerOffset  int64
+	maxReadOffset int64
 }
 
 func newTransfer(baseTransfer *common.BaseTransfer, pipeWriter *vfs.PipeWriter, pipeReader *pipeat.PipeReaderAt,
@@ -84,6 +96,7 @@ func newTransfer(baseTransfer *common.BaseTransfer, pipeWriter *vfs.PipeWriter,
 		writerAt:     writer,
 		readerAt:     reader,
 		isFinished:   false,
+		isPipeReader: baseTransfer.File == nil && pipeReader != nil && errForRead == nil,
 	}
 }
 
@@ -92,8 +105,19 @@ func newTransfer(baseTransfer *common.BaseTransfer, pipeWriter *vfs.PipeWriter,
 func (t *transfer) ReadAt(p []byte, off int64) (n int, err error) {
 	t.Connection.UpdateLastActivity()
 
-	n, err = t.readerAt.ReadAt(p, off)
+	reader, readerOffset, err := t.getReaderAt(off, len(p))
+	if err == nil {
+		n, err = reader.ReadAt(p, off-readerOffset)
+		if err != nil && err != io.EOF && t.isReaderChanged(reader) {
+			// the reader was closed by a concurrent range request, try again
+			reader, readerOffset, err = t.getReaderAt(off, len(p))
+			if err == nil {
+				n, err = reader.ReadAt(p, off-readerOffset)
+			}
+		}
+	}
 	atomic.AddInt64(&t.BytesSent, int64(n))
+	t.UpdateChecksum(p[:n], off)
 
 	if err != nil && err != io.EOF {
 		if t.GetType() == common.TransferDownload {
@@ -105,6 +129,54 @@ func (t *transfer) ReadAt(p []byte, off int64) (n int, err error) {
 	return
 }
 
+// getReaderAt returns the reader to use for the specified offset and the offset where
+// the reader starts. For cloud downloads the download is restarted from the requested
+// offset if it is not available in the current reader and it is not expected soon
+func (t *transfer) getReaderAt(off int64, size int) (readerAtCloser, int64, error) {
+	if !t.isPipeReader {
+		return t.readerAt, 0, nil
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	if off >= t.readerOffset && off <= t.maxReadOffset+rangeReadMinGap {
+		if off+int64(size) > t.maxReadOffset {
+			t.maxReadOffset = off + int64(size)
+		}
+		return t.readerAt, t.readerOffset, nil
+	}
+	t.Connection.Log(logger.LevelDebug, "restarting download from offset %v, current reader offset: %v, max read offset: %v",
+		off, t.readerOffset, t.maxReadOffset)
+	info, err := t.Fs.Stat(t.GetFsPath())
+	if err != nil {
+		return nil, 0, err
+	}
+	if off >= info.Size() {
+		// range requests beyond the end of the file are not allowed
+		return nil, 0, io.EOF
+	}
+	_, r, cancelFn, err := t.Fs.Open(t.GetFsPath(), off)
+	if err != nil {
+		return nil, 0, err
+	}
+	t.readerAt.Close() //nolint:errcheck
+	t.readerAt = r
+	t.readerOffset = off
+	t.maxReadOffset = off + int64(size)
+	t.SetCancelFn(cancelFn)
+	return t.readerAt, t.readerOffset, nil
+}
+
+func (t *transfer) isReaderChanged(reader readerAtCloser) bool {
+	if !t.isPipeReader {
+		return false
+	}
+	t.Lock()
+	defer t.Unlock()
+
+	return reader != t.readerAt
+}
+
 // WriteAt writes len(p) bytes to the uploaded file starting at byte offset off and updates the bytes received.
 // It handles upload bandwidth throttling too
 func (t *transfer) WriteAt(p []byte, off int64) (n int, err error) {
@@ -117,6 +189,7 @@ func (t *transfer) WriteAt(p []byte, off int64) (n int, err error) {
 
 	n, err = t.writerAt.WriteAt(p, off)
 	atomic.AddInt64(&t.BytesReceived, int64(n))
+	t.UpdateChecksum(p[:n], off)
 
 	if t.MaxWriteSize > 0 && err == nil && atomic.LoadInt64(&t.BytesReceived) > t.MaxWriteSize {
 		err = common.ErrQuotaExceeded
@@ -224,7 +297,8 @@ func (t *transfer) copyFromReaderToWriter(dst io.Writer, src io.Reader) (int64,
 	}
 	t.ErrTransfer = err
 	if written > 0 || err != nil {
-		metrics.TransferCompleted(atomic.LoadInt64(&t.BytesSent), atomic.LoadInt64(&t.BytesReceived), t.GetType(), t.ErrTransfer)
+		metrics.TransferCompleted(t.Connection.GetUsername(), atomic.LoadInt64(&t.BytesSent), atomic.LoadInt64(&t.BytesReceived), t.GetType(), t.ErrTransfer)
+		metrics.TransferFinished(t.Connection.GetProtocol(), t.GetType(), written, time.Since(t.GetStartTime()), t.ErrTransfer)
 	}
 	return written, err
 }
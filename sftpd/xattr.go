@@ -0,0 +1,29 @@
+package sftpd
+
+import (
+	"github.com/pkg/sftp"
+
+	"github.com/drakkan/sftpgo/vfs"
+)
+
+// sshFileXferAttrExtended is the SFTP attribute flag for extended attributes
+const sshFileXferAttrExtended = 0x80000000
+
+// getXattrsFromExtended returns the extended attributes and POSIX ACLs to set
+// from the SFTP extended attributes. The extension type is the attribute name,
+// for example "user.comment" or "system.posix_acl_access", and an empty value
+// removes the attribute. Unknown extension types are ignored
+func getXattrsFromExtended(extended []sftp.StatExtended) map[string][]byte {
+	result := make(map[string][]byte)
+	for _, ext := range extended {
+		if vfs.ValidateXattr(ext.ExtType, nil) != nil {
+			continue
+		}
+		if ext.ExtData == "" {
+			result[ext.ExtType] = nil
+		} else {
+			result[ext.ExtType] = []byte(ext.ExtData)
+		}
+	}
+	return result
+}
@@ -0,0 +1,209 @@
+// Package syslogclient implements a minimal syslog client able to send RFC 5424
+// messages using UDP, TCP or TLS. It is used to send the actions notifications
+package syslogclient
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Supported severities
+const (
+	SeverityError   = 3
+	SeverityWarning = 4
+	SeverityInfo    = 6
+)
+
+// Supported networks
+const (
+	NetworkUDP = "udp"
+	NetworkTCP = "tcp"
+)
+
+const nilValue = "-"
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// GetFacility returns the facility code for the given name, for example "local0"
+func GetFacility(name string) (int, error) {
+	facility, ok := facilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unsupported syslog facility %#v", name)
+	}
+	return facility, nil
+}
+
+// Options defines the options to connect to a syslog server
+type Options struct {
+	// NetworkUDP or NetworkTCP
+	Network string
+	// Server address as host:port
+	Address string
+	// TLS configuration, used for TCP only, nil means plain TCP
+	TLSConfig *tls.Config
+	// Timeout for the connection and the write. 0 means no timeout
+	Timeout time.Duration
+}
+
+// SDParam defines a structured data parameter
+type SDParam struct {
+	Name  string
+	Value string
+}
+
+// SDElement defines a structured data element
+type SDElement struct {
+	ID     string
+	Params []SDParam
+}
+
+// Message defines an RFC 5424 syslog message
+type Message struct {
+	Facility       int
+	Severity       int
+	Timestamp      time.Time
+	Hostname       string
+	AppName        string
+	ProcID         string
+	MsgID          string
+	StructuredData []SDElement
+	Message        string
+}
+
+// Format returns the message formatted as defined in RFC 5424
+func (m *Message) Format() []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<%d>1 ", m.Facility*8+m.Severity)
+	if m.Timestamp.IsZero() {
+		sb.WriteString(nilValue)
+	} else {
+		sb.WriteString(m.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"))
+	}
+	for _, field := range []struct {
+		value  string
+		maxLen int
+	}{
+		{m.Hostname, 255},
+		{m.AppName, 48},
+		{m.ProcID, 128},
+		{m.MsgID, 32},
+	} {
+		sb.WriteByte(' ')
+		sb.WriteString(getHeaderField(field.value, field.maxLen))
+	}
+	sb.WriteByte(' ')
+	if len(m.StructuredData) == 0 {
+		sb.WriteString(nilValue)
+	}
+	for _, element := range m.StructuredData {
+		sb.WriteByte('[')
+		sb.WriteString(getSDName(element.ID))
+		for _, param := range element.Params {
+			sb.WriteByte(' ')
+			sb.WriteString(getSDName(param.Name))
+			sb.WriteString(`="`)
+			sb.WriteString(sdValueEscaper.Replace(param.Value))
+			sb.WriteByte('"')
+		}
+		sb.WriteByte(']')
+	}
+	if m.Message != "" {
+		sb.WriteByte(' ')
+		sb.WriteString(m.Message)
+	}
+	return []byte(sb.String())
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// getHeaderField returns a valid header field, only printable US-ASCII characters
+// are allowed, the other ones are replaced with "_"
+func getHeaderField(value string, maxLen int) string {
+	if value == "" {
+		return nilValue
+	}
+	field := []byte(value)
+	if len(field) > maxLen {
+		field = field[:maxLen]
+	}
+	for idx, c := range field {
+		if c < 33 || c > 126 {
+			field[idx] = '_'
+		}
+	}
+	return string(field)
+}
+
+// getSDName returns a valid SD-ID or PARAM-NAME
+func getSDName(name string) string {
+	field := []byte(getHeaderField(name, 32))
+	for idx, c := range field {
+		if c == '=' || c == ']' || c == '"' {
+			field[idx] = '_'
+		}
+	}
+	return string(field)
+}
+
+// Send sends the given message. The TCP messages are framed using the octet
+// counting method as defined in RFC 5425
+func Send(opts *Options, msg *Message) error {
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{
+		Timeout: opts.Timeout,
+	}
+	switch opts.Network {
+	case NetworkUDP:
+		conn, err = dialer.Dial("udp", opts.Address)
+	case NetworkTCP:
+		if opts.TLSConfig != nil {
+			conn, err = tls.DialWithDialer(dialer, "tcp", opts.Address, opts.TLSConfig)
+		} else {
+			conn, err = dialer.Dial("tcp", opts.Address)
+		}
+	default:
+		return errors.New("unsupported network")
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if opts.Timeout > 0 {
+		if err := conn.SetDeadline(time.Now().Add(opts.Timeout)); err != nil {
+			return err
+		}
+	}
+	data := msg.Format()
+	if opts.Network == NetworkTCP {
+		data = append([]byte(fmt.Sprintf("%d ", len(data))), data...)
+	}
+	_, err = conn.Write(data)
+	return err
+}
@@ -0,0 +1,131 @@
+package syslogclient
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	msg := &Message{
+		Facility:  16,
+		Severity:  SeverityInfo,
+		Timestamp: time.Date(2021, 5, 20, 10, 11, 12, 123456000, time.UTC),
+		Hostname:  "host name",
+		AppName:   "sftpgo",
+		ProcID:    "1234",
+		MsgID:     "upload",
+		StructuredData: []SDElement{
+			{
+				ID: "sftpgo@32473",
+				Params: []SDParam{
+					{Name: "username", Value: "user"},
+					{Name: "path", Value: `/dir/file"[1]\.txt`},
+				},
+			},
+		},
+		Message: "upload completed",
+	}
+	assert.Equal(t, `<134>1 2021-05-20T10:11:12.123456Z host_name sftpgo 1234 upload [sftpgo@32473 username="user" `+
+		`path="/dir/file\"[1\]\\.txt"] upload completed`, string(msg.Format()))
+
+	msg = &Message{
+		Facility: 1,
+		Severity: SeverityError,
+		MsgID:    strings.Repeat("a", 40),
+	}
+	assert.Equal(t, "<11>1 - - - - "+strings.Repeat("a", 32)+" -", string(msg.Format()))
+}
+
+func TestGetFacility(t *testing.T) {
+	facility, err := GetFacility("LOCAL7")
+	assert.NoError(t, err)
+	assert.Equal(t, 23, facility)
+	_, err = GetFacility("unknown")
+	assert.Error(t, err)
+}
+
+func TestSendUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	msg := &Message{
+		Facility: 16,
+		Severity: SeverityWarning,
+		Message:  "test message",
+	}
+	err = Send(&Options{
+		Network: NetworkUDP,
+		Address: conn.LocalAddr().String(),
+		Timeout: 5 * time.Second,
+	}, msg)
+	require.NoError(t, err)
+	err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	require.NoError(t, err)
+	buf := make([]byte, 1024)
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, string(msg.Format()), string(buf[:n]))
+}
+
+func TestSendTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			return
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil {
+			return
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return
+		}
+		received <- string(data)
+	}()
+
+	msg := &Message{
+		Facility: 16,
+		Severity: SeverityInfo,
+		Message:  "test message",
+	}
+	err = Send(&Options{
+		Network: NetworkTCP,
+		Address: listener.Addr().String(),
+		Timeout: 5 * time.Second,
+	}, msg)
+	require.NoError(t, err)
+	select {
+	case data := <-received:
+		assert.Equal(t, string(msg.Format()), data)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "message not received")
+	}
+
+	err = Send(&Options{
+		Network: "unix",
+		Address: listener.Addr().String(),
+	}, msg)
+	assert.Error(t, err)
+}
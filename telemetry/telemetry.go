@@ -16,6 +16,7 @@ import (
 
 	"github.com/drakkan/sftpgo/common"
 	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/metrics"
 	"github.com/drakkan/sftpgo/utils"
 )
 
@@ -63,6 +64,12 @@ type Conf struct {
 	// any invalid name will be silently ignored.
 	// The order matters, the ciphers listed first will be the preferred ones.
 	TLSCipherSuites []string `json:"tls_cipher_suites" mapstructure:"tls_cipher_suites"`
+	// Set to true to export the transfer metrics labeled by username
+	PerUserMetrics bool `json:"per_user_metrics" mapstructure:"per_user_metrics"`
+	// Maximum number of users with dedicated metrics, the transfers for the
+	// other users are reported using the "#other" username. This limits the
+	// metrics cardinality
+	PerUserMetricsLimit int `json:"per_user_metrics_limit" mapstructure:"per_user_metrics_limit"`
 }
 
 // ShouldBind returns true if there service must be started
@@ -87,6 +94,11 @@ func (c Conf) Initialize(configDir string) error {
 	}
 	certificateFile := getConfigPath(c.CertificateFile, configDir)
 	certificateKeyFile := getConfigPath(c.CertificateKeyFile, configDir)
+	if c.PerUserMetrics {
+		metrics.SetPerUserMetricsLimit(c.PerUserMetricsLimit)
+	} else {
+		metrics.SetPerUserMetricsLimit(0)
+	}
 	initializeRouter(c.EnableProfiler)
 	httpServer := &http.Server{
 		Handler:           router,
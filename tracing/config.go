@@ -0,0 +1,59 @@
+package tracing
+
+import (
+	"fmt"
+	"net"
+)
+
+const defaultServiceName = "sftpgo"
+
+// Config defines the configuration to export the tracing spans to an
+// OpenTelemetry collector, or any other OTLP compatible backend, using the
+// OTLP gRPC protocol
+type Config struct {
+	// Address of the OTLP gRPC endpoint as host:port, for example
+	// "127.0.0.1:4317". Leave empty to disable tracing
+	Endpoint string `json:"endpoint" mapstructure:"endpoint"`
+	// Set to true to connect to the endpoint without TLS
+	Insecure bool `json:"insecure" mapstructure:"insecure"`
+	// Service name reported in the exported spans
+	ServiceName string `json:"service_name" mapstructure:"service_name"`
+	// Fraction of the traces to sample, from 0 to 1. 1 means sample all
+	// the traces
+	SampleRatio float64 `json:"sample_ratio" mapstructure:"sample_ratio"`
+}
+
+func (c *Config) validate() error {
+	if _, _, err := net.SplitHostPort(c.Endpoint); err != nil {
+		return fmt.Errorf("invalid tracing endpoint %#v: %w", c.Endpoint, err)
+	}
+	if c.ServiceName == "" {
+		c.ServiceName = defaultServiceName
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("invalid tracing sample ratio %v, it must be between 0 and 1", c.SampleRatio)
+	}
+	return nil
+}
+
+// Attribute defines a key value pair to add to a span
+type Attribute struct {
+	key   string
+	value interface{}
+}
+
+// String returns a string span attribute
+func String(key, value string) Attribute {
+	return Attribute{
+		key:   key,
+		value: value,
+	}
+}
+
+// Int64 returns an int64 span attribute
+func Int64(key string, value int64) Attribute {
+	return Attribute{
+		key:   key,
+		value: value,
+	}
+}
@@ -0,0 +1,137 @@
+// +build !notracing
+
+// Package tracing provides OpenTelemetry tracing support, the spans are
+// exported to the configured OTLP endpoint
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/version"
+)
+
+const (
+	logSender  = "tracing"
+	tracerName = "github.com/drakkan/sftpgo"
+)
+
+var (
+	tracerMutex    sync.RWMutex
+	tracer         = trace.NewNoopTracerProvider().Tracer(tracerName)
+	tracerProvider *sdktrace.TracerProvider
+)
+
+func init() {
+	version.AddFeature("+tracing")
+}
+
+// Initialize starts exporting the spans to the configured OTLP endpoint.
+// Tracing is disabled if the endpoint is empty
+func Initialize(config Config) error {
+	Shutdown()
+	if config.Endpoint == "" {
+		return nil
+	}
+	if err := config.validate(); err != nil {
+		return err
+	}
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(config.Endpoint)}
+	if config.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return err
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceNameKey.String(config.ServiceName),
+		semconv.ServiceVersionKey.String(version.Get().Version),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+	)
+
+	tracerMutex.Lock()
+	defer tracerMutex.Unlock()
+
+	tracerProvider = provider
+	tracer = provider.Tracer(tracerName)
+	logger.Info(logSender, "", "tracing enabled, endpoint: %#v, sample ratio: %v", config.Endpoint, config.SampleRatio)
+	return nil
+}
+
+// Shutdown exports the pending spans and disables tracing
+func Shutdown() {
+	tracerMutex.Lock()
+	provider := tracerProvider
+	tracerProvider = nil
+	tracer = trace.NewNoopTracerProvider().Tracer(tracerName)
+	tracerMutex.Unlock()
+
+	if provider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := provider.Shutdown(ctx); err != nil {
+		logger.Warn(logSender, "", "unable to export the pending spans: %v", err)
+	}
+}
+
+// Span defines a tracing span, it must be ended calling End
+type Span struct {
+	span trace.Span
+}
+
+// Start starts a new span with the given name. The span is a child of the
+// span in ctx, if any. The returned context contains the new span and can
+// be used to start child spans
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	tracerMutex.RLock()
+	t := tracer
+	tracerMutex.RUnlock()
+
+	ctx, span := t.Start(ctx, name, trace.WithAttributes(convertAttributes(attrs)...))
+	return ctx, &Span{span: span}
+}
+
+// SetAttributes adds the given attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {
+	s.span.SetAttributes(convertAttributes(attrs)...)
+}
+
+// End ends the span, the error, if any, is recorded within the span
+func (s *Span) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+func convertAttributes(attrs []Attribute) []attribute.KeyValue {
+	result := make([]attribute.KeyValue, 0, len(attrs))
+	for _, attr := range attrs {
+		switch v := attr.value.(type) {
+		case string:
+			result = append(result, attribute.String(attr.key, v))
+		case int64:
+			result = append(result, attribute.Int64(attr.key, v))
+		}
+	}
+	return result
+}
@@ -0,0 +1,40 @@
+// +build notracing
+
+package tracing
+
+import (
+	"context"
+	"errors"
+
+	"github.com/drakkan/sftpgo/version"
+)
+
+func init() {
+	version.AddFeature("-tracing")
+}
+
+// Initialize starts exporting the spans to the configured OTLP endpoint.
+// An error is returned if tracing is enabled, the tracing support is disabled
+func Initialize(config Config) error {
+	if config.Endpoint != "" {
+		return errors.New("unable to enable tracing, tracing support is disabled")
+	}
+	return nil
+}
+
+// Shutdown exports the pending spans and disables tracing
+func Shutdown() {}
+
+// Span defines a tracing span, it must be ended calling End
+type Span struct{}
+
+// Start starts a new span with the given name
+func Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, *Span) {
+	return ctx, &Span{}
+}
+
+// SetAttributes adds the given attributes to the span
+func (s *Span) SetAttributes(attrs ...Attribute) {}
+
+// End ends the span
+func (s *Span) End(err error) {}
@@ -0,0 +1,59 @@
+// +build !notracing
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitializeErrors(t *testing.T) {
+	err := Initialize(Config{})
+	assert.NoError(t, err)
+
+	err = Initialize(Config{
+		Endpoint: "127.0.0.1",
+	})
+	assert.Error(t, err)
+
+	err = Initialize(Config{
+		Endpoint:    "127.0.0.1:4317",
+		SampleRatio: 1.5,
+	})
+	assert.Error(t, err)
+}
+
+func TestInitializeAndShutdown(t *testing.T) {
+	err := Initialize(Config{
+		Endpoint:    "127.0.0.1:4317",
+		Insecure:    true,
+		SampleRatio: 0,
+	})
+	require.NoError(t, err)
+	tracerMutex.RLock()
+	assert.NotNil(t, tracerProvider)
+	tracerMutex.RUnlock()
+
+	ctx, span := Start(context.Background(), "parent", String("username", "user"), Int64("size", 10))
+	_, child := Start(ctx, "child")
+	// the spans are not sampled, so nothing is exported to the unreachable endpoint
+	assert.True(t, child.span.SpanContext().IsValid())
+	assert.False(t, child.span.SpanContext().IsSampled())
+	assert.Equal(t, span.span.SpanContext().TraceID(), child.span.SpanContext().TraceID())
+	child.End(errors.New("child error"))
+	span.SetAttributes(String("protocol", "SSH"))
+	span.End(nil)
+
+	Shutdown()
+	tracerMutex.RLock()
+	assert.Nil(t, tracerProvider)
+	tracerMutex.RUnlock()
+	// tracing is now disabled, the spans are not recorded
+	_, span = Start(context.Background(), "disabled")
+	assert.False(t, span.span.IsRecording())
+	span.End(nil)
+}
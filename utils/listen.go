@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"errors"
+	"net"
+	"os"
+	"sync"
+)
+
+// InheritedListenersEnvVar defines the environment variable used to pass the number
+// of inherited listeners to the new process after a graceful restart.
+// The listeners are passed as file descriptors starting from 3
+const InheritedListenersEnvVar = "SFTPGO_LISTEN_FDS"
+
+var (
+	listenersMu     sync.Mutex
+	activeListeners []net.Listener
+)
+
+// Listen is like net.Listen but it returns, if any, the matching listener
+// passed by systemd using socket activation, or inherited from the previous
+// process after a graceful restart, instead of creating a new one.
+// The returned listener is tracked and it will be closed by CloseListeners
+func Listen(network, address string) (net.Listener, error) {
+	l := getSocketActivationListener(network, address, true)
+	if l == nil {
+		var err error
+		l, err = net.Listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	activeListeners = append(activeListeners, l)
+	return l, nil
+}
+
+// CloseListeners closes all the listeners created using Listen, so the
+// servers using them stop accepting new connections
+func CloseListeners() {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	for _, l := range activeListeners {
+		l.Close()
+	}
+	activeListeners = nil
+}
+
+// GetListenerFiles returns a duplicated file for each listener created using Listen.
+// The files can be passed to a child process that will use them as inherited listeners.
+// The unix domain sockets are not removed when the listeners are closed so they can
+// still be used by the child process
+func GetListenerFiles() ([]*os.File, error) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+
+	var files []*os.File
+	for _, l := range activeListeners {
+		var f *os.File
+		var err error
+		switch listener := l.(type) {
+		case *net.TCPListener:
+			f, err = listener.File()
+		case *net.UnixListener:
+			listener.SetUnlinkOnClose(false)
+			f, err = listener.File()
+		default:
+			err = errors.New("unsupported listener type")
+		}
+		if err != nil {
+			for _, file := range files {
+				file.Close()
+			}
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"net"
+	"strconv"
+	"sync"
+)
+
+var (
+	activationOnce      sync.Once
+	activationMu        sync.Mutex
+	activationListeners []net.Listener
+)
+
+// hasSocketActivationListener returns true if systemd passed a listener
+// matching the given network and address
+func hasSocketActivationListener(network, address string) bool {
+	return getSocketActivationListener(network, address, false) != nil
+}
+
+// getSocketActivationListener returns the socket activated listener for the given
+// network and address. If remove is true the returned listener is not returned again
+func getSocketActivationListener(network, address string, remove bool) net.Listener {
+	activationOnce.Do(func() {
+		activationListeners = getInheritedListeners()
+	})
+
+	activationMu.Lock()
+	defer activationMu.Unlock()
+
+	for idx, l := range activationListeners {
+		if isListenerForAddress(l, network, address) {
+			if remove {
+				activationListeners = append(activationListeners[:idx], activationListeners[idx+1:]...)
+			}
+			return l
+		}
+	}
+	return nil
+}
+
+func isListenerForAddress(l net.Listener, network, address string) bool {
+	switch addr := l.Addr().(type) {
+	case *net.UnixAddr:
+		return network == "unix" && addr.Name == address
+	case *net.TCPAddr:
+		if network != "tcp" && network != "tcp4" && network != "tcp6" {
+			return false
+		}
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return false
+		}
+		if p, err := strconv.Atoi(port); err != nil || p != addr.Port {
+			return false
+		}
+		// an unspecified address means listening on all the interfaces
+		if host == "" || addr.IP.IsUnspecified() {
+			return true
+		}
+		if ip := net.ParseIP(host); ip != nil {
+			return ip.Equal(addr.IP)
+		}
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			return false
+		}
+		for _, ip := range ips {
+			if ip.Equal(addr.IP) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setSocketActivationListeners(t *testing.T, listeners ...net.Listener) {
+	// make sure the inherited listeners are already loaded, so they are not overwritten
+	activationOnce.Do(func() {})
+
+	activationMu.Lock()
+	defer activationMu.Unlock()
+
+	oldListeners := activationListeners
+	t.Cleanup(func() {
+		activationMu.Lock()
+		defer activationMu.Unlock()
+
+		activationListeners = oldListeners
+	})
+	activationListeners = listeners
+}
+
+func TestIsListenerForAddress(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	port := l.Addr().(*net.TCPAddr).Port
+	for _, address := range []string{fmt.Sprintf("127.0.0.1:%d", port), fmt.Sprintf(":%d", port),
+		fmt.Sprintf("localhost:%d", port)} {
+		assert.True(t, isListenerForAddress(l, "tcp", address), address)
+		assert.True(t, isListenerForAddress(l, "tcp4", address), address)
+	}
+	for _, address := range []string{fmt.Sprintf("127.0.0.2:%d", port), fmt.Sprintf("127.0.0.1:%d", port+1),
+		"127.0.0.1:port", "127.0.0.1"} {
+		assert.False(t, isListenerForAddress(l, "tcp", address), address)
+	}
+	assert.False(t, isListenerForAddress(l, "unix", fmt.Sprintf("127.0.0.1:%d", port)))
+	// a listener on all the interfaces matches any host
+	allInterfaces, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	defer allInterfaces.Close()
+
+	port = allInterfaces.Addr().(*net.TCPAddr).Port
+	assert.True(t, isListenerForAddress(allInterfaces, "tcp", fmt.Sprintf("127.0.0.1:%d", port)))
+	assert.True(t, isListenerForAddress(allInterfaces, "tcp", fmt.Sprintf("192.168.1.1:%d", port)))
+
+	if runtime.GOOS == osWindows {
+		t.Skip("this test is not available on Windows")
+	}
+	socketPath := filepath.Join(t.TempDir(), "test.sock")
+	unixListener, err := net.Listen("unix", socketPath)
+	require.NoError(t, err)
+	defer unixListener.Close()
+
+	assert.True(t, isListenerForAddress(unixListener, "unix", socketPath))
+	assert.False(t, isListenerForAddress(unixListener, "unix", socketPath+"1"))
+	assert.False(t, isListenerForAddress(unixListener, "tcp", socketPath))
+}
+
+func TestListenSocketActivation(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	address := l.Addr().String()
+	setSocketActivationListeners(t, l)
+	defer CloseListeners()
+
+	assert.True(t, hasSocketActivationListener("tcp", address))
+	assert.False(t, hasSocketActivationListener("unix", address))
+	// the socket activated listener is used instead of creating a new one
+	listener, err := Listen("tcp", address)
+	require.NoError(t, err)
+	assert.Equal(t, l, listener)
+	// and it is returned only once
+	assert.False(t, hasSocketActivationListener("tcp", address))
+	_, err = Listen("tcp", address)
+	assert.Error(t, err)
+
+	listener, err = Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.NotEqual(t, l, listener)
+	files, err := GetListenerFiles()
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+	for _, f := range files {
+		assert.NoError(t, f.Close())
+	}
+	CloseListeners()
+	// the listeners are closed
+	_, err = l.Accept()
+	assert.Error(t, err)
+	_, err = listener.Accept()
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+// +build !windows
+
+package utils
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// file descriptors passed by systemd start at 3, see sd_listen_fds(3).
+// The listeners inherited after a graceful restart use the same convention
+const listenFdsStart = 3
+
+// getInheritedListeners returns the listeners passed by systemd using socket activation
+// or inherited from the previous process after a graceful restart.
+// The environment variables used by the protocol are unset so they are not inherited by
+// child processes
+func getInheritedListeners() []net.Listener {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+		os.Unsetenv(InheritedListenersEnvVar)
+	}()
+
+	nfds, err := getInheritedFdsCount()
+	if err != nil || nfds <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var listeners []net.Listener
+	for fd := listenFdsStart; fd < listenFdsStart+nfds; fd++ {
+		syscall.CloseOnExec(fd)
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if idx := fd - listenFdsStart; idx < len(names) && names[idx] != "" {
+			name = names[idx]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			logger.Warn(logSender, "", "unable to use the socket activated file descriptor %v, name %#v: %v",
+				fd, name, err)
+			continue
+		}
+		logger.Info(logSender, "", "inherited listener %#v, address: %v", name, l.Addr().String())
+		listeners = append(listeners, l)
+	}
+	return listeners
+}
+
+func getInheritedFdsCount() (int, error) {
+	if val := os.Getenv(InheritedListenersEnvVar); val != "" {
+		return strconv.Atoi(val)
+	}
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil {
+		return 0, err
+	}
+	if pid != os.Getpid() {
+		return 0, nil
+	}
+	return strconv.Atoi(os.Getenv("LISTEN_FDS"))
+}
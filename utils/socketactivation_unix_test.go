@@ -0,0 +1,62 @@
+// +build !windows
+
+package utils
+
+import (
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setTestEnv(t *testing.T, key, value string) {
+	oldValue, ok := os.LookupEnv(key)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, oldValue)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+	os.Setenv(key, value)
+}
+
+func TestInheritedFdsCount(t *testing.T) {
+	setTestEnv(t, InheritedListenersEnvVar, "")
+	setTestEnv(t, "LISTEN_PID", "")
+	setTestEnv(t, "LISTEN_FDS", "2")
+	_, err := getInheritedFdsCount()
+	assert.Error(t, err)
+	// the file descriptors are for another process
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	nfds, err := getInheritedFdsCount()
+	require.NoError(t, err)
+	assert.Equal(t, 0, nfds)
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	nfds, err = getInheritedFdsCount()
+	require.NoError(t, err)
+	assert.Equal(t, 2, nfds)
+	// the listeners inherited after a graceful restart take precedence
+	os.Setenv(InheritedListenersEnvVar, "1")
+	nfds, err = getInheritedFdsCount()
+	require.NoError(t, err)
+	assert.Equal(t, 1, nfds)
+	os.Setenv(InheritedListenersEnvVar, "a")
+	_, err = getInheritedFdsCount()
+	assert.Error(t, err)
+}
+
+func TestInheritedListenersEnv(t *testing.T) {
+	setTestEnv(t, InheritedListenersEnvVar, "")
+	setTestEnv(t, "LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	setTestEnv(t, "LISTEN_FDS", "1")
+	setTestEnv(t, "LISTEN_FDNAMES", "sftp")
+	assert.Len(t, getInheritedListeners(), 0)
+	// the environment variables are not inherited by child processes
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", InheritedListenersEnvVar} {
+		_, ok := os.LookupEnv(key)
+		assert.False(t, ok, key)
+	}
+}
@@ -0,0 +1,8 @@
+package utils
+
+import "net"
+
+// getInheritedListeners returns nil, inherited listeners are not supported on Windows
+func getInheritedListeners() []net.Listener {
+	return nil
+}
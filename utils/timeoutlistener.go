@@ -2,6 +2,7 @@ package utils
 
 import (
 	"net"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -10,6 +11,7 @@ type listener struct {
 	net.Listener
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+	ConnCheck    func(net.Conn) error
 }
 
 func (l *listener) Accept() (net.Conn, error) {
@@ -25,6 +27,7 @@ func (l *listener) Accept() (net.Conn, error) {
 		WriteThreshold:           int32((l.WriteTimeout * 1024) / time.Second),
 		BytesReadFromDeadline:    0,
 		BytesWrittenFromDeadline: 0,
+		connCheck:                l.ConnCheck,
 	}
 	return tc, nil
 }
@@ -39,9 +42,32 @@ type Conn struct {
 	WriteThreshold           int32
 	BytesReadFromDeadline    int32
 	BytesWrittenFromDeadline int32
+	connCheck                func(net.Conn) error
+	connCheckOnce            sync.Once
+	connCheckErr             error
+}
+
+// checkConn executes the connection check, if any, only once.
+// It is called before the first read/write so it runs in the
+// goroutine handling the connection and not in the accept loop.
+// Rejected connections are closed
+func (c *Conn) checkConn() error {
+	if c.connCheck == nil {
+		return nil
+	}
+	c.connCheckOnce.Do(func() {
+		c.connCheckErr = c.connCheck(c.Conn)
+		if c.connCheckErr != nil {
+			c.Conn.Close()
+		}
+	})
+	return c.connCheckErr
 }
 
 func (c *Conn) Read(b []byte) (n int, err error) {
+	if err = c.checkConn(); err != nil {
+		return 0, err
+	}
 	if atomic.LoadInt32(&c.BytesReadFromDeadline) > c.ReadThreshold {
 		atomic.StoreInt32(&c.BytesReadFromDeadline, 0)
 		// we set both read and write deadlines here otherwise after the request
@@ -57,6 +83,9 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 }
 
 func (c *Conn) Write(b []byte) (n int, err error) {
+	if err = c.checkConn(); err != nil {
+		return 0, err
+	}
 	if atomic.LoadInt32(&c.BytesWrittenFromDeadline) > c.WriteThreshold {
 		atomic.StoreInt32(&c.BytesWrittenFromDeadline, 0)
 		// we extend the read deadline too, not sure it's necessary,
@@ -71,8 +100,10 @@ func (c *Conn) Write(b []byte) (n int, err error) {
 	return
 }
 
-func newListener(network, addr string, readTimeout, writeTimeout time.Duration) (net.Listener, error) {
-	l, err := net.Listen(network, addr)
+func newListener(network, addr string, readTimeout, writeTimeout time.Duration,
+	connCheck func(net.Conn) error,
+) (net.Listener, error) {
+	l, err := Listen(network, addr)
 	if err != nil {
 		return nil, err
 	}
@@ -81,6 +112,7 @@ func newListener(network, addr string, readTimeout, writeTimeout time.Duration)
 		Listener:     l,
 		ReadTimeout:  readTimeout,
 		WriteTimeout: writeTimeout,
+		ConnCheck:    connCheck,
 	}
 	return tl, nil
 }
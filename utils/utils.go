@@ -413,6 +413,15 @@ func GenerateRandomBytes(length int) []byte {
 // HTTPListenAndServe is a wrapper for ListenAndServe that support both tcp
 // and Unix-domain sockets
 func HTTPListenAndServe(srv *http.Server, address string, port int, isTLS bool, logSender string) error {
+	return HTTPListenAndServeWithConnCheck(srv, address, port, isTLS, logSender, nil)
+}
+
+// HTTPListenAndServeWithConnCheck is like HTTPListenAndServe but connCheck, if not nil,
+// is executed for each new TCP connection before reading any data, so before the
+// TLS handshake too. The connection is closed if connCheck returns an error
+func HTTPListenAndServeWithConnCheck(srv *http.Server, address string, port int, isTLS bool, logSender string,
+	connCheck func(net.Conn) error,
+) error {
 	var listener net.Listener
 	var err error
 
@@ -420,16 +429,20 @@ func HTTPListenAndServe(srv *http.Server, address string, port int, isTLS bool,
 		if !IsFileInputValid(address) {
 			return fmt.Errorf("invalid socket address %#v", address)
 		}
-		err = createDirPathIfMissing(address, os.ModePerm)
-		if err != nil {
-			logger.ErrorToConsole("error creating Unix-domain socket parent dir: %v", err)
-			logger.Error(logSender, "", "error creating Unix-domain socket parent dir: %v", err)
+		// the socket activated listeners are already bound
+		if !hasSocketActivationListener("unix", address) {
+			err = createDirPathIfMissing(address, os.ModePerm)
+			if err != nil {
+				logger.ErrorToConsole("error creating Unix-domain socket parent dir: %v", err)
+				logger.Error(logSender, "", "error creating Unix-domain socket parent dir: %v", err)
+			}
+			os.Remove(address)
 		}
-		os.Remove(address)
-		listener, err = newListener("unix", address, srv.ReadTimeout, srv.WriteTimeout)
+		listener, err = newListener("unix", address, srv.ReadTimeout, srv.WriteTimeout, nil)
 	} else {
 		CheckTCP4Port(port)
-		listener, err = newListener("tcp", fmt.Sprintf("%s:%d", address, port), srv.ReadTimeout, srv.WriteTimeout)
+		listener, err = newListener("tcp", fmt.Sprintf("%s:%d", address, port), srv.ReadTimeout, srv.WriteTimeout,
+			connCheck)
 	}
 	if err != nil {
 		return err
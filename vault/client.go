@@ -0,0 +1,216 @@
+// +build !novault
+
+package vault
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/hashicorp/vault/api"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/version"
+)
+
+func init() {
+	version.AddFeature("+vault")
+}
+
+type client struct {
+	config Config
+	api    *api.Client
+	done   chan bool
+	mu     sync.Mutex
+	// secrets read from Vault, by API path. A new client is created each time
+	// the configuration is loaded so the secrets are read again on reload
+	secrets map[string]map[string]interface{}
+}
+
+func newClient(config Config, configDir string) (*client, error) {
+	apiConfig := api.DefaultConfig()
+	if apiConfig.Error != nil {
+		return nil, apiConfig.Error
+	}
+	apiConfig.Address = config.Address
+	tlsConfig := &api.TLSConfig{
+		Insecure: config.SkipTLSVerify,
+	}
+	if config.CACertificate != "" {
+		tlsConfig.CACert = config.CACertificate
+		if !filepath.IsAbs(tlsConfig.CACert) {
+			tlsConfig.CACert = filepath.Join(configDir, tlsConfig.CACert)
+		}
+	}
+	if err := apiConfig.ConfigureTLS(tlsConfig); err != nil {
+		return nil, err
+	}
+	apiClient, err := api.NewClient(apiConfig)
+	if err != nil {
+		return nil, err
+	}
+	if config.Namespace != "" {
+		apiClient.SetNamespace(config.Namespace)
+	}
+	c := &client{
+		config:  config,
+		api:     apiClient,
+		done:    make(chan bool),
+		secrets: make(map[string]map[string]interface{}),
+	}
+	secret, err := c.login()
+	if err != nil {
+		return nil, err
+	}
+	c.startRenewal(secret)
+	return c, nil
+}
+
+// login authenticates using the configured method and returns the secret
+// to use to renew the token
+func (c *client) login() (*api.Secret, error) {
+	if c.config.AuthMethod == AuthMethodAppRole {
+		loginPath := fmt.Sprintf("auth/%s/login", c.config.AppRole.MountPath)
+		secret, err := c.api.Logical().Write(loginPath, map[string]interface{}{
+			"role_id":   c.config.AppRole.RoleID,
+			"secret_id": c.config.AppRole.SecretID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("approle login failed: %w", err)
+		}
+		if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+			return nil, errors.New("approle login failed: no token returned")
+		}
+		c.api.SetToken(secret.Auth.ClientToken)
+		return secret, nil
+	}
+	if c.config.Token != "" {
+		c.api.SetToken(c.config.Token)
+	}
+	if c.api.Token() == "" {
+		return nil, errors.New("no token configured")
+	}
+	secret, err := c.api.Auth().Token().LookupSelf()
+	if err != nil {
+		return nil, fmt.Errorf("unable to lookup the configured token: %w", err)
+	}
+	renewable, err := secret.TokenIsRenewable()
+	if err != nil || !renewable {
+		return secret, nil
+	}
+	// renewing the token we get the auth info required to keep renewing it
+	return c.api.Auth().Token().RenewSelf(0)
+}
+
+func (c *client) startRenewal(secret *api.Secret) {
+	if secret == nil || secret.Auth == nil || !secret.Auth.Renewable {
+		logger.Debug(logSender, "", "the vault token is not renewable")
+		return
+	}
+	watcher, err := c.api.NewLifetimeWatcher(&api.LifetimeWatcherInput{
+		Secret: secret,
+	})
+	if err != nil {
+		logger.Warn(logSender, "", "unable to renew the vault token: %v", err)
+		return
+	}
+	go watcher.Start()
+	go func() {
+		defer watcher.Stop()
+
+		for {
+			select {
+			case <-c.done:
+				return
+			case renewal := <-watcher.RenewCh():
+				logger.Debug(logSender, "", "vault token renewed at %v", renewal.RenewedAt)
+			case err := <-watcher.DoneCh():
+				if c.config.AuthMethod != AuthMethodAppRole {
+					logger.Warn(logSender, "", "the vault token cannot be renewed anymore: %v", err)
+					return
+				}
+				// the token reached its max TTL, login again
+				secret, err := c.login()
+				if err != nil {
+					logger.Warn(logSender, "", "unable to login again: %v", err)
+					return
+				}
+				c.startRenewal(secret)
+				return
+			}
+		}
+	}()
+}
+
+func (c *client) stop() {
+	close(c.done)
+}
+
+func (c *client) getSecret(secretPath, key string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dataPath := getKVDataPath(c.config.KVMountPath, secretPath)
+	data, ok := c.secrets[dataPath]
+	if !ok {
+		secret, err := c.api.Logical().Read(dataPath)
+		if err != nil {
+			return "", fmt.Errorf("vault: unable to read secret %#v: %w", secretPath, err)
+		}
+		if secret == nil || secret.Data == nil {
+			return "", fmt.Errorf("vault: secret %#v not found", secretPath)
+		}
+		data, ok = secret.Data["data"].(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("vault: secret %#v has no data, is it stored in a KV version 2 secrets engine?",
+				secretPath)
+		}
+		c.secrets[dataPath] = data
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("vault: key %#v not found in secret %#v", key, secretPath)
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprintf("%v", value), nil
+}
+
+func (c *client) transitEncrypt(keyName string, plaintext []byte) (string, error) {
+	secret, err := c.api.Logical().Write(getTransitPath(c.config.TransitMountPath, "encrypt", keyName),
+		map[string]interface{}{
+			"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+		})
+	if err != nil {
+		return "", fmt.Errorf("vault: unable to encrypt using the transit key %#v: %w", keyName, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return "", fmt.Errorf("vault: no ciphertext returned for the transit key %#v", keyName)
+	}
+	ciphertext, ok := secret.Data["ciphertext"].(string)
+	if !ok || ciphertext == "" {
+		return "", fmt.Errorf("vault: no ciphertext returned for the transit key %#v", keyName)
+	}
+	return ciphertext, nil
+}
+
+func (c *client) transitDecrypt(keyName, ciphertext string) ([]byte, error) {
+	secret, err := c.api.Logical().Write(getTransitPath(c.config.TransitMountPath, "decrypt", keyName),
+		map[string]interface{}{
+			"ciphertext": ciphertext,
+		})
+	if err != nil {
+		return nil, fmt.Errorf("vault: unable to decrypt using the transit key %#v: %w", keyName, err)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("vault: no plaintext returned for the transit key %#v", keyName)
+	}
+	plaintext, ok := secret.Data["plaintext"].(string)
+	if !ok {
+		return nil, fmt.Errorf("vault: no plaintext returned for the transit key %#v", keyName)
+	}
+	return base64.StdEncoding.DecodeString(plaintext)
+}
@@ -0,0 +1,33 @@
+// +build novault
+
+package vault
+
+import (
+	"errors"
+
+	"github.com/drakkan/sftpgo/version"
+)
+
+func init() {
+	version.AddFeature("-vault")
+}
+
+type client struct{}
+
+func newClient(config Config, configDir string) (*client, error) {
+	return nil, errors.New("Vault support disabled at build time")
+}
+
+func (c *client) stop() {}
+
+func (c *client) getSecret(secretPath, key string) (string, error) {
+	return "", ErrNotConfigured
+}
+
+func (c *client) transitEncrypt(keyName string, plaintext []byte) (string, error) {
+	return "", ErrNotConfigured
+}
+
+func (c *client) transitDecrypt(keyName, ciphertext string) ([]byte, error) {
+	return nil, ErrNotConfigured
+}
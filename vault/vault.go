@@ -0,0 +1,186 @@
+// Package vault allows to read secrets from a HashiCorp Vault KV version 2
+// secrets engine. The secrets are referenced inside the configuration using
+// placeholders and they are fetched when the configuration is loaded.
+// The transit secrets engine can be used to encrypt the KMS secrets
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const (
+	logSender = "vault"
+	// AuthMethodToken defines the token authentication method
+	AuthMethodToken = "token"
+	// AuthMethodAppRole defines the AppRole authentication method
+	AuthMethodAppRole = "approle"
+)
+
+var (
+	// ErrNotConfigured is returned if a secret is requested but Vault is not configured
+	ErrNotConfigured = errors.New("vault is not configured")
+	mu               sync.Mutex
+	activeClient     *client
+)
+
+// AppRoleConfig defines the configuration for the AppRole authentication method
+type AppRoleConfig struct {
+	// Path where the AppRole auth method is mounted, default "approle"
+	MountPath string `json:"mount_path" mapstructure:"mount_path"`
+	RoleID    string `json:"role_id" mapstructure:"role_id"`
+	SecretID  string `json:"secret_id" mapstructure:"secret_id"`
+}
+
+// Config defines the configuration to access the secrets stored in Vault
+type Config struct {
+	// Vault server address, for example "https://vault.example.com:8200".
+	// Empty means disabled
+	Address string `json:"address" mapstructure:"address"`
+	// Vault Enterprise namespace, optional
+	Namespace string `json:"namespace" mapstructure:"namespace"`
+	// Path where the KV version 2 secrets engine is mounted, default "secret"
+	KVMountPath string `json:"kv_mount_path" mapstructure:"kv_mount_path"`
+	// Path where the transit secrets engine is mounted, default "transit".
+	// It is used by the Vault KMS provider
+	TransitMountPath string `json:"transit_mount_path" mapstructure:"transit_mount_path"`
+	// Authentication method, "token" or "approle"
+	AuthMethod string `json:"auth_method" mapstructure:"auth_method"`
+	// Token for the token authentication method. If empty the VAULT_TOKEN
+	// environment variable is used
+	Token string `json:"token" mapstructure:"token"`
+	// Configuration for the AppRole authentication method
+	AppRole AppRoleConfig `json:"approle" mapstructure:"approle"`
+	// Path to a PEM encoded CA certificate to verify the Vault server certificate.
+	// If empty the system CAs are used
+	CACertificate string `json:"ca_certificate" mapstructure:"ca_certificate"`
+	// If enabled the Vault server certificate is not verified.
+	// This should be used only for testing
+	SkipTLSVerify bool `json:"skip_tls_verify" mapstructure:"skip_tls_verify"`
+}
+
+// IsEnabled returns true if a Vault server address is configured
+func (c *Config) IsEnabled() bool {
+	return c.Address != ""
+}
+
+func (c *Config) validate() error {
+	if c.KVMountPath == "" {
+		c.KVMountPath = "secret"
+	}
+	c.KVMountPath = strings.Trim(c.KVMountPath, "/")
+	if c.TransitMountPath == "" {
+		c.TransitMountPath = "transit"
+	}
+	c.TransitMountPath = strings.Trim(c.TransitMountPath, "/")
+	switch c.AuthMethod {
+	case AuthMethodToken:
+	case AuthMethodAppRole:
+		if c.AppRole.MountPath == "" {
+			c.AppRole.MountPath = "approle"
+		}
+		c.AppRole.MountPath = strings.Trim(c.AppRole.MountPath, "/")
+		if c.AppRole.RoleID == "" || c.AppRole.SecretID == "" {
+			return errors.New("vault: role_id and secret_id are required for the approle auth method")
+		}
+	default:
+		return fmt.Errorf("vault: unsupported auth method %#v", c.AuthMethod)
+	}
+	return nil
+}
+
+// Initialize configures the access to Vault and authenticates. The previous
+// configuration, if any, is replaced and its token is no longer renewed.
+// It does nothing if Vault is not enabled
+func (c *Config) Initialize(configDir string) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if activeClient != nil {
+		activeClient.stop()
+		activeClient = nil
+	}
+	if !c.IsEnabled() {
+		return nil
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	cl, err := newClient(*c, configDir)
+	if err != nil {
+		logger.Warn(logSender, "", "unable to initialize vault client: %v", err)
+		return fmt.Errorf("vault: %w", err)
+	}
+	activeClient = cl
+	logger.Debug(logSender, "", "vault client initialized, address %#v, auth method %#v", c.Address, c.AuthMethod)
+	return nil
+}
+
+// GetSecret returns the value for the specified key inside the secret at
+// secretPath. The path is relative to the KV secrets engine mount path
+func GetSecret(secretPath, key string) (string, error) {
+	cl := getActiveClient()
+	if cl == nil {
+		return "", ErrNotConfigured
+	}
+	return cl.getSecret(secretPath, key)
+}
+
+// IsConfigured returns true if the access to Vault is configured and the
+// authentication succeeded
+func IsConfigured() bool {
+	return getActiveClient() != nil
+}
+
+// TransitEncrypt encrypts the plaintext using the specified key of the transit
+// secrets engine and returns the ciphertext, for example "vault:v1:..."
+func TransitEncrypt(keyName string, plaintext []byte) (string, error) {
+	cl := getActiveClient()
+	if cl == nil {
+		return "", ErrNotConfigured
+	}
+	return cl.transitEncrypt(keyName, plaintext)
+}
+
+// TransitDecrypt decrypts the ciphertext using the specified key of the
+// transit secrets engine
+func TransitDecrypt(keyName, ciphertext string) ([]byte, error) {
+	cl := getActiveClient()
+	if cl == nil {
+		return nil, ErrNotConfigured
+	}
+	return cl.transitDecrypt(keyName, ciphertext)
+}
+
+func getActiveClient() *client {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return activeClient
+}
+
+// getKVDataPath returns the API path to read the secret at secretPath
+// from a KV version 2 secrets engine
+func getKVDataPath(mountPath, secretPath string) string {
+	return path.Join(mountPath, "data", strings.Trim(secretPath, "/"))
+}
+
+// getTransitPath returns the API path for the given transit secrets engine
+// operation, for example "encrypt", using the specified key
+func getTransitPath(mountPath, operation, keyName string) string {
+	return path.Join(mountPath, operation, strings.Trim(keyName, "/"))
+}
+
+// ParseReference parses a secret reference in the form "path#key"
+func ParseReference(reference string) (string, string, error) {
+	idx := strings.LastIndex(reference, "#")
+	if idx <= 0 || idx == len(reference)-1 {
+		return "", "", fmt.Errorf("invalid vault secret reference %#v, the format is \"path#key\"", reference)
+	}
+	return reference[:idx], reference[idx+1:], nil
+}
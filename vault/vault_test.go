@@ -0,0 +1,71 @@
+package vault
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReference(t *testing.T) {
+	secretPath, key, err := ParseReference("sftpgo/db#password")
+	assert.NoError(t, err)
+	assert.Equal(t, "sftpgo/db", secretPath)
+	assert.Equal(t, "password", key)
+	secretPath, key, err = ParseReference("sftpgo/a#b#key")
+	assert.NoError(t, err)
+	assert.Equal(t, "sftpgo/a#b", secretPath)
+	assert.Equal(t, "key", key)
+	for _, reference := range []string{"", "sftpgo/db", "#password", "sftpgo/db#"} {
+		_, _, err = ParseReference(reference)
+		assert.Error(t, err, reference)
+	}
+}
+
+func TestKVDataPath(t *testing.T) {
+	assert.Equal(t, "secret/data/sftpgo/db", getKVDataPath("secret", "sftpgo/db"))
+	assert.Equal(t, "kv/sftpgo/data/db", getKVDataPath("kv/sftpgo", "/db/"))
+}
+
+func TestTransitPath(t *testing.T) {
+	assert.Equal(t, "transit/encrypt/mykey", getTransitPath("transit", "encrypt", "mykey"))
+	assert.Equal(t, "kms/transit/decrypt/sftpgo/key", getTransitPath("kms/transit", "decrypt", "/sftpgo/key"))
+}
+
+func TestTransitNotConfigured(t *testing.T) {
+	c := Config{}
+	assert.NoError(t, c.Initialize("."))
+	assert.False(t, IsConfigured())
+	_, err := TransitEncrypt("mykey", []byte("payload"))
+	assert.ErrorIs(t, err, ErrNotConfigured)
+	_, err = TransitDecrypt("mykey", "vault:v1:ciphertext")
+	assert.ErrorIs(t, err, ErrNotConfigured)
+}
+
+func TestConfigValidation(t *testing.T) {
+	c := Config{}
+	assert.False(t, c.IsEnabled())
+	assert.NoError(t, c.Initialize("."))
+	_, err := GetSecret("sftpgo/db", "password")
+	assert.ErrorIs(t, err, ErrNotConfigured)
+
+	c.Address = "http://127.0.0.1:8200"
+	assert.True(t, c.IsEnabled())
+	assert.Error(t, c.validate())
+	c.AuthMethod = AuthMethodToken
+	c.KVMountPath = "/kv/"
+	assert.NoError(t, c.validate())
+	assert.Equal(t, "kv", c.KVMountPath)
+	c.KVMountPath = ""
+	assert.NoError(t, c.validate())
+	assert.Equal(t, "secret", c.KVMountPath)
+	assert.Equal(t, "transit", c.TransitMountPath)
+	c.TransitMountPath = "/kms/transit/"
+	assert.NoError(t, c.validate())
+	assert.Equal(t, "kms/transit", c.TransitMountPath)
+	c.AuthMethod = AuthMethodAppRole
+	assert.Error(t, c.validate())
+	c.AppRole.RoleID = "role"
+	c.AppRole.SecretID = "secret"
+	assert.NoError(t, c.validate())
+	assert.Equal(t, "approle", c.AppRole.MountPath)
+}
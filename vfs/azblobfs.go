@@ -106,7 +106,7 @@ func NewAzBlobFs(connectionID, localTempDir, mountPath string, config AzBlobFsCo
 			fs.svc = &serviceURL
 			fs.containerURL = fs.svc.NewContainerURL(fs.config.Container)
 		}
-		return fs, nil
+		return newCachedFs(fs, fs.getCacheNamespace()), nil
 	}
 
 	credential, err := azblob.NewSharedKeyCredential(fs.config.AccountName, fs.config.AccountKey.GetPayload())
@@ -135,7 +135,14 @@ func NewAzBlobFs(connectionID, localTempDir, mountPath string, config AzBlobFsCo
 	serviceURL := azblob.NewServiceURL(*u, pipeline)
 	fs.svc = &serviceURL
 	fs.containerURL = fs.svc.NewContainerURL(fs.config.Container)
-	return fs, nil
+	return newCachedFs(fs, fs.getCacheNamespace()), nil
+}
+
+// getCacheNamespace returns the disk cache namespace for this container,
+// the SAS token, if any, is not included
+func (fs *AzureBlobFs) getCacheNamespace() string {
+	u := fs.containerURL.URL()
+	return fmt.Sprintf("azblob %v%v", u.Host, u.Path)
 }
 
 // Name returns the name for the Fs implementation
@@ -403,7 +410,16 @@ func (*AzureBlobFs) Truncate(name string, size int64) error {
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
 func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
-	var result []os.FileInfo
+	lister, err := fs.OpenDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return readAllFromLister(lister)
+}
+
+// OpenDir opens the named directory, its entries are fetched one page
+// at a time using the returned lister
+func (fs *AzureBlobFs) OpenDir(dirname string) (DirLister, error) {
 	// dirname must be already cleaned
 	prefix := ""
 	if dirname != "" && dirname != "." {
@@ -414,8 +430,9 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	}
 
 	prefixes := make(map[string]bool)
+	marker := azblob.Marker{}
 
-	for marker := (azblob.Marker{}); marker.NotDone(); {
+	return newPagedDirLister(func() ([]os.FileInfo, bool, error) {
 		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
@@ -429,11 +446,12 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			},
 			Prefix: prefix,
 		})
+		metrics.AZListObjectsCompleted(err)
 		if err != nil {
-			metrics.AZListObjectsCompleted(err)
-			return nil, err
+			return nil, false, err
 		}
 		marker = listBlob.NextMarker
+		var result []os.FileInfo
 		for _, blobPrefix := range listBlob.Segment.BlobPrefixes {
 			// we don't support prefixes == "/" this will be sent if a key starts with "/"
 			if blobPrefix.Name == "/" {
@@ -467,23 +485,17 @@ func (fs *AzureBlobFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			}
 			result = append(result, NewFileInfo(name, isDir, size, blobInfo.Properties.LastModified, false))
 		}
-	}
-
-	metrics.AZListObjectsCompleted(nil)
-	return result, nil
-}
-
-// IsUploadResumeSupported returns true if resuming uploads is supported.
-// Resuming uploads is not supported on Azure Blob
-func (*AzureBlobFs) IsUploadResumeSupported() bool {
-	return false
+		return result, marker.NotDone(), nil
+	}, nil), nil
 }
 
-// IsAtomicUploadSupported returns true if atomic upload is supported.
+// Capabilities returns the features supported by this filesystem.
 // Azure Blob uploads are already atomic, we don't need to upload to a temporary
 // file
-func (*AzureBlobFs) IsAtomicUploadSupported() bool {
-	return false
+func (*AzureBlobFs) Capabilities() FsCapabilities {
+	return FsCapabilities{
+		RangeReads: true,
+	}
 }
 
 // IsNotExist returns a boolean indicating whether the error is known to
@@ -546,8 +558,10 @@ func (fs *AzureBlobFs) CheckRootPath(username string, uid int, gid int) bool {
 func (fs *AzureBlobFs) ScanRootDirContents() (int, int64, error) {
 	numFiles := 0
 	size := int64(0)
+	throttle := getQuotaScanThrottle()
 
 	for marker := (azblob.Marker{}); marker.NotDone(); {
+		throttle.waitRequest()
 		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 		defer cancelFn()
 
@@ -579,6 +593,7 @@ func (fs *AzureBlobFs) ScanRootDirContents() (int, int64, error) {
 			if isDir && blobSize == 0 {
 				continue
 			}
+			throttle.waitFiles(1)
 			numFiles++
 			size += blobSize
 		}
@@ -696,6 +711,81 @@ func (fs *AzureBlobFs) ResolvePath(virtualPath string) (string, error) {
 	return fs.Join(fs.config.KeyPrefix, strings.TrimPrefix(virtualPath, "/")), nil
 }
 
+// GetXattrs returns the extended attributes stored as blob metadata
+func (fs *AzureBlobFs) GetXattrs(name string) (map[string][]byte, error) {
+	response, err := fs.headObject(name)
+	if err != nil {
+		return nil, err
+	}
+	return getXattrsFromMetadata(response.NewMetadata()), nil
+}
+
+// SetXattr stores the specified extended attribute as blob metadata
+func (fs *AzureBlobFs) SetXattr(name, attr string, value []byte) error {
+	if err := ValidateXattr(attr, value); err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return fs.updateXattrs(name, attr, value)
+}
+
+// RemoveXattr removes the specified extended attribute from the blob metadata
+func (fs *AzureBlobFs) RemoveXattr(name, attr string) error {
+	if err := ValidateXattr(attr, nil); err != nil {
+		return err
+	}
+	return fs.updateXattrs(name, attr, nil)
+}
+
+// SetChecksum stores the SHA-256 checksum for the specified file as blob metadata
+func (fs *AzureBlobFs) SetChecksum(name, checksum string) error {
+	response, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	return fs.updateBlobXattr(name, response, checksumXattrName,
+		getChecksumXattrValue(checksum, response.ContentLength()))
+}
+
+// GetChecksum returns the SHA-256 checksum stored for the specified file.
+// The checksum is ignored if the blob size changed
+func (fs *AzureBlobFs) GetChecksum(name string) (string, error) {
+	response, err := fs.headObject(name)
+	if err != nil {
+		return "", err
+	}
+	return getChecksumFromMetadata(response.NewMetadata(), response.ContentLength()), nil
+}
+
+func (fs *AzureBlobFs) updateXattrs(name, attr string, value []byte) error {
+	response, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	return fs.updateBlobXattr(name, response, attr, value)
+}
+
+func (fs *AzureBlobFs) updateBlobXattr(name string, response *azblob.BlobGetPropertiesResponse, attr string,
+	value []byte,
+) error {
+	metadata, err := updateXattrMetadata(response.NewMetadata(), attr, value)
+	if err != nil {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	blobURL := fs.containerURL.NewBlobURL(name)
+	_, err = blobURL.SetMetadata(ctx, metadata, azblob.BlobAccessConditions{
+		ModifiedAccessConditions: azblob.ModifiedAccessConditions{
+			IfMatch: response.ETag(),
+		},
+	}, azblob.ClientProvidedKeyOptions{})
+	return err
+}
+
 func (fs *AzureBlobFs) headObject(name string) (*azblob.BlobGetPropertiesResponse, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
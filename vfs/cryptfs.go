@@ -209,14 +209,25 @@ func (fs *CryptFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	return result, nil
 }
 
-// IsUploadResumeSupported returns false sio does not support random access writes
-func (*CryptFs) IsUploadResumeSupported() bool {
-	return false
+// OpenDir opens the named directory, its entries can be read in batches
+// using the returned lister
+func (fs *CryptFs) OpenDir(dirname string) (DirLister, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return &osDirLister{f: f, convert: fs.ConvertFileInfo}, nil
 }
 
-// IsAtomicUploadSupported returns true if atomic upload is supported
-func (*CryptFs) IsAtomicUploadSupported() bool {
-	return false
+// Capabilities returns the features supported by this filesystem.
+// Resuming uploads and truncate are not supported: sio does not support random
+// access writes
+func (*CryptFs) Capabilities() FsCapabilities {
+	return FsCapabilities{
+		SetStat:    true,
+		Symlinks:   true,
+		RangeReads: true,
+	}
 }
 
 // GetMimeType returns the content type
@@ -0,0 +1,1117 @@
+package vfs
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const (
+	diskCacheFileExt   = ".sftpgocache"
+	diskCacheBufSize   = 32768
+	diskCacheBlockSize = 4 * 1048576
+	diskCacheLogSender = "diskcache"
+	// number of attempts to upload a file to the remote storage in write-back mode
+	diskCacheUploadAttempts = 3
+)
+
+var (
+	// diskCacheStore contains the *diskCache in use, nil if the cache is disabled
+	diskCacheStore atomic.Value
+	// delay between two attempts to upload a file in write-back mode
+	diskCacheRetryDelay = 10 * time.Second
+)
+
+func getDiskCache() *diskCache {
+	cache, _ := diskCacheStore.Load().(*diskCache)
+	return cache
+}
+
+func setDiskCache(cache *diskCache) {
+	diskCacheStore.Store(cache)
+}
+
+// DiskCacheConfig defines the configuration for the local disk cache used in front of
+// the cloud storage backends (S3, Google Cloud Storage, Azure Blob)
+type DiskCacheConfig struct {
+	// Absolute path to the directory where the cached files are stored.
+	// If empty a "sftpgo_cache" directory inside the system temp directory is used
+	Path string `json:"path" mapstructure:"path"`
+	// Maximum size of the cache as MB. The least recently used blocks are evicted when
+	// the limit is reached. 0 means disabled
+	MaxSize int64 `json:"max_size" mapstructure:"max_size"`
+	// WriteBack defines if the uploads are stored on the local disk and then
+	// uploaded to the remote storage in background
+	WriteBack bool `json:"write_back" mapstructure:"write_back"`
+}
+
+// IsEnabled returns true if the disk cache is enabled
+func (c *DiskCacheConfig) IsEnabled() bool {
+	return c.MaxSize > 0
+}
+
+// Initialize configures the disk cache, any previously cached file is removed.
+// The cache is disabled if the maximum size is not greater than 0
+func (c *DiskCacheConfig) Initialize() error {
+	setDiskCache(nil)
+	if !c.IsEnabled() {
+		return nil
+	}
+	cachePath := c.Path
+	if cachePath == "" {
+		cachePath = filepath.Join(os.TempDir(), "sftpgo_cache")
+	}
+	if !filepath.IsAbs(cachePath) {
+		return fmt.Errorf("invalid disk cache path %#v: it must be an absolute path", cachePath)
+	}
+	if err := os.MkdirAll(cachePath, 0700); err != nil {
+		return fmt.Errorf("unable to create disk cache directory %#v: %w", cachePath, err)
+	}
+	// we don't persist the cache index so remove any leftover from a previous run
+	leftovers, err := filepath.Glob(filepath.Join(cachePath, "*"+diskCacheFileExt+"*"))
+	if err != nil {
+		return err
+	}
+	for _, name := range leftovers {
+		os.Remove(name)
+	}
+	cache := newDiskCache(cachePath, c.MaxSize*1048576, diskCacheBlockSize)
+	cache.writeBack = c.WriteBack
+	setDiskCache(cache)
+	logger.Info(diskCacheLogSender, "", "disk cache initialized, path: %#v max size: %v MB, write back: %v",
+		cachePath, c.MaxSize, c.WriteBack)
+	return nil
+}
+
+// diskCacheFile defines the cached blocks for a version, identified by size
+// and modification time, of a remote file
+type diskCacheFile struct {
+	key     string
+	size    int64
+	modTime time.Time
+	blocks  map[int64]*list.Element
+}
+
+type diskCacheBlock struct {
+	file     *diskCacheFile
+	index    int64
+	fileName string
+	size     int64
+	// number of readers using the cached block
+	readers int
+	// true if the block was evicted or invalidated while in use
+	removed bool
+}
+
+// diskCacheUpload defines a file stored on the local disk and not yet
+// uploaded to the remote storage
+type diskCacheUpload struct {
+	key     string
+	name    string
+	blocks  []string
+	size    int64
+	modTime time.Time
+	// the previous pending upload for the same file, it must complete first
+	prev *diskCacheUpload
+	// closed when the remote upload completes
+	done    chan struct{}
+	readers int
+	removed bool
+}
+
+func (u *diskCacheUpload) getFileInfo() os.FileInfo {
+	return NewFileInfo(u.name, false, u.size, u.modTime, false)
+}
+
+func (u *diskCacheUpload) removeBlocks() {
+	for _, fileName := range u.blocks {
+		os.Remove(fileName)
+	}
+}
+
+// diskCache stores the remote files as fixed size blocks, so random access
+// reads only need to download the requested blocks
+type diskCache struct {
+	sync.Mutex
+	path      string
+	maxSize   int64
+	blockSize int64
+	writeBack bool
+	size      int64
+	lru       *list.List
+	files     map[string]*diskCacheFile
+	uploads   map[string]*diskCacheUpload
+}
+
+func newDiskCache(cachePath string, maxSize, blockSize int64) *diskCache {
+	if blockSize > maxSize {
+		blockSize = maxSize
+	}
+	return &diskCache{
+		path:      cachePath,
+		maxSize:   maxSize,
+		blockSize: blockSize,
+		lru:       list.New(),
+		files:     make(map[string]*diskCacheFile),
+		uploads:   make(map[string]*diskCacheUpload),
+	}
+}
+
+// canStore returns true if a file with the specified size fits in the cache
+func (c *diskCache) canStore(size int64) bool {
+	return size >= 0 && size <= c.maxSize
+}
+
+// getBlockSize returns the size of the block with the given index for a file with the specified size
+func (c *diskCache) getBlockSize(fileSize, index int64) int64 {
+	size := fileSize - index*c.blockSize
+	if size > c.blockSize {
+		return c.blockSize
+	}
+	return size
+}
+
+func (c *diskCache) createTempFile() (*os.File, error) {
+	return os.OpenFile(filepath.Join(c.path, xid.New().String()+diskCacheFileExt+".tmp"),
+		os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+}
+
+// get returns the cached block with the given index if the cached file size and
+// modification time match the provided ones. The caller must release the returned
+// block after use
+func (c *diskCache) get(key string, size int64, modTime time.Time, index int64) (*diskCacheBlock, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	file, ok := c.files[key]
+	if !ok {
+		return nil, false
+	}
+	if file.size != size || !file.modTime.Equal(modTime) {
+		c.removeFile(file)
+		return nil, false
+	}
+	elem, ok := file.blocks[index]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	block := elem.Value.(*diskCacheBlock)
+	block.readers++
+	return block, true
+}
+
+func (c *diskCache) release(block *diskCacheBlock) {
+	c.Lock()
+	defer c.Unlock()
+
+	block.readers--
+	if block.removed && block.readers <= 0 {
+		os.Remove(block.fileName)
+	}
+}
+
+// add moves the given temporary file inside the cache as the block with the
+// specified index and evicts the least recently used blocks if the maximum
+// size is exceeded
+func (c *diskCache) add(key string, size int64, modTime time.Time, index int64, tempFileName string) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.addBlock(key, size, modTime, index, tempFileName)
+}
+
+func (c *diskCache) addBlock(key string, size int64, modTime time.Time, index int64, tempFileName string) {
+	blockSize := c.getBlockSize(size, index)
+	if blockSize <= 0 || !c.canStore(blockSize) {
+		os.Remove(tempFileName)
+		return
+	}
+	file, ok := c.files[key]
+	if ok && (file.size != size || !file.modTime.Equal(modTime)) {
+		c.removeFile(file)
+		ok = false
+	}
+	if !ok {
+		file = &diskCacheFile{
+			key:     key,
+			size:    size,
+			modTime: modTime,
+			blocks:  make(map[int64]*list.Element),
+		}
+		c.files[key] = file
+	}
+	if elem, ok := file.blocks[index]; ok {
+		c.removeElement(elem)
+	}
+	// the file name is unique so we don't overwrite files for evicted blocks still in use
+	fileName := filepath.Join(c.path, fmt.Sprintf("%v_%v_%v%v", c.getFileName(key), index, xid.New().String(),
+		diskCacheFileExt))
+	if err := os.Rename(tempFileName, fileName); err != nil {
+		logger.Warn(diskCacheLogSender, "", "unable to add %#v to the disk cache: %v", key, err)
+		os.Remove(tempFileName)
+		if len(file.blocks) == 0 {
+			delete(c.files, key)
+		}
+		return
+	}
+	// the file could be removed while evicting, it is added again if required
+	c.files[key] = file
+	file.blocks[index] = c.lru.PushFront(&diskCacheBlock{
+		file:     file,
+		index:    index,
+		fileName: fileName,
+		size:     blockSize,
+	})
+	c.size += blockSize
+	for c.size > c.maxSize {
+		elem := c.lru.Back()
+		if elem == nil {
+			break
+		}
+		c.removeElement(elem)
+	}
+}
+
+// remove invalidates the cached blocks for the given key, if any
+func (c *diskCache) remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if file, ok := c.files[key]; ok {
+		c.removeFile(file)
+	}
+}
+
+// removeWithPrefix invalidates all the cached blocks whose key starts with the given prefix
+func (c *diskCache) removeWithPrefix(prefix string) {
+	c.Lock()
+	defer c.Unlock()
+
+	for key, file := range c.files {
+		if strings.HasPrefix(key, prefix) {
+			c.removeFile(file)
+		}
+	}
+}
+
+func (c *diskCache) removeFile(file *diskCacheFile) {
+	for _, elem := range file.blocks {
+		c.removeElement(elem)
+	}
+	delete(c.files, file.key)
+}
+
+func (c *diskCache) removeElement(elem *list.Element) {
+	block := c.lru.Remove(elem).(*diskCacheBlock)
+	delete(block.file.blocks, block.index)
+	if len(block.file.blocks) == 0 && c.files[block.file.key] == block.file {
+		delete(c.files, block.file.key)
+	}
+	c.size -= block.size
+	block.removed = true
+	if block.readers <= 0 {
+		os.Remove(block.fileName)
+	}
+}
+
+// addUpload registers a file to upload in write-back mode
+func (c *diskCache) addUpload(key, name string, blocks []string, size int64) *diskCacheUpload {
+	c.Lock()
+	defer c.Unlock()
+
+	upload := &diskCacheUpload{
+		key:     key,
+		name:    name,
+		blocks:  blocks,
+		size:    size,
+		modTime: time.Now(),
+		prev:    c.uploads[key],
+		done:    make(chan struct{}),
+	}
+	c.uploads[key] = upload
+	return upload
+}
+
+// getUpload returns the pending upload for the given key, if any. The caller
+// must release the returned upload after use
+func (c *diskCache) getUpload(key string) (*diskCacheUpload, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	upload, ok := c.uploads[key]
+	if ok {
+		upload.readers++
+	}
+	return upload, ok
+}
+
+func (c *diskCache) releaseUpload(upload *diskCacheUpload) {
+	c.Lock()
+	defer c.Unlock()
+
+	upload.readers--
+	if upload.removed && upload.readers <= 0 {
+		upload.removeBlocks()
+	}
+}
+
+// statUpload returns a FileInfo for the pending upload with the given key, if any
+func (c *diskCache) statUpload(key string) (os.FileInfo, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if upload, ok := c.uploads[key]; ok {
+		return upload.getFileInfo(), true
+	}
+	return nil, false
+}
+
+// getDirUploads returns the pending uploads inside the given directory
+// indexed by file name
+func (c *diskCache) getDirUploads(namespace, dirname string) map[string]os.FileInfo {
+	c.Lock()
+	defer c.Unlock()
+
+	uploads := make(map[string]os.FileInfo)
+	for key, upload := range c.uploads {
+		if strings.HasPrefix(key, namespace) && path.Dir(upload.name) == dirname {
+			info := upload.getFileInfo()
+			uploads[info.Name()] = info
+		}
+	}
+	return uploads
+}
+
+// waitUpload waits for the pending uploads for the given key, if any
+func (c *diskCache) waitUpload(key string) {
+	for {
+		c.Lock()
+		upload, ok := c.uploads[key]
+		c.Unlock()
+		if !ok {
+			return
+		}
+		<-upload.done
+	}
+}
+
+// completeUpload is called when the remote upload ends. If the upload succeeded
+// and the file was not uploaded again in the meantime its blocks are moved
+// inside the cache, otherwise they are removed
+func (c *diskCache) completeUpload(upload *diskCacheUpload, info os.FileInfo, err error) {
+	c.Lock()
+	defer c.Unlock()
+	defer close(upload.done)
+
+	isLast := c.uploads[upload.key] == upload
+	if isLast {
+		delete(c.uploads, upload.key)
+	}
+	upload.prev = nil
+	if upload.readers > 0 {
+		upload.removed = true
+		return
+	}
+	if err != nil || !isLast {
+		upload.removeBlocks()
+		return
+	}
+	if file, ok := c.files[upload.key]; ok {
+		c.removeFile(file)
+	}
+	for idx, fileName := range upload.blocks {
+		c.addBlock(upload.key, info.Size(), info.ModTime(), int64(idx), fileName)
+	}
+}
+
+func (*diskCache) getFileName(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+// diskCacheSpool writes the uploaded data to temporary files, one for each block
+type diskCacheSpool struct {
+	cache     *diskCache
+	blocks    []string
+	current   *os.File
+	blockSize int64
+	size      int64
+}
+
+func (s *diskCacheSpool) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if s.current == nil || s.blockSize == s.cache.blockSize {
+			if err := s.nextBlock(); err != nil {
+				return written, err
+			}
+		}
+		n := int64(len(p))
+		if n > s.cache.blockSize-s.blockSize {
+			n = s.cache.blockSize - s.blockSize
+		}
+		nw, err := s.current.Write(p[:n])
+		written += nw
+		s.blockSize += int64(nw)
+		s.size += int64(nw)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (s *diskCacheSpool) nextBlock() error {
+	if err := s.close(); err != nil {
+		return err
+	}
+	f, err := s.cache.createTempFile()
+	if err != nil {
+		return err
+	}
+	s.current = f
+	s.blockSize = 0
+	s.blocks = append(s.blocks, f.Name())
+	return nil
+}
+
+func (s *diskCacheSpool) close() error {
+	if s.current == nil {
+		return nil
+	}
+	err := s.current.Close()
+	s.current = nil
+	return err
+}
+
+func (s *diskCacheSpool) remove() {
+	s.close() //nolint:errcheck
+	for _, fileName := range s.blocks {
+		os.Remove(fileName)
+	}
+	s.blocks = nil
+}
+
+// cachedFs adds a local disk cache in front of the cloud storage backends.
+// The remote files are cached as fixed size blocks, the blocks are downloaded
+// as they are read and served from the cache as long as the remote file size
+// and modification time don't change. In write-back mode the uploads are
+// stored on the local disk and then uploaded to the remote storage in background,
+// otherwise the remote storage is updated before reporting success to the client
+type cachedFs struct {
+	Fs
+	cache     *diskCache
+	namespace string
+}
+
+// newCachedFs wraps the given Fs with the disk cache, if enabled.
+// The namespace must identify the remote storage, for example the bucket
+func newCachedFs(fs Fs, namespace string) Fs {
+	cache := getDiskCache()
+	if cache == nil {
+		return fs
+	}
+	return &cachedFs{
+		Fs:        fs,
+		cache:     cache,
+		namespace: namespace,
+	}
+}
+
+func (fs *cachedFs) getCacheKey(name string) string {
+	return fs.namespace + "\x00" + name
+}
+
+// Stat returns a FileInfo describing the named file, files not yet uploaded
+// in write-back mode are included
+func (fs *cachedFs) Stat(name string) (os.FileInfo, error) {
+	if info, ok := fs.cache.statUpload(fs.getCacheKey(name)); ok {
+		return info, nil
+	}
+	return fs.Fs.Stat(name)
+}
+
+// Lstat returns a FileInfo describing the named file, files not yet uploaded
+// in write-back mode are included
+func (fs *cachedFs) Lstat(name string) (os.FileInfo, error) {
+	if info, ok := fs.cache.statUpload(fs.getCacheKey(name)); ok {
+		return info, nil
+	}
+	return fs.Fs.Lstat(name)
+}
+
+// ReadDir reads the directory named by dirname and returns a list of directory entries
+func (fs *cachedFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	lister, err := fs.OpenDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return readAllFromLister(lister)
+}
+
+// OpenDir opens the named directory, files not yet uploaded in write-back
+// mode are included in the listing
+func (fs *cachedFs) OpenDir(dirname string) (DirLister, error) {
+	uploads := fs.cache.getDirUploads(fs.getCacheKey(""), dirname)
+	lister, err := fs.Fs.OpenDir(dirname)
+	if err != nil {
+		if len(uploads) > 0 && fs.IsNotExist(err) {
+			return newPendingDirLister(newSliceDirLister(nil), uploads), nil
+		}
+		return nil, err
+	}
+	if len(uploads) == 0 {
+		return lister, nil
+	}
+	return newPendingDirLister(lister, uploads), nil
+}
+
+// Open opens the named file for reading, the cached blocks are served from
+// the local disk and the missing ones are downloaded and added to the cache
+func (fs *cachedFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	key := fs.getCacheKey(name)
+	if upload, ok := fs.cache.getUpload(key); ok {
+		fsLog(fs, logger.LevelDebug, "serving %#v from the pending upload, offset: %v", name, offset)
+		return fs.openFromUpload(upload, offset)
+	}
+	info, err := fs.Fs.Stat(name)
+	if err != nil || !fs.Fs.Capabilities().RangeReads {
+		return fs.Fs.Open(name, offset)
+	}
+	r, w, err := pipeat.PipeInDir(fs.cache.path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		err := fs.readBlocks(ctx, w, name, key, info, offset)
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %#v offset: %v, err: %v", name, offset, err)
+	}()
+	return nil, r, cancelFn, nil
+}
+
+func (fs *cachedFs) openFromUpload(upload *diskCacheUpload, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.cache.path)
+	if err != nil {
+		fs.cache.releaseUpload(upload)
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+		defer fs.cache.releaseUpload(upload)
+
+		var err error
+		for idx := offset / fs.cache.blockSize; idx < int64(len(upload.blocks)); idx++ {
+			start := offset - idx*fs.cache.blockSize
+			if start < 0 {
+				start = 0
+			}
+			if err = copyFileWithContext(ctx, w, upload.blocks[idx], start); err != nil {
+				break
+			}
+		}
+		w.CloseWithError(err) //nolint:errcheck
+	}()
+	return nil, r, cancelFn, nil
+}
+
+// readBlocks sends the file contents starting at offset to w. The missing
+// blocks are downloaded from the remote storage, a new remote read is
+// started only after a cached block
+func (fs *cachedFs) readBlocks(ctx context.Context, w io.Writer, name, key string, info os.FileInfo, offset int64) error {
+	var remoteReader *pipeat.PipeReaderAt
+	var remoteCancelFn func()
+	closeRemote := func() {
+		if remoteReader != nil {
+			remoteCancelFn()
+			remoteReader.Close()
+			remoteReader = nil
+		}
+	}
+	defer closeRemote()
+
+	for idx := offset / fs.cache.blockSize; idx*fs.cache.blockSize < info.Size(); idx++ {
+		blockStart := idx * fs.cache.blockSize
+		skip := offset - blockStart
+		if skip < 0 {
+			skip = 0
+		}
+		if block, ok := fs.cache.get(key, info.Size(), info.ModTime(), idx); ok {
+			closeRemote()
+			err := copyFileWithContext(ctx, w, block.fileName, skip)
+			fs.cache.release(block)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if remoteReader == nil {
+			var err error
+			_, remoteReader, remoteCancelFn, err = fs.Fs.Open(name, blockStart)
+			if err != nil {
+				return err
+			}
+			if remoteReader == nil {
+				remoteCancelFn()
+				return fmt.Errorf("unable to read %#v: %w", name, ErrVfsUnsupported)
+			}
+		}
+		if err := fs.fetchBlock(ctx, w, remoteReader, key, info, idx, skip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchBlock reads the block with the given index from the remote reader, adds
+// it to the cache and sends its contents, after the first skip bytes, to w
+func (fs *cachedFs) fetchBlock(ctx context.Context, w io.Writer, remoteReader io.Reader, key string, info os.FileInfo,
+	index, skip int64,
+) error {
+	blockSize := fs.cache.getBlockSize(info.Size(), index)
+	tempFile, err := fs.cache.createTempFile()
+	if err != nil {
+		return err
+	}
+	n, err := copyWithContext(ctx, io.MultiWriter(tempFile, &skipWriter{w: w, skip: skip}),
+		io.LimitReader(remoteReader, blockSize))
+	if err == nil && n != blockSize {
+		err = io.ErrUnexpectedEOF
+	}
+	errClose := tempFile.Close()
+	if err == nil && errClose == nil {
+		fs.cache.add(key, info.Size(), info.ModTime(), index, tempFile.Name())
+	} else {
+		os.Remove(tempFile.Name())
+	}
+	return err
+}
+
+// Create creates or opens the named file for writing, the written data is stored
+// inside the cache too
+func (fs *cachedFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	key := fs.getCacheKey(name)
+	if fs.cache.writeBack && flag != -1 {
+		return fs.createWriteBack(name, key)
+	}
+	fs.cache.waitUpload(key)
+	fs.cache.remove(key)
+
+	file, remoteWriter, remoteCancelFn, err := fs.Fs.Create(name, flag)
+	if err != nil || remoteWriter == nil || flag == -1 {
+		return file, remoteWriter, remoteCancelFn, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.cache.path)
+	if err != nil {
+		return file, remoteWriter, remoteCancelFn, nil
+	}
+	p := NewPipeWriter(w)
+
+	go func() {
+		var err error
+		spool := &diskCacheSpool{cache: fs.cache}
+		cacheable := true
+		buf := make([]byte, diskCacheBufSize)
+		for {
+			nr, errRead := r.Read(buf)
+			if nr > 0 {
+				if _, err = remoteWriter.Write(buf[:nr]); err != nil {
+					break
+				}
+				if cacheable {
+					if !fs.cache.canStore(spool.size + int64(nr)) {
+						cacheable = false
+					} else if _, errWrite := spool.Write(buf[:nr]); errWrite != nil {
+						cacheable = false
+					}
+				}
+			}
+			if errRead != nil {
+				if errRead != io.EOF {
+					err = errRead
+				}
+				break
+			}
+		}
+		if err != nil {
+			remoteCancelFn()
+		}
+		// this waits for the upload to complete
+		errClose := remoteWriter.Close()
+		if err == nil {
+			err = errClose
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		if errClose := spool.close(); errClose != nil {
+			cacheable = false
+		}
+		if err == nil && cacheable {
+			fs.addUploadToCache(name, key, spool)
+		} else {
+			spool.remove()
+		}
+		p.Done(err)
+	}()
+	return file, p, remoteCancelFn, nil
+}
+
+// createWriteBack stores the uploaded data on the local disk. The upload is
+// completed as soon as the client ends writing and the data are uploaded to
+// the remote storage in background
+func (fs *cachedFs) createWriteBack(name, key string) (File, *PipeWriter, func(), error) {
+	r, w, err := pipeat.PipeInDir(fs.cache.path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+	ctx, cancelFn := context.WithCancel(context.Background())
+
+	go func() {
+		defer cancelFn()
+
+		spool := &diskCacheSpool{cache: fs.cache}
+		_, err := copyWithContext(ctx, spool, r)
+		if errClose := spool.close(); err == nil {
+			err = errClose
+		}
+		// the writer is closed without errors if the transfer is aborted
+		if err == nil {
+			err = ctx.Err()
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		if err != nil {
+			spool.remove()
+			p.Done(err)
+			return
+		}
+		upload := fs.cache.addUpload(key, name, spool.blocks, spool.size)
+		p.Done(nil)
+		fsLog(fs, logger.LevelDebug, "file %#v stored on the local disk, size: %v, starting write back",
+			name, spool.size)
+		fs.writeBack(upload)
+	}()
+	return nil, p, cancelFn, nil
+}
+
+// writeBack uploads the given file to the remote storage retrying on errors
+func (fs *cachedFs) writeBack(upload *diskCacheUpload) {
+	if upload.prev != nil {
+		<-upload.prev.done
+	}
+	var err error
+	for attempt := 1; attempt <= diskCacheUploadAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(diskCacheRetryDelay)
+		}
+		if err = fs.uploadBlocks(upload); err == nil {
+			break
+		}
+		fsLog(fs, logger.LevelWarn, "write back for %#v failed, attempt: %v, err: %v", upload.name, attempt, err)
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = fs.Fs.Stat(upload.name)
+		if err == nil && info.Size() != upload.size {
+			err = fmt.Errorf("size mismatch after upload, expected: %v, actual: %v", upload.size, info.Size())
+		}
+	}
+	if err != nil {
+		fsLog(fs, logger.LevelError, "unable to upload %#v to the remote storage, the uploaded data are lost: %v",
+			upload.name, err)
+	} else {
+		fsLog(fs, logger.LevelDebug, "write back completed for %#v, size: %v", upload.name, upload.size)
+	}
+	fs.cache.completeUpload(upload, info, err)
+}
+
+func (fs *cachedFs) uploadBlocks(upload *diskCacheUpload) error {
+	_, w, cancelFn, err := fs.Fs.Create(upload.name, 0)
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		cancelFn()
+		return fmt.Errorf("unable to upload %#v: %w", upload.name, ErrVfsUnsupported)
+	}
+	for _, fileName := range upload.blocks {
+		if err = copyFileWithContext(context.Background(), w, fileName, 0); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		cancelFn()
+	}
+	errClose := w.Close()
+	if err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func (fs *cachedFs) addUploadToCache(name, key string, spool *diskCacheSpool) {
+	// we need the modification time assigned by the remote storage
+	info, err := fs.Fs.Stat(name)
+	if err != nil || info.Size() != spool.size {
+		spool.remove()
+		return
+	}
+	for idx, fileName := range spool.blocks {
+		fs.cache.add(key, info.Size(), info.ModTime(), int64(idx), fileName)
+	}
+}
+
+// Rename renames (moves) source to target and invalidates the cached blocks.
+// Pending uploads for source and target are completed first
+func (fs *cachedFs) Rename(source, target string) error {
+	fs.waitUpload(source)
+	fs.waitUpload(target)
+	err := fs.Fs.Rename(source, target)
+	fs.invalidate(source)
+	fs.invalidate(target)
+	return err
+}
+
+// Remove removes the named file or (empty) directory and invalidates the cached blocks.
+// A pending upload for the named file is completed first
+func (fs *cachedFs) Remove(name string, isDir bool) error {
+	fs.waitUpload(name)
+	err := fs.Fs.Remove(name, isDir)
+	fs.invalidate(name)
+	return err
+}
+
+// ListVersions returns the versions for the specified file if the wrapped
+// filesystem supports versioning
+func (fs *cachedFs) ListVersions(name string) ([]FileVersion, error) {
+	if versionedFs, ok := fs.Fs.(VersionedFs); ok {
+		fs.waitUpload(name)
+		return versionedFs.ListVersions(name)
+	}
+	return nil, ErrVfsUnsupported
+}
+
+// RestoreVersion restores the specified version and invalidates the cached blocks
+func (fs *cachedFs) RestoreVersion(name, versionID string) error {
+	versionedFs, ok := fs.Fs.(VersionedFs)
+	if !ok {
+		return ErrVfsUnsupported
+	}
+	fs.waitUpload(name)
+	err := versionedFs.RestoreVersion(name, versionID)
+	fs.invalidate(name)
+	return err
+}
+
+// GetXattrs returns the extended attributes if the wrapped filesystem supports them
+func (fs *cachedFs) GetXattrs(name string) (map[string][]byte, error) {
+	if xattrFs, ok := fs.Fs.(XattrFs); ok {
+		fs.waitUpload(name)
+		return xattrFs.GetXattrs(name)
+	}
+	return nil, ErrVfsUnsupported
+}
+
+// SetXattr sets the extended attribute if the wrapped filesystem supports them
+func (fs *cachedFs) SetXattr(name, attr string, value []byte) error {
+	if xattrFs, ok := fs.Fs.(XattrFs); ok {
+		fs.waitUpload(name)
+		return xattrFs.SetXattr(name, attr, value)
+	}
+	return ErrVfsUnsupported
+}
+
+// RemoveXattr removes the extended attribute if the wrapped filesystem supports them
+func (fs *cachedFs) RemoveXattr(name, attr string) error {
+	if xattrFs, ok := fs.Fs.(XattrFs); ok {
+		fs.waitUpload(name)
+		return xattrFs.RemoveXattr(name, attr)
+	}
+	return ErrVfsUnsupported
+}
+
+// SetChecksum stores the checksum if the wrapped filesystem supports checksums
+func (fs *cachedFs) SetChecksum(name, checksum string) error {
+	if checksumFs, ok := fs.Fs.(ChecksumFs); ok {
+		fs.waitUpload(name)
+		return checksumFs.SetChecksum(name, checksum)
+	}
+	return ErrVfsUnsupported
+}
+
+// GetChecksum returns the stored checksum if the wrapped filesystem supports checksums
+func (fs *cachedFs) GetChecksum(name string) (string, error) {
+	if checksumFs, ok := fs.Fs.(ChecksumFs); ok {
+		fs.waitUpload(name)
+		return checksumFs.GetChecksum(name)
+	}
+	return "", ErrVfsUnsupported
+}
+
+// GetResumableUploadSize returns the size already uploaded for an interrupted
+// upload if the wrapped filesystem can resume uploads
+func (fs *cachedFs) GetResumableUploadSize(name string) (int64, bool) {
+	if resumableFs, ok := fs.Fs.(ResumableUploadFs); ok {
+		return resumableFs.GetResumableUploadSize(name)
+	}
+	return 0, false
+}
+
+// ResumeUpload continues an interrupted upload if the wrapped filesystem
+// can resume uploads
+func (fs *cachedFs) ResumeUpload(name string, offset int64) (*PipeWriter, func(), error) {
+	resumableFs, ok := fs.Fs.(ResumableUploadFs)
+	if !ok {
+		return nil, nil, ErrVfsUnsupported
+	}
+	fs.waitUpload(name)
+	fs.invalidate(name)
+	return resumableFs.ResumeUpload(name, offset)
+}
+
+func (fs *cachedFs) waitUpload(name string) {
+	fs.cache.waitUpload(fs.getCacheKey(name))
+}
+
+func (fs *cachedFs) invalidate(name string) {
+	key := fs.getCacheKey(name)
+	fs.cache.remove(key)
+	fs.cache.removeWithPrefix(strings.TrimSuffix(key, "/") + "/")
+}
+
+// pendingDirLister adds the files not yet uploaded in write-back mode to a
+// remote directory listing
+type pendingDirLister struct {
+	DirLister
+	uploads map[string]os.FileInfo
+	extra   []os.FileInfo
+	listed  bool
+}
+
+func newPendingDirLister(lister DirLister, uploads map[string]os.FileInfo) *pendingDirLister {
+	return &pendingDirLister{
+		DirLister: lister,
+		uploads:   uploads,
+	}
+}
+
+// Next implements the DirLister interface
+func (l *pendingDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidListerLimit
+	}
+	var entries []os.FileInfo
+	if !l.listed {
+		var err error
+		entries, err = l.DirLister.Next(limit)
+		// the remote entries are replaced by the pending uploads
+		for idx, info := range entries {
+			if upload, ok := l.uploads[info.Name()]; ok {
+				entries[idx] = upload
+				delete(l.uploads, info.Name())
+			}
+		}
+		if err != io.EOF {
+			return entries, err
+		}
+		l.listed = true
+		for _, info := range l.uploads {
+			l.extra = append(l.extra, info)
+		}
+	}
+	n := limit - len(entries)
+	if n > len(l.extra) {
+		n = len(l.extra)
+	}
+	entries = append(entries, l.extra[:n]...)
+	l.extra = l.extra[n:]
+	if len(l.extra) > 0 {
+		return entries, nil
+	}
+	return entries, io.EOF
+}
+
+// skipWriter discards the first skip bytes written
+type skipWriter struct {
+	w    io.Writer
+	skip int64
+}
+
+func (s *skipWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if s.skip >= int64(n) {
+		s.skip -= int64(n)
+		return n, nil
+	}
+	p = p[s.skip:]
+	s.skip = 0
+	if _, err := s.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// copyFileWithContext copies the contents of the named local file, starting at offset, to dst
+func copyFileWithContext(ctx context.Context, dst io.Writer, fileName string, offset int64) error {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = copyWithContext(ctx, dst, f)
+	return err
+}
+
+func copyWithContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	var written int64
+	buf := make([]byte, diskCacheBufSize)
+	for {
+		if ctx.Err() != nil {
+			return written, ctx.Err()
+		}
+		nr, errRead := src.Read(buf)
+		if nr > 0 {
+			nw, errWrite := dst.Write(buf[:nr])
+			written += int64(nw)
+			if errWrite != nil {
+				return written, errWrite
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+		if errRead != nil {
+			if errors.Is(errRead, io.EOF) {
+				return written, nil
+			}
+			return written, errRead
+		}
+	}
+}
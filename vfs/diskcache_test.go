@@ -0,0 +1,437 @@
+package vfs
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryRemoteFs is an in memory remote storage, the methods not used by
+// cachedFs are not implemented
+type memoryRemoteFs struct {
+	Fs
+	sync.Mutex
+	files    map[string][]byte
+	modTimes map[string]time.Time
+	// offsets requested to Open
+	opens []int64
+	// if not nil the uploads wait for this channel to be closed
+	uploadGate chan struct{}
+	checksums  map[string]string
+}
+
+func newMemoryRemoteFs() *memoryRemoteFs {
+	return &memoryRemoteFs{
+		files:    make(map[string][]byte),
+		modTimes: make(map[string]time.Time),
+	}
+}
+
+func (*memoryRemoteFs) Name() string {
+	return "memoryfs"
+}
+
+func (*memoryRemoteFs) ConnectionID() string {
+	return "id"
+}
+
+func (*memoryRemoteFs) Capabilities() FsCapabilities {
+	return FsCapabilities{RangeReads: true}
+}
+
+func (*memoryRemoteFs) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+func (fs *memoryRemoteFs) getOpens() []int64 {
+	fs.Lock()
+	defer fs.Unlock()
+
+	opens := fs.opens
+	fs.opens = nil
+	return opens
+}
+
+func (fs *memoryRemoteFs) Stat(name string) (os.FileInfo, error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	data, ok := fs.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return NewFileInfo(name, false, int64(len(data)), fs.modTimes[name], false), nil
+}
+
+func (fs *memoryRemoteFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	fs.Lock()
+	data, ok := fs.files[name]
+	fs.opens = append(fs.opens, offset)
+	fs.Unlock()
+	if !ok {
+		return nil, nil, nil, os.ErrNotExist
+	}
+	r, w, err := pipeat.Pipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	go func() {
+		_, err := w.Write(data[offset:])
+		w.CloseWithError(err) //nolint:errcheck
+	}()
+	return nil, r, func() {}, nil
+}
+
+func (fs *memoryRemoteFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	r, w, err := pipeat.Pipe()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+	go func() {
+		data, err := io.ReadAll(r)
+		if fs.uploadGate != nil {
+			<-fs.uploadGate
+		}
+		if err == nil {
+			fs.Lock()
+			fs.files[name] = data
+			fs.modTimes[name] = time.Now()
+			fs.Unlock()
+		}
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+	}()
+	return nil, p, func() {}, nil
+}
+
+func (fs *memoryRemoteFs) Remove(name string, isDir bool) error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if _, ok := fs.files[name]; !ok {
+		return os.ErrNotExist
+	}
+	delete(fs.files, name)
+	return nil
+}
+
+func (fs *memoryRemoteFs) Rename(source, target string) error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	data, ok := fs.files[source]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(fs.files, source)
+	fs.files[target] = data
+	fs.modTimes[target] = time.Now()
+	return nil
+}
+
+func (fs *memoryRemoteFs) OpenDir(dirname string) (DirLister, error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	var entries []os.FileInfo
+	for name, data := range fs.files {
+		if path.Dir(name) == dirname {
+			entries = append(entries, NewFileInfo(name, false, int64(len(data)), fs.modTimes[name], false))
+		}
+	}
+	return newSliceDirLister(entries), nil
+}
+
+// memoryChecksumFs is a memoryRemoteFs that can store checksums
+type memoryChecksumFs struct {
+	*memoryRemoteFs
+}
+
+func (fs *memoryChecksumFs) SetChecksum(name, checksum string) error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	fs.checksums[name] = checksum
+	return nil
+}
+
+func (fs *memoryChecksumFs) GetChecksum(name string) (string, error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	return fs.checksums[name], nil
+}
+
+func newTestCachedFs(t *testing.T, remoteFs Fs, maxSize, blockSize int64, writeBack bool) *cachedFs {
+	cache := newDiskCache(t.TempDir(), maxSize, blockSize)
+	cache.writeBack = writeBack
+	return &cachedFs{
+		Fs:        remoteFs,
+		cache:     cache,
+		namespace: "test",
+	}
+}
+
+func readTestFile(t *testing.T, fs Fs, name string, offset int64) []byte {
+	_, r, cancelFn, err := fs.Open(name, offset)
+	require.NoError(t, err)
+	defer cancelFn()
+
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.NoError(t, r.Close())
+	return data
+}
+
+func writeTestFile(t *testing.T, fs Fs, name string, data []byte) {
+	_, w, cancelFn, err := fs.Create(name, 0)
+	require.NoError(t, err)
+	defer cancelFn()
+
+	_, err = w.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+}
+
+func TestDiskCacheEviction(t *testing.T) {
+	cache := newDiskCache(t.TempDir(), 100, 40)
+	modTime := time.Now()
+
+	addBlock := func(key string, size, index int64) {
+		f, err := cache.createTempFile()
+		require.NoError(t, err)
+		_, err = f.Write(make([]byte, cache.getBlockSize(size, index)))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		cache.add(key, size, modTime, index, f.Name())
+	}
+
+	addBlock("a", 40, 0)
+	addBlock("b", 80, 0)
+	block, ok := cache.get("a", 40, modTime, 0)
+	assert.True(t, ok)
+	cache.release(block)
+	// the first block of b is the least recently used one
+	addBlock("b", 80, 1)
+	assert.Equal(t, int64(80), cache.size)
+	_, ok = cache.get("b", 80, modTime, 0)
+	assert.False(t, ok)
+	block, ok = cache.get("b", 80, modTime, 1)
+	assert.True(t, ok)
+	cache.release(block)
+	// a modification time change invalidates all the blocks
+	_, ok = cache.get("b", 80, modTime.Add(time.Second), 1)
+	assert.False(t, ok)
+	assert.Equal(t, int64(40), cache.size)
+	assert.Len(t, cache.files, 1)
+
+	files, err := filepath.Glob(filepath.Join(cache.path, "*"+diskCacheFileExt))
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestDiskCacheRemoveInUse(t *testing.T) {
+	cache := newDiskCache(t.TempDir(), 100, 10)
+	modTime := time.Now()
+
+	f, err := cache.createTempFile()
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	cache.add("/dir/file", 0, modTime, 0, f.Name())
+	assert.Equal(t, 0, cache.lru.Len())
+
+	f, err = cache.createTempFile()
+	require.NoError(t, err)
+	_, err = f.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	cache.add("/dir/file", 4, modTime, 0, f.Name())
+	block, ok := cache.get("/dir/file", 4, modTime, 0)
+	require.True(t, ok)
+	cache.removeWithPrefix("/dir/")
+	assert.Equal(t, 0, cache.lru.Len())
+	assert.Len(t, cache.files, 0)
+	// the file is still in use
+	_, err = os.Stat(block.fileName)
+	assert.NoError(t, err)
+	cache.release(block)
+	_, err = os.Stat(block.fileName)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCachedFsRangeReads(t *testing.T) {
+	remoteFs := newMemoryRemoteFs()
+	fs := newTestCachedFs(t, remoteFs, 100, 10, false)
+	data := []byte("0123456789abcdefghijABCDEFGHIJklmno")
+	remoteFs.files["/file"] = data
+	remoteFs.modTimes["/file"] = time.Now()
+
+	// only the blocks starting from the one containing the offset are downloaded
+	assert.Equal(t, data[25:], readTestFile(t, fs, "/file", 25))
+	assert.Equal(t, []int64{20}, remoteFs.getOpens())
+	assert.Equal(t, int64(15), fs.cache.size)
+	// the missing blocks are downloaded with a single remote read, the cached ones are served locally
+	assert.Equal(t, data[5:], readTestFile(t, fs, "/file", 5))
+	assert.Equal(t, []int64{0}, remoteFs.getOpens())
+	assert.Equal(t, int64(35), fs.cache.size)
+	assert.Equal(t, data, readTestFile(t, fs, "/file", 0))
+	assert.Len(t, remoteFs.getOpens(), 0)
+	assert.Len(t, readTestFile(t, fs, "/file", 40), 0)
+	// blocks in the middle of the file are downloaded reopening the remote file
+	fs.cache.Lock()
+	fs.cache.removeElement(fs.cache.files[fs.getCacheKey("/file")].blocks[1])
+	fs.cache.Unlock()
+	assert.Equal(t, data, readTestFile(t, fs, "/file", 0))
+	assert.Equal(t, []int64{10}, remoteFs.getOpens())
+	// a modified file is downloaded again
+	remoteFs.Lock()
+	remoteFs.files["/file"] = data[:30]
+	remoteFs.Unlock()
+	assert.Equal(t, data[:30], readTestFile(t, fs, "/file", 0))
+	assert.Equal(t, []int64{0}, remoteFs.getOpens())
+	assert.Equal(t, int64(30), fs.cache.size)
+	// an upload replaces the cached blocks
+	writeTestFile(t, fs, "/file", data[:15])
+	assert.Equal(t, int64(15), fs.cache.size)
+	assert.Equal(t, data[:15], readTestFile(t, fs, "/file", 0))
+	assert.Len(t, remoteFs.getOpens(), 0)
+	err := fs.Remove("/file", false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), fs.cache.size)
+	_, _, _, err = fs.Open("/file", 0)
+	assert.True(t, fs.IsNotExist(err))
+}
+
+func TestCachedFsWriteBack(t *testing.T) {
+	oldDelay := diskCacheRetryDelay
+	diskCacheRetryDelay = 10 * time.Millisecond
+	defer func() {
+		diskCacheRetryDelay = oldDelay
+	}()
+
+	remoteFs := newMemoryRemoteFs()
+	remoteFs.uploadGate = make(chan struct{})
+	fs := newTestCachedFs(t, remoteFs, 100, 10, true)
+	data := []byte("0123456789abcdefghijABCDE")
+	// the upload completes before the data are sent to the remote storage
+	writeTestFile(t, fs, "/dir/file", data)
+	info, err := fs.Stat("/dir/file")
+	require.NoError(t, err)
+	assert.Equal(t, "file", info.Name())
+	assert.Equal(t, int64(len(data)), info.Size())
+	entries, err := fs.ReadDir("/dir")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, int64(len(data)), entries[0].Size())
+	assert.Equal(t, data[12:], readTestFile(t, fs, "/dir/file", 12))
+	assert.Len(t, remoteFs.getOpens(), 0)
+	remoteFs.Lock()
+	_, ok := remoteFs.files["/dir/file"]
+	remoteFs.Unlock()
+	assert.False(t, ok)
+
+	renameErr := make(chan error, 1)
+	go func() {
+		renameErr <- fs.Rename("/dir/file", "/dir/renamed")
+	}()
+	select {
+	case <-renameErr:
+		assert.Fail(t, "rename must wait for the pending upload")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(remoteFs.uploadGate)
+	assert.NoError(t, <-renameErr)
+	remoteFs.Lock()
+	assert.Equal(t, data, remoteFs.files["/dir/renamed"])
+	remoteFs.Unlock()
+	assert.Equal(t, int64(0), fs.cache.size)
+	// the uploaded blocks are moved inside the cache
+	writeTestFile(t, fs, "/dir/file", data)
+	fs.waitUpload("/dir/file")
+	assert.Equal(t, int64(len(data)), fs.cache.size)
+	assert.Equal(t, data, readTestFile(t, fs, "/dir/file", 0))
+	assert.Len(t, remoteFs.getOpens(), 0)
+	files, err := filepath.Glob(filepath.Join(fs.cache.path, "*.tmp"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 0)
+	entries, err = fs.ReadDir("/dir")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestPendingDirLister(t *testing.T) {
+	modTime := time.Now()
+	lister := newPendingDirLister(newSliceDirLister([]os.FileInfo{
+		NewFileInfo("/dir/a", false, 1, modTime, false),
+		NewFileInfo("/dir/b", false, 1, modTime, false),
+	}), map[string]os.FileInfo{
+		"b": NewFileInfo("/dir/b", false, 2, modTime, false),
+		"c": NewFileInfo("/dir/c", false, 3, modTime, false),
+		"d": NewFileInfo("/dir/d", false, 4, modTime, false),
+	})
+	_, err := lister.Next(0)
+	assert.ErrorIs(t, err, errInvalidListerLimit)
+	entries, err := lister.Next(3)
+	assert.NoError(t, err)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "a", entries[0].Name())
+	assert.Equal(t, int64(2), entries[1].Size())
+	entries, err = lister.Next(3)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Len(t, entries, 1)
+	assert.NoError(t, lister.Close())
+}
+
+func TestCachedFsOptionalInterfaces(t *testing.T) {
+	remoteFs := newMemoryRemoteFs()
+	fs := newTestCachedFs(t, remoteFs, 100, 10, false)
+	_, ok := GetChecksumFs(fs)
+	assert.False(t, ok)
+	assert.ErrorIs(t, fs.SetChecksum("/file", "checksum"), ErrVfsUnsupported)
+	_, err := fs.GetXattrs("/file")
+	assert.ErrorIs(t, err, ErrVfsUnsupported)
+
+	remoteFs.checksums = make(map[string]string)
+	fs = newTestCachedFs(t, &memoryChecksumFs{remoteFs}, 100, 10, false)
+	checksumFs, ok := GetChecksumFs(fs)
+	require.True(t, ok)
+	assert.NoError(t, checksumFs.SetChecksum("/file", "checksum"))
+	checksum, err := checksumFs.GetChecksum("/file")
+	assert.NoError(t, err)
+	assert.Equal(t, "checksum", checksum)
+}
+
+func TestDiskCacheConfig(t *testing.T) {
+	defer setDiskCache(nil)
+
+	config := DiskCacheConfig{
+		Path:      filepath.Join(t.TempDir(), "cache"),
+		MaxSize:   1,
+		WriteBack: true,
+	}
+	require.NoError(t, config.Initialize())
+	cache := getDiskCache()
+	require.NotNil(t, cache)
+	assert.True(t, cache.writeBack)
+	assert.Equal(t, int64(1048576), cache.blockSize)
+	remoteFs := newMemoryRemoteFs()
+	_, ok := newCachedFs(remoteFs, "test").(*cachedFs)
+	assert.True(t, ok)
+
+	config.MaxSize = 0
+	require.NoError(t, config.Initialize())
+	assert.Nil(t, getDiskCache())
+	assert.Equal(t, remoteFs, newCachedFs(remoteFs, "test"))
+	config.MaxSize = 1
+	config.Path = "relative"
+	assert.Error(t, config.Initialize())
+}
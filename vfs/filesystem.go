@@ -13,6 +13,8 @@ const (
 	AzureBlobFilesystemProvider                           // Azure Blob Storage
 	CryptedFilesystemProvider                             // Local encrypted
 	SFTPFilesystemProvider                                // SFTP
+	PluginFilesystemProvider                              // External storage plugin
+	SMBFilesystemProvider                                 // SMB share, such as Azure Files
 )
 
 // Filesystem defines cloud storage filesystem details
@@ -24,6 +26,9 @@ type Filesystem struct {
 	AzBlobConfig   AzBlobFsConfig     `json:"azblobconfig,omitempty"`
 	CryptConfig    CryptFsConfig      `json:"cryptconfig,omitempty"`
 	SFTPConfig     SFTPFsConfig       `json:"sftpconfig,omitempty"`
+	PluginConfig   PluginFsConfig     `json:"pluginconfig,omitempty"`
+	SMBConfig      SMBFsConfig        `json:"smbconfig,omitempty"`
+	Versioning     VersioningConfig   `json:"versioning,omitempty"`
 }
 
 // SetEmptySecretsIfNil sets the secrets to empty if nil
@@ -31,6 +36,9 @@ func (f *Filesystem) SetEmptySecretsIfNil() {
 	if f.S3Config.AccessSecret == nil {
 		f.S3Config.AccessSecret = kms.NewEmptySecret()
 	}
+	if f.S3Config.SSECustomerKey == nil {
+		f.S3Config.SSECustomerKey = kms.NewEmptySecret()
+	}
 	if f.GCSConfig.Credentials == nil {
 		f.GCSConfig.Credentials = kms.NewEmptySecret()
 	}
@@ -46,6 +54,12 @@ func (f *Filesystem) SetEmptySecretsIfNil() {
 	if f.SFTPConfig.PrivateKey == nil {
 		f.SFTPConfig.PrivateKey = kms.NewEmptySecret()
 	}
+	if f.PluginConfig.Options == nil {
+		f.PluginConfig.Options = kms.NewEmptySecret()
+	}
+	if f.SMBConfig.Password == nil {
+		f.SMBConfig.Password = kms.NewEmptySecret()
+	}
 }
 
 // SetNilSecretsIfEmpty set the secrets to nil if empty.
@@ -55,6 +69,9 @@ func (f *Filesystem) SetNilSecretsIfEmpty() {
 	if f.S3Config.AccessSecret != nil && f.S3Config.AccessSecret.IsEmpty() {
 		f.S3Config.AccessSecret = nil
 	}
+	if f.S3Config.SSECustomerKey != nil && f.S3Config.SSECustomerKey.IsEmpty() {
+		f.S3Config.SSECustomerKey = nil
+	}
 	if f.GCSConfig.Credentials != nil && f.GCSConfig.Credentials.IsEmpty() {
 		f.GCSConfig.Credentials = nil
 	}
@@ -70,6 +87,12 @@ func (f *Filesystem) SetNilSecretsIfEmpty() {
 	if f.SFTPConfig.PrivateKey != nil && f.SFTPConfig.PrivateKey.IsEmpty() {
 		f.SFTPConfig.PrivateKey = nil
 	}
+	if f.PluginConfig.Options != nil && f.PluginConfig.Options.IsEmpty() {
+		f.PluginConfig.Options = nil
+	}
+	if f.SMBConfig.Password != nil && f.SMBConfig.Password.IsEmpty() {
+		f.SMBConfig.Password = nil
+	}
 }
 
 // IsEqual returns true if the fs is equal to other
@@ -77,6 +100,9 @@ func (f *Filesystem) IsEqual(other *Filesystem) bool {
 	if f.Provider != other.Provider {
 		return false
 	}
+	if !f.Versioning.isEqual(&other.Versioning) {
+		return false
+	}
 	switch f.Provider {
 	case S3FilesystemProvider:
 		return f.S3Config.isEqual(&other.S3Config)
@@ -88,6 +114,10 @@ func (f *Filesystem) IsEqual(other *Filesystem) bool {
 		return f.CryptConfig.isEqual(&other.CryptConfig)
 	case SFTPFilesystemProvider:
 		return f.SFTPConfig.isEqual(&other.SFTPConfig)
+	case PluginFilesystemProvider:
+		return f.PluginConfig.isEqual(&other.PluginConfig)
+	case SMBFilesystemProvider:
+		return f.SMBConfig.isEqual(&other.SMBConfig)
 	default:
 		return true
 	}
@@ -99,15 +129,22 @@ func (f *Filesystem) GetACopy() Filesystem {
 	fs := Filesystem{
 		Provider: f.Provider,
 		S3Config: S3FsConfig{
-			Bucket:            f.S3Config.Bucket,
-			Region:            f.S3Config.Region,
-			AccessKey:         f.S3Config.AccessKey,
-			AccessSecret:      f.S3Config.AccessSecret.Clone(),
-			Endpoint:          f.S3Config.Endpoint,
-			StorageClass:      f.S3Config.StorageClass,
-			KeyPrefix:         f.S3Config.KeyPrefix,
-			UploadPartSize:    f.S3Config.UploadPartSize,
-			UploadConcurrency: f.S3Config.UploadConcurrency,
+			Bucket:              f.S3Config.Bucket,
+			Region:              f.S3Config.Region,
+			AccessKey:           f.S3Config.AccessKey,
+			AccessSecret:        f.S3Config.AccessSecret.Clone(),
+			Endpoint:            f.S3Config.Endpoint,
+			StorageClass:        f.S3Config.StorageClass,
+			KeyPrefix:           f.S3Config.KeyPrefix,
+			UploadPartSize:      f.S3Config.UploadPartSize,
+			UploadConcurrency:   f.S3Config.UploadConcurrency,
+			UploadMaxBufferSize: f.S3Config.UploadMaxBufferSize,
+			DownloadPartSize:    f.S3Config.DownloadPartSize,
+			DownloadConcurrency: f.S3Config.DownloadConcurrency,
+			SSE:                 f.S3Config.SSE,
+			SSEKMSKeyID:         f.S3Config.SSEKMSKeyID,
+			SSECustomerKey:      f.S3Config.SSECustomerKey.Clone(),
+			CloudRequestConfig:  f.S3Config.CloudRequestConfig,
 		},
 		GCSConfig: GCSFsConfig{
 			Bucket:               f.GCSConfig.Bucket,
@@ -116,6 +153,7 @@ func (f *Filesystem) GetACopy() Filesystem {
 			AutomaticCredentials: f.GCSConfig.AutomaticCredentials,
 			StorageClass:         f.GCSConfig.StorageClass,
 			KeyPrefix:            f.GCSConfig.KeyPrefix,
+			CloudRequestConfig:   f.GCSConfig.CloudRequestConfig,
 		},
 		AzBlobConfig: AzBlobFsConfig{
 			Container:         f.AzBlobConfig.Container,
@@ -141,10 +179,31 @@ func (f *Filesystem) GetACopy() Filesystem {
 			DisableCouncurrentReads: f.SFTPConfig.DisableCouncurrentReads,
 			BufferSize:              f.SFTPConfig.BufferSize,
 		},
+		PluginConfig: PluginFsConfig{
+			Endpoint: f.PluginConfig.Endpoint,
+			Options:  f.PluginConfig.Options.Clone(),
+		},
+		SMBConfig: SMBFsConfig{
+			Endpoint: f.SMBConfig.Endpoint,
+			Share:    f.SMBConfig.Share,
+			Username: f.SMBConfig.Username,
+			Password: f.SMBConfig.Password.Clone(),
+			Domain:   f.SMBConfig.Domain,
+			Prefix:   f.SMBConfig.Prefix,
+		},
+		Versioning: f.Versioning,
 	}
 	if len(f.SFTPConfig.Fingerprints) > 0 {
 		fs.SFTPConfig.Fingerprints = make([]string, len(f.SFTPConfig.Fingerprints))
 		copy(fs.SFTPConfig.Fingerprints, f.SFTPConfig.Fingerprints)
 	}
+	fs.S3Config.Tags = copyS3Tags(f.S3Config.Tags)
+	for _, rule := range f.S3Config.UploadRules {
+		fs.S3Config.UploadRules = append(fs.S3Config.UploadRules, S3UploadRule{
+			Pattern:      rule.Pattern,
+			StorageClass: rule.StorageClass,
+			Tags:         copyS3Tags(rule.Tags),
+		})
+	}
 	return fs
 }
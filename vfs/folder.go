@@ -26,6 +26,8 @@ type BaseVirtualFolder struct {
 	Users []string `json:"users,omitempty"`
 	// Filesystem configuration details
 	FsConfig Filesystem `json:"filesystem"`
+	// Incremented on each update. Updates must provide the version they are based on
+	Version int64 `json:"version"`
 }
 
 // GetEncrytionAdditionalData returns the additional data to use for AEAD
@@ -52,6 +54,7 @@ func (v *BaseVirtualFolder) GetACopy() BaseVirtualFolder {
 		LastQuotaUpdate: v.LastQuotaUpdate,
 		Users:           users,
 		FsConfig:        v.FsConfig.GetACopy(),
+		Version:         v.Version,
 	}
 }
 
@@ -89,6 +92,10 @@ func (v *BaseVirtualFolder) GetStorageDescrition() string {
 		return fmt.Sprintf("Encrypted: %v", v.MappedPath)
 	case SFTPFilesystemProvider:
 		return fmt.Sprintf("SFTP: %v", v.FsConfig.SFTPConfig.Endpoint)
+	case PluginFilesystemProvider:
+		return fmt.Sprintf("Plugin: %v", v.FsConfig.PluginConfig.Endpoint)
+	case SMBFilesystemProvider:
+		return fmt.Sprintf("SMB: %v/%v", v.FsConfig.SMBConfig.Endpoint, v.FsConfig.SMBConfig.Share)
 	default:
 		return ""
 	}
@@ -104,6 +111,7 @@ func (v *BaseVirtualFolder) hideConfidentialData() {
 	switch v.FsConfig.Provider {
 	case S3FilesystemProvider:
 		v.FsConfig.S3Config.AccessSecret.Hide()
+		v.FsConfig.S3Config.SSECustomerKey.Hide()
 	case GCSFilesystemProvider:
 		v.FsConfig.GCSConfig.Credentials.Hide()
 	case AzureBlobFilesystemProvider:
@@ -113,6 +121,10 @@ func (v *BaseVirtualFolder) hideConfidentialData() {
 	case SFTPFilesystemProvider:
 		v.FsConfig.SFTPConfig.Password.Hide()
 		v.FsConfig.SFTPConfig.PrivateKey.Hide()
+	case PluginFilesystemProvider:
+		v.FsConfig.PluginConfig.Options.Hide()
+	case SMBFilesystemProvider:
+		v.FsConfig.SMBConfig.Password.Hide()
 	}
 }
 
@@ -131,6 +143,9 @@ func (v *BaseVirtualFolder) HasRedactedSecret() bool {
 		if v.FsConfig.S3Config.AccessSecret.IsRedacted() {
 			return true
 		}
+		if v.FsConfig.S3Config.SSECustomerKey.IsRedacted() {
+			return true
+		}
 	case GCSFilesystemProvider:
 		if v.FsConfig.GCSConfig.Credentials.IsRedacted() {
 			return true
@@ -150,6 +165,14 @@ func (v *BaseVirtualFolder) HasRedactedSecret() bool {
 		if v.FsConfig.SFTPConfig.PrivateKey.IsRedacted() {
 			return true
 		}
+	case PluginFilesystemProvider:
+		if v.FsConfig.PluginConfig.Options.IsRedacted() {
+			return true
+		}
+	case SMBFilesystemProvider:
+		if v.FsConfig.SMBConfig.Password.IsRedacted() {
+			return true
+		}
 	}
 	return false
 }
@@ -184,6 +207,10 @@ func (v *VirtualFolder) GetFilesystem(connectionID string, forbiddenSelfUsers []
 		return NewCryptFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.CryptConfig)
 	case SFTPFilesystemProvider:
 		return NewSFTPFs(connectionID, v.VirtualPath, v.MappedPath, forbiddenSelfUsers, v.FsConfig.SFTPConfig)
+	case PluginFilesystemProvider:
+		return NewPluginFs(connectionID, v.MappedPath, v.VirtualPath, v.FsConfig.PluginConfig)
+	case SMBFilesystemProvider:
+		return NewSMBFs(connectionID, v.VirtualPath, v.MappedPath, v.FsConfig.SMBConfig)
 	default:
 		return NewOsFs(connectionID, v.MappedPath, v.VirtualPath), nil
 	}
@@ -197,7 +224,7 @@ func (v *VirtualFolder) ScanQuota() (int, int64, error) {
 	}
 	defer fs.Close()
 
-	return fs.ScanRootDirContents()
+	return ScanQuota(fs)
 }
 
 // IsIncludedInUserQuota returns true if the virtual folder is included in user quota
@@ -0,0 +1,151 @@
+package fsplugin
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Client is the client for a storage plugin
+type Client struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewClient returns a client for the storage plugin reachable using the given connection
+func NewClient(cc grpc.ClientConnInterface) *Client {
+	return &Client{
+		cc: cc,
+	}
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req, resp interface{}) error {
+	return c.cc.Invoke(ctx, getFullMethod(method), req, resp, grpc.CallContentSubtype(CodecName))
+}
+
+// Stat returns the file info for the named file following symlinks
+func (c *Client) Stat(ctx context.Context, req *PathRequest) (*FileInfo, error) {
+	resp := new(FileInfo)
+	err := c.invoke(ctx, "Stat", req, resp)
+	return resp, err
+}
+
+// Lstat returns the file info for the named file without following symlinks
+func (c *Client) Lstat(ctx context.Context, req *PathRequest) (*FileInfo, error) {
+	resp := new(FileInfo)
+	err := c.invoke(ctx, "Lstat", req, resp)
+	return resp, err
+}
+
+// ReadDir returns the entries for the named directory
+func (c *Client) ReadDir(ctx context.Context, req *PathRequest) (*ReadDirResponse, error) {
+	resp := new(ReadDirResponse)
+	err := c.invoke(ctx, "ReadDir", req, resp)
+	return resp, err
+}
+
+// Readlink returns the destination of the named symbolic link
+func (c *Client) Readlink(ctx context.Context, req *PathRequest) (*ReadlinkResponse, error) {
+	resp := new(ReadlinkResponse)
+	err := c.invoke(ctx, "Readlink", req, resp)
+	return resp, err
+}
+
+// Mkdir creates the named directory
+func (c *Client) Mkdir(ctx context.Context, req *PathRequest) error {
+	return c.invoke(ctx, "Mkdir", req, new(Empty))
+}
+
+// Remove removes the named file or empty directory
+func (c *Client) Remove(ctx context.Context, req *PathRequest) error {
+	return c.invoke(ctx, "Remove", req, new(Empty))
+}
+
+// Rename renames (moves) source to target
+func (c *Client) Rename(ctx context.Context, req *RenameRequest) error {
+	return c.invoke(ctx, "Rename", req, new(Empty))
+}
+
+// Symlink creates source as a symbolic link to target
+func (c *Client) Symlink(ctx context.Context, req *RenameRequest) error {
+	return c.invoke(ctx, "Symlink", req, new(Empty))
+}
+
+// Setstat changes the attributes for the named file
+func (c *Client) Setstat(ctx context.Context, req *SetstatRequest) error {
+	return c.invoke(ctx, "Setstat", req, new(Empty))
+}
+
+// DirSize returns the number of files and their total size for the named directory
+func (c *Client) DirSize(ctx context.Context, req *PathRequest) (*DirSizeResponse, error) {
+	resp := new(DirSizeResponse)
+	err := c.invoke(ctx, "DirSize", req, resp)
+	return resp, err
+}
+
+// ReadFileClient is the client side stream for the ReadFile method
+type ReadFileClient interface {
+	Recv() (*DataChunk, error)
+}
+
+type readFileClient struct {
+	grpc.ClientStream
+}
+
+func (c *readFileClient) Recv() (*DataChunk, error) {
+	m := new(DataChunk)
+	if err := c.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ReadFile starts streaming the named file, the stream ends with io.EOF
+func (c *Client) ReadFile(ctx context.Context, req *ReadFileRequest) (ReadFileClient, error) {
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[0], getFullMethod("ReadFile"),
+		grpc.CallContentSubtype(CodecName))
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(req); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return &readFileClient{stream}, nil
+}
+
+// WriteFileClient is the client side stream for the WriteFile method
+type WriteFileClient interface {
+	Send(*WriteFileRequest) error
+	CloseAndRecv() (*WriteFileResponse, error)
+}
+
+type writeFileClient struct {
+	grpc.ClientStream
+}
+
+func (c *writeFileClient) Send(m *WriteFileRequest) error {
+	return c.ClientStream.SendMsg(m)
+}
+
+func (c *writeFileClient) CloseAndRecv() (*WriteFileResponse, error) {
+	if err := c.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(WriteFileResponse)
+	if err := c.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// WriteFile starts a stream to write a file
+func (c *Client) WriteFile(ctx context.Context) (WriteFileClient, error) {
+	stream, err := c.cc.NewStream(ctx, &serviceDesc.Streams[1], getFullMethod("WriteFile"),
+		grpc.CallContentSubtype(CodecName))
+	if err != nil {
+		return nil, err
+	}
+	return &writeFileClient{stream}, nil
+}
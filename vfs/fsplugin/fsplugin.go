@@ -0,0 +1,98 @@
+// Package fsplugin defines the RPC contract between SFTPGo and external storage plugins.
+// A storage plugin is a separate process that exposes a gRPC server implementing the
+// Filesystem interface. SFTPGo connects to the plugin using the endpoint configured for
+// each user or virtual folder and forwards all the filesystem operations to it.
+//
+// Messages are serialized as JSON so plugins can be implemented in any language with gRPC
+// support without requiring protobuf definitions: the service name, the method names and
+// the JSON messages defined in this package are the stable contract.
+package fsplugin
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is the gRPC service name that storage plugins must expose
+const ServiceName = "sftpgo.fsplugin.v1.Filesystem"
+
+// ErrUnsupported can be returned by plugins for unsupported operations
+var ErrUnsupported = errors.New("not supported")
+
+// Filesystem defines the interface that storage plugins must implement.
+// Returning errors wrapping os.ErrNotExist, os.ErrPermission or ErrUnsupported
+// allows SFTPGo to report the appropriate error to the clients.
+type Filesystem interface {
+	// Stat returns the file info for the named file following symlinks
+	Stat(ctx context.Context, req *PathRequest) (*FileInfo, error)
+	// Lstat returns the file info for the named file without following symlinks
+	Lstat(ctx context.Context, req *PathRequest) (*FileInfo, error)
+	// ReadDir returns the entries for the named directory
+	ReadDir(ctx context.Context, req *PathRequest) (*ReadDirResponse, error)
+	// Readlink returns the destination of the named symbolic link
+	Readlink(ctx context.Context, req *PathRequest) (*ReadlinkResponse, error)
+	// Mkdir creates the named directory
+	Mkdir(ctx context.Context, req *PathRequest) (*Empty, error)
+	// Remove removes the named file or empty directory
+	Remove(ctx context.Context, req *PathRequest) (*Empty, error)
+	// Rename renames (moves) source to target
+	Rename(ctx context.Context, req *RenameRequest) (*Empty, error)
+	// Symlink creates source as a symbolic link to target
+	Symlink(ctx context.Context, req *RenameRequest) (*Empty, error)
+	// Setstat changes the attributes for the named file
+	Setstat(ctx context.Context, req *SetstatRequest) (*Empty, error)
+	// DirSize returns the number of files and their total size for the named
+	// directory, including any sub directory
+	DirSize(ctx context.Context, req *PathRequest) (*DirSizeResponse, error)
+	// ReadFile streams the contents of the named file starting from the given offset
+	ReadFile(req *ReadFileRequest, stream ReadFileServer) error
+	// WriteFile receives the contents for a file. The first message contains the file
+	// name and the open flags, the following ones the file data
+	WriteFile(stream WriteFileServer) error
+}
+
+// ReadFileServer is the server side stream for the ReadFile method
+type ReadFileServer interface {
+	Send(*DataChunk) error
+	Context() context.Context
+}
+
+// WriteFileServer is the server side stream for the WriteFile method
+type WriteFileServer interface {
+	Recv() (*WriteFileRequest, error)
+	SendAndClose(*WriteFileResponse) error
+	Context() context.Context
+}
+
+// RegisterFilesystem registers the given plugin implementation to the gRPC server
+func RegisterFilesystem(s *grpc.Server, fs Filesystem) {
+	s.RegisterService(&serviceDesc, fs)
+}
+
+func toRPCError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, os.ErrPermission):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrUnsupported):
+		return status.Error(codes.Unimplemented, err.Error())
+	default:
+		return status.Error(codes.Unknown, err.Error())
+	}
+}
+
+func getFullMethod(method string) string {
+	return "/" + ServiceName + "/" + method
+}
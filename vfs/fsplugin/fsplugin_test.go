@@ -0,0 +1,445 @@
+package fsplugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const testOptions = `{"root":"/srv"}`
+
+// memoryFs is an in memory Filesystem implementation that records the
+// received options
+type memoryFs struct {
+	sync.Mutex
+	files   map[string][]byte
+	options []string
+}
+
+func newMemoryFs() *memoryFs {
+	return &memoryFs{
+		files: make(map[string][]byte),
+	}
+}
+
+func (fs *memoryFs) addOptions(options string) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	fs.options = append(fs.options, options)
+}
+
+func (fs *memoryFs) getFileInfo(name string) (*FileInfo, error) {
+	fs.Lock()
+	defer fs.Unlock()
+
+	data, ok := fs.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%#v: %w", name, os.ErrNotExist)
+	}
+	return &FileInfo{Name: name, Size: int64(len(data)), Mode: 0644, ModTime: 1000}, nil
+}
+
+func (fs *memoryFs) Stat(ctx context.Context, req *PathRequest) (*FileInfo, error) {
+	fs.addOptions(req.Options)
+	return fs.getFileInfo(req.Name)
+}
+
+func (fs *memoryFs) Lstat(ctx context.Context, req *PathRequest) (*FileInfo, error) {
+	fs.addOptions(req.Options)
+	return fs.getFileInfo(req.Name)
+}
+
+func (fs *memoryFs) ReadDir(ctx context.Context, req *PathRequest) (*ReadDirResponse, error) {
+	fs.addOptions(req.Options)
+	fs.Lock()
+	var names []string
+	for name := range fs.files {
+		names = append(names, name)
+	}
+	fs.Unlock()
+	sort.Strings(names)
+
+	resp := &ReadDirResponse{}
+	for _, name := range names {
+		info, err := fs.getFileInfo(name)
+		if err != nil {
+			return nil, err
+		}
+		resp.Entries = append(resp.Entries, *info)
+	}
+	return resp, nil
+}
+
+func (fs *memoryFs) Readlink(ctx context.Context, req *PathRequest) (*ReadlinkResponse, error) {
+	fs.addOptions(req.Options)
+	return &ReadlinkResponse{Target: req.Name + ".target"}, nil
+}
+
+func (fs *memoryFs) Mkdir(ctx context.Context, req *PathRequest) (*Empty, error) {
+	fs.addOptions(req.Options)
+	return nil, fmt.Errorf("mkdir %#v: %w", req.Name, os.ErrPermission)
+}
+
+func (fs *memoryFs) Remove(ctx context.Context, req *PathRequest) (*Empty, error) {
+	fs.addOptions(req.Options)
+	if req.IsDir {
+		return nil, ErrUnsupported
+	}
+	fs.Lock()
+	defer fs.Unlock()
+
+	if _, ok := fs.files[req.Name]; !ok {
+		return nil, os.ErrNotExist
+	}
+	delete(fs.files, req.Name)
+	return &Empty{}, nil
+}
+
+func (fs *memoryFs) Rename(ctx context.Context, req *RenameRequest) (*Empty, error) {
+	fs.addOptions(req.Options)
+	fs.Lock()
+	defer fs.Unlock()
+
+	data, ok := fs.files[req.Source]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	fs.files[req.Target] = data
+	delete(fs.files, req.Source)
+	return &Empty{}, nil
+}
+
+func (fs *memoryFs) Symlink(ctx context.Context, req *RenameRequest) (*Empty, error) {
+	fs.addOptions(req.Options)
+	return nil, status.Error(codes.AlreadyExists, "symlink already exists")
+}
+
+func (fs *memoryFs) Setstat(ctx context.Context, req *SetstatRequest) (*Empty, error) {
+	fs.addOptions(req.Options)
+	if req.Flags != SetstatFlagSize {
+		return nil, errors.New("unexpected setstat flags")
+	}
+	fs.Lock()
+	defer fs.Unlock()
+
+	data, ok := fs.files[req.Name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if req.Size < int64(len(data)) {
+		fs.files[req.Name] = data[:req.Size]
+	}
+	return &Empty{}, nil
+}
+
+func (fs *memoryFs) DirSize(ctx context.Context, req *PathRequest) (*DirSizeResponse, error) {
+	fs.addOptions(req.Options)
+	fs.Lock()
+	defer fs.Unlock()
+
+	resp := &DirSizeResponse{}
+	for _, data := range fs.files {
+		resp.Files++
+		resp.Size += int64(len(data))
+	}
+	return resp, nil
+}
+
+func (fs *memoryFs) ReadFile(req *ReadFileRequest, stream ReadFileServer) error {
+	fs.addOptions(req.Options)
+	fs.Lock()
+	data, ok := fs.files[req.Name]
+	fs.Unlock()
+	if !ok {
+		return os.ErrNotExist
+	}
+	if req.Offset > int64(len(data)) {
+		return errors.New("invalid offset")
+	}
+	data = data[req.Offset:]
+	// send the data using small chunks to test the streaming
+	for len(data) > 0 {
+		n := 4
+		if n > len(data) {
+			n = len(data)
+		}
+		if err := stream.Send(&DataChunk{Data: data[:n]}); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (fs *memoryFs) WriteFile(stream WriteFileServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	fs.addOptions(req.Options)
+	if req.Name == "" {
+		return errors.New("the first message must contain the file name")
+	}
+	var buf bytes.Buffer
+	buf.Write(req.Data)
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		buf.Write(chunk.Data)
+	}
+	fs.Lock()
+	fs.files[req.Name] = buf.Bytes()
+	fs.Unlock()
+	return stream.SendAndClose(&WriteFileResponse{Written: int64(buf.Len())})
+}
+
+func getTestClient(t *testing.T, fs Filesystem) *Client {
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	RegisterFilesystem(server, fs)
+	go server.Serve(listener) //nolint:errcheck
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return NewClient(conn)
+}
+
+func writeTestFile(ctx context.Context, t *testing.T, client *Client, name string, chunks ...[]byte) {
+	stream, err := client.WriteFile(ctx)
+	require.NoError(t, err)
+	err = stream.Send(&WriteFileRequest{Options: testOptions, Name: name})
+	require.NoError(t, err)
+	size := 0
+	for _, chunk := range chunks {
+		err = stream.Send(&WriteFileRequest{Data: chunk})
+		require.NoError(t, err)
+		size += len(chunk)
+	}
+	resp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(size), resp.Written)
+}
+
+func readTestFile(ctx context.Context, t *testing.T, client *Client, name string, offset int64) ([]byte, error) {
+	stream, err := client.ReadFile(ctx, &ReadFileRequest{Options: testOptions, Name: name, Offset: offset})
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	for {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			return buf.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(chunk.Data)
+	}
+}
+
+func TestServiceDescriptor(t *testing.T) {
+	assert.Equal(t, ServiceName, serviceDesc.ServiceName)
+	assert.Equal(t, "/sftpgo.fsplugin.v1.Filesystem/Stat", getFullMethod("Stat"))
+	// each Filesystem method must be exposed by the service
+	var methods []string
+	for _, m := range serviceDesc.Methods {
+		methods = append(methods, m.MethodName)
+	}
+	for _, s := range serviceDesc.Streams {
+		methods = append(methods, s.StreamName)
+	}
+	fsType := reflect.TypeOf((*Filesystem)(nil)).Elem()
+	var expected []string
+	for i := 0; i < fsType.NumMethod(); i++ {
+		expected = append(expected, fsType.Method(i).Name)
+	}
+	assert.ElementsMatch(t, expected, methods)
+	assert.True(t, serviceDesc.Streams[0].ServerStreams)
+	assert.False(t, serviceDesc.Streams[0].ClientStreams)
+	assert.Equal(t, "ReadFile", serviceDesc.Streams[0].StreamName)
+	assert.True(t, serviceDesc.Streams[1].ClientStreams)
+	assert.False(t, serviceDesc.Streams[1].ServerStreams)
+	assert.Equal(t, "WriteFile", serviceDesc.Streams[1].StreamName)
+}
+
+func TestJSONCodec(t *testing.T) {
+	codec := encoding.GetCodec(CodecName)
+	require.NotNil(t, codec)
+	assert.Equal(t, CodecName, codec.Name())
+
+	req := &SetstatRequest{
+		Options: testOptions,
+		Name:    "/file",
+		Flags:   SetstatFlagMode | SetstatFlagTimes,
+		Mode:    0600,
+		Mtime:   1000,
+	}
+	data, err := codec.Marshal(req)
+	require.NoError(t, err)
+	// the JSON messages are the contract with the plugins
+	assert.JSONEq(t, `{"options":"{\"root\":\"/srv\"}","name":"/file","flags":6,"mode":384,"mtime":1000}`, string(data))
+	decoded := new(SetstatRequest)
+	err = codec.Unmarshal(data, decoded)
+	require.NoError(t, err)
+	assert.Equal(t, req, decoded)
+
+	chunk := &DataChunk{Data: []byte("data")}
+	data, err = codec.Marshal(chunk)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"data":"ZGF0YQ=="}`, string(data))
+	err = codec.Unmarshal([]byte("invalid"), chunk)
+	assert.Error(t, err)
+}
+
+func TestRoundTrip(t *testing.T) {
+	fs := newMemoryFs()
+	client := getTestClient(t, fs)
+	ctx := context.Background()
+
+	writeTestFile(ctx, t, client, "/file", []byte("hello "), []byte("world"))
+	info, err := client.Stat(ctx, &PathRequest{Options: testOptions, Name: "/file"})
+	require.NoError(t, err)
+	assert.Equal(t, FileInfo{Name: "/file", Size: 11, Mode: 0644, ModTime: 1000}, *info)
+	info, err = client.Lstat(ctx, &PathRequest{Options: testOptions, Name: "/file"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), info.Size)
+
+	data, err := readTestFile(ctx, t, client, "/file", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(data))
+	data, err = readTestFile(ctx, t, client, "/file", 6)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(data))
+	data, err = readTestFile(ctx, t, client, "/file", 11)
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	err = client.Rename(ctx, &RenameRequest{Options: testOptions, Source: "/file", Target: "/file1"})
+	require.NoError(t, err)
+	writeTestFile(ctx, t, client, "/file2")
+	entries, err := client.ReadDir(ctx, &PathRequest{Options: testOptions, Name: "/"})
+	require.NoError(t, err)
+	if assert.Len(t, entries.Entries, 2) {
+		assert.Equal(t, "/file1", entries.Entries[0].Name)
+		assert.Equal(t, int64(11), entries.Entries[0].Size)
+		assert.Equal(t, "/file2", entries.Entries[1].Name)
+		assert.Equal(t, int64(0), entries.Entries[1].Size)
+	}
+	link, err := client.Readlink(ctx, &PathRequest{Options: testOptions, Name: "/link"})
+	require.NoError(t, err)
+	assert.Equal(t, "/link.target", link.Target)
+
+	err = client.Setstat(ctx, &SetstatRequest{Options: testOptions, Name: "/file1", Flags: SetstatFlagSize, Size: 5})
+	require.NoError(t, err)
+	dirSize, err := client.DirSize(ctx, &PathRequest{Options: testOptions, Name: "/"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, dirSize.Files)
+	assert.Equal(t, int64(5), dirSize.Size)
+
+	err = client.Remove(ctx, &PathRequest{Options: testOptions, Name: "/file2"})
+	require.NoError(t, err)
+	_, err = client.Stat(ctx, &PathRequest{Options: testOptions, Name: "/file2"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	// the options are sent with each request
+	fs.Lock()
+	assert.Greater(t, len(fs.options), 10)
+	for _, options := range fs.options {
+		assert.Equal(t, testOptions, options)
+	}
+	fs.Unlock()
+}
+
+func TestRoundTripErrors(t *testing.T) {
+	client := getTestClient(t, newMemoryFs())
+	ctx := context.Background()
+
+	_, err := client.Stat(ctx, &PathRequest{Name: "/missing"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "/missing")
+	err = client.Mkdir(ctx, &PathRequest{Name: "/dir"})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	err = client.Remove(ctx, &PathRequest{Name: "/dir", IsDir: true})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+	// the status errors returned by the plugins are preserved
+	err = client.Symlink(ctx, &RenameRequest{Source: "/file", Target: "/link"})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	err = client.Setstat(ctx, &SetstatRequest{Name: "/file", Flags: SetstatFlagMode})
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Equal(t, "unexpected setstat flags", status.Convert(err).Message())
+
+	_, err = readTestFile(ctx, t, client, "/missing", 0)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	// a write stream without the file name is refused
+	stream, err := client.WriteFile(ctx)
+	require.NoError(t, err)
+	err = stream.Send(&WriteFileRequest{Data: []byte("data")})
+	require.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	// a canceled context is reported
+	canceledCtx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = client.Stat(canceledCtx, &PathRequest{Name: "/file"})
+	assert.Equal(t, codes.Canceled, status.Code(err))
+}
+
+func TestUnaryInterceptor(t *testing.T) {
+	listener := bufconn.Listen(1024 * 1024)
+	var methods []string
+	var mu sync.Mutex
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{},
+		info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		mu.Lock()
+		methods = append(methods, info.FullMethod)
+		mu.Unlock()
+		return handler(ctx, req)
+	}))
+	RegisterFilesystem(server, newMemoryFs())
+	go server.Serve(listener) //nolint:errcheck
+	defer server.Stop()
+
+	conn, err := grpc.DialContext(context.Background(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	client := NewClient(conn)
+	_, err = client.DirSize(context.Background(), &PathRequest{Name: "/"})
+	require.NoError(t, err)
+	mu.Lock()
+	assert.Equal(t, []string{getFullMethod("DirSize")}, methods)
+	mu.Unlock()
+}
@@ -0,0 +1,96 @@
+package fsplugin
+
+// Setstat flags, they define the attributes to change
+const (
+	SetstatFlagOwner uint32 = 1 << iota
+	SetstatFlagMode
+	SetstatFlagTimes
+	SetstatFlagSize
+)
+
+// Empty is the response for methods without a result
+type Empty struct{}
+
+// FileInfo describes a file
+type FileInfo struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Mode is the os.FileMode for the file, it includes the type bits
+	Mode uint32 `json:"mode"`
+	// ModTime is the modification time as unix timestamp in milliseconds
+	ModTime int64 `json:"mod_time"`
+}
+
+// PathRequest is the request for methods that operates on a single path
+type PathRequest struct {
+	// Options is the plugin specific configuration for the user or folder
+	Options string `json:"options,omitempty"`
+	Name    string `json:"name"`
+	// IsDir is set for the Remove method if the path to remove is a directory
+	IsDir bool `json:"is_dir,omitempty"`
+}
+
+// RenameRequest is the request for the Rename and Symlink methods
+type RenameRequest struct {
+	Options string `json:"options,omitempty"`
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+}
+
+// SetstatRequest is the request for the Setstat method
+type SetstatRequest struct {
+	Options string `json:"options,omitempty"`
+	Name    string `json:"name"`
+	// Flags defines the attributes to change
+	Flags uint32 `json:"flags"`
+	UID   int    `json:"uid,omitempty"`
+	GID   int    `json:"gid,omitempty"`
+	Mode  uint32 `json:"mode,omitempty"`
+	// Atime and Mtime are unix timestamps in milliseconds
+	Atime int64 `json:"atime,omitempty"`
+	Mtime int64 `json:"mtime,omitempty"`
+	Size  int64 `json:"size,omitempty"`
+}
+
+// ReadDirResponse is the response for the ReadDir method
+type ReadDirResponse struct {
+	Entries []FileInfo `json:"entries"`
+}
+
+// ReadlinkResponse is the response for the Readlink method
+type ReadlinkResponse struct {
+	Target string `json:"target"`
+}
+
+// DirSizeResponse is the response for the DirSize method
+type DirSizeResponse struct {
+	Files int   `json:"files"`
+	Size  int64 `json:"size"`
+}
+
+// ReadFileRequest is the request for the ReadFile method
+type ReadFileRequest struct {
+	Options string `json:"options,omitempty"`
+	Name    string `json:"name"`
+	Offset  int64  `json:"offset,omitempty"`
+}
+
+// DataChunk is a chunk of file data sent by the ReadFile method
+type DataChunk struct {
+	Data []byte `json:"data"`
+}
+
+// WriteFileRequest is a message for the WriteFile method.
+// Options, Name and Flag are only set in the first message
+type WriteFileRequest struct {
+	Options string `json:"options,omitempty"`
+	Name    string `json:"name,omitempty"`
+	// Flag are the os.OpenFile flags, 0 means create or truncate
+	Flag int    `json:"flag,omitempty"`
+	Data []byte `json:"data,omitempty"`
+}
+
+// WriteFileResponse is the response for the WriteFile method
+type WriteFileResponse struct {
+	Written int64 `json:"written"`
+}
@@ -0,0 +1,182 @@
+package fsplugin
+
+import (
+	"context"
+	"encoding/json"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+)
+
+// CodecName is the name of the JSON codec used to serialize the plugin messages.
+// It is also used by the other SFTPGo plugin services
+const CodecName = "json"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return CodecName
+}
+
+func newUnaryHandler(method string, newRequest func() interface{},
+	call func(Filesystem, context.Context, interface{}) (interface{}, error),
+) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+		interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		req := newRequest()
+		if err := dec(req); err != nil {
+			return nil, err
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			resp, err := call(srv.(Filesystem), ctx, req)
+			return resp, toRPCError(err)
+		}
+		if interceptor == nil {
+			return handler(ctx, req)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: getFullMethod(method),
+		}
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+func newPathRequest() interface{} {
+	return new(PathRequest)
+}
+
+func newRenameRequest() interface{} {
+	return new(RenameRequest)
+}
+
+func newSetstatRequest() interface{} {
+	return new(SetstatRequest)
+}
+
+type readFileServer struct {
+	grpc.ServerStream
+}
+
+func (s *readFileServer) Send(m *DataChunk) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+func readFileHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(ReadFileRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return toRPCError(srv.(Filesystem).ReadFile(req, &readFileServer{stream}))
+}
+
+type writeFileServer struct {
+	grpc.ServerStream
+}
+
+func (s *writeFileServer) Recv() (*WriteFileRequest, error) {
+	m := new(WriteFileRequest)
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (s *writeFileServer) SendAndClose(m *WriteFileResponse) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+func writeFileHandler(srv interface{}, stream grpc.ServerStream) error {
+	return toRPCError(srv.(Filesystem).WriteFile(&writeFileServer{stream}))
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Filesystem)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Stat",
+			Handler: newUnaryHandler("Stat", newPathRequest, func(fs Filesystem, ctx context.Context, req interface{}) (interface{}, error) {
+				return fs.Stat(ctx, req.(*PathRequest))
+			}),
+		},
+		{
+			MethodName: "Lstat",
+			Handler: newUnaryHandler("Lstat", newPathRequest, func(fs Filesystem, ctx context.Context, req interface{}) (interface{}, error) {
+				return fs.Lstat(ctx, req.(*PathRequest))
+			}),
+		},
+		{
+			MethodName: "ReadDir",
+			Handler: newUnaryHandler("ReadDir", newPathRequest, func(fs Filesystem, ctx context.Context, req interface{}) (interface{}, error) {
+				return fs.ReadDir(ctx, req.(*PathRequest))
+			}),
+		},
+		{
+			MethodName: "Readlink",
+			Handler: newUnaryHandler("Readlink", newPathRequest, func(fs Filesystem, ctx context.Context, req interface{}) (interface{}, error) {
+				return fs.Readlink(ctx, req.(*PathRequest))
+			}),
+		},
+		{
+			MethodName: "Mkdir",
+			Handler: newUnaryHandler("Mkdir", newPathRequest, func(fs Filesystem, ctx context.Context, req interface{}) (interface{}, error) {
+				return fs.Mkdir(ctx, req.(*PathRequest))
+			}),
+		},
+		{
+			MethodName: "Remove",
+			Handler: newUnaryHandler("Remove", newPathRequest, func(fs Filesystem, ctx context.Context, req interface{}) (interface{}, error) {
+				return fs.Remove(ctx, req.(*PathRequest))
+			}),
+		},
+		{
+			MethodName: "Rename",
+			Handler: newUnaryHandler("Rename", newRenameRequest, func(fs Filesystem, ctx context.Context, req interface{}) (interface{}, error) {
+				return fs.Rename(ctx, req.(*RenameRequest))
+			}),
+		},
+		{
+			MethodName: "Symlink",
+			Handler: newUnaryHandler("Symlink", newRenameRequest, func(fs Filesystem, ctx context.Context, req interface{}) (interface{}, error) {
+				return fs.Symlink(ctx, req.(*RenameRequest))
+			}),
+		},
+		{
+			MethodName: "Setstat",
+			Handler: newUnaryHandler("Setstat", newSetstatRequest, func(fs Filesystem, ctx context.Context, req interface{}) (interface{}, error) {
+				return fs.Setstat(ctx, req.(*SetstatRequest))
+			}),
+		},
+		{
+			MethodName: "DirSize",
+			Handler: newUnaryHandler("DirSize", newPathRequest, func(fs Filesystem, ctx context.Context, req interface{}) (interface{}, error) {
+				return fs.DirSize(ctx, req.(*PathRequest))
+			}),
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ReadFile",
+			Handler:       readFileHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WriteFile",
+			Handler:       writeFileHandler,
+			ClientStreams: true,
+		},
+	},
+}
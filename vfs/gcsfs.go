@@ -19,9 +19,11 @@ import (
 	"cloud.google.com/go/storage"
 	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
+	"github.com/rs/xid"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
 
 	"github.com/drakkan/sftpgo/kms"
 	"github.com/drakkan/sftpgo/logger"
@@ -29,6 +31,9 @@ import (
 	"github.com/drakkan/sftpgo/version"
 )
 
+// each server side copy is retried this number of times if no progress is made
+const gcsCopyMaxRetries = 3
+
 var (
 	gcsDefaultFieldsSelection = []string{"Name", "Size", "Deleted", "Updated", "ContentType"}
 )
@@ -57,43 +62,72 @@ func NewGCSFs(connectionID, localTempDir, mountPath string, config GCSFsConfig)
 
 	var err error
 	fs := &GCSFs{
-		connectionID:   connectionID,
-		localTempDir:   localTempDir,
-		mountPath:      mountPath,
-		config:         &config,
-		ctxTimeout:     30 * time.Second,
-		ctxLongTimeout: 300 * time.Second,
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    mountPath,
+		config:       &config,
 	}
 	if err = fs.config.Validate(fs.config.CredentialFile); err != nil {
 		return fs, err
 	}
+	fs.ctxTimeout, fs.ctxLongTimeout = fs.config.getTimeouts()
 	ctx := context.Background()
-	if fs.config.AutomaticCredentials > 0 {
-		fs.svc, err = storage.NewClient(ctx)
-	} else if !fs.config.Credentials.IsEmpty() {
-		err = fs.config.Credentials.TryDecrypt()
-		if err != nil {
-			return fs, err
-		}
-		fs.svc, err = storage.NewClient(ctx, option.WithCredentialsJSON([]byte(fs.config.Credentials.GetPayload())))
-	} else {
-		var creds []byte
-		creds, err = os.ReadFile(fs.config.CredentialFile)
-		if err != nil {
-			return fs, err
+	var opts []option.ClientOption
+	if fs.config.AutomaticCredentials == 0 {
+		if !fs.config.Credentials.IsEmpty() {
+			err = fs.config.Credentials.TryDecrypt()
+			if err != nil {
+				return fs, err
+			}
+			opts = append(opts, option.WithCredentialsJSON([]byte(fs.config.Credentials.GetPayload())))
+		} else {
+			var creds []byte
+			creds, err = os.ReadFile(fs.config.CredentialFile)
+			if err != nil {
+				return fs, err
+			}
+			secret := kms.NewEmptySecret()
+			err = json.Unmarshal(creds, secret)
+			if err != nil {
+				return fs, err
+			}
+			err = secret.Decrypt()
+			if err != nil {
+				return fs, err
+			}
+			opts = append(opts, option.WithCredentialsJSON([]byte(secret.GetPayload())))
 		}
-		secret := kms.NewEmptySecret()
-		err = json.Unmarshal(creds, secret)
+	}
+	if fs.config.isCustomized() {
+		opts, err = fs.getHTTPClientOptions(ctx, opts)
 		if err != nil {
 			return fs, err
 		}
-		err = secret.Decrypt()
-		if err != nil {
-			return fs, err
+	}
+	fs.svc, err = storage.NewClient(ctx, opts...)
+	if err != nil {
+		return fs, err
+	}
+	return newCachedFs(fs, fmt.Sprintf("gcs %v", fs.config.Bucket)), nil
+}
+
+// getHTTPClientOptions returns the client options to use an HTTP client with
+// the configured timeout and retries. The retries are added below the
+// authentication layer, the client library retries some requests on its own
+func (fs *GCSFs) getHTTPClientOptions(ctx context.Context, opts []option.ClientOption) ([]option.ClientOption, error) {
+	var base http.RoundTripper = fs.config.getHTTPTransport()
+	if fs.config.MaxRetries > 0 {
+		base = &retryTransport{
+			base:       base,
+			maxRetries: fs.config.MaxRetries,
+			backoff:    fs.config.getRetryBackoff(),
 		}
-		fs.svc, err = storage.NewClient(ctx, option.WithCredentialsJSON([]byte(secret.GetPayload())))
 	}
-	return fs, err
+	transport, err := htransport.NewTransport(ctx, base, append(opts, option.WithScopes(storage.ScopeFullControl))...)
+	if err != nil {
+		return nil, err
+	}
+	return []option.ClientOption{option.WithHTTPClient(&http.Client{Transport: transport})}, nil
 }
 
 // Name returns the name for the Fs implementation
@@ -106,8 +140,54 @@ func (fs *GCSFs) ConnectionID() string {
 	return fs.connectionID
 }
 
-// Stat returns a FileInfo describing the named file
+// Stat returns a FileInfo describing the named file, emulated symlinks are followed
 func (fs *GCSFs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Lstat(name)
+	if err == nil && info.Mode()&os.ModeSymlink == 0 {
+		return info, nil
+	}
+	if err != nil && !fs.IsNotExist(err) {
+		return info, err
+	}
+	resolved, errResolve := resolveObjectSymlinks(name, fs.getSymlinkTarget, fs.IsNotExist)
+	if errResolve != nil {
+		return nil, errResolve
+	}
+	if resolved == name {
+		return info, err
+	}
+	info, err = fs.lstat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileInfo(name, info.IsDir(), info.Size(), info.ModTime(), false), nil
+}
+
+// Lstat returns a FileInfo describing the named file, emulated symlinks are not
+// followed unless they are parent directories of the named file
+func (fs *GCSFs) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.lstat(name)
+	if err == nil || !fs.IsNotExist(err) {
+		return info, err
+	}
+	resolved, found, errResolve := resolveParentSymlink(name, fs.getSymlinkTarget, fs.IsNotExist)
+	if errResolve != nil {
+		return nil, errResolve
+	}
+	if !found {
+		return info, err
+	}
+	info, err = fs.lstat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return newSymlinkFileInfo(name, info.ModTime()), nil
+	}
+	return NewFileInfo(name, info.IsDir(), info.Size(), info.ModTime(), false), nil
+}
+
+func (fs *GCSFs) lstat(name string) (os.FileInfo, error) {
 	var result *FileInfo
 	var err error
 	if name == "" || name == "." {
@@ -124,6 +204,9 @@ func (fs *GCSFs) Stat(name string) (os.FileInfo, error) {
 	if err == nil {
 		objSize := attrs.Size
 		objectModTime := attrs.Updated
+		if getGCSSymlinkTarget(attrs) != "" {
+			return newSymlinkFileInfo(name, objectModTime), nil
+		}
 		isDir := attrs.ContentType == dirMimeType || strings.HasSuffix(attrs.Name, "/")
 		return NewFileInfo(name, isDir, objSize, objectModTime, false), nil
 	}
@@ -141,13 +224,12 @@ func (fs *GCSFs) Stat(name string) (os.FileInfo, error) {
 	return nil, errors.New("404 no such file or directory")
 }
 
-// Lstat returns a FileInfo describing the named file
-func (fs *GCSFs) Lstat(name string) (os.FileInfo, error) {
-	return fs.Stat(name)
-}
-
 // Open opens the named file for reading
 func (fs *GCSFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	name, err := resolveObjectSymlinks(name, fs.getSymlinkTarget, fs.IsNotExist)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
@@ -225,7 +307,7 @@ func (fs *GCSFs) Rename(source, target string) error {
 	if source == target {
 		return nil
 	}
-	fi, err := fs.Stat(source)
+	fi, err := fs.Lstat(source)
 	if err != nil {
 		return err
 	}
@@ -240,8 +322,6 @@ func (fs *GCSFs) Rename(source, target string) error {
 	}
 	src := fs.svc.Bucket(fs.config.Bucket).Object(source)
 	dst := fs.svc.Bucket(fs.config.Bucket).Object(target)
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-	defer cancelFn()
 	copier := dst.CopierFrom(src)
 	if fs.config.StorageClass != "" {
 		copier.StorageClass = fs.config.StorageClass
@@ -249,13 +329,25 @@ func (fs *GCSFs) Rename(source, target string) error {
 	var contentType string
 	if fi.IsDir() {
 		contentType = dirMimeType
+	} else if fi.Mode()&os.ModeSymlink != 0 {
+		// the metadata are replaced with the ones set for the copier
+		symlinkTarget, err := fs.getSymlinkTarget(source)
+		if err != nil {
+			return err
+		}
+		contentType = symlinkMimeType
+		copier.Metadata = map[string]string{symlinkTargetMetadataKey: symlinkTarget}
 	} else {
 		contentType = mime.TypeByExtension(path.Ext(source))
 	}
 	if contentType != "" {
 		copier.ContentType = contentType
 	}
-	_, err = copier.Run(ctx)
+	copier.ProgressFunc = func(copiedBytes, totalBytes uint64) {
+		fsLog(fs, logger.LevelDebug, "copy from %#v to %#v in progress, copied bytes: %v/%v",
+			source, target, copiedBytes, totalBytes)
+	}
+	err = fs.runCopier(copier)
 	metrics.GCSCopyObjectCompleted(err)
 	if err != nil {
 		return err
@@ -263,6 +355,31 @@ func (fs *GCSFs) Rename(source, target string) error {
 	return fs.Remove(source, fi.IsDir())
 }
 
+// runCopier executes the server side copy. Big objects are copied using
+// multiple rewrite calls: if a call fails, the copy is resumed from the
+// last returned rewrite token instead of restarting from the beginning.
+// The copy fails if there is no progress after gcsCopyMaxRetries attempts
+func (fs *GCSFs) runCopier(copier *storage.Copier) error {
+	var err error
+	failedAttempts := 0
+	for failedAttempts < gcsCopyMaxRetries {
+		lastToken := copier.RewriteToken
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+		_, err = copier.Run(ctx)
+		cancelFn()
+		if err == nil {
+			return nil
+		}
+		if copier.RewriteToken == "" || copier.RewriteToken == lastToken {
+			failedAttempts++
+		} else {
+			failedAttempts = 0
+		}
+		fsLog(fs, logger.LevelWarn, "copy attempt failed, resumable: %v, err: %v", copier.RewriteToken != "", err)
+	}
+	return err
+}
+
 // Remove removes the named file or (empty) directory.
 func (fs *GCSFs) Remove(name string, isDir bool) error {
 	if isDir {
@@ -301,13 +418,47 @@ func (*GCSFs) MkdirAll(name string, uid int, gid int) error {
 }
 
 // Symlink creates source as a symbolic link to target.
-func (*GCSFs) Symlink(source, target string) error {
-	return ErrVfsUnsupported
+// The symlink is emulated using a zero bytes object with the source stored as metadata
+func (fs *GCSFs) Symlink(source, target string) error {
+	_, err := fs.lstat(target)
+	if err == nil {
+		return fmt.Errorf("unable to create symlink, %#v already exists", target)
+	}
+	if !fs.IsNotExist(err) {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	objectWriter := fs.svc.Bucket(fs.config.Bucket).Object(target).NewWriter(ctx)
+	objectWriter.ObjectAttrs.ContentType = symlinkMimeType
+	objectWriter.ObjectAttrs.Metadata = map[string]string{symlinkTargetMetadataKey: source}
+	if fs.config.StorageClass != "" {
+		objectWriter.ObjectAttrs.StorageClass = fs.config.StorageClass
+	}
+	err = objectWriter.Close()
+	metrics.GCSTransferCompleted(0, 0, err)
+	return err
 }
 
 // Readlink returns the destination of the named symbolic link
-func (*GCSFs) Readlink(name string) (string, error) {
-	return "", ErrVfsUnsupported
+func (fs *GCSFs) Readlink(name string) (string, error) {
+	target, err := fs.getSymlinkTarget(name)
+	if err != nil {
+		return "", err
+	}
+	if target == "" {
+		return "", fmt.Errorf("%#v is not a symlink", name)
+	}
+	return fs.GetRelativePath(target), nil
+}
+
+func (fs *GCSFs) getSymlinkTarget(name string) (string, error) {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return "", err
+	}
+	return getGCSSymlinkTarget(attrs), nil
 }
 
 // Chown changes the numeric uid and gid of the named file.
@@ -335,7 +486,42 @@ func (*GCSFs) Truncate(name string, size int64) error {
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
 func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
-	var result []os.FileInfo
+	lister, err := fs.OpenDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return readAllFromLister(lister)
+}
+
+// OpenDir opens the named directory, its entries are fetched one page
+// at a time using the returned lister
+func (fs *GCSFs) OpenDir(dirname string) (DirLister, error) {
+	lister, err := fs.newDirLister(dirname)
+	if err != nil {
+		return nil, err
+	}
+	isEmpty, err := lister.isEmpty()
+	if err != nil {
+		lister.Close()
+		return nil, err
+	}
+	if !isEmpty {
+		return lister, nil
+	}
+	// an empty listing could be an emulated symlink to a directory
+	resolved, err := resolveObjectSymlinks(dirname, fs.getSymlinkTarget, fs.IsNotExist)
+	if err != nil || resolved == dirname {
+		return lister, nil
+	}
+	lister.Close()
+	lister, err = fs.newDirLister(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return lister, nil
+}
+
+func (fs *GCSFs) newDirLister(dirname string) (*pagedDirLister, error) {
 	// dirname must be already cleaned
 	prefix := fs.getPrefix(dirname)
 
@@ -346,67 +532,74 @@ func (fs *GCSFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	}
 
 	prefixes := make(map[string]bool)
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-	defer cancelFn()
+	// the context must be valid until the lister is closed, the iterator
+	// uses it to fetch the next pages
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
 
 	bkt := fs.svc.Bucket(fs.config.Bucket)
 	it := bkt.Objects(ctx, query)
-	for {
-		attrs, err := it.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			metrics.GCSListObjectsCompleted(err)
-			return result, err
-		}
-		if attrs.Prefix != "" {
-			name, _ := fs.resolve(attrs.Prefix, prefix)
-			if name == "" {
-				continue
-			}
-			if _, ok := prefixes[name]; ok {
-				continue
-			}
-			result = append(result, NewFileInfo(name, true, 0, time.Now(), false))
-			prefixes[name] = true
-		} else {
-			name, isDir := fs.resolve(attrs.Name, prefix)
-			if name == "" {
-				continue
-			}
-			if !attrs.Deleted.IsZero() {
-				continue
+
+	return newPagedDirLister(func() ([]os.FileInfo, bool, error) {
+		var result []os.FileInfo
+		for len(result) < ListerBatchSize {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				metrics.GCSListObjectsCompleted(nil)
+				return result, false, nil
 			}
-			if attrs.ContentType == dirMimeType {
-				isDir = true
+			if err != nil {
+				metrics.GCSListObjectsCompleted(err)
+				return nil, false, err
 			}
-			if isDir {
-				// check if the dir is already included, it will be sent as blob prefix if it contains at least one item
+			if attrs.Prefix != "" {
+				name, _ := fs.resolve(attrs.Prefix, prefix)
+				if name == "" {
+					continue
+				}
 				if _, ok := prefixes[name]; ok {
 					continue
 				}
+				result = append(result, NewFileInfo(name, true, 0, time.Now(), false))
 				prefixes[name] = true
+			} else {
+				name, isDir := fs.resolve(attrs.Name, prefix)
+				if name == "" {
+					continue
+				}
+				if !attrs.Deleted.IsZero() {
+					continue
+				}
+				if attrs.ContentType == symlinkMimeType && !isDir {
+					result = append(result, newSymlinkFileInfo(name, attrs.Updated))
+					continue
+				}
+				if attrs.ContentType == dirMimeType {
+					isDir = true
+				}
+				if isDir {
+					// check if the dir is already included, it will be sent as blob prefix if it contains at least one item
+					if _, ok := prefixes[name]; ok {
+						continue
+					}
+					prefixes[name] = true
+				}
+				fi := NewFileInfo(name, isDir, attrs.Size, attrs.Updated, false)
+				result = append(result, fi)
 			}
-			fi := NewFileInfo(name, isDir, attrs.Size, attrs.Updated, false)
-			result = append(result, fi)
 		}
-	}
-	metrics.GCSListObjectsCompleted(nil)
-	return result, nil
+		return result, true, nil
+	}, cancelFn), nil
 }
 
-// IsUploadResumeSupported returns true if resuming uploads is supported.
-// Resuming uploads is not supported on GCS
-func (*GCSFs) IsUploadResumeSupported() bool {
-	return false
-}
-
-// IsAtomicUploadSupported returns true if atomic upload is supported.
-// S3 uploads are already atomic, we don't need to upload to a temporary
-// file
-func (*GCSFs) IsAtomicUploadSupported() bool {
-	return false
+// Capabilities returns the features supported by this filesystem.
+// GCS uploads are emulated as atomic: the file is uploaded to a temporary
+// object and then renamed using a server side copy
+func (*GCSFs) Capabilities() FsCapabilities {
+	return FsCapabilities{
+		AtomicUpload: true,
+		Symlinks:     true,
+		RangeReads:   true,
+	}
 }
 
 // IsNotExist returns a boolean indicating whether the error is known to
@@ -467,9 +660,14 @@ func (fs *GCSFs) ScanRootDirContents() (int, int64, error) {
 	}
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
 	defer cancelFn()
+	throttle := getQuotaScanThrottle()
 	bkt := fs.svc.Bucket(fs.config.Bucket)
 	it := bkt.Objects(ctx, query)
 	for {
+		if it.PageInfo().Remaining() == 0 {
+			// no buffered objects, the next call will fetch a new page
+			throttle.waitRequest()
+		}
 		attrs, err := it.Next()
 		if err == iterator.Done {
 			break
@@ -485,6 +683,7 @@ func (fs *GCSFs) ScanRootDirContents() (int, int64, error) {
 		if isDir && attrs.Size == 0 {
 			continue
 		}
+		throttle.waitFiles(1)
 		numFiles++
 		size += attrs.Size
 	}
@@ -499,9 +698,11 @@ func (*GCSFs) GetDirSize(dirname string) (int, int64, error) {
 }
 
 // GetAtomicUploadPath returns the path to use for an atomic upload.
-// GCS uploads are already atomic, we never call this method for GCS
+// The temporary object is created inside the same "directory" of the target one
 func (*GCSFs) GetAtomicUploadPath(name string) string {
-	return ""
+	dir := path.Dir(name)
+	guid := xid.New().String()
+	return path.Join(dir, ".sftpgo-upload."+guid+"."+path.Base(name))
 }
 
 // GetRelativePath returns the path for a file relative to the user's home dir.
@@ -671,6 +872,103 @@ func (fs *GCSFs) getPrefix(name string) string {
 	return prefix
 }
 
+// getGCSSymlinkTarget returns the target for an emulated symlink or an empty
+// string if the object is not a symlink
+func getGCSSymlinkTarget(attrs *storage.ObjectAttrs) string {
+	if attrs.ContentType != symlinkMimeType {
+		return ""
+	}
+	return attrs.Metadata[symlinkTargetMetadataKey]
+}
+
+// GetXattrs returns the extended attributes stored as object metadata
+func (fs *GCSFs) GetXattrs(name string) (map[string][]byte, error) {
+	_, attrs, err := fs.headObjectForXattrs(name)
+	if err != nil {
+		return nil, err
+	}
+	return getXattrsFromMetadata(attrs.Metadata), nil
+}
+
+// SetXattr stores the specified extended attribute as object metadata
+func (fs *GCSFs) SetXattr(name, attr string, value []byte) error {
+	if err := ValidateXattr(attr, value); err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return fs.updateXattrs(name, attr, value)
+}
+
+// RemoveXattr removes the specified extended attribute from the object metadata
+func (fs *GCSFs) RemoveXattr(name, attr string) error {
+	if err := ValidateXattr(attr, nil); err != nil {
+		return err
+	}
+	return fs.updateXattrs(name, attr, nil)
+}
+
+// SetChecksum stores the SHA-256 checksum for the specified file as object metadata
+func (fs *GCSFs) SetChecksum(name, checksum string) error {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	return fs.updateObjectXattr(name, attrs, checksumXattrName, getChecksumXattrValue(checksum, attrs.Size))
+}
+
+// GetChecksum returns the SHA-256 checksum stored for the specified file.
+// The checksum is ignored if the object size changed
+func (fs *GCSFs) GetChecksum(name string) (string, error) {
+	attrs, err := fs.headObject(name)
+	if err != nil {
+		return "", err
+	}
+	return getChecksumFromMetadata(attrs.Metadata, attrs.Size), nil
+}
+
+func (fs *GCSFs) updateXattrs(name, attr string, value []byte) error {
+	objName, attrs, err := fs.headObjectForXattrs(name)
+	if err != nil {
+		return err
+	}
+	return fs.updateObjectXattr(objName, attrs, attr, value)
+}
+
+func (fs *GCSFs) updateObjectXattr(objName string, attrs *storage.ObjectAttrs, attr string, value []byte) error {
+	metadata, err := updateXattrMetadata(attrs.Metadata, attr, value)
+	if err != nil {
+		return err
+	}
+	key := getXattrMetadataKey(attr)
+	// the metadata are merged with the existing ones, an empty value removes the key
+	changes := map[string]string{key: ""}
+	if value != nil {
+		changes[key] = metadata[key]
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	obj := fs.svc.Bucket(fs.config.Bucket).Object(objName)
+	_, err = obj.If(storage.Conditions{MetagenerationMatch: attrs.Metageneration}).Update(ctx, storage.ObjectAttrsToUpdate{
+		Metadata: changes,
+	})
+	return err
+}
+
+// headObjectForXattrs returns the name and the attributes for the object with
+// the given name, directories are stored as objects with a trailing slash
+func (fs *GCSFs) headObjectForXattrs(name string) (string, *storage.ObjectAttrs, error) {
+	attrs, err := fs.headObject(name)
+	if err == nil || !fs.IsNotExist(err) {
+		return name, attrs, err
+	}
+	dirName := strings.TrimSuffix(name, "/") + "/"
+	attrs, err = fs.headObject(dirName)
+	return dirName, attrs, err
+}
+
 func (fs *GCSFs) headObject(name string) (*storage.ObjectAttrs, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
@@ -0,0 +1,215 @@
+// +build !nogcs
+
+package vfs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
+)
+
+// gcsRewriteServer emulates the GCS rewrite API, each request is handled by
+// the next handler, if any, or returns an error
+type gcsRewriteServer struct {
+	sync.Mutex
+	handlers []func(w http.ResponseWriter)
+	tokens   []string
+}
+
+func (s *gcsRewriteServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if r.Method != http.MethodPost || !strings.Contains(r.URL.Path, "/rewriteTo/") {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	s.tokens = append(s.tokens, r.URL.Query().Get("rewriteToken"))
+	if len(s.handlers) == 0 {
+		writeGCSRewriteError(w)
+		return
+	}
+	handler := s.handlers[0]
+	s.handlers = s.handlers[1:]
+	handler(w)
+}
+
+func (s *gcsRewriteServer) getTokens() []string {
+	s.Lock()
+	defer s.Unlock()
+
+	return append([]string(nil), s.tokens...)
+}
+
+// writeGCSRewriteError returns an error that the GCS client does not retry itself
+func writeGCSRewriteError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	fmt.Fprint(w, `{"error":{"code":400,"message":"rewrite error"}}`)
+}
+
+func writeGCSRewriteProgress(token string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"kind":"storage#rewriteResponse","totalBytesRewritten":"100","objectSize":"300",`+
+			`"done":false,"rewriteToken":%q}`, token)
+	}
+}
+
+func writeGCSRewriteDone(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprint(w, `{"kind":"storage#rewriteResponse","totalBytesRewritten":"300","objectSize":"300",`+
+		`"done":true,"resource":{"bucket":"bucket","name":"target","size":"300"}}`)
+}
+
+func getGCSCopyTestFs(t *testing.T, handler http.Handler) *GCSFs {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"),
+		option.WithoutAuthentication())
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		assert.NoError(t, client.Close())
+	})
+	return &GCSFs{
+		connectionID: "copy_test",
+		config: &GCSFsConfig{
+			Bucket: "bucket",
+		},
+		svc:            client,
+		ctxTimeout:     10 * time.Second,
+		ctxLongTimeout: 10 * time.Second,
+	}
+}
+
+func getGCSTestCopier(fs *GCSFs) *storage.Copier {
+	bucket := fs.svc.Bucket(fs.config.Bucket)
+	return bucket.Object("target").CopierFrom(bucket.Object("source"))
+}
+
+func TestGCSRunCopierResume(t *testing.T) {
+	server := &gcsRewriteServer{
+		handlers: []func(w http.ResponseWriter){
+			writeGCSRewriteProgress("token1"),
+			writeGCSRewriteError,
+			writeGCSRewriteError,
+			writeGCSRewriteError,
+			writeGCSRewriteProgress("token2"),
+			writeGCSRewriteError,
+			writeGCSRewriteError,
+			writeGCSRewriteError,
+			writeGCSRewriteDone,
+		},
+	}
+	fs := getGCSCopyTestFs(t, server)
+	copier := getGCSTestCopier(fs)
+	err := fs.runCopier(copier)
+	require.NoError(t, err)
+	// the failed rewrites are resumed using the last returned token, a
+	// progress resets the failed attempts
+	assert.Equal(t, []string{"", "token1", "token1", "token1", "token1", "token2", "token2", "token2", "token2"},
+		server.getTokens())
+}
+
+func TestGCSRunCopierMaxRetries(t *testing.T) {
+	server := &gcsRewriteServer{}
+	fs := getGCSCopyTestFs(t, server)
+	copier := getGCSTestCopier(fs)
+	err := fs.runCopier(copier)
+	assert.Error(t, err)
+	assert.Len(t, server.getTokens(), gcsCopyMaxRetries)
+	// no progress after the returned token
+	server = &gcsRewriteServer{
+		handlers: []func(w http.ResponseWriter){
+			writeGCSRewriteProgress("token1"),
+		},
+	}
+	fs = getGCSCopyTestFs(t, server)
+	copier = getGCSTestCopier(fs)
+	err = fs.runCopier(copier)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"", "token1", "token1", "token1", "token1"}, server.getTokens())
+	assert.Equal(t, "token1", copier.RewriteToken)
+}
+
+// gcsObjectServer emulates the GCS API to get and update the object attributes
+type gcsObjectServer struct {
+	sync.Mutex
+	size     int64
+	metadata map[string]string
+}
+
+func (s *gcsObjectServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	if r.URL.Path != "/storage/v1/b/bucket/o/file" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPatch:
+		var attrs struct {
+			Metadata map[string]string `json:"metadata"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&attrs); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for k, v := range attrs.Metadata {
+			s.metadata[k] = v
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+		"bucket":         "bucket",
+		"name":           "file",
+		"size":           fmt.Sprintf("%v", s.size),
+		"metageneration": "1",
+		"metadata":       s.metadata,
+	})
+}
+
+func TestGCSChecksum(t *testing.T) {
+	server := &gcsObjectServer{
+		size:     100,
+		metadata: map[string]string{},
+	}
+	fs := getGCSCopyTestFs(t, server)
+	checksum, err := fs.GetChecksum("file")
+	require.NoError(t, err)
+	assert.Empty(t, checksum)
+	err = fs.SetChecksum("file", "abcd")
+	require.NoError(t, err)
+	server.Lock()
+	assert.Len(t, server.metadata, 1)
+	server.Unlock()
+	checksum, err = fs.GetChecksum("file")
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", checksum)
+	// the checksum is stale if the size changes
+	server.Lock()
+	server.size = 101
+	server.Unlock()
+	checksum, err = fs.GetChecksum("file")
+	require.NoError(t, err)
+	assert.Empty(t, checksum)
+	_, err = fs.GetChecksum("missing")
+	assert.Error(t, err)
+}
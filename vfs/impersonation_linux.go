@@ -0,0 +1,71 @@
+// +build linux
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+var errImpersonationFailed = errors.New("unable to impersonate the user, SFTPGo must run as root to use this feature")
+
+// IsImpersonationSupported returns true if the OS level impersonation is
+// supported on this platform
+func IsImpersonationSupported() bool {
+	return true
+}
+
+// runAs executes fn using uid and gid as filesystem user and group identifiers.
+// The identifiers are changed for the current OS thread only, so the goroutine
+// is locked to this thread until the original identifiers are restored.
+// If they cannot be restored the thread remains locked and it will be
+// terminated when the goroutine exits
+func runAs(uid, gid int, fn func() error) error {
+	runtime.LockOSThread()
+
+	groups, err := unix.Getgroups()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("unable to get the supplementary groups: %w", err)
+	}
+	// unix.Setgroups, unlike syscall.Setgroups, only affects the current thread
+	if err := unix.Setgroups(nil); err != nil {
+		runtime.UnlockOSThread()
+		return errImpersonationFailed
+	}
+	prevGID, _ := unix.Setfsgid(gid)
+	prevUID, _ := unix.Setfsuid(uid)
+
+	defer func() {
+		unix.Setfsuid(prevUID) //nolint:errcheck
+		unix.Setfsgid(prevGID) //nolint:errcheck
+		if unix.Setgroups(groups) != nil || getFsUID() != prevUID || getFsGID() != prevGID {
+			logger.Error(osFsName, "", "unable to restore the filesystem identifiers, uid %v, gid %v", prevUID, prevGID)
+			return
+		}
+		runtime.UnlockOSThread()
+	}()
+
+	if getFsUID() != uid || getFsGID() != gid {
+		return errImpersonationFailed
+	}
+	return fn()
+}
+
+// getFsUID returns the filesystem user identifier for the current thread.
+// Setting an invalid identifier does not change it
+func getFsUID() int {
+	uid, _ := unix.Setfsuid(-1)
+	return uid
+}
+
+// getFsGID returns the filesystem group identifier for the current thread
+func getFsGID() int {
+	gid, _ := unix.Setfsgid(-1)
+	return gid
+}
@@ -0,0 +1,18 @@
+// +build !linux
+
+package vfs
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// IsImpersonationSupported returns true if the OS level impersonation is
+// supported on this platform
+func IsImpersonationSupported() bool {
+	return false
+}
+
+func runAs(uid, gid int, fn func() error) error {
+	return fmt.Errorf("OS impersonation is not supported on %v", runtime.GOOS)
+}
@@ -0,0 +1,51 @@
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOsFsImpersonation(t *testing.T) {
+	rootDir := t.TempDir()
+	fs := NewOsFs("", rootDir, "")
+	SetOsFsImpersonation(fs, 1000, 1000)
+	osFs := fs.(*OsFs)
+	assert.True(t, osFs.impersonate)
+	assert.Equal(t, 1000, osFs.uid)
+	assert.Equal(t, 1000, osFs.gid)
+	// nothing to do for the other filesystems
+	SetOsFsImpersonation(&CryptFs{OsFs: &OsFs{}}, 1000, 1000)
+
+	if !IsImpersonationSupported() {
+		_, err := fs.Stat(rootDir)
+		assert.Error(t, err)
+		return
+	}
+	if os.Geteuid() != 0 {
+		_, err := fs.Stat(rootDir)
+		assert.ErrorIs(t, err, errImpersonationFailed)
+		return
+	}
+	require.NoError(t, os.Chmod(rootDir, 0700))
+	_, err := fs.Stat(filepath.Join(rootDir, "file"))
+	assert.True(t, fs.IsPermission(err))
+	err = fs.Mkdir(filepath.Join(rootDir, "dir"))
+	assert.True(t, fs.IsPermission(err))
+
+	require.NoError(t, os.Chown(rootDir, 1000, 1000))
+	err = fs.Mkdir(filepath.Join(rootDir, "dir"))
+	require.NoError(t, err)
+	_, err = fs.Stat(filepath.Join(rootDir, "dir"))
+	assert.NoError(t, err)
+	// the original identifiers are restored after each operation
+	require.NoError(t, os.Chmod(rootDir, 0000))
+	_, err = os.Stat(filepath.Join(rootDir, "dir"))
+	assert.NoError(t, err)
+	_, err = fs.Stat(filepath.Join(rootDir, "dir"))
+	assert.True(t, fs.IsPermission(err))
+	require.NoError(t, os.Chmod(rootDir, 0700))
+}
@@ -0,0 +1,189 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// ListerBatchSize defines the number of directory entries to request at
+// once to a DirLister
+const ListerBatchSize = 1000
+
+var errInvalidListerLimit = errors.New("invalid limit for directory lister")
+
+// DirLister allows to read the contents of a directory in batches so large
+// directories can be listed in bounded memory
+type DirLister interface {
+	// Next returns at most limit directory entries. io.EOF is returned,
+	// possibly together with the last entries, when the listing is complete.
+	// An empty result without errors is allowed, the caller must keep calling
+	// Next until io.EOF or another error is returned
+	Next(limit int) ([]os.FileInfo, error)
+	Close() error
+}
+
+// pageFetcher returns the next page of directory entries and false once
+// there are no more pages to fetch
+type pageFetcher func() ([]os.FileInfo, bool, error)
+
+// pagedDirLister is a DirLister for backends that list the directory contents
+// in pages, at most a page of entries is buffered
+type pagedDirLister struct {
+	fetch    pageFetcher
+	cancelFn func()
+	entries  []os.FileInfo
+	hasMore  bool
+}
+
+func newPagedDirLister(fetch pageFetcher, cancelFn func()) *pagedDirLister {
+	return &pagedDirLister{
+		fetch:    fetch,
+		cancelFn: cancelFn,
+		hasMore:  true,
+	}
+}
+
+// newSliceDirLister returns a DirLister for already loaded directory entries.
+// It is used by the backends that cannot read a directory in batches
+func newSliceDirLister(entries []os.FileInfo) DirLister {
+	return &pagedDirLister{
+		entries: entries,
+	}
+}
+
+func (l *pagedDirLister) fetchPage() error {
+	page, hasMore, err := l.fetch()
+	if err != nil {
+		return err
+	}
+	l.entries = append(l.entries, page...)
+	l.hasMore = hasMore
+	return nil
+}
+
+// isEmpty returns true if the listed directory has no entries
+func (l *pagedDirLister) isEmpty() (bool, error) {
+	for len(l.entries) == 0 && l.hasMore {
+		if err := l.fetchPage(); err != nil {
+			return false, err
+		}
+	}
+	return len(l.entries) == 0, nil
+}
+
+// Next implements the DirLister interface
+func (l *pagedDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidListerLimit
+	}
+	for len(l.entries) < limit && l.hasMore {
+		if err := l.fetchPage(); err != nil {
+			return nil, err
+		}
+	}
+	n := limit
+	if n > len(l.entries) {
+		n = len(l.entries)
+	}
+	result := make([]os.FileInfo, n)
+	copy(result, l.entries)
+	l.entries = l.entries[n:]
+	if len(l.entries) == 0 && !l.hasMore {
+		return result, io.EOF
+	}
+	return result, nil
+}
+
+// Close implements the DirLister interface
+func (l *pagedDirLister) Close() error {
+	if l.cancelFn != nil {
+		l.cancelFn()
+		l.cancelFn = nil
+	}
+	l.entries = nil
+	l.hasMore = false
+	return nil
+}
+
+// osDirLister is a DirLister for local directories
+type osDirLister struct {
+	f       *os.File
+	convert func(os.FileInfo) os.FileInfo
+}
+
+// Next implements the DirLister interface
+func (l *osDirLister) Next(limit int) ([]os.FileInfo, error) {
+	if limit <= 0 {
+		return nil, errInvalidListerLimit
+	}
+	entries, err := l.f.Readdir(limit)
+	if l.convert != nil {
+		for idx := range entries {
+			entries[idx] = l.convert(entries[idx])
+		}
+	}
+	return entries, err
+}
+
+// Close implements the DirLister interface
+func (l *osDirLister) Close() error {
+	return l.f.Close()
+}
+
+// readAllFromLister reads all the remaining entries from the given lister
+// and closes it
+func readAllFromLister(lister DirLister) ([]os.FileInfo, error) {
+	defer lister.Close()
+
+	var result []os.FileInfo
+	for {
+		entries, err := lister.Next(ListerBatchSize)
+		result = append(result, entries...)
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// getDirSizeWithLister returns the number of files and their size for the
+// given directory, including any subdirectory. The directories are read in
+// batches, at most a batch of entries is loaded for each directory level.
+// The quota scan limits are applied if throttle is not nil
+func getDirSizeWithLister(fs Fs, dirname string, throttle *quotaScanThrottle) (int, int64, error) {
+	throttle.waitRequest()
+	lister, err := fs.OpenDir(dirname)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer lister.Close()
+
+	numFiles := 0
+	size := int64(0)
+	for {
+		entries, err := lister.Next(ListerBatchSize)
+		for _, info := range entries {
+			if info.IsDir() {
+				files, dirSize, errDir := getDirSizeWithLister(fs, fs.Join(dirname, info.Name()), throttle)
+				if errDir != nil {
+					return numFiles, size, errDir
+				}
+				numFiles += files
+				size += dirSize
+			} else if info.Mode().IsRegular() {
+				throttle.waitFiles(1)
+				numFiles++
+				size += info.Size()
+			}
+		}
+		if err == io.EOF {
+			return numFiles, size, nil
+		}
+		if err != nil {
+			return numFiles, size, err
+		}
+	}
+}
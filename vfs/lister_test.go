@@ -0,0 +1,96 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPagedDirLister(t *testing.T) {
+	pages := [][]os.FileInfo{
+		{NewFileInfo("a", false, 1, time.Now(), false), NewFileInfo("b", false, 1, time.Now(), false)},
+		{},
+		{NewFileInfo("c", true, 0, time.Now(), false)},
+	}
+	fetched := 0
+	cancelled := false
+	lister := newPagedDirLister(func() ([]os.FileInfo, bool, error) {
+		page := pages[fetched]
+		fetched++
+		return page, fetched < len(pages), nil
+	}, func() {
+		cancelled = true
+	})
+	isEmpty, err := lister.isEmpty()
+	assert.NoError(t, err)
+	assert.False(t, isEmpty)
+	assert.Equal(t, 1, fetched)
+
+	_, err = lister.Next(0)
+	assert.ErrorIs(t, err, errInvalidListerLimit)
+	entries, err := lister.Next(1)
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, "a", entries[0].Name())
+	}
+	entries, err = lister.Next(10)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, 3, fetched)
+	assert.NoError(t, lister.Close())
+	assert.True(t, cancelled)
+
+	errFake := errors.New("fake error")
+	lister = newPagedDirLister(func() ([]os.FileInfo, bool, error) {
+		return nil, false, errFake
+	}, nil)
+	_, err = lister.isEmpty()
+	assert.ErrorIs(t, err, errFake)
+	_, err = readAllFromLister(lister)
+	assert.ErrorIs(t, err, errFake)
+
+	entries, err = readAllFromLister(newSliceDirLister(pages[0]))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestOsDirLister(t *testing.T) {
+	rootDir := t.TempDir()
+	fs := NewOsFs("", rootDir, "")
+	numFiles := ListerBatchSize + 10
+	for i := 0; i < numFiles; i++ {
+		err := os.WriteFile(filepath.Join(rootDir, fmt.Sprintf("file%d", i)), []byte("data"), os.ModePerm)
+		require.NoError(t, err)
+	}
+	subDir := filepath.Join(rootDir, "sub", "dir")
+	require.NoError(t, os.MkdirAll(subDir, os.ModePerm))
+	require.NoError(t, os.WriteFile(filepath.Join(subDir, "file"), []byte("content"), os.ModePerm))
+
+	lister, err := fs.OpenDir(rootDir)
+	require.NoError(t, err)
+	entries, err := lister.Next(ListerBatchSize)
+	assert.NoError(t, err)
+	assert.Len(t, entries, ListerBatchSize)
+	assert.NoError(t, lister.Close())
+
+	lister, err = fs.OpenDir(rootDir)
+	require.NoError(t, err)
+	entries, err = readAllFromLister(lister)
+	assert.NoError(t, err)
+	assert.Len(t, entries, numFiles+1)
+
+	files, size, err := fs.GetDirSize(rootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, numFiles+1, files)
+	assert.Equal(t, int64(numFiles*4+7), size)
+
+	_, err = fs.OpenDir(filepath.Join(rootDir, "missing"))
+	assert.True(t, fs.IsNotExist(err))
+}
@@ -7,6 +7,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,6 +21,8 @@ import (
 const (
 	// osFsName is the name for the local Fs implementation
 	osFsName = "osfs"
+	// extended attribute used to store the checksum of the uploaded files
+	checksumXattrName = "user.sftpgo.sha256"
 )
 
 // OsFs is a Fs implementation that uses functions provided by the os package.
@@ -29,6 +32,11 @@ type OsFs struct {
 	rootDir      string
 	// if not empty this fs is mouted as virtual folder in the specified path
 	mountPath string
+	// if true the filesystem operations are executed using uid and gid as
+	// filesystem user and group identifiers
+	impersonate bool
+	uid         int
+	gid         int
 }
 
 // NewOsFs returns an OsFs object that allows to interact with local Os filesystem
@@ -51,19 +59,51 @@ func (fs *OsFs) ConnectionID() string {
 	return fs.connectionID
 }
 
+// SetOsFsImpersonation executes the operations on the local filesystem using
+// the given uid and gid as filesystem user and group identifiers.
+// It does nothing for the other filesystems
+func SetOsFsImpersonation(fs Fs, uid, gid int) {
+	if osFs, ok := fs.(*OsFs); ok {
+		osFs.impersonate = true
+		osFs.uid = uid
+		osFs.gid = gid
+	}
+}
+
+// run executes fn impersonating the configured user, if any
+func (fs *OsFs) run(fn func() error) error {
+	if !fs.impersonate {
+		return fn()
+	}
+	return runAs(fs.uid, fs.gid, fn)
+}
+
 // Stat returns a FileInfo describing the named file
-func (fs *OsFs) Stat(name string) (os.FileInfo, error) {
-	return os.Stat(name)
+func (fs *OsFs) Stat(name string) (info os.FileInfo, err error) {
+	err = fs.run(func() error {
+		info, err = os.Stat(name)
+		return err
+	})
+	return info, err
 }
 
 // Lstat returns a FileInfo describing the named file
-func (fs *OsFs) Lstat(name string) (os.FileInfo, error) {
-	return os.Lstat(name)
+func (fs *OsFs) Lstat(name string) (info os.FileInfo, err error) {
+	err = fs.run(func() error {
+		info, err = os.Lstat(name)
+		return err
+	})
+	return info, err
 }
 
 // Open opens the named file for reading
-func (*OsFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
-	f, err := os.Open(name)
+func (fs *OsFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	var f *os.File
+	err := fs.run(func() error {
+		var err error
+		f, err = os.Open(name)
+		return err
+	})
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -78,30 +118,42 @@ func (*OsFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func()
 }
 
 // Create creates or opens the named file for writing
-func (*OsFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
-	var err error
+func (fs *OsFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
 	var f *os.File
-	if flag == 0 {
-		f, err = os.Create(name)
-	} else {
-		f, err = os.OpenFile(name, flag, os.ModePerm)
+	err := fs.run(func() error {
+		var err error
+		if flag == 0 {
+			f, err = os.Create(name)
+		} else {
+			f, err = os.OpenFile(name, flag, os.ModePerm)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, nil, nil, err
 	}
 	return f, nil, nil, err
 }
 
 // Rename renames (moves) source to target
-func (*OsFs) Rename(source, target string) error {
-	return os.Rename(source, target)
+func (fs *OsFs) Rename(source, target string) error {
+	return fs.run(func() error {
+		return os.Rename(source, target)
+	})
 }
 
 // Remove removes the named file or (empty) directory.
-func (*OsFs) Remove(name string, isDir bool) error {
-	return os.Remove(name)
+func (fs *OsFs) Remove(name string, isDir bool) error {
+	return fs.run(func() error {
+		return os.Remove(name)
+	})
 }
 
 // Mkdir creates a new directory with the specified name and default permissions
-func (*OsFs) Mkdir(name string) error {
-	return os.Mkdir(name, os.ModePerm)
+func (fs *OsFs) Mkdir(name string) error {
+	return fs.run(func() error {
+		return os.Mkdir(name, os.ModePerm)
+	})
 }
 
 // MkdirAll creates a directory named path, along with any necessary parents,
@@ -112,14 +164,21 @@ func (fs *OsFs) MkdirAll(name string, uid int, gid int) error {
 }
 
 // Symlink creates source as a symbolic link to target.
-func (*OsFs) Symlink(source, target string) error {
-	return os.Symlink(source, target)
+func (fs *OsFs) Symlink(source, target string) error {
+	return fs.run(func() error {
+		return os.Symlink(source, target)
+	})
 }
 
 // Readlink returns the destination of the named symbolic link
 // as absolute virtual path
 func (fs *OsFs) Readlink(name string) (string, error) {
-	p, err := os.Readlink(name)
+	var p string
+	err := fs.run(func() error {
+		var err error
+		p, err = os.Readlink(name)
+		return err
+	})
 	if err != nil {
 		return p, err
 	}
@@ -127,29 +186,42 @@ func (fs *OsFs) Readlink(name string) (string, error) {
 }
 
 // Chown changes the numeric uid and gid of the named file.
-func (*OsFs) Chown(name string, uid int, gid int) error {
-	return os.Chown(name, uid, gid)
+func (fs *OsFs) Chown(name string, uid int, gid int) error {
+	return fs.run(func() error {
+		return os.Chown(name, uid, gid)
+	})
 }
 
 // Chmod changes the mode of the named file to mode
-func (*OsFs) Chmod(name string, mode os.FileMode) error {
-	return os.Chmod(name, mode)
+func (fs *OsFs) Chmod(name string, mode os.FileMode) error {
+	return fs.run(func() error {
+		return os.Chmod(name, mode)
+	})
 }
 
 // Chtimes changes the access and modification times of the named file
-func (*OsFs) Chtimes(name string, atime, mtime time.Time) error {
-	return os.Chtimes(name, atime, mtime)
+func (fs *OsFs) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.run(func() error {
+		return os.Chtimes(name, atime, mtime)
+	})
 }
 
 // Truncate changes the size of the named file
-func (*OsFs) Truncate(name string, size int64) error {
-	return os.Truncate(name, size)
+func (fs *OsFs) Truncate(name string, size int64) error {
+	return fs.run(func() error {
+		return os.Truncate(name, size)
+	})
 }
 
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
-func (*OsFs) ReadDir(dirname string) ([]os.FileInfo, error) {
-	f, err := os.Open(dirname)
+func (fs *OsFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	var f *os.File
+	err := fs.run(func() error {
+		var err error
+		f, err = os.Open(dirname)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -161,14 +233,31 @@ func (*OsFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	return list, nil
 }
 
-// IsUploadResumeSupported returns true if resuming uploads is supported
-func (*OsFs) IsUploadResumeSupported() bool {
-	return true
+// OpenDir opens the named directory, its entries can be read in batches
+// using the returned lister
+func (fs *OsFs) OpenDir(dirname string) (DirLister, error) {
+	var f *os.File
+	err := fs.run(func() error {
+		var err error
+		f, err = os.Open(dirname)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &osDirLister{f: f}, nil
 }
 
-// IsAtomicUploadSupported returns true if atomic upload is supported
-func (*OsFs) IsAtomicUploadSupported() bool {
-	return true
+// Capabilities returns the features supported by this filesystem.
+func (*OsFs) Capabilities() FsCapabilities {
+	return FsCapabilities{
+		UploadResume: true,
+		AtomicUpload: true,
+		SetStat:      true,
+		Symlinks:     true,
+		Truncate:     true,
+		RangeReads:   true,
+	}
 }
 
 // IsNotExist returns a boolean indicating whether the error is known to
@@ -199,7 +288,13 @@ func (fs *OsFs) CheckRootPath(username string, uid int, gid int) bool {
 		fsLog(fs, logger.LevelDebug, "root directory %#v for user %#v does not exist, try to create, mkdir error: %v",
 			fs.rootDir, username, err)
 		if err == nil {
-			SetPathPermissions(fs, fs.rootDir, uid, gid)
+			if fs.impersonate {
+				// the root directory is created as root, the impersonated user
+				// must own it to work inside it
+				err = os.Chown(fs.rootDir, uid, gid)
+			} else {
+				SetPathPermissions(fs, fs.rootDir, uid, gid)
+			}
 		}
 	}
 	return err == nil
@@ -208,7 +303,7 @@ func (fs *OsFs) CheckRootPath(username string, uid int, gid int) bool {
 // ScanRootDirContents returns the number of files contained in the root
 // directory and their size
 func (fs *OsFs) ScanRootDirContents() (int, int64, error) {
-	return fs.GetDirSize(fs.rootDir)
+	return fs.getDirSize(fs.rootDir, getQuotaScanThrottle())
 }
 
 // GetAtomicUploadPath returns the path to use for an atomic upload
@@ -237,8 +332,10 @@ func (fs *OsFs) GetRelativePath(name string) string {
 
 // Walk walks the file tree rooted at root, calling walkFn for each file or
 // directory in the tree, including root
-func (*OsFs) Walk(root string, walkFn filepath.WalkFunc) error {
-	return filepath.Walk(root, walkFn)
+func (fs *OsFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	return fs.run(func() error {
+		return filepath.Walk(root, walkFn)
+	})
 }
 
 // Join joins any number of path elements into a single path
@@ -279,20 +376,17 @@ func (fs *OsFs) ResolvePath(virtualPath string) (string, error) {
 // GetDirSize returns the number of files and the size for a folder
 // including any subfolders
 func (fs *OsFs) GetDirSize(dirname string) (int, int64, error) {
+	return fs.getDirSize(dirname, nil)
+}
+
+func (fs *OsFs) getDirSize(dirname string, throttle *quotaScanThrottle) (int, int64, error) {
 	numFiles := 0
 	size := int64(0)
 	isDir, err := IsDirectory(fs, dirname)
 	if err == nil && isDir {
-		err = filepath.Walk(dirname, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if info != nil && info.Mode().IsRegular() {
-				size += info.Size()
-				numFiles++
-			}
-			return err
-		})
+		// the directories are read in batches, huge directories don't need to
+		// be loaded in memory
+		numFiles, size, err = getDirSizeWithLister(fs, dirname, throttle)
 	}
 	return numFiles, size, err
 }
@@ -390,7 +484,7 @@ func (fs *OsFs) createMissingDirs(filePath string, uid, gid int) error {
 	last := len(dirsToCreate) - 1
 	for i := range dirsToCreate {
 		d := dirsToCreate[last-i]
-		if err := os.Mkdir(d, os.ModePerm); err != nil {
+		if err := fs.Mkdir(d); err != nil {
 			fsLog(fs, logger.LevelError, "error creating missing dir: %#v", d)
 			return err
 		}
@@ -399,6 +493,77 @@ func (fs *OsFs) createMissingDirs(filePath string, uid, gid int) error {
 	return nil
 }
 
+// SetChecksum stores the SHA-256 checksum for the specified file as extended attribute
+func (fs *OsFs) SetChecksum(name, checksum string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	value := fmt.Sprintf("%v:%v:%v", checksum, info.Size(), info.ModTime().UnixNano())
+	return setXattr(name, checksumXattrName, []byte(value))
+}
+
+// GetChecksum returns the SHA-256 checksum stored for the specified file.
+// The checksum is ignored if the file size or modification time changed
+func (fs *OsFs) GetChecksum(name string) (string, error) {
+	info, err := os.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	value, err := getXattr(name, checksumXattrName)
+	if err != nil || len(value) == 0 {
+		return "", err
+	}
+	parts := strings.Split(string(value), ":")
+	if len(parts) != 3 {
+		return "", nil
+	}
+	if parts[1] != strconv.FormatInt(info.Size(), 10) || parts[2] != strconv.FormatInt(info.ModTime().UnixNano(), 10) {
+		fsLog(fs, logger.LevelDebug, "ignoring stale checksum for file %#v", name)
+		return "", nil
+	}
+	return parts[0], nil
+}
+
+// GetXattrs returns the extended attributes and POSIX ACLs for the specified path.
+// The attributes used internally are not returned
+func (*OsFs) GetXattrs(name string) (map[string][]byte, error) {
+	names, err := listXattrs(name)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string][]byte)
+	for _, attr := range names {
+		if !isXattrAllowed(attr) {
+			continue
+		}
+		value, err := getXattr(name, attr)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			result[attr] = value
+		}
+	}
+	return result, nil
+}
+
+// SetXattr sets the specified extended attribute
+func (*OsFs) SetXattr(name, attr string, value []byte) error {
+	if err := ValidateXattr(attr, value); err != nil {
+		return err
+	}
+	return setXattr(name, attr, value)
+}
+
+// RemoveXattr removes the specified extended attribute
+func (*OsFs) RemoveXattr(name, attr string) error {
+	if err := ValidateXattr(attr, nil); err != nil {
+		return err
+	}
+	return removeXattr(name, attr)
+}
+
 // GetMimeType returns the content type
 func (fs *OsFs) GetMimeType(name string) (string, error) {
 	f, err := os.OpenFile(name, os.O_RDONLY, 0)
@@ -0,0 +1,129 @@
+package vfs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/drakkan/sftpgo/httpclient"
+	"github.com/drakkan/sftpgo/logger"
+)
+
+// unixSocketPrefix is the gRPC target prefix for unix domain sockets
+const unixSocketPrefix = "unix:"
+
+var (
+	// connections to the external storage plugins not used for this time are closed
+	pluginConnIdleTimeout = 10 * time.Minute
+	pluginConns           = &pluginConnsCache{
+		conns: make(map[string]*pluginConn),
+	}
+)
+
+// pluginConn is a shared connection to a storage plugin
+type pluginConn struct {
+	conn *grpc.ClientConn
+	// connections for the plugins launched by SFTPGo are managed by the plugin
+	// package, we never close them
+	managed   bool
+	activeOps int
+	lastUsed  time.Time
+	idleTimer *time.Timer
+}
+
+// pluginConnsCache shares the connections to the storage plugins.
+// gRPC connections are safe for concurrent use, a connection to an external
+// plugin is closed when it has no active operations and it is not used for
+// pluginConnIdleTimeout
+type pluginConnsCache struct {
+	sync.Mutex
+	conns map[string]*pluginConn
+}
+
+// acquire returns a connection for the given endpoint, release must be called
+// when the operation using the connection ends
+func (c *pluginConnsCache) acquire(endpoint string) (*pluginConn, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	pc, ok := c.conns[endpoint]
+	if !ok {
+		if strings.HasPrefix(endpoint, managedPluginPrefix) {
+			return nil, fmt.Errorf("storage plugin %#v is not available", endpoint)
+		}
+		conn, err := grpc.Dial(endpoint, getPluginDialOption(endpoint))
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to storage plugin %#v: %w", endpoint, err)
+		}
+		pc = &pluginConn{
+			conn: conn,
+		}
+		c.conns[endpoint] = pc
+	}
+	pc.activeOps++
+	pc.lastUsed = time.Now()
+	return pc, nil
+}
+
+func (c *pluginConnsCache) release(endpoint string, pc *pluginConn) {
+	c.Lock()
+	defer c.Unlock()
+
+	pc.activeOps--
+	pc.lastUsed = time.Now()
+	if pc.activeOps > 0 || pc.managed {
+		return
+	}
+	if pc.idleTimer == nil {
+		pc.idleTimer = time.AfterFunc(pluginConnIdleTimeout, func() {
+			c.removeIfIdle(endpoint, pc)
+		})
+	} else {
+		pc.idleTimer.Reset(pluginConnIdleTimeout)
+	}
+}
+
+// removeIfIdle closes the given connection if it is still cached for the
+// specified endpoint and it is idle
+func (c *pluginConnsCache) removeIfIdle(endpoint string, pc *pluginConn) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.conns[endpoint] != pc || pc.activeOps > 0 || time.Since(pc.lastUsed) < pluginConnIdleTimeout {
+		return
+	}
+	delete(c.conns, endpoint)
+	err := pc.conn.Close()
+	logger.Debug(pluginFsName, "", "idle connection to storage plugin %#v closed, err: %v", endpoint, err)
+}
+
+// setManaged sets the connection for a plugin launched by SFTPGo, the
+// operations in progress will continue to use the previous connection
+func (c *pluginConnsCache) setManaged(endpoint string, conn *grpc.ClientConn) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.conns[endpoint] = &pluginConn{
+		conn:    conn,
+		managed: true,
+	}
+}
+
+// getPluginDialOption returns the transport credentials for the given
+// endpoint. Unix domain sockets are not encrypted, TLS is required for
+// any other endpoint, the TLS settings configured for the HTTP clients are used
+func getPluginDialOption(endpoint string) grpc.DialOption {
+	if strings.HasPrefix(endpoint, unixSocketPrefix) {
+		return grpc.WithInsecure()
+	}
+	tlsConfig := httpclient.GetTLSConfig()
+	if tlsConfig.MinVersion == 0 {
+		tlsConfig.MinVersion = tls.VersionTLS12
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+}
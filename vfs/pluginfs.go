@@ -0,0 +1,723 @@
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/drakkan/sftpgo/kms"
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+	"github.com/drakkan/sftpgo/vfs/fsplugin"
+)
+
+const (
+	// pluginFsName is the name for the storage plugin Fs implementation
+	pluginFsName = "pluginfs"
+	// timeout for non streaming requests to the plugins
+	pluginRequestTimeout = 60 * time.Second
+	// size for the data chunks sent to the plugins
+	pluginChunkSize = 32768
+	// managedPluginPrefix is the endpoint prefix for the storage plugins launched by SFTPGo
+	managedPluginPrefix = "plugin://"
+)
+
+// PluginFsConfig defines the configuration for storage plugins
+type PluginFsConfig struct {
+	// Endpoint is the gRPC target for the plugin, for example
+	// "unix:///run/sftpgo/plugin.sock" or "plugin.example.com:9000".
+	// TLS is used for any endpoint other than a unix domain socket.
+	// Use "plugin://<name>" for the storage plugins launched by SFTPGo
+	Endpoint string `json:"endpoint,omitempty"`
+	// Options is the plugin specific configuration, it is sent to the plugin
+	// with each request. It is stored encrypted since it could contain credentials
+	Options *kms.Secret `json:"options,omitempty"`
+}
+
+func (c *PluginFsConfig) isEqual(other *PluginFsConfig) bool {
+	if c.Endpoint != other.Endpoint {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	return c.Options.IsEqual(other.Options)
+}
+
+func (c *PluginFsConfig) setEmptyCredentialsIfNil() {
+	if c.Options == nil {
+		c.Options = kms.NewEmptySecret()
+	}
+}
+
+// Validate returns an error if the configuration is not valid
+func (c *PluginFsConfig) Validate() error {
+	c.setEmptyCredentialsIfNil()
+	if c.Endpoint == "" {
+		return errors.New("endpoint cannot be empty")
+	}
+	if c.Options.IsEncrypted() && !c.Options.IsValid() {
+		return errors.New("invalid encrypted options")
+	}
+	if !c.Options.IsEmpty() && !c.Options.IsValidInput() {
+		return errors.New("invalid options")
+	}
+	return nil
+}
+
+// EncryptCredentials encrypts the options if they are in plain text
+func (c *PluginFsConfig) EncryptCredentials(additionalData string) error {
+	if c.Options.IsPlain() {
+		c.Options.SetAdditionalData(additionalData)
+		if err := c.Options.Encrypt(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PluginFs is a Fs implementation that forwards all the operations to an external storage plugin
+type PluginFs struct {
+	connectionID string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath    string
+	localTempDir string
+	config       *PluginFsConfig
+}
+
+// NewPluginFs returns a PluginFs object that allows to interact with an external storage plugin
+func NewPluginFs(connectionID, localTempDir, mountPath string, config PluginFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		localTempDir = filepath.Clean(os.TempDir())
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if !config.Options.IsEmpty() {
+		if err := config.Options.TryDecrypt(); err != nil {
+			return nil, err
+		}
+	}
+	pc, err := pluginConns.acquire(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	pluginConns.release(config.Endpoint, pc)
+	return &PluginFs{
+		connectionID: connectionID,
+		mountPath:    mountPath,
+		localTempDir: localTempDir,
+		config:       &config,
+	}, nil
+}
+
+// SetManagedPluginConn sets the connection for the storage plugin, launched by SFTPGo,
+// with the given name. Users and folders refer to this plugin using "plugin://<name>"
+// as endpoint. The connection is replaced each time the plugin is restarted
+func SetManagedPluginConn(name string, conn *grpc.ClientConn) {
+	pluginConns.setManaged(managedPluginPrefix+name, conn)
+}
+
+// getClient returns a client for the plugin, the returned function must be
+// called when the operation ends to release the connection
+func (fs *PluginFs) getClient() (*fsplugin.Client, func(), error) {
+	pc, err := pluginConns.acquire(fs.config.Endpoint)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fsplugin.NewClient(pc.conn), func() {
+		pluginConns.release(fs.config.Endpoint, pc)
+	}, nil
+}
+
+// Name returns the name for the Fs implementation
+func (fs *PluginFs) Name() string {
+	return fmt.Sprintf("%v %#v", pluginFsName, fs.config.Endpoint)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *PluginFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *PluginFs) Stat(name string) (os.FileInfo, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), pluginRequestTimeout)
+	defer cancelFn()
+
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseFn()
+
+	info, err := client.Stat(ctx, fs.getPathRequest(name))
+	if err != nil {
+		return nil, fs.convertError(err)
+	}
+	return fs.getFileInfo(info), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *PluginFs) Lstat(name string) (os.FileInfo, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), pluginRequestTimeout)
+	defer cancelFn()
+
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseFn()
+
+	info, err := client.Lstat(ctx, fs.getPathRequest(name))
+	if err != nil {
+		return nil, fs.convertError(err)
+	}
+	return fs.getFileInfo(info), nil
+}
+
+// Open opens the named file for reading
+func (fs *PluginFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		releaseFn()
+		return nil, nil, nil, err
+	}
+	ctx, cancelFn := context.WithCancel(context.Background())
+	stream, err := client.ReadFile(ctx, &fsplugin.ReadFileRequest{
+		Options: fs.config.Options.GetPayload(),
+		Name:    name,
+		Offset:  offset,
+	})
+	if err != nil {
+		cancelFn()
+		releaseFn()
+		r.Close()
+		w.Close()
+		return nil, nil, nil, fs.convertError(err)
+	}
+
+	go func() {
+		defer releaseFn()
+		defer cancelFn()
+		var n int64
+		var err error
+		for {
+			chunk, errRecv := stream.Recv()
+			if errRecv == io.EOF {
+				break
+			}
+			if errRecv != nil {
+				err = fs.convertError(errRecv)
+				break
+			}
+			written, errWrite := w.Write(chunk.Data)
+			n += int64(written)
+			if errWrite != nil {
+				err = errWrite
+				break
+			}
+		}
+		w.CloseWithError(err) //nolint:errcheck
+		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
+	}()
+	return nil, r, cancelFn, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *PluginFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		releaseFn()
+		return nil, nil, nil, err
+	}
+	p := NewPipeWriter(w)
+	ctx, cancelFn := context.WithCancel(context.Background())
+	stream, err := client.WriteFile(ctx)
+	if err != nil {
+		cancelFn()
+		releaseFn()
+		r.Close()
+		w.Close()
+		return nil, nil, nil, fs.convertError(err)
+	}
+
+	go func() {
+		defer releaseFn()
+		defer cancelFn()
+		err := stream.Send(&fsplugin.WriteFileRequest{
+			Options: fs.config.Options.GetPayload(),
+			Name:    name,
+			Flag:    flag,
+		})
+		buf := make([]byte, pluginChunkSize)
+		for err == nil {
+			n, errRead := r.Read(buf)
+			if n > 0 {
+				err = stream.Send(&fsplugin.WriteFileRequest{
+					Data: buf[:n],
+				})
+			}
+			if errRead != nil {
+				if errRead != io.EOF {
+					err = errRead
+				}
+				break
+			}
+		}
+		var written int64
+		if err == nil {
+			var resp *fsplugin.WriteFileResponse
+			resp, err = stream.CloseAndRecv()
+			if err == nil {
+				written = resp.Written
+			}
+		}
+		err = fs.convertError(err)
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, readed bytes: %v, written bytes: %v err: %v",
+			name, r.GetReadedBytes(), written, err)
+	}()
+	return nil, p, cancelFn, nil
+}
+
+// Rename renames (moves) source to target.
+func (fs *PluginFs) Rename(source, target string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), pluginRequestTimeout)
+	defer cancelFn()
+
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return err
+	}
+	defer releaseFn()
+
+	return fs.convertError(client.Rename(ctx, &fsplugin.RenameRequest{
+		Options: fs.config.Options.GetPayload(),
+		Source:  source,
+		Target:  target,
+	}))
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *PluginFs) Remove(name string, isDir bool) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), pluginRequestTimeout)
+	defer cancelFn()
+
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return err
+	}
+	defer releaseFn()
+
+	req := fs.getPathRequest(name)
+	req.IsDir = isDir
+	return fs.convertError(client.Remove(ctx, req))
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *PluginFs) Mkdir(name string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), pluginRequestTimeout)
+	defer cancelFn()
+
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return err
+	}
+	defer releaseFn()
+
+	return fs.convertError(client.Mkdir(ctx, fs.getPathRequest(name)))
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents,
+// and returns nil, or else returns an error.
+// If path is already a directory, MkdirAll does nothing and returns nil.
+func (fs *PluginFs) MkdirAll(name string, uid int, gid int) error {
+	info, err := fs.Stat(name)
+	if err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return fmt.Errorf("%#v is not a directory", name)
+	}
+	if !fs.IsNotExist(err) {
+		return err
+	}
+	parent := path.Dir(name)
+	if parent != name && parent != "/" && parent != "." {
+		if err = fs.MkdirAll(parent, uid, gid); err != nil {
+			return err
+		}
+	}
+	if err = fs.Mkdir(name); err != nil {
+		// the directory could be created in the meantime
+		info, errStat := fs.Stat(name)
+		if errStat == nil && info.IsDir() {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// Symlink creates source as a symbolic link to target.
+func (fs *PluginFs) Symlink(source, target string) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), pluginRequestTimeout)
+	defer cancelFn()
+
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return err
+	}
+	defer releaseFn()
+
+	return fs.convertError(client.Symlink(ctx, &fsplugin.RenameRequest{
+		Options: fs.config.Options.GetPayload(),
+		Source:  source,
+		Target:  target,
+	}))
+}
+
+// Readlink returns the destination of the named symbolic link
+func (fs *PluginFs) Readlink(name string) (string, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), pluginRequestTimeout)
+	defer cancelFn()
+
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return "", err
+	}
+	defer releaseFn()
+
+	resp, err := client.Readlink(ctx, fs.getPathRequest(name))
+	if err != nil {
+		return "", fs.convertError(err)
+	}
+	return resp.Target, nil
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (fs *PluginFs) Chown(name string, uid int, gid int) error {
+	return fs.setstat(&fsplugin.SetstatRequest{
+		Name:  name,
+		Flags: fsplugin.SetstatFlagOwner,
+		UID:   uid,
+		GID:   gid,
+	})
+}
+
+// Chmod changes the mode of the named file to mode.
+func (fs *PluginFs) Chmod(name string, mode os.FileMode) error {
+	return fs.setstat(&fsplugin.SetstatRequest{
+		Name:  name,
+		Flags: fsplugin.SetstatFlagMode,
+		Mode:  uint32(mode),
+	})
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (fs *PluginFs) Chtimes(name string, atime, mtime time.Time) error {
+	return fs.setstat(&fsplugin.SetstatRequest{
+		Name:  name,
+		Flags: fsplugin.SetstatFlagTimes,
+		Atime: utils.GetTimeAsMsSinceEpoch(atime),
+		Mtime: utils.GetTimeAsMsSinceEpoch(mtime),
+	})
+}
+
+// Truncate changes the size of the named file.
+func (fs *PluginFs) Truncate(name string, size int64) error {
+	return fs.setstat(&fsplugin.SetstatRequest{
+		Name:  name,
+		Flags: fsplugin.SetstatFlagSize,
+		Size:  size,
+	})
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *PluginFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), pluginRequestTimeout)
+	defer cancelFn()
+
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseFn()
+
+	resp, err := client.ReadDir(ctx, fs.getPathRequest(dirname))
+	if err != nil {
+		return nil, fs.convertError(err)
+	}
+	result := make([]os.FileInfo, 0, len(resp.Entries))
+	for idx := range resp.Entries {
+		result = append(result, fs.getFileInfo(&resp.Entries[idx]))
+	}
+	return result, nil
+}
+
+// OpenDir opens the named directory, its entries can be read in batches
+// using the returned lister. The directory is read at once, the plugin protocol
+// does not allow to read it in batches
+func (fs *PluginFs) OpenDir(dirname string) (DirLister, error) {
+	entries, err := fs.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return newSliceDirLister(entries), nil
+}
+
+// Capabilities returns the features supported by this filesystem.
+func (*PluginFs) Capabilities() FsCapabilities {
+	return FsCapabilities{
+		Symlinks:   true,
+		RangeReads: true,
+	}
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*PluginFs) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*PluginFs) IsPermission(err error) bool {
+	return os.IsPermission(err)
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*PluginFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == ErrVfsUnsupported
+}
+
+// CheckRootPath creates the local directory used for temporary files
+func (fs *PluginFs) CheckRootPath(username string, uid int, gid int) bool {
+	osFs := NewOsFs(fs.ConnectionID(), fs.localTempDir, "")
+	return osFs.CheckRootPath(username, uid, gid)
+}
+
+// ScanRootDirContents returns the number of files contained in the root
+// directory and their size
+func (fs *PluginFs) ScanRootDirContents() (int, int64, error) {
+	return fs.GetDirSize("/")
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (*PluginFs) GetAtomicUploadPath(name string) string {
+	dir := path.Dir(name)
+	guid := xid.New().String()
+	return path.Join(dir, ".sftpgo-upload."+guid+"."+path.Base(name))
+}
+
+// GetRelativePath returns the path for a file relative to the plugin root.
+// This is the path as seen by SFTPGo users
+func (fs *PluginFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		rel = "/" + rel
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *PluginFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = fs.walk(root, info, walkFn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func (fs *PluginFs) walk(name string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return walkFn(name, info, nil)
+	}
+	entries, err := fs.ReadDir(name)
+	errWalk := walkFn(name, info, err)
+	if err != nil || errWalk != nil {
+		return errWalk
+	}
+	for _, entry := range entries {
+		err = fs.walk(path.Join(name, entry.Name()), entry, walkFn)
+		if err != nil {
+			if !entry.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Join joins any number of path elements into a single path
+func (*PluginFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*PluginFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path
+func (fs *PluginFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return fs.Join("/", virtualPath), nil
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *PluginFs) GetDirSize(dirname string) (int, int64, error) {
+	ctx, cancelFn := context.WithTimeout(context.Background(), pluginRequestTimeout)
+	defer cancelFn()
+
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer releaseFn()
+
+	resp, err := client.DirSize(ctx, fs.getPathRequest(dirname))
+	if err != nil {
+		return 0, 0, fs.convertError(err)
+	}
+	return resp.Files, resp.Size, nil
+}
+
+// GetMimeType returns the content type
+func (fs *PluginFs) GetMimeType(name string) (string, error) {
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return "", err
+	}
+	defer releaseFn()
+
+	ctx, cancelFn := context.WithCancel(context.Background())
+	defer cancelFn()
+
+	stream, err := client.ReadFile(ctx, &fsplugin.ReadFileRequest{
+		Options: fs.config.Options.GetPayload(),
+		Name:    name,
+	})
+	if err != nil {
+		return "", fs.convertError(err)
+	}
+	var buf []byte
+	for len(buf) < 512 {
+		chunk, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fs.convertError(err)
+		}
+		buf = append(buf, chunk.Data...)
+	}
+	if len(buf) > 512 {
+		buf = buf[:512]
+	}
+	return http.DetectContentType(buf), nil
+}
+
+// GetAvailableDiskSize return the available size for the specified path
+func (*PluginFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
+	return nil, ErrStorageSizeUnavailable
+}
+
+// Close closes the fs.
+// The connection to the plugin is shared, it is closed when it is idle
+func (*PluginFs) Close() error {
+	return nil
+}
+
+func (fs *PluginFs) setstat(req *fsplugin.SetstatRequest) error {
+	ctx, cancelFn := context.WithTimeout(context.Background(), pluginRequestTimeout)
+	defer cancelFn()
+
+	client, releaseFn, err := fs.getClient()
+	if err != nil {
+		return err
+	}
+	defer releaseFn()
+
+	req.Options = fs.config.Options.GetPayload()
+	return fs.convertError(client.Setstat(ctx, req))
+}
+
+func (fs *PluginFs) getPathRequest(name string) *fsplugin.PathRequest {
+	return &fsplugin.PathRequest{
+		Options: fs.config.Options.GetPayload(),
+		Name:    name,
+	}
+}
+
+func (*PluginFs) getFileInfo(info *fsplugin.FileInfo) os.FileInfo {
+	mode := os.FileMode(info.Mode)
+	fi := NewFileInfo(info.Name, mode.IsDir(), info.Size, utils.GetTimeFromMsecSinceEpoch(info.ModTime), false)
+	fi.SetMode(mode)
+	return fi
+}
+
+func (*PluginFs) convertError(err error) error {
+	if err == nil {
+		return nil
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	switch s.Code() {
+	case codes.NotFound:
+		return os.ErrNotExist
+	case codes.PermissionDenied:
+		return os.ErrPermission
+	case codes.Unimplemented:
+		return ErrVfsUnsupported
+	default:
+		return errors.New(s.Message())
+	}
+}
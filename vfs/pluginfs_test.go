@@ -0,0 +1,332 @@
+package vfs
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/drakkan/sftpgo/vfs/fsplugin"
+)
+
+// memoryPlugin is an in memory storage plugin
+type memoryPlugin struct {
+	sync.Mutex
+	files map[string][]byte
+	dirs  map[string]bool
+}
+
+func newMemoryPlugin() *memoryPlugin {
+	return &memoryPlugin{
+		files: make(map[string][]byte),
+		dirs:  map[string]bool{"/": true},
+	}
+}
+
+func (p *memoryPlugin) stat(name string) (*fsplugin.FileInfo, error) {
+	if p.dirs[name] {
+		return &fsplugin.FileInfo{Name: path.Base(name), Mode: uint32(os.ModeDir | 0755)}, nil
+	}
+	if data, ok := p.files[name]; ok {
+		return &fsplugin.FileInfo{Name: path.Base(name), Size: int64(len(data)), Mode: 0644}, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+func (p *memoryPlugin) Stat(ctx context.Context, req *fsplugin.PathRequest) (*fsplugin.FileInfo, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	return p.stat(req.Name)
+}
+
+func (p *memoryPlugin) Lstat(ctx context.Context, req *fsplugin.PathRequest) (*fsplugin.FileInfo, error) {
+	return p.Stat(ctx, req)
+}
+
+func (p *memoryPlugin) ReadDir(ctx context.Context, req *fsplugin.PathRequest) (*fsplugin.ReadDirResponse, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.dirs[req.Name] {
+		return nil, os.ErrNotExist
+	}
+	resp := &fsplugin.ReadDirResponse{}
+	for name := range p.files {
+		if path.Dir(name) == req.Name {
+			info, _ := p.stat(name)
+			resp.Entries = append(resp.Entries, *info)
+		}
+	}
+	for name := range p.dirs {
+		if name != "/" && path.Dir(name) == req.Name {
+			info, _ := p.stat(name)
+			resp.Entries = append(resp.Entries, *info)
+		}
+	}
+	return resp, nil
+}
+
+func (p *memoryPlugin) Readlink(ctx context.Context, req *fsplugin.PathRequest) (*fsplugin.ReadlinkResponse, error) {
+	return nil, fsplugin.ErrUnsupported
+}
+
+func (p *memoryPlugin) Mkdir(ctx context.Context, req *fsplugin.PathRequest) (*fsplugin.Empty, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.dirs[path.Dir(req.Name)] {
+		return nil, os.ErrNotExist
+	}
+	p.dirs[req.Name] = true
+	return &fsplugin.Empty{}, nil
+}
+
+func (p *memoryPlugin) Remove(ctx context.Context, req *fsplugin.PathRequest) (*fsplugin.Empty, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if req.IsDir {
+		delete(p.dirs, req.Name)
+		return &fsplugin.Empty{}, nil
+	}
+	if _, ok := p.files[req.Name]; !ok {
+		return nil, os.ErrNotExist
+	}
+	delete(p.files, req.Name)
+	return &fsplugin.Empty{}, nil
+}
+
+func (p *memoryPlugin) Rename(ctx context.Context, req *fsplugin.RenameRequest) (*fsplugin.Empty, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	data, ok := p.files[req.Source]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	delete(p.files, req.Source)
+	p.files[req.Target] = data
+	return &fsplugin.Empty{}, nil
+}
+
+func (p *memoryPlugin) Symlink(ctx context.Context, req *fsplugin.RenameRequest) (*fsplugin.Empty, error) {
+	return nil, fsplugin.ErrUnsupported
+}
+
+func (p *memoryPlugin) Setstat(ctx context.Context, req *fsplugin.SetstatRequest) (*fsplugin.Empty, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	data, ok := p.files[req.Name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	if req.Flags&fsplugin.SetstatFlagSize != 0 && req.Size < int64(len(data)) {
+		p.files[req.Name] = data[:req.Size]
+	}
+	return &fsplugin.Empty{}, nil
+}
+
+func (p *memoryPlugin) DirSize(ctx context.Context, req *fsplugin.PathRequest) (*fsplugin.DirSizeResponse, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	resp := &fsplugin.DirSizeResponse{}
+	for name, data := range p.files {
+		if strings.HasPrefix(name, req.Name) {
+			resp.Files++
+			resp.Size += int64(len(data))
+		}
+	}
+	return resp, nil
+}
+
+func (p *memoryPlugin) ReadFile(req *fsplugin.ReadFileRequest, stream fsplugin.ReadFileServer) error {
+	p.Lock()
+	data, ok := p.files[req.Name]
+	p.Unlock()
+	if !ok {
+		return os.ErrNotExist
+	}
+	if req.Offset > int64(len(data)) {
+		req.Offset = int64(len(data))
+	}
+	data = data[req.Offset:]
+	for len(data) > 0 {
+		n := len(data)
+		if n > 4 {
+			n = 4
+		}
+		if err := stream.Send(&fsplugin.DataChunk{Data: data[:n]}); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func (p *memoryPlugin) WriteFile(stream fsplugin.WriteFileServer) error {
+	req, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	name := req.Name
+	var data []byte
+	for {
+		req, err = stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		data = append(data, req.Data...)
+	}
+	p.Lock()
+	p.files[name] = data
+	p.Unlock()
+	return stream.SendAndClose(&fsplugin.WriteFileResponse{Written: int64(len(data))})
+}
+
+func startMemoryPlugin(t *testing.T, network, address string) (*memoryPlugin, string) {
+	listener, err := net.Listen(network, address)
+	require.NoError(t, err)
+	plugin := newMemoryPlugin()
+	server := grpc.NewServer()
+	fsplugin.RegisterFilesystem(server, plugin)
+	go func() {
+		server.Serve(listener) //nolint:errcheck
+	}()
+	t.Cleanup(server.Stop)
+	if network == "unix" {
+		return plugin, "unix://" + address
+	}
+	return plugin, listener.Addr().String()
+}
+
+func TestPluginFs(t *testing.T) {
+	plugin, endpoint := startMemoryPlugin(t, "unix", filepath.Join(t.TempDir(), "plugin.sock"))
+	fs, err := NewPluginFs("id", "", "", PluginFsConfig{Endpoint: endpoint})
+	require.NoError(t, err)
+
+	_, err = fs.Stat("/missing")
+	assert.True(t, fs.IsNotExist(err))
+	_, err = fs.Readlink("/missing")
+	assert.True(t, fs.IsNotSupported(err))
+	err = fs.MkdirAll("/dir/sub", -1, -1)
+	assert.NoError(t, err)
+	info, err := fs.Stat("/dir/sub")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	_, w, cancelFn, err := fs.Create("/dir/file", 0)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("plugin data"))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	cancelFn()
+	plugin.Lock()
+	assert.Equal(t, []byte("plugin data"), plugin.files["/dir/file"])
+	plugin.Unlock()
+
+	_, r, cancelFn, err := fs.Open("/dir/file", 7)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data"), data)
+	assert.NoError(t, r.Close())
+	cancelFn()
+
+	entries, err := fs.ReadDir("/dir")
+	require.NoError(t, err)
+	assert.Len(t, entries, 2)
+	err = fs.Rename("/dir/file", "/dir/renamed")
+	assert.NoError(t, err)
+	info, err = fs.Stat("/dir/renamed")
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), info.Size())
+	err = fs.Truncate("/dir/renamed", 6)
+	assert.NoError(t, err)
+	files, size, err := fs.GetDirSize("/dir")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, files)
+	assert.Equal(t, int64(6), size)
+	mimeType, err := fs.GetMimeType("/dir/renamed")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", mimeType)
+	err = fs.Remove("/dir/renamed", false)
+	assert.NoError(t, err)
+	err = fs.Remove("/dir/renamed", false)
+	assert.True(t, fs.IsNotExist(err))
+	assert.NoError(t, fs.Close())
+}
+
+func TestPluginConnsEviction(t *testing.T) {
+	oldTimeout := pluginConnIdleTimeout
+	pluginConnIdleTimeout = 100 * time.Millisecond
+	defer func() {
+		pluginConnIdleTimeout = oldTimeout
+	}()
+
+	_, endpoint := startMemoryPlugin(t, "unix", filepath.Join(t.TempDir(), "plugin.sock"))
+	fs, err := NewPluginFs("id", "", "", PluginFsConfig{Endpoint: endpoint})
+	require.NoError(t, err)
+	isCached := func() bool {
+		pluginConns.Lock()
+		defer pluginConns.Unlock()
+
+		_, ok := pluginConns.conns[endpoint]
+		return ok
+	}
+	// a connection with active operations is not closed
+	_, w, cancelFn, err := fs.Create("/file", 0)
+	require.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+	assert.True(t, isCached())
+	assert.NoError(t, w.Close())
+	cancelFn()
+	assert.Eventually(t, func() bool {
+		return !isCached()
+	}, 2*time.Second, 50*time.Millisecond)
+	// a new connection is established as needed
+	_, err = fs.Stat("/")
+	assert.NoError(t, err)
+
+	// managed connections are never closed
+	_, err = NewPluginFs("id", "", "", PluginFsConfig{Endpoint: managedPluginPrefix + "memory"})
+	assert.Error(t, err)
+	conn, err := grpc.Dial(endpoint, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	SetManagedPluginConn("memory", conn)
+	fs, err = NewPluginFs("id", "", "", PluginFsConfig{Endpoint: managedPluginPrefix + "memory"})
+	require.NoError(t, err)
+	_, err = fs.Stat("/")
+	assert.NoError(t, err)
+	time.Sleep(300 * time.Millisecond)
+	pluginConns.Lock()
+	_, ok := pluginConns.conns[managedPluginPrefix+"memory"]
+	pluginConns.Unlock()
+	assert.True(t, ok)
+}
+
+func TestPluginFsTLSRequired(t *testing.T) {
+	// the plugin does not use TLS, the connection must fail
+	_, endpoint := startMemoryPlugin(t, "tcp", "127.0.0.1:0")
+	fs, err := NewPluginFs("id", "", "", PluginFsConfig{Endpoint: endpoint})
+	require.NoError(t, err)
+	_, err = fs.Stat("/")
+	assert.Error(t, err)
+	assert.False(t, fs.IsNotExist(err))
+}
@@ -0,0 +1,131 @@
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"github.com/drakkan/sftpgo/logger"
+)
+
+const quotaScanLogSender = "quotascan"
+
+var (
+	quotaScanMu     sync.RWMutex
+	quotaScanLimits = &quotaScanThrottle{}
+)
+
+// QuotaScanConfig defines the limits for the quota scans, they allow to run the
+// scans in background without saturating the disks or the request quotas of
+// the storage backends. The rate limits are shared among all the scans
+type QuotaScanConfig struct {
+	// Maximum number of files scanned per second. 0 means unlimited
+	FilesPerSecond int `json:"files_per_second" mapstructure:"files_per_second"`
+	// Maximum number of listing requests per second. A request lists a page of
+	// objects for the S3, Google Cloud Storage and Azure Blob backends and a
+	// directory for the other backends. 0 means unlimited
+	RequestsPerSecond int `json:"requests_per_second" mapstructure:"requests_per_second"`
+	// Maximum number of filesystems scanned at the same time, the other scans
+	// wait for a free slot. 0 means unlimited
+	MaxConcurrentScans int `json:"max_concurrent_scans" mapstructure:"max_concurrent_scans"`
+}
+
+func (c *QuotaScanConfig) validate() error {
+	if c.FilesPerSecond < 0 {
+		return fmt.Errorf("invalid files per second: %v", c.FilesPerSecond)
+	}
+	if c.RequestsPerSecond < 0 {
+		return fmt.Errorf("invalid requests per second: %v", c.RequestsPerSecond)
+	}
+	if c.MaxConcurrentScans < 0 {
+		return fmt.Errorf("invalid max concurrent scans: %v", c.MaxConcurrentScans)
+	}
+	return nil
+}
+
+// Initialize configures the quota scans limits. The scans already in progress
+// keep their concurrency slot
+func (c *QuotaScanConfig) Initialize() error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+	throttle := &quotaScanThrottle{}
+	if c.FilesPerSecond > 0 {
+		throttle.files = rate.NewLimiter(rate.Limit(c.FilesPerSecond), c.FilesPerSecond)
+	}
+	if c.RequestsPerSecond > 0 {
+		throttle.requests = rate.NewLimiter(rate.Limit(c.RequestsPerSecond), c.RequestsPerSecond)
+	}
+	if c.MaxConcurrentScans > 0 {
+		throttle.slots = make(chan struct{}, c.MaxConcurrentScans)
+	}
+
+	quotaScanMu.Lock()
+	quotaScanLimits = throttle
+	quotaScanMu.Unlock()
+
+	logger.Debug(quotaScanLogSender, "", "quota scan limits initialized, files per second: %v, requests per second: %v, "+
+		"max concurrent scans: %v", c.FilesPerSecond, c.RequestsPerSecond, c.MaxConcurrentScans)
+	return nil
+}
+
+// ScanQuota returns the number of files contained in the root directory of
+// the given filesystem and their size. It waits for a free slot if the maximum
+// number of concurrent scans is reached and the configured rate limits are
+// applied while scanning
+func ScanQuota(fs Fs) (int, int64, error) {
+	throttle := getQuotaScanThrottle()
+	throttle.acquire()
+	defer throttle.release()
+
+	return fs.ScanRootDirContents()
+}
+
+func getQuotaScanThrottle() *quotaScanThrottle {
+	quotaScanMu.RLock()
+	defer quotaScanMu.RUnlock()
+
+	return quotaScanLimits
+}
+
+// quotaScanThrottle applies the quota scan limits, a nil throttle or a nil
+// limiter means no limits
+type quotaScanThrottle struct {
+	files    *rate.Limiter
+	requests *rate.Limiter
+	slots    chan struct{}
+}
+
+func (t *quotaScanThrottle) acquire() {
+	if t == nil || t.slots == nil {
+		return
+	}
+	t.slots <- struct{}{}
+}
+
+func (t *quotaScanThrottle) release() {
+	if t == nil || t.slots == nil {
+		return
+	}
+	<-t.slots
+}
+
+// waitFiles blocks until n files can be scanned
+func (t *quotaScanThrottle) waitFiles(n int) {
+	if t == nil || t.files == nil {
+		return
+	}
+	for ; n > 0; n-- {
+		t.files.Wait(context.Background()) //nolint:errcheck
+	}
+}
+
+// waitRequest blocks until a new listing request can be sent
+func (t *quotaScanThrottle) waitRequest() {
+	if t == nil || t.requests == nil {
+		return
+	}
+	t.requests.Wait(context.Background()) //nolint:errcheck
+}
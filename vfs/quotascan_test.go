@@ -0,0 +1,100 @@
+package vfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotaScanConfigValidation(t *testing.T) {
+	configs := []QuotaScanConfig{
+		{FilesPerSecond: -1},
+		{RequestsPerSecond: -1},
+		{MaxConcurrentScans: -1},
+	}
+	for _, c := range configs {
+		err := c.Initialize()
+		assert.Error(t, err)
+	}
+	c := QuotaScanConfig{}
+	require.NoError(t, c.Initialize())
+	throttle := getQuotaScanThrottle()
+	assert.Nil(t, throttle.files)
+	assert.Nil(t, throttle.requests)
+	assert.Nil(t, throttle.slots)
+	// a nil throttle has no limits
+	var nilThrottle *quotaScanThrottle
+	nilThrottle.acquire()
+	nilThrottle.waitRequest()
+	nilThrottle.waitFiles(10)
+	nilThrottle.release()
+}
+
+func TestQuotaScanLimits(t *testing.T) {
+	rootDir := t.TempDir()
+	for i := 0; i < 4; i++ {
+		err := os.WriteFile(filepath.Join(rootDir, fmt.Sprintf("file%v", i)), []byte("data"), 0666)
+		require.NoError(t, err)
+	}
+	err := os.Mkdir(filepath.Join(rootDir, "subdir"), 0755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(rootDir, "subdir", "file"), []byte("data"), 0666)
+	require.NoError(t, err)
+	fs := NewOsFs("", rootDir, "")
+
+	c := QuotaScanConfig{
+		FilesPerSecond:     2,
+		MaxConcurrentScans: 1,
+	}
+	require.NoError(t, c.Initialize())
+	defer func() {
+		c = QuotaScanConfig{}
+		assert.NoError(t, c.Initialize())
+	}()
+
+	startTime := time.Now()
+	numFiles, size, err := ScanQuota(fs)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, numFiles)
+	assert.Equal(t, int64(20), size)
+	// the first 2 files are allowed by the burst, the others wait 500ms each
+	assert.GreaterOrEqual(t, time.Since(startTime), 1400*time.Millisecond)
+	// the rate limits are not applied outside the quota scans
+	startTime = time.Now()
+	numFiles, _, err = fs.GetDirSize(rootDir)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, numFiles)
+	assert.Less(t, time.Since(startTime), 500*time.Millisecond)
+
+	c = QuotaScanConfig{
+		RequestsPerSecond:  100,
+		MaxConcurrentScans: 1,
+	}
+	require.NoError(t, c.Initialize())
+	throttle := getQuotaScanThrottle()
+	throttle.acquire()
+	done := make(chan bool)
+	go func() {
+		numFiles, _, err := ScanQuota(fs)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, numFiles)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		assert.Fail(t, "the quota scan must wait for a free slot")
+	case <-time.After(200 * time.Millisecond):
+	}
+	throttle.release()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "the quota scan must complete after releasing the slot")
+	}
+}
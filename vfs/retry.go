@@ -0,0 +1,150 @@
+package vfs
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/drakkan/sftpgo/httpclient"
+)
+
+const (
+	defaultRequestTimeout = 30 * time.Second
+	// long running requests, such as server side copies and listings,
+	// can last this number of times the request timeout
+	longRequestTimeoutMultiplier = 10
+	defaultRetryBackoff          = 500 * time.Millisecond
+	maxRetryBackoff              = 30 * time.Second
+	maxRequestRetries            = 20
+	maxRetryBackoffMs            = 60000
+	maxRequestTimeout            = 3600
+)
+
+// CloudRequestConfig defines the retries and the timeouts for the requests
+// to the cloud storage backends
+type CloudRequestConfig struct {
+	// Maximum number of retries for the requests failed with a transient error,
+	// for example a 503 response. 0 means the default for the backend
+	MaxRetries int `json:"max_retries,omitempty"`
+	// Initial delay, in milliseconds, before retrying a failed request. The delay
+	// is doubled for each retry, up to 30 seconds. 0 means 500 milliseconds
+	RetryBackoff int `json:"retry_backoff,omitempty"`
+	// Timeout, in seconds, for each request. 0 means 30 seconds. Long running
+	// requests, such as server side copies, are allowed to last ten times longer.
+	// Uploads and downloads are not limited, but the storage backend must start
+	// responding within this timeout
+	RequestTimeout int `json:"request_timeout,omitempty"`
+}
+
+func (c *CloudRequestConfig) isEqual(other *CloudRequestConfig) bool {
+	return c.MaxRetries == other.MaxRetries && c.RetryBackoff == other.RetryBackoff &&
+		c.RequestTimeout == other.RequestTimeout
+}
+
+func (c *CloudRequestConfig) validate() error {
+	if c.MaxRetries < 0 || c.MaxRetries > maxRequestRetries {
+		return fmt.Errorf("invalid max retries: %v", c.MaxRetries)
+	}
+	if c.RetryBackoff < 0 || c.RetryBackoff > maxRetryBackoffMs {
+		return fmt.Errorf("invalid retry backoff: %v", c.RetryBackoff)
+	}
+	if c.RequestTimeout < 0 || c.RequestTimeout > maxRequestTimeout {
+		return fmt.Errorf("invalid request timeout: %v", c.RequestTimeout)
+	}
+	return nil
+}
+
+// isCustomized returns true if any value differs from the backend defaults.
+// A custom HTTP transport is required to use an explicitly configured proxy
+// too, so this method returns true in this case
+func (c *CloudRequestConfig) isCustomized() bool {
+	return c.MaxRetries > 0 || c.RetryBackoff > 0 || c.RequestTimeout > 0 || httpclient.IsProxyConfigured()
+}
+
+// getTimeouts returns the timeout for the requests and the one for the long running requests
+func (c *CloudRequestConfig) getTimeouts() (time.Duration, time.Duration) {
+	timeout := defaultRequestTimeout
+	if c.RequestTimeout > 0 {
+		timeout = time.Duration(c.RequestTimeout) * time.Second
+	}
+	return timeout, longRequestTimeoutMultiplier * timeout
+}
+
+func (c *CloudRequestConfig) getRetryBackoff() time.Duration {
+	if c.RetryBackoff > 0 {
+		return time.Duration(c.RetryBackoff) * time.Millisecond
+	}
+	return defaultRetryBackoff
+}
+
+// getHTTPTransport returns an HTTP transport that fails the requests for
+// which the server does not start responding within the request timeout.
+// The configured outbound proxy, if any, is used
+func (c *CloudRequestConfig) getHTTPTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout, _ = c.getTimeouts()
+	transport.Proxy = httpclient.GetProxyFunc()
+	return transport
+}
+
+// retryTransport retries the requests failed with a transient error using an
+// exponential backoff. Requests whose body cannot be sent again are never retried
+type retryTransport struct {
+	base       http.RoundTripper
+	maxRetries int
+	backoff    time.Duration
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt >= t.maxRetries || !isRetryableResponse(resp, err) || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096)) //nolint:errcheck
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(getRetryDelay(t.backoff, attempt))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// isRetryableResponse returns true for throttled requests, server errors and
+// network errors, such as the timeouts waiting for the response headers
+func isRetryableResponse(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode >= http.StatusInternalServerError
+}
+
+// getRetryDelay returns the delay before the given retry attempt, the delay is
+// doubled for each attempt and a random jitter is added
+func getRetryDelay(backoff time.Duration, attempt int) time.Duration {
+	delay := backoff
+	for i := 0; i < attempt && delay < maxRetryBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryBackoff {
+		delay = maxRetryBackoff
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
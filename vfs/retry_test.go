@@ -0,0 +1,93 @@
+package vfs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudRequestConfig(t *testing.T) {
+	config := CloudRequestConfig{}
+	assert.NoError(t, config.validate())
+	assert.False(t, config.isCustomized())
+	timeout, longTimeout := config.getTimeouts()
+	assert.Equal(t, 30*time.Second, timeout)
+	assert.Equal(t, 300*time.Second, longTimeout)
+	assert.Equal(t, defaultRetryBackoff, config.getRetryBackoff())
+
+	config.RequestTimeout = 5
+	config.RetryBackoff = 100
+	assert.True(t, config.isCustomized())
+	timeout, longTimeout = config.getTimeouts()
+	assert.Equal(t, 5*time.Second, timeout)
+	assert.Equal(t, 50*time.Second, longTimeout)
+	assert.Equal(t, 100*time.Millisecond, config.getRetryBackoff())
+	assert.Equal(t, 5*time.Second, config.getHTTPTransport().ResponseHeaderTimeout)
+
+	config.MaxRetries = maxRequestRetries + 1
+	assert.Error(t, config.validate())
+	config.MaxRetries = 0
+	config.RetryBackoff = -1
+	assert.Error(t, config.validate())
+	config.RetryBackoff = 0
+	config.RequestTimeout = maxRequestTimeout + 1
+	assert.Error(t, config.validate())
+
+	for attempt := 0; attempt < 10; attempt++ {
+		delay := getRetryDelay(time.Second, attempt)
+		assert.LessOrEqual(t, int64(delay), int64(maxRetryBackoff))
+		assert.GreaterOrEqual(t, int64(delay), int64(time.Second/2))
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(body) //nolint:errcheck
+	}))
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &retryTransport{
+			base:       http.DefaultTransport,
+			maxRetries: 3,
+			backoff:    time.Millisecond,
+		},
+	}
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("data"))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "data", string(body))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&requests))
+
+	atomic.StoreInt32(&requests, 0)
+	client.Transport.(*retryTransport).maxRetries = 1
+	resp, err = client.Get(server.URL)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// a body that cannot be sent again is never retried
+	atomic.StoreInt32(&requests, 0)
+	resp, err = client.Post(server.URL, "text/plain", io.NopCloser(strings.NewReader("data")))
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+}
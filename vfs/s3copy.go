@@ -0,0 +1,250 @@
+// +build !nos3
+
+package vfs
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/utils"
+)
+
+// s3ResumableCopies tracks the interrupted multipart copies, the key is the
+// copy target. A failed rename can be retried without copying again the
+// parts already copied
+var s3ResumableCopies = &resumableUploads{
+	uploads: make(map[string]resumableUpload),
+}
+
+func (fs *S3Fs) getCopyPartSize(fileSize int64) int64 {
+	partSize := int64(s3MultipartCopyPartSize)
+	if fileSize/partSize >= s3MaxParts {
+		partSize = fileSize/(s3MaxParts-1) + 1
+	}
+	return partSize
+}
+
+// doMultipartCopy copies source to target using UploadPartCopy. Parts are
+// copied in parallel and each failed part is retried, so a transient error
+// does not require to restart the whole copy. If the copy fails the multipart
+// upload is kept with the copied parts, so a new copy for the same target
+// resumes it if the source is unchanged.
+// If metadata is nil the metadata of the source object are preserved, as for
+// CopyObject, otherwise they are replaced with the given ones. A copy with
+// replaced metadata is aborted on errors, since the metadata may be different
+// for the next attempt
+func (fs *S3Fs) doMultipartCopy(source, target, contentType string, fileSize int64, metadata map[string]*string) error {
+	obj, err := fs.headObject(source)
+	if err != nil {
+		return fmt.Errorf("unable to get the attributes for %#v: %w", source, err)
+	}
+	if obj.ContentType != nil && *obj.ContentType != "" {
+		contentType = *obj.ContentType
+	}
+	canResume := metadata == nil
+	if canResume {
+		metadata = obj.Metadata
+	}
+	upload, err := fs.getMultipartCopy(source, target, contentType, aws.StringValue(obj.ETag), metadata,
+		fs.getCopyPartSize(fileSize), canResume)
+	if err != nil {
+		return err
+	}
+	numParts := (fileSize + upload.partSize - 1) / upload.partSize
+	fsLog(fs, logger.LevelDebug, "starting multipart copy from %#v to %#v, size: %v, parts: %v, already copied: %v, upload id: %v",
+		source, target, fileSize, numParts, len(upload.parts), upload.uploadID)
+
+	copiedParts := make(map[int64]bool)
+	for _, part := range upload.parts {
+		copiedParts[*part.PartNumber] = true
+	}
+	guard := make(chan struct{}, fs.config.UploadConcurrency)
+	completedParts := append([]*s3.CompletedPart(nil), upload.parts...)
+	var copiedBytes int64
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var copyError error
+
+	opCtx, opCancel := context.WithCancel(context.Background())
+	defer opCancel()
+
+	for partNumber := int64(1); partNumber <= numParts; partNumber++ {
+		if copiedParts[partNumber] {
+			continue
+		}
+		start := (partNumber - 1) * upload.partSize
+		end := start + upload.partSize - 1
+		if end >= fileSize {
+			end = fileSize - 1
+		}
+
+		guard <- struct{}{}
+		if opCtx.Err() != nil {
+			fsLog(fs, logger.LevelDebug, "multipart copy error, copy for part %v not started", partNumber)
+			break
+		}
+
+		wg.Add(1)
+		go func(partNumber, start, end int64) {
+			defer func() {
+				<-guard
+				wg.Done()
+			}()
+
+			etag, err := fs.copyPart(opCtx, upload, target, partNumber, start, end)
+			if err != nil {
+				errOnce.Do(func() {
+					copyError = err
+					fsLog(fs, logger.LevelError, "unable to copy part %v for %#v: %v", partNumber, target, err)
+					opCancel()
+				})
+				return
+			}
+
+			mu.Lock()
+			completedParts = append(completedParts, &s3.CompletedPart{
+				ETag:       etag,
+				PartNumber: aws.Int64(partNumber),
+			})
+			copiedBytes += end - start + 1
+			fsLog(fs, logger.LevelDebug, "multipart copy of %#v in progress, copied bytes: %v/%v",
+				target, copiedBytes, fileSize)
+			mu.Unlock()
+		}(partNumber, start, end)
+	}
+
+	wg.Wait()
+	close(guard)
+
+	sort.Slice(completedParts, func(i, j int) bool {
+		return *completedParts[i].PartNumber < *completedParts[j].PartNumber
+	})
+	upload.parts = completedParts
+
+	if copyError != nil {
+		fs.handleMultipartCopyError(target, upload, canResume)
+		return fmt.Errorf("multipart copy error: %w", copyError)
+	}
+
+	completeCtx, completeCancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer completeCancelFn()
+
+	_, err = fs.svc.CompleteMultipartUploadWithContext(completeCtx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(fs.config.Bucket),
+		Key:      aws.String(target),
+		UploadId: aws.String(upload.uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: completedParts,
+		},
+	})
+	if err != nil {
+		fs.handleMultipartCopyError(target, upload, canResume)
+		return fmt.Errorf("unable to complete multipart copy: %w", err)
+	}
+	fsLog(fs, logger.LevelDebug, "multipart copy from %#v to %#v completed, size: %v", source, target, fileSize)
+	return nil
+}
+
+// getMultipartCopy returns the interrupted copy to target, if it can be
+// resumed, or a new multipart upload. An interrupted copy is resumed only if
+// it is not expired and the source object and the part size are unchanged,
+// otherwise it is aborted
+func (fs *S3Fs) getMultipartCopy(source, target, contentType, sourceETag string, metadata map[string]*string,
+	partSize int64, canResume bool,
+) (resumableUpload, error) {
+	// the copy is removed while in progress, so concurrent copies to the same
+	// target cannot use the same multipart upload
+	if upload, ok := s3ResumableCopies.remove(fs.getResumableUploadKey(target)); ok {
+		if canResume && !upload.isExpired() && upload.source == source && upload.sourceETag == sourceETag &&
+			upload.partSize == partSize {
+			fsLog(fs, logger.LevelDebug, "resuming multipart copy from %#v to %#v, upload id: %v",
+				source, target, upload.uploadID)
+			return upload, nil
+		}
+		err := fs.abortMultipartUpload(target, upload.uploadID)
+		fsLog(fs, logger.LevelDebug, "interrupted copy to %#v cannot be resumed, upload id %v aborted, err: %v",
+			target, upload.uploadID, err)
+	}
+
+	storageClass, tagging := fs.getUploadSettings(target)
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	res, err := fs.svc.CreateMultipartUploadWithContext(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(fs.config.Bucket),
+		Key:                  aws.String(target),
+		StorageClass:         utils.NilIfEmpty(storageClass),
+		Tagging:              utils.NilIfEmpty(tagging),
+		ContentType:          utils.NilIfEmpty(contentType),
+		Metadata:             metadata,
+		ServerSideEncryption: fs.getServerSideEncryption(),
+		SSEKMSKeyId:          utils.NilIfEmpty(fs.config.SSEKMSKeyID),
+		SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:       fs.getSSECustomerKey(),
+	})
+	if err != nil {
+		return resumableUpload{}, fmt.Errorf("unable to create multipart copy request: %w", err)
+	}
+	return resumableUpload{
+		uploadID:   aws.StringValue(res.UploadId),
+		partSize:   partSize,
+		createdAt:  time.Now(),
+		source:     source,
+		sourceETag: sourceETag,
+	}, nil
+}
+
+// handleMultipartCopyError records an interrupted multipart copy, so it can
+// be resumed, or aborts it
+func (fs *S3Fs) handleMultipartCopyError(target string, upload resumableUpload, canResume bool) {
+	if !canResume {
+		err := fs.abortMultipartUpload(target, upload.uploadID)
+		fsLog(fs, logger.LevelDebug, "interrupted copy to %#v aborted, upload id: %v, err: %v",
+			target, upload.uploadID, err)
+		return
+	}
+	s3ResumableCopies.add(fs.getResumableUploadKey(target), upload)
+	fsLog(fs, logger.LevelDebug, "interrupted copy to %#v can be resumed, upload id: %v, copied parts: %v",
+		target, upload.uploadID, len(upload.parts))
+}
+
+// copyPart copies the specified range of the source object as a new part.
+// The copy fails if the source object is modified after the copy is started
+func (fs *S3Fs) copyPart(ctx context.Context, upload resumableUpload, target string, partNumber, start, end int64) (*string, error) {
+	var err error
+	for attempt := 1; attempt <= s3CopyPartMaxRetries; attempt++ {
+		innerCtx, cancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxLongTimeout))
+		var res *s3.UploadPartCopyOutput
+		res, err = fs.svc.UploadPartCopyWithContext(innerCtx, &s3.UploadPartCopyInput{
+			Bucket:                         aws.String(fs.config.Bucket),
+			CopySource:                     aws.String(url.PathEscape(fs.Join(fs.config.Bucket, upload.source))),
+			CopySourceIfMatch:              utils.NilIfEmpty(upload.sourceETag),
+			Key:                            aws.String(target),
+			PartNumber:                     aws.Int64(partNumber),
+			UploadId:                       aws.String(upload.uploadID),
+			CopySourceRange:                aws.String(fmt.Sprintf("bytes=%v-%v", start, end)),
+			SSECustomerAlgorithm:           fs.getSSECustomerAlgorithm(),
+			SSECustomerKey:                 fs.getSSECustomerKey(),
+			CopySourceSSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+			CopySourceSSECustomerKey:       fs.getSSECustomerKey(),
+		})
+		cancelFn()
+		if err == nil {
+			return res.CopyPartResult.ETag, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		fsLog(fs, logger.LevelWarn, "unable to copy part %v for %#v, attempt %v: %v", partNumber, target, attempt, err)
+	}
+	return nil, err
+}
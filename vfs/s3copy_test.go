@@ -0,0 +1,420 @@
+// +build !nos3
+
+package vfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const s3CopyTestTarget = "target"
+
+// s3CopyClientMock implements the S3 API calls used for the server side copies
+type s3CopyClientMock struct {
+	s3iface.S3API
+	sync.Mutex
+	etag      string
+	size      int64
+	metadata  map[string]*string
+	copies    []*s3.CopyObjectInput
+	uploadIDs int
+	created   []*s3.CreateMultipartUploadInput
+	copied    []*s3.UploadPartCopyInput
+	completed []*s3.CompleteMultipartUploadInput
+	aborted   []string
+	// number of failures for each part number, a negative value means always
+	partErrors    map[int64]int
+	completeError error
+}
+
+func (m *s3CopyClientMock) HeadObjectWithContext(ctx aws.Context, input *s3.HeadObjectInput,
+	opts ...request.Option,
+) (*s3.HeadObjectOutput, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	return &s3.HeadObjectOutput{
+		ETag:          aws.String(m.etag),
+		ContentLength: aws.Int64(m.size),
+		ContentType:   aws.String("application/zip"),
+		Metadata:      m.metadata,
+	}, nil
+}
+
+func (m *s3CopyClientMock) CopyObjectWithContext(ctx aws.Context, input *s3.CopyObjectInput,
+	opts ...request.Option,
+) (*s3.CopyObjectOutput, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.copies = append(m.copies, input)
+	if aws.StringValue(input.MetadataDirective) == s3.MetadataDirectiveReplace {
+		m.metadata = input.Metadata
+	}
+	return &s3.CopyObjectOutput{}, nil
+}
+
+func (m *s3CopyClientMock) CreateMultipartUploadWithContext(ctx aws.Context, input *s3.CreateMultipartUploadInput,
+	opts ...request.Option,
+) (*s3.CreateMultipartUploadOutput, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.uploadIDs++
+	m.created = append(m.created, input)
+	return &s3.CreateMultipartUploadOutput{
+		UploadId: aws.String(fmt.Sprintf("upload%v", m.uploadIDs)),
+	}, nil
+}
+
+func (m *s3CopyClientMock) UploadPartCopyWithContext(ctx aws.Context, input *s3.UploadPartCopyInput,
+	opts ...request.Option,
+) (*s3.UploadPartCopyOutput, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.copied = append(m.copied, input)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	partNumber := aws.Int64Value(input.PartNumber)
+	if failures := m.partErrors[partNumber]; failures != 0 {
+		if failures > 0 {
+			m.partErrors[partNumber]--
+		}
+		return nil, errors.New("unable to copy part")
+	}
+	return &s3.UploadPartCopyOutput{
+		CopyPartResult: &s3.CopyPartResult{
+			ETag: aws.String(fmt.Sprintf("etag%v", partNumber)),
+		},
+	}, nil
+}
+
+func (m *s3CopyClientMock) CompleteMultipartUploadWithContext(ctx aws.Context, input *s3.CompleteMultipartUploadInput,
+	opts ...request.Option,
+) (*s3.CompleteMultipartUploadOutput, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.completed = append(m.completed, input)
+	return &s3.CompleteMultipartUploadOutput{}, m.completeError
+}
+
+func (m *s3CopyClientMock) AbortMultipartUploadWithContext(ctx aws.Context, input *s3.AbortMultipartUploadInput,
+	opts ...request.Option,
+) (*s3.AbortMultipartUploadOutput, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.aborted = append(m.aborted, aws.StringValue(input.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (m *s3CopyClientMock) setPartErrors(partErrors map[int64]int) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.partErrors = partErrors
+}
+
+func getS3CopyTestFs(t *testing.T, client *s3CopyClientMock) *S3Fs {
+	fs := &S3Fs{
+		connectionID: "copy_test",
+		config: &S3FsConfig{
+			Bucket: "bucket",
+			// parts are copied one at a time, so the copied parts are predictable on errors
+			UploadConcurrency: 1,
+		},
+		svc:            client,
+		ctxTimeout:     10 * time.Second,
+		ctxLongTimeout: 10 * time.Second,
+	}
+	t.Cleanup(func() {
+		s3ResumableCopies.remove(fs.getResumableUploadKey(s3CopyTestTarget))
+	})
+	return fs
+}
+
+func getCompletedPartNumbers(parts []*s3.CompletedPart) []int64 {
+	var partNumbers []int64
+	for _, part := range parts {
+		partNumbers = append(partNumbers, aws.Int64Value(part.PartNumber))
+	}
+	return partNumbers
+}
+
+func TestS3CopyPartSize(t *testing.T) {
+	fs := &S3Fs{}
+	assert.Equal(t, int64(s3MultipartCopyPartSize), fs.getCopyPartSize(s3MultipartCopyThreshold+1))
+	assert.Equal(t, int64(s3MultipartCopyPartSize), fs.getCopyPartSize(s3MultipartCopyPartSize*(s3MaxParts-1)))
+
+	for _, fileSize := range []int64{
+		s3MultipartCopyThreshold + 1,
+		s3MultipartCopyPartSize * (s3MaxParts - 1),
+		s3MultipartCopyPartSize * s3MaxParts,
+		s3MultipartCopyPartSize*s3MaxParts + 1,
+		// the maximum object size allowed by S3
+		5 * 1024 * 1024 * 1024 * 1024,
+	} {
+		partSize := fs.getCopyPartSize(fileSize)
+		numParts := (fileSize + partSize - 1) / partSize
+		assert.GreaterOrEqual(t, partSize, int64(s3MultipartCopyPartSize), "file size: %v", fileSize)
+		// the maximum part size allowed by S3 is 5GB
+		assert.LessOrEqual(t, partSize, int64(5*1024*1024*1024), "file size: %v", fileSize)
+		assert.LessOrEqual(t, numParts, int64(s3MaxParts), "file size: %v", fileSize)
+		// the last part is not empty
+		assert.Less(t, (numParts-1)*partSize, fileSize, "file size: %v", fileSize)
+	}
+}
+
+func TestS3CopyPartRetry(t *testing.T) {
+	client := &s3CopyClientMock{
+		partErrors: map[int64]int{1: s3CopyPartMaxRetries - 1},
+	}
+	fs := getS3CopyTestFs(t, client)
+	upload := resumableUpload{
+		uploadID:   "id",
+		source:     "dir/source",
+		sourceETag: "etag",
+	}
+	etag, err := fs.copyPart(context.Background(), upload, s3CopyTestTarget, 1, 0, 99)
+	require.NoError(t, err)
+	assert.Equal(t, "etag1", aws.StringValue(etag))
+	require.Len(t, client.copied, s3CopyPartMaxRetries)
+	input := client.copied[0]
+	assert.Equal(t, url.PathEscape("bucket/dir/source"), aws.StringValue(input.CopySource))
+	assert.Equal(t, "etag", aws.StringValue(input.CopySourceIfMatch))
+	assert.Equal(t, "bytes=0-99", aws.StringValue(input.CopySourceRange))
+	assert.Equal(t, "id", aws.StringValue(input.UploadId))
+	assert.Equal(t, s3CopyTestTarget, aws.StringValue(input.Key))
+	// all the attempts fail
+	client.copied = nil
+	client.setPartErrors(map[int64]int{2: -1})
+	_, err = fs.copyPart(context.Background(), upload, s3CopyTestTarget, 2, 100, 199)
+	assert.Error(t, err)
+	assert.Len(t, client.copied, s3CopyPartMaxRetries)
+	// a canceled copy is not retried
+	client.copied = nil
+	ctx, cancelFn := context.WithCancel(context.Background())
+	cancelFn()
+	_, err = fs.copyPart(ctx, upload, s3CopyTestTarget, 1, 0, 99)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Len(t, client.copied, 1)
+}
+
+func TestS3MultipartCopy(t *testing.T) {
+	client := &s3CopyClientMock{
+		etag: "etag",
+		metadata: map[string]*string{
+			"Key": aws.String("value"),
+		},
+	}
+	fs := getS3CopyTestFs(t, client)
+	fs.config.UploadConcurrency = 2
+	fileSize := int64(2*s3MultipartCopyPartSize + 100)
+	err := fs.doMultipartCopy("source", s3CopyTestTarget, "application/octet-stream", fileSize, nil)
+	require.NoError(t, err)
+
+	require.Len(t, client.created, 1)
+	// the source attributes are preserved
+	assert.Equal(t, client.metadata, client.created[0].Metadata)
+	assert.Equal(t, "application/zip", aws.StringValue(client.created[0].ContentType))
+	ranges := make(map[int64]string)
+	for _, input := range client.copied {
+		ranges[aws.Int64Value(input.PartNumber)] = aws.StringValue(input.CopySourceRange)
+	}
+	assert.Equal(t, map[int64]string{
+		1: fmt.Sprintf("bytes=0-%v", s3MultipartCopyPartSize-1),
+		2: fmt.Sprintf("bytes=%v-%v", s3MultipartCopyPartSize, 2*s3MultipartCopyPartSize-1),
+		3: fmt.Sprintf("bytes=%v-%v", 2*s3MultipartCopyPartSize, fileSize-1),
+	}, ranges)
+	require.Len(t, client.completed, 1)
+	assert.Equal(t, "upload1", aws.StringValue(client.completed[0].UploadId))
+	parts := client.completed[0].MultipartUpload.Parts
+	assert.Equal(t, []int64{1, 2, 3}, getCompletedPartNumbers(parts))
+	for _, part := range parts {
+		assert.Equal(t, fmt.Sprintf("etag%v", aws.Int64Value(part.PartNumber)), aws.StringValue(part.ETag))
+	}
+	assert.Len(t, client.aborted, 0)
+	_, ok := s3ResumableCopies.get(fs.getResumableUploadKey(s3CopyTestTarget))
+	assert.False(t, ok)
+}
+
+func TestS3MultipartCopyResume(t *testing.T) {
+	client := &s3CopyClientMock{
+		etag:       "etag",
+		partErrors: map[int64]int{2: -1},
+	}
+	fs := getS3CopyTestFs(t, client)
+	key := fs.getResumableUploadKey(s3CopyTestTarget)
+	fileSize := int64(3 * s3MultipartCopyPartSize)
+	err := fs.doMultipartCopy("source", s3CopyTestTarget, "", fileSize, nil)
+	assert.Error(t, err)
+	assert.Len(t, client.completed, 0)
+	assert.Len(t, client.aborted, 0)
+	upload, ok := s3ResumableCopies.get(key)
+	require.True(t, ok)
+	assert.Equal(t, "upload1", upload.uploadID)
+	assert.Equal(t, "source", upload.source)
+	assert.Equal(t, "etag", upload.sourceETag)
+	assert.Equal(t, []int64{1}, getCompletedPartNumbers(upload.parts))
+	// the copy is resumed and only the missing parts are copied
+	client.setPartErrors(nil)
+	client.copied = nil
+	err = fs.doMultipartCopy("source", s3CopyTestTarget, "", fileSize, nil)
+	require.NoError(t, err)
+	assert.Len(t, client.created, 1)
+	var copiedParts []int64
+	for _, input := range client.copied {
+		copiedParts = append(copiedParts, aws.Int64Value(input.PartNumber))
+	}
+	assert.Equal(t, []int64{2, 3}, copiedParts)
+	require.Len(t, client.completed, 1)
+	assert.Equal(t, "upload1", aws.StringValue(client.completed[0].UploadId))
+	assert.Equal(t, []int64{1, 2, 3}, getCompletedPartNumbers(client.completed[0].MultipartUpload.Parts))
+	_, ok = s3ResumableCopies.get(key)
+	assert.False(t, ok)
+	// a copy from a different source is not resumed
+	client.setPartErrors(map[int64]int{3: -1})
+	err = fs.doMultipartCopy("source", s3CopyTestTarget, "", fileSize, nil)
+	assert.Error(t, err)
+	client.setPartErrors(nil)
+	err = fs.doMultipartCopy("source1", s3CopyTestTarget, "", fileSize, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"upload2"}, client.aborted)
+	assert.Len(t, client.created, 3)
+}
+
+func TestS3MultipartCopyAbort(t *testing.T) {
+	client := &s3CopyClientMock{
+		etag:       "etag",
+		partErrors: map[int64]int{2: -1},
+	}
+	fs := getS3CopyTestFs(t, client)
+	key := fs.getResumableUploadKey(s3CopyTestTarget)
+	fileSize := int64(3 * s3MultipartCopyPartSize)
+	err := fs.doMultipartCopy("source", s3CopyTestTarget, "", fileSize, nil)
+	assert.Error(t, err)
+	// the source object is modified, the interrupted copy is aborted
+	client.setPartErrors(nil)
+	client.etag = "etag-modified"
+	client.copied = nil
+	err = fs.doMultipartCopy("source", s3CopyTestTarget, "", fileSize, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"upload1"}, client.aborted)
+	assert.Len(t, client.created, 2)
+	assert.Len(t, client.copied, 3)
+	require.Len(t, client.completed, 1)
+	assert.Equal(t, "upload2", aws.StringValue(client.completed[0].UploadId))
+	// the interrupted copy is expired
+	client.setPartErrors(map[int64]int{3: -1})
+	err = fs.doMultipartCopy("source", s3CopyTestTarget, "", fileSize, nil)
+	assert.Error(t, err)
+	upload, ok := s3ResumableCopies.get(key)
+	require.True(t, ok)
+	assert.Equal(t, "upload3", upload.uploadID)
+	upload.createdAt = time.Now().Add(-s3ResumableUploadMaxAge - time.Minute)
+	s3ResumableCopies.add(key, upload)
+	client.setPartErrors(nil)
+	err = fs.doMultipartCopy("source", s3CopyTestTarget, "", fileSize, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"upload1", "upload3"}, client.aborted)
+	require.Len(t, client.completed, 2)
+	assert.Equal(t, "upload4", aws.StringValue(client.completed[1].UploadId))
+}
+
+func TestS3MultipartCopyCompleteError(t *testing.T) {
+	client := &s3CopyClientMock{
+		etag:          "etag",
+		completeError: errors.New("unable to complete"),
+	}
+	fs := getS3CopyTestFs(t, client)
+	fileSize := int64(3 * s3MultipartCopyPartSize)
+	err := fs.doMultipartCopy("source", s3CopyTestTarget, "", fileSize, nil)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unable to complete multipart copy")
+	}
+	upload, ok := s3ResumableCopies.get(fs.getResumableUploadKey(s3CopyTestTarget))
+	require.True(t, ok)
+	assert.Equal(t, []int64{1, 2, 3}, getCompletedPartNumbers(upload.parts))
+	assert.Len(t, client.aborted, 0)
+	// all the parts are already copied, the copy is only completed
+	client.completeError = nil
+	err = fs.doMultipartCopy("source", s3CopyTestTarget, "", fileSize, nil)
+	require.NoError(t, err)
+	assert.Len(t, client.created, 1)
+	assert.Len(t, client.copied, 3)
+	require.Len(t, client.completed, 2)
+	assert.Equal(t, "upload1", aws.StringValue(client.completed[1].UploadId))
+	assert.Equal(t, []int64{1, 2, 3}, getCompletedPartNumbers(client.completed[1].MultipartUpload.Parts))
+}
+
+func TestS3MultipartCopyReplaceMetadata(t *testing.T) {
+	client := &s3CopyClientMock{
+		etag:       "etag",
+		partErrors: map[int64]int{2: -1},
+	}
+	fs := getS3CopyTestFs(t, client)
+	fileSize := int64(3 * s3MultipartCopyPartSize)
+	metadata := map[string]*string{
+		"key": aws.String("value"),
+	}
+	err := fs.doMultipartCopy(s3CopyTestTarget, s3CopyTestTarget, "", fileSize, metadata)
+	assert.Error(t, err)
+	require.Len(t, client.created, 1)
+	assert.Equal(t, metadata, client.created[0].Metadata)
+	// a copy that replaces the metadata is not resumable
+	assert.Equal(t, []string{"upload1"}, client.aborted)
+	_, ok := s3ResumableCopies.get(fs.getResumableUploadKey(s3CopyTestTarget))
+	assert.False(t, ok)
+
+	client.setPartErrors(nil)
+	err = fs.doMultipartCopy(s3CopyTestTarget, s3CopyTestTarget, "", fileSize, metadata)
+	require.NoError(t, err)
+	require.Len(t, client.completed, 1)
+	assert.Equal(t, "upload2", aws.StringValue(client.completed[0].UploadId))
+}
+
+func TestS3Checksum(t *testing.T) {
+	client := &s3CopyClientMock{
+		etag: "etag",
+		size: 100,
+	}
+	fs := getS3CopyTestFs(t, client)
+	checksum, err := fs.GetChecksum(s3CopyTestTarget)
+	require.NoError(t, err)
+	assert.Empty(t, checksum)
+	err = fs.SetChecksum(s3CopyTestTarget, "abcd")
+	require.NoError(t, err)
+	require.Len(t, client.copies, 1)
+	assert.Equal(t, s3.MetadataDirectiveReplace, aws.StringValue(client.copies[0].MetadataDirective))
+	checksum, err = fs.GetChecksum(s3CopyTestTarget)
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", checksum)
+	// the checksum is stale if the size changes
+	client.size = 101
+	checksum, err = fs.GetChecksum(s3CopyTestTarget)
+	require.NoError(t, err)
+	assert.Empty(t, checksum)
+	// big objects are copied using a multipart copy to update the metadata
+	client.size = s3MultipartCopyThreshold + 1
+	err = fs.SetChecksum(s3CopyTestTarget, "efgh")
+	require.NoError(t, err)
+	assert.Len(t, client.copies, 1)
+	require.Len(t, client.created, 1)
+	assert.Equal(t, "efgh", getChecksumFromMetadata(aws.StringValueMap(client.created[0].Metadata), client.size))
+	require.Len(t, client.completed, 1)
+}
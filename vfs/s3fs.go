@@ -3,24 +3,31 @@
 package vfs
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"mime"
+	"net/http"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
 	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/eikenb/pipeat"
 	"github.com/pkg/sftp"
+	"github.com/rs/xid"
 
 	"github.com/drakkan/sftpgo/logger"
 	"github.com/drakkan/sftpgo/metrics"
@@ -28,6 +35,16 @@ import (
 	"github.com/drakkan/sftpgo/version"
 )
 
+const (
+	// objects bigger than this size are renamed using a multipart copy
+	s3MultipartCopyThreshold = 500 * 1024 * 1024
+	s3MultipartCopyPartSize  = 100 * 1024 * 1024
+	// S3 allows at most 10000 parts
+	s3MaxParts = 10000
+	// each part copy is retried this number of times before giving up
+	s3CopyPartMaxRetries = 3
+)
+
 // S3Fs is a Fs implementation for AWS S3 compatible object storages
 type S3Fs struct {
 	connectionID string
@@ -35,9 +52,11 @@ type S3Fs struct {
 	// if not empty this fs is mouted as virtual folder in the specified path
 	mountPath      string
 	config         *S3FsConfig
-	svc            *s3.S3
+	svc            s3iface.S3API
 	ctxTimeout     time.Duration
 	ctxLongTimeout time.Duration
+	// raw SSE-C key, if any
+	sseCustomerKey string
 }
 
 func init() {
@@ -51,16 +70,15 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, config S3FsConfig) (F
 		localTempDir = filepath.Clean(os.TempDir())
 	}
 	fs := &S3Fs{
-		connectionID:   connectionID,
-		localTempDir:   localTempDir,
-		mountPath:      mountPath,
-		config:         &config,
-		ctxTimeout:     30 * time.Second,
-		ctxLongTimeout: 300 * time.Second,
+		connectionID: connectionID,
+		localTempDir: localTempDir,
+		mountPath:    mountPath,
+		config:       &config,
 	}
 	if err := fs.config.Validate(); err != nil {
 		return fs, err
 	}
+	fs.ctxTimeout, fs.ctxLongTimeout = fs.config.getTimeouts()
 	awsConfig := aws.NewConfig()
 
 	if fs.config.Region != "" {
@@ -74,6 +92,17 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, config S3FsConfig) (F
 		awsConfig.Credentials = credentials.NewStaticCredentials(fs.config.AccessKey, fs.config.AccessSecret.GetPayload(), "")
 	}
 
+	if fs.config.SSE == s3SSECustomer {
+		if err := fs.config.SSECustomerKey.TryDecrypt(); err != nil {
+			return fs, err
+		}
+		key, err := decodeSSECustomerKey(fs.config.SSECustomerKey.GetPayload())
+		if err != nil {
+			return fs, err
+		}
+		fs.sseCustomerKey = key
+	}
+
 	if fs.config.Endpoint != "" {
 		awsConfig.Endpoint = aws.String(fs.config.Endpoint)
 		awsConfig.S3ForcePathStyle = aws.Bool(true)
@@ -87,6 +116,31 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, config S3FsConfig) (F
 	if fs.config.UploadConcurrency == 0 {
 		fs.config.UploadConcurrency = 2
 	}
+	if fs.config.DownloadPartSize == 0 {
+		fs.config.DownloadPartSize = s3manager.DefaultDownloadPartSize
+	} else {
+		fs.config.DownloadPartSize *= 1024 * 1024
+	}
+	if fs.config.DownloadConcurrency == 0 {
+		fs.config.DownloadConcurrency = s3manager.DefaultDownloadConcurrency
+	}
+
+	if fs.config.isCustomized() {
+		awsConfig.HTTPClient = &http.Client{
+			Transport: fs.config.getHTTPTransport(),
+		}
+		numRetries := client.DefaultRetryerMaxNumRetries
+		if fs.config.MaxRetries > 0 {
+			numRetries = fs.config.MaxRetries
+		}
+		awsConfig = request.WithRetryer(awsConfig, client.DefaultRetryer{
+			NumMaxRetries:    numRetries,
+			MinRetryDelay:    fs.config.getRetryBackoff(),
+			MinThrottleDelay: fs.config.getRetryBackoff(),
+			MaxRetryDelay:    maxRetryBackoff,
+			MaxThrottleDelay: maxRetryBackoff,
+		})
+	}
 
 	sessOpts := session.Options{
 		Config:            *awsConfig,
@@ -97,7 +151,7 @@ func NewS3Fs(connectionID, localTempDir, mountPath string, config S3FsConfig) (F
 		return fs, err
 	}
 	fs.svc = s3.New(sess)
-	return fs, nil
+	return newCachedFs(fs, fmt.Sprintf("s3 %v %v %v", fs.config.Endpoint, fs.config.Region, fs.config.Bucket)), nil
 }
 
 // Name returns the name for the Fs implementation
@@ -110,8 +164,54 @@ func (fs *S3Fs) ConnectionID() string {
 	return fs.connectionID
 }
 
-// Stat returns a FileInfo describing the named file
+// Stat returns a FileInfo describing the named file, emulated symlinks are followed
 func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Lstat(name)
+	if err == nil && info.Mode()&os.ModeSymlink == 0 {
+		return info, nil
+	}
+	if err != nil && !fs.IsNotExist(err) {
+		return info, err
+	}
+	resolved, errResolve := resolveObjectSymlinks(name, fs.getSymlinkTarget, fs.IsNotExist)
+	if errResolve != nil {
+		return nil, errResolve
+	}
+	if resolved == name {
+		return info, err
+	}
+	info, err = fs.lstat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	return NewFileInfo(name, info.IsDir(), info.Size(), info.ModTime(), false), nil
+}
+
+// Lstat returns a FileInfo describing the named file, emulated symlinks are not
+// followed unless they are parent directories of the named file
+func (fs *S3Fs) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.lstat(name)
+	if err == nil || !fs.IsNotExist(err) {
+		return info, err
+	}
+	resolved, found, errResolve := resolveParentSymlink(name, fs.getSymlinkTarget, fs.IsNotExist)
+	if errResolve != nil {
+		return nil, errResolve
+	}
+	if !found {
+		return info, err
+	}
+	info, err = fs.lstat(resolved)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return newSymlinkFileInfo(name, info.ModTime()), nil
+	}
+	return NewFileInfo(name, info.IsDir(), info.Size(), info.ModTime(), false), nil
+}
+
+func (fs *S3Fs) lstat(name string) (os.FileInfo, error) {
 	var result *FileInfo
 	if name == "/" || name == "." {
 		err := fs.checkIfBucketExists()
@@ -128,6 +228,9 @@ func (fs *S3Fs) Stat(name string) (os.FileInfo, error) {
 		// a "dir" has a trailing "/" so we cannot have a directory here
 		objSize := *obj.ContentLength
 		objectModTime := *obj.LastModified
+		if getS3SymlinkTarget(obj.ContentType, obj.Metadata) != "" {
+			return newSymlinkFileInfo(name, objectModTime), nil
+		}
 		return NewFileInfo(name, false, objSize, objectModTime, false), nil
 	}
 	if !fs.IsNotExist(err) {
@@ -158,13 +261,12 @@ func (fs *S3Fs) getStatForDir(name string) (os.FileInfo, error) {
 	return NewFileInfo(name, true, objSize, objectModTime, false), nil
 }
 
-// Lstat returns a FileInfo describing the named file
-func (fs *S3Fs) Lstat(name string) (os.FileInfo, error) {
-	return fs.Stat(name)
-}
-
 // Open opens the named file for reading
 func (fs *S3Fs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	name, err := resolveObjectSymlinks(name, fs.getSymlinkTarget, fs.IsNotExist)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	r, w, err := pipeat.PipeInDir(fs.localTempDir)
 	if err != nil {
 		return nil, nil, nil, err
@@ -179,9 +281,14 @@ func (fs *S3Fs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, fun
 	go func() {
 		defer cancelFn()
 		n, err := downloader.DownloadWithContext(ctx, w, &s3.GetObjectInput{
-			Bucket: aws.String(fs.config.Bucket),
-			Key:    aws.String(name),
-			Range:  streamRange,
+			Bucket:               aws.String(fs.config.Bucket),
+			Key:                  aws.String(name),
+			Range:                streamRange,
+			SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+			SSECustomerKey:       fs.getSSECustomerKey(),
+		}, func(d *s3manager.Downloader) {
+			d.Concurrency = fs.config.DownloadConcurrency
+			d.PartSize = fs.config.DownloadPartSize
 		})
 		w.CloseWithError(err) //nolint:errcheck
 		fsLog(fs, logger.LevelDebug, "download completed, path: %#v size: %v, err: %v", name, n, err)
@@ -208,16 +315,29 @@ func (fs *S3Fs) Create(name string, flag int) (File, *PipeWriter, func(), error)
 		} else {
 			contentType = mime.TypeByExtension(path.Ext(name))
 		}
+		storageClass, tagging := fs.getUploadSettings(key)
+		// a new upload replaces any interrupted one
+		fs.abortResumableUpload(key)
 		response, err := uploader.UploadWithContext(ctx, &s3manager.UploadInput{
-			Bucket:       aws.String(fs.config.Bucket),
-			Key:          aws.String(key),
-			Body:         r,
-			StorageClass: utils.NilIfEmpty(fs.config.StorageClass),
-			ContentType:  utils.NilIfEmpty(contentType),
+			Bucket:               aws.String(fs.config.Bucket),
+			Key:                  aws.String(key),
+			Body:                 r,
+			StorageClass:         utils.NilIfEmpty(storageClass),
+			Tagging:              utils.NilIfEmpty(tagging),
+			ContentType:          utils.NilIfEmpty(contentType),
+			ServerSideEncryption: fs.getServerSideEncryption(),
+			SSEKMSKeyId:          utils.NilIfEmpty(fs.config.SSEKMSKeyID),
+			SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+			SSECustomerKey:       fs.getSSECustomerKey(),
 		}, func(u *s3manager.Uploader) {
-			u.Concurrency = fs.config.UploadConcurrency
+			u.Concurrency = fs.getUploadConcurrency()
 			u.PartSize = fs.config.UploadPartSize
+			// the uploaded parts are kept so the upload can be resumed
+			u.LeavePartsOnError = true
 		})
+		if err != nil {
+			fs.trackResumableUpload(key, err)
+		}
 		r.CloseWithError(err) //nolint:errcheck
 		p.Done(err)
 		fsLog(fs, logger.LevelDebug, "upload completed, path: %#v, response: %v, readed bytes: %v, err: %+v",
@@ -227,21 +347,34 @@ func (fs *S3Fs) Create(name string, flag int) (File, *PipeWriter, func(), error)
 	return nil, p, cancelFn, nil
 }
 
+// getUploadConcurrency returns the concurrency for the multipart uploads.
+// The SDK buffers up to concurrency + 1 parts for each upload, the configured
+// concurrency is reduced if these buffers exceed the upload buffer limit
+func (fs *S3Fs) getUploadConcurrency() int {
+	if fs.config.UploadMaxBufferSize <= 0 {
+		return fs.config.UploadConcurrency
+	}
+	maxConcurrency := int(fs.config.UploadMaxBufferSize*1024*1024/fs.config.UploadPartSize) - 1
+	if maxConcurrency < 1 {
+		maxConcurrency = 1
+	}
+	if fs.config.UploadConcurrency > maxConcurrency {
+		return maxConcurrency
+	}
+	return fs.config.UploadConcurrency
+}
+
 // Rename renames (moves) source to target.
 // We don't support renaming non empty directories since we should
 // rename all the contents too and this could take long time: think
 // about directories with thousands of files, for each file we should
 // execute a CopyObject call.
-// TODO: rename does not work for files bigger than 5GB, implement
-// multipart copy or wait for this pull request to be merged:
-//
-// https://github.com/aws/aws-sdk-go/pull/2653
-//
+// Big files are copied server side using a multipart copy.
 func (fs *S3Fs) Rename(source, target string) error {
 	if source == target {
 		return nil
 	}
-	fi, err := fs.Stat(source)
+	fi, err := fs.Lstat(source)
 	if err != nil {
 		return err
 	}
@@ -267,14 +400,31 @@ func (fs *S3Fs) Rename(source, target string) error {
 	} else {
 		contentType = mime.TypeByExtension(path.Ext(source))
 	}
+	if fi.Size() > s3MultipartCopyThreshold {
+		err = fs.doMultipartCopy(source, target, contentType, fi.Size(), nil)
+		metrics.S3CopyObjectCompleted(err)
+		if err != nil {
+			return err
+		}
+		return fs.Remove(source, fi.IsDir())
+	}
+	storageClass, tagging := fs.getUploadSettings(target)
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 	_, err = fs.svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
-		Bucket:       aws.String(fs.config.Bucket),
-		CopySource:   aws.String(url.PathEscape(copySource)),
-		Key:          aws.String(target),
-		StorageClass: utils.NilIfEmpty(fs.config.StorageClass),
-		ContentType:  utils.NilIfEmpty(contentType),
+		Bucket:                         aws.String(fs.config.Bucket),
+		CopySource:                     aws.String(url.PathEscape(copySource)),
+		Key:                            aws.String(target),
+		StorageClass:                   utils.NilIfEmpty(storageClass),
+		Tagging:                        aws.String(tagging),
+		TaggingDirective:               aws.String(s3.TaggingDirectiveReplace),
+		ContentType:                    utils.NilIfEmpty(contentType),
+		ServerSideEncryption:           fs.getServerSideEncryption(),
+		SSEKMSKeyId:                    utils.NilIfEmpty(fs.config.SSEKMSKeyID),
+		SSECustomerAlgorithm:           fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:                 fs.getSSECustomerKey(),
+		CopySourceSSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		CopySourceSSECustomerKey:       fs.getSSECustomerKey(),
 	})
 	metrics.S3CopyObjectCompleted(err)
 	if err != nil {
@@ -296,6 +446,8 @@ func (fs *S3Fs) Remove(name string, isDir bool) error {
 		if !strings.HasSuffix(name, "/") {
 			name += "/"
 		}
+	} else {
+		fs.abortResumableUpload(name)
 	}
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
@@ -329,13 +481,53 @@ func (*S3Fs) MkdirAll(name string, uid int, gid int) error {
 }
 
 // Symlink creates source as a symbolic link to target.
-func (*S3Fs) Symlink(source, target string) error {
-	return ErrVfsUnsupported
+// The symlink is emulated using a zero bytes object with the source stored as metadata
+func (fs *S3Fs) Symlink(source, target string) error {
+	_, err := fs.lstat(target)
+	if err == nil {
+		return fmt.Errorf("unable to create symlink, %#v already exists", target)
+	}
+	if !fs.IsNotExist(err) {
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	storageClass, tagging := fs.getUploadSettings(target)
+	_, err = fs.svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:               aws.String(fs.config.Bucket),
+		Key:                  aws.String(target),
+		Body:                 bytes.NewReader(nil),
+		ContentType:          aws.String(symlinkMimeType),
+		Metadata:             map[string]*string{symlinkTargetMetadataKey: aws.String(source)},
+		StorageClass:         utils.NilIfEmpty(storageClass),
+		Tagging:              utils.NilIfEmpty(tagging),
+		ServerSideEncryption: fs.getServerSideEncryption(),
+		SSEKMSKeyId:          utils.NilIfEmpty(fs.config.SSEKMSKeyID),
+		SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:       fs.getSSECustomerKey(),
+	})
+	metrics.S3TransferCompleted(0, 0, err)
+	return err
 }
 
 // Readlink returns the destination of the named symbolic link
-func (*S3Fs) Readlink(name string) (string, error) {
-	return "", ErrVfsUnsupported
+func (fs *S3Fs) Readlink(name string) (string, error) {
+	target, err := fs.getSymlinkTarget(name)
+	if err != nil {
+		return "", err
+	}
+	if target == "" {
+		return "", fmt.Errorf("%#v is not a symlink", name)
+	}
+	return fs.GetRelativePath(target), nil
+}
+
+func (fs *S3Fs) getSymlinkTarget(name string) (string, error) {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return "", err
+	}
+	return getS3SymlinkTarget(obj.ContentType, obj.Metadata), nil
 }
 
 // Chown changes the numeric uid and gid of the named file.
@@ -363,7 +555,37 @@ func (*S3Fs) Truncate(name string, size int64) error {
 // ReadDir reads the directory named by dirname and returns
 // a list of directory entries.
 func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
-	var result []os.FileInfo
+	lister, err := fs.OpenDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return readAllFromLister(lister)
+}
+
+// OpenDir opens the named directory, its entries are fetched one page
+// at a time using the returned lister
+func (fs *S3Fs) OpenDir(dirname string) (DirLister, error) {
+	lister := fs.newDirLister(dirname)
+	isEmpty, err := lister.isEmpty()
+	if err != nil {
+		lister.Close()
+		return nil, err
+	}
+	if !isEmpty {
+		return lister, nil
+	}
+	// an empty listing could be an emulated symlink to a directory.
+	// Emulated symlinks are listed as regular files since the listing
+	// does not include the content type
+	resolved, err := resolveObjectSymlinks(dirname, fs.getSymlinkTarget, fs.IsNotExist)
+	if err != nil || resolved == dirname {
+		return lister, nil
+	}
+	lister.Close()
+	return fs.newDirLister(resolved), nil
+}
+
+func (fs *S3Fs) newDirLister(dirname string) *pagedDirLister {
 	// dirname must be already cleaned
 	prefix := ""
 	if dirname != "/" && dirname != "." {
@@ -374,14 +596,23 @@ func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	}
 
 	prefixes := make(map[string]bool)
+	var continuationToken *string
 
-	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
-	defer cancelFn()
-	err := fs.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
-		Bucket:    aws.String(fs.config.Bucket),
-		Prefix:    aws.String(prefix),
-		Delimiter: aws.String("/"),
-	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+	return newPagedDirLister(func() ([]os.FileInfo, bool, error) {
+		ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+		defer cancelFn()
+
+		page, err := fs.svc.ListObjectsV2WithContext(ctx, &s3.ListObjectsV2Input{
+			Bucket:            aws.String(fs.config.Bucket),
+			Prefix:            aws.String(prefix),
+			Delimiter:         aws.String("/"),
+			ContinuationToken: continuationToken,
+		})
+		metrics.S3ListObjectsCompleted(err)
+		if err != nil {
+			return nil, false, err
+		}
+		var result []os.FileInfo
 		for _, p := range page.CommonPrefixes {
 			// prefixes have a trailing slash
 			name, _ := fs.resolve(p.Prefix, prefix)
@@ -409,23 +640,23 @@ func (fs *S3Fs) ReadDir(dirname string) ([]os.FileInfo, error) {
 			}
 			result = append(result, NewFileInfo(name, (isDir && objectSize == 0), objectSize, objectModTime, false))
 		}
-		return true
-	})
-	metrics.S3ListObjectsCompleted(err)
-	return result, err
+		continuationToken = page.NextContinuationToken
+		hasMore := aws.BoolValue(page.IsTruncated) && continuationToken != nil
+		return result, hasMore, nil
+	}, nil)
 }
 
-// IsUploadResumeSupported returns true if resuming uploads is supported.
-// Resuming uploads is not supported on S3
-func (*S3Fs) IsUploadResumeSupported() bool {
-	return false
-}
-
-// IsAtomicUploadSupported returns true if atomic upload is supported.
-// S3 uploads are already atomic, we don't need to upload to a temporary
-// file
-func (*S3Fs) IsAtomicUploadSupported() bool {
-	return false
+// Capabilities returns the features supported by this filesystem.
+// S3 uploads are emulated as atomic: the file is uploaded to a temporary
+// object and then renamed using a server side copy. Writes at arbitrary
+// offsets are not supported, interrupted uploads can be continued using
+// ResumeUpload
+func (*S3Fs) Capabilities() FsCapabilities {
+	return FsCapabilities{
+		AtomicUpload: true,
+		Symlinks:     true,
+		RangeReads:   true,
+	}
 }
 
 // IsNotExist returns a boolean indicating whether the error is known to
@@ -482,8 +713,10 @@ func (fs *S3Fs) CheckRootPath(username string, uid int, gid int) bool {
 func (fs *S3Fs) ScanRootDirContents() (int, int64, error) {
 	numFiles := 0
 	size := int64(0)
+	throttle := getQuotaScanThrottle()
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
 	defer cancelFn()
+	throttle.waitRequest()
 	err := fs.svc.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
 		Bucket: aws.String(fs.config.Bucket),
 		Prefix: aws.String(fs.config.KeyPrefix),
@@ -493,9 +726,13 @@ func (fs *S3Fs) ScanRootDirContents() (int, int64, error) {
 			if isDir && *fileObject.Size == 0 {
 				continue
 			}
+			throttle.waitFiles(1)
 			numFiles++
 			size += *fileObject.Size
 		}
+		if !lastPage {
+			throttle.waitRequest()
+		}
 		return true
 	})
 	metrics.S3ListObjectsCompleted(err)
@@ -509,9 +746,11 @@ func (*S3Fs) GetDirSize(dirname string) (int, int64, error) {
 }
 
 // GetAtomicUploadPath returns the path to use for an atomic upload.
-// S3 uploads are already atomic, we never call this method for S3
+// The temporary object is created inside the same "directory" of the target one
 func (*S3Fs) GetAtomicUploadPath(name string) string {
-	return ""
+	dir := path.Dir(name)
+	guid := xid.New().String()
+	return path.Join(dir, ".sftpgo-upload."+guid+"."+path.Base(name))
 }
 
 // GetRelativePath returns the path for a file relative to the user's home dir.
@@ -650,17 +889,234 @@ func (fs *S3Fs) hasContents(name string) (bool, error) {
 	return false, nil
 }
 
+// ListVersions returns the versions of the specified object, newest first.
+// Bucket versioning must be enabled
+func (fs *S3Fs) ListVersions(name string) ([]FileVersion, error) {
+	var versions []FileVersion
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	err := fs.svc.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(fs.config.Bucket),
+		Prefix: aws.String(name),
+	}, func(page *s3.ListObjectVersionsOutput, lastPage bool) bool {
+		for _, v := range page.Versions {
+			if aws.StringValue(v.Key) != name {
+				continue
+			}
+			versions = append(versions, FileVersion{
+				ID:           aws.StringValue(v.VersionId),
+				Size:         aws.Int64Value(v.Size),
+				LastModified: utils.GetTimeAsMsSinceEpoch(aws.TimeValue(v.LastModified)),
+				IsLatest:     aws.BoolValue(v.IsLatest),
+			})
+		}
+		return true
+	})
+	metrics.S3ListObjectsCompleted(err)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].LastModified > versions[j].LastModified
+	})
+	return versions, nil
+}
+
+// RestoreVersion copies the specified version over the current one,
+// the current version is preserved by the bucket versioning
+func (fs *S3Fs) RestoreVersion(name, versionID string) error {
+	copySource := fmt.Sprintf("%v?versionId=%v", url.PathEscape(fs.Join(fs.config.Bucket, name)),
+		url.QueryEscape(versionID))
+	storageClass, tagging := fs.getUploadSettings(name)
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	_, err := fs.svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:                         aws.String(fs.config.Bucket),
+		CopySource:                     aws.String(copySource),
+		Key:                            aws.String(name),
+		StorageClass:                   utils.NilIfEmpty(storageClass),
+		Tagging:                        aws.String(tagging),
+		TaggingDirective:               aws.String(s3.TaggingDirectiveReplace),
+		ServerSideEncryption:           fs.getServerSideEncryption(),
+		SSEKMSKeyId:                    utils.NilIfEmpty(fs.config.SSEKMSKeyID),
+		SSECustomerAlgorithm:           fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:                 fs.getSSECustomerKey(),
+		CopySourceSSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		CopySourceSSECustomerKey:       fs.getSSECustomerKey(),
+	})
+	metrics.S3CopyObjectCompleted(err)
+	return err
+}
+
+// GetXattrs returns the extended attributes stored as object metadata
+func (fs *S3Fs) GetXattrs(name string) (map[string][]byte, error) {
+	_, obj, err := fs.headObjectForXattrs(name)
+	if err != nil {
+		return nil, err
+	}
+	return getXattrsFromMetadata(aws.StringValueMap(obj.Metadata)), nil
+}
+
+// SetXattr stores the specified extended attribute as object metadata
+func (fs *S3Fs) SetXattr(name, attr string, value []byte) error {
+	if err := ValidateXattr(attr, value); err != nil {
+		return err
+	}
+	if value == nil {
+		value = []byte{}
+	}
+	return fs.updateXattrs(name, attr, value)
+}
+
+// RemoveXattr removes the specified extended attribute from the object metadata
+func (fs *S3Fs) RemoveXattr(name, attr string) error {
+	if err := ValidateXattr(attr, nil); err != nil {
+		return err
+	}
+	return fs.updateXattrs(name, attr, nil)
+}
+
+// SetChecksum stores the SHA-256 checksum for the specified file as object metadata
+func (fs *S3Fs) SetChecksum(name, checksum string) error {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return err
+	}
+	return fs.updateObjectXattr(name, obj, checksumXattrName,
+		getChecksumXattrValue(checksum, aws.Int64Value(obj.ContentLength)))
+}
+
+// GetChecksum returns the SHA-256 checksum stored for the specified file.
+// The checksum is ignored if the object size changed
+func (fs *S3Fs) GetChecksum(name string) (string, error) {
+	obj, err := fs.headObject(name)
+	if err != nil {
+		return "", err
+	}
+	return getChecksumFromMetadata(aws.StringValueMap(obj.Metadata), aws.Int64Value(obj.ContentLength)), nil
+}
+
+func (fs *S3Fs) updateXattrs(name, attr string, value []byte) error {
+	key, obj, err := fs.headObjectForXattrs(name)
+	if err != nil {
+		return err
+	}
+	return fs.updateObjectXattr(key, obj, attr, value)
+}
+
+// updateObjectXattr replaces the object metadata copying the object over
+// itself, big objects are copied using a multipart copy
+func (fs *S3Fs) updateObjectXattr(key string, obj *s3.HeadObjectOutput, attr string, value []byte) error {
+	metadata, err := updateXattrMetadata(aws.StringValueMap(obj.Metadata), attr, value)
+	if err != nil {
+		return err
+	}
+	if size := aws.Int64Value(obj.ContentLength); size > s3MultipartCopyThreshold {
+		err = fs.doMultipartCopy(key, key, aws.StringValue(obj.ContentType), size, aws.StringMap(metadata))
+		metrics.S3CopyObjectCompleted(err)
+		return err
+	}
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+	_, err = fs.svc.CopyObjectWithContext(ctx, &s3.CopyObjectInput{
+		Bucket:                         aws.String(fs.config.Bucket),
+		CopySource:                     aws.String(url.PathEscape(fs.Join(fs.config.Bucket, key))),
+		Key:                            aws.String(key),
+		StorageClass:                   obj.StorageClass,
+		ContentType:                    obj.ContentType,
+		Metadata:                       aws.StringMap(metadata),
+		MetadataDirective:              aws.String(s3.MetadataDirectiveReplace),
+		ServerSideEncryption:           fs.getServerSideEncryption(),
+		SSEKMSKeyId:                    utils.NilIfEmpty(fs.config.SSEKMSKeyID),
+		SSECustomerAlgorithm:           fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:                 fs.getSSECustomerKey(),
+		CopySourceSSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		CopySourceSSECustomerKey:       fs.getSSECustomerKey(),
+	})
+	metrics.S3CopyObjectCompleted(err)
+	return err
+}
+
+// headObjectForXattrs returns the key and the attributes for the object with
+// the given name, directories are stored as objects with a trailing slash
+func (fs *S3Fs) headObjectForXattrs(name string) (string, *s3.HeadObjectOutput, error) {
+	obj, err := fs.headObject(name)
+	if err == nil || !fs.IsNotExist(err) {
+		return name, obj, err
+	}
+	dirName := strings.TrimSuffix(name, "/") + "/"
+	obj, err = fs.headObject(dirName)
+	return dirName, obj, err
+}
+
 func (fs *S3Fs) headObject(name string) (*s3.HeadObjectOutput, error) {
 	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
 	defer cancelFn()
 	obj, err := fs.svc.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(fs.config.Bucket),
-		Key:    aws.String(name),
+		Bucket:               aws.String(fs.config.Bucket),
+		Key:                  aws.String(name),
+		SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+		SSECustomerKey:       fs.getSSECustomerKey(),
 	})
 	metrics.S3HeadObjectCompleted(err)
 	return obj, err
 }
 
+// getS3SymlinkTarget returns the target for an emulated symlink or an empty string
+// if the object is not a symlink. Metadata keys are returned canonicalized
+func getS3SymlinkTarget(contentType *string, metadata map[string]*string) string {
+	if contentType == nil || *contentType != symlinkMimeType {
+		return ""
+	}
+	for k, v := range metadata {
+		if strings.EqualFold(k, symlinkTargetMetadataKey) && v != nil {
+			return *v
+		}
+	}
+	return ""
+}
+
+// getServerSideEncryption returns the SSE-S3 or SSE-KMS algorithm, if configured.
+// SSE-C is requested using the customer key
+func (fs *S3Fs) getServerSideEncryption() *string {
+	switch fs.config.SSE {
+	case s3SSEAES256, s3SSEKMS:
+		return aws.String(fs.config.SSE)
+	default:
+		return nil
+	}
+}
+
+func (fs *S3Fs) getSSECustomerAlgorithm() *string {
+	if fs.sseCustomerKey == "" {
+		return nil
+	}
+	return aws.String(s3SSEAES256)
+}
+
+// getSSECustomerKey returns the raw SSE-C key, the SDK takes care of the
+// base64 encoding and of the MD5 digest
+func (fs *S3Fs) getSSECustomerKey() *string {
+	if fs.sseCustomerKey == "" {
+		return nil
+	}
+	return aws.String(fs.sseCustomerKey)
+}
+
+// getUploadSettings returns the storage class and the URL encoded tags
+// to use for the specified object key
+func (fs *S3Fs) getUploadSettings(key string) (string, string) {
+	name := path.Clean("/" + strings.TrimPrefix(key, fs.config.KeyPrefix))
+	storageClass, tags := fs.config.getUploadSettings(name)
+	tagging := url.Values{}
+	for k, v := range tags {
+		tagging.Set(k, v)
+	}
+	return storageClass, tagging.Encode()
+}
+
 // GetMimeType returns the content type
 func (fs *S3Fs) GetMimeType(name string) (string, error) {
 	obj, err := fs.headObject(name)
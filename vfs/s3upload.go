@@ -0,0 +1,287 @@
+// +build !nos3
+
+package vfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/eikenb/pipeat"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/metrics"
+)
+
+// interrupted multipart uploads older than this are aborted instead of resumed
+const s3ResumableUploadMaxAge = 24 * time.Hour
+
+var s3ResumableUploads = &resumableUploads{
+	uploads: make(map[string]resumableUpload),
+}
+
+// resumableUpload defines an interrupted multipart upload or copy. The parts
+// uploaded by an interrupted upload are not tracked here, they are listed
+// from S3 when the upload is resumed. For an interrupted copy we also keep
+// the source object and its ETag, the copy can be resumed only if the source
+// is unchanged, and the parts already copied
+type resumableUpload struct {
+	uploadID   string
+	partSize   int64
+	createdAt  time.Time
+	source     string
+	sourceETag string
+	parts      []*s3.CompletedPart
+}
+
+func (u *resumableUpload) isExpired() bool {
+	return time.Since(u.createdAt) > s3ResumableUploadMaxAge
+}
+
+// resumableUploads tracks the interrupted multipart uploads for all the S3
+// filesystems, so a client can resume an upload from a new connection.
+// The uploads are kept in memory and they are lost if SFTPGo is restarted
+type resumableUploads struct {
+	sync.RWMutex
+	uploads map[string]resumableUpload
+}
+
+func (r *resumableUploads) add(key string, upload resumableUpload) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.uploads[key] = upload
+}
+
+func (r *resumableUploads) get(key string) (resumableUpload, bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	upload, ok := r.uploads[key]
+	return upload, ok
+}
+
+// remove removes the upload for the given key, if any, and returns it
+func (r *resumableUploads) remove(key string) (resumableUpload, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	upload, ok := r.uploads[key]
+	if ok {
+		delete(r.uploads, key)
+	}
+	return upload, ok
+}
+
+func (fs *S3Fs) getResumableUploadKey(name string) string {
+	return fmt.Sprintf("%v|%v|%v", fs.config.Endpoint, fs.config.Bucket, name)
+}
+
+// trackResumableUpload records the multipart upload left by a failed upload,
+// if any, so it can be resumed later
+func (fs *S3Fs) trackResumableUpload(name string, err error) {
+	var failure s3manager.MultiUploadFailure
+	if !errors.As(err, &failure) || failure.UploadID() == "" {
+		return
+	}
+	s3ResumableUploads.add(fs.getResumableUploadKey(name), resumableUpload{
+		uploadID:  failure.UploadID(),
+		partSize:  fs.config.UploadPartSize,
+		createdAt: time.Now(),
+	})
+	fsLog(fs, logger.LevelDebug, "interrupted upload for %#v can be resumed, upload id: %v", name, failure.UploadID())
+}
+
+// abortResumableUpload aborts the interrupted multipart upload for the
+// specified file, if any
+func (fs *S3Fs) abortResumableUpload(name string) {
+	upload, ok := s3ResumableUploads.remove(fs.getResumableUploadKey(name))
+	if !ok {
+		return
+	}
+	err := fs.abortMultipartUpload(name, upload.uploadID)
+	fsLog(fs, logger.LevelDebug, "interrupted upload for %#v aborted, upload id: %v, err: %v", name, upload.uploadID, err)
+}
+
+func (fs *S3Fs) abortMultipartUpload(name, uploadID string) error {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err := fs.svc.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(fs.config.Bucket),
+		Key:      aws.String(name),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+// getResumableUpload returns the interrupted upload for the specified file and
+// its reusable parts: the parts are contiguous, starting from the first one,
+// and all of them have the size used for the upload
+func (fs *S3Fs) getResumableUpload(name string) (resumableUpload, []*s3.CompletedPart, int64, error) {
+	upload, ok := s3ResumableUploads.get(fs.getResumableUploadKey(name))
+	if !ok {
+		return upload, nil, 0, fmt.Errorf("no interrupted upload for %#v", name)
+	}
+	if upload.isExpired() {
+		fs.abortResumableUpload(name)
+		return upload, nil, 0, fmt.Errorf("the interrupted upload for %#v is expired", name)
+	}
+	parts, err := fs.listUploadedParts(name, upload.uploadID)
+	if err != nil {
+		return upload, nil, 0, err
+	}
+	var completedParts []*s3.CompletedPart
+	var size int64
+	for idx, part := range parts {
+		if *part.PartNumber != int64(idx+1) || *part.Size != upload.partSize {
+			break
+		}
+		completedParts = append(completedParts, &s3.CompletedPart{
+			ETag:       part.ETag,
+			PartNumber: part.PartNumber,
+		})
+		size += *part.Size
+	}
+	return upload, completedParts, size, nil
+}
+
+func (fs *S3Fs) listUploadedParts(name, uploadID string) ([]*s3.Part, error) {
+	ctx, cancelFn := context.WithDeadline(context.Background(), time.Now().Add(fs.ctxLongTimeout))
+	defer cancelFn()
+
+	var parts []*s3.Part
+	err := fs.svc.ListPartsPagesWithContext(ctx, &s3.ListPartsInput{
+		Bucket:   aws.String(fs.config.Bucket),
+		Key:      aws.String(name),
+		UploadId: aws.String(uploadID),
+	}, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		parts = append(parts, page.Parts...)
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the uploaded parts for %#v: %w", name, err)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return *parts[i].PartNumber < *parts[j].PartNumber
+	})
+	return parts, nil
+}
+
+// GetResumableUploadSize returns the size already uploaded for an interrupted
+// upload to the specified file
+func (fs *S3Fs) GetResumableUploadSize(name string) (int64, bool) {
+	if _, ok := s3ResumableUploads.get(fs.getResumableUploadKey(name)); !ok {
+		return 0, false
+	}
+	_, _, size, err := fs.getResumableUpload(name)
+	if err != nil {
+		fsLog(fs, logger.LevelDebug, "unable to get the resumable upload size for %#v: %v", name, err)
+		return 0, false
+	}
+	return size, true
+}
+
+// ResumeUpload continues the interrupted multipart upload to the specified
+// file. The data written starting at offset are uploaded as new parts and
+// the multipart upload is completed when the writer is closed
+func (fs *S3Fs) ResumeUpload(name string, offset int64) (*PipeWriter, func(), error) {
+	upload, parts, size, err := fs.getResumableUpload(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if size != offset {
+		return nil, nil, fmt.Errorf("invalid resume offset %v for %#v, uploaded size: %v", offset, name, size)
+	}
+	r, w, err := pipeat.PipeInDir(fs.localTempDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := NewPipeWriterAtOffset(w, offset)
+	ctx, cancelFn := context.WithCancel(context.Background())
+	fsLog(fs, logger.LevelDebug, "resuming upload for %#v, upload id: %v, offset: %v, reused parts: %v",
+		name, upload.uploadID, offset, len(parts))
+
+	go func() {
+		defer cancelFn()
+
+		err := fs.uploadRemainingParts(ctx, name, upload, parts, r)
+		r.CloseWithError(err) //nolint:errcheck
+		p.Done(err)
+		fsLog(fs, logger.LevelDebug, "resumed upload completed, path: %#v, readed bytes: %v, err: %+v",
+			name, r.GetReadedBytes(), err)
+		metrics.S3TransferCompleted(r.GetReadedBytes(), 0, err)
+	}()
+	return p, cancelFn, nil
+}
+
+// uploadRemainingParts uploads the data read from r as new parts and completes
+// the multipart upload. If an error is returned the upload is left in place,
+// so it can be resumed again
+func (fs *S3Fs) uploadRemainingParts(ctx context.Context, name string, upload resumableUpload,
+	parts []*s3.CompletedPart, r io.Reader,
+) error {
+	buf := make([]byte, upload.partSize)
+	partNumber := int64(len(parts)) + 1
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// the last part is empty if there are no other parts, an upload must have at least one part
+		if n > 0 || len(parts) == 0 {
+			innerCtx, cancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxLongTimeout))
+			res, errUpload := fs.svc.UploadPartWithContext(innerCtx, &s3.UploadPartInput{
+				Bucket:               aws.String(fs.config.Bucket),
+				Key:                  aws.String(name),
+				UploadId:             aws.String(upload.uploadID),
+				PartNumber:           aws.Int64(partNumber),
+				Body:                 bytes.NewReader(buf[:n]),
+				SSECustomerAlgorithm: fs.getSSECustomerAlgorithm(),
+				SSECustomerKey:       fs.getSSECustomerKey(),
+			})
+			cancelFn()
+			if errUpload != nil {
+				return fmt.Errorf("unable to upload part %v: %w", partNumber, errUpload)
+			}
+			parts = append(parts, &s3.CompletedPart{
+				ETag:       res.ETag,
+				PartNumber: aws.Int64(partNumber),
+			})
+			partNumber++
+		}
+		if err != nil {
+			break
+		}
+	}
+	// the writer is closed without errors if the transfer is aborted while
+	// we are waiting for data, the upload must not be completed in this case
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	completeCtx, cancelFn := context.WithDeadline(ctx, time.Now().Add(fs.ctxTimeout))
+	defer cancelFn()
+
+	_, err := fs.svc.CompleteMultipartUploadWithContext(completeCtx, &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(fs.config.Bucket),
+		Key:      aws.String(name),
+		UploadId: aws.String(upload.uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to complete the resumed upload: %w", err)
+	}
+	s3ResumableUploads.remove(fs.getResumableUploadKey(name))
+	return nil
+}
@@ -0,0 +1,110 @@
+// +build !nos3
+
+package vfs
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResumableUploadsTracking(t *testing.T) {
+	fs := &S3Fs{
+		config: &S3FsConfig{
+			Bucket: "bucket",
+		},
+	}
+	key := fs.getResumableUploadKey("dir/file")
+	assert.NotEqual(t, key, fs.getResumableUploadKey("dir/file1"))
+
+	uploads := &resumableUploads{
+		uploads: make(map[string]resumableUpload),
+	}
+	_, ok := uploads.get(key)
+	assert.False(t, ok)
+	uploads.add(key, resumableUpload{
+		uploadID:  "id",
+		partSize:  5 * 1024 * 1024,
+		createdAt: time.Now(),
+	})
+	upload, ok := uploads.get(key)
+	require.True(t, ok)
+	assert.Equal(t, "id", upload.uploadID)
+	assert.False(t, upload.isExpired())
+	upload.createdAt = time.Now().Add(-s3ResumableUploadMaxAge - time.Minute)
+	assert.True(t, upload.isExpired())
+
+	upload, ok = uploads.remove(key)
+	assert.True(t, ok)
+	assert.Equal(t, "id", upload.uploadID)
+	_, ok = uploads.remove(key)
+	assert.False(t, ok)
+	assert.Len(t, uploads.uploads, 0)
+	// no upload to resume
+	_, ok = fs.GetResumableUploadSize("dir/file")
+	assert.False(t, ok)
+	_, _, err := fs.ResumeUpload("dir/file", 0)
+	assert.Error(t, err)
+	assert.False(t, IsResumableUpload(fs, "dir/file", 0))
+	assert.False(t, IsResumableUpload(NewOsFs("", t.TempDir(), ""), "file", 0))
+}
+
+func TestPipeWriterAtOffset(t *testing.T) {
+	r, w, err := pipeat.Pipe()
+	require.NoError(t, err)
+	p := NewPipeWriterAtOffset(w, 100)
+	_, err = p.WriteAt([]byte("a"), 99)
+	assert.Error(t, err)
+	n, err := p.WriteAt([]byte("data"), 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	n, err = p.WriteAt([]byte("more"), 104)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n)
+	go func() {
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("datamore"), data)
+		p.Done(err)
+	}()
+	assert.NoError(t, p.Close())
+}
+
+func TestS3UploadMaxBufferSize(t *testing.T) {
+	config := S3FsConfig{
+		Bucket:              "bucket",
+		Region:              "us-east-1",
+		UploadPartSize:      10,
+		UploadConcurrency:   8,
+		UploadMaxBufferSize: 15,
+	}
+	err := config.Validate()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "upload_max_buffer_size")
+	}
+	config.UploadPartSize = 0
+	config.UploadMaxBufferSize = 9
+	assert.Error(t, config.Validate())
+	config.UploadMaxBufferSize = 10
+	assert.NoError(t, config.Validate())
+
+	fs := &S3Fs{
+		config: &S3FsConfig{
+			UploadPartSize:      10 * 1024 * 1024,
+			UploadConcurrency:   8,
+			UploadMaxBufferSize: 0,
+		},
+	}
+	assert.Equal(t, 8, fs.getUploadConcurrency())
+	// 5 parts fit in 50 MB, one of them is the part being read
+	fs.config.UploadMaxBufferSize = 50
+	assert.Equal(t, 4, fs.getUploadConcurrency())
+	fs.config.UploadMaxBufferSize = 20
+	assert.Equal(t, 1, fs.getUploadConcurrency())
+	fs.config.UploadMaxBufferSize = 1000
+	assert.Equal(t, 8, fs.getUploadConcurrency())
+}
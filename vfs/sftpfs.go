@@ -426,14 +426,28 @@ func (fs *SFTPFs) ReadDir(dirname string) ([]os.FileInfo, error) {
 	return result, nil
 }
 
-// IsUploadResumeSupported returns true if resuming uploads is supported.
-func (fs *SFTPFs) IsUploadResumeSupported() bool {
-	return fs.config.BufferSize == 0
+// OpenDir opens the named directory, its entries can be read in batches
+// using the returned lister. The directory is read at once, the SFTP client
+// does not allow to read it in batches
+func (fs *SFTPFs) OpenDir(dirname string) (DirLister, error) {
+	entries, err := fs.ReadDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return newSliceDirLister(entries), nil
 }
 
-// IsAtomicUploadSupported returns true if atomic upload is supported.
-func (fs *SFTPFs) IsAtomicUploadSupported() bool {
-	return fs.config.BufferSize == 0
+// Capabilities returns the features supported by this filesystem.
+// Resuming uploads and atomic uploads are not supported if a buffer is configured
+func (fs *SFTPFs) Capabilities() FsCapabilities {
+	return FsCapabilities{
+		UploadResume: fs.config.BufferSize == 0,
+		AtomicUpload: fs.config.BufferSize == 0,
+		SetStat:      true,
+		Symlinks:     true,
+		Truncate:     true,
+		RangeReads:   true,
+	}
 }
 
 // IsNotExist returns a boolean indicating whether the error is known to
@@ -476,7 +490,7 @@ func (fs *SFTPFs) CheckRootPath(username string, uid int, gid int) bool {
 // ScanRootDirContents returns the number of files contained in a directory and
 // their size
 func (fs *SFTPFs) ScanRootDirContents() (int, int64, error) {
-	return fs.GetDirSize(fs.config.Prefix)
+	return fs.getDirSize(fs.config.Prefix, getQuotaScanThrottle())
 }
 
 // GetAtomicUploadPath returns the path to use for an atomic upload
@@ -614,6 +628,10 @@ func (fs *SFTPFs) isSubDir(name string) error {
 // GetDirSize returns the number of files and the size for a folder
 // including any subfolders
 func (fs *SFTPFs) GetDirSize(dirname string) (int, int64, error) {
+	return fs.getDirSize(dirname, nil)
+}
+
+func (fs *SFTPFs) getDirSize(dirname string, throttle *quotaScanThrottle) (int, int64, error) {
 	numFiles := 0
 	size := int64(0)
 	if err := fs.checkConnection(); err != nil {
@@ -627,7 +645,11 @@ func (fs *SFTPFs) GetDirSize(dirname string) (int, int64, error) {
 			if err != nil {
 				return numFiles, size, err
 			}
-			if walker.Stat().Mode().IsRegular() {
+			if walker.Stat().IsDir() {
+				// the directory contents will be read in the next step
+				throttle.waitRequest()
+			} else if walker.Stat().Mode().IsRegular() {
+				throttle.waitFiles(1)
 				size += walker.Stat().Size()
 				numFiles++
 			}
@@ -0,0 +1,589 @@
+// +build smb
+
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eikenb/pipeat"
+	"github.com/hirochachacha/go-smb2"
+	"github.com/pkg/sftp"
+	"github.com/rs/xid"
+
+	"github.com/drakkan/sftpgo/logger"
+	"github.com/drakkan/sftpgo/version"
+)
+
+const smbDialTimeout = 30 * time.Second
+
+func init() {
+	version.AddFeature("+smb")
+}
+
+// SMBFs is a Fs implementation for SMB shares, such as Azure Files shares
+// or shares exported by Windows and Samba file servers
+type SMBFs struct {
+	sync.Mutex
+	connectionID string
+	// if not empty this fs is mouted as virtual folder in the specified path
+	mountPath    string
+	localTempDir string
+	config       *SMBFsConfig
+	conn         net.Conn
+	session      *smb2.Session
+	share        *smb2.Share
+}
+
+// NewSMBFs returns an SMBFs object that allows to interact with an SMB share
+func NewSMBFs(connectionID, mountPath, localTempDir string, config SMBFsConfig) (Fs, error) {
+	if localTempDir == "" {
+		localTempDir = filepath.Clean(os.TempDir())
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	if err := config.Password.TryDecrypt(); err != nil {
+		return nil, err
+	}
+	smbFs := &SMBFs{
+		connectionID: connectionID,
+		mountPath:    mountPath,
+		localTempDir: localTempDir,
+		config:       &config,
+	}
+	err := smbFs.createConnection()
+	return smbFs, err
+}
+
+// Name returns the name for the Fs implementation
+func (fs *SMBFs) Name() string {
+	return fmt.Sprintf("%v %#v", smbFsName, fs.config.Endpoint+"/"+fs.config.Share)
+}
+
+// ConnectionID returns the connection ID associated to this Fs implementation
+func (fs *SMBFs) ConnectionID() string {
+	return fs.connectionID
+}
+
+// Stat returns a FileInfo describing the named file
+func (fs *SMBFs) Stat(name string) (os.FileInfo, error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return nil, err
+	}
+	info, err := share.Stat(getSMBPath(name))
+	if err != nil {
+		return nil, fs.checkError(err)
+	}
+	return fs.getFileInfo(info), nil
+}
+
+// Lstat returns a FileInfo describing the named file
+func (fs *SMBFs) Lstat(name string) (os.FileInfo, error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return nil, err
+	}
+	info, err := share.Lstat(getSMBPath(name))
+	if err != nil {
+		return nil, fs.checkError(err)
+	}
+	return fs.getFileInfo(info), nil
+}
+
+// Open opens the named file for reading
+func (fs *SMBFs) Open(name string, offset int64) (File, *pipeat.PipeReaderAt, func(), error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	f, err := share.Open(getSMBPath(name))
+	if err != nil {
+		return nil, nil, nil, fs.checkError(err)
+	}
+	return f, nil, nil, nil
+}
+
+// Create creates or opens the named file for writing
+func (fs *SMBFs) Create(name string, flag int) (File, *PipeWriter, func(), error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var f *smb2.File
+	if flag == 0 {
+		f, err = share.Create(getSMBPath(name))
+	} else {
+		f, err = share.OpenFile(getSMBPath(name), flag, 0666)
+	}
+	if err != nil {
+		return nil, nil, nil, fs.checkError(err)
+	}
+	return f, nil, nil, nil
+}
+
+// Rename renames (moves) source to target.
+// SMB does not replace an existing target so it is removed before renaming
+func (fs *SMBFs) Rename(source, target string) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	err = share.Rename(getSMBPath(source), getSMBPath(target))
+	if err != nil && os.IsExist(err) {
+		info, errStat := share.Stat(getSMBPath(target))
+		if errStat == nil && info.Mode().IsRegular() {
+			if err = share.Remove(getSMBPath(target)); err == nil {
+				err = share.Rename(getSMBPath(source), getSMBPath(target))
+			}
+		}
+	}
+	return fs.checkError(err)
+}
+
+// Remove removes the named file or (empty) directory.
+func (fs *SMBFs) Remove(name string, isDir bool) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.checkError(share.Remove(getSMBPath(name)))
+}
+
+// Mkdir creates a new directory with the specified name and default permissions
+func (fs *SMBFs) Mkdir(name string) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.checkError(share.Mkdir(getSMBPath(name), os.ModePerm))
+}
+
+// MkdirAll creates a directory named path, along with any necessary parents,
+// and returns nil, or else returns an error.
+// If path is already a directory, MkdirAll does nothing and returns nil.
+func (fs *SMBFs) MkdirAll(name string, uid int, gid int) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.checkError(share.MkdirAll(getSMBPath(name), os.ModePerm))
+}
+
+// Symlink creates source as a symbolic link to target.
+func (*SMBFs) Symlink(source, target string) error {
+	return ErrVfsUnsupported
+}
+
+// Readlink returns the destination of the named symbolic link
+func (*SMBFs) Readlink(name string) (string, error) {
+	return "", ErrVfsUnsupported
+}
+
+// Chown changes the numeric uid and gid of the named file.
+func (*SMBFs) Chown(name string, uid int, gid int) error {
+	return ErrVfsUnsupported
+}
+
+// Chmod changes the mode of the named file to mode.
+// SMB only supports the read only attribute, it is set if the mode
+// does not allow writing for the owner
+func (fs *SMBFs) Chmod(name string, mode os.FileMode) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.checkError(share.Chmod(getSMBPath(name), mode))
+}
+
+// Chtimes changes the access and modification times of the named file.
+func (fs *SMBFs) Chtimes(name string, atime, mtime time.Time) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.checkError(share.Chtimes(getSMBPath(name), atime, mtime))
+}
+
+// Truncate changes the size of the named file.
+func (fs *SMBFs) Truncate(name string, size int64) error {
+	share, err := fs.getShare()
+	if err != nil {
+		return err
+	}
+	return fs.checkError(share.Truncate(getSMBPath(name), size))
+}
+
+// ReadDir reads the directory named by dirname and returns
+// a list of directory entries.
+func (fs *SMBFs) ReadDir(dirname string) ([]os.FileInfo, error) {
+	lister, err := fs.OpenDir(dirname)
+	if err != nil {
+		return nil, err
+	}
+	return readAllFromLister(lister)
+}
+
+// OpenDir opens the named directory, its entries can be read in batches
+// using the returned lister
+func (fs *SMBFs) OpenDir(dirname string) (DirLister, error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return nil, err
+	}
+	f, err := share.Open(getSMBPath(dirname))
+	if err != nil {
+		return nil, fs.checkError(err)
+	}
+	return newPagedDirLister(func() ([]os.FileInfo, bool, error) {
+		entries, err := f.Readdir(ListerBatchSize)
+		if err == io.EOF {
+			return fs.getFileInfos(entries), false, nil
+		}
+		if err != nil {
+			return nil, false, fs.checkError(err)
+		}
+		return fs.getFileInfos(entries), true, nil
+	}, func() {
+		f.Close()
+	}), nil
+}
+
+// Capabilities returns the features supported by this filesystem.
+// Symbolic links are not supported and chmod can only set the read only attribute
+func (*SMBFs) Capabilities() FsCapabilities {
+	return FsCapabilities{
+		UploadResume: true,
+		AtomicUpload: true,
+		SetStat:      true,
+		Symlinks:     false,
+		Truncate:     true,
+		RangeReads:   true,
+	}
+}
+
+// IsNotExist returns a boolean indicating whether the error is known to
+// report that a file or directory does not exist
+func (*SMBFs) IsNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+// IsPermission returns a boolean indicating whether the error is known to
+// report that permission is denied.
+func (*SMBFs) IsPermission(err error) bool {
+	return os.IsPermission(err)
+}
+
+// IsNotSupported returns true if the error indicate an unsupported operation
+func (*SMBFs) IsNotSupported(err error) bool {
+	if err == nil {
+		return false
+	}
+	return err == ErrVfsUnsupported
+}
+
+// CheckRootPath creates the prefix directory, inside the share, if it does not exists
+func (fs *SMBFs) CheckRootPath(username string, uid int, gid int) bool {
+	if fs.config.Prefix == "/" {
+		return true
+	}
+	if err := fs.MkdirAll(fs.config.Prefix, uid, gid); err != nil {
+		fsLog(fs, logger.LevelDebug, "error creating root directory %#v for user %#v: %v", fs.config.Prefix, username, err)
+		return false
+	}
+	return true
+}
+
+// ScanRootDirContents returns the number of files contained in a directory and
+// their size
+func (fs *SMBFs) ScanRootDirContents() (int, int64, error) {
+	return fs.getDirSize(fs.config.Prefix, getQuotaScanThrottle())
+}
+
+// GetAtomicUploadPath returns the path to use for an atomic upload
+func (*SMBFs) GetAtomicUploadPath(name string) string {
+	dir := path.Dir(name)
+	guid := xid.New().String()
+	return path.Join(dir, ".sftpgo-upload."+guid+"."+path.Base(name))
+}
+
+// GetRelativePath returns the path for a file relative to the share prefix if any.
+// This is the path as seen by SFTPGo users
+func (fs *SMBFs) GetRelativePath(name string) string {
+	rel := path.Clean(name)
+	if rel == "." {
+		rel = ""
+	}
+	if !path.IsAbs(rel) {
+		return "/" + rel
+	}
+	if fs.config.Prefix != "/" {
+		if !strings.HasPrefix(rel, fs.config.Prefix) {
+			rel = "/"
+		}
+		rel = path.Clean("/" + strings.TrimPrefix(rel, fs.config.Prefix))
+	}
+	if fs.mountPath != "" {
+		rel = path.Join(fs.mountPath, rel)
+	}
+	return rel
+}
+
+// Walk walks the file tree rooted at root, calling walkFn for each file or
+// directory in the tree, including root
+func (fs *SMBFs) Walk(root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		return walkFn(root, nil, err)
+	}
+	return fs.walk(root, info, walkFn)
+}
+
+func (fs *SMBFs) walk(name string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	if err := walkFn(name, info, nil); err != nil {
+		if info.IsDir() && err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	if !info.IsDir() {
+		return nil
+	}
+	entries, err := fs.ReadDir(name)
+	if err != nil {
+		return walkFn(name, info, err)
+	}
+	for _, entry := range entries {
+		if err := fs.walk(path.Join(name, entry.Name()), entry, walkFn); err != nil {
+			if !entry.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Join joins any number of path elements into a single path
+func (*SMBFs) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+// HasVirtualFolders returns true if folders are emulated
+func (*SMBFs) HasVirtualFolders() bool {
+	return false
+}
+
+// ResolvePath returns the matching filesystem path for the specified virtual path.
+// Links inside the share are resolved by the SMB server and they cannot point
+// outside the share
+func (fs *SMBFs) ResolvePath(virtualPath string) (string, error) {
+	if fs.mountPath != "" {
+		virtualPath = strings.TrimPrefix(virtualPath, fs.mountPath)
+	}
+	if !path.IsAbs(virtualPath) {
+		virtualPath = path.Clean("/" + virtualPath)
+	}
+	return fs.Join(fs.config.Prefix, virtualPath), nil
+}
+
+// GetDirSize returns the number of files and the size for a folder
+// including any subfolders
+func (fs *SMBFs) GetDirSize(dirname string) (int, int64, error) {
+	return fs.getDirSize(dirname, nil)
+}
+
+func (fs *SMBFs) getDirSize(dirname string, throttle *quotaScanThrottle) (int, int64, error) {
+	numFiles := 0
+	size := int64(0)
+	isDir, err := IsDirectory(fs, dirname)
+	if err == nil && isDir {
+		err = fs.Walk(dirname, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info == nil {
+				return nil
+			}
+			if info.IsDir() {
+				throttle.waitRequest()
+			} else if info.Mode().IsRegular() {
+				throttle.waitFiles(1)
+				size += info.Size()
+				numFiles++
+			}
+			return err
+		})
+	}
+	return numFiles, size, err
+}
+
+// GetMimeType returns the content type
+func (fs *SMBFs) GetMimeType(name string) (string, error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return "", err
+	}
+	f, err := share.Open(getSMBPath(name))
+	if err != nil {
+		return "", fs.checkError(err)
+	}
+	defer f.Close()
+	var buf [512]byte
+	n, err := io.ReadFull(f, buf[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+	ctype := http.DetectContentType(buf[:n])
+	// Rewind file.
+	_, err = f.Seek(0, io.SeekStart)
+	return ctype, err
+}
+
+// GetAvailableDiskSize return the available size for the specified path
+func (fs *SMBFs) GetAvailableDiskSize(dirName string) (*sftp.StatVFS, error) {
+	share, err := fs.getShare()
+	if err != nil {
+		return nil, err
+	}
+	info, err := share.Statfs(getSMBPath(dirName))
+	if err != nil {
+		return nil, fs.checkError(err)
+	}
+	return &sftp.StatVFS{
+		Bsize:   info.BlockSize(),
+		Frsize:  info.FragmentSize(),
+		Blocks:  info.TotalBlockCount(),
+		Bfree:   info.FreeBlockCount(),
+		Bavail:  info.AvailableBlockCount(),
+		Namemax: 255,
+	}, nil
+}
+
+// Close unmounts the share and closes the connection
+func (fs *SMBFs) Close() error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	return fs.closeConnection()
+}
+
+func (fs *SMBFs) getFileInfo(info os.FileInfo) os.FileInfo {
+	fi := NewFileInfo(info.Name(), info.IsDir(), info.Size(), info.ModTime(), false)
+	fi.SetMode(info.Mode())
+	return fi
+}
+
+func (fs *SMBFs) getFileInfos(entries []os.FileInfo) []os.FileInfo {
+	result := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, fs.getFileInfo(entry))
+	}
+	return result
+}
+
+// getShare returns the mounted share, the connection is established again
+// if it was closed after a network error
+func (fs *SMBFs) getShare() (*smb2.Share, error) {
+	fs.Lock()
+	share := fs.share
+	fs.Unlock()
+
+	if share != nil {
+		return share, nil
+	}
+	if err := fs.createConnection(); err != nil {
+		return nil, err
+	}
+	fs.Lock()
+	defer fs.Unlock()
+
+	return fs.share, nil
+}
+
+// checkError closes the connection if the given error is a network error,
+// so a new connection will be established for the next request
+func (fs *SMBFs) checkError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var transportErr *smb2.TransportError
+	var netErr net.Error
+	if errors.As(err, &transportErr) || errors.As(err, &netErr) {
+		fsLog(fs, logger.LevelDebug, "closing connection after network error: %v", err)
+		fs.Lock()
+		fs.closeConnection() //nolint:errcheck
+		fs.Unlock()
+	}
+	return err
+}
+
+func (fs *SMBFs) createConnection() error {
+	fs.Lock()
+	defer fs.Unlock()
+
+	if fs.share != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", fs.config.Endpoint, smbDialTimeout)
+	if err != nil {
+		return err
+	}
+	dialer := &smb2.Dialer{
+		Initiator: &smb2.NTLMInitiator{
+			User:     fs.config.Username,
+			Password: fs.config.Password.GetPayload(),
+			Domain:   fs.config.Domain,
+		},
+	}
+	session, err := dialer.Dial(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	host, _, _ := net.SplitHostPort(fs.config.Endpoint)
+	share, err := session.Mount(fmt.Sprintf(`\\%v\%v`, host, fs.config.Share))
+	if err != nil {
+		session.Logoff() //nolint:errcheck
+		conn.Close()
+		return err
+	}
+	fs.conn = conn
+	fs.session = session
+	fs.share = share
+	fsLog(fs, logger.LevelDebug, "share %#v mounted", fs.config.Share)
+	return nil
+}
+
+// closeConnection must be called with the lock held
+func (fs *SMBFs) closeConnection() error {
+	var err error
+	if fs.share != nil {
+		err = fs.share.Umount()
+		fs.share = nil
+	}
+	if fs.session != nil {
+		fs.session.Logoff() //nolint:errcheck
+		fs.session = nil
+	}
+	if fs.conn != nil {
+		fs.conn.Close()
+		fs.conn = nil
+	}
+	return err
+}
+
+// getSMBPath returns the given filesystem path relative to the share root,
+// the SMB paths cannot start with a separator
+func getSMBPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
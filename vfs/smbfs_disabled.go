@@ -0,0 +1,18 @@
+// +build !smb
+
+package vfs
+
+import (
+	"errors"
+
+	"github.com/drakkan/sftpgo/version"
+)
+
+func init() {
+	version.AddFeature("-smb")
+}
+
+// NewSMBFs returns an error, SMB is disabled
+func NewSMBFs(connectionID, mountPath, localTempDir string, config SMBFsConfig) (Fs, error) {
+	return nil, errors.New("SMB disabled at build time")
+}
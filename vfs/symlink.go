@@ -0,0 +1,70 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// symlinks are emulated on object storage backends using a zero bytes
+// object with this content type and the link target stored as metadata
+const (
+	symlinkMimeType          = "inode/symlink"
+	symlinkTargetMetadataKey = "sftpgo-symlink-target"
+	maxSymlinkHops           = 10
+)
+
+var errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// symlinkTargetGetter returns the target for the emulated symlink with the
+// given name or an empty string if the object is not a symlink
+type symlinkTargetGetter func(name string) (string, error)
+
+// newSymlinkFileInfo returns the file info for an emulated symlink
+func newSymlinkFileInfo(name string, modTime time.Time) *FileInfo {
+	info := NewFileInfo(name, false, 0, modTime, false)
+	info.SetMode(os.ModeSymlink | os.FileMode(0777))
+	return info
+}
+
+// resolveObjectSymlinks follows the emulated symlinks for the given object name,
+// symlinks inside the parent directories are followed too if the object does
+// not exist. The returned name is the one of the final object
+func resolveObjectSymlinks(name string, getTarget symlinkTargetGetter, isNotExist func(error) bool) (string, error) {
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		target, err := getTarget(name)
+		if err == nil {
+			if target == "" {
+				return name, nil
+			}
+			name = target
+			continue
+		}
+		if !isNotExist(err) {
+			return name, err
+		}
+		resolved, found, err := resolveParentSymlink(name, getTarget, isNotExist)
+		if err != nil || !found {
+			return name, err
+		}
+		name = resolved
+	}
+	return name, errTooManySymlinks
+}
+
+// resolveParentSymlink returns the object name obtained replacing the nearest
+// parent directory that is an emulated symlink with its target
+func resolveParentSymlink(name string, getTarget symlinkTargetGetter, isNotExist func(error) bool) (string, bool, error) {
+	for dir := path.Dir(name); dir != "/" && dir != "." && dir != ""; dir = path.Dir(dir) {
+		target, err := getTarget(dir)
+		if err != nil && !isNotExist(err) {
+			return name, false, err
+		}
+		if err == nil && target != "" {
+			return path.Join(target, strings.TrimPrefix(name, dir)), true, nil
+		}
+	}
+	return name, false, nil
+}
@@ -0,0 +1,65 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveObjectSymlinks(t *testing.T) {
+	errNotExist := errors.New("not found")
+	errFake := errors.New("fake error")
+	// object name -> symlink target, regular objects have an empty target
+	objects := map[string]string{
+		"prefix/release-1.2/file.txt": "",
+		"prefix/latest":               "prefix/release-1.2",
+		"prefix/current":              "prefix/latest",
+		"prefix/file":                 "prefix/current/file.txt",
+		"prefix/loop1":                "prefix/loop2",
+		"prefix/loop2":                "prefix/loop1",
+		"prefix/broken":               "prefix/missing",
+	}
+	getTarget := func(name string) (string, error) {
+		if name == "prefix/error" {
+			return "", errFake
+		}
+		target, ok := objects[name]
+		if !ok {
+			return "", errNotExist
+		}
+		return target, nil
+	}
+	isNotExist := func(err error) bool {
+		return err == errNotExist
+	}
+
+	for name, expected := range map[string]string{
+		"prefix/release-1.2/file.txt": "prefix/release-1.2/file.txt",
+		"prefix/latest":               "prefix/release-1.2",
+		"prefix/latest/file.txt":      "prefix/release-1.2/file.txt",
+		"prefix/current/file.txt":     "prefix/release-1.2/file.txt",
+		"prefix/file":                 "prefix/release-1.2/file.txt",
+		"prefix/broken":               "prefix/missing",
+		"prefix/missing/file.txt":     "prefix/missing/file.txt",
+	} {
+		resolved, err := resolveObjectSymlinks(name, getTarget, isNotExist)
+		assert.NoError(t, err, name)
+		assert.Equal(t, expected, resolved, name)
+	}
+	_, err := resolveObjectSymlinks("prefix/loop1", getTarget, isNotExist)
+	assert.ErrorIs(t, err, errTooManySymlinks)
+	_, err = resolveObjectSymlinks("prefix/loop1/file.txt", getTarget, isNotExist)
+	assert.ErrorIs(t, err, errTooManySymlinks)
+	_, err = resolveObjectSymlinks("prefix/error", getTarget, isNotExist)
+	assert.ErrorIs(t, err, errFake)
+	_, err = resolveObjectSymlinks("prefix/error/file.txt", getTarget, isNotExist)
+	assert.ErrorIs(t, err, errFake)
+
+	info := newSymlinkFileInfo("prefix/latest", time.Now())
+	assert.Equal(t, "latest", info.Name())
+	assert.NotEqual(t, os.FileMode(0), info.Mode()&os.ModeSymlink)
+	assert.False(t, info.IsDir())
+}
@@ -0,0 +1,97 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// VersionsDirName is the name of the directory, inside the filesystem root,
+// where the previous versions of the overwritten and deleted files are stored.
+// This directory is hidden and cannot be accessed directly
+const VersionsDirName = ".sftpgo-versions"
+
+// versionIDFormat is used to generate sortable version identifiers
+const versionIDFormat = "20060102T150405.000000000Z"
+
+// Supported versioning modes
+const (
+	// VersioningModeDisabled means no versioning
+	VersioningModeDisabled = iota
+	// VersioningModeVersionsDir means that overwritten and deleted files are moved
+	// inside the versions directory
+	VersioningModeVersionsDir
+	// VersioningModeBucket means that the versioning is handled by the bucket,
+	// supported for S3 only
+	VersioningModeBucket
+)
+
+// VersioningConfig defines the file versioning configuration
+type VersioningConfig struct {
+	// 0 disabled, 1 versions directory, 2 bucket versioning
+	Mode int `json:"mode,omitempty"`
+	// Maximum number of versions to keep for each file, 0 means unlimited.
+	// Older versions are removed when a new one is added.
+	// Ignored for bucket versioning, use the bucket lifecycle rules
+	MaxVersions int `json:"max_versions,omitempty"`
+}
+
+// IsEnabled returns true if file versioning is enabled
+func (c *VersioningConfig) IsEnabled() bool {
+	return c.Mode != VersioningModeDisabled
+}
+
+func (c *VersioningConfig) isEqual(other *VersioningConfig) bool {
+	return c.Mode == other.Mode && c.MaxVersions == other.MaxVersions
+}
+
+// Validate returns an error if the configuration is not valid for the given provider
+func (c *VersioningConfig) Validate(provider FilesystemProvider) error {
+	switch c.Mode {
+	case VersioningModeDisabled:
+		c.MaxVersions = 0
+		return nil
+	case VersioningModeVersionsDir:
+		if c.MaxVersions < 0 {
+			return fmt.Errorf("invalid max versions: %v", c.MaxVersions)
+		}
+		return nil
+	case VersioningModeBucket:
+		if provider != S3FilesystemProvider {
+			return errors.New("bucket versioning is supported for S3 only")
+		}
+		c.MaxVersions = 0
+		return nil
+	default:
+		return fmt.Errorf("invalid versioning mode: %v", c.Mode)
+	}
+}
+
+// FileVersion defines a previous version of a file
+type FileVersion struct {
+	ID string `json:"id"`
+	// file size in bytes
+	Size int64 `json:"size"`
+	// last modification time as unix timestamp in milliseconds
+	LastModified int64 `json:"last_modified"`
+	// true for the current version, bucket versioning only
+	IsLatest bool `json:"is_latest,omitempty"`
+}
+
+// VersionedFs defines the interface for filesystems with native versioning support
+type VersionedFs interface {
+	// ListVersions returns the versions for the specified file, newest first
+	ListVersions(name string) ([]FileVersion, error)
+	// RestoreVersion restores the specified version as the current one
+	RestoreVersion(name, versionID string) error
+}
+
+// NewVersionID returns a new version identifier based on the current time
+func NewVersionID() string {
+	return time.Now().UTC().Format(versionIDFormat)
+}
+
+// ParseVersionID returns the time for the given version identifier
+func ParseVersionID(versionID string) (time.Time, error) {
+	return time.Parse(versionIDFormat, versionID)
+}
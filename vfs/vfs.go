@@ -2,9 +2,11 @@
 package vfs
 
 import (
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/url"
 	"os"
 	"path"
@@ -21,10 +23,22 @@ import (
 	"github.com/drakkan/sftpgo/utils"
 )
 
-const dirMimeType = "inode/directory"
+const (
+	dirMimeType = "inode/directory"
+	// smbFsName is the name for the SMB Fs implementation
+	smbFsName = "smbfs"
+)
+
+// supported S3 server side encryption modes
+const (
+	s3SSEAES256   = "AES256"
+	s3SSEKMS      = "aws:kms"
+	s3SSECustomer = "SSE-C"
+)
 
 var (
 	validAzAccessTier = []string{"", "Archive", "Hot", "Cool"}
+	validS3SSE        = []string{"", s3SSEAES256, s3SSEKMS, s3SSECustomer}
 	// ErrStorageSizeUnavailable is returned if the storage backend does not support getting the size
 	ErrStorageSizeUnavailable = errors.New("unable to get available size for this storage backend")
 	// ErrVfsUnsupported defines the error for an unsupported VFS operation
@@ -66,9 +80,9 @@ type Fs interface {
 	Chtimes(name string, atime, mtime time.Time) error
 	Truncate(name string, size int64) error
 	ReadDir(dirname string) ([]os.FileInfo, error)
+	OpenDir(dirname string) (DirLister, error)
 	Readlink(name string) (string, error)
-	IsUploadResumeSupported() bool
-	IsAtomicUploadSupported() bool
+	Capabilities() FsCapabilities
 	CheckRootPath(username string, uid int, gid int) bool
 	ResolvePath(sftpPath string) (string, error)
 	IsNotExist(err error) bool
@@ -86,6 +100,52 @@ type Fs interface {
 	Close() error
 }
 
+// FsCapabilities describes the features supported by a filesystem backend.
+// Protocol handlers should use it instead of checking the backend type
+type FsCapabilities struct {
+	// UploadResume is true if an upload can be resumed writing at an offset
+	UploadResume bool
+	// AtomicUpload is true if files can be uploaded to a temporary path and
+	// then renamed to the final one
+	AtomicUpload bool
+	// SetStat is true if chmod, chown and chtimes are applied to the files
+	SetStat bool
+	// Symlinks is true if symbolic links can be created and followed
+	Symlinks bool
+	// Truncate is true if existing files are truncated in place, so the used
+	// quota is updated as soon as a file is truncated and not when the
+	// upload that replaces it completes
+	Truncate bool
+	// RangeReads is true if a file can be read starting from an arbitrary
+	// offset without downloading its previous contents
+	RangeReads bool
+}
+
+// ChecksumFs defines the interface for filesystems that can store the SHA-256
+// checksum of the uploaded files
+type ChecksumFs interface {
+	// SetChecksum stores the hex encoded SHA-256 checksum for the specified file
+	SetChecksum(name, checksum string) error
+	// GetChecksum returns the stored checksum for the specified file.
+	// An empty string is returned if no checksum is stored or if the file
+	// was modified after the checksum was stored
+	GetChecksum(name string) (string, error)
+}
+
+// ResumableUploadFs defines the interface for filesystems that cannot write
+// at arbitrary offsets but can continue an interrupted upload
+type ResumableUploadFs interface {
+	// GetResumableUploadSize returns the size already uploaded for an
+	// interrupted upload to the specified file. The returned bool is false
+	// if there is no interrupted upload to continue
+	GetResumableUploadSize(name string) (int64, bool)
+	// ResumeUpload continues the interrupted upload to the specified file.
+	// The offset must match the already uploaded size, the data written to
+	// the returned PipeWriter, starting at offset, are appended to the
+	// uploaded ones
+	ResumeUpload(name string, offset int64) (*PipeWriter, func(), error)
+}
+
 // File defines an interface representing a SFTPGo file
 type File interface {
 	io.Reader
@@ -126,6 +186,47 @@ func (q *QuotaCheckResult) GetRemainingFiles() int {
 	return 0
 }
 
+// S3UploadRule defines the storage class and the tags to apply to the
+// uploaded objects matching the given pattern
+type S3UploadRule struct {
+	// Shell like pattern, for example "*.bak" or "/archive/*". Patterns without a "/"
+	// are matched against the file name, the other ones against the full path.
+	// Paths are relative to the key prefix and start with "/"
+	Pattern string `json:"pattern"`
+	// StorageClass, if not empty, overrides the default storage class
+	StorageClass string `json:"storage_class,omitempty"`
+	// Tags are added to the default ones, overriding the tags with the same key
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+func (r *S3UploadRule) matches(name string) bool {
+	if !strings.Contains(r.Pattern, "/") {
+		name = path.Base(name)
+	}
+	matched, err := path.Match(r.Pattern, name)
+	return err == nil && matched
+}
+
+func (r *S3UploadRule) isEqual(other *S3UploadRule) bool {
+	if r.Pattern != other.Pattern || r.StorageClass != other.StorageClass {
+		return false
+	}
+	return areS3TagsEqual(r.Tags, other.Tags)
+}
+
+func (r *S3UploadRule) validate() error {
+	if r.Pattern == "" {
+		return errors.New("upload rule pattern cannot be empty")
+	}
+	if _, err := path.Match(r.Pattern, "abc"); err != nil {
+		return fmt.Errorf("invalid upload rule pattern %#v: %v", r.Pattern, err)
+	}
+	if r.StorageClass == "" && len(r.Tags) == 0 {
+		return fmt.Errorf("upload rule for pattern %#v must define a storage class or some tags", r.Pattern)
+	}
+	return validateS3Tags(r.Tags)
+}
+
 // S3FsConfig defines the configuration for S3 based filesystem
 type S3FsConfig struct {
 	Bucket string `json:"bucket,omitempty"`
@@ -151,6 +252,47 @@ type S3FsConfig struct {
 	UploadPartSize int64 `json:"upload_part_size,omitempty"`
 	// How many parts are uploaded in parallel
 	UploadConcurrency int `json:"upload_concurrency,omitempty"`
+	// The maximum memory (in MB) used to buffer the parts of a single upload. The
+	// AWS SDK buffers up to upload_concurrency + 1 parts, the upload concurrency
+	// is reduced if these buffers don't fit. 0 means no limit
+	UploadMaxBufferSize int64 `json:"upload_max_buffer_size,omitempty"`
+	// The buffer size (in MB) to use for multipart downloads. The minimum allowed part size is 5MB,
+	// and if this value is set to zero, the default value (5MB) for the AWS SDK will be used.
+	// The minimum allowed value is 5.
+	DownloadPartSize int64 `json:"download_part_size,omitempty"`
+	// How many parts are downloaded in parallel
+	DownloadConcurrency int `json:"download_concurrency,omitempty"`
+	// Server side encryption to apply to the uploaded objects. Empty means the bucket
+	// default, "AES256" means SSE-S3, "aws:kms" means SSE-KMS and "SSE-C" means
+	// server side encryption with the customer provided key
+	SSE string `json:"sse,omitempty"`
+	// The KMS key ID or ARN to use for SSE-KMS. If empty the AWS managed key is used
+	SSEKMSKeyID string `json:"sse_kms_key_id,omitempty"`
+	// The base64 encoded 256 bit key to use for SSE-C
+	SSECustomerKey *kms.Secret `json:"sse_customer_key,omitempty"`
+	// Tags to apply to the uploaded objects
+	Tags map[string]string `json:"tags,omitempty"`
+	// Storage class and tags for the uploaded objects matching specific patterns.
+	// The first matching rule is applied
+	UploadRules []S3UploadRule `json:"upload_rules,omitempty"`
+	// retries and timeouts for the requests to S3
+	CloudRequestConfig
+}
+
+// validateUploadMaxBufferSize checks that the buffer limit allows to upload
+// at least a part while the next one is read
+func (c *S3FsConfig) validateUploadMaxBufferSize() error {
+	if c.UploadMaxBufferSize == 0 {
+		return nil
+	}
+	partSize := c.UploadPartSize
+	if partSize == 0 {
+		partSize = 5
+	}
+	if c.UploadMaxBufferSize < 2*partSize {
+		return fmt.Errorf("upload_max_buffer_size must be 0 or at least twice the upload part size (%v MB)", partSize)
+	}
+	return nil
 }
 
 func (c *S3FsConfig) isEqual(other *S3FsConfig) bool {
@@ -178,13 +320,50 @@ func (c *S3FsConfig) isEqual(other *S3FsConfig) bool {
 	if c.UploadConcurrency != other.UploadConcurrency {
 		return false
 	}
+	if c.UploadMaxBufferSize != other.UploadMaxBufferSize {
+		return false
+	}
+	if c.DownloadPartSize != other.DownloadPartSize {
+		return false
+	}
+	if c.DownloadConcurrency != other.DownloadConcurrency {
+		return false
+	}
+	if c.SSE != other.SSE {
+		return false
+	}
+	if c.SSEKMSKeyID != other.SSEKMSKeyID {
+		return false
+	}
+	if !areS3TagsEqual(c.Tags, other.Tags) {
+		return false
+	}
+	if !c.CloudRequestConfig.isEqual(&other.CloudRequestConfig) {
+		return false
+	}
+	if len(c.UploadRules) != len(other.UploadRules) {
+		return false
+	}
+	for idx := range c.UploadRules {
+		if !c.UploadRules[idx].isEqual(&other.UploadRules[idx]) {
+			return false
+		}
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	if !c.SSECustomerKey.IsEqual(other.SSECustomerKey) {
+		return false
+	}
+	return c.AccessSecret.IsEqual(other.AccessSecret)
+}
+
+func (c *S3FsConfig) setEmptyCredentialsIfNil() {
 	if c.AccessSecret == nil {
 		c.AccessSecret = kms.NewEmptySecret()
 	}
-	if other.AccessSecret == nil {
-		other.AccessSecret = kms.NewEmptySecret()
+	if c.SSECustomerKey == nil {
+		c.SSECustomerKey = kms.NewEmptySecret()
 	}
-	return c.AccessSecret.IsEqual(other.AccessSecret)
 }
 
 func (c *S3FsConfig) checkCredentials() error {
@@ -203,7 +382,37 @@ func (c *S3FsConfig) checkCredentials() error {
 	return nil
 }
 
-// EncryptCredentials encrypts access secret if it is in plain text
+func (c *S3FsConfig) checkSSE() error {
+	if !utils.IsStringInSlice(c.SSE, validS3SSE) {
+		return fmt.Errorf("invalid sse: %#v", c.SSE)
+	}
+	if c.SSE != s3SSEKMS && c.SSEKMSKeyID != "" {
+		return errors.New("sse_kms_key_id requires SSE-KMS")
+	}
+	if c.SSE != s3SSECustomer {
+		if !c.SSECustomerKey.IsEmpty() {
+			return errors.New("sse_customer_key requires SSE-C")
+		}
+		return nil
+	}
+	if c.SSECustomerKey.IsEmpty() {
+		return errors.New("sse_customer_key cannot be empty with SSE-C")
+	}
+	if c.SSECustomerKey.IsEncrypted() && !c.SSECustomerKey.IsValid() {
+		return errors.New("invalid encrypted sse_customer_key")
+	}
+	if !c.SSECustomerKey.IsValidInput() {
+		return errors.New("invalid sse_customer_key")
+	}
+	if c.SSECustomerKey.IsPlain() {
+		if _, err := decodeSSECustomerKey(c.SSECustomerKey.GetPayload()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EncryptCredentials encrypts access secret and SSE-C key if they are in plain text
 func (c *S3FsConfig) EncryptCredentials(additionalData string) error {
 	if c.AccessSecret.IsPlain() {
 		c.AccessSecret.SetAdditionalData(additionalData)
@@ -212,14 +421,18 @@ func (c *S3FsConfig) EncryptCredentials(additionalData string) error {
 			return err
 		}
 	}
+	if c.SSECustomerKey.IsPlain() {
+		c.SSECustomerKey.SetAdditionalData(additionalData)
+		if err := c.SSECustomerKey.Encrypt(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // Validate returns an error if the configuration is not valid
 func (c *S3FsConfig) Validate() error {
-	if c.AccessSecret == nil {
-		c.AccessSecret = kms.NewEmptySecret()
-	}
+	c.setEmptyCredentialsIfNil()
 	if c.Bucket == "" {
 		return errors.New("bucket cannot be empty")
 	}
@@ -244,9 +457,106 @@ func (c *S3FsConfig) Validate() error {
 	if c.UploadConcurrency < 0 || c.UploadConcurrency > 64 {
 		return fmt.Errorf("invalid upload concurrency: %v", c.UploadConcurrency)
 	}
+	if err := c.validateUploadMaxBufferSize(); err != nil {
+		return err
+	}
+	if c.DownloadPartSize != 0 && (c.DownloadPartSize < 5 || c.DownloadPartSize > 5000) {
+		return errors.New("download_part_size cannot be != 0, lower than 5 (MB) or greater than 5000 (MB)")
+	}
+	if c.DownloadConcurrency < 0 || c.DownloadConcurrency > 64 {
+		return fmt.Errorf("invalid download concurrency: %v", c.DownloadConcurrency)
+	}
+	if err := c.CloudRequestConfig.validate(); err != nil {
+		return err
+	}
+	if err := validateS3Tags(c.Tags); err != nil {
+		return err
+	}
+	for idx := range c.UploadRules {
+		if err := c.UploadRules[idx].validate(); err != nil {
+			return err
+		}
+	}
+	return c.checkSSE()
+}
+
+// getUploadSettings returns the storage class and the tags to apply to an
+// object uploaded to the specified path relative to the key prefix
+func (c *S3FsConfig) getUploadSettings(name string) (string, map[string]string) {
+	storageClass := c.StorageClass
+	tags := make(map[string]string)
+	for k, v := range c.Tags {
+		tags[k] = v
+	}
+	for idx := range c.UploadRules {
+		rule := &c.UploadRules[idx]
+		if rule.matches(name) {
+			if rule.StorageClass != "" {
+				storageClass = rule.StorageClass
+			}
+			for k, v := range rule.Tags {
+				tags[k] = v
+			}
+			break
+		}
+	}
+	return storageClass, tags
+}
+
+func copyS3Tags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(tags))
+	for k, v := range tags {
+		res[k] = v
+	}
+	return res
+}
+
+func areS3TagsEqual(tags, other map[string]string) bool {
+	if len(tags) != len(other) {
+		return false
+	}
+	for k, v := range tags {
+		if val, ok := other[k]; !ok || val != v {
+			return false
+		}
+	}
+	return true
+}
+
+// validateS3Tags checks the S3 limits for object tags
+func validateS3Tags(tags map[string]string) error {
+	if len(tags) > 10 {
+		return fmt.Errorf("too many tags: %v, the maximum allowed is 10", len(tags))
+	}
+	for k, v := range tags {
+		if k == "" || len(k) > 128 {
+			return fmt.Errorf("invalid tag key %#v: it must be between 1 and 128 characters", k)
+		}
+		if len(v) > 256 {
+			return fmt.Errorf("invalid value for tag %#v: it cannot exceed 256 characters", k)
+		}
+		if strings.HasPrefix(k, "aws:") {
+			return fmt.Errorf("invalid tag key %#v: the aws: prefix is reserved", k)
+		}
+	}
 	return nil
 }
 
+// decodeSSECustomerKey returns the raw SSE-C key from its base64 encoding
+func decodeSSECustomerKey(key string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", fmt.Errorf("invalid sse_customer_key, it must be base64 encoded: %v", err)
+	}
+	if len(decoded) != 32 {
+		return "", fmt.Errorf("invalid sse_customer_key, it must be a 256 bit key, got %v bits", len(decoded)*8)
+	}
+	return string(decoded), nil
+}
+
 // GCSFsConfig defines the configuration for Google Cloud Storage based filesystem
 type GCSFsConfig struct {
 	Bucket string `json:"bucket,omitempty"`
@@ -262,6 +572,8 @@ type GCSFsConfig struct {
 	// 0 explicit, 1 automatic
 	AutomaticCredentials int    `json:"automatic_credentials,omitempty"`
 	StorageClass         string `json:"storage_class,omitempty"`
+	// retries and timeouts for the requests to Google Cloud Storage
+	CloudRequestConfig
 }
 
 func (c *GCSFsConfig) isEqual(other *GCSFsConfig) bool {
@@ -277,6 +589,9 @@ func (c *GCSFsConfig) isEqual(other *GCSFsConfig) bool {
 	if c.StorageClass != other.StorageClass {
 		return false
 	}
+	if !c.CloudRequestConfig.isEqual(&other.CloudRequestConfig) {
+		return false
+	}
 	if c.Credentials == nil {
 		c.Credentials = kms.NewEmptySecret()
 	}
@@ -303,6 +618,9 @@ func (c *GCSFsConfig) Validate(credentialsFilePath string) error {
 			c.KeyPrefix += "/"
 		}
 	}
+	if err := c.CloudRequestConfig.validate(); err != nil {
+		return err
+	}
 	if c.Credentials.IsEncrypted() && !c.Credentials.IsValid() {
 		return errors.New("invalid encrypted credentials")
 	}
@@ -489,11 +807,107 @@ func (c *CryptFsConfig) Validate() error {
 	return nil
 }
 
+// SMBFsConfig defines the configuration for SMB based filesystems, such as
+// Azure Files shares or Windows and Samba file servers
+type SMBFsConfig struct {
+	// Endpoint is the SMB server address as host or host:port, port 445 is used if omitted.
+	// For Azure Files use "<storage account>.file.core.windows.net"
+	Endpoint string `json:"endpoint,omitempty"`
+	// Share is the name of the share to mount
+	Share    string `json:"share,omitempty"`
+	Username string `json:"username,omitempty"`
+	// The password is stored encrypted based on the kms configuration.
+	// For Azure Files use the storage account key
+	Password *kms.Secret `json:"password,omitempty"`
+	// Domain is the optional NTLM domain
+	Domain string `json:"domain,omitempty"`
+	// Prefix is the path prefix, inside the share, to strip from SMB resource paths.
+	Prefix string `json:"prefix,omitempty"`
+}
+
+func (c *SMBFsConfig) isEqual(other *SMBFsConfig) bool {
+	if c.Endpoint != other.Endpoint {
+		return false
+	}
+	if c.Share != other.Share {
+		return false
+	}
+	if c.Username != other.Username {
+		return false
+	}
+	if c.Domain != other.Domain {
+		return false
+	}
+	if c.Prefix != other.Prefix {
+		return false
+	}
+	c.setEmptyCredentialsIfNil()
+	other.setEmptyCredentialsIfNil()
+	return c.Password.IsEqual(other.Password)
+}
+
+func (c *SMBFsConfig) setEmptyCredentialsIfNil() {
+	if c.Password == nil {
+		c.Password = kms.NewEmptySecret()
+	}
+}
+
+// EncryptCredentials encrypts the password if it is in plain text
+func (c *SMBFsConfig) EncryptCredentials(additionalData string) error {
+	if c.Password.IsPlain() {
+		c.Password.SetAdditionalData(additionalData)
+		if err := c.Password.Encrypt(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate returns an error if the configuration is not valid
+func (c *SMBFsConfig) Validate() error {
+	c.setEmptyCredentialsIfNil()
+	if c.Endpoint == "" {
+		return errors.New("endpoint cannot be empty")
+	}
+	if _, _, err := net.SplitHostPort(c.Endpoint); err != nil {
+		endpoint := net.JoinHostPort(c.Endpoint, "445")
+		if _, _, err := net.SplitHostPort(endpoint); err != nil {
+			return fmt.Errorf("invalid endpoint: %v", err)
+		}
+		c.Endpoint = endpoint
+	}
+	c.Share = strings.Trim(c.Share, `/\`)
+	if c.Share == "" {
+		return errors.New("share cannot be empty")
+	}
+	if strings.ContainsAny(c.Share, `/\`) {
+		return fmt.Errorf("invalid share %#v", c.Share)
+	}
+	if c.Username == "" {
+		return errors.New("username cannot be empty")
+	}
+	if c.Password.IsEmpty() || !c.Password.IsValidInput() {
+		return errors.New("password cannot be empty or invalid")
+	}
+	if c.Password.IsEncrypted() && !c.Password.IsValid() {
+		return errors.New("invalid encrypted password")
+	}
+	if c.Prefix != "" {
+		c.Prefix = utils.CleanPath(c.Prefix)
+	} else {
+		c.Prefix = "/"
+	}
+	return nil
+}
+
 // PipeWriter defines a wrapper for pipeat.PipeWriterAt.
 type PipeWriter struct {
 	writer *pipeat.PipeWriterAt
 	err    error
 	done   chan bool
+	// offset of the first byte written to the pipe, it is not zero for
+	// resumed uploads
+	offset int64
 }
 
 // NewPipeWriter initializes a new PipeWriter
@@ -505,6 +919,15 @@ func NewPipeWriter(w *pipeat.PipeWriterAt) *PipeWriter {
 	}
 }
 
+// NewPipeWriterAtOffset initializes a new PipeWriter for an upload resumed at
+// the specified offset, the data written at offset are the first ones read
+// from the pipe
+func NewPipeWriterAtOffset(w *pipeat.PipeWriterAt, offset int64) *PipeWriter {
+	p := NewPipeWriter(w)
+	p.offset = offset
+	return p
+}
+
 // Close waits for the upload to end, closes the pipeat.PipeWriterAt and returns an error if any.
 func (p *PipeWriter) Close() error {
 	p.writer.Close() //nolint:errcheck // the returned error is always null
@@ -521,7 +944,10 @@ func (p *PipeWriter) Done(err error) {
 
 // WriteAt is a wrapper for pipeat WriteAt
 func (p *PipeWriter) WriteAt(data []byte, off int64) (int, error) {
-	return p.writer.WriteAt(data, off)
+	if off < p.offset {
+		return 0, fmt.Errorf("invalid write offset: %v minimum valid value: %v", off, p.offset)
+	}
+	return p.writer.WriteAt(data, off-p.offset)
 }
 
 // Write is a wrapper for pipeat Write
@@ -543,6 +969,18 @@ func IsLocalOsFs(fs Fs) bool {
 	return fs.Name() == osFsName
 }
 
+// GetChecksumFs returns the ChecksumFs implementation for the given Fs, if
+// checksums are supported
+func GetChecksumFs(fs Fs) (ChecksumFs, bool) {
+	if c, ok := fs.(*cachedFs); ok {
+		if _, ok := c.Fs.(ChecksumFs); !ok {
+			return nil, false
+		}
+	}
+	checksumFs, ok := fs.(ChecksumFs)
+	return checksumFs, ok
+}
+
 // IsCryptOsFs returns true if fs is an encrypted local filesystem implementation
 func IsCryptOsFs(fs Fs) bool {
 	return fs.Name() == cryptFsName
@@ -558,7 +996,7 @@ func IsBufferedSFTPFs(fs Fs) bool {
 	if !IsSFTPFs(fs) {
 		return false
 	}
-	return !fs.IsUploadResumeSupported()
+	return !fs.Capabilities().UploadResume
 }
 
 // IsLocalOrUnbufferedSFTPFs returns true if fs is local or SFTP with no buffer
@@ -567,23 +1005,41 @@ func IsLocalOrUnbufferedSFTPFs(fs Fs) bool {
 		return true
 	}
 	if IsSFTPFs(fs) {
-		return fs.IsUploadResumeSupported()
+		return fs.Capabilities().UploadResume
 	}
 	return false
 }
 
-// IsLocalOrSFTPFs returns true if fs is local or SFTP
-func IsLocalOrSFTPFs(fs Fs) bool {
-	return IsLocalOsFs(fs) || IsSFTPFs(fs)
+// IsResumableUpload returns true if fs can continue an interrupted upload to
+// the specified file and the already uploaded size matches the given one
+func IsResumableUpload(fs Fs, name string, size int64) bool {
+	uploadedSize, ok := GetResumableUploadSize(fs, name)
+	return ok && uploadedSize == size
+}
+
+// GetResumableUploadSize returns the size already uploaded for an interrupted
+// upload to the specified file if fs can continue it. Interrupted uploads are
+// not visible using Stat, so this is the only way to get the resume offset
+func GetResumableUploadSize(fs Fs, name string) (int64, bool) {
+	resumableFs, ok := fs.(ResumableUploadFs)
+	if !ok {
+		return 0, false
+	}
+	return resumableFs.GetResumableUploadSize(name)
+}
+
+// IsSMBFs returns true if fs is an SMB filesystem
+func IsSMBFs(fs Fs) bool {
+	return strings.HasPrefix(fs.Name(), smbFsName)
 }
 
 // HasOpenRWSupport returns true if the fs can open a file
 // for reading and writing at the same time
 func HasOpenRWSupport(fs Fs) bool {
-	if IsLocalOsFs(fs) {
+	if IsLocalOsFs(fs) || IsSMBFs(fs) {
 		return true
 	}
-	if IsSFTPFs(fs) && fs.IsUploadResumeSupported() {
+	if IsSFTPFs(fs) && fs.Capabilities().UploadResume {
 		return true
 	}
 	return false
@@ -0,0 +1,161 @@
+package vfs
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// extended attributes names allowed for the clients, the "trusted" and
+// "security" namespaces are reserved to privileged processes and are never
+// exposed. POSIX ACLs are stored inside the "system" namespace
+const (
+	xattrUserNamespace      = "user."
+	xattrInternalPrefix     = "user.sftpgo."
+	xattrPosixACLAccess     = "system.posix_acl_access"
+	xattrPosixACLDefault    = "system.posix_acl_default"
+	xattrMetadataPrefix     = "sftpgoxattr"
+	maxXattrNameLength      = 255
+	maxXattrValueLength     = 64 * 1024
+	maxMetadataXattrsLength = 1024
+)
+
+var errInvalidXattrName = errors.New("invalid extended attribute name")
+
+// XattrFs defines the interface for filesystems that can store extended
+// attributes and POSIX ACLs. The local filesystem uses the OS extended
+// attributes, the object storage backends store them as object metadata
+type XattrFs interface {
+	// GetXattrs returns the allowed extended attributes for the specified path
+	GetXattrs(name string) (map[string][]byte, error)
+	// SetXattr sets the specified extended attribute
+	SetXattr(name, attr string, value []byte) error
+	// RemoveXattr removes the specified extended attribute,
+	// removing a missing attribute is not an error
+	RemoveXattr(name, attr string) error
+}
+
+// ValidateXattr returns an error if the given extended attribute cannot be
+// set by the clients
+func ValidateXattr(attr string, value []byte) error {
+	if !isXattrAllowed(attr) {
+		return fmt.Errorf("%w: %#v", errInvalidXattrName, attr)
+	}
+	if len(value) > maxXattrValueLength {
+		return fmt.Errorf("extended attribute %#v too large: %v bytes", attr, len(value))
+	}
+	return nil
+}
+
+// isXattrAllowed returns true if the given extended attribute name can be
+// read or written by the clients. The attributes used internally by SFTPGo,
+// for example the stored checksums, are hidden
+func isXattrAllowed(attr string) bool {
+	if len(attr) > maxXattrNameLength || strings.ContainsRune(attr, 0) {
+		return false
+	}
+	if attr == xattrPosixACLAccess || attr == xattrPosixACLDefault {
+		return true
+	}
+	return strings.HasPrefix(attr, xattrUserNamespace) && len(attr) > len(xattrUserNamespace) &&
+		!strings.HasPrefix(attr, xattrInternalPrefix)
+}
+
+// getXattrMetadataKey returns the object metadata key for the given extended
+// attribute. The name is hex encoded, the object storage backends have
+// different restrictions for metadata keys and some of them are case insensitive
+func getXattrMetadataKey(attr string) string {
+	return xattrMetadataPrefix + hex.EncodeToString([]byte(attr))
+}
+
+// getXattrsFromMetadata returns the extended attributes stored inside the given
+// object metadata. Keys are compared case insensitively since some backends
+// canonicalize them
+func getXattrsFromMetadata(metadata map[string]string) map[string][]byte {
+	result := make(map[string][]byte)
+	for k, v := range metadata {
+		key := strings.ToLower(k)
+		if !strings.HasPrefix(key, xattrMetadataPrefix) {
+			continue
+		}
+		attr, err := hex.DecodeString(strings.TrimPrefix(key, xattrMetadataPrefix))
+		if err != nil || !isXattrAllowed(string(attr)) {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			continue
+		}
+		result[string(attr)] = value
+	}
+	return result
+}
+
+// updateXattrMetadata sets or removes, if value is nil, the given extended
+// attribute inside the object metadata. The returned map can be used to replace
+// the object metadata
+func updateXattrMetadata(metadata map[string]string, attr string, value []byte) (map[string]string, error) {
+	result := make(map[string]string)
+	key := getXattrMetadataKey(attr)
+	size := 0
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			continue
+		}
+		result[k] = v
+		if strings.HasPrefix(strings.ToLower(k), xattrMetadataPrefix) {
+			size += len(k) + len(v)
+		}
+	}
+	if value != nil {
+		result[key] = base64.StdEncoding.EncodeToString(value)
+		size += len(key) + len(result[key])
+	}
+	// object storage backends limit the metadata size, for example 2KB for S3
+	if size > maxMetadataXattrsLength {
+		return nil, fmt.Errorf("extended attributes too large to be stored as metadata: %v bytes", size)
+	}
+	return result, nil
+}
+
+// getChecksumXattrValue returns the value to store as object metadata for the
+// given checksum. The object size is stored too: objects are replaced as a
+// whole, so a checksum for a different size is stale
+func getChecksumXattrValue(checksum string, size int64) []byte {
+	return []byte(fmt.Sprintf("%v:%v", checksum, size))
+}
+
+// getChecksumFromMetadata returns the checksum stored inside the given object
+// metadata or an empty string if there is no checksum for the given size
+func getChecksumFromMetadata(metadata map[string]string, size int64) string {
+	key := getXattrMetadataKey(checksumXattrName)
+	for k, v := range metadata {
+		if !strings.EqualFold(k, key) {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return ""
+		}
+		parts := strings.Split(string(value), ":")
+		if len(parts) != 2 || parts[1] != strconv.FormatInt(size, 10) {
+			return ""
+		}
+		return parts[0]
+	}
+	return ""
+}
+
+// splitXattrNames returns the names from a NUL separated list, as returned by listxattr
+func splitXattrNames(buf []byte) []string {
+	var result []string
+	for _, name := range strings.Split(string(buf), "\x00") {
+		if name != "" {
+			result = append(result, name)
+		}
+	}
+	return result
+}
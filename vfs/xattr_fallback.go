@@ -0,0 +1,19 @@
+// +build !linux,!darwin,!freebsd,!netbsd
+
+package vfs
+
+func setXattr(name, attr string, value []byte) error {
+	return ErrVfsUnsupported
+}
+
+func getXattr(name, attr string) ([]byte, error) {
+	return nil, ErrVfsUnsupported
+}
+
+func removeXattr(name, attr string) error {
+	return ErrVfsUnsupported
+}
+
+func listXattrs(name string) ([]string, error) {
+	return nil, ErrVfsUnsupported
+}
@@ -0,0 +1,56 @@
+// +build linux
+
+package vfs
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+func setXattr(name, attr string, value []byte) error {
+	return unix.Setxattr(name, attr, value, 0)
+}
+
+// getXattr returns a nil value and no error if the attribute does not exist
+func getXattr(name, attr string) ([]byte, error) {
+	buf := make([]byte, 256)
+	n, err := unix.Getxattr(name, attr, buf)
+	if errors.Is(err, unix.ERANGE) {
+		// the value is larger than our buffer, ask for the required size
+		n, err = unix.Getxattr(name, attr, nil)
+		if err == nil {
+			buf = make([]byte, n)
+			n, err = unix.Getxattr(name, attr, buf)
+		}
+	}
+	if err != nil {
+		if errors.Is(err, unix.ENODATA) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+// removeXattr returns no error if the attribute does not exist
+func removeXattr(name, attr string) error {
+	err := unix.Removexattr(name, attr)
+	if errors.Is(err, unix.ENODATA) {
+		return nil
+	}
+	return err
+}
+
+func listXattrs(name string) ([]string, error) {
+	n, err := unix.Listxattr(name, nil)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	n, err = unix.Listxattr(name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return splitXattrNames(buf[:n]), nil
+}
@@ -0,0 +1,109 @@
+package vfs
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateXattr(t *testing.T) {
+	assert.NoError(t, ValidateXattr("user.comment", []byte("value")))
+	assert.NoError(t, ValidateXattr(xattrPosixACLAccess, nil))
+	assert.NoError(t, ValidateXattr(xattrPosixACLDefault, nil))
+	assert.ErrorIs(t, ValidateXattr("user.", nil), errInvalidXattrName)
+	assert.ErrorIs(t, ValidateXattr(checksumXattrName, nil), errInvalidXattrName)
+	assert.ErrorIs(t, ValidateXattr("trusted.overlay", nil), errInvalidXattrName)
+	assert.ErrorIs(t, ValidateXattr("security.selinux", nil), errInvalidXattrName)
+	assert.ErrorIs(t, ValidateXattr("user.a\x00b", nil), errInvalidXattrName)
+	assert.ErrorIs(t, ValidateXattr("user."+strings.Repeat("a", maxXattrNameLength), nil), errInvalidXattrName)
+	assert.Error(t, ValidateXattr("user.comment", make([]byte, maxXattrValueLength+1)))
+
+	assert.Equal(t, []string{"user.a", "user.b"}, splitXattrNames([]byte("user.a\x00user.b\x00")))
+	assert.Len(t, splitXattrNames(nil), 0)
+}
+
+func TestXattrMetadata(t *testing.T) {
+	metadata, err := updateXattrMetadata(map[string]string{symlinkTargetMetadataKey: "/target"}, "user.comment",
+		[]byte("value"))
+	require.NoError(t, err)
+	assert.Len(t, metadata, 2)
+	metadata, err = updateXattrMetadata(metadata, xattrPosixACLAccess, []byte{2, 0, 0, 0})
+	require.NoError(t, err)
+	assert.Len(t, metadata, 3)
+	// some backends canonicalize the metadata keys
+	canonicalized := make(map[string]string)
+	for k, v := range metadata {
+		canonicalized[strings.Title(k)] = v
+	}
+	canonicalized[xattrMetadataPrefix+"invalid"] = "value"
+	xattrs := getXattrsFromMetadata(canonicalized)
+	assert.Len(t, xattrs, 2)
+	assert.Equal(t, []byte("value"), xattrs["user.comment"])
+	assert.Equal(t, []byte{2, 0, 0, 0}, xattrs[xattrPosixACLAccess])
+
+	metadata, err = updateXattrMetadata(canonicalized, "user.comment", nil)
+	require.NoError(t, err)
+	assert.Len(t, metadata, 3)
+	xattrs = getXattrsFromMetadata(metadata)
+	assert.Len(t, xattrs, 1)
+	assert.Equal(t, "/target", metadata[strings.Title(symlinkTargetMetadataKey)])
+
+	_, err = updateXattrMetadata(metadata, "user.large", make([]byte, maxMetadataXattrsLength))
+	assert.Error(t, err)
+}
+
+func TestChecksumMetadata(t *testing.T) {
+	metadata, err := updateXattrMetadata(map[string]string{}, checksumXattrName,
+		getChecksumXattrValue("abcd", 100))
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", getChecksumFromMetadata(metadata, 100))
+	// the checksum is hidden to the clients
+	assert.Len(t, getXattrsFromMetadata(metadata), 0)
+	// stale checksum
+	assert.Empty(t, getChecksumFromMetadata(metadata, 101))
+	canonicalized := make(map[string]string)
+	for k, v := range metadata {
+		canonicalized[strings.Title(k)] = v
+	}
+	assert.Equal(t, "abcd", getChecksumFromMetadata(canonicalized, 100))
+	assert.Empty(t, getChecksumFromMetadata(map[string]string{}, 100))
+	assert.Empty(t, getChecksumFromMetadata(map[string]string{
+		getXattrMetadataKey(checksumXattrName): "invalid base64",
+	}, 100))
+}
+
+func TestOsFsXattrs(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("this test is only available on Linux")
+	}
+	rootDir := t.TempDir()
+	fs := NewOsFs("", rootDir, "")
+	filePath := filepath.Join(rootDir, "file")
+	require.NoError(t, os.WriteFile(filePath, []byte("data"), os.ModePerm))
+
+	xattrFs, ok := fs.(XattrFs)
+	require.True(t, ok)
+	err := xattrFs.SetXattr(filePath, "user.comment", []byte("value"))
+	if err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+	assert.NoError(t, fs.(ChecksumFs).SetChecksum(filePath, "checksum"))
+	assert.Error(t, xattrFs.SetXattr(filePath, checksumXattrName, []byte("value")))
+	xattrs, err := xattrFs.GetXattrs(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"user.comment": []byte("value")}, xattrs)
+
+	assert.NoError(t, xattrFs.RemoveXattr(filePath, "user.comment"))
+	assert.NoError(t, xattrFs.RemoveXattr(filePath, "user.comment"))
+	xattrs, err = xattrFs.GetXattrs(filePath)
+	assert.NoError(t, err)
+	assert.Len(t, xattrs, 0)
+	checksum, err := fs.(ChecksumFs).GetChecksum(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "checksum", checksum)
+}
@@ -29,6 +29,8 @@ type webDavFile struct {
 	startOffset int64
 	isFinished  bool
 	readTryed   int32
+	// client IP address, used for the pre-download action
+	remoteIP string
 }
 
 func newWebDavFile(baseTransfer *common.BaseTransfer, pipeWriter *vfs.PipeWriter, pipeReader *pipeat.PipeReaderAt) *webDavFile {
@@ -147,6 +149,10 @@ func (f *webDavFile) Read(p []byte) (n int, err error) {
 			f.Connection.Log(logger.LevelWarn, "reading file %#v is not allowed", f.GetVirtualPath())
 			return 0, f.Connection.GetPermissionDeniedError()
 		}
+
+		if err := f.Connection.PreDownloadAction(f.GetFsPath(), f.GetVirtualPath(), f.remoteIP); err != nil {
+			return 0, err
+		}
 		atomic.StoreInt32(&f.readTryed, 1)
 	}
 
@@ -174,7 +180,7 @@ func (f *webDavFile) Read(p []byte) (n int, err error) {
 	}
 
 	n, err = f.reader.Read(p)
-	atomic.AddInt64(&f.BytesSent, int64(n))
+	f.UpdateChecksum(p[:n], f.startOffset+atomic.AddInt64(&f.BytesSent, int64(n))-int64(n))
 
 	if err != nil && err != io.EOF {
 		f.TransferError(err)
@@ -193,7 +199,7 @@ func (f *webDavFile) Write(p []byte) (n int, err error) {
 	f.Connection.UpdateLastActivity()
 
 	n, err = f.writer.Write(p)
-	atomic.AddInt64(&f.BytesReceived, int64(n))
+	f.UpdateChecksum(p[:n], atomic.AddInt64(&f.BytesReceived, int64(n))-int64(n))
 
 	if f.MaxWriteSize > 0 && err == nil && atomic.LoadInt64(&f.BytesReceived) > f.MaxWriteSize {
 		err = common.ErrQuotaExceeded
@@ -232,6 +238,10 @@ func (f *webDavFile) Seek(offset int64, whence int) (int64, error) {
 		if err != nil {
 			f.TransferError(err)
 		}
+		// seeking to the end is used to get the file size
+		if ret != 0 && whence != io.SeekEnd {
+			f.DisableChecksum()
+		}
 		return ret, err
 	}
 	if f.GetType() == common.TransferDownload {
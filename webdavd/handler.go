@@ -120,13 +120,31 @@ func (c *Connection) RemoveAll(ctx context.Context, name string) error {
 func (c *Connection) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
 	c.UpdateLastActivity()
 
+	if err := c.LimitOperationRate(common.RateLimitOperationOpen); err != nil {
+		return nil, err
+	}
+
 	name = utils.CleanPath(name)
+	isUpload := flag != os.O_RDONLY && c.request.Method != "PROPPATCH"
+	// Stat and Readdir open the files too, only uploads and downloads are real transfers
+	if isUpload || c.request.Method == http.MethodGet {
+		if err := c.CheckMaxTransfers(); err != nil {
+			return nil, err
+		}
+	}
+	if isUpload {
+		virtualPath, err := c.PreUploadAction(name)
+		if err != nil {
+			return nil, err
+		}
+		name = virtualPath
+	}
 	fs, p, err := c.GetFsAndResolvedPath(name)
 	if err != nil {
 		return nil, err
 	}
 
-	if flag == os.O_RDONLY || c.request.Method == "PROPPATCH" {
+	if !isUpload {
 		// Download, Stat, Readdir or simply open/close
 		return c.getFile(fs, p, name)
 	}
@@ -152,7 +170,9 @@ func (c *Connection) getFile(fs vfs.Fs, fsPath, virtualPath string) (webdav.File
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, fsPath, virtualPath, common.TransferDownload,
 		0, 0, 0, false, fs)
 
-	return newWebDavFile(baseTransfer, nil, r), nil
+	davFile := newWebDavFile(baseTransfer, nil, r)
+	davFile.remoteIP = utils.GetIPFromRemoteAddress(c.GetRemoteAddress())
+	return davFile, nil
 }
 
 func (c *Connection) putFile(fs vfs.Fs, fsPath, virtualPath string) (webdav.File, error) {
@@ -162,7 +182,7 @@ func (c *Connection) putFile(fs vfs.Fs, fsPath, virtualPath string) (webdav.File
 	}
 
 	filePath := fsPath
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if common.Config.IsAtomicUploadEnabled() && fs.Capabilities().AtomicUpload {
 		filePath = fs.GetAtomicUploadPath(fsPath)
 	}
 
@@ -189,6 +209,15 @@ func (c *Connection) putFile(fs vfs.Fs, fsPath, virtualPath string) (webdav.File
 		return nil, c.GetPermissionDeniedError()
 	}
 
+	// the existing content will be replaced, save it as a new version if versioning is enabled
+	isVersioned, err := c.SaveFileVersion(fs, fsPath, virtualPath)
+	if err != nil {
+		return nil, c.GetFsError(fs, err)
+	}
+	if isVersioned {
+		return c.handleUploadToNewFile(fs, fsPath, filePath, virtualPath)
+	}
+
 	return c.handleUploadToExistingFile(fs, fsPath, filePath, stat.Size(), virtualPath)
 }
 
@@ -207,10 +236,11 @@ func (c *Connection) handleUploadToNewFile(fs vfs.Fs, resolvedPath, filePath, re
 	vfs.SetPathPermissions(fs, filePath, c.User.GetUID(), c.User.GetGID())
 
 	// we can get an error only for resume
-	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0, fs.IsUploadResumeSupported())
+	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, 0, fs.Capabilities().UploadResume)
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, 0, 0, maxWriteSize, true, fs)
+	baseTransfer.SetEffectiveFsPath(filePath)
 
 	return newWebDavFile(baseTransfer, w, nil), nil
 }
@@ -226,9 +256,9 @@ func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePat
 
 	// if there is a size limit remaining size cannot be 0 here, since quotaResult.HasSpace
 	// will return false in this case and we deny the upload before
-	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, fileSize, fs.IsUploadResumeSupported())
+	maxWriteSize, _ := c.GetMaxWriteSize(quotaResult, false, fileSize, fs.Capabilities().UploadResume)
 
-	if common.Config.IsAtomicUploadEnabled() && fs.IsAtomicUploadSupported() {
+	if caps := fs.Capabilities(); common.Config.IsAtomicUploadEnabled() && caps.AtomicUpload && caps.Truncate {
 		err = fs.Rename(resolvedPath, filePath)
 		if err != nil {
 			c.Log(logger.LevelWarn, "error renaming existing file for atomic upload, source: %#v, dest: %#v, err: %+v",
@@ -243,7 +273,7 @@ func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePat
 		return nil, c.GetFsError(fs, err)
 	}
 	initialSize := int64(0)
-	if vfs.IsLocalOrSFTPFs(fs) {
+	if fs.Capabilities().Truncate {
 		vfolder, err := c.User.GetVirtualFolderForPath(path.Dir(requestPath))
 		if err == nil {
 			dataprovider.UpdateVirtualFolderQuota(&vfolder.BaseVirtualFolder, 0, -fileSize, false) //nolint:errcheck
@@ -253,6 +283,7 @@ func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePat
 		} else {
 			dataprovider.UpdateUserQuota(&c.User, 0, -fileSize, false) //nolint:errcheck
 		}
+		dataprovider.UpdateDirQuota(&c.User, requestPath, 0, -fileSize)
 	} else {
 		initialSize = fileSize
 	}
@@ -261,6 +292,7 @@ func (c *Connection) handleUploadToExistingFile(fs vfs.Fs, resolvedPath, filePat
 
 	baseTransfer := common.NewBaseTransfer(file, c.BaseConnection, cancelFn, resolvedPath, requestPath,
 		common.TransferUpload, 0, initialSize, maxWriteSize, false, fs)
+	baseTransfer.SetEffectiveFsPath(filePath)
 
 	return newWebDavFile(baseTransfer, w, nil), nil
 }
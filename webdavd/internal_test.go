@@ -278,14 +278,11 @@ func (fs *MockOsFs) Open(name string, offset int64) (vfs.File, *pipeat.PipeReade
 	return nil, fs.reader, nil, nil
 }
 
-// IsUploadResumeSupported returns true if resuming uploads is supported
-func (*MockOsFs) IsUploadResumeSupported() bool {
-	return false
-}
-
-// IsAtomicUploadSupported returns true if atomic upload is supported
-func (fs *MockOsFs) IsAtomicUploadSupported() bool {
-	return fs.isAtomicUploadSupported
+// Capabilities returns the features supported by this filesystem
+func (fs *MockOsFs) Capabilities() vfs.FsCapabilities {
+	return vfs.FsCapabilities{
+		AtomicUpload: fs.isAtomicUploadSupported,
+	}
 }
 
 // Remove removes the named file or (empty) directory.
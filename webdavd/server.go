@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"path"
 	"path/filepath"
@@ -80,11 +81,19 @@ func (s *webDavServer) listenAndServe(compressor *middleware.Compressor) error {
 				httpServer.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
 			}
 		}
-		return utils.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, true, logSender)
+		return utils.HTTPListenAndServeWithConnCheck(httpServer, s.binding.Address, s.binding.Port, true, logSender,
+			s.checkConnection)
 	}
 	s.binding.EnableHTTPS = false
 	serviceStatus.Bindings = append(serviceStatus.Bindings, s.binding)
-	return utils.HTTPListenAndServe(httpServer, s.binding.Address, s.binding.Port, false, logSender)
+	return utils.HTTPListenAndServeWithConnCheck(httpServer, s.binding.Address, s.binding.Port, false, logSender,
+		s.checkConnection)
+}
+
+// checkConnection executes the pre connect hook before reading anything from the connection
+func (s *webDavServer) checkConnection(conn net.Conn) error {
+	ipAddr := utils.GetIPFromRemoteAddress(conn.RemoteAddr().String())
+	return common.Config.ExecutePreConnectHook(ipAddr, common.ProtocolWebDAV)
 }
 
 func (s *webDavServer) verifyTLSConnection(state tls.ConnectionState) error {
@@ -152,6 +161,12 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, common.ErrConnectionDenied.Error(), http.StatusServiceUnavailable)
 		return
 	}
+	if err := common.CheckLoad(); err != nil {
+		logger.Log(logger.LevelDebug, common.ProtocolWebDAV, "", "connection refused: %v", err)
+		w.Header().Set("Retry-After", "30")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	checkRemoteAddress(r)
 	ipAddr := utils.GetIPFromRemoteAddress(r.RemoteAddr)
 	if common.IsBanned(ipAddr) {
@@ -208,6 +223,7 @@ func (s *webDavServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		BaseConnection: common.NewBaseConnection(connectionID, common.ProtocolWebDAV, user),
 		request:        r,
 	}
+	connection.SetRemoteAddress(r.RemoteAddr)
 	common.Connections.Add(connection)
 	defer common.Connections.Remove(connection.GetID())
 
@@ -273,6 +289,9 @@ func (s *webDavServer) authenticate(r *http.Request, ip string) (dataprovider.Us
 			return user, false, nil, loginMethod, dataprovider.ErrInvalidCredentials
 		}
 	}
+	if err := common.LimitAuthRate(common.ProtocolWebDAV, ip); err != nil {
+		return user, false, nil, loginMethod, err
+	}
 	user, loginMethod, err = dataprovider.CheckCompositeCredentials(username, password, ip, loginMethod,
 		common.ProtocolWebDAV, tlsCert)
 	if err != nil {
@@ -342,6 +361,9 @@ func writeLog(r *http.Request, err error) {
 	if reqStart, ok := r.Context().Value(requestStartKey).(time.Time); ok {
 		fields["elapsed_ms"] = time.Since(reqStart).Nanoseconds() / 1000000
 	}
+	if !logger.IsLevelEnabled(logSender, logger.LevelInfo) {
+		return
+	}
 	logger.GetLogger().Info().
 		Timestamp().
 		Str("sender", logSender).